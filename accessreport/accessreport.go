@@ -0,0 +1,233 @@
+package accessreport
+
+import (
+	"banking-app/models"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of actor in a report
+const (
+	ActorStaff    = "staff"      // Bank staff, including admins signed in as the customer
+	ActorClient   = "api_client" // A third party reading under the customer's consent
+	ActorCustomer = "customer"   // The customer's own sign-in
+)
+
+// Kinds of access
+const (
+	AccessRead  = "read"
+	AccessWrite = "write"
+)
+
+// Query selects the audit entries about one customer, or one of their accounts, in a period
+type Query struct {
+	CustomerID uint
+	AccountID  uint      // 0 covers all of the customer's records
+	From       time.Time // Inclusive
+	To         time.Time // Exclusive
+}
+
+// Access is one actor's reads or writes in one endpoint category
+type Access struct {
+	ActorType       string    `json:"actor_type"`     // staff, api_client or customer
+	Actor           string    `json:"actor"`          // Staff or customer username, or the client id
+	Role            string    `json:"role,omitempty"` // Staff role
+	Impersonation   bool      `json:"impersonation"`  // Staff signed in as the customer; Actor is the admin
+	Access          string    `json:"access"`         // read or write
+	Category        string    `json:"category"`       // Endpoint category, e.g. accounts/transactions
+	Count           int64     `json:"count"`          // Requests
+	FirstAt         time.Time `json:"first_at"`       // Earliest request in the period
+	LastAt          time.Time `json:"last_at"`        // Latest request in the period
+	firstID, lastID uint      // Entries of the first and last requests
+}
+
+// accessKey identifies the access a group of entries counts toward
+type accessKey struct {
+	actorType, actor, role string
+	impersonation          bool
+	access, category       string
+}
+
+// Event is one request in a detailed report
+type Event struct {
+	models.AuditEntry
+	ActorType string `json:"actor_type"`
+	Access    string `json:"access"`
+	Category  string `json:"category"`
+}
+
+// Entries scopes db to the audit entries the query covers; ok is false when the period has none
+// The period is turned into a range of entry ids, found through the created_at index, so with the customer_id or
+// account_id index (which SQLite keys by id too) a report reads only the customer's entries in the period.
+// Callers must have found the customer in their tenant: ids are unique across tenants, so the entries are selected
+// by table rather than model, leaving out the tenant filter that would steer SQLite to the tenant_id index
+func (q Query) Entries(db *gorm.DB) (scoped *gorm.DB, ok bool, err error) {
+	var first, last []uint
+	err = db.Raw("SELECT id FROM audit_entries WHERE created_at >= ? ORDER BY created_at, id LIMIT 1", q.From.UTC()).Scan(&first).Error
+	if err == nil {
+		err = db.Raw("SELECT id FROM audit_entries WHERE created_at < ? ORDER BY created_at DESC, id DESC LIMIT 1", q.To.UTC()).Scan(&last).Error
+	}
+	if err != nil || len(first) == 0 || len(last) == 0 || first[0] > last[0] {
+		return nil, false, err
+	}
+	scoped = db.Table("audit_entries").Where("customer_id = ? AND id BETWEEN ? AND ?", q.CustomerID, first[0], last[0])
+	if q.AccountID != 0 {
+		scoped = scoped.Where("account_id = ?", q.AccountID)
+	}
+	return scoped, true, nil
+}
+
+// Summarize groups the entries by actor, access and endpoint category, most recent first
+func Summarize(db *gorm.DB, q Query) ([]Access, int64, error) {
+	scoped, ok, err := q.Entries(db)
+	if err != nil || !ok {
+		return []Access{}, 0, err
+	}
+	var groups []struct {
+		Username      string
+		Role          string
+		ClientID      string
+		Impersonation bool
+		Method        string
+		Route         string
+		Count         int64
+		FirstID       uint
+		LastID        uint
+	}
+	err = scoped.Select("username, role, client_id, impersonation, method, route, COUNT(*) AS count, MIN(id) AS first_id, MAX(id) AS last_id").
+		Group("username, role, client_id, impersonation, method, route").Scan(&groups).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Routes fold into categories, so several groups can make one access
+	byKey := make(map[accessKey]*Access)
+	var total int64
+	var bounds []uint
+	for _, g := range groups {
+		entry := models.AuditEntry{Username: g.Username, Role: g.Role, ClientID: g.ClientID, Impersonation: g.Impersonation}
+		key := accessKey{ActorType(entry), actor(entry), "", g.Impersonation, AccessOf(g.Method), Category(g.Route)}
+		if key.actorType == ActorStaff {
+			key.role = g.Role
+		}
+		access := byKey[key]
+		if access == nil {
+			access = &Access{ActorType: key.actorType, Actor: key.actor, Role: key.role, Impersonation: key.impersonation,
+				Access: key.access, Category: key.category, firstID: g.FirstID, lastID: g.LastID}
+			byKey[key] = access
+		}
+		access.Count += g.Count
+		if g.FirstID < access.firstID {
+			access.firstID = g.FirstID
+		}
+		if g.LastID > access.lastID {
+			access.lastID = g.LastID
+		}
+		total += g.Count
+	}
+	for _, access := range byKey {
+		bounds = append(bounds, access.firstID, access.lastID)
+	}
+
+	// Ids rise with time, so the first and last ids are the first and last requests
+	times := make(map[uint]time.Time, len(bounds))
+	if len(bounds) > 0 {
+		var stamps []models.AuditEntry
+		if err := db.Model(&models.AuditEntry{}).Select("id, created_at").Where("id IN ?", bounds).Find(&stamps).Error; err != nil {
+			return nil, 0, err
+		}
+		for _, s := range stamps {
+			times[s.ID] = s.CreatedAt
+		}
+	}
+	accesses := make([]Access, 0, len(byKey))
+	for _, access := range byKey {
+		access.FirstAt, access.LastAt = times[access.firstID], times[access.lastID]
+		accesses = append(accesses, *access)
+	}
+	sort.Slice(accesses, func(i, j int) bool {
+		if accesses[i].lastID != accesses[j].lastID {
+			return accesses[i].lastID > accesses[j].lastID
+		}
+		return accesses[i].firstID > accesses[j].firstID
+	})
+	return accesses, total, nil
+}
+
+// Events lists the entries one page at a time, newest first
+func Events(db *gorm.DB, q Query, offset, limit int) ([]Event, int64, error) {
+	scoped, ok, err := q.Entries(db)
+	if err != nil || !ok {
+		return []Event{}, 0, err
+	}
+	var total int64
+	if err := scoped.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var ids []uint
+	if err := scoped.Order("id DESC").Offset(offset).Limit(limit).Pluck("id", &ids).Error; err != nil {
+		return nil, 0, err
+	}
+	var entries []models.AuditEntry
+	if err := db.Where("id IN ?", ids).Order("id DESC").Find(&entries).Error; err != nil {
+		return nil, 0, err
+	}
+	events := make([]Event, len(entries))
+	for i, entry := range entries {
+		events[i] = Event{AuditEntry: entry, ActorType: ActorType(entry), Access: AccessOf(entry.Method), Category: Category(entry.Route)}
+	}
+	return events, total, nil
+}
+
+// ActorType classifies who made a request; impersonation counts as staff, since the entry names the admin
+func ActorType(entry models.AuditEntry) string {
+	switch {
+	case entry.ClientID != "":
+		return ActorClient
+	case entry.Impersonation:
+		return ActorStaff
+	case entry.Role == "customer":
+		return ActorCustomer
+	}
+	return ActorStaff
+}
+
+// actor names who made a request: the client for third parties, otherwise the signed-in user
+func actor(entry models.AuditEntry) string {
+	if entry.ClientID != "" {
+		return entry.ClientID
+	}
+	return entry.Username
+}
+
+// AccessOf classifies a request method as a read or a write
+func AccessOf(method string) string {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return AccessRead
+	}
+	return AccessWrite
+}
+
+// Category names the endpoints a route belongs to by its fixed path segments, e.g. /api/v1/accounts/:id/balance
+// is accounts/balance; bulk operation entries are bulk-operations
+func Category(route string) string {
+	if strings.HasPrefix(route, "bulk-operation:") {
+		return "bulk-operations"
+	}
+	var segments []string
+	for _, segment := range strings.Split(route, "/") {
+		if segment == "" || segment == "api" || strings.HasPrefix(segment, ":") ||
+			(len(segment) > 1 && segment[0] == 'v' && strings.Trim(segment[1:], "0123456789") == "") {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	if len(segments) == 0 {
+		return "other"
+	}
+	return strings.Join(segments, "/")
+}
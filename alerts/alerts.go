@@ -0,0 +1,204 @@
+package alerts
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/communications"
+	"banking-app/events"
+	"banking-app/fx"
+	"banking-app/models"
+	"banking-app/notifications"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Supported alert rule types
+const (
+	RuleBalanceBelow    = "balance_below"    // Balance dropped under Threshold after a transaction
+	RuleTransactionOver = "transaction_over" // Single transaction larger than Threshold
+	RuleLoanPaymentDue  = "loan_payment_due" // Loan installment due within DaysBefore days
+	RuleInternational   = "international"    // Foreign-currency posting or FX batch leg of at least Threshold; 0 for any
+)
+
+// RuleTypes lists every rule type accepted by the API
+var RuleTypes = []string{RuleBalanceBelow, RuleTransactionOver, RuleLoanPaymentDue, RuleInternational}
+
+// Channels lists the delivery channels an alert can use
+var Channels = []string{"email", "webhook"}
+
+// EvaluateTransaction checks transaction-driven rules for an account, and the holder's budgets, after posting
+// Called once the posting transaction has committed so alerts never reference rolled-back data
+func EvaluateTransaction(db *gorm.DB, account models.Account, txn models.Transaction) {
+	EvaluateLeg(db, account, txn, 0)
+}
+
+// EvaluateLeg is EvaluateTransaction for a leg of a batch, given the rate that converted it into the batch
+// currency, or 0 when it was posted in the batch currency
+func EvaluateLeg(db *gorm.DB, account models.Account, txn models.Transaction, rate float64) {
+	var rules []models.AlertRule
+	err := db.Where("account_id = ? AND enabled = ? AND rule_type IN ?", account.ID, true,
+		[]string{RuleBalanceBelow, RuleTransactionOver, RuleInternational}).Find(&rules).Error
+	if err != nil {
+		log.Printf("alerts: failed to load rules for account %d: %v", account.ID, err)
+		return
+	}
+
+	for _, rule := range rules {
+		var message string
+		switch rule.RuleType {
+		case RuleBalanceBelow:
+			// Only fire when this transaction crossed the threshold, not on every later debit
+			if txn.BalanceAfter < rule.Threshold && txn.BalanceBefore >= rule.Threshold {
+				message = fmt.Sprintf("Balance of account %s fell below %.2f (now %.2f)",
+					account.AccountNumber, rule.Threshold, txn.BalanceAfter)
+				recordBalanceLow(db, rule, account, txn)
+			}
+		case RuleTransactionOver:
+			if txn.Amount > rule.Threshold {
+				message = fmt.Sprintf("A %s of %.2f was posted to account %s",
+					txn.TransactionType, txn.Amount, account.AccountNumber)
+			}
+		case RuleInternational:
+			// The amount is in the account's currency, converted or not
+			if txn.Amount < rule.Threshold {
+				break
+			}
+			if rate > 0 {
+				message = fmt.Sprintf("A %s of %.2f %s was posted to account %s, converted at an exchange rate of %g",
+					txn.TransactionType, txn.Amount, account.Currency, account.AccountNumber, rate)
+			} else if account.Currency != "" && account.Currency != fx.BaseCurrency {
+				message = fmt.Sprintf("A %s of %.2f %s in a foreign currency was posted to account %s",
+					txn.TransactionType, txn.Amount, account.Currency, account.AccountNumber)
+			}
+		}
+
+		if message != "" {
+			txnID := txn.ID
+			fire(db, rule, account, &txnID, message)
+		}
+	}
+	EvaluateBudgets(db, account, txn)
+}
+
+// recordBalanceLow publishes the crossing of a balance_below rule for the account's webhooks, whether or not the
+// rule's own notification is under its daily cap
+func recordBalanceLow(db *gorm.DB, rule models.AlertRule, account models.Account, txn models.Transaction) {
+	err := events.Record(db, events.AggregateAccount, account.ID, events.BalanceLow, map[string]interface{}{
+		"account_id":     account.ID,
+		"alert_rule_id":  rule.ID,
+		"threshold":      rule.Threshold,
+		"balance":        txn.BalanceAfter,
+		"currency":       account.Currency,
+		"transaction_id": txn.ID,
+	})
+	if err != nil {
+		log.Printf("alerts: failed to record low balance of account %d: %v", account.ID, err)
+	}
+}
+
+// EvaluateDueDates checks date-based rules, intended to run once per day from the scheduler
+func EvaluateDueDates(db *gorm.DB, now time.Time) {
+	var rules []models.AlertRule
+	if err := db.Where("enabled = ? AND rule_type = ?", true, RuleLoanPaymentDue).Find(&rules).Error; err != nil {
+		log.Printf("alerts: failed to load date rules: %v", err)
+		return
+	}
+
+	today := businessdays.StartOfDay(now)
+	for _, rule := range rules {
+		var account models.Account
+		if err := db.First(&account, rule.AccountID).Error; err != nil {
+			continue
+		}
+
+		// Loans are held at customer level, so the rule watches the account owner's active loans
+		var loans []models.Loan
+		db.Where("customer_id = ? AND status = ?", account.CustomerID, "active").Find(&loans)
+
+		for _, loan := range loans {
+			due, ok := NextPaymentDate(loan, today)
+			if !ok {
+				continue
+			}
+			if businessdays.Days(today, due) == rule.DaysBefore {
+				message := fmt.Sprintf("Loan %s payment of %.2f is due on %s",
+					loan.LoanNumber, loan.MonthlyPayment, businessdays.Format(due))
+				fire(db, rule, account, nil, message)
+			}
+		}
+	}
+}
+
+// NextPaymentDate returns the first monthly installment date on or after the given day
+// Installments fall on the disbursement day-of-month for LoanTerm months
+func NextPaymentDate(loan models.Loan, from time.Time) (time.Time, bool) {
+	disbursed, err := businessdays.ParseDate(loan.DisbursementDate)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	for i := 1; i <= loan.LoanTerm; i++ {
+		due := disbursed.AddDate(0, i, 0)
+		if !due.Before(from) {
+			return due, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// recipientFor is where an alert is delivered: its target, or for email the customer's own address once verified
+func recipientFor(db *gorm.DB, channel, target string, customerID uint) string {
+	if target != "" || channel != "email" {
+		return target
+	}
+	var customer models.Customer
+	if err := db.Select("id, email, email_verified").First(&customer, customerID).Error; err == nil && customer.EmailVerified {
+		return customer.Email
+	}
+	return ""
+}
+
+// fire records a firing and queues its notification, respecting the rule's daily cap
+func fire(db *gorm.DB, rule models.AlertRule, account models.Account, txnID *uint, message string) {
+	startOfDay := businessdays.StartOfDay(clock.Now())
+	var firedToday int64
+	db.Model(&models.AlertFiring{}).Where("alert_rule_id = ? AND created_at >= ?", rule.ID, startOfDay).Count(&firedToday)
+	if rule.DailyCap > 0 && firedToday >= int64(rule.DailyCap) {
+		return
+	}
+
+	recipient := recipientFor(db, rule.Channel, rule.Target, account.CustomerID)
+	if recipient == "" {
+		log.Printf("alerts: rule %d has no deliverable recipient", rule.ID)
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		notification := models.Notification{
+			CustomerID:   account.CustomerID,
+			Channel:      rule.Channel,
+			ResourceType: communications.ResourceAlertRule,
+			ResourceID:   rule.ID,
+			Recipient:    recipient,
+			Subject:      "Account alert: " + rule.RuleType,
+			Body:         message,
+		}
+		if err := notifications.Enqueue(tx, &notification); err != nil {
+			return err
+		}
+
+		return tx.Create(&models.AlertFiring{
+			AlertRuleID:    rule.ID,
+			AccountID:      account.ID,
+			TransactionID:  txnID,
+			NotificationID: notification.ID,
+			Message:        message,
+		}).Error
+	})
+	if err != nil {
+		log.Printf("alerts: failed to fire rule %d: %v", rule.ID, err)
+	}
+}
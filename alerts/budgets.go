@@ -0,0 +1,111 @@
+package alerts
+
+import (
+	"banking-app/budgets"
+	"banking-app/clock"
+	"banking-app/communications"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/notifications"
+	"fmt"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// EvaluateBudgets alerts the account holder when a categorized debit takes their budget for its category to 80%
+// or 100% of the monthly amount
+// Each threshold alerts at most once per budget and bank month. A posting reaching both at once sends one
+// notification for the higher, recording both
+func EvaluateBudgets(db *gorm.DB, account models.Account, txn models.Transaction) {
+	if txn.CategoryCode == "" || ledger.IsCredit(txn.TransactionType) || txn.ReversalOfID != nil || account.CustomerID == 0 {
+		return
+	}
+	var budget models.Budget
+	if err := db.Where("customer_id = ? AND category = ?", account.CustomerID, strings.ToUpper(txn.CategoryCode)).Limit(1).Find(&budget).Error; err != nil || budget.ID == 0 {
+		return
+	}
+
+	now := clock.Now()
+	spend, err := budgets.Spend(db, account.CustomerID, now)
+	if err != nil {
+		log.Printf("alerts: failed to compute spending for budget %d: %v", budget.ID, err)
+		return
+	}
+	spent := spend[budget.Category]
+	_, _, period := budgets.Month(now)
+
+	var sent []int
+	if err := db.Model(&models.BudgetAlert{}).Where("budget_id = ? AND period = ?", budget.ID, period).Pluck("threshold", &sent).Error; err != nil {
+		log.Printf("alerts: failed to load alerts for budget %d: %v", budget.ID, err)
+		return
+	}
+	var reached []int
+	for _, threshold := range budgets.Reached(budget, spent) {
+		if !containsInt(sent, threshold) {
+			reached = append(reached, threshold)
+		}
+	}
+	if len(reached) == 0 {
+		return
+	}
+
+	recipient := recipientFor(db, budget.Channel, budget.Target, account.CustomerID)
+	if recipient == "" {
+		log.Printf("alerts: budget %d has no deliverable recipient", budget.ID)
+		return
+	}
+	highest := reached[len(reached)-1]
+	message := fmt.Sprintf("You have spent %.2f of your %.2f monthly budget for category %s (%d%%)",
+		spent, budget.MonthlyAmount, budget.Category, highest)
+	if highest >= 100 {
+		message = fmt.Sprintf("You have spent %.2f, reaching your %.2f monthly budget for category %s",
+			spent, budget.MonthlyAmount, budget.Category)
+	}
+
+	// The unique period index stops a concurrent posting from alerting twice; the loser rolls back its notification
+	err = db.Transaction(func(tx *gorm.DB) error {
+		notification := models.Notification{
+			CustomerID:   account.CustomerID,
+			Channel:      budget.Channel,
+			ResourceType: communications.ResourceBudget,
+			ResourceID:   budget.ID,
+			Recipient:    recipient,
+			Subject:      fmt.Sprintf("Budget alert: %s at %d%%", budget.Category, highest),
+			Body:         message,
+		}
+		if err := notifications.Enqueue(tx, &notification); err != nil {
+			return err
+		}
+		for _, threshold := range reached {
+			txnID := txn.ID
+			err := tx.Create(&models.BudgetAlert{
+				TenantID:       budget.TenantID,
+				BudgetID:       budget.ID,
+				Period:         period,
+				Threshold:      threshold,
+				Spent:          spent,
+				TransactionID:  &txnID,
+				NotificationID: notification.ID,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("alerts: failed to alert on budget %d: %v", budget.ID, err)
+	}
+}
+
+// containsInt reports whether list holds n
+func containsInt(list []int, n int) bool {
+	for _, item := range list {
+		if item == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,636 @@
+package anonymize
+
+import (
+	"banking-app/descriptors"
+	"banking-app/documents"
+	"banking-app/models"
+	"banking-app/receipts"
+	"banking-app/uploads"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrProductionDatabase is returned for a target that is a production database file
+var ErrProductionDatabase = errors.New("refusing to anonymize a production database")
+
+// batchSize bounds the rows held in memory while rewriting a table
+const batchSize = 500
+
+// maxLeaks bounds the leaks a verification pass reports
+const maxLeaks = 100
+
+// ProductionPathsFromEnv reads PRODUCTION_DB_PATH, a comma-separated list of the database files production
+// servers use. It is the marker CheckTarget compares against
+func ProductionPathsFromEnv() []string {
+	var paths []string
+	for _, path := range strings.Split(os.Getenv("PRODUCTION_DB_PATH"), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// CheckTarget refuses a database file that is one of the production files
+// Paths are compared as files, so a relative path, a symlink or a hard link to production is caught too
+func CheckTarget(dbPath string, production []string) error {
+	target := fileOf(dbPath)
+	targetAbs, _ := filepath.Abs(target)
+	targetInfo, targetErr := os.Stat(target)
+	for _, path := range production {
+		path = fileOf(path)
+		abs, _ := filepath.Abs(path)
+		if abs == targetAbs {
+			return fmt.Errorf("%w: %s is listed in PRODUCTION_DB_PATH", ErrProductionDatabase, dbPath)
+		}
+		if info, err := os.Stat(path); err == nil && targetErr == nil && os.SameFile(info, targetInfo) {
+			return fmt.Errorf("%w: %s is the same file as %s in PRODUCTION_DB_PATH", ErrProductionDatabase, dbPath, path)
+		}
+	}
+	return nil
+}
+
+// fileOf strips connection parameters from a database path
+func fileOf(dsn string) string {
+	return strings.TrimPrefix(strings.SplitN(dsn, "?", 2)[0], "file:")
+}
+
+// Options control an anonymization run
+type Options struct {
+	Seed    string          // Varies the fake values; the same seed gives the same values for the same rows
+	Storage uploads.Storage // Where documents, statements and communication content are kept
+}
+
+// Report counts what a run rewrote
+type Report struct {
+	Customers     int    `json:"customers"`
+	Users         int    `json:"users"`
+	Notifications int    `json:"notifications"`
+	Subscriptions int    `json:"subscriptions"`
+	Notes         int    `json:"notes"`
+	Documents     int    `json:"documents"`
+	Files         int    `json:"files"`                   // Stored files replaced with placeholders
+	TextRows      int    `json:"text_rows"`               // Rows elsewhere whose free text named a customer or address
+	Rehashed      int    `json:"transactions_rehashed"`   // Transactions re-chained after their descriptions changed
+	Descriptors   int    `json:"descriptors_regenerated"` // Statement descriptors rendered again from the fake names
+	Leaks         []Leak `json:"leaks"`                   // Original emails or phones the verification pass still found
+}
+
+// Leak is an original email address or phone number found after anonymization
+type Leak struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	RowID  int64  `json:"rowid"`
+	Kind   string `json:"kind"` // email, phone
+}
+
+// Run rewrites the personal data in a database copy in place and then verifies that no original email address
+// or phone number remains anywhere in it
+// Row identities, amounts, balances and links between records are untouched, so the data stays usable for
+// testing. Stored files are replaced with placeholders after the database changes commit
+func Run(db *gorm.DB, opts Options) (Report, error) {
+	var report Report
+	m := newMapper(opts.Seed)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		steps := []func(*gorm.DB, *mapper, *Report) error{
+			customers, users, verifications, notifications, subscriptions, notes, documentNames, freeText,
+			statementDescriptors,
+		}
+		for _, step := range steps {
+			if err := step(tx, m, &report); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if opts.Storage != nil {
+		if err := files(db, opts.Storage, &report); err != nil {
+			return report, err
+		}
+	}
+
+	report.Leaks, err = verify(db, m)
+	return report, err
+}
+
+// mapper derives fake values and remembers each original with its replacement
+type mapper struct {
+	seed     string
+	replaced map[string]string // Original to fake, for rewriting free text
+	order    []string          // Originals in the order they were seen, so free text rewrites deterministically
+	emails   map[string]bool   // Original addresses, lower-cased
+	phones   map[string]bool   // Digits of original phone numbers
+}
+
+func newMapper(seed string) *mapper {
+	return &mapper{seed: seed, replaced: map[string]string{}, emails: map[string]bool{}, phones: map[string]bool{}}
+}
+
+// number hashes the seed and parts into a stable pseudo-random number
+func (m *mapper) number(parts ...interface{}) uint64 {
+	sum := sha256.Sum256([]byte(m.seed + "|" + fmt.Sprint(parts...)))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// pick chooses a stable entry of list for the parts
+func (m *mapper) pick(list []string, parts ...interface{}) string {
+	return list[m.number(parts...)%uint64(len(list))]
+}
+
+// remember records an original and its fake for free text; the first fake recorded for an original is kept
+func (m *mapper) remember(original, fake string) string {
+	if original == "" {
+		return ""
+	}
+	if existing, ok := m.replaced[original]; ok {
+		return existing
+	}
+	m.replaced[original] = fake
+	m.order = append(m.order, original)
+	return fake
+}
+
+// email returns the fake for an original address, fake if it has none yet
+func (m *mapper) email(original, fake string) string {
+	original = strings.TrimSpace(original)
+	if original == "" {
+		return ""
+	}
+	m.emails[strings.ToLower(original)] = true
+	if existing, ok := m.replaced[strings.ToLower(original)]; ok {
+		return m.remember(original, existing)
+	}
+	m.remember(strings.ToLower(original), fake)
+	return m.remember(original, fake)
+}
+
+// phone returns a fake number for an original one
+// Only numbers of at least seven digits are looked for in free text; shorter ones would match too much
+func (m *mapper) phone(original string, id uint) string {
+	if strings.TrimSpace(original) == "" {
+		return original
+	}
+	n := m.number("phone", id)
+	fake := fmt.Sprintf("+1 555 %03d %04d", n%1000, (n/1000)%10000)
+	if d := digits(original); len(d) >= 7 {
+		m.phones[d] = true
+		return m.remember(original, fake)
+	}
+	return fake
+}
+
+// replacer rewrites every remembered original in free text, longest first so an address is not cut short
+func (m *mapper) replacer() *strings.Replacer {
+	originals := append([]string(nil), m.order...)
+	sort.SliceStable(originals, func(i, j int) bool { return len(originals[i]) > len(originals[j]) })
+	pairs := make([]string, 0, 2*len(originals))
+	for _, original := range originals {
+		pairs = append(pairs, original, m.replaced[original])
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// digits keeps only the digits of s
+func digits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// customers replaces names, addresses, phones and emails, and moves dates of birth by up to a year
+// Fake emails carry the customer ID, so they stay unique
+func customers(tx *gorm.DB, m *mapper, report *Report) error {
+	var batch []models.Customer
+	return tx.Unscoped().FindInBatches(&batch, batchSize, func(tx *gorm.DB, _ int) error {
+		for _, c := range batch {
+			first, last := m.pick(firstNames, "first", c.ID), m.pick(lastNames, "last", c.ID)
+			local := strings.ToLower(first + "." + last)
+			updates := map[string]interface{}{
+				"first_name": first,
+				"last_name":  last,
+				"email":      m.email(c.Email, fmt.Sprintf("%s.%d@example.com", local, c.ID)),
+				"phone":      m.phone(c.Phone, c.ID),
+			}
+			if full := strings.TrimSpace(c.FirstName + " " + c.LastName); strings.Contains(full, " ") && len(full) >= 5 {
+				m.remember(full, first+" "+last)
+			}
+			if c.PendingEmail != "" {
+				updates["pending_email"] = m.email(c.PendingEmail, fmt.Sprintf("%s.%d.new@example.com", local, c.ID))
+			}
+			if strings.TrimSpace(c.Address) != "" {
+				n := m.number("address", c.ID)
+				updates["address"] = m.remember(c.Address, fmt.Sprintf("%d %s, %s", 1+n%998,
+					streets[(n/1000)%uint64(len(streets))], towns[(n/100000)%uint64(len(towns))]))
+			}
+			if len(c.DateOfBirth) >= 10 {
+				if dob, err := time.Parse("2006-01-02", c.DateOfBirth[:10]); err == nil {
+					shift := int(m.number("dob", c.ID)%730) - 365
+					if shift >= 0 {
+						shift++ // Never zero, so no date of birth is kept as it was
+					}
+					updates["date_of_birth"] = dob.AddDate(0, 0, shift).Format("2006-01-02")
+				}
+			}
+			if err := tx.Unscoped().Model(&models.Customer{}).Where("id = ?", c.ID).UpdateColumns(updates).Error; err != nil {
+				return err
+			}
+			report.Customers++
+		}
+		return nil
+	}).Error
+}
+
+// users renames customer sign-ins and any user whose login is an email address
+// Staff logins stay, so testers who know them can still sign in; passwords are left as they are
+func users(tx *gorm.DB, m *mapper, report *Report) error {
+	var list []models.User
+	if err := tx.Unscoped().Where("role = ? OR username LIKE ?", "customer", "%@%").Find(&list).Error; err != nil {
+		return err
+	}
+	for _, u := range list {
+		username := fmt.Sprintf("customer-user-%d", u.ID)
+		if u.Role != "customer" {
+			username = fmt.Sprintf("user-%d@example.com", u.ID)
+		}
+		if strings.Contains(u.Username, "@") {
+			username = m.email(u.Username, username)
+		}
+		if err := tx.Unscoped().Model(&models.User{}).Where("id = ?", u.ID).UpdateColumn("username", username).Error; err != nil {
+			return err
+		}
+		// Records that name the user by login follow the rename
+		if err := tx.Model(&models.AuditEntry{}).Where("username = ?", u.Username).UpdateColumn("username", username).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&models.Document{}).Where("uploaded_by = ?", u.Username).UpdateColumn("uploaded_by", username).Error; err != nil {
+			return err
+		}
+		report.Users++
+	}
+	return nil
+}
+
+// verifications replaces the addresses verification tokens were sent to, including addresses since changed
+func verifications(tx *gorm.DB, m *mapper, report *Report) error {
+	var list []models.EmailVerification
+	if err := tx.Find(&list).Error; err != nil {
+		return err
+	}
+	for _, v := range list {
+		email := m.email(v.Email, fmt.Sprintf("verification-%d@example.com", v.ID))
+		if err := tx.Model(&models.EmailVerification{}).Where("id = ?", v.ID).UpdateColumn("email", email).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifications replaces email recipients and drops message bodies, which hold names, links and tokens
+// Webhook recipients are partner endpoints and stay
+func notifications(tx *gorm.DB, m *mapper, report *Report) error {
+	var batch []models.Notification
+	return tx.FindInBatches(&batch, batchSize, func(tx *gorm.DB, _ int) error {
+		for _, n := range batch {
+			updates := map[string]interface{}{"body": "Anonymized message"}
+			if strings.Contains(n.Recipient, "@") {
+				updates["recipient"] = m.email(n.Recipient, fmt.Sprintf("notification-%d@example.com", n.ID))
+			}
+			if err := tx.Model(&models.Notification{}).Where("id = ?", n.ID).UpdateColumns(updates).Error; err != nil {
+				return err
+			}
+			report.Notifications++
+		}
+		return nil
+	}).Error
+}
+
+// subscriptions replaces the email targets and alert addresses of report subscriptions
+func subscriptions(tx *gorm.DB, m *mapper, report *Report) error {
+	var list []models.ReportSubscription
+	if err := tx.Unscoped().Find(&list).Error; err != nil {
+		return err
+	}
+	for _, s := range list {
+		updates := map[string]interface{}{}
+		if strings.Contains(s.Target, "@") {
+			updates["target"] = m.email(s.Target, fmt.Sprintf("subscription-%d@example.com", s.ID))
+		}
+		if s.AlertEmail != "" {
+			updates["alert_email"] = m.email(s.AlertEmail, fmt.Sprintf("subscription-%d-alerts@example.com", s.ID))
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		if err := tx.Unscoped().Model(&models.ReportSubscription{}).Where("id = ?", s.ID).UpdateColumns(updates).Error; err != nil {
+			return err
+		}
+		report.Subscriptions++
+	}
+	return nil
+}
+
+// notes replaces staff notes, whose free text can say anything about a customer
+func notes(tx *gorm.DB, m *mapper, report *Report) error {
+	result := tx.Unscoped().Model(&models.Note{}).Where("1 = 1").UpdateColumn("body", gorm.Expr("'Anonymized note ' || id"))
+	report.Notes = int(result.RowsAffected)
+	return result.Error
+}
+
+// documentNames replaces the names documents were uploaded with, keeping their extension
+func documentNames(tx *gorm.DB, m *mapper, report *Report) error {
+	var list []models.Document
+	if err := tx.Unscoped().Select("id, filename").Find(&list).Error; err != nil {
+		return err
+	}
+	for _, d := range list {
+		name := fmt.Sprintf("document-%d%s", d.ID, strings.ToLower(filepath.Ext(d.Filename)))
+		if err := tx.Unscoped().Model(&models.Document{}).Where("id = ?", d.ID).UpdateColumn("filename", name).Error; err != nil {
+			return err
+		}
+		report.Documents++
+	}
+	return nil
+}
+
+// freeText rewrites every remembered original wherever else it appears, such as event payloads, audit paths,
+// certificate holder names and transaction descriptions
+// Transactions whose description changed are re-chained so receipt verification still passes on the copy
+func freeText(tx *gorm.DB, m *mapper, report *Report) error {
+	replacer := m.replacer()
+	changedAccounts := map[uint]bool{}
+	err := eachText(tx, func(table string, rowID int64, columns []string, values []sql.NullString) error {
+		updates := map[string]interface{}{}
+		for i, value := range values {
+			if !value.Valid {
+				continue
+			}
+			if rewritten := replacer.Replace(value.String); rewritten != value.String {
+				updates[columns[i]] = rewritten
+			}
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		if err := tx.Table(table).Where("rowid = ?", rowID).UpdateColumns(updates).Error; err != nil {
+			return err
+		}
+		report.TextRows++
+		if table == "transactions" {
+			var accountID uint
+			if err := tx.Raw("SELECT account_id FROM transactions WHERE rowid = ?", rowID).Scan(&accountID).Error; err != nil {
+				return err
+			}
+			changedAccounts[accountID] = true
+		}
+		return nil
+	})
+	if err != nil || len(changedAccounts) == 0 {
+		return err
+	}
+
+	ids := make([]uint, 0, len(changedAccounts))
+	for id := range changedAccounts {
+		ids = append(ids, id)
+	}
+	if err := tx.Unscoped().Model(&models.Transaction{}).Where("account_id IN ?", ids).UpdateColumn("hash", "").Error; err != nil {
+		return err
+	}
+	if report.Rehashed, err = receipts.Backfill(tx); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		chain, err := receipts.Verify(tx, id)
+		if err != nil {
+			return err
+		}
+		if !chain.Valid {
+			return fmt.Errorf("transaction chain of account %d does not verify after re-chaining", id)
+		}
+	}
+	return nil
+}
+
+// statementDescriptors renders every transaction's descriptor again, now from the fake names
+// Descriptors hold counterparty names upper-cased, which the free text rewrite does not match
+func statementDescriptors(tx *gorm.DB, m *mapper, report *Report) error {
+	var err error
+	report.Descriptors, err = descriptors.Backfill(tx, true)
+	return err
+}
+
+// eachText calls fn for every row of every table with the row's text columns
+func eachText(db *gorm.DB, fn func(table string, rowID int64, columns []string, values []sql.NullString) error) error {
+	var tables []string
+	if err := db.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name").Scan(&tables).Error; err != nil {
+		return err
+	}
+	for _, table := range tables {
+		var info []struct {
+			Name string
+			Type string
+		}
+		if err := db.Raw("SELECT name, type FROM pragma_table_info(?)", table).Scan(&info).Error; err != nil {
+			return err
+		}
+		var columns []string
+		for _, column := range info {
+			t := strings.ToLower(column.Type)
+			if t == "" || strings.Contains(t, "text") || strings.Contains(t, "char") || strings.Contains(t, "clob") {
+				columns = append(columns, column.Name)
+			}
+		}
+		if len(columns) == 0 {
+			continue
+		}
+
+		quoted := make([]string, len(columns))
+		for i, column := range columns {
+			quoted[i] = `"` + column + `"`
+		}
+		query := fmt.Sprintf(`SELECT rowid, %s FROM "%s" WHERE rowid > ? ORDER BY rowid LIMIT %d`, strings.Join(quoted, ", "), table, batchSize)
+		for last := int64(0); ; {
+			rows, err := db.Raw(query, last).Rows()
+			if err != nil {
+				return err
+			}
+			type row struct {
+				id     int64
+				values []sql.NullString
+			}
+			var batch []row
+			for rows.Next() {
+				r := row{values: make([]sql.NullString, len(columns))}
+				dest := []interface{}{&r.id}
+				for i := range r.values {
+					dest = append(dest, &r.values[i])
+				}
+				if err := rows.Scan(dest...); err != nil {
+					rows.Close()
+					return err
+				}
+				batch = append(batch, r)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			// The batch is read in full before fn runs, so fn may write to the table
+			for _, r := range batch {
+				if err := fn(table, r.id, columns, r.values); err != nil {
+					return err
+				}
+				last = r.id
+			}
+			if len(batch) < batchSize {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// files replaces stored documents, statement PDFs and communication content with placeholders of the same type
+func files(db *gorm.DB, storage uploads.Storage, report *Report) error {
+	var docs []models.Document
+	if err := db.Unscoped().Select("id, storage_key, content_type").Find(&docs).Error; err != nil {
+		return err
+	}
+	for _, d := range docs {
+		data, err := store(storage, d.StorageKey, d.ContentType, fmt.Sprintf("Document %d", d.ID))
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		if err := db.Unscoped().Model(&models.Document{}).Where("id = ?", d.ID).
+			UpdateColumns(map[string]interface{}{"size": len(data), "sha256": hex.EncodeToString(sum[:])}).Error; err != nil {
+			return err
+		}
+		report.Files++
+	}
+
+	var statements []models.Statement
+	if err := db.Select("id, storage_key").Find(&statements).Error; err != nil {
+		return err
+	}
+	for _, st := range statements {
+		data, err := store(storage, st.StorageKey, "application/pdf", fmt.Sprintf("Statement %d", st.ID))
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		if err := db.Model(&models.Statement{}).Where("id = ?", st.ID).
+			UpdateColumns(map[string]interface{}{"size": len(data), "checksum": hex.EncodeToString(sum[:])}).Error; err != nil {
+			return err
+		}
+		report.Files++
+	}
+
+	var entries []models.CommunicationLog
+	if err := db.Select("id, content_key, content_type").Where("content_key <> ''").Find(&entries).Error; err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if _, err := store(storage, e.ContentKey, e.ContentType, fmt.Sprintf("Communication %d", e.ID)); err != nil {
+			return err
+		}
+		report.Files++
+	}
+	return nil
+}
+
+// store writes a placeholder of a content type under key and returns it
+func store(storage uploads.Storage, key, contentType, title string) ([]byte, error) {
+	data, err := placeholder(contentType, title)
+	if err != nil {
+		return nil, err
+	}
+	return data, storage.Put(key, data)
+}
+
+// placeholder renders a small stand-in file of a content type
+func placeholder(contentType, title string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch strings.SplitN(contentType, ";", 2)[0] {
+	case "application/pdf":
+		pdf := documents.NewPDF(&buf)
+		pdf.Heading("Anonymized document")
+		pdf.Text(title)
+		err = pdf.Close()
+	case "image/png":
+		err = png.Encode(&buf, grey())
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, grey(), nil)
+	default:
+		buf.WriteString("Anonymized content: " + title + "\n")
+	}
+	return buf.Bytes(), err
+}
+
+// grey is a plain image for image placeholders
+func grey() image.Image {
+	img := image.NewGray(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = 0xcc
+	}
+	return img
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+`)
+	phonePattern = regexp.MustCompile(`\+?[0-9][0-9 ().\-]{5,}[0-9]`)
+)
+
+// verify scans every text column of every table for the original email addresses and phone numbers a run
+// replaced, and returns where any remain
+func verify(db *gorm.DB, m *mapper) ([]Leak, error) {
+	leaks := []Leak{}
+	err := eachText(db, func(table string, rowID int64, columns []string, values []sql.NullString) error {
+		for i, value := range values {
+			if !value.Valid || len(leaks) >= maxLeaks {
+				continue
+			}
+			kind := ""
+			for _, email := range emailPattern.FindAllString(value.String, -1) {
+				if m.emails[strings.ToLower(email)] {
+					kind = "email"
+				}
+			}
+			for _, phone := range phonePattern.FindAllString(value.String, -1) {
+				if m.phones[digits(phone)] {
+					kind = "phone"
+				}
+			}
+			if kind != "" {
+				leaks = append(leaks, Leak{Table: table, Column: columns[i], RowID: rowID, Kind: kind})
+			}
+		}
+		return nil
+	})
+	return leaks, err
+}
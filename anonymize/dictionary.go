@@ -0,0 +1,26 @@
+package anonymize
+
+// Fake values are drawn from these lists; none of them is meant to be anyone in particular
+var (
+	firstNames = []string{
+		"Alex", "Bailey", "Casey", "Dana", "Eden", "Finley", "Gray", "Harper", "Indigo", "Jordan",
+		"Kai", "Logan", "Morgan", "Noel", "Oakley", "Parker", "Quinn", "Riley", "Sage", "Taylor",
+		"Umber", "Val", "Wren", "Xen", "Yael", "Zion", "Avery", "Blair", "Cameron", "Drew",
+		"Emery", "Frankie", "Hayden", "Jamie", "Kendall", "Lane", "Marlowe", "Reese", "Rowan", "Skyler",
+	}
+	lastNames = []string{
+		"Ashford", "Brookes", "Calder", "Dunmore", "Ellery", "Fairweather", "Garrow", "Hollis", "Ingram", "Jessop",
+		"Kettering", "Linwood", "Marsh", "Northcott", "Orwell", "Penrose", "Quarry", "Radley", "Stanton", "Thorne",
+		"Underhill", "Vance", "Whitlock", "Yardley", "Ashdown", "Birch", "Coleridge", "Dale", "Everly", "Fenwick",
+		"Gale", "Hartley", "Keswick", "Lowther", "Merriman", "Newbold", "Oakes", "Pryor", "Rook", "Sable",
+	}
+	streets = []string{
+		"Acorn Lane", "Beacon Road", "Cedar Avenue", "Dockside Way", "Elm Street", "Foundry Row", "Garden Close",
+		"Harbour Street", "Ivy Court", "Juniper Drive", "Kiln Road", "Linden Walk", "Mill Lane", "Orchard Way",
+		"Pine Crescent", "Quay Street", "River Road", "Station Approach", "Tannery Lane", "Willow Grove",
+	}
+	towns = []string{
+		"Anytown", "Brookfield", "Clearwater", "Dunfield", "Eastbury", "Fairview", "Greendale", "Hillcrest",
+		"Lakeside", "Millbrook", "Northfield", "Riverton", "Springvale", "Westhaven",
+	}
+)
@@ -0,0 +1,154 @@
+package apiversion
+
+import (
+	"banking-app/metrics"
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API versions served side by side
+const (
+	V1 = "v1"
+	V2 = "v2"
+)
+
+// DefaultV1Sunset is when v1 endpoints with a v2 replacement are announced to stop working
+const DefaultV1Sunset = "2027-06-30"
+
+// versionKey carries the version a request was made against through re-dispatch
+type versionKey struct{}
+
+// Router registers endpoints across API versions
+// v2 handlers override v1 per endpoint; a v2 request for an endpoint without an override is
+// served by the v1 handler, so unchanged endpoints are shared rather than registered twice
+type Router struct {
+	engine   *gin.Engine
+	v1Prefix string
+	v2Prefix string
+	sunset   time.Time
+
+	mu         sync.RWMutex
+	successors map[string]string // "METHOD v1 route" -> v2 route replacing it
+
+	requests map[string]*metrics.Counter
+}
+
+// New returns a router for the versions mounted at the given prefixes, e.g. /api/v1 and /api/v2
+func New(engine *gin.Engine, v1Prefix, v2Prefix string, sunset time.Time) *Router {
+	r := &Router{
+		engine:     engine,
+		v1Prefix:   v1Prefix,
+		v2Prefix:   v2Prefix,
+		sunset:     sunset,
+		successors: make(map[string]string),
+		requests:   make(map[string]*metrics.Counter),
+	}
+	for _, version := range []string{V1, V2} {
+		r.requests[version] = metrics.NewCounter(`api_requests_total{version="`+version+`"}`, "API requests by version")
+	}
+	return r
+}
+
+// SunsetFromEnv reads API_V1_SUNSET (YYYY-MM-DD), defaulting to DefaultV1Sunset
+func SunsetFromEnv() time.Time {
+	raw := os.Getenv("API_V1_SUNSET")
+	if raw == "" {
+		raw = DefaultV1Sunset
+	}
+	sunset, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		log.Printf("apiversion: ignoring invalid API_V1_SUNSET %q", raw)
+		sunset, _ = time.Parse("2006-01-02", DefaultV1Sunset)
+	}
+	return sunset
+}
+
+// Override registers a v2 handler on group for the endpoint at the same relative path in v1
+// The v1 endpoint keeps working and gains deprecation headers pointing at its successor
+func (r *Router) Override(group *gin.RouterGroup, method, relativePath string, handlers ...gin.HandlerFunc) {
+	group.Handle(method, relativePath, handlers...)
+	v2Route := joinPaths(group.BasePath(), relativePath)
+	v1Route := r.v1Prefix + strings.TrimPrefix(v2Route, r.v2Prefix)
+
+	r.mu.Lock()
+	r.successors[method+" "+v1Route] = v2Route
+	r.mu.Unlock()
+}
+
+// Version returns the API version a request was made against, or "" outside the versioned API
+func Version(c *gin.Context) string {
+	version, _ := c.Request.Context().Value(versionKey{}).(string)
+	return version
+}
+
+// Track tags each request with its API version and counts it; install it on the engine
+// A v2 request served by a v1 handler is re-dispatched through the engine and keeps its v2 tag
+func (r *Router) Track() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if Version(c) != "" {
+			c.Next()
+			return
+		}
+		version := ""
+		switch path := c.Request.URL.Path; {
+		case strings.HasPrefix(path, r.v1Prefix+"/"):
+			version = V1
+		case strings.HasPrefix(path, r.v2Prefix+"/"):
+			version = V2
+		}
+		if version != "" {
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), versionKey{}, version))
+			r.requests[version].Inc()
+		}
+		c.Next()
+	}
+}
+
+// Deprecation marks v1 responses of endpoints that have a v2 replacement; install it on the v1 group
+// Headers follow the Deprecation and Sunset conventions, with a Link to the successor route
+func (r *Router) Deprecation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if Version(c) == V1 {
+			r.mu.RLock()
+			successor, ok := r.successors[c.Request.Method+" "+c.FullPath()]
+			r.mu.RUnlock()
+			if ok {
+				c.Header("Deprecation", "true")
+				c.Header("Sunset", r.sunset.UTC().Format(http.TimeFormat))
+				c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+			}
+		}
+		c.Next()
+	}
+}
+
+// Fallthrough serves v2 requests that matched no v2 route with the v1 handler; install it as NoRoute
+// Other unmatched requests get the engine's default 404
+func (r *Router) Fallthrough() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if !strings.HasPrefix(path, r.v2Prefix+"/") {
+			return
+		}
+		c.Request.URL.Path = r.v1Prefix + strings.TrimPrefix(path, r.v2Prefix)
+		c.Request.URL.RawPath = ""
+		r.engine.HandleContext(c)
+		// HandleContext leaves the v1 chain on the context; stop the outer chain from resuming it
+		c.Abort()
+	}
+}
+
+// joinPaths appends a relative route to a group's base path
+func joinPaths(base, relative string) string {
+	if relative == "" {
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(relative, "/")
+}
@@ -0,0 +1,277 @@
+package applications
+
+import (
+	"banking-app/eligibility"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"banking-app/statushistory"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Application statuses
+const (
+	StatusDraft     = "draft"
+	StatusSubmitted = "submitted" // Waiting for staff: the applicant failed an eligibility criterion
+	StatusApproved  = "approved"
+	StatusRejected  = "rejected"
+	StatusOpened    = "opened"
+	StatusExpired   = "expired"
+)
+
+// Steps a draft is filled in by; every step must be completed before it is submitted
+const (
+	StepProduct = "product" // Desired product and currency
+	StepFunding = "funding" // How the account is funded when it opens
+)
+
+// Steps lists every step in the order they are filled in
+var Steps = []string{StepProduct, StepFunding}
+
+// Funding methods
+const (
+	FundingNone     = "none"     // Opened empty
+	FundingTransfer = "transfer" // From another of the applicant's accounts, as the account opens
+)
+
+// FundingMethods lists every funding method
+var FundingMethods = []string{FundingNone, FundingTransfer}
+
+// DraftTTL is how long a draft waits to be submitted before the application-expiry job expires it
+const DraftTTL = 30 * 24 * time.Hour
+
+// MinKYCLevel is the identity verification level an applicant needs whatever the product requires
+const MinKYCLevel = 1
+
+// Reasons recorded in the status history for transitions without a free-text reason
+const (
+	ReasonSubmitted = "Submitted"
+	ReasonEligible  = "Eligible on submission"
+	ReasonReferred  = "Referred for staff review"
+	ReasonOpened    = "Account opened"
+	ReasonExpired   = "Not submitted within 30 days"
+)
+
+// Application errors - handlers map these to client responses
+var (
+	ErrNotDraft     = errors.New("only draft applications can be changed or submitted")
+	ErrNotSubmitted = errors.New("application is not waiting for a staff decision")
+	ErrNotApproved  = errors.New("only approved applications can be opened")
+)
+
+// IncompleteError refuses to submit a draft with steps left to fill in
+type IncompleteError struct {
+	Missing []string
+}
+
+func (e *IncompleteError) Error() string {
+	return "application steps not completed: " + strings.Join(e.Missing, ", ")
+}
+
+// Create starts a draft for a customer; by is the customer's or staff member's username
+func Create(tx *gorm.DB, customer models.Customer, by string, now time.Time) (models.AccountApplication, error) {
+	app := models.AccountApplication{
+		TenantID:   customer.TenantID,
+		CustomerID: customer.ID,
+		Status:     StatusDraft,
+		CreatedBy:  by,
+		ExpiresAt:  now.Add(DraftTTL),
+	}
+	if err := tx.Create(&app).Error; err != nil {
+		return app, err
+	}
+	return app, statushistory.Created(tx, app.TenantID, statushistory.SubjectApplication, app.ID, app.Status, by)
+}
+
+// Completed reports whether a step of an application has been filled in
+func Completed(app models.AccountApplication, step string) bool {
+	for _, s := range strings.Split(app.CompletedSteps, ",") {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// Complete marks a step filled in; the caller saves the application
+func Complete(app *models.AccountApplication, step string) {
+	var steps []string
+	for _, s := range Steps {
+		if s == step || Completed(*app, s) {
+			steps = append(steps, s)
+		}
+	}
+	app.CompletedSteps = strings.Join(steps, ",")
+}
+
+// Save stores the steps of a draft; it fails with ErrNotDraft when the draft was submitted or expired meanwhile
+func Save(tx *gorm.DB, app *models.AccountApplication) error {
+	if app.Status != StatusDraft {
+		return ErrNotDraft
+	}
+	return transition(tx, app, StatusDraft, "", "", time.Time{})
+}
+
+// Submit evaluates a completed draft against the product's eligibility rules and the applicant's KYC level
+// An eligible applicant is approved at once; otherwise the application waits for staff with the failed criteria
+func Submit(tx *gorm.DB, app *models.AccountApplication, by string, now time.Time) ([]eligibility.Failure, error) {
+	if app.Status != StatusDraft {
+		return nil, ErrNotDraft
+	}
+	var missing []string
+	for _, step := range Steps {
+		if !Completed(*app, step) {
+			missing = append(missing, step)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &IncompleteError{Missing: missing}
+	}
+
+	var customer models.Customer
+	if err := tx.First(&customer, app.CustomerID).Error; err != nil {
+		return nil, err
+	}
+	failures, err := Evaluate(tx, app.AccountType, customer, now)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(failures)
+	if err != nil {
+		return nil, err
+	}
+	app.FailedCriteria, app.SubmittedAt = string(encoded), &now
+	if len(failures) > 0 {
+		return failures, transition(tx, app, StatusSubmitted, ReasonReferred, by, now)
+	}
+	if err := transition(tx, app, StatusSubmitted, ReasonSubmitted, by, now); err != nil {
+		return nil, err
+	}
+	app.DecidedBy, app.DecidedAt = statushistory.SystemActor, &now
+	return failures, transition(tx, app, StatusApproved, ReasonEligible, statushistory.SystemActor, now)
+}
+
+// Evaluate checks an applicant against the rules of the product an account type is on, and against MinKYCLevel
+func Evaluate(db *gorm.DB, accountType string, customer models.Customer, now time.Time) ([]eligibility.Failure, error) {
+	failures := []eligibility.Failure{}
+	product, err := eligibility.Lookup(db, accountType)
+	if err != nil {
+		return nil, err
+	}
+	if product != nil {
+		if failures, err = eligibility.Evaluate(db, *product, customer, now); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range failures {
+		if f.Criterion == eligibility.CriterionKYCLevel {
+			return failures, nil
+		}
+	}
+	if customer.KYCLevel < MinKYCLevel {
+		failures = append(failures, eligibility.Failure{
+			Criterion: eligibility.CriterionKYCLevel,
+			Message:   fmt.Sprintf("KYC level %d is required to open an account", MinKYCLevel),
+			Required:  MinKYCLevel,
+			Actual:    customer.KYCLevel,
+		})
+	}
+	return failures, nil
+}
+
+// Failures decodes the eligibility failures an application was submitted with
+func Failures(app models.AccountApplication) []eligibility.Failure {
+	failures := []eligibility.Failure{}
+	if app.FailedCriteria != "" {
+		if err := json.Unmarshal([]byte(app.FailedCriteria), &failures); err != nil {
+			return []eligibility.Failure{}
+		}
+	}
+	return failures
+}
+
+// Decide records a staff decision on a submitted application with the reason
+func Decide(tx *gorm.DB, app *models.AccountApplication, approve bool, reason, by string, now time.Time) error {
+	status := StatusRejected
+	if approve {
+		status = StatusApproved
+	}
+	if !lifecycle.Allows(lifecycle.Application, app.Status, status) {
+		return ErrNotSubmitted
+	}
+	app.DecidedBy, app.DecidedAt, app.DecisionReason = by, &now, reason
+	return transition(tx, app, status, reason, by, now)
+}
+
+// Opened records the account an approved application was opened into, in the transaction that opened it
+func Opened(tx *gorm.DB, app *models.AccountApplication, accountID uint, by string, now time.Time) error {
+	if !lifecycle.Allows(lifecycle.Application, app.Status, StatusOpened) {
+		return ErrNotApproved
+	}
+	app.AccountID, app.OpenedAt = &accountID, &now
+	return transition(tx, app, StatusOpened, ReasonOpened, by, now)
+}
+
+// Expire expires drafts not submitted by their expiry time; it runs without a tenant context
+// A draft submitted meanwhile is left alone
+func Expire(db *gorm.DB, now time.Time) (int, error) {
+	var drafts []models.AccountApplication
+	if err := db.Where("status = ? AND expires_at <= ?", StatusDraft, now).Order("id").Find(&drafts).Error; err != nil {
+		return 0, err
+	}
+	expired := 0
+	for i := range drafts {
+		app := &drafts[i]
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return transition(tx, app, StatusExpired, ReasonExpired, statushistory.SystemActor, now)
+		})
+		if err == ErrNotDraft {
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// stale is the error for an application that left a status before a transition from it could be saved
+var stale = map[string]error{
+	StatusDraft:     ErrNotDraft,
+	StatusSubmitted: ErrNotSubmitted,
+	StatusApproved:  ErrNotApproved,
+}
+
+// transition saves an application in its new status and records the change in its status history
+// The update only applies while the stored status is still the one the application was read with, so two
+// concurrent transitions cannot both succeed
+func transition(tx *gorm.DB, app *models.AccountApplication, status, reason, by string, now time.Time) error {
+	from := app.Status
+	app.Status = status
+	result := tx.Model(app).Where("status = ?", from).Select("*").Omit("created_at").Updates(app)
+	if result.Error != nil {
+		app.Status = from
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		app.Status = from
+		return stale[from]
+	}
+	// Saving a draft's steps keeps its status, which statushistory.Record does not record
+	return statushistory.Record(tx, models.StatusHistory{
+		TenantID:    app.TenantID,
+		SubjectType: statushistory.SubjectApplication,
+		SubjectID:   app.ID,
+		OldStatus:   from,
+		NewStatus:   status,
+		Reason:      reason,
+		ChangedBy:   by,
+		ChangedAt:   now,
+	})
+}
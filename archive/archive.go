@@ -0,0 +1,561 @@
+package archive
+
+import (
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/money"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Table holds postings moved out of the transactions table, with the same columns and IDs
+const Table = "transactions_archive"
+
+// ActorJob records batches moved by the scheduled job
+const ActorJob = "archive-job"
+
+// MaxAfterMonths is the longest postings stay hot however the job is configured: seven years
+const MaxAfterMonths = 84
+
+// Errors
+var (
+	ErrReconcile = errors.New("archive counts do not reconcile")
+	ErrRange     = errors.New("from must be before to")
+)
+
+// Config holds how old postings get before they are archived and how many move per batch
+type Config struct {
+	AfterMonths int // Postings effective and created more than this many months ago are archived
+	BatchSize   int // Postings moved per database transaction
+}
+
+// ConfigFromEnv reads ARCHIVE_AFTER_MONTHS (default 18, at most 84) and ARCHIVE_BATCH_SIZE (default 500)
+func ConfigFromEnv() Config {
+	months, err := strconv.Atoi(os.Getenv("ARCHIVE_AFTER_MONTHS"))
+	if err != nil || months <= 0 {
+		months = 18
+	}
+	if months > MaxAfterMonths {
+		months = MaxAfterMonths
+	}
+	size, err := strconv.Atoi(os.Getenv("ARCHIVE_BATCH_SIZE"))
+	if err != nil || size <= 0 {
+		size = 500
+	}
+	return Config{AfterMonths: months, BatchSize: size}
+}
+
+// Cutoff is the instant before which postings are archived
+func (cfg Config) Cutoff(now time.Time) time.Time {
+	return now.AddDate(0, -cfg.AfterMonths, 0)
+}
+
+// columns is the quoted column list shared by both tables, read from the transactions table once per process
+var columns struct {
+	sync.Mutex
+	list string
+}
+
+// column is one row of PRAGMA table_info
+type column struct {
+	Name string
+	Type string
+}
+
+// tableColumns returns a table's columns in order
+func tableColumns(db *gorm.DB, table string) ([]column, error) {
+	var cols []column
+	err := db.Raw("SELECT name, type FROM pragma_table_info(?) ORDER BY cid", table).Scan(&cols).Error
+	return cols, err
+}
+
+// Ensure creates the archive table from the transactions table's columns, adding any the transactions table has
+// gained since, with the indexes statements and account history read it by
+// It runs after AutoMigrate, so the archive always has every column a posting can have
+func Ensure(db *gorm.DB) error {
+	hot, err := tableColumns(db, "transactions")
+	if err != nil {
+		return err
+	}
+	if len(hot) == 0 {
+		return errors.New("transactions table not found")
+	}
+	existing, err := tableColumns(db, Table)
+	if err != nil {
+		return err
+	}
+
+	if len(existing) == 0 {
+		defs := make([]string, 0, len(hot))
+		for _, c := range hot {
+			if c.Name == "id" {
+				defs = append(defs, "`id` integer PRIMARY KEY") // IDs are copied, never assigned
+				continue
+			}
+			defs = append(defs, "`"+c.Name+"` "+c.Type)
+		}
+		if err := db.Exec("CREATE TABLE `" + Table + "` (" + strings.Join(defs, ",") + ")").Error; err != nil {
+			return err
+		}
+	} else {
+		have := make(map[string]bool, len(existing))
+		for _, c := range existing {
+			have[c.Name] = true
+		}
+		for _, c := range hot {
+			if !have[c.Name] {
+				if err := db.Exec("ALTER TABLE `" + Table + "` ADD COLUMN `" + c.Name + "` " + c.Type).Error; err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	indexes := []string{
+		"CREATE INDEX IF NOT EXISTS idx_transactions_archive_account_effective ON " + Table + "(account_id, effective_date)",
+		"CREATE INDEX IF NOT EXISTS idx_transactions_archive_account_created ON " + Table + "(account_id, created_at)",
+		"CREATE INDEX IF NOT EXISTS idx_transactions_archive_tenant_id ON " + Table + "(tenant_id)",
+	}
+	for _, statement := range indexes {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, len(hot))
+	for i, c := range hot {
+		names[i] = "`" + c.Name + "`"
+	}
+	columns.Lock()
+	columns.list = strings.Join(names, ",")
+	columns.Unlock()
+	return nil
+}
+
+// columnList returns the shared column list, reading it if Ensure has not run in this process
+func columnList(db *gorm.DB) (string, error) {
+	columns.Lock()
+	defer columns.Unlock()
+	if columns.list != "" {
+		return columns.list, nil
+	}
+	hot, err := tableColumns(db.Session(&gorm.Session{NewDB: true}), "transactions")
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, len(hot))
+	for i, c := range hot {
+		names[i] = "`" + c.Name + "`"
+	}
+	columns.list = strings.Join(names, ",")
+	return columns.list, nil
+}
+
+// Transactions is the hot and archived postings together, queried as if they were the transactions table
+// Callers add conditions as usual, e.g. Transactions(db).Where("account_id = ?", id).Find(&postings); the
+// soft-delete and tenant scopes apply to the union as they would to the table
+func Transactions(db *gorm.DB) *gorm.DB {
+	list, err := columnList(db)
+	if err != nil {
+		db = db.Session(&gorm.Session{})
+		db.AddError(err)
+		return db
+	}
+	union := db.Session(&gorm.Session{NewDB: true}).
+		Raw("SELECT " + list + " FROM transactions UNION ALL SELECT " + list + " FROM " + Table)
+	return db.Model(&models.Transaction{}).Table("(?) AS transactions", union)
+}
+
+// Summary returns an account's archive totals, and whether it has archived postings at all
+func Summary(db *gorm.DB, accountID uint) (models.TransactionArchive, bool, error) {
+	var found []models.TransactionArchive
+	err := db.Where("account_id = ?", accountID).Limit(1).Find(&found).Error
+	if err != nil || len(found) == 0 {
+		return models.TransactionArchive{}, false, err
+	}
+	return found[0], true, nil
+}
+
+// Reaches reports whether an account's postings from a time on may include archived ones
+// A nil from is the account's whole history
+func Reaches(db *gorm.DB, accountID uint, from *time.Time) (bool, error) {
+	summary, ok, err := Summary(db, accountID)
+	if err != nil || !ok {
+		return false, err
+	}
+	return from == nil || !from.After(summary.Through), nil
+}
+
+// Result counts what a run moved
+type Result struct {
+	Rows    int `json:"rows"`    // Postings moved
+	Batches int `json:"batches"` // Database transactions committed
+}
+
+// Run archives postings effective and created before the cutoff, one batch per database transaction
+// Each account's latest posting stays hot, since new postings chain their hash to it, and so do ranges an
+// administrator unarchived until their hold ends and postings linked to an open SAR case. A run that stops part
+// way leaves every committed batch in place, and the next run carries on from there
+func Run(db *gorm.DB, cfg Config, now time.Time) (Result, error) {
+	var result Result
+	cutoff := cfg.Cutoff(now)
+
+	// An unarchive records its range on each of its batches, so ranges are held once
+	var batches, holds []models.ArchiveBatch
+	err := db.Where("direction = ? AND hold_until > ?", models.ArchiveDirectionUnarchive, now).Find(&batches).Error
+	if err != nil {
+		return result, err
+	}
+	held := map[string]bool{}
+	for _, b := range batches {
+		key := fmt.Sprint(accountOf(b), b.From.Unix(), b.To.Unix())
+		if !held[key] {
+			held[key] = true
+			holds = append(holds, b)
+		}
+	}
+
+	for {
+		moved, err := archiveBatch(db, cutoff, holds, cfg.BatchSize)
+		if err != nil {
+			return result, err
+		}
+		if moved == 0 {
+			return result, nil
+		}
+		result.Rows += moved
+		result.Batches++
+		if moved < cfg.BatchSize {
+			return result, nil
+		}
+	}
+}
+
+// archiveBatch moves up to size postings into the archive and returns how many moved
+func archiveBatch(db *gorm.DB, cutoff time.Time, holds []models.ArchiveBatch, size int) (int, error) {
+	moved := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Unscoped().Table("transactions").
+			Select("id, tenant_id, account_id, transaction_type, amount, effective_date, created_at, deleted_at").
+			Where("effective_date < ? AND created_at < ?", cutoff, cutoff).
+			Where("id NOT IN (SELECT MAX(id) FROM transactions GROUP BY account_id)").
+			Where("id NOT IN (?)", lockedBySAR(tx))
+		for _, hold := range holds {
+			query = query.Where("NOT (effective_date >= ? AND effective_date < ? AND (? = 0 OR account_id = ?))",
+				*hold.From, *hold.To, accountOf(hold), accountOf(hold))
+		}
+		var rows []models.Transaction
+		if err := query.Order("id").Limit(size).Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		if err := move(tx, "transactions", Table, rows); err != nil {
+			return err
+		}
+		if err := addToSummaries(tx, rows, 1); err != nil {
+			return err
+		}
+		batch := models.ArchiveBatch{Direction: models.ArchiveDirectionArchive, Cutoff: &cutoff, Rows: len(rows),
+			FirstID: rows[0].ID, LastID: rows[len(rows)-1].ID, Actor: ActorJob}
+		if err := tx.Create(&batch).Error; err != nil {
+			return err
+		}
+		moved = len(rows)
+		return nil
+	})
+	return moved, err
+}
+
+// lockedBySAR selects the postings linked to an open or investigating SAR case, which stay hot until it is filed
+// or closed. The statuses are spelled out, since the sar package reads through this one
+func lockedBySAR(tx *gorm.DB) *gorm.DB {
+	return tx.Session(&gorm.Session{NewDB: true}).Table("sar_case_transactions").
+		Select("sar_case_transactions.transaction_id").
+		Joins("JOIN sar_cases ON sar_cases.id = sar_case_transactions.case_id").
+		Where("sar_cases.status IN ?", []string{"open", "investigating"})
+}
+
+// Range selects archived postings to bring back by effective date
+type Range struct {
+	AccountID uint      // Zero for every account
+	From      time.Time // Inclusive
+	To        time.Time // Exclusive
+}
+
+// Unarchive moves a range of archived postings back into the transactions table in batches and holds the range
+// out of the archive job until holdUntil
+func Unarchive(db *gorm.DB, r Range, size int, actor string, holdUntil time.Time) (Result, error) {
+	var result Result
+	if !r.From.Before(r.To) {
+		return result, ErrRange
+	}
+	for {
+		moved, err := unarchiveBatch(db, r, size, actor, holdUntil)
+		if err != nil {
+			return result, err
+		}
+		if moved == 0 {
+			return result, nil
+		}
+		result.Rows += moved
+		result.Batches++
+		if moved < size {
+			return result, nil
+		}
+	}
+}
+
+// unarchiveBatch moves up to size postings of a range back and returns how many moved
+func unarchiveBatch(db *gorm.DB, r Range, size int, actor string, holdUntil time.Time) (int, error) {
+	moved := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Unscoped().Table(Table).
+			Select("id, tenant_id, account_id, transaction_type, amount, effective_date, created_at, deleted_at").
+			Where("effective_date >= ? AND effective_date < ?", r.From, r.To)
+		if r.AccountID != 0 {
+			query = query.Where("account_id = ?", r.AccountID)
+		}
+		var rows []models.Transaction
+		if err := query.Order("id").Limit(size).Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		if err := move(tx, Table, "transactions", rows); err != nil {
+			return err
+		}
+		if err := addToSummaries(tx, rows, -1); err != nil {
+			return err
+		}
+		batch := models.ArchiveBatch{Direction: models.ArchiveDirectionUnarchive, From: &r.From, To: &r.To,
+			Rows: len(rows), FirstID: rows[0].ID, LastID: rows[len(rows)-1].ID, Actor: actor, HoldUntil: &holdUntil}
+		if r.AccountID != 0 {
+			batch.AccountID = &r.AccountID
+		}
+		if err := tx.Create(&batch).Error; err != nil {
+			return err
+		}
+		moved = len(rows)
+		return nil
+	})
+	return moved, err
+}
+
+// move copies postings from one table to the other and deletes them from the first, failing with ErrReconcile
+// unless every row was copied and removed exactly once
+func move(tx *gorm.DB, from, to string, rows []models.Transaction) error {
+	list, err := columnList(tx)
+	if err != nil {
+		return err
+	}
+	ids := make([]uint, len(rows))
+	for i, t := range rows {
+		ids[i] = t.ID
+	}
+	want := int64(len(ids))
+
+	inserted := tx.Exec("INSERT INTO "+to+" ("+list+") SELECT "+list+" FROM "+from+" WHERE id IN ?", ids)
+	if inserted.Error != nil {
+		return inserted.Error
+	}
+	var copied, source int64
+	if err := tx.Table(to).Where("id IN ?", ids).Count(&copied).Error; err != nil {
+		return err
+	}
+	if err := tx.Table(from).Where("id IN ?", ids).Count(&source).Error; err != nil {
+		return err
+	}
+	if inserted.RowsAffected != want || copied != want || source != want {
+		return fmt.Errorf("%w: %d selected, %d inserted, %d in %s, %d in %s", ErrReconcile, want,
+			inserted.RowsAffected, copied, to, source, from)
+	}
+
+	deleted := tx.Exec("DELETE FROM "+from+" WHERE id IN ?", ids)
+	if deleted.Error != nil {
+		return deleted.Error
+	}
+	if deleted.RowsAffected != want {
+		return fmt.Errorf("%w: %d copied to %s but %d deleted from %s", ErrReconcile, want, to, deleted.RowsAffected, from)
+	}
+	return nil
+}
+
+// addToSummaries adds moved postings to their accounts' archive totals, or takes them away when sign is -1. Totals
+// are kept in each account's currency, so they carry no floating point noise however many batches are moved
+func addToSummaries(tx *gorm.DB, rows []models.Transaction, sign int) error {
+	type change struct {
+		tenantID uint
+		count    int64
+		net      money.Money
+		latest   time.Time
+	}
+	currencies, err := accountCurrencies(tx, rows)
+	if err != nil {
+		return err
+	}
+	changes := map[uint]*change{}
+	var order []uint
+	for _, t := range rows {
+		c, ok := changes[t.AccountID]
+		if !ok {
+			c = &change{tenantID: t.TenantID, net: money.Zero(currencies[t.AccountID])}
+			changes[t.AccountID] = c
+			order = append(order, t.AccountID)
+		}
+		if latest := later(t.EffectiveDate, t.CreatedAt); latest.After(c.latest) {
+			c.latest = latest
+		}
+		if t.DeletedAt.Valid {
+			continue
+		}
+		c.count++
+		if c.net, err = c.net.Add(money.FromFloat(ledger.SignedAmount(t), c.net.Currency)); err != nil {
+			return err
+		}
+	}
+
+	for _, accountID := range order {
+		c := changes[accountID]
+		summary, ok, err := Summary(tx, accountID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			summary = models.TransactionArchive{TenantID: c.tenantID, AccountID: accountID}
+		}
+		summary.Count += int64(sign) * c.count
+		net := c.net
+		if sign < 0 {
+			net = net.Neg()
+		}
+		total, err := money.FromFloat(summary.Net, net.Currency).Add(net)
+		if err != nil {
+			return err
+		}
+		summary.Net = total.Float()
+		if sign > 0 {
+			if c.latest.After(summary.Through) {
+				summary.Through = c.latest
+			}
+		} else {
+			latest, any, err := latestArchived(tx, accountID)
+			if err != nil {
+				return err
+			}
+			if !any {
+				if summary.ID != 0 {
+					if err := tx.Delete(&summary).Error; err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			summary.Through = latest
+		}
+		if err := tx.Save(&summary).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// latestArchived returns the latest effective date or creation time among an account's archived postings
+func latestArchived(tx *gorm.DB, accountID uint) (time.Time, bool, error) {
+	var latest time.Time
+	for _, field := range []string{"effective_date", "created_at"} {
+		var found []models.Transaction
+		err := tx.Unscoped().Table(Table).Select("id, effective_date, created_at").Where("account_id = ?", accountID).
+			Order(field + " DESC").Limit(1).Find(&found).Error
+		if err != nil {
+			return latest, false, err
+		}
+		if len(found) == 0 {
+			return latest, false, nil
+		}
+		if l := later(found[0].EffectiveDate, found[0].CreatedAt); l.After(latest) {
+			latest = l
+		}
+	}
+	return latest, true, nil
+}
+
+// Status is the archive at a glance
+type Status struct {
+	AfterMonths int                   `json:"after_months"` // Configured hot period
+	Cutoff      time.Time             `json:"cutoff"`       // Postings before this are due for archiving
+	HotRows     int64                 `json:"hot_rows"`     // Postings in the transactions table
+	Archived    int64                 `json:"archived_rows"`
+	Accounts    int64                 `json:"accounts"`       // Accounts with archived postings
+	Due         int64                 `json:"due_rows"`       // Hot postings the next run would consider
+	Batches     []models.ArchiveBatch `json:"recent_batches"` // Latest batches, newest first
+}
+
+// GetStatus reports the sizes of both tables and the latest batches
+func GetStatus(db *gorm.DB, cfg Config, now time.Time) (Status, error) {
+	status := Status{AfterMonths: cfg.AfterMonths, Cutoff: cfg.Cutoff(now), Batches: []models.ArchiveBatch{}}
+	counts := []struct {
+		query *gorm.DB
+		into  *int64
+	}{
+		{db.Table("transactions"), &status.HotRows},
+		{db.Table(Table), &status.Archived},
+		{db.Model(&models.TransactionArchive{}), &status.Accounts},
+		{db.Table("transactions").Where("effective_date < ? AND created_at < ?", status.Cutoff, status.Cutoff), &status.Due},
+	}
+	for _, c := range counts {
+		if err := c.query.Count(c.into).Error; err != nil {
+			return status, err
+		}
+	}
+	err := db.Order("id DESC").Limit(20).Find(&status.Batches).Error
+	return status, err
+}
+
+// accountOf is the account a hold covers, zero for all
+func accountOf(b models.ArchiveBatch) uint {
+	if b.AccountID == nil {
+		return 0
+	}
+	return *b.AccountID
+}
+
+// later returns the later of two times
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// accountCurrencies returns the currency of each account the rows are posted to, including closed accounts
+func accountCurrencies(tx *gorm.DB, rows []models.Transaction) (map[uint]string, error) {
+	seen := map[uint]bool{}
+	var ids []uint
+	for _, t := range rows {
+		if !seen[t.AccountID] {
+			seen[t.AccountID] = true
+			ids = append(ids, t.AccountID)
+		}
+	}
+	var accounts []models.Account
+	if err := tx.Unscoped().Select("id, currency").Where("id IN ?", ids).Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	currencies := make(map[uint]string, len(accounts))
+	for _, a := range accounts {
+		currencies[a.ID] = a.Currency
+	}
+	return currencies, nil
+}
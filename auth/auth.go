@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"banking-app/clock"
+	"banking-app/models"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Supported user roles
+var Roles = []string{"admin", "teller", "compliance", "customer"}
+
+// Permissions granted beyond ordinary access
+const (
+	PermPostBackdated  = "transactions:backdate"    // Post entries effective before today
+	PermPostCharges    = "transactions:charges"     // Post interest credits and fee debits
+	PermExceptions     = "operations:exceptions"    // Work the suspense exception queue
+	PermEligibility    = "accounts:eligibility"     // Override product eligibility criteria and set KYC levels
+	PermReveal         = "accounts:reveal"          // See full account numbers with ?reveal=true
+	PermInternalNotes  = "notes:internal"           // Write notes and read internal ones
+	PermCommunications = "customers:communications" // Read customers' communication logs
+	PermTags           = "tags:apply"               // Add and remove customer and account tags
+	PermRestrictions   = "accounts:restrictions"    // Set an account's deposit and withdrawal restrictions
+	PermApprovals      = "operations:approvals"     // Approve or reject withdrawals held for staff approval
+	PermLiens          = "accounts:liens"           // Place, change, satisfy and release liens on accounts
+	PermGarnishments   = "accounts:garnishments"    // Register, suspend, resume and satisfy garnishment orders
+	PermInvestigations = "investigations:flow"      // Trace money movement between accounts
+	PermStaffAccounts  = "accounts:staff"           // See accounts held by bank staff in investigations
+	PermCreditReview   = "loans:credit_review"      // Decide loan applications referred for manual credit review
+	PermStatusHistory  = "records:status_history"   // Read customer, account and loan status histories
+	PermBatchPostings  = "transactions:batch"       // Post atomic multi-leg batches, general-ledger legs included
+	PermAccessReports  = "customers:access_reports" // See who read or changed a customer's records
+	PermApplications   = "accounts:applications"    // Decide account applications referred for staff review
+	PermCustomerStatus = "customers:status"         // Activate and deactivate customers
+	PermAuthorizations = "transactions:authorize"   // Place, capture and release pre-authorization holds
+	PermSARCases       = "compliance:sar_cases"     // Open, work and export suspicious activity report cases
+	PermAccountHooks   = "accounts:webhooks"        // Manage webhooks on customers' accounts on their behalf
+	PermDisputes       = "transactions:disputes"    // Open disputes on customers' behalf, read and resolve them
+)
+
+// rolePermissions maps each role to its special permissions
+// SAR cases are for the compliance role alone; admins and tellers must not see them
+var rolePermissions = map[string][]string{
+	"admin":      {PermPostBackdated, PermPostCharges, PermExceptions, PermEligibility, PermReveal, PermInternalNotes, PermCommunications, PermTags, PermRestrictions, PermApprovals, PermLiens, PermGarnishments, PermInvestigations, PermStaffAccounts, PermCreditReview, PermStatusHistory, PermBatchPostings, PermAccessReports, PermApplications, PermCustomerStatus, PermAuthorizations, PermAccountHooks, PermDisputes},
+	"teller":     {PermPostBackdated, PermPostCharges, PermExceptions, PermEligibility, PermReveal, PermInternalNotes, PermCommunications, PermTags, PermApprovals, PermInvestigations, PermStatusHistory, PermBatchPostings, PermApplications, PermCustomerStatus, PermAuthorizations, PermAccountHooks, PermDisputes},
+	"compliance": {PermReveal, PermInvestigations, PermStaffAccounts, PermStatusHistory, PermAccessReports, PermSARCases},
+}
+
+// Can reports whether a role holds a permission
+func Can(role, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxFailedLogins is the number of consecutive failures that locks a user
+const MaxFailedLogins = 5
+
+// Sign-in errors - callers should not reveal which one occurred to the client
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrLocked             = errors.New("user is locked")
+)
+
+// HashPassword returns the bcrypt hash stored for a password
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// CreateUser stores a new user with a hashed password
+func CreateUser(db *gorm.DB, username, password, role string) (models.User, error) {
+	user := models.User{Username: username, Role: role, Status: "active"}
+	if username == "" || len(password) < 8 {
+		return user, errors.New("username is required and password must be at least 8 characters")
+	}
+	valid := false
+	for _, r := range Roles {
+		valid = valid || r == role
+	}
+	if !valid {
+		return user, errors.New("invalid role: " + role)
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return user, err
+	}
+	user.PasswordHash = hash
+	return user, db.Create(&user).Error
+}
+
+// CreateCustomerUser stores a customer-role user who signs in as an existing customer
+func CreateCustomerUser(db *gorm.DB, username, password string, customerID uint) (models.User, error) {
+	var user models.User
+	var count int64
+	if err := db.Model(&models.Customer{}).Where("id = ?", customerID).Count(&count).Error; err != nil {
+		return user, err
+	}
+	if count == 0 {
+		return user, fmt.Errorf("customer %d not found", customerID)
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if user, err = CreateUser(tx, username, password, "customer"); err != nil {
+			return err
+		}
+		user.CustomerID = &customerID
+		return tx.Model(&user).Update("customer_id", customerID).Error
+	})
+	return user, err
+}
+
+// Authenticate checks a username/password pair and records the outcome
+// Locks the user after MaxFailedLogins consecutive failures
+func Authenticate(db *gorm.DB, username, password string) (models.User, error) {
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return user, ErrInvalidCredentials
+		}
+		return user, err
+	}
+	if user.Status != "active" {
+		return user, ErrLocked
+	}
+
+	now := clock.Now()
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		updates := map[string]interface{}{"failed_logins": gorm.Expr("failed_logins + 1")}
+		if user.FailedLogins+1 >= MaxFailedLogins {
+			updates["status"] = "locked"
+			updates["locked_at"] = now
+		}
+		if err := db.Model(&user).Updates(updates).Error; err != nil {
+			return user, err
+		}
+		return user, ErrInvalidCredentials
+	}
+
+	err := db.Model(&user).Updates(map[string]interface{}{"failed_logins": 0, "last_login_at": now}).Error
+	return user, err
+}
+
+// Unlock clears a user's lock and failed sign-in counter
+func Unlock(db *gorm.DB, username string) (models.User, error) {
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return user, err
+	}
+	user.Status = "active"
+	user.FailedLogins = 0
+	user.LockedAt = nil
+	err := db.Model(&user).Updates(map[string]interface{}{
+		"status":        user.Status,
+		"failed_logins": user.FailedLogins,
+		"locked_at":     nil,
+	}).Error
+	return user, err
+}
+
+// Disable deactivates a user so they can no longer sign in
+func Disable(db *gorm.DB, username string) (models.User, error) {
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return user, err
+	}
+	user.Status = "disabled"
+	err := db.Model(&user).Update("status", user.Status).Error
+	return user, err
+}
+
+// GenerateSecret returns a random value suitable for JWT_SECRET
+func GenerateSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
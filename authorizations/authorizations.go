@@ -0,0 +1,406 @@
+package authorizations
+
+import (
+	"banking-app/alerts"
+	"banking-app/cache"
+	"banking-app/communications"
+	"banking-app/creditlines"
+	"banking-app/enrichment"
+	"banking-app/events"
+	"banking-app/fees"
+	"banking-app/flags"
+	"banking-app/ledger"
+	"banking-app/liens"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"banking-app/notifications"
+	"banking-app/restrictions"
+	"banking-app/tenancy"
+	"banking-app/transfers"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Authorization statuses
+const (
+	StatusPending  = ledger.AuthorizationPending
+	StatusCaptured = "captured"
+	StatusReleased = "released"
+	StatusExpired  = "expired"
+)
+
+// Channels - each has its own default expiry
+const (
+	ChannelCard     = "card"     // Captured as a withdrawal by the merchant
+	ChannelTransfer = "transfer" // Captured as a transfer to ToAccountID
+)
+
+// SystemActor expires holds whose time passed
+const SystemActor = "system"
+
+// Authorization errors - handlers map these to client responses
+var (
+	ErrChannel       = errors.New("channel must be card or transfer")
+	ErrNotPending    = errors.New("authorization has already been captured or released")
+	ErrExpired       = errors.New("authorization expired before it was captured")
+	ErrExceedsAmount = errors.New("capture amount exceeds the authorized amount")
+)
+
+// Config holds how long each channel's holds last before they are released
+type Config struct {
+	Expiry map[string]time.Duration
+}
+
+// ConfigFromEnv reads AUTHORIZATION_CARD_HOURS (default 168, 7 days) and AUTHORIZATION_TRANSFER_HOURS (default 24)
+func ConfigFromEnv() Config {
+	hours := func(name string, fallback int) time.Duration {
+		h, err := strconv.Atoi(os.Getenv(name))
+		if err != nil || h <= 0 {
+			h = fallback
+		}
+		return time.Duration(h) * time.Hour
+	}
+	return Config{Expiry: map[string]time.Duration{
+		ChannelCard:     hours("AUTHORIZATION_CARD_HOURS", 7*24),
+		ChannelTransfer: hours("AUTHORIZATION_TRANSFER_HOURS", 24),
+	}}
+}
+
+// transactionType is the debit a channel's capture posts, checked against the account's restrictions
+func transactionType(channel string) string {
+	if channel == ChannelTransfer {
+		return "transfer"
+	}
+	return "withdrawal"
+}
+
+// Authorize places a hold on an account for a later capture, returning it and whether it is new
+// The amount must fit in the account's available funds and leaves them until the hold is captured, released or
+// expires. A request repeating the reference of a pending hold on the account returns that hold; one repeating the
+// reference of a hold that expired or was released places a fresh hold rather than reviving the old one
+func Authorize(db *gorm.DB, cfg Config, featureFlags *flags.Store, a models.Authorization, now time.Time) (models.Authorization, bool, error) {
+	expiry, ok := cfg.Expiry[a.Channel]
+	if !ok {
+		return a, false, ErrChannel
+	}
+	if err := ledger.ValidateAmount(a.Amount); err != nil {
+		return a, false, err
+	}
+	if a.Channel == ChannelTransfer {
+		if err := ledger.ValidateMovement(a.AccountID, a.ToAccountID, a.Amount); err != nil {
+			return a, false, err
+		}
+	} else {
+		a.ToAccountID = 0
+	}
+
+	created := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var account models.Account
+		if err := tx.First(&account, a.AccountID).Error; err != nil {
+			return err
+		}
+		if account.Status != "active" {
+			return ledger.ErrAccountInactive
+		}
+		if err := restrictions.Check(tx, account.ID, transactionType(a.Channel), false); err != nil {
+			return err
+		}
+
+		a.PreviousID = nil
+		if a.Reference != "" {
+			var previous models.Authorization
+			if err := tx.Where("account_id = ? AND reference = ?", account.ID, a.Reference).Order("id DESC").
+				Limit(1).Find(&previous).Error; err != nil {
+				return err
+			}
+			switch {
+			case previous.ID == 0:
+			case previous.Status == StatusPending:
+				a = previous
+				return nil
+			case previous.Status != StatusCaptured:
+				a.PreviousID = &previous.ID
+			}
+		}
+		if a.Channel == ChannelTransfer {
+			if err := checkDestination(tx, account, a.ToAccountID); err != nil {
+				return err
+			}
+		}
+		available, err := ledger.Available(tx, account, featureFlags)
+		if err != nil {
+			return err
+		}
+		if available < a.Amount {
+			return ledger.ErrInsufficientFunds
+		}
+
+		a.ID = 0
+		a.Status = StatusPending
+		a.CustomerID = account.CustomerID
+		a.ExpiresAt = now.Add(expiry)
+		a.CapturedAmount = 0
+		a.TransactionID, a.TransferID, a.ClosedAt, a.ClosedBy = nil, nil, nil, ""
+		if err := tx.Create(&a).Error; err != nil {
+			return err
+		}
+		created = true
+		return nil
+	})
+	return a, created, err
+}
+
+// checkDestination refuses a transfer hold when it is placed, rather than when it is captured, if it could never post
+func checkDestination(tx *gorm.DB, from models.Account, toAccountID uint) error {
+	var to models.Account
+	if err := tx.Select("id, status, currency").First(&to, toAccountID).Error; err != nil {
+		return err
+	}
+	if to.Status != "active" {
+		return transfers.ErrDestinationInactive
+	}
+	if to.Currency != from.Currency {
+		return transfers.ErrCurrencyMismatch
+	}
+	return nil
+}
+
+// Posted is what a capture posted
+type Posted struct {
+	Transaction *models.Transaction // Withdrawal posted for a card hold; nil for a transfer
+	Transfer    *transfers.Result   // Transfer posted for a transfer hold; nil for a card hold
+	Fee         *models.Transaction // Fee charged with it, if any
+}
+
+// Capture posts a pending hold as a debit of amount, or of the whole hold when amount is 0, effective now
+// Whatever the capture leaves of the hold is released with it. A hold past its expiry fails with ErrExpired even
+// before the expiry job releases it, and since both move the hold out of pending only while it is still there,
+// a capture racing the job either posts or is refused, never both. A debit that no longer posts, such as one a
+// lien placed since blocks, fails with the posting's error and the hold stays pending
+func Capture(db *gorm.DB, a *models.Authorization, amount float64, by string, cfg transfers.Config, featureFlags *flags.Store, balances *cache.Balances, now time.Time) (Posted, error) {
+	var posted Posted
+	if amount == 0 {
+		amount = a.Amount
+	}
+	if err := ledger.ValidateAmount(amount); err != nil {
+		return posted, err
+	}
+	if amount > a.Amount {
+		return posted, ErrExceedsAmount
+	}
+	if err := capturable(*a, now); err != nil {
+		return posted, err
+	}
+
+	original := *a
+	var accounts []models.Account
+	var drawn *models.CreditLine
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Closing first takes the hold's own amount out of what the posting's funds check sees as held
+		if err := closeHold(tx, a, StatusCaptured, by, now); err != nil {
+			return err
+		}
+		a.CapturedAmount = amount
+		var tenant models.Tenant
+		if err := tx.Limit(1).Find(&tenant, a.TenantID).Error; err != nil {
+			return err
+		}
+		cutoffs := tenancy.SettingsFor(tenant).Cutoffs
+
+		if a.Channel == ChannelTransfer {
+			result, err := transfers.Post(tx, transfers.Request{
+				FromAccountID:    a.AccountID,
+				ToAccountID:      a.ToAccountID,
+				Amount:           amount,
+				Description:      a.Merchant,
+				Reference:        a.Reference,
+				Channel:          "api",
+				ConfirmDuplicate: true, // The hold itself was the original request
+				CreatedBy:        by,
+				Cutoffs:          cutoffs,
+			}, cfg, featureFlags)
+			if err != nil {
+				return err
+			}
+			posted.Transfer, posted.Fee = &result, result.Fee
+			accounts = []models.Account{result.From, result.To}
+			a.TransactionID, a.TransferID = &result.Transfer.DebitTransactionID, &result.Transfer.ID
+			return tx.Model(a).Select("captured_amount", "transaction_id", "transfer_id").Updates(a).Error
+		}
+
+		transaction := &models.Transaction{
+			AccountID:       a.AccountID,
+			TransactionType: "withdrawal",
+			Amount:          amount,
+			Description:     a.Merchant,
+			Reference:       a.Reference,
+			Channel:         ChannelCard,
+		}
+		if rules, err := enrichment.LoadRules(tx); err == nil {
+			enrichment.Apply(rules, transaction)
+		}
+		if err := restrictions.Check(tx, transaction.AccountID, transaction.TransactionType, false); err != nil {
+			return err
+		}
+		valueDate, err := ledger.ValueDate(tx, transaction.TransactionType, time.Time{}, cutoffs)
+		if err != nil {
+			return err
+		}
+		transaction.ValueDate = &valueDate
+		if drawn, err = creditlines.Cover(tx, transaction); err != nil {
+			return err
+		}
+		account, err := ledger.PostExternal(tx, transaction, featureFlags)
+		if err != nil {
+			return err
+		}
+		if posted.Fee, account, err = fees.Charge(tx, *transaction, account, featureFlags); err != nil {
+			return err
+		}
+		if err := liens.Enforce(tx, account); err != nil {
+			return err
+		}
+		posted.Transaction = transaction
+		accounts = []models.Account{account}
+		a.TransactionID = &transaction.ID
+		return tx.Model(a).Select("captured_amount", "transaction_id").Updates(a).Error
+	})
+	if err != nil {
+		*a = original
+		return Posted{}, err
+	}
+
+	for _, account := range accounts {
+		balances.Invalidate(account.ID)
+	}
+	if drawn != nil {
+		balances.Invalidate(drawn.AccountID)
+	}
+	if posted.Transfer != nil {
+		alerts.EvaluateTransaction(db, posted.Transfer.From, posted.Transfer.Debit)
+		alerts.EvaluateTransaction(db, posted.Transfer.To, posted.Transfer.Credit)
+	} else {
+		alerts.EvaluateTransaction(db, accounts[0], *posted.Transaction)
+	}
+	return posted, nil
+}
+
+// Release voids a pending hold without posting it, returning its amount to the account's available funds
+func Release(db *gorm.DB, a *models.Authorization, by string, now time.Time) error {
+	if !lifecycle.Allows(lifecycle.Authorization, a.Status, StatusReleased) {
+		return ErrNotPending
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		return closeHold(tx, a, StatusReleased, by, now)
+	})
+}
+
+// Expire releases the pending holds whose expiry has passed, returning how many it released
+// Each is recorded as an account event and its customer is told once their email is verified
+func Expire(db *gorm.DB, now time.Time) (int, error) {
+	var due []models.Authorization
+	if err := db.Where("status = ? AND expires_at <= ?", StatusPending, now).Order("expires_at, id").Find(&due).Error; err != nil {
+		return 0, err
+	}
+	expired := 0
+	for i := range due {
+		err := expire(db, &due[i], now)
+		switch {
+		case err == nil:
+			expired++
+		case errors.Is(err, ErrNotPending):
+			// Captured or released since it was listed
+		default:
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+// expire moves one hold past its expiry to expired, with its event and the customer's notice
+func expire(db *gorm.DB, a *models.Authorization, now time.Time) error {
+	var account models.Account
+	if err := db.Select("id, account_number, currency").First(&account, a.AccountID).Error; err != nil {
+		return err
+	}
+	var customer models.Customer
+	db.Select("id, email, email_verified").Limit(1).Find(&customer, a.CustomerID)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := closeHold(tx, a, StatusExpired, SystemActor, now); err != nil {
+			return err
+		}
+		if err := events.Record(tx, events.AggregateAccount, a.AccountID, events.AccountAuthorizationExpired, a); err != nil {
+			return err
+		}
+		if customer.Email == "" || !customer.EmailVerified {
+			log.Printf("authorizations: customer %d has no verified email for the expiry of authorization %d", a.CustomerID, a.ID)
+			return nil
+		}
+		merchant := a.Merchant
+		if merchant == "" {
+			merchant = "a " + a.Channel + " payment"
+		}
+		return notifications.Enqueue(tx, &models.Notification{
+			CustomerID:   a.CustomerID,
+			Channel:      "email",
+			ResourceType: communications.ResourceAuthorization,
+			ResourceID:   a.ID,
+			Recipient:    customer.Email,
+			Subject:      "A pending payment on your account has expired",
+			Body: fmt.Sprintf("The %.2f %s held on account %s for %s was not collected in time, so the hold has been "+
+				"released and the amount is available again.", a.Amount, account.Currency, account.AccountNumber, merchant),
+		})
+	})
+}
+
+// capturable reports why a hold cannot be captured at now, or nil when it can
+func capturable(a models.Authorization, now time.Time) error {
+	switch {
+	case a.Status == StatusExpired:
+		return ErrExpired
+	case !lifecycle.Allows(lifecycle.Authorization, a.Status, StatusCaptured):
+		return ErrNotPending
+	case !now.Before(a.ExpiresAt):
+		return ErrExpired
+	}
+	return nil
+}
+
+// closeHold moves a pending hold to status. The update only applies while the stored hold is still pending, and
+// for a capture still unexpired, and for an expiry expired; so a capture and the expiry job racing for the same
+// hold cannot both succeed
+func closeHold(tx *gorm.DB, a *models.Authorization, status, by string, now time.Time) error {
+	query := tx.Model(&models.Authorization{}).Where("id = ? AND status = ?", a.ID, StatusPending)
+	switch status {
+	case StatusCaptured:
+		query = query.Where("expires_at > ?", now)
+	case StatusExpired:
+		query = query.Where("expires_at <= ?", now)
+	}
+	result := query.Updates(map[string]interface{}{"status": status, "closed_at": now, "closed_by": by})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		var current models.Authorization
+		if err := tx.Select("id, status, expires_at").First(&current, a.ID).Error; err != nil {
+			return err
+		}
+		if status == StatusCaptured {
+			if err := capturable(current, now); err != nil {
+				return err
+			}
+		}
+		return ErrNotPending
+	}
+	a.Status, a.ClosedAt, a.ClosedBy = status, &now, by
+	return nil
+}
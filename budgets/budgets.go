@@ -0,0 +1,130 @@
+package budgets
+
+import (
+	"banking-app/businessdays"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/money"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PeriodLayout formats the bank month a budget is tracked for
+const PeriodLayout = "2006-01"
+
+// Thresholds are the percentages of a budget that alert the customer, lowest first
+var Thresholds = []int{80, 100}
+
+// Budget definition errors - handlers map these to client responses
+var (
+	ErrDefinition = errors.New("a budget needs a category code of at most 4 characters and a positive monthly_amount")
+	ErrExists     = errors.New("the customer already has a budget for this category")
+)
+
+// Status is a budget's month to date
+type Status struct {
+	Budget      models.Budget `json:"budget"`
+	Spent       float64       `json:"spent"`
+	Remaining   float64       `json:"remaining"`    // Negative once the budget is exceeded
+	PercentUsed float64       `json:"percent_used"` // Of the monthly amount
+	AlertsSent  []int         `json:"alerts_sent"`  // Thresholds reached this month
+}
+
+// Validate checks a budget's category and amount, normalizing the category code
+func Validate(b *models.Budget) error {
+	b.Category = strings.ToUpper(strings.TrimSpace(b.Category))
+	if b.Category == "" || len(b.Category) > 4 || !(b.MonthlyAmount > 0) {
+		return ErrDefinition
+	}
+	return nil
+}
+
+// Conflict reports whether the customer has another budget for the same category
+func Conflict(db *gorm.DB, b models.Budget) (bool, error) {
+	var count int64
+	err := db.Model(&models.Budget{}).Where("customer_id = ? AND category = ? AND id <> ?", b.CustomerID, b.Category, b.ID).Count(&count).Error
+	return count > 0, err
+}
+
+// Month returns the bank month now falls in as [start, end) and its period
+func Month(now time.Time) (time.Time, time.Time, string) {
+	start := businessdays.StartOfMonth(now)
+	return start, start.AddDate(0, 1, 0), start.Format(PeriodLayout)
+}
+
+// Spend sums a customer's spending per category in the bank month now falls in, by effective date
+// Spending is debits from the customer's accounts. Transfers to another of their own accounts move money rather
+// than spend it, and a reversed posting and its reversal cancel out, so none of these count
+func Spend(db *gorm.DB, customerID uint, now time.Time) (map[string]float64, error) {
+	start, end, _ := Month(now)
+	var rows []struct {
+		Category string
+		Spent    float64
+	}
+	err := db.Model(&models.Transaction{}).
+		Select("transactions.category_code AS category, SUM(transactions.amount) AS spent").
+		Joins("JOIN accounts ON accounts.id = transactions.account_id").
+		Where("accounts.customer_id = ? AND transactions.category_code <> '' AND NOT ("+ledger.CreditSQL+")", customerID).
+		Where("transactions.effective_date >= ? AND transactions.effective_date < ?", start, end).
+		Where("transactions.reversal_of_id IS NULL").
+		Where("NOT EXISTS (SELECT 1 FROM transactions reversals WHERE reversals.reversal_of_id = transactions.id AND reversals.deleted_at IS NULL)").
+		Where("transactions.counterparty_account_id IS NULL OR transactions.counterparty_account_id NOT IN (SELECT id FROM accounts own WHERE own.customer_id = ?)", customerID).
+		Group("transactions.category_code").Scan(&rows).Error
+	spend := make(map[string]float64, len(rows))
+	for _, r := range rows {
+		spend[strings.ToUpper(r.Category)] += money.Cents(r.Spent)
+	}
+	return spend, err
+}
+
+// Statuses returns each of a customer's budgets with its month-to-date spending and the alerts sent this month
+func Statuses(db *gorm.DB, customerID uint, now time.Time) ([]Status, error) {
+	var list []models.Budget
+	if err := db.Where("customer_id = ?", customerID).Order("category").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	spend, err := Spend(db, customerID, now)
+	if err != nil {
+		return nil, err
+	}
+	_, _, period := Month(now)
+
+	statuses := make([]Status, 0, len(list))
+	for _, b := range list {
+		var sent []models.BudgetAlert
+		if err := db.Where("budget_id = ? AND period = ?", b.ID, period).Order("threshold").Find(&sent).Error; err != nil {
+			return nil, err
+		}
+		status := Measure(b, spend[b.Category])
+		for _, alert := range sent {
+			status.AlertsSent = append(status.AlertsSent, alert.Threshold)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Measure sets a budget's spending against its monthly amount
+func Measure(b models.Budget, spent float64) Status {
+	return Status{
+		Budget:      b,
+		Spent:       money.Cents(spent),
+		Remaining:   money.Cents(b.MonthlyAmount - spent),
+		PercentUsed: money.Cents(spent / b.MonthlyAmount * 100),
+		AlertsSent:  []int{},
+	}
+}
+
+// Reached returns the thresholds a month's spending has reached
+func Reached(b models.Budget, spent float64) []int {
+	var reached []int
+	for _, threshold := range Thresholds {
+		if money.Cents(spent*100) >= money.Cents(b.MonthlyAmount*float64(threshold)) {
+			reached = append(reached, threshold)
+		}
+	}
+	return reached
+}
@@ -0,0 +1,394 @@
+package bulkops
+
+import (
+	"banking-app/cache"
+	"banking-app/clock"
+	"banking-app/conversions"
+	"banking-app/events"
+	"banking-app/flags"
+	"banking-app/gl"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"banking-app/reconcile"
+	"banking-app/statushistory"
+	"banking-app/tenancy"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Bulk actions
+const (
+	ActionFreeze         = "freeze"
+	ActionUnfreeze       = "unfreeze"
+	ActionSetLimit       = "set_limit"
+	ActionConvertProduct = "convert_product"
+
+	// ActionRecomputeBalances changes nothing: each account's stored balance is checked against its postings, a
+	// match succeeding and a drift failing with the amount
+	ActionRecomputeBalances = "recompute_balances"
+)
+
+// Actions lists every bulk action
+var Actions = []string{ActionFreeze, ActionUnfreeze, ActionSetLimit, ActionConvertProduct, ActionRecomputeBalances}
+
+// Operation statuses
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+)
+
+// Per-account results
+const (
+	ResultSucceeded = "succeeded"
+	ResultSkipped   = "skipped"
+	ResultFailed    = "failed"
+)
+
+// SampleSize is the number of accounts a dry run lists
+const SampleSize = 10
+
+// Validation errors - handlers map these to client responses
+var (
+	ErrInvalidAction = errors.New("action must be freeze, unfreeze, set_limit, convert_product or recompute_balances")
+	ErrEmptyFilter   = errors.New("filter needs at least one criterion")
+	ErrInvalidLimit  = errors.New("set_limit needs a non-negative overdraft_limit")
+	ErrInvalidTarget = errors.New("convert_product needs a target_type and the filter's account_type to convert from")
+)
+
+// Validate checks an operation's action and filter before it is queued or dry-run; a freeze or unfreeze also needs
+// a reason and a requester whose role may make the change
+func Validate(op models.BulkOperation) error {
+	switch op.Action {
+	case ActionFreeze:
+		if err := lifecycle.Authorize(lifecycle.Account, "active", "frozen", op.RequestedRole, op.Reason); err != nil {
+			return err
+		}
+	case ActionUnfreeze:
+		if err := lifecycle.Authorize(lifecycle.Account, "frozen", "active", op.RequestedRole, op.Reason); err != nil {
+			return err
+		}
+	case ActionSetLimit:
+		if op.OverdraftLimit == nil || *op.OverdraftLimit < 0 {
+			return ErrInvalidLimit
+		}
+	case ActionConvertProduct:
+		if op.TargetType == "" || op.Filter.AccountType == "" || op.TargetType == op.Filter.AccountType {
+			return ErrInvalidTarget
+		}
+	case ActionRecomputeBalances:
+	default:
+		return ErrInvalidAction
+	}
+	f := op.Filter
+	if f.CustomerIDs == "" && f.AccountIDs == "" && f.AccountType == "" && f.CreatedFrom == nil && f.CreatedTo == nil {
+		return ErrEmptyFilter
+	}
+	return nil
+}
+
+// JoinIDs renders IDs in the comma-separated form filters store
+func JoinIDs(ids []uint) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// splitIDs parses a comma-separated ID list
+func splitIDs(s string) []uint {
+	var ids []uint
+	for _, part := range strings.Split(s, ",") {
+		if id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 32); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
+
+// Matching restricts an accounts query to the filter; internal ledger accounts never match
+// db must already be scoped to the operation's tenant
+func Matching(db *gorm.DB, f models.BulkFilter) *gorm.DB {
+	q := db.Model(&models.Account{}).Where("account_type <> ?", gl.AccountType)
+	if f.CustomerIDs != "" {
+		q = q.Where("customer_id IN ?", splitIDs(f.CustomerIDs))
+	}
+	if f.AccountIDs != "" {
+		q = q.Where("id IN ?", splitIDs(f.AccountIDs))
+	}
+	if f.AccountType != "" {
+		q = q.Where("account_type = ?", f.AccountType)
+	}
+	if f.CreatedFrom != nil {
+		q = q.Where("created_at >= ?", *f.CreatedFrom)
+	}
+	if f.CreatedTo != nil {
+		q = q.Where("created_at < ?", *f.CreatedTo)
+	}
+	return q
+}
+
+// RunPending runs queued operations, and resumes any a restart interrupted, oldest first
+// The job runs without a tenant context, so each operation runs scoped to its own tenant
+func RunPending(db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store) {
+	var ops []models.BulkOperation
+	if err := db.Where("status IN ?", []string{StatusQueued, StatusRunning}).Order("id").Find(&ops).Error; err != nil {
+		log.Printf("bulkops: loading operations failed: %v", err)
+		return
+	}
+	for i := range ops {
+		scoped := db.WithContext(tenancy.NewContext(context.Background(), ops[i].TenantID))
+		if err := Run(scoped, balances, featureFlags, &ops[i], clock.Now()); err != nil {
+			log.Printf("bulkops: operation %d failed: %v", ops[i].ID, err)
+		}
+	}
+}
+
+// Run applies an operation to each matching account in its own transaction, so one failure does not stop the rest
+// Accounts that already have a result are skipped, which makes an interrupted run safe to resume.
+// db must be scoped to the operation's tenant
+func Run(db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store, op *models.BulkOperation, now time.Time) error {
+	var ids []uint
+	if err := Matching(db, op.Filter).Order("id").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if op.Status == StatusQueued {
+		op.Status = StatusRunning
+		op.StartedAt = &now
+		op.Total = len(ids)
+		if err := db.Model(op).Updates(map[string]interface{}{"status": op.Status, "started_at": now, "total": op.Total}).Error; err != nil {
+			return err
+		}
+	}
+
+	var done []uint
+	if err := db.Model(&models.BulkOperationItem{}).Where("bulk_operation_id = ?", op.ID).Pluck("account_id", &done).Error; err != nil {
+		return err
+	}
+	handled := make(map[uint]bool, len(done))
+	for _, id := range done {
+		handled[id] = true
+	}
+
+	for _, id := range ids {
+		if handled[id] {
+			continue
+		}
+		item := models.BulkOperationItem{TenantID: op.TenantID, BulkOperationID: op.ID, AccountID: id}
+		if op.Action == ActionRecomputeBalances {
+			item = recompute(db, item)
+			if err := db.Create(&item).Error; err != nil {
+				return err
+			}
+			if err := progress(db, op, item.Result); err != nil {
+				return err
+			}
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if item.Result, err = apply(tx, featureFlags, op, id, &item.PreviousStatus); err != nil {
+				return err
+			}
+			if item.Result == ResultSucceeded {
+				if err := audit(tx, op, id); err != nil {
+					return err
+				}
+			}
+			return tx.Create(&item).Error
+		})
+		if err != nil {
+			// The account's change rolled back; only the failure is recorded
+			item.Result = ResultFailed
+			item.Error = err.Error()
+			if err := db.Create(&item).Error; err != nil {
+				return err
+			}
+		}
+		if item.Result == ResultSucceeded && balances != nil {
+			balances.Invalidate(id)
+		}
+		if err := progress(db, op, item.Result); err != nil {
+			return err
+		}
+	}
+
+	completed := clock.Now()
+	op.Status = StatusCompleted
+	op.CompletedAt = &completed
+	return db.Model(op).Updates(map[string]interface{}{"status": op.Status, "completed_at": completed}).Error
+}
+
+// recompute checks one account's balance against its postings and returns its item
+// Nothing changes, so there is no transaction, audit entry or cache invalidation
+func recompute(db *gorm.DB, item models.BulkOperationItem) models.BulkOperationItem {
+	result, err := reconcile.RecomputeOne(db, item.AccountID)
+	switch {
+	case err != nil:
+		item.Result, item.Error = ResultFailed, err.Error()
+	case result.Match:
+		item.Result = ResultSucceeded
+	default:
+		item.Result, item.Drift = ResultFailed, &result.Drift
+		item.Error = fmt.Sprintf("balance %.2f drifted by %.2f from the ledger balance %.2f", result.Balance, result.Drift, result.LedgerBalance)
+	}
+	return item
+}
+
+// errSkip reports an account already in the requested state
+var errSkip = errors.New("skip")
+
+// apply performs the operation's action on one account and returns its result; previous receives the account's status
+func apply(tx *gorm.DB, featureFlags *flags.Store, op *models.BulkOperation, accountID uint, previous *string) (string, error) {
+	var account models.Account
+	if err := tx.First(&account, accountID).Error; err != nil {
+		return "", err
+	}
+	*previous = account.Status
+	if op.Action == ActionConvertProduct {
+		return convert(tx, featureFlags, op, account)
+	}
+	updates := map[string]interface{}{}
+	eventType := ""
+
+	err := func() error {
+		switch op.Action {
+		case ActionFreeze:
+			if account.Status == "frozen" {
+				return errSkip
+			}
+			if err := lifecycle.CheckReason(lifecycle.Account, account.Status, "frozen", op.Reason); err != nil {
+				return err
+			}
+			updates["status"], eventType = "frozen", events.AccountFrozen
+		case ActionUnfreeze:
+			if account.Status == "active" {
+				return errSkip
+			}
+			// Escheated accounts also return to active, but only through a reclaim
+			if account.Status != "frozen" {
+				return fmt.Errorf("only frozen accounts can be unfrozen, account is %s", account.Status)
+			}
+			if err := lifecycle.CheckReason(lifecycle.Account, account.Status, "active", op.Reason); err != nil {
+				return err
+			}
+			updates["status"], eventType = "active", events.AccountUnfrozen
+		case ActionSetLimit:
+			if account.OverdraftLimit == *op.OverdraftLimit {
+				return errSkip
+			}
+			if account.Status == "closed" {
+				return errors.New("closed accounts keep their limit")
+			}
+			updates["overdraft_limit"] = *op.OverdraftLimit
+		}
+		return nil
+	}()
+	if err == errSkip {
+		return ResultSkipped, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	updates["version"] = account.Version + 1
+	if err := tx.Model(&account).Updates(updates).Error; err != nil {
+		return "", err
+	}
+	if eventType != "" {
+		err := statushistory.Record(tx, models.StatusHistory{
+			TenantID:    account.TenantID,
+			SubjectType: statushistory.SubjectAccount,
+			SubjectID:   account.ID,
+			OldStatus:   *previous,
+			NewStatus:   updates["status"].(string),
+			Reason:      op.Reason,
+			ChangedBy:   op.RequestedBy,
+		})
+		if err != nil {
+			return "", err
+		}
+		err = events.Record(tx, events.AggregateAccount, account.ID, eventType, map[string]interface{}{
+			"account_id":        account.ID,
+			"account_number":    account.AccountNumber,
+			"previous_status":   *previous,
+			"reason":            op.Reason,
+			"bulk_operation_id": op.ID,
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return ResultSucceeded, nil
+}
+
+// convert moves one account to the operation's target product. Eligibility is not checked: the bank is moving its
+// customers off a product rather than them applying for another
+func convert(tx *gorm.DB, featureFlags *flags.Store, op *models.BulkOperation, account models.Account) (string, error) {
+	if account.AccountType == op.TargetType {
+		return ResultSkipped, nil
+	}
+	target, err := conversions.Target(tx, account, op.TargetType)
+	if err != nil {
+		return "", err
+	}
+	opID := op.ID
+	_, err = conversions.Convert(tx, featureFlags, account, target, conversions.Request{
+		NextCycle:       op.NextCycle,
+		Reason:          op.Reason,
+		RequestedBy:     op.RequestedBy,
+		BulkOperationID: &opID,
+	}, clock.Now())
+	if err != nil {
+		return "", err
+	}
+	return ResultSucceeded, nil
+}
+
+// audit records the change to one account in the audit log, attributed to the administrator who requested it
+func audit(tx *gorm.DB, op *models.BulkOperation, accountID uint) error {
+	opID, account := op.ID, accountID
+	var owner models.Account
+	if err := tx.Select("customer_id").First(&owner, accountID).Error; err != nil {
+		return err
+	}
+	return tx.Create(&models.AuditEntry{
+		TenantID:        op.TenantID,
+		Username:        op.RequestedBy,
+		Role:            op.RequestedRole,
+		Method:          http.MethodPost,
+		Path:            fmt.Sprintf("/api/v1/admin/bulk-operations/%d", op.ID),
+		Route:           "bulk-operation:" + op.Action,
+		Status:          http.StatusOK,
+		BulkOperationID: &opID,
+		CustomerID:      &owner.CustomerID,
+		AccountID:       &account,
+	}).Error
+}
+
+// progress counts one handled account on the operation
+func progress(db *gorm.DB, op *models.BulkOperation, result string) error {
+	op.Processed++
+	updates := map[string]interface{}{"processed": op.Processed}
+	switch result {
+	case ResultSucceeded:
+		op.Succeeded++
+		updates["succeeded"] = op.Succeeded
+	case ResultSkipped:
+		op.Skipped++
+		updates["skipped"] = op.Skipped
+	default:
+		op.Failed++
+		updates["failed"] = op.Failed
+	}
+	return db.Model(op).Updates(updates).Error
+}
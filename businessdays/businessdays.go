@@ -0,0 +1,114 @@
+package businessdays
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DateLayout is how processing dates are written in requests, responses and the holiday table
+const DateLayout = "2006-01-02"
+
+// Conventions for moving a date that falls on a weekend or holiday
+const (
+	Following = "following" // The next business day
+	Preceding = "preceding" // The previous business day
+)
+
+// ErrConvention is returned for an unknown business-day convention
+var ErrConvention = errors.New("convention must be following or preceding")
+
+// The bank's time zone; days, months and "today" begin at midnight here
+var (
+	mu       sync.RWMutex
+	location = time.UTC
+)
+
+// LocationFromEnv reads BANK_TIMEZONE, an IANA zone name such as America/New_York (default UTC)
+func LocationFromEnv() (*time.Location, error) {
+	name := strings.TrimSpace(os.Getenv("BANK_TIMEZONE"))
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BANK_TIMEZONE %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+// ConventionFromEnv reads BUSINESS_DAY_CONVENTION (default following)
+func ConventionFromEnv() (string, error) {
+	convention := strings.ToLower(strings.TrimSpace(os.Getenv("BUSINESS_DAY_CONVENTION")))
+	switch convention {
+	case "":
+		return Following, nil
+	case Following, Preceding:
+		return convention, nil
+	}
+	return "", fmt.Errorf("invalid BUSINESS_DAY_CONVENTION %q: %w", convention, ErrConvention)
+}
+
+// SetLocation sets the bank's time zone; it is meant to be called once at startup
+func SetLocation(loc *time.Location) {
+	mu.Lock()
+	location = loc
+	mu.Unlock()
+}
+
+// Location returns the bank's time zone
+func Location() *time.Location {
+	mu.RLock()
+	defer mu.RUnlock()
+	return location
+}
+
+// In returns t on the bank's wall clock
+func In(t time.Time) time.Time {
+	return t.In(Location())
+}
+
+// StartOfDay returns bank midnight of the day t falls on
+func StartOfDay(t time.Time) time.Time {
+	d := In(t)
+	return time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, d.Location())
+}
+
+// DayAfter returns bank midnight of the day following t
+// Days around a daylight saving change are 23 or 25 hours long, so this is not StartOfDay plus 24 hours
+func DayAfter(t time.Time) time.Time {
+	return StartOfDay(t).AddDate(0, 0, 1)
+}
+
+// StartOfMonth returns bank midnight of the first day of the month t falls on
+func StartOfMonth(t time.Time) time.Time {
+	d := In(t)
+	return Month(d.Year(), d.Month())
+}
+
+// Month returns bank midnight of the first day of a month
+func Month(year int, month time.Month) time.Time {
+	return time.Date(year, month, 1, 0, 0, 0, 0, Location())
+}
+
+// Days returns the number of calendar days from one date to another
+func Days(from, to time.Time) int {
+	a, b := In(from), In(to)
+	// Counted on UTC dates, so a 23 or 25 hour day still counts as one
+	start := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	end := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(end.Sub(start).Hours() / 24)
+}
+
+// ParseDate reads a YYYY-MM-DD processing date as bank midnight of that day
+func ParseDate(s string) (time.Time, error) {
+	return time.ParseInLocation(DateLayout, s, Location())
+}
+
+// Format writes the bank date t falls on as YYYY-MM-DD
+func Format(t time.Time) string {
+	return In(t).Format(DateLayout)
+}
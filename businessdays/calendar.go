@@ -0,0 +1,146 @@
+package businessdays
+
+import (
+	"banking-app/models"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Calendar knows which days the bank is open: weekdays that are not holidays
+type Calendar struct {
+	holidays map[string]string // Holiday names by YYYY-MM-DD
+}
+
+// NewCalendar returns a calendar closed on weekends and the given holidays
+func NewCalendar(holidays []models.Holiday) Calendar {
+	c := Calendar{holidays: map[string]string{}}
+	for _, h := range holidays {
+		c.holidays[h.Date] = h.Name
+	}
+	return c
+}
+
+// Load reads the holiday table into a calendar
+func Load(db *gorm.DB) (Calendar, error) {
+	var holidays []models.Holiday
+	if err := db.Find(&holidays).Error; err != nil {
+		return Calendar{}, err
+	}
+	return NewCalendar(holidays), nil
+}
+
+// Holiday returns the name of the holiday t falls on in bank time, if it is one
+func (c Calendar) Holiday(t time.Time) (string, bool) {
+	name, ok := c.holidays[Format(t)]
+	return name, ok
+}
+
+// IsBusinessDay reports whether the bank is open on the day t falls on in bank time
+func (c Calendar) IsBusinessDay(t time.Time) bool {
+	switch In(t).Weekday() {
+	case time.Saturday, time.Sunday:
+		return false
+	}
+	_, holiday := c.Holiday(t)
+	return !holiday
+}
+
+// Next returns the first business day after t, at the same bank time of day
+func (c Calendar) Next(t time.Time) time.Time {
+	return c.step(In(t), 1)
+}
+
+// Previous returns the last business day before t, at the same bank time of day
+func (c Calendar) Previous(t time.Time) time.Time {
+	return c.step(In(t), -1)
+}
+
+// step moves a day at a time until it lands on a business day
+// AddDate keeps the wall-clock time across daylight saving changes
+func (c Calendar) step(t time.Time, days int) time.Time {
+	for t = t.AddDate(0, 0, days); !c.IsBusinessDay(t); t = t.AddDate(0, 0, days) {
+	}
+	return t
+}
+
+// Adjust returns t if it falls on a business day, otherwise the business day the convention moves it to,
+// on the bank's wall clock
+func (c Calendar) Adjust(t time.Time, convention string) time.Time {
+	if c.IsBusinessDay(t) {
+		return In(t)
+	}
+	if convention == Preceding {
+		return c.Previous(t)
+	}
+	return c.Next(t)
+}
+
+// FederalHolidays returns the days the Federal Reserve is closed in a year
+// A holiday on a Sunday is observed on the Monday; one on a Saturday is not moved, as the Federal Reserve
+// stays open the Friday before
+func FederalHolidays(year int) []models.Holiday {
+	fixed := func(month time.Month, day int) time.Time {
+		d := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+		if d.Weekday() == time.Sunday {
+			d = d.AddDate(0, 0, 1)
+		}
+		return d
+	}
+	// nth returns the nth weekday of a month; n of -1 is the last
+	nth := func(month time.Month, weekday time.Weekday, n int) time.Time {
+		if n < 0 {
+			d := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+			return d.AddDate(0, 0, -((int(d.Weekday()) - int(weekday) + 7) % 7))
+		}
+		d := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+		return d.AddDate(0, 0, (int(weekday)-int(d.Weekday())+7)%7+7*(n-1))
+	}
+
+	days := []struct {
+		date time.Time
+		name string
+	}{
+		{fixed(time.January, 1), "New Year's Day"},
+		{nth(time.January, time.Monday, 3), "Birthday of Martin Luther King, Jr."},
+		{nth(time.February, time.Monday, 3), "Washington's Birthday"},
+		{nth(time.May, time.Monday, -1), "Memorial Day"},
+		{fixed(time.June, 19), "Juneteenth National Independence Day"},
+		{fixed(time.July, 4), "Independence Day"},
+		{nth(time.September, time.Monday, 1), "Labor Day"},
+		{nth(time.October, time.Monday, 2), "Columbus Day"},
+		{fixed(time.November, 11), "Veterans Day"},
+		{nth(time.November, time.Thursday, 4), "Thanksgiving Day"},
+		{fixed(time.December, 25), "Christmas Day"},
+	}
+	holidays := make([]models.Holiday, 0, len(days))
+	for _, d := range days {
+		if d.date.Weekday() == time.Saturday {
+			continue
+		}
+		holidays = append(holidays, models.Holiday{Date: d.date.Format(DateLayout), Name: d.name, Source: "federal"})
+	}
+	return holidays
+}
+
+// SeedFederal adds a year's federal holidays unless that year has already been seeded
+// Dates an admin has already entered are left as they are. Returns the number of holidays added
+func SeedFederal(db *gorm.DB, year int) (int, error) {
+	var seeded int64
+	err := db.Model(&models.Holiday{}).
+		Where("source = ? AND date LIKE ?", "federal", fmt.Sprintf("%04d-%%", year)).
+		Count(&seeded).Error
+	if err != nil || seeded > 0 {
+		return 0, err
+	}
+	added := 0
+	for _, h := range FederalHolidays(year) {
+		result := db.Where(models.Holiday{Date: h.Date}).FirstOrCreate(&h)
+		if result.Error != nil {
+			return added, result.Error
+		}
+		added += int(result.RowsAffected)
+	}
+	return added, nil
+}
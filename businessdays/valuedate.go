@@ -0,0 +1,68 @@
+package businessdays
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// CutoffLayout is how daily cutoffs are written: a 24-hour time on the bank's wall clock
+const CutoffLayout = "15:04"
+
+// ErrCutoff is returned for a cutoff that is not an HH:MM time
+var ErrCutoff = errors.New("a cutoff must be an HH:MM bank time such as 17:00")
+
+// ParseCutoff normalizes an HH:MM cutoff, so 9:30 and 09:30 compare alike
+func ParseCutoff(s string) (string, error) {
+	t, err := time.Parse(CutoffLayout, strings.TrimSpace(s))
+	if err != nil {
+		return "", ErrCutoff
+	}
+	return t.Format(CutoffLayout), nil
+}
+
+// NormalizeCutoffs parses every cutoff of a per-transaction-type map in place
+func NormalizeCutoffs(cutoffs map[string]string) error {
+	for transactionType, raw := range cutoffs {
+		cutoff, err := ParseCutoff(raw)
+		if err != nil {
+			return fmt.Errorf("cutoff for %s: %w", transactionType, err)
+		}
+		cutoffs[transactionType] = cutoff
+	}
+	return nil
+}
+
+// CutoffsFromEnv reads TRANSACTION_CUTOFFS, daily cutoffs by transaction type such as
+// "transfer=17:00,payment=16:30" (default none)
+func CutoffsFromEnv() (map[string]string, error) {
+	raw := strings.TrimSpace(os.Getenv("TRANSACTION_CUTOFFS"))
+	if raw == "" {
+		return nil, nil
+	}
+	cutoffs := map[string]string{}
+	for _, entry := range strings.Split(raw, ",") {
+		transactionType, cutoff, ok := strings.Cut(entry, "=")
+		transactionType = strings.TrimSpace(transactionType)
+		if !ok || transactionType == "" {
+			return nil, fmt.Errorf("invalid TRANSACTION_CUTOFFS entry %q, expected type=HH:MM", entry)
+		}
+		cutoffs[transactionType] = cutoff
+	}
+	if err := NormalizeCutoffs(cutoffs); err != nil {
+		return nil, fmt.Errorf("invalid TRANSACTION_CUTOFFS: %w", err)
+	}
+	return cutoffs, nil
+}
+
+// ValueDate returns bank midnight of the day a posting submitted at t is valued on
+// A submission on a business day before its cutoff is valued that day; one at or after the cutoff, or on a
+// weekend or holiday, is valued the next business day. An empty cutoff values the posting on the day of t
+func (c Calendar) ValueDate(t time.Time, cutoff string) time.Time {
+	if cutoff == "" || c.IsBusinessDay(t) && In(t).Format(CutoffLayout) < cutoff {
+		return StartOfDay(t)
+	}
+	return StartOfDay(c.Next(t))
+}
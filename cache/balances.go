@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"banking-app/metrics"
+	"banking-app/models"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+// BalanceEntry is the cached view served by the balance endpoint
+type BalanceEntry struct {
+	AccountID     uint    `json:"account_id"`
+	AccountNumber string  `json:"account_number"`
+	Balance       float64 `json:"balance"`
+	Currency      string  `json:"currency"`
+	Status        string  `json:"status"`
+	Version       uint64  `json:"-"` // Account version - an entry never replaces one of a higher version
+	TenantID      uint    `json:"-"` // Owning tenant - checked before a cached entry is served
+
+	ReadAt    time.Time `json:"-"` // When the account was read, or when a tombstone was left
+	Tombstone bool      `json:"-"` // Left by Invalidate; never served
+}
+
+// Entry is the cache entry for an account as just read or posted
+func Entry(account models.Account) BalanceEntry {
+	return BalanceEntry{
+		AccountID:     account.ID,
+		AccountNumber: account.AccountNumber,
+		Balance:       account.Balance,
+		Currency:      account.Currency,
+		Status:        account.Status,
+		Version:       account.Version,
+		TenantID:      account.TenantID,
+		ReadAt:        time.Now(),
+	}
+}
+
+// Default balance cache settings
+const (
+	DefaultBalanceTTL        = time.Minute
+	DefaultBalanceSize       = 100000
+	DefaultBalanceSampleRate = 0.01
+)
+
+// BalanceConfig sizes the balance cache and its consistency check
+type BalanceConfig struct {
+	TTL        time.Duration // Bounds staleness from writers that bypass the cache
+	Size       int           // Entries kept in process before the least recently used is evicted
+	SampleRate float64       // Share of cache hits also read from the database and compared, 0 to 1
+}
+
+// BalanceConfigFromEnv reads BALANCE_CACHE_SIZE and BALANCE_CACHE_SAMPLE_RATE, falling back to the defaults
+func BalanceConfigFromEnv() BalanceConfig {
+	cfg := BalanceConfig{TTL: DefaultBalanceTTL, Size: DefaultBalanceSize, SampleRate: DefaultBalanceSampleRate}
+	if raw := os.Getenv("BALANCE_CACHE_SIZE"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			cfg.Size = n
+		} else {
+			log.Printf("cache: ignoring invalid BALANCE_CACHE_SIZE %q", raw)
+		}
+	}
+	if raw := os.Getenv("BALANCE_CACHE_SAMPLE_RATE"); raw != "" {
+		if rate, err := strconv.ParseFloat(raw, 64); err == nil && rate >= 0 && rate <= 1 {
+			cfg.SampleRate = rate
+		} else {
+			log.Printf("cache: ignoring invalid BALANCE_CACHE_SAMPLE_RATE %q", raw)
+		}
+	}
+	return cfg
+}
+
+// Balances is a read-through cache of account balances keyed by account ID, over a Store
+// Postings write the new balance through synchronously once they commit; the TTL only bounds staleness from
+// writers that bypass the cache, and a sample of hits is checked against the database to find and repair drift
+type Balances struct {
+	store      Store
+	sampleRate float64
+
+	hits, misses, samples, drift *metrics.Counter
+}
+
+// NewBalances creates an empty in-process balance cache
+func NewBalances(cfg BalanceConfig) *Balances {
+	return NewBalancesWith(NewLRU(cfg.Size, cfg.TTL), cfg.SampleRate)
+}
+
+// NewBalancesWith creates a balance cache over any store, checking sampleRate of hits against the database
+func NewBalancesWith(store Store, sampleRate float64) *Balances {
+	b := &Balances{
+		store:      store,
+		sampleRate: sampleRate,
+		hits:       metrics.NewCounter(`balance_cache_requests_total{result="hit"}`, "Balance lookups served from the cache or read from the database"),
+		misses:     metrics.NewCounter(`balance_cache_requests_total{result="miss"}`, "Balance lookups served from the cache or read from the database"),
+		samples:    metrics.NewCounter("balance_cache_samples_total", "Cache hits also read from the database to check consistency"),
+		drift:      metrics.NewCounter("balance_cache_drift_total", "Sampled cache entries that differed from the database and were repaired"),
+	}
+	metrics.RegisterGauge("balance_cache_hit_ratio", "Share of balance lookups served from the cache", func() float64 {
+		hits, misses := b.hits.Value(), b.misses.Value()
+		if hits+misses == 0 {
+			return 0
+		}
+		return float64(hits) / float64(hits+misses)
+	})
+	metrics.RegisterGauge("balance_cache_entries", "Accounts held in the balance cache, tombstones included", func() float64 {
+		return float64(b.store.Len())
+	})
+	return b
+}
+
+// Get returns a fresh cached entry for the account, if any
+func (b *Balances) Get(accountID uint) (BalanceEntry, bool) {
+	entry, ok := b.store.Get(accountID)
+	if !ok || entry.Tombstone {
+		return BalanceEntry{}, false
+	}
+	return entry, true
+}
+
+// Load serves an account's entry from the cache, calling load to read it from the database on a miss
+// A cached entry of another tenant is treated as a miss, so the caller's scoped read decides what the client sees.
+// A sample of hits is read anyway: an entry that differs from the database is logged, counted and replaced
+func (b *Balances) Load(accountID, tenantID uint, load func() (BalanceEntry, error)) (BalanceEntry, error) {
+	cached, ok := b.Get(accountID)
+	if ok && cached.TenantID == tenantID {
+		b.hits.Inc()
+		if b.sampleRate <= 0 || rand.Float64() >= b.sampleRate {
+			return cached, nil
+		}
+		b.samples.Inc()
+		readAt := time.Now()
+		current, err := load()
+		if err != nil {
+			return cached, nil
+		}
+		current.ReadAt = readAt
+		b.check(cached, current)
+		return current, nil
+	}
+
+	b.misses.Inc()
+	readAt := time.Now()
+	entry, err := load()
+	if err != nil {
+		return entry, err
+	}
+	// A posting that committed and invalidated the account while it was being read leaves a newer tombstone
+	entry.ReadAt = readAt
+	b.store.Put(entry)
+	return entry, nil
+}
+
+// check compares a cached entry with the database and replaces it when they differ, e.g. after a write that
+// bypassed the cache. A posting committed since the sample was read keeps its newer version, unless the database
+// is behind the cache, as after a restore
+func (b *Balances) check(cached, current BalanceEntry) {
+	if cached.Version == current.Version && cached.Balance == current.Balance && cached.Status == current.Status {
+		return
+	}
+	log.Printf("cache: balance of account %d drifted: cached %.2f %s at version %d, database %.2f %s at version %d",
+		cached.AccountID, cached.Balance, cached.Status, cached.Version, current.Balance, current.Status, current.Version)
+	b.drift.Inc()
+	if current.Version < cached.Version {
+		b.store.Replace(current)
+		return
+	}
+	b.store.Put(current)
+}
+
+// Set writes an account's entry through, unless a newer version is already cached
+func (b *Balances) Set(entry BalanceEntry) {
+	if entry.ReadAt.IsZero() {
+		entry.ReadAt = time.Now()
+	}
+	b.store.Put(entry)
+}
+
+// Invalidate drops the cached entry for an account, for writers that do not have the account as posted
+// It leaves a tombstone, so a read that started before it cannot cache the balance from before
+func (b *Balances) Invalidate(accountID uint) {
+	b.store.Delete(accountID, time.Now())
+}
+
+// Clear drops every cached entry
+func (b *Balances) Clear() {
+	b.store.Clear()
+}
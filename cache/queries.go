@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// Queries is an in-process cache of computed query results keyed by the query
+// Nothing invalidates entries early, so it suits results where the TTL is an acceptable staleness bound
+type Queries struct {
+	mu      sync.RWMutex
+	entries map[string]queryEntry
+	ttl     time.Duration
+	max     int
+}
+
+type queryEntry struct {
+	value    interface{}
+	cachedAt time.Time
+}
+
+// NewQueries creates an empty query cache holding at most max entries for ttl each
+func NewQueries(ttl time.Duration, max int) *Queries {
+	return &Queries{entries: make(map[string]queryEntry), ttl: ttl, max: max}
+}
+
+// Get returns a fresh cached result for the key, if any
+func (q *Queries) Get(key string) (interface{}, bool) {
+	q.mu.RLock()
+	entry, ok := q.entries[key]
+	q.mu.RUnlock()
+
+	if !ok || time.Since(entry.cachedAt) > q.ttl {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Set stores a result, first dropping expired entries when the cache is full
+func (q *Queries) Set(key string, value interface{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.entries) >= q.max {
+		for k, entry := range q.entries {
+			if time.Since(entry.cachedAt) > q.ttl {
+				delete(q.entries, k)
+			}
+		}
+		// Still full of live entries - start over rather than track recency
+		if len(q.entries) >= q.max {
+			q.entries = make(map[string]queryEntry)
+		}
+	}
+	q.entries[key] = queryEntry{value: value, cachedAt: time.Now()}
+}
+
+// Clear drops every cached result
+func (q *Queries) Clear() {
+	q.mu.Lock()
+	q.entries = make(map[string]queryEntry)
+	q.mu.Unlock()
+}
@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Store holds balance entries for Balances. LRU keeps them in process; a store shared between servers, such as
+// Redis, can take its place as long as Put compares and stores in one atomic step (e.g. a Lua script)
+type Store interface {
+	// Get returns the entry stored for an account, which may be a tombstone left by Delete
+	Get(accountID uint) (BalanceEntry, bool)
+	// Put stores an entry unless the one stored for the account is newer: a higher version, or a tombstone
+	// written after the entry was read. It reports whether the entry was stored
+	Put(entry BalanceEntry) bool
+	// Replace stores an entry whatever is stored for the account
+	Replace(entry BalanceEntry)
+	// Delete leaves a tombstone for an account, so a read that started before it cannot be stored after it
+	Delete(accountID uint, at time.Time)
+	// Clear drops every entry and tombstone
+	Clear()
+	// Len returns how many entries and tombstones are stored
+	Len() int
+}
+
+// LRU is an in-process Store holding at most max entries, evicting the least recently used
+// Entries older than the TTL are dropped when read
+type LRU struct {
+	mu      sync.Mutex
+	entries map[uint]*list.Element
+	order   *list.List // Most recently used first
+	max     int
+	ttl     time.Duration
+}
+
+// NewLRU creates an empty in-process store of at most max entries kept for ttl each
+func NewLRU(max int, ttl time.Duration) *LRU {
+	return &LRU{entries: make(map[uint]*list.Element), order: list.New(), max: max, ttl: ttl}
+}
+
+// Get returns the entry stored for an account, if any and not expired
+func (l *LRU) Get(accountID uint) (BalanceEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.entries[accountID]
+	if !ok {
+		return BalanceEntry{}, false
+	}
+	entry := el.Value.(BalanceEntry)
+	if time.Since(entry.ReadAt) > l.ttl {
+		l.remove(el)
+		return BalanceEntry{}, false
+	}
+	l.order.MoveToFront(el)
+	return entry, true
+}
+
+// Put stores an entry unless the stored one has a higher version or is a tombstone newer than the entry's read
+func (l *LRU) Put(entry BalanceEntry) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.entries[entry.AccountID]; ok {
+		current := el.Value.(BalanceEntry)
+		if current.Version > entry.Version || (current.Tombstone && current.ReadAt.After(entry.ReadAt)) {
+			return false
+		}
+	}
+	l.store(entry)
+	return true
+}
+
+// Replace stores an entry whatever is stored for the account
+func (l *LRU) Replace(entry BalanceEntry) {
+	l.mu.Lock()
+	l.store(entry)
+	l.mu.Unlock()
+}
+
+// Delete replaces an account's entry with a tombstone dated at; the tombstone keeps the version it replaced so
+// older versions are still refused
+func (l *LRU) Delete(accountID uint, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tombstone := BalanceEntry{AccountID: accountID, Tombstone: true, ReadAt: at}
+	if el, ok := l.entries[accountID]; ok {
+		tombstone.Version = el.Value.(BalanceEntry).Version
+	}
+	l.store(tombstone)
+}
+
+// Clear drops every entry and tombstone
+func (l *LRU) Clear() {
+	l.mu.Lock()
+	l.entries = make(map[uint]*list.Element)
+	l.order.Init()
+	l.mu.Unlock()
+}
+
+// Len returns how many entries and tombstones are stored
+func (l *LRU) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+// store writes an entry as the most recently used, evicting the least recently used past max; the caller holds mu
+func (l *LRU) store(entry BalanceEntry) {
+	if el, ok := l.entries[entry.AccountID]; ok {
+		el.Value = entry
+		l.order.MoveToFront(el)
+		return
+	}
+	l.entries[entry.AccountID] = l.order.PushFront(entry)
+	for l.order.Len() > l.max {
+		l.remove(l.order.Back())
+	}
+}
+
+// remove drops an element; the caller holds mu
+func (l *LRU) remove(el *list.Element) {
+	l.order.Remove(el)
+	delete(l.entries, el.Value.(BalanceEntry).AccountID)
+}
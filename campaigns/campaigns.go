@@ -0,0 +1,647 @@
+package campaigns
+
+import (
+	"banking-app/communications"
+	"banking-app/models"
+	"banking-app/notifications"
+	"banking-app/tags"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// Campaign statuses
+const (
+	StatusScheduled = "scheduled"
+	StatusRunning   = "running"
+	StatusPaused    = "paused"
+	StatusCompleted = "completed"
+)
+
+// Recipient statuses
+const (
+	RecipientPending     = "pending"
+	RecipientQueued      = "queued"
+	RecipientSent        = "sent"
+	RecipientFailed      = "failed"
+	RecipientSuppressed  = "suppressed"
+	RecipientUnreachable = "unreachable"
+)
+
+// CategoryAnnouncements is the preference category campaigns fall under
+const CategoryAnnouncements = "announcements"
+
+// Categories lists the optional message categories customers can opt out of
+var Categories = []string{CategoryAnnouncements}
+
+// Where an opt-out came from
+const (
+	SourcePreferences = "preferences"
+	SourceUnsubscribe = "unsubscribe"
+)
+
+// OverrideRoute marks the audit entries of customers a regulatory campaign messaged despite their opt-out
+const OverrideRoute = "campaign:regulatory_override"
+
+// Variables are the per-customer values a subject or template may use
+var Variables = []string{"first_name", "last_name", "full_name", "email", "bank_name"}
+
+// placeholder matches one {variable} in a template
+var placeholder = regexp.MustCompile(`\{([a-z_]*)\}`)
+
+// Validation errors - handlers map these to client responses
+var (
+	ErrName          = errors.New("name must be 1 to 200 characters")
+	ErrSubject       = errors.New("subject must be 1 to 255 characters with balanced braces")
+	ErrTemplate      = errors.New("template must be 1 to 5000 characters with balanced braces")
+	ErrVariable      = errors.New("unknown template variable")
+	ErrBatch         = errors.New("batch_size and batch_interval_seconds must be positive")
+	ErrTag           = errors.New("tag is not a valid tag")
+	ErrUnknownTenant = errors.New("unknown tenant code")
+	ErrTenantScope   = errors.New("only the platform tenant can address other tenants' customers")
+	ErrStatusChanged = errors.New("campaign status changed; reload it and try again")
+	ErrCategory      = errors.New("unknown notification category")
+)
+
+// Config holds the default rate limit and the address unsubscribe links point at
+type Config struct {
+	BatchSize     int           // Customers queued per batch unless a campaign sets its own
+	BatchInterval time.Duration // Time between batches unless a campaign sets its own
+	BaseURL       string        // Public URL of the API
+}
+
+// ConfigFromEnv reads CAMPAIGN_BATCH_SIZE (default 100), CAMPAIGN_BATCH_INTERVAL_SECONDS (default 60) and
+// PUBLIC_BASE_URL (default "http://localhost:8080")
+func ConfigFromEnv() Config {
+	size, err := strconv.Atoi(os.Getenv("CAMPAIGN_BATCH_SIZE"))
+	if err != nil || size <= 0 {
+		size = 100
+	}
+	seconds, err := strconv.Atoi(os.Getenv("CAMPAIGN_BATCH_INTERVAL_SECONDS"))
+	if err != nil || seconds <= 0 {
+		seconds = 60
+	}
+	base := strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	return Config{BatchSize: size, BatchInterval: time.Duration(seconds) * time.Second, BaseURL: base}
+}
+
+// Validate checks a campaign's name, templates and rate limit before it is created or dry-run
+func Validate(c models.Campaign) error {
+	name := strings.TrimSpace(c.Name)
+	if name == "" || utf8.RuneCountInString(name) > 200 {
+		return ErrName
+	}
+	if err := validateTemplate(c.Subject, 255, ErrSubject); err != nil {
+		return err
+	}
+	if err := validateTemplate(c.Template, 5000, ErrTemplate); err != nil {
+		return err
+	}
+	if c.BatchSize <= 0 || c.BatchIntervalSeconds <= 0 {
+		return ErrBatch
+	}
+	if c.Audience.Tag != "" && !tags.ValidSlug(c.Audience.Tag) {
+		return ErrTag
+	}
+	return nil
+}
+
+// validateTemplate checks a template's length and braces, and that it only uses known variables
+func validateTemplate(template string, max int, errShape error) error {
+	if strings.TrimSpace(template) == "" || utf8.RuneCountInString(template) > max {
+		return errShape
+	}
+	if strings.ContainsAny(placeholder.ReplaceAllString(template, ""), "{}") {
+		return errShape
+	}
+	for _, match := range placeholder.FindAllStringSubmatch(template, -1) {
+		known := false
+		for _, variable := range Variables {
+			known = known || variable == match[1]
+		}
+		if !known {
+			return fmt.Errorf("%w {%s}; templates can use {%s}", ErrVariable, match[1], strings.Join(Variables, "}, {"))
+		}
+	}
+	return nil
+}
+
+// Render fills a template's variables
+func Render(template string, values map[string]string) string {
+	return placeholder.ReplaceAllStringFunc(template, func(match string) string {
+		return values[match[1:len(match)-1]]
+	})
+}
+
+// Values are a customer's template variables; bankName is the name of the customer's tenant
+func Values(customer models.Customer, bankName string) map[string]string {
+	return map[string]string{
+		"first_name": customer.FirstName,
+		"last_name":  customer.LastName,
+		"full_name":  strings.TrimSpace(customer.FirstName + " " + customer.LastName),
+		"email":      customer.Email,
+		"bank_name":  bankName,
+	}
+}
+
+// Tenants resolves an audience's tenant codes to the comma-separated IDs it stores; no codes means the campaign's
+// own tenant. Only the platform tenant may name others
+func Tenants(db *gorm.DB, current, platform uint, codes []string) (string, error) {
+	if len(codes) == 0 {
+		return strconv.FormatUint(uint64(current), 10), nil
+	}
+	ids := make([]string, 0, len(codes))
+	for _, code := range codes {
+		var tenant models.Tenant
+		if err := db.Select("id").Where("code = ?", code).First(&tenant).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return "", fmt.Errorf("%w %q", ErrUnknownTenant, code)
+			}
+			return "", err
+		}
+		if tenant.ID != current && current != platform {
+			return "", ErrTenantScope
+		}
+		ids = append(ids, strconv.FormatUint(uint64(tenant.ID), 10))
+	}
+	return strings.Join(ids, ","), nil
+}
+
+// splitIDs parses a comma-separated ID list
+func splitIDs(s string) []uint {
+	var ids []uint
+	for _, part := range strings.Split(s, ",") {
+		if id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 32); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
+
+// Audience restricts a customers query to an audience
+// The audience names its tenants, so db must not be scoped to the request tenant
+func Audience(db *gorm.DB, a models.CampaignAudience) *gorm.DB {
+	q := db.Model(&models.Customer{}).Where("customers.tenant_id IN ?", splitIDs(a.TenantIDs))
+	if a.AccountType != "" {
+		q = q.Where("customers.id IN (?)", db.Model(&models.Account{}).Select("customer_id").
+			Where("account_type = ? AND status <> ?", a.AccountType, "closed"))
+	}
+	if a.Tag != "" {
+		q = q.Where("customers.id IN (?)", tags.Tagged(db, tags.SubjectCustomer, a.Tag))
+	}
+	if a.CustomerStatus != "" {
+		q = q.Where("customers.status = ?", a.CustomerStatus)
+	}
+	return q
+}
+
+// optedOut selects the customers who opted out of a category
+func optedOut(db *gorm.DB, category string) *gorm.DB {
+	return db.Model(&models.NotificationPreference{}).Select("customer_id").
+		Where("category = ? AND opted_out = ?", category, true)
+}
+
+// Rendering is the message one customer would receive
+type Rendering struct {
+	CustomerID uint   `json:"customer_id"`
+	Recipient  string `json:"recipient"`
+	Subject    string `json:"subject"`
+	Body       string `json:"body"`
+}
+
+// Preview is a dry run's audience: how many customers match, how many would be skipped, and the first one's message
+type Preview struct {
+	Audience    int64      `json:"audience"`
+	OptedOut    int64      `json:"opted_out"`   // Suppressed, or for a regulatory campaign sent regardless
+	Unreachable int64      `json:"unreachable"` // No verified email address
+	Sample      *Rendering `json:"sample"`      // The first customer's message; nil for an empty audience
+}
+
+// DryRun counts a campaign's audience and renders its message for the first customer in it
+// Like Audience, db must not be scoped to the request tenant
+func DryRun(db *gorm.DB, cfg Config, c models.Campaign) (Preview, error) {
+	var preview Preview
+	if err := Audience(db, c.Audience).Count(&preview.Audience).Error; err != nil {
+		return preview, err
+	}
+	err := Audience(db, c.Audience).Where("customers.id IN (?)", optedOut(db, CategoryAnnouncements)).Count(&preview.OptedOut).Error
+	if err != nil {
+		return preview, err
+	}
+	err = Audience(db, c.Audience).Where("customers.email = '' OR customers.email IS NULL OR customers.email_verified = ?", false).
+		Count(&preview.Unreachable).Error
+	if err != nil {
+		return preview, err
+	}
+
+	var first models.Customer
+	if err := Audience(db, c.Audience).Order("customers.id").Limit(1).Find(&first).Error; err != nil || first.ID == 0 {
+		return preview, err
+	}
+	var tenant models.Tenant
+	db.Select("name").Limit(1).Find(&tenant, first.TenantID)
+	subject, body := message(cfg, c, first, tenant.Name, "TOKEN")
+	preview.Sample = &Rendering{CustomerID: first.ID, Recipient: first.Email, Subject: subject, Body: body}
+	return preview, nil
+}
+
+// message renders a campaign for one customer. Optional campaigns end with an unsubscribe link carrying the token;
+// regulatory ones have none, as the customer cannot opt out of them
+func message(cfg Config, c models.Campaign, customer models.Customer, bankName, token string) (string, string) {
+	values := Values(customer, bankName)
+	body := Render(c.Template, values)
+	if !c.Regulatory {
+		body += fmt.Sprintf("\n\nTo stop receiving announcements: %s/api/v1/unsubscribe/%s", cfg.BaseURL, token)
+	}
+	return Render(c.Subject, values), body
+}
+
+// RunDue starts campaigns whose time has come and moves running ones along, oldest first
+// The job runs without a tenant context; an audience names its own tenants
+func RunDue(db *gorm.DB, cfg Config, now time.Time) {
+	var due []models.Campaign
+	err := db.Where("(status = ? AND scheduled_at <= ?) OR status IN ?", StatusScheduled, now, []string{StatusRunning, StatusPaused}).
+		Order("id").Find(&due).Error
+	if err != nil {
+		log.Printf("campaigns: loading campaigns failed: %v", err)
+		return
+	}
+	for i := range due {
+		if err := Run(db, cfg, &due[i], now); err != nil {
+			log.Printf("campaigns: campaign %d failed: %v", due[i].ID, err)
+		}
+	}
+}
+
+// Run takes a scheduled campaign's audience, settles delivered messages, queues the next batch when one is due and
+// refreshes the counts. A running campaign with nothing pending or awaiting delivery is completed
+func Run(db *gorm.DB, cfg Config, c *models.Campaign, now time.Time) error {
+	if c.Status == StatusScheduled {
+		if err := start(db, c, now); err != nil {
+			return err
+		}
+	}
+	if err := settle(db, c); err != nil {
+		return err
+	}
+	if c.Status == StatusRunning {
+		if err := batch(db, cfg, c, now); err != nil {
+			return err
+		}
+	}
+	return tally(db, c, now)
+}
+
+// start records the customers in the audience as pending recipients and sets the campaign running
+// A campaign paused before it started stays paused
+func start(db *gorm.DB, c *models.Campaign, now time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(c).Where("status = ?", StatusScheduled).Updates(map[string]interface{}{"status": StatusRunning, "started_at": now})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		var ids []uint
+		if err := Audience(tx, c.Audience).Order("customers.id").Pluck("customers.id", &ids).Error; err != nil {
+			return err
+		}
+		recipients := make([]models.CampaignRecipient, len(ids))
+		for i, id := range ids {
+			recipients[i] = models.CampaignRecipient{TenantID: c.TenantID, CampaignID: c.ID, CustomerID: id, Status: RecipientPending}
+		}
+		if len(recipients) > 0 {
+			if err := tx.CreateInBatches(recipients, 500).Error; err != nil {
+				return err
+			}
+		}
+		c.Status, c.StartedAt = StatusRunning, &now
+		log.Printf("campaigns: campaign %d started for %d customers", c.ID, len(ids))
+		return nil
+	})
+}
+
+// settle copies the outcome of each delivered or finally failed message to its recipient
+func settle(db *gorm.DB, c *models.Campaign) error {
+	var outcomes []struct {
+		ID        uint
+		Status    string
+		LastError string
+	}
+	err := db.Model(&models.CampaignRecipient{}).
+		Select("campaign_recipients.id, notifications.status, notifications.last_error").
+		Joins("JOIN notifications ON notifications.id = campaign_recipients.notification_id").
+		Where("campaign_recipients.campaign_id = ? AND campaign_recipients.status = ? AND notifications.status IN ?",
+			c.ID, RecipientQueued, []string{RecipientSent, RecipientFailed}).
+		Scan(&outcomes).Error
+	if err != nil {
+		return err
+	}
+	for _, o := range outcomes {
+		err := db.Model(&models.CampaignRecipient{}).Where("id = ? AND status = ?", o.ID, RecipientQueued).
+			Updates(map[string]interface{}{"status": o.Status, "error": o.LastError}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batch queues the next batch of pending recipients once the campaign's interval has passed since the last one
+// The batch is claimed only while the campaign is still running, so a pause stops it before anything is queued
+func batch(db *gorm.DB, cfg Config, c *models.Campaign, now time.Time) error {
+	interval := time.Duration(c.BatchIntervalSeconds) * time.Second
+	if c.LastBatchAt != nil && now.Before(c.LastBatchAt.Add(interval)) {
+		return nil
+	}
+	var recipients []models.CampaignRecipient
+	err := db.Where("campaign_id = ? AND status = ?", c.ID, RecipientPending).Order("id").Limit(c.BatchSize).Find(&recipients).Error
+	if err != nil || len(recipients) == 0 {
+		return err
+	}
+	result := db.Model(c).Where("status = ?", StatusRunning).Update("last_batch_at", now)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	c.LastBatchAt = &now
+
+	var tenants []models.Tenant
+	if err := db.Select("id, name").Find(&tenants).Error; err != nil {
+		return err
+	}
+	bankNames := make(map[uint]string, len(tenants))
+	for _, tenant := range tenants {
+		bankNames[tenant.ID] = tenant.Name
+	}
+	for i := range recipients {
+		if err := send(db, cfg, c, &recipients[i], bankNames, now); err != nil {
+			// Nothing was queued for the customer; the failure is theirs alone
+			log.Printf("campaigns: campaign %d could not queue customer %d: %v", c.ID, recipients[i].CustomerID, err)
+			err = db.Model(&recipients[i]).Updates(map[string]interface{}{"status": RecipientFailed, "error": truncate(err.Error())}).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// send queues one recipient's message, or skips a customer without a verified address or, unless the campaign is
+// regulatory, one who opted out. A regulatory message to a customer who opted out is recorded in the audit log
+func send(db *gorm.DB, cfg Config, c *models.Campaign, r *models.CampaignRecipient, bankNames map[uint]string, now time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var customer models.Customer
+		err := tx.Select("id, tenant_id, first_name, last_name, email, email_verified").Limit(1).Find(&customer, r.CustomerID).Error
+		if err != nil {
+			return err
+		}
+		if customer.ID == 0 || customer.Email == "" || !customer.EmailVerified {
+			return tx.Model(r).Update("status", RecipientUnreachable).Error
+		}
+		opted, err := OptedOut(tx, customer.ID, CategoryAnnouncements)
+		if err != nil {
+			return err
+		}
+		if opted && !c.Regulatory {
+			return tx.Model(r).Update("status", RecipientSuppressed).Error
+		}
+
+		token, tokenHash := "", ""
+		if !c.Regulatory {
+			if token, err = newToken(); err != nil {
+				return err
+			}
+			tokenHash = HashToken(token)
+		}
+		subject, body := message(cfg, *c, customer, bankNames[customer.TenantID], token)
+		n := models.Notification{
+			CustomerID:   customer.ID,
+			Channel:      "email",
+			ResourceType: communications.ResourceCampaign,
+			ResourceID:   c.ID,
+			Recipient:    customer.Email,
+			Subject:      subject,
+			Body:         body,
+		}
+		if err := notifications.Enqueue(tx, &n); err != nil {
+			return err
+		}
+		if opted {
+			if err := audit(tx, c, customer); err != nil {
+				return err
+			}
+		}
+		return tx.Model(r).Updates(map[string]interface{}{
+			"status":                 RecipientQueued,
+			"notification_id":        n.ID,
+			"queued_at":              now,
+			"overrode_preference":    opted,
+			"unsubscribe_token_hash": tokenHash,
+		}).Error
+	})
+}
+
+// audit records that a regulatory campaign messaged a customer who opted out, attributed to its creator and filed
+// under the customer's tenant so it shows in their access report
+func audit(tx *gorm.DB, c *models.Campaign, customer models.Customer) error {
+	campaignID, customerID := c.ID, customer.ID
+	return tx.Create(&models.AuditEntry{
+		TenantID:   customer.TenantID,
+		Username:   c.CreatedBy,
+		Role:       c.CreatedRole,
+		Method:     http.MethodPost,
+		Path:       fmt.Sprintf("/api/v1/admin/campaigns/%d", c.ID),
+		Route:      OverrideRoute,
+		Status:     http.StatusOK,
+		CampaignID: &campaignID,
+		CustomerID: &customerID,
+	}).Error
+}
+
+// tally refreshes a campaign's counts from its recipients and completes a running campaign that has nothing left
+// to queue or deliver
+func tally(db *gorm.DB, c *models.Campaign, now time.Time) error {
+	counts, err := Counts(db, c.ID)
+	if err != nil {
+		return err
+	}
+	var overrides int64
+	if err := db.Model(&models.CampaignRecipient{}).Where("campaign_id = ? AND overrode_preference = ?", c.ID, true).Count(&overrides).Error; err != nil {
+		return err
+	}
+	c.Pending, c.Queued, c.Sent, c.Failed = counts[RecipientPending], counts[RecipientQueued], counts[RecipientSent], counts[RecipientFailed]
+	c.Suppressed, c.Unreachable, c.Overrides = counts[RecipientSuppressed], counts[RecipientUnreachable], int(overrides)
+	c.Total = 0
+	for _, n := range counts {
+		c.Total += n
+	}
+	err = db.Model(c).Updates(map[string]interface{}{
+		"total": c.Total, "pending": c.Pending, "queued": c.Queued, "sent": c.Sent, "failed": c.Failed,
+		"suppressed": c.Suppressed, "unreachable": c.Unreachable, "overrides": c.Overrides,
+	}).Error
+	if err != nil || c.Status != StatusRunning || c.Pending > 0 || c.Queued > 0 {
+		return err
+	}
+
+	result := db.Model(c).Where("status = ?", StatusRunning).Updates(map[string]interface{}{"status": StatusCompleted, "completed_at": now})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	c.Status, c.CompletedAt = StatusCompleted, &now
+	log.Printf("campaigns: campaign %d completed: %d sent, %d failed, %d suppressed, %d unreachable, %d overrides",
+		c.ID, c.Sent, c.Failed, c.Suppressed, c.Unreachable, c.Overrides)
+	return nil
+}
+
+// Counts returns how many of a campaign's recipients are in each status
+func Counts(db *gorm.DB, campaignID uint) (map[string]int, error) {
+	var rows []struct {
+		Status string
+		Count  int
+	}
+	err := db.Model(&models.CampaignRecipient{}).Select("status, COUNT(*) AS count").
+		Where("campaign_id = ?", campaignID).Group("status").Scan(&rows).Error
+	counts := map[string]int{}
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, err
+}
+
+// Pause stops a scheduled or running campaign from queuing further batches; messages already queued are delivered.
+// Pausing a paused campaign changes nothing
+func Pause(db *gorm.DB, c *models.Campaign, by string, now time.Time) error {
+	if c.Status == StatusPaused {
+		return nil
+	}
+	return change(db, c, map[string]interface{}{"status": StatusPaused, "paused_at": now, "paused_by": by})
+}
+
+// Resume returns a paused campaign to running, or to scheduled if it was paused before it started
+func Resume(db *gorm.DB, c *models.Campaign) error {
+	if c.Status == ResumeTo(*c) {
+		return nil
+	}
+	return change(db, c, map[string]interface{}{"status": ResumeTo(*c)})
+}
+
+// ResumeTo is the status a paused campaign resumes in
+func ResumeTo(c models.Campaign) string {
+	if c.StartedAt == nil {
+		return StatusScheduled
+	}
+	return StatusRunning
+}
+
+// change updates a campaign only if its status is still the one it was loaded with
+func change(db *gorm.DB, c *models.Campaign, updates map[string]interface{}) error {
+	result := db.Model(c).Where("status = ?", c.Status).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrStatusChanged
+	}
+	return db.First(c, c.ID).Error
+}
+
+// OptedOut reports whether a customer opted out of a message category
+func OptedOut(db *gorm.DB, customerID uint, category string) (bool, error) {
+	var count int64
+	err := db.Model(&models.NotificationPreference{}).
+		Where("customer_id = ? AND category = ? AND opted_out = ?", customerID, category, true).Count(&count).Error
+	return count > 0, err
+}
+
+// Preferences returns whether a customer receives each optional category
+func Preferences(db *gorm.DB, customerID uint) (map[string]bool, error) {
+	var prefs []models.NotificationPreference
+	if err := db.Where("customer_id = ?", customerID).Find(&prefs).Error; err != nil {
+		return nil, err
+	}
+	receives := make(map[string]bool, len(Categories))
+	for _, category := range Categories {
+		receives[category] = true
+	}
+	for _, pref := range prefs {
+		if _, ok := receives[pref.Category]; ok {
+			receives[pref.Category] = !pref.OptedOut
+		}
+	}
+	return receives, nil
+}
+
+// SetPreference records a customer's choice about a category, filed under the customer's tenant
+func SetPreference(db *gorm.DB, customer models.Customer, category string, optOut bool, source string, campaignID *uint) (models.NotificationPreference, error) {
+	var pref models.NotificationPreference
+	known := false
+	for _, c := range Categories {
+		known = known || c == category
+	}
+	if !known {
+		return pref, ErrCategory
+	}
+	err := db.Where("customer_id = ? AND category = ?", customer.ID, category).
+		Attrs(models.NotificationPreference{TenantID: customer.TenantID, CustomerID: customer.ID, Category: category}).
+		FirstOrInit(&pref).Error
+	if err != nil {
+		return pref, err
+	}
+	pref.OptedOut, pref.Source, pref.CampaignID = optOut, source, campaignID
+	return pref, db.Save(&pref).Error
+}
+
+// Unsubscribe opts the customer an emailed unsubscribe token was sent to out of announcements
+// The token is the credential; unknown tokens return gorm.ErrRecordNotFound
+func Unsubscribe(db *gorm.DB, token string) (models.NotificationPreference, error) {
+	var r models.CampaignRecipient
+	if token == "" {
+		return models.NotificationPreference{}, gorm.ErrRecordNotFound
+	}
+	if err := db.Where("unsubscribe_token_hash = ?", HashToken(token)).First(&r).Error; err != nil {
+		return models.NotificationPreference{}, err
+	}
+	var customer models.Customer
+	if err := db.Select("id, tenant_id").First(&customer, r.CustomerID).Error; err != nil {
+		return models.NotificationPreference{}, err
+	}
+	campaignID := r.CampaignID
+	return SetPreference(db, customer, CategoryAnnouncements, true, SourceUnsubscribe, &campaignID)
+}
+
+// HashToken is the stored form of an unsubscribe token; the token itself is only ever emailed
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken returns a random, URL-safe unsubscribe token
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// truncate fits an error into the recipient's error column
+func truncate(s string) string {
+	if len(s) > 500 {
+		return s[:500]
+	}
+	return s
+}
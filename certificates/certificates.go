@@ -0,0 +1,240 @@
+package certificates
+
+import (
+	"banking-app/businessdays"
+	"banking-app/documents"
+	"banking-app/i18n"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/money"
+	"banking-app/statements"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MaxAveragingDays bounds the averaging period so one request cannot walk years of postings
+const MaxAveragingDays = 366
+
+// Request errors
+var (
+	ErrFutureDate     = errors.New("certificate dates cannot be in the future")
+	ErrAveragePeriod  = errors.New("average_from and average_to must be given together, in order")
+	ErrAverageTooLong = fmt.Errorf("averaging period cannot exceed %d days", MaxAveragingDays)
+)
+
+// Signer computes verification codes; the secret never leaves the server
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner returns a signer keyed with secret
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: secret}
+}
+
+// SignerFromEnv keys the signer with CERTIFICATE_SECRET, falling back to JWT_SECRET
+// Certificates issued under one secret stop verifying when it changes, so a dedicated secret is
+// preferred - JWT_SECRET is rotated with bankctl
+func SignerFromEnv() *Signer {
+	secret := os.Getenv("CERTIFICATE_SECRET")
+	if secret == "" {
+		log.Println("certificates: CERTIFICATE_SECRET not set, signing with JWT_SECRET")
+		secret = os.Getenv("JWT_SECRET")
+	}
+	return NewSigner([]byte(secret))
+}
+
+// Code returns the verification code for a certificate's printed content
+// It is an HMAC over every stated figure, so a code only verifies the exact document it was issued on
+func (s *Signer) Code(cert models.BalanceCertificate) string {
+	mac := hmac.New(sha256.New, s.secret)
+	io.WriteString(mac, canonical(cert))
+	raw := base32.StdEncoding.EncodeToString(mac.Sum(nil))[:20]
+	groups := make([]string, 0, 5)
+	for i := 0; i < len(raw); i += 4 {
+		groups = append(groups, raw[i:i+4])
+	}
+	return strings.Join(groups, "-")
+}
+
+// Valid reports whether a stored certificate still carries the code its content signs to
+func (s *Signer) Valid(cert models.BalanceCertificate) bool {
+	return hmac.Equal([]byte(s.Code(cert)), []byte(cert.VerificationCode))
+}
+
+// canonical is the signed form of a certificate
+func canonical(cert models.BalanceCertificate) string {
+	money := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	fields := []string{
+		strconv.FormatUint(uint64(cert.TenantID), 10),
+		strconv.FormatUint(uint64(cert.AccountID), 10),
+		cert.HolderName,
+		cert.AccountNumber,
+		cert.Currency,
+		businessdays.Format(cert.AsOf),
+		money(cert.Balance),
+		"", "", "",
+		strconv.FormatInt(cert.CreatedAt.UnixMilli(), 10),
+	}
+	if cert.AverageFrom != nil && cert.AverageTo != nil && cert.AverageBalance != nil {
+		fields[7] = businessdays.Format(*cert.AverageFrom)
+		fields[8] = businessdays.Format(*cert.AverageTo)
+		fields[9] = money(*cert.AverageBalance)
+	}
+	return strings.Join(fields, "\x1f")
+}
+
+// Request describes the certificate to issue
+type Request struct {
+	AsOf        *time.Time // Defaults to today
+	AverageFrom *time.Time // Optional averaging period, both ends inclusive
+	AverageTo   *time.Time
+	Mask        bool // Print only the last four digits of the account number
+	Purpose     string
+	IssuedBy    string
+}
+
+// Validate checks the request dates against today
+func (r Request) Validate(now time.Time) error {
+	today := ledger.StartOfDay(now)
+	if r.AsOf != nil && r.AsOf.After(today) {
+		return ErrFutureDate
+	}
+	if (r.AverageFrom == nil) != (r.AverageTo == nil) {
+		return ErrAveragePeriod
+	}
+	if r.AverageFrom != nil {
+		if r.AverageTo.Before(*r.AverageFrom) {
+			return ErrAveragePeriod
+		}
+		if r.AverageTo.After(today) {
+			return ErrFutureDate
+		}
+		if days(*r.AverageFrom, *r.AverageTo) > MaxAveragingDays {
+			return ErrAverageTooLong
+		}
+	}
+	return nil
+}
+
+// Build computes the figures for a certificate without saving it
+func Build(db *gorm.DB, account models.Account, customer models.Customer, req Request, now time.Time) (models.BalanceCertificate, error) {
+	asOf := ledger.StartOfDay(now)
+	if req.AsOf != nil {
+		asOf = ledger.StartOfDay(*req.AsOf)
+	}
+	cert := models.BalanceCertificate{
+		CreatedAt:     now.Truncate(time.Millisecond),
+		TenantID:      account.TenantID,
+		AccountID:     account.ID,
+		CustomerID:    customer.ID,
+		HolderName:    strings.TrimSpace(customer.FirstName + " " + customer.LastName),
+		AccountNumber: account.AccountNumber,
+		Masked:        req.Mask,
+		Currency:      account.Currency,
+		Purpose:       req.Purpose,
+		AsOf:          asOf,
+		IssuedBy:      req.IssuedBy,
+	}
+	if req.Mask {
+		cert.AccountNumber = Mask(account.AccountNumber)
+	}
+
+	var err error
+	if cert.Balance, err = BalanceAt(db, account.ID, asOf); err != nil {
+		return cert, err
+	}
+	if req.AverageFrom != nil {
+		from, to := ledger.StartOfDay(*req.AverageFrom), ledger.StartOfDay(*req.AverageTo)
+		average, err := AverageBalance(db, account.ID, from, to)
+		if err != nil {
+			return cert, err
+		}
+		cert.AverageFrom, cert.AverageTo, cert.AverageBalance = &from, &to, &average
+	}
+	return cert, nil
+}
+
+// BalanceAt returns an account's balance at the end of a day, by effective date
+func BalanceAt(db *gorm.DB, accountID uint, day time.Time) (float64, error) {
+	balance, err := statements.BalanceAt(db, accountID, day)
+	return balance.Balance, err
+}
+
+// AverageBalance returns the mean end-of-day balance over [from, to], both days inclusive
+func AverageBalance(db *gorm.DB, accountID uint, from, to time.Time) (float64, error) {
+	end := ledger.DayAfter(to)
+	sum, err := statements.Summarize(db, accountID, from, end)
+	if err != nil {
+		return 0, err
+	}
+
+	// Walk the postings once, carrying each day's closing balance forward to the next posting
+	var total float64
+	day, balance := from, sum.OpeningBalance
+	err = statements.Each(db, accountID, from, end, sum.OpeningBalance, func(line statements.Line) error {
+		for posted := ledger.StartOfDay(line.Date); day.Before(posted); day = day.AddDate(0, 0, 1) {
+			total += balance
+		}
+		balance = line.Balance
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for ; day.Before(end); day = day.AddDate(0, 0, 1) {
+		total += balance
+	}
+	return money.Cents(total / float64(days(from, to))), nil
+}
+
+// Mask keeps the last four characters of an account number
+func Mask(number string) string {
+	if len(number) <= 4 {
+		return number
+	}
+	return "****" + number[len(number)-4:]
+}
+
+// WritePDF renders an issued certificate as a one-page letter in the given locale
+func WritePDF(w io.Writer, cert models.BalanceCertificate, bank string, locale *i18n.Locale) error {
+	money, date, t := locale.Number, locale.Date, locale.T
+
+	pdf := documents.NewPDF(w)
+	pdf.Heading(bank)
+	pdf.Heading(t("certificate.title"))
+	pdf.Text(t("certificate.issued", date(cert.CreatedAt)))
+	if cert.Purpose != "" {
+		pdf.Text(t("certificate.purpose", cert.Purpose))
+	}
+	pdf.Text("")
+	pdf.Text(t("certificate.statement"))
+	pdf.Mono(fmt.Sprintf("%-28s %s", t("certificate.holder"), cert.HolderName))
+	pdf.Mono(fmt.Sprintf("%-28s %s", t("certificate.account_number"), cert.AccountNumber))
+	pdf.Mono(fmt.Sprintf("%-28s %s", t("certificate.currency"), cert.Currency))
+	pdf.Mono(fmt.Sprintf("%-28s %s", t("certificate.balance_as_of", date(cert.AsOf)), money(cert.Balance)))
+	if cert.AverageBalance != nil {
+		pdf.Mono(fmt.Sprintf("%-28s %s", t("certificate.average_balance"), money(*cert.AverageBalance)))
+		pdf.Mono(fmt.Sprintf("%-28s %s", t("certificate.averaging_period"), t("certificate.period", date(*cert.AverageFrom), date(*cert.AverageTo))))
+	}
+	pdf.Heading(t("certificate.verification"))
+	pdf.Text(t("certificate.code", cert.VerificationCode))
+	pdf.Text(t("certificate.confirm", "/api/v1/certificates/verify/"+cert.VerificationCode))
+	return pdf.Close()
+}
+
+// days counts the calendar days in [from, to], both inclusive
+func days(from, to time.Time) int {
+	return businessdays.Days(from, to) + 1
+}
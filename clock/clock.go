@@ -0,0 +1,75 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// System is the real clock
+type System struct{}
+
+// Now returns the wall-clock time
+func (System) Now() time.Time { return time.Now() }
+
+// Fake is a clock that stands still until it is moved, so a sandbox sees the same time on every read
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFake returns a fake clock stopped at start
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+// Now returns the fake time
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Set moves the fake time to t, which may be earlier than now
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	f.now = t
+	f.mu.Unlock()
+}
+
+// Advance moves the fake time forward by d and returns the new time
+func (f *Fake) Advance(d time.Duration) time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	return f.now
+}
+
+// The application's clock; business logic reads it through Now instead of calling time.Now
+var (
+	mu      sync.RWMutex
+	current Clock = System{}
+)
+
+// Use replaces the application's clock; it is meant to be called once at startup
+func Use(c Clock) {
+	mu.Lock()
+	current = c
+	mu.Unlock()
+}
+
+// Now returns the current time on the application's clock
+func Now() time.Time {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current.Now()
+}
+
+// Since returns the time elapsed since t on the application's clock
+func Since(t time.Time) time.Duration {
+	return Now().Sub(t)
+}
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"banking-app/anonymize"
+	"banking-app/auth"
+	"banking-app/database"
+	"banking-app/events"
+	"banking-app/externalaccounts"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"banking-app/reconcile"
+	"banking-app/statements"
+	"banking-app/statushistory"
+	"banking-app/subscriptions"
+	"banking-app/tenancy"
+	"banking-app/uploads"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// runCreateAdmin creates an admin user, migrating the schema first so it works on a fresh database
+func runCreateAdmin(a *app, args []string) error {
+	fs := a.flags("create-admin")
+	username := fs.String("username", "admin", "login name")
+	password := fs.String("password", os.Getenv("BANKCTL_PASSWORD"), "password (defaults to $BANKCTL_PASSWORD)")
+	tenant := fs.String("tenant", tenancy.DefaultCode, "tenant code")
+	fs.Parse(args)
+
+	db, err := a.migrate()
+	if err != nil {
+		return err
+	}
+	if err := tenancy.EnsureDefault(db); err != nil {
+		return err
+	}
+	db, err = forTenant(db, *tenant)
+	if err != nil {
+		return err
+	}
+
+	user, err := auth.CreateUser(db, *username, *password, "admin")
+	if err != nil {
+		return err
+	}
+	a.emit(user, "created admin user %q (id %d)", user.Username, user.ID)
+	return nil
+}
+
+// runCreateCustomerUser creates a customer-role user linked to a customer, e.g. to approve OAuth2 clients
+func runCreateCustomerUser(a *app, args []string) error {
+	fs := a.flags("create-customer-user")
+	username := fs.String("username", "", "login name")
+	password := fs.String("password", os.Getenv("BANKCTL_PASSWORD"), "password (defaults to $BANKCTL_PASSWORD)")
+	customerID := fs.Uint("customer-id", 0, "customer the user signs in as")
+	tenant := fs.String("tenant", tenancy.DefaultCode, "tenant code")
+	fs.Parse(args)
+	if *username == "" || *customerID == 0 {
+		return errors.New("-username and -customer-id are required")
+	}
+
+	db, err := a.open()
+	if err != nil {
+		return err
+	}
+	db, err = forTenant(db, *tenant)
+	if err != nil {
+		return err
+	}
+	user, err := auth.CreateCustomerUser(db, *username, *password, uint(*customerID))
+	if err != nil {
+		return err
+	}
+	a.emit(user, "created customer user %q (id %d) for customer %d", user.Username, user.ID, *customerID)
+	return nil
+}
+
+// runRotateSecret prints a new random JWT secret
+// The server reads JWT_SECRET at startup; existing tokens stop validating once it restarts with the new value
+func runRotateSecret(a *app, args []string) error {
+	fs := a.flags("rotate-jwt-secret")
+	fs.Parse(args)
+
+	secret, err := auth.GenerateSecret()
+	if err != nil {
+		return err
+	}
+	a.emit(map[string]string{"jwt_secret": secret},
+		"JWT_SECRET=%s\n\nSet this value in the server environment and restart; all issued tokens will be invalidated.", secret)
+	return nil
+}
+
+// runMigrate applies or inspects the schema
+func runMigrate(a *app, args []string) error {
+	fs := a.flags("migrate")
+	fs.Parse(args)
+
+	direction := fs.Arg(0)
+	switch direction {
+	case "up":
+		if _, err := a.migrate(); err != nil {
+			return err
+		}
+		a.emit(map[string]interface{}{"migrated": true, "tables": len(database.Models())},
+			"schema is up to date (%d tables)", len(database.Models()))
+		return nil
+
+	case "status":
+		db, err := a.open()
+		if err != nil {
+			return err
+		}
+		type tableStatus struct {
+			Table   string `json:"table"`
+			Exists  bool   `json:"exists"`
+			Current bool   `json:"current"` // every model column is present
+		}
+		var status []tableStatus
+		var lines []string
+		for _, model := range database.Models() {
+			stmt := &gorm.Statement{DB: db}
+			if err := stmt.Parse(model); err != nil {
+				return err
+			}
+			ts := tableStatus{Table: stmt.Schema.Table, Exists: db.Migrator().HasTable(model)}
+			if ts.Exists {
+				ts.Current = true
+				for _, field := range stmt.Schema.DBNames {
+					if !db.Migrator().HasColumn(model, field) {
+						ts.Current = false
+					}
+				}
+			}
+			status = append(status, ts)
+			state := "missing"
+			if ts.Exists && ts.Current {
+				state = "current"
+			} else if ts.Exists {
+				state = "pending columns"
+			}
+			lines = append(lines, fmt.Sprintf("  %-24s %s", ts.Table, state))
+		}
+		a.emit(status, "%s", strings.Join(lines, "\n"))
+		return nil
+
+	case "down":
+		// The schema is managed declaratively by AutoMigrate, so there are no versioned down steps to run
+		return errors.New("migrate down is not supported: the schema is derived from the models; restore a database backup to roll back")
+
+	default:
+		return errors.New("usage: bankctl migrate up|status|down")
+	}
+}
+
+// runReconcile checks balances against postings; exits 3 when breaks are found
+func runReconcile(a *app, args []string) error {
+	fs := a.flags("reconcile")
+	fs.Parse(args)
+
+	db, err := a.open()
+	if err != nil {
+		return err
+	}
+	report, err := reconcile.Accounts(db)
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("checked %d accounts, %d break(s)", report.AccountsChecked, len(report.Breaks))
+	for _, b := range report.Breaks {
+		text += fmt.Sprintf("\n  %s balance=%.2f ledger=%.2f last_posting=%.2f difference=%.2f",
+			b.AccountNumber, b.Balance, b.LedgerBalance, b.LastBalance, b.Difference)
+	}
+	a.emit(report, "%s", text)
+
+	if len(report.Breaks) > 0 {
+		return errBreaks{count: len(report.Breaks)}
+	}
+	return nil
+}
+
+// runUnlockUser clears the lock placed after repeated failed sign-ins
+func runUnlockUser(a *app, args []string) error {
+	fs := a.flags("unlock-user")
+	username := fs.String("username", "", "login name")
+	tenant := fs.String("tenant", tenancy.DefaultCode, "tenant code")
+	fs.Parse(args)
+	if *username == "" {
+		return errors.New("-username is required")
+	}
+
+	db, err := a.open()
+	if err != nil {
+		return err
+	}
+	db, err = forTenant(db, *tenant)
+	if err != nil {
+		return err
+	}
+	var user models.User
+	if err = checkUserStatus(db, *username, "active"); err == nil {
+		user, err = auth.Unlock(db, *username)
+	}
+	if err == gorm.ErrRecordNotFound {
+		return fmt.Errorf("user %q not found", *username)
+	}
+	if err != nil {
+		return err
+	}
+	a.emit(user, "unlocked user %q", user.Username)
+	return nil
+}
+
+// runDisableUser stops a user signing in and pauses the report subscriptions they own
+func runDisableUser(a *app, args []string) error {
+	fs := a.flags("disable-user")
+	username := fs.String("username", "", "login name")
+	tenant := fs.String("tenant", tenancy.DefaultCode, "tenant code")
+	fs.Parse(args)
+	if *username == "" {
+		return errors.New("-username is required")
+	}
+
+	db, err := a.open()
+	if err != nil {
+		return err
+	}
+	db, err = forTenant(db, *tenant)
+	if err != nil {
+		return err
+	}
+	var user models.User
+	if err = checkUserStatus(db, *username, "disabled"); err == nil {
+		user, err = auth.Disable(db, *username)
+	}
+	if err == gorm.ErrRecordNotFound {
+		return fmt.Errorf("user %q not found", *username)
+	}
+	if err != nil {
+		return err
+	}
+	paused, err := subscriptions.PauseInactiveOwners(db)
+	if err != nil {
+		return err
+	}
+	a.emit(user, "disabled user %q, %d report subscription(s) paused", user.Username, paused)
+	return nil
+}
+
+// checkUserStatus refuses a change of a user's status that the user lifecycle does not allow
+func checkUserStatus(db *gorm.DB, username, to string) error {
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return err
+	}
+	return lifecycle.Check(lifecycle.User, user.Status, to)
+}
+
+// runFreezeAccount blocks postings on an account
+// The server rejects postings to non-active accounts immediately; its cached balance view refreshes within a minute
+func runFreezeAccount(a *app, args []string) error {
+	fs := a.flags("freeze-account")
+	number := fs.String("number", "", "account number")
+	reason := fs.String("reason", "", "reason recorded on the account.frozen event and in the status history")
+	fs.Parse(args)
+	if *number == "" || strings.TrimSpace(*reason) == "" {
+		return errors.New("-number and -reason are required")
+	}
+
+	db, err := a.open()
+	if err != nil {
+		return err
+	}
+
+	var account models.Account
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("account_number = ?", *number).First(&account).Error; err != nil {
+			return err
+		}
+		if account.Status == "frozen" {
+			return nil
+		}
+		previous := account.Status
+		account.Status = "frozen"
+		account.Version++
+		if err := tx.Model(&account).Updates(map[string]interface{}{"status": account.Status, "version": account.Version}).Error; err != nil {
+			return err
+		}
+		err := statushistory.Record(tx, models.StatusHistory{
+			TenantID:    account.TenantID,
+			SubjectType: statushistory.SubjectAccount,
+			SubjectID:   account.ID,
+			OldStatus:   previous,
+			NewStatus:   account.Status,
+			Reason:      *reason,
+			ChangedBy:   "bankctl",
+		})
+		if err != nil {
+			return err
+		}
+		return events.Record(tx, events.AggregateAccount, account.ID, events.AccountFrozen, map[string]interface{}{
+			"account_id":      account.ID,
+			"account_number":  account.AccountNumber,
+			"previous_status": previous,
+			"reason":          *reason,
+		})
+	})
+	if err == gorm.ErrRecordNotFound {
+		return fmt.Errorf("account %s not found", *number)
+	}
+	if err != nil {
+		return err
+	}
+
+	a.emit(map[string]interface{}{"account_id": account.ID, "account_number": account.AccountNumber, "status": account.Status},
+		"account %s is frozen", account.AccountNumber)
+	return nil
+}
+
+// runStatement writes a month's statement for an account as CSV or JSON
+func runStatement(a *app, args []string) error {
+	fs := a.flags("statement")
+	number := fs.String("account", "", "account number")
+	month := fs.String("month", "", "statement month (YYYY-MM)")
+	format := fs.String("format", "csv", "file format: csv or json")
+	out := fs.String("out", "", "output file (default statement-<account>-<month>.<format>)")
+	fs.Parse(args)
+	if *number == "" || *month == "" {
+		return errors.New("-account and -month are required")
+	}
+	if *format != "csv" && *format != "json" {
+		return errors.New("-format must be csv or json")
+	}
+
+	start, err := statements.ParseMonth(*month)
+	if err != nil {
+		return err
+	}
+	db, err := a.open()
+	if err != nil {
+		return err
+	}
+
+	var account models.Account
+	if err := db.Where("account_number = ?", *number).First(&account).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return fmt.Errorf("account %s not found", *number)
+		}
+		return err
+	}
+	st, err := statements.Build(db, account, start, start.AddDate(0, 1, 0))
+	if err != nil {
+		return err
+	}
+
+	path := *out
+	if path == "" {
+		path = fmt.Sprintf("statement-%s-%s.%s", account.AccountNumber, *month, *format)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if *format == "json" {
+		enc := json.NewEncoder(file)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(st)
+	} else {
+		err = statements.WriteCSV(file, st)
+	}
+	if err != nil {
+		return err
+	}
+
+	a.emit(map[string]interface{}{
+		"file":            path,
+		"account_number":  account.AccountNumber,
+		"month":           *month,
+		"lines":           len(st.Lines),
+		"opening_balance": st.OpeningBalance,
+		"closing_balance": st.ClosingBalance,
+	}, "wrote %s (%d lines, opening %.2f, closing %.2f)", path, len(st.Lines), st.OpeningBalance, st.ClosingBalance)
+	return nil
+}
+
+// runAnonymize rewrites the personal data in a database copy so it can be used outside production
+// It refuses a database listed in PRODUCTION_DB_PATH and exits non-zero if the verification pass finds an
+// original email address or phone number left behind
+func runAnonymize(a *app, args []string) error {
+	fs := a.flags("anonymize")
+	seed := fs.String("seed", "", "varies the fake values; the same seed gives the same values for the same rows")
+	uploadDir := fs.String("uploads", envOr("UPLOAD_DIR", "data/uploads"), "document storage directory of the copy")
+	fs.Parse(args)
+
+	production := anonymize.ProductionPathsFromEnv()
+	if err := anonymize.CheckTarget(a.dbPath, production); err != nil {
+		return err
+	}
+	if len(production) == 0 && !a.jsonMode {
+		fmt.Fprintln(os.Stderr, "bankctl: warning: PRODUCTION_DB_PATH is not set, so the target cannot be checked against production")
+	}
+
+	db, err := a.open()
+	if err != nil {
+		return err
+	}
+	report, err := anonymize.Run(db, anonymize.Options{Seed: *seed, Storage: uploads.LocalStorage{Dir: *uploadDir}})
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("anonymized %d customers, %d users, %d notifications, %d subscriptions, %d notes, %d documents\n"+
+		"replaced %d stored files, rewrote %d other rows, re-chained %d transactions, regenerated %d descriptors",
+		report.Customers, report.Users, report.Notifications, report.Subscriptions, report.Notes, report.Documents,
+		report.Files, report.TextRows, report.Rehashed, report.Descriptors)
+	for _, leak := range report.Leaks {
+		text += fmt.Sprintf("\n  original %s remains in %s.%s (rowid %d)", leak.Kind, leak.Table, leak.Column, leak.RowID)
+	}
+	a.emit(report, "%s", text)
+	if len(report.Leaks) > 0 {
+		return fmt.Errorf("verification failed: %d original email address(es) or phone number(s) remain", len(report.Leaks))
+	}
+	return nil
+}
+
+// runMicroDeposits prints the micro-deposits to send for external account links awaiting confirmation
+// The amounts are never served by the API; this reads them with the server's EXTERNAL_ACCOUNT_KEY
+func runMicroDeposits(a *app, args []string) error {
+	fs := a.flags("micro-deposits")
+	fs.Parse(args)
+
+	db, err := a.open()
+	if err != nil {
+		return err
+	}
+	pending, err := externalaccounts.Pending(db, externalaccounts.SealerFromEnv(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("%d link(s) awaiting micro-deposits", len(pending))
+	for _, o := range pending {
+		text += fmt.Sprintf("\n  link %d customer %d %s routing=%s account=%s amounts=%.2f,%.2f",
+			o.LinkID, o.CustomerID, o.AccountType, o.RoutingNumber, o.AccountNumber, o.Amounts[0], o.Amounts[1])
+	}
+	a.emit(pending, "%s", text)
+	return nil
+}
+
+// storageCheck is what storage-check found
+type storageCheck struct {
+	Backend   string `json:"backend"`
+	Key       string `json:"key"`
+	Size      int    `json:"size"`
+	Presigned bool   `json:"presigned"` // Whether the storage hands out direct download URLs
+}
+
+// runLifecycles prints every record type's statuses and transitions as markdown, for the README and client docs
+// -check instead fails if the declared lifecycles are malformed or a model has a status field without one
+func runLifecycles(a *app, args []string) error {
+	fs := a.flags("lifecycles")
+	check := fs.Bool("check", false, "only check every model with a status field has a well-formed lifecycle")
+	fs.Parse(args)
+
+	if *check {
+		if err := lifecycle.Validate(); err != nil {
+			return err
+		}
+		if missing := lifecycle.Undeclared(database.Models()...); len(missing) > 0 {
+			return fmt.Errorf("no lifecycle is declared for the status of %s", strings.Join(missing, ", "))
+		}
+		machines := lifecycle.All()
+		a.emit(map[string]int{"lifecycles": len(machines)}, "%d lifecycles declared; every status field has one", len(machines))
+		return nil
+	}
+
+	machines := lifecycle.All()
+	var b strings.Builder
+	for i, m := range machines {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "#### %s\n\nStatuses: %s. New records start %s.\n", m.Subject,
+			strings.Join(m.Statuses, ", "), strings.Join(m.Initial, " or "))
+		if len(m.Transitions) == 0 {
+			b.WriteString("\nNo transitions; the status never changes.\n")
+			continue
+		}
+		b.WriteString("\n| From | To | Permission | Reason |\n|------|----|------------|--------|\n")
+		for _, t := range m.Transitions {
+			reason := ""
+			if t.Reason {
+				reason = "required"
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", t.From, t.To, t.Permission, reason)
+		}
+	}
+	a.emit(machines, "%s", strings.TrimSuffix(b.String(), "\n"))
+	return nil
+}
+
+// runStorageCheck round-trips a random file through the storage the server is configured with
+// (STORAGE_BACKEND and its settings), so a bucket's credentials, encryption and pre-signing can be
+// checked before the server depends on them. The file is removed again
+func runStorageCheck(a *app, args []string) error {
+	fs := a.flags("storage-check")
+	size := fs.Int("size", 256<<10, "bytes in the test file")
+	fs.Parse(args)
+
+	storage, err := uploads.StorageFromEnv()
+	if err != nil {
+		return err
+	}
+	data := make([]byte, *size)
+	if _, err := rand.Read(data); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	check := storageCheck{
+		Backend: envOr("STORAGE_BACKEND", uploads.BackendDisk),
+		Key:     "checks/" + hex.EncodeToString(sum[:8]),
+		Size:    *size,
+	}
+
+	if err := storage.Put(check.Key, data); err != nil {
+		return err
+	}
+	defer storage.Delete(check.Key)
+	file, err := storage.Get(check.Key)
+	if err != nil {
+		return err
+	}
+	read, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(read, data) {
+		return fmt.Errorf("read back %d bytes that differ from the %d written", len(read), len(data))
+	}
+
+	if presigner, ok := storage.(uploads.Presigner); ok {
+		check.Presigned = true
+		url, err := presigner.PresignGet(check.Key, "check.bin", "application/octet-stream")
+		if err != nil {
+			return err
+		}
+		resp, err := http.Get(url)
+		if err != nil {
+			return fmt.Errorf("pre-signed download: %w", err)
+		}
+		read, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK || !bytes.Equal(read, data) {
+			return fmt.Errorf("pre-signed download returned status %d and %d bytes that do not match", resp.StatusCode, len(read))
+		}
+	}
+
+	if err := storage.Delete(check.Key); err != nil {
+		return err
+	}
+	if file, err := storage.Get(check.Key); err == nil {
+		file.Close()
+		return errors.New("the test file can still be read after it was deleted")
+	} else if !errors.Is(err, iofs.ErrNotExist) {
+		return err
+	}
+	if err := storage.Delete(check.Key); err != nil {
+		return fmt.Errorf("deleting a missing file: %w", err)
+	}
+
+	a.emit(check, "%s storage: wrote, read back and deleted %d bytes at %s (pre-signed download: %t)", check.Backend, check.Size, check.Key, check.Presigned)
+	return nil
+}
+
+// forTenant scopes a database handle to the tenant with the given code
+// Commands without a tenant flag operate across all tenants
+func forTenant(db *gorm.DB, code string) (*gorm.DB, error) {
+	var tenant models.Tenant
+	if err := db.Where("code = ?", code).First(&tenant).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("tenant %q not found", code)
+		}
+		return nil, err
+	}
+	return db.WithContext(tenancy.NewContext(context.Background(), tenant.ID)), nil
+}
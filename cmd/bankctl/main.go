@@ -0,0 +1,188 @@
+// Command bankctl performs operational tasks directly against the banking database
+//
+// Usage:
+//
+//	bankctl [-db path] [-json] <command> [flags]
+//
+// Commands:
+//
+//	create-admin          create an admin user (password from -password or BANKCTL_PASSWORD)
+//	create-customer-user  create a customer user who signs in as a customer
+//	rotate-jwt-secret     generate a new JWT_SECRET value
+//	migrate               run schema migrations: up, status, down
+//	reconcile             compare account balances with their postings
+//	unlock-user           clear a user's sign-in lock
+//	disable-user          deactivate a user and pause their report subscriptions
+//	freeze-account        freeze an account by account number
+//	statement             write an account statement file for a month
+//	anonymize             rewrite personal data in a non-production database copy
+//	micro-deposits        print the micro-deposits to send for pending external account links
+//	storage-check         write, read, pre-sign and delete a test file in the configured document storage
+//	lifecycles            print every record type's status transitions as markdown, or check they are declared
+package main
+
+import (
+	"banking-app/database"
+	"banking-app/tenancy"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// command is a bankctl subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(app *app, args []string) error
+}
+
+// app holds the global options shared by every command
+type app struct {
+	dbPath   string
+	jsonMode bool
+	db       *gorm.DB
+}
+
+// exitBreaks is returned by reconcile when balances do not match
+const exitBreaks = 3
+
+// errBreaks signals a successful run that found problems
+type errBreaks struct{ count int }
+
+func (e errBreaks) Error() string { return fmt.Sprintf("%d reconciliation break(s) found", e.count) }
+
+var commands = []command{
+	{"create-admin", "create an admin user", runCreateAdmin},
+	{"create-customer-user", "create a customer user linked to a customer", runCreateCustomerUser},
+	{"rotate-jwt-secret", "generate a new JWT_SECRET value", runRotateSecret},
+	{"migrate", "run schema migrations (up, status, down)", runMigrate},
+	{"reconcile", "compare account balances with their postings", runReconcile},
+	{"unlock-user", "clear a user's sign-in lock", runUnlockUser},
+	{"disable-user", "deactivate a user and pause their report subscriptions", runDisableUser},
+	{"freeze-account", "freeze an account by account number", runFreezeAccount},
+	{"statement", "write an account statement file for a month", runStatement},
+	{"anonymize", "rewrite personal data in a non-production database copy", runAnonymize},
+	{"micro-deposits", "print the micro-deposits to send for pending external account links", runMicroDeposits},
+	{"storage-check", "write, read, pre-sign and delete a test file in the configured document storage", runStorageCheck},
+	{"lifecycles", "print status transitions per record type, or -check every status field has them", runLifecycles},
+}
+
+func main() {
+	a := &app{}
+	global := flag.NewFlagSet("bankctl", flag.ExitOnError)
+	global.StringVar(&a.dbPath, "db", envOr("DB_PATH", "banking.db"), "SQLite database file")
+	global.BoolVar(&a.jsonMode, "json", false, "machine-readable JSON output")
+	global.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: bankctl [-db path] [-json] <command> [flags]")
+		fmt.Fprintln(os.Stderr, "\ncommands:")
+		for _, cmd := range commands {
+			fmt.Fprintf(os.Stderr, "  %-18s %s\n", cmd.name, cmd.summary)
+		}
+		fmt.Fprintln(os.Stderr, "\nglobal flags:")
+		global.PrintDefaults()
+	}
+	global.Parse(os.Args[1:])
+
+	if global.NArg() == 0 {
+		global.Usage()
+		os.Exit(2)
+	}
+
+	name := global.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		err := cmd.run(a, global.Args()[1:])
+		if err == nil {
+			return
+		}
+		if breaks, ok := err.(errBreaks); ok {
+			if !a.jsonMode {
+				fmt.Fprintln(os.Stderr, breaks.Error())
+			}
+			os.Exit(exitBreaks)
+		}
+		a.fail(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "bankctl: unknown command %q\n", name)
+	global.Usage()
+	os.Exit(2)
+}
+
+// flags creates a subcommand flag set that also accepts -json after the command name
+func (a *app) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("bankctl "+name, flag.ExitOnError)
+	fs.BoolVar(&a.jsonMode, "json", a.jsonMode, "machine-readable JSON output")
+	return fs
+}
+
+// open connects to an existing database with SQL logging silenced
+func (a *app) open() (*gorm.DB, error) {
+	if a.db != nil {
+		return a.db, nil
+	}
+	if _, err := os.Stat(a.dbPath); err != nil {
+		return nil, fmt.Errorf("database %s: %w", a.dbPath, err)
+	}
+	return a.connect()
+}
+
+// migrate connects, creating the database file if needed, and brings the schema up to date
+func (a *app) migrate() (*gorm.DB, error) {
+	db, err := a.connect()
+	if err != nil {
+		return nil, err
+	}
+	return db, database.Migrate(db)
+}
+
+// connect opens the database file with SQL logging silenced
+func (a *app) connect() (*gorm.DB, error) {
+	if a.db != nil {
+		return a.db, nil
+	}
+	db, err := database.Open(a.dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := tenancy.RegisterCallbacks(db); err != nil {
+		return nil, err
+	}
+	a.db = db.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+	return a.db, nil
+}
+
+// emit prints a result as JSON or as the given human-readable text
+func (a *app) emit(result interface{}, text string, args ...interface{}) {
+	if a.jsonMode {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+		return
+	}
+	fmt.Printf(text+"\n", args...)
+}
+
+// fail reports an error and exits non-zero
+func (a *app) fail(err error) {
+	if a.jsonMode {
+		json.NewEncoder(os.Stdout).Encode(map[string]string{"error": err.Error()})
+	} else {
+		fmt.Fprintln(os.Stderr, "bankctl:", err)
+	}
+	os.Exit(1)
+}
+
+// envOr returns an environment variable or a fallback
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
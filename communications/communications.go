@@ -0,0 +1,154 @@
+package communications
+
+import (
+	"banking-app/clock"
+	"banking-app/models"
+	"banking-app/uploads"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Channels a communication goes out on
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+	ChannelLetter  = "letter"  // A document produced for the customer to receive, such as a balance certificate
+	ChannelArchive = "archive" // A document filed for the customer without being sent
+)
+
+// Entry statuses
+const (
+	StatusSent      = "sent"
+	StatusFailed    = "failed"
+	StatusGenerated = "generated"
+	StatusArchived  = "archived"
+)
+
+// Related record types
+const (
+	ResourceStatement         = "statement"
+	ResourceCertificate       = "certificate"
+	ResourceAlertRule         = "alert_rule"
+	ResourceEscheatment       = "escheatment"
+	ResourceEmailVerification = "email_verification"
+	ResourceBudget            = "budget"
+	ResourceTransactionReview = "transaction_review"
+	ResourceRateChange        = "rate_change"
+	ResourceDuplicatePayment  = "duplicate_payment"
+	ResourceProductChange     = "product_change"
+	ResourceAuthorization     = "authorization"
+	ResourceLoanCollection    = "loan_collection"
+	ResourceCampaign          = "campaign"
+	ResourceGarnishment       = "garnishment"
+)
+
+// Errors returned by Retry; handlers map these to client responses
+var (
+	ErrNotFailed = errors.New("only failed sends can be retried")
+	ErrNoRetry   = errors.New("this entry has no notification to resend")
+	ErrRetried   = errors.New("the notification has already been queued again")
+)
+
+// Storage is where communication content is kept; uploads.Storage satisfies it
+type Storage interface {
+	Put(key string, data []byte) error
+}
+
+// Entry describes a communication to log
+// Content is stored under a key derived from its hash; ContentKey instead points at a document already stored
+type Entry struct {
+	CustomerID     uint
+	Channel        string
+	Subject        string
+	Status         string
+	ResourceType   string
+	ResourceID     uint
+	NotificationID *uint
+	Error          string
+	Content        []byte
+	ContentKey     string
+	ContentType    string
+}
+
+// Record stores an entry's content and appends it to the customer's communication log
+// The entry is filed under the customer's tenant, so background senders need no tenant context
+func Record(db *gorm.DB, storage Storage, e Entry) (models.CommunicationLog, error) {
+	var customer models.Customer
+	if err := db.Unscoped().Select("id, tenant_id").First(&customer, e.CustomerID).Error; err != nil {
+		return models.CommunicationLog{}, fmt.Errorf("customer %d: %w", e.CustomerID, err)
+	}
+
+	key := e.ContentKey
+	if key == "" {
+		sum := sha256.Sum256(e.Content)
+		key = uploads.Prefix("communications", customer.TenantID, customer.ID, clock.Now()) + "/" + hex.EncodeToString(sum[:])
+		if err := storage.Put(key, e.Content); err != nil {
+			return models.CommunicationLog{}, err
+		}
+	}
+	if len(e.Error) > 500 {
+		e.Error = e.Error[:500]
+	}
+
+	entry := models.CommunicationLog{
+		TenantID:       customer.TenantID,
+		CustomerID:     customer.ID,
+		Channel:        e.Channel,
+		Subject:        e.Subject,
+		Status:         e.Status,
+		ResourceType:   e.ResourceType,
+		ResourceID:     e.ResourceID,
+		NotificationID: e.NotificationID,
+		Error:          e.Error,
+		ContentKey:     key,
+		ContentType:    e.ContentType,
+	}
+	return entry, db.Create(&entry).Error
+}
+
+// RecordNotification logs the outcome of a notification's delivery, storing its body
+func RecordNotification(db *gorm.DB, storage Storage, n models.Notification, status, sendErr string) (models.CommunicationLog, error) {
+	id := n.ID
+	return Record(db, storage, Entry{
+		CustomerID:     n.CustomerID,
+		Channel:        n.Channel,
+		Subject:        n.Subject,
+		Status:         status,
+		ResourceType:   n.ResourceType,
+		ResourceID:     n.ResourceID,
+		NotificationID: &id,
+		Error:          sendErr,
+		Content:        []byte(n.Body),
+		ContentType:    "text/plain; charset=utf-8",
+	})
+}
+
+// Retry queues a failed entry's notification for delivery again; its outcome is logged as a new entry
+// The notification must still be failed, so two retries of the same failure cannot both send it
+func Retry(db *gorm.DB, entry models.CommunicationLog, now time.Time) (models.Notification, error) {
+	var n models.Notification
+	if entry.Status != StatusFailed {
+		return n, ErrNotFailed
+	}
+	if entry.NotificationID == nil {
+		return n, ErrNoRetry
+	}
+	if err := db.First(&n, *entry.NotificationID).Error; err != nil {
+		return n, ErrNoRetry
+	}
+
+	result := db.Model(&n).Where("status = ?", StatusFailed).
+		Updates(map[string]interface{}{"status": "pending", "attempts": 0, "last_error": "", "updated_at": now})
+	if result.Error != nil {
+		return n, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return n, ErrRetried
+	}
+	return n, db.First(&n, n.ID).Error
+}
@@ -0,0 +1,329 @@
+package compression
+
+import (
+	"banking-app/metrics"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultMinBytes is the smallest response body worth compressing; below it the gzip framing costs more than it saves
+const DefaultMinBytes = 1024
+
+// DefaultExcludeTypes are content types that are already compressed, or streamed to clients that expect them as is
+var DefaultExcludeTypes = []string{
+	"application/pdf", "application/zip", "application/gzip", "application/x-gzip",
+	"image/", "audio/", "video/", "text/event-stream",
+}
+
+// Config holds response compression settings
+type Config struct {
+	Enabled      bool
+	MinBytes     int      // Bodies shorter than this are sent as they are, unless the handler flushes first
+	Level        int      // gzip level, 1 (fastest) to 9 (smallest), or gzip.DefaultCompression
+	ExcludeTypes []string // Content type prefixes that are never compressed
+	ExcludePaths []string // Request path prefixes that are never compressed
+}
+
+// ConfigFromEnv reads RESPONSE_COMPRESSION (default on), COMPRESSION_MIN_BYTES (default 1024), COMPRESSION_LEVEL,
+// and the comma-separated COMPRESSION_EXCLUDE_TYPES and COMPRESSION_EXCLUDE_PATHS prefix lists
+// Setting COMPRESSION_EXCLUDE_TYPES replaces the default list rather than adding to it
+func ConfigFromEnv() Config {
+	cfg := Config{Enabled: true, MinBytes: DefaultMinBytes, Level: gzip.DefaultCompression, ExcludeTypes: DefaultExcludeTypes}
+	if raw := os.Getenv("RESPONSE_COMPRESSION"); raw != "" {
+		if enabled, err := strconv.ParseBool(raw); err == nil {
+			cfg.Enabled = enabled
+		} else {
+			log.Printf("compression: ignoring invalid RESPONSE_COMPRESSION %q", raw)
+		}
+	}
+	if raw := os.Getenv("COMPRESSION_MIN_BYTES"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+			cfg.MinBytes = n
+		} else {
+			log.Printf("compression: ignoring invalid COMPRESSION_MIN_BYTES %q", raw)
+		}
+	}
+	if raw := os.Getenv("COMPRESSION_LEVEL"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= gzip.BestSpeed && n <= gzip.BestCompression {
+			cfg.Level = n
+		} else {
+			log.Printf("compression: ignoring invalid COMPRESSION_LEVEL %q", raw)
+		}
+	}
+	if raw, ok := os.LookupEnv("COMPRESSION_EXCLUDE_TYPES"); ok {
+		cfg.ExcludeTypes = splitList(raw)
+	}
+	cfg.ExcludePaths = splitList(os.Getenv("COMPRESSION_EXCLUDE_PATHS"))
+	return cfg
+}
+
+// splitList splits a comma-separated setting, dropping blank entries
+func splitList(raw string) []string {
+	var out []string
+	for _, entry := range strings.Split(raw, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// AcceptsGzip reports whether an Accept-Encoding header allows a gzip response
+// gzip is allowed when it is listed with a non-zero q value, or not listed while * is
+func AcceptsGzip(header string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		switch coding {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+// Compressor gzips responses for clients that accept it
+type Compressor struct {
+	cfg     Config
+	writers sync.Pool
+	sent    *metrics.Counter
+	raw     *metrics.Counter
+	packed  *metrics.Counter
+}
+
+// New returns a compressor and registers its counters
+func New(cfg Config) *Compressor {
+	if cfg.Level == 0 {
+		cfg.Level = gzip.DefaultCompression
+	}
+	c := &Compressor{
+		cfg:    cfg,
+		sent:   metrics.NewCounter("http_responses_compressed_total", "Responses sent gzip-compressed"),
+		raw:    metrics.NewCounter(`http_compression_bytes_total{stage="raw"}`, "Body bytes of compressed responses, before and after compression"),
+		packed: metrics.NewCounter(`http_compression_bytes_total{stage="sent"}`, "Body bytes of compressed responses, before and after compression"),
+	}
+	c.writers.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, cfg.Level) // The level was validated by ConfigFromEnv
+		return w
+	}
+	return c
+}
+
+// Middleware compresses response bodies when the client accepts gzip and the body is at least MinBytes long
+// Bodies are held back until that size is reached, so short responses keep their Content-Length. A handler that
+// flushes is streaming, so its response is compressed from the first flush whatever its size. Responses that
+// already carry a Content-Encoding, partial content, HEAD requests and excluded types and paths pass through
+func (c *Compressor) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !c.cfg.Enabled || ctx.Request.Method == http.MethodHead || !AcceptsGzip(ctx.GetHeader("Accept-Encoding")) ||
+			hasPrefix(ctx.Request.URL.Path, c.cfg.ExcludePaths) {
+			ctx.Next()
+			return
+		}
+
+		w := &writer{ResponseWriter: ctx.Writer, c: c}
+		ctx.Writer = w
+		defer w.finish()
+		ctx.Next()
+	}
+}
+
+// hasPrefix reports whether s starts with any of the prefixes
+func hasPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// writer holds back a response body until it knows whether to compress it
+type writer struct {
+	gin.ResponseWriter
+	c         *Compressor
+	buf       bytes.Buffer
+	decided   bool
+	gz        *gzip.Writer
+	counted   *countingWriter
+	raw       int64
+	headerNow bool // The handler asked for the headers to be sent before any body
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.buf.Write(p)
+		if w.buf.Len() < w.c.cfg.MinBytes {
+			return len(p), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if w.gz != nil {
+		w.raw += int64(len(p))
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *writer) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// WriteHeaderNow is deferred until the body is decided; the status is already recorded by WriteHeader
+func (w *writer) WriteHeaderNow() {
+	if w.decided {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	w.headerNow = true
+}
+
+// Written reports a body held back as written, as it will be
+func (w *writer) Written() bool {
+	return w.ResponseWriter.Written() || w.buf.Len() > 0 || w.headerNow
+}
+
+// Flush starts compressing whatever has been written so far, then pushes it to the client
+func (w *writer) Flush() {
+	if !w.decided {
+		if err := w.decide(true); err != nil {
+			return
+		}
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Hijack hands over the connection as it is, with nothing held back
+func (w *writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.decided = true
+	return w.ResponseWriter.Hijack()
+}
+
+// decide commits the headers and sends the held-back body, compressed if compress is set and the response allows it
+func (w *writer) decide(compress bool) error {
+	w.decided = true
+	header := w.Header()
+	status := w.Status()
+	if compress && w.compressible(header, status) {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+		addVary(header)
+		w.ResponseWriter.WriteHeaderNow()
+		w.counted = &countingWriter{w: w.ResponseWriter}
+		w.gz = w.c.writers.Get().(*gzip.Writer)
+		w.gz.Reset(w.counted)
+		w.c.sent.Inc()
+	} else if w.typeAllowed(header) {
+		addVary(header)
+	}
+
+	if w.buf.Len() == 0 {
+		if w.headerNow || w.gz != nil {
+			w.ResponseWriter.WriteHeaderNow()
+		}
+		return nil
+	}
+	body := w.buf.Bytes()
+	w.buf = bytes.Buffer{}
+	if w.gz != nil {
+		w.raw += int64(len(body))
+		_, err := w.gz.Write(body)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(body)
+	return err
+}
+
+// compressible reports whether a response with these headers and status may be gzipped
+func (w *writer) compressible(header http.Header, status int) bool {
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified ||
+		status == http.StatusPartialContent {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	return w.typeAllowed(header)
+}
+
+// typeAllowed reports whether the response's content type is not excluded
+func (w *writer) typeAllowed(header http.Header) bool {
+	contentType := strings.ToLower(header.Get("Content-Type"))
+	return !hasPrefix(contentType, w.c.cfg.ExcludeTypes)
+}
+
+// addVary tells caches the response depends on Accept-Encoding, joining any Vary already set into one line
+func addVary(header http.Header) {
+	values := header.Values("Vary")
+	for _, v := range values {
+		if strings.Contains(strings.ToLower(v), "accept-encoding") {
+			return
+		}
+	}
+	header.Set("Vary", strings.Join(append(values, "Accept-Encoding"), ", "))
+}
+
+// finish sends a body still held back uncompressed, since it never reached MinBytes, or ends the gzip stream
+// A v2 request re-dispatched to v1 resets the context's writer, so the outer writer sees nothing and does nothing
+func (w *writer) finish() {
+	if !w.decided {
+		if w.buf.Len() == 0 && !w.headerNow {
+			return
+		}
+		w.decide(false)
+		return
+	}
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	w.c.raw.Add(w.raw)
+	w.c.packed.Add(w.counted.n)
+	w.gz.Reset(io.Discard)
+	w.c.writers.Put(w.gz)
+	w.gz = nil
+}
@@ -0,0 +1,153 @@
+package consent
+
+import (
+	"banking-app/models"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Scopes a customer can grant; each covers read access only
+const (
+	ScopeAccounts     = "accounts:read"     // Customer profile and account details
+	ScopeBalances     = "balances:read"     // Account balances
+	ScopeTransactions = "transactions:read" // Transaction history and activity
+	ScopeStatements   = "statements:read"   // Monthly statements
+)
+
+// ScopeCertificates is a service scope: it reaches no customer's data, so it needs no consent
+const ScopeCertificates = "certificates:verify" // Balance certificate verification
+
+// Scopes lists every scope a customer can grant
+var Scopes = []string{ScopeAccounts, ScopeBalances, ScopeTransactions, ScopeStatements}
+
+// ServiceScopes lists the scopes a client holds on its own behalf
+var ServiceScopes = []string{ScopeCertificates}
+
+// Consent statuses, derived from the timestamps
+const (
+	StatusActive  = "active"
+	StatusExpired = "expired"
+	StatusRevoked = "revoked"
+)
+
+// MaxDuration is the longest a consent may last before the customer has to grant it again
+const MaxDuration = 90 * 24 * time.Hour
+
+// Consent errors - handlers map these to client responses
+var (
+	ErrNoConsent = errors.New("no active consent covers this data")
+	ErrRevoked   = errors.New("consent is already revoked")
+)
+
+// Subjects are the kinds of record a route's :id names
+const (
+	SubjectCustomer = "customer"
+	SubjectAccount  = "account"
+	SubjectNone     = "" // Service routes
+)
+
+// Rule is what a third-party read of one route needs: the scope, and which customer's data it is
+type Rule struct {
+	Scope   string
+	Subject string // SubjectCustomer, SubjectAccount or SubjectNone
+}
+
+// rules maps the routes third parties may read onto their scope; every other route is closed to them
+var rules = map[string]Rule{
+	"/api/v1/customers/:id":                         {ScopeAccounts, SubjectCustomer},
+	"/api/v1/accounts/:id":                          {ScopeAccounts, SubjectAccount},
+	"/api/v1/accounts/:id/balance":                  {ScopeBalances, SubjectAccount},
+	"/api/v1/accounts/:id/transactions":             {ScopeTransactions, SubjectAccount},
+	"/api/v1/accounts/:id/activity":                 {ScopeTransactions, SubjectAccount},
+	"/api/v1/accounts/:id/statements":               {ScopeStatements, SubjectAccount},
+	"/api/v1/accounts/:id/statements/:statementId":  {ScopeStatements, SubjectAccount},
+	"/api/v1/customers/:id/statements/:year/:month": {ScopeStatements, SubjectCustomer},
+	"/api/v1/certificates/verify/:code":             {ScopeCertificates, SubjectNone},
+	"/api/v1/usage":                                 {"", SubjectNone}, // A client's own usage needs no scope
+}
+
+// RouteRule returns the rule for a matched route pattern, if third parties may read it
+func RouteRule(route string) (Rule, bool) {
+	rule, ok := rules[route]
+	return rule, ok
+}
+
+// ParseScopes validates a comma- or space-separated scope list against allowed and returns it comma-separated,
+// sorted and without duplicates
+func ParseScopes(raw string, allowed []string) (string, error) {
+	seen := make(map[string]bool)
+	var list []string
+	for _, scope := range strings.Fields(strings.ReplaceAll(raw, ",", " ")) {
+		if seen[scope] {
+			continue
+		}
+		if !Contains(allowed, scope) {
+			return "", fmt.Errorf("unknown scope %q; scopes are %s", scope, strings.Join(allowed, ", "))
+		}
+		seen[scope] = true
+		list = append(list, scope)
+	}
+	if len(list) == 0 {
+		return "", errors.New("at least one scope is required")
+	}
+	sort.Strings(list)
+	return strings.Join(list, ","), nil
+}
+
+// Contains reports whether a scope list includes scope
+func Contains(list []string, scope string) bool {
+	for _, s := range list {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers reports whether a consent includes a scope
+func Covers(consent models.Consent, scope string) bool {
+	return Contains(strings.Split(consent.Scopes, ","), scope)
+}
+
+// Status returns a consent's status at now
+func Status(consent models.Consent, now time.Time) string {
+	switch {
+	case consent.RevokedAt != nil:
+		return StatusRevoked
+	case !now.Before(consent.ExpiresAt):
+		return StatusExpired
+	}
+	return StatusActive
+}
+
+// Active returns the newest consent from a customer to a client that covers scope and is in force at now
+// It is checked on every request, so revoking or expiry cuts access off at once
+func Active(db *gorm.DB, customerID uint, clientID, scope string, now time.Time) (models.Consent, error) {
+	var consents []models.Consent
+	err := db.Where("customer_id = ? AND granted_to = ? AND revoked_at IS NULL AND expires_at > ?", customerID, clientID, now).
+		Order("id DESC").Find(&consents).Error
+	if err != nil {
+		return models.Consent{}, err
+	}
+	for _, c := range consents {
+		if Covers(c, scope) {
+			return c, nil
+		}
+	}
+	return models.Consent{}, ErrNoConsent
+}
+
+// Revoke ends a consent now
+func Revoke(db *gorm.DB, consent *models.Consent, by string, now time.Time) error {
+	if consent.RevokedAt != nil {
+		return ErrRevoked
+	}
+	consent.RevokedAt = &now
+	consent.RevokedBy = by
+	return db.Model(consent).Updates(map[string]interface{}{"revoked_at": now, "revoked_by": by}).Error
+}
@@ -0,0 +1,344 @@
+package conversions
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/communications"
+	"banking-app/creditlines"
+	"banking-app/display"
+	"banking-app/eligibility"
+	"banking-app/events"
+	"banking-app/flags"
+	"banking-app/gl"
+	"banking-app/interest"
+	"banking-app/ledger"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"banking-app/notifications"
+	"banking-app/tenancy"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Product change statuses
+const (
+	StatusScheduled = "scheduled"
+	StatusApplied   = "applied"
+	StatusCancelled = "cancelled"
+)
+
+// standardTypes open without a catalog entry; each is its own category
+var standardTypes = []string{"checking", "savings"}
+
+// reservedTypes belong to the APIs that open them, so their accounts never change product
+var reservedTypes = []string{"loan", creditlines.AccountType, gl.AccountType}
+
+// Conversion errors - handlers map these to client responses
+var (
+	ErrClosed       = errors.New("closed accounts cannot change product")
+	ErrReserved     = errors.New("loan, credit line and internal accounts cannot change product, nor can accounts become one")
+	ErrSameProduct  = errors.New("account is already on that product")
+	ErrUnknown      = errors.New("product is not in the catalog")
+	ErrDeprecated   = errors.New("product is deprecated and takes no new accounts")
+	ErrPending      = errors.New("account already has a product change scheduled")
+	ErrNotScheduled = errors.New("only scheduled product changes can be cancelled")
+)
+
+// IncompatibleError refuses a conversion between products of different categories
+type IncompatibleError struct {
+	From, To string // Categories
+}
+
+func (e *IncompatibleError) Error() string {
+	return fmt.Sprintf("a %s account cannot become a %s product", e.From, e.To)
+}
+
+// Category returns the family a product's accounts convert within: its category, or its account type when it has none
+func Category(p models.Product) string {
+	if p.Category != "" {
+		return p.Category
+	}
+	return p.AccountType
+}
+
+// ValidateProduct checks the conversion parameters of a catalog product, trimming its category; a standard type
+// is its own category
+func ValidateProduct(p *models.Product) error {
+	p.Category = strings.ToLower(strings.TrimSpace(p.Category))
+	if contains(standardTypes, p.AccountType) && p.Category != "" && p.Category != p.AccountType {
+		return fmt.Errorf("category of the %s product must be %s", p.AccountType, p.AccountType)
+	}
+	if p.MonthlyFee < 0 {
+		return errors.New("monthly_fee must not be negative")
+	}
+	if p.OverdraftLimit != nil && *p.OverdraftLimit < 0 {
+		return errors.New("overdraft_limit must not be negative")
+	}
+	return nil
+}
+
+// Product returns the product an account type is on: its catalog entry, or a bare product for a standard type or
+// one the catalog no longer lists
+func Product(db *gorm.DB, accountType string) (models.Product, bool, error) {
+	product, err := eligibility.Lookup(db, accountType)
+	if err != nil {
+		return models.Product{}, false, err
+	}
+	if product == nil {
+		return models.Product{AccountType: accountType, Name: accountType}, false, nil
+	}
+	return *product, true, nil
+}
+
+// Target returns the product an account may be converted to, checking it is open and in the same category as the
+// account's current product
+func Target(db *gorm.DB, account models.Account, accountType string) (models.Product, error) {
+	if account.Status == "closed" {
+		return models.Product{}, ErrClosed
+	}
+	if contains(reservedTypes, account.AccountType) || contains(reservedTypes, accountType) {
+		return models.Product{}, ErrReserved
+	}
+	if account.AccountType == accountType {
+		return models.Product{}, ErrSameProduct
+	}
+	target, listed, err := Product(db, accountType)
+	if err != nil {
+		return target, err
+	}
+	if !listed && !contains(standardTypes, accountType) {
+		return target, ErrUnknown
+	}
+	if target.Deprecated {
+		return target, ErrDeprecated
+	}
+	current, _, err := Product(db, account.AccountType)
+	if err != nil {
+		return target, err
+	}
+	if Category(current) != Category(target) {
+		return target, &IncompatibleError{From: Category(current), To: Category(target)}
+	}
+	return target, nil
+}
+
+// Request is a conversion of one account to a target product
+type Request struct {
+	NextCycle       bool   // From the first day of the next statement cycle rather than at once
+	Reason          string // Kept on the change
+	RequestedBy     string
+	BulkOperationID *uint
+}
+
+// Convert moves an account to a target product Target has approved, at once or from the next statement cycle,
+// and tells the holder. An account has at most one change scheduled. db should be a transaction, so a failure
+// leaves the account as it was
+func Convert(db *gorm.DB, featureFlags *flags.Store, account models.Account, target models.Product, req Request, now time.Time) (models.ProductChange, error) {
+	var pending int64
+	if err := db.Model(&models.ProductChange{}).Where("account_id = ? AND status = ?", account.ID, StatusScheduled).Count(&pending).Error; err != nil {
+		return models.ProductChange{}, err
+	}
+	if pending > 0 {
+		return models.ProductChange{}, ErrPending
+	}
+	current, _, err := Product(db, account.AccountType)
+	if err != nil {
+		return models.ProductChange{}, err
+	}
+	change := models.ProductChange{
+		AccountID:       account.ID,
+		CustomerID:      account.CustomerID,
+		ToType:          target.AccountType,
+		Status:          StatusScheduled,
+		EffectiveDate:   ledger.StartOfDay(now),
+		Reason:          req.Reason,
+		RequestedBy:     req.RequestedBy,
+		BulkOperationID: req.BulkOperationID,
+	}
+	if req.NextCycle {
+		// Statements cover calendar months, so the next cycle starts on the first of next month
+		change.EffectiveDate = businessdays.StartOfMonth(now).AddDate(0, 1, 0)
+	}
+	prepare(&change, account, current, target)
+	if err := db.Create(&change).Error; err != nil {
+		return change, err
+	}
+	if req.NextCycle {
+		return change, notify(db, account, current, target, change)
+	}
+	return change, apply(db, featureFlags, account, current, target, &change, now)
+}
+
+// prepare sets a change's parameters before and after from the account and the two products
+func prepare(change *models.ProductChange, account models.Account, current, target models.Product) {
+	change.FromType = account.AccountType
+	change.PreviousOverdraftLimit, change.OverdraftLimit = account.OverdraftLimit, account.OverdraftLimit
+	if target.OverdraftLimit != nil {
+		change.OverdraftLimit = *target.OverdraftLimit
+	}
+	change.PreviousMonthlyFee, change.MonthlyFee = current.MonthlyFee, target.MonthlyFee
+	change.PreviousFeeShare, change.FeeShare = shares(account, *change)
+}
+
+// apply changes an account's product. Interest is first accrued through yesterday at the old product's rates, and
+// the days before are marked accrued, so the new product's rates only apply from today
+func apply(db *gorm.DB, featureFlags *flags.Store, account models.Account, current, target models.Product, change *models.ProductChange, now time.Time) error {
+	today := ledger.StartOfDay(now)
+	if err := interest.AccrueAccount(db, featureFlags, account, now); err != nil {
+		return err
+	}
+	if err := db.First(&account, account.ID).Error; err != nil {
+		return err
+	}
+	updates := map[string]interface{}{
+		"account_type":                               target.AccountType,
+		"overdraft_limit":                            change.OverdraftLimit,
+		"restriction_deposit_only":                   target.Restrictions.DepositOnly,
+		"restriction_no_outbound_transfers":          target.Restrictions.NoOutboundTransfers,
+		"restriction_staff_approval_for_withdrawals": target.Restrictions.StaffApprovalForWithdrawals,
+		"version": account.Version + 1,
+	}
+	if account.InterestAccruedThrough == nil || account.InterestAccruedThrough.Before(today) {
+		updates["interest_accrued_through"] = today
+	}
+	if err := db.Model(&account).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	applied := clock.Now()
+	change.Status, change.AppliedAt = StatusApplied, &applied
+	if err := db.Model(change).Updates(map[string]interface{}{"status": change.Status, "applied_at": applied}).Error; err != nil {
+		return err
+	}
+	err := events.Record(db, events.AggregateAccount, account.ID, events.AccountConverted, map[string]interface{}{
+		"account_id":        account.ID,
+		"account_number":    account.AccountNumber,
+		"product_change_id": change.ID,
+		"from_type":         change.FromType,
+		"to_type":           change.ToType,
+		"reason":            change.Reason,
+		"changed_by":        change.RequestedBy,
+	})
+	if err != nil {
+		return err
+	}
+	return notify(db, account, current, target, *change)
+}
+
+// ApplyDue applies scheduled changes whose effective date has come, an end-of-day step after interest accrual
+// It runs without a tenant context, so each change is applied scoped to its own tenant. The parameters are taken
+// from the products as they are then; a change the account no longer qualifies for, e.g. because it was closed or
+// the product was deprecated, is cancelled with the reason
+func ApplyDue(db *gorm.DB, featureFlags *flags.Store, now time.Time) (int, error) {
+	var due []models.ProductChange
+	err := db.Where("status = ? AND effective_date <= ?", StatusScheduled, ledger.StartOfDay(now)).Order("effective_date, id").Find(&due).Error
+	if err != nil {
+		return 0, err
+	}
+	applied := 0
+	for i := range due {
+		change := &due[i]
+		scoped := db.WithContext(tenancy.NewContext(db.Statement.Context, change.TenantID))
+		err := scoped.Transaction(func(tx *gorm.DB) error {
+			var account models.Account
+			if err := tx.First(&account, change.AccountID).Error; err != nil {
+				return err
+			}
+			target, err := Target(tx, account, change.ToType)
+			if refused(err) {
+				return cancel(tx, change, err.Error())
+			}
+			if err != nil {
+				return err
+			}
+			current, _, err := Product(tx, account.AccountType)
+			if err != nil {
+				return err
+			}
+			prepare(change, account, current, target)
+			if err := tx.Save(change).Error; err != nil {
+				return err
+			}
+			return apply(tx, featureFlags, account, current, target, change, now)
+		})
+		if err != nil {
+			log.Printf("conversions: product change %d failed: %v", change.ID, err)
+			continue
+		}
+		if change.Status == StatusApplied {
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+// Cancel withdraws a scheduled change before it takes effect
+func Cancel(db *gorm.DB, change *models.ProductChange, note string) error {
+	if !lifecycle.Allows(lifecycle.ProductChange, change.Status, StatusCancelled) {
+		return ErrNotScheduled
+	}
+	return cancel(db, change, note)
+}
+
+// refused reports whether err is Target refusing a conversion rather than a failure to check it
+func refused(err error) bool {
+	var incompatible *IncompatibleError
+	if errors.As(err, &incompatible) {
+		return true
+	}
+	for _, e := range []error{ErrClosed, ErrReserved, ErrSameProduct, ErrUnknown, ErrDeprecated} {
+		if err == e {
+			return true
+		}
+	}
+	return false
+}
+
+// cancel marks a scheduled change cancelled with why
+func cancel(db *gorm.DB, change *models.ProductChange, note string) error {
+	change.Status, change.Note = StatusCancelled, note
+	return db.Model(change).Updates(map[string]interface{}{"status": change.Status, "note": note}).Error
+}
+
+// notify tells the account holder of the change by email; holders without a verified email are logged instead
+func notify(db *gorm.DB, account models.Account, current, target models.Product, change models.ProductChange) error {
+	var customer models.Customer
+	if err := db.First(&customer, account.CustomerID).Error; err != nil {
+		return err
+	}
+	if customer.Email == "" || !customer.EmailVerified {
+		log.Printf("conversions: customer %d has no verified email for the product change %d", customer.ID, change.ID)
+		return nil
+	}
+	when := "is now"
+	if change.Status == StatusScheduled {
+		when = "becomes on " + businessdays.Format(change.EffectiveDate)
+	}
+	body := fmt.Sprintf("Your %s account %s %s a %s account. Its monthly maintenance fee is %s; in the month of the "+
+		"change you are charged each product's fee for the days the account is on it.", current.Name,
+		display.MaskAccountNumber(account.AccountNumber), when, target.Name, display.Amount(change.MonthlyFee, account.Currency))
+	return notifications.Enqueue(db, &models.Notification{
+		CustomerID:   customer.ID,
+		Channel:      "email",
+		ResourceType: communications.ResourceProductChange,
+		ResourceID:   change.ID,
+		Recipient:    customer.Email,
+		Subject:      "Your account is changing to " + target.Name,
+		Body:         body,
+	})
+}
+
+// contains reports whether list holds s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package conversions
+
+import (
+	"banking-app/businessdays"
+	"banking-app/cache"
+	"banking-app/enrichment"
+	"banking-app/flags"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/money"
+	"banking-app/relationship"
+	"banking-app/tenancy"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// shares splits the maintenance fee of a change's effective month by the days the account is on each product
+// Days before the account opened are not charged
+func shares(account models.Account, change models.ProductChange) (previous, next float64) {
+	month := businessdays.StartOfMonth(change.EffectiveDate)
+	end := month.AddDate(0, 1, 0)
+	days := businessdays.Days(month, end)
+	from := month
+	if opened := ledger.StartOfDay(account.CreatedAt); opened.After(from) {
+		from = opened
+	}
+	before, after := 0, 0
+	if change.EffectiveDate.After(from) {
+		before = businessdays.Days(from, change.EffectiveDate)
+		after = businessdays.Days(change.EffectiveDate, end)
+	} else {
+		after = businessdays.Days(from, end)
+	}
+	return money.Cents(change.PreviousMonthlyFee * float64(before) / float64(days)), money.Cents(change.MonthlyFee * float64(after) / float64(days))
+}
+
+// segment is a run of days in a month an account spent on one product
+type segment struct {
+	accountType string
+	days        int
+}
+
+// ChargeMaintenance charges last month's maintenance fee to every open account that was on a product with one, an
+// end-of-day step after product changes are applied. An account that changed product during the month pays each
+// product's fee, at its rate when charged, for the days it was on it; days before the account opened are free.
+// The fee is effective at the end of the month, so it is on that month's statement, and the holder's relationship
+// tier may waive it. Safe to run repeatedly: an account is charged for a month once, and one that could not be
+// charged, e.g. for lack of funds, is retried on the next run. Each fee is written through to the balance cache
+func ChargeMaintenance(db *gorm.DB, featureFlags *flags.Store, balances *cache.Balances, now time.Time) (int, error) {
+	month := businessdays.StartOfMonth(now).AddDate(0, -1, 0)
+	end := month.AddDate(0, 1, 0)
+
+	// Accounts on a product with a fee, and those whose product changed since the month began
+	var products []models.Product
+	if err := db.Where("monthly_fee > 0").Find(&products).Error; err != nil {
+		return 0, err
+	}
+	var changed []uint
+	if err := db.Model(&models.ProductChange{}).Where("status = ? AND effective_date >= ?", StatusApplied, month).Distinct().Pluck("account_id", &changed).Error; err != nil {
+		return 0, err
+	}
+	candidates := map[uint]models.Account{}
+	add := func(query *gorm.DB) error {
+		var accounts []models.Account
+		if err := query.Where("status <> ? AND created_at < ?", "closed", end).Find(&accounts).Error; err != nil {
+			return err
+		}
+		for _, a := range accounts {
+			candidates[a.ID] = a
+		}
+		return nil
+	}
+	for _, p := range products {
+		scoped := db.WithContext(tenancy.NewContext(db.Statement.Context, p.TenantID))
+		if err := add(scoped.Where("account_type = ?", p.AccountType)); err != nil {
+			return 0, err
+		}
+	}
+	if len(changed) > 0 {
+		if err := add(db.Where("id IN ?", changed)); err != nil {
+			return 0, err
+		}
+	}
+
+	charged := 0
+	for _, account := range candidates {
+		scoped := db.WithContext(tenancy.NewContext(db.Statement.Context, account.TenantID))
+		ok, err := chargeAccount(scoped, featureFlags, balances, account, month)
+		if err != nil {
+			log.Printf("conversions: maintenance fee for account %s failed: %v", account.AccountNumber, err)
+			continue
+		}
+		if ok {
+			charged++
+		}
+	}
+	return charged, nil
+}
+
+// chargeAccount charges one account's fee for a month, reporting whether a fee was posted
+func chargeAccount(db *gorm.DB, featureFlags *flags.Store, balances *cache.Balances, account models.Account, month time.Time) (bool, error) {
+	end := month.AddDate(0, 1, 0)
+	reference := fmt.Sprintf("MAINT-%s-%s", account.AccountNumber, businessdays.In(month).Format("200601"))
+	var posted int64
+	if err := db.Model(&models.Transaction{}).Where("account_id = ? AND reference = ?", account.ID, reference).Count(&posted).Error; err != nil {
+		return false, err
+	}
+	if posted > 0 {
+		return false, nil
+	}
+
+	segments, err := monthSegments(db, account, month)
+	if err != nil {
+		return false, err
+	}
+	days := businessdays.Days(month, end)
+	amount := 0.0
+	var parts []string
+	for _, s := range segments {
+		product, _, err := Product(db, s.accountType)
+		if err != nil {
+			return false, err
+		}
+		if product.MonthlyFee <= 0 {
+			continue
+		}
+		amount = money.Cents(amount + money.Cents(product.MonthlyFee*float64(s.days)/float64(days)))
+		parts = append(parts, fmt.Sprintf("%s %d days", product.Name, s.days))
+	}
+	if amount <= 0 {
+		return false, nil
+	}
+	lastSecond := end.Add(-time.Second)
+	if waived, err := relationship.WaivesFees(db, account, lastSecond); err != nil || waived {
+		return false, err
+	}
+
+	description := "Monthly maintenance fee for " + businessdays.In(month).Format("January 2006")
+	if len(segments) > 1 || segments[0].days < days {
+		description += " (" + strings.Join(parts, ", ") + ")"
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		account, err = ledger.PostExternal(tx, &models.Transaction{
+			AccountID:       account.ID,
+			TransactionType: ledger.TypeFee,
+			Amount:          amount,
+			Description:     description,
+			Reference:       reference,
+			Channel:         enrichment.DefaultChannel,
+			EffectiveDate:   lastSecond,
+		}, featureFlags)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	balances.Set(cache.Entry(account))
+	return true, nil
+}
+
+// monthSegments returns the products an account was on in a month with the days on each, latest first, by
+// walking its applied product changes back from its current product
+func monthSegments(db *gorm.DB, account models.Account, month time.Time) ([]segment, error) {
+	var changes []models.ProductChange
+	err := db.Where("account_id = ? AND status = ? AND effective_date >= ?", account.ID, StatusApplied, month).
+		Order("effective_date DESC, id DESC").Find(&changes).Error
+	if err != nil {
+		return nil, err
+	}
+	start := month
+	if opened := ledger.StartOfDay(account.CreatedAt); opened.After(start) {
+		start = opened
+	}
+	until := month.AddDate(0, 1, 0)
+	accountType := account.AccountType
+	var segments []segment
+	for _, c := range changes {
+		if !c.EffectiveDate.Before(until) {
+			// Changed after the month; before the change it was on the old product
+			accountType = c.FromType
+			continue
+		}
+		if !c.EffectiveDate.After(start) {
+			break
+		}
+		segments = append(segments, segment{accountType: accountType, days: businessdays.Days(c.EffectiveDate, until)})
+		until, accountType = c.EffectiveDate, c.FromType
+	}
+	if until.After(start) {
+		segments = append(segments, segment{accountType: accountType, days: businessdays.Days(start, until)})
+	}
+	return segments, nil
+}
@@ -0,0 +1,57 @@
+package creditbureau
+
+import (
+	"banking-app/clock"
+	"banking-app/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Cached reuses a customer's stored report while it is younger than TTL and stores each new pull
+// Failures are not stored, so the next application asks the bureau again
+type Cached struct {
+	Bureau Bureau
+	DB     *gorm.DB
+	TTL    time.Duration
+}
+
+// Check returns a fresh stored report or pulls a new one
+func (c *Cached) Check(ctx context.Context, customer models.Customer) (Report, error) {
+	db := c.DB.WithContext(ctx)
+	var stored models.CreditReport
+	err := db.Where("customer_id = ? AND pulled_at > ?", customer.ID, clock.Now().Add(-c.TTL)).Order("pulled_at DESC").First(&stored).Error
+	if err == nil {
+		report := Report{Score: stored.Score, ReportID: stored.ReportID, PulledAt: stored.PulledAt, Cached: true, Tradelines: []Tradeline{}}
+		if err := json.Unmarshal([]byte(stored.Tradelines), &report.Tradelines); err != nil {
+			report.Tradelines = []Tradeline{}
+		}
+		return report, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return Report{}, err
+	}
+
+	report, err := c.Bureau.Check(ctx, customer)
+	if err != nil {
+		return report, err
+	}
+	tradelines, err := json.Marshal(report.Tradelines)
+	if err != nil {
+		return report, err
+	}
+	stored = models.CreditReport{
+		TenantID:   customer.TenantID,
+		CustomerID: customer.ID,
+		ReportID:   report.ReportID,
+		Score:      report.Score,
+		Tradelines: string(tradelines),
+		PulledAt:   report.PulledAt,
+	}
+	// A report that cannot be stored is still used; it is pulled again next time
+	db.Create(&stored)
+	return report, nil
+}
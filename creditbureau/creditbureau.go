@@ -0,0 +1,208 @@
+package creditbureau
+
+import (
+	"banking-app/models"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Bureau modes
+const (
+	ModeOff  = "off"  // No credit checks (default)
+	ModeStub = "stub" // Configured scores, for development and tests
+	ModeHTTP = "http" // A bureau's HTTP endpoint
+)
+
+// Credit decisions recorded on a loan
+const (
+	DecisionApproved = "approved"
+	DecisionDeclined = "declined"
+	DecisionManual   = "manual_review" // Waiting for a staff decision
+)
+
+// ErrUnavailable is returned when the bureau cannot be reached or answers with an error
+var ErrUnavailable = errors.New("credit bureau unavailable")
+
+// Tradeline is one credit account on a customer's report
+type Tradeline struct {
+	Creditor string  `json:"creditor"`
+	Type     string  `json:"type"` // e.g. mortgage, card, auto
+	Balance  float64 `json:"balance"`
+	Limit    float64 `json:"limit,omitempty"`
+	Status   string  `json:"status"` // e.g. current, late, closed
+}
+
+// Report is the result of a soft credit pull
+type Report struct {
+	Score      int         `json:"score"`
+	ReportID   string      `json:"report_id"`
+	Tradelines []Tradeline `json:"tradelines"`
+	PulledAt   time.Time   `json:"pulled_at"`
+	Cached     bool        `json:"cached"` // Served from an earlier pull rather than the bureau
+}
+
+// Bureau pulls soft credit reports; a soft pull does not affect the customer's score
+type Bureau interface {
+	Check(ctx context.Context, customer models.Customer) (Report, error)
+}
+
+// Config controls credit checks and the score cutoffs applied to them
+type Config struct {
+	Mode         string
+	DeclineBelow int // Scores below this are declined automatically
+	ApproveAbove int // Scores above this are approved automatically; those in between go to manual review
+
+	URL      string        // HTTP mode: the bureau's check endpoint
+	APIKey   string        // HTTP mode: sent as a bearer token
+	Timeout  time.Duration // HTTP mode: per attempt
+	Retries  int           // HTTP mode: further attempts after a failure
+	CacheFor time.Duration // HTTP mode: how long a customer's report is reused
+
+	StubScores  map[string]int // Stub mode: score by customer ID or email; Unavailable simulates an outage
+	StubDefault int            // Stub mode: score for everyone else
+}
+
+// Unavailable is the stub score that makes the bureau fail, written "unavailable" in CREDIT_BUREAU_STUB_SCORES
+const Unavailable = -1
+
+// ConfigFromEnv reads CREDIT_BUREAU (off, stub or http; default off), CREDIT_SCORE_DECLINE_BELOW (default 580),
+// CREDIT_SCORE_APPROVE_ABOVE (default 700), CREDIT_BUREAU_URL, CREDIT_BUREAU_API_KEY,
+// CREDIT_BUREAU_TIMEOUT_SECONDS (default 5), CREDIT_BUREAU_RETRIES (default 2), CREDIT_BUREAU_CACHE_DAYS (default 30),
+// CREDIT_BUREAU_STUB_SCORES ("42=810,jane@example.com=620,joe@example.com=unavailable") and
+// CREDIT_BUREAU_STUB_DEFAULT (default 700)
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Mode:         strings.ToLower(strings.TrimSpace(os.Getenv("CREDIT_BUREAU"))),
+		DeclineBelow: 580,
+		ApproveAbove: 700,
+		URL:          strings.TrimSpace(os.Getenv("CREDIT_BUREAU_URL")),
+		APIKey:       os.Getenv("CREDIT_BUREAU_API_KEY"),
+		Retries:      2,
+		StubScores:   map[string]int{},
+		StubDefault:  700,
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = ModeOff
+	}
+	if cfg.Mode != ModeOff && cfg.Mode != ModeStub && cfg.Mode != ModeHTTP {
+		return cfg, fmt.Errorf("invalid CREDIT_BUREAU %q: must be off, stub or http", cfg.Mode)
+	}
+
+	timeout, cacheDays := 5, 30
+	settings := []struct {
+		name string
+		into *int
+	}{
+		{"CREDIT_SCORE_DECLINE_BELOW", &cfg.DeclineBelow},
+		{"CREDIT_SCORE_APPROVE_ABOVE", &cfg.ApproveAbove},
+		{"CREDIT_BUREAU_TIMEOUT_SECONDS", &timeout},
+		{"CREDIT_BUREAU_RETRIES", &cfg.Retries},
+		{"CREDIT_BUREAU_CACHE_DAYS", &cacheDays},
+		{"CREDIT_BUREAU_STUB_DEFAULT", &cfg.StubDefault},
+	}
+	for _, setting := range settings {
+		raw := strings.TrimSpace(os.Getenv(setting.name))
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid %s %q: must be a whole number of 0 or more", setting.name, raw)
+		}
+		*setting.into = n
+	}
+	if timeout == 0 {
+		return cfg, errors.New("CREDIT_BUREAU_TIMEOUT_SECONDS must be at least 1")
+	}
+	cfg.Timeout = time.Duration(timeout) * time.Second
+	cfg.CacheFor = time.Duration(cacheDays) * 24 * time.Hour
+	if cfg.DeclineBelow > cfg.ApproveAbove+1 {
+		return cfg, fmt.Errorf("CREDIT_SCORE_DECLINE_BELOW (%d) must not be above CREDIT_SCORE_APPROVE_ABOVE (%d)", cfg.DeclineBelow, cfg.ApproveAbove)
+	}
+
+	if raw := strings.TrimSpace(os.Getenv("CREDIT_BUREAU_STUB_SCORES")); raw != "" {
+		for _, pair := range strings.Split(raw, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+			score, err := strconv.Atoi(value)
+			if strings.EqualFold(value, "unavailable") {
+				score, err = Unavailable, nil
+			}
+			if !ok || key == "" || err != nil || score < Unavailable {
+				return cfg, fmt.Errorf("invalid CREDIT_BUREAU_STUB_SCORES entry %q: want customer=score", pair)
+			}
+			cfg.StubScores[key] = score
+		}
+	}
+	if cfg.Mode == ModeHTTP && cfg.URL == "" {
+		return cfg, errors.New("CREDIT_BUREAU=http needs CREDIT_BUREAU_URL")
+	}
+	return cfg, nil
+}
+
+// Reviewer pulls reports for loan applications and applies the score cutoffs
+// A nil Reviewer, or one without a bureau, leaves applications to the existing checks
+type Reviewer struct {
+	Bureau       Bureau
+	DeclineBelow int
+	ApproveAbove int
+}
+
+// NewReviewer builds the reviewer for a configuration; CREDIT_BUREAU=off gives a disabled one
+func NewReviewer(cfg Config, db *gorm.DB) *Reviewer {
+	r := &Reviewer{DeclineBelow: cfg.DeclineBelow, ApproveAbove: cfg.ApproveAbove}
+	switch cfg.Mode {
+	case ModeStub:
+		r.Bureau = &Stub{Scores: cfg.StubScores, Default: cfg.StubDefault}
+	case ModeHTTP:
+		r.Bureau = &Cached{Bureau: NewHTTP(cfg), DB: db, TTL: cfg.CacheFor}
+	}
+	return r
+}
+
+// Enabled reports whether applications are credit checked
+func (r *Reviewer) Enabled() bool {
+	return r != nil && r.Bureau != nil
+}
+
+// Decide applies the cutoffs to a score
+func (r *Reviewer) Decide(score int) string {
+	switch {
+	case score < r.DeclineBelow:
+		return DecisionDeclined
+	case score > r.ApproveAbove:
+		return DecisionApproved
+	}
+	return DecisionManual
+}
+
+// Review pulls the borrower's report and records the score, report and decision on the loan
+// A bureau failure never blocks the application: it goes to manual review instead. The loan is not saved
+func (r *Reviewer) Review(ctx context.Context, customer models.Customer, loan *models.Loan, now time.Time) {
+	loan.CreditCheckedAt = &now
+	report, err := r.Bureau.Check(ctx, customer)
+	if err != nil {
+		loan.CreditScore, loan.CreditReportID = nil, ""
+		loan.CreditDecision = DecisionManual
+		loan.CreditDecisionReason = ErrUnavailable.Error()
+		return
+	}
+	score := report.Score
+	loan.CreditScore, loan.CreditReportID = &score, report.ReportID
+	loan.CreditDecision = r.Decide(score)
+	switch loan.CreditDecision {
+	case DecisionDeclined:
+		loan.CreditDecisionReason = fmt.Sprintf("score %d is below %d", score, r.DeclineBelow)
+	case DecisionApproved:
+		loan.CreditDecisionReason = fmt.Sprintf("score %d is above %d", score, r.ApproveAbove)
+	default:
+		loan.CreditDecisionReason = fmt.Sprintf("score %d is between %d and %d", score, r.DeclineBelow, r.ApproveAbove)
+	}
+}
@@ -0,0 +1,117 @@
+package creditbureau
+
+import (
+	"banking-app/clock"
+	"banking-app/models"
+	"banking-app/tracing"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HTTP pulls reports from a bureau's JSON endpoint
+// It POSTs the customer's identity and expects {"score", "report_id", "tradelines"} back
+type HTTP struct {
+	URL     string
+	APIKey  string
+	Client  *http.Client
+	Retries int           // Further attempts after a network error, 429 or 5xx
+	Backoff time.Duration // Wait before the first retry, doubled for each one after
+}
+
+// NewHTTP creates a bureau client with the configured per-attempt timeout
+func NewHTTP(cfg Config) *HTTP {
+	return &HTTP{URL: cfg.URL, APIKey: cfg.APIKey, Client: &http.Client{Timeout: cfg.Timeout}, Retries: cfg.Retries, Backoff: 500 * time.Millisecond}
+}
+
+// checkRequest is what the bureau is sent
+type checkRequest struct {
+	CustomerID  uint   `json:"customer_id"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	DateOfBirth string `json:"date_of_birth"`
+	Email       string `json:"email"`
+	Address     string `json:"address"`
+}
+
+// Check pulls the customer's report, retrying transient failures
+// Every failure is reported as ErrUnavailable, wrapping the last cause
+func (h *HTTP) Check(ctx context.Context, customer models.Customer) (report Report, err error) {
+	ctx, span := tracing.Start(ctx, "creditbureau.check", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() { tracing.End(span, err) }()
+
+	body, err := json.Marshal(checkRequest{
+		CustomerID:  customer.ID,
+		FirstName:   customer.FirstName,
+		LastName:    customer.LastName,
+		DateOfBirth: customer.DateOfBirth,
+		Email:       customer.Email,
+		Address:     customer.Address,
+	})
+	if err != nil {
+		return report, err
+	}
+
+	wait := h.Backoff
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		report, retry, err = h.attempt(ctx, body)
+		if err == nil || !retry || attempt >= h.Retries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return report, fmt.Errorf("%w: %v", ErrUnavailable, ctx.Err())
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+	if err != nil {
+		return report, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return report, nil
+}
+
+// attempt makes one request and reports whether a failure is worth retrying
+func (h *HTTP) attempt(ctx context.Context, body []byte) (Report, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return Report{}, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.APIKey)
+	}
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return Report{}, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return Report{}, true, fmt.Errorf("bureau returned status %d", resp.StatusCode)
+	}
+	if resp.StatusCode >= 300 {
+		return Report{}, false, fmt.Errorf("bureau returned status %d", resp.StatusCode)
+	}
+
+	var report Report
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&report); err != nil {
+		return Report{}, false, fmt.Errorf("bureau response: %w", err)
+	}
+	if report.ReportID == "" || report.Score <= 0 {
+		return Report{}, false, fmt.Errorf("bureau response has no score or report id")
+	}
+	if report.Tradelines == nil {
+		report.Tradelines = []Tradeline{}
+	}
+	report.PulledAt, report.Cached = clock.Now(), false
+	return report, false, nil
+}
@@ -0,0 +1,391 @@
+package creditlines
+
+import (
+	"banking-app/businessdays"
+	"banking-app/enrichment"
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/lifecycle"
+	"banking-app/loans"
+	"banking-app/models"
+	"banking-app/money"
+	"banking-app/statushistory"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Line statuses
+const (
+	StatusPending  = loans.StatusPending
+	StatusDeclined = loans.StatusDeclined
+	StatusActive   = "active"
+	StatusClosed   = "closed"
+)
+
+// AccountType marks the accounts that carry a line's drawn amount
+const AccountType = "credit_line"
+
+// RepaymentMonths is the repayment period assumed when checking a line's affordability
+const RepaymentMonths = 12
+
+// coveredTypes are the customer debits a line covers; bank charges never draw on it
+var coveredTypes = map[string]bool{"withdrawal": true, "payment": true, "transfer": true}
+
+// Line errors - handlers map these to client responses
+var (
+	ErrInvalidTerms  = errors.New("credit_limit must be positive and interest_rate between 0 and 1")
+	ErrLinkedAccount = errors.New("lines of credit can only be linked to an active checking account")
+	ErrAlreadyLinked = errors.New("account already has a line of credit")
+	ErrNotPending    = errors.New("line of credit has already been opened")
+	ErrNotActive     = errors.New("line of credit is not active")
+	ErrOutstanding   = errors.New("line of credit must be fully repaid before it is closed")
+)
+
+// Utilization describes how much of a line is in use
+type Utilization struct {
+	Drawn              float64 `json:"drawn"`
+	AvailableCredit    float64 `json:"available_credit"`
+	UtilizationPercent float64 `json:"utilization_percent"`
+}
+
+// MonthlyPayment is the payment a fully drawn line would need to be repaid over RepaymentMonths
+// It stands in for the line in debt-to-income checks
+func MonthlyPayment(limit, rate float64) float64 {
+	return money.Cents(limit/RepaymentMonths + limit*rate/12)
+}
+
+// Apply records a pending line for a checking account inside an open transaction
+// The backing loan takes the line number and carries the borrower party; co-borrowers and guarantors
+// are added to it as for any pending loan
+func Apply(tx *gorm.DB, account models.Account, limit, rate float64, lineNumber, by string) (models.CreditLine, error) {
+	line := models.CreditLine{
+		TenantID:        account.TenantID,
+		LineNumber:      lineNumber,
+		CustomerID:      account.CustomerID,
+		LinkedAccountID: account.ID,
+		CreditLimit:     limit,
+		InterestRate:    rate,
+		Status:          StatusPending,
+	}
+	if limit <= 0 || rate < 0 || rate > 1 {
+		return line, ErrInvalidTerms
+	}
+	if account.AccountType != "checking" || account.Status != "active" {
+		return line, ErrLinkedAccount
+	}
+	var linked int64
+	if err := tx.Model(&models.CreditLine{}).Where("linked_account_id = ? AND status NOT IN ?", account.ID, []string{StatusClosed, StatusDeclined}).Count(&linked).Error; err != nil {
+		return line, err
+	}
+	if linked > 0 {
+		return line, ErrAlreadyLinked
+	}
+
+	loan := models.Loan{
+		TenantID:        account.TenantID,
+		LoanNumber:      lineNumber,
+		CustomerID:      account.CustomerID,
+		PrincipalAmount: limit,
+		InterestRate:    rate,
+		LoanTerm:        RepaymentMonths,
+		Status:          loans.StatusPending,
+		MonthlyPayment:  MonthlyPayment(limit, rate),
+	}
+	if err := tx.Create(&loan).Error; err != nil {
+		return line, err
+	}
+	if err := statushistory.Created(tx, loan.TenantID, statushistory.SubjectLoan, loan.ID, loan.Status, by); err != nil {
+		return line, err
+	}
+	if err := loans.AddBorrower(tx, loan, by); err != nil {
+		return line, err
+	}
+	line.LoanID = loan.ID
+	return line, tx.Create(&line).Error
+}
+
+// Activate opens a pending line inside an open transaction once every guarantor has confirmed
+// Nothing is paid out: the line account starts at zero and is drawn on as withdrawals need it
+func Activate(tx *gorm.DB, line *models.CreditLine, accountNumber, by string, now time.Time) error {
+	if !lifecycle.Allows(lifecycle.CreditLine, line.Status, StatusActive) {
+		return ErrNotPending
+	}
+	if err := loans.CheckGuarantors(tx, line.LoanID); err != nil {
+		return err
+	}
+	var linked models.Account
+	if err := tx.First(&linked, line.LinkedAccountID).Error; err != nil {
+		return err
+	}
+	if linked.Status != "active" {
+		return ErrLinkedAccount
+	}
+
+	account := models.Account{
+		TenantID:      line.TenantID,
+		CustomerID:    line.CustomerID,
+		AccountNumber: accountNumber,
+		AccountType:   AccountType,
+		Currency:      linked.Currency,
+		Status:        "active",
+	}
+	if err := tx.Create(&account).Error; err != nil {
+		return err
+	}
+	if err := statushistory.Created(tx, account.TenantID, statushistory.SubjectAccount, account.ID, account.Status, by); err != nil {
+		return err
+	}
+	err := tx.Model(&models.Loan{}).Where("id = ?", line.LoanID).Updates(map[string]interface{}{
+		"account_id":        account.ID,
+		"status":            "active",
+		"remaining_balance": 0,
+		"disbursement_date": businessdays.Format(now),
+	}).Error
+	if err != nil {
+		return err
+	}
+	err = statushistory.Record(tx, models.StatusHistory{
+		TenantID:    line.TenantID,
+		SubjectType: statushistory.SubjectLoan,
+		SubjectID:   line.LoanID,
+		OldStatus:   loans.StatusPending,
+		NewStatus:   "active",
+		Reason:      "Line of credit activated",
+		ChangedBy:   by,
+		ChangedAt:   now,
+	})
+	if err != nil {
+		return err
+	}
+
+	line.AccountID = account.ID
+	line.Status = StatusActive
+	line.ActivatedAt = &now
+	line.InterestAccruedThrough = &now
+	return tx.Model(line).Updates(map[string]interface{}{
+		"account_id":               line.AccountID,
+		"status":                   line.Status,
+		"activated_at":             now,
+		"interest_accrued_through": now,
+	}).Error
+}
+
+// Usage reports a line's utilization; pending and closed lines have nothing drawn
+func Usage(db *gorm.DB, line models.CreditLine) (Utilization, error) {
+	var u Utilization
+	if line.AccountID != 0 {
+		var account models.Account
+		if err := db.Select("id, balance").First(&account, line.AccountID).Error; err != nil {
+			return u, err
+		}
+		u.Drawn = money.Cents(-account.Balance)
+	}
+	if line.Status == StatusActive {
+		u.AvailableCredit = money.Cents(math.Max(line.CreditLimit-u.Drawn, 0))
+	}
+	if line.CreditLimit > 0 {
+		u.UtilizationPercent = money.Cents(u.Drawn / line.CreditLimit * 100)
+	}
+	return u, nil
+}
+
+// Linked returns the active line covering an account, if any
+func Linked(db *gorm.DB, accountID uint) (models.CreditLine, bool, error) {
+	var line models.CreditLine
+	err := db.Where("linked_account_id = ? AND status = ?", accountID, StatusActive).First(&line).Error
+	if err == gorm.ErrRecordNotFound {
+		return line, false, nil
+	}
+	return line, err == nil, err
+}
+
+// BacksLoan reports whether a loan is the backing loan of a line; such loans are not disbursed or paid directly
+func BacksLoan(db *gorm.DB, loanID uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.CreditLine{}).Where("loan_id = ?", loanID).Count(&count).Error
+	return count > 0, err
+}
+
+// Cover draws the shortfall of a debit the account balance cannot cover from its linked line, inside the
+// transaction that then posts the debit. The debit is given its transaction ID so the draw can name it.
+// Nothing is drawn when the account has no active line or the shortfall exceeds the available credit;
+// the posting's own funds check then decides. It returns the line drawn on, or nil
+func Cover(tx *gorm.DB, t *models.Transaction) (*models.CreditLine, error) {
+	if !coveredTypes[t.TransactionType] {
+		return nil, nil
+	}
+	var account models.Account
+	if err := tx.Select("id, balance, status").First(&account, t.AccountID).Error; err != nil {
+		return nil, err
+	}
+	shortfall := money.Cents(t.Amount - account.Balance)
+	if shortfall <= 0 || account.Status != "active" {
+		return nil, nil
+	}
+	line, ok, err := Linked(tx, account.ID)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var lineAccount models.Account
+	if err := tx.First(&lineAccount, line.AccountID).Error; err != nil {
+		return nil, err
+	}
+	if shortfall > money.Cents(line.CreditLimit+lineAccount.Balance) {
+		return nil, nil
+	}
+
+	if t.TransactionID == "" {
+		t.TransactionID = ledger.NewTransactionID()
+	}
+	description := fmt.Sprintf("Line of credit %s draw covering %s", line.LineNumber, t.TransactionID)
+	if _, err := ledger.Draw(tx, lineAccount, account.ID, shortfall, line.LineNumber, description, t.ValueDate); err != nil {
+		return nil, err
+	}
+	err = tx.Model(&models.Loan{}).Where("id = ?", line.LoanID).
+		Update("remaining_balance", gorm.Expr("remaining_balance + ?", shortfall)).Error
+	return &line, err
+}
+
+// Repay applies a deposit to the account's drawn line inside the transaction that posted it
+// Accrued interest is repaid first, then the drawn amount, up to the deposit and the account balance.
+// account is the account after the deposit; it returns the account after the repayment and the line repaid, or nil
+func Repay(tx *gorm.DB, account models.Account, deposit models.Transaction) (models.Account, *models.CreditLine, error) {
+	if deposit.TransactionType != "deposit" {
+		return account, nil, nil
+	}
+	line, ok, err := Linked(tx, account.ID)
+	if err != nil || !ok {
+		return account, nil, err
+	}
+	usage, err := Usage(tx, line)
+	if err != nil {
+		return account, nil, err
+	}
+	amount := money.Cents(math.Min(math.Min(deposit.Amount, account.Balance), usage.Drawn+line.AccruedInterest))
+	if amount <= 0 {
+		return account, nil, nil
+	}
+	interestPart, principalPart, err := loans.Allocate(money.FromFloat(amount, account.Currency), money.FromFloat(line.AccruedInterest, account.Currency))
+	if err != nil {
+		return account, nil, err
+	}
+	interest, principal := interestPart.Float(), principalPart.Float()
+
+	posting := models.Transaction{
+		AccountID:       account.ID,
+		TransactionType: "payment",
+		Amount:          amount,
+		Description:     fmt.Sprintf("Line of credit %s repayment from %s", line.LineNumber, deposit.TransactionID),
+		Reference:       line.LineNumber,
+		Channel:         enrichment.DefaultChannel,
+	}
+	if account, err = ledger.Post(tx, &posting, nil); err != nil {
+		return account, nil, err
+	}
+	// Interest is income; the rest pays down the line account
+	if interest > 0 {
+		if _, err := ledger.OffsetTo(tx, posting, account, gl.InterestIncome, interest); err != nil {
+			return account, nil, err
+		}
+	}
+	if principal > 0 {
+		if _, err := ledger.Offset(tx, posting, line.AccountID, principal); err != nil {
+			return account, nil, err
+		}
+	}
+
+	line.AccruedInterest = money.Cents(line.AccruedInterest - interest)
+	if err := tx.Model(&line).Update("accrued_interest", line.AccruedInterest).Error; err != nil {
+		return account, nil, err
+	}
+	err = tx.Model(&models.Loan{}).Where("id = ?", line.LoanID).
+		Update("remaining_balance", money.Cents(usage.Drawn-principal)).Error
+	return account, &line, err
+}
+
+// Accrue adds daily simple interest on each active line's drawn amount for the whole days since it last ran
+// The drawn amount is taken by value date. Uses an actual/365 day count; it returns the number of lines that accrued
+func Accrue(db *gorm.DB, now time.Time) (int, error) {
+	var lines []models.CreditLine
+	if err := db.Where("status = ?", StatusActive).Order("id").Find(&lines).Error; err != nil {
+		return 0, err
+	}
+	accrued := 0
+	for _, line := range lines {
+		if line.InterestAccruedThrough == nil {
+			continue
+		}
+		days := math.Floor(now.Sub(*line.InterestAccruedThrough).Hours() / 24)
+		if days < 1 {
+			continue
+		}
+		usage, err := Usage(db, line)
+		if err != nil {
+			return accrued, err
+		}
+		through := line.InterestAccruedThrough.AddDate(0, 0, int(days))
+		// Draws and repayments valued after the days accrued, such as a draw submitted after the cutoff,
+		// count from their value date
+		var pending struct{ Net float64 }
+		err = db.Model(&models.Transaction{}).Select("COALESCE(SUM("+ledger.SignedAmountSQL+"), 0) AS net").
+			Where("account_id = ? AND value_date >= ?", line.AccountID, through).Scan(&pending).Error
+		if err != nil {
+			return accrued, err
+		}
+		drawn := money.Cents(usage.Drawn + pending.Net)
+		updates := map[string]interface{}{"interest_accrued_through": through}
+		if interest := money.Cents(drawn * line.InterestRate * days / 365); interest > 0 {
+			updates["accrued_interest"] = money.Cents(line.AccruedInterest + interest)
+			accrued++
+		}
+		if err := db.Model(&line).Updates(updates).Error; err != nil {
+			return accrued, err
+		}
+	}
+	return accrued, nil
+}
+
+// Close closes a fully repaid line and its account inside an open transaction; the backing loan is paid off
+func Close(tx *gorm.DB, line *models.CreditLine, by string, now time.Time) error {
+	if !lifecycle.Allows(lifecycle.CreditLine, line.Status, StatusClosed) {
+		return ErrNotActive
+	}
+	usage, err := Usage(tx, *line)
+	if err != nil {
+		return err
+	}
+	if usage.Drawn > 0 || line.AccruedInterest > 0 {
+		return ErrOutstanding
+	}
+	var account models.Account
+	var loan models.Loan
+	if err := tx.Select("id, status").First(&account, line.AccountID).Error; err != nil {
+		return err
+	}
+	if err := tx.Select("id, status").First(&loan, line.LoanID).Error; err != nil {
+		return err
+	}
+	changes := []models.StatusHistory{
+		{SubjectType: statushistory.SubjectAccount, SubjectID: account.ID, OldStatus: account.Status, NewStatus: "closed", Reason: "Line of credit closed"},
+		{SubjectType: statushistory.SubjectLoan, SubjectID: loan.ID, OldStatus: loan.Status, NewStatus: "paid_off", Reason: statushistory.ReasonPaidOff},
+	}
+	if err := tx.Model(&account).Update("status", "closed").Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&loan).Update("status", "paid_off").Error; err != nil {
+		return err
+	}
+	for _, change := range changes {
+		change.TenantID, change.ChangedBy, change.ChangedAt = line.TenantID, by, now
+		if err := statushistory.Record(tx, change); err != nil {
+			return err
+		}
+	}
+	line.Status = StatusClosed
+	line.ClosedAt = &now
+	return tx.Model(line).Updates(map[string]interface{}{"status": line.Status, "closed_at": now}).Error
+}
@@ -0,0 +1,191 @@
+package dashboard
+
+import (
+	"banking-app/businessdays"
+	"banking-app/duplicates"
+	"banking-app/events"
+	"banking-app/exceptions"
+	"banking-app/gl"
+	"banking-app/jobs"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Version is the payload version; fields are only added within a version, and anything renamed or removed bumps it
+const Version = 1
+
+// QueryBudget is the most statements one uncached summary may run. Every block is a fixed number of indexed
+// queries, so the count does not grow with the data
+const QueryBudget = 12
+
+// PostingRoutes are the matched routes of requests that post to the ledger; their failures are counted
+var PostingRoutes = []string{
+	"/api/v1/transactions",
+	"/api/v1/transactions/batch-atomic",
+	"/api/v1/transactions/:id/reverse",
+	"/api/v1/transfers",
+	"/api/v2/transactions",
+	"/api/v2/transfers",
+}
+
+// Summary is today's headline numbers for an operations screen
+// Jobs, Webhooks and Database are deployment-wide and only filled in for the platform tenant
+type Summary struct {
+	Version      int               `json:"version"`
+	GeneratedAt  time.Time         `json:"generated_at"`
+	Day          string            `json:"day"` // YYYY-MM-DD in bank time; "today" starts at bank midnight
+	TenantID     uint              `json:"tenant_id"`
+	Transactions []Volume          `json:"transactions"`
+	Onboarding   Onboarding        `json:"onboarding"`
+	Failures     []Failure         `json:"failed_transactions"`
+	Risk         Risk              `json:"risk"`
+	Jobs         []jobs.Registered `json:"jobs,omitempty"`
+	Webhooks     *Webhooks         `json:"webhooks,omitempty"`
+	Database     *Pool             `json:"database,omitempty"`
+	QueryCount   int               `json:"query_count"` // Statements the summary ran, filled in by the handler
+	Cached       bool              `json:"cached"`      // Served from an earlier summary rather than computed for this request
+}
+
+// Volume is the number and value of one type of customer posting made today in one currency
+type Volume struct {
+	TransactionType string  `json:"transaction_type"`
+	Currency        string  `json:"currency"`
+	Count           int64   `json:"count"`
+	Amount          float64 `json:"amount"`
+}
+
+// Onboarding counts the customers and accounts opened today
+type Onboarding struct {
+	NewCustomers int64 `json:"new_customers"`
+	NewAccounts  int64 `json:"new_accounts"`
+}
+
+// Failure counts today's rejected posting requests by route and response status
+// Error codes are not kept once a response is sent, so the audit log's status stands in for them; only
+// signed-in callers' requests are audited
+type Failure struct {
+	Route  string `json:"route"`
+	Status int    `json:"status"`
+	Count  int64  `json:"count"`
+}
+
+// Risk counts the items waiting on a person. SAR cases are left out: they are visible to compliance only
+type Risk struct {
+	PendingReviews    int64 `json:"pending_reviews"`    // Postings held for review
+	FlaggedDuplicates int64 `json:"flagged_duplicates"` // Suspected duplicate payments not yet decided
+	OpenExceptions    int64 `json:"open_exceptions"`    // Unmatched credits held in suspense
+}
+
+// Webhooks is the delivery backlog across every subscription
+type Webhooks struct {
+	PendingDeliveries      int64 `json:"pending_deliveries"`
+	SuspendedSubscriptions int64 `json:"suspended_subscriptions"`
+	PendingEvents          int64 `json:"pending_events"` // Outbox events not yet fanned out
+	FailedEvents           int64 `json:"failed_events"`
+}
+
+// Pool is the database connection pool's state
+type Pool struct {
+	OpenConnections int     `json:"open_connections"`
+	InUse           int     `json:"in_use"`
+	Idle            int     `json:"idle"`
+	MaxOpen         int     `json:"max_open"`
+	WaitCount       int64   `json:"wait_count"`
+	WaitMS          float64 `json:"wait_ms"`
+}
+
+// Build computes the summary as of now. db should be scoped to the tenant; platform adds the deployment-wide
+// blocks from scheduler and the unscoped database
+func Build(db *gorm.DB, scheduler *jobs.Scheduler, platform bool, tenantID uint, now time.Time) (Summary, error) {
+	since := businessdays.StartOfDay(now)
+	summary := Summary{
+		Version:     Version,
+		GeneratedAt: now,
+		Day:         since.Format("2006-01-02"),
+		TenantID:    tenantID,
+		Failures:    []Failure{},
+	}
+
+	// General-ledger legs are left out, so each customer posting counts once
+	summary.Transactions = []Volume{}
+	err := db.Model(&models.Transaction{}).
+		Select("transactions.transaction_type, accounts.currency, COUNT(*) AS count, COALESCE(SUM(transactions.amount), 0) AS amount").
+		Joins("JOIN accounts ON accounts.id = transactions.account_id AND accounts.account_type <> ?", gl.AccountType).
+		Where("transactions.created_at >= ?", since).
+		Group("1, 2").Order("1, 2").Scan(&summary.Transactions).Error
+	if err != nil {
+		return summary, err
+	}
+	for i := range summary.Transactions {
+		summary.Transactions[i].Amount = money.Cents(summary.Transactions[i].Amount)
+	}
+
+	if err := db.Model(&models.Customer{}).Where("created_at >= ?", since).Count(&summary.Onboarding.NewCustomers).Error; err != nil {
+		return summary, err
+	}
+	if err := db.Model(&models.Account{}).Where("created_at >= ? AND account_type <> ?", since, gl.AccountType).
+		Count(&summary.Onboarding.NewAccounts).Error; err != nil {
+		return summary, err
+	}
+
+	err = db.Model(&models.AuditEntry{}).Select("route, status, COUNT(*) AS count").
+		Where("route IN ? AND created_at >= ? AND method = ? AND status >= ?", PostingRoutes, since, "POST", 400).
+		Group("route, status").Order("route, status").Scan(&summary.Failures).Error
+	if err != nil {
+		return summary, err
+	}
+
+	for _, count := range []struct {
+		model  interface{}
+		status string
+		into   *int64
+	}{
+		{&models.TransactionReview{}, ledger.ReviewPending, &summary.Risk.PendingReviews},
+		{&models.DuplicatePayment{}, duplicates.StatusFlagged, &summary.Risk.FlaggedDuplicates},
+		{&models.ExceptionItem{}, exceptions.StatusOpen, &summary.Risk.OpenExceptions},
+	} {
+		if err := db.Model(count.model).Where("status = ?", count.status).Count(count.into).Error; err != nil {
+			return summary, err
+		}
+	}
+
+	if !platform {
+		return summary, nil
+	}
+	if summary.Jobs, err = scheduler.JobsContext(db.Statement.Context); err != nil {
+		return summary, err
+	}
+	if summary.Webhooks, err = webhookBacklog(db); err != nil {
+		return summary, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return summary, err
+	}
+	stats := sqlDB.Stats()
+	summary.Database = &Pool{
+		OpenConnections: stats.OpenConnections,
+		InUse:           stats.InUse,
+		Idle:            stats.Idle,
+		MaxOpen:         stats.MaxOpenConnections,
+		WaitCount:       stats.WaitCount,
+		WaitMS:          float64(stats.WaitDuration.Microseconds()) / 1000,
+	}
+	return summary, nil
+}
+
+// webhookBacklog counts what is waiting to go out to subscribers, in one statement
+func webhookBacklog(db *gorm.DB) (*Webhooks, error) {
+	var backlog Webhooks
+	err := db.Raw(`SELECT
+		(SELECT COUNT(*) FROM webhook_deliveries WHERE status = ?) AS pending_deliveries,
+		(SELECT COUNT(*) FROM webhook_subscriptions WHERE suspended AND deleted_at IS NULL) AS suspended_subscriptions,
+		(SELECT COUNT(*) FROM outbox_events WHERE status = ?) AS pending_events,
+		(SELECT COUNT(*) FROM outbox_events WHERE status = ?) AS failed_events`,
+		events.DeliveryPending, "pending", "failed").Scan(&backlog).Error
+	return &backlog, err
+}
@@ -1,10 +1,23 @@
 package database
 
 import (
+	"banking-app/archive"
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/garnishments"
+	"banking-app/gl"
+	"banking-app/invariants"
 	"banking-app/models"
+	"banking-app/receipts"
+	"banking-app/statements"
+	"banking-app/statushistory"
+	"context"
+	"database/sql"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"gorm.io/driver/sqlite"
@@ -12,48 +25,375 @@ import (
 	"gorm.io/gorm/logger"
 )
 
-// InitDatabase establishes connection to SQLite database and handles migrations
-// Uses SQLite for simplicity - easily replaceable with PostgreSQL/MySQL
-func InitDatabase() (*gorm.DB, error) {
+// PathFromEnv returns the database file named by DB_PATH
+func PathFromEnv() string {
 	// Database configuration - SQLite file for development
 	dbPath := os.Getenv("DB_PATH")
 	if dbPath == "" {
 		dbPath = "banking.db" // Default SQLite file
 	}
+	return dbPath
+}
+
+// DefaultBusyTimeout is how long a connection waits for another connection's write lock before reporting
+// "database is locked"; DB_BUSY_TIMEOUT_MS overrides it
+const DefaultBusyTimeout = 5 * time.Second
+
+// BusyTimeoutFromEnv reads DB_BUSY_TIMEOUT_MS
+func BusyTimeoutFromEnv() time.Duration {
+	raw := os.Getenv("DB_BUSY_TIMEOUT_MS")
+	if raw == "" {
+		return DefaultBusyTimeout
+	}
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms < 0 {
+		log.Printf("database: ignoring invalid DB_BUSY_TIMEOUT_MS %q", raw)
+		return DefaultBusyTimeout
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// dsn adds the connection settings every pooled connection needs to a database file path
+// The driver applies them as each connection opens, since SQLite pragmas last only as long as their connection:
+// - WAL journaling, so reads never wait for a writer and a writer never waits for reads
+// - a busy timeout, so a writer waits its turn instead of failing at once
+// - foreign key enforcement
+// - immediate transactions, which take the write lock at BEGIN; a deferred one that read first could not wait
+//   for the lock and would fail if another connection wrote in between
+func dsn(dbPath string) string {
+	separator := "?"
+	if strings.Contains(dbPath, "?") {
+		separator = "&"
+	}
+	return dbPath + separator + "_journal_mode=WAL&_busy_timeout=" + strconv.FormatInt(BusyTimeoutFromEnv().Milliseconds(), 10) +
+		"&_foreign_keys=on&_txlock=immediate"
+}
+
+// InitDatabase establishes connection to SQLite database and handles migrations
+// Uses SQLite for simplicity - easily replaceable with PostgreSQL/MySQL
+func InitDatabase(dbPath string) (*gorm.DB, error) {
+	db, err := Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
 
+	if err := Migrate(db); err != nil {
+		return nil, err
+	}
+
+	// Verify hot-path queries are served by indexes
+	LogQueryPlans(db)
+
+	log.Println("Database connection established and migrations completed successfully")
+	return db, nil
+}
+
+// Open connects to the SQLite database file without migrating it
+// Shared by the server and the bankctl admin CLI
+func Open(dbPath string) (*gorm.DB, error) {
 	// Open database connection with logging enabled for development
 	// Silent mode can be used in production for better performance
-	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{
+	pool, err := sql.Open(sqlite.DriverName, dsn(dbPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect database: %w", err)
+	}
+	db, err := gorm.Open(&sqlite.Dialector{Conn: utcPool{db: pool}}, &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info), // Log SQL queries during development
+		NowFunc: func() time.Time { return clock.Now().UTC() }, // Timestamps follow the application clock, which a sandbox can move; stored in UTC
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect database: %w", err)
 	}
 
-	// Enable foreign key constraints - critical for data integrity in banking systems
-	// SQLite requires explicit enabling of foreign key constraints
+	// Foreign key constraints are critical for data integrity in banking systems
+	// SQLite leaves them off unless each connection enables them, which the DSN does
 	sqlDB, err := db.DB()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get database instance: %w", err)
 	}
 	
+	// Checked quietly so the SQL log doesn't end up in output such as bankctl -json
+	quiet := db.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+	var foreignKeys int
+	var journalMode string
+	if err := quiet.Raw("PRAGMA foreign_keys").Scan(&foreignKeys).Error; err != nil {
+		return nil, fmt.Errorf("failed to read database settings: %w", err)
+	}
+	if err := quiet.Raw("PRAGMA journal_mode").Scan(&journalMode).Error; err != nil {
+		return nil, fmt.Errorf("failed to read database settings: %w", err)
+	}
+	if foreignKeys != 1 || !strings.EqualFold(journalMode, "wal") {
+		return nil, fmt.Errorf("database settings not applied: foreign_keys=%d journal_mode=%s", foreignKeys, journalMode)
+	}
+
 	sqlDB.SetMaxIdleConns(10)                    // Maximum number of idle connections
 	sqlDB.SetMaxOpenConns(100)                   // Maximum number of open connections
 	sqlDB.SetConnMaxLifetime(time.Hour)          // Connection maximum lifetime
 
-	// Auto migrate database schema
-	// Automatically creates/updates tables based on model definitions
-	// Critical for maintaining database schema consistency
-	err = db.AutoMigrate(
+	// Every transaction is hash-chained to the previous one on its account as it is created
+	if err := receipts.RegisterCallbacks(db); err != nil {
+		return nil, fmt.Errorf("failed to register transaction hashing: %w", err)
+	}
+	// Account balances change only through the ledger's guarded write
+	if err := invariants.RegisterCallbacks(db); err != nil {
+		return nil, fmt.Errorf("failed to register balance guard: %w", err)
+	}
+	// Deposits to garnished accounts are swept toward the orders as they post, whichever code path posts them
+	if err := garnishments.RegisterCallbacks(db); err != nil {
+		return nil, fmt.Errorf("failed to register garnishment sweeps: %w", err)
+	}
+
+	return db, nil
+}
+
+// WriteGate gives database writes their turn, one at a time
+type WriteGate interface {
+	Acquire(ctx context.Context) error
+	Release()
+}
+
+// QueueWrites makes every transaction, and every statement run outside one, on db wait for the gate first
+// Reads outside a transaction don't wait. Call it before any session is derived from db, since sessions keep the
+// connection pool they were made with
+func QueueWrites(db *gorm.DB, gate WriteGate) error {
+	pool, ok := db.ConnPool.(utcPool)
+	if !ok {
+		return fmt.Errorf("database writes can only be queued on the connection pool Open makes")
+	}
+	pool.gate = gate
+	db.ConnPool = pool
+	db.Statement.ConnPool = pool
+	return nil
+}
+
+// Models lists every migrated model in dependency order
+func Models() []interface{} {
+	return []interface{}{
 		&models.Customer{},  // Customer table
 		&models.Account{},   // Account table
 		&models.Transaction{}, // Transaction table
 		&models.Loan{},      // Loan table
-	)
+		&models.Notification{}, // Outbound notification queue
+		&models.AlertRule{},    // Per-account alert rules
+		&models.AlertFiring{},  // Alert firing history
+		&models.Budget{},       // Customer monthly category budgets
+		&models.BudgetAlert{},  // Budget thresholds reached per month
+		&models.EnrichmentRule{}, // Transaction enrichment rules
+		&models.OutboxEvent{},    // Transactional event outbox
+		&models.WebhookSubscription{}, // Webhook event subscribers
+		&models.WebhookDelivery{},     // Per-subscription webhook delivery queue
+		&models.Tenant{},              // Bank brands served by this deployment
+		&models.User{},                // Sign-in users
+		&models.PeriodLock{},          // Accounting period closes
+		&models.FeatureFlag{},         // Runtime feature flags
+		&models.LoanPayment{},         // Loan payment allocations
+		&models.TaxSummary{},          // Pre-generated year-end tax summaries
+		&models.AccountClosure{},      // Account closure records
+		&models.Escheatment{},         // Abandoned-funds turnover tracking
+		&models.ExceptionItem{},       // Unmatched credits held in suspense
+		&models.InvariantViolation{},  // Broken balance invariants found by the scan
+		&models.Document{},            // Scanned customer uploads
+		&models.Product{},             // Account product catalog and eligibility rules
+		&models.EligibilityOverride{}, // Staff waivers of product eligibility criteria
+		&models.ProductRate{},         // Effective-dated product interest rates
+		&models.Transfer{},            // Account-to-account transfers
+		&models.BalanceCertificate{},  // Issued balance confirmation letters
+		&models.ImpersonationSession{}, // Admins viewing the API as a customer
+		&models.AuditEntry{},           // Audit log of authenticated requests
+		&models.SIEMCursor{},           // How far the audit log has been forwarded to the SIEM
+		&models.MaintenanceState{},     // Read-only maintenance mode
+		&models.Statement{},            // Archived monthly and ad hoc account statements
+		&models.StatementRequest{},     // Statements requested for custom date ranges
+		&models.LoanParty{},            // Loan borrowers, co-borrowers and guarantors
+		&models.LoanSplit{},            // Loan installments collected in shares from each party
+		&models.LoanCollection{},       // Installments collected under a payment split
+		&models.LoanCollectionShare{},  // Each party's part of a split collection
+		&models.InstallmentPlan{},      // Payments converted into monthly installments
+		&models.CreditLine{},           // Lines of credit covering checking shortfalls
+		&models.BulkOperation{},        // Administrator actions over many accounts
+		&models.BulkOperationItem{},    // Per-account outcomes of bulk operations
+		&models.Campaign{},             // Announcements emailed to an audience in batches
+		&models.CampaignRecipient{},    // Per-customer outcomes of campaigns
+		&models.NotificationPreference{}, // Customers' opt-outs from optional messages
+		&models.Note{},                 // Staff notes on transactions, accounts, customers and loans
+		&models.JobRun{},               // Background job execution history
+		&models.JobLease{},             // Locks preventing overlapping job runs
+		&models.PostingWindow{},        // Posting pause held during end of day
+		&models.UsageRecord{},          // Daily API client usage counters
+		&models.UsageQuota{},           // Monthly API client quotas
+		&models.ProductChange{},        // Account conversions between products
+		&models.AccountApplication{},   // Self-service account opening drafts
+		&models.MatchReport{},          // External bank statement reconciliations
+		&models.MatchItem{},            // Matched and unmatched statement lines and postings
+		&models.Consent{},              // Customer consents for third-party data access
+		&models.OAuthClient{},          // Registered OAuth2 third-party clients
+		&models.OAuthCode{},            // One-time OAuth2 authorization codes
+		&models.CommunicationLog{},     // Messages and documents sent to customers
+		&models.Tag{},                  // Customer and account segmentation tags
+		&models.EntityTag{},            // Tags applied to customers and accounts
+		&models.ReportSubscription{},   // Scheduled report deliveries
+		&models.ReportRun{},            // Each rendering and delivery of a subscribed report
+		&models.FeeSchedule{},          // Transaction fee pricing by product, type, channel and currency
+		&models.RelationshipTier{},     // Relationship pricing tier definitions
+		&models.CustomerRelationship{}, // Customers' monthly relationship tiers and their inputs
+		&models.FXRate{},               // Daily closing exchange rates into the base currency
+		&models.BalanceSnapshot{},      // End-of-day foreign-currency balances valued in the base currency
+		&models.FXRevaluation{},        // Daily unrealized FX gain or loss per currency
+		&models.WithdrawalApproval{},   // Restricted-account debits awaiting staff approval
+		&models.TransactionReview{},    // First large debits from new accounts held for review
+		&models.Authorization{},        // Pre-authorization holds awaiting capture
+		&models.DuplicatePayment{},     // Payments repeating an earlier one across channels, flagged for review
+		&models.Dispute{},              // Customers' challenges to posted transactions, resolved by staff
+		&models.DisputeMessage{},       // Dispute threads between the customer and the assigned staff member
+		&models.DisputeAttachment{},    // Evidence uploaded to disputes
+		&models.Lien{},                 // Third-party claims on account funds
+		&models.LienDocument{},         // Documents supporting liens
+		&models.LienPayment{},          // Payments of liens to their claimants
+		&models.GarnishmentOrder{},     // Court orders collecting debts from deposits
+		&models.GarnishmentDocument{},  // Documents supporting garnishment orders
+		&models.GarnishmentPayment{},   // Deposits swept toward garnishment orders
+		&models.EmailVerification{},    // Emailed tokens confirming customer addresses
+		&models.ExternalAccount{},      // Accounts at other banks, linked by micro-deposits
+		&models.DescriptorTemplate{},   // Per-tenant and per-product statement descriptor templates
+		&models.Holiday{},              // Bank holidays, on top of weekends
+		&models.CreditReport{},         // Soft credit pulls, reused while fresh
+		&models.StatusHistory{},        // Customer, account and loan status changes
+		&models.TransactionArchive{},   // Per-account totals of archived postings
+		&models.ArchiveBatch{},         // Postings moved to and from the archive table
+		&models.SARCase{},              // Suspicious activity report cases
+		&models.SARCaseTransaction{},   // Transactions linked to SAR cases
+		&models.SARCaseDocument{},      // Documents attached to SAR cases
+		&models.SARCaseNote{},          // Investigation notes on SAR cases
+	}
+}
+
+// Migrate brings the schema up to date with the model definitions
+// Automatically creates/updates tables - critical for maintaining database schema consistency
+// It runs on one connection with foreign keys off, as SQLite requires for rebuilding a table other tables
+// reference, and reports any rows left without their parent once the schema is settled
+func Migrate(db *gorm.DB) error {
+	return db.Connection(func(conn *gorm.DB) error {
+		conn = conn.Session(&gorm.Session{})
+		if err := conn.Exec("PRAGMA foreign_keys = OFF").Error; err != nil {
+			return fmt.Errorf("failed to disable foreign keys for migration: %w", err)
+		}
+		defer conn.Exec("PRAGMA foreign_keys = ON")
+		if err := migrate(conn); err != nil {
+			return err
+		}
+		return checkForeignKeys(conn)
+	})
+}
+
+// checkForeignKeys logs rows whose parent row is missing
+// Enforcement only covers writes made since it was turned on, so older databases may hold such rows
+func checkForeignKeys(db *gorm.DB) error {
+	var violations []struct {
+		Table  string
+		Parent string
+		Count  int
+	}
+	err := db.Raw(`SELECT "table", parent, COUNT(*) AS count FROM pragma_foreign_key_check GROUP BY "table", parent`).Scan(&violations).Error
 	if err != nil {
-		return nil, fmt.Errorf("failed to migrate database: %w", err)
+		return fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+	for _, v := range violations {
+		log.Printf("WARNING: %d %s rows reference missing %s rows", v.Count, v.Table, v.Parent)
 	}
 
-	log.Println("Database connection established and migrations completed successfully")
-	return db, nil
-}
\ No newline at end of file
+	// accounts has no foreign key to customers, so customer accounts are checked here instead
+	var orphans int64
+	err = db.Table("accounts").
+		Where("account_type <> ? AND NOT EXISTS (SELECT 1 FROM customers WHERE customers.id = accounts.customer_id)", gl.AccountType).
+		Count(&orphans).Error
+	if err != nil {
+		return fmt.Errorf("failed to check account customers: %w", err)
+	}
+	if orphans > 0 {
+		log.Printf("WARNING: %d accounts rows reference missing customers rows", orphans)
+	}
+	return nil
+}
+
+// migrate is Migrate on its connection
+func migrate(db *gorm.DB) error {
+	// Internal general-ledger accounts belong to no customer, so accounts no longer reference customers
+	// Dropping the constraint rebuilds the table without its indexes, so it goes before AutoMigrate restores them
+	if db.Migrator().HasConstraint(&models.Account{}, "fk_customers_accounts") {
+		if err := db.Migrator().DropConstraint(&models.Account{}, "fk_customers_accounts"); err != nil {
+			return fmt.Errorf("failed to drop constraint fk_customers_accounts: %w", err)
+		}
+	}
+
+	if err := db.AutoMigrate(Models()...); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	// Old postings are moved to an archive table with the same columns, kept in step as transactions gains them
+	if err := archive.Ensure(db); err != nil {
+		return fmt.Errorf("failed to migrate transaction archive: %w", err)
+	}
+
+	// Entries recorded before effective dating take effect when they were created
+	if err := db.Exec("UPDATE transactions SET effective_date = created_at WHERE effective_date IS NULL").Error; err != nil {
+		return fmt.Errorf("failed to backfill effective dates: %w", err)
+	}
+
+	// Uniqueness became per tenant - drop the global indexes AutoMigrate leaves behind
+	legacy := []struct {
+		model interface{}
+		index string
+	}{
+		{&models.Customer{}, "idx_customers_email"},
+		{&models.User{}, "idx_users_username"},
+		{&models.Statement{}, "idx_statements_account_period"}, // Ad hoc statements made it per request too
+	}
+	for _, l := range legacy {
+		if db.Migrator().HasIndex(l.model, l.index) {
+			if err := db.Migrator().DropIndex(l.model, l.index); err != nil {
+				return fmt.Errorf("failed to drop index %s: %w", l.index, err)
+			}
+		}
+	}
+
+	// Internal general-ledger accounts for every tenant and currency in use
+	if err := gl.Seed(db); err != nil {
+		return fmt.Errorf("failed to seed general ledger accounts: %w", err)
+	}
+
+	// Federal holidays for this year and next; later years are seeded as the calendar reaches them
+	year := businessdays.In(clock.Now()).Year()
+	for _, y := range []int{year, year + 1} {
+		if _, err := businessdays.SeedFederal(db, y); err != nil {
+			return fmt.Errorf("failed to seed holidays: %w", err)
+		}
+	}
+
+	// Loans opened before co-borrowers and guarantors have their customer as the sole, fully liable borrower
+	err := db.Exec(`INSERT INTO loan_parties (created_at, updated_at, tenant_id, loan_id, customer_id, role, liability_percent)
+		SELECT created_at, created_at, tenant_id, id, customer_id, 'borrower', 100 FROM loans
+		WHERE NOT EXISTS (SELECT 1 FROM loan_parties WHERE loan_parties.loan_id = loans.id)`).Error
+	if err != nil {
+		return fmt.Errorf("failed to backfill loan borrowers: %w", err)
+	}
+
+	// Records from before status history start theirs with how they were created
+	if err := statushistory.Backfill(db); err != nil {
+		return fmt.Errorf("failed to backfill status history: %w", err)
+	}
+
+	// Transactions recorded before hash chaining get their hashes in posting order
+	if hashed, err := receipts.Backfill(db); err != nil {
+		return fmt.Errorf("failed to backfill transaction hashes: %w", err)
+	} else if hashed > 0 {
+		log.Printf("Hashed %d existing transactions", hashed)
+	}
+
+	// Statements archived before sequencing are numbered by month from each account's first one
+	if numbered, err := statements.Backfill(db); err != nil {
+		return fmt.Errorf("failed to number statements: %w", err)
+	} else if numbered > 0 {
+		log.Printf("Numbered %d existing statements", numbered)
+	}
+	return nil
+}
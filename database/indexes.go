@@ -0,0 +1,82 @@
+package database
+
+import (
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// QueryPlan describes how SQLite executes one of the canonical hot-path queries
+type QueryPlan struct {
+	Name      string   `json:"name"`       // Short query identifier
+	SQL       string   `json:"sql"`        // Query text that was explained
+	Plan      []string `json:"plan"`       // EXPLAIN QUERY PLAN detail lines
+	UsesIndex bool     `json:"uses_index"` // True when no step performs a full table scan
+}
+
+// canonicalQueries are the frequent query shapes the composite indexes exist for
+var canonicalQueries = []struct {
+	name string
+	sql  string
+	args []interface{}
+}{
+	{"account_transactions", "SELECT * FROM transactions WHERE account_id = ? AND deleted_at IS NULL ORDER BY created_at DESC LIMIT 10", []interface{}{1}},
+	{"customer_accounts_by_status", "SELECT * FROM accounts WHERE customer_id = ? AND status = ? AND deleted_at IS NULL", []interface{}{1, "active"}},
+	{"transactions_by_type", "SELECT * FROM transactions WHERE transaction_type = ? AND created_at >= ? AND deleted_at IS NULL ORDER BY created_at DESC LIMIT 10", []interface{}{"deposit", "2024-01-01"}},
+	{"customer_loans_by_status", "SELECT * FROM loans WHERE customer_id = ? AND status = ? AND deleted_at IS NULL", []interface{}{1, "active"}},
+	{"audit_entries_from", "SELECT id FROM audit_entries WHERE created_at >= ? ORDER BY created_at, id LIMIT 1", []interface{}{"2024-01-01"}},
+	{"customer_access_report", "SELECT username, role, client_id, impersonation, method, route, COUNT(*), MIN(id), MAX(id) FROM audit_entries WHERE customer_id = ? AND id BETWEEN ? AND ? GROUP BY username, role, client_id, impersonation, method, route", []interface{}{1, 1, 1000}},
+	{"account_access_report", "SELECT id FROM audit_entries WHERE customer_id = ? AND id BETWEEN ? AND ? AND account_id = ? ORDER BY id DESC LIMIT 50", []interface{}{1, 1, 1000, 1}},
+}
+
+// ExplainCanonicalQueries runs EXPLAIN QUERY PLAN for each hot-path query
+// Only supported on SQLite; other dialects return no plans
+func ExplainCanonicalQueries(db *gorm.DB) ([]QueryPlan, error) {
+	if db.Dialector.Name() != "sqlite" {
+		return nil, nil
+	}
+
+	plans := make([]QueryPlan, 0, len(canonicalQueries))
+	for _, q := range canonicalQueries {
+		rows, err := db.Raw("EXPLAIN QUERY PLAN "+q.sql, q.args...).Rows()
+		if err != nil {
+			return nil, err
+		}
+
+		plan := QueryPlan{Name: q.name, SQL: q.sql, UsesIndex: true}
+		for rows.Next() {
+			var id, parent, notUsed int
+			var detail string
+			if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			plan.Plan = append(plan.Plan, detail)
+			if strings.HasPrefix(detail, "SCAN ") && !strings.Contains(detail, "INDEX") {
+				plan.UsesIndex = false
+			}
+		}
+		rows.Close()
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// LogQueryPlans logs whether each canonical query is served by an index
+// Run at startup so a missing index is visible immediately in the logs
+func LogQueryPlans(db *gorm.DB) {
+	plans, err := ExplainCanonicalQueries(db)
+	if err != nil {
+		log.Printf("Query plan check failed: %v", err)
+		return
+	}
+
+	for _, plan := range plans {
+		if plan.UsesIndex {
+			log.Printf("Query plan OK: %s uses an index (%s)", plan.Name, strings.Join(plan.Plan, "; "))
+		} else {
+			log.Printf("Query plan WARNING: %s performs a full scan (%s)", plan.Name, strings.Join(plan.Plan, "; "))
+		}
+	}
+}
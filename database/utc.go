@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// utcPool writes every time value to SQLite in UTC
+// SQLite keeps times as text and compares them as text, so a bank-midnight boundary written as
+// "2026-04-01 00:00:00-04:00" would sort before "2026-04-01 03:30:00+00:00" although it is later.
+// With one zone on disk, text order is time order
+type utcPool struct {
+	db   *sql.DB
+	gate WriteGate
+}
+
+// utcTx is a transaction on a utcPool
+type utcTx struct {
+	tx      *sql.Tx
+	release func() // Hands back the write turn, once, when the transaction ends
+}
+
+// utc converts the time arguments of a statement to UTC
+func utc(args []interface{}) []interface{} {
+	var out []interface{}
+	for i, arg := range args {
+		var t time.Time
+		switch v := arg.(type) {
+		case time.Time:
+			t = v
+		case *time.Time:
+			if v == nil {
+				continue
+			}
+			t = *v
+		case gorm.DeletedAt:
+			if !v.Valid {
+				continue
+			}
+			t = v.Time
+		case sql.NullTime:
+			if !v.Valid {
+				continue
+			}
+			t = v.Time
+		default:
+			continue
+		}
+		if out == nil {
+			out = append([]interface{}{}, args...)
+		}
+		out[i] = t.UTC()
+	}
+	if out == nil {
+		return args
+	}
+	return out
+}
+
+func (p utcPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return p.db.PrepareContext(ctx, query)
+}
+
+func (p utcPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if p.gate != nil {
+		if err := p.gate.Acquire(ctx); err != nil {
+			return nil, err
+		}
+		defer p.gate.Release()
+	}
+	return p.db.ExecContext(ctx, query, utc(args)...)
+}
+
+func (p utcPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return p.db.QueryContext(ctx, query, utc(args)...)
+}
+
+func (p utcPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return p.db.QueryRowContext(ctx, query, utc(args)...)
+}
+
+// BeginTx starts a transaction whose statements are converted too
+// Transactions are immediate, so each takes the write lock at BEGIN; with a gate it waits its turn first
+func (p utcPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	release := func() {}
+	if p.gate != nil {
+		if err := p.gate.Acquire(ctx); err != nil {
+			return nil, err
+		}
+		release = sync.OnceFunc(p.gate.Release)
+	}
+	tx, err := p.db.BeginTx(ctx, opts)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &utcTx{tx: tx, release: release}, nil
+}
+
+// GetDBConn exposes the pool for connection settings and Connection
+func (p utcPool) GetDBConn() (*sql.DB, error) {
+	return p.db, nil
+}
+
+func (t *utcTx) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return t.tx.PrepareContext(ctx, query)
+}
+
+func (t *utcTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return t.tx.ExecContext(ctx, query, utc(args)...)
+}
+
+func (t *utcTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return t.tx.QueryContext(ctx, query, utc(args)...)
+}
+
+func (t *utcTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return t.tx.QueryRowContext(ctx, query, utc(args)...)
+}
+
+func (t *utcTx) Commit() error {
+	defer t.release()
+	return t.tx.Commit()
+}
+
+func (t *utcTx) Rollback() error {
+	defer t.release()
+	return t.tx.Rollback()
+}
@@ -0,0 +1,227 @@
+package descriptors
+
+import (
+	"banking-app/display"
+	"banking-app/gl"
+	"banking-app/models"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Describe renders the descriptor of a posting on its account from the template for its kind
+// A template for the account's product wins over the tenant's catch-all, which wins over the built-in default.
+// Internal ledger accounts are not on any statement, so their postings keep their description, upper-cased
+func Describe(db *gorm.DB, t models.Transaction, account models.Account) (string, error) {
+	if account.AccountType == gl.AccountType {
+		return Render("", t.Description, nil), nil
+	}
+	kind, values, err := classify(db, t, account)
+	if err != nil {
+		return "", err
+	}
+	template, err := TemplateFor(db, account.TenantID, account.AccountType, kind)
+	if err != nil {
+		return "", err
+	}
+	return Render(kind, template, values), nil
+}
+
+// TemplateFor returns the template a tenant uses for one kind of posting on one product
+func TemplateFor(db *gorm.DB, tenantID uint, accountType, kind string) (string, error) {
+	var templates []models.DescriptorTemplate
+	err := db.Where("tenant_id = ? AND kind = ? AND account_type IN ?", tenantID, kind, []string{accountType, ""}).
+		Find(&templates).Error
+	if err != nil {
+		return "", err
+	}
+	template := Defaults[kind]
+	for _, t := range templates {
+		if t.AccountType != "" {
+			return t.Template, nil
+		}
+		template = t.Template
+	}
+	return template, nil
+}
+
+// classify works out a posting's kind and the values of the variables its template can use
+func classify(db *gorm.DB, t models.Transaction, account models.Account) (string, map[string]string, error) {
+	values := map[string]string{
+		"account":        display.MaskAccountNumber(account.AccountNumber),
+		"amount":         strconv.FormatFloat(t.Amount, 'f', 2, 64),
+		"currency":       account.Currency,
+		"channel":        t.Channel,
+		"merchant":       t.MerchantName,
+		"memo":           t.Memo,
+		"reference":      t.Reference,
+		"transaction_id": t.TransactionID,
+	}
+
+	switch {
+	case t.ReversalOfID != nil:
+		var original models.Transaction
+		if err := db.Unscoped().Select("transaction_id", "descriptor", "description").First(&original, *t.ReversalOfID).Error; err != nil {
+			return "", nil, err
+		}
+		values["original_transaction_id"] = original.TransactionID
+		values["original_descriptor"] = original.Descriptor
+		if original.Descriptor == "" {
+			values["original_descriptor"] = original.Description
+		}
+		return KindReversal, values, nil
+
+	case t.TransactionType == "fee":
+		values["fee_name"] = t.Description
+		if t.FeeScheduleID != nil {
+			var schedule models.FeeSchedule
+			if err := db.Select("name").Limit(1).Find(&schedule, *t.FeeScheduleID).Error; err != nil {
+				return "", nil, err
+			}
+			if schedule.Name != "" {
+				values["fee_name"] = schedule.Name
+			}
+		}
+		if t.FeeOfID != nil {
+			var original models.Transaction
+			if err := db.Unscoped().Select("transaction_id").Limit(1).Find(&original, *t.FeeOfID).Error; err != nil {
+				return "", nil, err
+			}
+			values["original_transaction_id"] = original.TransactionID
+		}
+		return KindFee, values, nil
+
+	case t.TransactionType == "interest":
+		return KindInterest, values, nil
+	}
+
+	counterpartyID, err := counterparty(db, t)
+	if err != nil {
+		return "", nil, err
+	}
+	if counterpartyID != nil {
+		var other models.Account
+		if err := db.Unscoped().Limit(1).Find(&other, *counterpartyID).Error; err != nil {
+			return "", nil, err
+		}
+		values["counterparty_account"] = display.MaskAccountNumber(other.AccountNumber)
+		if other.CustomerID != 0 {
+			var owner models.Customer
+			if err := db.Unscoped().Select("first_name", "last_name").Limit(1).Find(&owner, other.CustomerID).Error; err != nil {
+				return "", nil, err
+			}
+			values["counterparty_name"] = strings.TrimSpace(owner.FirstName + " " + owner.LastName)
+		}
+		if t.TransactionType == "deposit" {
+			return KindTransferIn, values, nil
+		}
+		return KindTransferOut, values, nil
+	}
+
+	switch t.TransactionType {
+	case "transfer":
+		return KindTransferOut, values, nil
+	case "payment", "withdrawal":
+		loanNumber, err := loanFor(db, t, account)
+		if err != nil {
+			return "", nil, err
+		}
+		if loanNumber != "" {
+			values["loan_number"] = loanNumber
+			if t.TransactionType == "payment" {
+				return KindLoanPayment, values, nil
+			}
+			return KindLoanDisbursement, values, nil
+		}
+		return t.TransactionType, values, nil
+	}
+	return KindDeposit, values, nil
+}
+
+// counterparty returns the other account of a transfer leg
+// Legs posted before the counterparty was recorded are found through their transfer
+func counterparty(db *gorm.DB, t models.Transaction) (*uint, error) {
+	if t.CounterpartyAccountID != nil || t.ID == 0 {
+		return t.CounterpartyAccountID, nil
+	}
+	var transfer models.Transfer
+	err := db.Where("debit_transaction_id = ? OR credit_transaction_id = ?", t.ID, t.ID).Limit(1).Find(&transfer).Error
+	if err != nil || transfer.ID == 0 {
+		return nil, err
+	}
+	if transfer.DebitTransactionID == t.ID {
+		return &transfer.ToAccountID, nil
+	}
+	return &transfer.FromAccountID, nil
+}
+
+// loanFor returns the number of the loan a payment pays or a disbursement draws, or "" for other postings
+// Loan postings carry the loan number as their reference; a disbursement debits the loan's own account
+func loanFor(db *gorm.DB, t models.Transaction, account models.Account) (string, error) {
+	if t.Reference == "" || (t.TransactionType == "withdrawal" && account.AccountType != "loan") {
+		return "", nil
+	}
+	var loan models.Loan
+	if err := db.Unscoped().Select("loan_number").Where("loan_number = ?", t.Reference).Limit(1).Find(&loan).Error; err != nil {
+		return "", err
+	}
+	return loan.LoanNumber, nil
+}
+
+// Backfill regenerates the descriptors of existing postings, in batches so large histories do not load into
+// memory at once. Only postings without a descriptor are described unless all is set, as after a template change.
+// Transfer legs found through their transfer also get their counterparty recorded
+func Backfill(db *gorm.DB, all bool) (int, error) {
+	query := db.Model(&models.Transaction{})
+	if !all {
+		query = query.Where("descriptor = '' OR descriptor IS NULL")
+	}
+
+	updated := 0
+	accounts := map[uint]models.Account{}
+	var batch []models.Transaction
+	result := query.Order("id").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for _, t := range batch {
+			account, ok := accounts[t.AccountID]
+			if !ok {
+				if err := db.Unscoped().Limit(1).Find(&account, t.AccountID).Error; err != nil {
+					return err
+				}
+				accounts[t.AccountID] = account
+			}
+			if account.ID == 0 {
+				continue
+			}
+
+			updates := map[string]interface{}{}
+			if t.CounterpartyAccountID == nil && t.TransactionType != "fee" && t.TransactionType != "interest" {
+				other, err := counterparty(db, t)
+				if err != nil {
+					return err
+				}
+				if other != nil {
+					t.CounterpartyAccountID = other
+					updates["counterparty_account_id"] = *other
+				}
+			}
+			descriptor, err := Describe(db, t, account)
+			if err != nil {
+				return err
+			}
+			if descriptor != t.Descriptor {
+				updates["descriptor"] = descriptor
+			}
+			if len(updates) == 0 {
+				continue
+			}
+			// UpdateColumns leaves updated_at alone; the posting itself has not changed
+			if err := db.Model(&models.Transaction{}).Where("id = ?", t.ID).UpdateColumns(updates).Error; err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	})
+	return updated, result.Error
+}
@@ -0,0 +1,147 @@
+package descriptors
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Template kinds - what a posting is, which decides the template that describes it
+const (
+	KindDeposit          = "deposit"
+	KindWithdrawal       = "withdrawal"
+	KindPayment          = "payment"
+	KindTransferOut      = "transfer_out"
+	KindTransferIn       = "transfer_in"
+	KindLoanPayment      = "loan_payment"
+	KindLoanDisbursement = "loan_disbursement"
+	KindInterest         = "interest"
+	KindFee              = "fee"
+	KindReversal         = "reversal"
+)
+
+// MaxLength is the longest descriptor kept, in characters; longer renderings are cut
+const MaxLength = 80
+
+// commonVariables can be used in every kind's template
+var commonVariables = []string{"account", "amount", "currency", "channel", "merchant", "memo", "reference", "transaction_id"}
+
+// kindVariables are the variables only some kinds have a value for
+var kindVariables = map[string][]string{
+	KindDeposit:          nil,
+	KindWithdrawal:       nil,
+	KindPayment:          nil,
+	KindTransferOut:      {"counterparty_account", "counterparty_name"},
+	KindTransferIn:       {"counterparty_account", "counterparty_name"},
+	KindLoanPayment:      {"loan_number"},
+	KindLoanDisbursement: {"loan_number"},
+	KindInterest:         nil,
+	KindFee:              {"fee_name", "original_transaction_id"},
+	KindReversal:         {"original_transaction_id", "original_descriptor"},
+}
+
+// Defaults are the built-in templates, used where a tenant has not configured its own
+var Defaults = map[string]string{
+	KindDeposit:          "DEPOSIT {merchant}",
+	KindWithdrawal:       "WITHDRAWAL {merchant}",
+	KindPayment:          "PAYMENT {merchant}",
+	KindTransferOut:      "TRANSFER TO {counterparty_account} / {counterparty_name}",
+	KindTransferIn:       "TRANSFER FROM {counterparty_account} / {counterparty_name}",
+	KindLoanPayment:      "LOAN PAYMENT {loan_number}",
+	KindLoanDisbursement: "LOAN DISBURSEMENT {loan_number}",
+	KindInterest:         "INTEREST",
+	KindFee:              "{fee_name} ON {original_transaction_id}",
+	KindReversal:         "REVERSAL {original_descriptor}",
+}
+
+// Template errors - handlers map these to client responses
+var (
+	ErrKind     = errors.New("kind must be one of " + strings.Join(Kinds(), ", "))
+	ErrTemplate = errors.New("template must be 1 to 200 characters with balanced braces")
+	ErrVariable = errors.New("unknown template variable")
+)
+
+// placeholder matches one {variable} in a template
+var placeholder = regexp.MustCompile(`\{([a-z_]*)\}`)
+
+// Kinds returns every template kind in name order
+func Kinds() []string {
+	kinds := make([]string, 0, len(kindVariables))
+	for kind := range kindVariables {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Variables returns the variables a kind's template may use
+func Variables(kind string) []string {
+	return append(append([]string{}, commonVariables...), kindVariables[kind]...)
+}
+
+// Validate checks a template for a kind: it must be short, have balanced braces and only use the
+// variables the kind has values for
+func Validate(kind, template string) error {
+	if _, ok := kindVariables[kind]; !ok {
+		return ErrKind
+	}
+	if strings.TrimSpace(template) == "" || utf8.RuneCountInString(template) > 200 {
+		return ErrTemplate
+	}
+	stripped := placeholder.ReplaceAllString(template, "")
+	if strings.ContainsAny(stripped, "{}") {
+		return ErrTemplate
+	}
+	allowed := Variables(kind)
+	for _, match := range placeholder.FindAllStringSubmatch(template, -1) {
+		if !contains(allowed, match[1]) {
+			return fmt.Errorf("%w {%s}; %s templates can use %s", ErrVariable, match[1], kind, braced(allowed))
+		}
+	}
+	return nil
+}
+
+// Render fills a template's variables and tidies the result into a descriptor: upper case, single spaces,
+// no separator left dangling by an empty variable, and at most MaxLength characters
+// A template that renders empty falls back to the kind's name
+func Render(kind, template string, values map[string]string) string {
+	text := placeholder.ReplaceAllStringFunc(template, func(match string) string {
+		return values[match[1:len(match)-1]]
+	})
+	text = strings.Join(strings.Fields(strings.ToUpper(text)), " ")
+	for {
+		trimmed := strings.TrimSpace(strings.Trim(text, "/-:,|"))
+		if trimmed == text {
+			break
+		}
+		text = trimmed
+	}
+	if text == "" {
+		text = strings.ToUpper(strings.ReplaceAll(kind, "_", " "))
+	}
+	if utf8.RuneCountInString(text) > MaxLength {
+		text = strings.TrimSpace(string([]rune(text)[:MaxLength]))
+	}
+	return text
+}
+
+func braced(names []string) string {
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = "{" + name + "}"
+	}
+	return strings.Join(out, ", ")
+}
+
+// contains reports whether list holds s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
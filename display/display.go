@@ -0,0 +1,108 @@
+package display
+
+import (
+	"banking-app/gl"
+	"banking-app/money"
+	"encoding/json"
+	"strings"
+)
+
+// MaskPrefix replaces all but the last four characters of a masked account number
+const MaskPrefix = "••••"
+
+// amountFields maps raw amount keys to the display field written beside them
+var amountFields = map[string]string{
+	"amount":  "display_amount",
+	"balance": "display_balance",
+}
+
+// Options controls how a response is presented to one caller
+type Options struct {
+	Mask     bool   // Replace account numbers with their last four digits
+	Amounts  bool   // Add display_* fields beside raw amounts
+	Currency string // Currency for amounts in objects that do not name their own
+}
+
+// MaskAccountNumber shows only the last four characters, e.g. "••••4821"
+// Internal ledger account numbers identify no customer and are left as they are
+func MaskAccountNumber(number string) string {
+	if number == "" || strings.HasPrefix(number, MaskPrefix) || gl.Code(number) != "" {
+		return number
+	}
+	if len(number) > 4 {
+		number = number[len(number)-4:]
+	}
+	return MaskPrefix + number
+}
+
+// Symbol returns a currency's display symbol, e.g. "€" for EUR, and whether it has one
+func Symbol(currency string) (string, bool) {
+	return money.Symbol(currency)
+}
+
+// Amount formats an amount with its currency symbol and thousands separators to the currency's minor unit,
+// e.g. "-$1,234.50" or "¥1,235". Currencies without a known symbol are shown with their code, e.g. "CHF 1,234.50"
+func Amount(value float64, currency string) string {
+	return money.FromFloat(value, currency).String()
+}
+
+// Apply returns value as a generic JSON tree with account numbers masked and display amounts added
+// Nested objects are covered at any depth (transaction -> account -> account_number), and an object
+// without its own currency takes the nearest enclosing one before falling back to opts.Currency
+func Apply(value interface{}, opts Options) (interface{}, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.UseNumber() // Raw values are re-encoded exactly as they were
+	var tree interface{}
+	if err := decoder.Decode(&tree); err != nil {
+		return nil, err
+	}
+	walk(tree, opts, opts.Currency)
+	return tree, nil
+}
+
+// MaskRow masks the account numbers of one flat row in place, e.g. a scanned export row
+func MaskRow(row map[string]interface{}) {
+	for key, value := range row {
+		if s, ok := value.(string); ok && isAccountNumberKey(key) {
+			row[key] = MaskAccountNumber(s)
+		}
+	}
+}
+
+// walk rewrites one node of a decoded JSON tree in place
+func walk(node interface{}, opts Options, currency string) {
+	switch n := node.(type) {
+	case []interface{}:
+		for _, item := range n {
+			walk(item, opts, currency)
+		}
+	case map[string]interface{}:
+		if own, ok := n["currency"].(string); ok && own != "" {
+			currency = own
+		}
+		for key, value := range n {
+			if s, ok := value.(string); ok && opts.Mask && isAccountNumberKey(key) {
+				n[key] = MaskAccountNumber(s)
+				continue
+			}
+			if number, ok := value.(json.Number); ok && opts.Amounts {
+				if field, ok := amountFields[key]; ok {
+					if f, err := number.Float64(); err == nil {
+						n[field] = Amount(f, currency)
+					}
+				}
+				continue
+			}
+			walk(value, opts, currency)
+		}
+	}
+}
+
+// isAccountNumberKey reports whether a JSON key holds an account number, e.g. account_number or to_account_number
+func isAccountNumberKey(key string) bool {
+	return key == "account_number" || strings.HasSuffix(key, "_account_number")
+}
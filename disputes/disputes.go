@@ -0,0 +1,243 @@
+package disputes
+
+import (
+	"banking-app/auth"
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/models"
+	"banking-app/notifications"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Dispute statuses, as declared in the dispute lifecycle
+const (
+	StatusOpen     = "open"
+	StatusResolved = "resolved"
+)
+
+// Sides of a dispute's thread
+const (
+	AuthorCustomer = "customer"
+	AuthorStaff    = "staff"
+)
+
+// ResourceDispute marks notifications about a dispute
+const ResourceDispute = "dispute"
+
+// MaxMessageLength bounds a thread post
+const MaxMessageLength = 4000
+
+// Thread errors - handlers map these to client responses
+var (
+	ErrResolved        = errors.New("dispute has already been resolved")
+	ErrMessageRequired = errors.New("a message body is required")
+	ErrMessageTooLong  = errors.New("a message is at most 4000 characters")
+	ErrStaffOnly       = errors.New("only staff can ask the customer for information")
+	ErrAssignee        = errors.New("disputes can only be assigned to active staff with the disputes permission")
+)
+
+// Config holds how long a customer has to answer a request for information and where staff are told of replies
+type Config struct {
+	WarningDays int    // Days an unanswered request for information waits before the customer is warned of closure
+	StaffEmail  string // Disputes mailbox told of customers' posts; none only logs them
+}
+
+// ConfigFromEnv reads DISPUTE_WARNING_DAYS (default 10) and DISPUTE_STAFF_EMAIL
+func ConfigFromEnv() Config {
+	days, err := strconv.Atoi(os.Getenv("DISPUTE_WARNING_DAYS"))
+	if err != nil || days <= 0 {
+		days = 10
+	}
+	return Config{WarningDays: days, StaffEmail: strings.TrimSpace(os.Getenv("DISPUTE_STAFF_EMAIL"))}
+}
+
+// Assign hands an open dispute to a staff member, who with the customer is then the only one in its thread
+func Assign(tx *gorm.DB, d *models.Dispute, username string) error {
+	if d.Status != StatusOpen {
+		return ErrResolved
+	}
+	var user models.User
+	err := tx.Where("username = ? AND status = ?", username, "active").First(&user).Error
+	if err == gorm.ErrRecordNotFound || (err == nil && !auth.Can(user.Role, auth.PermDisputes)) {
+		return ErrAssignee
+	}
+	if err != nil {
+		return err
+	}
+	result := tx.Model(&models.Dispute{}).Where("id = ? AND status = ?", d.ID, StatusOpen).Update("assigned_to", username)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrResolved
+	}
+	d.AssignedTo = username
+	return nil
+}
+
+// Post adds a message to an open dispute's thread and tells the other side
+// A staff post can ask the customer for information, which starts the wait for a closure warning; any customer
+// post answers it
+func Post(tx *gorm.DB, cfg Config, d *models.Dispute, authorType, author, body string, requestsInformation bool) (models.DisputeMessage, error) {
+	message := models.DisputeMessage{
+		DisputeID: d.ID, AuthorType: authorType, Author: author, Body: strings.TrimSpace(body),
+		RequestsInformation: requestsInformation,
+	}
+	switch {
+	case d.Status != StatusOpen:
+		return message, ErrResolved
+	case message.Body == "":
+		return message, ErrMessageRequired
+	case len([]rune(message.Body)) > MaxMessageLength:
+		return message, ErrMessageTooLong
+	case requestsInformation && authorType != AuthorStaff:
+		return message, ErrStaffOnly
+	}
+
+	if err := tx.Create(&message).Error; err != nil {
+		return message, err
+	}
+	var updates map[string]interface{}
+	if authorType == AuthorCustomer && d.InformationRequestedAt != nil {
+		updates = map[string]interface{}{"information_requested_at": nil, "closure_warned_at": nil}
+		d.InformationRequestedAt, d.ClosureWarnedAt = nil, nil
+	}
+	if requestsInformation {
+		updates = map[string]interface{}{"information_requested_at": message.CreatedAt, "closure_warned_at": nil}
+		d.InformationRequestedAt, d.ClosureWarnedAt = &message.CreatedAt, nil
+	}
+	if updates != nil {
+		result := tx.Model(&models.Dispute{}).Where("id = ? AND status = ?", d.ID, StatusOpen).Updates(updates)
+		if result.Error != nil {
+			return message, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return message, ErrResolved
+		}
+	}
+	return message, notify(tx, cfg, *d, message)
+}
+
+// notify tells the other side of a new post: the disputes mailbox of a customer's post, and the customer, by their
+// verified email, of a staff post
+func notify(tx *gorm.DB, cfg Config, d models.Dispute, message models.DisputeMessage) error {
+	if message.AuthorType == AuthorCustomer {
+		assigned := d.AssignedTo
+		if assigned == "" {
+			assigned = "nobody yet"
+		}
+		subject := fmt.Sprintf("New customer message on dispute %d", d.ID)
+		if cfg.StaffEmail == "" {
+			log.Printf("disputes: %s (assigned to %s); DISPUTE_STAFF_EMAIL is not set", subject, assigned)
+			return nil
+		}
+		return notifications.Enqueue(tx, &models.Notification{
+			Channel:      "email",
+			Recipient:    cfg.StaffEmail,
+			ResourceType: ResourceDispute,
+			ResourceID:   d.ID,
+			Subject:      subject,
+			Body:         fmt.Sprintf("The customer posted on dispute %d (assigned to %s):\n\n%s", d.ID, assigned, message.Body),
+		})
+	}
+
+	subject, lead := "New message about your dispute", "We have replied about your dispute of a %.2f posting."
+	if message.RequestsInformation {
+		subject, lead = "We need more information about your dispute", "We need more information about your dispute of a %.2f posting."
+	}
+	return toCustomer(tx, d, subject, fmt.Sprintf(lead+" Read and answer it in your dispute's messages.", d.Amount))
+}
+
+// toCustomer queues an email to the dispute's customer once their email is verified, adding it to their
+// communication log
+func toCustomer(tx *gorm.DB, d models.Dispute, subject, body string) error {
+	var customer models.Customer
+	tx.Select("id, email, email_verified").Limit(1).Find(&customer, d.CustomerID)
+	if customer.Email == "" || !customer.EmailVerified {
+		log.Printf("disputes: customer %d has no verified email for %q on dispute %d", d.CustomerID, subject, d.ID)
+		return nil
+	}
+	return notifications.Enqueue(tx, &models.Notification{
+		CustomerID:   d.CustomerID,
+		Channel:      "email",
+		Recipient:    customer.Email,
+		ResourceType: ResourceDispute,
+		ResourceID:   d.ID,
+		Subject:      subject,
+		Body:         body,
+	})
+}
+
+// MarkRead marks the other side's posts read by reader, through the given message or all of them when through is 0,
+// and returns how many were newly marked. Posts already read keep who read them first
+func MarkRead(tx *gorm.DB, d models.Dispute, readerType, reader string, through uint) (int64, error) {
+	query := tx.Model(&models.DisputeMessage{}).Where("dispute_id = ? AND author_type <> ? AND read_at IS NULL", d.ID, readerType)
+	if through != 0 {
+		query = query.Where("id <= ?", through)
+	}
+	result := query.Updates(map[string]interface{}{"read_at": clock.Now(), "read_by": reader})
+	return result.RowsAffected, result.Error
+}
+
+// Unread counts the other side's posts on a dispute that reader's side has not read
+func Unread(db *gorm.DB, d models.Dispute, readerType string) (int64, error) {
+	var count int64
+	err := db.Model(&models.DisputeMessage{}).Where("dispute_id = ? AND author_type <> ? AND read_at IS NULL", d.ID, readerType).Count(&count).Error
+	return count, err
+}
+
+// Warn is the dispute-closure-warnings job. An open dispute whose request for information has gone unanswered for
+// cfg.WarningDays gets one warning to the customer that it may be closed, copied to the disputes mailbox; it returns
+// how many were warned. The dispute stays open: closing it is left to the assigned staff member
+func Warn(db *gorm.DB, cfg Config, now time.Time) (int, error) {
+	var due []models.Dispute
+	err := db.Where("status = ? AND closure_warned_at IS NULL AND information_requested_at <= ?", StatusOpen, now.AddDate(0, 0, -cfg.WarningDays)).
+		Order("information_requested_at, id").Find(&due).Error
+	if err != nil {
+		return 0, err
+	}
+	warned := 0
+	for _, d := range due {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// A reply since the dispute was read clears the request, and then there is nothing to warn of
+			result := tx.Model(&models.Dispute{}).
+				Where("id = ? AND status = ? AND closure_warned_at IS NULL AND information_requested_at IS NOT NULL", d.ID, StatusOpen).
+				Update("closure_warned_at", now)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			warned++
+			asked := businessdays.Format(*d.InformationRequestedAt)
+			if err := toCustomer(tx, d, "Your dispute may be closed", fmt.Sprintf(
+				"We asked for more information about your dispute of a %.2f posting on %s and have not heard back. "+
+					"Please answer in your dispute's messages, or the dispute may be closed.", d.Amount, asked)); err != nil {
+				return err
+			}
+			if cfg.StaffEmail == "" {
+				log.Printf("disputes: customer warned of closure on dispute %d, unanswered since %s; DISPUTE_STAFF_EMAIL is not set", d.ID, asked)
+				return nil
+			}
+			return notifications.Enqueue(tx, &models.Notification{
+				Channel:      "email",
+				Recipient:    cfg.StaffEmail,
+				ResourceType: ResourceDispute,
+				ResourceID:   d.ID,
+				Subject:      fmt.Sprintf("Dispute %d: no reply in %d days", d.ID, cfg.WarningDays),
+				Body: fmt.Sprintf("The customer has not answered the request for information on dispute %d (assigned to %s) since %s "+
+					"and has been warned that it may be closed.", d.ID, d.AssignedTo, asked),
+			})
+		})
+		if err != nil {
+			return warned, err
+		}
+	}
+	return warned, nil
+}
@@ -0,0 +1,103 @@
+package disputes
+
+import (
+	"banking-app/clock"
+	"banking-app/database"
+	"banking-app/models"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB opens a migrated database in a temporary directory holding one open dispute of a verified customer
+func testDB(t *testing.T) (*gorm.DB, models.Dispute) {
+	t.Helper()
+	db, err := database.Open(filepath.Join(t.TempDir(), "disputes.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	db.Logger = logger.Default.LogMode(logger.Silent)
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	customer := models.Customer{FirstName: "Dispute", LastName: "Holder", Email: "dispute@example.test", EmailVerified: true, DateOfBirth: "1980-01-01", Status: "active"}
+	if err := db.Create(&customer).Error; err != nil {
+		t.Fatal(err)
+	}
+	dispute := models.Dispute{Status: StatusOpen, TransactionID: 1, AccountID: 1, CustomerID: customer.ID, Amount: 79.99,
+		Reason: "The goods never arrived", OpenedBy: "holder", AssignedTo: "assignee"}
+	if err := db.Create(&dispute).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db, dispute
+}
+
+func TestWarn(t *testing.T) {
+	start := time.Date(2026, time.March, 2, 9, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	clock.Use(fake)
+	t.Cleanup(func() { clock.Use(clock.System{}) })
+	db, dispute := testDB(t)
+	cfg := Config{WarningDays: 10, StaffEmail: "disputes@bank.test"}
+
+	// post adds a message as one side, reloading the dispute first as a handler would
+	post := func(authorType, body string, requestsInformation bool) {
+		t.Helper()
+		if err := db.First(&dispute, dispute.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Post(db, cfg, &dispute, authorType, "someone", body, requestsInformation); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// warn runs the job at start plus days, checking how many disputes it warned
+	warn := func(days float64, want int) {
+		t.Helper()
+		warned, err := Warn(db, cfg, start.Add(time.Duration(days*24)*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if warned != want {
+			t.Errorf("%g days in: %d warned, want %d", days, warned, want)
+		}
+	}
+
+	post(AuthorStaff, "Please send the tracking number", true)
+	warn(9.9, 0)
+	warn(10, 1)
+	warn(11, 0) // Once per request
+
+	var sent []models.Notification
+	db.Where("resource_type = ? AND subject = ?", ResourceDispute, "Your dispute may be closed").Find(&sent)
+	if len(sent) != 1 || sent[0].Recipient != "dispute@example.test" || sent[0].CustomerID != dispute.CustomerID {
+		t.Errorf("closure warnings %+v, want one to the customer", sent)
+	}
+	var copies int64
+	db.Model(&models.Notification{}).Where("recipient = ? AND subject LIKE ?", cfg.StaffEmail, "%no reply%").Count(&copies)
+	if copies != 1 {
+		t.Errorf("%d copies to the disputes mailbox, want 1", copies)
+	}
+
+	// The customer's reply answers the request; a later one starts the wait afresh
+	fake.Advance(12 * 24 * time.Hour)
+	post(AuthorCustomer, "It is 1Z999", false)
+	warn(40, 0)
+	post(AuthorStaff, "Thanks, noted", false)
+	warn(40, 0) // A post that asks nothing starts no wait
+	post(AuthorStaff, "And the invoice, please", true)
+	warn(21.9, 0)
+	warn(22, 1)
+
+	// Nothing is warned of once the dispute is resolved
+	post(AuthorStaff, "One more thing", true)
+	db.Model(&models.Dispute{}).Where("id = ?", dispute.ID).Update("status", StatusResolved)
+	warn(60, 0)
+}
@@ -0,0 +1,191 @@
+package documents
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Page geometry in points (US Letter)
+const (
+	pageWidth  = 612.0
+	pageHeight = 792.0
+	margin     = 50.0
+)
+
+// Fonts available to documents - the standard PDF base fonts need no embedding
+const (
+	FontBody    = "F1" // Helvetica
+	FontBold    = "F2" // Helvetica-Bold
+	FontMono    = "F3" // Courier, for aligned tables
+	objCatalog  = 1
+	objPages    = 2
+	firstFontID = 3
+)
+
+var baseFonts = []string{"Helvetica", "Helvetica-Bold", "Courier"}
+
+// PDF is a minimal streaming PDF writer for text documents such as statements
+// Pages are written as soon as they fill, so memory use is bounded by one page
+type PDF struct {
+	w       *countingWriter
+	offsets map[int]int64
+	nextID  int
+	pageIDs []int
+	page    *bytes.Buffer
+	y       float64
+	err     error
+}
+
+// NewPDF starts a document on w
+func NewPDF(w io.Writer) *PDF {
+	p := &PDF{
+		w:       &countingWriter{w: w},
+		offsets: map[int]int64{},
+		nextID:  firstFontID,
+	}
+	p.write("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	for _, font := range baseFonts {
+		p.object(p.nextID, fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", font))
+		p.nextID++
+	}
+	return p
+}
+
+// Heading writes a bold line with extra space above it
+func (p *PDF) Heading(text string) {
+	p.space(8)
+	p.text(FontBold, 13, text)
+}
+
+// Text writes a line of body text
+func (p *PDF) Text(text string) {
+	p.text(FontBody, 10, text)
+}
+
+// Mono writes a line in the fixed-width font, for column-aligned rows
+func (p *PDF) Mono(text string) {
+	p.text(FontMono, 8.5, text)
+}
+
+// NewPage ends the current page; the next line starts at the top of a fresh page
+func (p *PDF) NewPage() {
+	p.flushPage()
+}
+
+// Close finishes the page tree, cross-reference table and trailer
+func (p *PDF) Close() error {
+	p.flushPage()
+	if len(p.pageIDs) == 0 {
+		p.startPage()
+		p.flushPage()
+	}
+
+	kids := make([]string, len(p.pageIDs))
+	for i, id := range p.pageIDs {
+		kids[i] = fmt.Sprintf("%d 0 R", id)
+	}
+	p.object(objPages, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pageIDs)))
+	p.object(objCatalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", objPages))
+
+	xref := p.w.n
+	p.write(fmt.Sprintf("xref\n0 %d\n0000000000 65535 f \n", p.nextID))
+	for id := 1; id < p.nextID; id++ {
+		p.write(fmt.Sprintf("%010d 00000 n \n", p.offsets[id]))
+	}
+	p.write(fmt.Sprintf("trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", p.nextID, objCatalog, xref))
+	return p.err
+}
+
+// space moves the cursor down, breaking the page when needed
+func (p *PDF) space(points float64) {
+	if p.page == nil {
+		p.startPage()
+	}
+	p.y -= points
+}
+
+// text places one line at the cursor
+func (p *PDF) text(font string, size float64, text string) {
+	if p.page == nil || p.y-size < margin {
+		p.flushPage()
+		p.startPage()
+	}
+	p.y -= size + 4
+	fmt.Fprintf(p.page, "BT /%s %.1f Tf %.1f %.1f Td (%s) Tj ET\n", font, size, margin, p.y, escape(text))
+}
+
+// startPage begins buffering a new page
+func (p *PDF) startPage() {
+	p.page = &bytes.Buffer{}
+	p.y = pageHeight - margin
+}
+
+// flushPage writes the buffered page content and page object
+func (p *PDF) flushPage() {
+	if p.page == nil {
+		return
+	}
+	contentID, pageID := p.nextID, p.nextID+1
+	p.nextID += 2
+
+	p.object(contentID, fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.page.Len(), p.page.String()))
+
+	fonts := make([]string, len(baseFonts))
+	for i := range baseFonts {
+		fonts[i] = fmt.Sprintf("/F%d %d 0 R", i+1, firstFontID+i)
+	}
+	p.object(pageID, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << %s >> >> /Contents %d 0 R >>",
+		objPages, pageWidth, pageHeight, strings.Join(fonts, " "), contentID))
+
+	p.pageIDs = append(p.pageIDs, pageID)
+	p.page = nil
+}
+
+// object writes an indirect object and records its offset
+func (p *PDF) object(id int, body string) {
+	p.offsets[id] = p.w.n
+	p.write(fmt.Sprintf("%d 0 obj\n%s\nendobj\n", id, body))
+}
+
+func (p *PDF) write(s string) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = io.WriteString(p.w, s)
+}
+
+// escape makes text safe inside a PDF string literal; characters outside Latin-1 become '?'
+// except the bullet used by masked account numbers, which WinAnsi places at 0x95
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32:
+			b.WriteByte(' ')
+		case r == '•':
+			b.WriteByte(0x95)
+		case r > 255:
+			b.WriteByte('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+	return b.String()
+}
+
+// countingWriter tracks the byte offset needed for the cross-reference table
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,326 @@
+package duplicates
+
+import (
+	"banking-app/communications"
+	"banking-app/display"
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"banking-app/money"
+	"banking-app/notifications"
+	"banking-app/tenancy"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// Flag statuses
+const (
+	StatusFlagged   = "flagged"
+	StatusReversed  = "reversed"
+	StatusDismissed = "dismissed"
+)
+
+// Identifiers two payments can share - checked in this order
+const (
+	MatchAccount   = "account"   // The same beneficiary account on the books
+	MatchPayee     = "payee"     // The same payee, by normalized merchant name
+	MatchReference = "reference" // The same normalized reference
+)
+
+// CustomerActor decides flags reversed through the emailed link
+const CustomerActor = "customer"
+
+// paymentTypes are the debits that can repeat a payment; the channel they came through does not matter
+var paymentTypes = []string{"payment", "transfer", "withdrawal"}
+
+// Flag errors - handlers map these to client responses
+var (
+	ErrNotFlagged  = errors.New("duplicate payment has already been decided")
+	ErrLinkExpired = errors.New("reversal link has expired")
+)
+
+// Config holds the duplicate payment rule
+type Config struct {
+	Window  time.Duration // How far back a payment is compared; 0 disables detection
+	LinkTTL time.Duration // How long the emailed reversal link works
+	BaseURL string        // Public URL of the API the email points at
+}
+
+// ConfigFromEnv reads DUPLICATE_PAYMENT_WINDOW_MINUTES (default 30, 0 disables), DUPLICATE_PAYMENT_LINK_HOURS
+// (default 72) and PUBLIC_BASE_URL (default "http://localhost:8080")
+func ConfigFromEnv() Config {
+	minutes, err := strconv.Atoi(os.Getenv("DUPLICATE_PAYMENT_WINDOW_MINUTES"))
+	if err != nil || minutes < 0 {
+		minutes = 30
+	}
+	hours, err := strconv.Atoi(os.Getenv("DUPLICATE_PAYMENT_LINK_HOURS"))
+	if err != nil || hours <= 0 {
+		hours = 72
+	}
+	base := strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	return Config{Window: time.Duration(minutes) * time.Minute, LinkTTL: time.Duration(hours) * time.Hour, BaseURL: base}
+}
+
+// Payment is what matching compares of a posted debit
+type Payment struct {
+	AccountID             uint
+	Amount                float64
+	Currency              string // Currency of the account the payment came from
+	CounterpartyAccountID *uint  // Beneficiary account of a transfer
+	Payee                 string // Merchant or payee name
+	Reference             string // Reference sent with the payment
+	At                    time.Time
+}
+
+// PaymentOf returns the fields of a posted transaction in an account's currency that matching compares
+func PaymentOf(t models.Transaction, currency string) Payment {
+	return Payment{
+		AccountID:             t.AccountID,
+		Amount:                t.Amount,
+		Currency:              currency,
+		CounterpartyAccountID: t.CounterpartyAccountID,
+		Payee:                 t.MerchantName,
+		Reference:             t.Reference,
+		At:                    t.CreatedAt,
+	}
+}
+
+// NormalizeReference lower-cases a reference and strips all whitespace, so "INV 1001 " and "inv1001" are the same
+func NormalizeReference(reference string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, reference)
+}
+
+// Match reports whether later repeats earlier: the same account, currency and amount, made no more than window
+// after it, to the same beneficiary account, payee or normalized reference. It returns which identifier matched and
+// its normalized value
+func Match(earlier, later Payment, window time.Duration) (string, string, bool) {
+	if earlier.AccountID == 0 || earlier.AccountID != later.AccountID || earlier.Currency != later.Currency ||
+		money.Cents(earlier.Amount) != money.Cents(later.Amount) {
+		return "", "", false
+	}
+	if gap := later.At.Sub(earlier.At); gap < 0 || gap > window {
+		return "", "", false
+	}
+	if earlier.CounterpartyAccountID != nil && later.CounterpartyAccountID != nil && *earlier.CounterpartyAccountID == *later.CounterpartyAccountID {
+		return MatchAccount, strconv.FormatUint(uint64(*later.CounterpartyAccountID), 10), true
+	}
+	if payee := NormalizeReference(later.Payee); payee != "" && payee == NormalizeReference(earlier.Payee) {
+		return MatchPayee, payee, true
+	}
+	if reference := NormalizeReference(later.Reference); reference != "" && reference == NormalizeReference(earlier.Reference) {
+		return MatchReference, reference, true
+	}
+	return "", "", false
+}
+
+// Detect flags a debit that has just posted when it repeats a payment made from the same account within the window,
+// emailing the holder a link that reverses it. The debit itself stands. An earlier payment that was reversed is not
+// compared. Called once the posting has committed; failures are logged, never returned to the payer
+func Detect(db *gorm.DB, cfg Config, account models.Account, txn models.Transaction) {
+	if cfg.Window <= 0 || !isPayment(txn) || account.CustomerID == 0 || account.AccountType == gl.AccountType {
+		return
+	}
+	// Staff, the approval queue and the review job post through here too, so the flag takes the account's tenant
+	db = db.WithContext(tenancy.NewContext(db.Statement.Context, account.TenantID))
+
+	reversed := db.Model(&models.Transaction{}).Select("reversal_of_id").Where("reversal_of_id IS NOT NULL")
+	var earlier []models.Transaction
+	err := db.Where("account_id = ? AND id < ? AND transaction_type IN ? AND amount = ? AND created_at >= ? AND reversal_of_id IS NULL",
+		txn.AccountID, txn.ID, paymentTypes, txn.Amount, txn.CreatedAt.Add(-cfg.Window)).
+		Where("id NOT IN (?)", reversed).Order("id DESC").Find(&earlier).Error
+	if err != nil {
+		log.Printf("duplicates: failed to load payments before transaction %d: %v", txn.ID, err)
+		return
+	}
+	later := PaymentOf(txn, account.Currency)
+	for _, original := range earlier {
+		matchedOn, counterparty, ok := Match(PaymentOf(original, account.Currency), later, cfg.Window)
+		if !ok {
+			continue
+		}
+		if err := flag(db, cfg, account, original, txn, matchedOn, counterparty); err != nil {
+			log.Printf("duplicates: failed to flag transaction %d as repeating %d: %v", txn.ID, original.ID, err)
+		}
+		return
+	}
+}
+
+// isPayment reports whether a posting is a customer debit that can repeat a payment
+func isPayment(t models.Transaction) bool {
+	if t.ReversalOfID != nil || t.OffsetOfID != nil || t.FeeOfID != nil {
+		return false
+	}
+	for _, paymentType := range paymentTypes {
+		if t.TransactionType == paymentType {
+			return true
+		}
+	}
+	return false
+}
+
+// flag records the repeat and emails the holder the reversal link, or logs when they have no verified email
+func flag(db *gorm.DB, cfg Config, account models.Account, original, txn models.Transaction, matchedOn, counterparty string) error {
+	var customer models.Customer
+	if err := db.Select("id, email, email_verified").Limit(1).Find(&customer, account.CustomerID).Error; err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		duplicate := models.DuplicatePayment{
+			Status:                StatusFlagged,
+			AccountID:             account.ID,
+			CustomerID:            account.CustomerID,
+			TransactionID:         txn.ID,
+			OriginalTransactionID: original.ID,
+			Amount:                txn.Amount,
+			MatchedOn:             matchedOn,
+			Counterparty:          counterparty,
+			Channel:               txn.Channel,
+			OriginalChannel:       original.Channel,
+		}
+		if customer.Email == "" || !customer.EmailVerified {
+			log.Printf("duplicates: customer %d has no verified email for the repeated payment %d", account.CustomerID, txn.ID)
+			return tx.Create(&duplicate).Error
+		}
+
+		token, err := newToken()
+		if err != nil {
+			return err
+		}
+		expires := txn.CreatedAt.Add(cfg.LinkTTL)
+		duplicate.ReversalTokenHash, duplicate.ReversalExpiresAt = HashToken(token), &expires
+		if err := tx.Create(&duplicate).Error; err != nil {
+			return err
+		}
+		minutes := int(math.Round(txn.CreatedAt.Sub(original.CreatedAt).Minutes()))
+		return notifications.Enqueue(tx, &models.Notification{
+			CustomerID:   account.CustomerID,
+			Channel:      "email",
+			ResourceType: communications.ResourceDuplicatePayment,
+			ResourceID:   duplicate.ID,
+			Recipient:    customer.Email,
+			Subject:      "Did you mean to pay twice?",
+			Body: fmt.Sprintf("A %s of %.2f %s from account %s matches one made %d minutes earlier to the same %s. "+
+				"Both were paid. If you meant to pay once, reverse the second one within %d hours: %s/api/v1/duplicate-payments/%s/reverse",
+				txn.TransactionType, txn.Amount, account.Currency, display.MaskAccountNumber(account.AccountNumber), minutes,
+				matchedOn, int(cfg.LinkTTL.Hours()), cfg.BaseURL, token),
+		})
+	})
+}
+
+// Reverse reverses the later payment of a flag and marks it reversed. A transfer is reversed on both accounts, so
+// the beneficiary must still hold the amount. It returns the accounts whose balances changed
+func Reverse(db *gorm.DB, duplicate *models.DuplicatePayment, by, note string, now time.Time) ([]models.Account, error) {
+	if !lifecycle.Allows(lifecycle.DuplicatePayment, duplicate.Status, StatusReversed) {
+		return nil, ErrNotFlagged
+	}
+	var accounts []models.Account
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var txn models.Transaction
+		if err := tx.First(&txn, duplicate.TransactionID).Error; err != nil {
+			return err
+		}
+		reason := "repeated payment"
+		var transfer models.Transfer
+		if err := tx.Where("debit_transaction_id = ?", txn.ID).Limit(1).Find(&transfer).Error; err != nil {
+			return err
+		}
+		if transfer.ID != 0 {
+			var credit models.Transaction
+			if err := tx.First(&credit, transfer.CreditTransactionID).Error; err != nil {
+				return err
+			}
+			_, beneficiary, err := ledger.Reverse(tx, credit, reason)
+			if err != nil {
+				return err
+			}
+			accounts = append(accounts, beneficiary)
+		}
+		reversal, account, err := ledger.Reverse(tx, txn, reason)
+		if err != nil {
+			return err
+		}
+		accounts = append(accounts, account)
+		duplicate.ReversalTransactionID = &reversal.ID
+		return decide(tx, duplicate, StatusReversed, by, note, now)
+	})
+	return accounts, err
+}
+
+// Dismiss records that a flagged payment was meant, leaving both payments posted
+func Dismiss(db *gorm.DB, duplicate *models.DuplicatePayment, by, note string, now time.Time) error {
+	if !lifecycle.Allows(lifecycle.DuplicatePayment, duplicate.Status, StatusDismissed) {
+		return ErrNotFlagged
+	}
+	return decide(db, duplicate, StatusDismissed, by, note, now)
+}
+
+// decide moves a flag out of flagged, failing with ErrNotFlagged when another decision got there first
+func decide(tx *gorm.DB, duplicate *models.DuplicatePayment, status, by, note string, now time.Time) error {
+	result := tx.Model(duplicate).Where("status = ?", StatusFlagged).Updates(map[string]interface{}{
+		"status":                  status,
+		"reversal_transaction_id": duplicate.ReversalTransactionID,
+		"decided_at":              now,
+		"decided_by":              by,
+		"decision_note":           note,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFlagged
+	}
+	return tx.First(duplicate, duplicate.ID).Error
+}
+
+// FindByToken looks up the flag of an emailed reversal token
+// Unknown tokens return gorm.ErrRecordNotFound and expired ones ErrLinkExpired
+func FindByToken(db *gorm.DB, token string, now time.Time) (models.DuplicatePayment, error) {
+	var duplicate models.DuplicatePayment
+	if token == "" {
+		return duplicate, gorm.ErrRecordNotFound
+	}
+	if err := db.Where("reversal_token_hash = ?", HashToken(token)).First(&duplicate).Error; err != nil {
+		return duplicate, err
+	}
+	if duplicate.ReversalExpiresAt == nil || now.After(*duplicate.ReversalExpiresAt) {
+		return duplicate, ErrLinkExpired
+	}
+	return duplicate, nil
+}
+
+// HashToken is the stored form of a reversal token; the token itself is only ever emailed
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken returns a random, URL-safe reversal token
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,169 @@
+package duplicates
+
+import (
+	"banking-app/clock"
+	"banking-app/database"
+	"banking-app/ledger"
+	"banking-app/models"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// start is when the first payment in each test is made
+var start = time.Date(2026, time.March, 2, 9, 0, 0, 0, time.UTC)
+
+func TestMatch(t *testing.T) {
+	landlord, other := uint(7), uint(8)
+	bill := Payment{AccountID: 1, Amount: 120, Currency: "USD", CounterpartyAccountID: &landlord, Payee: "City Water", Reference: "INV 1001", At: start}
+	tests := []struct {
+		name      string
+		later     func(p *Payment)
+		matchedOn string // Empty when it is not a repeat
+		value     string
+	}{
+		{"the same beneficiary account", func(p *Payment) { p.At = start.Add(5 * time.Minute) }, MatchAccount, "7"},
+		{"the same payee to another account", func(p *Payment) { p.CounterpartyAccountID = &other }, MatchPayee, "citywater"},
+		{"the same payee at the end of the window", func(p *Payment) { p.CounterpartyAccountID, p.At = nil, start.Add(30*time.Minute) }, MatchPayee, "citywater"},
+		{"the same payee a second outside the window", func(p *Payment) { p.CounterpartyAccountID, p.At = nil, start.Add(30*time.Minute+time.Second) }, "", ""},
+		{"the same payee made before the earlier one", func(p *Payment) { p.CounterpartyAccountID, p.At = nil, start.Add(-time.Minute) }, "", ""},
+		{"the same reference in other case and spacing", func(p *Payment) { p.CounterpartyAccountID, p.Payee, p.Reference = nil, "", " inv1001 " }, MatchReference, "inv1001"},
+		{"a payment floating point noise away", func(p *Payment) { p.Amount = 0.1 + 0.2 + 119.7 }, MatchAccount, "7"},
+		{"another amount", func(p *Payment) { p.Amount = 120.01 }, "", ""},
+		{"another payee and reference", func(p *Payment) { p.CounterpartyAccountID, p.Payee, p.Reference = &other, "Power Co", "INV 1002" }, "", ""},
+		{"another of the customer's accounts", func(p *Payment) { p.AccountID = 2 }, "", ""},
+		{"a different currency", func(p *Payment) { p.Currency = "EUR" }, "", ""},
+		{"a payment with nothing to compare", func(p *Payment) { p.CounterpartyAccountID, p.Payee, p.Reference = nil, "", "" }, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			later := bill
+			later.At = start.Add(time.Minute)
+			tt.later(&later)
+			matchedOn, value, ok := Match(bill, later, 30*time.Minute)
+			if ok != (tt.matchedOn != "") || matchedOn != tt.matchedOn || value != tt.value {
+				t.Errorf("Match = %q, %q, %v; want %q, %q", matchedOn, value, ok, tt.matchedOn, tt.value)
+			}
+		})
+	}
+
+	if _, _, ok := Match(Payment{Amount: 120, Payee: "City Water", At: start}, Payment{Amount: 120, Payee: "City Water", At: start}, time.Hour); ok {
+		t.Errorf("payments without an account match")
+	}
+}
+
+// testDB opens a migrated database in a temporary directory on a fake clock stopped at start, holding an account
+// funded before it
+func testDB(t *testing.T) (*gorm.DB, *clock.Fake, models.Account) {
+	t.Helper()
+	fake := clock.NewFake(start.Add(-time.Hour))
+	clock.Use(fake)
+	t.Cleanup(func() { clock.Use(clock.System{}) })
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "duplicates.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	db.Logger = logger.Default.LogMode(logger.Silent)
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	customer := models.Customer{FirstName: "Double", LastName: "Payer", Email: "double@example.test", EmailVerified: true,
+		DateOfBirth: "1980-01-01", Status: "active"}
+	if err := db.Create(&customer).Error; err != nil {
+		t.Fatal(err)
+	}
+	account := models.Account{CustomerID: customer.ID, AccountNumber: "DUP-1", AccountType: "checking", Currency: "USD", Status: "active"}
+	if err := db.Create(&account).Error; err != nil {
+		t.Fatal(err)
+	}
+	pay(t, db, fake, account, "deposit", 0)
+	return db, fake, account
+}
+
+// pay posts 1000 for a deposit or a 120 bill payment a number of minutes after start and runs detection on it
+func pay(t *testing.T, db *gorm.DB, fake *clock.Fake, account models.Account, transactionType string, minutes int) models.Transaction {
+	t.Helper()
+	fake.Set(start.Add(time.Duration(minutes) * time.Minute))
+	txn := models.Transaction{AccountID: account.ID, TransactionType: transactionType, Amount: 120, MerchantName: "City Water"}
+	if transactionType == "deposit" {
+		txn.Amount, txn.MerchantName = 1000, ""
+	}
+	if _, err := ledger.Post(db, &txn, nil); err != nil {
+		t.Fatal(err)
+	}
+	Detect(db, Config{Window: 30 * time.Minute, LinkTTL: time.Hour}, account, txn)
+	return txn
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		earlier  []int // Minutes after start of the earlier payments
+		reversed []int // Indexes into earlier of payments reversed before the repeat
+		repeat   int   // Minutes after start of the repeat
+		original int   // Index into earlier of the payment it repeats, or -1 when it is not flagged
+	}{
+		{"a repeat inside the window", []int{0}, nil, 10, 0},
+		{"a repeat outside the window", []int{0}, nil, 31, -1},
+		{"a repeat of a reversed payment", []int{0}, []int{0}, 10, -1},
+		{"a repeat of two payments", []int{0, 5}, nil, 10, 1},
+		{"a repeat of two payments, the latest reversed", []int{0, 5}, []int{1}, 10, 0},
+		{"a repeat of two payments, both reversed", []int{0, 5}, []int{0, 1}, 10, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake, account := testDB(t)
+			var earlier []models.Transaction
+			for _, minutes := range tt.earlier {
+				earlier = append(earlier, pay(t, db, fake, account, "payment", minutes))
+			}
+			for _, i := range tt.reversed {
+				if _, _, err := ledger.Reverse(db, earlier[i], "test"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			repeat := pay(t, db, fake, account, "withdrawal", tt.repeat)
+
+			// Earlier payments inside the window flag each other; only the repeat's flag is checked
+			var flags []models.DuplicatePayment
+			db.Where("transaction_id = ?", repeat.ID).Find(&flags)
+			if tt.original < 0 {
+				if len(flags) != 0 {
+					t.Errorf("flagged %+v, want nothing", flags)
+				}
+				return
+			}
+			if len(flags) != 1 || flags[0].OriginalTransactionID != earlier[tt.original].ID ||
+				flags[0].Status != StatusFlagged || flags[0].MatchedOn != MatchPayee {
+				t.Errorf("flags %+v, want transaction %d flagged as repeating %d", flags, repeat.ID, earlier[tt.original].ID)
+			}
+		})
+	}
+}
+
+func TestDetectSkipsNonPayments(t *testing.T) {
+	db, fake, account := testDB(t)
+	payment := pay(t, db, fake, account, "payment", 0)
+	fake.Set(start.Add(time.Minute))
+	reversal, _, err := ledger.Reverse(db, payment, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Detect(db, Config{Window: 30 * time.Minute}, account, reversal)
+	pay(t, db, fake, account, "deposit", 2)
+
+	var flags int64
+	db.Model(&models.DuplicatePayment{}).Count(&flags)
+	if flags != 0 {
+		t.Errorf("%d flags for a reversal and a deposit, want none", flags)
+	}
+}
@@ -0,0 +1,137 @@
+package eligibility
+
+import (
+	"banking-app/models"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Criteria a product can impose
+const (
+	CriterionMaxPerCustomer = "max_per_customer"
+	CriterionMinAge         = "min_age"
+	CriterionMinTenure      = "min_tenure"
+	CriterionKYCLevel       = "kyc_level"
+)
+
+// Criteria lists every criterion in evaluation order
+var Criteria = []string{CriterionMaxPerCustomer, CriterionMinAge, CriterionMinTenure, CriterionKYCLevel}
+
+// Failure is one criterion a customer does not meet
+type Failure struct {
+	Criterion string `json:"criterion"`
+	Message   string `json:"message"`
+	Required  int    `json:"required"`
+	Actual    int    `json:"actual"`
+}
+
+// Offer is a catalog product with the customer's eligibility for it
+type Offer struct {
+	Product  models.Product `json:"product"`
+	Eligible bool           `json:"eligible"`
+	Failures []Failure      `json:"failed_criteria"`
+}
+
+// Lookup returns the catalog product for an account type, or nil when the type has no catalog entry
+func Lookup(db *gorm.DB, accountType string) (*models.Product, error) {
+	var product models.Product
+	err := db.Where("account_type = ?", accountType).First(&product).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// Evaluate checks a customer against every rule of a product and returns the criteria that fail
+func Evaluate(db *gorm.DB, product models.Product, customer models.Customer, now time.Time) ([]Failure, error) {
+	failures := []Failure{}
+
+	if product.MaxPerCustomer > 0 {
+		var held int64
+		err := db.Model(&models.Account{}).
+			Where("customer_id = ? AND account_type = ? AND status <> 'closed'", customer.ID, product.AccountType).
+			Count(&held).Error
+		if err != nil {
+			return nil, err
+		}
+		if int(held) >= product.MaxPerCustomer {
+			failures = append(failures, Failure{
+				Criterion: CriterionMaxPerCustomer,
+				Message:   fmt.Sprintf("Customer already holds %d of at most %d %s accounts", held, product.MaxPerCustomer, product.AccountType),
+				Required:  product.MaxPerCustomer,
+				Actual:    int(held),
+			})
+		}
+	}
+
+	if product.MinAgeYears > 0 {
+		age, known := Age(customer.DateOfBirth, now)
+		if !known || age < product.MinAgeYears {
+			message := fmt.Sprintf("Customer must be at least %d years old", product.MinAgeYears)
+			if !known {
+				message += "; date of birth is not on file"
+			}
+			failures = append(failures, Failure{Criterion: CriterionMinAge, Message: message, Required: product.MinAgeYears, Actual: age})
+		}
+	}
+
+	if product.MinTenureDays > 0 {
+		tenure := int(now.Sub(customer.CreatedAt).Hours() / 24)
+		if tenure < product.MinTenureDays {
+			failures = append(failures, Failure{
+				Criterion: CriterionMinTenure,
+				Message:   fmt.Sprintf("Customer must have been with the bank for %d days", product.MinTenureDays),
+				Required:  product.MinTenureDays,
+				Actual:    tenure,
+			})
+		}
+	}
+
+	if product.RequiredKYCLevel > 0 && customer.KYCLevel < product.RequiredKYCLevel {
+		failures = append(failures, Failure{
+			Criterion: CriterionKYCLevel,
+			Message:   fmt.Sprintf("KYC level %d is required", product.RequiredKYCLevel),
+			Required:  product.RequiredKYCLevel,
+			Actual:    customer.KYCLevel,
+		})
+	}
+	return failures, nil
+}
+
+// ForCustomer evaluates every catalog product for a customer
+func ForCustomer(db *gorm.DB, customer models.Customer, now time.Time) ([]Offer, error) {
+	var products []models.Product
+	if err := db.Order("account_type").Find(&products).Error; err != nil {
+		return nil, err
+	}
+	offers := make([]Offer, 0, len(products))
+	for _, product := range products {
+		failures, err := Evaluate(db, product, customer, now)
+		if err != nil {
+			return nil, err
+		}
+		offers = append(offers, Offer{Product: product, Eligible: len(failures) == 0, Failures: failures})
+	}
+	return offers, nil
+}
+
+// Age is the customer's age in whole years on a date; known is false when the date of birth is missing or invalid
+func Age(dateOfBirth string, now time.Time) (age int, known bool) {
+	if len(dateOfBirth) < 10 {
+		return 0, false
+	}
+	born, err := time.Parse("2006-01-02", dateOfBirth[:10])
+	if err != nil {
+		return 0, false
+	}
+	age = now.Year() - born.Year()
+	if now.Month() < born.Month() || (now.Month() == born.Month() && now.Day() < born.Day()) {
+		age--
+	}
+	return age, true
+}
@@ -0,0 +1,101 @@
+package enrichment
+
+import (
+	"banking-app/models"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Supported transaction channels
+var Channels = []string{"branch", "atm", "online", "api", "card"}
+
+// Supported rule match types
+var MatchTypes = []string{"contains", "prefix", "regex"}
+
+// DefaultChannel is assigned when a creation path does not specify one
+const DefaultChannel = "api"
+
+// LoadRules returns all enrichment rules in evaluation order
+func LoadRules(db *gorm.DB) ([]models.EnrichmentRule, error) {
+	var rules []models.EnrichmentRule
+	err := db.Order("priority ASC, id ASC").Find(&rules).Error
+	return rules, err
+}
+
+// Match reports whether a rule applies to a transaction description
+// Matching is case-insensitive; invalid regex patterns never match
+func Match(rule models.EnrichmentRule, description string) bool {
+	desc := strings.ToUpper(strings.TrimSpace(description))
+	pattern := strings.ToUpper(strings.TrimSpace(rule.Pattern))
+	if desc == "" || pattern == "" {
+		return false
+	}
+
+	switch rule.MatchType {
+	case "contains":
+		return strings.Contains(desc, pattern)
+	case "prefix":
+		return strings.HasPrefix(desc, pattern)
+	case "regex":
+		re, err := regexp.Compile("(?i)" + rule.Pattern)
+		return err == nil && re.MatchString(description)
+	}
+	return false
+}
+
+// Apply fills in missing merchant name and category from the first matching rule
+// Values supplied explicitly by the creation path are never overwritten
+func Apply(rules []models.EnrichmentRule, txn *models.Transaction) bool {
+	if txn.MerchantName != "" {
+		return false
+	}
+
+	text := txn.Description
+	if text == "" {
+		text = txn.Reference
+	}
+
+	for _, rule := range rules {
+		if Match(rule, text) {
+			txn.MerchantName = rule.MerchantName
+			if txn.CategoryCode == "" {
+				txn.CategoryCode = rule.CategoryCode
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Backfill enriches existing transactions that have no merchant name yet
+// Processes rows in batches so large histories do not load into memory at once
+func Backfill(db *gorm.DB) (int, error) {
+	rules, err := LoadRules(db)
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	var batch []models.Transaction
+	result := db.Where("merchant_name = '' OR merchant_name IS NULL").
+		FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+			for i := range batch {
+				if !Apply(rules, &batch[i]) {
+					continue
+				}
+				err := tx.Model(&models.Transaction{}).Where("id = ?", batch[i].ID).Updates(map[string]interface{}{
+					"merchant_name": batch[i].MerchantName,
+					"category_code": batch[i].CategoryCode,
+				}).Error
+				if err != nil {
+					return err
+				}
+				updated++
+			}
+			return nil
+		})
+
+	return updated, result.Error
+}
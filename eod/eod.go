@@ -0,0 +1,252 @@
+package eod
+
+import (
+	"banking-app/jobs"
+	"banking-app/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// JobName is the registered name of the end-of-day job
+const JobName = "eod"
+
+// Posting pause modes
+const (
+	ModeQueue  = "queue"  // Postings wait for the pause to end, up to the queue timeout, then are refused
+	ModeReject = "reject" // Postings are refused at once
+)
+
+// DrainTimeout bounds how long the cutoff waits for postings already in flight
+const DrainTimeout = 30 * time.Second
+
+// StepWait bounds how long a step waits for a run of its job already in progress, e.g. a manual one
+const StepWait = time.Minute
+
+// Orchestrator errors - handlers map these to client responses
+var (
+	ErrNotEOD     = errors.New("run is not an end-of-day run")
+	ErrNotFailed  = errors.New("only a failed end-of-day run can be resumed")
+	ErrSuperseded = errors.New("a later end-of-day run has started since; resume that one instead")
+)
+
+// Config holds how postings are held during end of day
+type Config struct {
+	Mode         string        // queue or reject
+	QueueTimeout time.Duration // How long a queued posting waits before it is refused
+	RetryAfter   int           // Seconds, sent as Retry-After on refused postings
+	MaxPause     time.Duration // The pause lifts on its own after this, should a run never finish
+}
+
+// ConfigFromEnv reads EOD_POSTING_MODE (queue or reject, default queue), EOD_QUEUE_SECONDS (default 30),
+// EOD_RETRY_AFTER_SECONDS (default 30) and EOD_MAX_PAUSE_SECONDS (default 900)
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{Mode: ModeQueue}
+	switch mode := strings.TrimSpace(os.Getenv("EOD_POSTING_MODE")); mode {
+	case "":
+	case ModeQueue, ModeReject:
+		cfg.Mode = mode
+	default:
+		return cfg, fmt.Errorf("invalid EOD_POSTING_MODE %q: must be queue or reject", mode)
+	}
+	queue, retryAfter, maxPause := 30, 30, 900
+	settings := []struct {
+		name string
+		into *int
+	}{
+		{"EOD_QUEUE_SECONDS", &queue},
+		{"EOD_RETRY_AFTER_SECONDS", &retryAfter},
+		{"EOD_MAX_PAUSE_SECONDS", &maxPause},
+	}
+	for _, setting := range settings {
+		raw := strings.TrimSpace(os.Getenv(setting.name))
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return cfg, fmt.Errorf("invalid %s %q: must be a whole number of 1 or more", setting.name, raw)
+		}
+		*setting.into = n
+	}
+	cfg.QueueTimeout = time.Duration(queue) * time.Second
+	cfg.RetryAfter = retryAfter
+	cfg.MaxPause = time.Duration(maxPause) * time.Second
+	return cfg, nil
+}
+
+// Step is one stage of end of day: a registered job, and the earlier steps that must succeed before it runs
+type Step struct {
+	Job       string   `json:"job"`
+	DependsOn []string `json:"depends_on,omitempty"`
+}
+
+// Orchestrator is the end-of-day job: it pauses postings, runs the steps in order and resumes postings
+// Each step is a run of its job recorded with this run as its parent, so the job run history holds every
+// step's duration and outcome
+type Orchestrator struct {
+	DB        *gorm.DB
+	Scheduler *jobs.Scheduler
+	Window    *Window
+	Steps     []Step
+}
+
+// New returns an orchestrator for steps, checking each step depends only on steps before it
+func New(db *gorm.DB, scheduler *jobs.Scheduler, window *Window, steps []Step) (*Orchestrator, error) {
+	seen := make(map[string]bool, len(steps))
+	for _, step := range steps {
+		for _, dependency := range step.DependsOn {
+			if !seen[dependency] {
+				return nil, fmt.Errorf("eod step %s depends on %s, which is not an earlier step", step.Job, dependency)
+			}
+		}
+		seen[step.Job] = true
+	}
+	return &Orchestrator{DB: db, Scheduler: scheduler, Window: window, Steps: steps}, nil
+}
+
+// Name implements jobs.Job
+func (o *Orchestrator) Name() string { return JobName }
+
+// Run implements jobs.Job. Steps whose dependencies did not succeed are recorded as skipped, and any failed or
+// skipped step fails the run. A run resuming a failed one leaves out the steps that already succeeded there.
+// Postings are resumed however the run ends; it returns the number of steps that succeeded
+func (o *Orchestrator) Run(ctx context.Context) (int, error) {
+	run, ok := jobs.CurrentRun(ctx)
+	if !ok {
+		return 0, errors.New("end of day runs through the scheduler")
+	}
+	done, err := o.succeeded(run)
+	if err != nil {
+		return 0, err
+	}
+	if err := o.Window.Pause(run.ID); err != nil {
+		return 0, fmt.Errorf("pausing postings: %w", err)
+	}
+	defer func() {
+		if err := o.Window.Resume(); err != nil {
+			log.Printf("eod: resuming postings failed: %v", err)
+		}
+	}()
+
+	succeeded := 0
+	var failed, skipped []string
+	for _, step := range o.Steps {
+		if done[step.Job] {
+			continue
+		}
+		if blocker := blockedBy(step, done); blocker != "" {
+			o.record(run, step.Job, jobs.StatusSkipped, fmt.Sprintf("depends on %s, which did not succeed", blocker))
+			skipped = append(skipped, step.Job)
+			continue
+		}
+		stepRun, err := o.Scheduler.RunStep(step.Job, run, StepWait)
+		if stepRun.ID == 0 {
+			o.record(run, step.Job, jobs.StatusFailed, fmt.Sprintf("could not start: %v", err))
+			failed = append(failed, step.Job)
+			continue
+		}
+		if stepRun.Status != jobs.StatusSucceeded {
+			failed = append(failed, step.Job)
+			continue
+		}
+		done[step.Job] = true
+		succeeded++
+	}
+
+	if len(failed) > 0 {
+		message := "failed: " + strings.Join(failed, ", ")
+		if len(skipped) > 0 {
+			message += "; skipped: " + strings.Join(skipped, ", ")
+		}
+		return succeeded, errors.New(message)
+	}
+	return succeeded, nil
+}
+
+// blockedBy returns the first dependency of step that has not succeeded, or "" when it may run
+func blockedBy(step Step, done map[string]bool) string {
+	for _, dependency := range step.DependsOn {
+		if !done[dependency] {
+			return dependency
+		}
+	}
+	return ""
+}
+
+// record writes the run of a step that did not run, so the history still holds every step
+func (o *Orchestrator) record(parent models.JobRun, job, status, message string) {
+	now := time.Now()
+	run := models.JobRun{
+		JobName:     job,
+		Trigger:     jobs.TriggerStep,
+		TriggeredBy: parent.JobName,
+		Instance:    o.Scheduler.Instance,
+		Status:      status,
+		StartedAt:   now,
+		FinishedAt:  &now,
+		Error:       message,
+		ParentRunID: &parent.ID,
+	}
+	if err := o.DB.Create(&run).Error; err != nil {
+		log.Printf("eod: recording %s step failed: %v", job, err)
+	}
+}
+
+// succeeded lists the steps that succeeded in the runs run resumes, following the chain back to the first
+func (o *Orchestrator) succeeded(run models.JobRun) (map[string]bool, error) {
+	done := make(map[string]bool)
+	for from := run.ResumedFromID; from != nil; {
+		var names []string
+		if err := o.DB.Model(&models.JobRun{}).Where("parent_run_id = ? AND status = ?", *from, jobs.StatusSucceeded).
+			Pluck("job_name", &names).Error; err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			done[name] = true
+		}
+		var earlier models.JobRun
+		if err := o.DB.Select("id, resumed_from_id").First(&earlier, *from).Error; err != nil {
+			return nil, err
+		}
+		from = earlier.ResumedFromID
+	}
+	return done, nil
+}
+
+// Resume starts a run picking up a failed end-of-day run from its failed step, once the cause is fixed
+// Only the latest end-of-day run can be resumed
+func (o *Orchestrator) Resume(runID uint, by string) (models.JobRun, error) {
+	var run models.JobRun
+	if err := o.DB.First(&run, runID).Error; err != nil {
+		return run, err
+	}
+	if run.JobName != JobName {
+		return run, ErrNotEOD
+	}
+	if run.Status != jobs.StatusFailed {
+		return run, ErrNotFailed
+	}
+	var later int64
+	if err := o.DB.Model(&models.JobRun{}).Where("job_name = ? AND id > ?", JobName, run.ID).Count(&later).Error; err != nil {
+		return run, err
+	}
+	if later > 0 {
+		return run, ErrSuperseded
+	}
+	return o.Scheduler.Resume(JobName, by, run.ID)
+}
+
+// RunSteps lists the step runs of an end-of-day run in the order they ran
+func RunSteps(db *gorm.DB, runID uint) ([]models.JobRun, error) {
+	steps := []models.JobRun{}
+	err := db.Where("parent_run_id = ?", runID).Order("id").Find(&steps).Error
+	return steps, err
+}
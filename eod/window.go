@@ -0,0 +1,275 @@
+package eod
+
+import (
+	"banking-app/apiversion"
+	"banking-app/models"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// windowID is the primary key of the single posting window row
+const windowID = 1
+
+// PausedMessage is shown to clients whose postings are refused during end of day
+const PausedMessage = "Postings are paused while the bank closes the day; retry shortly"
+
+// PostingRoutes are the endpoints that post money, as "METHOD /path" below the API version prefix; a :param
+// segment matches any value. They are the ones held while postings are paused
+var PostingRoutes = []string{
+	"POST /transactions",
+	"POST /transactions/batch-atomic",
+	"POST /transactions/:id/reverse",
+	"POST /transactions/:id/installment-plan",
+	"POST /transfers",
+	"POST /loans/:id/payments",
+	"POST /duplicate-payments/:token/reverse",
+	"POST /operations/approvals/:id/approve",
+	"POST /operations/reviews/:id/approve",
+	"POST /operations/duplicate-payments/:id/reverse",
+}
+
+// Window errors
+var (
+	ErrNotPaused = errors.New("postings are not paused; the cutoff runs as a step of end of day")
+	ErrInFlight  = errors.New("postings still in flight when the cutoff gave up waiting")
+)
+
+// Window is the in-process view of the persisted posting pause, and the count of postings in flight here
+// Every instance refreshes it from the database, so a pause taken on the instance running end of day reaches
+// all of them within the refresh interval
+type Window struct {
+	db       *gorm.DB
+	cfg      Config
+	interval time.Duration
+
+	mu       sync.Mutex
+	state    models.PostingWindow
+	inFlight int
+}
+
+// NewWindow loads the persisted posting window
+func NewWindow(db *gorm.DB, cfg Config) *Window {
+	w := &Window{db: db, cfg: cfg}
+	if err := w.Refresh(); err != nil {
+		log.Printf("eod: initial posting window load failed: %v", err)
+	}
+	return w
+}
+
+// Refresh reloads the state from the database; a missing row means postings are open
+func (w *Window) Refresh() error {
+	var state models.PostingWindow
+	err := w.db.First(&state, windowID).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+	w.mu.Lock()
+	w.state = state
+	w.mu.Unlock()
+	return nil
+}
+
+// Start refreshes the state every interval until stop is closed
+func (w *Window) Start(interval time.Duration, stop <-chan struct{}) {
+	w.interval = interval
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := w.Refresh(); err != nil {
+					log.Printf("eod: posting window refresh failed: %v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// State returns a snapshot of the current state
+func (w *Window) State() models.PostingWindow {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.state
+}
+
+// Paused reports whether postings are held; a pause past its expiry no longer holds them
+func (w *Window) Paused() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pausedLocked(time.Now())
+}
+
+// pausedLocked is Paused with the lock held
+func (w *Window) pausedLocked(now time.Time) bool {
+	return w.state.Paused && (w.state.ExpiresAt == nil || now.Before(*w.state.ExpiresAt))
+}
+
+// Pause persists a posting pause for an end-of-day run, lifting on its own after MaxPause
+func (w *Window) Pause(runID uint) error {
+	now := time.Now()
+	expires := now.Add(w.cfg.MaxPause)
+	state := w.State()
+	state.ID = windowID
+	state.Paused = true
+	state.RunID = runID
+	state.PausedAt = &now
+	state.ExpiresAt = &expires
+	return w.save(&state)
+}
+
+// Resume persists the end of the pause; queued postings go ahead at once on this instance, and within the
+// refresh interval on the others
+func (w *Window) Resume() error {
+	now := time.Now()
+	state := w.State()
+	state.ID = windowID
+	state.Paused = false
+	state.ResumedAt = &now
+	return w.save(&state)
+}
+
+// save writes the state row and updates the local copy
+func (w *Window) save(state *models.PostingWindow) error {
+	if err := w.db.Save(state).Error; err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.state = *state
+	w.mu.Unlock()
+	return nil
+}
+
+// Cutoff closes the day's postings once they are paused: it waits one refresh interval, so every instance
+// holds new postings, then for the postings already in flight on this instance to finish. It returns how many
+// it waited for
+func (w *Window) Cutoff(timeout time.Duration) (int, error) {
+	if !w.Paused() {
+		return 0, ErrNotPaused
+	}
+	time.Sleep(w.interval)
+	waited := w.InFlight()
+	deadline := time.Now().Add(timeout)
+	for w.InFlight() > 0 {
+		if time.Now().After(deadline) {
+			return waited, ErrInFlight
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return waited, nil
+}
+
+// InFlight counts the postings running on this instance
+func (w *Window) InFlight() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.inFlight
+}
+
+// enter admits a posting, counting it in flight; while postings are paused it is refused, or in queue mode held
+// until they resume or QueueTimeout passes. It reports whether the posting may go ahead
+func (w *Window) enter(c *gin.Context) bool {
+	deadline := time.Now().Add(w.cfg.QueueTimeout)
+	for {
+		w.mu.Lock()
+		// Checked under the lock so a posting either sees the pause or is counted for Cutoff
+		if !w.pausedLocked(time.Now()) {
+			w.inFlight++
+			w.mu.Unlock()
+			return true
+		}
+		w.mu.Unlock()
+		if w.cfg.Mode != ModeQueue || time.Now().After(deadline) {
+			return false
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-c.Request.Context().Done():
+			return false
+		}
+	}
+}
+
+// leave marks a posting finished
+func (w *Window) leave() {
+	w.mu.Lock()
+	w.inFlight--
+	w.mu.Unlock()
+}
+
+// Middleware holds postings while they are paused, with 503 and Retry-After for any it refuses
+// Other requests always pass. Install it on the engine ahead of the write queue, so held postings never
+// hold up other writes
+func (w *Window) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !Posting(c.Request.Method, c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		if !w.enter(c) {
+			refuse(c, w.cfg.RetryAfter)
+			return
+		}
+		defer w.leave()
+		c.Next()
+	}
+}
+
+// refuse answers a posting held past its wait
+func refuse(c *gin.Context, retryAfter int) {
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	if apiversion.Version(c) == apiversion.V2 {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": gin.H{
+			"code":    "POSTING_PAUSED",
+			"message": PausedMessage,
+			"details": gin.H{"retry_after_seconds": retryAfter},
+		}})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+		"error":               PausedMessage,
+		"code":                "POSTING_PAUSED",
+		"retry_after_seconds": retryAfter,
+	})
+}
+
+// Posting reports whether a request matches PostingRoutes under any API version prefix
+func Posting(method, path string) bool {
+	for _, prefix := range []string{"/api/v1", "/api/v2"} {
+		if rest := strings.TrimPrefix(path, prefix); rest != path {
+			path = rest
+			break
+		}
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, route := range PostingRoutes {
+		routeMethod, routePath, _ := strings.Cut(route, " ")
+		if routeMethod == method && matches(strings.Split(strings.Trim(routePath, "/"), "/"), segments) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches compares a route's segments with a path's, a :param matching any one segment
+func matches(route, path []string) bool {
+	if len(route) != len(path) {
+		return false
+	}
+	for i, segment := range route {
+		if segment != path[i] && !strings.HasPrefix(segment, ":") {
+			return false
+		}
+	}
+	return true
+}
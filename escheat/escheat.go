@@ -0,0 +1,323 @@
+package escheat
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/communications"
+	"banking-app/events"
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"banking-app/notifications"
+	"banking-app/statushistory"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Escheatment statuses
+const (
+	StatusPending   = "pending"
+	StatusCancelled = "cancelled"
+	StatusEscheated = "escheated"
+	StatusReclaimed = "reclaimed"
+)
+
+// ErrNotEscheated is returned when reclaiming an escheatment that has not moved funds
+var ErrNotEscheated = errors.New("escheatment is not in escheated status")
+
+// Config holds the dormancy thresholds
+type Config struct {
+	DormantAfter time.Duration // Inactivity after which funds are turned over
+	NoticeBefore time.Duration // Minimum time between the final notice and the turnover
+}
+
+// ConfigFromEnv reads ESCHEAT_AFTER_DAYS (default 1095, three years) and ESCHEAT_NOTICE_DAYS (default 30)
+func ConfigFromEnv() Config {
+	days := func(key string, fallback int) time.Duration {
+		n, err := strconv.Atoi(os.Getenv(key))
+		if err != nil || n <= 0 {
+			n = fallback
+		}
+		return time.Duration(n) * 24 * time.Hour
+	}
+	return Config{
+		DormantAfter: days("ESCHEAT_AFTER_DAYS", 1095),
+		NoticeBefore: days("ESCHEAT_NOTICE_DAYS", 30),
+	}
+}
+
+// Result counts what one run did
+type Result struct {
+	Noticed   int `json:"noticed"`
+	Cancelled int `json:"cancelled"`
+	Escheated int `json:"escheated"`
+}
+
+// customerActivity filters postings the customer initiated - interest and fees do not keep an account alive
+func customerActivity(db *gorm.DB) *gorm.DB {
+	return db.Model(&models.Transaction{}).Where("transaction_type NOT IN ?", []string{ledger.TypeInterest, ledger.TypeFee})
+}
+
+// LastActivity is the time of an account's last customer-initiated posting, or its opening
+func LastActivity(db *gorm.DB, account models.Account) (time.Time, error) {
+	var last models.Transaction
+	err := customerActivity(db).Where("account_id = ?", account.ID).Order("created_at DESC").Limit(1).Find(&last).Error
+	if err != nil || last.ID == 0 {
+		return account.CreatedAt, err
+	}
+	return last.CreatedAt, nil
+}
+
+// Run sends final notices, cancels notices whose account came back to life and escheats overdue accounts
+// Safe to run repeatedly; each step only acts on rows in the state it expects
+func Run(db *gorm.DB, cfg Config, now time.Time) (Result, error) {
+	var result Result
+
+	// Activity since the notice cancels it
+	var pending []models.Escheatment
+	if err := db.Where("status = ?", StatusPending).Find(&pending).Error; err != nil {
+		return result, err
+	}
+	for _, row := range pending {
+		var account models.Account
+		if err := db.First(&account, row.AccountID).Error; err != nil {
+			return result, err
+		}
+		last, err := LastActivity(db, account)
+		if err != nil {
+			return result, err
+		}
+		if account.Status != "active" || account.Balance <= 0 || last.After(row.LastActivityAt) {
+			if err := db.Model(&row).Update("status", StatusCancelled).Error; err != nil {
+				return result, err
+			}
+			result.Cancelled++
+		}
+	}
+
+	// Accounts entering the notice window get their final notice
+	noticeFrom := now.Add(-(cfg.DormantAfter - cfg.NoticeBefore))
+	var candidates []models.Account
+	err := db.Where("status = 'active' AND account_type <> ? AND balance > 0 AND created_at < ?", gl.AccountType, noticeFrom).
+		Where("NOT EXISTS (?)", customerActivity(db.Session(&gorm.Session{NewDB: true})).Select("1").
+			Where("transactions.account_id = accounts.id AND transactions.created_at >= ?", noticeFrom)).
+		Where("NOT EXISTS (SELECT 1 FROM escheatments e WHERE e.account_id = accounts.id AND e.status = ?)", StatusPending).
+		Find(&candidates).Error
+	if err != nil {
+		return result, err
+	}
+	for _, account := range candidates {
+		if err := notice(db, cfg, account, now); err != nil {
+			return result, err
+		}
+		result.Noticed++
+	}
+
+	// Notices past their due date are escheated
+	var due []models.Escheatment
+	if err := db.Where("status = ? AND due_at <= ?", StatusPending, now).Find(&due).Error; err != nil {
+		return result, err
+	}
+	for _, row := range due {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return escheat(tx, &row, now)
+		})
+		if err != nil {
+			return result, err
+		}
+		if row.Status == StatusEscheated {
+			result.Escheated++
+		}
+	}
+	return result, nil
+}
+
+// notice records a pending escheatment and queues the final notice to the customer
+// The turnover date is never sooner than NoticeBefore from now, so every customer gets the full notice period
+func notice(db *gorm.DB, cfg Config, account models.Account, now time.Time) error {
+	last, err := LastActivity(db, account)
+	if err != nil {
+		return err
+	}
+	dueAt := last.Add(cfg.DormantAfter)
+	if earliest := now.Add(cfg.NoticeBefore); dueAt.Before(earliest) {
+		dueAt = earliest
+	}
+
+	var customer models.Customer
+	db.Select("id, email").First(&customer, account.CustomerID)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		row := models.Escheatment{
+			TenantID:       account.TenantID,
+			AccountID:      account.ID,
+			CustomerID:     account.CustomerID,
+			Status:         StatusPending,
+			LastActivityAt: last,
+			DueAt:          dueAt,
+			Amount:         account.Balance,
+		}
+		if err := tx.Create(&row).Error; err != nil {
+			return err
+		}
+		if customer.Email == "" {
+			log.Printf("escheat: customer %d has no email for the final notice on account %s", account.CustomerID, account.AccountNumber)
+			return nil
+		}
+		return notifications.Enqueue(tx, &models.Notification{
+			CustomerID:   account.CustomerID,
+			Channel:      "email",
+			ResourceType: communications.ResourceEscheatment,
+			ResourceID:   row.ID,
+			Recipient:    customer.Email,
+			Subject:      "Final notice: your account is dormant",
+			Body: fmt.Sprintf("Account %s has had no activity since %s. Unless you use the account before %s, "+
+				"its balance of %.2f %s will be turned over to the state as unclaimed property.",
+				account.AccountNumber, businessdays.Format(last), businessdays.Format(dueAt), account.Balance, account.Currency),
+		})
+	})
+}
+
+// escheat moves an account's balance to the escheatment account and marks it escheated
+func escheat(tx *gorm.DB, row *models.Escheatment, now time.Time) error {
+	var account models.Account
+	if err := tx.First(&account, row.AccountID).Error; err != nil {
+		return err
+	}
+	if account.Status != "active" || account.Balance <= 0 {
+		row.Status = StatusCancelled
+		return tx.Model(row).Update("status", row.Status).Error
+	}
+
+	holding, err := gl.Account(tx, account.TenantID, gl.Escheatment, account.Currency)
+	if err != nil {
+		return err
+	}
+
+	debit := models.Transaction{
+		AccountID:       account.ID,
+		TransactionType: "transfer",
+		Amount:          account.Balance,
+		Description:     "Escheated to the state as unclaimed property",
+		Channel:         "api",
+	}
+	if account, err = ledger.Post(tx, &debit, nil); err != nil {
+		return err
+	}
+	credit := models.Transaction{
+		AccountID:       holding.ID,
+		TransactionType: "deposit",
+		Amount:          debit.Amount,
+		Description:     "Escheatment of account " + account.AccountNumber,
+		Reference:       debit.TransactionID,
+		Channel:         "api",
+	}
+	if _, err := ledger.Post(tx, &credit, nil); err != nil {
+		return err
+	}
+
+	previous := account.Status
+	if err := tx.Model(&account).Updates(map[string]interface{}{"status": StatusEscheated, "version": account.Version + 1}).Error; err != nil {
+		return err
+	}
+	err = statushistory.Record(tx, models.StatusHistory{
+		TenantID:    account.TenantID,
+		SubjectType: statushistory.SubjectAccount,
+		SubjectID:   account.ID,
+		OldStatus:   previous,
+		NewStatus:   StatusEscheated,
+		Reason:      "Escheated to the state as unclaimed property",
+		ChangedBy:   statushistory.SystemActor,
+		ChangedAt:   now,
+	})
+	if err != nil {
+		return err
+	}
+
+	row.Status = StatusEscheated
+	row.Amount = debit.Amount
+	row.EscheatedAt = &now
+	row.DebitTransactionID = &debit.ID
+	row.CreditTransactionID = &credit.ID
+	if err := tx.Save(row).Error; err != nil {
+		return err
+	}
+	return events.Record(tx, events.AggregateAccount, account.ID, events.AccountEscheated, row)
+}
+
+// Reclaim returns escheated funds to the customer and reactivates the account inside an open transaction
+// Both escheatment postings are reversed, so the escheatment account is drawn down by the same amount
+func Reclaim(tx *gorm.DB, row *models.Escheatment, by, note string) error {
+	if !lifecycle.Allows(lifecycle.Escheatment, row.Status, StatusReclaimed) || row.DebitTransactionID == nil || row.CreditTransactionID == nil {
+		return ErrNotEscheated
+	}
+
+	var account models.Account
+	if err := tx.First(&account, row.AccountID).Error; err != nil {
+		return err
+	}
+	var credit models.Transaction
+	if err := tx.First(&credit, *row.CreditTransactionID).Error; err != nil {
+		return err
+	}
+
+	// Reactivate first - postings require an active account
+	previous := account.Status
+	if err := tx.Model(&account).Update("status", "active").Error; err != nil {
+		return err
+	}
+	err := statushistory.Record(tx, models.StatusHistory{
+		TenantID:    account.TenantID,
+		SubjectType: statushistory.SubjectAccount,
+		SubjectID:   account.ID,
+		OldStatus:   previous,
+		NewStatus:   "active",
+		Reason:      note,
+		ChangedBy:   by,
+	})
+	if err != nil {
+		return err
+	}
+
+	drawdown := models.Transaction{
+		AccountID:       credit.AccountID,
+		TransactionType: "withdrawal",
+		Amount:          row.Amount,
+		Description:     "Reclaim of escheated account " + account.AccountNumber,
+		Reference:       credit.TransactionID,
+		Channel:         "api",
+		ReversalOfID:    row.CreditTransactionID,
+	}
+	if _, err := ledger.Post(tx, &drawdown, nil); err != nil {
+		return err
+	}
+	restore := models.Transaction{
+		AccountID:       account.ID,
+		TransactionType: "deposit",
+		Amount:          row.Amount,
+		Description:     "Reclaimed unclaimed property",
+		Reference:       drawdown.TransactionID,
+		Channel:         "api",
+		ReversalOfID:    row.DebitTransactionID,
+	}
+	if _, err := ledger.Post(tx, &restore, nil); err != nil {
+		return err
+	}
+
+	now := clock.Now()
+	row.Status = StatusReclaimed
+	row.ReclaimedAt = &now
+	row.ReclaimedBy = by
+	row.ReclaimNote = note
+	if err := tx.Save(row).Error; err != nil {
+		return err
+	}
+	return events.Record(tx, events.AggregateAccount, account.ID, events.AccountReclaimed, row)
+}
@@ -0,0 +1,269 @@
+package escheat
+
+import (
+	"banking-app/clock"
+	"banking-app/communications"
+	"banking-app/database"
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/models"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const day = 24 * time.Hour
+
+// opened is when the test account is opened and last used
+var opened = time.Date(2020, time.January, 6, 12, 0, 0, 0, time.UTC)
+
+// testConfig turns funds over after three years with a 30-day notice
+var testConfig = Config{DormantAfter: 1095 * day, NoticeBefore: 30 * day}
+
+// testDB opens a migrated database in a temporary directory on a fake clock stopped at opened, holding a customer
+// with an account funded by a deposit at that time
+func testDB(t *testing.T) (*gorm.DB, *clock.Fake, models.Account) {
+	t.Helper()
+	fake := clock.NewFake(opened)
+	clock.Use(fake)
+	t.Cleanup(func() { clock.Use(clock.System{}) })
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "escheat.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	db.Logger = logger.Default.LogMode(logger.Silent)
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	customer := models.Customer{FirstName: "Dormant", LastName: "Owner", Email: "dormant@example.test", DateOfBirth: "1950-01-01", Status: "active"}
+	if err := db.Create(&customer).Error; err != nil {
+		t.Fatal(err)
+	}
+	account := models.Account{CustomerID: customer.ID, AccountNumber: "DORMANT-1", AccountType: "savings", Currency: "USD", Status: "active"}
+	if err := db.Create(&account).Error; err != nil {
+		t.Fatal(err)
+	}
+	account = post(t, db, fake, account.ID, "deposit", 250, opened)
+	return db, fake, account
+}
+
+// post posts a transaction at a time and returns the account after it
+func post(t *testing.T, db *gorm.DB, fake *clock.Fake, accountID uint, transactionType string, amount float64, at time.Time) models.Account {
+	t.Helper()
+	fake.Set(at)
+	account, err := ledger.Post(db, &models.Transaction{AccountID: accountID, TransactionType: transactionType, Amount: amount}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+// run runs the job at a time
+func run(t *testing.T, db *gorm.DB, fake *clock.Fake, at time.Time) Result {
+	t.Helper()
+	fake.Set(at)
+	result, err := Run(db, testConfig, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// pending returns the account's pending escheatment, failing the test if there is none
+func pending(t *testing.T, db *gorm.DB, account models.Account) models.Escheatment {
+	t.Helper()
+	var row models.Escheatment
+	if err := db.Where("account_id = ? AND status = ?", account.ID, StatusPending).First(&row).Error; err != nil {
+		t.Fatalf("no pending escheatment: %v", err)
+	}
+	return row
+}
+
+func TestNoticeThresholds(t *testing.T) {
+	// The notice window opens NoticeBefore ahead of the dormancy period, 1065 days after the last activity
+	tests := []struct {
+		name    string
+		idle    time.Duration
+		noticed int
+		due     time.Duration // From opened; the notice period from the run when it ends later
+	}{
+		{"a day before the window", 1064 * day, 0, 0},
+		{"as the window opens", 1065 * day, 0, 0},
+		{"a second into the window", 1065*day + time.Second, 1, 1095*day + time.Second},
+		{"a week into the window", 1072 * day, 1, 1102 * day},
+		{"years past dormancy", 2000 * day, 1, 2030 * day},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake, account := testDB(t)
+			result := run(t, db, fake, opened.Add(tt.idle))
+			if result.Noticed != tt.noticed || result.Escheated != 0 {
+				t.Fatalf("run after %v idle: %+v, want %d noticed and none escheated", tt.idle, result, tt.noticed)
+			}
+			if tt.noticed == 0 {
+				return
+			}
+			row := pending(t, db, account)
+			if !row.DueAt.Equal(opened.Add(tt.due)) || !row.LastActivityAt.Equal(opened) || row.Amount != 250 {
+				t.Errorf("notice due %v, last activity %v, amount %v; want due %v, activity %v, amount 250",
+					row.DueAt, row.LastActivityAt, row.Amount, opened.Add(tt.due), opened)
+			}
+			if row.DueAt.Sub(opened.Add(tt.idle)) < testConfig.NoticeBefore {
+				t.Errorf("notice due %v gives less than the notice period from %v", row.DueAt, opened.Add(tt.idle))
+			}
+			var notices int64
+			db.Model(&models.Notification{}).Where("resource_type = ? AND resource_id = ?", communications.ResourceEscheatment, row.ID).Count(&notices)
+			if notices != 1 {
+				t.Errorf("%d final notices queued, want 1", notices)
+			}
+			if again := run(t, db, fake, opened.Add(tt.idle+day)); again.Noticed != 0 {
+				t.Errorf("a second run sent %d more notices", again.Noticed)
+			}
+		})
+	}
+}
+
+func TestEscheatsOnTheDueDate(t *testing.T) {
+	db, fake, account := testDB(t)
+	run(t, db, fake, opened.Add(1070*day))
+	due := pending(t, db, account).DueAt
+
+	if result := run(t, db, fake, due.Add(-time.Second)); result.Escheated != 0 {
+		t.Errorf("escheated a second before the due date")
+	}
+	if result := run(t, db, fake, due); result.Escheated != 1 {
+		t.Errorf("run on the due date: %+v, want 1 escheated", result)
+	}
+	if result := run(t, db, fake, due.Add(day)); result.Escheated != 0 || result.Noticed != 0 {
+		t.Errorf("a run after escheatment acted again: %+v", result)
+	}
+}
+
+func TestActivity(t *testing.T) {
+	tests := []struct {
+		name            string
+		transactionType string
+		keepsAlive      bool
+	}{
+		{"a deposit", "deposit", true},
+		{"a withdrawal", "withdrawal", true},
+		{"interest", ledger.TypeInterest, false},
+		{"a fee", ledger.TypeFee, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" before the window", func(t *testing.T) {
+			db, fake, account := testDB(t)
+			post(t, db, fake, account.ID, tt.transactionType, 5, opened.Add(1000*day))
+			result := run(t, db, fake, opened.Add(1066*day))
+			if noticed := result.Noticed == 1; noticed == tt.keepsAlive {
+				t.Errorf("run after %s: %+v", tt.name, result)
+			}
+		})
+		t.Run(tt.name+" after the notice", func(t *testing.T) {
+			db, fake, account := testDB(t)
+			run(t, db, fake, opened.Add(1066*day))
+			due := pending(t, db, account).DueAt
+			post(t, db, fake, account.ID, tt.transactionType, 5, opened.Add(1070*day))
+			if result := run(t, db, fake, opened.Add(1071*day)); (result.Cancelled == 1) != tt.keepsAlive {
+				t.Errorf("run after %s: %+v", tt.name, result)
+			}
+			if result := run(t, db, fake, due); (result.Escheated == 1) == tt.keepsAlive {
+				t.Errorf("run on the due date after %s: %+v", tt.name, result)
+			}
+		})
+	}
+}
+
+// balance returns an account's stored balance and the sum of its postings
+func balance(t *testing.T, db *gorm.DB, accountID uint) (stored, posted float64) {
+	t.Helper()
+	var account models.Account
+	if err := db.First(&account, accountID).Error; err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&models.Transaction{}).Select("COALESCE(SUM("+ledger.SignedAmountSQL+"), 0)").Where("account_id = ?", accountID).Scan(&posted)
+	return account.Balance, posted
+}
+
+func TestLedgerBalances(t *testing.T) {
+	db, fake, account := testDB(t)
+	run(t, db, fake, opened.Add(1066*day))
+	due := pending(t, db, account).DueAt
+	run(t, db, fake, due)
+
+	var row models.Escheatment
+	if err := db.Where("account_id = ?", account.ID).First(&row).Error; err != nil {
+		t.Fatal(err)
+	}
+	if row.Status != StatusEscheated || row.Amount != 250 || row.DebitTransactionID == nil || row.CreditTransactionID == nil {
+		t.Fatalf("escheatment %+v, want escheated with 250 and both postings", row)
+	}
+	holding, err := gl.Account(db, account.TenantID, gl.Escheatment, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var debit, credit models.Transaction
+	db.First(&debit, *row.DebitTransactionID)
+	db.First(&credit, *row.CreditTransactionID)
+	if debit.AccountID != account.ID || credit.AccountID != holding.ID || debit.Amount != 250 || credit.Amount != 250 ||
+		ledger.SignedAmount(debit)+ledger.SignedAmount(credit) != 0 {
+		t.Errorf("escheatment postings do not balance: debit %+v, credit %+v", debit, credit)
+	}
+	if stored, posted := balance(t, db, account.ID); stored != 0 || posted != 0 {
+		t.Errorf("escheated account holds %v, postings sum to %v, want 0", stored, posted)
+	}
+	if stored, posted := balance(t, db, holding.ID); stored != 250 || posted != 250 {
+		t.Errorf("escheatment account holds %v, postings sum to %v, want 250", stored, posted)
+	}
+	var status string
+	db.Model(&models.Account{}).Where("id = ?", account.ID).Pluck("status", &status)
+	if status != StatusEscheated {
+		t.Errorf("account status %q, want escheated", status)
+	}
+
+	fake.Set(due.Add(90 * day))
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return Reclaim(tx, &row, "admin", "Owner came forward with identification")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, posted := balance(t, db, account.ID); stored != 250 || posted != 250 {
+		t.Errorf("reclaimed account holds %v, postings sum to %v, want 250", stored, posted)
+	}
+	if stored, posted := balance(t, db, holding.ID); stored != 0 || posted != 0 {
+		t.Errorf("escheatment account holds %v after the reclaim, postings sum to %v, want 0", stored, posted)
+	}
+	var reversals int64
+	db.Model(&models.Transaction{}).Where("reversal_of_id IN ?", []uint{debit.ID, credit.ID}).Count(&reversals)
+	if reversals != 2 {
+		t.Errorf("%d postings reverse the escheatment, want 2", reversals)
+	}
+	db.Model(&models.Account{}).Where("id = ?", account.ID).Pluck("status", &status)
+	if status != "active" || row.Status != StatusReclaimed || row.ReclaimedBy != "admin" {
+		t.Errorf("after the reclaim the account is %q and the escheatment %q by %q", status, row.Status, row.ReclaimedBy)
+	}
+	var history int64
+	db.Model(&models.StatusHistory{}).Where("subject_id = ? AND new_status IN ?", account.ID, []string{StatusEscheated, "active"}).Count(&history)
+	if history != 2 {
+		t.Errorf("%d status changes recorded for the escheatment and reclaim, want 2", history)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return Reclaim(tx, &row, "admin", "Claimed twice")
+	})
+	if err != ErrNotEscheated {
+		t.Errorf("second reclaim: %v, want ErrNotEscheated", err)
+	}
+}
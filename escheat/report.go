@@ -0,0 +1,81 @@
+package escheat
+
+import (
+	"banking-app/businessdays"
+	"banking-app/models"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ReportRow is one escheatment with the owner details a state filing needs
+type ReportRow struct {
+	ID             uint       `json:"id"`
+	Status         string     `json:"status"`
+	AccountID      uint       `json:"account_id"`
+	AccountNumber  string     `json:"account_number"`
+	Currency       string     `json:"currency"`
+	Amount         float64    `json:"amount"`
+	LastActivityAt time.Time  `json:"last_activity_at"`
+	DueAt          time.Time  `json:"due_at"`
+	EscheatedAt    *time.Time `json:"escheated_at,omitempty"`
+	ReclaimedAt    *time.Time `json:"reclaimed_at,omitempty"`
+	CustomerID     uint       `json:"customer_id"`
+	CustomerName   string     `json:"customer_name"`
+	Email          string     `json:"email"`
+	Address        string     `json:"address"`
+}
+
+// reportColumns selects the ReportRow fields from the joined tables
+const reportColumns = `escheatments.id, escheatments.status, escheatments.account_id, accounts.account_number,
+	accounts.currency, escheatments.amount, escheatments.last_activity_at, escheatments.due_at,
+	escheatments.escheated_at, escheatments.reclaimed_at, escheatments.customer_id,
+	customers.first_name || ' ' || customers.last_name AS customer_name, customers.email, customers.address`
+
+// ReportQuery starts a report query joined to the account and owner
+// Deleted accounts and customers stay on the report - the filing covers them too
+func ReportQuery(db *gorm.DB) *gorm.DB {
+	return db.Model(&models.Escheatment{}).Select(reportColumns).
+		Joins("LEFT JOIN accounts ON accounts.id = escheatments.account_id").
+		Joins("LEFT JOIN customers ON customers.id = escheatments.customer_id")
+}
+
+// WriteCSV streams report rows from a query as CSV
+func WriteCSV(w io.Writer, db, query *gorm.DB) error {
+	rows, err := query.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	date := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return businessdays.Format(*t)
+	}
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "status", "account_number", "currency", "amount", "last_activity", "due", "escheated",
+		"reclaimed", "customer_id", "customer_name", "email", "address"})
+	for rows.Next() {
+		var row ReportRow
+		if err := db.ScanRows(rows, &row); err != nil {
+			return err
+		}
+		out.Write([]string{
+			strconv.FormatUint(uint64(row.ID), 10), row.Status, row.AccountNumber, row.Currency,
+			strconv.FormatFloat(row.Amount, 'f', 2, 64), date(&row.LastActivityAt), date(&row.DueAt),
+			date(row.EscheatedAt), date(row.ReclaimedAt), strconv.FormatUint(uint64(row.CustomerID), 10),
+			row.CustomerName, row.Email, row.Address,
+		})
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return err
+	}
+	return rows.Err()
+}
@@ -0,0 +1,51 @@
+package events
+
+import (
+	"banking-app/models"
+	"context"
+	"sync"
+)
+
+// Broker fans events out to in-process subscribers such as server-sent event streams
+// Slow subscribers drop events rather than stalling the dispatcher
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[chan models.OutboxEvent]struct{}
+}
+
+// NewBroker creates an empty broker
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[chan models.OutboxEvent]struct{})}
+}
+
+// Subscribe registers a buffered channel and returns it with its cancel function
+func (b *Broker) Subscribe() (<-chan models.OutboxEvent, func()) {
+	ch := make(chan models.OutboxEvent, 64)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Publish delivers the event to every current subscriber without blocking
+func (b *Broker) Publish(ctx context.Context, event models.OutboxEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package events
+
+import (
+	"banking-app/models"
+	"banking-app/notifications"
+	"banking-app/tracing"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDropped   = "dropped" // Pushed out of a full backlog
+)
+
+// ResourceWebhook is the resource type of suspension alerts
+const ResourceWebhook = "webhook_subscription"
+
+// ErrNotSuspended is returned when resuming a subscription whose deliveries are not suspended
+var ErrNotSuspended = errors.New("webhook subscription is not suspended")
+
+// WebhookConfig holds the circuit breaker and backlog settings of webhook delivery
+type WebhookConfig struct {
+	BreakerFailures int           // Consecutive failed attempts that suspend a subscription
+	ProbeInterval   time.Duration // How often a suspended subscription is tried with one delivery
+	BacklogLimit    int           // Deliveries a subscription may have queued unless it sets its own limit
+	RatePerSecond   int           // Most deliveries sent to one subscription per second, backlog replays included
+	AccountLimit    int           // Most subscriptions one account may have
+	AccountHTTP     bool          // Let account subscriptions use plain http endpoints, for local development
+}
+
+// WebhookConfigFromEnv reads WEBHOOK_BREAKER_FAILURES (default 5), WEBHOOK_PROBE_SECONDS (default 300),
+// WEBHOOK_BACKLOG_LIMIT (default 1000), WEBHOOK_RATE_PER_SECOND (default 10), ACCOUNT_WEBHOOK_LIMIT (default 3)
+// and ACCOUNT_WEBHOOK_ALLOW_HTTP
+func WebhookConfigFromEnv() (WebhookConfig, error) {
+	cfg := WebhookConfig{BreakerFailures: 5, BacklogLimit: 1000, RatePerSecond: 10, AccountLimit: 3}
+	cfg.AccountHTTP, _ = strconv.ParseBool(os.Getenv("ACCOUNT_WEBHOOK_ALLOW_HTTP"))
+	probe := 300
+	settings := []struct {
+		name string
+		into *int
+	}{
+		{"WEBHOOK_BREAKER_FAILURES", &cfg.BreakerFailures},
+		{"WEBHOOK_PROBE_SECONDS", &probe},
+		{"WEBHOOK_BACKLOG_LIMIT", &cfg.BacklogLimit},
+		{"WEBHOOK_RATE_PER_SECOND", &cfg.RatePerSecond},
+		{"ACCOUNT_WEBHOOK_LIMIT", &cfg.AccountLimit},
+	}
+	for _, setting := range settings {
+		raw := strings.TrimSpace(os.Getenv(setting.name))
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return cfg, fmt.Errorf("invalid %s %q: must be a whole number of 1 or more", setting.name, raw)
+		}
+		*setting.into = n
+	}
+	cfg.ProbeInterval = time.Duration(probe) * time.Second
+	return cfg, nil
+}
+
+// backlogLimit is how many deliveries a subscription may have queued
+func (c WebhookConfig) backlogLimit(sub models.WebhookSubscription) int {
+	if sub.BacklogLimit > 0 {
+		return sub.BacklogLimit
+	}
+	return c.BacklogLimit
+}
+
+// trimBacklog drops a subscription's oldest queued deliveries beyond its limit
+// The oldest delivery left carries how many were dropped ahead of it, so the endpoint learns of the gap
+func trimBacklog(db *gorm.DB, sub models.WebhookSubscription, limit int) error {
+	var queued int64
+	if err := db.Model(&models.WebhookDelivery{}).Where("subscription_id = ? AND status = ?", sub.ID, DeliveryPending).Count(&queued).Error; err != nil {
+		return err
+	}
+	excess := int(queued) - limit
+	if excess <= 0 {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var oldest []models.WebhookDelivery
+		if err := tx.Where("subscription_id = ? AND status = ?", sub.ID, DeliveryPending).Order("id").Limit(excess).Find(&oldest).Error; err != nil {
+			return err
+		}
+		ids, dropped := make([]uint, 0, len(oldest)), 0
+		for _, delivery := range oldest {
+			ids = append(ids, delivery.ID)
+			dropped += 1 + delivery.DroppedBefore // Gaps already reported on a dropped delivery carry over
+		}
+		if err := tx.Model(&models.WebhookDelivery{}).Where("id IN ?", ids).Update("status", DeliveryDropped).Error; err != nil {
+			return err
+		}
+		var head models.WebhookDelivery
+		if err := tx.Where("subscription_id = ? AND status = ?", sub.ID, DeliveryPending).Order("id").Limit(1).Find(&head).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&head).Update("dropped_before", head.DroppedBefore+dropped).Error; err != nil {
+			return err
+		}
+		log.Printf("webhooks: subscription %d backlog is full, dropped %d oldest deliveries", sub.ID, len(oldest))
+		return tx.Model(&models.WebhookSubscription{}).Where("id = ?", sub.ID).
+			Update("dropped_deliveries", gorm.Expr("dropped_deliveries + ?", len(oldest))).Error
+	})
+}
+
+// WebhookDeliverer sends each subscription's queued deliveries in order, with a circuit breaker per subscription
+// After BreakerFailures consecutive failures a subscription is suspended: its deliveries keep queueing, its owner
+// is alerted and one delivery is tried every ProbeInterval. A probe that succeeds, or an admin resuming it, closes
+// the breaker and the backlog replays in order at RatePerSecond
+type WebhookDeliverer struct {
+	DB     *gorm.DB
+	Client *http.Client
+	Cfg    WebhookConfig
+}
+
+// NewWebhookDeliverer creates a deliverer with a bounded request timeout
+// DeliverPending is meant to run every second, which makes RatePerSecond the rate cap
+func NewWebhookDeliverer(db *gorm.DB, cfg WebhookConfig) *WebhookDeliverer {
+	return &WebhookDeliverer{DB: db, Client: &http.Client{Timeout: 10 * time.Second}, Cfg: cfg}
+}
+
+// DeliverPending sends up to RatePerSecond due deliveries of every active subscription, and probes the suspended
+// ones that are due a probe
+func (d *WebhookDeliverer) DeliverPending() {
+	var subscriptions []models.WebhookSubscription
+	if err := d.DB.Where("active = ?", true).Order("id").Find(&subscriptions).Error; err != nil {
+		log.Printf("webhooks: failed to load subscriptions: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, sub := range subscriptions {
+		if !sub.Suspended {
+			d.drain(sub, d.Cfg.RatePerSecond, false)
+		} else if sub.NextProbeAt == nil || !sub.NextProbeAt.After(now) {
+			d.drain(sub, 1, true)
+		}
+	}
+}
+
+// drain sends a subscription's queue from its head, stopping at the first delivery that fails or is backing off,
+// so no delivery overtakes an earlier one. A probe ignores the head's backoff
+func (d *WebhookDeliverer) drain(sub models.WebhookSubscription, limit int, probe bool) {
+	var queue []models.WebhookDelivery
+	if err := d.DB.Where("subscription_id = ? AND status = ?", sub.ID, DeliveryPending).Order("id").Limit(limit).Find(&queue).Error; err != nil {
+		log.Printf("webhooks: failed to load deliveries of subscription %d: %v", sub.ID, err)
+		return
+	}
+	for _, delivery := range queue {
+		if !probe && delivery.NextAttemptAt.After(time.Now()) {
+			return
+		}
+		if err := d.attempt(sub, delivery); err != nil {
+			d.recordFailure(sub, delivery, err, probe)
+			return
+		}
+		if sub.Suspended || sub.ConsecutiveFailures > 0 {
+			d.recordRecovery(sub)
+			sub.Suspended, sub.ConsecutiveFailures = false, 0
+		}
+	}
+	if probe && len(queue) == 0 {
+		d.recordRecovery(sub) // Nothing left to probe with
+	}
+}
+
+// attempt sends one delivery and marks it delivered when the endpoint accepts it
+// The delivery span continues the trace of the publish that queued it
+func (d *WebhookDeliverer) attempt(sub models.WebhookSubscription, delivery models.WebhookDelivery) error {
+	var event models.OutboxEvent
+	if err := d.DB.First(&event, delivery.EventID).Error; err != nil {
+		return fmt.Errorf("event %d: %w", delivery.EventID, err)
+	}
+	ctx := tracing.FromTraceParent(context.Background(), delivery.TraceParent)
+	if err := send(ctx, d.Client, sub, delivery, event); err != nil {
+		return err
+	}
+	deliveredAt := time.Now()
+	return d.DB.Model(&delivery).Updates(map[string]interface{}{
+		"status":       DeliveryDelivered,
+		"attempts":     delivery.Attempts + 1,
+		"delivered_at": &deliveredAt,
+		"last_error":   "",
+	}).Error
+}
+
+// recordFailure backs the delivery off and counts the failure against the subscription, suspending it at
+// BreakerFailures; a failed probe schedules the next one
+func (d *WebhookDeliverer) recordFailure(sub models.WebhookSubscription, delivery models.WebhookDelivery, err error, probe bool) {
+	now := time.Now()
+	attempts := delivery.Attempts + 1
+	d.DB.Model(&delivery).Updates(map[string]interface{}{
+		"attempts":        attempts,
+		"last_error":      truncate(err.Error(), 500),
+		"next_attempt_at": now.Add(Backoff(attempts)),
+	})
+
+	failures := sub.ConsecutiveFailures + 1
+	updates := map[string]interface{}{"consecutive_failures": failures, "last_error": truncate(err.Error(), 500)}
+	nextProbe := now.Add(d.Cfg.ProbeInterval)
+	suspending := !sub.Suspended && failures >= d.Cfg.BreakerFailures
+	if probe || suspending {
+		updates["next_probe_at"] = &nextProbe
+	}
+	if suspending {
+		updates["suspended"] = true
+		updates["suspended_at"] = &now
+	}
+	if err := d.DB.Model(&models.WebhookSubscription{}).Where("id = ?", sub.ID).Updates(updates).Error; err != nil {
+		log.Printf("webhooks: failed to record failure of subscription %d: %v", sub.ID, err)
+		return
+	}
+	if suspending {
+		log.Printf("webhooks: subscription %d suspended after %d consecutive failures: %v", sub.ID, failures, err)
+		d.alert(sub, failures, err)
+	}
+}
+
+// recordRecovery closes a subscription's breaker after a successful delivery
+func (d *WebhookDeliverer) recordRecovery(sub models.WebhookSubscription) {
+	err := d.DB.Model(&models.WebhookSubscription{}).Where("id = ?", sub.ID).Updates(map[string]interface{}{
+		"suspended":            false,
+		"next_probe_at":        nil,
+		"consecutive_failures": 0,
+		"last_error":           "",
+	}).Error
+	if err != nil {
+		log.Printf("webhooks: failed to record recovery of subscription %d: %v", sub.ID, err)
+		return
+	}
+	if sub.Suspended {
+		log.Printf("webhooks: subscription %d recovered, replaying its backlog", sub.ID)
+	}
+}
+
+// alert emails the subscription's owner that its deliveries are suspended
+func (d *WebhookDeliverer) alert(sub models.WebhookSubscription, failures int, cause error) {
+	if sub.AlertEmail == "" {
+		log.Printf("webhooks: subscription %d has no alert address for its suspension", sub.ID)
+		return
+	}
+	err := notifications.Enqueue(d.DB, &models.Notification{
+		Channel:      "email",
+		Recipient:    sub.AlertEmail,
+		ResourceType: ResourceWebhook,
+		ResourceID:   sub.ID,
+		Subject:      fmt.Sprintf("Webhook deliveries to %s are suspended", sub.URL),
+		Body: fmt.Sprintf("Deliveries to webhook subscription %d (%s) failed %d times in a row, most recently with: %v. "+
+			"New events are queued, up to %d; beyond that the oldest are dropped. The endpoint is tried again every %d minutes, "+
+			"and deliveries resume in order once it accepts one or an administrator resumes the subscription.",
+			sub.ID, sub.URL, failures, cause, d.Cfg.backlogLimit(sub), int(d.Cfg.ProbeInterval.Minutes())),
+	})
+	if err != nil {
+		log.Printf("webhooks: failed to alert owner of subscription %d: %v", sub.ID, err)
+	}
+}
+
+// ResumeWebhook closes a suspended subscription's breaker and returns how many deliveries will replay
+// Its backlog replays from the oldest at once, at the usual rate cap
+func ResumeWebhook(db *gorm.DB, id uint) (int64, error) {
+	var sub models.WebhookSubscription
+	if err := db.First(&sub, id).Error; err != nil {
+		return 0, err
+	}
+	result := db.Model(&models.WebhookSubscription{}).Where("id = ? AND suspended = ?", id, true).Updates(map[string]interface{}{
+		"suspended":            false,
+		"next_probe_at":        nil,
+		"consecutive_failures": 0,
+	})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, ErrNotSuspended
+	}
+	queued := db.Model(&models.WebhookDelivery{}).Where("subscription_id = ? AND status = ?", id, DeliveryPending)
+	if err := queued.Update("next_attempt_at", time.Now()).Error; err != nil {
+		return 0, err
+	}
+	var backlog int64
+	err := db.Model(&models.WebhookDelivery{}).Where("subscription_id = ? AND status = ?", id, DeliveryPending).Count(&backlog).Error
+	return backlog, err
+}
+
+// FillBacklogs sets how many deliveries each subscription has waiting
+func FillBacklogs(db *gorm.DB, subscriptions []models.WebhookSubscription) error {
+	var counts []struct {
+		SubscriptionID uint
+		Count          int64
+	}
+	err := db.Model(&models.WebhookDelivery{}).Select("subscription_id, COUNT(*) AS count").
+		Where("status = ?", DeliveryPending).Group("subscription_id").Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+	backlog := make(map[uint]int64, len(counts))
+	for _, c := range counts {
+		backlog[c.SubscriptionID] = c.Count
+	}
+	for i := range subscriptions {
+		subscriptions[i].Backlog = backlog[subscriptions[i].ID]
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package events
+
+import (
+	"banking-app/clock"
+	"banking-app/models"
+	"banking-app/tracing"
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// Event types written to the outbox
+const (
+	CustomerCreated             = "customer.created"
+	CustomerEmailChanged        = "customer.email_changed"
+	AccountOpened               = "account.opened"
+	AccountFrozen               = "account.frozen"
+	AccountUnfrozen             = "account.unfrozen"
+	AccountClosed               = "account.closed"
+	AccountEscheated            = "account.escheated"
+	AccountReclaimed            = "account.reclaimed"
+	AccountRestricted           = "account.restrictions_changed"
+	AccountConverted            = "account.product_changed"
+	AccountLienPlaced           = "account.lien_placed"
+	AccountLienChanged          = "account.lien_changed"
+	AccountLienPaid             = "account.lien_paid"
+	AccountLienReleased         = "account.lien_released"
+	GarnishmentRegistered       = "account.garnishment_registered"
+	GarnishmentPaid             = "account.garnishment_paid"
+	GarnishmentSuspended        = "account.garnishment_suspended"
+	GarnishmentResumed          = "account.garnishment_resumed"
+	GarnishmentSatisfied        = "account.garnishment_satisfied"
+	AccountAuthorizationExpired = "account.authorization_expired"
+	TransactionPosted           = "transaction.posted"
+	BalanceLow                  = "balance.low"
+	StatementReady              = "statement.ready"
+	LoanCreated                 = "loan.created"
+	LoanDisbursed               = "loan.disbursed"
+	LoanCreditDecided           = "loan.credit_decided"
+	LoanInstallmentShort        = "loan.installment_short"
+	DocumentUploaded            = "document.uploaded"
+	DocumentRejected            = "document.rejected"
+	CertificateIssued           = "certificate.issued"
+
+	FeatureFlagChanged = "feature_flag.changed"
+)
+
+// Aggregate types used for per-entity ordering
+const (
+	AggregateCustomer = "customer"
+	AggregateAccount  = "account"
+	AggregateLoan     = "loan"
+
+	AggregateFeatureFlag = "feature_flag"
+)
+
+// MaxAttempts is the number of publish attempts before an event is parked as failed (poison)
+const MaxAttempts = 8
+
+// Record writes an outbox event using the caller's transaction handle
+// Must be called inside the same db.Transaction as the business change
+// The trace of the request recording it is kept, so publishing continues that trace
+func Record(tx *gorm.DB, aggregateType string, aggregateID uint, eventType string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return tx.Create(&models.OutboxEvent{
+		AggregateType: aggregateType,
+		AggregateID:   aggregateID,
+		EventType:     eventType,
+		Payload:       string(body),
+		TraceParent:   tracing.TraceParent(tx.Statement.Context),
+		Status:        "pending",
+		NextAttemptAt: time.Now(),
+	}).Error
+}
+
+// Publisher delivers one event to a class of subscribers
+// ctx carries the publish span, which outgoing deliveries propagate
+type Publisher interface {
+	Publish(ctx context.Context, event models.OutboxEvent) error
+}
+
+// Dispatcher polls the outbox and publishes pending events in order
+type Dispatcher struct {
+	DB         *gorm.DB
+	Publishers []Publisher
+	BatchSize  int
+}
+
+// Start polls the outbox at the given interval until stop is closed
+func (d *Dispatcher) Start(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.DispatchPending()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// DispatchPending publishes one batch of pending events
+// Events are processed in id order; once an event for an aggregate fails or is
+// backing off, later events for that aggregate wait so per-account order holds
+func (d *Dispatcher) DispatchPending() {
+	batchSize := d.BatchSize
+	if batchSize == 0 {
+		batchSize = 100
+	}
+
+	var pending []models.OutboxEvent
+	if err := d.DB.Where("status = ?", "pending").Order("id").Limit(batchSize).Find(&pending).Error; err != nil {
+		log.Printf("outbox: failed to load pending events: %v", err)
+		return
+	}
+
+	now := time.Now()
+	blocked := make(map[string]bool)
+	for _, event := range pending {
+		key := aggregateKey(event)
+		if blocked[key] {
+			continue
+		}
+		if event.NextAttemptAt.After(now) {
+			blocked[key] = true
+			continue
+		}
+
+		if err := d.publish(event); err != nil {
+			blocked[key] = true
+			d.recordFailure(event, err)
+			continue
+		}
+
+		dispatchedAt := time.Now()
+		d.DB.Model(&models.OutboxEvent{}).Where("id = ?", event.ID).Updates(map[string]interface{}{
+			"status":        "dispatched",
+			"attempts":      event.Attempts + 1,
+			"dispatched_at": &dispatchedAt,
+			"last_error":    "",
+		})
+	}
+}
+
+// publish hands the event to every publisher, stopping at the first failure
+// Publishers must tolerate redelivery since a partial failure retries the whole event
+// Each attempt is a span in the trace of the request that recorded the event
+func (d *Dispatcher) publish(event models.OutboxEvent) (err error) {
+	ctx := tracing.FromTraceParent(context.Background(), event.TraceParent)
+	ctx, span := tracing.Start(ctx, "outbox.publish "+event.EventType, trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.Int64("event.id", int64(event.ID)),
+			attribute.String("event.type", event.EventType),
+			attribute.String("event.aggregate", aggregateKey(event)),
+			attribute.Int("event.attempt", event.Attempts+1),
+		))
+	defer func() { tracing.End(span, err) }()
+
+	for _, p := range d.Publishers {
+		if err := p.Publish(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordFailure schedules a retry with exponential backoff or parks the event as poison
+func (d *Dispatcher) recordFailure(event models.OutboxEvent, err error) {
+	attempts := event.Attempts + 1
+	updates := map[string]interface{}{
+		"attempts":        attempts,
+		"last_error":      truncate(err.Error(), 500),
+		"next_attempt_at": time.Now().Add(Backoff(attempts)),
+	}
+	if attempts >= MaxAttempts {
+		updates["status"] = "failed"
+		log.Printf("outbox: event %d (%s) parked after %d attempts: %v", event.ID, event.EventType, attempts, err)
+	}
+	d.DB.Model(&models.OutboxEvent{}).Where("id = ?", event.ID).Updates(updates)
+}
+
+// Backoff returns the retry delay after the given number of attempts (2^n seconds, capped at 10 minutes)
+func Backoff(attempts int) time.Duration {
+	if attempts > 10 {
+		return 10 * time.Minute
+	}
+	delay := time.Duration(1<<uint(attempts)) * time.Second
+	if delay > 10*time.Minute {
+		delay = 10 * time.Minute
+	}
+	return delay
+}
+
+// Redrive returns a parked event to the pending queue for immediate retry
+func Redrive(db *gorm.DB, id uint) (bool, error) {
+	result := db.Model(&models.OutboxEvent{}).Where("id = ? AND status = ?", id, "failed").Updates(map[string]interface{}{
+		"status":          "pending",
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Lag returns the age of the oldest pending event, or zero when the outbox is drained
+func Lag(db *gorm.DB) time.Duration {
+	var oldest models.OutboxEvent
+	if err := db.Where("status = ?", "pending").Order("id").First(&oldest).Error; err != nil {
+		return 0
+	}
+	return clock.Since(oldest.CreatedAt) // Created on the application clock
+}
+
+func aggregateKey(event models.OutboxEvent) string {
+	return event.AggregateType + ":" + strconv.FormatUint(uint64(event.AggregateID), 10)
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,164 @@
+package events
+
+import (
+	"banking-app/models"
+	"banking-app/tracing"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AccountEventTypes are the events a customer's subscription on one of their accounts may receive
+var AccountEventTypes = []string{TransactionPosted, BalanceLow, StatementReady}
+
+// WebhookPublisher queues events for every matching active subscription
+// Queuing never waits on an endpoint, so one that is down cannot hold up the outbox; the WebhookDeliverer
+// sends each subscription's queue
+type WebhookPublisher struct {
+	DB  *gorm.DB
+	Cfg WebhookConfig
+
+	// Launched reports whether account subscriptions are live for a customer; nil leaves them live for everyone
+	Launched func(customerID uint) bool
+}
+
+// NewWebhookPublisher creates a publisher that caps each subscription's queue per cfg
+func NewWebhookPublisher(db *gorm.DB, cfg WebhookConfig) *WebhookPublisher {
+	return &WebhookPublisher{DB: db, Cfg: cfg}
+}
+
+// Publish queues the event for all subscriptions interested in its type
+// The delivery keeps the publish span's traceparent, so the delivery span joins the event's trace
+// Queuing the same event twice, after a partial outbox failure, is a no-op
+func (p *WebhookPublisher) Publish(ctx context.Context, event models.OutboxEvent) error {
+	db := p.DB.WithContext(ctx)
+	var subscriptions []models.WebhookSubscription
+	if err := db.Where("active = ?", true).Find(&subscriptions).Error; err != nil {
+		return err
+	}
+
+	for _, sub := range subscriptions {
+		if !Delivers(sub, event) {
+			continue
+		}
+		if sub.AccountID != nil && p.Launched != nil && !p.Launched(sub.CustomerID) {
+			continue
+		}
+		delivery := models.WebhookDelivery{
+			SubscriptionID: sub.ID,
+			EventID:        event.ID,
+			EventType:      event.EventType,
+			TraceParent:    tracing.TraceParent(ctx),
+			Status:         DeliveryPending,
+			NextAttemptAt:  time.Now(),
+		}
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&delivery).Error; err != nil {
+			return fmt.Errorf("subscription %d: %w", sub.ID, err)
+		}
+		if err := trimBacklog(db, sub, p.Cfg.backlogLimit(sub)); err != nil {
+			return fmt.Errorf("subscription %d: %w", sub.ID, err)
+		}
+	}
+	return nil
+}
+
+// send posts one signed event to one subscription
+// The request carries a traceparent header, so receivers that trace can join the event's trace
+func send(ctx context.Context, client *http.Client, sub models.WebhookSubscription, delivery models.WebhookDelivery, event models.OutboxEvent) (err error) {
+	ctx, span := tracing.Start(ctx, "webhook.deliver", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int64("webhook.subscription_id", int64(sub.ID))))
+	defer func() { tracing.End(span, err) }()
+
+	body := []byte(event.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	tracing.Inject(ctx, req.Header)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", strconv.FormatUint(uint64(event.ID), 10))
+	req.Header.Set("X-Event-Type", event.EventType)
+	if delivery.DroppedBefore > 0 {
+		req.Header.Set("X-Webhook-Dropped", strconv.Itoa(delivery.DroppedBefore))
+	}
+	if sub.Secret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+Sign(sub.Secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Subscribed reports whether a subscription wants an event type
+func Subscribed(sub models.WebhookSubscription, eventType string) bool {
+	if strings.TrimSpace(sub.EventTypes) == "" {
+		return true
+	}
+	for _, t := range strings.Split(sub.EventTypes, ",") {
+		if strings.TrimSpace(t) == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivers reports whether an event goes to a subscription. A subscription on an account gets only the
+// AccountEventTypes it chose, and only when both the event's aggregate and its payload name that account
+func Delivers(sub models.WebhookSubscription, event models.OutboxEvent) bool {
+	if sub.AccountID == nil {
+		return Subscribed(sub, event.EventType)
+	}
+	if event.AggregateType != AggregateAccount || event.AggregateID != *sub.AccountID {
+		return false
+	}
+	if !AccountEvent(event.EventType) || !Subscribed(sub, event.EventType) {
+		return false
+	}
+	var payload struct {
+		AccountID *uint `json:"account_id"`
+	}
+	if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil || payload.AccountID == nil {
+		return false
+	}
+	return *payload.AccountID == *sub.AccountID
+}
+
+// AccountEvent reports whether an event type may be sent to an account's subscription
+func AccountEvent(eventType string) bool {
+	for _, t := range AccountEventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign computes the hex HMAC-SHA256 of a payload with the subscription secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,264 @@
+package exceptions
+
+import (
+	"banking-app/clock"
+	"banking-app/flags"
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Exception statuses
+const (
+	StatusOpen     = "open"
+	StatusApplied  = "applied"
+	StatusReturned = "returned"
+)
+
+// Reasons an incoming credit is held in suspense
+const (
+	ReasonUnknownAccount   = "unknown_account"
+	ReasonAccountInactive  = "account_inactive"
+	ReasonCurrencyMismatch = "currency_mismatch"
+)
+
+// SystemActor resolves items the job returns automatically
+const SystemActor = "system"
+
+// Resolution errors
+var (
+	ErrNotOpen             = errors.New("exception is not open")
+	ErrInvalidDestination  = errors.New("destination must be an active customer account")
+	ErrDestinationCurrency = errors.New("destination currency does not match the credit")
+)
+
+// Config holds the queue's service level
+type Config struct {
+	ReturnAfter time.Duration // Open items older than this are returned to the originator
+}
+
+// ConfigFromEnv reads EXCEPTION_RETURN_DAYS (default 30)
+func ConfigFromEnv() Config {
+	n, err := strconv.Atoi(os.Getenv("EXCEPTION_RETURN_DAYS"))
+	if err != nil || n <= 0 {
+		n = 30
+	}
+	return Config{ReturnAfter: time.Duration(n) * 24 * time.Hour}
+}
+
+// Credit is an incoming credit from outside the bank, addressed by account number
+type Credit struct {
+	AccountNumber string  `json:"account_number"`
+	Amount        float64 `json:"amount"`
+	Currency      string  `json:"currency"`
+	Reference     string  `json:"reference"`
+	Originator    string  `json:"originator"`
+	Description   string  `json:"description"`
+}
+
+// Receipt is where an incoming credit landed
+type Receipt struct {
+	Transaction models.Transaction    // Credit on the customer or suspense account
+	Account     models.Account        // Account credited
+	Exception   *models.ExceptionItem // Set when the credit was held in suspense
+}
+
+// Receive posts an incoming credit to its account, or to suspense with an open exception when it cannot be applied
+// payload is kept verbatim on the exception for investigation
+func Receive(tx *gorm.DB, tenantID uint, credit Credit, payload string, featureFlags *flags.Store) (Receipt, error) {
+	var receipt Receipt
+	var account models.Account
+	reason := ""
+	err := tx.Where("account_number = ? AND account_type <> ?", credit.AccountNumber, gl.AccountType).First(&account).Error
+	switch {
+	case err == gorm.ErrRecordNotFound:
+		reason = ReasonUnknownAccount
+	case err != nil:
+		return receipt, err
+	case account.Status != "active":
+		reason = ReasonAccountInactive
+	case account.Currency != credit.Currency:
+		reason = ReasonCurrencyMismatch
+	}
+
+	description := credit.Description
+	if description == "" {
+		description = "Incoming credit"
+	}
+	if credit.Originator != "" {
+		description += " from " + credit.Originator
+	}
+	receipt.Transaction = models.Transaction{
+		AccountID:       account.ID,
+		TransactionType: "deposit",
+		Amount:          credit.Amount,
+		Description:     description,
+		Reference:       credit.Reference,
+		Channel:         "api",
+	}
+
+	if reason == "" {
+		receipt.Account, err = ledger.PostExternal(tx, &receipt.Transaction, featureFlags)
+		return receipt, err
+	}
+
+	suspense, err := gl.Account(tx, tenantID, gl.Suspense, credit.Currency)
+	if err != nil {
+		return receipt, err
+	}
+	receipt.Transaction.AccountID = suspense.ID
+	if receipt.Account, err = ledger.PostExternal(tx, &receipt.Transaction, featureFlags); err != nil {
+		return receipt, err
+	}
+
+	receipt.Exception = &models.ExceptionItem{
+		TenantID:              tenantID,
+		Status:                StatusOpen,
+		Reason:                reason,
+		Payload:               payload,
+		AccountNumber:         credit.AccountNumber,
+		Amount:                credit.Amount,
+		Currency:              credit.Currency,
+		Reference:             credit.Reference,
+		Originator:            credit.Originator,
+		SuspenseTransactionID: receipt.Transaction.ID,
+	}
+	return receipt, tx.Create(receipt.Exception).Error
+}
+
+// Apply moves an open item's funds out of suspense into a corrected account inside an open transaction
+// Returns the credited account
+func Apply(tx *gorm.DB, item *models.ExceptionItem, accountID uint, by, note string) (models.Account, error) {
+	var account models.Account
+	if !lifecycle.Allows(lifecycle.ExceptionItem, item.Status, StatusApplied) {
+		return account, ErrNotOpen
+	}
+	if err := tx.First(&account, accountID).Error; err != nil {
+		return account, err
+	}
+	if account.AccountType == gl.AccountType || account.Status != "active" {
+		return account, ErrInvalidDestination
+	}
+	if account.Currency != item.Currency {
+		return account, ErrDestinationCurrency
+	}
+
+	suspense, err := gl.Account(tx, item.TenantID, gl.Suspense, item.Currency)
+	if err != nil {
+		return account, err
+	}
+	debit := models.Transaction{
+		AccountID:       suspense.ID,
+		TransactionType: "transfer",
+		Amount:          item.Amount,
+		Description:     fmt.Sprintf("Exception %d applied to %s", item.ID, account.AccountNumber),
+		Reference:       item.Reference,
+		Channel:         "api",
+	}
+	if _, err := ledger.Post(tx, &debit, nil); err != nil {
+		return account, err
+	}
+	credit := models.Transaction{
+		AccountID:       account.ID,
+		TransactionType: "deposit",
+		Amount:          item.Amount,
+		Description:     "Incoming credit",
+		Reference:       item.Reference,
+		Channel:         "api",
+	}
+	if item.Originator != "" {
+		credit.Description += " from " + item.Originator
+	}
+	if account, err = ledger.Post(tx, &credit, nil); err != nil {
+		return account, err
+	}
+
+	item.AppliedAccountID = &account.ID
+	return account, resolve(tx, item, StatusApplied, debit.ID, by, note)
+}
+
+// Return sends an open item's funds back to the originator inside an open transaction
+func Return(tx *gorm.DB, item *models.ExceptionItem, by, note string) error {
+	if !lifecycle.Allows(lifecycle.ExceptionItem, item.Status, StatusReturned) {
+		return ErrNotOpen
+	}
+	suspense, err := gl.Account(tx, item.TenantID, gl.Suspense, item.Currency)
+	if err != nil {
+		return err
+	}
+	debit := models.Transaction{
+		AccountID:       suspense.ID,
+		TransactionType: "withdrawal",
+		Amount:          item.Amount,
+		Description:     fmt.Sprintf("Exception %d returned to originator", item.ID),
+		Reference:       item.Reference,
+		Channel:         "api",
+	}
+	if _, err := ledger.PostExternal(tx, &debit, nil); err != nil {
+		return err
+	}
+
+	return resolve(tx, item, StatusReturned, debit.ID, by, note)
+}
+
+// resolve records who took an item out of the queue and the suspense debit that did it
+// The update only matches an item still open, so a concurrent resolution rolls this one back
+func resolve(tx *gorm.DB, item *models.ExceptionItem, status string, debitID uint, by, note string) error {
+	now := clock.Now()
+	result := tx.Model(&models.ExceptionItem{}).Where("id = ? AND status = ?", item.ID, StatusOpen).Updates(map[string]interface{}{
+		"status":                    status,
+		"applied_account_id":        item.AppliedAccountID,
+		"resolution_transaction_id": debitID,
+		"resolved_at":               now,
+		"resolved_by":               by,
+		"resolution_note":           note,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotOpen
+	}
+	item.Status = status
+	item.ResolutionTransactionID = &debitID
+	item.ResolvedAt = &now
+	item.ResolvedBy = by
+	item.ResolutionNote = note
+	return nil
+}
+
+// AutoReturn returns every item that has been open longer than the configured period
+// Each item is returned in its own transaction so one failure does not hold up the rest
+func AutoReturn(db *gorm.DB, cfg Config, now time.Time) (int, error) {
+	var due []models.ExceptionItem
+	if err := db.Where("status = ? AND created_at <= ?", StatusOpen, now.Add(-cfg.ReturnAfter)).Find(&due).Error; err != nil {
+		return 0, err
+	}
+	returned := 0
+	for _, item := range due {
+		note := fmt.Sprintf("Unresolved after %d days", int(cfg.ReturnAfter.Hours()/24))
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return Return(tx, &item, SystemActor, note)
+		})
+		if err != nil {
+			return returned, err
+		}
+		returned++
+	}
+	return returned, nil
+}
+
+// OpenCount is the number of items awaiting resolution
+func OpenCount(db *gorm.DB) (int64, error) {
+	var count int64
+	err := db.Model(&models.ExceptionItem{}).Where("status = ?", StatusOpen).Count(&count).Error
+	return count, err
+}
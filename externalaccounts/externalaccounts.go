@@ -0,0 +1,369 @@
+package externalaccounts
+
+import (
+	"banking-app/models"
+	"banking-app/money"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Link statuses
+const (
+	StatusPending  = "pending"  // Micro-deposits sent, waiting for the customer to confirm them
+	StatusVerified = "verified" // Confirmed; usable as a transfer destination
+	StatusLocked   = "locked"   // Every attempt was used up; purged with the other unverified links
+)
+
+// MaxAttempts is how many wrong confirmations lock a link
+const MaxAttempts = 3
+
+// LinkTTL is how long a link can wait for its micro-deposits to be confirmed before it is purged
+const LinkTTL = 7 * 24 * time.Hour
+
+// AccountTypes are the kinds of external account that can be linked
+var AccountTypes = []string{"checking", "savings"}
+
+// Link errors - handlers map these to client responses
+var (
+	ErrDetails     = errors.New("routing_number must be a valid 9-digit ABA routing number and account_number 4 to 17 digits")
+	ErrAccountType = errors.New("account_type must be checking or savings")
+	ErrLinked      = errors.New("this account is already linked or awaiting verification")
+	ErrVerified    = errors.New("the external account is already verified")
+	ErrLocked      = errors.New("too many wrong amounts were given; the link stays locked until it expires")
+	ErrExpired     = errors.New("the micro-deposits have expired; link the account again")
+	ErrMismatch    = errors.New("the amounts do not match the micro-deposits")
+	ErrNotPending  = errors.New("the external account is no longer awaiting verification")
+	ErrNotVerified = errors.New("the external account has not been verified")
+)
+
+// Sealer encrypts external account details; the key never leaves the server
+type Sealer struct {
+	aead cipher.AEAD
+	key  []byte
+}
+
+// NewSealer returns a sealer keyed with a SHA-256 of secret
+func NewSealer(secret []byte) *Sealer {
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err) // A 32-byte key is always valid
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return &Sealer{aead: aead, key: key[:]}
+}
+
+// SealerFromEnv keys the sealer with EXTERNAL_ACCOUNT_KEY, falling back to JWT_SECRET
+// Details sealed under one key cannot be read after it changes, so a dedicated key is preferred - JWT_SECRET
+// is rotated with bankctl
+func SealerFromEnv() *Sealer {
+	secret := os.Getenv("EXTERNAL_ACCOUNT_KEY")
+	if secret == "" {
+		log.Println("externalaccounts: EXTERNAL_ACCOUNT_KEY not set, sealing with JWT_SECRET")
+		secret = os.Getenv("JWT_SECRET")
+	}
+	return NewSealer([]byte(secret))
+}
+
+// Seal encrypts a value with a fresh nonce
+func (s *Sealer) Seal(plain string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(s.aead.Seal(nonce, nonce, []byte(plain), nil)), nil
+}
+
+// Open decrypts a sealed value
+func (s *Sealer) Open(sealed string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < s.aead.NonceSize() {
+		return "", errors.New("externalaccounts: sealed value is too short")
+	}
+	plain, err := s.aead.Open(nil, raw[:s.aead.NonceSize()], raw[s.aead.NonceSize():], nil)
+	return string(plain), err
+}
+
+// fingerprint identifies an account's numbers without storing them readably
+func (s *Sealer) fingerprint(routing, account string) string {
+	mac := hmac.New(sha256.New, s.key)
+	io.WriteString(mac, routing+":"+account)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Request is an external account to link
+type Request struct {
+	RoutingNumber string
+	AccountNumber string
+	AccountType   string
+	Nickname      string
+}
+
+// Link records an external account for a customer and generates the two micro-deposits that confirm it
+// The amounts are only ever sent to the other bank; the customer reads them from their statement there
+func Link(db *gorm.DB, sealer *Sealer, customerID uint, req Request, now time.Time) (models.ExternalAccount, error) {
+	routing, number := strings.TrimSpace(req.RoutingNumber), strings.TrimSpace(req.AccountNumber)
+	if !validRouting(routing) || len(number) < 4 || len(number) > 17 || !allDigits(number) {
+		return models.ExternalAccount{}, ErrDetails
+	}
+	accountType := strings.ToLower(strings.TrimSpace(req.AccountType))
+	if accountType == "" {
+		accountType = "checking"
+	}
+	known := false
+	for _, t := range AccountTypes {
+		known = known || t == accountType
+	}
+	if !known {
+		return models.ExternalAccount{}, ErrAccountType
+	}
+
+	// A verified link, or one still waiting, stands in the way of linking the same account again
+	fingerprint := sealer.fingerprint(routing, number)
+	var existing int64
+	err := db.Model(&models.ExternalAccount{}).
+		Where("customer_id = ? AND fingerprint = ? AND (status = ? OR expires_at > ?)", customerID, fingerprint, StatusVerified, now).
+		Count(&existing).Error
+	if err != nil {
+		return models.ExternalAccount{}, err
+	}
+	if existing > 0 {
+		return models.ExternalAccount{}, ErrLinked
+	}
+
+	first, second, err := microDeposits()
+	if err != nil {
+		return models.ExternalAccount{}, err
+	}
+	link := models.ExternalAccount{
+		CustomerID:  customerID,
+		Nickname:    strings.TrimSpace(req.Nickname),
+		AccountType: accountType,
+		Mask:        "••••" + number[len(number)-4:],
+		Fingerprint: fingerprint,
+		Status:      StatusPending,
+		ExpiresAt:   now.Add(LinkTTL),
+	}
+	if link.RoutingNumber, err = sealer.Seal(routing); err != nil {
+		return link, err
+	}
+	if link.AccountNumber, err = sealer.Seal(number); err != nil {
+		return link, err
+	}
+	if link.Deposits, err = sealer.Seal(fmt.Sprintf("%d,%d", first, second)); err != nil {
+		return link, err
+	}
+	return link, db.Create(&link).Error
+}
+
+// Verify confirms a pending link with the two micro-deposit amounts, in either order
+// A wrong pair uses up an attempt and returns ErrMismatch with the link as it now stands; the last attempt locks it
+func Verify(db *gorm.DB, sealer *Sealer, customerID, id uint, amounts []float64, now time.Time) (models.ExternalAccount, error) {
+	var link models.ExternalAccount
+	mismatch := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("customer_id = ?", customerID).First(&link, id).Error; err != nil {
+			return err
+		}
+		switch {
+		case link.Status == StatusVerified:
+			return ErrVerified
+		case link.Status == StatusLocked:
+			return ErrLocked
+		case !now.Before(link.ExpiresAt):
+			return ErrExpired
+		}
+		plain, err := sealer.Open(link.Deposits)
+		if err != nil {
+			return err
+		}
+
+		if matches(amounts, plain) {
+			result := tx.Model(&link).Where("status = ?", StatusPending).
+				Updates(map[string]interface{}{"status": StatusVerified, "verified_at": now, "deposits": ""})
+			if result.Error == nil && result.RowsAffected == 0 {
+				return ErrNotPending
+			}
+			return result.Error
+		}
+
+		// The attempts condition stops concurrent wrong guesses from getting past the limit
+		mismatch = true
+		result := tx.Model(&models.ExternalAccount{}).Where("id = ? AND status = ? AND attempts < ?", link.ID, StatusPending, MaxAttempts).
+			Update("attempts", gorm.Expr("attempts + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotPending
+		}
+		err = tx.Model(&models.ExternalAccount{}).Where("id = ? AND status = ? AND attempts >= ?", link.ID, StatusPending, MaxAttempts).
+			Updates(map[string]interface{}{"status": StatusLocked, "locked_at": now, "deposits": ""}).Error
+		if err != nil {
+			return err
+		}
+		return tx.First(&link, link.ID).Error
+	})
+	if err == nil && mismatch {
+		err = ErrMismatch
+	}
+	return link, err
+}
+
+// Unlink removes a customer's link; a locked one stays until it is purged, so the lockout cannot be skipped
+func Unlink(db *gorm.DB, customerID, id uint) error {
+	var link models.ExternalAccount
+	if err := db.Where("customer_id = ?", customerID).First(&link, id).Error; err != nil {
+		return err
+	}
+	if link.Status == StatusLocked {
+		return ErrLocked
+	}
+	return db.Delete(&link).Error
+}
+
+// Destination returns a customer's verified link for sending money to
+// Payments out to other banks are not posted yet; this is the check they are to go through
+func Destination(db *gorm.DB, customerID, id uint) (models.ExternalAccount, error) {
+	var link models.ExternalAccount
+	if err := db.Where("customer_id = ?", customerID).First(&link, id).Error; err != nil {
+		return link, err
+	}
+	if link.Status != StatusVerified {
+		return link, ErrNotVerified
+	}
+	return link, nil
+}
+
+// Origination is a pending link's micro-deposits, as they are to be sent to the other bank
+type Origination struct {
+	LinkID        uint      `json:"external_account_id"`
+	TenantID      uint      `json:"tenant_id"`
+	CustomerID    uint      `json:"customer_id"`
+	CreatedAt     time.Time `json:"created_at"`
+	RoutingNumber string    `json:"routing_number"`
+	AccountNumber string    `json:"account_number"`
+	AccountType   string    `json:"account_type"`
+	Amounts       []float64 `json:"amounts"`
+}
+
+// Pending opens every link still waiting for its micro-deposits to be confirmed, oldest first
+// There is no ACH connection yet, so operators send the deposits from this list; it is not served by the API
+func Pending(db *gorm.DB, sealer *Sealer, now time.Time) ([]Origination, error) {
+	var links []models.ExternalAccount
+	if err := db.Where("status = ? AND expires_at > ?", StatusPending, now).Order("id").Find(&links).Error; err != nil {
+		return nil, err
+	}
+	list := make([]Origination, 0, len(links))
+	for _, link := range links {
+		o := Origination{LinkID: link.ID, TenantID: link.TenantID, CustomerID: link.CustomerID, CreatedAt: link.CreatedAt, AccountType: link.AccountType}
+		var deposits string
+		var err error
+		if o.RoutingNumber, err = sealer.Open(link.RoutingNumber); err != nil {
+			return nil, fmt.Errorf("external account %d: %w", link.ID, err)
+		}
+		if o.AccountNumber, err = sealer.Open(link.AccountNumber); err != nil {
+			return nil, fmt.Errorf("external account %d: %w", link.ID, err)
+		}
+		if deposits, err = sealer.Open(link.Deposits); err != nil {
+			return nil, fmt.Errorf("external account %d: %w", link.ID, err)
+		}
+		for _, part := range strings.Split(deposits, ",") {
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("external account %d: %w", link.ID, err)
+			}
+			o.Amounts = append(o.Amounts, float64(n)/100)
+		}
+		list = append(list, o)
+	}
+	return list, nil
+}
+
+// Purge deletes unverified links, pending or locked, whose time to be confirmed ran out by now
+func Purge(db *gorm.DB, now time.Time) (int, error) {
+	result := db.Where("status <> ? AND expires_at <= ?", StatusVerified, now).Delete(&models.ExternalAccount{})
+	return int(result.RowsAffected), result.Error
+}
+
+// microDeposits returns two different random amounts between 1 and 99 cents
+func microDeposits() (int, int, error) {
+	pick := func() (int, error) {
+		n, err := rand.Int(rand.Reader, big.NewInt(99))
+		if err != nil {
+			return 0, err
+		}
+		return int(n.Int64()) + 1, nil
+	}
+	first, err := pick()
+	if err != nil {
+		return 0, 0, err
+	}
+	for {
+		second, err := pick()
+		if err != nil || second != first {
+			return first, second, err
+		}
+	}
+}
+
+// matches reports whether amounts are the two sealed deposits, in either order
+func matches(amounts []float64, plain string) bool {
+	parts := strings.Split(plain, ",")
+	if len(amounts) != 2 || len(parts) != 2 {
+		return false
+	}
+	first, err1 := strconv.Atoi(parts[0])
+	second, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	a, b := money.Cents(amounts[0]), money.Cents(amounts[1])
+	x, y := float64(first)/100, float64(second)/100
+	return (a == x && b == y) || (a == y && b == x)
+}
+
+// validRouting checks an ABA routing number's length and check digit
+func validRouting(routing string) bool {
+	if len(routing) != 9 || !allDigits(routing) {
+		return false
+	}
+	weights := []int{3, 7, 1}
+	sum := 0
+	for i, r := range routing {
+		sum += int(r-'0') * weights[i%3]
+	}
+	return sum%10 == 0
+}
+
+// allDigits reports whether s is only ASCII digits
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,400 @@
+package feed
+
+import (
+	"banking-app/events"
+	"banking-app/ledger"
+	"banking-app/models"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Feed item types
+const (
+	TypeTransaction = "transaction"
+	TypeStatus      = "status"
+	TypeAlert       = "alert"
+	TypeDocument    = "document"
+	TypePlan        = "installment_plan"
+	TypeInstallment = "installment_payment"
+	TypeHold        = "hold"
+)
+
+// Types lists every item type in source order
+var Types = []string{TypeTransaction, TypeStatus, TypeAlert, TypeDocument, TypePlan, TypeInstallment, TypeHold}
+
+// ErrInvalidCursor is returned for a cursor this package did not issue
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Item is one entry in an account's activity feed
+type Item struct {
+	ID        string                 `json:"id"` // Stable across calls: <type>_<source row id>, or hold_<table>_<row id>
+	Type      string                 `json:"type"`
+	Timestamp time.Time              `json:"timestamp"`
+	Title     string                 `json:"title"`
+	Details   map[string]interface{} `json:"details"`
+
+	rank  int  // Position of the source in Types; breaks timestamp ties
+	rowID uint // Primary key in the source table, or a hold's key; breaks ties within a source
+}
+
+// Cursor marks the last item of a page; the next page starts strictly after it
+type Cursor struct {
+	Timestamp time.Time
+	Rank      int
+	RowID     uint
+}
+
+// Encode renders the cursor as an opaque string for clients
+func (c Cursor) Encode() string {
+	raw := fmt.Sprintf("%d:%d:%d", c.Timestamp.UnixNano(), c.Rank, c.RowID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a cursor produced by Encode
+func DecodeCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 3 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	nanos, err1 := strconv.ParseInt(parts[0], 10, 64)
+	rank, err2 := strconv.Atoi(parts[1])
+	rowID, err3 := strconv.ParseUint(parts[2], 10, 32)
+	if err1 != nil || err2 != nil || err3 != nil || rank < 0 || rank >= len(Types) {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{Timestamp: time.Unix(0, nanos), Rank: rank, RowID: uint(rowID)}, nil
+}
+
+// afterFunc restricts a source query to rows ordered after the cursor, given its timestamp and id columns
+type afterFunc func(q *gorm.DB, tsColumn, idColumn string) *gorm.DB
+
+// source loads up to limit items of one type for an account, newest first, strictly after the cursor
+type source func(db *gorm.DB, accountID uint, after afterFunc, limit int) ([]Item, error)
+
+// sources are indexed like Types; new types go at the end so issued cursors keep their ranks
+var sources = []source{transactions, statusChanges, alertFirings, accountDocuments, installmentPlans, installmentPayments, holds}
+
+// Page loads one page of an account's feed, newest first, interleaving every requested type by timestamp
+// Each source is asked for limit+1 items after the cursor, so the merged page is exact and knows whether more exist
+func Page(db *gorm.DB, accountID uint, types []string, after *Cursor, limit int) ([]Item, *Cursor, error) {
+	wanted := map[string]bool{}
+	for _, t := range types {
+		wanted[t] = true
+	}
+
+	var items []Item
+	for rank, load := range sources {
+		if len(wanted) > 0 && !wanted[Types[rank]] {
+			continue
+		}
+		rows, err := load(db, accountID, afterFilter(after, rank), limit+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range rows {
+			rows[i].rank = rank
+		}
+		items = append(items, rows...)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.After(b.Timestamp)
+		}
+		if a.rank != b.rank {
+			return a.rank > b.rank
+		}
+		return a.rowID > b.rowID
+	})
+
+	var next *Cursor
+	if len(items) > limit {
+		items = items[:limit]
+		last := items[limit-1]
+		next = &Cursor{Timestamp: last.Timestamp, Rank: last.rank, RowID: last.rowID}
+	}
+	if items == nil {
+		items = []Item{}
+	}
+	return items, next, nil
+}
+
+// afterFilter restricts a source's rows to those ordered after the cursor
+// Order is (timestamp, source rank, row id), all descending
+func afterFilter(after *Cursor, rank int) afterFunc {
+	return func(q *gorm.DB, tsColumn, idColumn string) *gorm.DB {
+		if after == nil {
+			return q
+		}
+		switch {
+		case rank < after.Rank:
+			return q.Where(tsColumn+" <= ?", after.Timestamp)
+		case rank > after.Rank:
+			return q.Where(tsColumn+" < ?", after.Timestamp)
+		default:
+			return q.Where("("+tsColumn+" < ? OR ("+tsColumn+" = ? AND "+idColumn+" < ?))", after.Timestamp, after.Timestamp, after.RowID)
+		}
+	}
+}
+
+// transactions are postings on the account, stamped when they were recorded
+func transactions(db *gorm.DB, accountID uint, after afterFunc, limit int) ([]Item, error) {
+	var rows []models.Transaction
+	query := after(db.Where("account_id = ?", accountID), "created_at", "id")
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	items := make([]Item, 0, len(rows))
+	for _, t := range rows {
+		title := t.Descriptor
+		if title == "" {
+			title = strings.ToUpper(t.TransactionType[:1]) + t.TransactionType[1:]
+			if t.MerchantName != "" {
+				title += " - " + t.MerchantName
+			}
+		}
+		details := map[string]interface{}{
+			"transaction_id":   t.TransactionID,
+			"transaction_type": t.TransactionType,
+			"amount":           t.Amount,
+			"balance_after":    t.BalanceAfter,
+			"description":      t.Description,
+			"descriptor":       t.Descriptor,
+			"memo":             t.Memo,
+			"effective_date":   t.EffectiveDate,
+		}
+		if t.ReversalOfID != nil {
+			details["reversal_of_id"] = *t.ReversalOfID
+		}
+		items = append(items, Item{
+			ID: fmt.Sprintf("%s_%d", TypeTransaction, t.ID), Type: TypeTransaction, Timestamp: t.CreatedAt,
+			Title: title, Details: details, rowID: t.ID,
+		})
+	}
+	return items, nil
+}
+
+// statusTitles names the account lifecycle events shown in the feed
+var statusTitles = map[string]string{
+	events.AccountOpened:     "Account opened",
+	events.AccountFrozen:     "Account frozen",
+	events.AccountUnfrozen:   "Account unfrozen",
+	events.AccountClosed:     "Account closed",
+	events.AccountEscheated:  "Balance turned over as unclaimed property",
+	events.AccountReclaimed:  "Unclaimed property returned",
+	events.AccountRestricted: "Account restrictions changed",
+	events.AccountConverted:  "Account product changed",
+}
+
+// statusChanges come from the account's lifecycle events in the outbox
+func statusChanges(db *gorm.DB, accountID uint, after afterFunc, limit int) ([]Item, error) {
+	eventTypes := make([]string, 0, len(statusTitles))
+	for t := range statusTitles {
+		eventTypes = append(eventTypes, t)
+	}
+	var rows []models.OutboxEvent
+	query := db.Where("aggregate_type = ? AND aggregate_id = ? AND event_type IN ?", events.AggregateAccount, accountID, eventTypes)
+	if err := after(query, "created_at", "id").Order("created_at DESC, id DESC").Limit(limit).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	items := make([]Item, 0, len(rows))
+	for _, e := range rows {
+		details := map[string]interface{}{}
+		json.Unmarshal([]byte(e.Payload), &details)
+		details["event_type"] = e.EventType
+		items = append(items, Item{
+			ID: fmt.Sprintf("%s_%d", TypeStatus, e.ID), Type: TypeStatus, Timestamp: e.CreatedAt,
+			Title: statusTitles[e.EventType], Details: details, rowID: e.ID,
+		})
+	}
+	return items, nil
+}
+
+// alertFirings are the account's alert rules that triggered
+func alertFirings(db *gorm.DB, accountID uint, after afterFunc, limit int) ([]Item, error) {
+	var rows []models.AlertFiring
+	query := after(db.Where("account_id = ?", accountID), "created_at", "id")
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	items := make([]Item, 0, len(rows))
+	for _, f := range rows {
+		details := map[string]interface{}{
+			"alert_rule_id":   f.AlertRuleID,
+			"notification_id": f.NotificationID,
+			"message":         f.Message,
+		}
+		if f.TransactionID != nil {
+			details["transaction_id"] = *f.TransactionID
+		}
+		items = append(items, Item{
+			ID: fmt.Sprintf("%s_%d", TypeAlert, f.ID), Type: TypeAlert, Timestamp: f.CreatedAt,
+			Title: "Alert triggered", Details: details, rowID: f.ID,
+		})
+	}
+	return items, nil
+}
+
+// accountDocuments are uploads filed against the account, such as cheque images
+func accountDocuments(db *gorm.DB, accountID uint, after afterFunc, limit int) ([]Item, error) {
+	var rows []models.Document
+	query := after(db.Where("account_id = ?", accountID), "created_at", "id")
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	items := make([]Item, 0, len(rows))
+	for _, d := range rows {
+		items = append(items, Item{
+			ID: fmt.Sprintf("%s_%d", TypeDocument, d.ID), Type: TypeDocument, Timestamp: d.CreatedAt,
+			Title: "Document uploaded",
+			Details: map[string]interface{}{
+				"document_id":  d.ID,
+				"kind":         d.Kind,
+				"filename":     d.Filename,
+				"content_type": d.ContentType,
+			},
+			rowID: d.ID,
+		})
+	}
+	return items, nil
+}
+
+// installmentPlans are payments on the account converted into installments
+func installmentPlans(db *gorm.DB, accountID uint, after afterFunc, limit int) ([]Item, error) {
+	var rows []models.InstallmentPlan
+	query := after(db.Where("account_id = ?", accountID), "created_at", "id")
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	items := make([]Item, 0, len(rows))
+	for _, p := range rows {
+		items = append(items, Item{
+			ID: fmt.Sprintf("%s_%d", TypePlan, p.ID), Type: TypePlan, Timestamp: p.CreatedAt,
+			Title: fmt.Sprintf("Installment plan over %d months", p.Months),
+			Details: map[string]interface{}{
+				"plan_id":         p.ID,
+				"plan_number":     p.PlanNumber,
+				"transaction_id":  p.TransactionID,
+				"amount":          p.Amount,
+				"fee":             p.Fee,
+				"monthly_payment": p.MonthlyPayment,
+				"status":          p.Status,
+			},
+			rowID: p.ID,
+		})
+	}
+	return items, nil
+}
+
+// installmentPayments are installments collected from the account for its plans
+func installmentPayments(db *gorm.DB, accountID uint, after afterFunc, limit int) ([]Item, error) {
+	var rows []struct {
+		models.LoanPayment
+		PlanID     uint
+		PlanNumber string
+	}
+	query := db.Model(&models.LoanPayment{}).
+		Select("loan_payments.*, installment_plans.id AS plan_id, installment_plans.plan_number").
+		Joins("JOIN installment_plans ON installment_plans.loan_id = loan_payments.loan_id AND installment_plans.deleted_at IS NULL").
+		Where("installment_plans.account_id = ?", accountID)
+	query = after(query, "loan_payments.created_at", "loan_payments.id")
+	if err := query.Order("loan_payments.created_at DESC, loan_payments.id DESC").Limit(limit).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	items := make([]Item, 0, len(rows))
+	for _, p := range rows {
+		items = append(items, Item{
+			ID: fmt.Sprintf("%s_%d", TypeInstallment, p.ID), Type: TypeInstallment, Timestamp: p.CreatedAt,
+			Title: "Installment paid - " + p.PlanNumber,
+			Details: map[string]interface{}{
+				"plan_id":         p.PlanID,
+				"loan_payment_id": p.ID,
+				"transaction_id":  p.TransactionID,
+				"amount":          p.Amount,
+				"balance_after":   p.BalanceAfter,
+			},
+			rowID: p.ID,
+		})
+	}
+	return items, nil
+}
+
+// holds are amounts still held out of the account's available funds: debits waiting for review and pending
+// pre-authorizations, stamped when they were placed. A hold leaves the feed once it is decided, captured, released
+// or expired; what it posted shows as a transaction. Both tables share the hold rank, so their ids are interleaved
+// into one key, even for reviews and odd for authorizations, that orders them within it
+func holds(db *gorm.DB, accountID uint, after afterFunc, limit int) ([]Item, error) {
+	var reviews []models.TransactionReview
+	query := after(db.Where("account_id = ? AND status = ?", accountID, ledger.ReviewPending), "created_at", "(id * 2)")
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&reviews).Error; err != nil {
+		return nil, err
+	}
+	var authorizations []models.Authorization
+	query = after(db.Where("account_id = ? AND status = ?", accountID, ledger.AuthorizationPending), "created_at", "(id * 2 + 1)")
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&authorizations).Error; err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0, len(reviews)+len(authorizations))
+	for _, r := range reviews {
+		items = append(items, Item{
+			ID: fmt.Sprintf("%s_review_%d", TypeHold, r.ID), Type: TypeHold, Timestamp: r.CreatedAt,
+			Title: strings.ToUpper(r.TransactionType[:1]) + r.TransactionType[1:] + " held for review",
+			Details: map[string]interface{}{
+				"hold":             "review",
+				"review_id":        r.ID,
+				"transaction_type": r.TransactionType,
+				"amount":           r.Amount,
+				"description":      r.Description,
+				"memo":             r.Memo,
+				"review_by":        r.ReviewBy,
+			},
+			rowID: r.ID * 2,
+		})
+	}
+	for _, a := range authorizations {
+		title := "Pre-authorization"
+		if a.Merchant != "" {
+			title += " - " + a.Merchant
+		}
+		items = append(items, Item{
+			ID: fmt.Sprintf("%s_authorization_%d", TypeHold, a.ID), Type: TypeHold, Timestamp: a.CreatedAt,
+			Title: title,
+			Details: map[string]interface{}{
+				"hold":             "authorization",
+				"authorization_id": a.ID,
+				"channel":          a.Channel,
+				"amount":           a.Amount,
+				"merchant":         a.Merchant,
+				"reference":        a.Reference,
+				"expires_at":       a.ExpiresAt,
+			},
+			rowID: a.ID*2 + 1,
+		})
+	}
+
+	// Page merges by (timestamp, rank, row id), so only the newest limit of the two tables are needed
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].Timestamp.Equal(items[j].Timestamp) {
+			return items[i].Timestamp.After(items[j].Timestamp)
+		}
+		return items[i].rowID > items[j].rowID
+	})
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
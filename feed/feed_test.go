@@ -0,0 +1,116 @@
+package feed
+
+import (
+	"banking-app/database"
+	"banking-app/ledger"
+	"banking-app/models"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB opens a migrated database in a temporary directory holding one account
+func testDB(t *testing.T) (*gorm.DB, models.Account) {
+	t.Helper()
+	db, err := database.Open(filepath.Join(t.TempDir(), "feed.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	db.Logger = logger.Default.LogMode(logger.Silent)
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	customer := models.Customer{FirstName: "Feed", LastName: "Holder", Email: "feed@example.test", DateOfBirth: "1980-01-01", Status: "active"}
+	if err := db.Create(&customer).Error; err != nil {
+		t.Fatal(err)
+	}
+	account := models.Account{CustomerID: customer.ID, AccountNumber: "FEED-1", AccountType: "checking", Balance: 1000, Currency: "USD", Status: "active"}
+	if err := db.Create(&account).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db, account
+}
+
+func TestHolds(t *testing.T) {
+	db, account := testDB(t)
+	placed := time.Date(2026, time.March, 2, 9, 30, 0, 0, time.UTC)
+	review := func(status string, at time.Time) {
+		r := models.TransactionReview{CreatedAt: at, Status: status, Kind: "transaction", AccountID: account.ID, CustomerID: account.CustomerID,
+			TransactionType: "withdrawal", Amount: 400, RequestedBy: "teller", ReviewBy: at.Add(24 * time.Hour)}
+		if err := db.Create(&r).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	authorization := func(status string, at time.Time) {
+		a := models.Authorization{CreatedAt: at, Status: status, Channel: "card", AccountID: account.ID, CustomerID: account.CustomerID,
+			Amount: 80, Merchant: "Grand Hotel", ExpiresAt: at.Add(7 * 24 * time.Hour), RequestedBy: "teller"}
+		if err := db.Create(&a).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Holds from both tables tie on one timestamp, and a decided or released one is not held any more
+	for i := 0; i < 3; i++ {
+		review(ledger.ReviewPending, placed)
+		authorization(ledger.AuthorizationPending, placed)
+	}
+	review("declined", placed)
+	authorization("released", placed)
+	review(ledger.ReviewPending, placed.Add(-time.Hour))
+	transaction := models.Transaction{CreatedAt: placed, TransactionID: "FEED-TXN-1", AccountID: account.ID, TransactionType: "deposit", Amount: 1000}
+	if err := db.Create(&transaction).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"hold_authorization_3", "hold_review_3", "hold_authorization_2", "hold_review_2", "hold_authorization_1", "hold_review_1",
+		"transaction_1", "hold_review_5",
+	}
+	for _, limit := range []int{1, 2, 3, 100} {
+		var got []string
+		var after *Cursor
+		for page := 0; page < len(want)+1; page++ {
+			items, next, err := Page(db, account.ID, nil, after, limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, item := range items {
+				got = append(got, item.ID)
+			}
+			if next == nil {
+				break
+			}
+			// Clients only ever hold the encoded cursor
+			decoded, err := DecodeCursor(next.Encode())
+			if err != nil {
+				t.Fatalf("limit %d: cursor %+v does not decode: %v", limit, next, err)
+			}
+			after = &decoded
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("pages of %d held %v, want %v", limit, got, want)
+		}
+	}
+
+	items, _, err := Page(db, account.ID, []string{TypeHold}, nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 7 {
+		t.Fatalf("types=hold returned %d items, want the 7 pending holds", len(items))
+	}
+	if first := items[0]; first.Title != "Pre-authorization - Grand Hotel" || first.Details["hold"] != "authorization" || first.Details["amount"] != 80.0 {
+		t.Errorf("authorization hold %+v", first)
+	}
+	if second := items[1]; second.Title != "Withdrawal held for review" || second.Details["hold"] != "review" || second.Details["review_id"] != uint(3) {
+		t.Errorf("review hold %+v", second)
+	}
+}
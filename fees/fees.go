@@ -0,0 +1,222 @@
+package fees
+
+import (
+	"banking-app/enrichment"
+	"banking-app/flags"
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/money"
+	"banking-app/relationship"
+	"banking-app/tenancy"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ChargeableTypes are the customer posting types a fee schedule can charge
+var ChargeableTypes = []string{"deposit", "withdrawal", "transfer", "payment"}
+
+// Schedule errors - handlers map these to client responses
+var (
+	ErrTransactionType = errors.New("transaction_type must be deposit, withdrawal, transfer or payment")
+	ErrChannel         = errors.New("channel must be empty or one of " + strings.Join(enrichment.Channels, ", "))
+	ErrCurrency        = errors.New("currency must be one of " + strings.Join(tenancy.SupportedCurrencies, ", "))
+	ErrPricing         = errors.New("a schedule needs a flat_fee or percent; amounts must not be negative, percent at most 100 and max_fee at least min_fee")
+	ErrDates           = errors.New("effective_to must not be before effective_from")
+	ErrOverlap         = errors.New("another schedule for the same product, transaction type, channel and currency is in effect on some of these dates")
+)
+
+// Posting is what a fee is priced on: a customer posting and the account it is made on
+type Posting struct {
+	TransactionType string
+	Channel         string
+	AccountType     string
+	Amount          money.Money
+	EffectiveDate   time.Time
+}
+
+// Calculate prices the fee a schedule charges on amount: the flat fee plus the percentage of the amount, rounded to
+// the currency's minor unit, then raised to the minimum and capped at the maximum where set
+// An amount in another currency than the schedule's fails with money.ErrCurrencyMismatch
+func Calculate(s models.FeeSchedule, amount money.Money) (money.Money, error) {
+	fee, err := money.FromFloat(s.FlatFee, s.Currency).Add(amount.Percent(s.Percent))
+	if err != nil {
+		return money.Zero(s.Currency), err
+	}
+	if minimum := money.FromFloat(s.MinFee, s.Currency); minimum.IsPositive() {
+		if below, _ := fee.Less(minimum); below {
+			fee = minimum
+		}
+	}
+	if maximum := money.FromFloat(s.MaxFee, s.Currency); maximum.IsPositive() {
+		if above, _ := maximum.Less(fee); above {
+			fee = maximum
+		}
+	}
+	return fee, nil
+}
+
+// InEffect reports whether a schedule charges postings effective on date's UTC day
+func InEffect(s models.FeeSchedule, date time.Time) bool {
+	day := ledger.StartOfDay(date)
+	if day.Before(ledger.StartOfDay(s.EffectiveFrom)) {
+		return false
+	}
+	return s.EffectiveTo == nil || !day.After(ledger.StartOfDay(*s.EffectiveTo))
+}
+
+// Applies reports whether a schedule charges a posting
+func Applies(s models.FeeSchedule, p Posting) bool {
+	return s.TransactionType == p.TransactionType && s.Currency == p.Amount.Currency &&
+		(s.AccountType == "" || s.AccountType == p.AccountType) &&
+		(s.Channel == "" || s.Channel == p.Channel) &&
+		InEffect(s, p.EffectiveDate)
+}
+
+// specificity ranks a schedule naming a product above one naming only a channel, and both above a catch-all
+func specificity(s models.FeeSchedule) int {
+	rank := 0
+	if s.AccountType != "" {
+		rank += 2
+	}
+	if s.Channel != "" {
+		rank++
+	}
+	return rank
+}
+
+// Select picks the schedule that charges a posting: the most specific one that applies
+// Schedules of equal specificity cannot overlap, so at most one of them applies on a given day
+func Select(schedules []models.FeeSchedule, p Posting) (models.FeeSchedule, bool) {
+	var best models.FeeSchedule
+	found := false
+	for _, s := range schedules {
+		if Applies(s, p) && (!found || specificity(s) > specificity(best)) {
+			best, found = s, true
+		}
+	}
+	return best, found
+}
+
+// Overlaps reports whether two schedules price the same postings on at least one common day
+func Overlaps(a, b models.FeeSchedule) bool {
+	if a.TransactionType != b.TransactionType || a.Currency != b.Currency || a.AccountType != b.AccountType || a.Channel != b.Channel {
+		return false
+	}
+	return (a.EffectiveTo == nil || !ledger.StartOfDay(b.EffectiveFrom).After(ledger.StartOfDay(*a.EffectiveTo))) &&
+		(b.EffectiveTo == nil || !ledger.StartOfDay(a.EffectiveFrom).After(ledger.StartOfDay(*b.EffectiveTo)))
+}
+
+// Validate checks a schedule's matching fields, pricing and dates, upper-casing its currency
+// The account type is checked by the caller against the tenant's catalog
+func Validate(s *models.FeeSchedule) error {
+	s.Currency = strings.ToUpper(strings.TrimSpace(s.Currency))
+	switch {
+	case !contains(ChargeableTypes, s.TransactionType):
+		return ErrTransactionType
+	case s.Channel != "" && !contains(enrichment.Channels, s.Channel):
+		return ErrChannel
+	case !tenancy.SupportedCurrency(s.Currency):
+		return ErrCurrency
+	case s.FlatFee < 0 || s.Percent < 0 || s.Percent > 100 || s.MinFee < 0 || s.MaxFee < 0,
+		s.FlatFee == 0 && s.Percent == 0,
+		s.MaxFee > 0 && s.MaxFee < s.MinFee:
+		return ErrPricing
+	case s.EffectiveTo != nil && ledger.StartOfDay(*s.EffectiveTo).Before(ledger.StartOfDay(s.EffectiveFrom)):
+		return ErrDates
+	}
+	return nil
+}
+
+// Conflict returns a schedule other than s that overlaps it, if any
+func Conflict(db *gorm.DB, s models.FeeSchedule) (models.FeeSchedule, bool, error) {
+	var candidates []models.FeeSchedule
+	err := db.Where("id <> ? AND transaction_type = ? AND currency = ? AND account_type = ? AND channel = ?",
+		s.ID, s.TransactionType, s.Currency, s.AccountType, s.Channel).Find(&candidates).Error
+	if err != nil {
+		return models.FeeSchedule{}, false, err
+	}
+	for _, other := range candidates {
+		if Overlaps(s, other) {
+			return other, true, nil
+		}
+	}
+	return models.FeeSchedule{}, false, nil
+}
+
+// LastCharged returns the latest fee posted under a schedule, if it has charged any
+func LastCharged(db *gorm.DB, scheduleID uint) (models.Transaction, bool, error) {
+	var fee models.Transaction
+	err := db.Where("fee_schedule_id = ?", scheduleID).Order("effective_date DESC").Limit(1).Find(&fee).Error
+	return fee, fee.ID != 0, err
+}
+
+// Assess prices the fee on a posted transaction, returning it unposted, or nil when no schedule charges it
+// or the account holder's relationship tier waives it
+// The fee is linked to the posting and its schedule, and is effective on the same date
+func Assess(db *gorm.DB, t models.Transaction, account models.Account) (*models.Transaction, error) {
+	if !contains(ChargeableTypes, t.TransactionType) || account.AccountType == gl.AccountType {
+		return nil, nil
+	}
+	p := Posting{
+		TransactionType: t.TransactionType,
+		Channel:         t.Channel,
+		AccountType:     account.AccountType,
+		Amount:          money.FromFloat(t.Amount, account.Currency),
+		EffectiveDate:   t.EffectiveDate,
+	}
+	var schedules []models.FeeSchedule
+	if err := db.Where("transaction_type = ? AND currency = ?", p.TransactionType, p.Amount.Currency).Find(&schedules).Error; err != nil {
+		return nil, err
+	}
+	schedule, ok := Select(schedules, p)
+	if !ok {
+		return nil, nil
+	}
+	// The holder's relationship tier may waive fees on this product
+	if waived, err := relationship.WaivesFees(db, account, p.EffectiveDate); err != nil || waived {
+		return nil, err
+	}
+	amount, err := Calculate(schedule, p.Amount)
+	if err != nil || !amount.IsPositive() {
+		return nil, err
+	}
+	return &models.Transaction{
+		AccountID:       t.AccountID,
+		TransactionType: ledger.TypeFee,
+		Amount:          amount.Float(),
+		Description:     fmt.Sprintf("%s on %s", schedule.Name, t.TransactionID),
+		Reference:       t.TransactionID,
+		Channel:         t.Channel,
+		EffectiveDate:   t.EffectiveDate,
+		ValueDate:       t.ValueDate,
+		FeeOfID:         &t.ID,
+		FeeScheduleID:   &schedule.ID,
+	}, nil
+}
+
+// Charge posts the fee on a posted transaction inside its database transaction, returning the fee,
+// or nil, and the account after it. The fee needs funds like any debit, so a posting the balance
+// covers but not together with its fee fails as a whole
+func Charge(tx *gorm.DB, t models.Transaction, account models.Account, featureFlags *flags.Store) (*models.Transaction, models.Account, error) {
+	fee, err := Assess(tx, t, account)
+	if err != nil || fee == nil {
+		return nil, account, err
+	}
+	account, err = ledger.PostExternal(tx, fee, featureFlags)
+	return fee, account, err
+}
+
+// contains reports whether list holds s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package fees
+
+import (
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/money"
+	"errors"
+	"testing"
+	"time"
+)
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 12, 0, 0, 0, time.UTC)
+}
+
+func until(t time.Time) *time.Time {
+	return &t
+}
+
+func TestCalculate(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule models.FeeSchedule
+		amount   money.Money
+		want     money.Money
+	}{
+		{"flat", models.FeeSchedule{FlatFee: 2.5, Currency: "USD"}, money.New(10000, "USD"), money.New(250, "USD")},
+		{"percent", models.FeeSchedule{Percent: 1.5, Currency: "USD"}, money.New(20000, "USD"), money.New(300, "USD")},
+		{"flat plus percent", models.FeeSchedule{FlatFee: 1, Percent: 0.5, Currency: "USD"}, money.New(12000, "USD"), money.New(160, "USD")},
+		{"raised to the minimum", models.FeeSchedule{Percent: 1, MinFee: 1, Currency: "USD"}, money.New(5000, "USD"), money.New(100, "USD")},
+		{"capped at the maximum", models.FeeSchedule{Percent: 2, MaxFee: 25, Currency: "USD"}, money.New(500000, "USD"), money.New(2500, "USD")},
+		{"between the bounds", models.FeeSchedule{Percent: 1, MinFee: 1, MaxFee: 25, Currency: "USD"}, money.New(50000, "USD"), money.New(500, "USD")},
+		{"minimum and maximum equal", models.FeeSchedule{Percent: 3, MinFee: 4, MaxFee: 4, Currency: "USD"}, money.New(1000, "USD"), money.New(400, "USD")},
+		{"half a cent rounds up", models.FeeSchedule{Percent: 1, Currency: "USD"}, money.New(150, "USD"), money.New(2, "USD")},
+		{"under half a cent rounds down", models.FeeSchedule{Percent: 1, Currency: "USD"}, money.New(149, "USD"), money.New(1, "USD")},
+		{"half a cent on a fractional percent", models.FeeSchedule{Percent: 0.25, Currency: "USD"}, money.New(1000, "USD"), money.New(3, "USD")},
+		{"rounded before the minimum applies", models.FeeSchedule{Percent: 1, MinFee: 0.02, Currency: "USD"}, money.New(150, "USD"), money.New(2, "USD")},
+		{"rounded before the maximum applies", models.FeeSchedule{Percent: 1, MaxFee: 0.01, Currency: "USD"}, money.New(150, "USD"), money.New(1, "USD")},
+		{"whole yen", models.FeeSchedule{Percent: 1, Currency: "JPY"}, money.New(150, "JPY"), money.New(2, "JPY")},
+		{"zero amount charges the flat fee", models.FeeSchedule{FlatFee: 0.75, Percent: 1, Currency: "USD"}, money.Zero("USD"), money.New(75, "USD")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Calculate(tt.schedule, tt.amount)
+			if err != nil {
+				t.Fatalf("Calculate: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Calculate(%+v, %v) = %v, want %v", tt.schedule, tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateCurrencyMismatch(t *testing.T) {
+	fee, err := Calculate(models.FeeSchedule{FlatFee: 1, Currency: "USD"}, money.New(10000, "EUR"))
+	if !errors.Is(err, money.ErrCurrencyMismatch) {
+		t.Fatalf("Calculate error = %v, want ErrCurrencyMismatch", err)
+	}
+	if fee != money.Zero("USD") {
+		t.Errorf("fee on a mismatch = %v, want zero in the schedule's currency", fee)
+	}
+}
+
+func TestInEffect(t *testing.T) {
+	from, to := day(2026, time.March, 1), day(2026, time.March, 31)
+	bounded := models.FeeSchedule{EffectiveFrom: from, EffectiveTo: until(to)}
+	open := models.FeeSchedule{EffectiveFrom: from}
+	tests := []struct {
+		name     string
+		schedule models.FeeSchedule
+		date     time.Time
+		want     bool
+	}{
+		{"the day before", bounded, day(2026, time.February, 28), false},
+		{"the first day", bounded, from, true},
+		{"early on the first day", bounded, ledger.StartOfDay(from).Add(time.Second), true},
+		{"the last day is inclusive", bounded, to, true},
+		{"late on the last day", bounded, ledger.DayAfter(ledger.StartOfDay(to)).Add(-time.Minute), true},
+		{"the day after", bounded, day(2026, time.April, 1), false},
+		{"open-ended", open, day(2030, time.January, 1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := InEffect(tt.schedule, tt.date); got != tt.want {
+				t.Errorf("InEffect(%v) = %v, want %v", tt.date, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelect(t *testing.T) {
+	march := day(2026, time.March, 1)
+	schedules := []models.FeeSchedule{
+		{ID: 1, TransactionType: "withdrawal", Currency: "USD", EffectiveFrom: march},
+		{ID: 2, TransactionType: "withdrawal", Channel: "atm", Currency: "USD", EffectiveFrom: march},
+		{ID: 3, TransactionType: "withdrawal", AccountType: "savings", Currency: "USD", EffectiveFrom: march},
+		{ID: 4, TransactionType: "withdrawal", AccountType: "savings", Channel: "atm", Currency: "USD", EffectiveFrom: march, EffectiveTo: until(day(2026, time.March, 31))},
+		{ID: 5, TransactionType: "withdrawal", AccountType: "savings", Channel: "atm", Currency: "USD", EffectiveFrom: day(2026, time.April, 1)},
+		{ID: 6, TransactionType: "transfer", Currency: "USD", EffectiveFrom: day(2026, time.June, 1)},
+		{ID: 7, TransactionType: "withdrawal", Currency: "EUR", EffectiveFrom: march},
+	}
+	posting := func(transactionType, channel, accountType, currency string, date time.Time) Posting {
+		return Posting{TransactionType: transactionType, Channel: channel, AccountType: accountType, Amount: money.New(10000, currency), EffectiveDate: date}
+	}
+	tests := []struct {
+		name    string
+		posting Posting
+		want    uint // 0 when nothing charges it
+	}{
+		{"catch-all", posting("withdrawal", "branch", "checking", "USD", day(2026, time.March, 10)), 1},
+		{"channel beats catch-all", posting("withdrawal", "atm", "checking", "USD", day(2026, time.March, 10)), 2},
+		{"product beats channel", posting("withdrawal", "branch", "savings", "USD", day(2026, time.March, 10)), 3},
+		{"product and channel beat product", posting("withdrawal", "atm", "savings", "USD", day(2026, time.March, 10)), 4},
+		{"last day of the old schedule", posting("withdrawal", "atm", "savings", "USD", day(2026, time.March, 31)), 4},
+		{"first day of its successor", posting("withdrawal", "atm", "savings", "USD", day(2026, time.April, 1)), 5},
+		{"before any schedule", posting("withdrawal", "atm", "savings", "USD", day(2026, time.February, 1)), 0},
+		{"not yet in effect", posting("transfer", "online", "checking", "USD", day(2026, time.May, 31)), 0},
+		{"currency picks the schedule", posting("withdrawal", "atm", "savings", "EUR", day(2026, time.March, 10)), 7},
+		{"no schedule in the currency", posting("withdrawal", "atm", "savings", "GBP", day(2026, time.March, 10)), 0},
+		{"type not charged", posting("deposit", "branch", "checking", "USD", day(2026, time.March, 10)), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Select(schedules, tt.posting)
+			if tt.want == 0 {
+				if ok {
+					t.Errorf("Select chose schedule %d, want none", got.ID)
+				}
+				return
+			}
+			if !ok || got.ID != tt.want {
+				t.Errorf("Select chose %d (found %v), want %d", got.ID, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	schedule := func(from time.Time, to *time.Time) models.FeeSchedule {
+		return models.FeeSchedule{TransactionType: "transfer", Currency: "USD", Channel: "online", EffectiveFrom: from, EffectiveTo: to}
+	}
+	march := schedule(day(2026, time.March, 1), until(day(2026, time.March, 31)))
+	tests := []struct {
+		name string
+		a, b models.FeeSchedule
+		want bool
+	}{
+		{"same dates", march, march, true},
+		{"ends the day the other starts", march, schedule(day(2026, time.March, 31), nil), true},
+		{"starts the day after the other ends", march, schedule(day(2026, time.April, 1), nil), false},
+		{"ends the day before the other starts", schedule(day(2026, time.January, 1), until(day(2026, time.February, 28))), march, false},
+		{"contained", march, schedule(day(2026, time.March, 10), until(day(2026, time.March, 12))), true},
+		{"both open-ended", schedule(day(2026, time.January, 1), nil), schedule(day(2027, time.January, 1), nil), true},
+		{"open-ended after a closed one", schedule(day(2026, time.April, 1), nil), march, false},
+		{"different channel", march, models.FeeSchedule{TransactionType: "transfer", Currency: "USD", Channel: "branch", EffectiveFrom: march.EffectiveFrom}, false},
+		{"different product", march, models.FeeSchedule{TransactionType: "transfer", Currency: "USD", Channel: "online", AccountType: "savings", EffectiveFrom: march.EffectiveFrom}, false},
+		{"different currency", march, models.FeeSchedule{TransactionType: "transfer", Currency: "EUR", Channel: "online", EffectiveFrom: march.EffectiveFrom}, false},
+		{"different type", march, models.FeeSchedule{TransactionType: "payment", Currency: "USD", Channel: "online", EffectiveFrom: march.EffectiveFrom}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Overlaps(tt.a, tt.b); got != tt.want {
+				t.Errorf("Overlaps = %v, want %v", got, tt.want)
+			}
+			if got := Overlaps(tt.b, tt.a); got != tt.want {
+				t.Errorf("Overlaps reversed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	march := day(2026, time.March, 1)
+	valid := models.FeeSchedule{TransactionType: "transfer", Currency: " usd ", FlatFee: 1, EffectiveFrom: march}
+	tests := []struct {
+		name   string
+		change func(*models.FeeSchedule)
+		want   error
+	}{
+		{"valid", func(*models.FeeSchedule) {}, nil},
+		{"type not charged", func(s *models.FeeSchedule) { s.TransactionType = "fee" }, ErrTransactionType},
+		{"unknown channel", func(s *models.FeeSchedule) { s.Channel = "fax" }, ErrChannel},
+		{"unsupported currency", func(s *models.FeeSchedule) { s.Currency = "XYZ" }, ErrCurrency},
+		{"no price", func(s *models.FeeSchedule) { s.FlatFee = 0 }, ErrPricing},
+		{"negative flat fee", func(s *models.FeeSchedule) { s.FlatFee = -1 }, ErrPricing},
+		{"percent over 100", func(s *models.FeeSchedule) { s.Percent = 100.5 }, ErrPricing},
+		{"maximum under the minimum", func(s *models.FeeSchedule) { s.MinFee, s.MaxFee = 5, 2 }, ErrPricing},
+		{"ends before it starts", func(s *models.FeeSchedule) { s.EffectiveTo = until(day(2026, time.February, 28)) }, ErrDates},
+		{"one-day schedule", func(s *models.FeeSchedule) { s.EffectiveTo = until(march) }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid
+			tt.change(&s)
+			if err := Validate(&s); err != tt.want {
+				t.Errorf("Validate = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && s.Currency != "USD" {
+				t.Errorf("currency %q, want USD", s.Currency)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package finance
+
+import (
+	"banking-app/money"
+	"errors"
+	"math"
+	"time"
+)
+
+// DaysPerYear is the day count interest is charged and paid on (actual/365)
+const DaysPerYear = 365
+
+// MaxInstallments bounds a projected repayment schedule
+const MaxInstallments = 1200
+
+// ErrNeverRepaid is returned for a payment that does not cover a schedule's interest
+var ErrNeverRepaid = errors.New("payment does not cover the interest, so the balance is never repaid")
+
+// MonthlyPayment is the level payment that repays principal over months at an annual rate, unrounded
+// M = P * [r(1+r)^n] / [(1+r)^n - 1] with the monthly rate r = rate / 12
+func MonthlyPayment(principal, rate float64, months int) float64 {
+	monthlyRate := rate / 12
+	power := 1.0
+	for i := 0; i < months; i++ {
+		power *= 1 + monthlyRate
+	}
+	return principal * monthlyRate * power / (power - 1)
+}
+
+// Interest is simple interest on a balance at an annual rate for whole days, rounded to cents
+func Interest(balance, rate float64, days int) float64 {
+	if days <= 0 {
+		return 0
+	}
+	return money.Cents(balance * rate * float64(days) / DaysPerYear)
+}
+
+// DailyInterest is one day's interest earned on a deposit balance; nothing is earned on a balance or rate at or
+// below zero
+func DailyInterest(balance, rate float64) float64 {
+	if balance <= 0 || rate <= 0 {
+		return 0
+	}
+	return Interest(balance, rate, 1)
+}
+
+// Days counts the whole days from one time to another
+func Days(from, to time.Time) int {
+	return int(math.Floor(to.Sub(from).Hours() / 24))
+}
+
+// Installment is one projected loan repayment
+type Installment struct {
+	Number    int       `json:"number"`
+	Date      time.Time `json:"date"`
+	Payment   float64   `json:"payment"`
+	Interest  float64   `json:"interest"`
+	Principal float64   `json:"principal"`
+	Balance   float64   `json:"balance"` // Remaining after the payment
+}
+
+// Schedule projects a loan's repayments of payment a month until balance is repaid. Installment i falls on
+// anchor.AddDate(0, first+i, 0) and pays the interest since the one before, or since since for the first, with
+// the rest going to principal; the last pays off what remains. Loan payments charge interest the same way
+func Schedule(balance, rate, payment float64, since, anchor time.Time, first int) ([]Installment, error) {
+	var schedule []Installment
+	previous := since
+	for i := 0; balance > 0; i++ {
+		if i == MaxInstallments {
+			return schedule, ErrNeverRepaid
+		}
+		date := anchor.AddDate(0, first+i, 0)
+		interest := Interest(balance, rate, Days(previous, date))
+		amount := money.Cents(math.Min(payment, balance+interest))
+		if amount <= interest {
+			return schedule, ErrNeverRepaid
+		}
+		balance = money.Cents(balance - (amount - interest))
+		schedule = append(schedule, Installment{Number: i + 1, Date: date, Payment: amount, Interest: interest,
+			Principal: money.Cents(amount - interest), Balance: balance})
+		previous = date
+	}
+	return schedule, nil
+}
+
+// TotalInterest adds up a schedule's interest
+func TotalInterest(schedule []Installment) float64 {
+	total := 0.0
+	for _, s := range schedule {
+		total = money.Cents(total + s.Interest)
+	}
+	return total
+}
+
+// Growth is a deposit's projected balance at the end of a period
+type Growth struct {
+	Year          int       `json:"year"`
+	Date          time.Time `json:"date"`
+	Contributions float64   `json:"contributions"` // Deposited to date, the initial amount included
+	Interest      float64   `json:"interest"`      // Interest credited to date
+	Balance       float64   `json:"balance"`
+}
+
+// Grow projects a deposit over years from start, returning its position at the end of each year. initial is
+// deposited on start, and monthly on start and each monthly anniversary after it. Interest accrues each day on the
+// day's balance with DailyInterest and is credited on the last day of each calendar month, as interest accrual does
+func Grow(initial, monthly, rate float64, start time.Time, years int) []Growth {
+	series := make([]Growth, 0, years)
+	balance, contributions, credited, accrued := money.Cents(initial), money.Cents(initial), 0.0, 0.0
+	end := start.AddDate(years, 0, 0)
+	month, year := 0, 1
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		if day.Equal(start.AddDate(0, month, 0)) {
+			balance, contributions = money.Cents(balance+monthly), money.Cents(contributions+monthly)
+			month++
+		}
+		accrued = money.Cents(accrued + DailyInterest(balance, rate))
+		next := day.AddDate(0, 0, 1)
+		if next.Day() == 1 && accrued > 0 {
+			balance, credited, accrued = money.Cents(balance+accrued), money.Cents(credited+accrued), 0
+		}
+		if next.Equal(start.AddDate(year, 0, 0)) {
+			// Interest accrued since the month began is not credited yet, as on a statement
+			series = append(series, Growth{Year: year, Date: next, Contributions: contributions, Interest: credited, Balance: balance})
+			year++
+		}
+	}
+	return series
+}
@@ -0,0 +1,147 @@
+package flags
+
+import (
+	"banking-app/events"
+	"banking-app/models"
+	"hash/fnv"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Flag keys checked by the application
+const (
+	Overdraft        = "overdraft"          // Allow debits into the account's overdraft limit
+	FeeCharging      = "fee_charging"       // Charge fees on postings
+	FraudBlocking    = "fraud_blocking"     // Block postings flagged by fraud rules
+	NewAccountReview = "new_account_review" // Hold the first large debit from a new account for review
+	AccountWebhooks  = "account_webhooks"   // Let customers subscribe webhooks to their own accounts
+)
+
+// Defaults are created disabled on startup so they can be toggled without knowing their keys
+// Enabling one turns it on for every customer unless its rollout percentage is lowered
+var Defaults = []models.FeatureFlag{
+	{Key: Overdraft, Description: "Allow withdrawals and payments to draw on the account overdraft limit", RolloutPercent: 100},
+	{Key: FeeCharging, Description: "Charge fees on postings", RolloutPercent: 100},
+	{Key: FraudBlocking, Description: "Block postings flagged by fraud rules", RolloutPercent: 100},
+	{Key: NewAccountReview, Description: "Hold the first large withdrawal or transfer from a new account for review", RolloutPercent: 100},
+	{Key: AccountWebhooks, Description: "Let customers subscribe webhooks to events on their own accounts", RolloutPercent: 100},
+}
+
+// EnsureDefaults creates any missing default flags; existing flags are left untouched
+func EnsureDefaults(db *gorm.DB) error {
+	for _, flag := range Defaults {
+		flag := flag
+		flag.UpdatedBy = "system"
+		if err := db.Where(models.FeatureFlag{Key: flag.Key}).FirstOrCreate(&flag).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Store is an in-process cache of flag values
+// It reloads periodically and whenever a flag change event arrives
+type Store struct {
+	db *gorm.DB
+
+	mu        sync.RWMutex
+	flags     map[string]models.FeatureFlag
+	refreshed time.Time
+}
+
+// NewStore creates a store and loads the current flags
+func NewStore(db *gorm.DB) *Store {
+	s := &Store{db: db, flags: map[string]models.FeatureFlag{}}
+	if err := s.Refresh(); err != nil {
+		log.Printf("flags: initial load failed: %v", err)
+	}
+	return s
+}
+
+// Refresh reloads every flag from the database
+func (s *Store) Refresh() error {
+	var rows []models.FeatureFlag
+	if err := s.db.Find(&rows).Error; err != nil {
+		return err
+	}
+
+	loaded := make(map[string]models.FeatureFlag, len(rows))
+	for _, flag := range rows {
+		loaded[flag.Key] = flag
+	}
+
+	s.mu.Lock()
+	s.flags = loaded
+	s.refreshed = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// Start refreshes the store every interval and on flag change events until stop is closed
+func (s *Store) Start(interval time.Duration, changes <-chan models.OutboxEvent, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case event, ok := <-changes:
+				if !ok {
+					changes = nil
+					continue
+				}
+				if event.EventType != events.FeatureFlagChanged {
+					continue
+				}
+			case <-stop:
+				return
+			}
+			if err := s.Refresh(); err != nil {
+				log.Printf("flags: refresh failed: %v", err)
+			}
+		}
+	}()
+}
+
+// All returns a snapshot of every cached flag and when it was loaded
+func (s *Store) All() ([]models.FeatureFlag, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := make([]models.FeatureFlag, 0, len(s.flags))
+	for _, flag := range s.flags {
+		all = append(all, flag)
+	}
+	return all, s.refreshed
+}
+
+// Enabled reports whether a flag is on for a customer
+// Unknown flags are off; partial rollouts need a customer id and are stable per customer
+func (s *Store) Enabled(key string, customerID uint) bool {
+	s.mu.RLock()
+	flag, ok := s.flags[key]
+	s.mu.RUnlock()
+	if !ok || !flag.Enabled {
+		return false
+	}
+	return InRollout(key, customerID, flag.RolloutPercent)
+}
+
+// InRollout reports whether a customer falls inside a flag's rollout percentage
+// Hashing the key with the id gives each flag an independent customer slice
+func InRollout(key string, customerID uint, percent int) bool {
+	if percent >= 100 {
+		return true
+	}
+	if percent <= 0 || customerID == 0 {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(key) + ":" + strconv.FormatUint(uint64(customerID), 10)))
+	return int(h.Sum32()%100) < percent
+}
@@ -0,0 +1,273 @@
+package fx
+
+import (
+	"banking-app/businessdays"
+	"banking-app/enrichment"
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/money"
+	"banking-app/tenancy"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BaseCurrency is the currency management reporting values foreign balances in
+const BaseCurrency = "USD"
+
+// MaxCatchUp bounds how many days a currency is revalued in one run after missed nights
+const MaxCatchUp = 31
+
+// Revaluation statuses
+const (
+	StatusPosted = "posted"
+	StatusFailed = "failed"
+)
+
+// Rate errors - handlers map these to client responses
+var (
+	ErrCurrency    = errors.New("currency must be a supported currency other than " + BaseCurrency)
+	ErrRate        = errors.New("rate must be positive")
+	ErrMissingRate = errors.New("no closing rate")
+)
+
+// ValidateRate checks a closing rate, upper-casing its currency and truncating its date to the UTC day
+func ValidateRate(rate *models.FXRate) error {
+	rate.Currency = strings.ToUpper(strings.TrimSpace(rate.Currency))
+	rate.Date = ledger.StartOfDay(rate.Date)
+	switch {
+	case rate.Currency == BaseCurrency || !tenancy.SupportedCurrency(rate.Currency):
+		return ErrCurrency
+	case !(rate.Rate > 0):
+		return ErrRate
+	}
+	return nil
+}
+
+// Revalued reports whether a posted revaluation used a currency's rate for a day, which fixes the rate
+func Revalued(db *gorm.DB, currency string, day time.Time) (bool, error) {
+	var count int64
+	err := db.Model(&models.FXRevaluation{}).
+		Where("currency = ? AND date = ? AND status = ?", currency, ledger.StartOfDay(day), StatusPosted).Count(&count).Error
+	return count > 0, err
+}
+
+// Result counts what a revaluation run did
+type Result struct {
+	Posted int // Currency-days revalued
+	Failed int // Currency-days that could not be revalued, such as for a missing rate
+}
+
+// Run is the fx-revaluation job. For every tenant and foreign currency its customer accounts hold, it revalues
+// each day from the one after the last posted revaluation through yesterday, oldest first. A day that fails
+// stops its currency, so no later day is revalued past a gap; the error names every currency that failed
+func Run(db *gorm.DB, now time.Time) (Result, error) {
+	var result Result
+	var tenants []models.Tenant
+	if err := db.Find(&tenants).Error; err != nil {
+		return result, err
+	}
+	yesterday := ledger.StartOfDay(now).AddDate(0, 0, -1)
+
+	var failures []string
+	for _, tenant := range tenants {
+		scoped := db.WithContext(tenancy.NewContext(context.Background(), tenant.ID))
+		var currencies []string
+		err := scoped.Model(&models.Account{}).Distinct("currency").
+			Where("account_type <> ? AND currency <> ?", gl.AccountType, BaseCurrency).Order("currency").Pluck("currency", &currencies).Error
+		if err != nil {
+			return result, err
+		}
+		for _, currency := range currencies {
+			day, err := firstDue(scoped, currency, yesterday)
+			if err != nil {
+				return result, err
+			}
+			for ; !day.After(yesterday); day = day.AddDate(0, 0, 1) {
+				if _, err := Revalue(scoped, currency, day); err != nil {
+					result.Failed++
+					failures = append(failures, fmt.Sprintf("tenant %d %s on %s: %v", tenant.ID, currency, businessdays.Format(day), err))
+					break
+				}
+				result.Posted++
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return result, errors.New("fx revaluation failed for " + strings.Join(failures, "; "))
+	}
+	return result, nil
+}
+
+// firstDue is the first day of a currency still to revalue: the day after its last posted revaluation,
+// at most MaxCatchUp days back, or yesterday for a currency never revalued
+func firstDue(db *gorm.DB, currency string, yesterday time.Time) (time.Time, error) {
+	var last models.FXRevaluation
+	err := db.Where("currency = ? AND status = ?", currency, StatusPosted).Order("date DESC").Limit(1).Find(&last).Error
+	if err != nil || last.ID == 0 {
+		return yesterday, err
+	}
+	day := ledger.DayAfter(last.Date)
+	if earliest := yesterday.AddDate(0, 0, 1-MaxCatchUp); day.Before(earliest) {
+		day = earliest
+	}
+	return day, nil
+}
+
+// Revalue values a currency's customer balances at the end of day at that day's closing rate, snapshots each
+// account and posts the unrealized gain or loss since each account's previous snapshot, all in one database
+// transaction. Without a closing rate for the day it records a failed revaluation and returns ErrMissingRate;
+// an older rate is never used instead. A day already posted is returned as it is
+func Revalue(db *gorm.DB, currency string, day time.Time) (models.FXRevaluation, error) {
+	day = ledger.StartOfDay(day)
+	var revaluation models.FXRevaluation
+	if err := db.Where("currency = ? AND date = ?", currency, day).Limit(1).Find(&revaluation).Error; err != nil {
+		return revaluation, err
+	}
+	if revaluation.Status == StatusPosted {
+		return revaluation, nil
+	}
+	revaluation.Currency, revaluation.Date = currency, day
+	id, createdAt := revaluation.ID, revaluation.CreatedAt
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var rate models.FXRate
+		if err := tx.Where("currency = ? AND date = ?", currency, day).Limit(1).Find(&rate).Error; err != nil {
+			return err
+		}
+		if rate.ID == 0 {
+			return ErrMissingRate
+		}
+		revaluation.Rate = rate.Rate
+
+		snapshots, previous, err := value(tx, currency, day, rate.Rate)
+		if err != nil {
+			return err
+		}
+		// Balances are credit-positive: a deposit the bank owes costs more when its currency strengthens,
+		// and a loan it is owed is worth more
+		var change float64
+		for _, snapshot := range snapshots {
+			revaluation.Balance += snapshot.Balance
+			revaluation.BaseCurrencyValue += snapshot.BaseCurrencyValue
+			if prior, ok := previous[snapshot.AccountID]; ok {
+				change += prior.Balance * (rate.Rate - prior.Rate)
+			}
+		}
+		if len(snapshots) > 0 {
+			if err := tx.Create(&snapshots).Error; err != nil {
+				return err
+			}
+		}
+		revaluation.Accounts = len(snapshots)
+		revaluation.Balance = money.Cents(revaluation.Balance)
+		revaluation.BaseCurrencyValue = money.Cents(revaluation.BaseCurrencyValue)
+		revaluation.Impact = money.Cents(-change)
+
+		if revaluation.Impact != 0 {
+			posting, err := post(tx, revaluation)
+			if err != nil {
+				return err
+			}
+			revaluation.TransactionID = &posting.ID
+		}
+		revaluation.Status, revaluation.Error = StatusPosted, ""
+		return tx.Save(&revaluation).Error
+	})
+	if err != nil {
+		failed := models.FXRevaluation{ID: id, CreatedAt: createdAt, Currency: currency, Date: day, Status: StatusFailed, Error: truncate(err.Error(), 500)}
+		if saveErr := db.Save(&failed).Error; saveErr != nil {
+			log.Printf("fx revaluation: recording failure of %s on %s: %v", currency, businessdays.Format(day), saveErr)
+		}
+		return failed, err
+	}
+	return revaluation, nil
+}
+
+// value snapshots every customer account in a currency that has postings by the end of day, and returns the
+// previous snapshot of each account that has one
+func value(tx *gorm.DB, currency string, day time.Time, rate float64) ([]models.BalanceSnapshot, map[uint]models.BalanceSnapshot, error) {
+	var balances []struct {
+		AccountID uint
+		Balance   float64
+	}
+	err := tx.Model(&models.Transaction{}).
+		Select("transactions.account_id, COALESCE(SUM("+ledger.SignedAmountOf("transactions")+"), 0) AS balance").
+		Joins("JOIN accounts ON accounts.id = transactions.account_id").
+		Where("accounts.currency = ? AND accounts.account_type <> ? AND transactions.effective_date < ?", currency, gl.AccountType, ledger.DayAfter(day)).
+		Group("transactions.account_id").Order("transactions.account_id").Scan(&balances).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var prior []models.BalanceSnapshot
+	latest := tx.Model(&models.BalanceSnapshot{}).Select("MAX(id)").Where("currency = ? AND date < ?", currency, day).Group("account_id")
+	if err := tx.Where("id IN (?)", latest).Find(&prior).Error; err != nil {
+		return nil, nil, err
+	}
+	previous := make(map[uint]models.BalanceSnapshot, len(prior))
+	for _, snapshot := range prior {
+		previous[snapshot.AccountID] = snapshot
+	}
+
+	snapshots := make([]models.BalanceSnapshot, 0, len(balances))
+	for _, b := range balances {
+		balance := money.Cents(b.Balance)
+		snapshots = append(snapshots, models.BalanceSnapshot{
+			AccountID:         b.AccountID,
+			Date:              day,
+			Currency:          currency,
+			Balance:           balance,
+			Rate:              rate,
+			BaseCurrencyValue: money.Cents(balance * rate),
+		})
+	}
+	return snapshots, previous, nil
+}
+
+// post books an unrealized gain or loss: FX_GAIN_LOSS is credited with a gain, or debited with a loss, against
+// FX_REVALUATION in the base currency, effective at the end of the revalued day
+func post(tx *gorm.DB, revaluation models.FXRevaluation) (models.Transaction, error) {
+	tenantID, _ := tenancy.FromContext(tx.Statement.Context)
+	gainLoss, err := gl.Account(tx, tenantID, gl.FXGainLoss, BaseCurrency)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+	adjustment, err := gl.Account(tx, tenantID, gl.FXRevaluation, BaseCurrency)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+
+	posting := models.Transaction{
+		AccountID:       gainLoss.ID,
+		TransactionType: "deposit",
+		Amount:          math.Abs(revaluation.Impact),
+		Description:     fmt.Sprintf("Unrealized FX revaluation of %s on %s at %g", revaluation.Currency, businessdays.Format(revaluation.Date), revaluation.Rate),
+		Reference:       "FXREVAL-" + revaluation.Currency + "-" + revaluation.Date.Format("20060102"),
+		Channel:         enrichment.DefaultChannel,
+		EffectiveDate:   ledger.DayAfter(revaluation.Date).Add(-time.Second),
+	}
+	if revaluation.Impact < 0 {
+		posting.TransactionType = "withdrawal"
+	}
+	if _, err := ledger.Post(tx, &posting, nil); err != nil {
+		return posting, err
+	}
+	_, err = ledger.Offset(tx, posting, adjustment.ID, posting.Amount)
+	return posting, err
+}
+
+// truncate shortens s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,469 @@
+package garnishments
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/communications"
+	"banking-app/events"
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/liens"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"banking-app/money"
+	"banking-app/notifications"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Order statuses
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	StatusSatisfied = "satisfied"
+)
+
+// Order scopes
+const (
+	ScopeAccount  = "account"  // One account
+	ScopeCustomer = "customer" // Every account of the customer in the order's currency
+)
+
+// SystemActor is recorded as satisfying an order that was collected in full
+const SystemActor = "system"
+
+// Garnishment errors - handlers map these to client responses
+var (
+	ErrInvalid           = errors.New("a garnishment order needs a court, a case reference, a payee, a total amount above zero, a protected amount of zero or more and a priority of 1 or more")
+	ErrNotActive         = errors.New("garnishment order is not active")
+	ErrNotSuspended      = errors.New("garnishment order is not suspended")
+	ErrSatisfied         = errors.New("garnishment order is already satisfied")
+	ErrDocumentNotFound  = errors.New("document not found for the order's customer")
+	ErrRemittanceAccount = errors.New("remittance account must be an active account in the order's currency that the debtor does not own")
+)
+
+// Validate checks an order's fields before it is registered
+func Validate(o models.GarnishmentOrder) error {
+	if strings.TrimSpace(o.Court) == "" || strings.TrimSpace(o.CaseReference) == "" || strings.TrimSpace(o.PayeeName) == "" {
+		return ErrInvalid
+	}
+	if o.TotalAmount <= 0 || o.ProtectedAmount < 0 || o.Priority < 1 {
+		return ErrInvalid
+	}
+	if (o.Scope == ScopeAccount) != (o.AccountID != nil) || (o.Scope != ScopeAccount && o.Scope != ScopeCustomer) {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// Register records a new active order with its supporting documents inside an open transaction, queues the
+// customer's notice and sweeps what the garnished accounts already hold above the protected amount
+// Without a remittance account, swept funds go to the tenant's GARNISHMENTS internal account in the order's currency
+func Register(tx *gorm.DB, o *models.GarnishmentOrder, documentIDs []uint) ([]models.GarnishmentPayment, error) {
+	if err := Validate(*o); err != nil {
+		return nil, err
+	}
+	var customer models.Customer
+	if err := tx.Select("id, tenant_id, email").First(&customer, o.CustomerID).Error; err != nil {
+		return nil, err
+	}
+	if err := remittanceAccount(tx, o, customer); err != nil {
+		return nil, err
+	}
+
+	o.ID = 0
+	o.TenantID = customer.TenantID
+	o.Status = StatusActive
+	o.TotalAmount, o.ProtectedAmount, o.CollectedAmount = money.Cents(o.TotalAmount), money.Cents(o.ProtectedAmount), 0
+	if err := tx.Create(o).Error; err != nil {
+		return nil, err
+	}
+	if err := Attach(tx, *o, documentIDs); err != nil {
+		return nil, err
+	}
+	if err := notify(tx, o, customer); err != nil {
+		return nil, err
+	}
+	if err := record(tx, *o, events.GarnishmentRegistered, o); err != nil {
+		return nil, err
+	}
+	return sweepOrderAccounts(tx, o)
+}
+
+// remittanceAccount checks the order's remittance account, or fills in the GARNISHMENTS internal account
+func remittanceAccount(tx *gorm.DB, o *models.GarnishmentOrder, customer models.Customer) error {
+	if o.RemittanceAccountID == 0 {
+		holding, err := gl.Account(tx, customer.TenantID, gl.Garnishments, o.Currency)
+		o.RemittanceAccountID = holding.ID
+		return err
+	}
+	var remit models.Account
+	err := tx.Select("id, customer_id, currency, status").First(&remit, o.RemittanceAccountID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrRemittanceAccount
+	}
+	if err != nil {
+		return err
+	}
+	if remit.Status != "active" || remit.Currency != o.Currency || remit.CustomerID == o.CustomerID {
+		return ErrRemittanceAccount
+	}
+	return nil
+}
+
+// notify queues the notice the customer must receive when an order is registered
+// It is a legal notice, so it goes to the email on file whether or not it is verified and whatever the customer's
+// preferences; without one the order records no notification and the notice must be sent by post
+func notify(tx *gorm.DB, o *models.GarnishmentOrder, customer models.Customer) error {
+	if customer.Email == "" {
+		log.Printf("garnishments: customer %d has no email for the notice of order %d", customer.ID, o.ID)
+		return nil
+	}
+	covers := "all of your " + o.Currency + " accounts"
+	if o.AccountID != nil {
+		var account models.Account
+		if err := tx.Select("id, account_number").First(&account, *o.AccountID).Error; err != nil {
+			return err
+		}
+		covers = "account " + account.AccountNumber
+	}
+	notification := models.Notification{
+		CustomerID:   customer.ID,
+		Channel:      "email",
+		ResourceType: communications.ResourceGarnishment,
+		ResourceID:   o.ID,
+		Recipient:    customer.Email,
+		Subject:      "Notice of garnishment order " + o.CaseReference,
+		Body: fmt.Sprintf("On %s we received a garnishment order from %s (case %s) to collect %.2f %s for %s. "+
+			"From now on, funds in %s above the protected amount of %.2f %s will be transferred toward the order as "+
+			"they are deposited, until it is paid. The protected amount always stays available to you. If you "+
+			"believe the order is wrong or that more of your funds are exempt, contact the court named above.",
+			businessdays.Format(o.CreatedAt), o.Court, o.CaseReference, o.TotalAmount, o.Currency, o.PayeeName,
+			covers, o.ProtectedAmount, o.Currency),
+	}
+	if err := notifications.Enqueue(tx, &notification); err != nil {
+		return err
+	}
+	o.NotificationID = &notification.ID
+	return tx.Model(o).Update("notification_id", notification.ID).Error
+}
+
+// Attach links documents of the order's customer to an order; documents already attached are skipped
+func Attach(tx *gorm.DB, o models.GarnishmentOrder, documentIDs []uint) error {
+	for _, id := range documentIDs {
+		var count int64
+		if err := tx.Model(&models.Document{}).Where("id = ? AND customer_id = ?", id, o.CustomerID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrDocumentNotFound
+		}
+		link := models.GarnishmentDocument{TenantID: o.TenantID, OrderID: o.ID, DocumentID: id}
+		if err := tx.Where(models.GarnishmentDocument{OrderID: o.ID, DocumentID: id}).FirstOrCreate(&link).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Suspend pauses collection on an active order; deposits post in full until it is resumed
+func Suspend(tx *gorm.DB, o *models.GarnishmentOrder, by, reason string) error {
+	if !lifecycle.Allows(lifecycle.GarnishmentOrder, o.Status, StatusSuspended) {
+		return ErrNotActive
+	}
+	now := clock.Now()
+	if err := move(tx, o, StatusActive, map[string]interface{}{
+		"status": StatusSuspended, "suspended_at": now, "suspended_by": by, "suspend_reason": reason,
+	}); err != nil {
+		return err
+	}
+	o.Status, o.SuspendedAt, o.SuspendedBy, o.SuspendReason = StatusSuspended, &now, by, reason
+	return record(tx, *o, events.GarnishmentSuspended, o)
+}
+
+// Resume restarts collection on a suspended order and sweeps what the garnished accounts hold above the protected
+// amount now. Deposits made while it was suspended are not revisited, only the balance they left
+func Resume(tx *gorm.DB, o *models.GarnishmentOrder, by string) ([]models.GarnishmentPayment, error) {
+	if !lifecycle.Allows(lifecycle.GarnishmentOrder, o.Status, StatusActive) {
+		return nil, ErrNotSuspended
+	}
+	if err := move(tx, o, StatusSuspended, map[string]interface{}{"status": StatusActive}); err != nil {
+		return nil, err
+	}
+	o.Status = StatusActive
+	if err := record(tx, *o, events.GarnishmentResumed, map[string]interface{}{"order": o, "resumed_by": by}); err != nil {
+		return nil, err
+	}
+	return sweepOrderAccounts(tx, o)
+}
+
+// Satisfy closes an active or suspended order before it is collected in full, such as when the debt is paid to
+// the court directly; nothing more is swept toward it
+func Satisfy(tx *gorm.DB, o *models.GarnishmentOrder, by, note string) error {
+	if !lifecycle.Allows(lifecycle.GarnishmentOrder, o.Status, StatusSatisfied) {
+		return ErrSatisfied
+	}
+	now := clock.Now()
+	if err := move(tx, o, o.Status, map[string]interface{}{
+		"status": StatusSatisfied, "satisfied_at": now, "satisfied_by": by, "satisfy_note": note,
+	}); err != nil {
+		return err
+	}
+	o.Status, o.SatisfiedAt, o.SatisfiedBy, o.SatisfyNote = StatusSatisfied, &now, by, note
+	return record(tx, *o, events.GarnishmentSatisfied, o)
+}
+
+// move changes an order's row if it is still in the status it was loaded in
+func move(tx *gorm.DB, o *models.GarnishmentOrder, from string, updates map[string]interface{}) error {
+	result := tx.Model(&models.GarnishmentOrder{}).Where("id = ? AND status = ?", o.ID, from).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotActive
+	}
+	return nil
+}
+
+// record writes an order's outbox event on its account, or on its customer for a customer-wide order
+func record(tx *gorm.DB, o models.GarnishmentOrder, eventType string, payload interface{}) error {
+	if o.AccountID != nil {
+		return events.Record(tx, events.AggregateAccount, *o.AccountID, eventType, payload)
+	}
+	return events.Record(tx, events.AggregateCustomer, o.CustomerID, eventType, payload)
+}
+
+// sweepOrderAccounts sweeps every account an order covers, in account order
+func sweepOrderAccounts(tx *gorm.DB, o *models.GarnishmentOrder) ([]models.GarnishmentPayment, error) {
+	accountIDs := []uint{}
+	if o.AccountID != nil {
+		accountIDs = append(accountIDs, *o.AccountID)
+	} else if err := covered(tx, *o).Order("id").Pluck("id", &accountIDs).Error; err != nil {
+		return nil, err
+	}
+	payments := []models.GarnishmentPayment{}
+	for _, id := range accountIDs {
+		swept, err := Sweep(tx, id, nil)
+		if err != nil {
+			return payments, err
+		}
+		payments = append(payments, swept...)
+	}
+	if err := tx.First(o, o.ID).Error; err != nil {
+		return payments, err
+	}
+	return payments, nil
+}
+
+// covered selects the active customer accounts a customer-wide order reaches
+func covered(tx *gorm.DB, o models.GarnishmentOrder) *gorm.DB {
+	return tx.Model(&models.Account{}).Where("customer_id = ? AND currency = ? AND status = ? AND account_type <> ?",
+		o.CustomerID, o.Currency, "active", gl.AccountType)
+}
+
+// Open counts the active and suspended orders covering an account
+func Open(db *gorm.DB, account models.Account) (int64, error) {
+	var count int64
+	err := db.Model(&models.GarnishmentOrder{}).Where("customer_id = ? AND currency = ? AND (account_id IS NULL OR account_id = ?) AND status IN ?",
+		account.CustomerID, account.Currency, account.ID, []string{StatusActive, StatusSuspended}).Count(&count).Error
+	return count, err
+}
+
+// RegisterCallbacks sweeps deposits toward garnishment orders as they are created, whichever code path posts them
+func RegisterCallbacks(db *gorm.DB) error {
+	return db.Callback().Create().After("gorm:create").Register("garnishments:sweep", sweepOnCreate)
+}
+
+// sweepOnCreate sweeps the account a new deposit credited, inside the creating database transaction
+// General-ledger offset legs and reversals are not deposits of the customer's funds, so they are left alone
+func sweepOnCreate(tx *gorm.DB) {
+	if tx.Error != nil || tx.Statement.Schema == nil || tx.Statement.Schema.Table != "transactions" {
+		return
+	}
+	value := tx.Statement.ReflectValue
+	if value.Kind() != reflect.Struct {
+		return
+	}
+	t, ok := value.Addr().Interface().(*models.Transaction)
+	if !ok || t.TransactionType != "deposit" || t.OffsetOfID != nil || t.ReversalOfID != nil {
+		return
+	}
+	if _, err := Sweep(tx.Session(&gorm.Session{NewDB: true}), t.AccountID, t); err != nil {
+		tx.AddError(err)
+	}
+}
+
+// Sweep transfers what an account holds above the protected amount toward the active orders covering it, most
+// senior first, inside an open transaction. trigger is the deposit that prompted it, or nil
+// Each order takes only its own account's free funds: the balance less the debits held for review or
+// authorization and whatever liens ranked ahead of the order hold. The overdraft limit never counts, so an
+// overdrawn account is swept only once a deposit takes it back above the protected amount
+func Sweep(tx *gorm.DB, accountID uint, trigger *models.Transaction) ([]models.GarnishmentPayment, error) {
+	var account models.Account
+	if err := tx.Select("id, customer_id, currency, account_type, status").First(&account, accountID).Error; err != nil {
+		return nil, err
+	}
+	if account.AccountType == gl.AccountType || account.Status != "active" || account.CustomerID == 0 {
+		return nil, nil
+	}
+	var orders []models.GarnishmentOrder
+	err := tx.Where("customer_id = ? AND status = ? AND currency = ? AND (account_id IS NULL OR account_id = ?)",
+		account.CustomerID, StatusActive, account.Currency, account.ID).Order("priority, created_at, id").Find(&orders).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var payments []models.GarnishmentPayment
+	for i := range orders {
+		payment, err := collect(tx, &orders[i], account.ID, trigger)
+		if err != nil {
+			return payments, err
+		}
+		if payment.ID != 0 {
+			payments = append(payments, payment)
+		}
+	}
+	return payments, nil
+}
+
+// Collectible is what an order may take from an account now
+// An account-wide order leaves the account its protected amount. A customer-wide order leaves the protected amount
+// across all the accounts it covers, and takes no more from one account than that account has free
+func Collectible(tx *gorm.DB, o models.GarnishmentOrder, accountID uint) (float64, error) {
+	var account models.Account
+	if err := tx.First(&account, accountID).Error; err != nil {
+		return 0, err
+	}
+	free, err := Free(tx, o, account)
+	if err != nil {
+		return 0, err
+	}
+	amount := free - o.ProtectedAmount
+	if o.AccountID == nil {
+		var accounts []models.Account
+		if err := covered(tx, o).Find(&accounts).Error; err != nil {
+			return 0, err
+		}
+		total := 0.0
+		for _, other := range accounts {
+			f, err := Free(tx, o, other)
+			if err != nil {
+				return 0, err
+			}
+			total += f
+		}
+		amount = math.Min(free, total-o.ProtectedAmount)
+	}
+	return math.Max(0, money.Cents(math.Min(amount, o.TotalAmount-o.CollectedAmount))), nil
+}
+
+// Free is the part of an account's balance an order could reach before the protected amount: the balance less
+// held debits and the liens ranked ahead of the order, never below zero
+func Free(tx *gorm.DB, o models.GarnishmentOrder, account models.Account) (float64, error) {
+	held, err := ledger.Held(tx, account.ID)
+	if err != nil {
+		return 0, err
+	}
+	ahead, err := liens.HoldAhead(tx, account.ID, o.Priority, o.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+	return ahead.Available(account.Balance - held), nil
+}
+
+// collect sweeps what an order may take from an account to its remittance account and records the payment
+// An order whose remittance account can no longer receive funds is skipped and logged, so the deposit still posts
+func collect(tx *gorm.DB, o *models.GarnishmentOrder, accountID uint, trigger *models.Transaction) (models.GarnishmentPayment, error) {
+	var payment models.GarnishmentPayment
+	amount, err := Collectible(tx, *o, accountID)
+	if err != nil || amount <= 0 {
+		return payment, err
+	}
+	var remit models.Account
+	if err := tx.First(&remit, o.RemittanceAccountID).Error; err != nil {
+		return payment, err
+	}
+	if remit.Status != "active" || remit.Currency != o.Currency {
+		log.Printf("garnishments: remittance account %d of order %d cannot receive funds; nothing swept", remit.ID, o.ID)
+		return payment, nil
+	}
+
+	reference := o.CaseReference
+	if o.PayeeReference != "" {
+		reference = o.PayeeReference
+	}
+	debit := models.Transaction{
+		AccountID:             accountID,
+		TransactionType:       "transfer",
+		Amount:                amount,
+		Description:           "Garnishment to " + o.PayeeName + " (" + o.CaseReference + ")",
+		Reference:             reference,
+		Channel:               "branch",
+		CounterpartyAccountID: &remit.ID,
+	}
+	if _, err := ledger.Post(tx, &debit, nil); err != nil {
+		return payment, err
+	}
+	credit := models.Transaction{
+		AccountID:             remit.ID,
+		TransactionType:       "deposit",
+		Amount:                amount,
+		Description:           "Garnishment " + o.CaseReference + " for " + o.PayeeName,
+		Reference:             debit.TransactionID,
+		Channel:               "branch",
+		CounterpartyAccountID: &accountID,
+	}
+	if _, err := ledger.Post(tx, &credit, nil); err != nil {
+		return payment, err
+	}
+
+	payment = models.GarnishmentPayment{
+		TenantID:            o.TenantID,
+		OrderID:             o.ID,
+		AccountID:           accountID,
+		Amount:              amount,
+		RemittanceAccountID: remit.ID,
+		DebitTransactionID:  debit.ID,
+		CreditTransactionID: credit.ID,
+	}
+	if trigger != nil {
+		payment.TriggerTransactionID = &trigger.ID
+	}
+	if err := tx.Create(&payment).Error; err != nil {
+		return payment, err
+	}
+
+	updates := map[string]interface{}{"collected_amount": money.Cents(o.CollectedAmount + amount)}
+	satisfied := updates["collected_amount"].(float64) >= o.TotalAmount
+	if satisfied {
+		if err := lifecycle.Check(lifecycle.GarnishmentOrder, o.Status, StatusSatisfied); err != nil {
+			return payment, err
+		}
+		now := clock.Now()
+		updates["status"], updates["satisfied_at"], updates["satisfied_by"] = StatusSatisfied, now, SystemActor
+		o.SatisfiedAt, o.SatisfiedBy = &now, SystemActor
+	}
+	if err := move(tx, o, StatusActive, updates); err != nil {
+		return payment, err
+	}
+	o.CollectedAmount = updates["collected_amount"].(float64)
+	if satisfied {
+		o.Status = StatusSatisfied
+	}
+	if err := events.Record(tx, events.AggregateAccount, accountID, events.GarnishmentPaid, map[string]interface{}{
+		"order":   o,
+		"payment": payment,
+	}); err != nil {
+		return payment, err
+	}
+	if satisfied {
+		return payment, record(tx, *o, events.GarnishmentSatisfied, o)
+	}
+	return payment, nil
+}
@@ -0,0 +1,157 @@
+package gl
+
+import (
+	"banking-app/clock"
+	"banking-app/invariants"
+	"banking-app/models"
+	"banking-app/statushistory"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// AccountType marks internal general-ledger accounts among ordinary accounts
+// Internal accounts belong to no customer (customer_id 0) and post through the ledger like any other
+const AccountType = "internal"
+
+// Internal account codes
+const (
+	Cash            = "CASH"             // Money entering and leaving the bank
+	FeeIncome       = "FEE_INCOME"       // Fees charged to customers
+	InterestIncome  = "INTEREST_INCOME"  // Interest collected on loans
+	InterestExpense = "INTEREST_EXPENSE" // Interest paid on deposits
+	AccruedInterest = "ACCRUED_INTEREST" // Interest accrued on deposits but not yet credited to them
+	Suspense        = "SUSPENSE"         // Funds awaiting a correct destination
+	Escheatment     = "ESCHEATMENT"      // Abandoned funds held for turnover to the state
+	FXRevaluation   = "FX_REVALUATION"   // Base-currency revaluation of foreign-currency customer balances
+	FXGainLoss      = "FX_GAIN_LOSS"     // Unrealized gains, or losses, from that revaluation
+	Garnishments    = "GARNISHMENTS"     // Garnished funds awaiting remittance to the court
+)
+
+// Seeded lists the accounts created for every tenant and currency at migration
+var Seeded = []string{Cash, FeeIncome, InterestIncome, InterestExpense, Suspense}
+
+// Account kinds, used to present balances on reports
+const (
+	KindAsset     = "asset"
+	KindLiability = "liability"
+	KindIncome    = "income"
+	KindExpense   = "expense"
+)
+
+// Kinds classifies each internal account code
+var Kinds = map[string]string{
+	Cash:            KindAsset,
+	FeeIncome:       KindIncome,
+	InterestIncome:  KindIncome,
+	InterestExpense: KindExpense,
+	AccruedInterest: KindLiability,
+	Suspense:        KindLiability,
+	Escheatment:     KindLiability,
+	FXRevaluation:   KindLiability,
+	FXGainLoss:      KindIncome,
+	Garnishments:    KindLiability,
+}
+
+// Number is the account number of an internal account in a tenant; each currency has its own
+func Number(tenantID uint, code, currency string) string {
+	return fmt.Sprintf("GL-%d-%s-%s", tenantID, code, currency)
+}
+
+// Code extracts the account code from an internal account number
+func Code(accountNumber string) string {
+	parts := strings.Split(accountNumber, "-")
+	if len(parts) != 4 || parts[0] != "GL" {
+		return ""
+	}
+	return parts[2]
+}
+
+// Account returns a tenant's internal account for a code and currency, creating it on first use
+func Account(tx *gorm.DB, tenantID uint, code, currency string) (models.Account, error) {
+	account := models.Account{
+		TenantID:      tenantID,
+		AccountNumber: Number(tenantID, code, currency),
+		AccountType:   AccountType,
+		Currency:      currency,
+		Status:        "active",
+	}
+	result := tx.Where("account_number = ?", account.AccountNumber).FirstOrCreate(&account)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return account, result.Error
+	}
+	return account, statushistory.Created(tx, tenantID, statushistory.SubjectAccount, account.ID, account.Status, statushistory.SystemActor)
+}
+
+// Seed creates the standard internal accounts for every tenant and currency with accounts
+// When a tenant's cash account is first created, existing balances were never offset, so an opening
+// entry brought forward on cash makes the books balance from then on
+func Seed(db *gorm.DB) error {
+	var books []struct {
+		TenantID uint
+		Currency string
+		Net      float64
+	}
+	err := db.Unscoped().Model(&models.Account{}).Select("tenant_id, currency, COALESCE(SUM(balance), 0) AS net").
+		Group("tenant_id, currency").Scan(&books).Error
+	if err != nil {
+		return err
+	}
+
+	for _, book := range books {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var existing int64
+			if err := tx.Model(&models.Account{}).Where("account_number = ?", Number(book.TenantID, Cash, book.Currency)).Count(&existing).Error; err != nil {
+				return err
+			}
+			for _, code := range Seeded {
+				if _, err := Account(tx, book.TenantID, code, book.Currency); err != nil {
+					return err
+				}
+			}
+			if existing > 0 || book.Net == 0 {
+				return nil
+			}
+			return openingBalance(tx, book.TenantID, book.Currency, book.Net)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openingBalance posts the cash entry that offsets balances recorded before the general ledger existed
+func openingBalance(tx *gorm.DB, tenantID uint, currency string, net float64) error {
+	cash, err := Account(tx, tenantID, Cash, currency)
+	if err != nil {
+		return err
+	}
+
+	entry := models.Transaction{
+		TenantID:        tenantID,
+		TransactionID:   fmt.Sprintf("GLOPEN-%d-%s", tenantID, currency),
+		AccountID:       cash.ID,
+		TransactionType: "withdrawal",
+		Amount:          net,
+		EffectiveDate:   clock.Now(),
+		Description:     "Opening balance brought forward",
+		Channel:         "api",
+		BalanceBefore:   cash.Balance,
+		BalanceAfter:    cash.Balance - net,
+	}
+	if net < 0 {
+		entry.TransactionType = "deposit"
+		entry.Amount = -net
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return err
+	}
+	before := cash.Balance
+	cash.Balance = entry.BalanceAfter
+	if err := invariants.SetBalance(tx, cash, before, entry); err != nil {
+		return err
+	}
+	return tx.Model(&cash).Update("version", cash.Version+1).Error
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"banking-app/accessreport"
+	"banking-app/auth"
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== DATA ACCESS REPORT HANDLERS ====================
+
+// GetAccessReport summarizes who read or changed a customer's records, or one account's, from the audit log
+// Each actor's requests are counted by endpoint category with the first and last time; an admin signed in as
+// the customer is shown as that admin. ?from= and ?to= (YYYY-MM-DD, to inclusive) default to the year to today.
+// ?detail=true lists the requests themselves instead, newest first and paginated.
+// Staff need the access reports permission; customers can see their own
+func GetAccessReport(db *gorm.DB, subjectType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+			return
+		}
+		q := accessreport.Query{CustomerID: uint(id)}
+		if subjectType == "account" {
+			var account models.Account
+			if err := db.Select("id, customer_id").First(&account, id).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+				return
+			}
+			q.CustomerID, q.AccountID = account.CustomerID, account.ID
+		} else if _, ok := noteSubjectID(c, db, "customer"); !ok {
+			return
+		}
+		if !seesAccessReport(c, db, q.CustomerID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			return
+		}
+
+		to := clock.Now()
+		if raw := c.Query("to"); raw != "" {
+			if to, err = businessdays.ParseDate(raw); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+				return
+			}
+		}
+		from := businessdays.StartOfDay(to).AddDate(-1, 0, 1)
+		if raw := c.Query("from"); raw != "" {
+			if from, err = businessdays.ParseDate(raw); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+				return
+			}
+		}
+		if to.Before(from) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+			return
+		}
+		q.From, q.To = from, businessdays.DayAfter(to)
+
+		response := gin.H{
+			"customer_id": q.CustomerID,
+			"from":        businessdays.Format(from),
+			"to":          businessdays.Format(to),
+		}
+		if q.AccountID != 0 {
+			response["account_id"] = q.AccountID
+		}
+		if c.Query("detail") == "true" {
+			page, limit, offset := parsePagination(c, 50)
+			events, total, err := accessreport.Events(db, q, offset, limit)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build access report"})
+				return
+			}
+			response["events"], response["total"], response["page"], response["limit"] = events, total, page, limit
+			c.JSON(http.StatusOK, response)
+			return
+		}
+
+		accesses, total, err := accessreport.Summarize(db, q)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build access report"})
+			return
+		}
+		response["accesses"], response["total"] = accesses, total
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// seesAccessReport reports whether the caller may see a customer's access report: staff with the permission,
+// the customer signed in, or an admin signed in as them
+func seesAccessReport(c *gin.Context, db *gorm.DB, customerID uint) bool {
+	if impersonated := c.GetUint("impersonated_customer_id"); impersonated != 0 {
+		return impersonated == customerID
+	}
+	role := c.GetString("user_role")
+	if role != "customer" {
+		return auth.Can(role, auth.PermAccessReports)
+	}
+	var user models.User
+	if err := db.Select("customer_id").First(&user, c.GetUint("user_id")).Error; err != nil {
+		return false
+	}
+	return user.CustomerID != nil && *user.CustomerID == customerID
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"banking-app/auth"
+	"banking-app/events"
+	"banking-app/flags"
+	"banking-app/middleware"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== ACCOUNT WEBHOOK HANDLERS ====================
+
+// accountWebhookRequest carries the fields a customer sets on a subscription to one of their accounts
+type accountWebhookRequest struct {
+	URL        string `json:"url"`
+	Secret     string `json:"secret"`      // Signs every delivery; write-only
+	EventTypes string `json:"event_types"` // Comma-separated, from events.AccountEventTypes
+	AlertEmail string `json:"alert_email"` // Told when the circuit breaker suspends deliveries
+}
+
+// minWebhookSecret is the shortest signing secret an account subscription accepts
+const minWebhookSecret = 16
+
+// webhookAccount finds the :id account whose webhooks are managed, recording the access against its holder
+// Customers only find their own accounts; staff need the accounts:webhooks permission
+func webhookAccount(c *gin.Context, db *gorm.DB) (models.Account, bool) {
+	var account models.Account
+	customerID, isCustomer := applicant(c, db)
+	if !isCustomer && !auth.Can(c.GetString("user_role"), auth.PermAccountHooks) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return account, false
+	}
+	err := db.First(&account, c.Param("id")).Error
+	if err == nil && isCustomer && account.CustomerID != customerID {
+		err = gorm.ErrRecordNotFound
+	}
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return account, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return account, false
+	}
+	middleware.AuditCustomer(c, account.CustomerID)
+	return account, true
+}
+
+// accountWebhook finds the :webhookId subscription on the :id account
+func accountWebhook(c *gin.Context, db *gorm.DB) (models.WebhookSubscription, bool) {
+	var subscription models.WebhookSubscription
+	account, ok := webhookAccount(c, db)
+	if !ok {
+		return subscription, false
+	}
+	err := db.Where("account_id = ?", account.ID).First(&subscription, c.Param("webhookId")).Error
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+		return subscription, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return subscription, false
+	}
+	return subscription, true
+}
+
+// parseAccountEventTypes validates a comma-separated list of account event types and returns it in the order of
+// events.AccountEventTypes without duplicates
+func parseAccountEventTypes(raw string) (string, bool) {
+	chosen := map[string]bool{}
+	for _, t := range strings.Split(raw, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if !events.AccountEvent(t) {
+			return "", false
+		}
+		chosen[t] = true
+	}
+	var types []string
+	for _, t := range events.AccountEventTypes {
+		if chosen[t] {
+			types = append(types, t)
+		}
+	}
+	return strings.Join(types, ","), len(types) > 0
+}
+
+// GetAccountWebhooks lists the webhook subscriptions on an account with their circuit breaker state and backlog
+func GetAccountWebhooks(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		account, ok := webhookAccount(c, db)
+		if !ok {
+			return
+		}
+
+		var subscriptions []models.WebhookSubscription
+		err := db.Where("account_id = ?", account.ID).Order("id").Find(&subscriptions).Error
+		if err == nil {
+			err = events.FillBacklogs(db, subscriptions)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhook subscriptions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"account_id": account.ID, "subscriptions": subscriptions})
+	}
+}
+
+// CreateAccountWebhook subscribes an endpoint to one account's events
+// Only customers the account_webhooks flag is on for can subscribe, and each account has at most cfg.AccountLimit
+// subscriptions. Deliveries are signed and retried like bank-wide ones
+func CreateAccountWebhook(db *gorm.DB, featureFlags *flags.Store, cfg events.WebhookConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		account, ok := webhookAccount(c, db)
+		if !ok {
+			return
+		}
+		if !featureFlags.Enabled(flags.AccountWebhooks, account.CustomerID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account webhooks are not available for this customer yet", "code": "FEATURE_NOT_AVAILABLE"})
+			return
+		}
+
+		var req accountWebhookRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		u, err := url.Parse(req.URL)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && !(cfg.AccountHTTP && u.Scheme == "http")) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A valid https URL is required"})
+			return
+		}
+		if len(req.Secret) < minWebhookSecret {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "secret must be at least 16 characters"})
+			return
+		}
+		eventTypes, ok := parseAccountEventTypes(req.EventTypes)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "event_types must list one or more of " + strings.Join(events.AccountEventTypes, ", ")})
+			return
+		}
+
+		accountID := account.ID
+		subscription := models.WebhookSubscription{
+			URL:        req.URL,
+			Secret:     req.Secret,
+			EventTypes: eventTypes,
+			Active:     true,
+			CreatedBy:  actor(c),
+			AlertEmail: req.AlertEmail,
+			AccountID:  &accountID,
+			CustomerID: account.CustomerID,
+		}
+		full := false
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var count int64
+			if err := tx.Model(&models.WebhookSubscription{}).Where("account_id = ?", account.ID).Count(&count).Error; err != nil {
+				return err
+			}
+			if full = count >= int64(cfg.AccountLimit); full {
+				return nil
+			}
+			return tx.Create(&subscription).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook subscription"})
+			return
+		}
+		if full {
+			c.JSON(http.StatusConflict, gin.H{"error": "The account already has the most webhook subscriptions allowed",
+				"code": "WEBHOOK_LIMIT_REACHED", "limit": cfg.AccountLimit})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message":      "Webhook subscription created successfully",
+			"subscription": subscription,
+		})
+	}
+}
+
+// DeleteAccountWebhook removes a subscription from an account; its queued deliveries are not sent
+func DeleteAccountWebhook(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		subscription, ok := accountWebhook(c, db)
+		if !ok {
+			return
+		}
+		if err := db.Delete(&subscription).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook subscription"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Webhook subscription deleted successfully"})
+	}
+}
+
+// GetAccountWebhookDeliveries lists one of an account's subscriptions' deliveries, newest first
+func GetAccountWebhookDeliveries(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		subscription, ok := accountWebhook(c, db)
+		if !ok {
+			return
+		}
+		respondWebhookDeliveries(c, db, subscription)
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"banking-app/feed"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"banking-app/usage"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== ACTIVITY FEED HANDLERS ====================
+
+// GetAccountActivity returns an account's activity feed, newest first, across transactions,
+// status changes, alerts and documents
+// ?types= filters by comma-separated item type; ?cursor= continues from next_cursor of the previous page
+func GetAccountActivity(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var account models.Account
+		if err := db.First(&account, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+
+		var types []string
+		if raw := c.Query("types"); raw != "" {
+			for _, t := range strings.Split(raw, ",") {
+				t = strings.TrimSpace(t)
+				if !contains(feed.Types, t) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown item type " + t, "types": feed.Types})
+					return
+				}
+				types = append(types, t)
+			}
+		}
+
+		var after *feed.Cursor
+		if raw := c.Query("cursor"); raw != "" {
+			cursor, err := feed.DecodeCursor(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+				return
+			}
+			after = &cursor
+		}
+
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if limit < 1 {
+			limit = 20
+		}
+		if limit > maxPageSize {
+			limit = maxPageSize
+		}
+
+		items, next, err := feed.Page(db, account.ID, types, after, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve activity"})
+			return
+		}
+
+		response := gin.H{
+			"account_id":  account.ID,
+			"items":       items,
+			"next_cursor": nil,
+		}
+		if next != nil {
+			response["next_cursor"] = next.Encode()
+		}
+		display := displayOptions(c)
+		display.Currency = account.Currency
+		usage.Rows(c, len(items))
+		respondDisplayWith(c, http.StatusOK, response, display)
+	}
+}
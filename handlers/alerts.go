@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"banking-app/alerts"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== ALERT HANDLERS ====================
+
+// alertRuleRequest carries the client-settable fields of an alert rule
+type alertRuleRequest struct {
+	RuleType   string  `json:"rule_type"`
+	Threshold  float64 `json:"threshold"`
+	DaysBefore int     `json:"days_before"`
+	Enabled    *bool   `json:"enabled"`
+	DailyCap   *int    `json:"daily_cap"`
+	Channel    string  `json:"channel"`
+	Target     string  `json:"target"`
+}
+
+// validateAlertRule checks a rule's definition before it is stored
+func validateAlertRule(rule models.AlertRule) string {
+	if !contains(alerts.RuleTypes, rule.RuleType) {
+		return "Invalid rule type"
+	}
+	if !contains(alerts.Channels, rule.Channel) {
+		return "Invalid channel"
+	}
+	if rule.Channel == "webhook" && rule.Target == "" {
+		return "Webhook alerts require a target URL"
+	}
+	if rule.RuleType == alerts.RuleLoanPaymentDue && rule.DaysBefore < 0 {
+		return "days_before must not be negative"
+	}
+	if rule.RuleType != alerts.RuleLoanPaymentDue && rule.Threshold < 0 {
+		return "threshold must not be negative"
+	}
+	if rule.DailyCap < 0 {
+		return "daily_cap must not be negative"
+	}
+	return ""
+}
+
+// findAccountAlert loads an alert rule scoped to the account in the route
+func findAccountAlert(c *gin.Context, db *gorm.DB) (models.AlertRule, bool) {
+	var rule models.AlertRule
+
+	accountID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+		return rule, false
+	}
+	alertID, err := strconv.ParseUint(c.Param("alertId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert ID"})
+		return rule, false
+	}
+
+	// The account lookup is tenant scoped; alert rules are reached only through it
+	var account models.Account
+	err = db.Select("id").First(&account, uint(accountID)).Error
+	if err == nil {
+		err = db.Where("account_id = ?", account.ID).First(&rule, uint(alertID)).Error
+	}
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert rule not found"})
+		return rule, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return rule, false
+	}
+	return rule, true
+}
+
+// GetAccountAlerts lists the alert rules configured on an account
+func GetAccountAlerts(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+			return
+		}
+
+		var account models.Account
+		if err := db.Select("id").First(&account, uint(id)).Error; err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+
+		var rules []models.AlertRule
+		if err := db.Where("account_id = ?", account.ID).Order("id").Find(&rules).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve alert rules"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"account_id": uint(id),
+			"alerts":     rules,
+		})
+	}
+}
+
+// CreateAccountAlert adds an alert rule to an account
+// Rules are evaluated after each posted transaction and by the daily scheduler
+func CreateAccountAlert(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+			return
+		}
+
+		var account models.Account
+		if err := db.First(&account, uint(id)).Error; err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+
+		var req alertRuleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		rule := models.AlertRule{
+			AccountID:  account.ID,
+			RuleType:   req.RuleType,
+			Threshold:  req.Threshold,
+			DaysBefore: req.DaysBefore,
+			Enabled:    true,
+			DailyCap:   5,
+			Channel:    req.Channel,
+			Target:     req.Target,
+		}
+		if rule.Channel == "" {
+			rule.Channel = "email"
+		}
+		if req.Enabled != nil {
+			rule.Enabled = *req.Enabled
+		}
+		if req.DailyCap != nil {
+			rule.DailyCap = *req.DailyCap
+		}
+
+		if msg := validateAlertRule(rule); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		if rule.Channel == "email" && rule.Target == "" && !requireVerifiedEmail(c, db, account.CustomerID) {
+			return
+		}
+
+		if err := db.Create(&rule).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create alert rule"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Alert rule created successfully",
+			"alert":   rule,
+		})
+	}
+}
+
+// GetAccountAlert retrieves a single alert rule
+func GetAccountAlert(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		rule, ok := findAccountAlert(c, db)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, rule)
+	}
+}
+
+// UpdateAccountAlert modifies an existing alert rule
+func UpdateAccountAlert(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		rule, ok := findAccountAlert(c, db)
+		if !ok {
+			return
+		}
+
+		var req alertRuleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		// Apply only the fields that were supplied
+		if req.RuleType != "" {
+			rule.RuleType = req.RuleType
+		}
+		if req.Channel != "" {
+			rule.Channel = req.Channel
+		}
+		if req.Target != "" {
+			rule.Target = req.Target
+		}
+		if req.Threshold != 0 {
+			rule.Threshold = req.Threshold
+		}
+		if req.DaysBefore != 0 {
+			rule.DaysBefore = req.DaysBefore
+		}
+		if req.Enabled != nil {
+			rule.Enabled = *req.Enabled
+		}
+		if req.DailyCap != nil {
+			rule.DailyCap = *req.DailyCap
+		}
+
+		if msg := validateAlertRule(rule); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		if req.Channel == "email" && rule.Target == "" {
+			var account models.Account
+			db.Select("id, customer_id").First(&account, rule.AccountID)
+			if !requireVerifiedEmail(c, db, account.CustomerID) {
+				return
+			}
+		}
+
+		if err := db.Save(&rule).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update alert rule"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Alert rule updated successfully",
+			"alert":   rule,
+		})
+	}
+}
+
+// DeleteAccountAlert removes an alert rule; firing history is retained
+func DeleteAccountAlert(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		rule, ok := findAccountAlert(c, db)
+		if !ok {
+			return
+		}
+
+		if err := db.Delete(&rule).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete alert rule"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Alert rule deleted successfully"})
+	}
+}
+
+// GetAlertFirings returns the firing history of an alert rule, newest first
+func GetAlertFirings(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		rule, ok := findAccountAlert(c, db)
+		if !ok {
+			return
+		}
+
+		page, limit, offset := parsePagination(c, 10)
+
+		var firings []models.AlertFiring
+		var filter listFilter
+		filter.where("alert_rule_id = ?", rule.ID)
+
+		total, err := filter.count(db, &models.AlertFiring{})
+		if err == nil {
+			err = filter.apply(db).Order("created_at DESC, id DESC").
+				Offset(offset).Limit(limit).Find(&firings).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve alert history"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"alert_id": rule.ID,
+			"firings":  firings,
+			"total":    total,
+			"page":     page,
+			"limit":    limit,
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// apiError is a failure from handler logic shared between API versions
+// Each version renders it in its own error format
+type apiError struct {
+	Status  int    // HTTP status
+	Code    string // Machine-readable code, e.g. INSUFFICIENT_FUNDS
+	Message string // Human-readable message
+	Details gin.H  // Extra context, e.g. the original of a suspected duplicate
+	v1Code  bool   // v1 responses carried the code before v2 existed
+}
+
+// respondV1 writes the v1 error body: {"error": message} with any details alongside
+func (e *apiError) respondV1(c *gin.Context) {
+	body := gin.H{"error": e.Message}
+	if e.v1Code {
+		body["code"] = e.Code
+	}
+	for key, value := range e.Details {
+		body[key] = value
+	}
+	c.JSON(e.Status, body)
+}
+
+// respondV2 writes the v2 error envelope: {"error": {"code", "message", "details"}}
+func (e *apiError) respondV2(c *gin.Context) {
+	envelope := gin.H{"code": e.Code, "message": e.Message}
+	if len(e.Details) > 0 {
+		envelope["details"] = e.Details
+	}
+	c.JSON(e.Status, gin.H{"error": envelope})
+}
@@ -0,0 +1,458 @@
+package handlers
+
+import (
+	"banking-app/applications"
+	"banking-app/cache"
+	"banking-app/clock"
+	"banking-app/eligibility"
+	"banking-app/enrichment"
+	"banking-app/flags"
+	"banking-app/ledger"
+	"banking-app/middleware"
+	"banking-app/models"
+	"banking-app/restrictions"
+	"banking-app/statushistory"
+	"banking-app/tenancy"
+	"banking-app/transfers"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== ACCOUNT APPLICATION HANDLERS ====================
+
+// applicationRequest starts an application; customers signed in apply for themselves
+type applicationRequest struct {
+	CustomerID uint `json:"customer_id"`
+}
+
+// applicationStepsRequest fills in one or more steps of a draft
+type applicationStepsRequest struct {
+	Product *struct {
+		AccountType string `json:"account_type"`
+		Currency    string `json:"currency"` // Defaults to the bank's currency
+	} `json:"product"`
+	Funding *struct {
+		Method        string  `json:"method"` // none or transfer
+		Amount        float64 `json:"amount"`
+		FromAccountID *uint   `json:"from_account_id"` // Applicant's account a transfer comes from
+	} `json:"funding"`
+}
+
+// applicationDecisionRequest is a staff decision on a referred application
+type applicationDecisionRequest struct {
+	Decision string `json:"decision" binding:"required"` // approve or reject
+	Reason   string `json:"reason" binding:"required"`
+}
+
+// applicant returns the customer a customer-role caller acts as, or an admin signed in as one; isCustomer is false
+// for staff, who may act on any customer's applications
+func applicant(c *gin.Context, db *gorm.DB) (customerID uint, isCustomer bool) {
+	if impersonated := c.GetUint("impersonated_customer_id"); impersonated != 0 {
+		return impersonated, true
+	}
+	if c.GetString("user_role") != "customer" {
+		return 0, false
+	}
+	var user models.User
+	if err := db.Select("customer_id").First(&user, c.GetUint("user_id")).Error; err != nil || user.CustomerID == nil {
+		return 0, true
+	}
+	return *user.CustomerID, true
+}
+
+// loadApplication finds the :id application; customers only find their own
+func loadApplication(c *gin.Context, db *gorm.DB) (models.AccountApplication, bool) {
+	var app models.AccountApplication
+	err := db.First(&app, c.Param("id")).Error
+	if customerID, isCustomer := applicant(c, db); err == nil && isCustomer && app.CustomerID != customerID {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Application not found"})
+		return app, false
+	}
+	middleware.AuditCustomer(c, app.CustomerID)
+	return app, true
+}
+
+// nextStep is the first step of a draft left to fill in, or submit once every step is
+func nextStep(app models.AccountApplication) string {
+	if app.Status != applications.StatusDraft {
+		return ""
+	}
+	for _, step := range applications.Steps {
+		if !applications.Completed(app, step) {
+			return step
+		}
+	}
+	return "submit"
+}
+
+// respondApplication returns an application with the step it waits for and the criteria it failed
+func respondApplication(c *gin.Context, status int, message string, app models.AccountApplication) {
+	response := gin.H{"application": app, "failed_criteria": applications.Failures(app)}
+	if message != "" {
+		response["message"] = message
+	}
+	if step := nextStep(app); step != "" {
+		response["next_step"] = step
+	}
+	c.JSON(status, response)
+}
+
+// respondApplicationError maps application errors to 400 or 409, and anything else to 500
+func respondApplicationError(c *gin.Context, err error) {
+	var incomplete *applications.IncompleteError
+	switch {
+	case errors.As(err, &incomplete):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "APPLICATION_INCOMPLETE", "missing_steps": incomplete.Missing})
+	case err == applications.ErrNotDraft || err == applications.ErrNotSubmitted || err == applications.ErrNotApproved:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update application"})
+	}
+}
+
+// respondStepError refuses a step that failed validation
+func respondStepError(c *gin.Context, step, message string) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": message, "code": "INVALID_APPLICATION_STEP", "step": step})
+}
+
+// CreateAccountApplication starts a draft application to open an account
+// Customers apply for themselves; staff name the customer. The draft expires unless submitted within 30 days
+func CreateAccountApplication(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req applicationRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+				return
+			}
+		}
+		customerID, isCustomer := applicant(c, db)
+		switch {
+		case isCustomer && customerID == 0:
+			c.JSON(http.StatusForbidden, gin.H{"error": "User is not linked to a customer"})
+			return
+		case isCustomer && req.CustomerID != 0 && req.CustomerID != customerID:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Customers can only apply for themselves"})
+			return
+		case !isCustomer:
+			if req.CustomerID == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "customer_id is required"})
+				return
+			}
+			customerID = req.CustomerID
+		}
+
+		var customer models.Customer
+		if err := db.First(&customer, customerID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+			return
+		}
+		middleware.AuditCustomer(c, customer.ID)
+
+		var app models.AccountApplication
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			app, err = applications.Create(tx, customer, actor(c), clock.Now())
+			return err
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create application"})
+			return
+		}
+		respondApplication(c, http.StatusCreated, "Application started", app)
+	}
+}
+
+// GetAccountApplications lists applications, newest first; customers see their own
+// ?status= filters, e.g. submitted for the ones waiting for staff, and staff may narrow to a ?customer_id=
+func GetAccountApplications(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 50)
+
+		var filter listFilter
+		if customerID, isCustomer := applicant(c, db); isCustomer {
+			filter.where("customer_id = ?", customerID)
+		} else if customerID := c.Query("customer_id"); customerID != "" {
+			filter.where("customer_id = ?", customerID)
+		}
+		if status := c.Query("status"); status != "" {
+			filter.where("status = ?", status)
+		}
+
+		apps := []models.AccountApplication{}
+		total, err := filter.count(db, &models.AccountApplication{})
+		if err == nil {
+			err = filter.apply(db).Order("id DESC").Offset(offset).Limit(limit).Find(&apps).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve applications"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"applications": apps, "total": total, "page": page, "limit": limit})
+	}
+}
+
+// GetAccountApplication returns an application with every transition it went through, oldest first
+func GetAccountApplication(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		app, ok := loadApplication(c, db)
+		if !ok {
+			return
+		}
+		history, err := statushistory.For(db, statushistory.SubjectApplication, app.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve application"})
+			return
+		}
+		response := gin.H{"application": app, "failed_criteria": applications.Failures(app), "status_history": history}
+		if step := nextStep(app); step != "" {
+			response["next_step"] = step
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// UpdateAccountApplication fills in steps of a draft: product picks the account type and currency, funding how
+// the account is funded when it opens. Each step is validated as it is saved and can be changed until submission
+func UpdateAccountApplication(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req applicationStepsRequest
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Product == nil && req.Funding == nil) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Send at least one of the product and funding steps"})
+			return
+		}
+		app, ok := loadApplication(c, db)
+		if !ok {
+			return
+		}
+		if app.Status != applications.StatusDraft {
+			respondApplicationError(c, applications.ErrNotDraft)
+			return
+		}
+
+		if p := req.Product; p != nil {
+			app.AccountType = strings.TrimSpace(p.AccountType)
+			if app.AccountType == "" {
+				respondStepError(c, applications.StepProduct, "account_type is required")
+				return
+			}
+			if !checkAccountType(c, db, app.AccountType) {
+				return
+			}
+			app.Currency = strings.ToUpper(strings.TrimSpace(p.Currency))
+			if app.Currency == "" {
+				app.Currency = tenancy.CurrentSettings(c).DefaultCurrency
+			} else if !tenancy.SupportedCurrency(app.Currency) {
+				respondStepError(c, applications.StepProduct, "Unsupported currency")
+				return
+			}
+			applications.Complete(&app, applications.StepProduct)
+		}
+		if f := req.Funding; f != nil {
+			app.FundingMethod, app.FundingAmount, app.FundingAccountID = f.Method, f.Amount, f.FromAccountID
+			applications.Complete(&app, applications.StepFunding)
+		}
+		// A product in another currency can leave a funding account behind, so funding is checked whenever either changes
+		if applications.Completed(app, applications.StepFunding) {
+			if message := checkFunding(c, db, app); message != "" {
+				respondStepError(c, applications.StepFunding, message)
+				return
+			}
+		}
+
+		if err := db.Transaction(func(tx *gorm.DB) error { return applications.Save(tx, &app) }); err != nil {
+			respondApplicationError(c, err)
+			return
+		}
+		respondApplication(c, http.StatusOK, "", app)
+	}
+}
+
+// checkFunding validates a draft's funding step, returning why it is refused or "" when it is valid
+// A transfer must come from another active account of the applicant, in the currency the account opens in
+func checkFunding(c *gin.Context, db *gorm.DB, app models.AccountApplication) string {
+	if !contains(applications.FundingMethods, app.FundingMethod) {
+		return "method must be one of " + strings.Join(applications.FundingMethods, ", ")
+	}
+	if app.FundingMethod == applications.FundingNone {
+		if app.FundingAmount != 0 || app.FundingAccountID != nil {
+			return "An unfunded account takes no amount or from_account_id"
+		}
+		return ""
+	}
+	if err := ledger.ValidateAmount(app.FundingAmount); err != nil {
+		return "amount must be positive"
+	}
+	if limit := tenancy.CurrentSettings(c).TransactionLimit; limit > 0 && app.FundingAmount > limit {
+		return "amount exceeds the transaction limit"
+	}
+	if app.FundingAccountID == nil {
+		return "from_account_id is required for a transfer"
+	}
+	var from models.Account
+	if err := db.First(&from, *app.FundingAccountID).Error; err != nil || from.CustomerID != app.CustomerID {
+		return "from_account_id must be one of the applicant's accounts"
+	}
+	if from.Status != "active" {
+		return "The funding account is not active"
+	}
+	if app.Currency != "" && from.Currency != app.Currency {
+		return fmt.Sprintf("The funding account is in %s, not %s", from.Currency, app.Currency)
+	}
+	return ""
+}
+
+// SubmitAccountApplication submits a completed draft. The applicant is evaluated against the product's eligibility
+// rules and their KYC level: an eligible applicant is approved at once (200); otherwise the application waits for
+// staff with the failed criteria (202)
+func SubmitAccountApplication(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		app, ok := loadApplication(c, db)
+		if !ok {
+			return
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			_, err := applications.Submit(tx, &app, actor(c), clock.Now())
+			return err
+		})
+		if err != nil {
+			respondApplicationError(c, err)
+			return
+		}
+		if app.Status == applications.StatusApproved {
+			respondApplication(c, http.StatusOK, "Application approved", app)
+			return
+		}
+		respondApplication(c, http.StatusAccepted, "Application referred for staff review", app)
+	}
+}
+
+// DecideAccountApplication records a staff decision on an application referred on submission
+// Approving waives the failed criteria, which are stored as eligibility overrides when the account opens
+func DecideAccountApplication(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req applicationDecisionRequest
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Decision != "approve" && req.Decision != "reject") || strings.TrimSpace(req.Reason) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "decision must be approve or reject, with a reason"})
+			return
+		}
+		app, ok := loadApplication(c, db)
+		if !ok {
+			return
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return applications.Decide(tx, &app, req.Decision == "approve", strings.TrimSpace(req.Reason), actor(c), clock.Now())
+		})
+		if err != nil {
+			respondApplicationError(c, err)
+			return
+		}
+		respondApplication(c, http.StatusOK, "Decision recorded", app)
+	}
+}
+
+// OpenAccountApplication opens the account of an approved application and posts its initial funding, all in one
+// database transaction: if the funding transfer fails, e.g. for lack of funds, no account is opened and the
+// application stays approved to be opened again
+func OpenAccountApplication(db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store, cfg transfers.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		app, ok := loadApplication(c, db)
+		if !ok {
+			return
+		}
+		if app.Status != applications.StatusApproved {
+			respondApplicationError(c, applications.ErrNotApproved)
+			return
+		}
+		// The product may have been deprecated since the application was approved
+		if !checkAccountType(c, db, app.AccountType) {
+			return
+		}
+		product, err := eligibility.Lookup(db, app.AccountType)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open account"})
+			return
+		}
+
+		// Criteria staff waived when approving are kept against the account, as for overrides when opening directly
+		var overrides []models.EligibilityOverride
+		if app.DecidedBy != statushistory.SystemActor {
+			for _, f := range applications.Failures(app) {
+				overrides = append(overrides, models.EligibilityOverride{
+					CustomerID:   app.CustomerID,
+					AccountType:  app.AccountType,
+					Criterion:    f.Criterion,
+					Detail:       f.Message,
+					Reason:       app.DecisionReason,
+					OverriddenBy: app.DecidedBy,
+				})
+			}
+		}
+
+		account := models.Account{CustomerID: app.CustomerID, AccountType: app.AccountType, Currency: app.Currency}
+		var funding *transfers.Result
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := openAccount(c, tx, &account, product, overrides); err != nil {
+				return err
+			}
+			if app.FundingMethod == applications.FundingTransfer {
+				result, err := transfers.Post(tx, transfers.Request{
+					FromAccountID:    *app.FundingAccountID,
+					ToAccountID:      account.ID,
+					Amount:           app.FundingAmount,
+					Description:      fmt.Sprintf("Initial funding, application %d", app.ID),
+					Reference:        fmt.Sprintf("APP-%d", app.ID),
+					Channel:          enrichment.DefaultChannel,
+					ConfirmDuplicate: true, // Opening funds an account once; a similar recent transfer is no duplicate
+					CreatedBy:        actor(c),
+					Cutoffs:          tenancy.CurrentSettings(c).Cutoffs,
+				}, cfg, featureFlags)
+				if err != nil {
+					return err
+				}
+				funding = &result
+			}
+			return applications.Opened(tx, &app, account.ID, actor(c), clock.Now())
+		})
+		switch {
+		case err == nil:
+		case err == applications.ErrNotApproved:
+			respondApplicationError(c, err)
+			return
+		case errors.Is(err, transfers.ErrCurrencyMismatch):
+			(&apiError{Status: http.StatusBadRequest, Code: "CURRENCY_MISMATCH", Message: err.Error()}).respondV1(c)
+			return
+		case errors.Is(err, restrictions.ErrApprovalRequired):
+			(&apiError{Status: http.StatusForbidden, Code: "APPROVAL_REQUIRED", Message: "Debits from the funding account need staff approval", v1Code: true}).respondV1(c)
+			return
+		default:
+			postingError(err).respondV1(c)
+			return
+		}
+
+		response := gin.H{"message": "Account opened", "application": app, "account": account}
+		if funding != nil {
+			balances.Invalidate(funding.From.ID)
+			response["account"], response["funding_transfer"] = funding.To, funding.Transfer
+		}
+		if len(overrides) > 0 {
+			response["eligibility_overrides"] = overrides
+		}
+		c.JSON(http.StatusCreated, response)
+	}
+}
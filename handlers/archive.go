@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"banking-app/archive"
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== TRANSACTION ARCHIVE HANDLERS ====================
+
+// unarchiveRequest selects archived postings to move back by effective date
+type unarchiveRequest struct {
+	AccountID uint   `json:"account_id"`                          // Omit for every account
+	From      string `json:"from" binding:"required"`             // YYYY-MM-DD, inclusive
+	To        string `json:"to" binding:"required"`               // YYYY-MM-DD, inclusive of the whole day
+	HoldDays  *int   `json:"hold_days" binding:"omitempty,min=0"` // Days the job leaves the range hot (default 30)
+}
+
+// defaultHoldDays is how long unarchived postings stay hot unless the request says otherwise
+const defaultHoldDays = 30
+
+// GetArchiveStatus reports the hot and archived table sizes, the postings due for archiving and the latest batches
+func GetArchiveStatus(db *gorm.DB, cfg archive.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status, err := archive.GetStatus(db, cfg, clock.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve archive status"})
+			return
+		}
+		c.JSON(http.StatusOK, status)
+	}
+}
+
+// UnarchiveTransactions moves archived postings in an effective-date range back into the transactions table
+func UnarchiveTransactions(db *gorm.DB, cfg archive.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req unarchiveRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from and to are required"})
+			return
+		}
+		from, err := businessdays.ParseDate(req.From)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+			return
+		}
+		to, err := businessdays.ParseDate(req.To)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+			return
+		}
+		holdDays := defaultHoldDays
+		if req.HoldDays != nil {
+			holdDays = *req.HoldDays
+		}
+
+		now := clock.Now()
+		r := archive.Range{AccountID: req.AccountID, From: from, To: to.AddDate(0, 0, 1)}
+		result, err := archive.Unarchive(db, r, cfg.BatchSize, actor(c), now.AddDate(0, 0, holdDays))
+		switch {
+		case err == nil:
+			c.JSON(http.StatusOK, gin.H{"message": "Transactions unarchived", "rows": result.Rows, "batches": result.Batches,
+				"hold_until": now.AddDate(0, 0, holdDays)})
+		case errors.Is(err, archive.ErrRange):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must not be after to"})
+		default:
+			// Committed batches stay unarchived; the request can be repeated for the rest
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unarchive transactions", "rows": result.Rows})
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"banking-app/auth"
+	"banking-app/middleware"
+	"banking-app/tenancy"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== AUTH HANDLERS ====================
+
+// loginRequest carries sign-in credentials
+type loginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// Login exchanges a username and password for a JWT
+// Users are locked after repeated failures and must be unlocked with bankctl
+func Login(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req loginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		user, err := auth.Authenticate(db, req.Username, req.Password)
+		if err == auth.ErrInvalidCredentials {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+			return
+		}
+		if err == auth.ErrLocked {
+			c.JSON(http.StatusForbidden, gin.H{"error": "User is locked"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+			return
+		}
+
+		token, err := middleware.GenerateJWT(middleware.User{ID: user.ID, Username: user.Username, Role: user.Role, TenantID: user.TenantID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"token": token,
+			"user":  user,
+		})
+	}
+}
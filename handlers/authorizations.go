@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"banking-app/authorizations"
+	"banking-app/cache"
+	"banking-app/clock"
+	"banking-app/flags"
+	"banking-app/models"
+	"banking-app/restrictions"
+	"banking-app/tenancy"
+	"banking-app/transfers"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== PRE-AUTHORIZATION HANDLERS ====================
+
+// authorizeRequest places a hold; to_account_id is required for the transfer channel
+type authorizeRequest struct {
+	Amount      float64 `json:"amount"`
+	Channel     string  `json:"channel"` // card or transfer
+	Merchant    string  `json:"merchant"`
+	Reference   string  `json:"reference"` // Sent again to re-authorize; a pending hold with it is returned instead
+	ToAccountID uint    `json:"to_account_id"`
+}
+
+// captureRequest takes all or part of a hold; amount defaults to the whole hold
+type captureRequest struct {
+	Amount float64 `json:"amount"`
+}
+
+// CreateAuthorization places a pre-authorization hold on an account, answering 201 for a new hold and 200 for the
+// pending hold a repeated reference already placed
+func CreateAuthorization(db *gorm.DB, featureFlags *flags.Store, cfg authorizations.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		accountID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+			return
+		}
+		var req authorizeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		hold, created, err := authorizations.Authorize(db, cfg, featureFlags, models.Authorization{
+			Channel:     req.Channel,
+			AccountID:   uint(accountID),
+			ToAccountID: req.ToAccountID,
+			Amount:      req.Amount,
+			Merchant:    strings.TrimSpace(req.Merchant),
+			Reference:   strings.TrimSpace(req.Reference),
+			RequestedBy: actor(c),
+		}, clock.Now())
+		if err != nil {
+			authorizationError(err).respondV1(c)
+			return
+		}
+		if !created {
+			c.JSON(http.StatusOK, gin.H{"message": "Authorization already pending", "authorization": hold})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Amount held", "authorization": hold})
+	}
+}
+
+// GetAccountAuthorizations lists an account's holds, newest first; ?status= filters (default all)
+func GetAccountAuthorizations(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var account models.Account
+		if err := db.Select("id").First(&account, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		query := db.Where("account_id = ?", account.ID)
+		if status := c.Query("status"); status != "" && status != "all" {
+			query = query.Where("status = ?", status)
+		}
+		holds := []models.Authorization{}
+		if err := query.Order("id DESC").Find(&holds).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve authorizations"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"authorizations": holds})
+	}
+}
+
+// GetAuthorizationHold returns one pre-authorization hold
+func GetAuthorizationHold(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var hold models.Authorization
+		if err := db.First(&hold, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Authorization not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"authorization": hold})
+	}
+}
+
+// CaptureAuthorization posts a pending hold as a debit, effective now; any amount left of the hold is released
+// An expired hold answers 409 AUTHORIZATION_EXPIRED; the merchant must authorize again
+func CaptureAuthorization(db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store, cfg transfers.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req captureRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		var hold models.Authorization
+		if err := db.First(&hold, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Authorization not found"})
+			return
+		}
+		posted, err := authorizations.Capture(db, &hold, req.Amount, actor(c), cfg, featureFlags, balances, clock.Now())
+		if err != nil {
+			authorizationError(err).respondV1(c)
+			return
+		}
+
+		response := gin.H{"message": "Authorization captured", "authorization": hold}
+		if posted.Transfer != nil {
+			response["transfer"] = posted.Transfer.Transfer
+		} else {
+			response["transaction"] = posted.Transaction
+		}
+		if posted.Fee != nil {
+			response["fee"] = posted.Fee
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// ReleaseAuthorization voids a pending hold without posting it
+func ReleaseAuthorization(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var hold models.Authorization
+		if err := db.First(&hold, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Authorization not found"})
+			return
+		}
+		if err := authorizations.Release(db, &hold, actor(c), clock.Now()); err != nil {
+			authorizationError(err).respondV1(c)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Authorization released", "authorization": hold})
+	}
+}
+
+// authorizationError maps a refused hold or capture, including why a capture did not post
+func authorizationError(err error) *apiError {
+	switch {
+	case errors.Is(err, authorizations.ErrChannel):
+		return &apiError{Status: http.StatusBadRequest, Code: "INVALID_CHANNEL", Message: "channel must be card or transfer"}
+	case errors.Is(err, authorizations.ErrExpired):
+		return &apiError{Status: http.StatusConflict, Code: "AUTHORIZATION_EXPIRED", Message: "Authorization expired before it was captured; authorize again", v1Code: true}
+	case errors.Is(err, authorizations.ErrNotPending):
+		return &apiError{Status: http.StatusConflict, Code: "AUTHORIZATION_CLOSED", Message: "Authorization has already been captured or released", v1Code: true}
+	case errors.Is(err, authorizations.ErrExceedsAmount):
+		return &apiError{Status: http.StatusBadRequest, Code: "CAPTURE_EXCEEDS_AUTHORIZATION", Message: "Capture amount exceeds the authorized amount", v1Code: true}
+	case errors.Is(err, restrictions.ErrApprovalRequired):
+		return &apiError{Status: http.StatusForbidden, Code: "APPROVAL_REQUIRED", Message: "Debits from this account need staff approval; post them on their own", v1Code: true}
+	case errors.Is(err, transfers.ErrDestinationInactive):
+		return &apiError{Status: http.StatusBadRequest, Code: "DESTINATION_INACTIVE", Message: err.Error()}
+	case errors.Is(err, transfers.ErrCurrencyMismatch):
+		return &apiError{Status: http.StatusBadRequest, Code: "CURRENCY_MISMATCH", Message: err.Error()}
+	}
+	return postingError(err)
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"banking-app/alerts"
+	"banking-app/cache"
+	"banking-app/enrichment"
+	"banking-app/flags"
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/liens"
+	"banking-app/models"
+	"banking-app/restrictions"
+	"banking-app/tenancy"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== ATOMIC BATCH HANDLERS ====================
+
+// Leg directions
+const (
+	directionDebit  = "debit"
+	directionCredit = "credit"
+)
+
+// errBatchRejected ends a batch's database transaction when legs were rejected; they are reported per leg
+var errBatchRejected = errors.New("batch rejected")
+
+// Leg errors found before the ledger sees the batch
+var (
+	errInvalidLeg   = errors.New("a leg names either account_id or a known gl_code")
+	errLegDirection = errors.New("direction must be debit or credit, and the transaction type must move the account that way")
+	errLegLimit     = errors.New("leg amount exceeds the transaction limit")
+)
+
+// batchLegRequest is one posting of an atomic batch
+type batchLegRequest struct {
+	AccountID       uint    `json:"account_id"`
+	GLCode          string  `json:"gl_code"`          // An internal account instead, in the batch currency, e.g. FEE_INCOME
+	Direction       string  `json:"direction"`        // debit or credit
+	Amount          float64 `json:"amount"`           // In the account's currency
+	TransactionType string  `json:"transaction_type"` // Defaults to withdrawal for debits and deposit for credits
+	Description     string  `json:"description"`
+	FX              *struct {
+		Rate float64 `json:"rate"` // Batch currency per unit of the account's currency
+	} `json:"fx"`
+}
+
+// batchRequest is a set of legs posted all together or not at all
+type batchRequest struct {
+	Currency  string            `json:"currency"`  // Defaults to the first leg's account currency
+	Reference string            `json:"reference"` // Shared by every leg; generated when empty
+	Channel   string            `json:"channel"`
+	Legs      []batchLegRequest `json:"legs"`
+}
+
+// legRejection renders why one leg was rejected, in the codes single postings use
+func legRejection(legErr ledger.LegError) gin.H {
+	var apiErr *apiError
+	switch {
+	case errors.Is(legErr.Err, ledger.ErrLegCurrency):
+		apiErr = &apiError{Code: "CURRENCY_MISMATCH", Message: legErr.Err.Error()}
+	case errors.Is(legErr.Err, ledger.ErrFXRate):
+		apiErr = &apiError{Code: "INVALID_FX_RATE", Message: legErr.Err.Error()}
+	case errors.Is(legErr.Err, errInvalidLeg):
+		apiErr = &apiError{Code: "INVALID_LEG", Message: legErr.Err.Error()}
+	case errors.Is(legErr.Err, errLegDirection):
+		apiErr = &apiError{Code: "INVALID_TRANSACTION_TYPE", Message: legErr.Err.Error()}
+	case errors.Is(legErr.Err, errLegLimit):
+		apiErr = &apiError{Code: "AMOUNT_LIMIT_EXCEEDED", Message: "Transaction amount exceeds the limit"}
+	case errors.Is(legErr.Err, restrictions.ErrApprovalRequired):
+		apiErr = &apiError{Code: "APPROVAL_REQUIRED", Message: "Debits from this account need staff approval; post them on their own"}
+	default:
+		apiErr = postingError(legErr.Err)
+	}
+	return gin.H{"leg": legErr.Leg, "code": apiErr.Code, "error": apiErr.Message}
+}
+
+// PostBatch posts up to ledger.MaxLegs legs in one database transaction: every leg posts or none does
+// Body: {"currency": "USD", "legs": [{"account_id": 12, "direction": "debit", "amount": 100, "transaction_type": "payment"},
+// {"account_id": 31, "direction": "credit", "amount": 97}, {"gl_code": "FEE_INCOME", "direction": "credit", "amount": 3}]}
+// Debits and credits must balance in the batch currency; a leg on an account in another currency gives its rate
+// as fx.rate. Legs carry no fee schedule fees, since any fee is a leg of its own, and share one value date. A
+// rejected batch lists every leg that failed validation, or the leg that failed to post
+func PostBatch(db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req batchRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if req.Channel == "" {
+			req.Channel = enrichment.DefaultChannel
+		}
+		if !contains(enrichment.Channels, req.Channel) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction channel", "code": "INVALID_CHANNEL"})
+			return
+		}
+		if len(req.Legs) < 2 || len(req.Legs) > ledger.MaxLegs {
+			c.JSON(http.StatusBadRequest, gin.H{"error": ledger.ErrLegCount.Error(), "code": "INVALID_BATCH"})
+			return
+		}
+		if req.Reference == "" {
+			req.Reference = "BATCH" + strings.TrimPrefix(ledger.NewTransactionID(), "TXN")
+		}
+		settings := tenancy.CurrentSettings(c)
+		limit, cutoffs := settings.TransactionLimit, settings.Cutoffs
+
+		var rejected []ledger.LegError
+		var posted []models.Transaction
+		var accounts []models.Account
+		err := db.Transaction(func(tx *gorm.DB) error {
+			currency := strings.ToUpper(strings.TrimSpace(req.Currency))
+			if currency == "" {
+				var first models.Account
+				if req.Legs[0].AccountID == 0 {
+					return ledger.LegError{Leg: 0, Err: errInvalidLeg}
+				}
+				if err := tx.Select("currency").First(&first, req.Legs[0].AccountID).Error; err != nil {
+					return ledger.LegError{Leg: 0, Err: err}
+				}
+				currency = first.Currency
+			}
+
+			// Each leg becomes a posting; the shape of every leg is checked before any account is
+			legs := make([]ledger.Leg, len(req.Legs))
+			for i, l := range req.Legs {
+				if l.TransactionType == "" && l.Direction == directionCredit {
+					l.TransactionType = "deposit"
+				} else if l.TransactionType == "" {
+					l.TransactionType = "withdrawal"
+				}
+				t := &models.Transaction{
+					AccountID:       l.AccountID,
+					TransactionType: l.TransactionType,
+					Amount:          l.Amount,
+					Description:     l.Description,
+					Reference:       req.Reference,
+					Channel:         req.Channel,
+				}
+				legs[i] = ledger.Leg{Transaction: t}
+				if l.FX != nil {
+					legs[i].Rate = l.FX.Rate
+				}
+
+				validTypes := []string{"deposit", "withdrawal", "transfer", "payment", ledger.TypeInterest, ledger.TypeFee}
+				switch {
+				case l.Direction != directionDebit && l.Direction != directionCredit,
+					!contains(validTypes, l.TransactionType),
+					ledger.IsCredit(l.TransactionType) != (l.Direction == directionCredit):
+					rejected = append(rejected, ledger.LegError{Leg: i, Err: errLegDirection})
+				case (l.AccountID == 0) == (l.GLCode == ""),
+					l.GLCode != "" && gl.Kinds[l.GLCode] == "":
+					rejected = append(rejected, ledger.LegError{Leg: i, Err: errInvalidLeg})
+				case l.FX != nil && !(l.FX.Rate > 0):
+					rejected = append(rejected, ledger.LegError{Leg: i, Err: ledger.ErrFXRate})
+				case limit > 0 && l.Amount > limit:
+					rejected = append(rejected, ledger.LegError{Leg: i, Err: errLegLimit})
+				case l.GLCode != "":
+					internal, err := gl.Account(tx, tenancy.Current(c).ID, l.GLCode, currency)
+					if err != nil {
+						return err
+					}
+					t.AccountID = internal.ID
+				}
+			}
+			if len(rejected) > 0 {
+				return errBatchRejected
+			}
+
+			found, invalid, err := ledger.CheckBatch(tx, legs, currency)
+			if err != nil {
+				return err
+			}
+			for i, account := range found {
+				// Restricted accounts refuse debits in a batch as they would on their own; none is queued for approval
+				if account.ID != 0 && !containsLeg(invalid, i) {
+					if err := restrictions.Allow(account.Restrictions, legs[i].Transaction.TransactionType, false); err != nil {
+						invalid = append(invalid, ledger.LegError{Leg: i, Err: err})
+					}
+				}
+			}
+			if rejected = invalid; len(rejected) > 0 {
+				return errBatchRejected
+			}
+
+			// Legs balance one another, so all take the latest value date their types' cutoffs give
+			var valueDate time.Time
+			for _, leg := range legs {
+				day, err := ledger.ValueDate(tx, leg.Transaction.TransactionType, time.Time{}, cutoffs)
+				if err != nil {
+					return err
+				}
+				if day.After(valueDate) {
+					valueDate = day
+				}
+			}
+			for _, leg := range legs {
+				leg.Transaction.ValueDate = &valueDate
+			}
+
+			if accounts, err = ledger.PostLegs(tx, legs, featureFlags); err != nil {
+				return ledger.LegError{Leg: len(accounts), Err: err}
+			}
+			// Active liens hold their amount against each debited account once every leg has posted
+			for i, leg := range legs {
+				if restrictions.Restricts(leg.Transaction.TransactionType) {
+					if err := liens.Enforce(tx, accounts[i]); err != nil {
+						return ledger.LegError{Leg: i, Err: err}
+					}
+				}
+				posted = append(posted, *leg.Transaction)
+			}
+			return nil
+		})
+
+		var legErr ledger.LegError
+		switch {
+		case err == nil:
+		case errors.Is(err, errBatchRejected):
+			sort.Slice(rejected, func(i, j int) bool { return rejected[i].Leg < rejected[j].Leg })
+			legs := make([]gin.H, len(rejected))
+			for i, r := range rejected {
+				legs[i] = legRejection(r)
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The batch was not posted; no leg was", "code": "INVALID_BATCH", "legs": legs})
+			return
+		case errors.Is(err, ledger.ErrUnbalanced):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "BATCH_UNBALANCED"})
+			return
+		case errors.As(err, &legErr):
+			status := postingError(legErr.Err).Status
+			if errors.Is(legErr.Err, errInvalidLeg) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": "The batch was not posted; no leg was", "code": "INVALID_BATCH", "legs": []gin.H{legRejection(legErr)}})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to post the batch"})
+			return
+		}
+
+		for i, account := range accounts {
+			balances.Set(cache.Entry(account))
+			if account.AccountType != gl.AccountType {
+				var rate float64
+				if req.Legs[i].FX != nil {
+					rate = req.Legs[i].FX.Rate
+				}
+				alerts.EvaluateLeg(db, account, posted[i], rate)
+			}
+		}
+		c.JSON(http.StatusCreated, gin.H{
+			"message":      "Batch posted",
+			"reference":    req.Reference,
+			"transactions": posted,
+		})
+	}
+}
+
+// containsLeg reports whether a leg is already among the rejected
+func containsLeg(rejected []ledger.LegError, leg int) bool {
+	for _, r := range rejected {
+		if r.Leg == leg {
+			return true
+		}
+	}
+	return false
+}
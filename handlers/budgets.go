@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"banking-app/alerts"
+	"banking-app/budgets"
+	"banking-app/clock"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== BUDGET HANDLERS ====================
+
+// budgetRequest carries the client-settable fields of a budget
+type budgetRequest struct {
+	Category      string  `json:"category"`
+	MonthlyAmount float64 `json:"monthly_amount"`
+	Channel       string  `json:"channel"`
+	Target        string  `json:"target"`
+}
+
+// checkBudget validates a budget and that the customer has no other for its category, responding when it fails
+func checkBudget(c *gin.Context, db *gorm.DB, b *models.Budget) bool {
+	if err := budgets.Validate(b); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "INVALID_BUDGET"})
+		return false
+	}
+	if !contains(alerts.Channels, b.Channel) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel", "code": "INVALID_BUDGET"})
+		return false
+	}
+	if b.Channel == "webhook" && b.Target == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Webhook alerts require a target URL", "code": "INVALID_BUDGET"})
+		return false
+	}
+	exists, err := budgets.Conflict(db, *b)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check budgets"})
+		return false
+	}
+	if exists {
+		c.JSON(http.StatusConflict, gin.H{"error": budgets.ErrExists.Error(), "code": "BUDGET_EXISTS"})
+		return false
+	}
+	return true
+}
+
+// findCustomerBudget loads a budget scoped to the customer in the route
+func findCustomerBudget(c *gin.Context, db *gorm.DB) (models.Budget, bool) {
+	var budget models.Budget
+	var customer models.Customer
+	if err := db.Select("id").First(&customer, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return budget, false
+	}
+	if err := db.Where("customer_id = ?", customer.ID).First(&budget, c.Param("budgetId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Budget not found"})
+		return budget, false
+	}
+	return budget, true
+}
+
+// GetCustomerBudgets shows each of a customer's budgets with the bank month's spending against it
+// Spending excludes transfers between the customer's own accounts and reversed postings
+func GetCustomerBudgets(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var customer models.Customer
+		if err := db.Select("id").First(&customer, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+			return
+		}
+
+		now := clock.Now()
+		statuses, err := budgets.Statuses(db, customer.ID, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve budgets"})
+			return
+		}
+		start, end, period := budgets.Month(now)
+		c.JSON(http.StatusOK, gin.H{
+			"customer_id":  customer.ID,
+			"period":       period,
+			"period_start": start,
+			"period_end":   end,
+			"budgets":      statuses,
+			"as_of":        now,
+		})
+	}
+}
+
+// CreateCustomerBudget sets a monthly budget for one spending category
+// Body: {"category": "5411", "monthly_amount": 400}; alerts go to the verified customer email unless a channel
+// and target are given, as for account alerts
+func CreateCustomerBudget(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var customer models.Customer
+		if err := db.Select("id").First(&customer, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+			return
+		}
+		var req budgetRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		budget := models.Budget{
+			CustomerID:    customer.ID,
+			Category:      req.Category,
+			MonthlyAmount: req.MonthlyAmount,
+			Channel:       req.Channel,
+			Target:        req.Target,
+		}
+		if budget.Channel == "" {
+			budget.Channel = "email"
+		}
+		if !checkBudget(c, db, &budget) {
+			return
+		}
+		if err := db.Create(&budget).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create budget"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Budget created", "budget": budget})
+	}
+}
+
+// UpdateCustomerBudget changes a budget's category, amount or alert delivery
+// Alerts already sent this month stay sent, so raising the amount does not alert again at the same threshold
+func UpdateCustomerBudget(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		budget, ok := findCustomerBudget(c, db)
+		if !ok {
+			return
+		}
+		var req budgetRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		if req.Category != "" {
+			budget.Category = req.Category
+		}
+		if req.MonthlyAmount != 0 {
+			budget.MonthlyAmount = req.MonthlyAmount
+		}
+		if req.Channel != "" {
+			budget.Channel = req.Channel
+		}
+		if req.Target != "" {
+			budget.Target = req.Target
+		}
+		if !checkBudget(c, db, &budget) {
+			return
+		}
+		if err := db.Save(&budget).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update budget"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Budget updated", "budget": budget})
+	}
+}
+
+// DeleteCustomerBudget removes a budget; its spending stops being tracked and alerted
+func DeleteCustomerBudget(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		budget, ok := findCustomerBudget(c, db)
+		if !ok {
+			return
+		}
+		if err := db.Delete(&budget).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete budget"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Budget deleted"})
+	}
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"banking-app/bulkops"
+	"banking-app/clock"
+	"banking-app/models"
+	"banking-app/ratelimit"
+	"banking-app/reconcile"
+	"banking-app/tenancy"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== BULK OPERATION HANDLERS ====================
+
+// bulkActionRequest selects accounts and the action to apply to them
+type bulkActionRequest struct {
+	Action string `json:"action" binding:"required"` // freeze, unfreeze, set_limit, convert_product
+	Filter struct {
+		CustomerIDs []uint     `json:"customer_ids"`
+		AccountIDs  []uint     `json:"account_ids"`
+		AccountType string     `json:"account_type"`
+		CreatedFrom *time.Time `json:"created_from"`
+		CreatedTo   *time.Time `json:"created_to"`
+	} `json:"filter"`
+	OverdraftLimit *float64 `json:"overdraft_limit"` // Required for set_limit
+	TargetType     string   `json:"target_type"`     // Product convert_product moves accounts to; required for it
+	NextCycle      bool     `json:"next_cycle"`      // convert_product from the next statement cycle
+	Reason         string   `json:"reason"`
+	DryRun         bool     `json:"dry_run"` // Report the affected accounts without changing them
+}
+
+// bulkSampleAccount is one account listed by a dry run
+type bulkSampleAccount struct {
+	ID             uint    `json:"id"`
+	AccountNumber  string  `json:"account_number"`
+	CustomerID     uint    `json:"customer_id"`
+	AccountType    string  `json:"account_type"`
+	Status         string  `json:"status"`
+	OverdraftLimit float64 `json:"overdraft_limit"`
+}
+
+// CreateBulkAction queues an action over every account matching a filter, or previews it with dry_run
+// The operation runs in the background; its progress is at GET /admin/bulk-operations/:id
+func CreateBulkAction(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req bulkActionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		role, _ := c.Get("user_role")
+		op := models.BulkOperation{
+			Action: req.Action,
+			Filter: models.BulkFilter{
+				CustomerIDs: bulkops.JoinIDs(req.Filter.CustomerIDs),
+				AccountIDs:  bulkops.JoinIDs(req.Filter.AccountIDs),
+				AccountType: req.Filter.AccountType,
+				CreatedFrom: req.Filter.CreatedFrom,
+				CreatedTo:   req.Filter.CreatedTo,
+			},
+			OverdraftLimit: req.OverdraftLimit,
+			TargetType:     req.TargetType,
+			NextCycle:      req.NextCycle,
+			Reason:         req.Reason,
+			RequestedBy:    actor(c),
+			Status:         bulkops.StatusQueued,
+		}
+		op.RequestedRole, _ = role.(string)
+		if err := bulkops.Validate(op); err != nil {
+			if e := transitionError(err); e != nil {
+				e.respondV1(c)
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if req.DryRun {
+			var count int64
+			sample := []bulkSampleAccount{}
+			err := bulkops.Matching(db, op.Filter).Count(&count).Error
+			if err == nil {
+				err = bulkops.Matching(db, op.Filter).Order("id").Limit(bulkops.SampleSize).Scan(&sample).Error
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to match accounts"})
+				return
+			}
+			respondDisplay(c, http.StatusOK, gin.H{
+				"dry_run":  true,
+				"action":   op.Action,
+				"affected": count,
+				"sample":   sample,
+			})
+			return
+		}
+
+		if err := db.Create(&op).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue bulk operation"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": "Bulk operation queued", "operation": op})
+	}
+}
+
+// GetBulkOperation returns an operation's progress with its per-account results
+// ?result=failed narrows the results
+func GetBulkOperation(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var op models.BulkOperation
+		if err := db.First(&op, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bulk operation not found"})
+			return
+		}
+
+		page, limit, offset := parsePagination(c, 50)
+		var filter listFilter
+		filter.where("bulk_operation_id = ?", op.ID)
+		if result := c.Query("result"); result != "" {
+			filter.where("result = ?", result)
+		}
+
+		var items []models.BulkOperationItem
+		total, err := filter.count(db, &models.BulkOperationItem{})
+		if err == nil {
+			err = filter.apply(db).Order("id").Offset(offset).Limit(limit).Find(&items).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve bulk operation"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"operation": op,
+			"items":     items,
+			"total":     total,
+			"page":      page,
+			"limit":     limit,
+		})
+	}
+}
+
+// recomputeRequest lists the accounts whose balances to recompute
+type recomputeRequest struct {
+	AccountIDs []uint `json:"account_ids"`
+}
+
+// RecomputeBalances recomputes the listed accounts' balances from their postings and reports match or drift for each
+// More than reconcile.MaxRecompute accounts queue a recompute_balances bulk operation instead
+func RecomputeBalances(db *gorm.DB, cfg reconcile.RecomputeConfig, throttle *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req recomputeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		ids := make([]uint, 0, len(req.AccountIDs))
+		seen := make(map[uint]bool, len(req.AccountIDs))
+		for _, id := range req.AccountIDs {
+			if id != 0 && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "account_ids must list at least one account"})
+			return
+		}
+
+		// Usernames are unique per tenant, so the window is kept per tenant and user
+		user := fmt.Sprintf("%d/%s", tenancy.Current(c).ID, actor(c))
+		if ok, wait := throttle.Allow(user, clock.Now()); !ok {
+			seconds := int(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many balance recomputations; try again shortly",
+				"code": "RATE_LIMITED", "retry_after_seconds": seconds})
+			return
+		}
+
+		if len(ids) > reconcile.MaxRecompute {
+			role, _ := c.Get("user_role")
+			op := models.BulkOperation{
+				Action:      bulkops.ActionRecomputeBalances,
+				Filter:      models.BulkFilter{AccountIDs: bulkops.JoinIDs(ids)},
+				RequestedBy: actor(c),
+				Status:      bulkops.StatusQueued,
+			}
+			op.RequestedRole, _ = role.(string)
+			if err := db.Create(&op).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue balance recomputation"})
+				return
+			}
+			c.JSON(http.StatusAccepted, gin.H{"message": "Balance recomputation queued", "async": true, "operation": op})
+			return
+		}
+
+		results := reconcile.Recompute(db, ids, cfg.Workers)
+		var matched, drifted, failed int
+		for _, r := range results {
+			switch {
+			case r.Error != "":
+				failed++
+			case r.Match:
+				matched++
+			default:
+				drifted++
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"total":    len(results),
+			"matched":  matched,
+			"drifted":  drifted,
+			"failed":   failed,
+			"accounts": results,
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cache lifetimes for polled read endpoints
+const (
+	balanceMaxAge  = 5  // Seconds clients may reuse a balance response
+	customerMaxAge = 30 // Seconds clients may reuse a customer detail response
+)
+
+// weakETag builds a weak validator from the values that determine a response
+func weakETag(parts ...interface{}) string {
+	h := fnv.New64a()
+	for _, p := range parts {
+		fmt.Fprintf(h, "%v|", p)
+	}
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// notModified sets caching headers and answers 304 when the client's copy is current
+// Returns true when the response has been fully written
+func notModified(c *gin.Context, etag string, maxAge int) bool {
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+
+	for _, candidate := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		candidate = strings.TrimSpace(candidate)
+		// Weak comparison - the W/ prefix is ignored on both sides
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
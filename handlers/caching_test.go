@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"banking-app/cache"
+	"banking-app/models"
+	"banking-app/slowquery"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BenchmarkBalancePolling polls one account's balance the way a dashboard does, resending the last ETag, with the
+// balance cache and with every entry expired at once. Statements are counted by the callbacks testDB registers
+// and reported per poll, so the run shows the read the cache saves alongside the time
+func BenchmarkBalancePolling(b *testing.B) {
+	db := testDB(b)
+	customer := models.Customer{FirstName: "Polled", LastName: "Holder", Email: "polled@example.test", DateOfBirth: "1980-01-01", Status: "active"}
+	if err := db.Create(&customer).Error; err != nil {
+		b.Fatal(err)
+	}
+	account := models.Account{CustomerID: customer.ID, AccountNumber: "POLL-1", AccountType: "checking", Balance: 250, Currency: "USD", Status: "active"}
+	if err := db.Create(&account).Error; err != nil {
+		b.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		balances *cache.Balances
+	}{
+		{"cached", cache.NewBalances(cache.BalanceConfig{Size: 10, TTL: time.Minute})},
+		{"uncached", cache.NewBalancesWith(cache.NewLRU(10, 0), 0)},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			var count func() int
+			router.GET("/accounts/:id/balance", func(c *gin.Context) {
+				var ctx context.Context
+				ctx, count = slowquery.Counting(c.Request.Context())
+				c.Request = c.Request.WithContext(ctx)
+				c.Next()
+			}, GetAccountBalance(db, tt.balances))
+
+			target := fmt.Sprintf("/accounts/%d/balance", account.ID)
+			etag, queries := "", 0
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				request := httptest.NewRequest(http.MethodGet, target, nil)
+				if etag != "" {
+					request.Header.Set("If-None-Match", etag)
+				}
+				recorder := httptest.NewRecorder()
+				router.ServeHTTP(recorder, request)
+				if recorder.Code != http.StatusOK && recorder.Code != http.StatusNotModified {
+					b.Fatalf("GET %s: status %d: %s", target, recorder.Code, recorder.Body.String())
+				}
+				etag = recorder.Header().Get("ETag")
+				queries += count()
+			}
+			b.ReportMetric(float64(queries)/float64(b.N), "queries/op")
+		})
+	}
+}
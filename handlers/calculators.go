@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/finance"
+	"banking-app/loans"
+	"banking-app/middleware"
+	"banking-app/models"
+	"banking-app/money"
+	"banking-app/ratelimit"
+	"banking-app/tenancy"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== CALCULATOR HANDLERS ====================
+
+// Bounds on calculator inputs; rates are annual and decimal, as on loans and products
+const (
+	maxCalculatorPrincipal    = 10000000
+	maxCalculatorRate         = 1
+	maxCalculatorTerm         = 480 // Months
+	maxCalculatorContribution = 1000000
+	maxCalculatorYears        = 50
+)
+
+// loanCalculatorRequest describes a hypothetical loan
+type loanCalculatorRequest struct {
+	Principal float64 `json:"principal"`
+	Rate      float64 `json:"rate"`
+	Term      int     `json:"term"` // Months
+}
+
+// validate checks the loan is one the bank could originate, within the calculator's bounds
+func (r loanCalculatorRequest) validate() string {
+	switch {
+	case r.Principal <= 0 || r.Principal > maxCalculatorPrincipal:
+		return "principal must be greater than 0 and at most 10000000"
+	case r.Rate <= 0 || r.Rate > maxCalculatorRate:
+		return "rate must be an annual decimal rate greater than 0 and at most 1"
+	case r.Term <= 0 || r.Term > maxCalculatorTerm:
+		return "term must be between 1 and 480 months"
+	}
+	return ""
+}
+
+// schedule projects the loan from today with the payment a loan created now would have, plus extra a month
+func (r loanCalculatorRequest) schedule(extra float64) (float64, []finance.Installment, error) {
+	today := businessdays.StartOfDay(clock.Now())
+	payment := finance.MonthlyPayment(r.Principal, r.Rate, r.Term)
+	schedule, err := finance.Schedule(money.Cents(r.Principal), r.Rate, payment+extra, today, today, 1)
+	return payment, schedule, err
+}
+
+// CalculatorRateLimit limits each client address to the limiter's calculator requests a minute
+func CalculatorRateLimit(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ok, wait := limiter.Allow(c.ClientIP(), clock.Now()); !ok {
+			seconds := int(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many calculator requests; try again shortly",
+				"code": "RATE_LIMITED", "retry_after_seconds": seconds})
+			return
+		}
+		c.Next()
+	}
+}
+
+// CalculateLoan projects a hypothetical loan's payment, total interest and repayment schedule
+// The payment and schedule come from the same functions that price and charge real loans
+func CalculateLoan() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req loanCalculatorRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if msg := req.validate(); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		payment, schedule, err := req.schedule(0)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		totalInterest := finance.TotalInterest(schedule)
+		c.JSON(http.StatusOK, gin.H{
+			"monthly_payment": money.Cents(payment),
+			"total_interest":  totalInterest,
+			"total_paid":      money.Cents(req.Principal + totalInterest),
+			"schedule":        schedule,
+		})
+	}
+}
+
+// earlyPayoffRequest is a loan, either an existing one or hypothetical, and an extra amount paid each month
+type earlyPayoffRequest struct {
+	loanCalculatorRequest
+	LoanID       uint    `json:"loan_id"`
+	ExtraMonthly float64 `json:"extra_monthly"`
+}
+
+// CalculateEarlyPayoff compares a loan's repayments with and without an extra amount paid each month
+// An existing loan is projected from its remaining balance, as its payments would charge it; only a signed-in
+// caller may name one, and customers only their own
+func CalculateEarlyPayoff(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req earlyPayoffRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if req.ExtraMonthly <= 0 || req.ExtraMonthly > maxCalculatorContribution {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "extra_monthly must be greater than 0 and at most 1000000"})
+			return
+		}
+
+		var payment float64
+		var base, faster []finance.Installment
+		var err error
+		if req.LoanID != 0 {
+			if c.GetUint("user_id") == 0 {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign in to project an existing loan"})
+				return
+			}
+			db := tenancy.DB(c, db)
+			var loan models.Loan
+			err = db.First(&loan, req.LoanID).Error
+			if customerID, isCustomer := applicant(c, db); err == nil && isCustomer && loan.CustomerID != customerID {
+				err = gorm.ErrRecordNotFound
+			}
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+				return
+			}
+			middleware.AuditCustomer(c, loan.CustomerID)
+			now := clock.Now()
+			payment = loan.MonthlyPayment
+			if base, err = loans.Remaining(db, loan, 0, now); err == nil {
+				faster, err = loans.Remaining(db, loan, req.ExtraMonthly, now)
+			}
+			if errors.Is(err, loans.ErrLoanClosed) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Only active loans can be projected"})
+				return
+			}
+		} else {
+			if msg := req.validate(); msg != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+				return
+			}
+			if payment, base, err = req.schedule(0); err == nil {
+				_, faster, err = req.schedule(req.ExtraMonthly)
+			}
+		}
+		if errors.Is(err, finance.ErrNeverRepaid) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to project loan"})
+			return
+		}
+
+		interest, fasterInterest := finance.TotalInterest(base), finance.TotalInterest(faster)
+		c.JSON(http.StatusOK, gin.H{
+			"monthly_payment":        money.Cents(payment),
+			"extra_monthly":          money.Cents(req.ExtraMonthly),
+			"months":                 len(base),
+			"months_with_extra":      len(faster),
+			"months_saved":           len(base) - len(faster),
+			"interest":               interest,
+			"interest_with_extra":    fasterInterest,
+			"interest_saved":         money.Cents(interest - fasterInterest),
+			"payoff_date":            payoffDate(base),
+			"payoff_date_with_extra": payoffDate(faster),
+		})
+	}
+}
+
+// payoffDate is the date of a schedule's last installment, or nil for an empty one
+func payoffDate(schedule []finance.Installment) *time.Time {
+	if len(schedule) == 0 {
+		return nil
+	}
+	return &schedule[len(schedule)-1].Date
+}
+
+// savingsGrowthRequest describes a hypothetical deposit
+type savingsGrowthRequest struct {
+	Initial             float64 `json:"initial"`
+	MonthlyContribution float64 `json:"monthly_contribution"`
+	Rate                float64 `json:"rate"`
+	Years               int     `json:"years"`
+}
+
+// CalculateSavingsGrowth projects a deposit's balance at the end of each year from today
+// Interest accrues and is credited as on a real savings account
+func CalculateSavingsGrowth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req savingsGrowthRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		switch {
+		case req.Initial < 0 || req.Initial > maxCalculatorPrincipal:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "initial must be between 0 and 10000000"})
+			return
+		case req.MonthlyContribution < 0 || req.MonthlyContribution > maxCalculatorContribution:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "monthly_contribution must be between 0 and 1000000"})
+			return
+		case req.Initial == 0 && req.MonthlyContribution == 0:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "initial or monthly_contribution must be greater than 0"})
+			return
+		case req.Rate < 0 || req.Rate > maxCalculatorRate:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "rate must be an annual decimal rate between 0 and 1"})
+			return
+		case req.Years <= 0 || req.Years > maxCalculatorYears:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "years must be between 1 and 50"})
+			return
+		}
+
+		series := finance.Grow(req.Initial, req.MonthlyContribution, req.Rate, businessdays.StartOfDay(clock.Now()), req.Years)
+		final := series[len(series)-1]
+		c.JSON(http.StatusOK, gin.H{
+			"final_balance":       final.Balance,
+			"total_contributions": final.Contributions,
+			"total_interest":      final.Interest,
+			"series":              series,
+		})
+	}
+}
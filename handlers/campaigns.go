@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"banking-app/campaigns"
+	"banking-app/clock"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== CAMPAIGN HANDLERS ====================
+
+// campaignRequest describes an announcement and who it goes to
+type campaignRequest struct {
+	Name     string `json:"name" binding:"required"`
+	Audience struct {
+		AccountType    string   `json:"account_type"`    // Product: customers holding an open account of this type
+		Tag            string   `json:"tag"`             // Customers carrying this tag
+		CustomerStatus string   `json:"customer_status"` // e.g. active
+		Tenants        []string `json:"tenants"`         // Tenant codes; the caller's tenant when empty
+	} `json:"audience"`
+	Subject              string     `json:"subject" binding:"required"`
+	Template             string     `json:"template" binding:"required"`
+	Regulatory           bool       `json:"regulatory"`             // Mandatory notice: preferences are overridden and audited
+	ScheduledAt          *time.Time `json:"scheduled_at"`           // Now when omitted
+	BatchSize            int        `json:"batch_size"`             // Defaults to CAMPAIGN_BATCH_SIZE
+	BatchIntervalSeconds int        `json:"batch_interval_seconds"` // Defaults to CAMPAIGN_BATCH_INTERVAL_SECONDS
+	DryRun               bool       `json:"dry_run"`                // Count the audience and render a sample without creating the campaign
+}
+
+// CreateCampaign schedules an announcement to every customer matching an audience, or previews it with dry_run
+// The campaigns job sends it in batches; progress is at GET /admin/campaigns/:id
+func CreateCampaign(db *gorm.DB, cfg campaigns.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The audience names its tenants, so it is matched on the unscoped handle
+		audienceDB := db
+		db := tenancy.DB(c, db)
+		var req campaignRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		role, _ := c.Get("user_role")
+		campaign := models.Campaign{
+			TenantID: tenancy.Current(c).ID,
+			Name:     req.Name,
+			Audience: models.CampaignAudience{
+				AccountType:    req.Audience.AccountType,
+				Tag:            req.Audience.Tag,
+				CustomerStatus: req.Audience.CustomerStatus,
+			},
+			Subject:              req.Subject,
+			Template:             req.Template,
+			Regulatory:           req.Regulatory,
+			ScheduledAt:          clock.Now(),
+			CreatedBy:            actor(c),
+			BatchSize:            req.BatchSize,
+			BatchIntervalSeconds: req.BatchIntervalSeconds,
+			Status:               campaigns.StatusScheduled,
+		}
+		campaign.CreatedRole, _ = role.(string)
+		if req.ScheduledAt != nil {
+			campaign.ScheduledAt = *req.ScheduledAt
+		}
+		if campaign.BatchSize == 0 {
+			campaign.BatchSize = cfg.BatchSize
+		}
+		if campaign.BatchIntervalSeconds == 0 {
+			campaign.BatchIntervalSeconds = int(cfg.BatchInterval / time.Second)
+		}
+		if err := campaigns.Validate(campaign); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tenantIDs, err := campaigns.Tenants(audienceDB, campaign.TenantID, tenancy.DefaultTenantID, req.Audience.Tenants)
+		switch {
+		case errors.Is(err, campaigns.ErrTenantScope):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, campaigns.ErrUnknownTenant):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve tenants"})
+			return
+		}
+		campaign.Audience.TenantIDs = tenantIDs
+
+		if req.DryRun {
+			preview, err := campaigns.DryRun(audienceDB, cfg, campaign)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to match customers"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"dry_run": true, "regulatory": campaign.Regulatory, "preview": preview})
+			return
+		}
+
+		if err := db.Create(&campaign).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create campaign"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Campaign scheduled", "campaign": campaign})
+	}
+}
+
+// GetCampaigns lists campaigns, newest first; ?status= narrows them
+func GetCampaigns(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 20)
+		var filter listFilter
+		if status := c.Query("status"); status != "" {
+			filter.where("status = ?", status)
+		}
+
+		var list []models.Campaign
+		total, err := filter.count(db, &models.Campaign{})
+		if err == nil {
+			err = filter.apply(db).Order("id DESC").Offset(offset).Limit(limit).Find(&list).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve campaigns"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"campaigns": list, "total": total, "page": page, "limit": limit})
+	}
+}
+
+// GetCampaign returns a campaign's progress with its per-customer statuses
+// ?status=failed narrows the recipients
+func GetCampaign(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var campaign models.Campaign
+		if err := db.First(&campaign, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+			return
+		}
+
+		page, limit, offset := parsePagination(c, 50)
+		var filter listFilter
+		filter.where("campaign_id = ?", campaign.ID)
+		if status := c.Query("status"); status != "" {
+			filter.where("status = ?", status)
+		}
+
+		var recipients []models.CampaignRecipient
+		total, err := filter.count(db, &models.CampaignRecipient{})
+		if err == nil {
+			err = filter.apply(db).Order("id").Offset(offset).Limit(limit).Find(&recipients).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve campaign"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"campaign":   campaign,
+			"recipients": recipients,
+			"total":      total,
+			"page":       page,
+			"limit":      limit,
+		})
+	}
+}
+
+// PauseCampaign stops a campaign queuing further batches; messages already queued are still delivered
+func PauseCampaign(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var campaign models.Campaign
+		if err := db.First(&campaign, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+			return
+		}
+		if !authorizeTransition(c, lifecycle.Campaign, campaign.Status, campaigns.StatusPaused, "") {
+			return
+		}
+		err := campaigns.Pause(db, &campaign, actor(c), clock.Now())
+		changeCampaign(c, err, campaign, "Campaign paused")
+	}
+}
+
+// ResumeCampaign sends a paused campaign's remaining batches, or returns it to its schedule if it had not started
+func ResumeCampaign(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var campaign models.Campaign
+		if err := db.First(&campaign, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+			return
+		}
+		if !authorizeTransition(c, lifecycle.Campaign, campaign.Status, campaigns.ResumeTo(campaign), "") {
+			return
+		}
+		err := campaigns.Resume(db, &campaign)
+		changeCampaign(c, err, campaign, "Campaign resumed")
+	}
+}
+
+// changeCampaign responds to a pause or resume
+func changeCampaign(c *gin.Context, err error, campaign models.Campaign, message string) {
+	switch {
+	case errors.Is(err, campaigns.ErrStatusChanged):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update campaign"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": message, "campaign": campaign})
+	}
+}
+
+// GetCampaignReport is a campaign's delivery report: recipients by status, the regulatory overrides and every
+// failed delivery with its error. It is final once the campaign is completed
+func GetCampaignReport(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var campaign models.Campaign
+		if err := db.First(&campaign, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+			return
+		}
+		counts, err := campaigns.Counts(db, campaign.ID)
+		var failures []models.CampaignRecipient
+		if err == nil {
+			err = db.Where("campaign_id = ? AND status = ?", campaign.ID, campaigns.RecipientFailed).Order("id").Find(&failures).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build campaign report"})
+			return
+		}
+		byStatus := gin.H{}
+		for _, status := range []string{campaigns.RecipientPending, campaigns.RecipientQueued, campaigns.RecipientSent,
+			campaigns.RecipientFailed, campaigns.RecipientSuppressed, campaigns.RecipientUnreachable} {
+			byStatus[status] = counts[status]
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"campaign_id":  campaign.ID,
+			"status":       campaign.Status,
+			"final":        campaign.Status == campaigns.StatusCompleted,
+			"regulatory":   campaign.Regulatory,
+			"total":        campaign.Total,
+			"by_status":    byStatus,
+			"overrides":    campaign.Overrides,
+			"failures":     failures,
+			"started_at":   campaign.StartedAt,
+			"completed_at": campaign.CompletedAt,
+		})
+	}
+}
+
+// Unsubscribe opts a customer out of announcements through the link in a campaign email - the token is the credential
+// Regulatory campaigns are still sent
+func Unsubscribe(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pref, err := campaigns.Unsubscribe(db, c.Param("token"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unsubscribe link not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "You will no longer receive announcements", "preference": pref})
+	}
+}
+
+// notificationPreferencesRequest sets whether a customer receives each optional category; omitted ones are kept
+type notificationPreferencesRequest struct {
+	Announcements *bool `json:"announcements"`
+}
+
+// GetNotificationPreferences returns whether a customer receives each optional message category
+// Regulatory notices are sent whatever the preferences
+func GetNotificationPreferences(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, ok := noteSubjectID(c, db, "customer")
+		if !ok {
+			return
+		}
+		receives, err := campaigns.Preferences(db, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve notification preferences"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"customer_id": id, "preferences": receives})
+	}
+}
+
+// UpdateNotificationPreferences opts a customer in or out of optional message categories
+func UpdateNotificationPreferences(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req notificationPreferencesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		var customer models.Customer
+		if err := db.Select("id, tenant_id").First(&customer, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+			return
+		}
+		if req.Announcements != nil {
+			_, err := campaigns.SetPreference(db, customer, campaigns.CategoryAnnouncements, !*req.Announcements, campaigns.SourcePreferences, nil)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences"})
+				return
+			}
+		}
+		receives, err := campaigns.Preferences(db, customer.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve notification preferences"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"customer_id": customer.ID, "preferences": receives})
+	}
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"banking-app/businessdays"
+	"banking-app/certificates"
+	"banking-app/clock"
+	"banking-app/communications"
+	"banking-app/events"
+	"banking-app/middleware"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== CERTIFICATE HANDLERS ====================
+
+// certificateRequest selects the figures stated on a balance certificate
+type certificateRequest struct {
+	AsOf              string `json:"as_of"`        // YYYY-MM-DD, defaults to today
+	AverageFrom       string `json:"average_from"` // YYYY-MM-DD, optional averaging period start
+	AverageTo         string `json:"average_to"`   // YYYY-MM-DD, inclusive
+	MaskAccountNumber bool   `json:"mask_account_number"`
+	Purpose           string `json:"purpose"`
+}
+
+// parseOptionalDate parses a YYYY-MM-DD value, leaving empty values unset
+func parseOptionalDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	date, err := businessdays.ParseDate(value)
+	if err != nil {
+		return nil, err
+	}
+	return &date, nil
+}
+
+// CreateBalanceCertificate issues a signed balance confirmation letter for an account
+// The figures are frozen in the stored certificate and the issue is recorded as an account event;
+// the letter is stored and added to the customer's communication log
+func CreateBalanceCertificate(db *gorm.DB, signer *certificates.Signer, storage communications.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+			return
+		}
+
+		var body certificateRequest
+		c.ShouldBindJSON(&body)
+		req := certificates.Request{Mask: body.MaskAccountNumber, Purpose: body.Purpose, IssuedBy: actor(c)}
+		var asOfErr, fromErr, toErr error
+		req.AsOf, asOfErr = parseOptionalDate(body.AsOf)
+		req.AverageFrom, fromErr = parseOptionalDate(body.AverageFrom)
+		req.AverageTo, toErr = parseOptionalDate(body.AverageTo)
+		if asOfErr != nil || fromErr != nil || toErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Dates must be YYYY-MM-DD"})
+			return
+		}
+		now := clock.Now()
+		if err := req.Validate(now); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var account models.Account
+		if err := db.Preload("Customer").First(&account, uint(id)).Error; err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		if account.AccountType == "internal" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Certificates cannot be issued for internal accounts"})
+			return
+		}
+
+		cert, err := certificates.Build(db, account, account.Customer, req, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute certificate balances"})
+			return
+		}
+		cert.VerificationCode = signer.Code(cert)
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&cert).Error; err != nil {
+				return err
+			}
+			var letter bytes.Buffer
+			if err := certificates.WritePDF(&letter, cert, tenancy.Current(c).Name, middleware.Locale(c)); err != nil {
+				return err
+			}
+			_, err := communications.Record(tx, storage, communications.Entry{
+				CustomerID:   cert.CustomerID,
+				Channel:      communications.ChannelLetter,
+				Subject:      "Balance certificate as of " + businessdays.Format(cert.AsOf),
+				Status:       communications.StatusGenerated,
+				ResourceType: communications.ResourceCertificate,
+				ResourceID:   cert.ID,
+				Content:      letter.Bytes(),
+				ContentType:  "application/pdf",
+			})
+			if err != nil {
+				return err
+			}
+			return events.Record(tx, events.AggregateAccount, account.ID, events.CertificateIssued, gin.H{
+				"certificate_id": cert.ID,
+				"account_id":     account.ID,
+				"as_of":          businessdays.Format(cert.AsOf),
+				"masked":         cert.Masked,
+				"purpose":        cert.Purpose,
+				"issued_by":      cert.IssuedBy,
+			})
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue certificate"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message":     "Certificate issued successfully",
+			"certificate": cert,
+		})
+	}
+}
+
+// GetBalanceCertificates lists the certificates issued for an account, newest first
+func GetBalanceCertificates(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var issued []models.BalanceCertificate
+		if err := db.Where("account_id = ?", c.Param("id")).Order("id DESC").Find(&issued).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve certificates"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"certificates": issued})
+	}
+}
+
+// GetBalanceCertificate returns one issued certificate; ?format=pdf renders the letter
+func GetBalanceCertificate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		format := c.DefaultQuery("format", "json")
+		if format != "json" && format != "pdf" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be json or pdf"})
+			return
+		}
+
+		var cert models.BalanceCertificate
+		if err := db.Where("account_id = ?", c.Param("id")).First(&cert, c.Param("certId")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+			return
+		}
+		if format == "json" {
+			c.JSON(http.StatusOK, gin.H{"certificate": cert})
+			return
+		}
+
+		c.Header("Content-Type", "application/pdf")
+		c.Header("Content-Disposition", fmt.Sprintf("inline; filename=\"certificate-%d.pdf\"", cert.ID))
+		c.Status(http.StatusOK)
+		certificates.WritePDF(c.Writer, cert, tenancy.Current(c).Name, middleware.Locale(c))
+	}
+}
+
+// VerifyCertificate lets a third party confirm a certificate by its printed code
+// Only validity and the issue date are disclosed; the lookup spans tenants because the
+// verifier has no tenant context, and codes are unique across the deployment
+func VerifyCertificate(db *gorm.DB, signer *certificates.Signer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		code := strings.ToUpper(strings.TrimSpace(c.Param("code")))
+
+		var cert models.BalanceCertificate
+		err := db.Where("verification_code = ?", code).First(&cert).Error
+		if err != nil || !signer.Valid(cert) {
+			c.JSON(http.StatusNotFound, gin.H{"valid": false})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"valid":     true,
+			"issued_at": businessdays.Format(cert.CreatedAt),
+		})
+	}
+}
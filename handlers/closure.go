@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"banking-app/cache"
+	"banking-app/clock"
+	"banking-app/creditlines"
+	"banking-app/flags"
+	"banking-app/garnishments"
+	"banking-app/interest"
+	"banking-app/ledger"
+	"banking-app/liens"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== ACCOUNT CLOSURE HANDLERS ====================
+
+// closeAccountRequest names where the remaining balance goes
+// Either destination_account_id or destination "cashier_check" is required when the balance is not zero
+type closeAccountRequest struct {
+	DestinationAccountID uint   `json:"destination_account_id"`
+	Destination          string `json:"destination"`
+	Reason               string `json:"reason"`
+}
+
+// CloseAccount sweeps the remaining balance out and closes the account in one transaction
+// Retrying a close returns the original closure record, so clients can safely resend
+func CloseAccount(db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+			return
+		}
+
+		var req closeAccountRequest
+		// The body is optional when closing a zero-balance account
+		if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if req.Destination != "" && req.Destination != ledger.CloseCashierCheck {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Destination must be cashier_check or a destination_account_id"})
+			return
+		}
+
+		var account models.Account
+		if err := db.First(&account, uint(id)).Error; err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+
+		respondExisting := func() bool {
+			closure, ok, err := ledger.ExistingClosure(db, account.ID)
+			if err != nil || !ok {
+				return false
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message":        "Account already closed",
+				"already_closed": true,
+				"closure":        closure,
+			})
+			return true
+		}
+		if respondExisting() {
+			return
+		}
+		if _, linked, _ := creditlines.Linked(db, account.ID); linked {
+			c.JSON(http.StatusConflict, gin.H{"error": "Account has an open line of credit; repay and close it first"})
+			return
+		}
+		if hold, err := liens.HoldOn(db, account.ID); err == nil && hold.Count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Account has active liens; satisfy or release them first"})
+			return
+		}
+		if open, err := garnishments.Open(db, account); err == nil && open > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Account is covered by an open garnishment order; satisfy it first"})
+			return
+		}
+
+		var closure models.AccountClosure
+		var touched []models.Account
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// Interest accrued this month is credited first, so the sweep pays it out with the balance
+			if _, err := interest.PostAccrued(tx, featureFlags, account, clock.Now()); err != nil {
+				return err
+			}
+			var err error
+			closure, touched, err = ledger.Close(tx, account.ID, ledger.CloseRequest{
+				DestinationAccountID: req.DestinationAccountID,
+				CashierCheck:         req.Destination == ledger.CloseCashierCheck,
+				ClosedBy:             actor(c),
+				Reason:               req.Reason,
+			}, featureFlags)
+			return err
+		})
+
+		switch err {
+		case nil:
+		case ledger.ErrNegativeBalance:
+			c.JSON(http.StatusConflict, gin.H{"error": "Account has a negative balance; settle it before closing"})
+			return
+		case ledger.ErrFundsHeld:
+			c.JSON(http.StatusConflict, gin.H{"error": "Account has funds on hold; decide its held debits and release or capture its authorizations first"})
+			return
+		case ledger.ErrSweepDestination:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A valid destination_account_id or destination cashier_check is required"})
+			return
+		case ledger.ErrSelfTransfer:
+			respondPostingError(c, err)
+			return
+		case ledger.ErrDestinationCurrency:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Destination account currency must match"})
+			return
+		default:
+			// A concurrent close of the same account wins the unique closure row
+			if respondExisting() {
+				return
+			}
+			if err == ledger.ErrAccountInactive {
+				c.JSON(http.StatusConflict, gin.H{"error": "Only active accounts can be closed, and the destination must be active"})
+				return
+			}
+			respondPostingError(c, err)
+			return
+		}
+
+		for _, changed := range touched {
+			balances.Set(cache.Entry(changed))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Account closed successfully",
+			"closure": closure,
+			"account": touched[0],
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/communications"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"banking-app/uploads"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== COMMUNICATION LOG HANDLERS ====================
+
+// findCommunication loads one entry of the customer in the path, answering 404 itself
+func findCommunication(c *gin.Context, db *gorm.DB) (models.CommunicationLog, bool) {
+	var entry models.CommunicationLog
+	err := db.Where("customer_id = ?", c.Param("id")).First(&entry, c.Param("commId")).Error
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Communication not found"})
+		return entry, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve communication"})
+		return entry, false
+	}
+	return entry, true
+}
+
+// GetCommunications lists what was sent or produced for a customer, newest first
+// Filters: ?channel=, ?status=, ?from= and ?to= (YYYY-MM-DD, to inclusive)
+func GetCommunications(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, ok := noteSubjectID(c, db, "customer")
+		if !ok {
+			return
+		}
+		page, limit, offset := parsePagination(c, 50)
+
+		var filter listFilter
+		filter.where("customer_id = ?", id)
+		if channel := c.Query("channel"); channel != "" {
+			filter.where("channel = ?", channel)
+		}
+		if status := c.Query("status"); status != "" {
+			filter.where("status = ?", status)
+		}
+		if raw := c.Query("from"); raw != "" {
+			from, err := businessdays.ParseDate(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+				return
+			}
+			filter.where("created_at >= ?", from)
+		}
+		if raw := c.Query("to"); raw != "" {
+			to, err := businessdays.ParseDate(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+				return
+			}
+			filter.where("created_at < ?", to.AddDate(0, 0, 1))
+		}
+
+		total, err := filter.count(db, &models.CommunicationLog{})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve communications"})
+			return
+		}
+		var entries []models.CommunicationLog
+		if err := filter.apply(db).Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&entries).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve communications"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"communications": entries, "total": total, "page": page, "limit": limit})
+	}
+}
+
+// GetCommunicationContent streams the stored body or document of one entry
+func GetCommunicationContent(db *gorm.DB, storage uploads.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		entry, ok := findCommunication(c, db)
+		if !ok {
+			return
+		}
+		content, err := storage.Get(entry.ContentKey)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Content is no longer in storage"})
+			return
+		}
+		defer content.Close()
+		c.Header("Content-Type", entry.ContentType)
+		c.Status(http.StatusOK)
+		io.Copy(c.Writer, content)
+	}
+}
+
+// RetryCommunication queues a failed send again; the entry itself is left as it is
+// The new attempt is logged as its own entry once the notification dispatcher delivers or gives up on it
+func RetryCommunication(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		entry, ok := findCommunication(c, db)
+		if !ok {
+			return
+		}
+		notification, err := communications.Retry(db, entry, clock.Now())
+		switch err {
+		case nil:
+		case communications.ErrNotFailed, communications.ErrNoRetry, communications.ErrRetried:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue the retry"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":         "Queued for delivery; the outcome will appear as a new entry",
+			"notification_id": notification.ID,
+			"communication":   entry,
+		})
+	}
+}
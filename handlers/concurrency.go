@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"banking-app/loadshed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== CONCURRENCY LIMIT HANDLERS ====================
+
+// GetConcurrency returns this instance's concurrency limits and the requests in flight
+func GetConcurrency(limiter *loadshed.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"limits":    limiter.Limits(),
+			"in_flight": limiter.InFlight(),
+		})
+	}
+}
+
+// UpdateConcurrency replaces this instance's concurrency limits until the next restart
+// Requests already running are unaffected; a lowered limit sheds new requests until enough finish
+func UpdateConcurrency(limiter *loadshed.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var limits loadshed.Limits
+		if err := c.ShouldBindJSON(&limits); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limits"})
+			return
+		}
+		if err := limiter.SetLimits(limits); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "Concurrency limits updated",
+			"limits":    limiter.Limits(),
+			"in_flight": limiter.InFlight(),
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"banking-app/clock"
+	"banking-app/consent"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== CONSENT HANDLERS ====================
+
+// consentRequest grants a third-party client read access to the customer's data
+type consentRequest struct {
+	GrantedTo string     `json:"granted_to" binding:"required"` // Client identifier
+	Scopes    string     `json:"scopes" binding:"required"`     // Comma-separated, e.g. accounts:read,transactions:read
+	ExpiresAt *time.Time `json:"expires_at"`                    // Defaults to, and may not exceed, 90 days from now
+}
+
+// consentView is a consent with its status at the time of the request
+type consentView struct {
+	models.Consent
+	Status string `json:"status"` // active, expired or revoked
+}
+
+// GetConsents lists a customer's consents, newest first; ?status=active narrows the list
+func GetConsents(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, ok := noteSubjectID(c, db, "customer")
+		if !ok {
+			return
+		}
+		var consents []models.Consent
+		if err := db.Where("customer_id = ?", id).Order("id DESC").Find(&consents).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve consents"})
+			return
+		}
+
+		now := clock.Now()
+		status := c.Query("status")
+		views := []consentView{}
+		for _, granted := range consents {
+			view := consentView{Consent: granted, Status: consent.Status(granted, now)}
+			if status == "" || status == view.Status {
+				views = append(views, view)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"consents": views, "total": len(views)})
+	}
+}
+
+// CreateConsent records a customer's consent for a client to read the data its scopes cover
+func CreateConsent(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, ok := noteSubjectID(c, db, "customer")
+		if !ok {
+			return
+		}
+		var req consentRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "granted_to and scopes are required"})
+			return
+		}
+		scopes, err := consent.ParseScopes(req.Scopes, consent.Scopes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		now := clock.Now()
+		expires := now.Add(consent.MaxDuration)
+		if req.ExpiresAt != nil {
+			if !req.ExpiresAt.After(now) || req.ExpiresAt.After(expires) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future and at most 90 days away"})
+				return
+			}
+			expires = *req.ExpiresAt
+		}
+
+		granted := models.Consent{
+			CustomerID: id,
+			GrantedTo:  strings.TrimSpace(req.GrantedTo),
+			Scopes:     scopes,
+			GrantedAt:  now,
+			GrantedBy:  actor(c),
+			ExpiresAt:  expires,
+		}
+		if err := db.Create(&granted).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record consent"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Consent granted",
+			"consent": consentView{Consent: granted, Status: consent.StatusActive},
+		})
+	}
+}
+
+// RevokeConsent ends a consent; the client's next request under it is refused
+func RevokeConsent(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var granted models.Consent
+		if err := db.Where("customer_id = ?", c.Param("id")).First(&granted, c.Param("consentId")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Consent not found"})
+			return
+		}
+		now := clock.Now()
+		if err := consent.Revoke(db, &granted, actor(c), now); err != nil {
+			if errors.Is(err, consent.ErrRevoked) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke consent"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Consent revoked",
+			"consent": consentView{Consent: granted, Status: consent.Status(granted, now)},
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"banking-app/cache"
+	"banking-app/clock"
+	"banking-app/conversions"
+	"banking-app/flags"
+	"banking-app/middleware"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== PRODUCT CONVERSION HANDLERS ====================
+
+// convertProductRequest moves an account to another product of the same category
+type convertProductRequest struct {
+	AccountType string                       `json:"account_type" binding:"required"` // Target product, by its account type
+	NextCycle   bool                         `json:"next_cycle"`                      // From the first day of the next statement cycle instead of now
+	Reason      string                       `json:"reason"`
+	Overrides   []eligibilityOverrideRequest `json:"overrides"` // Staff waivers of the target product's failed criteria
+}
+
+// ConvertAccountProduct converts an account to another product of its category, e.g. checking to premium checking
+// The customer must be eligible for the target product as for opening it. The account takes the product's limit,
+// restrictions, rates and fee schedules at once, or from the next statement cycle with next_cycle; the month's
+// maintenance fee is shared between the two products by days, and the holder is told by email
+func ConvertAccountProduct(db *gorm.DB, featureFlags *flags.Store, balances *cache.Balances) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req convertProductRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "account_type is required"})
+			return
+		}
+		var account models.Account
+		if err := db.First(&account, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		middleware.AuditCustomer(c, account.CustomerID)
+
+		target, err := conversions.Target(db, account, strings.TrimSpace(req.AccountType))
+		if err != nil {
+			respondConversionError(c, err)
+			return
+		}
+		var customer models.Customer
+		if err := db.First(&customer, account.CustomerID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert account"})
+			return
+		}
+		overrides, ok := checkEligibility(c, db, models.Account{AccountType: target.AccountType}, customer, req.Overrides)
+		if !ok {
+			return
+		}
+
+		var change models.ProductChange
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			change, err = conversions.Convert(tx, featureFlags, account, target, conversions.Request{
+				NextCycle:   req.NextCycle,
+				Reason:      req.Reason,
+				RequestedBy: actor(c),
+			}, clock.Now())
+			if err != nil {
+				return err
+			}
+			for i := range overrides {
+				overrides[i].AccountID = account.ID
+				if err := tx.Create(&overrides[i]).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			respondConversionError(c, err)
+			return
+		}
+		balances.Invalidate(account.ID)
+
+		status, message := http.StatusOK, "Account converted"
+		if change.Status == conversions.StatusScheduled {
+			status, message = http.StatusAccepted, "Product change scheduled for the next statement cycle"
+		}
+		response := gin.H{"message": message, "product_change": change}
+		if len(overrides) > 0 {
+			response["eligibility_overrides"] = overrides
+		}
+		c.JSON(status, response)
+	}
+}
+
+// respondConversionError maps a refused conversion to 400, 409 or 422, and anything else to 500
+func respondConversionError(c *gin.Context, err error) {
+	var incompatible *conversions.IncompatibleError
+	switch {
+	case errors.As(err, &incompatible):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "code": "INCOMPATIBLE_PRODUCT",
+			"from_category": incompatible.From, "to_category": incompatible.To})
+	case err == conversions.ErrReserved:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "RESERVED_ACCOUNT_TYPE"})
+	case err == conversions.ErrUnknown || err == conversions.ErrDeprecated || err == conversions.ErrSameProduct:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err == conversions.ErrClosed || err == conversions.ErrPending || err == conversions.ErrNotScheduled:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert account"})
+	}
+}
+
+// GetProductChanges lists an account's product changes, latest first
+func GetProductChanges(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var account models.Account
+		if err := db.First(&account, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		changes := []models.ProductChange{}
+		if err := db.Where("account_id = ?", account.ID).Order("id DESC").Find(&changes).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product changes"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"account_id": account.ID, "account_type": account.AccountType, "product_changes": changes})
+	}
+}
+
+// CancelProductChange withdraws a change scheduled for the next statement cycle
+func CancelProductChange(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var change models.ProductChange
+		if err := db.Where("account_id = ?", c.Param("id")).First(&change, c.Param("changeId")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product change not found"})
+			return
+		}
+		if err := conversions.Cancel(db, &change, "Cancelled by "+actor(c)); err != nil {
+			respondConversionError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Product change cancelled", "product_change": change})
+	}
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"banking-app/cache"
+	"banking-app/clock"
+	"banking-app/creditbureau"
+	"banking-app/creditlines"
+	"banking-app/events"
+	"banking-app/loans"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== LINE OF CREDIT HANDLERS ====================
+
+// generateLineNumber returns a unique line of credit number; the backing loan shares it
+func generateLineNumber() string {
+	return "LOC" + time.Now().Format("20060102150405") + strconv.Itoa(int(time.Now().UnixNano()%1000))
+}
+
+// creditLineRequest applies for a line on a checking account
+type creditLineRequest struct {
+	LinkedAccountID uint    `json:"linked_account_id" binding:"required"`
+	CreditLimit     float64 `json:"credit_limit"`
+	InterestRate    float64 `json:"interest_rate"` // Annual, e.g. 0.18
+}
+
+// creditLineResponse is a line with its current utilization
+type creditLineResponse struct {
+	models.CreditLine
+	creditlines.Utilization
+}
+
+// respondCreditLineError maps line errors to client responses
+func respondCreditLineError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, creditlines.ErrInvalidTerms), errors.Is(err, creditlines.ErrLinkedAccount):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, creditlines.ErrAlreadyLinked), errors.Is(err, creditlines.ErrNotPending),
+		errors.Is(err, creditlines.ErrNotActive), errors.Is(err, creditlines.ErrOutstanding):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, loans.ErrUnconfirmedGuarantor):
+		respondLoanPartyError(c, err)
+	default:
+		respondPostingError(c, err)
+	}
+}
+
+// loadCreditLine fetches the line named by :id and its utilization, responding on failure
+func loadCreditLine(c *gin.Context, db *gorm.DB) (creditLineResponse, bool) {
+	var resp creditLineResponse
+	if err := db.First(&resp.CreditLine, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Line of credit not found"})
+		return resp, false
+	}
+	usage, err := creditlines.Usage(db, resp.CreditLine)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve line of credit"})
+		return resp, false
+	}
+	resp.Utilization = usage
+	return resp, true
+}
+
+// CreateCreditLine applies for a line of credit on a checking account
+// The line starts pending; co-borrowers and guarantors are added through its backing loan's parties
+func CreateCreditLine(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req creditLineRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		var account models.Account
+		if err := db.First(&account, req.LinkedAccountID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+
+		var line models.CreditLine
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			line, err = creditlines.Apply(tx, account, req.CreditLimit, req.InterestRate, generateLineNumber(), actor(c))
+			return err
+		})
+		if err != nil {
+			respondCreditLineError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Line of credit created successfully", "credit_line": line})
+	}
+}
+
+// GetCreditLine returns a line with its drawn amount, available credit and accrued interest
+func GetCreditLine(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		resp, ok := loadCreditLine(c, db)
+		if !ok {
+			return
+		}
+		respondDisplay(c, http.StatusOK, resp)
+	}
+}
+
+// ActivateCreditLine approves and opens a pending line, as disbursement does for a loan
+// Every guarantor must have confirmed, every party's share must pass the debt-to-income check and the
+// borrower's credit must pass review
+func ActivateCreditLine(db *gorm.DB, maxDebtToIncome float64, reviewer *creditbureau.Reviewer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var line models.CreditLine
+		if err := db.First(&line, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Line of credit not found"})
+			return
+		}
+		if line.Status == creditlines.StatusDeclined {
+			c.JSON(http.StatusConflict, gin.H{"error": "Line of credit application was declined", "code": "CREDIT_DECLINED"})
+			return
+		}
+		if line.Status != creditlines.StatusPending {
+			respondCreditLineError(c, creditlines.ErrNotPending)
+			return
+		}
+		var loan models.Loan
+		var parties []models.LoanParty
+		err := db.First(&loan, line.LoanID).Error
+		if err == nil {
+			err = db.Where("loan_id = ?", loan.ID).Order("id").Find(&parties).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve loan parties"})
+			return
+		}
+		if err := loans.CheckAffordability(db, loan, parties, maxDebtToIncome); err != nil {
+			respondLoanPartyError(c, err)
+			return
+		}
+		if !creditReview(c, db, reviewer, &loan) {
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := creditlines.Activate(tx, &line, generateAccountNumber(), actor(c), clock.Now()); err != nil {
+				return err
+			}
+			return events.Record(tx, events.AggregateLoan, loan.ID, events.LoanDisbursed, gin.H{
+				"loan_id":      loan.ID,
+				"loan_number":  loan.LoanNumber,
+				"credit_limit": line.CreditLimit,
+				"account_id":   line.AccountID,
+				"parties":      len(parties),
+			})
+		})
+		if err != nil {
+			respondCreditLineError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Line of credit opened successfully", "credit_line": line})
+	}
+}
+
+// CloseCreditLine closes a fully repaid line and its account
+func CloseCreditLine(db *gorm.DB, balances *cache.Balances) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var line models.CreditLine
+		if err := db.First(&line, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Line of credit not found"})
+			return
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return creditlines.Close(tx, &line, actor(c), clock.Now())
+		})
+		if err != nil {
+			respondCreditLineError(c, err)
+			return
+		}
+		balances.Invalidate(line.AccountID)
+		c.JSON(http.StatusOK, gin.H{"message": "Line of credit closed successfully", "credit_line": line})
+	}
+}
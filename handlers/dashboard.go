@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"banking-app/cache"
+	"banking-app/clock"
+	"banking-app/dashboard"
+	"banking-app/jobs"
+	"banking-app/slowquery"
+	"banking-app/tenancy"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== DASHBOARD HANDLERS ====================
+
+// dashboardMaxAge is how long clients may reuse a dashboard summary; main caches summaries server-side for as long
+const dashboardMaxAge = 30
+
+// GetDashboard returns today's headline numbers in one response, computed at most once per tenant per cache period
+// The platform tenant also sees job, webhook and connection pool state, which is shared by every tenant
+func GetDashboard(db *gorm.DB, scheduler *jobs.Scheduler, summaries *cache.Queries) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant := tenancy.Current(c)
+		key := strconv.FormatUint(uint64(tenant.ID), 10)
+
+		var summary dashboard.Summary
+		if cached, ok := summaries.Get(key); ok {
+			summary = cached.(dashboard.Summary)
+			summary.Cached = true
+		} else {
+			ctx, count := slowquery.Counting(c.Request.Context())
+			c.Request = c.Request.WithContext(ctx)
+			var err error
+			summary, err = dashboard.Build(tenancy.DB(c, db), scheduler, tenant.ID == tenancy.DefaultTenantID, tenant.ID, clock.Now())
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build dashboard"})
+				return
+			}
+			summary.QueryCount = count()
+			summaries.Set(key, summary)
+		}
+
+		if notModified(c, weakETag(key, summary.Version, summary.GeneratedAt.UnixNano()), dashboardMaxAge) {
+			return
+		}
+		c.JSON(http.StatusOK, summary)
+	}
+}
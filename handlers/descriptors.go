@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"banking-app/creditlines"
+	"banking-app/descriptors"
+	"banking-app/gl"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== STATEMENT DESCRIPTOR HANDLERS ====================
+
+// descriptorTemplateRequest is a template as admins send it
+type descriptorTemplateRequest struct {
+	AccountType string `json:"account_type"` // Empty for every product
+	Kind        string `json:"kind" binding:"required"`
+	Template    string `json:"template" binding:"required"`
+}
+
+// checkDescriptorTemplate validates a template and its product, responding when it fails
+// Loan and credit line accounts are opened through their own APIs but still get statements, so their
+// types are accepted here; internal ledger accounts have no statements to describe
+func checkDescriptorTemplate(c *gin.Context, db *gorm.DB, t *models.DescriptorTemplate) bool {
+	t.Template = strings.TrimSpace(t.Template)
+	if err := descriptors.Validate(t.Kind, t.Template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "INVALID_DESCRIPTOR_TEMPLATE"})
+		return false
+	}
+	switch t.AccountType {
+	case "", "loan", creditlines.AccountType:
+		return true
+	case gl.AccountType:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Internal accounts have no statements to describe", "code": "INVALID_DESCRIPTOR_TEMPLATE"})
+		return false
+	}
+	return checkAccountType(c, db, t.AccountType)
+}
+
+// descriptorTemplateTaken responds 409 when another template already covers the same product and kind
+func descriptorTemplateTaken(c *gin.Context, db *gorm.DB, t models.DescriptorTemplate) bool {
+	var count int64
+	err := db.Model(&models.DescriptorTemplate{}).
+		Where("account_type = ? AND kind = ? AND id <> ?", t.AccountType, t.Kind, t.ID).Count(&count).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check descriptor templates"})
+		return true
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A template for this product and kind already exists", "code": "DESCRIPTOR_TEMPLATE_EXISTS"})
+		return true
+	}
+	return false
+}
+
+// GetDescriptorTemplates lists the tenant's templates with the built-in defaults and the variables each kind can use
+// ?account_type= and ?kind= filter the tenant's templates
+func GetDescriptorTemplates(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var filter listFilter
+		for _, field := range []string{"account_type", "kind"} {
+			if value := c.Query(field); value != "" {
+				filter.where(field+" = ?", value)
+			}
+		}
+		var templates []models.DescriptorTemplate
+		if err := filter.apply(db).Order("kind, account_type").Find(&templates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve descriptor templates"})
+			return
+		}
+
+		kinds := []gin.H{}
+		for _, kind := range descriptors.Kinds() {
+			kinds = append(kinds, gin.H{"kind": kind, "default": descriptors.Defaults[kind], "variables": descriptors.Variables(kind)})
+		}
+		c.JSON(http.StatusOK, gin.H{"templates": templates, "kinds": kinds, "max_length": descriptors.MaxLength})
+	}
+}
+
+// CreateDescriptorTemplate adds a template; descriptors already posted keep their text until the backfill runs
+// Body: {"account_type": "savings", "kind": "transfer_out", "template": "TO {counterparty_name} {counterparty_account}"}
+func CreateDescriptorTemplate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req descriptorTemplateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "kind and template are required"})
+			return
+		}
+		template := models.DescriptorTemplate{AccountType: req.AccountType, Kind: req.Kind, Template: req.Template, CreatedBy: actor(c)}
+		if !checkDescriptorTemplate(c, db, &template) || descriptorTemplateTaken(c, db, template) {
+			return
+		}
+		if err := db.Create(&template).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create descriptor template"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Descriptor template created", "template": template})
+	}
+}
+
+// UpdateDescriptorTemplate replaces a template's product, kind and text
+func UpdateDescriptorTemplate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var template models.DescriptorTemplate
+		if err := db.First(&template, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Descriptor template not found"})
+			return
+		}
+		var req descriptorTemplateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "kind and template are required"})
+			return
+		}
+		template.AccountType, template.Kind, template.Template = req.AccountType, req.Kind, req.Template
+		if !checkDescriptorTemplate(c, db, &template) || descriptorTemplateTaken(c, db, template) {
+			return
+		}
+		if err := db.Save(&template).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update descriptor template"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Descriptor template updated", "template": template})
+	}
+}
+
+// DeleteDescriptorTemplate removes a template; its kind falls back to the catch-all template or the default
+func DeleteDescriptorTemplate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var template models.DescriptorTemplate
+		if err := db.First(&template, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Descriptor template not found"})
+			return
+		}
+		if err := db.Delete(&template).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete descriptor template"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Descriptor template deleted"})
+	}
+}
+
+// BackfillDescriptors generates descriptors for the tenant's postings that have none
+// ?all=true regenerates every descriptor, so a changed template reaches historical postings
+func BackfillDescriptors(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		updated, err := descriptors.Backfill(db, c.Query("all") == "true")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to regenerate descriptors"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Descriptors regenerated", "updated": updated})
+	}
+}
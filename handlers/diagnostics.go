@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"banking-app/database"
+	"banking-app/slowquery"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== DIAGNOSTIC HANDLERS ====================
+
+// GetQueryPlans reports whether the canonical hot-path queries use indexes
+// Lets operators confirm index coverage without shell access to the database
+func GetQueryPlans(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		plans, err := database.ExplainCanonicalQueries(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to explain queries"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"plans": plans})
+	}
+}
+
+// GetSlowQueries lists the statements that ran longer than the slow query threshold, worst first
+// Grouped by route and normalized SQL over the last ?window_minutes= (default 60), at most ?limit= (default 20)
+func GetSlowQueries(recorder *slowquery.Recorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		window, err := strconv.Atoi(c.DefaultQuery("window_minutes", "60"))
+		if err != nil || window <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "window_minutes must be a positive number"})
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+
+		cfg := recorder.Config()
+		offenders := recorder.Worst(time.Now().Add(-time.Duration(window)*time.Minute), limit)
+		c.JSON(http.StatusOK, gin.H{
+			"threshold_ms":   cfg.Threshold.Milliseconds(),
+			"explain":        cfg.Explain,
+			"window_minutes": window,
+			"queries":        offenders,
+			"total":          len(offenders),
+		})
+	}
+}
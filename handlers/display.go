@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"banking-app/auth"
+	"banking-app/display"
+	"banking-app/middleware"
+	"banking-app/tenancy"
+	"banking-app/usage"
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// streamBatchSize is how many rows a streamed list reads, renders and flushes at a time
+const streamBatchSize = 500
+
+// displayOptions decides how account numbers and amounts are shown to the caller
+// Account numbers are masked unless a caller holding the reveal permission asks for ?reveal=true;
+// honored reveals are written to the audit log. Customers and anonymous callers always see masked numbers
+func displayOptions(c *gin.Context) display.Options {
+	opts := display.Options{Mask: true, Amounts: true, Currency: tenancy.CurrentSettings(c).DefaultCurrency}
+	if c.Query("reveal") == "true" {
+		role, _ := c.Get("user_role")
+		roleName, _ := role.(string)
+		if auth.Can(roleName, auth.PermReveal) {
+			opts.Mask = false
+			middleware.AuditRequest(c)
+		}
+	}
+	return opts
+}
+
+// respondDisplay writes body as JSON with account numbers masked and display amounts added for the caller
+func respondDisplay(c *gin.Context, status int, body interface{}) {
+	respondDisplayWith(c, status, body, displayOptions(c))
+}
+
+// respondDisplayWith writes body with explicit display options, e.g. in a known account's currency
+func respondDisplayWith(c *gin.Context, status int, body interface{}, opts display.Options) {
+	tree, err := display.Apply(body, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render response"})
+		return
+	}
+	c.JSON(status, tree)
+}
+
+// streamDisplayList writes a JSON object of fields followed by a key array holding every row of query, with the
+// same display rules as respondDisplayWith. Rows are read through a cursor into values from newRow and written
+// in batches, so a long list never sits in memory whole. Once the first batch is sent an error can only cut the
+// body short, which leaves it unparseable rather than silently incomplete
+func streamDisplayList(c *gin.Context, query *gorm.DB, newRow func() interface{}, fields gin.H, key string, opts display.Options) {
+	rows, err := query.Rows()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve " + key})
+		return
+	}
+	defer rows.Close()
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	head := []byte{'{'}
+	for _, name := range append(names, key) {
+		quoted, _ := json.Marshal(name)
+		head = append(append(head, quoted...), ':')
+		if name == key {
+			break
+		}
+		value, err := json.Marshal(fields[name])
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render response"})
+			return
+		}
+		head = append(append(head, value...), ',')
+	}
+	head = append(head, '[')
+
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+	if _, err := c.Writer.Write(head); err != nil {
+		return
+	}
+
+	written := 0
+	defer func() { usage.Rows(c, written) }()
+	batch := make([]interface{}, 0, streamBatchSize)
+	send := func() bool {
+		tree, err := display.Apply(batch, opts)
+		if err != nil {
+			return false
+		}
+		for _, item := range tree.([]interface{}) {
+			out, err := json.Marshal(item)
+			if err != nil {
+				return false
+			}
+			if written > 0 {
+				out = append([]byte{','}, out...)
+			}
+			if _, err := c.Writer.Write(out); err != nil {
+				return false
+			}
+			written++
+		}
+		batch = batch[:0]
+		return true
+	}
+	for rows.Next() {
+		row := newRow()
+		if err := query.ScanRows(rows, row); err != nil {
+			return
+		}
+		batch = append(batch, row)
+		if len(batch) == streamBatchSize {
+			if !send() || c.Request.Context().Err() != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+	if rows.Err() != nil || !send() {
+		return
+	}
+	c.Writer.Write([]byte("]}"))
+}
@@ -0,0 +1,498 @@
+package handlers
+
+import (
+	"banking-app/auth"
+	"banking-app/clock"
+	"banking-app/disputes"
+	"banking-app/lifecycle"
+	"banking-app/middleware"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"banking-app/uploads"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== DISPUTE HANDLERS ====================
+
+// Dispute statuses, as declared in the dispute lifecycle
+const (
+	disputeOpen     = disputes.StatusOpen
+	disputeResolved = disputes.StatusResolved
+)
+
+// disputeThreadPageSize is how many thread posts GetDispute returns by default
+const disputeThreadPageSize = 50
+
+var errDisputeOpen = errors.New("transaction already has an open dispute")
+
+// openDisputeRequest is the body for opening a dispute
+type openDisputeRequest struct {
+	Reason string `json:"reason"`
+}
+
+// resolveDisputeRequest is the body for resolving a dispute
+type resolveDisputeRequest struct {
+	Resolution string `json:"resolution"`
+}
+
+// assignDisputeRequest is the body for assigning a dispute
+type assignDisputeRequest struct {
+	AssignedTo string `json:"assigned_to"`
+}
+
+// disputeMessageRequest is the body for posting to a dispute's thread
+type disputeMessageRequest struct {
+	Body               string `json:"body"`
+	RequestInformation bool   `json:"request_information"` // Staff only: the customer is asked to reply
+}
+
+// markDisputeReadRequest is the body for marking a dispute's thread read
+type markDisputeReadRequest struct {
+	ThroughMessageID uint `json:"through_message_id"` // Defaults to every post
+}
+
+// OpenDispute opens a dispute on the :id transaction. Customers dispute postings on their own accounts; staff with
+// the disputes permission open one on the holder's behalf
+// Body: {"reason": "..."}; the reason is required
+func OpenDispute(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		customerID, isCustomer := applicant(c, db)
+		if !isCustomer && !auth.Can(c.GetString("user_role"), auth.PermDisputes) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			return
+		}
+		var req openDisputeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if strings.TrimSpace(req.Reason) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required to open a dispute"})
+			return
+		}
+
+		var t models.Transaction
+		var account models.Account
+		err := db.First(&t, c.Param("id")).Error
+		if err == nil {
+			err = db.First(&account, t.AccountID).Error
+		}
+		if err == nil && isCustomer && account.CustomerID != customerID {
+			err = gorm.ErrRecordNotFound
+		}
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		middleware.AuditCustomer(c, account.CustomerID)
+
+		dispute := models.Dispute{
+			Status:        disputeOpen,
+			TransactionID: t.ID,
+			AccountID:     account.ID,
+			CustomerID:    account.CustomerID,
+			Amount:        t.Amount,
+			Reason:        strings.TrimSpace(req.Reason),
+			OpenedBy:      actor(c),
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var open int64
+			if err := tx.Model(&models.Dispute{}).Where("transaction_id = ? AND status = ?", t.ID, disputeOpen).Count(&open).Error; err != nil {
+				return err
+			}
+			if open > 0 {
+				return errDisputeOpen
+			}
+			if err := lifecycle.Check(lifecycle.Dispute, "", dispute.Status); err != nil {
+				return err
+			}
+			return tx.Create(&dispute).Error
+		})
+		if errors.Is(err, errDisputeOpen) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Transaction already has an open dispute", "code": "DISPUTE_OPEN"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open dispute"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Dispute opened", "dispute": dispute})
+	}
+}
+
+// GetDispute returns one dispute with the transaction it challenges; customers only find their own
+// The customer and the assigned staff member also get its thread, oldest post first and paginated with ?page= and
+// ?limit=, how many of the other side's posts they have not read, and the evidence attached to it
+func GetDispute(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		dispute, ok := loadDispute(c, db)
+		if !ok {
+			return
+		}
+		var t models.Transaction
+		if err := db.First(&t, dispute.TransactionID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve dispute"})
+			return
+		}
+		side, inThread := threadSide(c, db, dispute)
+		if !inThread {
+			c.JSON(http.StatusOK, gin.H{"dispute": dispute, "transaction": t})
+			return
+		}
+
+		page, limit, offset := parsePagination(c, disputeThreadPageSize)
+		var messages []models.DisputeMessage
+		var attachments []models.DisputeAttachment
+		var thread listFilter
+		thread.where("dispute_id = ?", dispute.ID)
+		total, err := thread.count(db, &models.DisputeMessage{})
+		if err == nil {
+			err = thread.apply(db).Order("id").Limit(limit).Offset(offset).Find(&messages).Error
+		}
+		if err == nil {
+			err = db.Where("dispute_id = ?", dispute.ID).Order("id").Find(&attachments).Error
+		}
+		unread, unreadErr := disputes.Unread(db, dispute, side)
+		if err != nil || unreadErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve dispute"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"dispute":     dispute,
+			"transaction": t,
+			"thread": gin.H{
+				"messages": messages,
+				"total":    total,
+				"page":     page,
+				"limit":    limit,
+				"unread":   unread,
+			},
+			"attachments": attachments,
+		})
+	}
+}
+
+// AssignDispute hands an open dispute to a staff member with the disputes permission, who then works its thread
+// with the customer. Reassigning moves the thread to the new assignee
+// Body: {"assigned_to": "username"}
+func AssignDispute(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req assignDisputeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		dispute, ok := loadDispute(c, db)
+		if !ok {
+			return
+		}
+		err := disputes.Assign(db, &dispute, strings.TrimSpace(req.AssignedTo))
+		switch {
+		case errors.Is(err, disputes.ErrResolved):
+			c.JSON(http.StatusConflict, gin.H{"error": "Dispute has already been resolved", "code": "DISPUTE_RESOLVED"})
+			return
+		case errors.Is(err, disputes.ErrAssignee):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "INVALID_ASSIGNEE"})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign dispute"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Dispute assigned", "dispute": dispute})
+	}
+}
+
+// PostDisputeMessage adds the customer's or the assigned staff member's post to an open dispute's thread, and
+// notifies the other side
+// Body: {"body": "...", "request_information": false}; staff set request_information to ask the customer to reply,
+// who is warned the dispute may close if they do not
+func PostDisputeMessage(db *gorm.DB, cfg disputes.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req disputeMessageRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		dispute, ok := loadDispute(c, db)
+		if !ok {
+			return
+		}
+		side, ok := threadParticipant(c, db, dispute)
+		if !ok {
+			return
+		}
+		var message models.DisputeMessage
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			message, err = disputes.Post(tx, cfg, &dispute, side, actor(c), req.Body, req.RequestInformation)
+			return err
+		})
+		if err != nil {
+			disputeThreadError(c, err, "Failed to post message")
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Message posted", "dispute_message": message})
+	}
+}
+
+// UploadDisputeEvidence stores a JPEG, PNG or PDF of evidence for an open dispute, validated and scanned like
+// customer documents, and posts it to the thread with the multipart "message" field or a note naming the file
+// Multipart form: file (required), message (optional)
+func UploadDisputeEvidence(db *gorm.DB, pipeline *uploads.Pipeline, cfg disputes.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		dispute, ok := loadDispute(c, db)
+		if !ok {
+			return
+		}
+		side, ok := threadParticipant(c, db, dispute)
+		if !ok {
+			return
+		}
+		if dispute.Status != disputeOpen {
+			c.JSON(http.StatusConflict, gin.H{"error": "Dispute has already been resolved", "code": "DISPUTE_RESOLVED"})
+			return
+		}
+
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A file is required"})
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+			return
+		}
+		defer file.Close()
+		data, err := io.ReadAll(io.LimitReader(file, uploads.MaxSize()+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+			return
+		}
+
+		prefix := uploads.Prefix("disputes", dispute.TenantID, dispute.CustomerID, clock.Now())
+		var result uploads.File
+		err = uploads.ErrTooLarge
+		if int64(len(data)) <= uploads.MaxSize() {
+			result, err = pipeline.Process(c.Request.Context(), prefix, uploads.KindDisputeEvidence, header.Header.Get("Content-Type"), data)
+		}
+		if err != nil {
+			rejected := models.Document{CustomerID: dispute.CustomerID, Kind: uploads.KindDisputeEvidence, Filename: header.Filename, UploadedBy: actor(c)}
+			rejectUpload(c, db, rejected, result, int64(len(data)), err)
+			return
+		}
+
+		body := c.PostForm("message")
+		if strings.TrimSpace(body) == "" {
+			body = "Attached " + header.Filename
+		}
+		attachment := models.DisputeAttachment{
+			DisputeID:    dispute.ID,
+			Filename:     header.Filename,
+			ContentType:  result.ContentType,
+			Size:         result.Size,
+			SHA256:       result.SHA256,
+			StorageKey:   result.Key,
+			UploaderType: side,
+			UploadedBy:   actor(c),
+		}
+		var message models.DisputeMessage
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if message, err = disputes.Post(tx, cfg, &dispute, side, actor(c), body, false); err != nil {
+				return err
+			}
+			attachment.MessageID = message.ID
+			return tx.Create(&attachment).Error
+		})
+		if err != nil {
+			// Identical bytes share a key, so only remove the file when no document or other evidence uses it
+			var documents, attachments int64
+			db.Model(&models.Document{}).Where("storage_key = ?", result.Key).Count(&documents)
+			db.Model(&models.DisputeAttachment{}).Where("storage_key = ?", result.Key).Count(&attachments)
+			if documents == 0 && attachments == 0 {
+				pipeline.Storage.Delete(result.Key)
+			}
+			disputeThreadError(c, err, "Failed to save evidence")
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Evidence uploaded", "dispute_message": message, "attachment": attachment})
+	}
+}
+
+// GetDisputeAttachment streams one piece of a dispute's evidence to the customer or the assigned staff member
+func GetDisputeAttachment(db *gorm.DB, pipeline *uploads.Pipeline) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		dispute, ok := loadDispute(c, db)
+		if !ok {
+			return
+		}
+		if _, ok := threadParticipant(c, db, dispute); !ok {
+			return
+		}
+		var attachment models.DisputeAttachment
+		if err := db.Where("dispute_id = ?", dispute.ID).First(&attachment, c.Param("attachmentId")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+			return
+		}
+		file, err := pipeline.Storage.Get(attachment.StorageKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read attachment"})
+			return
+		}
+		defer file.Close()
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", attachment.Filename))
+		c.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, file, nil)
+	}
+}
+
+// MarkDisputeRead marks the other side's posts in a dispute's thread read, through the given post or all of them
+// Body (optional): {"through_message_id": 42}
+func MarkDisputeRead(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req markDisputeReadRequest
+		if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		dispute, ok := loadDispute(c, db)
+		if !ok {
+			return
+		}
+		side, ok := threadParticipant(c, db, dispute)
+		if !ok {
+			return
+		}
+		marked, err := disputes.MarkRead(db, dispute, side, actor(c), req.ThroughMessageID)
+		var unread int64
+		if err == nil {
+			unread, err = disputes.Unread(db, dispute, side)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark messages read"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Messages marked read", "marked": marked, "unread": unread})
+	}
+}
+
+// ResolveDispute records the outcome of an open dispute
+// Body: {"resolution": "..."}; the resolution is required
+func ResolveDispute(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req resolveDisputeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		dispute, ok := loadDispute(c, db)
+		if !ok {
+			return
+		}
+		if dispute.Status != disputeOpen {
+			c.JSON(http.StatusConflict, gin.H{"error": "Dispute has already been resolved", "code": "DISPUTE_RESOLVED"})
+			return
+		}
+		if !authorizeTransition(c, lifecycle.Dispute, dispute.Status, disputeResolved, req.Resolution) {
+			return
+		}
+
+		now := clock.Now()
+		updates := map[string]interface{}{
+			"status":      disputeResolved,
+			"resolved_at": now,
+			"resolved_by": actor(c),
+			"resolution":  strings.TrimSpace(req.Resolution),
+		}
+		// Only an open dispute is resolved, so two staff deciding it at once cannot both win
+		result := db.Model(&models.Dispute{}).Where("id = ? AND status = ?", dispute.ID, disputeOpen).Updates(updates)
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve dispute"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Dispute has already been resolved", "code": "DISPUTE_RESOLVED"})
+			return
+		}
+		db.First(&dispute, dispute.ID)
+		c.JSON(http.StatusOK, gin.H{"message": "Dispute resolved", "dispute": dispute})
+	}
+}
+
+// loadDispute finds the :id dispute for a customer holding it or staff with the disputes permission, responding
+// when it is out of reach
+func loadDispute(c *gin.Context, db *gorm.DB) (models.Dispute, bool) {
+	var dispute models.Dispute
+	customerID, isCustomer := applicant(c, db)
+	if !isCustomer && !auth.Can(c.GetString("user_role"), auth.PermDisputes) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return dispute, false
+	}
+	err := db.First(&dispute, c.Param("id")).Error
+	if err == nil && isCustomer && dispute.CustomerID != customerID {
+		err = gorm.ErrRecordNotFound
+	}
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dispute not found"})
+		return dispute, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return dispute, false
+	}
+	middleware.AuditCustomer(c, dispute.CustomerID)
+	return dispute, true
+}
+
+// threadSide reports which side of a dispute's thread the caller is on: the customer holding it, or the staff
+// member it is assigned to. Other staff who can reach the dispute are not in its thread
+func threadSide(c *gin.Context, db *gorm.DB, dispute models.Dispute) (string, bool) {
+	if _, isCustomer := applicant(c, db); isCustomer {
+		return disputes.AuthorCustomer, true
+	}
+	if dispute.AssignedTo != "" && dispute.AssignedTo == actor(c) {
+		return disputes.AuthorStaff, true
+	}
+	return "", false
+}
+
+// threadParticipant is threadSide for the thread's own endpoints, responding when the caller is not in it
+func threadParticipant(c *gin.Context, db *gorm.DB, dispute models.Dispute) (string, bool) {
+	side, ok := threadSide(c, db, dispute)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the customer and the staff member assigned the dispute take part in its thread", "code": "DISPUTE_NOT_ASSIGNED"})
+	}
+	return side, ok
+}
+
+// disputeThreadError responds to a failed post to a dispute's thread
+func disputeThreadError(c *gin.Context, err error, failure string) {
+	switch {
+	case errors.Is(err, disputes.ErrResolved):
+		c.JSON(http.StatusConflict, gin.H{"error": "Dispute has already been resolved", "code": "DISPUTE_RESOLVED"})
+	case errors.Is(err, disputes.ErrMessageRequired), errors.Is(err, disputes.ErrMessageTooLong), errors.Is(err, disputes.ErrStaffOnly):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "INVALID_MESSAGE"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": failure})
+	}
+}
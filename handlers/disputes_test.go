@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"banking-app/disputes"
+	"banking-app/models"
+	"banking-app/uploads"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// disputeUser creates a signed-in user for the dispute tests; customerID is set for customer-role users
+func disputeUser(t *testing.T, db *gorm.DB, username, role string, customerID uint) models.User {
+	t.Helper()
+	user := models.User{Username: username, PasswordHash: "x", Role: role, Status: "active"}
+	if customerID != 0 {
+		user.CustomerID = &customerID
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// evidence builds a multipart upload of a small PDF with a message
+func evidence(message string) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("message", message)
+	part, _ := form.CreateFormFile("file", "receipt.pdf")
+	part.Write([]byte("%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj\n%%EOF\n"))
+	form.Close()
+	return &body, form.FormDataContentType()
+}
+
+func TestDisputeThreadAccess(t *testing.T) {
+	db := testDB(t)
+	var customers [2]models.Customer
+	for i, name := range []string{"Holder", "Other"} {
+		customers[i] = models.Customer{FirstName: name, LastName: "Disputer", Email: name + "@example.test", DateOfBirth: "1980-01-01", Status: "active"}
+		if err := db.Create(&customers[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	account := models.Account{CustomerID: customers[0].ID, AccountNumber: "DISPUTE-1", AccountType: "checking", Currency: "USD", Status: "active"}
+	if err := db.Create(&account).Error; err != nil {
+		t.Fatal(err)
+	}
+	payment := models.Transaction{TransactionID: "TXNDISPUTE1", AccountID: account.ID, TransactionType: "payment", Amount: 79.99}
+	if err := db.Create(&payment).Error; err != nil {
+		t.Fatal(err)
+	}
+	dispute := models.Dispute{Status: disputes.StatusOpen, TransactionID: payment.ID, AccountID: account.ID, CustomerID: customers[0].ID,
+		Amount: payment.Amount, Reason: "The goods never arrived", OpenedBy: "holder"}
+	if err := db.Create(&dispute).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	users := map[string]models.User{
+		"holder":     disputeUser(t, db, "holder", "customer", customers[0].ID),
+		"other":      disputeUser(t, db, "other", "customer", customers[1].ID),
+		"assignee":   disputeUser(t, db, "assignee", "teller", 0),
+		"bystander":  disputeUser(t, db, "bystander", "teller", 0),
+		"compliance": disputeUser(t, db, "compliance", "compliance", 0),
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		user := users[c.GetHeader("X-User")]
+		c.Set("user_id", user.ID)
+		c.Set("username", user.Username)
+		c.Set("user_role", user.Role)
+	})
+	pipeline := &uploads.Pipeline{Scanner: uploads.NoopScanner{}, Storage: uploads.LocalStorage{Dir: t.TempDir()}}
+	router.GET("/disputes/:id", GetDispute(db))
+	router.POST("/disputes/:id/assign", AssignDispute(db))
+	router.POST("/disputes/:id/messages", PostDisputeMessage(db, disputes.Config{WarningDays: 10}))
+	router.POST("/disputes/:id/read", MarkDisputeRead(db))
+	router.POST("/disputes/:id/attachments", UploadDisputeEvidence(db, pipeline, disputes.Config{WarningDays: 10}))
+	router.GET("/disputes/:id/attachments/:attachmentId", GetDisputeAttachment(db, pipeline))
+
+	// serve sends one request as user, JSON-encoding a non-nil body unless it is already multipart
+	serve := func(user, method, target string, body interface{}, contentType string) (int, map[string]interface{}) {
+		var reader *bytes.Buffer
+		switch b := body.(type) {
+		case *bytes.Buffer:
+			reader = b
+		default:
+			data, _ := json.Marshal(body)
+			reader, contentType = bytes.NewBuffer(data), "application/json"
+		}
+		request := httptest.NewRequest(method, target, reader)
+		request.Header.Set("Content-Type", contentType)
+		request.Header.Set("X-User", user)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		var decoded map[string]interface{}
+		json.Unmarshal(recorder.Body.Bytes(), &decoded)
+		return recorder.Code, decoded
+	}
+	base := "/disputes/" + strconv.FormatUint(uint64(dispute.ID), 10)
+
+	if status, body := serve("bystander", http.MethodPost, base+"/assign", gin.H{"assigned_to": "compliance"}, ""); status != http.StatusBadRequest || body["code"] != "INVALID_ASSIGNEE" {
+		t.Fatalf("assigning staff without the disputes permission: %d %v, want 400 INVALID_ASSIGNEE", status, body)
+	}
+	if status, body := serve("bystander", http.MethodPost, base+"/assign", gin.H{"assigned_to": "assignee"}, ""); status != http.StatusOK {
+		t.Fatalf("assigning the dispute: %d %v", status, body)
+	}
+	if status, body := serve("holder", http.MethodPost, base+"/messages", gin.H{"body": "Here is my receipt"}, ""); status != http.StatusCreated {
+		t.Fatalf("the holder posting: %d %v", status, body)
+	}
+	upload, contentType := evidence("The order confirmation")
+	status, uploaded := serve("holder", http.MethodPost, base+"/attachments", upload, contentType)
+	if status != http.StatusCreated {
+		t.Fatalf("the holder uploading evidence: %d %v", status, uploaded)
+	}
+	attachment := fmt.Sprintf("%s/attachments/%v", base, uploaded["attachment"].(map[string]interface{})["id"])
+
+	// Everyone but the holder and the assignee is kept out of the thread; other customers do not find the dispute
+	tests := []struct {
+		name    string
+		user    string
+		method  string
+		target  string
+		body    interface{}
+		status  int
+		code    string
+		message string // Set when the response is checked for a thread
+	}{
+		{"another customer reading it", "other", http.MethodGet, base, nil, http.StatusNotFound, "", ""},
+		{"another customer posting", "other", http.MethodPost, base + "/messages", gin.H{"body": "Mine too?"}, http.StatusNotFound, "", ""},
+		{"another customer marking it read", "other", http.MethodPost, base + "/read", gin.H{}, http.StatusNotFound, "", ""},
+		{"another customer downloading evidence", "other", http.MethodGet, attachment, nil, http.StatusNotFound, "", ""},
+		{"staff without the permission reading it", "compliance", http.MethodGet, base, nil, http.StatusForbidden, "", ""},
+		{"unassigned staff posting", "bystander", http.MethodPost, base + "/messages", gin.H{"body": "Looking into it"}, http.StatusForbidden, "DISPUTE_NOT_ASSIGNED", ""},
+		{"unassigned staff marking it read", "bystander", http.MethodPost, base + "/read", gin.H{}, http.StatusForbidden, "DISPUTE_NOT_ASSIGNED", ""},
+		{"unassigned staff downloading evidence", "bystander", http.MethodGet, attachment, nil, http.StatusForbidden, "DISPUTE_NOT_ASSIGNED", ""},
+		{"a customer asking for information", "holder", http.MethodPost, base + "/messages", gin.H{"body": "Well?", "request_information": true}, http.StatusBadRequest, "INVALID_MESSAGE", ""},
+		{"the assignee asking for information", "assignee", http.MethodPost, base + "/messages", gin.H{"body": "Please send the courier's tracking number", "request_information": true}, http.StatusCreated, "", ""},
+		{"the assignee downloading evidence", "assignee", http.MethodGet, attachment, nil, http.StatusOK, "", ""},
+		{"the holder downloading evidence", "holder", http.MethodGet, attachment, nil, http.StatusOK, "", ""},
+		{"the holder reading the thread", "holder", http.MethodGet, base, nil, http.StatusOK, "", "Please send the courier's tracking number"},
+		{"the assignee reading the thread", "assignee", http.MethodGet, base, nil, http.StatusOK, "", "Here is my receipt"},
+	}
+	for _, tt := range tests {
+		status, body := serve(tt.user, tt.method, tt.target, tt.body, "")
+		if status != tt.status || (tt.code != "" && body["code"] != tt.code) {
+			t.Errorf("%s: %d %v, want %d %s", tt.name, status, body["code"], tt.status, tt.code)
+		}
+		if tt.message == "" {
+			continue
+		}
+		thread, _ := body["thread"].(map[string]interface{})
+		found := false
+		messages, _ := thread["messages"].([]interface{})
+		for _, m := range messages {
+			found = found || m.(map[string]interface{})["body"] == tt.message
+		}
+		if !found || thread["total"] != float64(3) || len(body["attachments"].([]interface{})) != 1 {
+			t.Errorf("%s: thread %v with attachments %v, want 3 posts including %q and the evidence", tt.name, thread, body["attachments"], tt.message)
+		}
+	}
+
+	// Unassigned staff with the permission see the dispute but not its thread
+	if status, body := serve("bystander", http.MethodGet, base, nil, ""); status != http.StatusOK || body["thread"] != nil || body["attachments"] != nil {
+		t.Errorf("unassigned staff reading it: %d with thread %v, want 200 without the thread", status, body["thread"])
+	}
+
+	// Each side reads the other's posts: the holder has one unread, the assignee two
+	if _, body := serve("holder", http.MethodPost, base+"/read", gin.H{}, ""); body["marked"] != float64(1) || body["unread"] != float64(0) {
+		t.Errorf("the holder marking read: %v, want 1 marked and none unread", body)
+	}
+	if _, body := serve("assignee", http.MethodGet, base+"?limit=1", nil, ""); body["thread"].(map[string]interface{})["unread"] != float64(2) ||
+		len(body["thread"].(map[string]interface{})["messages"].([]interface{})) != 1 {
+		t.Errorf("the assignee's first page: %v, want one post and 2 unread", body["thread"])
+	}
+}
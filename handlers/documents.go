@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"banking-app/clock"
+	"banking-app/events"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"banking-app/uploads"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== DOCUMENT HANDLERS ====================
+
+// UploadDocument accepts a multipart file for a customer after validating and scanning it
+// Form fields: file, kind (identity, proof_of_address, cheque_image, legal_order) and optional account_id
+func UploadDocument(db *gorm.DB, pipeline *uploads.Pipeline) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+
+		var customer models.Customer
+		if err := db.First(&customer, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+			return
+		}
+
+		kind := c.PostForm("kind")
+		if _, ok := uploads.Kinds[kind]; !ok || kind == uploads.KindDisputeEvidence {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be identity, proof_of_address, cheque_image or legal_order"})
+			return
+		}
+		document := models.Document{CustomerID: customer.ID, Kind: kind, UploadedBy: actor(c)}
+		if raw := c.PostForm("account_id"); raw != "" {
+			id, err := strconv.ParseUint(raw, 10, 32)
+			var count int64
+			if err == nil {
+				db.Model(&models.Account{}).Where("id = ? AND customer_id = ?", id, customer.ID).Count(&count)
+			}
+			if count == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "account_id must be an account of the customer"})
+				return
+			}
+			accountID := uint(id)
+			document.AccountID = &accountID
+		}
+
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A file is required"})
+			return
+		}
+		document.Filename = header.Filename
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+			return
+		}
+		defer file.Close()
+		data, err := io.ReadAll(io.LimitReader(file, uploads.MaxSize()+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+			return
+		}
+
+		prefix := uploads.Prefix("documents", customer.TenantID, customer.ID, clock.Now())
+		var result uploads.File
+		err = uploads.ErrTooLarge
+		if int64(len(data)) <= uploads.MaxSize() {
+			result, err = pipeline.Process(c.Request.Context(), prefix, kind, header.Header.Get("Content-Type"), data)
+		}
+		if err != nil {
+			rejectUpload(c, db, document, result, int64(len(data)), err)
+			return
+		}
+
+		document.ContentType = result.ContentType
+		document.Size = result.Size
+		document.SHA256 = result.SHA256
+		document.StorageKey = result.Key
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&document).Error; err != nil {
+				return err
+			}
+			return events.Record(tx, events.AggregateCustomer, customer.ID, events.DocumentUploaded, document)
+		})
+		if err != nil {
+			// Identical bytes share a key, so only remove the file when no other document uses it
+			var shared int64
+			db.Model(&models.Document{}).Where("storage_key = ?", result.Key).Count(&shared)
+			if shared == 0 {
+				pipeline.Storage.Delete(result.Key)
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save document"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message":  "Document uploaded successfully",
+			"document": document,
+		})
+	}
+}
+
+// rejectUpload audits a rejected upload and responds with the status for the failure
+// Nothing was stored; the audit event keeps the file's name, type and the reason
+func rejectUpload(c *gin.Context, db *gorm.DB, document models.Document, result uploads.File, size int64, err error) {
+	var infected *uploads.InfectedError
+	status, message := http.StatusInternalServerError, "Failed to store document"
+	switch {
+	case errors.As(err, &infected):
+		status, message = http.StatusUnprocessableEntity, "File failed the malware scan"
+	case errors.Is(err, uploads.ErrTooLarge):
+		status, message = http.StatusRequestEntityTooLarge, err.Error()
+	case errors.Is(err, uploads.ErrTypeMismatch), errors.Is(err, uploads.ErrUnsupportedType):
+		status, message = http.StatusUnsupportedMediaType, err.Error()
+	default:
+		// The scanner could not give a verdict; unscanned files are never accepted
+		log.Printf("uploads: rejecting %q for customer %d: %v", document.Filename, document.CustomerID, err)
+		status, message = http.StatusServiceUnavailable, "File could not be scanned, try again later"
+	}
+
+	audit := gin.H{
+		"customer_id":  document.CustomerID,
+		"kind":         document.Kind,
+		"filename":     document.Filename,
+		"content_type": result.ContentType,
+		"size":         size,
+		"uploaded_by":  document.UploadedBy,
+		"reason":       err.Error(),
+	}
+	if infected != nil {
+		audit["signature"] = infected.Signature
+	}
+	if err := events.Record(db, events.AggregateCustomer, document.CustomerID, events.DocumentRejected, audit); err != nil {
+		log.Printf("uploads: failed to audit rejected upload for customer %d: %v", document.CustomerID, err)
+	}
+
+	c.JSON(status, gin.H{"error": message})
+}
+
+// GetDocuments lists a customer's documents, newest first
+func GetDocuments(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var documents []models.Document
+		if err := db.Where("customer_id = ?", c.Param("id")).Order("id DESC").Find(&documents).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve documents"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"documents": documents})
+	}
+}
+
+// GetDocumentContent streams a stored document as an attachment
+func GetDocumentContent(db *gorm.DB, pipeline *uploads.Pipeline) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var document models.Document
+		if err := db.Where("customer_id = ?", c.Param("id")).First(&document, c.Param("documentId")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		file, err := pipeline.Storage.Get(document.StorageKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read document"})
+			return
+		}
+		defer file.Close()
+
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", document.Filename))
+		c.DataFromReader(http.StatusOK, document.Size, document.ContentType, file, nil)
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"banking-app/loans"
+	"banking-app/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CustomerDetail is a customer with their tags and every loan they are a party to, not only those they borrowed
+// Loans they guarantee are flagged contingent; installment plans are listed with their backing loans
+type CustomerDetail struct {
+	models.Customer
+	Loans            []loans.CustomerLoan     `json:"loans,omitempty"`
+	InstallmentPlans []models.InstallmentPlan `json:"installment_plans,omitempty"`
+	Tags             []string                 `json:"tags"`
+	Notes            []models.Note            `json:"notes,omitempty"` // Only with ?include=notes
+}
+
+// AccountDetail is an account with its tags, the notes requested via ?include=notes and its active liens
+type AccountDetail struct {
+	models.Account
+	Tags        []string      `json:"tags"`
+	Notes       []models.Note `json:"notes,omitempty"`
+	Liens       []LienDetail  `json:"liens,omitempty"`        // Staff only
+	LienSummary *LienSummary  `json:"lien_summary,omitempty"` // Customers, when liens are active
+
+	InterestRate *float64            `json:"interest_rate,omitempty"` // Product rate in force today, when the product has rates
+	UpcomingRate *models.ProductRate `json:"upcoming_rate,omitempty"` // Next scheduled change to it
+}
+
+// TransactionSummary is the list representation of a transaction
+// Carries the account number and owner name via joins instead of nested objects
+type TransactionSummary struct {
+	ID              uint      `json:"id"`
+	TransactionID   string    `json:"transaction_id"`
+	AccountID       uint      `json:"account_id"`
+	TransactionType string    `json:"transaction_type"`
+	Amount          float64   `json:"amount"`
+	Description     string    `json:"description"`
+	Descriptor      string    `json:"descriptor"`
+	Memo            string    `json:"memo"`
+	Reference       string    `json:"reference"`
+	MerchantName    string    `json:"merchant_name"`
+	Channel         string    `json:"channel"`
+	CategoryCode    string    `json:"category_code"`
+	Location        string    `json:"location,omitempty"`
+	BalanceBefore   float64   `json:"balance_before"`
+	BalanceAfter    float64   `json:"balance_after"`
+	CreatedAt       time.Time `json:"created_at"`
+
+	// Joined summary columns
+	AccountNumber string `json:"account_number"`
+	Currency      string `json:"currency"`
+	CustomerID    uint   `json:"customer_id"`
+	CustomerName  string `json:"customer_name"`
+
+	// Nested objects, only populated when requested via ?include=
+	Account *models.Account `json:"account,omitempty" gorm:"-"`
+	Notes   []models.Note   `json:"notes,omitempty" gorm:"-"`
+}
+
+// transactionSummaryColumns selects the TransactionSummary fields from the joined tables
+const transactionSummaryColumns = `transactions.id, transactions.transaction_id, transactions.account_id,
+	transactions.transaction_type, transactions.amount, transactions.description, transactions.descriptor,
+	transactions.memo, transactions.reference, transactions.merchant_name, transactions.channel, transactions.category_code, transactions.location,
+	transactions.balance_before, transactions.balance_after, transactions.created_at,
+	accounts.account_number, accounts.currency, accounts.customer_id,
+	customers.first_name || ' ' || customers.last_name AS customer_name`
+
+// transactionSummaryQuery starts a transaction list query joined to its account and owner
+func transactionSummaryQuery(db *gorm.DB) *gorm.DB {
+	return db.Model(&models.Transaction{}).
+		Joins("LEFT JOIN accounts ON accounts.id = transactions.account_id").
+		Joins("LEFT JOIN customers ON customers.id = accounts.customer_id")
+}
+
+// CustomerSummary is the list representation of a customer
+// Replaces the full account and loan collections with counts unless ?include= asks for them
+type CustomerSummary struct {
+	ID            uint      `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	FirstName     string    `json:"first_name"`
+	LastName      string    `json:"last_name"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Phone         string    `json:"phone"`
+	Status        string    `json:"status"`
+	AccountCount  int64     `json:"account_count"`
+	LoanCount     int64     `json:"loan_count"`
+
+	// Nested collections, only populated when requested via ?include=
+	Accounts []models.Account `json:"accounts,omitempty" gorm:"-"`
+	Loans    []models.Loan    `json:"loans,omitempty" gorm:"-"`
+}
+
+// customerSummaryColumns selects the CustomerSummary fields with correlated counts
+const customerSummaryColumns = `customers.id, customers.created_at, customers.first_name, customers.last_name,
+	customers.email, customers.email_verified, customers.phone, customers.status,
+	(SELECT count(*) FROM accounts WHERE accounts.customer_id = customers.id AND accounts.deleted_at IS NULL) AS account_count,
+	(SELECT count(*) FROM loans WHERE loans.customer_id = customers.id AND loans.deleted_at IS NULL) AS loan_count`
+
+// tagList returns a record's tags, as an empty list rather than null when it has none
+func tagList(slugs []string) []string {
+	if slugs == nil {
+		return []string{}
+	}
+	return slugs
+}
+
+// parseIncludes reads a comma-separated ?include= value into a set
+func parseIncludes(raw string) map[string]bool {
+	includes := make(map[string]bool)
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(strings.ToLower(part)); part != "" {
+			includes[part] = true
+		}
+	}
+	return includes
+}
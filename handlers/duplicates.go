@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"banking-app/cache"
+	"banking-app/clock"
+	"banking-app/duplicates"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== DUPLICATE PAYMENT HANDLERS ====================
+
+// GetDuplicatePayments lists payments flagged as repeating an earlier one, newest first
+// ?status= filters (default flagged, all for every status) and ?account_id= narrows to one account
+func GetDuplicatePayments(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 50)
+
+		var filter listFilter
+		if status := c.DefaultQuery("status", duplicates.StatusFlagged); status != "all" {
+			filter.where("status = ?", status)
+		}
+		if accountID := c.Query("account_id"); accountID != "" {
+			filter.where("account_id = ?", accountID)
+		}
+
+		var flagged []models.DuplicatePayment
+		total, err := filter.count(db, &models.DuplicatePayment{})
+		if err == nil {
+			err = filter.apply(db).Order("id DESC").Offset(offset).Limit(limit).Find(&flagged).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve duplicate payments"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"duplicate_payments": flagged,
+			"total":              total,
+			"page":               page,
+			"limit":              limit,
+		})
+	}
+}
+
+// GetDuplicatePayment returns one flag with both payments
+func GetDuplicatePayment(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var duplicate models.DuplicatePayment
+		if err := db.First(&duplicate, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Duplicate payment not found"})
+			return
+		}
+		var payments []models.Transaction
+		if err := db.Where("id IN ?", []uint{duplicate.OriginalTransactionID, duplicate.TransactionID}).Order("id").Find(&payments).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve duplicate payment"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"duplicate_payment": duplicate, "payments": payments})
+	}
+}
+
+// ReverseFlaggedPayment reverses the later payment of a flag on the customer's behalf
+// Body: {"note": "..."}, optional
+func ReverseFlaggedPayment(db *gorm.DB, balances *cache.Balances) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		duplicate, note, ok := flaggedPayment(c, db, false)
+		if !ok {
+			return
+		}
+		reverseDuplicate(c, db, balances, &duplicate, actor(c), note)
+	}
+}
+
+// DismissFlaggedPayment records that a flagged payment was meant, leaving both payments posted
+// Body: {"note": "..."}; the note is required
+func DismissFlaggedPayment(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		duplicate, note, ok := flaggedPayment(c, db, true)
+		if !ok {
+			return
+		}
+		if err := duplicates.Dismiss(db, &duplicate, actor(c), note, clock.Now()); err != nil {
+			duplicateError(err).respondV1(c)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Payment kept", "duplicate_payment": duplicate})
+	}
+}
+
+// ReverseDuplicatePayment reverses a flagged payment through the link emailed to the customer
+// The token is the credential, so this is reachable without signing in; it is a POST so link scanners cannot use it
+func ReverseDuplicatePayment(db *gorm.DB, balances *cache.Balances) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		duplicate, err := duplicates.FindByToken(db, c.Param("token"), clock.Now())
+		if errors.Is(err, duplicates.ErrLinkExpired) {
+			c.JSON(http.StatusGone, gin.H{"error": "This reversal link has expired; contact the bank to reverse the payment", "code": "REVERSAL_LINK_EXPIRED"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Duplicate payment not found"})
+			return
+		}
+		db := db.WithContext(tenancy.NewContext(c.Request.Context(), duplicate.TenantID))
+		reverseDuplicate(c, db, balances, &duplicate, duplicates.CustomerActor, "Reversed through the emailed link")
+	}
+}
+
+// reverseDuplicate reverses a flag's later payment and responds with the flag
+func reverseDuplicate(c *gin.Context, db *gorm.DB, balances *cache.Balances, duplicate *models.DuplicatePayment, by, note string) {
+	accounts, err := duplicates.Reverse(db, duplicate, by, note, clock.Now())
+	if err != nil {
+		duplicateError(err).respondV1(c)
+		return
+	}
+	for _, account := range accounts {
+		balances.Invalidate(account.ID)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Repeated payment reversed", "duplicate_payment": duplicate})
+}
+
+// flaggedPayment loads the flag in the route and the decision note, responding when either is unusable
+func flaggedPayment(c *gin.Context, db *gorm.DB, noteRequired bool) (models.DuplicatePayment, string, bool) {
+	var duplicate models.DuplicatePayment
+	var req decideApprovalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return duplicate, "", false
+	}
+	if noteRequired && strings.TrimSpace(req.Note) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "note is required to dismiss"})
+		return duplicate, "", false
+	}
+	if err := db.First(&duplicate, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Duplicate payment not found"})
+		return duplicate, "", false
+	}
+	return duplicate, req.Note, true
+}
+
+// duplicateError maps a failed decision, including why a reversal did not post
+func duplicateError(err error) *apiError {
+	switch {
+	case errors.Is(err, duplicates.ErrNotFlagged):
+		return &apiError{Status: http.StatusConflict, Code: "DUPLICATE_DECIDED", Message: "Duplicate payment has already been decided", v1Code: true}
+	case errors.Is(err, ledger.ErrAlreadyReversed):
+		return &apiError{Status: http.StatusConflict, Code: "ALREADY_REVERSED", Message: "Payment has already been reversed", v1Code: true}
+	}
+	return postingError(err)
+}
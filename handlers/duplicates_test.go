@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"banking-app/cache"
+	"banking-app/clock"
+	"banking-app/duplicates"
+	"banking-app/ledger"
+	"banking-app/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReverseDuplicatePaymentLink(t *testing.T) {
+	now := time.Date(2026, time.March, 2, 9, 0, 0, 0, time.UTC)
+	clock.Use(clock.NewFake(now))
+	t.Cleanup(func() { clock.Use(clock.System{}) })
+	db := testDB(t)
+
+	customer := models.Customer{FirstName: "Link", LastName: "Holder", Email: "link@example.test", DateOfBirth: "1980-01-01", Status: "active"}
+	if err := db.Create(&customer).Error; err != nil {
+		t.Fatal(err)
+	}
+	account := models.Account{CustomerID: customer.ID, AccountNumber: "DUP-LINK", AccountType: "checking", Currency: "USD", Status: "active"}
+	if err := db.Create(&account).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ledger.Post(db, &models.Transaction{AccountID: account.ID, TransactionType: "deposit", Amount: 1000}, nil); err != nil {
+		t.Fatal(err)
+	}
+	original := models.Transaction{AccountID: account.ID, TransactionType: "payment", Amount: 50, MerchantName: "City Water"}
+	if _, err := ledger.Post(db, &original, nil); err != nil {
+		t.Fatal(err)
+	}
+	// flag posts a repeat of the payment and flags it with a link for token, expiring at expires
+	flag := func(token, status string, expires time.Time) models.DuplicatePayment {
+		repeat := models.Transaction{AccountID: account.ID, TransactionType: "payment", Amount: 50, MerchantName: "City Water"}
+		if _, err := ledger.Post(db, &repeat, nil); err != nil {
+			t.Fatal(err)
+		}
+		duplicate := models.DuplicatePayment{Status: status, AccountID: account.ID, CustomerID: customer.ID, TransactionID: repeat.ID,
+			OriginalTransactionID: original.ID, Amount: 50, MatchedOn: duplicates.MatchPayee, Counterparty: "citywater",
+			ReversalTokenHash: duplicates.HashToken(token), ReversalExpiresAt: &expires}
+		if err := db.Create(&duplicate).Error; err != nil {
+			t.Fatal(err)
+		}
+		return duplicate
+	}
+	flags := map[string]models.DuplicatePayment{
+		"live":      flag("live", duplicates.StatusFlagged, now.Add(time.Hour)),
+		"edge":      flag("edge", duplicates.StatusFlagged, now),
+		"expired":   flag("expired", duplicates.StatusFlagged, now.Add(-time.Second)),
+		"dismissed": flag("dismissed", duplicates.StatusDismissed, now.Add(time.Hour)),
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/duplicate-payments/:token/reverse", ReverseDuplicatePayment(db, cache.NewBalances(cache.BalanceConfig{Size: 10, TTL: time.Minute})))
+
+	// In order: the live link is used twice
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		code   string // Error code, or empty on success
+		after  string // Flag status afterwards
+	}{
+		{"an unknown token", "nonsense", http.StatusNotFound, "", ""},
+		{"an expired link", "expired", http.StatusGone, "REVERSAL_LINK_EXPIRED", duplicates.StatusFlagged},
+		{"a live link", "live", http.StatusOK, "", duplicates.StatusReversed},
+		{"a reused link", "live", http.StatusConflict, "DUPLICATE_DECIDED", duplicates.StatusReversed},
+		{"a link used as it expires", "edge", http.StatusOK, "", duplicates.StatusReversed},
+		{"the link of a dismissed flag", "dismissed", http.StatusConflict, "DUPLICATE_DECIDED", duplicates.StatusDismissed},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/duplicate-payments/"+tt.token+"/reverse", nil))
+		var body struct {
+			Code      string                  `json:"code"`
+			Duplicate models.DuplicatePayment `json:"duplicate_payment"`
+		}
+		json.Unmarshal(recorder.Body.Bytes(), &body)
+		if recorder.Code != tt.status || body.Code != tt.code {
+			t.Errorf("%s: status %d code %q, want %d %q: %s", tt.name, recorder.Code, body.Code, tt.status, tt.code, recorder.Body.String())
+		}
+		if tt.status == http.StatusOK && (body.Duplicate.DecidedBy != duplicates.CustomerActor || body.Duplicate.ReversalTransactionID == nil) {
+			t.Errorf("%s: reversed flag %+v, want decided by the customer with a reversal", tt.name, body.Duplicate)
+		}
+
+		flagged, ok := flags[tt.token]
+		if !ok {
+			continue
+		}
+		var stored models.DuplicatePayment
+		db.First(&stored, flagged.ID)
+		var reversals int64
+		db.Model(&models.Transaction{}).Where("reversal_of_id = ?", flagged.TransactionID).Count(&reversals)
+		want := int64(0)
+		if tt.after == duplicates.StatusReversed {
+			want = 1
+		}
+		if reversals != want {
+			t.Errorf("%s: %d reversals of the repeated payment, want %d", tt.name, reversals, want)
+		}
+		if stored.Status != tt.after {
+			t.Errorf("%s: flag is %s, want %s", tt.name, stored.Status, tt.after)
+		}
+	}
+
+	var balance float64
+	db.Model(&models.Account{}).Where("id = ?", account.ID).Pluck("balance", &balance)
+	if balance != 1000-50*3 {
+		t.Errorf("balance %v after two of four repeats were reversed, want %v", balance, 1000-50*3)
+	}
+}
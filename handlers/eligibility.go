@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"banking-app/auth"
+	"banking-app/clock"
+	"banking-app/conversions"
+	"banking-app/creditlines"
+	"banking-app/eligibility"
+	"banking-app/gl"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== PRODUCT ELIGIBILITY HANDLERS ====================
+
+// createAccountRequest is an account to open plus any staff overrides of eligibility criteria
+type createAccountRequest struct {
+	models.Account
+	Overrides []eligibilityOverrideRequest `json:"overrides"`
+}
+
+// eligibilityOverrideRequest waives one failed criterion
+type eligibilityOverrideRequest struct {
+	Criterion string `json:"criterion"`
+	Reason    string `json:"reason"`
+}
+
+// standardAccountTypes can always be opened; a tenant's product catalog adds its own types
+var standardAccountTypes = []string{"checking", "savings"}
+
+// reservedAccountTypes are opened only by the API that owns them, which creates the record behind the account
+var reservedAccountTypes = map[string]string{
+	"loan":                  "Loan accounts are opened by disbursing a loan; use POST /api/v1/loans",
+	creditlines.AccountType: "Credit line accounts are opened by activating a line of credit; use POST /api/v1/credit-lines",
+	gl.AccountType:          "Internal accounts are managed by the general ledger and cannot be opened",
+}
+
+// checkAccountType allows the standard types and the tenant's catalog products that are not deprecated, responding
+// 400 with the rejected type otherwise
+func checkAccountType(c *gin.Context, db *gorm.DB, accountType string) bool {
+	if message, reserved := reservedAccountTypes[accountType]; reserved {
+		(&apiError{Status: http.StatusBadRequest, Code: "RESERVED_ACCOUNT_TYPE", Message: message, v1Code: true,
+			Details: gin.H{"account_type": accountType}}).respondV1(c)
+		return false
+	}
+	if contains(standardAccountTypes, accountType) {
+		return true
+	}
+	var catalog []string
+	if err := db.Model(&models.Product{}).Where("deprecated = ?", false).Order("account_type").Pluck("account_type", &catalog).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the account type"})
+		return false
+	}
+	if contains(catalog, accountType) {
+		return true
+	}
+	allowed := append([]string{}, standardAccountTypes...)
+	for _, t := range catalog {
+		if _, reserved := reservedAccountTypes[t]; !reserved && !contains(allowed, t) {
+			allowed = append(allowed, t)
+		}
+	}
+	(&apiError{Status: http.StatusBadRequest, Code: "INVALID_ACCOUNT_TYPE", Message: "Unsupported account type", v1Code: true,
+		Details: gin.H{"account_type": accountType, "allowed": allowed}}).respondV1(c)
+	return false
+}
+
+// checkEligibility evaluates the account's product for the customer and applies requested overrides
+// It responds and returns ok=false when the account must not be opened; otherwise it returns the
+// override records to store with the account
+func checkEligibility(c *gin.Context, db *gorm.DB, account models.Account, customer models.Customer, requested []eligibilityOverrideRequest) ([]models.EligibilityOverride, bool) {
+	if len(requested) > 0 && !canManageEligibility(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Eligibility overrides require the eligibility permission"})
+		return nil, false
+	}
+
+	product, err := eligibility.Lookup(db, account.AccountType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check product eligibility"})
+		return nil, false
+	}
+	if product == nil {
+		if len(requested) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Account type has no eligibility rules to override"})
+			return nil, false
+		}
+		return nil, true
+	}
+
+	failures, err := eligibility.Evaluate(db, *product, customer, clock.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check product eligibility"})
+		return nil, false
+	}
+
+	waived := map[string]eligibilityOverrideRequest{}
+	for _, o := range requested {
+		if !contains(eligibility.Criteria, o.Criterion) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown eligibility criterion " + o.Criterion})
+			return nil, false
+		}
+		if strings.TrimSpace(o.Reason) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Every override needs a reason"})
+			return nil, false
+		}
+		waived[o.Criterion] = o
+	}
+
+	var overrides []models.EligibilityOverride
+	remaining := []eligibility.Failure{}
+	for _, f := range failures {
+		o, ok := waived[f.Criterion]
+		if !ok {
+			remaining = append(remaining, f)
+			continue
+		}
+		overrides = append(overrides, models.EligibilityOverride{
+			CustomerID:   customer.ID,
+			AccountType:  account.AccountType,
+			Criterion:    f.Criterion,
+			Detail:       f.Message,
+			Reason:       o.Reason,
+			OverriddenBy: actor(c),
+		})
+	}
+	if len(remaining) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":           "Customer is not eligible for this product",
+			"product":         product.AccountType,
+			"failed_criteria": remaining,
+		})
+		return nil, false
+	}
+	return overrides, true
+}
+
+// canManageEligibility reports whether the caller may override eligibility criteria and set KYC levels
+func canManageEligibility(c *gin.Context) bool {
+	role, _ := c.Get("user_role")
+	roleName, _ := role.(string)
+	return auth.Can(roleName, auth.PermEligibility)
+}
+
+// GetEligibleProducts lists the catalog with the customer's eligibility for each product
+// ?eligible=true returns only products the customer can open now
+func GetEligibleProducts(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var customer models.Customer
+		if err := db.First(&customer, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+			return
+		}
+
+		offers, err := eligibility.ForCustomer(db, customer, clock.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate products"})
+			return
+		}
+		if c.Query("eligible") == "true" {
+			eligible := offers[:0]
+			for _, o := range offers {
+				if o.Eligible {
+					eligible = append(eligible, o)
+				}
+			}
+			offers = eligible
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"customer_id": customer.ID,
+			"products":    offers,
+		})
+	}
+}
+
+// GetProducts lists the tenant's account product catalog
+func GetProducts(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var products []models.Product
+		if err := db.Order("account_type").Find(&products).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve products"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"products": products})
+	}
+}
+
+// CreateProduct adds an account type to the catalog with its eligibility rules and the restrictions, overdraft limit
+// and monthly fee its accounts open with
+func CreateProduct(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var product models.Product
+		if err := c.ShouldBindJSON(&product); err != nil || product.AccountType == "" || product.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "account_type and name are required"})
+			return
+		}
+		if message, reserved := reservedAccountTypes[product.AccountType]; reserved {
+			c.JSON(http.StatusBadRequest, gin.H{"error": message, "code": "RESERVED_ACCOUNT_TYPE", "account_type": product.AccountType})
+			return
+		}
+		if !validProductRules(product) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Eligibility rules must not be negative"})
+			return
+		}
+		if err := conversions.ValidateProduct(&product); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		product.ID = 0
+
+		var existing int64
+		db.Model(&models.Product{}).Where("account_type = ?", product.AccountType).Count(&existing)
+		if existing > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "A product for this account type already exists"})
+			return
+		}
+		if err := db.Create(&product).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Product created successfully",
+			"product": product,
+		})
+	}
+}
+
+// UpdateProduct replaces a product's name, description, eligibility rules, restrictions and conversion parameters
+// The account type is fixed once created since accounts link to it; restrictions and the overdraft limit apply to
+// accounts opened or converted afterwards, while a changed monthly fee is charged from the next charge on
+func UpdateProduct(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var product models.Product
+		if err := db.First(&product, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+
+		var req models.Product
+		if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+		if !validProductRules(req) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Eligibility rules must not be negative"})
+			return
+		}
+		req.AccountType = product.AccountType
+		if err := conversions.ValidateProduct(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		product.Name = req.Name
+		product.Description = req.Description
+		product.MaxPerCustomer = req.MaxPerCustomer
+		product.MinAgeYears = req.MinAgeYears
+		product.MinTenureDays = req.MinTenureDays
+		product.RequiredKYCLevel = req.RequiredKYCLevel
+		product.Restrictions = req.Restrictions
+		product.Category = req.Category
+		product.Deprecated = req.Deprecated
+		product.MonthlyFee = req.MonthlyFee
+		product.OverdraftLimit = req.OverdraftLimit
+		if err := db.Save(&product).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Product updated successfully",
+			"product": product,
+		})
+	}
+}
+
+// DeleteProduct removes a product from the catalog; its account type is then opened without rules
+func DeleteProduct(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		result := db.Delete(&models.Product{}, c.Param("id"))
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully"})
+	}
+}
+
+// validProductRules rejects negative limits
+func validProductRules(p models.Product) bool {
+	return p.MaxPerCustomer >= 0 && p.MinAgeYears >= 0 && p.MinTenureDays >= 0 && p.RequiredKYCLevel >= 0
+}
+
+// GetEligibilityOverrides lists the audit trail of waived criteria, newest first
+// ?customer_id= filters by customer
+func GetEligibilityOverrides(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 50)
+
+		var filter listFilter
+		if customerID := c.Query("customer_id"); customerID != "" {
+			filter.where("customer_id = ?", customerID)
+		}
+
+		var overrides []models.EligibilityOverride
+		total, err := filter.count(db, &models.EligibilityOverride{})
+		if err == nil {
+			err = filter.apply(db).Order("id DESC").Offset(offset).Limit(limit).Find(&overrides).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve overrides"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"overrides": overrides,
+			"total":     total,
+			"page":      page,
+			"limit":     limit,
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"banking-app/enrichment"
+	"banking-app/models"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== ENRICHMENT RULE HANDLERS ====================
+
+// validateEnrichmentRule checks a rule before it is stored
+func validateEnrichmentRule(rule models.EnrichmentRule) string {
+	if !contains(enrichment.MatchTypes, rule.MatchType) {
+		return "Invalid match type"
+	}
+	if rule.Pattern == "" || rule.MerchantName == "" {
+		return "Pattern and merchant name are required"
+	}
+	if rule.MatchType == "regex" {
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return "Invalid regex pattern: " + err.Error()
+		}
+	}
+	if len(rule.CategoryCode) > 4 {
+		return "Category code must be at most 4 characters"
+	}
+	return ""
+}
+
+// GetEnrichmentRules lists enrichment rules in evaluation order
+func GetEnrichmentRules(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rules, err := enrichment.LoadRules(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve enrichment rules"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"rules": rules})
+	}
+}
+
+// CreateEnrichmentRule adds a description → merchant mapping rule
+func CreateEnrichmentRule(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rule models.EnrichmentRule
+		if err := c.ShouldBindJSON(&rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		if msg := validateEnrichmentRule(rule); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		rule.ID = 0
+		if err := db.Create(&rule).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create enrichment rule"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Enrichment rule created successfully",
+			"rule":    rule,
+		})
+	}
+}
+
+// UpdateEnrichmentRule replaces an existing enrichment rule definition
+func UpdateEnrichmentRule(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+			return
+		}
+
+		var rule models.EnrichmentRule
+		if err := db.First(&rule, uint(id)).Error; err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Enrichment rule not found"})
+			return
+		}
+
+		var updateData models.EnrichmentRule
+		if err := c.ShouldBindJSON(&updateData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		// Apply only the fields that were supplied
+		if updateData.MatchType != "" {
+			rule.MatchType = updateData.MatchType
+		}
+		if updateData.Pattern != "" {
+			rule.Pattern = updateData.Pattern
+		}
+		if updateData.MerchantName != "" {
+			rule.MerchantName = updateData.MerchantName
+		}
+		if updateData.CategoryCode != "" {
+			rule.CategoryCode = updateData.CategoryCode
+		}
+		if updateData.Priority != 0 {
+			rule.Priority = updateData.Priority
+		}
+
+		if msg := validateEnrichmentRule(rule); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		if err := db.Save(&rule).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update enrichment rule"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Enrichment rule updated successfully",
+			"rule":    rule,
+		})
+	}
+}
+
+// DeleteEnrichmentRule removes an enrichment rule; already enriched transactions are unchanged
+func DeleteEnrichmentRule(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+			return
+		}
+
+		result := db.Delete(&models.EnrichmentRule{}, uint(id))
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete enrichment rule"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Enrichment rule not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Enrichment rule deleted successfully"})
+	}
+}
+
+// BackfillEnrichment applies the current rules to transactions without a merchant name
+// Safe to rerun - transactions that already have a merchant are skipped
+func BackfillEnrichment(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		updated, err := enrichment.Backfill(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to backfill enrichment"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Enrichment backfill completed",
+			"updated": updated,
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"banking-app/eod"
+	"banking-app/jobs"
+	"banking-app/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== END OF DAY HANDLERS ====================
+
+// GetEOD shows the posting window, the end-of-day steps and the latest run with its steps
+func GetEOD(db *gorm.DB, orchestrator *eod.Orchestrator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response := gin.H{
+			"posting_window": orchestrator.Window.State(),
+			"paused":         orchestrator.Window.Paused(),
+			"in_flight":      orchestrator.Window.InFlight(),
+			"steps":          orchestrator.Steps,
+		}
+		var last models.JobRun
+		err := db.Where("job_name = ?", eod.JobName).Order("id DESC").First(&last).Error
+		if err == nil {
+			steps, stepErr := eod.RunSteps(db, last.ID)
+			if stepErr != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve end-of-day run"})
+				return
+			}
+			response["last_run"], response["last_run_steps"] = last, steps
+		} else if err != gorm.ErrRecordNotFound {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve end-of-day run"})
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// GetEODRun returns an end-of-day run with its steps in the order they ran
+func GetEODRun(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var run models.JobRun
+		if err := db.Where("job_name = ?", eod.JobName).First(&run, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "End-of-day run not found"})
+			return
+		}
+		steps, err := eod.RunSteps(db, run.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve end-of-day run"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"run": run, "steps": steps})
+	}
+}
+
+// ResumeEODRun starts a run picking up a failed end-of-day run at its failed step; steps that succeeded are
+// not run again. The run continues in the background
+func ResumeEODRun(orchestrator *eod.Orchestrator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "End-of-day run not found"})
+			return
+		}
+		run, err := orchestrator.Resume(uint(id), actor(c))
+		switch err {
+		case nil:
+			c.JSON(http.StatusAccepted, gin.H{"message": "End of day resumed", "run": run})
+		case gorm.ErrRecordNotFound, eod.ErrNotEOD:
+			c.JSON(http.StatusNotFound, gin.H{"error": "End-of-day run not found"})
+		case eod.ErrNotFailed:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": "EOD_NOT_FAILED"})
+		case eod.ErrSuperseded:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": "EOD_SUPERSEDED"})
+		case jobs.ErrRunning:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case jobs.ErrPaused:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error(), "code": "MAINTENANCE"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resume end of day"})
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"banking-app/cache"
+	"banking-app/clock"
+	"banking-app/escheat"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== ESCHEATMENT HANDLERS ====================
+
+// GetEscheatments reports escheatments for state filing, newest first
+// ?status= filters (pending, cancelled, escheated, reclaimed); ?format=csv returns every matching row
+func GetEscheatments(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+
+		var filter listFilter
+		if status := c.Query("status"); status != "" {
+			filter.where("escheatments.status = ?", status)
+		}
+		query := filter.apply(escheat.ReportQuery(db)).Order("escheatments.id DESC")
+
+		if c.Query("format") == "csv" {
+			c.Header("Content-Type", "text/csv")
+			c.Header("Content-Disposition", "attachment; filename=\"escheatments.csv\"")
+			c.Status(http.StatusOK)
+			escheat.WriteCSV(c.Writer, db, query)
+			return
+		}
+
+		page, limit, offset := parsePagination(c, 50)
+		var rows []escheat.ReportRow
+		total, err := filter.count(db, &models.Escheatment{})
+		if err == nil {
+			err = query.Offset(offset).Limit(limit).Scan(&rows).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve escheatments"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"escheatments": rows,
+			"total":        total,
+			"page":         page,
+			"limit":        limit,
+		})
+	}
+}
+
+// RunEscheatment runs the dormancy and escheatment job now for the admin's tenant
+func RunEscheatment(db *gorm.DB, cfg escheat.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		result, err := escheat.Run(db, cfg, clock.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Escheatment run failed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Escheatment run completed",
+			"result":  result,
+		})
+	}
+}
+
+// reclaimRequest records the evidence behind a reclaim
+type reclaimRequest struct {
+	Note string `json:"note" binding:"required"`
+}
+
+// ReclaimEscheatment returns escheated funds to a customer who came forward and reactivates the account
+func ReclaimEscheatment(db *gorm.DB, balances *cache.Balances) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid escheatment ID"})
+			return
+		}
+
+		var req reclaimRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A note describing the claim is required"})
+			return
+		}
+
+		var row models.Escheatment
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.First(&row, uint(id)).Error; err != nil {
+				return err
+			}
+			return escheat.Reclaim(tx, &row, actor(c), req.Note)
+		})
+		switch err {
+		case nil:
+		case gorm.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Escheatment not found"})
+			return
+		case escheat.ErrNotEscheated:
+			c.JSON(http.StatusConflict, gin.H{"error": "Only escheated funds can be reclaimed"})
+			return
+		default:
+			respondPostingError(c, err)
+			return
+		}
+
+		balances.Invalidate(row.AccountID)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "Escheated funds reclaimed",
+			"escheatment": row,
+		})
+	}
+}
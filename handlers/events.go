@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"banking-app/events"
+	"banking-app/models"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== EVENT & WEBHOOK HANDLERS ====================
+
+// webhookSubscriptionRequest carries the settable fields of a subscription
+// The secret is write-only and never echoed back
+type webhookSubscriptionRequest struct {
+	URL          string `json:"url"`
+	Secret       string `json:"secret"`
+	EventTypes   string `json:"event_types"`
+	Active       *bool  `json:"active"`
+	AlertEmail   string `json:"alert_email"`   // Told when the circuit breaker suspends deliveries
+	BacklogLimit int    `json:"backlog_limit"` // Overrides WEBHOOK_BACKLOG_LIMIT when above 0
+}
+
+// GetWebhookSubscriptions lists all webhook subscriptions with their circuit breaker state and backlog
+func GetWebhookSubscriptions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var subscriptions []models.WebhookSubscription
+		err := db.Order("id").Find(&subscriptions).Error
+		if err == nil {
+			err = events.FillBacklogs(db, subscriptions)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhook subscriptions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"subscriptions": subscriptions})
+	}
+}
+
+// CreateWebhookSubscription registers an endpoint for domain events
+func CreateWebhookSubscription(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req webhookSubscriptionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A valid http(s) URL is required"})
+			return
+		}
+		if req.BacklogLimit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "backlog_limit cannot be negative"})
+			return
+		}
+
+		subscription := models.WebhookSubscription{
+			URL:          req.URL,
+			Secret:       req.Secret,
+			EventTypes:   req.EventTypes,
+			Active:       true,
+			CreatedBy:    actor(c),
+			AlertEmail:   req.AlertEmail,
+			BacklogLimit: req.BacklogLimit,
+		}
+		if req.Active != nil {
+			subscription.Active = *req.Active
+		}
+
+		if err := db.Create(&subscription).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook subscription"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message":      "Webhook subscription created successfully",
+			"subscription": subscription,
+		})
+	}
+}
+
+// DeleteWebhookSubscription removes a webhook subscription
+func DeleteWebhookSubscription(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription ID"})
+			return
+		}
+
+		result := db.Delete(&models.WebhookSubscription{}, uint(id))
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook subscription"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Webhook subscription deleted successfully"})
+	}
+}
+
+// GetWebhookDeliveries lists a subscription's deliveries, newest first
+// ?status= filters to pending, delivered or dropped; a pending delivery's dropped_before counts the deliveries
+// dropped from a full backlog just ahead of it
+func GetWebhookDeliveries(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var subscription models.WebhookSubscription
+		if err := db.First(&subscription, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+			return
+		}
+		respondWebhookDeliveries(c, db, subscription)
+	}
+}
+
+// respondWebhookDeliveries writes a page of one subscription's deliveries, newest first, filtered by ?status=
+func respondWebhookDeliveries(c *gin.Context, db *gorm.DB, subscription models.WebhookSubscription) {
+	page, limit, offset := parsePagination(c, 50)
+
+	var filter listFilter
+	filter.where("subscription_id = ?", subscription.ID)
+	if status := c.Query("status"); status != "" {
+		filter.where("status = ?", status)
+	}
+
+	var deliveries []models.WebhookDelivery
+	total, err := filter.count(db, &models.WebhookDelivery{})
+	if err == nil {
+		err = filter.apply(db).Order("id DESC").Offset(offset).Limit(limit).Find(&deliveries).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhook deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveries,
+		"total":      total,
+		"page":       page,
+		"limit":      limit,
+	})
+}
+
+// ResumeWebhookSubscription closes a suspended subscription's circuit breaker without waiting for a probe
+// Its backlog replays in order at the usual rate cap
+func ResumeWebhookSubscription(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription ID"})
+			return
+		}
+
+		backlog, err := events.ResumeWebhook(db, uint(id))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+			return
+		}
+		if errors.Is(err, events.ErrNotSuspended) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Webhook subscription is not suspended", "code": "WEBHOOK_NOT_SUSPENDED"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resume webhook subscription"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Webhook subscription resumed", "backlog": backlog})
+	}
+}
+
+// GetOutboxEvents lists outbox events, optionally filtered by status
+// Use ?status=failed to find poison events that need a redrive
+func GetOutboxEvents(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, offset := parsePagination(c, 50)
+
+		var filter listFilter
+		if status := c.Query("status"); status != "" {
+			filter.where("status = ?", status)
+		}
+
+		var outbox []models.OutboxEvent
+		total, err := filter.count(db, &models.OutboxEvent{})
+		if err == nil {
+			err = filter.apply(db).Order("id DESC").Offset(offset).Limit(limit).Find(&outbox).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve outbox events"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"events": outbox,
+			"total":  total,
+			"page":   page,
+			"limit":  limit,
+		})
+	}
+}
+
+// RedriveOutboxEvent requeues a parked (failed) event for immediate delivery
+func RedriveOutboxEvent(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+			return
+		}
+
+		ok, err := events.Redrive(db, uint(id))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redrive event"})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No failed event with that ID"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Event requeued for delivery"})
+	}
+}
+
+// GetOutboxStats summarizes outbox backlog and dispatch lag
+func GetOutboxStats(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var pending, failed int64
+		db.Model(&models.OutboxEvent{}).Where("status = ?", "pending").Count(&pending)
+		db.Model(&models.OutboxEvent{}).Where("status = ?", "failed").Count(&failed)
+
+		c.JSON(http.StatusOK, gin.H{
+			"pending":     pending,
+			"failed":      failed,
+			"lag_seconds": events.Lag(db).Seconds(),
+		})
+	}
+}
+
+// StreamEvents pushes dispatched events to the client as server-sent events
+func StreamEvents(broker *events.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stream, cancel := broker.Subscribe()
+		defer cancel()
+
+		keepAlive := time.NewTicker(30 * time.Second)
+		defer keepAlive.Stop()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case event, ok := <-stream:
+				if !ok {
+					return false
+				}
+				c.SSEvent(event.EventType, json.RawMessage(event.Payload))
+				return true
+			case <-keepAlive.C:
+				c.SSEvent("ping", gin.H{"time": time.Now().UTC()})
+				return true
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	}
+}
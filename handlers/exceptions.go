@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"banking-app/cache"
+	"banking-app/exceptions"
+	"banking-app/flags"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== EXCEPTION QUEUE HANDLERS ====================
+
+// ReceiveIncomingCredit books a credit from outside the bank addressed by account number
+// Credits that cannot be applied as sent are held in suspense with an open exception instead of being rejected
+func ReceiveIncomingCredit(db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		payload, err := c.GetRawData()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		var credit exceptions.Credit
+		if err := json.Unmarshal(payload, &credit); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if credit.AccountNumber == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "account_number is required"})
+			return
+		}
+		if err := ledger.ValidateAmount(credit.Amount); err != nil {
+			respondPostingError(c, err)
+			return
+		}
+		if credit.Currency == "" {
+			credit.Currency = tenancy.CurrentSettings(c).DefaultCurrency
+		}
+		credit.Currency = strings.ToUpper(credit.Currency)
+
+		var receipt exceptions.Receipt
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			receipt, err = exceptions.Receive(tx, tenancy.Current(c).ID, credit, string(payload), featureFlags)
+			return err
+		})
+		if err != nil {
+			respondPostingError(c, err)
+			return
+		}
+		balances.Invalidate(receipt.Account.ID)
+
+		if receipt.Exception != nil {
+			c.JSON(http.StatusAccepted, gin.H{
+				"message":     "Credit held in suspense",
+				"transaction": receipt.Transaction,
+				"exception":   receipt.Exception,
+			})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{
+			"message":     "Credit applied",
+			"transaction": receipt.Transaction,
+		})
+	}
+}
+
+// GetExceptions lists the exception queue, oldest first so the nearest auto-returns lead
+// ?status= filters (open, applied, returned; default open)
+func GetExceptions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 50)
+
+		var filter listFilter
+		if status := c.DefaultQuery("status", exceptions.StatusOpen); status != "all" {
+			filter.where("status = ?", status)
+		}
+
+		var items []models.ExceptionItem
+		total, err := filter.count(db, &models.ExceptionItem{})
+		if err == nil {
+			err = filter.apply(db).Order("created_at, id").Offset(offset).Limit(limit).Find(&items).Error
+		}
+		var open int64
+		if err == nil {
+			open, err = exceptions.OpenCount(db)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve exceptions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"exceptions": items,
+			"total":      total,
+			"open":       open,
+			"page":       page,
+			"limit":      limit,
+		})
+	}
+}
+
+// GetException returns one exception with its raw payload
+func GetException(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var item models.ExceptionItem
+		if err := db.First(&item, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Exception not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"exception": item})
+	}
+}
+
+// resolveExceptionRequest carries the corrected account and the reason for a resolution
+type resolveExceptionRequest struct {
+	AccountID uint   `json:"account_id"`
+	Note      string `json:"note"`
+}
+
+// ApplyException moves a held credit out of suspense into the corrected account
+func ApplyException(db *gorm.DB, balances *cache.Balances) gin.HandlerFunc {
+	return resolveException(db, balances, true)
+}
+
+// ReturnException sends a held credit back to its originator
+func ReturnException(db *gorm.DB, balances *cache.Balances) gin.HandlerFunc {
+	return resolveException(db, balances, false)
+}
+
+// resolveException takes an open item out of the queue by applying or returning it
+func resolveException(db *gorm.DB, balances *cache.Balances, apply bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid exception ID"})
+			return
+		}
+
+		var req resolveExceptionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if apply && req.AccountID == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "account_id is required"})
+			return
+		}
+
+		var item models.ExceptionItem
+		var account models.Account
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.First(&item, uint(id)).Error; err != nil {
+				return err
+			}
+			if !apply {
+				return exceptions.Return(tx, &item, actor(c), req.Note)
+			}
+			var err error
+			account, err = exceptions.Apply(tx, &item, req.AccountID, actor(c), req.Note)
+			return err
+		})
+		switch err {
+		case nil:
+		case gorm.ErrRecordNotFound:
+			if item.ID == 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Exception not found"})
+			} else {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			}
+			return
+		case exceptions.ErrNotOpen:
+			c.JSON(http.StatusConflict, gin.H{"error": "Exception has already been resolved"})
+			return
+		case exceptions.ErrInvalidDestination, exceptions.ErrDestinationCurrency:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		default:
+			respondPostingError(c, err)
+			return
+		}
+
+		if account.ID != 0 {
+			balances.Invalidate(account.ID)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "Exception " + item.Status,
+			"exception": item,
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"banking-app/clock"
+	"banking-app/display"
+	"banking-app/models"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== EXPORT HANDLERS ====================
+
+// exportFlushEvery controls how often buffered rows are pushed to the client
+const exportFlushEvery = 500
+
+// exportSummary is the trailing record of every export stream
+// max_updated_at is the value to pass as ?since= on the next incremental sync
+type exportSummary struct {
+	Type         string     `json:"type"`
+	RowCount     int        `json:"row_count"`
+	Since        *time.Time `json:"since,omitempty"`
+	MaxUpdatedAt *time.Time `json:"max_updated_at,omitempty"`
+	GeneratedAt  time.Time  `json:"generated_at"`
+}
+
+// ExportTransactions streams all transactions as newline-delimited JSON
+func ExportTransactions(db *gorm.DB) gin.HandlerFunc {
+	return exportTable(db, &models.Transaction{}, "transactions")
+}
+
+// ExportCustomers streams all customers as newline-delimited JSON
+func ExportCustomers(db *gorm.DB) gin.HandlerFunc {
+	return exportTable(db, &models.Customer{}, "customers")
+}
+
+// ExportAccounts streams all accounts as newline-delimited JSON
+func ExportAccounts(db *gorm.DB) gin.HandlerFunc {
+	return exportTable(db, &models.Account{}, "accounts")
+}
+
+// exportTable builds a streaming NDJSON export handler for one table
+// Rows are read through a cursor so memory stays flat regardless of table size;
+// soft-deleted rows are included (with deleted_at) so warehouses can apply deletes.
+// Account numbers are masked unless the export is requested with ?reveal=true
+func exportTable(db *gorm.DB, model interface{}, name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		summary := exportSummary{Type: name}
+		mask := displayOptions(c).Mask
+
+		query := db.WithContext(c.Request.Context()).Unscoped().Model(model).Order("updated_at, id")
+		if since := c.Query("since"); since != "" {
+			t, err := time.Parse(time.RFC3339Nano, since)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since timestamp, expected RFC 3339"})
+				return
+			}
+			summary.Since = &t
+			query = query.Where("updated_at > ?", t)
+		}
+
+		rows, err := query.Rows()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start export"})
+			return
+		}
+		defer rows.Close()
+
+		// Headers are committed from here on - errors can only end the stream early
+		// The compression middleware gzips the stream for clients that accept it
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(http.StatusOK)
+
+		encoder := json.NewEncoder(c.Writer)
+		for rows.Next() {
+			// Stop promptly when the client disconnects
+			if c.Request.Context().Err() != nil {
+				return
+			}
+
+			row := map[string]interface{}{}
+			if err := db.ScanRows(rows, &row); err != nil {
+				return
+			}
+			if mask {
+				display.MaskRow(row)
+			}
+			if err := encoder.Encode(row); err != nil {
+				return
+			}
+
+			summary.RowCount++
+			if updated, ok := row["updated_at"].(time.Time); ok {
+				if summary.MaxUpdatedAt == nil || updated.After(*summary.MaxUpdatedAt) {
+					summary.MaxUpdatedAt = &updated
+				}
+			}
+			if summary.RowCount%exportFlushEvery == 0 {
+				c.Writer.Flush()
+			}
+		}
+		if rows.Err() != nil {
+			// Omitting the summary signals an incomplete extract to the consumer
+			return
+		}
+
+		summary.GeneratedAt = clock.Now().UTC()
+		encoder.Encode(gin.H{"_summary": summary})
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"banking-app/clock"
+	"banking-app/externalaccounts"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== EXTERNAL ACCOUNT HANDLERS ====================
+
+// linkExternalAccountRequest carries the details of an account at another bank
+type linkExternalAccountRequest struct {
+	RoutingNumber string `json:"routing_number" binding:"required"`
+	AccountNumber string `json:"account_number" binding:"required"`
+	AccountType   string `json:"account_type"`
+	Nickname      string `json:"nickname"`
+}
+
+// verifyExternalAccountRequest carries the two micro-deposit amounts the customer saw, in either order
+type verifyExternalAccountRequest struct {
+	Amounts []float64 `json:"amounts" binding:"required"`
+}
+
+// externalAccountCustomer loads the customer in the route, responding when there is none
+func externalAccountCustomer(c *gin.Context, db *gorm.DB) (models.Customer, bool) {
+	var customer models.Customer
+	if err := db.Select("id").First(&customer, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return customer, false
+	}
+	return customer, true
+}
+
+// externalAccountID parses the link ID in the route, responding when it is not one
+func externalAccountID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("externalId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "External account not found"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// GetExternalAccounts lists a customer's linked and pending external accounts, masked
+func GetExternalAccounts(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		customer, ok := externalAccountCustomer(c, db)
+		if !ok {
+			return
+		}
+		var links []models.ExternalAccount
+		if err := db.Where("customer_id = ?", customer.ID).Order("id").Find(&links).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve external accounts"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"external_accounts": links})
+	}
+}
+
+// LinkExternalAccount starts linking an account at another bank by sending it two micro-deposits
+// Body: {"routing_number": "011000015", "account_number": "123456789", "account_type": "checking"}. The amounts
+// are never returned; the customer confirms them from the other bank within 7 days
+func LinkExternalAccount(db *gorm.DB, sealer *externalaccounts.Sealer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		customer, ok := externalAccountCustomer(c, db)
+		if !ok {
+			return
+		}
+		var req linkExternalAccountRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "routing_number and account_number are required", "code": "INVALID_EXTERNAL_ACCOUNT"})
+			return
+		}
+
+		link, err := externalaccounts.Link(db, sealer, customer.ID, externalaccounts.Request{
+			RoutingNumber: req.RoutingNumber,
+			AccountNumber: req.AccountNumber,
+			AccountType:   req.AccountType,
+			Nickname:      req.Nickname,
+		}, clock.Now())
+		switch err {
+		case nil:
+		case externalaccounts.ErrDetails, externalaccounts.ErrAccountType:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "INVALID_EXTERNAL_ACCOUNT"})
+			return
+		case externalaccounts.ErrLinked:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": "EXTERNAL_ACCOUNT_EXISTS"})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link external account"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{
+			"message":          "Two small deposits are on their way to the account; confirm their amounts to finish linking it",
+			"external_account": link,
+		})
+	}
+}
+
+// VerifyExternalAccount confirms a link with the micro-deposit amounts
+// Body: {"amounts": [0.12, 0.34]}. Three wrong pairs lock the link until it expires
+func VerifyExternalAccount(db *gorm.DB, sealer *externalaccounts.Sealer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		customer, ok := externalAccountCustomer(c, db)
+		if !ok {
+			return
+		}
+		id, ok := externalAccountID(c)
+		if !ok {
+			return
+		}
+		var req verifyExternalAccountRequest
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.Amounts) != 2 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "amounts must be the two deposit amounts", "code": "INVALID_EXTERNAL_ACCOUNT"})
+			return
+		}
+
+		link, err := externalaccounts.Verify(db, sealer, customer.ID, id, req.Amounts, clock.Now())
+		switch err {
+		case nil:
+		case gorm.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "External account not found"})
+			return
+		case externalaccounts.ErrMismatch:
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":              err.Error(),
+				"code":               "MICRO_DEPOSIT_MISMATCH",
+				"attempts_remaining": externalaccounts.MaxAttempts - link.Attempts,
+				"external_account":   link,
+			})
+			return
+		case externalaccounts.ErrLocked:
+			c.JSON(http.StatusLocked, gin.H{"error": err.Error(), "code": "EXTERNAL_ACCOUNT_LOCKED"})
+			return
+		case externalaccounts.ErrExpired:
+			c.JSON(http.StatusGone, gin.H{"error": err.Error(), "code": "EXTERNAL_ACCOUNT_EXPIRED"})
+			return
+		case externalaccounts.ErrVerified, externalaccounts.ErrNotPending:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": "EXTERNAL_ACCOUNT_NOT_PENDING"})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify external account"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "External account verified", "external_account": link})
+	}
+}
+
+// UnlinkExternalAccount removes a linked or pending external account
+func UnlinkExternalAccount(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		customer, ok := externalAccountCustomer(c, db)
+		if !ok {
+			return
+		}
+		id, ok := externalAccountID(c)
+		if !ok {
+			return
+		}
+		switch err := externalaccounts.Unlink(db, customer.ID, id); err {
+		case nil:
+			c.JSON(http.StatusOK, gin.H{"message": "External account unlinked"})
+		case gorm.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "External account not found"})
+		case externalaccounts.ErrLocked:
+			c.JSON(http.StatusLocked, gin.H{"error": err.Error(), "code": "EXTERNAL_ACCOUNT_LOCKED"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink external account"})
+		}
+	}
+}
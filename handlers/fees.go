@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"banking-app/businessdays"
+	"banking-app/fees"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== FEE SCHEDULE HANDLERS ====================
+
+// feeScheduleRequest is a fee schedule as admins send it, with YYYY-MM-DD dates
+type feeScheduleRequest struct {
+	Name            string  `json:"name" binding:"required"`
+	AccountType     string  `json:"account_type"`
+	TransactionType string  `json:"transaction_type"`
+	Channel         string  `json:"channel"`
+	Currency        string  `json:"currency"`
+	FlatFee         float64 `json:"flat_fee"`
+	Percent         float64 `json:"percent"`
+	MinFee          float64 `json:"min_fee"`
+	MaxFee          float64 `json:"max_fee"`
+	EffectiveFrom   string  `json:"effective_from" binding:"required"`
+	EffectiveTo     string  `json:"effective_to"` // Empty for open-ended
+}
+
+// schedule parses the request into a fee schedule
+func (r feeScheduleRequest) schedule() (models.FeeSchedule, error) {
+	s := models.FeeSchedule{
+		Name:            r.Name,
+		AccountType:     r.AccountType,
+		TransactionType: r.TransactionType,
+		Channel:         r.Channel,
+		Currency:        r.Currency,
+		FlatFee:         r.FlatFee,
+		Percent:         r.Percent,
+		MinFee:          r.MinFee,
+		MaxFee:          r.MaxFee,
+	}
+	from, err := businessdays.ParseDate(r.EffectiveFrom)
+	if err != nil {
+		return s, err
+	}
+	s.EffectiveFrom = from
+	if r.EffectiveTo != "" {
+		to, err := businessdays.ParseDate(r.EffectiveTo)
+		if err != nil {
+			return s, err
+		}
+		s.EffectiveTo = &to
+	}
+	return s, nil
+}
+
+// checkFeeSchedule validates a schedule and that no other schedule overlaps it, responding when it fails
+func checkFeeSchedule(c *gin.Context, db *gorm.DB, s *models.FeeSchedule) bool {
+	if err := fees.Validate(s); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "INVALID_FEE_SCHEDULE"})
+		return false
+	}
+	if s.AccountType != "" && !checkAccountType(c, db, s.AccountType) {
+		return false
+	}
+	other, found, err := fees.Conflict(db, *s)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check fee schedules"})
+		return false
+	}
+	if found {
+		c.JSON(http.StatusConflict, gin.H{"error": fees.ErrOverlap.Error(), "code": "FEE_SCHEDULE_OVERLAP", "conflicting_id": other.ID})
+		return false
+	}
+	return true
+}
+
+// GetFeeSchedules lists fee schedules; ?transaction_type=, ?currency= and ?account_type= filter,
+// and ?active_on=YYYY-MM-DD keeps those in effect on that day
+func GetFeeSchedules(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var filter listFilter
+		for _, field := range []string{"transaction_type", "currency", "account_type"} {
+			if value := c.Query(field); value != "" {
+				filter.where(field+" = ?", value)
+			}
+		}
+		var schedules []models.FeeSchedule
+		if err := filter.apply(db).Order("transaction_type, currency, account_type, channel, effective_from").Find(&schedules).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve fee schedules"})
+			return
+		}
+		if activeOn := c.Query("active_on"); activeOn != "" {
+			day, err := businessdays.ParseDate(activeOn)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid active_on date, expected YYYY-MM-DD"})
+				return
+			}
+			active := schedules[:0]
+			for _, s := range schedules {
+				if fees.InEffect(s, day) {
+					active = append(active, s)
+				}
+			}
+			schedules = active
+		}
+		c.JSON(http.StatusOK, gin.H{"fee_schedules": schedules})
+	}
+}
+
+// CreateFeeSchedule adds a fee schedule
+// Body: {"name": "Online transfer fee", "transaction_type": "transfer", "channel": "online", "currency": "USD",
+// "percent": 0.5, "min_fee": 2, "effective_from": "2025-01-01"}
+func CreateFeeSchedule(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req feeScheduleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name and effective_from are required"})
+			return
+		}
+		schedule, err := req.schedule()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+			return
+		}
+		schedule.CreatedBy = actor(c)
+		if !checkFeeSchedule(c, db, &schedule) {
+			return
+		}
+		if err := db.Create(&schedule).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create fee schedule"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Fee schedule created", "fee_schedule": schedule})
+	}
+}
+
+// UpdateFeeSchedule replaces a fee schedule
+// Once a schedule has charged fees only its name and end date can change, and it cannot end before the
+// last fee it charged; a new price takes effect through a new schedule starting the day after
+func UpdateFeeSchedule(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var existing models.FeeSchedule
+		if err := db.First(&existing, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Fee schedule not found"})
+			return
+		}
+		var req feeScheduleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name and effective_from are required"})
+			return
+		}
+		schedule, err := req.schedule()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+			return
+		}
+		schedule.ID, schedule.CreatedAt, schedule.TenantID, schedule.CreatedBy = existing.ID, existing.CreatedAt, existing.TenantID, existing.CreatedBy
+		if !checkFeeSchedule(c, db, &schedule) {
+			return
+		}
+
+		last, charged, err := fees.LastCharged(db, existing.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update fee schedule"})
+			return
+		}
+		if charged {
+			pricing := existing
+			pricing.Name, pricing.EffectiveTo = schedule.Name, schedule.EffectiveTo
+			if schedule.AccountType != existing.AccountType || schedule.TransactionType != existing.TransactionType ||
+				schedule.Channel != existing.Channel || schedule.Currency != existing.Currency ||
+				schedule.FlatFee != existing.FlatFee || schedule.Percent != existing.Percent ||
+				schedule.MinFee != existing.MinFee || schedule.MaxFee != existing.MaxFee ||
+				!schedule.EffectiveFrom.Equal(existing.EffectiveFrom) {
+				c.JSON(http.StatusConflict, gin.H{
+					"error": "This schedule has charged fees; end it with effective_to and create a new schedule for the new price",
+					"code":  "FEE_SCHEDULE_IN_USE",
+				})
+				return
+			}
+			if !fees.InEffect(pricing, last.EffectiveDate) {
+				c.JSON(http.StatusConflict, gin.H{
+					"error":           "effective_to cannot be before the last fee this schedule charged",
+					"code":            "FEE_SCHEDULE_IN_USE",
+					"last_charged_on": businessdays.Format(last.EffectiveDate),
+				})
+				return
+			}
+		}
+
+		if err := db.Save(&schedule).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update fee schedule"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Fee schedule updated", "fee_schedule": schedule})
+	}
+}
+
+// DeleteFeeSchedule removes a schedule that has never charged a fee; one that has is ended with effective_to instead
+func DeleteFeeSchedule(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var schedule models.FeeSchedule
+		if err := db.First(&schedule, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Fee schedule not found"})
+			return
+		}
+		_, charged, err := fees.LastCharged(db, schedule.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete fee schedule"})
+			return
+		}
+		if charged {
+			c.JSON(http.StatusConflict, gin.H{"error": "This schedule has charged fees; end it with effective_to instead", "code": "FEE_SCHEDULE_IN_USE"})
+			return
+		}
+		if err := db.Delete(&schedule).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete fee schedule"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Fee schedule deleted"})
+	}
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"banking-app/events"
+	"banking-app/flags"
+	"banking-app/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== FEATURE FLAG HANDLERS ====================
+
+// featureFlagRequest carries the client-settable fields of a flag
+type featureFlagRequest struct {
+	Key            string  `json:"key"`
+	Description    *string `json:"description"`
+	Enabled        *bool   `json:"enabled"`
+	RolloutPercent *int    `json:"rollout_percent"`
+}
+
+// actor returns the username of the authenticated caller for change metadata
+func actor(c *gin.Context) string {
+	if username, ok := c.Get("username"); ok {
+		if name, ok := username.(string); ok && name != "" {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// saveFeatureFlag stores a flag with its change event and refreshes the local cache
+func saveFeatureFlag(db *gorm.DB, store *flags.Store, flag *models.FeatureFlag, action string) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if action == "deleted" {
+			err = tx.Delete(flag).Error
+		} else {
+			err = tx.Save(flag).Error
+		}
+		if err != nil {
+			return err
+		}
+		return events.Record(tx, events.AggregateFeatureFlag, flag.ID, events.FeatureFlagChanged, gin.H{
+			"key":             flag.Key,
+			"action":          action,
+			"enabled":         flag.Enabled,
+			"rollout_percent": flag.RolloutPercent,
+			"updated_by":      flag.UpdatedBy,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	// Other instances pick the change up from the event or their periodic refresh
+	return store.Refresh()
+}
+
+// GetFeatureFlags lists every flag with its last-changed metadata
+func GetFeatureFlags(db *gorm.DB, store *flags.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var all []models.FeatureFlag
+		if err := db.Order("key").Find(&all).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve feature flags"})
+			return
+		}
+
+		_, refreshed := store.All()
+		c.JSON(http.StatusOK, gin.H{
+			"flags":              all,
+			"cache_refreshed_at": refreshed,
+		})
+	}
+}
+
+// CreateFeatureFlag adds a new flag; new flags default to disabled with a full rollout
+func CreateFeatureFlag(db *gorm.DB, store *flags.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req featureFlagRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.Key == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		var existing int64
+		db.Model(&models.FeatureFlag{}).Where("key = ?", req.Key).Count(&existing)
+		if existing > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Feature flag already exists"})
+			return
+		}
+
+		flag := models.FeatureFlag{Key: req.Key, RolloutPercent: 100, UpdatedBy: actor(c)}
+		if msg := applyFeatureFlagRequest(&flag, req); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		if err := saveFeatureFlag(db, store, &flag, "created"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create feature flag"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Feature flag created successfully",
+			"flag":    flag,
+		})
+	}
+}
+
+// UpdateFeatureFlag toggles a flag or changes its rollout percentage
+func UpdateFeatureFlag(db *gorm.DB, store *flags.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var flag models.FeatureFlag
+		if err := db.Where("key = ?", c.Param("key")).First(&flag).Error; err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Feature flag not found"})
+			return
+		}
+
+		var req featureFlagRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		if msg := applyFeatureFlagRequest(&flag, req); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		flag.UpdatedBy = actor(c)
+
+		if err := saveFeatureFlag(db, store, &flag, "updated"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update feature flag"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Feature flag updated successfully",
+			"flag":    flag,
+		})
+	}
+}
+
+// DeleteFeatureFlag removes a flag; code checking it then treats it as off
+func DeleteFeatureFlag(db *gorm.DB, store *flags.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var flag models.FeatureFlag
+		if err := db.Where("key = ?", c.Param("key")).First(&flag).Error; err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Feature flag not found"})
+			return
+		}
+
+		flag.UpdatedBy = actor(c)
+		if err := saveFeatureFlag(db, store, &flag, "deleted"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete feature flag"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Feature flag deleted successfully"})
+	}
+}
+
+// applyFeatureFlagRequest copies supplied fields onto a flag and validates the result
+func applyFeatureFlagRequest(flag *models.FeatureFlag, req featureFlagRequest) string {
+	if req.Description != nil {
+		flag.Description = *req.Description
+	}
+	if req.Enabled != nil {
+		flag.Enabled = *req.Enabled
+	}
+	if req.RolloutPercent != nil {
+		flag.RolloutPercent = *req.RolloutPercent
+	}
+	if flag.RolloutPercent < 0 || flag.RolloutPercent > 100 {
+		return "rollout_percent must be between 0 and 100"
+	}
+	return ""
+}
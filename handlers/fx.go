@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/fx"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/reports"
+	"banking-app/tenancy"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== FX RATE HANDLERS ====================
+
+// fxRateRequest is a closing rate as admins send it
+type fxRateRequest struct {
+	Currency string  `json:"currency" binding:"required"`
+	Date     string  `json:"date" binding:"required"` // YYYY-MM-DD, UTC
+	Rate     float64 `json:"rate"`
+	Source   string  `json:"source"`
+}
+
+// GetFXRates lists closing rates, latest first; ?currency= filters and ?from=/?to= (YYYY-MM-DD, inclusive) bound the dates
+func GetFXRates(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 50)
+
+		var filter listFilter
+		if currency := c.Query("currency"); currency != "" {
+			filter.where("currency = ?", strings.ToUpper(currency))
+		}
+		for _, bound := range []struct{ param, condition string }{{"from", "date >= ?"}, {"to", "date <= ?"}} {
+			raw := c.Query(bound.param)
+			if raw == "" {
+				continue
+			}
+			day, err := businessdays.ParseDate(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + bound.param + " date, expected YYYY-MM-DD"})
+				return
+			}
+			filter.where(bound.condition, day)
+		}
+
+		var rates []models.FXRate
+		total, err := filter.count(db, &models.FXRate{})
+		if err == nil {
+			err = filter.apply(db).Order("date DESC, currency").Offset(offset).Limit(limit).Find(&rates).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve FX rates"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"rates": rates,
+			"total": total,
+			"page":  page,
+			"limit": limit,
+		})
+	}
+}
+
+// PutFXRate enters or corrects a currency's closing rate for a day
+// Body: {"currency": "EUR", "date": "2025-03-31", "rate": 1.0825, "source": "ECB"}
+// A rate a posted revaluation used is fixed, so corrections cannot silently change posted gains and losses
+func PutFXRate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req fxRateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "currency, date and rate are required"})
+			return
+		}
+		day, err := businessdays.ParseDate(req.Date)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+			return
+		}
+		rate := models.FXRate{Currency: req.Currency, Date: day, Rate: req.Rate, Source: req.Source}
+		if err := fx.ValidateRate(&rate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "INVALID_FX_RATE"})
+			return
+		}
+		if !rate.Date.Before(ledger.DayAfter(clock.Now())) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A closing rate cannot be entered for a future day", "code": "INVALID_FX_RATE"})
+			return
+		}
+		if !checkRateUnused(c, db, rate.Currency, rate.Date) {
+			return
+		}
+
+		var existing models.FXRate
+		if err := db.Where("currency = ? AND date = ?", rate.Currency, rate.Date).Limit(1).Find(&existing).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save FX rate"})
+			return
+		}
+		status, message := http.StatusCreated, "FX rate created"
+		if existing.ID != 0 {
+			rate.ID, rate.CreatedAt, rate.TenantID = existing.ID, existing.CreatedAt, existing.TenantID
+			status, message = http.StatusOK, "FX rate updated"
+		}
+		rate.CreatedBy = actor(c)
+		if err := db.Save(&rate).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save FX rate"})
+			return
+		}
+		c.JSON(status, gin.H{"message": message, "rate": rate})
+	}
+}
+
+// DeleteFXRate removes a closing rate no posted revaluation used
+func DeleteFXRate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var rate models.FXRate
+		if err := db.First(&rate, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "FX rate not found"})
+			return
+		}
+		if !checkRateUnused(c, db, rate.Currency, rate.Date) {
+			return
+		}
+		if err := db.Delete(&rate).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete FX rate"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "FX rate deleted"})
+	}
+}
+
+// checkRateUnused responds with a conflict when a posted revaluation used a currency's rate for a day
+func checkRateUnused(c *gin.Context, db *gorm.DB, currency string, day time.Time) bool {
+	used, err := fx.Revalued(db, currency, day)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check revaluations"})
+		return false
+	}
+	if used {
+		c.JSON(http.StatusConflict, gin.H{"error": "A posted revaluation used this rate", "code": "FX_RATE_IN_USE"})
+		return false
+	}
+	return true
+}
+
+// GetFXRevaluationReport summarizes exposure per foreign currency in the base currency and the revaluation
+// impact of days in ?from= to ?to= (YYYY-MM-DD, inclusive; default this month to date)
+func GetFXRevaluationReport(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		now := clock.Now()
+		from := businessdays.StartOfMonth(now)
+		to := now
+
+		var err error
+		if raw := c.Query("from"); raw != "" {
+			if from, err = businessdays.ParseDate(raw); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+				return
+			}
+		}
+		if raw := c.Query("to"); raw != "" {
+			if to, err = businessdays.ParseDate(raw); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+				return
+			}
+		}
+		if to.Before(from) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+			return
+		}
+
+		report, err := reports.FXRevaluations(db, from, ledger.DayAfter(to))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build FX revaluation report"})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
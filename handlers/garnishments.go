@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"banking-app/cache"
+	"banking-app/garnishments"
+	"banking-app/gl"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== GARNISHMENT ORDER HANDLERS ====================
+
+// garnishmentRequest registers an order on one account with account_id, or on every account of a customer in a
+// currency with customer_id
+type garnishmentRequest struct {
+	AccountID           uint    `json:"account_id"`
+	CustomerID          uint    `json:"customer_id"`
+	Currency            string  `json:"currency"` // Customer-wide orders only; defaults to USD
+	Court               string  `json:"court"`
+	CaseReference       string  `json:"case_reference"`
+	PayeeName           string  `json:"payee_name"`
+	PayeeReference      string  `json:"payee_reference"`
+	TotalAmount         float64 `json:"total_amount"`
+	ProtectedAmount     float64 `json:"protected_amount"`
+	Priority            int     `json:"priority"`              // Ranked with liens; defaults to 1, the most senior
+	RemittanceAccountID uint    `json:"remittance_account_id"` // Defaults to the GARNISHMENTS internal account
+	DocumentIDs         []uint  `json:"document_ids"`
+}
+
+// GarnishmentDetail is an order with its supporting documents and the sweeps made toward it
+type GarnishmentDetail struct {
+	models.GarnishmentOrder
+	Documents []models.Document           `json:"documents"`
+	Payments  []models.GarnishmentPayment `json:"payments"`
+}
+
+// garnishmentDetail loads the documents and payments of an order
+func garnishmentDetail(db *gorm.DB, o models.GarnishmentOrder) (GarnishmentDetail, error) {
+	detail := GarnishmentDetail{GarnishmentOrder: o, Documents: []models.Document{}, Payments: []models.GarnishmentPayment{}}
+	var documentIDs []uint
+	if err := db.Model(&models.GarnishmentDocument{}).Where("order_id = ?", o.ID).Order("id").Pluck("document_id", &documentIDs).Error; err != nil {
+		return detail, err
+	}
+	if len(documentIDs) > 0 {
+		if err := db.Where("id IN ?", documentIDs).Order("id").Find(&detail.Documents).Error; err != nil {
+			return detail, err
+		}
+	}
+	err := db.Where("order_id = ?", o.ID).Order("id").Find(&detail.Payments).Error
+	return detail, err
+}
+
+// respondGarnishmentError maps garnishment errors to client responses
+func respondGarnishmentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, garnishments.ErrInvalid), errors.Is(err, garnishments.ErrDocumentNotFound),
+		errors.Is(err, garnishments.ErrRemittanceAccount):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, garnishments.ErrNotActive), errors.Is(err, garnishments.ErrNotSuspended),
+		errors.Is(err, garnishments.ErrSatisfied):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		respondPostingError(c, err)
+	}
+}
+
+// respondGarnishment answers with an order's detail and the sweeps an action made
+func respondGarnishment(c *gin.Context, db *gorm.DB, status int, o models.GarnishmentOrder, swept []models.GarnishmentPayment) {
+	detail, err := garnishmentDetail(db, o)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve garnishment order"})
+		return
+	}
+	if swept == nil {
+		swept = []models.GarnishmentPayment{}
+	}
+	respondDisplay(c, status, gin.H{"garnishment": detail, "swept": swept})
+}
+
+// invalidateSwept drops the cached balances of the accounts a set of sweeps touched
+func invalidateSwept(balances *cache.Balances, swept []models.GarnishmentPayment) {
+	for _, p := range swept {
+		balances.Invalidate(p.AccountID)
+		balances.Invalidate(p.RemittanceAccountID)
+	}
+}
+
+// loadGarnishment fetches the order named by :id, responding on failure
+func loadGarnishment(c *gin.Context, db *gorm.DB) (models.GarnishmentOrder, bool) {
+	var o models.GarnishmentOrder
+	if err := db.First(&o, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Garnishment order not found"})
+		return o, false
+	}
+	return o, true
+}
+
+// GetGarnishments lists garnishment orders, newest first; ?customer_id=&account_id=&status= filter
+// account_id matches orders on that account and the customer-wide orders covering it
+func GetGarnishments(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 50)
+
+		var filter listFilter
+		if status := c.Query("status"); status != "" {
+			filter.where("status = ?", status)
+		}
+		if customerID := c.Query("customer_id"); customerID != "" {
+			filter.where("customer_id = ?", customerID)
+		}
+		if accountID := c.Query("account_id"); accountID != "" {
+			var account models.Account
+			if err := db.Select("id, customer_id, currency").First(&account, accountID).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+				return
+			}
+			filter.where("customer_id = ? AND currency = ? AND (account_id IS NULL OR account_id = ?)", account.CustomerID, account.Currency, account.ID)
+		}
+
+		var orders []models.GarnishmentOrder
+		total, err := filter.count(db, &models.GarnishmentOrder{})
+		if err == nil {
+			err = filter.apply(db).Order("id DESC").Offset(offset).Limit(limit).Find(&orders).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve garnishment orders"})
+			return
+		}
+		respondDisplay(c, http.StatusOK, gin.H{"garnishments": orders, "total": total, "page": page, "limit": limit})
+	}
+}
+
+// GetGarnishment returns an order with its documents and sweeps
+func GetGarnishment(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		o, ok := loadGarnishment(c, db)
+		if !ok {
+			return
+		}
+		respondGarnishment(c, db, http.StatusOK, o, nil)
+	}
+}
+
+// CreateGarnishment registers a court order, queues the customer's notice and sweeps what the garnished
+// accounts already hold above the protected amount; the response lists those sweeps
+// Documents must be the customer's, typically the order uploaded with kind legal_order
+func CreateGarnishment(db *gorm.DB, balances *cache.Balances) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req garnishmentRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if (req.AccountID == 0) == (req.CustomerID == 0) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Set either account_id or customer_id"})
+			return
+		}
+
+		o := models.GarnishmentOrder{
+			Court:               strings.TrimSpace(req.Court),
+			CaseReference:       strings.TrimSpace(req.CaseReference),
+			PayeeName:           strings.TrimSpace(req.PayeeName),
+			PayeeReference:      strings.TrimSpace(req.PayeeReference),
+			TotalAmount:         req.TotalAmount,
+			ProtectedAmount:     req.ProtectedAmount,
+			Priority:            req.Priority,
+			RemittanceAccountID: req.RemittanceAccountID,
+			RegisteredBy:        actor(c),
+		}
+		if o.Priority == 0 {
+			o.Priority = 1
+		}
+		if req.AccountID != 0 {
+			var account models.Account
+			if err := db.First(&account, req.AccountID).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+				return
+			}
+			if account.AccountType == gl.AccountType {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Internal accounts cannot be garnished"})
+				return
+			}
+			if account.Status == "closed" {
+				c.JSON(http.StatusConflict, gin.H{"error": "Closed accounts cannot be garnished"})
+				return
+			}
+			o.Scope, o.AccountID, o.CustomerID, o.Currency = garnishments.ScopeAccount, &account.ID, account.CustomerID, account.Currency
+		} else {
+			var customer models.Customer
+			if err := db.Select("id").First(&customer, req.CustomerID).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+				return
+			}
+			o.Scope, o.CustomerID, o.Currency = garnishments.ScopeCustomer, customer.ID, strings.ToUpper(strings.TrimSpace(req.Currency))
+			if o.Currency == "" {
+				o.Currency = "USD"
+			}
+		}
+
+		var swept []models.GarnishmentPayment
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			swept, err = garnishments.Register(tx, &o, req.DocumentIDs)
+			return err
+		}); err != nil {
+			respondGarnishmentError(c, err)
+			return
+		}
+		invalidateSwept(balances, swept)
+		respondGarnishment(c, db, http.StatusCreated, o, swept)
+	}
+}
+
+// garnishmentActionRequest carries the reason for a suspension or the note on a manual satisfaction
+type garnishmentActionRequest struct {
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// SuspendGarnishment pauses collection on an active order, such as while the court hears an exemption claim
+func SuspendGarnishment(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req garnishmentActionRequest
+		if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required"})
+			return
+		}
+		o, ok := loadGarnishment(c, db)
+		if !ok {
+			return
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return garnishments.Suspend(tx, &o, actor(c), strings.TrimSpace(req.Reason))
+		}); err != nil {
+			respondGarnishmentError(c, err)
+			return
+		}
+		respondGarnishment(c, db, http.StatusOK, o, nil)
+	}
+}
+
+// ResumeGarnishment restarts collection on a suspended order and sweeps the balances it covers right away
+func ResumeGarnishment(db *gorm.DB, balances *cache.Balances) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		o, ok := loadGarnishment(c, db)
+		if !ok {
+			return
+		}
+		var swept []models.GarnishmentPayment
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			swept, err = garnishments.Resume(tx, &o, actor(c))
+			return err
+		}); err != nil {
+			respondGarnishmentError(c, err)
+			return
+		}
+		invalidateSwept(balances, swept)
+		respondGarnishment(c, db, http.StatusOK, o, swept)
+	}
+}
+
+// SatisfyGarnishment closes an active or suspended order before it is collected in full; the body's note is optional
+// Orders collected in full are satisfied by the sweep that completes them
+func SatisfyGarnishment(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req garnishmentActionRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+				return
+			}
+		}
+		o, ok := loadGarnishment(c, db)
+		if !ok {
+			return
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return garnishments.Satisfy(tx, &o, actor(c), strings.TrimSpace(req.Note))
+		}); err != nil {
+			respondGarnishmentError(c, err)
+			return
+		}
+		respondGarnishment(c, db, http.StatusOK, o, nil)
+	}
+}
+
+// garnishmentDocumentsRequest names further documents for an order
+type garnishmentDocumentsRequest struct {
+	DocumentIDs []uint `json:"document_ids" binding:"required"`
+}
+
+// AttachGarnishmentDocuments adds documents of the order's customer to an order, such as an amended court order
+func AttachGarnishmentDocuments(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req garnishmentDocumentsRequest
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.DocumentIDs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "document_ids is required"})
+			return
+		}
+		o, ok := loadGarnishment(c, db)
+		if !ok {
+			return
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return garnishments.Attach(tx, o, req.DocumentIDs)
+		}); err != nil {
+			respondGarnishmentError(c, err)
+			return
+		}
+		respondGarnishment(c, db, http.StatusOK, o, nil)
+	}
+}
@@ -1,7 +1,38 @@
 package handlers
 
 import (
+	"banking-app/alerts"
+	"banking-app/archive"
+	"banking-app/auth"
+	"banking-app/businessdays"
+	"banking-app/cache"
+	"banking-app/clock"
+	"banking-app/creditbureau"
+	"banking-app/creditlines"
+	"banking-app/duplicates"
+	"banking-app/eligibility"
+	"banking-app/enrichment"
+	"banking-app/events"
+	"banking-app/fees"
+	"banking-app/finance"
+	"banking-app/flags"
+	"banking-app/i18n"
+	"banking-app/invariants"
+	"banking-app/ledger"
+	"banking-app/lifecycle"
+	"banking-app/liens"
+	"banking-app/loans"
+	"banking-app/middleware"
 	"banking-app/models"
+	"banking-app/restrictions"
+	"banking-app/reviews"
+	"banking-app/search"
+	"banking-app/statushistory"
+	"banking-app/tags"
+	"banking-app/tenancy"
+	"banking-app/usage"
+	"banking-app/verification"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
@@ -17,12 +48,6 @@ func generateAccountNumber() string {
 	return "ACC" + time.Now().Format("20060102150405") + strconv.Itoa(int(time.Now().UnixNano()%1000))
 }
 
-// Utility function to generate unique transaction IDs
-// Critical for audit trails and transaction tracking
-func generateTransactionID() string {
-	return "TXN" + time.Now().Format("20060102150405") + strconv.Itoa(int(time.Now().UnixNano()%1000))
-}
-
 // Utility function to generate unique loan numbers
 // Important for loan tracking and regulatory compliance
 func generateLoanNumber() string {
@@ -33,26 +58,68 @@ func generateLoanNumber() string {
 
 // GetCustomers retrieves all customers with pagination support
 // Important for customer management and regulatory reporting
+// Returns summaries with account/loan counts; ?include=accounts,loans adds the collections
+// ?tag= lists only customers with that tag
 func GetCustomers(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
 		// Parse pagination parameters
-		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-		offset := (page - 1) * limit
-
-		// Query customers with pagination
-		var customers []models.Customer
-		var total int64
+		page, limit, offset := parsePagination(c, 10)
+		includes := parseIncludes(c.Query("include"))
+
+		// Query customer summaries with pagination
+		var customers []CustomerSummary
+		var filter listFilter
+		tag, ok := tagQuery(c, "tag")
+		if !ok {
+			return
+		}
+		if tag != "" {
+			filter.where("customers.id IN (?)", tags.Tagged(db, tags.SubjectCustomer, tag))
+		}
 
-		db.Model(&models.Customer{}).Count(&total)
-		err := db.Preload("Accounts").Preload("Loans").Offset(offset).Limit(limit).Find(&customers).Error
+		total, err := filter.count(db, &models.Customer{})
+		if err == nil {
+			err = filter.apply(db.Model(&models.Customer{})).Select(customerSummaryColumns).
+				Order("customers.id").Offset(offset).Limit(limit).Scan(&customers).Error
+		}
 		
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve customers"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
+		// Load requested collections for the whole page in one query each
+		ids := make([]uint, len(customers))
+		index := make(map[uint]int, len(customers))
+		for i, customer := range customers {
+			ids[i] = customer.ID
+			index[customer.ID] = i
+		}
+		if includes["accounts"] && len(ids) > 0 {
+			var accounts []models.Account
+			if err := db.Where("customer_id IN ?", ids).Find(&accounts).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve customers"})
+				return
+			}
+			for _, account := range accounts {
+				i := index[account.CustomerID]
+				customers[i].Accounts = append(customers[i].Accounts, account)
+			}
+		}
+		if includes["loans"] && len(ids) > 0 {
+			var loans []models.Loan
+			if err := db.Where("customer_id IN ?", ids).Find(&loans).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve customers"})
+				return
+			}
+			for _, loan := range loans {
+				i := index[loan.CustomerID]
+				customers[i].Loans = append(customers[i].Loans, loan)
+			}
+		}
+
+		respondDisplay(c, http.StatusOK, gin.H{
 			"customers": customers,
 			"total":     total,
 			"page":      page,
@@ -63,8 +130,10 @@ func GetCustomers(db *gorm.DB) gin.HandlerFunc {
 
 // GetCustomer retrieves a single customer by ID with related data
 // Essential for customer service and account access
+// ?include=notes adds the notes the caller may see
 func GetCustomer(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
 		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
@@ -72,26 +141,70 @@ func GetCustomer(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		var customer models.Customer
-		err = db.Preload("Accounts").Preload("Loans").First(&customer, uint(id)).Error
+		err = db.Preload("Accounts").First(&customer, uint(id)).Error
 		
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
 			return
 		}
 		
+		// Loans include those the customer is a co-borrower or guarantor on
+		var partyLoans []loans.CustomerLoan
+		if err == nil {
+			partyLoans, err = loans.CustomerLoans(db, customer.ID)
+		}
+		var plans []models.InstallmentPlan
+		if err == nil {
+			err = db.Where("customer_id = ?", customer.ID).Order("id").Find(&plans).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		// Validator covers the customer and every nested account and loan
+		parts := []interface{}{"customer", customer.ID, customer.UpdatedAt.UnixNano()}
+		for _, account := range customer.Accounts {
+			parts = append(parts, account.ID, account.Version, account.UpdatedAt.UnixNano())
+		}
+		for _, loan := range partyLoans {
+			parts = append(parts, loan.ID, loan.UpdatedAt.UnixNano(), loan.Role)
+		}
+		for _, plan := range plans {
+			parts = append(parts, "plan", plan.ID, plan.UpdatedAt.UnixNano())
+		}
+		customerTags, err := tags.For(db, tags.SubjectCustomer, []uint{customer.ID})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 			return
 		}
+		for _, tag := range customerTags[customer.ID] {
+			parts = append(parts, "tag", tag)
+		}
+		var notes map[uint][]models.Note
+		if parseIncludes(c.Query("include"))["notes"] {
+			if notes, err = visibleNotes(c, db, "customer", []uint{customer.ID}); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			for _, note := range notes[customer.ID] {
+				parts = append(parts, "note", note.ID)
+			}
+		}
+		if notModified(c, weakETag(parts...), customerMaxAge) {
+			return
+		}
 
-		c.JSON(http.StatusOK, customer)
+		respondDisplay(c, http.StatusOK, CustomerDetail{Customer: customer, Loans: partyLoans, InstallmentPlans: plans, Tags: tagList(customerTags[customer.ID]), Notes: notes[customer.ID]})
 	}
 }
 
 // CreateCustomer creates a new customer record
 // Core banking function - first step in customer onboarding
-func CreateCustomer(db *gorm.DB) gin.HandlerFunc {
+// The email address starts unverified; a verification token is emailed to it with the new record
+func CreateCustomer(db *gorm.DB, verifyCfg verification.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
 		var customer models.Customer
 		
 		// Validate and bind JSON request
@@ -106,11 +219,36 @@ func CreateCustomer(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		if customer.KYCLevel != 0 && !canManageEligibility(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Setting kyc_level requires the eligibility permission"})
+			return
+		}
+		if !checkPreferredLanguage(c, &customer.PreferredLanguage) {
+			return
+		}
+
 		// Set default values
 		customer.Status = "active"
+		customer.EmailVerified, customer.EmailVerifiedAt, customer.PendingEmail = false, nil, ""
 		
-		// Create customer record
-		if err := db.Create(&customer).Error; err != nil {
+		// Create customer record, its verification email and its outbox event atomically
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&customer).Error; err != nil {
+				return err
+			}
+			if err := statushistory.Created(tx, customer.TenantID, statushistory.SubjectCustomer, customer.ID, customer.Status, actor(c)); err != nil {
+				return err
+			}
+			if _, err := verification.Issue(tx, verifyCfg, customer, customer.Email, verification.PurposeSignup, clock.Now()); err != nil {
+				return err
+			}
+			return events.Record(tx, events.AggregateCustomer, customer.ID, events.CustomerCreated, gin.H{
+				"customer_id": customer.ID,
+				"email":       customer.Email,
+				"status":      customer.Status,
+			})
+		})
+		if err != nil {
 			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
 				c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
 				return
@@ -118,6 +256,7 @@ func CreateCustomer(db *gorm.DB) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create customer"})
 			return
 		}
+		middleware.AuditCustomer(c, customer.ID)
 
 		c.JSON(http.StatusCreated, gin.H{
 			"message":  "Customer created successfully",
@@ -128,8 +267,10 @@ func CreateCustomer(db *gorm.DB) gin.HandlerFunc {
 
 // UpdateCustomer updates existing customer information
 // Important for customer data maintenance and regulatory compliance
-func UpdateCustomer(db *gorm.DB) gin.HandlerFunc {
+// A new email address is held as pending_email until it is confirmed; the current one stays in use meanwhile
+func UpdateCustomer(db *gorm.DB, verifyCfg verification.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
 		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
@@ -144,51 +285,191 @@ func UpdateCustomer(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		var updateData models.Customer
-		if err := c.ShouldBindJSON(&updateData); err != nil {
+		// status_reason is recorded in the status history when status changes
+		var req struct {
+			models.Customer
+			StatusReason string `json:"status_reason"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
 			return
 		}
+		updateData := req.Customer
 
-		// Update customer information
-		if err := db.Model(&customer).Updates(updateData).Error; err != nil {
+		if updateData.KYCLevel != 0 && !canManageEligibility(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Setting kyc_level requires the eligibility permission"})
+			return
+		}
+		if !checkPreferredLanguage(c, &updateData.PreferredLanguage) {
+			return
+		}
+
+		if updateData.Status != "" && !authorizeTransition(c, lifecycle.Customer, customer.Status, updateData.Status, req.StatusReason) {
+			return
+		}
+
+		// Verification state only changes through a confirmed token
+		email := strings.TrimSpace(updateData.Email)
+		updateData.Email, updateData.EmailVerified, updateData.EmailVerifiedAt, updateData.PendingEmail = "", false, nil, ""
+
+		// Update customer information, recording a status change in its history
+		previous := customer.Status
+		reason := strings.TrimSpace(req.StatusReason)
+		if reason == "" {
+			reason = statushistory.ReasonUpdated
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&customer).Updates(updateData).Error; err != nil {
+				return err
+			}
+			err := statushistory.Record(tx, models.StatusHistory{
+				TenantID:    customer.TenantID,
+				SubjectType: statushistory.SubjectCustomer,
+				SubjectID:   customer.ID,
+				OldStatus:   previous,
+				NewStatus:   customer.Status,
+				Reason:      reason,
+				ChangedBy:   actor(c),
+			})
+			if err != nil {
+				return err
+			}
+			if email == "" {
+				return nil
+			}
+			return verification.RequestChange(tx, verifyCfg, &customer, email, clock.Now())
+		})
+		if err == verification.ErrEmailTaken {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already exists", "code": "EMAIL_TAKEN"})
+			return
+		}
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update customer"})
 			return
 		}
 
+		message := "Customer updated successfully"
+		if email != "" && customer.PendingEmail != "" {
+			message = "Customer updated successfully; confirm the new email address to complete the change"
+		}
 		c.JSON(http.StatusOK, gin.H{
-			"message":  "Customer updated successfully",
+			"message":  message,
 			"customer": customer,
 		})
 	}
 }
 
+// checkPreferredLanguage normalizes a requested preferred_language to a shipped locale, e.g. es-MX to es
+// Unsupported languages are refused with 400; an empty one leaves the customer following Accept-Language
+func checkPreferredLanguage(c *gin.Context, language *string) bool {
+	if *language == "" {
+		return true
+	}
+	matched, ok := i18n.Match(*language)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "preferred_language must be one of: " + strings.Join(i18n.Supported(), ", "),
+			"code":  "UNSUPPORTED_LANGUAGE",
+		})
+		return false
+	}
+	*language = matched
+	return true
+}
+
+// deletionBlocker is one reason a customer cannot be deleted yet
+type deletionBlocker struct {
+	Reason     string `json:"reason"` // open_accounts, nonzero_balance, open_loans
+	Message    string `json:"message"`
+	AccountIDs []uint `json:"account_ids,omitempty"`
+	LoanIDs    []uint `json:"loan_ids,omitempty"`
+}
+
+// customerDeletionBlockers lists what keeps a customer from being deleted: accounts not yet closed, accounts of
+// any status still holding a balance, and loans the customer is liable for that are not paid off
+func customerDeletionBlockers(db *gorm.DB, customerID uint) ([]deletionBlocker, error) {
+	var blockers []deletionBlocker
+	var open, funded, liable, loanIDs []uint
+	if err := db.Model(&models.Account{}).Where("customer_id = ? AND status <> ?", customerID, "closed").Order("id").Pluck("id", &open).Error; err != nil {
+		return nil, err
+	}
+	if len(open) > 0 {
+		blockers = append(blockers, deletionBlocker{Reason: "open_accounts", Message: "Accounts must be closed first", AccountIDs: open})
+	}
+	if err := db.Model(&models.Account{}).Where("customer_id = ? AND balance <> 0", customerID).Order("id").Pluck("id", &funded).Error; err != nil {
+		return nil, err
+	}
+	if len(funded) > 0 {
+		blockers = append(blockers, deletionBlocker{Reason: "nonzero_balance", Message: "Accounts still hold a balance; sweep or settle it first", AccountIDs: funded})
+	}
+
+	// Co-borrowers and guarantors answer for a loan as much as its borrower does
+	if err := db.Model(&models.LoanParty{}).Where("customer_id = ?", customerID).Pluck("loan_id", &liable).Error; err != nil {
+		return nil, err
+	}
+	err := db.Model(&models.Loan{}).Where("status <> ? AND (customer_id = ? OR id IN ?)", "paid_off", customerID, liable).
+		Order("id").Pluck("id", &loanIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(loanIDs) > 0 {
+		blockers = append(blockers, deletionBlocker{Reason: "open_loans", Message: "Loans the customer is liable for must be paid off first", LoanIDs: loanIDs})
+	}
+	return blockers, nil
+}
+
 // DeleteCustomer performs soft delete of customer record
 // Important for data retention policies and audit trails
+// Refused with 409 and every blocking reason while the customer has open accounts, balances or loans;
+// otherwise their closed accounts are soft-deleted with them, and the accounts' transactions are kept
 func DeleteCustomer(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
 		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
 			return
 		}
 
-		// Check for active accounts before deletion
-		var activeAccounts int64
-		db.Model(&models.Account{}).Where("customer_id = ? AND status = 'active'", uint(id)).Count(&activeAccounts)
-		
-		if activeAccounts > 0 {
-			c.JSON(http.StatusConflict, gin.H{"error": "Cannot delete customer with active accounts"})
+		var customer models.Customer
+		if err := db.First(&customer, uint(id)).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve customer"})
 			return
 		}
 
-		err = db.Delete(&models.Customer{}, uint(id)).Error
+		// Checked inside the transaction so an account opened or funded meanwhile is not deleted with the customer
+		var blockers []deletionBlocker
+		var accountsDeleted int64
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if blockers, err = customerDeletionBlockers(tx, customer.ID); err != nil || len(blockers) > 0 {
+				return err
+			}
+			result := tx.Where("customer_id = ? AND status = ? AND balance = 0", customer.ID, "closed").Delete(&models.Account{})
+			if result.Error != nil {
+				return result.Error
+			}
+			accountsDeleted = result.RowsAffected
+			return tx.Delete(&customer).Error
+		})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete customer"})
 			return
 		}
+		if len(blockers) > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":    "Customer still has open accounts, balances or loans",
+				"code":     "CUSTOMER_HAS_OBLIGATIONS",
+				"blocking": blockers,
+			})
+			return
+		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Customer deleted successfully"})
+		c.JSON(http.StatusOK, gin.H{"message": "Customer deleted successfully", "accounts_deleted": accountsDeleted})
 	}
 }
 
@@ -198,22 +479,30 @@ func DeleteCustomer(db *gorm.DB) gin.HandlerFunc {
 // Essential for account management and reporting
 func GetAccounts(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-		offset := (page - 1) * limit
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 10)
 
 		var accounts []models.Account
-		var total int64
+		var filter listFilter
+		tag, ok := tagQuery(c, "tag")
+		if !ok {
+			return
+		}
+		if tag != "" {
+			filter.where("accounts.id IN (?)", tags.Tagged(db, tags.SubjectAccount, tag))
+		}
 
-		db.Model(&models.Account{}).Count(&total)
-		err := db.Preload("Customer").Offset(offset).Limit(limit).Find(&accounts).Error
+		total, err := filter.count(db, &models.Account{})
+		if err == nil {
+			err = filter.apply(db.Preload("Customer")).Order("id").Offset(offset).Limit(limit).Find(&accounts).Error
+		}
 		
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve accounts"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
+		respondDisplay(c, http.StatusOK, gin.H{
 			"accounts": accounts,
 			"total":    total,
 			"page":     page,
@@ -223,8 +512,10 @@ func GetAccounts(db *gorm.DB) gin.HandlerFunc {
 }
 
 // GetAccount retrieves a single account with transaction history
+// ?include=notes adds the notes the caller may see; staff also get the active liens, customers a summary of them
 func GetAccount(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
 		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
@@ -239,7 +530,41 @@ func GetAccount(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusOK, account)
+		accountTags, err := tags.For(db, tags.SubjectAccount, []uint{account.ID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		detail := AccountDetail{Account: account, Tags: tagList(accountTags[account.ID])}
+		if parseIncludes(c.Query("include"))["notes"] {
+			notes, err := visibleNotes(c, db, "account", []uint{account.ID})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			detail.Notes = notes[account.ID]
+		}
+		// Staff see active liens in full; customers only what they hold
+		if seesLienDetails(c) {
+			active, err := liens.Active(db, account.ID)
+			if err == nil {
+				detail.Liens, err = lienDetails(db, active)
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+		} else if summary, err := lienSummary(db, account); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		} else if summary.Count > 0 {
+			detail.LienSummary = &summary
+		}
+		if err := accountRates(db, &detail); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		respondDisplay(c, http.StatusOK, detail)
 	}
 }
 
@@ -247,12 +572,14 @@ func GetAccount(db *gorm.DB) gin.HandlerFunc {
 // Core banking function - account opening process
 func CreateAccount(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var account models.Account
+		db := tenancy.DB(c, db)
+		var req createAccountRequest
 		
-		if err := c.ShouldBindJSON(&account); err != nil {
+		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
 			return
 		}
+		account := req.Account
 
 		// Validate customer exists
 		var customer models.Customer
@@ -260,84 +587,284 @@ func CreateAccount(db *gorm.DB) gin.HandlerFunc {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
 			return
 		}
+		middleware.AuditCustomer(c, customer.ID)
+
+		if account.OverdraftLimit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Overdraft limit must not be negative"})
+			return
+		}
+		if !checkAccountType(c, db, account.AccountType) {
+			return
+		}
+
+		// Accounts open in the tenant's default currency unless a supported one is asked for
+		requested := account.Currency
+		account.Currency = strings.ToUpper(strings.TrimSpace(requested))
+		if account.Currency == "" {
+			account.Currency = tenancy.CurrentSettings(c).DefaultCurrency
+		} else if !tenancy.SupportedCurrency(account.Currency) {
+			(&apiError{Status: http.StatusBadRequest, Code: "UNSUPPORTED_CURRENCY", Message: "Unsupported currency", v1Code: true,
+				Details: gin.H{"currency": requested, "supported": tenancy.SupportedCurrencies}}).respondV1(c)
+			return
+		}
 
-		// Set default values and generate account number
-		account.AccountNumber = generateAccountNumber()
-		account.Balance = 0.0
-		account.Currency = "USD"
-		account.Status = "active"
+		// Products in the catalog carry eligibility rules; staff may waive failed criteria with a reason
+		overrides, ok := checkEligibility(c, db, account, customer, req.Overrides)
+		if !ok {
+			return
+		}
 
-		if err := db.Create(&account).Error; err != nil {
+		product, err := eligibility.Lookup(db, account.AccountType)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
+			return
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			return openAccount(c, tx, &account, product, overrides)
+		})
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
 			return
 		}
 
-		c.JSON(http.StatusCreated, gin.H{
+		response := gin.H{
 			"message": "Account created successfully",
 			"account": account,
-		})
+		}
+		if len(overrides) > 0 {
+			response["eligibility_overrides"] = overrides
+		}
+		c.JSON(http.StatusCreated, response)
 	}
 }
 
+// openAccount stores a new account with its product's defaults, its first status and any eligibility overrides,
+// and records the account.opened event. tx must be the transaction the account is opened in
+func openAccount(c *gin.Context, tx *gorm.DB, account *models.Account, product *models.Product, overrides []models.EligibilityOverride) error {
+	// Restrictions come from the product; staff with the restrictions permission change them per account
+	account.Restrictions = models.AccountRestrictions{}
+	if product != nil {
+		account.Restrictions = product.Restrictions
+		// The product's overdraft limit applies unless the request sets one
+		if product.OverdraftLimit != nil && account.OverdraftLimit == 0 {
+			account.OverdraftLimit = *product.OverdraftLimit
+		}
+	}
+
+	// Set default values and generate account number
+	account.AccountNumber = generateAccountNumber()
+	account.Balance = 0.0
+	account.Status = "active"
+
+	if err := tx.Create(account).Error; err != nil {
+		return err
+	}
+	if err := statushistory.Created(tx, account.TenantID, statushistory.SubjectAccount, account.ID, account.Status, actor(c)); err != nil {
+		return err
+	}
+	for i := range overrides {
+		overrides[i].AccountID = account.ID
+		if err := tx.Create(&overrides[i]).Error; err != nil {
+			return err
+		}
+	}
+	return events.Record(tx, events.AggregateAccount, account.ID, events.AccountOpened, gin.H{
+		"account_id":     account.ID,
+		"account_number": account.AccountNumber,
+		"customer_id":    account.CustomerID,
+		"account_type":   account.AccountType,
+		"currency":       account.Currency,
+	})
+}
+
 // GetAccountBalance retrieves current balance for an account
-// Critical for real-time balance inquiries
-func GetAccountBalance(db *gorm.DB) gin.HandlerFunc {
+// Critical for real-time balance inquiries; ?as_of=YYYY-MM-DD gives a past day's closing balance
+// Served from the in-process balance cache when possible, with ETag revalidation; held_amount totals the debits
+// held for review and the pending pre-authorizations
+func GetAccountBalance(db *gorm.DB, balances *cache.Balances) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
 		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
 			return
 		}
 
-		var account models.Account
-		err = db.Select("id, account_number, balance, currency, status").First(&account, uint(id)).Error
-		
+		// ?as_of=YYYY-MM-DD answers with the balance at the end of that day instead
+		if asOf := c.Query("as_of"); asOf != "" {
+			respondBalanceAsOf(c, db, uint(id), asOf)
+			return
+		}
+
+		// A cached entry of another tenant is treated as a miss so the scoped query answers 404
+		entry, err := balances.Load(uint(id), tenancy.Current(c).ID, func() (cache.BalanceEntry, error) {
+			var account models.Account
+			err := db.Select("id, tenant_id, account_number, balance, currency, status, version").First(&account, uint(id)).Error
+			return cache.Entry(account), err
+		})
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
 			return
 		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"account_id":    account.ID,
-			"account_number": account.AccountNumber,
-			"balance":       account.Balance,
-			"currency":      account.Currency,
-			"status":        account.Status,
-		})
+		// A linked line of credit adds its available credit
+		line, linked, err := creditlines.Linked(db, entry.AccountID)
+		var usage creditlines.Utilization
+		if err == nil && linked {
+			usage, err = creditlines.Usage(db, line)
+		}
+		// Amounts held for reviews and pre-authorizations are read fresh, so a released hold shows at once
+		var held float64
+		if err == nil {
+			held, err = ledger.Held(db, entry.AccountID)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		if notModified(c, weakETag("balance", entry.AccountID, entry.Version, entry.Status, line.ID, usage.AvailableCredit, held), balanceMaxAge) {
+			return
+		}
+
+		body := gin.H{
+			"account_id":    entry.AccountID,
+			"account_number": entry.AccountNumber,
+			"balance":       entry.Balance,
+			"currency":      entry.Currency,
+			"status":        entry.Status,
+			"held_amount":   held,
+		}
+		if linked {
+			body["credit_line_id"] = line.ID
+			body["credit_limit"] = line.CreditLimit
+			body["available_credit"] = usage.AvailableCredit
+		}
+		respondDisplay(c, http.StatusOK, body)
 	}
 }
 
 // GetAccountTransactions retrieves transaction history for an account
 // Important for account statements and audit trails
-func GetAccountTransactions(db *gorm.DB) gin.HandlerFunc {
+// Supports ?q= full-text search plus type, date, and amount filters
+func GetAccountTransactions(db *gorm.DB, searcher search.TransactionSearcher) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
 		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
 			return
 		}
 
-		var transactions []models.Transaction
-		err = db.Where("account_id = ?", uint(id)).Order("created_at DESC").Find(&transactions).Error
-		
+		filter, err := parseTransactionFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter.AccountID = uint(id)
+
+		// Amounts are displayed in the account's currency
+		display := displayOptions(c)
+		var account models.Account
+		if err := db.Select("id, currency").First(&account, uint(id)).Error; err == nil && account.Currency != "" {
+			display.Currency = account.Currency
+		}
+
+		// Full-text search returns ranked, paginated results
+		if filter.Query != "" {
+			page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+			limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+			filter.Offset = (page - 1) * limit
+			filter.Limit = limit
+
+			transactions, total, err := searcher.Search(db, filter)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search transactions"})
+				return
+			}
+
+			usage.Rows(c, len(transactions))
+			respondDisplayWith(c, http.StatusOK, gin.H{
+				"account_id":   uint(id),
+				"query":        filter.Query,
+				"transactions": transactions,
+				"total":        total,
+				"page":         page,
+				"limit":        limit,
+			}, display)
+			return
+		}
+
+		// The unsearched history is every transaction on the account, so it is streamed rather than built in memory
+		// A range reaching back past the hot table reads the archive too, which is slower, and says so
+		postings := db.Model(&models.Transaction{})
+		fields := gin.H{"account_id": uint(id)}
+		archived, err := archive.Reaches(db, uint(id), filter.From)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve transactions"})
 			return
 		}
+		if archived {
+			postings = archive.Transactions(db)
+			fields["includes_archive"] = true
+		}
+		query := search.ApplyFilters(postings, filter).Order("created_at DESC")
+		streamDisplayList(c, query, func() interface{} { return &models.Transaction{} },
+			fields, "transactions", display)
+	}
+}
 
-		c.JSON(http.StatusOK, gin.H{
-			"account_id":   uint(id),
-			"transactions": transactions,
-		})
+// parseTransactionFilter reads the shared transaction query parameters
+// Dates use YYYY-MM-DD; the to date is inclusive of the whole day
+func parseTransactionFilter(c *gin.Context) (search.TransactionFilter, error) {
+	filter := search.TransactionFilter{
+		Query: strings.TrimSpace(c.Query("q")),
+		Type:  c.Query("type"),
+	}
+
+	if from := c.Query("from"); from != "" {
+		t, err := businessdays.ParseDate(from)
+		if err != nil {
+			return filter, errors.New("Invalid from date, expected YYYY-MM-DD")
+		}
+		filter.From = &t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := businessdays.ParseDate(to)
+		if err != nil {
+			return filter, errors.New("Invalid to date, expected YYYY-MM-DD")
+		}
+		end := t.AddDate(0, 0, 1)
+		filter.To = &end
+	}
+	if min := c.Query("min_amount"); min != "" {
+		v, err := strconv.ParseFloat(min, 64)
+		if err != nil {
+			return filter, errors.New("Invalid min_amount")
+		}
+		filter.MinAmount = &v
 	}
+	if max := c.Query("max_amount"); max != "" {
+		v, err := strconv.ParseFloat(max, 64)
+		if err != nil {
+			return filter, errors.New("Invalid max_amount")
+		}
+		filter.MaxAmount = &v
+	}
+	return filter, nil
 }
 
 // ==================== TRANSACTION HANDLERS ====================
 
 // CreateTransaction processes financial transactions (deposits, withdrawals)
 // Core banking function - money movement processing
-func CreateTransaction(db *gorm.DB) gin.HandlerFunc {
+func CreateTransaction(db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store, reviewConfig reviews.Config, duplicateConfig duplicates.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
 		var transaction models.Transaction
 		
 		if err := c.ShouldBindJSON(&transaction); err != nil {
@@ -345,121 +872,200 @@ func CreateTransaction(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Validate transaction type
-		validTypes := []string{"deposit", "withdrawal", "transfer", "payment"}
-		if !contains(validTypes, transaction.TransactionType) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction type"})
+		fee, held, apiErr := postTransaction(c, db, balances, featureFlags, reviewConfig, duplicateConfig, &transaction, false)
+		if apiErr != nil {
+			apiErr.respondV1(c)
 			return
 		}
-
-		// Validate amount is positive
-		if transaction.Amount <= 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Transaction amount must be positive"})
+		if held != nil {
+			held.respondV1(c, "Withdrawal")
 			return
 		}
 
-		// Get account and perform transaction in database transaction for atomicity
-		err := db.Transaction(func(tx *gorm.DB) error {
-			var account models.Account
-			
-			if err := tx.First(&account, transaction.AccountID).Error; err != nil {
-				return err
-			}
+		response := gin.H{
+			"message":     "Transaction processed successfully",
+			"transaction": transaction,
+		}
+		if fee != nil {
+			response["fee"] = fee
+		}
+		c.JSON(http.StatusCreated, response)
+	}
+}
 
-			// Check account status
-			if account.Status != "active" {
-				return gorm.ErrInvalidData
-			}
+// postTransaction validates and posts a client transaction with any fee its schedule charges, returning
+// the fee posting or nil; shared by every API version
+// A debit from an account whose withdrawals need staff approval is held in the approval queue instead, and the
+// first large debit from a new account in the review queue; what holds it is returned. approved is set when an
+// approver posts it from the approval queue, which also skips review
+func postTransaction(c *gin.Context, db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store, reviewConfig reviews.Config, duplicateConfig duplicates.Config, transaction *models.Transaction, approved bool) (*models.Transaction, *held, *apiError) {
+	middleware.AuditAccount(c, transaction.AccountID)
+	// Reversals and ledger offsets are only created by the ledger, and descriptors are generated by it
+	transaction.ReversalOfID = nil
+	transaction.OffsetOfID = nil
+	transaction.Descriptor = ""
+	transaction.CounterpartyAccountID = nil
+	transaction.ValueDate = nil // Assigned from the bank's cutoffs below
+	// The description a client sends is its own note unless it sent one separately
+	if transaction.Memo == "" {
+		transaction.Memo = transaction.Description
+	}
 
-			// Store balance before transaction
-			transaction.BalanceBefore = account.Balance
+	// Validate transaction type
+	validTypes := []string{"deposit", "withdrawal", "transfer", "payment", ledger.TypeInterest, ledger.TypeFee}
+	if !contains(validTypes, transaction.TransactionType) {
+		return nil, nil, &apiError{Status: http.StatusBadRequest, Code: "INVALID_TRANSACTION_TYPE", Message: "Invalid transaction type"}
+	}
 
-			// Process transaction based on type
-			switch transaction.TransactionType {
-			case "deposit":
-				account.Balance += transaction.Amount
-			case "withdrawal":
-				if account.Balance < transaction.Amount {
-					return gorm.ErrInvalidData
-				}
-				account.Balance -= transaction.Amount
-			case "transfer", "payment":
-				if account.Balance < transaction.Amount {
-					return gorm.ErrInvalidData
-				}
-				account.Balance -= transaction.Amount
-			}
+	// Interest and fees are bank-originated and feed customers' tax summaries
+	if transaction.TransactionType == ledger.TypeInterest || transaction.TransactionType == ledger.TypeFee {
+		role, _ := c.Get("user_role")
+		roleName, _ := role.(string)
+		if !auth.Can(roleName, auth.PermPostCharges) {
+			return nil, nil, &apiError{Status: http.StatusForbidden, Code: "PERMISSION_DENIED", Message: "Interest and fee postings require the charges permission"}
+		}
+	}
 
-			// Update balance after transaction
-			transaction.BalanceAfter = account.Balance
-			transaction.TransactionID = generateTransactionID()
+	// Validate amount is positive - the same rule every entry point applies
+	if err := ledger.ValidateAmount(transaction.Amount); err != nil {
+		return nil, nil, postingError(err)
+	}
 
-			// Update account balance
-			if err := tx.Save(&account).Error; err != nil {
-				return err
-			}
+	// Enforce the tenant's single-posting limit
+	if limit := tenancy.CurrentSettings(c).TransactionLimit; limit > 0 && transaction.Amount > limit {
+		return nil, nil, &apiError{Status: http.StatusBadRequest, Code: "AMOUNT_LIMIT_EXCEEDED", Message: "Transaction amount exceeds the limit"}
+	}
 
-			// Create transaction record
-			if err := tx.Create(&transaction).Error; err != nil {
-				return err
-			}
+	// Validate channel - API callers default to the api channel
+	if transaction.Channel == "" {
+		transaction.Channel = enrichment.DefaultChannel
+	}
+	if !contains(enrichment.Channels, transaction.Channel) {
+		return nil, nil, &apiError{Status: http.StatusBadRequest, Code: "INVALID_CHANNEL", Message: "Invalid transaction channel"}
+	}
 
-			return nil
-		})
+	// Derive merchant data from the description when not supplied
+	if rules, err := enrichment.LoadRules(db); err == nil {
+		enrichment.Apply(rules, transaction)
+	}
 
+	// Entries effective before today are corrections and need the backdating permission
+	if !transaction.EffectiveDate.IsZero() && transaction.EffectiveDate.Before(ledger.StartOfDay(clock.Now())) {
+		role, _ := c.Get("user_role")
+		roleName, _ := role.(string)
+		if !auth.Can(roleName, auth.PermPostBackdated) {
+			return nil, nil, &apiError{Status: http.StatusForbidden, Code: "PERMISSION_DENIED", Message: "Backdated entries require the backdating permission"}
+		}
+	}
+
+	// The first large withdrawal from a new account is held for review rather than posted
+	if !approved {
+		review, err := reviews.Hold(db, reviewConfig, featureFlags, models.TransactionReview{
+			Kind:            reviews.KindTransaction,
+			AccountID:       transaction.AccountID,
+			TransactionType: transaction.TransactionType,
+			Amount:          transaction.Amount,
+			Description:     transaction.Description,
+			Memo:            transaction.Memo,
+			Reference:       transaction.Reference,
+			Channel:         transaction.Channel,
+			RequestedBy:     actor(c),
+		}, clock.Now())
 		if err != nil {
-			if err == gorm.ErrRecordNotFound {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
-				return
-			}
-			if err == gorm.ErrInvalidData {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient balance or invalid account status"})
-				return
+			return nil, nil, postingError(err)
+		}
+		if review != nil {
+			return nil, &held{Review: review}, nil
+		}
+	}
+
+	// Get account and perform transaction in database transaction for atomicity
+	// A debit the balance cannot cover draws on a linked line of credit first; a deposit repays it
+	// after any fee is charged
+	var account models.Account
+	var fee *models.Transaction
+	var drawn, repaid *models.CreditLine
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := restrictions.Check(tx, transaction.AccountID, transaction.TransactionType, approved); err != nil {
+			return err
+		}
+		// A submission after its type's cutoff is valued the next business day, though it posts now
+		valueDate, err := ledger.ValueDate(tx, transaction.TransactionType, transaction.EffectiveDate, tenancy.CurrentSettings(c).Cutoffs)
+		if err != nil {
+			return err
+		}
+		transaction.ValueDate = &valueDate
+		if drawn, err = creditlines.Cover(tx, transaction); err != nil {
+			return err
+		}
+		if account, err = ledger.PostExternal(tx, transaction, featureFlags); err != nil {
+			return err
+		}
+		if fee, account, err = fees.Charge(tx, *transaction, account, featureFlags); err != nil {
+			return err
+		}
+		// Active liens hold their amount against customer debits, fee included
+		if restrictions.Restricts(transaction.TransactionType) {
+			if err := liens.Enforce(tx, account); err != nil {
+				return err
 			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process transaction"})
-			return
 		}
+		account, repaid, err = creditlines.Repay(tx, account, *transaction)
+		return err
+	})
+
+	if err == restrictions.ErrApprovalRequired {
+		// Held rather than refused; the approver posts it with the same details, effective the day it is approved
+		approval := models.WithdrawalApproval{
+			Kind:            restrictions.KindTransaction,
+			AccountID:       transaction.AccountID,
+			TransactionType: transaction.TransactionType,
+			Amount:          transaction.Amount,
+			Description:     transaction.Description,
+			Memo:            transaction.Memo,
+			Reference:       transaction.Reference,
+			Channel:         transaction.Channel,
+			RequestedBy:     actor(c),
+		}
+		if err := restrictions.Hold(db, &approval); err != nil {
+			return nil, nil, &apiError{Status: http.StatusInternalServerError, Code: "INTERNAL_ERROR", Message: "Failed to queue the withdrawal for approval"}
+		}
+		return nil, &held{Approval: &approval}, nil
+	}
+	if err != nil {
+		return nil, nil, postingError(err)
+	}
 
-		c.JSON(http.StatusCreated, gin.H{
-			"message":     "Transaction processed successfully",
-			"transaction": transaction,
-		})
+	// Refresh the cached balance before responding so polling clients never see the old value
+	// Writing the new version (rather than deleting) keeps a racing reader from caching the pre-commit row
+	balances.Set(cache.Entry(account))
+	for _, line := range []*models.CreditLine{drawn, repaid} {
+		if line != nil {
+			balances.Invalidate(line.AccountID)
+		}
 	}
+
+	// Evaluate account alert rules and look for a repeated payment now that the posting has committed
+	alerts.EvaluateTransaction(db, account, *transaction)
+	duplicates.Detect(db, duplicateConfig, account, *transaction)
+	return fee, nil, nil
 }
 
 // GetTransactions retrieves all transactions with filtering options
+// Rows carry the account number and customer name via joins; ?include=account,customer nests full objects
+// and ?include=notes adds the notes the caller may see
 func GetTransactions(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-		offset := (page - 1) * limit
-
-		var transactions []models.Transaction
-		query := db.Preload("Account.Customer")
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 10)
 
-		// Optional filtering by account ID
-		if accountID := c.Query("account_id"); accountID != "" {
-			if id, err := strconv.ParseUint(accountID, 10, 32); err == nil {
-				query = query.Where("account_id = ?", uint(id))
-			}
-		}
-
-		// Optional filtering by transaction type
-		if transactionType := c.Query("type"); transactionType != "" {
-			query = query.Where("transaction_type = ?", transactionType)
-		}
-
-		var total int64
-		query.Model(&models.Transaction{}).Count(&total)
-		
-		err := query.Offset(offset).Limit(limit).Order("created_at DESC").Find(&transactions).Error
-		
+		transactions, total, err := listTransactions(c, db, limit, offset)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve transactions"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
+		respondDisplay(c, http.StatusOK, gin.H{
 			"transactions": transactions,
 			"total":        total,
 			"page":         page,
@@ -468,12 +1074,100 @@ func GetTransactions(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
+// listTransactions runs the filtered transaction list query for one page; shared by every API version
+func listTransactions(c *gin.Context, db *gorm.DB, limit, offset int) ([]TransactionSummary, int64, error) {
+	includes := parseIncludes(c.Query("include"))
+
+	var transactions []TransactionSummary
+	var filter listFilter
+
+	// Optional filtering by account ID
+	if accountID := c.Query("account_id"); accountID != "" {
+		if id, err := strconv.ParseUint(accountID, 10, 32); err == nil {
+			filter.where("transactions.account_id = ?", uint(id))
+		}
+	}
+
+	// Optional filtering by transaction type
+	if transactionType := c.Query("type"); transactionType != "" {
+		filter.where("transactions.transaction_type = ?", transactionType)
+	}
+
+	// Optional filtering by enrichment fields
+	if merchant := c.Query("merchant"); merchant != "" {
+		filter.where("transactions.merchant_name = ?", merchant)
+	}
+	if channel := c.Query("channel"); channel != "" {
+		filter.where("transactions.channel = ?", channel)
+	}
+	if category := c.Query("category"); category != "" {
+		filter.where("transactions.category_code = ?", category)
+	}
+
+	// Count and page run as separate queries over the same conditions
+	// The joins only add display columns, so the count does not need them
+	total, err := filter.count(db, &models.Transaction{})
+	if err == nil {
+		err = filter.apply(transactionSummaryQuery(db)).Select(transactionSummaryColumns).
+			Order("transactions.created_at DESC, transactions.id DESC").Offset(offset).Limit(limit).
+			Scan(&transactions).Error
+	}
+	
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Nested objects are loaded for the whole page at once, never per row
+	if includes["account"] || includes["customer"] {
+		ids := make([]uint, 0, len(transactions))
+		for _, t := range transactions {
+			ids = append(ids, t.AccountID)
+		}
+
+		accountQuery := db
+		if includes["customer"] {
+			accountQuery = accountQuery.Preload("Customer")
+		}
+		var accounts []models.Account
+		if err := accountQuery.Where("id IN ?", ids).Find(&accounts).Error; err != nil {
+			return nil, 0, err
+		}
+
+		byID := make(map[uint]*models.Account, len(accounts))
+		for i := range accounts {
+			byID[accounts[i].ID] = &accounts[i]
+		}
+		for i := range transactions {
+			transactions[i].Account = byID[transactions[i].AccountID]
+		}
+	}
+
+	if includes["notes"] {
+		ids := make([]uint, 0, len(transactions))
+		for _, t := range transactions {
+			ids = append(ids, t.ID)
+		}
+		notes, err := visibleNotes(c, db, "transaction", ids)
+		if err != nil {
+			return nil, 0, err
+		}
+		for i := range transactions {
+			transactions[i].Notes = notes[transactions[i].ID]
+		}
+	}
+
+	return transactions, total, nil
+}
+
 // ==================== LOAN HANDLERS ====================
 
 // CreateLoan creates a new loan for a customer
 // Core banking function - loan origination
-func CreateLoan(db *gorm.DB) gin.HandlerFunc {
+// The loan is disbursed at once unless ?disburse=false, which leaves it pending so co-borrowers and
+// guarantors can be added before POST /loans/:id/disburse
+func CreateLoan(db *gorm.DB, maxDebtToIncome float64, reviewer *creditbureau.Reviewer) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
 		var loan models.Loan
 		
 		if err := c.ShouldBindJSON(&loan); err != nil {
@@ -494,43 +1188,64 @@ func CreateLoan(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Calculate monthly payment using standard amortization formula
-		// M = P * [r(1+r)^n] / [(1+r)^n - 1]
-		monthlyRate := loan.InterestRate / 12 // Convert annual rate to monthly
-		power := 1.0 // Changed to float64 for proper calculation
-		for i := 0; i < loan.LoanTerm; i++ {
-			power *= (1 + monthlyRate)
-		}
-		
-		numerator := loan.PrincipalAmount * monthlyRate * float64(power)
-		denominator := float64(power) - 1
-		loan.MonthlyPayment = numerator / denominator
+		// Calculate monthly payment using standard amortization formula, as the public loan calculator does
+		loan.MonthlyPayment = finance.MonthlyPayment(loan.PrincipalAmount, loan.InterestRate, loan.LoanTerm)
 
-		// Set loan properties
+		// Set loan properties - dates and the loan account are set when the loan is disbursed
 		loan.LoanNumber = generateLoanNumber()
 		loan.RemainingBalance = loan.PrincipalAmount
-		loan.Status = "active"
-		loan.DisbursementDate = time.Now().Format("2006-01-02")
-		loan.DueDate = time.Now().AddDate(0, loan.LoanTerm, 0).Format("2006-01-02")
-
-		// Create automatic payment account for the loan
-		var loanAccount models.Account
-		loanAccount.CustomerID = loan.CustomerID
-		loanAccount.AccountNumber = generateAccountNumber()
-		loanAccount.AccountType = "loan"
-		loanAccount.Balance = -loan.PrincipalAmount // Negative balance represents debt
-		loanAccount.Currency = "USD"
-		loanAccount.Status = "active"
-
-		// Create loan and associated account in transaction
+		loan.Status = loans.StatusPending
+		loan.AccountID = 0
+		loan.DisbursementDate = ""
+		loan.DueDate = ""
+		loan.Parties = nil
+		loan.CreditScore, loan.CreditReportID, loan.CreditCheckedAt = nil, "", nil
+		loan.CreditDecision, loan.CreditDecisionReason, loan.CreditReviewedBy = "", "", ""
+		disburse := c.Query("disburse") != "false"
+
+		// Debt-to-income - the borrower's payment on top of every loan they are already a party to
+		borrower := models.LoanParty{CustomerID: loan.CustomerID, Role: loans.RoleBorrower, LiabilityPercent: 100}
+		if err := loans.CheckAffordability(db, loan, []models.LoanParty{borrower}, maxDebtToIncome); err != nil {
+			respondLoanPartyError(c, err)
+			return
+		}
+
+		// Disbursing at once makes this the review step, so the borrower's credit is checked now
+		// Declined and referred applications are still recorded, but not disbursed
+		if disburse && reviewer.Enabled() {
+			reviewer.Review(c.Request.Context(), customer, &loan, clock.Now())
+			disburse = loan.CreditDecision == creditbureau.DecisionApproved
+		}
+
+		// Create the loan with its borrower party, and disburse it, in one transaction
 		err := db.Transaction(func(tx *gorm.DB) error {
 			if err := tx.Create(&loan).Error; err != nil {
 				return err
 			}
-			if err := tx.Create(&loanAccount).Error; err != nil {
+			if err := statushistory.Created(tx, loan.TenantID, statushistory.SubjectLoan, loan.ID, loan.Status, actor(c)); err != nil {
+				return err
+			}
+			if err := loans.AddBorrower(tx, loan, actor(c)); err != nil {
 				return err
 			}
-			return nil
+			if loan.CreditDecision != "" {
+				if err := recordCreditDecision(tx, &loan); err != nil {
+					return err
+				}
+			}
+			if disburse {
+				if _, err := loans.Disburse(tx, &loan, generateAccountNumber(), actor(c), clock.Now()); err != nil {
+					return err
+				}
+			}
+			return events.Record(tx, events.AggregateLoan, loan.ID, events.LoanCreated, gin.H{
+				"loan_id":          loan.ID,
+				"loan_number":      loan.LoanNumber,
+				"customer_id":      loan.CustomerID,
+				"principal_amount": loan.PrincipalAmount,
+				"account_id":       loan.AccountID,
+				"status":           loan.Status,
+			})
 		})
 
 		if err != nil {
@@ -538,8 +1253,15 @@ func CreateLoan(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		message := "Loan created successfully"
+		switch loan.CreditDecision {
+		case creditbureau.DecisionDeclined:
+			message = "Loan application declined by credit review"
+		case creditbureau.DecisionManual:
+			message = "Loan application referred for manual credit review"
+		}
 		c.JSON(http.StatusCreated, gin.H{
-			"message": "Loan created successfully",
+			"message": message,
 			"loan":    loan,
 		})
 	}
@@ -548,15 +1270,16 @@ func CreateLoan(db *gorm.DB) gin.HandlerFunc {
 // GetLoans retrieves all loans with customer information
 func GetLoans(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-		offset := (page - 1) * limit
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 10)
 
 		var loans []models.Loan
-		var total int64
+		var filter listFilter
 
-		db.Model(&models.Loan{}).Count(&total)
-		err := db.Preload("Customer").Offset(offset).Limit(limit).Find(&loans).Error
+		total, err := filter.count(db, &models.Loan{})
+		if err == nil {
+			err = filter.apply(db.Preload("Customer")).Order("id").Offset(offset).Limit(limit).Find(&loans).Error
+		}
 		
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve loans"})
@@ -572,6 +1295,46 @@ func GetLoans(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
+// respondPostingError maps ledger posting errors to client responses
+func respondPostingError(c *gin.Context, err error) {
+	postingError(err).respondV1(c)
+}
+
+// postingError maps a ledger posting error to its status, code and message
+func postingError(err error) *apiError {
+	if e := transitionError(err); e != nil {
+		return e
+	}
+	switch err {
+	case gorm.ErrRecordNotFound:
+		return &apiError{Status: http.StatusNotFound, Code: "ACCOUNT_NOT_FOUND", Message: "Account not found"}
+	case ledger.ErrAccountInactive:
+		return &apiError{Status: http.StatusBadRequest, Code: "ACCOUNT_INACTIVE", Message: "Insufficient balance or invalid account status"}
+	case ledger.ErrInsufficientFunds:
+		return &apiError{Status: http.StatusBadRequest, Code: "INSUFFICIENT_FUNDS", Message: "Insufficient balance or invalid account status"}
+	case ledger.ErrPeriodLocked:
+		return &apiError{Status: http.StatusConflict, Code: "PERIOD_LOCKED", Message: "Accounting period is locked", v1Code: true}
+	case ledger.ErrFutureDated:
+		return &apiError{Status: http.StatusBadRequest, Code: "FUTURE_DATED", Message: "Effective date cannot be in the future"}
+	case ledger.ErrZeroAmount:
+		return &apiError{Status: http.StatusBadRequest, Code: "ZERO_AMOUNT", Message: "Amount must not be zero", v1Code: true}
+	case ledger.ErrNegativeAmount:
+		return &apiError{Status: http.StatusBadRequest, Code: "INVALID_AMOUNT", Message: "Amount must be positive"}
+	case ledger.ErrSelfTransfer:
+		return &apiError{Status: http.StatusBadRequest, Code: "SELF_TRANSFER", Message: "Source and destination must be different accounts", v1Code: true}
+	case restrictions.ErrDepositOnly:
+		return &apiError{Status: http.StatusForbidden, Code: "ACCOUNT_DEPOSIT_ONLY", Message: "Account accepts deposits only", v1Code: true}
+	case restrictions.ErrOutboundTransfers:
+		return &apiError{Status: http.StatusForbidden, Code: "OUTBOUND_TRANSFERS_RESTRICTED", Message: "Account does not allow outbound transfers", v1Code: true}
+	case liens.ErrHeld:
+		return &apiError{Status: http.StatusForbidden, Code: "LIEN_HOLD", Message: "Debit would take the balance below the amount held by liens on the account", v1Code: true}
+	case invariants.ErrBelowFloor:
+		return &apiError{Status: http.StatusConflict, Code: "BALANCE_FLOOR", Message: "Posting would take the account below its allowed balance", v1Code: true}
+	default:
+		return &apiError{Status: http.StatusInternalServerError, Code: "INTERNAL_ERROR", Message: "Failed to process transaction"}
+	}
+}
+
 // Helper function to check if a slice contains a string
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"banking-app/database"
+	"banking-app/models"
+	"banking-app/search"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Size of the seeded history: every account holds the same number of postings
+const (
+	historyAccounts           = 1000
+	historyPostingsPerAccount = 500
+	historyPostings           = historyAccounts * historyPostingsPerAccount
+)
+
+// BenchmarkAccountTransactions reads one account's history from a table of 500,000 postings spread over 1,000
+// accounts: the whole streamed history, a type and date filter, and a search. The postings are inserted by one
+// statement, a minute apart and interleaved across the accounts, so an account's rows are scattered as in
+// production and only the account_id, created_at index keeps a read from scanning the table
+func BenchmarkAccountTransactions(b *testing.B) {
+	db := testDB(b)
+	customer := models.Customer{FirstName: "History", LastName: "Holder", Email: "history@example.test", DateOfBirth: "1980-01-01", Status: "active"}
+	if err := db.Create(&customer).Error; err != nil {
+		b.Fatal(err)
+	}
+	accounts := make([]models.Account, historyAccounts)
+	for i := range accounts {
+		accounts[i] = models.Account{CustomerID: customer.ID, AccountNumber: fmt.Sprintf("HIST-%d", i), AccountType: "checking", Currency: "USD", Status: "active"}
+	}
+	if err := db.CreateInBatches(&accounts, 500).Error; err != nil {
+		b.Fatal(err)
+	}
+	first := accounts[0].ID
+	if err := db.Exec(`
+		WITH RECURSIVE n(i) AS (SELECT 0 UNION ALL SELECT i + 1 FROM n WHERE i < ? - 1)
+		INSERT INTO transactions (created_at, updated_at, effective_date, tenant_id, transaction_id, account_id,
+			transaction_type, amount, description, merchant_name, channel)
+		SELECT datetime('2025-01-01', '+' || i || ' minutes'), datetime('2025-01-01', '+' || i || ' minutes'),
+			datetime('2025-01-01', '+' || i || ' minutes'), 1, 'HIST-' || i, ? + i % ?,
+			CASE WHEN i % 3 = 2 THEN 'withdrawal' ELSE 'deposit' END, (i % 200) + 1, 'History posting ' || i,
+			CASE WHEN i % 7 = 0 THEN 'Corner Grocer' ELSE 'Transit Authority' END, 'card'
+		FROM n`, historyPostings, first, historyAccounts).Error; err != nil {
+		b.Fatal(err)
+	}
+	var count int64
+	if err := db.Model(&models.Transaction{}).Count(&count).Error; err != nil || count != historyPostings {
+		b.Fatalf("seeded %d postings (%v), want %d", count, err, historyPostings)
+	}
+	plans, err := database.ExplainCanonicalQueries(db)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, plan := range plans {
+		if plan.Name == "account_transactions" && !plan.UsesIndex {
+			b.Fatalf("account history scans the table: %v", plan.Plan)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/accounts/:id/transactions", GetAccountTransactions(db, search.LikeSearcher{}))
+	account := accounts[historyAccounts/2].ID
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"history", ""},
+		{"type and dates", "?type=withdrawal&from=2025-03-01&to=2025-03-31"},
+		{"search", "?q=grocer&limit=20"},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			target := fmt.Sprintf("/accounts/%d/transactions%s", account, tt.query)
+			for i := 0; i < b.N; i++ {
+				recorder := httptest.NewRecorder()
+				router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+				if recorder.Code != http.StatusOK {
+					b.Fatalf("GET %s: status %d: %s", target, recorder.Code, recorder.Body.String())
+				}
+				if i == 0 && !strings.Contains(recorder.Body.String(), `"transactions":[{`) {
+					b.Fatalf("GET %s found no postings: %.200s", target, recorder.Body.String())
+				}
+			}
+		})
+	}
+}
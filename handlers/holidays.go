@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/models"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== BUSINESS DAY CALENDAR HANDLERS ====================
+
+// holidayRequest is a holiday as admins send it
+type holidayRequest struct {
+	Date string `json:"date" binding:"required"` // YYYY-MM-DD in bank time
+	Name string `json:"name" binding:"required"`
+}
+
+// checkHoliday validates a holiday's date and name, responding when they fail
+func checkHoliday(c *gin.Context, h *models.Holiday) bool {
+	h.Name = strings.TrimSpace(h.Name)
+	if _, err := businessdays.ParseDate(h.Date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be a YYYY-MM-DD date", "code": "INVALID_HOLIDAY"})
+		return false
+	}
+	if h.Name == "" || len(h.Name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1 to 100 characters", "code": "INVALID_HOLIDAY"})
+		return false
+	}
+	return true
+}
+
+// holidayTaken responds 409 when another holiday is already on the same date
+func holidayTaken(c *gin.Context, db *gorm.DB, h models.Holiday) bool {
+	var count int64
+	if err := db.Model(&models.Holiday{}).Where("date = ? AND id <> ?", h.Date, h.ID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check holidays"})
+		return true
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A holiday on " + h.Date + " already exists", "code": "HOLIDAY_EXISTS"})
+		return true
+	}
+	return false
+}
+
+// GetHolidays lists the bank holidays in date order; ?year= limits them to one year
+func GetHolidays(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := db.Order("date")
+		if raw := c.Query("year"); raw != "" {
+			year, err := strconv.Atoi(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "year must be a number"})
+				return
+			}
+			query = query.Where("date LIKE ?", fmt.Sprintf("%04d-%%", year))
+		}
+		var holidays []models.Holiday
+		if err := query.Find(&holidays).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve holidays"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"holidays": holidays, "time_zone": businessdays.Location().String()})
+	}
+}
+
+// CreateHoliday closes the bank on a date
+// Body: {"date": "2026-12-24", "name": "Christmas Eve"}
+func CreateHoliday(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req holidayRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date and name are required"})
+			return
+		}
+		holiday := models.Holiday{Date: req.Date, Name: req.Name, Source: "admin", CreatedBy: actor(c)}
+		if !checkHoliday(c, &holiday) || holidayTaken(c, db, holiday) {
+			return
+		}
+		if err := db.Create(&holiday).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create holiday"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Holiday created", "holiday": holiday})
+	}
+}
+
+// UpdateHoliday moves or renames a holiday
+func UpdateHoliday(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var holiday models.Holiday
+		if err := db.First(&holiday, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Holiday not found"})
+			return
+		}
+		var req holidayRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date and name are required"})
+			return
+		}
+		holiday.Date, holiday.Name = req.Date, req.Name
+		if !checkHoliday(c, &holiday) || holidayTaken(c, db, holiday) {
+			return
+		}
+		if err := db.Save(&holiday).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update holiday"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Holiday updated", "holiday": holiday})
+	}
+}
+
+// DeleteHoliday reopens the bank on a holiday's date
+// A deleted federal holiday stays deleted: seeding skips years that already have federal holidays
+func DeleteHoliday(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var holiday models.Holiday
+		if err := db.First(&holiday, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Holiday not found"})
+			return
+		}
+		if err := db.Delete(&holiday).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete holiday"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Holiday deleted"})
+	}
+}
+
+// SeedHolidays adds a year's federal holidays, unless that year has been seeded before
+// Body: {"year": 2027}
+func SeedHolidays(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Year int `json:"year" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Year < 1900 || req.Year > 2200 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "year must be between 1900 and 2200"})
+			return
+		}
+		added, err := businessdays.SeedFederal(db, req.Year)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to seed holidays"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Federal holidays seeded", "year": req.Year, "added": added})
+	}
+}
+
+// GetBusinessDay reports whether the bank is open on ?date= (YYYY-MM-DD, default today in bank time)
+// and the business days either side of it
+func GetBusinessDay(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		day := businessdays.StartOfDay(clock.Now())
+		if raw := c.Query("date"); raw != "" {
+			var err error
+			if day, err = businessdays.ParseDate(raw); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "date must be a YYYY-MM-DD date"})
+				return
+			}
+		}
+		calendar, err := businessdays.Load(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load the business day calendar"})
+			return
+		}
+		response := gin.H{
+			"date":                  businessdays.Format(day),
+			"time_zone":             businessdays.Location().String(),
+			"is_business_day":       calendar.IsBusinessDay(day),
+			"next_business_day":     businessdays.Format(calendar.Next(day)),
+			"previous_business_day": businessdays.Format(calendar.Previous(day)),
+			"starts_at":             day,
+			"ends_at":               businessdays.DayAfter(day),
+			"hours":                 businessdays.DayAfter(day).Sub(day).Hours(), // 23 or 25 on a daylight saving change
+		}
+		if name, ok := calendar.Holiday(day); ok {
+			response["holiday"] = name
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"banking-app/clock"
+	"banking-app/middleware"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== IMPERSONATION HANDLERS ====================
+
+// impersonationRequest explains why an admin needs a customer's view
+type impersonationRequest struct {
+	Reason  string `json:"reason" binding:"required"`
+	Minutes int    `json:"minutes"` // Session length, capped at 30
+}
+
+// StartImpersonation issues a short-lived token that views the API as a customer
+// The token keeps the admin's identity, mutations are limited by the impersonation guard,
+// and every request made with it is written to the audit log
+func StartImpersonation(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		customerID, err := strconv.ParseUint(c.Param("customerId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
+			return
+		}
+
+		var req impersonationRequest
+		if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+			return
+		}
+		ttl := middleware.ImpersonationTTL
+		if req.Minutes > 0 && time.Duration(req.Minutes)*time.Minute < ttl {
+			ttl = time.Duration(req.Minutes) * time.Minute
+		}
+
+		var customer models.Customer
+		if err := db.First(&customer, uint(customerID)).Error; err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+			return
+		}
+		middleware.AuditCustomer(c, customer.ID)
+
+		session := models.ImpersonationSession{
+			AdminUserID:   c.GetUint("user_id"),
+			AdminUsername: actor(c),
+			CustomerID:    customer.ID,
+			Reason:        strings.TrimSpace(req.Reason),
+			ExpiresAt:     clock.Now().Add(ttl),
+		}
+		if err := db.Create(&session).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start impersonation"})
+			return
+		}
+
+		admin := middleware.User{ID: session.AdminUserID, Username: session.AdminUsername, TenantID: session.TenantID}
+		token, err := middleware.GenerateImpersonationJWT(admin, customer.ID, session.ID, session.ExpiresAt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue token"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"token":   token,
+			"session": session,
+		})
+	}
+}
+
+// GetImpersonations lists impersonation sessions, newest first
+func GetImpersonations(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 50)
+
+		var filter listFilter
+		if customerID := c.Query("customer_id"); customerID != "" {
+			filter.where("customer_id = ?", customerID)
+		}
+		if admin := c.Query("admin"); admin != "" {
+			filter.where("admin_username = ?", admin)
+		}
+
+		var sessions []models.ImpersonationSession
+		total, err := filter.count(db, &models.ImpersonationSession{})
+		if err == nil {
+			err = filter.apply(db).Order("id DESC").Offset(offset).Limit(limit).Find(&sessions).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve impersonation sessions"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"sessions": sessions,
+			"total":    total,
+			"page":     page,
+			"limit":    limit,
+		})
+	}
+}
+
+// EndImpersonation ends a session early; its token is refused from then on
+func EndImpersonation(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var session models.ImpersonationSession
+		if err := db.First(&session, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Impersonation session not found"})
+			return
+		}
+		if session.EndedAt != nil || !clock.Now().Before(session.ExpiresAt) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Impersonation session has already ended"})
+			return
+		}
+
+		now := clock.Now()
+		session.EndedAt = &now
+		session.EndedBy = actor(c)
+		if err := db.Model(&session).Updates(map[string]interface{}{"ended_at": now, "ended_by": session.EndedBy}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end impersonation"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Impersonation ended",
+			"session": session,
+		})
+	}
+}
+
+// GetMe describes the caller's token
+// Under impersonation it carries a banner so clients can show that an admin is viewing as the customer
+func GetMe(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		role, _ := c.Get("user_role")
+		response := gin.H{
+			"user": gin.H{
+				"id":        c.GetUint("user_id"),
+				"username":  actor(c),
+				"role":      role,
+				"tenant_id": tenancy.Current(c).ID,
+			},
+			"impersonation": nil,
+		}
+
+		if sessionID, ok := c.Get("impersonation_session_id"); ok {
+			var session models.ImpersonationSession
+			if err := db.First(&session, sessionID).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load impersonation session"})
+				return
+			}
+			var customer models.Customer
+			db.First(&customer, session.CustomerID)
+			response["impersonation"] = gin.H{
+				"active":      true,
+				"session_id":  session.ID,
+				"admin":       session.AdminUsername,
+				"customer_id": session.CustomerID,
+				"reason":      session.Reason,
+				"expires_at":  session.ExpiresAt,
+				"banner": fmt.Sprintf("%s is viewing as %s %s (customer %d) until %s",
+					session.AdminUsername, customer.FirstName, customer.LastName, session.CustomerID, session.ExpiresAt.UTC().Format("15:04 MST")),
+			}
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// GetAuditLog lists audited requests, newest first
+// ?impersonation=true narrows to requests made under impersonation; ?bulk_operation_id= to one bulk operation's changes
+// and ?campaign_id= to one campaign's regulatory overrides
+// ?client_id= and ?consent_id= narrow to third-party reads; ?request_id= finds the entry for one request
+func GetAuditLog(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 50)
+
+		var filter listFilter
+		if username := c.Query("username"); username != "" {
+			filter.where("username = ?", username)
+		}
+		if c.Query("impersonation") == "true" {
+			filter.where("impersonation = ?", true)
+		}
+		if sessionID := c.Query("session_id"); sessionID != "" {
+			filter.where("impersonation_session_id = ?", sessionID)
+		}
+		if operationID := c.Query("bulk_operation_id"); operationID != "" {
+			filter.where("bulk_operation_id = ?", operationID)
+		}
+		if campaignID := c.Query("campaign_id"); campaignID != "" {
+			filter.where("campaign_id = ?", campaignID)
+		}
+		if clientID := c.Query("client_id"); clientID != "" {
+			filter.where("client_id = ?", clientID)
+		}
+		if consentID := c.Query("consent_id"); consentID != "" {
+			filter.where("consent_id = ?", consentID)
+		}
+		if requestID := c.Query("request_id"); requestID != "" {
+			filter.where("request_id = ?", requestID)
+		}
+
+		var entries []models.AuditEntry
+		total, err := filter.count(db, &models.AuditEntry{})
+		if err == nil {
+			err = filter.apply(db).Order("id DESC").Offset(offset).Limit(limit).Find(&entries).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit log"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"entries": entries,
+			"total":   total,
+			"page":    page,
+			"limit":   limit,
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"banking-app/businessdays"
+	"banking-app/cache"
+	"banking-app/clock"
+	"banking-app/flags"
+	"banking-app/installments"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== INSTALLMENT PLAN HANDLERS ====================
+
+// generatePlanNumber returns a unique installment plan number; the backing loan shares it
+func generatePlanNumber() string {
+	return "INST" + time.Now().Format("20060102150405") + strconv.Itoa(int(time.Now().UnixNano()%1000))
+}
+
+// installmentPlanRequest picks one of the offered plan lengths
+type installmentPlanRequest struct {
+	Months int `json:"months" binding:"required"`
+}
+
+// respondInstallmentError maps plan errors to client responses
+func respondInstallmentError(c *gin.Context, err error) {
+	var eligibility *installments.EligibilityError
+	switch {
+	case errors.As(err, &eligibility):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": eligibility.Reason, "code": "NOT_ELIGIBLE"})
+	case errors.Is(err, installments.ErrInvalidMonths):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, installments.ErrAlreadyConverted), errors.Is(err, installments.ErrPlanClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		respondPostingError(c, err)
+	}
+}
+
+// CreateInstallmentPlan converts a posted payment into monthly installments
+// The amount is credited back and the plan fee charged; one plan per transaction
+func CreateInstallmentPlan(db *gorm.DB, balances *cache.Balances, cfg installments.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var t models.Transaction
+		if err := db.First(&t, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+			return
+		}
+
+		var req installmentPlanRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		var account models.Account
+		if err := db.First(&account, t.AccountID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+
+		now := clock.Now()
+		if err := installments.CheckEligibility(db, cfg, t, account, req.Months, now); err != nil {
+			respondInstallmentError(c, err)
+			return
+		}
+
+		var plan models.InstallmentPlan
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			plan, err = installments.Convert(tx, cfg, t, account, req.Months, generatePlanNumber(), generateAccountNumber(), actor(c), now)
+			return err
+		})
+		if err != nil {
+			// The unique index catches a concurrent conversion of the same transaction
+			var converted int64
+			if db.Model(&models.InstallmentPlan{}).Where("transaction_id = ?", t.ID).Count(&converted); converted > 0 {
+				err = installments.ErrAlreadyConverted
+			}
+			respondInstallmentError(c, err)
+			return
+		}
+		balances.Invalidate(account.ID)
+
+		c.JSON(http.StatusCreated, gin.H{"message": "Installment plan created successfully", "plan": plan})
+	}
+}
+
+// GetInstallmentPlan returns a plan with the installments collected so far
+// next_collection_date is the next due date moved off weekends and holidays by the business-day convention
+func GetInstallmentPlan(db *gorm.DB, convention string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var plan models.InstallmentPlan
+		if err := db.First(&plan, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Installment plan not found"})
+			return
+		}
+
+		var loan models.Loan
+		var payments []models.LoanPayment
+		err := db.First(&loan, plan.LoanID).Error
+		if err == nil {
+			err = db.Where("loan_id = ?", plan.LoanID).Order("paid_at, id").Find(&payments).Error
+		}
+		var calendar businessdays.Calendar
+		if err == nil {
+			calendar, err = businessdays.Load(db)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve installment plan"})
+			return
+		}
+
+		var nextCollection *time.Time
+		if plan.NextDueDate != nil {
+			next := calendar.Adjust(*plan.NextDueDate, convention)
+			nextCollection = &next
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"plan":                 plan,
+			"next_collection_date": nextCollection,
+			"remaining_balance":    loan.RemainingBalance,
+			"payments":             payments,
+		})
+	}
+}
+
+// PayOffInstallmentPlan settles a plan's remaining balance from its account
+func PayOffInstallmentPlan(db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var plan models.InstallmentPlan
+		if err := db.First(&plan, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Installment plan not found"})
+			return
+		}
+
+		var payment models.LoanPayment
+		var account models.Account
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			payment, account, err = installments.PayOff(tx, &plan, actor(c), featureFlags, clock.Now())
+			return err
+		})
+		if err != nil {
+			respondInstallmentError(c, err)
+			return
+		}
+
+		balances.Set(cache.Entry(account))
+
+		c.JSON(http.StatusOK, gin.H{"message": "Installment plan paid off", "plan": plan, "payment": payment})
+	}
+}
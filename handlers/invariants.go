@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"banking-app/invariants"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== INVARIANT VIOLATION HANDLERS ====================
+
+// GetInvariantViolations lists what the invariant scan found, oldest first
+// ?status= filters (open, resolved, all; default open)
+func GetInvariantViolations(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 50)
+
+		var filter listFilter
+		if status := c.DefaultQuery("status", invariants.StatusOpen); status != "all" {
+			filter.where("status = ?", status)
+		}
+		if kind := c.Query("kind"); kind != "" {
+			filter.where("kind = ?", kind)
+		}
+
+		var violations []models.InvariantViolation
+		total, err := filter.count(db, &models.InvariantViolation{})
+		if err == nil {
+			err = filter.apply(db).Order("detected_at, id").Offset(offset).Limit(limit).Find(&violations).Error
+		}
+		var open int64
+		if err == nil {
+			open, err = invariants.OpenCount(db)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve invariant violations"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"violations": violations,
+			"total":      total,
+			"open":       open,
+			"page":       page,
+			"limit":      limit,
+		})
+	}
+}
+
+// ResolveInvariantViolation records that staff have dealt with a violation
+// Correcting the books is done with postings; this only clears the item, and the next scan reopens it if it is still there
+func ResolveInvariantViolation(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid violation ID"})
+			return
+		}
+		var req struct {
+			Note string `json:"note"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Note == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "note is required"})
+			return
+		}
+
+		var violation models.InvariantViolation
+		if err := db.First(&violation, uint(id)).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invariant violation not found"})
+			return
+		}
+		switch err := invariants.Resolve(db, &violation, actor(c), req.Note); err {
+		case nil:
+		case invariants.ErrNotOpen:
+			c.JSON(http.StatusConflict, gin.H{"error": "Invariant violation has already been resolved"})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve invariant violation"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "Invariant violation resolved",
+			"violation": violation,
+		})
+	}
+}
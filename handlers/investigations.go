@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"banking-app/auth"
+	"banking-app/cache"
+	"banking-app/display"
+	"banking-app/investigations"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== INVESTIGATION HANDLERS ====================
+
+// flowMaxAge is how long clients may reuse a traced flow; main caches flows server-side for as long
+const flowMaxAge = 30
+
+// GetMoneyFlow traces transfers onward from a transaction, or back to where its money came from
+// Query: transaction_id (ID or TXN reference), direction=forward|backward, depth (1-6, default 3),
+// max_nodes (1-500, default 100), format=json|dot
+// Accounts the caller may not see are left out and not walked through; hidden_nodes counts them
+func GetMoneyFlow(db *gorm.DB, flows *cache.Queries) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		format := c.DefaultQuery("format", "json")
+		if format != "json" && format != "dot" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be json or dot"})
+			return
+		}
+		opts := investigations.Options{Direction: c.Query("direction")}
+		var err error
+		for param, target := range map[string]*int{"depth": &opts.Depth, "max_nodes": &opts.MaxNodes} {
+			if raw := c.Query(param); raw != "" {
+				if *target, err = strconv.Atoi(raw); err != nil || *target == 0 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": investigations.ErrInvalid.Error(), "code": "INVALID_FLOW_QUERY"})
+					return
+				}
+			}
+		}
+		if err := opts.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "INVALID_FLOW_QUERY"})
+			return
+		}
+
+		ref := c.Query("transaction_id")
+		if ref == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "transaction_id is required", "code": "INVALID_FLOW_QUERY"})
+			return
+		}
+		var start models.Transaction
+		query := db.Where("transaction_id = ?", ref)
+		if id, err := strconv.ParseUint(ref, 10, 64); err == nil {
+			query = db.Where("id = ?", id)
+		}
+		if err := query.First(&start).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+			return
+		}
+
+		role, _ := c.Get("user_role")
+		roleName, _ := role.(string)
+		seesStaff := auth.Can(roleName, auth.PermStaffAccounts)
+
+		// The same query by callers who see the same accounts gets the same graph
+		key := fmt.Sprintf("%d|%t|%d|%s|%d|%d", tenancy.Current(c).ID, seesStaff, start.ID, opts.Direction, opts.Depth, opts.MaxNodes)
+		var graph *investigations.Graph
+		if cached, ok := flows.Get(key); ok {
+			graph = cached.(*investigations.Graph)
+		} else {
+			staff := map[uint]bool{}
+			if !seesStaff {
+				if staff, err = investigations.StaffCustomers(db); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to trace money flow"})
+					return
+				}
+			}
+			graph, err = investigations.Trace(db, start, opts, func(a models.Account) bool { return !staff[a.CustomerID] })
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to trace money flow"})
+				return
+			}
+			flows.Set(key, graph)
+		}
+
+		options := displayOptions(c)
+		if notModified(c, weakETag(key, format, options.Mask, len(graph.Nodes), len(graph.Edges), graph.HiddenNodes, graph.Truncated, graphTail(graph)), flowMaxAge) {
+			return
+		}
+		if format == "dot" {
+			label := func(number string) string { return number }
+			if options.Mask {
+				label = display.MaskAccountNumber
+			}
+			c.Data(http.StatusOK, "text/vnd.graphviz; charset=utf-8", []byte(investigations.DOT(graph, label)))
+			return
+		}
+		respondDisplayWith(c, http.StatusOK, gin.H{"flow": graph}, options)
+	}
+}
+
+// graphTail is the newest transfer in a graph, which changes whenever the graph does
+func graphTail(g *investigations.Graph) uint {
+	if len(g.Edges) == 0 {
+		return 0
+	}
+	return g.Edges[len(g.Edges)-1].TransferID
+}
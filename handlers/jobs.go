@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"banking-app/jobs"
+	"banking-app/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== JOB HANDLERS ====================
+
+// GetJobs lists the registered background jobs with their schedules, next run and latest run
+func GetJobs(scheduler *jobs.Scheduler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := scheduler.Jobs()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve jobs"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"jobs": list, "instance": scheduler.Instance})
+	}
+}
+
+// GetJobRuns lists recorded job runs from every instance, newest first
+// ?job= and ?status= narrow the list
+func GetJobRuns(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, offset := parsePagination(c, 50)
+
+		var filter listFilter
+		if job := c.Query("job"); job != "" {
+			filter.where("job_name = ?", job)
+		}
+		if status := c.Query("status"); status != "" {
+			filter.where("status = ?", status)
+		}
+
+		var runs []models.JobRun
+		total, err := filter.count(db, &models.JobRun{})
+		if err == nil {
+			err = filter.apply(db).Order("id DESC").Offset(offset).Limit(limit).Find(&runs).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve job runs"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"runs":  runs,
+			"total": total,
+			"page":  page,
+			"limit": limit,
+		})
+	}
+}
+
+// TriggerJob starts a job now, outside its schedule; the run continues in the background
+// A job already running on any instance is refused, as is any run during maintenance
+func TriggerJob(scheduler *jobs.Scheduler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		run, err := scheduler.Trigger(c.Param("name"), actor(c))
+		switch err {
+		case nil:
+			c.JSON(http.StatusAccepted, gin.H{"message": "Job started", "run": run})
+		case jobs.ErrUnknownJob:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		case jobs.ErrRunning:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case jobs.ErrPaused:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error(), "code": "MAINTENANCE"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start job"})
+		}
+	}
+}
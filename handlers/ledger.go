@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"banking-app/alerts"
+	"banking-app/businessdays"
+	"banking-app/cache"
+	"banking-app/clock"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/sar"
+	"banking-app/tenancy"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== LEDGER HANDLERS ====================
+
+// reverseRequest carries the reason for a reversal
+type reverseRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ReverseTransaction posts the opposite entry of a transaction in the current period
+// Used for corrections, including of entries in locked periods; each transaction can be reversed once
+func ReverseTransaction(db *gorm.DB, balances *cache.Balances) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
+			return
+		}
+
+		var req reverseRequest
+		c.ShouldBindJSON(&req)
+
+		var original models.Transaction
+		if err := db.First(&original, uint(id)).Error; err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+			return
+		}
+		if original.ReversalOfID != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A reversal cannot be reversed"})
+			return
+		}
+		if original.OffsetOfID != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Ledger offsets are reversed with the posting they balance"})
+			return
+		}
+
+		// Loan payments also moved the loan balance; reversing only the debit would strand the allocation
+		var allocations int64
+		db.Model(&models.LoanPayment{}).Where("transaction_id = ?", original.ID).Count(&allocations)
+		if allocations > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Loan payments cannot be reversed here"})
+			return
+		}
+
+		// Converted payments and a plan's own postings are settled through the plan
+		var plans int64
+		db.Model(&models.InstallmentPlan{}).
+			Where("transaction_id = ? OR credit_transaction_id = ? OR fee_transaction_id = ?", original.ID, original.ID, original.ID).
+			Count(&plans)
+		if plans > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Transactions of an installment plan cannot be reversed"})
+			return
+		}
+
+		// Evidence for a suspicious activity report stays as it was until the case is filed or closed
+		if locked, err := sar.Locked(db, original.ID); err != nil || locked {
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reverse transaction"})
+				return
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": "Transaction is under investigation and cannot be reversed", "code": "TRANSACTION_LOCKED"})
+			return
+		}
+
+		var reversal models.Transaction
+		var account models.Account
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			reversal, account, err = ledger.Reverse(tx, original, req.Reason)
+			return err
+		})
+		if errors.Is(err, ledger.ErrAlreadyReversed) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Transaction has already been reversed"})
+			return
+		}
+		if err != nil {
+			respondPostingError(c, err)
+			return
+		}
+
+		balances.Set(cache.Entry(account))
+		alerts.EvaluateTransaction(db, account, reversal)
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message":     "Transaction reversed successfully",
+			"transaction": reversal,
+		})
+	}
+}
+
+// periodLockRequest sets the closed-through date
+type periodLockRequest struct {
+	ClosedThrough string `json:"closed_through" binding:"required"` // YYYY-MM-DD
+	Note          string `json:"note"`
+}
+
+// GetPeriodLock returns the period lock in force for the tenant and its history
+func GetPeriodLock(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+
+		var history []models.PeriodLock
+		if err := db.Order("id DESC").Limit(50).Find(&history).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve period lock"})
+			return
+		}
+
+		var current interface{}
+		if len(history) > 0 {
+			current = history[0]
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"current": current,
+			"history": history,
+		})
+	}
+}
+
+// SetPeriodLock closes the books through a date; entries effective on or before it are rejected
+// Moving the date backwards reopens a period and is recorded in the history like any other change
+func SetPeriodLock(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+
+		var req periodLockRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		closedThrough, err := businessdays.ParseDate(req.ClosedThrough)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "closed_through must be a YYYY-MM-DD date"})
+			return
+		}
+		if !closedThrough.Before(ledger.StartOfDay(clock.Now())) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only past dates can be locked"})
+			return
+		}
+
+		lock := models.PeriodLock{ClosedThrough: closedThrough, SetBy: actor(c), Note: req.Note}
+		if err := db.Create(&lock).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set period lock"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Period lock updated successfully",
+			"lock":    lock,
+		})
+	}
+}
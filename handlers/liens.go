@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"banking-app/auth"
+	"banking-app/cache"
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/liens"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== ACCOUNT LIEN HANDLERS ====================
+
+// lienRequest places or changes a lien; set either amount or all_funds
+type lienRequest struct {
+	Claimant       string  `json:"claimant"`
+	LegalReference string  `json:"legal_reference"`
+	Amount         float64 `json:"amount"`
+	AllFunds       bool    `json:"all_funds"`
+	Priority       int     `json:"priority"` // Defaults to 1, the most senior
+	DocumentIDs    []uint  `json:"document_ids"`
+}
+
+// lien returns the request's fields as a lien on an account
+func (r lienRequest) lien(accountID uint) models.Lien {
+	priority := r.Priority
+	if priority == 0 {
+		priority = 1
+	}
+	return models.Lien{
+		AccountID:      accountID,
+		Claimant:       strings.TrimSpace(r.Claimant),
+		LegalReference: strings.TrimSpace(r.LegalReference),
+		Amount:         r.Amount,
+		AllFunds:       r.AllFunds,
+		Priority:       priority,
+	}
+}
+
+// LienDetail is a lien with its supporting documents and the payments made on it, as staff see it
+type LienDetail struct {
+	models.Lien
+	Documents []models.Document    `json:"documents"`
+	Payments  []models.LienPayment `json:"payments"`
+}
+
+// LienSummary is what customers see of the liens on their account
+type LienSummary struct {
+	liens.Hold
+	AvailableBalance float64 `json:"available_balance"` // Balance not held by liens
+}
+
+// seesLienDetails reports whether the caller is staff; customers and anonymous callers get a summary
+func seesLienDetails(c *gin.Context) bool {
+	role, _ := c.Get("user_role")
+	roleName, _ := role.(string)
+	return auth.Can(roleName, auth.PermInternalNotes)
+}
+
+// lienDetails loads the documents and payments of a set of liens
+func lienDetails(db *gorm.DB, list []models.Lien) ([]LienDetail, error) {
+	details := make([]LienDetail, len(list))
+	if len(list) == 0 {
+		return details, nil
+	}
+	ids := make([]uint, len(list))
+	for i, l := range list {
+		ids[i] = l.ID
+	}
+
+	var links []models.LienDocument
+	if err := db.Where("lien_id IN ?", ids).Order("id").Find(&links).Error; err != nil {
+		return nil, err
+	}
+	documentIDs := make([]uint, len(links))
+	for i, link := range links {
+		documentIDs[i] = link.DocumentID
+	}
+	var documents []models.Document
+	if len(documentIDs) > 0 {
+		if err := db.Where("id IN ?", documentIDs).Find(&documents).Error; err != nil {
+			return nil, err
+		}
+	}
+	documentByID := make(map[uint]models.Document, len(documents))
+	for _, d := range documents {
+		documentByID[d.ID] = d
+	}
+
+	var payments []models.LienPayment
+	if err := db.Where("lien_id IN ?", ids).Order("id").Find(&payments).Error; err != nil {
+		return nil, err
+	}
+
+	index := make(map[uint]int, len(list))
+	for i, l := range list {
+		index[l.ID] = i
+		details[i] = LienDetail{Lien: l, Documents: []models.Document{}, Payments: []models.LienPayment{}}
+	}
+	for _, link := range links {
+		if d, ok := documentByID[link.DocumentID]; ok {
+			details[index[link.LienID]].Documents = append(details[index[link.LienID]].Documents, d)
+		}
+	}
+	for _, p := range payments {
+		details[index[p.LienID]].Payments = append(details[index[p.LienID]].Payments, p)
+	}
+	return details, nil
+}
+
+// lienSummary totals an account's active liens for its owner
+func lienSummary(db *gorm.DB, account models.Account) (LienSummary, error) {
+	hold, err := liens.HoldOn(db, account.ID)
+	if err != nil {
+		return LienSummary{}, err
+	}
+	return LienSummary{Hold: hold, AvailableBalance: hold.Available(account.Balance)}, nil
+}
+
+// respondLienError maps lien errors to client responses
+func respondLienError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, liens.ErrInvalid), errors.Is(err, liens.ErrDocumentNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, liens.ErrNotActive), errors.Is(err, liens.ErrNoFunds):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ledger.ErrDestinationCurrency):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Destination account must be in the account's currency", "code": "CURRENCY_MISMATCH"})
+	default:
+		respondPostingError(c, err)
+	}
+}
+
+// loadLien fetches the lien named by :lienId on the account named by :id, responding on failure
+func loadLien(c *gin.Context, db *gorm.DB) (models.Lien, bool) {
+	var l models.Lien
+	if err := db.Where("account_id = ?", c.Param("id")).First(&l, c.Param("lienId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lien not found"})
+		return l, false
+	}
+	return l, true
+}
+
+// GetAccountLiens lists the liens on an account
+// Staff get every lien, most senior active ones first, with its documents and payments; ?status= filters
+// (active, satisfied, released). Customers get only the total held and the balance left available
+func GetAccountLiens(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var account models.Account
+		if err := db.First(&account, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+
+		if !seesLienDetails(c) {
+			summary, err := lienSummary(db, account)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve liens"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"account_id": account.ID, "lien_summary": summary})
+			return
+		}
+
+		query := db.Where("account_id = ?", account.ID)
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+		var list []models.Lien
+		err := query.Order("CASE WHEN status = '" + liens.StatusActive + "' THEN 0 ELSE 1 END, priority, id").Find(&list).Error
+		var details []LienDetail
+		if err == nil {
+			details, err = lienDetails(db, list)
+		}
+		var summary LienSummary
+		if err == nil {
+			summary, err = lienSummary(db, account)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve liens"})
+			return
+		}
+		respondDisplay(c, http.StatusOK, gin.H{"account_id": account.ID, "liens": details, "lien_summary": summary})
+	}
+}
+
+// CreateAccountLien records a claimant's lien on an account
+// Body: {"claimant": "...", "legal_reference": "...", "amount": 500 or "all_funds": true, "priority": 1,
+// "document_ids": [...]}. Documents must be the account owner's, typically uploaded with kind legal_order
+func CreateAccountLien(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req lienRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		var account models.Account
+		if err := db.First(&account, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		if account.AccountType == gl.AccountType {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Internal accounts cannot carry liens"})
+			return
+		}
+		if account.Status == "closed" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Closed accounts cannot carry liens"})
+			return
+		}
+
+		l := req.lien(account.ID)
+		l.PlacedBy = actor(c)
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return liens.Place(tx, &l, req.DocumentIDs)
+		}); err != nil {
+			respondLienError(c, err)
+			return
+		}
+		details, err := lienDetails(db, []models.Lien{l})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve lien"})
+			return
+		}
+		respondDisplay(c, http.StatusCreated, gin.H{"lien": details[0]})
+	}
+}
+
+// UpdateAccountLien replaces an active lien's claimant, reference, amount and priority
+// The body is the same as for placing one; document_ids are attached alongside those already on the lien
+func UpdateAccountLien(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req lienRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		l, ok := loadLien(c, db)
+		if !ok {
+			return
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return liens.Change(tx, &l, req.lien(l.AccountID), req.DocumentIDs)
+		}); err != nil {
+			respondLienError(c, err)
+			return
+		}
+		details, err := lienDetails(db, []models.Lien{l})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve lien"})
+			return
+		}
+		respondDisplay(c, http.StatusOK, gin.H{"lien": details[0]})
+	}
+}
+
+// satisfyLienRequest names the account the claimant is paid into
+type satisfyLienRequest struct {
+	DestinationAccountID uint `json:"destination_account_id" binding:"required"`
+}
+
+// SatisfyAccountLien pays an active lien from the account to the claimant's destination account
+// A lien is paid only from what its seniors leave; an amount lien paid in part stays active for the rest, and
+// an all-funds lien takes what is available. Nothing left to pay is a 409
+func SatisfyAccountLien(db *gorm.DB, balances *cache.Balances) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req satisfyLienRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "destination_account_id is required"})
+			return
+		}
+		l, ok := loadLien(c, db)
+		if !ok {
+			return
+		}
+		var payment models.LienPayment
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			payment, err = liens.Satisfy(tx, &l, req.DestinationAccountID, actor(c))
+			return err
+		})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Destination account not found"})
+			return
+		}
+		if err != nil {
+			respondLienError(c, err)
+			return
+		}
+		balances.Invalidate(l.AccountID)
+		balances.Invalidate(req.DestinationAccountID)
+
+		details, err := lienDetails(db, []models.Lien{l})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve lien"})
+			return
+		}
+		respondDisplay(c, http.StatusOK, gin.H{"lien": details[0], "payment": payment})
+	}
+}
+
+// releaseLienRequest records why a lien was lifted
+type releaseLienRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ReleaseAccountLien lifts an active lien without paying it, such as when the order is withdrawn
+// Liens are never deleted, so the account keeps a record of every claim made on it
+func ReleaseAccountLien(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req releaseLienRequest
+		if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required"})
+			return
+		}
+		l, ok := loadLien(c, db)
+		if !ok {
+			return
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return liens.Release(tx, &l, actor(c), strings.TrimSpace(req.Reason))
+		}); err != nil {
+			respondLienError(c, err)
+			return
+		}
+		details, err := lienDetails(db, []models.Lien{l})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve lien"})
+			return
+		}
+		respondDisplay(c, http.StatusOK, gin.H{"lien": details[0]})
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"banking-app/lifecycle"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== LIFECYCLE HANDLERS ====================
+
+// GetLifecycles lists every record type's statuses and the transitions between them, so clients know the lifecycle
+func GetLifecycles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"lifecycles": lifecycle.All()})
+	}
+}
+
+// transitionError maps a refused status change to its client response, or returns nil for any other error
+func transitionError(err error) *apiError {
+	var refused *lifecycle.TransitionError
+	switch {
+	case errors.As(err, &refused):
+		return &apiError{Status: http.StatusConflict, Code: "INVALID_STATUS_TRANSITION", Message: refused.Error(),
+			Details: gin.H{"allowed": refused.Allowed}, v1Code: true}
+	case errors.Is(err, lifecycle.ErrReasonRequired):
+		return &apiError{Status: http.StatusBadRequest, Code: "REASON_REQUIRED", Message: "A reason is required for this status change", v1Code: true}
+	case errors.Is(err, lifecycle.ErrForbidden):
+		return &apiError{Status: http.StatusForbidden, Code: "PERMISSION_DENIED", Message: "Insufficient permissions"}
+	}
+	return nil
+}
+
+// authorizeTransition checks a status change the signed-in user asked for, responding if it is refused
+func authorizeTransition(c *gin.Context, subject, from, to, reason string) bool {
+	role, _ := c.Get("user_role")
+	roleName, _ := role.(string)
+	if e := transitionError(lifecycle.Authorize(subject, from, to, roleName, reason)); e != nil {
+		e.respondV1(c)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"banking-app/auth"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"banking-app/verification"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// staffRouter is a test router whose requests are signed in with the role in the X-Role header
+func staffRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_role", c.GetHeader("X-Role"))
+		c.Set("username", "tester")
+	})
+	return router
+}
+
+// refusal is the body of a refused status change
+type refusal struct {
+	Error   string   `json:"error"`
+	Code    string   `json:"code"`
+	Allowed []string `json:"allowed"`
+}
+
+// send serves one request with a role and JSON body, returning the status and the decoded refusal
+func send(router *gin.Engine, method, target, role string, body interface{}) (int, refusal) {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, target, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Role", role)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	var out refusal
+	json.Unmarshal(recorder.Body.Bytes(), &out)
+	return recorder.Code, out
+}
+
+// holder returns a role holding a permission, or any staff role when it is empty
+func holder(permission string) string {
+	for _, role := range auth.Roles {
+		if permission == "" || auth.Can(role, permission) {
+			return role
+		}
+	}
+	return ""
+}
+
+func TestTransitionGuards(t *testing.T) {
+	router := staffRouter()
+	router.POST("/guard", func(c *gin.Context) {
+		var req struct{ Subject, From, To, Reason string }
+		c.ShouldBindJSON(&req)
+		if authorizeTransition(c, req.Subject, req.From, req.To, req.Reason) {
+			c.Status(http.StatusNoContent)
+		}
+	})
+	guard := func(m lifecycle.Machine, from, to, role, reason string) (int, refusal) {
+		return send(router, http.MethodPost, "/guard", role, gin.H{"subject": m.Subject, "from": from, "to": to, "reason": reason})
+	}
+
+	for _, m := range lifecycle.All() {
+		t.Run(m.Subject, func(t *testing.T) {
+			declared := map[[2]string]lifecycle.Transition{}
+			for _, tr := range m.Transitions {
+				declared[[2]string{tr.From, tr.To}] = tr
+			}
+			for _, s := range m.Initial {
+				declared[[2]string{"", s}] = lifecycle.Transition{To: s}
+			}
+
+			// Every pair of statuses, from a new record, and to a status that does not exist
+			for _, from := range append([]string{""}, m.Statuses...) {
+				for _, to := range append(append([]string{}, m.Statuses...), "nonexistent") {
+					tr, ok := declared[[2]string{from, to}]
+					status, body := guard(m, from, to, holder(tr.Permission), "Test reason")
+					switch {
+					case ok || from == to:
+						if status != http.StatusNoContent {
+							t.Errorf("%q -> %q: status %d, want it allowed: %+v", from, to, status, body)
+						}
+					case status != http.StatusConflict || body.Code != "INVALID_STATUS_TRANSITION" || !reflect.DeepEqual(body.Allowed, m.Allowed(from)):
+						t.Errorf("%q -> %q: status %d %+v, want 409 allowing %v", from, to, status, body, m.Allowed(from))
+					}
+				}
+			}
+
+			for _, tr := range m.Transitions {
+				if tr.Permission != "" {
+					for _, role := range append(auth.Roles, "") {
+						if auth.Can(role, tr.Permission) {
+							continue
+						}
+						if status, body := guard(m, tr.From, tr.To, role, "Test reason"); status != http.StatusForbidden || body.Error != "Insufficient permissions" {
+							t.Errorf("%s -> %s as %q without %s: status %d %+v, want 403", tr.From, tr.To, role, tr.Permission, status, body)
+						}
+					}
+				}
+				if tr.Reason {
+					for _, reason := range []string{"", " \t"} {
+						if status, body := guard(m, tr.From, tr.To, holder(tr.Permission), reason); status != http.StatusBadRequest || body.Code != "REASON_REQUIRED" {
+							t.Errorf("%s -> %s with reason %q: status %d %+v, want 400 REASON_REQUIRED", tr.From, tr.To, reason, status, body)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCustomerStatusChanges(t *testing.T) {
+	db := testDB(t)
+	customer := models.Customer{FirstName: "Status", LastName: "Holder", Email: "status@example.test", DateOfBirth: "1980-01-01", Status: "active"}
+	if err := db.Create(&customer).Error; err != nil {
+		t.Fatal(err)
+	}
+	router := staffRouter()
+	router.PUT("/customers/:id", UpdateCustomer(db, verification.Config{}))
+	target := fmt.Sprintf("/customers/%d", customer.ID)
+
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		role    string
+		status  int
+		allowed []string // Targets named by a 409
+	}{
+		{"deactivating", "active", "inactive", "teller", http.StatusOK, nil},
+		{"reactivating", "inactive", "active", "admin", http.StatusOK, nil},
+		{"keeping the status", "active", "active", "compliance", http.StatusOK, nil},
+		{"an undeclared status", "active", "closed", "admin", http.StatusConflict, []string{"inactive"}},
+		{"an undeclared status from inactive", "inactive", "suspended", "admin", http.StatusConflict, []string{"active"}},
+		{"without the customer status permission", "active", "inactive", "compliance", http.StatusForbidden, nil},
+		{"as a customer", "inactive", "active", "customer", http.StatusForbidden, nil},
+	}
+	for _, tt := range tests {
+		db.Model(&customer).Update("status", tt.from)
+
+		status, body := send(router, http.MethodPut, target, tt.role, gin.H{"status": tt.to, "status_reason": "Test reason"})
+		if status != tt.status || (tt.allowed != nil && !reflect.DeepEqual(body.Allowed, tt.allowed)) {
+			t.Errorf("%s: status %d %+v, want %d allowing %v", tt.name, status, body, tt.status, tt.allowed)
+		}
+		var stored models.Customer
+		db.First(&stored, customer.ID)
+		var after int64
+		db.Model(&models.StatusHistory{}).Where("subject_id = ? AND new_status = ? AND reason = ?", customer.ID, tt.to, "Test reason").Count(&after)
+		switch {
+		case tt.status == http.StatusOK && tt.from != tt.to && (stored.Status != tt.to || after == 0):
+			t.Errorf("%s: stored %s with %d matching history rows, want %s recorded", tt.name, stored.Status, after, tt.to)
+		case tt.status != http.StatusOK && stored.Status != tt.from:
+			t.Errorf("%s: a refused change left the customer %s, want %s", tt.name, stored.Status, tt.from)
+		}
+	}
+}
+
+func TestCampaignStatusChanges(t *testing.T) {
+	db := testDB(t)
+	router := staffRouter()
+	router.POST("/campaigns/:id/pause", PauseCampaign(db))
+	router.POST("/campaigns/:id/resume", ResumeCampaign(db))
+	started := time.Date(2026, time.March, 2, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		from    string
+		started bool
+		action  string
+		want    string // Status afterwards; the 409 names the allowed targets from the starting status when it is refused
+		status  int
+	}{
+		{"scheduled", false, "pause", "paused", http.StatusOK},
+		{"running", true, "pause", "paused", http.StatusOK},
+		{"paused", true, "pause", "paused", http.StatusOK},
+		{"completed", true, "pause", "completed", http.StatusConflict},
+		{"paused", true, "resume", "running", http.StatusOK},
+		{"paused", false, "resume", "scheduled", http.StatusOK},
+		{"running", true, "resume", "running", http.StatusOK},
+		{"completed", true, "resume", "completed", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		campaign := models.Campaign{Name: "Lifecycle", Subject: "Hello", Template: "Hello {first_name}", CreatedBy: "tester", Status: tt.from}
+		if tt.started {
+			campaign.StartedAt = &started
+		}
+		if err := db.Create(&campaign).Error; err != nil {
+			t.Fatal(err)
+		}
+		status, body := send(router, http.MethodPost, fmt.Sprintf("/campaigns/%d/%s", campaign.ID, tt.action), "admin", nil)
+		m, _ := lifecycle.Lookup(lifecycle.Campaign)
+		if status != tt.status || (status == http.StatusConflict && (body.Code != "INVALID_STATUS_TRANSITION" || !reflect.DeepEqual(body.Allowed, m.Allowed(tt.from)))) {
+			t.Errorf("%s a %s campaign: status %d %+v, want %d", tt.action, tt.from, status, body, tt.status)
+		}
+		var stored models.Campaign
+		db.First(&stored, campaign.ID)
+		if stored.Status != tt.want {
+			t.Errorf("%s a %s campaign left it %s, want %s", tt.action, tt.from, stored.Status, tt.want)
+		}
+	}
+}
+
+func TestTenantStatusChanges(t *testing.T) {
+	db := testDB(t)
+	router := staffRouter()
+	router.PUT("/tenants/:id", UpdateTenant(db))
+	// The default tenant is never suspended, so the tenants under test come after it
+	if err := db.FirstOrCreate(&models.Tenant{ID: tenancy.DefaultTenantID, Code: "default", Name: "Default", Status: "active"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		from   string
+		to     string
+		status int
+	}{
+		{"active", "suspended", http.StatusOK},
+		{"suspended", "active", http.StatusOK},
+		{"active", "closed", http.StatusConflict},
+		{"suspended", "deleted", http.StatusConflict},
+	}
+	for i, tt := range tests {
+		tenant := models.Tenant{Code: fmt.Sprintf("life%d", i), Name: "Lifecycle", Status: tt.from}
+		if err := db.Create(&tenant).Error; err != nil {
+			t.Fatal(err)
+		}
+		status, body := send(router, http.MethodPut, fmt.Sprintf("/tenants/%d", tenant.ID), "admin", gin.H{"status": tt.to})
+		if status != tt.status || (status == http.StatusConflict && body.Code != "INVALID_STATUS_TRANSITION") {
+			t.Errorf("%s -> %s: status %d %+v, want %d", tt.from, tt.to, status, body, tt.status)
+		}
+		var stored models.Tenant
+		db.First(&stored, tenant.ID)
+		if want := map[bool]string{true: tt.to, false: tt.from}[status == http.StatusOK]; stored.Status != want {
+			t.Errorf("%s -> %s left the tenant %s, want %s", tt.from, tt.to, stored.Status, want)
+		}
+	}
+}
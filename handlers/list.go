@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxPageSize caps the limit parameter of every paginated list endpoint
+const maxPageSize = 100
+
+// parsePagination reads page/limit query parameters, clamping them to sane values
+func parsePagination(c *gin.Context, defaultLimit int) (page, limit, offset int) {
+	page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ = strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLimit)))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = defaultLimit
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return page, limit, (page - 1) * limit
+}
+
+// listFilter is the set of WHERE conditions of a list endpoint
+// It is built once and applied to independent count and page queries so the
+// reported total always reflects exactly the conditions used for the page
+type listFilter []func(*gorm.DB) *gorm.DB
+
+// where adds a condition to the filter
+func (f *listFilter) where(query string, args ...interface{}) {
+	*f = append(*f, func(q *gorm.DB) *gorm.DB {
+		return q.Where(query, args...)
+	})
+}
+
+// apply adds the filter conditions to a page query
+func (f listFilter) apply(query *gorm.DB) *gorm.DB {
+	return query.Scopes(f...)
+}
+
+// count totals the matching rows on a fresh session, without joins, preloads, ordering or paging
+func (f listFilter) count(db *gorm.DB, model interface{}) (int64, error) {
+	var total int64
+	err := db.Session(&gorm.Session{NewDB: true}).Model(model).Scopes(f...).Count(&total).Error
+	return total, err
+}
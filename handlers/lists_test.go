@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"banking-app/database"
+	"banking-app/models"
+	"banking-app/slowquery"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB opens a migrated database in a temporary directory with statement counting registered
+func testDB(t testing.TB) *gorm.DB {
+	t.Helper()
+	db, err := database.Open(filepath.Join(t.TempDir(), "handlers.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	db.Logger = logger.Default.LogMode(logger.Silent)
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := slowquery.New(slowquery.Config{Threshold: slowquery.DefaultThreshold}).Register(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// listDB opens a test database holding customers each with a checking and a savings account, a loan, and
+// transactions on both accounts
+func listDB(t *testing.T, customers, transactionsPerAccount int) *gorm.DB {
+	t.Helper()
+	db := testDB(t)
+
+	for i := 0; i < customers; i++ {
+		customer := models.Customer{FirstName: "List", LastName: fmt.Sprintf("Customer%d", i), Email: fmt.Sprintf("list%d@example.test", i),
+			DateOfBirth: "1980-01-01", Status: "active"}
+		if err := db.Create(&customer).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, accountType := range []string{"checking", "savings"} {
+			account := models.Account{CustomerID: customer.ID, AccountNumber: fmt.Sprintf("LIST-%d-%s", i, accountType),
+				AccountType: accountType, Currency: "USD", Status: "active"}
+			if err := db.Create(&account).Error; err != nil {
+				t.Fatal(err)
+			}
+			for j := 0; j < transactionsPerAccount; j++ {
+				transactionType := "deposit"
+				if j%3 == 2 {
+					transactionType = "withdrawal"
+				}
+				transaction := models.Transaction{TransactionID: fmt.Sprintf("LIST-%d-%s-%d", i, accountType, j), AccountID: account.ID,
+					TransactionType: transactionType, Amount: float64(j + 1), Description: "List test"}
+				if err := db.Create(&transaction).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		loan := models.Loan{CustomerID: customer.ID, LoanNumber: fmt.Sprintf("LIST-LOAN-%d", i), PrincipalAmount: 1000,
+			InterestRate: 0.05, LoanTerm: 12, RemainingBalance: 1000, Status: "pending"}
+		if err := db.Create(&loan).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// counted serves one GET through handler, returning the decoded body and the statements the request ran
+func counted(t *testing.T, handler gin.HandlerFunc, target string) (map[string]interface{}, int) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	var count func() int
+	router.GET("/list", func(c *gin.Context) {
+		var ctx context.Context
+		ctx, count = slowquery.Counting(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}, handler)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d: %s", target, recorder.Code, recorder.Body.String())
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	return body, count()
+}
+
+// rows returns a list response's array
+func rows(t *testing.T, body map[string]interface{}, key string) []map[string]interface{} {
+	t.Helper()
+	list, _ := body[key].([]interface{})
+	out := make([]map[string]interface{}, len(list))
+	for i, row := range list {
+		out[i] = row.(map[string]interface{})
+	}
+	return out
+}
+
+func TestTransactionListQueryBudget(t *testing.T) {
+	db := listDB(t, 10, 5)
+	tests := []struct {
+		target  string
+		queries int // Count, page, then one query per included association for the whole page
+	}{
+		{"/list?limit=50", 2},
+		{"/list?limit=50&include=account", 3},
+		{"/list?limit=50&include=customer", 4},
+		{"/list?limit=50&account_id=1&type=deposit", 2},
+	}
+	for _, tt := range tests {
+		body, queries := counted(t, GetTransactions(db), tt.target)
+		if queries != tt.queries {
+			t.Errorf("GET %s ran %d statements, want %d", tt.target, queries, tt.queries)
+		}
+		page := rows(t, body, "transactions")
+		if tt.target == "/list?limit=50" && len(page) != 50 {
+			t.Errorf("GET %s returned %d transactions, want 50", tt.target, len(page))
+		}
+		for _, row := range page {
+			if row["account_number"] == "" || row["customer_name"] == "" {
+				t.Fatalf("GET %s: summary without its joined columns: %v", tt.target, row)
+			}
+		}
+	}
+}
+
+func TestTransactionListSummaries(t *testing.T) {
+	db := listDB(t, 2, 2)
+	body, _ := counted(t, GetTransactions(db), "/list?limit=1")
+	row := rows(t, body, "transactions")[0]
+	for _, key := range []string{"account_number", "currency", "customer_id", "customer_name"} {
+		if _, ok := row[key]; !ok {
+			t.Errorf("summary has no %s", key)
+		}
+	}
+	if _, ok := row["account"]; ok {
+		t.Errorf("summary nests its account without ?include=account")
+	}
+
+	body, _ = counted(t, GetTransactions(db), "/list?limit=1&include=customer")
+	account, ok := rows(t, body, "transactions")[0]["account"].(map[string]interface{})
+	if !ok || account["customer"] == nil {
+		t.Errorf("?include=customer does not nest the account and its customer: %v", body)
+	}
+}
+
+func TestCustomerListQueryBudget(t *testing.T) {
+	db := listDB(t, 60, 0)
+	tests := []struct {
+		target  string
+		queries int
+	}{
+		{"/list?limit=50", 2},
+		{"/list?limit=50&include=accounts", 3},
+		{"/list?limit=50&include=accounts,loans", 4},
+	}
+	for _, tt := range tests {
+		body, queries := counted(t, GetCustomers(db), tt.target)
+		if queries != tt.queries {
+			t.Errorf("GET %s ran %d statements, want %d", tt.target, queries, tt.queries)
+		}
+		if page := rows(t, body, "customers"); len(page) != 50 {
+			t.Errorf("GET %s returned %d customers, want 50", tt.target, len(page))
+		}
+	}
+}
+
+func TestCustomerListSummaries(t *testing.T) {
+	db := listDB(t, 3, 1)
+	body, _ := counted(t, GetCustomers(db), "/list")
+	for _, row := range rows(t, body, "customers") {
+		if row["account_count"] != float64(2) || row["loan_count"] != float64(1) {
+			t.Errorf("customer %v has counts %v and %v, want 2 accounts and 1 loan", row["id"], row["account_count"], row["loan_count"])
+		}
+		if _, ok := row["accounts"]; ok {
+			t.Errorf("summary nests accounts without ?include=accounts")
+		}
+	}
+
+	body, _ = counted(t, GetCustomers(db), "/list?include=accounts,loans")
+	for _, row := range rows(t, body, "customers") {
+		accounts, _ := row["accounts"].([]interface{})
+		loans, _ := row["loans"].([]interface{})
+		if len(accounts) != 2 || len(loans) != 1 {
+			t.Errorf("customer %v includes %d accounts and %d loans, want 2 and 1", row["id"], len(accounts), len(loans))
+		}
+	}
+}
+
+func TestTransactionListTotals(t *testing.T) {
+	db := listDB(t, 3, 7)
+	tests := []struct {
+		filter string
+		where  string // The filter as SQL, to count the expected total directly
+	}{
+		{"", "1 = 1"},
+		{"account_id=1", "account_id = 1"},
+		{"type=deposit", "transaction_type = 'deposit'"},
+		{"account_id=1&type=deposit", "account_id = 1 AND transaction_type = 'deposit'"},
+		{"account_id=2&type=withdrawal", "account_id = 2 AND transaction_type = 'withdrawal'"},
+		{"account_id=1&type=deposit&include=customer", "account_id = 1 AND transaction_type = 'deposit'"},
+		{"account_id=999&type=deposit", "account_id = 999"},
+	}
+	for _, tt := range tests {
+		var want int64
+		if err := db.Model(&models.Transaction{}).Where(tt.where).Count(&want).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, limit := range []int{2, 3, 100} {
+			seen := map[float64]bool{}
+			for page := 1; ; page++ {
+				target := fmt.Sprintf("/list?%s&limit=%d&page=%d", tt.filter, limit, page)
+				body, _ := counted(t, GetTransactions(db), target)
+				if total := int64(body["total"].(float64)); total != want {
+					t.Errorf("GET %s: total %d, want %d", target, total, want)
+				}
+				list := rows(t, body, "transactions")
+				for _, row := range list {
+					if seen[row["id"].(float64)] {
+						t.Errorf("GET %s: transaction %v repeated from an earlier page", target, row["id"])
+					}
+					seen[row["id"].(float64)] = true
+				}
+				if len(list) < limit {
+					break
+				}
+			}
+			if int64(len(seen)) != want {
+				t.Errorf("GET /list?%s&limit=%d: pages held %d transactions, total %d", tt.filter, limit, len(seen), want)
+			}
+		}
+	}
+}
+
+func TestCustomerListTotals(t *testing.T) {
+	db := listDB(t, 7, 0)
+	for _, limit := range []int{2, 3, 100} {
+		seen := 0
+		for page := 1; ; page++ {
+			target := fmt.Sprintf("/list?include=accounts,loans&limit=%d&page=%d", limit, page)
+			body, _ := counted(t, GetCustomers(db), target)
+			if body["total"] != float64(7) {
+				t.Errorf("GET %s: total %v, want 7", target, body["total"])
+			}
+			list := rows(t, body, "customers")
+			seen += len(list)
+			if len(list) < limit {
+				break
+			}
+		}
+		if seen != 7 {
+			t.Errorf("pages of %d held %d customers, want 7", limit, seen)
+		}
+	}
+}
@@ -0,0 +1,515 @@
+package handlers
+
+import (
+	"banking-app/cache"
+	"banking-app/clock"
+	"banking-app/creditbureau"
+	"banking-app/creditlines"
+	"banking-app/events"
+	"banking-app/flags"
+	"banking-app/loans"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== LOAN PAYMENT HANDLERS ====================
+
+// loanPaymentRequest names the funding account and the amount to pay
+type loanPaymentRequest struct {
+	AccountID uint    `json:"account_id" binding:"required"`
+	Amount    float64 `json:"amount"`
+}
+
+// CreateLoanPayment takes a payment from an account of the borrower or a co-borrower
+// Accrued interest is settled first and the rest reduces the balance; the split is recorded
+func CreateLoanPayment(db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid loan ID"})
+			return
+		}
+
+		var req loanPaymentRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		var loan models.Loan
+		if err := db.First(&loan, uint(id)).Error; err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+			return
+		}
+
+		if backed, _ := creditlines.BacksLoan(db, loan.ID); backed {
+			c.JSON(http.StatusConflict, gin.H{"error": "Lines of credit are repaid by deposits to their linked account"})
+			return
+		}
+
+		var funding models.Account
+		borrower := false
+		if err := db.Select("id, customer_id").First(&funding, req.AccountID).Error; err == nil {
+			borrower, _ = loans.IsBorrower(db, loan, funding.CustomerID)
+		}
+		if !borrower {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Funding account must belong to the borrower or a co-borrower"})
+			return
+		}
+
+		var payment models.LoanPayment
+		var account models.Account
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			payment, account, err = loans.Pay(tx, &loan, req.AccountID, req.Amount, actor(c), featureFlags)
+			if err != nil {
+				return err
+			}
+			// The history shows which party funded each payment
+			var party models.LoanParty
+			if tx.Where("loan_id = ? AND customer_id = ?", loan.ID, funding.CustomerID).Limit(1).Find(&party); party.ID == 0 {
+				return nil
+			}
+			payment.PartyID = &party.ID
+			return tx.Model(&payment).Update("party_id", party.ID).Error
+		})
+		switch err {
+		case nil:
+		case loans.ErrExceedsPayoff:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Payment amount must be at most the payoff amount"})
+			return
+		case loans.ErrLoanClosed:
+			c.JSON(http.StatusConflict, gin.H{"error": "Loan is not active"})
+			return
+		case loans.ErrCurrency:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Funding account must be in the loan's currency", "code": "LOAN_CURRENCY_MISMATCH"})
+			return
+		default:
+			respondPostingError(c, err)
+			return
+		}
+
+		balances.Set(cache.Entry(account))
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Loan payment processed successfully",
+			"payment": payment,
+			"loan":    loan,
+		})
+	}
+}
+
+// GetLoanPayments lists a loan's payments with their interest/principal split, newest first
+func GetLoanPayments(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid loan ID"})
+			return
+		}
+
+		var loan models.Loan
+		if err := db.Select("id").First(&loan, uint(id)).Error; err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+			return
+		}
+
+		page, limit, offset := parsePagination(c, 10)
+
+		var payments []models.LoanPayment
+		var filter listFilter
+		filter.where("loan_id = ?", loan.ID)
+
+		total, err := filter.count(db, &models.LoanPayment{})
+		if err == nil {
+			err = filter.apply(db).Order("paid_at DESC, id DESC").Offset(offset).Limit(limit).Find(&payments).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve loan payments"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"loan_id":  loan.ID,
+			"payments": payments,
+			"total":    total,
+			"page":     page,
+			"limit":    limit,
+		})
+	}
+}
+
+// ==================== LOAN PARTY HANDLERS ====================
+
+// loanPartyRequest adds a co-borrower or guarantor to a pending loan
+type loanPartyRequest struct {
+	CustomerID       uint     `json:"customer_id" binding:"required"`
+	Role             string   `json:"role" binding:"required"` // co_borrower, guarantor
+	LiabilityPercent *float64 `json:"liability_percent"`       // Defaults to 100
+}
+
+// respondLoanPartyError maps loan party and affordability errors to client responses
+func respondLoanPartyError(c *gin.Context, err error) {
+	var affordability *loans.AffordabilityError
+	switch {
+	case errors.As(err, &affordability):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":       err.Error(),
+			"code":        "DEBT_TO_INCOME_EXCEEDED",
+			"customer_id": affordability.CustomerID,
+			"role":        affordability.Role,
+			"ratio":       affordability.Ratio,
+			"limit":       affordability.Limit,
+		})
+	case errors.Is(err, loans.ErrInvalidRole), errors.Is(err, loans.ErrInvalidLiability):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, loans.ErrNotPending), errors.Is(err, loans.ErrDuplicateParty), errors.Is(err, loans.ErrSoleBorrower),
+		errors.Is(err, loans.ErrNotGuarantor), errors.Is(err, loans.ErrAlreadyConfirmed), errors.Is(err, loans.ErrUnconfirmedGuarantor):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update loan parties"})
+	}
+}
+
+// loadLoanParty finds a loan and one of its parties from the :id and :partyId parameters
+func loadLoanParty(c *gin.Context, db *gorm.DB) (models.Loan, models.LoanParty, bool) {
+	var loan models.Loan
+	var party models.LoanParty
+	if err := db.First(&loan, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return loan, party, false
+	}
+	if err := db.Where("loan_id = ?", loan.ID).First(&party, c.Param("partyId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan party not found"})
+		return loan, party, false
+	}
+	return loan, party, true
+}
+
+// GetLoanParties lists a loan's borrower, co-borrowers and guarantors
+func GetLoanParties(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var loan models.Loan
+		if err := db.First(&loan, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+			return
+		}
+		var parties []models.LoanParty
+		if err := db.Where("loan_id = ?", loan.ID).Order("id").Find(&parties).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve loan parties"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"loan_id": loan.ID, "status": loan.Status, "parties": parties})
+	}
+}
+
+// AddLoanParty adds a co-borrower or guarantor to a loan that has not been disbursed
+// Guarantors are bound only after their confirmation is recorded
+func AddLoanParty(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req loanPartyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		var loan models.Loan
+		if err := db.First(&loan, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+			return
+		}
+		var customer models.Customer
+		if err := db.First(&customer, req.CustomerID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+			return
+		}
+
+		party := models.LoanParty{CustomerID: customer.ID, Role: req.Role, LiabilityPercent: 100, AddedBy: actor(c)}
+		if req.LiabilityPercent != nil {
+			party.LiabilityPercent = *req.LiabilityPercent
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return loans.AddParty(tx, loan, &party)
+		})
+		if err != nil {
+			respondLoanPartyError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Loan party added successfully", "party": party})
+	}
+}
+
+// RemoveLoanParty removes a co-borrower or guarantor from a loan that has not been disbursed
+func RemoveLoanParty(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		loan, party, ok := loadLoanParty(c, db)
+		if !ok {
+			return
+		}
+		if err := loans.RemoveParty(db, loan, party); err != nil {
+			respondLoanPartyError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Loan party removed successfully"})
+	}
+}
+
+// ConfirmLoanGuarantee records a guarantor's consent with the time and the user who recorded it
+func ConfirmLoanGuarantee(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		loan, party, ok := loadLoanParty(c, db)
+		if !ok {
+			return
+		}
+		if err := loans.ConfirmGuarantee(db, loan, &party, actor(c), clock.Now()); err != nil {
+			respondLoanPartyError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Guarantee confirmed", "party": party})
+	}
+}
+
+// ==================== LOAN PAYMENT SPLIT HANDLERS ====================
+
+// loanSplitRequest sets the share of each installment every funding party pays, and from which account
+type loanSplitRequest struct {
+	FallbackToBorrower bool          `json:"fallback_to_borrower"` // Charge the borrower for a co-borrower share that fails
+	Shares             []loans.Share `json:"shares" binding:"required"`
+}
+
+// GetLoanSplit returns a loan's payment split, its funding parties and its latest collections, newest first
+func GetLoanSplit(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var loan models.Loan
+		if err := db.Select("id").First(&loan, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+			return
+		}
+		var split models.LoanSplit
+		if err := db.Where("loan_id = ?", loan.ID).First(&split).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Loan has no payment split"})
+			return
+		}
+		var parties []models.LoanParty
+		var collections []models.LoanCollection
+		err := db.Where("loan_id = ? AND share_percent > 0", loan.ID).Order("id").Find(&parties).Error
+		if err == nil {
+			err = db.Preload("Shares", func(q *gorm.DB) *gorm.DB { return q.Order("id") }).
+				Where("loan_id = ?", loan.ID).Order("scheduled_date DESC, id DESC").Limit(12).Find(&collections).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve payment split"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"split": split, "parties": parties, "collections": collections})
+	}
+}
+
+// SetLoanSplit configures how an active loan's installments are collected from its parties, replacing any split
+func SetLoanSplit(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req loanSplitRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		var loan models.Loan
+		if err := db.First(&loan, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+			return
+		}
+		if backed, _ := creditlines.BacksLoan(db, loan.ID); backed {
+			c.JSON(http.StatusConflict, gin.H{"error": "Lines of credit are repaid by deposits to their linked account"})
+			return
+		}
+
+		var split models.LoanSplit
+		var parties []models.LoanParty
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if split, err = loans.ConfigureSplit(tx, loan, req.Shares, req.FallbackToBorrower, actor(c), clock.Now()); err != nil {
+				return err
+			}
+			return tx.Where("loan_id = ? AND share_percent > 0", loan.ID).Order("id").Find(&parties).Error
+		})
+		switch {
+		case err == nil:
+		case errors.Is(err, loans.ErrLoanClosed), errors.Is(err, loans.ErrSplitDisbursed):
+			c.JSON(http.StatusConflict, gin.H{"error": "Loan is not active"})
+			return
+		case errors.Is(err, loans.ErrSplitTotal), errors.Is(err, loans.ErrSplitParty), errors.Is(err, loans.ErrSplitAccount),
+			errors.Is(err, loans.ErrSplitFallback), errors.Is(err, loans.ErrSplitDuplicate):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set payment split"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Payment split set", "split": split, "parties": parties})
+	}
+}
+
+// DisburseLoan pays out a pending loan once every guarantor has confirmed
+// Every party's share of the payment is checked against its debt-to-income limit first, then the borrower's credit
+func DisburseLoan(db *gorm.DB, maxDebtToIncome float64, reviewer *creditbureau.Reviewer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var loan models.Loan
+		if err := db.First(&loan, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+			return
+		}
+		if loan.Status == loans.StatusDeclined {
+			c.JSON(http.StatusConflict, gin.H{"error": "Loan application was declined", "code": "CREDIT_DECLINED"})
+			return
+		}
+		if loan.Status != loans.StatusPending {
+			c.JSON(http.StatusConflict, gin.H{"error": "Loan has already been disbursed"})
+			return
+		}
+		if backed, _ := creditlines.BacksLoan(db, loan.ID); backed {
+			c.JSON(http.StatusConflict, gin.H{"error": "Lines of credit are opened with POST /credit-lines/:id/activate"})
+			return
+		}
+		var parties []models.LoanParty
+		if err := db.Where("loan_id = ?", loan.ID).Order("id").Find(&parties).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve loan parties"})
+			return
+		}
+		if err := loans.CheckAffordability(db, loan, parties, maxDebtToIncome); err != nil {
+			respondLoanPartyError(c, err)
+			return
+		}
+		if !creditReview(c, db, reviewer, &loan) {
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if _, err := loans.Disburse(tx, &loan, generateAccountNumber(), actor(c), clock.Now()); err != nil {
+				return err
+			}
+			return events.Record(tx, events.AggregateLoan, loan.ID, events.LoanDisbursed, gin.H{
+				"loan_id":          loan.ID,
+				"loan_number":      loan.LoanNumber,
+				"principal_amount": loan.PrincipalAmount,
+				"account_id":       loan.AccountID,
+				"parties":          len(parties),
+			})
+		})
+		if err != nil {
+			if errors.Is(err, loans.ErrUnconfirmedGuarantor) {
+				respondLoanPartyError(c, err)
+				return
+			}
+			respondPostingError(c, err)
+			return
+		}
+		loan.Parties = parties
+		c.JSON(http.StatusOK, gin.H{"message": "Loan disbursed successfully", "loan": loan})
+	}
+}
+
+// ==================== CREDIT REVIEW HANDLERS ====================
+
+// recordCreditDecision saves a loan's credit decision and puts it on the outbox, inside an open transaction
+// The score and reason stay off the event: subscribers learn the outcome, not the customer's credit data
+func recordCreditDecision(tx *gorm.DB, loan *models.Loan) error {
+	if err := loans.SaveCreditDecision(tx, loan); err != nil {
+		return err
+	}
+	return events.Record(tx, events.AggregateLoan, loan.ID, events.LoanCreditDecided, gin.H{
+		"loan_id":     loan.ID,
+		"loan_number": loan.LoanNumber,
+		"decision":    loan.CreditDecision,
+		"reviewed_by": loan.CreditReviewedBy,
+	})
+}
+
+// creditReview is the credit check in the review step of a pending loan or line of credit
+// Returns true when the application may go ahead: checks are off, or it is approved. Otherwise it has responded -
+// 422 when declined, 202 when referred to manual review, 409 while a referral waits for staff
+func creditReview(c *gin.Context, db *gorm.DB, reviewer *creditbureau.Reviewer, loan *models.Loan) bool {
+	if !reviewer.Enabled() || loan.CreditDecision == creditbureau.DecisionApproved {
+		return true
+	}
+	if loan.CreditDecision == creditbureau.DecisionManual {
+		c.JSON(http.StatusConflict, gin.H{"error": "Application is waiting for a manual credit review", "code": "CREDIT_REVIEW_PENDING", "loan": loan})
+		return false
+	}
+
+	var customer models.Customer
+	if err := db.First(&customer, loan.CustomerID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve borrower"})
+		return false
+	}
+	reviewer.Review(c.Request.Context(), customer, loan, clock.Now())
+	if err := db.Transaction(func(tx *gorm.DB) error { return recordCreditDecision(tx, loan) }); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record credit decision"})
+		return false
+	}
+
+	switch loan.CreditDecision {
+	case creditbureau.DecisionApproved:
+		return true
+	case creditbureau.DecisionDeclined:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Application declined by credit review", "code": "CREDIT_DECLINED", "loan": loan})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"message": "Application referred for manual credit review", "loan": loan})
+	}
+	return false
+}
+
+// creditReviewRequest is a staff decision on a referred application
+type creditReviewRequest struct {
+	Decision string `json:"decision" binding:"required"` // approve or decline
+	Reason   string `json:"reason" binding:"required"`
+}
+
+// ReviewLoanCredit records a staff decision on an application referred for manual credit review
+// An approved application still has to be disbursed (or its line activated); a declined one is closed
+func ReviewLoanCredit(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req creditReviewRequest
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Decision != "approve" && req.Decision != "decline") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "decision must be approve or decline, with a reason"})
+			return
+		}
+		var loan models.Loan
+		if err := db.First(&loan, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+			return
+		}
+		if loan.Status != loans.StatusPending || loan.CreditDecision != creditbureau.DecisionManual {
+			c.JSON(http.StatusConflict, gin.H{"error": "Loan is not waiting for a manual credit review", "code": "CREDIT_REVIEW_NOT_PENDING"})
+			return
+		}
+
+		loan.CreditDecision = creditbureau.DecisionApproved
+		if req.Decision == "decline" {
+			loan.CreditDecision = creditbureau.DecisionDeclined
+		}
+		loan.CreditDecisionReason = strings.TrimSpace(req.Reason)
+		loan.CreditReviewedBy = actor(c)
+		if err := db.Transaction(func(tx *gorm.DB) error { return recordCreditDecision(tx, &loan) }); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record credit decision"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Credit review recorded", "loan": loan})
+	}
+}
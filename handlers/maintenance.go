@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"banking-app/maintenance"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== MAINTENANCE HANDLERS ====================
+
+// maintenanceRequest enters or exits maintenance mode
+type maintenanceRequest struct {
+	Enabled           *bool  `json:"enabled" binding:"required"`
+	Message           string `json:"message"`             // Shown to refused writers; defaults to a generic notice
+	RetryAfterSeconds int    `json:"retry_after_seconds"` // Retry-After on refused writes; defaults to 300
+}
+
+// GetMaintenance returns the maintenance state and the jobs running on this instance
+func GetMaintenance(mode *maintenance.Mode) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"maintenance":  mode.State(),
+			"jobs_running": mode.Running(),
+		})
+	}
+}
+
+// SetMaintenance enters or exits read-only maintenance mode
+// Entering waits for scheduled job runs on this instance to finish; jobs still running after
+// the drain timeout are reported so the operator knows to wait before migrating
+func SetMaintenance(mode *maintenance.Mode) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req maintenanceRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "enabled is required"})
+			return
+		}
+		if req.RetryAfterSeconds < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "retry_after_seconds cannot be negative"})
+			return
+		}
+
+		if !*req.Enabled {
+			state, err := mode.Exit(actor(c))
+			if err == maintenance.ErrNotEnabled {
+				c.JSON(http.StatusConflict, gin.H{"error": "Maintenance mode is not enabled"})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to exit maintenance mode"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message":     "Maintenance mode disabled",
+				"maintenance": state,
+			})
+			return
+		}
+
+		state, err := mode.Enter(actor(c), req.Message, req.RetryAfterSeconds)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enter maintenance mode"})
+			return
+		}
+		running := mode.Drain(maintenance.DrainTimeout)
+		c.JSON(http.StatusOK, gin.H{
+			"message":      "Maintenance mode enabled",
+			"maintenance":  state,
+			"jobs_drained": len(running) == 0,
+			"jobs_running": running,
+		})
+	}
+}
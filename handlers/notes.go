@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"banking-app/auth"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== NOTE HANDLERS ====================
+
+// Note visibilities
+const (
+	NoteInternal = "internal" // Staff only
+	NoteCustomer = "customer" // Also shown to the customer
+)
+
+// noteSubjects maps each record type notes attach to onto its model
+var noteSubjects = map[string]func() interface{}{
+	"transaction": func() interface{} { return &models.Transaction{} },
+	"account":     func() interface{} { return &models.Account{} },
+	"customer":    func() interface{} { return &models.Customer{} },
+	"loan":        func() interface{} { return &models.Loan{} },
+}
+
+// noteRequest is a new note; corrections name the note they correct
+type noteRequest struct {
+	Body           string `json:"body" binding:"required"`
+	Visibility     string `json:"visibility"` // internal (default) or customer
+	CorrectsNoteID *uint  `json:"corrects_note_id"`
+}
+
+// readsInternalNotes reports whether the caller is staff; everyone else only sees customer-visible notes
+func readsInternalNotes(c *gin.Context) bool {
+	role, _ := c.Get("user_role")
+	roleName, _ := role.(string)
+	return auth.Can(roleName, auth.PermInternalNotes)
+}
+
+// visibleNotes returns the notes on the given records the caller may see, oldest first, keyed by record ID
+// Used by ?include=notes so a whole page is loaded in one query
+func visibleNotes(c *gin.Context, db *gorm.DB, subjectType string, ids []uint) (map[uint][]models.Note, error) {
+	byID := make(map[uint][]models.Note)
+	if len(ids) == 0 {
+		return byID, nil
+	}
+	q := db.Where("subject_type = ? AND subject_id IN ?", subjectType, ids)
+	if !readsInternalNotes(c) {
+		q = q.Where("visibility = ?", NoteCustomer)
+	}
+	var notes []models.Note
+	if err := q.Order("id").Find(&notes).Error; err != nil {
+		return nil, err
+	}
+	for _, note := range notes {
+		byID[note.SubjectID] = append(byID[note.SubjectID], note)
+	}
+	return byID, nil
+}
+
+// noteSubjectID parses :id and checks the record exists, responding on failure
+func noteSubjectID(c *gin.Context, db *gorm.DB, subjectType string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	var count int64
+	if err == nil {
+		err = db.Model(noteSubjects[subjectType]()).Where("id = ?", id).Count(&count).Error
+	}
+	if err != nil || count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// GetNotes lists the notes on a transaction, account, customer or loan
+// Staff see every note; customers only those marked customer-visible
+func GetNotes(db *gorm.DB, subjectType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, ok := noteSubjectID(c, db, subjectType)
+		if !ok {
+			return
+		}
+		notes, err := visibleNotes(c, db, subjectType, []uint{id})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve notes"})
+			return
+		}
+		list := notes[id]
+		if list == nil {
+			list = []models.Note{}
+		}
+		c.JSON(http.StatusOK, gin.H{"notes": list, "total": len(list)})
+	}
+}
+
+// CreateNote attaches a note to a transaction, account, customer or loan without changing the record
+// Notes cannot be edited; to correct one, post a new note with corrects_note_id
+func CreateNote(db *gorm.DB, subjectType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, ok := noteSubjectID(c, db, subjectType)
+		if !ok {
+			return
+		}
+		var req noteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if req.Visibility == "" {
+			req.Visibility = NoteInternal
+		}
+		if req.Visibility != NoteInternal && req.Visibility != NoteCustomer {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "visibility must be internal or customer"})
+			return
+		}
+		if req.CorrectsNoteID != nil {
+			var count int64
+			db.Model(&models.Note{}).Where("id = ? AND subject_type = ? AND subject_id = ?", *req.CorrectsNoteID, subjectType, id).Count(&count)
+			if count == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "corrects_note_id must be a note on the same record"})
+				return
+			}
+		}
+
+		note := models.Note{
+			SubjectType:    subjectType,
+			SubjectID:      id,
+			Author:         actor(c),
+			Visibility:     req.Visibility,
+			Body:           req.Body,
+			CorrectsNoteID: req.CorrectsNoteID,
+		}
+		if err := db.Create(&note).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create note"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Note created successfully", "note": note})
+	}
+}
+
+// DeleteNote removes a note; only its author or an admin may, and the request is written to the audit log
+func DeleteNote(db *gorm.DB, subjectType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var note models.Note
+		err := db.Where("subject_type = ? AND subject_id = ?", subjectType, c.Param("id")).First(&note, c.Param("noteId")).Error
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+			return
+		}
+		role, _ := c.Get("user_role")
+		if note.Author != actor(c) && role != "admin" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the note's author or an admin can delete it"})
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&note).Update("deleted_by", actor(c)).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&note).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete note"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Note deleted successfully"})
+	}
+}
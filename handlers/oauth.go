@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"banking-app/clock"
+	"banking-app/consent"
+	"banking-app/middleware"
+	"banking-app/models"
+	"banking-app/oauth"
+	"banking-app/tenancy"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== OAUTH2 HANDLERS ====================
+
+// oauthClientRequest registers a third-party client
+type oauthClientRequest struct {
+	Name         string `json:"name" binding:"required"`
+	RedirectURIs string `json:"redirect_uris"` // Comma-separated; required for customer scopes
+	Scopes       string `json:"scopes" binding:"required"`
+}
+
+// approvalRequest is a signed-in customer's answer to a client's authorization request
+type approvalRequest struct {
+	ClientID    string `json:"client_id" binding:"required"`
+	RedirectURI string `json:"redirect_uri"`
+	Scope       string `json:"scope"` // Space-separated; defaults to every customer scope the client is registered for
+	State       string `json:"state"` // Returned to the client unchanged
+	Approve     bool   `json:"approve"`
+}
+
+// respondOAuthError sends an error in the OAuth2 format rather than the API's usual one, as clients expect
+func respondOAuthError(c *gin.Context, err error) {
+	var oauthErr *oauth.Error
+	if !errors.As(err, &oauthErr) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "the request could not be completed"})
+		return
+	}
+	status := http.StatusBadRequest
+	if oauthErr == oauth.ErrInvalidClient {
+		status = http.StatusUnauthorized
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	} else if oauthErr == oauth.ErrNoCustomer {
+		status = http.StatusForbidden
+	}
+	c.JSON(status, gin.H{"error": oauthErr.Code, "error_description": oauthErr.Description})
+}
+
+// clientCredentials authenticates the calling client from HTTP Basic auth or the client_id/client_secret form fields
+func clientCredentials(c *gin.Context, db *gorm.DB) (models.OAuthClient, error) {
+	id, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		id, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+	if id == "" || secret == "" {
+		return models.OAuthClient{}, oauth.ErrInvalidClient
+	}
+	return oauth.AuthenticateClient(db, id, secret)
+}
+
+// CreateOAuthClient registers a client; the secret is in the response only
+func CreateOAuthClient(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req oauthClientRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name and scopes are required"})
+			return
+		}
+		client, secret, err := oauth.RegisterClient(db, req.Name, req.RedirectURIs, req.Scopes, actor(c))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{
+			"message":       "Client registered; store the secret now, it cannot be shown again",
+			"client":        client,
+			"client_secret": secret,
+		})
+	}
+}
+
+// GetOAuthClients lists registered clients
+func GetOAuthClients(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var clients []models.OAuthClient
+		if err := db.Order("id").Find(&clients).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve clients"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"clients": clients, "total": len(clients)})
+	}
+}
+
+// DeleteOAuthClient removes a client; its tokens stop working on their next request
+func DeleteOAuthClient(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		result := db.Where("client_id = ?", c.Param("clientId")).Delete(&models.OAuthClient{})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete client"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Client deleted"})
+	}
+}
+
+// GetAuthorization validates a client's authorization request and describes it for the approval screen
+// Takes the standard response_type=code, client_id, redirect_uri, scope and state parameters
+func GetAuthorization(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		if c.Query("response_type") != "code" {
+			respondOAuthError(c, &oauth.Error{Code: "unsupported_response_type", Description: "response_type must be code"})
+			return
+		}
+		client, err := oauth.FindClient(db, c.Query("client_id"))
+		if err != nil {
+			respondOAuthError(c, oauth.ErrInvalidClient)
+			return
+		}
+		redirectURI, err := oauth.RedirectURI(client, c.Query("redirect_uri"))
+		if err != nil {
+			respondOAuthError(c, err)
+			return
+		}
+		scopes, err := oauth.RequestScopes(client, c.Query("scope"), consent.Scopes)
+		if err != nil {
+			respondOAuthError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"client":       gin.H{"client_id": client.ClientID, "name": client.Name},
+			"scopes":       strings.Split(scopes, ","),
+			"redirect_uri": redirectURI,
+			"state":        c.Query("state"),
+			"expires_in":   int(consent.MaxDuration.Seconds()), // How long the consent lasts once approved
+		})
+	}
+}
+
+// ApproveAuthorization records the signed-in customer's decision on a client's request
+// Approving grants a consent and returns the redirect carrying a one-time code; denying returns the
+// redirect carrying error=access_denied. The bank's front end sends the customer on to redirect_to
+func ApproveAuthorization(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req approvalRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondOAuthError(c, &oauth.Error{Code: "invalid_request", Description: "client_id is required"})
+			return
+		}
+		client, err := oauth.FindClient(db, req.ClientID)
+		if err != nil {
+			respondOAuthError(c, oauth.ErrInvalidClient)
+			return
+		}
+		redirectURI, err := oauth.RedirectURI(client, req.RedirectURI)
+		if err != nil {
+			respondOAuthError(c, err)
+			return
+		}
+		var user models.User
+		if err := db.First(&user, c.GetUint("user_id")).Error; err != nil {
+			respondOAuthError(c, oauth.ErrNoCustomer)
+			return
+		}
+
+		params := url.Values{}
+		if req.State != "" {
+			params.Set("state", req.State)
+		}
+		if !req.Approve {
+			params.Set("error", "access_denied")
+			c.JSON(http.StatusOK, gin.H{"redirect_to": withQuery(redirectURI, params)})
+			return
+		}
+		scopes, err := oauth.RequestScopes(client, req.Scope, consent.Scopes)
+		if err != nil {
+			respondOAuthError(c, err)
+			return
+		}
+		code, granted, err := oauth.Approve(db, client, user, redirectURI, scopes, clock.Now())
+		if err != nil {
+			respondOAuthError(c, err)
+			return
+		}
+		params.Set("code", code)
+		c.JSON(http.StatusOK, gin.H{"redirect_to": withQuery(redirectURI, params), "consent": granted})
+	}
+}
+
+// withQuery appends parameters to a redirect URI, keeping any query it already has
+func withQuery(uri string, params url.Values) string {
+	if strings.Contains(uri, "?") {
+		return uri + "&" + params.Encode()
+	}
+	return uri + "?" + params.Encode()
+}
+
+// IssueToken is the OAuth2 token endpoint for the client_credentials and authorization_code grants
+// Clients authenticate with HTTP Basic auth or client_id/client_secret form fields
+func IssueToken(db *gorm.DB, cfg oauth.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		c.Header("Cache-Control", "no-store")
+		client, err := clientCredentials(c, db)
+		if err != nil {
+			respondOAuthError(c, err)
+			return
+		}
+
+		now := time.Now()
+		expires := now.Add(cfg.TokenTTL)
+		var customerID uint
+		var role, scopes string
+		switch c.PostForm("grant_type") {
+		case oauth.GrantClientCredentials:
+			if scopes, err = oauth.RequestScopes(client, c.PostForm("scope"), consent.ServiceScopes); err != nil {
+				respondOAuthError(c, err)
+				return
+			}
+			role = oauth.RoleClient
+		case oauth.GrantAuthorizationCode:
+			issued, granted, err := oauth.Exchange(db, client, c.PostForm("code"), c.PostForm("redirect_uri"), clock.Now())
+			if err != nil {
+				respondOAuthError(c, err)
+				return
+			}
+			customerID, role, scopes = issued.CustomerID, oauth.RoleCustomer, issued.Scopes
+			// Tokens expire on wall time while consents follow the application clock, so cap by what is left
+			if left := granted.ExpiresAt.Sub(clock.Now()); now.Add(left).Before(expires) {
+				expires = now.Add(left)
+			}
+		default:
+			respondOAuthError(c, &oauth.Error{Code: "unsupported_grant_type", Description: "grant_type must be client_credentials or authorization_code"})
+			return
+		}
+
+		token, err := middleware.GenerateClientJWT(client.ClientID, client.TenantID, customerID, role, oauth.TokenScope(scopes), expires)
+		if err != nil {
+			respondOAuthError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"access_token": token,
+			"token_type":   "Bearer",
+			"expires_in":   int(expires.Sub(now).Seconds()),
+			"scope":        oauth.TokenScope(scopes),
+		})
+	}
+}
+
+// IntrospectToken reports whether one of the calling client's tokens is still usable (RFC 7662)
+// A token is inactive once expired, once its client is deleted, or for a customer token once no active
+// consent covers any of its scopes. Tokens issued to other clients are reported inactive
+func IntrospectToken(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		client, err := clientCredentials(c, db)
+		if err != nil {
+			respondOAuthError(c, err)
+			return
+		}
+		inactive := gin.H{"active": false}
+		claims, err := middleware.ParseJWT(c.PostForm("token"))
+		if err != nil || claims.ClientID != client.ClientID || claims.TenantID != client.TenantID {
+			c.JSON(http.StatusOK, inactive)
+			return
+		}
+
+		response := gin.H{
+			"active":     true,
+			"client_id":  claims.ClientID,
+			"scope":      claims.Scope,
+			"token_type": "Bearer",
+			"sub":        claims.Subject,
+			"role":       claims.Role,
+			"exp":        claims.ExpiresAt.Unix(),
+			"iat":        claims.IssuedAt.Unix(),
+		}
+		if claims.CustomerID != 0 {
+			response["customer_id"] = claims.CustomerID
+			var active *models.Consent
+			for _, scope := range strings.Fields(claims.Scope) {
+				if granted, err := consent.Active(db, claims.CustomerID, claims.ClientID, scope, clock.Now()); err == nil {
+					active = &granted
+					break
+				}
+			}
+			if active == nil {
+				c.JSON(http.StatusOK, inactive)
+				return
+			}
+			response["consent_id"] = active.ID
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/interest"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== PRODUCT RATE HANDLERS ====================
+
+// GetProductRates lists a product's rate history, latest effective date first, with the rate in force today
+func GetProductRates(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var product models.Product
+		if err := db.First(&product, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		var rates []models.ProductRate
+		if err := db.Where("account_type = ?", product.AccountType).Order("effective_date DESC").Find(&rates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product rates"})
+			return
+		}
+		current, err := interest.On(db, product.AccountType, clock.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product rates"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"product_id": product.ID, "account_type": product.AccountType, "current_rate": current, "rates": rates})
+	}
+}
+
+// ScheduleProductRate schedules a change to a product's interest rate and notifies its account holders
+// Body: {"rate": 0.0375, "effective_date": "2026-03-01", "reason": "Base rate cut"}
+func ScheduleProductRate(db *gorm.DB, cfg interest.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var product models.Product
+		if err := db.First(&product, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		var req struct {
+			Rate          *float64 `json:"rate" binding:"required"`
+			EffectiveDate string   `json:"effective_date" binding:"required"`
+			Reason        string   `json:"reason"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "rate and effective_date are required"})
+			return
+		}
+		effective, err := businessdays.ParseDate(req.EffectiveDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid effective_date, expected YYYY-MM-DD"})
+			return
+		}
+
+		change := models.ProductRate{Rate: *req.Rate, EffectiveDate: effective, Reason: req.Reason, ScheduledBy: actor(c)}
+		err = interest.Schedule(db, cfg, product, &change, clock.Now())
+		var notice *interest.NoticeError
+		switch {
+		case errors.As(err, &notice):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":                   err.Error(),
+				"code":                    "RATE_NOTICE_REQUIRED",
+				"notice_days":             notice.NoticeDays,
+				"earliest_effective_date": businessdays.Format(notice.Earliest),
+			})
+			return
+		case errors.Is(err, interest.ErrOutOfOrder):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": "RATE_CHANGE_OUT_OF_ORDER"})
+			return
+		case errors.Is(err, interest.ErrRate), errors.Is(err, interest.ErrEffectiveDate), errors.Is(err, interest.ErrUnchanged):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "INVALID_RATE_CHANGE"})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule rate change"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Rate change scheduled", "rate": change})
+	}
+}
+
+// accountRates adds the product rate an account earns today and its next scheduled change to its detail
+func accountRates(db *gorm.DB, detail *AccountDetail) error {
+	now := clock.Now()
+	var count int64
+	if err := db.Model(&models.ProductRate{}).Where("account_type = ? AND effective_date <= ?", detail.AccountType, now).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		rate, err := interest.On(db, detail.AccountType, now)
+		if err != nil {
+			return err
+		}
+		detail.InterestRate = &rate
+	}
+	upcoming, err := interest.Upcoming(db, detail.AccountType, now)
+	detail.UpcomingRate = upcoming
+	return err
+}
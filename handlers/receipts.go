@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"banking-app/middleware"
+	"banking-app/models"
+	"banking-app/receipts"
+	"banking-app/tenancy"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== RECEIPT HANDLERS ====================
+
+// GetTransactionReceipt returns proof that a transaction was posted, with both parties' masked
+// account numbers and the transaction's chained hash; ?format=pdf renders a document
+func GetTransactionReceipt(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		format := c.DefaultQuery("format", "json")
+		if format != "json" && format != "pdf" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be json or pdf"})
+			return
+		}
+
+		var transaction models.Transaction
+		if err := db.First(&transaction, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+			return
+		}
+		receipt, err := receipts.Build(db, transaction)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build receipt"})
+			return
+		}
+
+		if format == "json" {
+			opts := displayOptions(c)
+			opts.Currency = receipt.Currency
+			respondDisplayWith(c, http.StatusOK, gin.H{"receipt": receipt}, opts)
+			return
+		}
+		c.Header("Content-Type", "application/pdf")
+		c.Header("Content-Disposition", fmt.Sprintf("inline; filename=\"receipt-%s.pdf\"", receipt.TransactionID))
+		c.Status(http.StatusOK)
+		receipts.WritePDF(c.Writer, receipt, tenancy.Current(c).Name, middleware.Locale(c))
+	}
+}
+
+// VerifyAccountChain walks an account's transaction hash chain and reports the first break
+func VerifyAccountChain(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+			return
+		}
+		var account models.Account
+		if err := db.First(&account, uint(id)).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+
+		report, err := receipts.Verify(db, account.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify chain"})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
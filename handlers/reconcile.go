@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"banking-app/cache"
+	"banking-app/gl"
+	"banking-app/models"
+	"banking-app/reconcile"
+	"banking-app/tenancy"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== STATEMENT RECONCILIATION HANDLERS ====================
+
+// maxStatementSize bounds an uploaded bank statement
+const maxStatementSize = 10 << 20
+
+// pairRequest manually matches a statement line with a posting from the same report
+type pairRequest struct {
+	StatementItemID uint `json:"statement_item_id" binding:"required"` // unmatched_theirs item
+	LedgerItemID    uint `json:"ledger_item_id" binding:"required"`    // unmatched_ours item
+}
+
+// adjustRequest books an unmatched statement line
+type adjustRequest struct {
+	OffsetCode string `json:"offset_code"` // Internal account the entry is offset to; defaults to SUSPENSE
+}
+
+// respondReconcileError maps statement reconciliation errors to client responses
+func respondReconcileError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+	case errors.Is(err, reconcile.ErrNotAssetAccount), errors.Is(err, reconcile.ErrCurrency),
+		errors.Is(err, reconcile.ErrOffsetCode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, reconcile.ErrItemState), errors.Is(err, reconcile.ErrMismatch):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		respondPostingError(c, err)
+	}
+}
+
+// ImportStatement reconciles an external bank statement against a ledger account
+// Form fields: file (camt.053 XML or CSV), optional format, account_id (defaults to the cash account in the
+// statement's currency) and tolerance_days. The report is stored for review
+func ImportStatement(db *gorm.DB, toleranceDays int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A file is required"})
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+			return
+		}
+		defer file.Close()
+		data, err := io.ReadAll(io.LimitReader(file, maxStatementSize+1))
+		if err != nil || len(data) > maxStatementSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Statement files are limited to 10 MB"})
+			return
+		}
+
+		format := strings.ToLower(c.PostForm("format"))
+		stmt, err := reconcile.ParseStatement(data, format)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if format == "" {
+			format = reconcile.FormatCSV
+			if strings.HasPrefix(strings.TrimSpace(string(data)), "<") {
+				format = reconcile.FormatCAMT053
+			}
+		}
+		if raw := c.PostForm("tolerance_days"); raw != "" {
+			if toleranceDays, err = strconv.Atoi(raw); err != nil || toleranceDays < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "tolerance_days must be a non-negative number"})
+				return
+			}
+		}
+
+		var report models.MatchReport
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var account models.Account
+			if raw := c.PostForm("account_id"); raw != "" {
+				if err := tx.First(&account, raw).Error; err != nil {
+					return reconcile.ErrNotAssetAccount
+				}
+			} else {
+				currency := stmt.Currency
+				if currency == "" {
+					currency = tenancy.CurrentSettings(c).DefaultCurrency
+				}
+				var err error
+				if account, err = gl.Account(tx, tenancy.Current(c).ID, gl.Cash, currency); err != nil {
+					return err
+				}
+			}
+			var err error
+			report, err = reconcile.Import(tx, account, stmt, reconcile.ImportOptions{
+				ToleranceDays: toleranceDays,
+				Format:        format,
+				Filename:      header.Filename,
+				By:            actor(c),
+			})
+			return err
+		})
+		if err != nil {
+			respondReconcileError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Statement reconciled", "report": report})
+	}
+}
+
+// GetMatchReports lists statement reconciliations, newest first; ?status=open narrows the list
+func GetMatchReports(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 50)
+
+		var filter listFilter
+		if status := c.Query("status"); status != "" {
+			filter.where("status = ?", status)
+		}
+		var reports []models.MatchReport
+		total, err := filter.count(db, &models.MatchReport{})
+		if err == nil {
+			err = filter.apply(db).Order("id DESC").Offset(offset).Limit(limit).Find(&reports).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reports"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"reports": reports, "total": total, "page": page, "limit": limit})
+	}
+}
+
+// GetMatchReport returns a reconciliation with its items; ?status=unmatched_theirs narrows the items
+// Items pairing a posting carry the posting for review
+func GetMatchReport(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var report models.MatchReport
+		if err := db.First(&report, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Report not found"})
+			return
+		}
+
+		q := db.Where("match_report_id = ?", report.ID)
+		if status := c.Query("status"); status != "" {
+			q = q.Where("status = ?", status)
+		}
+		var items []models.MatchItem
+		if err := q.Order("id").Find(&items).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve report"})
+			return
+		}
+		var ids []uint
+		for _, item := range items {
+			if item.TransactionID != nil {
+				ids = append(ids, *item.TransactionID)
+			}
+		}
+		postings := make(map[uint]models.Transaction)
+		if len(ids) > 0 {
+			var rows []models.Transaction
+			if err := db.Where("id IN ?", ids).Find(&rows).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve report"})
+				return
+			}
+			for _, row := range rows {
+				postings[row.ID] = row
+			}
+		}
+
+		type itemView struct {
+			models.MatchItem
+			Posting *models.Transaction `json:"posting,omitempty"`
+		}
+		views := make([]itemView, len(items))
+		for i, item := range items {
+			views[i].MatchItem = item
+			if item.TransactionID != nil {
+				if posting, ok := postings[*item.TransactionID]; ok {
+					views[i].Posting = &posting
+				}
+			}
+		}
+		respondDisplay(c, http.StatusOK, gin.H{"report": report, "items": views})
+	}
+}
+
+// PairMatchItems manually matches an unmatched statement line with an unmatched posting of the same report
+func PairMatchItems(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var report models.MatchReport
+		if err := db.First(&report, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Report not found"})
+			return
+		}
+		var req pairRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "statement_item_id and ledger_item_id are required"})
+			return
+		}
+
+		var item models.MatchItem
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			item, err = reconcile.Pair(tx, &report, req.StatementItemID, req.LedgerItemID, actor(c))
+			return err
+		})
+		if err != nil {
+			respondReconcileError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Items matched", "item": item, "report": report})
+	}
+}
+
+// AdjustMatchItem books an unmatched statement line as an adjustment entry on the reconciled account
+func AdjustMatchItem(db *gorm.DB, balances *cache.Balances) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var report models.MatchReport
+		if err := db.First(&report, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Report not found"})
+			return
+		}
+		var req adjustRequest
+		c.ShouldBindJSON(&req)
+		itemID, err := strconv.ParseUint(c.Param("itemId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item ID"})
+			return
+		}
+
+		var item models.MatchItem
+		var entry models.Transaction
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			item, entry, err = reconcile.Adjust(tx, &report, uint(itemID), strings.ToUpper(req.OffsetCode), actor(c))
+			return err
+		})
+		if err != nil {
+			respondReconcileError(c, err)
+			return
+		}
+		balances.Invalidate(report.AccountID)
+		respondDisplay(c, http.StatusOK, gin.H{"message": "Adjustment posted", "item": item, "transaction": entry, "report": report})
+	}
+}
@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/models"
+	"banking-app/relationship"
+	"banking-app/tenancy"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== RELATIONSHIP PRICING HANDLERS ====================
+
+// relationshipTierRequest is a tier definition as admins send it, with YYYY-MM-DD dates
+type relationshipTierRequest struct {
+	Code             string  `json:"code" binding:"required"`
+	Name             string  `json:"name" binding:"required"`
+	Rank             int     `json:"rank"`
+	MinDeposits      float64 `json:"min_deposits"`
+	MinLoanBalance   float64 `json:"min_loan_balance"`
+	MinProducts      int     `json:"min_products"`
+	MinTenureDays    int     `json:"min_tenure_days"`
+	FeeWaivers       string  `json:"fee_waivers"` // Comma-separated account types
+	SavingsRateBonus float64 `json:"savings_rate_bonus"`
+	EffectiveFrom    string  `json:"effective_from" binding:"required"`
+	EffectiveTo      string  `json:"effective_to"` // Empty for open-ended
+}
+
+// tier parses the request into a tier definition
+func (r relationshipTierRequest) tier() (models.RelationshipTier, error) {
+	t := models.RelationshipTier{
+		Code:             r.Code,
+		Name:             r.Name,
+		Rank:             r.Rank,
+		MinDeposits:      r.MinDeposits,
+		MinLoanBalance:   r.MinLoanBalance,
+		MinProducts:      r.MinProducts,
+		MinTenureDays:    r.MinTenureDays,
+		FeeWaivers:       r.FeeWaivers,
+		SavingsRateBonus: r.SavingsRateBonus,
+	}
+	from, err := businessdays.ParseDate(r.EffectiveFrom)
+	if err != nil {
+		return t, err
+	}
+	t.EffectiveFrom = from
+	if r.EffectiveTo != "" {
+		to, err := businessdays.ParseDate(r.EffectiveTo)
+		if err != nil {
+			return t, err
+		}
+		t.EffectiveTo = &to
+	}
+	return t, nil
+}
+
+// checkRelationshipTier validates a definition and that no other definition overlaps it, responding when it fails
+func checkRelationshipTier(c *gin.Context, db *gorm.DB, t *models.RelationshipTier) bool {
+	if err := relationship.Validate(t); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "INVALID_RELATIONSHIP_TIER"})
+		return false
+	}
+	if t.FeeWaivers != "" {
+		for _, accountType := range strings.Split(t.FeeWaivers, ",") {
+			if !checkAccountType(c, db, accountType) {
+				return false
+			}
+		}
+	}
+	other, found, err := relationship.Conflict(db, *t)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check relationship tiers"})
+		return false
+	}
+	if found {
+		c.JSON(http.StatusConflict, gin.H{"error": relationship.ErrOverlap.Error(), "code": "RELATIONSHIP_TIER_OVERLAP", "conflicting_id": other.ID})
+		return false
+	}
+	return true
+}
+
+// GetRelationshipTiers lists tier definitions, best first; ?active_on=YYYY-MM-DD keeps those in effect on that day
+func GetRelationshipTiers(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var tiers []models.RelationshipTier
+		if err := db.Order("rank DESC, effective_from").Find(&tiers).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve relationship tiers"})
+			return
+		}
+		if activeOn := c.Query("active_on"); activeOn != "" {
+			day, err := businessdays.ParseDate(activeOn)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid active_on date, expected YYYY-MM-DD"})
+				return
+			}
+			active := tiers[:0]
+			for _, t := range tiers {
+				if relationship.InEffect(t, day) {
+					active = append(active, t)
+				}
+			}
+			tiers = active
+		}
+		c.JSON(http.StatusOK, gin.H{"relationship_tiers": tiers})
+	}
+}
+
+// CreateRelationshipTier adds a tier definition; customers move into it at the next recomputation
+// Body: {"code": "gold", "name": "Gold", "rank": 2, "min_deposits": 10000, "min_loan_balance": 1,
+// "fee_waivers": "checking", "savings_rate_bonus": 0.25, "effective_from": "2025-01-01"}
+func CreateRelationshipTier(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req relationshipTierRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "code, name and effective_from are required"})
+			return
+		}
+		tier, err := req.tier()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+			return
+		}
+		tier.CreatedBy = actor(c)
+		if !checkRelationshipTier(c, db, &tier) {
+			return
+		}
+		if err := db.Create(&tier).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create relationship tier"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Relationship tier created", "relationship_tier": tier})
+	}
+}
+
+// UpdateRelationshipTier replaces a tier definition
+// Once customers have been placed in a definition only its name and end date can change; new thresholds or
+// benefits take effect through a new definition of the same code starting the day after
+func UpdateRelationshipTier(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var existing models.RelationshipTier
+		if err := db.First(&existing, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Relationship tier not found"})
+			return
+		}
+		var req relationshipTierRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "code, name and effective_from are required"})
+			return
+		}
+		tier, err := req.tier()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+			return
+		}
+		tier.ID, tier.CreatedAt, tier.TenantID, tier.CreatedBy = existing.ID, existing.CreatedAt, existing.TenantID, existing.CreatedBy
+		if !checkRelationshipTier(c, db, &tier) {
+			return
+		}
+
+		used, err := relationship.InUse(db, existing.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update relationship tier"})
+			return
+		}
+		if used && (tier.Code != existing.Code || tier.Rank != existing.Rank ||
+			tier.MinDeposits != existing.MinDeposits || tier.MinLoanBalance != existing.MinLoanBalance ||
+			tier.MinProducts != existing.MinProducts || tier.MinTenureDays != existing.MinTenureDays ||
+			tier.FeeWaivers != existing.FeeWaivers || tier.SavingsRateBonus != existing.SavingsRateBonus ||
+			!tier.EffectiveFrom.Equal(existing.EffectiveFrom)) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "Customers have been placed in this tier; end it with effective_to and create a new definition for the new terms",
+				"code":  "RELATIONSHIP_TIER_IN_USE",
+			})
+			return
+		}
+
+		if err := db.Save(&tier).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update relationship tier"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Relationship tier updated", "relationship_tier": tier})
+	}
+}
+
+// DeleteRelationshipTier removes a definition no customer has been placed in; one in use is ended with effective_to instead
+func DeleteRelationshipTier(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var tier models.RelationshipTier
+		if err := db.First(&tier, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Relationship tier not found"})
+			return
+		}
+		used, err := relationship.InUse(db, tier.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete relationship tier"})
+			return
+		}
+		if used {
+			c.JSON(http.StatusConflict, gin.H{"error": "Customers have been placed in this tier; end it with effective_to instead", "code": "RELATIONSHIP_TIER_IN_USE"})
+			return
+		}
+		if err := db.Delete(&tier).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete relationship tier"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Relationship tier deleted"})
+	}
+}
+
+// RecomputeRelationships records this month's tier for every customer of the tenant now, instead of at the monthly run
+func RecomputeRelationships(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		recorded, err := relationship.Recompute(tenancy.DB(c, db), tenancy.Current(c).ID, clock.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recompute relationship tiers"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Relationship tiers recomputed", "customers": recorded})
+	}
+}
+
+// GetCustomerRelationship returns the customer's relationship tier and what they need for the next one
+// The tier is the one recorded at the last monthly computation, which is what fees and rates are priced with.
+// The inputs beside it are read now, so the next tier's needs reflect today's balances
+func GetCustomerRelationship(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var customer models.Customer
+		if err := db.First(&customer, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+			return
+		}
+
+		now := clock.Now()
+		record, tier, found, err := relationship.Current(db, customer.ID)
+		var inputs relationship.Inputs
+		if err == nil {
+			inputs, err = relationship.Measure(db, customer, now)
+		}
+		var tiers []models.RelationshipTier
+		if err == nil {
+			err = db.Find(&tiers).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve the relationship"})
+			return
+		}
+
+		response := gin.H{
+			"customer_id": customer.ID,
+			"tier":        tier,
+			"inputs":      inputs,
+			"as_of":       now,
+		}
+		if found {
+			response["recorded"] = record
+		} else {
+			response["recorded"] = nil
+		}
+
+		rank := 0
+		if tier != nil {
+			rank = tier.Rank
+		}
+		if qualifies, ok := relationship.Select(tiers, inputs, now); ok {
+			response["qualifies_for"] = qualifies.Code // Takes effect at the next computation
+			if qualifies.Rank > rank {
+				rank = qualifies.Rank
+			}
+		} else {
+			response["qualifies_for"] = nil
+		}
+		if next, ok := relationship.Next(tiers, rank, now); ok {
+			response["next_tier"] = gin.H{"tier": next, "needs": relationship.Missing(next, inputs)}
+		} else {
+			response["next_tier"] = nil
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/reports"
+	"banking-app/tenancy"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== REPORT HANDLERS ====================
+
+// GetTrialBalance lists every balance on the books per currency; each currency must net to zero
+func GetTrialBalance(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		balances, err := reports.TrialBalances(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build trial balance"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"as_of":          clock.Now().UTC(),
+			"trial_balances": balances,
+		})
+	}
+}
+
+// GetIncomeReport summarizes fee and interest income and interest expense by account and product
+// ?from= and ?to= are inclusive YYYY-MM-DD dates (default: month to date); ?currency= defaults to the tenant's
+// ?account_tag= and ?customer_tag= limit it to entries offsetting accounts with that tag, or whose owner has it
+func GetIncomeReport(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		now := clock.Now()
+		from := businessdays.StartOfMonth(now)
+		to := now
+
+		var err error
+		if raw := c.Query("from"); raw != "" {
+			if from, err = businessdays.ParseDate(raw); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+				return
+			}
+		}
+		if raw := c.Query("to"); raw != "" {
+			if to, err = businessdays.ParseDate(raw); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+				return
+			}
+		}
+		if to.Before(from) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+			return
+		}
+		currency := strings.ToUpper(c.DefaultQuery("currency", tenancy.CurrentSettings(c).DefaultCurrency))
+		var segment reports.Segment
+		var ok bool
+		if segment.AccountTag, ok = tagQuery(c, "account_tag"); !ok {
+			return
+		}
+		if segment.CustomerTag, ok = tagQuery(c, "customer_tag"); !ok {
+			return
+		}
+
+		report, err := reports.IncomeStatement(db, currency, from, businessdays.DayAfter(to), segment)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build income report"})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// GetExposureReport lists each customer's direct and contingent (guaranteed) loan exposure
+// ?tag= limits it to customers with that tag
+func GetExposureReport(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		tag, ok := tagQuery(c, "tag")
+		if !ok {
+			return
+		}
+		exposures, err := reports.Exposures(db, tag)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build exposure report"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"as_of":     clock.Now().UTC(),
+			"tag":       tag,
+			"exposures": exposures,
+		})
+	}
+}
+
+// GetInterestLiabilityReport summarizes interest accrued on deposits but not yet credited, by product
+// ?as_of= is a YYYY-MM-DD date, reported through its end (default: today); ?currency= defaults to the tenant's
+func GetInterestLiabilityReport(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		asOf := clock.Now()
+		if raw := c.Query("as_of"); raw != "" {
+			var err error
+			if asOf, err = businessdays.ParseDate(raw); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid as_of date, expected YYYY-MM-DD"})
+				return
+			}
+		}
+		currency := strings.ToUpper(c.DefaultQuery("currency", tenancy.CurrentSettings(c).DefaultCurrency))
+
+		report, err := reports.InterestLiabilities(db, tenancy.Current(c).ID, currency, asOf)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build interest liability report"})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
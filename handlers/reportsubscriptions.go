@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"banking-app/clock"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"banking-app/subscriptions"
+	"banking-app/tenancy"
+	"banking-app/uploads"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== REPORT SUBSCRIPTION HANDLERS ====================
+
+// subscriptionRequest is the editable part of a report subscription
+type subscriptionRequest struct {
+	Name       string              `json:"name" binding:"required"`
+	ReportType string              `json:"report_type"`
+	Parameters models.ReportParams `json:"parameters"`
+	Schedule   string              `json:"schedule"`
+	Format     string              `json:"format"`
+	Channel    string              `json:"channel"`
+	Target     string              `json:"target"`
+	AlertEmail string              `json:"alert_email"`
+	Status     string              `json:"status"` // Updates only: active or paused
+}
+
+// apply copies the request onto a subscription and validates it
+func (r subscriptionRequest) apply(sub *models.ReportSubscription) error {
+	sub.Name, sub.ReportType, sub.Params, sub.Schedule = r.Name, r.ReportType, r.Parameters, r.Schedule
+	sub.Format, sub.Channel, sub.Target, sub.AlertEmail = r.Format, r.Channel, r.Target, r.AlertEmail
+	return subscriptions.Validate(sub)
+}
+
+// loadSubscription finds the :id subscription, responding 404 when it does not exist
+func loadSubscription(c *gin.Context, db *gorm.DB) (models.ReportSubscription, bool) {
+	var sub models.ReportSubscription
+	if err := db.First(&sub, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Report subscription not found"})
+		return sub, false
+	}
+	return sub, true
+}
+
+// GetReportSubscriptions lists report subscriptions; ?status= and ?report_type= filter
+func GetReportSubscriptions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 50)
+
+		var filter listFilter
+		if status := c.Query("status"); status != "" {
+			filter.where("status = ?", status)
+		}
+		if reportType := c.Query("report_type"); reportType != "" {
+			filter.where("report_type = ?", reportType)
+		}
+
+		var subs []models.ReportSubscription
+		total, err := filter.count(db, &models.ReportSubscription{})
+		if err == nil {
+			err = filter.apply(db).Order("id").Offset(offset).Limit(limit).Find(&subs).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve report subscriptions"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"subscriptions": subs,
+			"total":         total,
+			"page":          page,
+			"limit":         limit,
+		})
+	}
+}
+
+// GetReportSubscription returns one report subscription
+func GetReportSubscription(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		if sub, ok := loadSubscription(c, db); ok {
+			c.JSON(http.StatusOK, gin.H{"subscription": sub})
+		}
+	}
+}
+
+// CreateReportSubscription subscribes to a report; the caller owns the subscription
+// Body: {"name": "Daily volume", "report_type": "transaction_volume", "parameters": {"days": 1},
+// "schedule": "0 7 * * *", "format": "csv", "channel": "email", "target": "ops@example.com"}
+func CreateReportSubscription(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req subscriptionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+		sub := models.ReportSubscription{OwnerID: c.GetUint("user_id"), Owner: actor(c), Status: subscriptions.StatusActive}
+		if err := req.apply(&sub); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		next, _ := subscriptions.Next(sub, clock.Now())
+		sub.NextRunAt = &next
+		if err := db.Create(&sub).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create report subscription"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Report subscription created", "subscription": sub})
+	}
+}
+
+// UpdateReportSubscription replaces a subscription's settings; "status" pauses or resumes it
+// The schedule restarts from now and any pending retry is dropped. A subscription whose owner is
+// deactivated cannot be resumed
+func UpdateReportSubscription(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		sub, ok := loadSubscription(c, db)
+		if !ok {
+			return
+		}
+		var req subscriptionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+		if err := req.apply(&sub); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if req.Status != "" && !authorizeTransition(c, lifecycle.ReportSubscription, sub.Status, req.Status, "") {
+			return
+		}
+		switch req.Status {
+		case "", sub.Status:
+		case subscriptions.StatusPaused:
+			sub.Status, sub.PausedReason = subscriptions.StatusPaused, "Paused by "+actor(c)
+		case subscriptions.StatusActive:
+			active, err := subscriptions.OwnerActive(db, sub)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update report subscription"})
+				return
+			}
+			if !active {
+				c.JSON(http.StatusConflict, gin.H{"error": subscriptions.ErrOwnerInactive.Error(), "code": "OWNER_INACTIVE"})
+				return
+			}
+			sub.Status, sub.PausedReason = subscriptions.StatusActive, ""
+		}
+
+		next, _ := subscriptions.Next(sub, clock.Now())
+		sub.NextRunAt, sub.DueAt, sub.Attempts = &next, nil, 0
+		if err := db.Save(&sub).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update report subscription"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Report subscription updated", "subscription": sub})
+	}
+}
+
+// DeleteReportSubscription stops a subscription; its runs and artifacts are kept
+func DeleteReportSubscription(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		result := db.Delete(&models.ReportSubscription{}, c.Param("id"))
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete report subscription"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Report subscription not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Report subscription deleted"})
+	}
+}
+
+// RunReportSubscription renders and delivers a subscription's report now, outside its schedule
+// A manual run that fails is not retried and does not alert the owner, since the caller sees the result
+func RunReportSubscription(db *gorm.DB, storage uploads.Storage, cfg subscriptions.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		sub, ok := loadSubscription(c, db)
+		if !ok {
+			return
+		}
+		now := clock.Now()
+		run, err := subscriptions.Run(db, storage, cfg, sub, now, subscriptions.TriggerManual, 1)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run report subscription"})
+			return
+		}
+		db.Model(&sub).Updates(map[string]interface{}{"last_run_at": now, "last_run_status": run.Status})
+
+		status := http.StatusCreated
+		if run.Status == subscriptions.RunFailed {
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{"run": run})
+	}
+}
+
+// GetReportRuns lists a subscription's runs, newest first
+func GetReportRuns(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 50)
+		sub, ok := loadSubscription(c, db)
+		if !ok {
+			return
+		}
+
+		var filter listFilter
+		filter.where("subscription_id = ?", sub.ID)
+		if status := c.Query("status"); status != "" {
+			filter.where("status = ?", status)
+		}
+		var runs []models.ReportRun
+		total, err := filter.count(db, &models.ReportRun{})
+		if err == nil {
+			err = filter.apply(db).Order("id DESC").Offset(offset).Limit(limit).Find(&runs).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve report runs"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"runs":  runs,
+			"total": total,
+			"page":  page,
+			"limit": limit,
+		})
+	}
+}
+
+// GetReportArtifact downloads the report a run rendered
+func GetReportArtifact(db *gorm.DB, storage uploads.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var run models.ReportRun
+		if err := db.Where("subscription_id = ?", c.Param("id")).First(&run, c.Param("runId")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Report run not found"})
+			return
+		}
+		if run.ArtifactKey == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "This run produced no report", "run_error": run.Error})
+			return
+		}
+		file, err := storage.Get(run.ArtifactKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read report"})
+			return
+		}
+		defer file.Close()
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"report-%d-%d%s\"", run.SubscriptionID, run.ID, path.Ext(run.ArtifactKey)))
+		c.DataFromReader(http.StatusOK, run.Size, run.ContentType, file, nil)
+	}
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"banking-app/cache"
+	"banking-app/duplicates"
+	"banking-app/events"
+	"banking-app/flags"
+	"banking-app/gl"
+	"banking-app/models"
+	"banking-app/restrictions"
+	"banking-app/reviews"
+	"banking-app/tenancy"
+	"banking-app/transfers"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== ACCOUNT RESTRICTION HANDLERS ====================
+
+// restrictionsRequest replaces an account's restrictions; the reason is kept on the change event
+type restrictionsRequest struct {
+	models.AccountRestrictions
+	Reason string `json:"reason"`
+}
+
+// UpdateAccountRestrictions sets an account's deposit-only, outbound-transfer and withdrawal-approval restrictions
+// Body: {"deposit_only": false, "no_outbound_transfers": true, "staff_approval_for_withdrawals": true, "reason": "..."}
+// The change is recorded on the account's activity feed; debits already waiting for approval stay in the queue
+func UpdateAccountRestrictions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req restrictionsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if strings.TrimSpace(req.Reason) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required"})
+			return
+		}
+
+		var account models.Account
+		if err := db.First(&account, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		if account.AccountType == gl.AccountType {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Internal accounts cannot be restricted"})
+			return
+		}
+		previous := account.Restrictions
+		if previous == req.AccountRestrictions {
+			c.JSON(http.StatusOK, gin.H{"account_id": account.ID, "restrictions": account.Restrictions})
+			return
+		}
+
+		account.Restrictions = req.AccountRestrictions
+		err := db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Model(&account).Updates(map[string]interface{}{
+				"restriction_deposit_only":                   account.Restrictions.DepositOnly,
+				"restriction_no_outbound_transfers":          account.Restrictions.NoOutboundTransfers,
+				"restriction_staff_approval_for_withdrawals": account.Restrictions.StaffApprovalForWithdrawals,
+			}).Error
+			if err != nil {
+				return err
+			}
+			return events.Record(tx, events.AggregateAccount, account.ID, events.AccountRestricted, gin.H{
+				"account_id":            account.ID,
+				"account_number":        account.AccountNumber,
+				"restrictions":          account.Restrictions,
+				"previous_restrictions": previous,
+				"reason":                req.Reason,
+				"changed_by":            actor(c),
+			})
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update restrictions"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"account_id": account.ID, "restrictions": account.Restrictions})
+	}
+}
+
+// GetWithdrawalApprovals lists debits held for staff approval, oldest first so the longest waiting lead
+// ?status= filters (pending, approved, rejected, all; default pending) and ?account_id= narrows to one account
+func GetWithdrawalApprovals(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 50)
+
+		var filter listFilter
+		if status := c.DefaultQuery("status", restrictions.StatusPending); status != "all" {
+			filter.where("status = ?", status)
+		}
+		if accountID := c.Query("account_id"); accountID != "" {
+			filter.where("account_id = ?", accountID)
+		}
+
+		var approvals []models.WithdrawalApproval
+		total, err := filter.count(db, &models.WithdrawalApproval{})
+		if err == nil {
+			err = filter.apply(db).Order("created_at, id").Offset(offset).Limit(limit).Find(&approvals).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve approvals"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"approvals": approvals,
+			"total":     total,
+			"page":      page,
+			"limit":     limit,
+		})
+	}
+}
+
+// GetWithdrawalApproval returns one held debit
+func GetWithdrawalApproval(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var approval models.WithdrawalApproval
+		if err := db.First(&approval, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Approval not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"approval": approval})
+	}
+}
+
+// decideApprovalRequest carries the reason for a decision
+type decideApprovalRequest struct {
+	Note string `json:"note"`
+}
+
+// ApproveWithdrawal posts a held debit as it was requested
+// The approver must not be the user who requested it. A debit that no longer posts, such as for insufficient
+// funds, fails with the posting's error and stays in the queue
+func ApproveWithdrawal(db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store, cfg transfers.Config) gin.HandlerFunc {
+	return decideWithdrawal(db, balances, featureFlags, cfg, true)
+}
+
+// RejectWithdrawal takes a held debit out of the queue without posting it
+func RejectWithdrawal(db *gorm.DB) gin.HandlerFunc {
+	return decideWithdrawal(db, nil, nil, transfers.Config{}, false)
+}
+
+// decideWithdrawal takes a pending approval out of the queue by approving or rejecting it
+func decideWithdrawal(db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store, cfg transfers.Config, approve bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid approval ID"})
+			return
+		}
+		var req decideApprovalRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if !approve && strings.TrimSpace(req.Note) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "note is required to reject"})
+			return
+		}
+
+		var approval models.WithdrawalApproval
+		if err := db.First(&approval, uint(id)).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Approval not found"})
+			return
+		}
+		if approve {
+			err = restrictions.Claim(db, &approval, actor(c), req.Note)
+		} else {
+			err = restrictions.Reject(db, &approval, actor(c), req.Note)
+		}
+		switch err {
+		case nil:
+		case restrictions.ErrNotPending:
+			c.JSON(http.StatusConflict, gin.H{"error": "Approval has already been decided", "code": "APPROVAL_DECIDED"})
+			return
+		case restrictions.ErrRequesterCannotDecide:
+			c.JSON(http.StatusForbidden, gin.H{"error": "A withdrawal must be decided by someone other than its requester", "code": "SAME_USER_DECISION"})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decide approval"})
+			return
+		}
+		if !approve {
+			c.JSON(http.StatusOK, gin.H{"message": "Withdrawal rejected", "approval": approval})
+			return
+		}
+
+		response, apiErr := postApproved(c, db, balances, featureFlags, cfg, &approval)
+		if apiErr != nil {
+			// The debit did not post, so the approval goes back to the queue for another try or a rejection
+			restrictions.Release(db, &approval)
+			apiErr.respondV1(c)
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// postApproved posts a claimed approval's debit through the API it was requested on and links it to the approval
+func postApproved(c *gin.Context, db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store, cfg transfers.Config, approval *models.WithdrawalApproval) (gin.H, *apiError) {
+	response := gin.H{"message": "Withdrawal approved and posted", "approval": approval}
+	if approval.Kind == restrictions.KindTransfer {
+		result, _, apiErr := postTransfer(c, db, balances, featureFlags, cfg, reviews.Config{}, duplicates.Config{}, transferRequest{
+			FromAccountID:    approval.AccountID,
+			ToAccountID:      approval.ToAccountID,
+			Amount:           approval.Amount,
+			Description:      approval.Description,
+			Reference:        approval.Reference,
+			Channel:          approval.Channel,
+			ConfirmDuplicate: true, // Staff reviewed it; the hold itself was the original request
+		}, true)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		restrictions.Complete(db, approval, &result.Transfer.DebitTransactionID, &result.Transfer.ID)
+		response["transfer"] = result.Transfer
+		if result.Fee != nil {
+			response["fee"] = result.Fee
+		}
+		return response, nil
+	}
+
+	transaction := models.Transaction{
+		AccountID:       approval.AccountID,
+		TransactionType: approval.TransactionType,
+		Amount:          approval.Amount,
+		Description:     approval.Description,
+		Memo:            approval.Memo,
+		Reference:       approval.Reference,
+		Channel:         approval.Channel,
+	}
+	fee, _, apiErr := postTransaction(c, db, balances, featureFlags, reviews.Config{}, duplicates.Config{}, &transaction, true)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	restrictions.Complete(db, approval, &transaction.ID, nil)
+	response["transaction"] = transaction
+	if fee != nil {
+		response["fee"] = fee
+	}
+	return response, nil
+}
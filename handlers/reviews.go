@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"banking-app/cache"
+	"banking-app/flags"
+	"banking-app/models"
+	"banking-app/reviews"
+	"banking-app/tenancy"
+	"banking-app/transfers"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== TRANSACTION REVIEW HANDLERS ====================
+
+// held is a debit queued instead of posted: for staff approval from a restricted account, or for review as the
+// first large debit from a new account
+type held struct {
+	Approval *models.WithdrawalApproval
+	Review   *models.TransactionReview
+}
+
+// respondV1 answers a held debit with 202; what names the debit in the message, e.g. Withdrawal
+// A review carries the time it posts by at the latest, when no one decides it sooner
+func (h *held) respondV1(c *gin.Context, what string) {
+	if h.Review != nil {
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":             what + " is pending review and will post once approved",
+			"status":              h.Review.Status,
+			"estimated_review_by": h.Review.ReviewBy,
+			"review":              h.Review,
+		})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":  what + " held for staff approval",
+		"approval": h.Approval,
+	})
+}
+
+// respondV2 answers a held debit with 202 and its v2 representation
+func (h *held) respondV2(c *gin.Context) {
+	if h.Review != nil {
+		c.JSON(http.StatusAccepted, gin.H{"data": newReviewV2(*h.Review)})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"data": newApprovalV2(*h.Approval)})
+}
+
+// GetTransactionReviews lists debits held for review, those posting soonest first
+// ?status= filters (pending_review, approved, declined, all; default pending_review) and ?account_id= narrows to one account
+func GetTransactionReviews(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 50)
+
+		var filter listFilter
+		if status := c.DefaultQuery("status", reviews.StatusPending); status != "all" {
+			filter.where("status = ?", status)
+		}
+		if accountID := c.Query("account_id"); accountID != "" {
+			filter.where("account_id = ?", accountID)
+		}
+
+		var held []models.TransactionReview
+		total, err := filter.count(db, &models.TransactionReview{})
+		if err == nil {
+			err = filter.apply(db).Order("review_by, id").Offset(offset).Limit(limit).Find(&held).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reviews"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"reviews": held,
+			"total":   total,
+			"page":    page,
+			"limit":   limit,
+		})
+	}
+}
+
+// GetTransactionReview returns one held debit
+func GetTransactionReview(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var review models.TransactionReview
+		if err := db.First(&review, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"review": review})
+	}
+}
+
+// ApproveTransactionReview posts a held debit as it was requested, effective now
+// Body: {"note": "..."}, optional. A debit that no longer posts fails with the posting's error and stays pending
+func ApproveTransactionReview(db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store, cfg transfers.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		review, note, ok := pendingReview(c, db, false)
+		if !ok {
+			return
+		}
+		posted, err := reviews.Approve(db, &review, actor(c), note, cfg, featureFlags, balances)
+		if err != nil {
+			reviewError(err).respondV1(c)
+			return
+		}
+
+		response := gin.H{"message": "Review approved and posted", "review": review}
+		if posted.Transfer != nil {
+			response["transfer"] = posted.Transfer.Transfer
+		} else {
+			response["transaction"] = posted.Transaction
+		}
+		if posted.Fee != nil {
+			response["fee"] = posted.Fee
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// DeclineTransactionReview takes a held debit out of the queue without posting it and tells the customer
+// Body: {"note": "..."}; the note is required and kept on the review, not sent to the customer
+func DeclineTransactionReview(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		review, note, ok := pendingReview(c, db, true)
+		if !ok {
+			return
+		}
+		if err := reviews.Decline(db, &review, actor(c), note); err != nil {
+			reviewError(err).respondV1(c)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Review declined and the held amount released", "review": review})
+	}
+}
+
+// pendingReview loads the review in the route and the decision note, responding when either is unusable
+func pendingReview(c *gin.Context, db *gorm.DB, noteRequired bool) (models.TransactionReview, string, bool) {
+	var review models.TransactionReview
+	var req decideApprovalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return review, "", false
+	}
+	if noteRequired && strings.TrimSpace(req.Note) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "note is required to decline"})
+		return review, "", false
+	}
+	if err := db.First(&review, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+		return review, "", false
+	}
+	return review, req.Note, true
+}
+
+// reviewError maps a failed decision, including why an approved debit did not post
+func reviewError(err error) *apiError {
+	switch {
+	case errors.Is(err, reviews.ErrNotPending):
+		return &apiError{Status: http.StatusConflict, Code: "REVIEW_DECIDED", Message: "Review has already been decided", v1Code: true}
+	case errors.Is(err, reviews.ErrRequesterCannotDecide):
+		return &apiError{Status: http.StatusForbidden, Code: "SAME_USER_DECISION", Message: "A withdrawal must be decided by someone other than its requester", v1Code: true}
+	case errors.Is(err, transfers.ErrDestinationInactive):
+		return &apiError{Status: http.StatusBadRequest, Code: "DESTINATION_INACTIVE", Message: err.Error()}
+	case errors.Is(err, transfers.ErrCurrencyMismatch):
+		return &apiError{Status: http.StatusBadRequest, Code: "CURRENCY_MISMATCH", Message: err.Error()}
+	}
+	return postingError(err)
+}
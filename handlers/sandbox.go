@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"banking-app/jobs"
+	"banking-app/ledger"
+	"banking-app/middleware"
+	"banking-app/sandbox"
+	"banking-app/tenancy"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== SANDBOX HANDLERS ====================
+
+// GetSandbox returns the sandbox's fake time
+func GetSandbox(sb *sandbox.Sandbox) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, sb.Status())
+	}
+}
+
+// AdvanceSandboxTime moves the fake clock forward, running the scheduled jobs due in between
+// Body: {"days": 30} and/or {"duration": "36h"}; the two add up
+func AdvanceSandboxTime(sb *sandbox.Sandbox) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Days     int    `json:"days"`
+			Duration string `json:"duration"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		d := time.Duration(req.Days) * 24 * time.Hour
+		if req.Duration != "" {
+			parsed, err := time.ParseDuration(req.Duration)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid duration, expected e.g. 36h or 90m"})
+				return
+			}
+			d += parsed
+		}
+
+		advanced, err := sb.Advance(d)
+		switch err {
+		case nil:
+		case sandbox.ErrAdvance, jobs.ErrTooManyRuns:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		default:
+			// The clock stays at the last run made, so the caller can see how far it got
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "advanced": advanced})
+			return
+		}
+		c.JSON(http.StatusOK, advanced)
+	}
+}
+
+// ResetSandbox deletes all sandbox data and puts the clock back at its start
+func ResetSandbox(sb *sandbox.Sandbox) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := sb.Reset(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset the sandbox"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Sandbox reset", "sandbox": sb.Status()})
+	}
+}
+
+// simulationCaller names a caller for simulations: an OAuth client by its client id, anyone else by username,
+// within their tenant
+func simulationCaller(tenantID uint, clientID, username string) string {
+	if tenantID == 0 {
+		tenantID = tenancy.DefaultTenantID
+	}
+	if clientID != "" {
+		return fmt.Sprintf("%d/client/%s", tenantID, clientID)
+	}
+	return fmt.Sprintf("%d/user/%s", tenantID, username)
+}
+
+// contextCaller names the signed-in caller of a sandbox route
+func contextCaller(c *gin.Context) string {
+	var tenantID uint
+	if value, ok := c.Get("tenant_id"); ok {
+		tenantID, _ = value.(uint)
+	}
+	clientID, _ := c.Get("client_id")
+	id, _ := clientID.(string)
+	return simulationCaller(tenantID, id, c.GetString("username"))
+}
+
+// tokenCaller names the caller of any request from its bearer token, before the route checks it
+func tokenCaller(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	claims, err := middleware.ParseJWT(strings.TrimPrefix(header, "Bearer "))
+	if err != nil {
+		return "", false
+	}
+	return simulationCaller(claims.TenantID, claims.ClientID, claims.Username), true
+}
+
+// respondSimulated writes a forced error in the shape of the API version the request went to
+func respondSimulated(c *gin.Context, e *apiError) {
+	if strings.HasPrefix(c.Request.URL.Path, "/api/v2/") {
+		e.respondV2(c)
+	} else {
+		e.respondV1(c)
+	}
+	c.Abort()
+}
+
+// sleepFor waits d, or until the client goes away; it reports whether the request is still wanted
+func sleepFor(c *gin.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.Request.Context().Done():
+		c.Abort()
+		return false
+	}
+}
+
+// SandboxSimulation applies forced errors and latency to the requests of callers who asked for them, and makes
+// transfers to the magic accounts end the way their numbers say. Sandbox routes are left alone, so a caller can
+// always look at and clear what is pending. Installed only in sandbox mode
+func SandboxSimulation(sb *sandbox.Sandbox) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if path == "/api/v1/sandbox" || strings.HasPrefix(path, "/api/v1/sandbox/") {
+			c.Next()
+			return
+		}
+
+		if caller, ok := tokenCaller(c); ok {
+			if sim, ok := sb.TakeSimulation(caller); ok {
+				c.Header("X-Sandbox-Simulated", "true")
+				if !sleepFor(c, sim.Latency) {
+					return
+				}
+				if sim.ErrorCode != "" {
+					respondSimulated(c, &apiError{Status: sim.Status, Code: sim.ErrorCode, Message: "Simulated " + sim.ErrorCode + " error", v1Code: true})
+					return
+				}
+			}
+		}
+
+		if c.Request.Method == http.MethodPost && (path == "/api/v1/transfers" || path == "/api/v2/transfers") {
+			body, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				c.Next()
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			var req struct {
+				ToAccountID uint `json:"to_account_id"`
+			}
+			if json.Unmarshal(body, &req) == nil && req.ToAccountID != 0 {
+				switch magic, _ := sb.MagicAccount(req.ToAccountID); magic {
+				case sandbox.AccountInsufficient:
+					e := *postingError(ledger.ErrInsufficientFunds)
+					e.v1Code = true
+					respondSimulated(c, &e)
+					return
+				case sandbox.AccountFrozen:
+					respondSimulated(c, &apiError{Status: http.StatusForbidden, Code: "ACCOUNT_FROZEN", Message: "Destination account is frozen", v1Code: true})
+					return
+				case sandbox.AccountTimeout:
+					c.Header("X-Sandbox-Simulated", "true")
+					if !sleepFor(c, sb.TimeoutDelay()) {
+						return
+					}
+				}
+			}
+		}
+		c.Next()
+	}
+}
+
+// simulationRequest forces the caller's next requests to fail or to be slow
+type simulationRequest struct {
+	ErrorCode string `json:"error_code"` // One of sandbox.SimulatedCodes; empty adds latency only
+	Status    int    `json:"status"`     // Overrides the code's usual status, 400-599
+	LatencyMS int64  `json:"latency_ms"` // Added before each request is answered
+	Count     int    `json:"count"`      // Requests affected; defaults to 1
+}
+
+// CreateSandboxSimulation forces the caller's next requests to return an error code or to wait, replacing
+// whatever was pending. The caller is the signed-in user, or the OAuth client the token was issued to
+func CreateSandboxSimulation(sb *sandbox.Sandbox) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req simulationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		sim, err := sb.Simulate(contextCaller(c), sandbox.Simulation{
+			ErrorCode: req.ErrorCode,
+			Status:    req.Status,
+			Latency:   time.Duration(req.LatencyMS) * time.Millisecond,
+			Remaining: req.Count,
+		})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "error_codes": sandbox.SimulatedCodes})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"simulation": sim})
+	}
+}
+
+// GetSandboxSimulation returns what the caller has pending; simulation is null when nothing is
+func GetSandboxSimulation(sb *sandbox.Sandbox) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if sim, ok := sb.Simulation(contextCaller(c)); ok {
+			c.JSON(http.StatusOK, gin.H{"simulation": sim})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"simulation": nil})
+	}
+}
+
+// ClearSandboxSimulation drops what the caller has pending
+func ClearSandboxSimulation(sb *sandbox.Sandbox) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sb.ClearSimulation(contextCaller(c))
+		c.JSON(http.StatusOK, gin.H{"message": "Simulation cleared"})
+	}
+}
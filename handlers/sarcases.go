@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/middleware"
+	"banking-app/models"
+	"banking-app/sar"
+	"banking-app/tenancy"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== SAR CASE HANDLERS ====================
+
+// SARCaseDetail is a case with its linked transactions, documents and investigation notes
+type SARCaseDetail struct {
+	models.SARCase
+	Transactions []models.SARCaseTransaction `json:"transactions"`
+	Documents    []models.SARCaseDocument    `json:"documents"`
+	Notes        []models.SARCaseNote        `json:"notes"`
+}
+
+// sarCaseDetail loads a case's links and notes
+func sarCaseDetail(db *gorm.DB, c models.SARCase) (SARCaseDetail, error) {
+	detail := SARCaseDetail{SARCase: c, Transactions: []models.SARCaseTransaction{}, Documents: []models.SARCaseDocument{},
+		Notes: []models.SARCaseNote{}}
+	err := db.Where("case_id = ?", c.ID).Order("id").Find(&detail.Transactions).Error
+	if err == nil {
+		err = db.Where("case_id = ?", c.ID).Order("id").Find(&detail.Documents).Error
+	}
+	if err == nil {
+		err = db.Where("case_id = ?", c.ID).Order("id").Find(&detail.Notes).Error
+	}
+	return detail, err
+}
+
+// respondSARCase writes a case in full, or a 500 if its details cannot be loaded
+func respondSARCase(c *gin.Context, db *gorm.DB, status int, sarCase models.SARCase) {
+	detail, err := sarCaseDetail(db, sarCase)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve case"})
+		return
+	}
+	c.JSON(status, gin.H{"case": detail})
+}
+
+// respondSARError maps case errors to client responses
+func respondSARError(c *gin.Context, err error) {
+	if e := transitionError(err); e != nil {
+		e.respondV1(c)
+		return
+	}
+	switch {
+	case errors.Is(err, sar.ErrClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, sar.ErrCustomerNotFound), errors.Is(err, sar.ErrSourceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, sar.ErrNarrativeRequired), errors.Is(err, sar.ErrUnknownSource), errors.Is(err, sar.ErrInvestigator),
+		errors.Is(err, sar.ErrTransactionNotFound), errors.Is(err, sar.ErrDocumentNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update case"})
+	}
+}
+
+// loadSARCase fetches the :id case and records the read of its subject in the audit log
+func loadSARCase(c *gin.Context, db *gorm.DB) (models.SARCase, bool) {
+	var sarCase models.SARCase
+	if err := db.First(&sarCase, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Case not found"})
+		return sarCase, false
+	}
+	middleware.AuditCustomer(c, sarCase.CustomerID)
+	return sarCase, true
+}
+
+// GetSARCases lists cases, soonest deadline first
+// ?status= filters (default the open and investigating ones, all for every status); ?assigned_to= and
+// ?customer_id= narrow further
+func GetSARCases(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 50)
+
+		var filter listFilter
+		switch status := c.Query("status"); status {
+		case "":
+			filter.where("status IN ?", sar.Active)
+		case "all":
+		default:
+			filter.where("status = ?", status)
+		}
+		if assigned := c.Query("assigned_to"); assigned != "" {
+			filter.where("assigned_to = ?", assigned)
+		}
+		if customerID := c.Query("customer_id"); customerID != "" {
+			filter.where("customer_id = ?", customerID)
+		}
+
+		var cases []models.SARCase
+		total, err := filter.count(db, &models.SARCase{})
+		if err == nil {
+			err = filter.apply(db).Order("due_at, id").Offset(offset).Limit(limit).Find(&cases).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve cases"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"cases": cases, "total": total, "page": page, "limit": limit})
+	}
+}
+
+// sarCaseRequest opens a case for a customer, or from an alert with source_type and source_id
+type sarCaseRequest struct {
+	CustomerID     uint   `json:"customer_id"`
+	SourceType     string `json:"source_type"` // transaction_review or duplicate_payment
+	SourceID       uint   `json:"source_id"`
+	Narrative      string `json:"narrative"`
+	AssignedTo     string `json:"assigned_to"`
+	TransactionIDs []uint `json:"transaction_ids"`
+	DocumentIDs    []uint `json:"document_ids"`
+}
+
+// CreateSARCase opens a case, due SAR_FILING_DAYS from now
+// A case opened from a held transaction review or a duplicate payment flag takes its subject and transactions from
+// it; otherwise customer_id names the subject
+func CreateSARCase(db *gorm.DB, cfg sar.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req sarCaseRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		var source *sar.Source
+		if req.SourceType != "" || req.SourceID != 0 {
+			source = &sar.Source{Type: req.SourceType, ID: req.SourceID}
+		} else if req.CustomerID == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "customer_id, or source_type and source_id, is required"})
+			return
+		}
+
+		sarCase := models.SARCase{
+			CustomerID: req.CustomerID,
+			Narrative:  strings.TrimSpace(req.Narrative),
+			AssignedTo: strings.TrimSpace(req.AssignedTo),
+			OpenedBy:   actor(c),
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return sar.Open(tx, cfg, &sarCase, source, req.TransactionIDs, req.DocumentIDs)
+		}); err != nil {
+			respondSARError(c, err)
+			return
+		}
+		middleware.AuditCustomer(c, sarCase.CustomerID)
+		respondSARCase(c, db, http.StatusCreated, sarCase)
+	}
+}
+
+// GetSARCase returns a case with its linked transactions, documents and notes
+func GetSARCase(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		sarCase, ok := loadSARCase(c, db)
+		if !ok {
+			return
+		}
+		respondSARCase(c, db, http.StatusOK, sarCase)
+	}
+}
+
+// updateSARCaseRequest replaces a case's narrative and investigator; due_at (YYYY-MM-DD) moves the deadline
+type updateSARCaseRequest struct {
+	Narrative  string `json:"narrative"`
+	AssignedTo string `json:"assigned_to"`
+	DueAt      string `json:"due_at"`
+}
+
+// UpdateSARCase replaces an active case's narrative, investigator and deadline
+func UpdateSARCase(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req updateSARCaseRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		var dueAt *time.Time
+		if req.DueAt != "" {
+			day, err := businessdays.ParseDate(req.DueAt)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "due_at must be a date (YYYY-MM-DD)"})
+				return
+			}
+			// Due at the end of the day
+			day = businessdays.DayAfter(day).Add(-time.Second)
+			dueAt = &day
+		}
+		sarCase, ok := loadSARCase(c, db)
+		if !ok {
+			return
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return sar.Update(tx, &sarCase, strings.TrimSpace(req.Narrative), strings.TrimSpace(req.AssignedTo), dueAt)
+		}); err != nil {
+			respondSARError(c, err)
+			return
+		}
+		respondSARCase(c, db, http.StatusOK, sarCase)
+	}
+}
+
+// sarAttachRequest lists transactions or documents to link to a case
+type sarAttachRequest struct {
+	TransactionIDs []uint `json:"transaction_ids"`
+	DocumentIDs    []uint `json:"document_ids"`
+}
+
+// AttachSARCaseTransactions links transactions to an active case; they cannot be reversed or archived until the
+// case is filed or closed
+func AttachSARCaseTransactions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req sarAttachRequest
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.TransactionIDs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "transaction_ids must list at least one transaction"})
+			return
+		}
+		sarCase, ok := loadSARCase(c, db)
+		if !ok {
+			return
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return sar.AttachTransactions(tx, sarCase, req.TransactionIDs, actor(c))
+		}); err != nil {
+			respondSARError(c, err)
+			return
+		}
+		respondSARCase(c, db, http.StatusOK, sarCase)
+	}
+}
+
+// AttachSARCaseDocuments attaches documents uploaded for the case's customer to an active case
+func AttachSARCaseDocuments(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req sarAttachRequest
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.DocumentIDs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "document_ids must list at least one document"})
+			return
+		}
+		sarCase, ok := loadSARCase(c, db)
+		if !ok {
+			return
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return sar.AttachDocuments(tx, sarCase, req.DocumentIDs, actor(c))
+		}); err != nil {
+			respondSARError(c, err)
+			return
+		}
+		respondSARCase(c, db, http.StatusOK, sarCase)
+	}
+}
+
+// sarNoteRequest is an investigation note's text
+type sarNoteRequest struct {
+	Body string `json:"body"`
+}
+
+// CreateSARCaseNote records an investigation note on an active case
+func CreateSARCaseNote(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req sarNoteRequest
+		if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Body) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "body is required"})
+			return
+		}
+		sarCase, ok := loadSARCase(c, db)
+		if !ok {
+			return
+		}
+		note, err := sar.AddNote(db, sarCase, actor(c), strings.TrimSpace(req.Body))
+		if err != nil {
+			respondSARError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"note": note})
+	}
+}
+
+// sarStatusRequest moves a case on; reason is required to close without action, filing_reference records the
+// regulator's acknowledgement of a filed report
+type sarStatusRequest struct {
+	Status          string `json:"status" binding:"required"`
+	Reason          string `json:"reason"`
+	FilingReference string `json:"filing_reference"`
+}
+
+// ChangeSARCaseStatus moves a case from open to investigating, and on to sar_filed or closed_no_action
+// Starting an investigation on an unassigned case assigns it to the caller
+func ChangeSARCaseStatus(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req sarStatusRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status is required"})
+			return
+		}
+		sarCase, ok := loadSARCase(c, db)
+		if !ok {
+			return
+		}
+		role, _ := c.Get("user_role")
+		roleName, _ := role.(string)
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return sar.Transition(tx, &sarCase, req.Status, roleName, actor(c), strings.TrimSpace(req.Reason),
+				strings.TrimSpace(req.FilingReference))
+		}); err != nil {
+			respondSARError(c, err)
+			return
+		}
+		respondSARCase(c, db, http.StatusOK, sarCase)
+	}
+}
+
+// ExportSARCase downloads a case's filing bundle as JSON: the case, its subject and their accounts, the linked
+// transactions and notes, and a manifest of the attached documents with their hashes
+func ExportSARCase(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		sarCase, ok := loadSARCase(c, db)
+		if !ok {
+			return
+		}
+		bundle, err := sar.Export(db, sarCase, actor(c), clock.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export case"})
+			return
+		}
+		body, err := json.MarshalIndent(bundle, "", "  ")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export case"})
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="`+sarCase.CaseNumber+`.json"`)
+		c.Data(http.StatusOK, "application/json", body)
+	}
+}
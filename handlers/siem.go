@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"banking-app/siem"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSIEMStatus reports how far the audit log has been forwarded to the SIEM and whether the sink is reachable
+// forwarder is nil when SIEM_SINK is off
+func GetSIEMStatus(forwarder *siem.Forwarder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if forwarder == nil {
+			c.JSON(http.StatusOK, gin.H{"enabled": false})
+			return
+		}
+		status, err := forwarder.Status()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read forwarding status"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"enabled": true, "status": status})
+	}
+}
@@ -0,0 +1,699 @@
+package handlers
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/display"
+	"banking-app/documents"
+	"banking-app/i18n"
+	"banking-app/ledger"
+	"banking-app/middleware"
+	"banking-app/models"
+	"banking-app/statements"
+	"banking-app/tenancy"
+	"banking-app/uploads"
+	"banking-app/usage"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== STATEMENT HANDLERS ====================
+
+// respondBalanceAsOf answers GET /accounts/:id/balance?as_of=YYYY-MM-DD with the balance at the end of that day
+// It is computed the same way as statement opening balances, by effective date
+func respondBalanceAsOf(c *gin.Context, db *gorm.DB, accountID uint, asOf string) {
+	day, err := businessdays.ParseDate(asOf)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "as_of must be YYYY-MM-DD"})
+		return
+	}
+	if day.After(ledger.StartOfDay(clock.Now())) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "as_of cannot be in the future"})
+		return
+	}
+
+	var account models.Account
+	err = db.Select("id, account_number, currency, created_at").First(&account, accountID).Error
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if day.Before(ledger.StartOfDay(account.CreatedAt)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "The account did not exist on " + asOf})
+		return
+	}
+
+	balance, err := statements.BalanceAt(db, account.ID, day)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute balance"})
+		return
+	}
+	respondDisplay(c, http.StatusOK, gin.H{
+		"account_id":       account.ID,
+		"account_number":   account.AccountNumber,
+		"currency":         account.Currency,
+		"as_of":            asOf,
+		"balance":          balance.Balance,
+		"method":           balance.Method,
+		"last_transaction": balance.LastTransaction,
+	})
+}
+
+// GetCustomerStatement returns a consolidated statement of all a customer's accounts for one month
+// ?format=pdf renders a document; JSON is the default. Both stream postings account by account
+func GetCustomerStatement(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
+			return
+		}
+
+		year, yearErr := strconv.Atoi(c.Param("year"))
+		month, monthErr := strconv.Atoi(c.Param("month"))
+		if yearErr != nil || monthErr != nil || year < 1900 || month < 1 || month > 12 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid statement period, expected /:year/:month"})
+			return
+		}
+		start := businessdays.Month(year, time.Month(month))
+		end := start.AddDate(0, 1, 0)
+
+		format := c.DefaultQuery("format", "json")
+		if format != "json" && format != "pdf" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be json or pdf"})
+			return
+		}
+
+		var customer models.Customer
+		if err := db.First(&customer, uint(id)).Error; err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+			return
+		}
+
+		summary, err := statements.Consolidate(db, customer, tenancy.CurrentSettings(c).DefaultCurrency, start, end)
+		var missing *statements.MissingRatesError
+		if errors.As(err, &missing) {
+			(&apiError{Status: http.StatusUnprocessableEntity, Code: "MISSING_FX_RATES", Message: err.Error(), v1Code: true,
+				Details: gin.H{"rate_date": missing.Date.Format("2006-01-02"), "missing_pairs": missing.Pairs}}).respondV1(c)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build statement"})
+			return
+		}
+
+		opts := displayOptions(c)
+		if opts.Mask {
+			for i := range summary.Accounts {
+				summary.Accounts[i].AccountNumber = display.MaskAccountNumber(summary.Accounts[i].AccountNumber)
+			}
+		}
+
+		// Headers are committed from here on - errors can only end the stream early
+		out := bufio.NewWriter(c.Writer)
+		defer out.Flush()
+		if format == "pdf" {
+			c.Header("Content-Type", "application/pdf")
+			c.Header("Content-Disposition", fmt.Sprintf("inline; filename=\"statement-%d-%s.pdf\"", customer.ID, start.Format("2006-01")))
+			c.Status(http.StatusOK)
+			writeStatementPDF(out, db, summary, middleware.Locale(c))
+			return
+		}
+		c.Header("Content-Type", "application/json; charset=utf-8")
+		c.Status(http.StatusOK)
+		writeStatementJSON(out, db, summary, opts)
+	}
+}
+
+// writeStatementJSON writes the summary followed by each account section with its postings
+// The document is assembled by hand so only one posting is held in memory at a time;
+// each piece is passed through the caller's display options on its way out
+func writeStatementJSON(w io.Writer, db *gorm.DB, summary statements.Consolidated, opts display.Options) error {
+	marshal := func(v interface{}, currency string) ([]byte, error) {
+		section := opts
+		if currency != "" {
+			section.Currency = currency
+		}
+		tree, err := display.Apply(v, section)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(tree)
+	}
+
+	fields := gin.H{
+		"customer_id":       summary.CustomerID,
+		"customer_name":     summary.CustomerName,
+		"period_start":      summary.PeriodStart,
+		"period_end":        summary.PeriodEnd,
+		"base_currency":     summary.BaseCurrency,
+		"rates_as_of":       summary.RatesAsOf,
+		"total_assets":      summary.TotalAssets,
+		"total_liabilities": summary.TotalLiabilities,
+		"net_movement":      summary.NetMovement,
+		"loans":             summary.Loans,
+	}
+	// Archived postings are slower to read, so a statement reaching back to them says so
+	for _, section := range summary.Accounts {
+		if section.Summary.IncludesArchive {
+			fields["includes_archive"] = true
+		}
+	}
+	head, err := marshal(fields, "")
+	if err != nil {
+		return err
+	}
+	// Reopen the object to append the accounts array
+	if _, err := fmt.Fprintf(w, "%s,\"accounts\":[", head[:len(head)-1]); err != nil {
+		return err
+	}
+
+	for i, section := range summary.Accounts {
+		if i > 0 {
+			io.WriteString(w, ",")
+		}
+		head, err := marshal(section, section.Currency)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s,\"lines\":[", head[:len(head)-1]); err != nil {
+			return err
+		}
+
+		first := true
+		err = statements.Each(db, section.AccountID, summary.PeriodStart, summary.PeriodEnd, section.Summary.OpeningBalance, func(line statements.Line) error {
+			raw, err := marshal(line, section.Currency)
+			if err != nil {
+				return err
+			}
+			if !first {
+				io.WriteString(w, ",")
+			}
+			first = false
+			_, err = w.Write(raw)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		io.WriteString(w, "]}")
+	}
+
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+// writeStatementPDF renders the summary page followed by one section per account, labelled in the given locale
+func writeStatementPDF(w io.Writer, db *gorm.DB, summary statements.Consolidated, locale *i18n.Locale) error {
+	money, date, t := locale.Number, locale.Date, locale.T
+	last := summary.PeriodEnd.AddDate(0, 0, -1)
+
+	pdf := documents.NewPDF(w)
+	pdf.Heading(t("statement.consolidated_title"))
+	pdf.Text(summary.CustomerName)
+	pdf.Text(t("statement.period", date(summary.PeriodStart), date(last)))
+	pdf.Heading(t("statement.summary"))
+	pdf.Text(t("statement.base_currency", summary.BaseCurrency))
+	pdf.Mono(fmt.Sprintf("%-24s %16s", t("statement.total_assets"), money(summary.TotalAssets)))
+	pdf.Mono(fmt.Sprintf("%-24s %16s", t("statement.total_liabilities"), money(summary.TotalLiabilities)))
+	pdf.Mono(fmt.Sprintf("%-24s %16s", t("statement.net_movement"), money(summary.NetMovement)))
+
+	pdf.Heading(t("statement.accounts"))
+	pdf.Mono(fmt.Sprintf("%-20s %-10s %-4s %14s %14s", t("statement.column_account"), t("statement.column_type"),
+		t("statement.column_currency"), t("statement.column_opening"), t("statement.column_closing")))
+	for _, section := range summary.Accounts {
+		pdf.Mono(fmt.Sprintf("%-20s %-10s %-4s %14s %14s", section.AccountNumber, section.AccountType,
+			section.Currency, money(section.Summary.OpeningBalance), money(section.Summary.ClosingBalance)))
+		if conv := section.Converted; conv != nil {
+			rate := strconv.FormatFloat(conv.Rate, 'f', -1, 64)
+			pdf.Mono(fmt.Sprintf("%-20s %-10s %-4s %14s %14s", "", "", conv.Currency, "", money(conv.ClosingBalance)))
+			pdf.Mono(fmt.Sprintf("%-20s %s", "", t("statement.rate", rate, conv.Currency, section.Currency, date(conv.RateDate))))
+		}
+	}
+	if len(summary.Loans) > 0 {
+		pdf.Heading(t("statement.loans"))
+		for _, loan := range summary.Loans {
+			pdf.Mono(fmt.Sprintf("%-20s %-10s %29s", loan.LoanNumber, loan.Status, money(loan.RemainingBalance)))
+		}
+	}
+
+	for _, section := range summary.Accounts {
+		pdf.NewPage()
+		pdf.Heading(t("statement.account", section.AccountNumber, section.AccountType, section.Currency))
+		pdf.Mono(fmt.Sprintf("%-10s %-10s %-20s %-20s %12s %12s", t("statement.column_date"), t("statement.column_value_date"),
+			t("statement.column_reference"), t("statement.column_description"), t("statement.column_amount"), t("statement.column_balance")))
+		pdf.Mono(fmt.Sprintf("%-10s %-10s %-20s %-20s %12s %12s", date(summary.PeriodStart), "", "", t("statement.opening_balance"), "", money(section.Summary.OpeningBalance)))
+		err := statements.Each(db, section.AccountID, summary.PeriodStart, summary.PeriodEnd, section.Summary.OpeningBalance, func(line statements.Line) error {
+			pdf.Mono(fmt.Sprintf("%-10s %-10s %-20s %-20s %12s %12s", date(line.Date), date(line.ValueDate), truncate(line.TransactionID, 20),
+				truncate(line.Description, 20), money(line.Amount), money(line.Balance)))
+			if line.Memo != "" && line.Memo != line.Description {
+				pdf.Mono(fmt.Sprintf("%-10s %-10s %-20s %-20s", "", "", "", truncate(t("statement.memo", line.Memo), 20)))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		pdf.Mono(fmt.Sprintf("%-10s %-10s %-20s %-20s %12s %12s", date(last), "", "", t("statement.closing_balance"), "", money(section.Summary.ClosingBalance)))
+		pdf.Text(t("statement.totals", money(section.Summary.TotalCredits), money(section.Summary.TotalDebits), section.Summary.Transactions))
+	}
+
+	return pdf.Close()
+}
+
+// truncate shortens s to at most n characters for fixed-width columns
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "~"
+}
+
+// statementPreferenceRequest changes how an account's monthly statement is delivered; omitted fields are kept
+type statementPreferenceRequest struct {
+	Channel *string `json:"channel"` // email, none
+	Day     *int    `json:"day"`     // 1-28
+}
+
+// UpdateStatementPreference sets an account's statement channel and day of the month
+func UpdateStatementPreference(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var account models.Account
+		if err := db.First(&account, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		if account.AccountType == "internal" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Internal accounts have no statements"})
+			return
+		}
+
+		var req statementPreferenceRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		pref := account.StatementPreference
+		if req.Channel != nil {
+			pref.Channel = *req.Channel
+		}
+		if req.Day != nil {
+			pref.Day = *req.Day
+		}
+		if err := statements.ValidatePreference(pref); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Channel != nil && pref.Channel == statements.ChannelEmail && !requireVerifiedEmail(c, db, account.CustomerID) {
+			return
+		}
+
+		err := db.Model(&account).Updates(map[string]interface{}{
+			"statement_channel": pref.Channel,
+			"statement_day":     pref.Day,
+		}).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update statement preference"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"account_id": account.ID, "statement_preference": pref})
+	}
+}
+
+// GetAccountStatements lists an account's archived statements, newest period first; ?ad_hoc=true|false keeps
+// only custom-range or monthly ones. gaps lists every month missing from the archive's sequence, whichever page is shown
+func GetAccountStatements(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var filter listFilter
+		filter.where("account_id = ?", c.Param("id"))
+		if adHoc, err := strconv.ParseBool(c.Query("ad_hoc")); err == nil {
+			filter.where("ad_hoc = ?", adHoc)
+		}
+
+		page, limit, offset := parsePagination(c, 12)
+		var archived []models.Statement
+		total, err := filter.count(db, &models.Statement{})
+		if err == nil {
+			err = filter.apply(db).Order("period_start DESC").Offset(offset).Limit(limit).Find(&archived).Error
+		}
+		var gaps []statements.Gap
+		if err == nil {
+			accountID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+			gaps, err = statements.Gaps(db, uint(accountID))
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve statements"})
+			return
+		}
+		usage.Rows(c, len(archived))
+		c.JSON(http.StatusOK, gin.H{
+			"statements": archived,
+			"gaps":       gaps,
+			"complete":   len(gaps) == 0,
+			"total":      total,
+			"page":       page,
+			"limit":      limit,
+		})
+	}
+}
+
+// RepairAccountStatements generates the months missing from an account's statement archive, each under its own
+// sequence number, and archives them without sending
+func RepairAccountStatements(db *gorm.DB, storage uploads.Storage, cfg statements.DeliveryConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var account models.Account
+		if err := db.Preload("Customer").First(&account, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		repaired, err := statements.Repair(db, storage, cfg, account, clock.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to repair statements", "repaired": repaired})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"repaired": repaired, "count": len(repaired)})
+	}
+}
+
+// GetAccountStatementDocument returns the stored PDF of one archived statement
+func GetAccountStatementDocument(db *gorm.DB, storage uploads.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var st models.Statement
+		if err := db.Where("account_id = ?", c.Param("id")).First(&st, c.Param("statementId")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Statement not found"})
+			return
+		}
+		serveStatement(c, storage, st)
+	}
+}
+
+// GenerateAccountStatement renders and archives an account's statement for /:year/:month now
+// Regenerating an archived period replaces its document in place and does not send it again
+func GenerateAccountStatement(db *gorm.DB, storage uploads.Storage, cfg statements.DeliveryConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		year, yearErr := strconv.Atoi(c.Param("year"))
+		month, monthErr := strconv.Atoi(c.Param("month"))
+		if yearErr != nil || monthErr != nil || year < 1900 || month < 1 || month > 12 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid statement period, expected /:year/:month"})
+			return
+		}
+		start := businessdays.Month(year, time.Month(month))
+		now := clock.Now()
+		if !start.AddDate(0, 1, 0).Before(now) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Statements can only be generated for completed months"})
+			return
+		}
+
+		var account models.Account
+		if err := db.Preload("Customer").First(&account, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		if account.AccountType == "internal" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Internal accounts have no statements"})
+			return
+		}
+
+		st, created, err := statements.Generate(db, storage, cfg, account, start, now)
+		if errors.Is(err, statements.ErrBeforeSequence) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Statements start at the account's first archived month; earlier months cannot be added", "code": "BEFORE_FIRST_STATEMENT"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate statement"})
+			return
+		}
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		c.JSON(status, gin.H{"statement": st, "created": created})
+	}
+}
+
+// GetStatementFollowUps lists archived statements that could not be emailed, for ops to contact the customer
+func GetStatementFollowUps(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var filter listFilter
+		filter.where("needs_follow_up = ?", true)
+
+		page, limit, offset := parsePagination(c, 50)
+		var archived []models.Statement
+		total, err := filter.count(db, &models.Statement{})
+		if err == nil {
+			err = filter.apply(db).Order("id").Offset(offset).Limit(limit).Find(&archived).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve statements"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"statements": archived,
+			"total":      total,
+			"page":       page,
+			"limit":      limit,
+		})
+	}
+}
+
+// RunStatementDispatch generates and sends every due statement of the admin's tenant now
+func RunStatementDispatch(db *gorm.DB, storage uploads.Storage, cfg statements.DeliveryConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := statements.Dispatch(tenancy.DB(c, db), storage, cfg, clock.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Statement dispatch failed"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// DownloadStatement serves a statement through the expiring link emailed to the customer
+// The token is the credential, so this is the one statement endpoint reachable without signing in
+// Storage that pre-signs URLs, such as S3, is downloaded from directly through a short-lived redirect
+func DownloadStatement(db *gorm.DB, storage uploads.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		st, err := statements.FindByToken(db, c.Param("token"), clock.Now())
+		if err == statements.ErrLinkExpired {
+			c.JSON(http.StatusGone, gin.H{"error": "This statement link has expired; sign in to download the statement"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Statement not found"})
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		if presigner, ok := storage.(uploads.Presigner); ok {
+			url, err := presigner.PresignGet(st.StorageKey, statementFilename(st), statements.ContentType(st.Format))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Statement document is unavailable"})
+				return
+			}
+			c.Redirect(http.StatusFound, url)
+			return
+		}
+		serveStatement(c, storage, st)
+	}
+}
+
+// serveStatement streams an archived statement's document from storage
+func serveStatement(c *gin.Context, storage uploads.Storage, st models.Statement) {
+	file, err := storage.Get(st.StorageKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Statement document is unavailable"})
+		return
+	}
+	defer file.Close()
+	c.Header("Content-Type", statements.ContentType(st.Format))
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", statementFilename(st)))
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, file); err != nil {
+		// Headers are already out, so a failed integrity check can only be logged
+		log.Printf("statements: serving statement %d: %v", st.ID, err)
+	}
+}
+
+// statementFilename names an archived statement's document for download; ad hoc statements are named by their range
+func statementFilename(st models.Statement) string {
+	if st.AdHoc {
+		last := st.PeriodEnd.AddDate(0, 0, -1)
+		return fmt.Sprintf("statement-%d-%s-%s.%s", st.AccountID, businessdays.Format(st.PeriodStart), businessdays.Format(last), st.Format)
+	}
+	return fmt.Sprintf("statement-%d-%s.pdf", st.AccountID, businessdays.In(st.PeriodStart).Format("2006-01"))
+}
+
+// customStatementRequest asks for an account's statement over an inclusive range of bank days
+type customStatementRequest struct {
+	From   string `json:"from" binding:"required"` // YYYY-MM-DD
+	To     string `json:"to" binding:"required"`   // YYYY-MM-DD, inclusive
+	Format string `json:"format"`                  // pdf (default), json or csv
+}
+
+// loadStatementAccount finds the :id account with its customer; customers only find their own accounts
+func loadStatementAccount(c *gin.Context, db *gorm.DB) (models.Account, bool) {
+	var account models.Account
+	err := db.Preload("Customer").First(&account, c.Param("id")).Error
+	if customerID, isCustomer := applicant(c, db); err == nil && isCustomer && account.CustomerID != customerID {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return account, false
+	}
+	middleware.AuditCustomer(c, account.CustomerID)
+	return account, true
+}
+
+// customStatementURLs are where a request's status and document are read from
+func customStatementURLs(req models.StatementRequest) (string, string) {
+	status := fmt.Sprintf("/api/v1/accounts/%d/statements/custom/%d", req.AccountID, req.ID)
+	return status, status + "/download"
+}
+
+// RequestCustomStatement generates an account's statement for a custom date range, archived as ad hoc
+// Ranges with few postings are generated while the caller waits (201); larger ones are queued (202) and
+// polled through status_url. Customers may only request statements of their own accounts
+func RequestCustomStatement(db *gorm.DB, storage uploads.Storage, cfg statements.CustomConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var body customStatementRequest
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if body.Format == "" {
+			body.Format = statements.FormatPDF
+		}
+		if !statements.ValidFormat(body.Format) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": statements.ErrInvalidFormat.Error()})
+			return
+		}
+
+		account, ok := loadStatementAccount(c, db)
+		if !ok {
+			return
+		}
+		if account.AccountType == "internal" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Internal accounts have no statements"})
+			return
+		}
+		now := clock.Now()
+		start, end, err := statements.ParseRange(cfg, account, body.From, body.To, now)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		summary, err := statements.Summarize(db, account.ID, start, end)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to size statement"})
+			return
+		}
+		async := summary.Transactions > cfg.SyncLimit
+		req := models.StatementRequest{
+			TenantID:    account.TenantID,
+			AccountID:   account.ID,
+			CustomerID:  account.CustomerID,
+			PeriodStart: start,
+			PeriodEnd:   end,
+			Format:      body.Format,
+			RequestedBy: actor(c),
+			Async:       async,
+			Status:      statements.RequestRunning,
+		}
+		if async {
+			req.Status = statements.RequestQueued
+		}
+		if err := db.Create(&req).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request statement"})
+			return
+		}
+		statusURL, downloadURL := customStatementURLs(req)
+		if async {
+			c.JSON(http.StatusAccepted, gin.H{"request": req, "status_url": statusURL})
+			return
+		}
+
+		st, err := statements.GenerateCustom(db, storage, account, &req, now)
+		if err != nil {
+			log.Printf("statements: custom statement %d failed: %v", req.ID, err)
+			statements.FailRequest(db, &req, err, clock.Now())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate statement", "request": req})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"request": req, "statement": st, "status_url": statusURL, "download_url": downloadURL})
+	}
+}
+
+// loadStatementRequest finds the :requestId custom statement request of the :id account
+func loadStatementRequest(c *gin.Context, db *gorm.DB) (models.StatementRequest, bool) {
+	var req models.StatementRequest
+	if _, ok := loadStatementAccount(c, db); !ok {
+		return req, false
+	}
+	if err := db.Where("account_id = ?", c.Param("id")).First(&req, c.Param("requestId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Statement request not found"})
+		return req, false
+	}
+	return req, true
+}
+
+// GetCustomStatementRequest returns the status of a custom statement request, with its download URL once ready
+func GetCustomStatementRequest(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		req, ok := loadStatementRequest(c, db)
+		if !ok {
+			return
+		}
+		statusURL, downloadURL := customStatementURLs(req)
+		response := gin.H{"request": req, "status_url": statusURL}
+		if req.Status == statements.RequestReady {
+			response["download_url"] = downloadURL
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// DownloadCustomStatement serves the document of a ready custom statement request
+func DownloadCustomStatement(db *gorm.DB, storage uploads.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		req, ok := loadStatementRequest(c, db)
+		if !ok {
+			return
+		}
+		if req.Status != statements.RequestReady || req.StatementID == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "The statement is not ready", "status": req.Status})
+			return
+		}
+		var st models.Statement
+		if err := db.First(&st, *req.StatementID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Statement not found"})
+			return
+		}
+		serveStatement(c, storage, st)
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"banking-app/businessdays"
+	"banking-app/models"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// statementDB opens a test database holding one customer with accounts opened before March 2026, each with
+// deposits effective during March, and a loan
+func statementDB(t *testing.T, accounts, transactionsPerAccount int) (*gorm.DB, models.Customer) {
+	t.Helper()
+	db := testDB(t)
+	start := businessdays.Month(2026, time.March)
+
+	customer := models.Customer{FirstName: "Statement", LastName: "Holder", Email: "statement@example.test", DateOfBirth: "1980-01-01", Status: "active"}
+	if err := db.Create(&customer).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < accounts; i++ {
+		account := models.Account{CustomerID: customer.ID, AccountNumber: fmt.Sprintf("STMT-%d", i), AccountType: "checking",
+			Currency: "USD", Status: "active", CreatedAt: start.AddDate(0, -1, 0)}
+		if err := db.Create(&account).Error; err != nil {
+			t.Fatal(err)
+		}
+		transactions := make([]models.Transaction, transactionsPerAccount)
+		for j := range transactions {
+			effective := start.Add(time.Duration(j) * time.Minute)
+			transactions[j] = models.Transaction{TransactionID: fmt.Sprintf("STMT-%d-%d", i, j), AccountID: account.ID,
+				TransactionType: "deposit", Amount: 1, Description: "Statement test deposit", EffectiveDate: effective, CreatedAt: effective}
+		}
+		if err := db.CreateInBatches(transactions, 500).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	loan := models.Loan{CustomerID: customer.ID, LoanNumber: "STMT-LOAN", PrincipalAmount: 5000, InterestRate: 0.05, LoanTerm: 12,
+		RemainingBalance: 4000, Status: "active", CreatedAt: start.AddDate(0, -1, 0)}
+	if err := db.Create(&loan).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db, customer
+}
+
+// statement serves GET /customers/:id/statements/2026/3 with the given query to w
+func statement(db *gorm.DB, customer models.Customer, query string, w http.ResponseWriter) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/customers/:id/statements/:year/:month", GetCustomerStatement(db))
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/customers/%d/statements/2026/3%s", customer.ID, query), nil))
+}
+
+func TestCustomerStatement(t *testing.T) {
+	db, customer := statementDB(t, 3, 4)
+	recorder := httptest.NewRecorder()
+	statement(db, customer, "", recorder)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var body struct {
+		TotalAssets      float64 `json:"total_assets"`
+		TotalLiabilities float64 `json:"total_liabilities"`
+		NetMovement      float64 `json:"net_movement"`
+		Accounts         []struct {
+			Summary struct {
+				OpeningBalance float64 `json:"opening_balance"`
+				ClosingBalance float64 `json:"closing_balance"`
+			} `json:"summary"`
+			Lines []struct {
+				Balance float64 `json:"balance"`
+			} `json:"lines"`
+		} `json:"accounts"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("statement is not valid JSON: %v", err)
+	}
+	if body.TotalAssets != 12 || body.TotalLiabilities != 4000 || body.NetMovement != 12 {
+		t.Errorf("summary %v assets, %v liabilities, %v movement, want 12, 4000 and 12", body.TotalAssets, body.TotalLiabilities, body.NetMovement)
+	}
+	if len(body.Accounts) != 3 {
+		t.Fatalf("%d account sections, want 3", len(body.Accounts))
+	}
+	for i, section := range body.Accounts {
+		if len(section.Lines) != 4 || section.Summary.OpeningBalance != 0 || section.Summary.ClosingBalance != 4 {
+			t.Errorf("section %d: %d lines, opening %v, closing %v, want 4, 0 and 4", i, len(section.Lines), section.Summary.OpeningBalance, section.Summary.ClosingBalance)
+		}
+		if len(section.Lines) > 0 && section.Lines[len(section.Lines)-1].Balance != section.Summary.ClosingBalance {
+			t.Errorf("section %d: running balance ends at %v, closing balance %v", i, section.Lines[len(section.Lines)-1].Balance, section.Summary.ClosingBalance)
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	statement(db, customer, "?format=pdf", recorder)
+	if recorder.Code != http.StatusOK || !bytes.HasPrefix(recorder.Body.Bytes(), []byte("%PDF-")) {
+		t.Errorf("?format=pdf: status %d, not a PDF", recorder.Code)
+	}
+}
+
+// heapWatcher discards a response while recording the largest live heap seen as it was written
+// Each sample is taken after a collection, so garbage already written out does not count
+type heapWatcher struct {
+	header  http.Header
+	written int
+	next    int
+	peak    uint64
+}
+
+func (w *heapWatcher) Header() http.Header { return w.header }
+func (w *heapWatcher) WriteHeader(int)     {}
+
+func (w *heapWatcher) Write(p []byte) (int, error) {
+	w.written += len(p)
+	if w.written >= w.next {
+		w.next = w.written + 64<<10
+		w.sample()
+	}
+	return len(p), nil
+}
+
+func (w *heapWatcher) sample() {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	if stats.HeapAlloc > w.peak {
+		w.peak = stats.HeapAlloc
+	}
+}
+
+func TestCustomerStatementStreams(t *testing.T) {
+	if testing.Short() {
+		t.Skip("seeds 10,000 postings")
+	}
+	db, customer := statementDB(t, 20, 500)
+
+	// The budget is well under the size of either document, so holding the postings or the output fails it
+	const budget = 512 << 10
+	for _, query := range []string{"", "?format=pdf"} {
+		w := &heapWatcher{header: http.Header{}}
+		w.sample()
+		baseline := w.peak
+		statement(db, customer, query, w)
+		w.sample()
+
+		t.Logf("statement%s: %d bytes, live heap grew by %d bytes", query, w.written, w.peak-baseline)
+		if w.written < 2*budget {
+			t.Fatalf("statement%s is %d bytes, too small to tell streaming from buffering", query, w.written)
+		}
+		if grown := w.peak - baseline; grown > budget {
+			t.Errorf("statement%s of %d bytes grew the live heap by %d bytes, budget %d", query, w.written, grown, budget)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"banking-app/statushistory"
+	"banking-app/tenancy"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== STATUS HISTORY HANDLERS ====================
+
+// GetStatusHistory lists every status a customer, account or loan has had, oldest first
+// The first entry is the status the record was created with, so the list is never empty
+func GetStatusHistory(db *gorm.DB, subjectType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, ok := noteSubjectID(c, db, subjectType)
+		if !ok {
+			return
+		}
+		history, err := statushistory.For(db, subjectType, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve status history"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"subject_type": subjectType, "subject_id": id, "status_history": history, "total": len(history)})
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"banking-app/models"
+	"banking-app/tags"
+	"banking-app/tenancy"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== TAG HANDLERS ====================
+
+// tagError responds with the client error for a tagging failure
+func tagError(c *gin.Context, err error, cfg tags.Config, slug string) {
+	e := &apiError{Status: http.StatusInternalServerError, Code: "INTERNAL_ERROR", Message: "Failed to update tags"}
+	switch {
+	case errors.Is(err, tags.ErrInvalidSlug):
+		e = &apiError{Status: http.StatusBadRequest, Code: "INVALID_TAG", Message: err.Error(), v1Code: true, Details: gin.H{"tag": slug}}
+	case errors.Is(err, tags.ErrUnknownTag):
+		e = &apiError{Status: http.StatusUnprocessableEntity, Code: "UNKNOWN_TAG", Message: err.Error(), v1Code: true, Details: gin.H{"tag": slug}}
+	case errors.Is(err, tags.ErrTooMany):
+		e = &apiError{Status: http.StatusConflict, Code: "TOO_MANY_TAGS", Message: err.Error(), v1Code: true, Details: gin.H{"max": cfg.MaxPerEntity}}
+	case errors.Is(err, tags.ErrNotTagged):
+		e = &apiError{Status: http.StatusNotFound, Code: "TAG_NOT_APPLIED", Message: err.Error(), v1Code: true, Details: gin.H{"tag": slug}}
+	case errors.Is(err, tags.ErrTagInUse):
+		e = &apiError{Status: http.StatusConflict, Code: "TAG_IN_USE", Message: err.Error(), v1Code: true, Details: gin.H{"tag": slug}}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		e = &apiError{Status: http.StatusNotFound, Code: "NOT_FOUND", Message: "Tag not found"}
+	}
+	e.respondV1(c)
+}
+
+// tagQuery reads an optional tag query parameter, responding 400 when it is not a well-formed tag
+func tagQuery(c *gin.Context, name string) (string, bool) {
+	slug := c.Query(name)
+	if slug != "" && !tags.ValidSlug(slug) {
+		tagError(c, tags.ErrInvalidSlug, tags.Config{}, slug)
+		return "", false
+	}
+	return slug, true
+}
+
+// respondTags writes the record's current tags
+func respondTags(c *gin.Context, db *gorm.DB, status int, subjectType string, id uint) {
+	byID, err := tags.For(db, subjectType, []uint{id})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tags"})
+		return
+	}
+	c.JSON(status, gin.H{"subject_type": subjectType, "subject_id": id, "tags": tagList(byID[id])})
+}
+
+// GetTags lists the tags on a customer or account
+func GetTags(db *gorm.DB, subjectType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, ok := noteSubjectID(c, db, subjectType)
+		if !ok {
+			return
+		}
+		respondTags(c, db, http.StatusOK, subjectType, id)
+	}
+}
+
+// AddTag applies the :tag in the path to a customer or account, so the audit log records which tag
+// Responds 201 when the tag was applied and 200 when the record already had it
+func AddTag(db *gorm.DB, cfg tags.Config, subjectType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, ok := noteSubjectID(c, db, subjectType)
+		if !ok {
+			return
+		}
+		slug := c.Param("tag")
+		added, err := tags.Add(db, cfg, subjectType, id, slug, actor(c))
+		if err != nil {
+			tagError(c, err, cfg, slug)
+			return
+		}
+		status := http.StatusOK
+		if added {
+			status = http.StatusCreated
+		}
+		respondTags(c, db, status, subjectType, id)
+	}
+}
+
+// RemoveTag takes the :tag in the path off a customer or account
+func RemoveTag(db *gorm.DB, cfg tags.Config, subjectType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, ok := noteSubjectID(c, db, subjectType)
+		if !ok {
+			return
+		}
+		slug := c.Param("tag")
+		if err := tags.Remove(db, subjectType, id, slug); err != nil {
+			tagError(c, err, cfg, slug)
+			return
+		}
+		respondTags(c, db, http.StatusOK, subjectType, id)
+	}
+}
+
+// GetTagVocabulary lists the tenant's tags with how many customers and accounts carry each
+func GetTagVocabulary(db *gorm.DB, cfg tags.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		usage, err := tags.Vocabulary(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tags"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"tags": usage, "free_form": cfg.FreeForm, "max_per_entity": cfg.MaxPerEntity})
+	}
+}
+
+// CreateTag adds a tag to the tenant's vocabulary
+// Body: {"slug": "vip", "description": "High-value relationship customers"}
+func CreateTag(db *gorm.DB, cfg tags.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req struct {
+			Slug        string `json:"slug" binding:"required"`
+			Description string `json:"description"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if !tags.ValidSlug(req.Slug) {
+			tagError(c, tags.ErrInvalidSlug, cfg, req.Slug)
+			return
+		}
+
+		var existing int64
+		if err := db.Model(&models.Tag{}).Where("slug = ?", req.Slug).Count(&existing).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tag"})
+			return
+		}
+		if existing > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Tag already exists", "code": "TAG_EXISTS", "tag": req.Slug})
+			return
+		}
+		tag := models.Tag{Slug: req.Slug, Description: strings.TrimSpace(req.Description), CreatedBy: actor(c)}
+		if err := db.Create(&tag).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tag"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Tag created", "tag": tag})
+	}
+}
+
+// DeleteTag removes an unused tag from the tenant's vocabulary
+func DeleteTag(db *gorm.DB, cfg tags.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		slug := c.Param("tag")
+		if err := tags.Delete(db, slug); err != nil {
+			tagError(c, err, cfg, slug)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Tag deleted", "tag": slug})
+	}
+}
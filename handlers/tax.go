@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"banking-app/clock"
+	"banking-app/models"
+	"banking-app/tax"
+	"banking-app/tenancy"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== TAX SUMMARY HANDLERS ====================
+
+// parseTaxYear reads the :year route parameter; only completed or current years are accepted
+func parseTaxYear(c *gin.Context) (int, bool) {
+	year, err := strconv.Atoi(c.Param("year"))
+	if err != nil || year < 1900 || year > clock.Now().Year() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tax year"})
+		return 0, false
+	}
+	return year, true
+}
+
+// GetTaxSummary returns a customer's interest and fee totals for a year as JSON or CSV (?format=csv)
+// A pre-generated summary is served when present; ?refresh=true recomputes from the postings
+func GetTaxSummary(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
+			return
+		}
+		year, ok := parseTaxYear(c)
+		if !ok {
+			return
+		}
+		format := c.DefaultQuery("format", "json")
+		if format != "json" && format != "csv" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be json or csv"})
+			return
+		}
+
+		var customer models.Customer
+		if err := db.Select("id").First(&customer, uint(id)).Error; err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+			return
+		}
+
+		var summary tax.Summary
+		found := false
+		if c.Query("refresh") != "true" {
+			summary, found, err = tax.Load(db, customer.ID, year)
+		}
+		if err == nil && !found {
+			summary, err = tax.Build(db, customer.ID, year)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build tax summary"})
+			return
+		}
+
+		if format == "csv" {
+			c.Header("Content-Type", "text/csv")
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"tax-summary-%d-%d.csv\"", customer.ID, year))
+			c.Status(http.StatusOK)
+			tax.WriteCSV(c.Writer, summary)
+			return
+		}
+		c.JSON(http.StatusOK, summary)
+	}
+}
+
+// GenerateTaxSummaries pre-generates and stores the year's summary for every customer
+// Safe to rerun - existing summaries are replaced with fresh figures
+func GenerateTaxSummaries(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		year, ok := parseTaxYear(c)
+		if !ok {
+			return
+		}
+
+		generated, err := tax.GenerateAll(db, year)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tax summaries"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "Tax summaries generated",
+			"year":      year,
+			"generated": generated,
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"banking-app/auth"
+	"banking-app/businessdays"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== TENANT HANDLERS ====================
+
+// tenantRequest carries a tenant definition and, on creation, its first admin user
+type tenantRequest struct {
+	Code     string            `json:"code"`
+	Name     string            `json:"name"`
+	Status   string            `json:"status"`
+	Settings *tenancy.Settings `json:"settings"`
+	Admin    *struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"admin"`
+}
+
+// cutoffTypes are the transaction types clients submit, which a daily cutoff can apply to
+var cutoffTypes = []string{"deposit", "withdrawal", "transfer", "payment"}
+
+// applyTenantRequest copies supplied fields onto a tenant and validates the result
+func applyTenantRequest(tenant *models.Tenant, req tenantRequest) string {
+	if req.Name != "" {
+		tenant.Name = req.Name
+	}
+	if req.Status != "" {
+		tenant.Status = req.Status
+	}
+	if req.Settings != nil {
+		if req.Settings.TransactionLimit < 0 {
+			return "transaction_limit must not be negative"
+		}
+		if req.Settings.DefaultCurrency != "" && len(req.Settings.DefaultCurrency) != 3 {
+			return "default_currency must be a 3-letter ISO code"
+		}
+		for transactionType := range req.Settings.Cutoffs {
+			if !contains(cutoffTypes, transactionType) {
+				return "cutoffs are set for deposit, withdrawal, transfer or payment"
+			}
+		}
+		if err := businessdays.NormalizeCutoffs(req.Settings.Cutoffs); err != nil {
+			return err.Error()
+		}
+		settings, _ := json.Marshal(req.Settings)
+		tenant.Settings = string(settings)
+	}
+	if tenant.Code == "" || tenant.Name == "" {
+		return "Code and name are required"
+	}
+	if tenant.Status != "active" && tenant.Status != "suspended" {
+		return "Status must be active or suspended"
+	}
+	return ""
+}
+
+// GetTenants lists every tenant with its effective settings
+func GetTenants(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var tenants []models.Tenant
+		if err := db.Order("id").Find(&tenants).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tenants"})
+			return
+		}
+
+		result := make([]gin.H, 0, len(tenants))
+		for _, tenant := range tenants {
+			result = append(result, gin.H{
+				"tenant":             tenant,
+				"effective_settings": tenancy.SettingsFor(tenant),
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{"tenants": result})
+	}
+}
+
+// CreateTenant adds a bank brand and bootstraps its first admin user in one transaction
+func CreateTenant(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req tenantRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if req.Admin == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "An initial admin user is required"})
+			return
+		}
+
+		tenant := models.Tenant{Code: req.Code, Status: "active"}
+		if msg := applyTenantRequest(&tenant, req); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		var existing int64
+		db.Model(&models.Tenant{}).Where("code = ?", tenant.Code).Count(&existing)
+		if existing > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Tenant code already exists"})
+			return
+		}
+
+		var admin models.User
+		var adminErr error
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&tenant).Error; err != nil {
+				return err
+			}
+			// The new admin is created inside the new tenant's scope
+			scoped := tx.WithContext(tenancy.NewContext(c.Request.Context(), tenant.ID))
+			admin, adminErr = auth.CreateUser(scoped, req.Admin.Username, req.Admin.Password, "admin")
+			return adminErr
+		})
+		if adminErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": adminErr.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tenant"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Tenant created successfully",
+			"tenant":  tenant,
+			"admin":   admin,
+		})
+	}
+}
+
+// UpdateTenant changes a tenant's name, status or settings; the code is immutable
+func UpdateTenant(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+			return
+		}
+
+		var tenant models.Tenant
+		if err := db.First(&tenant, uint(id)).Error; err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+			return
+		}
+
+		var req tenantRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if req.Status != "" && !authorizeTransition(c, lifecycle.Tenant, tenant.Status, req.Status, "") {
+			return
+		}
+		if msg := applyTenantRequest(&tenant, req); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		if tenant.ID == tenancy.DefaultTenantID && tenant.Status != "active" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The default tenant cannot be suspended"})
+			return
+		}
+
+		if err := db.Save(&tenant).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tenant"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Tenant updated successfully",
+			"tenant":  tenant,
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"banking-app/cache"
+	"banking-app/duplicates"
+	"banking-app/flags"
+	"banking-app/middleware"
+	"banking-app/models"
+	"banking-app/reviews"
+	"banking-app/tracing"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// attr returns a recorded span's attribute by key
+func attr(span sdktrace.ReadOnlySpan, key string) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestCreateTransactionSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	db := testDB(t)
+	if err := tracing.RegisterCallbacks(db); err != nil {
+		t.Fatal(err)
+	}
+	customer := models.Customer{FirstName: "Traced", LastName: "Holder", Email: "traced@example.test", DateOfBirth: "1980-01-01", Status: "active"}
+	if err := db.Create(&customer).Error; err != nil {
+		t.Fatal(err)
+	}
+	account := models.Account{CustomerID: customer.ID, AccountNumber: "TRACE-1", AccountType: "checking", Currency: "USD", Status: "active"}
+	if err := db.Create(&account).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.Ended()) != 0 {
+		t.Fatalf("%d spans recorded outside a request, want none", len(recorder.Ended()))
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.RequestID(), tracing.Middleware(), func(c *gin.Context) {
+		c.Set("user_role", "admin")
+		c.Set("username", "tester")
+	})
+	router.POST("/api/v1/transactions", CreateTransaction(db, cache.NewBalances(cache.BalanceConfig{Size: 10, TTL: time.Minute}),
+		flags.NewStore(db), reviews.Config{}, duplicates.Config{}))
+
+	// The caller's trace, which the request must continue
+	const traceID, callerSpanID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/transactions",
+		strings.NewReader(`{"account_id": `+strconv.FormatUint(uint64(account.ID), 10)+`, "transaction_type": "deposit", "amount": 125}`))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("traceparent", "00-"+traceID+"-"+callerSpanID+"-01")
+	request.Header.Set("X-Request-ID", "trace-test-1")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", response.Code, response.Body.String())
+	}
+
+	spans := recorder.Ended()
+	var server sdktrace.ReadOnlySpan
+	for _, span := range spans {
+		if span.SpanKind() == trace.SpanKindServer {
+			if server != nil {
+				t.Fatalf("two server spans: %s and %s", server.Name(), span.Name())
+			}
+			server = span
+		}
+	}
+	if server == nil {
+		t.Fatalf("no server span among %d spans", len(spans))
+	}
+
+	if server.Name() != "POST /api/v1/transactions" {
+		t.Errorf("server span named %q, want POST /api/v1/transactions", server.Name())
+	}
+	if server.SpanContext().TraceID().String() != traceID || server.Parent().SpanID().String() != callerSpanID || !server.Parent().IsRemote() {
+		t.Errorf("server span in trace %s under %s (remote %v), want the caller's trace %s under %s",
+			server.SpanContext().TraceID(), server.Parent().SpanID(), server.Parent().IsRemote(), traceID, callerSpanID)
+	}
+	for key, want := range map[string]attribute.Value{
+		"http.request.method":       attribute.StringValue("POST"),
+		"http.route":                attribute.StringValue("/api/v1/transactions"),
+		"url.path":                  attribute.StringValue("/api/v1/transactions"),
+		"request.id":                attribute.StringValue("trace-test-1"),
+		"http.response.status_code": attribute.IntValue(http.StatusCreated),
+	} {
+		if got, ok := attr(server, key); !ok || got != want {
+			t.Errorf("server span %s = %v (set %v), want %v", key, got.Emit(), ok, want.Emit())
+		}
+	}
+	if server.Status().Code == codes.Error {
+		t.Errorf("server span status %v, want no error", server.Status())
+	}
+
+	// Every statement is a client span directly under the request, in its trace, and the posting is among them
+	statements, inserted := 0, map[string]bool{}
+	for _, span := range spans {
+		if span == server {
+			continue
+		}
+		if span.SpanContext().TraceID().String() != traceID {
+			t.Errorf("span %s in trace %s, want %s", span.Name(), span.SpanContext().TraceID(), traceID)
+		}
+		if !strings.HasPrefix(span.Name(), "db.") {
+			continue
+		}
+		statements++
+		if span.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Errorf("statement span %s under %s, want the request span %s", span.Name(), span.Parent().SpanID(), server.SpanContext().SpanID())
+		}
+		if span.SpanKind() != trace.SpanKindClient {
+			t.Errorf("statement span %s is %v, want client", span.Name(), span.SpanKind())
+		}
+		if system, _ := attr(span, "db.system"); system.AsString() != "sqlite" {
+			t.Errorf("statement span %s db.system %q, want sqlite", span.Name(), system.AsString())
+		}
+		if statement, ok := attr(span, "db.statement"); !ok || statement.AsString() == "" || strings.Contains(statement.AsString(), "TRACE-1") {
+			t.Errorf("statement span %s db.statement %q, want the normalized SQL", span.Name(), statement.AsString())
+		}
+		if span.Status().Code == codes.Error {
+			t.Errorf("statement span %s failed: %s", span.Name(), span.Status().Description)
+		}
+		if table, _ := attr(span, "db.sql.table"); span.Name() == "db.create" {
+			inserted[table.AsString()] = true
+		}
+		if span.StartTime().Before(server.StartTime()) || span.EndTime().After(server.EndTime()) {
+			t.Errorf("statement span %s runs outside the request span", span.Name())
+		}
+	}
+	if statements == 0 {
+		t.Fatal("no statement spans under the request")
+	}
+	if !inserted["transactions"] {
+		t.Errorf("no db.create span on transactions, only on %v", inserted)
+	}
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"banking-app/alerts"
+	"banking-app/cache"
+	"banking-app/clock"
+	"banking-app/duplicates"
+	"banking-app/enrichment"
+	"banking-app/flags"
+	"banking-app/ledger"
+	"banking-app/middleware"
+	"banking-app/models"
+	"banking-app/restrictions"
+	"banking-app/reviews"
+	"banking-app/tenancy"
+	"banking-app/transfers"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== TRANSFER HANDLERS ====================
+
+// transferRequest is a transfer between two accounts
+type transferRequest struct {
+	FromAccountID    uint    `json:"from_account_id" binding:"required"`
+	ToAccountID      uint    `json:"to_account_id" binding:"required"`
+	Amount           float64 `json:"amount"`
+	Description      string  `json:"description"`
+	Reference        string  `json:"reference"`
+	Channel          string  `json:"channel"`
+	ConfirmDuplicate bool    `json:"confirm_duplicate"`
+}
+
+// CreateTransfer moves money between two accounts, debiting one and crediting the other atomically
+// A transfer matching a recent one is refused as a suspected duplicate unless confirm_duplicate is set.
+// A retry with the same Idempotency-Key header returns the original transfer with 200
+func CreateTransfer(db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store, cfg transfers.Config, reviewConfig reviews.Config, duplicateConfig duplicates.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req transferRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		result, held, apiErr := postTransfer(c, db, balances, featureFlags, cfg, reviewConfig, duplicateConfig, req, false)
+		if apiErr != nil {
+			apiErr.respondV1(c)
+			return
+		}
+		if held != nil {
+			held.respondV1(c, "Transfer")
+			return
+		}
+
+		response := gin.H{
+			"message":  "Transfer completed successfully",
+			"transfer": result.Transfer,
+		}
+		if result.Fee != nil {
+			response["fee"] = result.Fee
+		}
+		if result.Replayed {
+			response["message"] = "Transfer already processed"
+			response["idempotent_replay"] = true
+			c.JSON(http.StatusOK, response)
+			return
+		}
+		c.JSON(http.StatusCreated, response)
+	}
+}
+
+// postTransfer validates and posts a transfer; shared by every API version
+// The Idempotency-Key header, when sent, makes retries return the transfer first posted with it. A transfer from
+// an account whose withdrawals need staff approval is held in the approval queue instead, and the first large
+// transfer from a new account in the review queue; what holds it is returned. approved is set when an approver
+// posts it from the approval queue, which also skips review
+func postTransfer(c *gin.Context, db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store, cfg transfers.Config, reviewConfig reviews.Config, duplicateConfig duplicates.Config, req transferRequest, approved bool) (transfers.Result, *held, *apiError) {
+	middleware.AuditAccount(c, req.FromAccountID)
+	if err := ledger.ValidateMovement(req.FromAccountID, req.ToAccountID, req.Amount); err != nil {
+		return transfers.Result{}, nil, postingError(err)
+	}
+	key := strings.TrimSpace(c.GetHeader("Idempotency-Key"))
+	if len(key) > 100 {
+		return transfers.Result{}, nil, &apiError{Status: http.StatusBadRequest, Code: "INVALID_IDEMPOTENCY_KEY", Message: "Idempotency-Key must be at most 100 characters"}
+	}
+	if limit := tenancy.CurrentSettings(c).TransactionLimit; limit > 0 && req.Amount > limit {
+		return transfers.Result{}, nil, &apiError{Status: http.StatusBadRequest, Code: "AMOUNT_LIMIT_EXCEEDED", Message: "Transaction amount exceeds the limit"}
+	}
+	if req.Channel == "" {
+		req.Channel = enrichment.DefaultChannel
+	}
+	if !contains(enrichment.Channels, req.Channel) {
+		return transfers.Result{}, nil, &apiError{Status: http.StatusBadRequest, Code: "INVALID_CHANNEL", Message: "Invalid transaction channel"}
+	}
+
+	// The first large transfer from a new account is held for review rather than posted; a transfer that could
+	// never post is refused now with the same errors
+	var result transfers.Result
+	var review *models.TransactionReview
+	var err error
+	if !approved {
+		review, err = reviews.Hold(db, reviewConfig, featureFlags, models.TransactionReview{
+			Kind:            reviews.KindTransfer,
+			AccountID:       req.FromAccountID,
+			ToAccountID:     req.ToAccountID,
+			TransactionType: "transfer",
+			Amount:          req.Amount,
+			Description:     req.Description,
+			Reference:       req.Reference,
+			Channel:         req.Channel,
+			IdempotencyKey:  key,
+			RequestedBy:     actor(c),
+		}, clock.Now())
+	}
+	if err == nil && review == nil {
+		result, err = transfers.Post(db, transfers.Request{
+			FromAccountID:    req.FromAccountID,
+			ToAccountID:      req.ToAccountID,
+			Amount:           req.Amount,
+			Description:      req.Description,
+			Reference:        req.Reference,
+			Channel:          req.Channel,
+			ConfirmDuplicate: req.ConfirmDuplicate,
+			IdempotencyKey:   key,
+			CreatedBy:        actor(c),
+			Approved:         approved,
+			Cutoffs:          tenancy.CurrentSettings(c).Cutoffs,
+		}, cfg, featureFlags)
+	}
+
+	var duplicate *transfers.DuplicateError
+	var reused *transfers.IdempotencyError
+	switch {
+	case err == nil:
+	case errors.As(err, &duplicate):
+		return result, nil, &apiError{
+			Status:  http.StatusConflict,
+			Code:    "DUPLICATE_SUSPECTED",
+			Message: "Transfer matches a recent transfer; resend with confirm_duplicate=true if intended",
+			Details: gin.H{
+				"original_transfer_id":    duplicate.Original.ID,
+				"original_transaction_id": duplicate.Original.DebitTransactionID,
+				"original_created_at":     duplicate.Original.CreatedAt,
+			},
+			v1Code: true,
+		}
+	case errors.As(err, &reused):
+		return result, nil, &apiError{
+			Status:  http.StatusConflict,
+			Code:    "IDEMPOTENCY_CONFLICT",
+			Message: "Idempotency-Key was already used for a transfer with a different source, destination or amount",
+			Details: gin.H{"original_transfer_id": reused.Original.ID},
+			v1Code:  true,
+		}
+	case errors.Is(err, transfers.ErrCurrencyMismatch):
+		return result, nil, &apiError{Status: http.StatusBadRequest, Code: "CURRENCY_MISMATCH", Message: err.Error()}
+	case errors.Is(err, transfers.ErrDestinationInactive):
+		return result, nil, &apiError{Status: http.StatusBadRequest, Code: "DESTINATION_INACTIVE", Message: err.Error()}
+	case errors.Is(err, restrictions.ErrApprovalRequired):
+		approval := models.WithdrawalApproval{
+			Kind:            restrictions.KindTransfer,
+			AccountID:       req.FromAccountID,
+			ToAccountID:     req.ToAccountID,
+			TransactionType: "transfer",
+			Amount:          req.Amount,
+			Description:     req.Description,
+			Reference:       req.Reference,
+			Channel:         req.Channel,
+			RequestedBy:     actor(c),
+		}
+		if err := restrictions.Hold(db, &approval); err != nil {
+			return result, nil, &apiError{Status: http.StatusInternalServerError, Code: "INTERNAL_ERROR", Message: "Failed to queue the transfer for approval"}
+		}
+		return result, &held{Approval: &approval}, nil
+	default:
+		return result, nil, postingError(err)
+	}
+	if review != nil {
+		return result, &held{Review: review}, nil
+	}
+	if result.Replayed {
+		return result, nil, nil
+	}
+
+	for _, account := range []models.Account{result.From, result.To} {
+		balances.Set(cache.Entry(account))
+	}
+	alerts.EvaluateTransaction(db, result.From, result.Debit)
+	alerts.EvaluateTransaction(db, result.To, result.Credit)
+	duplicates.Detect(db, duplicateConfig, result.From, result.Debit)
+	return result, nil, nil
+}
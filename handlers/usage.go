@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"banking-app/usage"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ==================== API USAGE HANDLERS ====================
+
+// usagePeriod reads ?from= and ?to= (YYYY-MM-DD, to inclusive), which default to the month to today
+func usagePeriod(c *gin.Context) (from, to string, ok bool) {
+	now := clock.Now()
+	to, from = businessdays.Format(now), businessdays.Format(businessdays.StartOfMonth(now))
+	for _, param := range []struct {
+		name string
+		into *string
+	}{{"from", &from}, {"to", &to}} {
+		if raw := c.Query(param.name); raw != "" {
+			if _, err := businessdays.ParseDate(raw); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param.name + " date, expected YYYY-MM-DD"})
+				return "", "", false
+			}
+			*param.into = raw
+		}
+	}
+	if to < from {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+		return "", "", false
+	}
+	return from, to, true
+}
+
+// usageClient finds the client :id names in the request tenant; deleted clients are found too, since their
+// usage is still billed
+func usageClient(c *gin.Context, db *gorm.DB) (models.OAuthClient, bool) {
+	var client models.OAuthClient
+	if err := tenancy.DB(c, db).Unscoped().Where("client_id = ?", c.Param("id")).First(&client).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		return client, false
+	}
+	return client, true
+}
+
+// GetClientUsage reports an API client's usage by endpoint category and day, and its quotas against this
+// month's usage
+func GetClientUsage(db *gorm.DB, meter *usage.Meter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client, ok := usageClient(c, db)
+		if !ok {
+			return
+		}
+		from, to, ok := usagePeriod(c)
+		if !ok {
+			return
+		}
+		report, err := meter.Report(tenancy.DB(c, db), client.ClientID, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve usage"})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// GetOwnUsage is GetClientUsage for the client calling it with its own token
+func GetOwnUsage(db *gorm.DB, meter *usage.Meter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientID := c.GetString("client_id")
+		if clientID == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Usage is reported to API clients; staff see it at /admin/clients/:id/usage"})
+			return
+		}
+		from, to, ok := usagePeriod(c)
+		if !ok {
+			return
+		}
+		report, err := meter.Report(tenancy.DB(c, db), clientID, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve usage"})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// GetClientQuotas lists an API client's monthly quotas
+func GetClientQuotas(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client, ok := usageClient(c, db)
+		if !ok {
+			return
+		}
+		quotas := []models.UsageQuota{}
+		if err := tenancy.DB(c, db).Where("client_id = ?", client.ClientID).Order("id").Find(&quotas).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve quotas"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"client_id": client.ClientID, "quotas": quotas})
+	}
+}
+
+// usageQuotaRequest sets a quota; a client has one quota per metric and category
+type usageQuotaRequest struct {
+	Metric            string `json:"metric" binding:"required"`
+	Category          string `json:"category"`
+	MonthlyLimit      *int64 `json:"monthly_limit" binding:"required"`
+	Mode              string `json:"mode" binding:"required"`
+	ThrottlePerMinute int    `json:"throttle_per_minute"`
+}
+
+// SetClientQuota creates or replaces an API client's quota for a metric and category
+// It applies on this instance at once, and on the others after their next usage flush
+func SetClientQuota(db *gorm.DB, meter *usage.Meter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client, ok := usageClient(c, db)
+		if !ok {
+			return
+		}
+		var req usageQuotaRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "metric, monthly_limit and mode are required"})
+			return
+		}
+		quota := models.UsageQuota{
+			ClientID:          client.ClientID,
+			Metric:            req.Metric,
+			Category:          req.Category,
+			MonthlyLimit:      *req.MonthlyLimit,
+			Mode:              req.Mode,
+			ThrottlePerMinute: req.ThrottlePerMinute,
+			UpdatedBy:         actor(c),
+		}
+		if err := usage.Validate(&quota); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		db := tenancy.DB(c, db)
+		err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "client_id"}, {Name: "metric"}, {Name: "category"}},
+			DoUpdates: clause.AssignmentColumns([]string{"monthly_limit", "mode", "throttle_per_minute", "updated_by", "updated_at"}),
+		}).Create(&quota).Error
+		if err == nil {
+			err = db.Where("client_id = ? AND metric = ? AND category = ?", quota.ClientID, quota.Metric, quota.Category).First(&quota).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save quota"})
+			return
+		}
+		meter.Forget(client.ClientID)
+		c.JSON(http.StatusOK, gin.H{"message": "Quota set", "quota": quota})
+	}
+}
+
+// DeleteClientQuota removes one of an API client's quotas
+func DeleteClientQuota(db *gorm.DB, meter *usage.Meter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client, ok := usageClient(c, db)
+		if !ok {
+			return
+		}
+		result := tenancy.DB(c, db).Where("client_id = ?", client.ClientID).Delete(&models.UsageQuota{}, c.Param("quotaId"))
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete quota"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Quota not found"})
+			return
+		}
+		meter.Forget(client.ClientID)
+		c.JSON(http.StatusOK, gin.H{"message": "Quota removed"})
+	}
+}
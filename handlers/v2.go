@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"banking-app/cache"
+	"banking-app/duplicates"
+	"banking-app/flags"
+	"banking-app/models"
+	"banking-app/reviews"
+	"banking-app/tenancy"
+	"banking-app/transfers"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== API V2 HANDLERS ====================
+// v2 wraps results in {"data": ...}, reports errors as {"error": {"code", "message"}} and carries
+// amounts as decimal strings so clients never round-trip money through floating point
+
+// decimalPattern accepts a non-negative amount with at most two decimal places
+var decimalPattern = regexp.MustCompile(`^\d{1,13}(\.\d{1,2})?$`)
+
+// invalidRequestV2 is the v2 error for a body that does not bind
+var invalidRequestV2 = &apiError{Status: http.StatusBadRequest, Code: "INVALID_REQUEST", Message: "Invalid request data; amounts must be decimal strings"}
+
+// parseDecimal reads a decimal-string amount such as "125.50"
+func parseDecimal(value string) (float64, *apiError) {
+	if !decimalPattern.MatchString(value) {
+		return 0, &apiError{Status: http.StatusBadRequest, Code: "INVALID_AMOUNT", Message: "Amount must be a decimal string with at most two decimal places"}
+	}
+	amount, _ := strconv.ParseFloat(value, 64)
+	return amount, nil
+}
+
+// formatDecimal renders an amount as a two-place decimal string
+func formatDecimal(value float64) string {
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
+
+// transactionV2 is the v2 representation of a transaction
+type transactionV2 struct {
+	ID              uint       `json:"id"`
+	TransactionID   string     `json:"transaction_id"`
+	AccountID       uint       `json:"account_id"`
+	TransactionType string     `json:"transaction_type"`
+	Amount          string     `json:"amount"`
+	Description     string     `json:"description"`
+	Descriptor      string     `json:"descriptor"`
+	Memo            string     `json:"memo"`
+	Reference       string     `json:"reference"`
+	MerchantName    string     `json:"merchant_name"`
+	Channel         string     `json:"channel"`
+	CategoryCode    string     `json:"category_code"`
+	Location        string     `json:"location,omitempty"`
+	BalanceBefore   string     `json:"balance_before"`
+	BalanceAfter    string     `json:"balance_after"`
+	EffectiveDate   *time.Time `json:"effective_date,omitempty"`
+	ValueDate       *time.Time `json:"value_date,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+
+	// Fees - a fee posting names the posting it was charged on; a posting just made carries its fee
+	FeeOfID *uint          `json:"fee_of_id,omitempty"`
+	Fee     *transactionV2 `json:"fee,omitempty"`
+
+	// List rows also carry the account number and owner
+	AccountNumber string `json:"account_number,omitempty"`
+	CustomerID    uint   `json:"customer_id,omitempty"`
+	CustomerName  string `json:"customer_name,omitempty"`
+}
+
+// newTransactionV2 converts a posted transaction
+func newTransactionV2(t models.Transaction) transactionV2 {
+	return transactionV2{
+		ID:              t.ID,
+		TransactionID:   t.TransactionID,
+		AccountID:       t.AccountID,
+		TransactionType: t.TransactionType,
+		Amount:          formatDecimal(t.Amount),
+		Description:     t.Description,
+		Descriptor:      t.Descriptor,
+		Memo:            t.Memo,
+		Reference:       t.Reference,
+		MerchantName:    t.MerchantName,
+		Channel:         t.Channel,
+		CategoryCode:    t.CategoryCode,
+		Location:        t.Location,
+		BalanceBefore:   formatDecimal(t.BalanceBefore),
+		BalanceAfter:    formatDecimal(t.BalanceAfter),
+		EffectiveDate:   &t.EffectiveDate,
+		ValueDate:       t.ValueDate,
+		CreatedAt:       t.CreatedAt,
+		FeeOfID:         t.FeeOfID,
+	}
+}
+
+// feeV2 converts the fee charged on a posting, if any
+func feeV2(fee *models.Transaction) *transactionV2 {
+	if fee == nil {
+		return nil
+	}
+	v2 := newTransactionV2(*fee)
+	return &v2
+}
+
+// summaryTransactionV2 converts a transaction list row
+func summaryTransactionV2(t TransactionSummary) transactionV2 {
+	return transactionV2{
+		ID:              t.ID,
+		TransactionID:   t.TransactionID,
+		AccountID:       t.AccountID,
+		TransactionType: t.TransactionType,
+		Amount:          formatDecimal(t.Amount),
+		Description:     t.Description,
+		Descriptor:      t.Descriptor,
+		Memo:            t.Memo,
+		Reference:       t.Reference,
+		MerchantName:    t.MerchantName,
+		Channel:         t.Channel,
+		CategoryCode:    t.CategoryCode,
+		Location:        t.Location,
+		BalanceBefore:   formatDecimal(t.BalanceBefore),
+		BalanceAfter:    formatDecimal(t.BalanceAfter),
+		CreatedAt:       t.CreatedAt,
+		AccountNumber:   t.AccountNumber,
+		CustomerID:      t.CustomerID,
+		CustomerName:    t.CustomerName,
+	}
+}
+
+// approvalV2 is the v2 representation of a debit held for staff approval
+type approvalV2 struct {
+	ID              uint      `json:"id"`
+	Status          string    `json:"status"`
+	Kind            string    `json:"kind"`
+	AccountID       uint      `json:"account_id"`
+	ToAccountID     uint      `json:"to_account_id,omitempty"`
+	TransactionType string    `json:"transaction_type"`
+	Amount          string    `json:"amount"`
+	Description     string    `json:"description"`
+	Reference       string    `json:"reference"`
+	RequestedBy     string    `json:"requested_by"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// newApprovalV2 converts a held debit
+func newApprovalV2(a models.WithdrawalApproval) approvalV2 {
+	return approvalV2{
+		ID:              a.ID,
+		Status:          a.Status,
+		Kind:            a.Kind,
+		AccountID:       a.AccountID,
+		ToAccountID:     a.ToAccountID,
+		TransactionType: a.TransactionType,
+		Amount:          formatDecimal(a.Amount),
+		Description:     a.Description,
+		Reference:       a.Reference,
+		RequestedBy:     a.RequestedBy,
+		CreatedAt:       a.CreatedAt,
+	}
+}
+
+// reviewV2 is the v2 representation of a debit held for review on a new account
+type reviewV2 struct {
+	ID                uint      `json:"id"`
+	Status            string    `json:"status"`
+	Kind              string    `json:"kind"`
+	AccountID         uint      `json:"account_id"`
+	ToAccountID       uint      `json:"to_account_id,omitempty"`
+	TransactionType   string    `json:"transaction_type"`
+	Amount            string    `json:"amount"`
+	Description       string    `json:"description"`
+	Reference         string    `json:"reference"`
+	EstimatedReviewBy time.Time `json:"estimated_review_by"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// newReviewV2 converts a held debit
+func newReviewV2(r models.TransactionReview) reviewV2 {
+	return reviewV2{
+		ID:                r.ID,
+		Status:            r.Status,
+		Kind:              r.Kind,
+		AccountID:         r.AccountID,
+		ToAccountID:       r.ToAccountID,
+		TransactionType:   r.TransactionType,
+		Amount:            formatDecimal(r.Amount),
+		Description:       r.Description,
+		Reference:         r.Reference,
+		EstimatedReviewBy: r.ReviewBy,
+		CreatedAt:         r.CreatedAt,
+	}
+}
+
+// transactionRequestV2 is a client transaction with a decimal-string amount
+type transactionRequestV2 struct {
+	AccountID       uint       `json:"account_id" binding:"required"`
+	TransactionType string     `json:"transaction_type" binding:"required"`
+	Amount          string     `json:"amount" binding:"required"`
+	Description     string     `json:"description"`
+	Memo            string     `json:"memo"`
+	Reference       string     `json:"reference"`
+	MerchantName    string     `json:"merchant_name"`
+	Channel         string     `json:"channel"`
+	CategoryCode    string     `json:"category_code"`
+	Location        string     `json:"location"`
+	EffectiveDate   *time.Time `json:"effective_date"`
+}
+
+// CreateTransactionV2 posts a transaction; validation and posting are shared with v1
+func CreateTransactionV2(db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store, reviewConfig reviews.Config, duplicateConfig duplicates.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var req transactionRequestV2
+		if err := c.ShouldBindJSON(&req); err != nil {
+			invalidRequestV2.respondV2(c)
+			return
+		}
+		amount, apiErr := parseDecimal(req.Amount)
+		if apiErr != nil {
+			apiErr.respondV2(c)
+			return
+		}
+
+		transaction := models.Transaction{
+			AccountID:       req.AccountID,
+			TransactionType: req.TransactionType,
+			Amount:          amount,
+			Description:     req.Description,
+			Memo:            req.Memo,
+			Reference:       req.Reference,
+			MerchantName:    req.MerchantName,
+			Channel:         req.Channel,
+			CategoryCode:    req.CategoryCode,
+			Location:        req.Location,
+		}
+		if req.EffectiveDate != nil {
+			transaction.EffectiveDate = *req.EffectiveDate
+		}
+		fee, held, apiErr := postTransaction(c, db, balances, featureFlags, reviewConfig, duplicateConfig, &transaction, false)
+		if apiErr != nil {
+			apiErr.respondV2(c)
+			return
+		}
+		if held != nil {
+			held.respondV2(c)
+			return
+		}
+
+		data := newTransactionV2(transaction)
+		data.Fee = feeV2(fee)
+		c.JSON(http.StatusCreated, gin.H{"data": data})
+	}
+}
+
+// GetTransactionsV2 lists transactions with the v1 filters; ?include= is not supported
+func GetTransactionsV2(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		page, limit, offset := parsePagination(c, 10)
+
+		rows, total, err := listTransactions(c, db, limit, offset)
+		if err != nil {
+			(&apiError{Status: http.StatusInternalServerError, Code: "INTERNAL_ERROR", Message: "Failed to retrieve transactions"}).respondV2(c)
+			return
+		}
+
+		data := make([]transactionV2, 0, len(rows))
+		for _, row := range rows {
+			data = append(data, summaryTransactionV2(row))
+		}
+		respondDisplay(c, http.StatusOK, gin.H{
+			"data": data,
+			"meta": gin.H{"total": total, "page": page, "limit": limit},
+		})
+	}
+}
+
+// transferRequestV2 is a transfer with a decimal-string amount
+type transferRequestV2 struct {
+	FromAccountID    uint   `json:"from_account_id" binding:"required"`
+	ToAccountID      uint   `json:"to_account_id" binding:"required"`
+	Amount           string `json:"amount" binding:"required"`
+	Description      string `json:"description"`
+	Reference        string `json:"reference"`
+	Channel          string `json:"channel"`
+	ConfirmDuplicate bool   `json:"confirm_duplicate"`
+}
+
+// transferV2 is the v2 representation of a transfer
+type transferV2 struct {
+	ID                     uint           `json:"id"`
+	FromAccountID          uint           `json:"from_account_id"`
+	ToAccountID            uint           `json:"to_account_id"`
+	Amount                 string         `json:"amount"`
+	Description            string         `json:"description"`
+	Reference              string         `json:"reference"`
+	DebitTransactionID     uint           `json:"debit_transaction_id"`
+	CreditTransactionID    uint           `json:"credit_transaction_id"`
+	Fee                    *transactionV2 `json:"fee,omitempty"`
+	ConfirmedDuplicateOfID *uint          `json:"confirmed_duplicate_of_id,omitempty"`
+	IdempotencyKey         *string        `json:"idempotency_key,omitempty"`
+	CreatedBy              string         `json:"created_by"`
+	CreatedAt              time.Time      `json:"created_at"`
+}
+
+// CreateTransferV2 moves money between two accounts; duplicate detection and idempotency keys are shared with v1
+func CreateTransferV2(db *gorm.DB, balances *cache.Balances, featureFlags *flags.Store, cfg transfers.Config, reviewConfig reviews.Config, duplicateConfig duplicates.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		var body transferRequestV2
+		if err := c.ShouldBindJSON(&body); err != nil {
+			invalidRequestV2.respondV2(c)
+			return
+		}
+		amount, apiErr := parseDecimal(body.Amount)
+		if apiErr != nil {
+			apiErr.respondV2(c)
+			return
+		}
+
+		result, held, apiErr := postTransfer(c, db, balances, featureFlags, cfg, reviewConfig, duplicateConfig, transferRequest{
+			FromAccountID:    body.FromAccountID,
+			ToAccountID:      body.ToAccountID,
+			Amount:           amount,
+			Description:      body.Description,
+			Reference:        body.Reference,
+			Channel:          body.Channel,
+			ConfirmDuplicate: body.ConfirmDuplicate,
+		}, false)
+		if apiErr != nil {
+			apiErr.respondV2(c)
+			return
+		}
+		if held != nil {
+			held.respondV2(c)
+			return
+		}
+
+		status := http.StatusCreated
+		if result.Replayed {
+			status = http.StatusOK
+		}
+		transfer := result.Transfer
+		c.JSON(status, gin.H{"data": transferV2{
+			ID:                     transfer.ID,
+			FromAccountID:          transfer.FromAccountID,
+			ToAccountID:            transfer.ToAccountID,
+			Amount:                 formatDecimal(transfer.Amount),
+			Description:            transfer.Description,
+			Reference:              transfer.Reference,
+			DebitTransactionID:     transfer.DebitTransactionID,
+			CreditTransactionID:    transfer.CreditTransactionID,
+			Fee:                    feeV2(result.Fee),
+			ConfirmedDuplicateOfID: transfer.ConfirmedDuplicateOfID,
+			IdempotencyKey:         transfer.IdempotencyKey,
+			CreatedBy:              transfer.CreatedBy,
+			CreatedAt:              transfer.CreatedAt,
+		}})
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"banking-app/clock"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"banking-app/verification"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ==================== EMAIL VERIFICATION HANDLERS ====================
+
+// verifyEmailRequest carries an emailed verification token
+type verifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// VerifyCustomerEmail confirms a customer's email address with the token emailed to it
+// Body: {"token": "..."}. The token is the credential, so no sign-in is needed. A signup token marks the
+// address verified; a change token replaces the customer's address and both addresses are told
+func VerifyCustomerEmail(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req verifyEmailRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+			return
+		}
+
+		customer, err := verification.Verify(db, req.Token, clock.Now())
+		switch err {
+		case nil:
+		case verification.ErrInvalidToken:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Verification token not found", "code": "VERIFICATION_TOKEN_INVALID"})
+			return
+		case verification.ErrTokenExpired:
+			c.JSON(http.StatusGone, gin.H{"error": "This verification token has expired; request a new one", "code": "VERIFICATION_TOKEN_EXPIRED"})
+			return
+		case verification.ErrTokenUsed:
+			c.JSON(http.StatusConflict, gin.H{"error": "This verification token has already been used", "code": "VERIFICATION_TOKEN_USED"})
+			return
+		case verification.ErrTokenSuperseded:
+			c.JSON(http.StatusConflict, gin.H{"error": "A newer verification email has been sent; use the token in it", "code": "VERIFICATION_TOKEN_SUPERSEDED"})
+			return
+		case verification.ErrEmailTaken:
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already exists", "code": "EMAIL_TAKEN"})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email address"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":        "Email address verified",
+			"customer_id":    customer.ID,
+			"email":          customer.Email,
+			"email_verified": customer.EmailVerified,
+		})
+	}
+}
+
+// ResendEmailVerification emails a fresh token for the customer's unconfirmed address, the pending new one
+// if a change was requested; earlier tokens stop working. Resends are spaced by the configured interval
+func ResendEmailVerification(db *gorm.DB, cfg verification.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := tenancy.DB(c, db)
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
+			return
+		}
+		var customer models.Customer
+		if err := db.First(&customer, uint(id)).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+			return
+		}
+
+		var sent models.EmailVerification
+		now := clock.Now()
+		err = db.Transaction(func(tx *gorm.DB) error {
+			sent, err = verification.Resend(tx, cfg, customer, now)
+			return err
+		})
+		switch err {
+		case nil:
+		case verification.ErrNothingToVerify:
+			c.JSON(http.StatusConflict, gin.H{"error": "The customer's email address is already verified", "code": "NOTHING_TO_VERIFY"})
+			return
+		case verification.ErrResendTooSoon:
+			wait := sent.CreatedAt.Add(cfg.ResendInterval).Sub(now)
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "A verification email was sent moments ago; try again shortly", "code": "RESEND_TOO_SOON"})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resend verification email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Verification email sent",
+			"email":      sent.Email,
+			"purpose":    sent.Purpose,
+			"expires_at": sent.ExpiresAt,
+		})
+	}
+}
+
+// requireVerifiedEmail answers 409 EMAIL_NOT_VERIFIED unless the customer's email address is verified
+// Features that send to the customer's address, such as e-statements, call it before enabling them
+func requireVerifiedEmail(c *gin.Context, db *gorm.DB, customerID uint) bool {
+	var customer models.Customer
+	if err := db.Select("id, email, email_verified").First(&customer, customerID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return false
+	}
+	if customer.Email == "" || !customer.EmailVerified {
+		c.JSON(http.StatusConflict, gin.H{"error": "The customer's email address must be verified before email delivery can be used", "code": "EMAIL_NOT_VERIFIED"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,256 @@
+package i18n
+
+import (
+	"banking-app/businessdays"
+	"banking-app/display"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default is the locale used when nothing better is known, and the one every other falls back to
+const Default = "en"
+
+// ErrorPrefix starts the catalog key of an API error code, e.g. error.INSUFFICIENT_FUNDS
+const ErrorPrefix = "error."
+
+// Catalog keys describing how a locale writes dates and numbers rather than words
+const (
+	keyDate    = "format.date"    // Go time layout for a bank date
+	keyMonth   = "format.month"   // Month and year, from the month name and the year, e.g. "%s %d"
+	keyDecimal = "format.decimal" // Decimal separator
+	keyGroup   = "format.group"   // Thousands separator
+	keyAmount  = "format.amount"  // Amount with its currency, from {symbol}, {code} and {number}
+)
+
+//go:embed locales/*.json
+var files embed.FS
+
+// Locale is one shipped message catalog
+type Locale struct {
+	Tag      string // Language tag, e.g. es
+	messages map[string]string
+}
+
+// locales are the shipped catalogs by tag, loaded once from the embedded files
+var locales = load()
+
+// warned holds locale/key pairs already reported missing, so each is logged once
+var warned sync.Map
+
+// load parses every embedded catalog; the files are compiled in, so a malformed one is a build defect
+func load() map[string]*Locale {
+	entries, err := files.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	loaded := map[string]*Locale{}
+	for _, entry := range entries {
+		raw, err := files.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		locale := &Locale{Tag: strings.TrimSuffix(entry.Name(), ".json")}
+		if err := json.Unmarshal(raw, &locale.messages); err != nil {
+			panic(fmt.Sprintf("i18n: catalog %s: %v", entry.Name(), err))
+		}
+		loaded[locale.Tag] = locale
+	}
+	if loaded[Default] == nil {
+		panic("i18n: no catalog for the default locale " + Default)
+	}
+	return loaded
+}
+
+// Supported lists the shipped locale tags, the default first
+func Supported() []string {
+	tags := []string{Default}
+	for tag := range locales {
+		if tag != Default {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags[1:])
+	return tags
+}
+
+// Match returns the shipped locale a language tag asks for, matching on the primary language
+// when the region is not shipped, e.g. es-MX is served in es
+func Match(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimSpace(strings.ReplaceAll(tag, "_", "-")))
+	if _, ok := locales[tag]; ok {
+		return tag, true
+	}
+	if primary, _, found := strings.Cut(tag, "-"); found {
+		if _, ok := locales[primary]; ok {
+			return primary, true
+		}
+	}
+	return "", false
+}
+
+// For returns the catalog for a language tag; unknown or empty tags get the default
+func For(tag string) *Locale {
+	if matched, ok := Match(tag); ok {
+		return locales[matched]
+	}
+	return locales[Default]
+}
+
+// Negotiate picks the locale an Accept-Language header prefers most among those shipped
+// Languages are tried in descending q order, ties in header order; nothing acceptable gives the default
+func Negotiate(header string) *Locale {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			choices = append(choices, choice{tag, q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	for _, c := range choices {
+		if matched, ok := Match(c.tag); ok {
+			return locales[matched]
+		}
+	}
+	return locales[Default]
+}
+
+// Missing lists, per shipped locale, the keys the default catalog has that it lacks
+func Missing() map[string][]string {
+	missing := map[string][]string{}
+	for tag, locale := range locales {
+		for key := range locales[Default].messages {
+			if _, ok := locale.messages[key]; !ok {
+				missing[tag] = append(missing[tag], key)
+			}
+		}
+		sort.Strings(missing[tag])
+	}
+	for tag, keys := range missing {
+		if len(keys) == 0 {
+			delete(missing, tag)
+		}
+	}
+	return missing
+}
+
+// lookup finds a key in this catalog, logging once and falling back to the default catalog when it is missing
+func (l *Locale) lookup(key string) (string, bool) {
+	if message, ok := l.messages[key]; ok {
+		return message, true
+	}
+	l.warn(key)
+	message, ok := locales[Default].messages[key]
+	return message, ok
+}
+
+// warn logs a missing key the first time this locale is asked for it
+func (l *Locale) warn(key string) {
+	if _, seen := warned.LoadOrStore(l.Tag+"/"+key, true); !seen {
+		log.Printf("i18n: locale %s has no message %q, falling back to %s", l.Tag, key, Default)
+	}
+}
+
+// T returns the message for key, formatted with args when there are any
+// A key missing from every catalog is returned as it is, so a gap shows up without failing the request
+func (l *Locale) T(key string, args ...interface{}) string {
+	message, ok := l.lookup(key)
+	if !ok {
+		message = key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+// Error returns the message for an API error code, or fallback when the code has none
+// The default locale keeps the handler's own message, which is often more specific than the catalog's
+func (l *Locale) Error(code, fallback string) string {
+	if l.Tag == Default {
+		return fallback
+	}
+	if message, ok := l.messages[ErrorPrefix+code]; ok {
+		return message
+	}
+	l.warn(ErrorPrefix + code)
+	return fallback
+}
+
+// Date writes the bank date t falls on, e.g. 2024-03-31 or 31/03/2024
+func (l *Locale) Date(t time.Time) string {
+	return businessdays.In(t).Format(l.T(keyDate))
+}
+
+// Timestamp writes an instant in UTC with the locale's date order, e.g. 2024-03-31 14:05:00 UTC
+func (l *Locale) Timestamp(t time.Time) string {
+	return t.UTC().Format(l.T(keyDate) + " 15:04:05 MST")
+}
+
+// Month writes the month and year t falls in, e.g. "March 2024" or "marzo de 2024"
+func (l *Locale) Month(t time.Time) string {
+	t = businessdays.In(t)
+	return fmt.Sprintf(l.T(keyMonth), l.T(fmt.Sprintf("month.%d", t.Month())), t.Year())
+}
+
+// Number writes an amount with two decimals and the locale's separators, e.g. 1,234.50 or 1.234,50
+func (l *Locale) Number(value float64) string {
+	sign := ""
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+	raw := strconv.FormatFloat(value, 'f', 2, 64)
+	whole, cents := raw[:len(raw)-3], raw[len(raw)-2:]
+	group := l.T(keyGroup)
+
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteString(group)
+		}
+		grouped.WriteRune(digit)
+	}
+	return sign + grouped.String() + l.T(keyDecimal) + cents
+}
+
+// Amount writes an amount with its currency in the locale's order, e.g. -$1,234.50 or -1.234,50 USD
+// Currencies without a symbol use their code in its place
+func (l *Locale) Amount(value float64, currency string) string {
+	sign := ""
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+	currency = strings.ToUpper(currency)
+	symbol, ok := display.Symbol(currency)
+	if !ok && currency != "" {
+		symbol = currency + " "
+	}
+	return sign + strings.TrimSpace(strings.NewReplacer("{symbol}", symbol, "{code}", currency, "{number}", l.Number(value)).Replace(l.T(keyAmount)))
+}
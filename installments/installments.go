@@ -0,0 +1,326 @@
+package installments
+
+import (
+	"banking-app/businessdays"
+	"banking-app/enrichment"
+	"banking-app/flags"
+	"banking-app/ledger"
+	"banking-app/loans"
+	"banking-app/models"
+	"banking-app/money"
+	"banking-app/statushistory"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Plan statuses
+const (
+	StatusActive  = "active"
+	StatusPaidOff = "paid_off"
+)
+
+// Currency is the only currency plans are offered in - their backing loans are opened in it
+const Currency = "USD"
+
+// Plan errors - handlers map these to client responses
+var (
+	ErrAlreadyConverted = errors.New("transaction has already been converted to an installment plan")
+	ErrInvalidMonths    = errors.New("months is not one of the offered plan lengths")
+	ErrPlanClosed       = errors.New("installment plan is not active")
+)
+
+// EligibilityError explains why a transaction cannot be converted
+type EligibilityError struct {
+	Reason string
+}
+
+// Error returns the reason
+func (e *EligibilityError) Error() string {
+	return e.Reason
+}
+
+// Config holds the eligibility rules and pricing of installment plans
+type Config struct {
+	MinAmount  float64       // Smallest payment that can be converted
+	MaxAmount  float64       // Largest payment that can be converted
+	MaxAge     time.Duration // How long after posting a payment can still be converted
+	Months     []int         // Offered plan lengths
+	FeePercent float64       // Fee as a percentage of the amount, charged once at conversion
+}
+
+// ConfigFromEnv reads INSTALLMENT_MIN_AMOUNT (default 100), INSTALLMENT_MAX_AMOUNT (10000),
+// INSTALLMENT_MAX_AGE_DAYS (30), INSTALLMENT_MONTHS (comma-separated, "3,6,12") and INSTALLMENT_FEE_PERCENT (1.5)
+func ConfigFromEnv() Config {
+	number := func(key string, fallback float64) float64 {
+		v, err := strconv.ParseFloat(os.Getenv(key), 64)
+		if err != nil || v < 0 {
+			return fallback
+		}
+		return v
+	}
+	cfg := Config{
+		MinAmount:  number("INSTALLMENT_MIN_AMOUNT", 100),
+		MaxAmount:  number("INSTALLMENT_MAX_AMOUNT", 10000),
+		MaxAge:     time.Duration(number("INSTALLMENT_MAX_AGE_DAYS", 30)) * 24 * time.Hour,
+		FeePercent: number("INSTALLMENT_FEE_PERCENT", 1.5),
+	}
+	for _, raw := range strings.Split(os.Getenv("INSTALLMENT_MONTHS"), ",") {
+		if months, err := strconv.Atoi(strings.TrimSpace(raw)); err == nil && months > 1 {
+			cfg.Months = append(cfg.Months, months)
+		}
+	}
+	if len(cfg.Months) == 0 {
+		cfg.Months = []int{3, 6, 12}
+	}
+	return cfg
+}
+
+// convertibleTypes are the posting types a plan can be made from
+var convertibleTypes = map[string]bool{"payment": true, "withdrawal": true}
+
+// CheckEligibility applies the plan rules to a posted transaction and the account it was taken from
+// The transaction must be a recent customer debit within the amount limits that has not been reversed,
+// converted or used to pay a loan; the account must be in good standing
+func CheckEligibility(db *gorm.DB, cfg Config, t models.Transaction, account models.Account, months int, now time.Time) error {
+	offered := false
+	for _, m := range cfg.Months {
+		offered = offered || m == months
+	}
+	if !offered {
+		return ErrInvalidMonths
+	}
+
+	var converted int64
+	if err := db.Model(&models.InstallmentPlan{}).Where("transaction_id = ?", t.ID).Count(&converted).Error; err != nil {
+		return err
+	}
+	if converted > 0 {
+		return ErrAlreadyConverted
+	}
+
+	if !convertibleTypes[t.TransactionType] || t.ReversalOfID != nil || t.OffsetOfID != nil {
+		return &EligibilityError{Reason: "Only payments and withdrawals can be converted"}
+	}
+	if t.Amount < cfg.MinAmount || t.Amount > cfg.MaxAmount {
+		return &EligibilityError{Reason: fmt.Sprintf("Amount must be between %.2f and %.2f", cfg.MinAmount, cfg.MaxAmount)}
+	}
+	if now.Sub(t.CreatedAt) > cfg.MaxAge {
+		return &EligibilityError{Reason: fmt.Sprintf("Payments can only be converted within %d days", int(cfg.MaxAge.Hours()/24))}
+	}
+
+	var reversed, loanPayments int64
+	if err := db.Model(&models.Transaction{}).Where("reversal_of_id = ?", t.ID).Count(&reversed).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&models.LoanPayment{}).Where("transaction_id = ?", t.ID).Count(&loanPayments).Error; err != nil {
+		return err
+	}
+	if reversed > 0 || loanPayments > 0 {
+		return &EligibilityError{Reason: "Reversed payments and loan payments cannot be converted"}
+	}
+
+	// Account standing
+	if account.Status != "active" || (account.AccountType != "checking" && account.AccountType != "savings") {
+		return &EligibilityError{Reason: "Plans are only offered on active checking and savings accounts"}
+	}
+	if account.Currency != Currency {
+		return &EligibilityError{Reason: "Plans are only offered in " + Currency}
+	}
+	if account.Balance < 0 {
+		return &EligibilityError{Reason: "Overdrawn accounts are not eligible"}
+	}
+	var behind int64
+	err := db.Model(&models.InstallmentPlan{}).
+		Where("customer_id = ? AND status = ? AND missed_collections > 0", account.CustomerID, StatusActive).Count(&behind).Error
+	if err != nil {
+		return err
+	}
+	if behind > 0 {
+		return &EligibilityError{Reason: "Customer has an installment plan with missed collections"}
+	}
+	return nil
+}
+
+// Convert turns an eligible transaction into a plan inside an open transaction
+// A zero-interest loan is opened for the amount and disbursed straight back to the account, then the fee
+// is charged. accountNumber is the number of the new loan account
+func Convert(tx *gorm.DB, cfg Config, t models.Transaction, account models.Account, months int, planNumber, accountNumber, by string, now time.Time) (models.InstallmentPlan, error) {
+	plan := models.InstallmentPlan{
+		TenantID:       account.TenantID,
+		PlanNumber:     planNumber,
+		CustomerID:     account.CustomerID,
+		AccountID:      account.ID,
+		TransactionID:  t.ID,
+		Amount:         t.Amount,
+		Fee:            money.Cents(t.Amount * cfg.FeePercent / 100),
+		Months:         months,
+		MonthlyPayment: money.Cents(t.Amount / float64(months)),
+		Status:         StatusActive,
+	}
+
+	loan := models.Loan{
+		TenantID:         account.TenantID,
+		LoanNumber:       planNumber,
+		CustomerID:       account.CustomerID,
+		PrincipalAmount:  plan.Amount,
+		InterestRate:     0,
+		LoanTerm:         months,
+		Status:           loans.StatusPending,
+		RemainingBalance: plan.Amount,
+		MonthlyPayment:   plan.MonthlyPayment,
+	}
+	if err := tx.Create(&loan).Error; err != nil {
+		return plan, err
+	}
+	if err := statushistory.Created(tx, loan.TenantID, statushistory.SubjectLoan, loan.ID, loan.Status, by); err != nil {
+		return plan, err
+	}
+	if err := loans.AddBorrower(tx, loan, by); err != nil {
+		return plan, err
+	}
+	if _, err := loans.Disburse(tx, &loan, accountNumber, by, now); err != nil {
+		return plan, err
+	}
+	plan.LoanID = loan.ID
+
+	// The disbursement paid out of cash; the credit back to the account pays it in again
+	credit := models.Transaction{
+		AccountID:       account.ID,
+		TransactionType: "deposit",
+		Amount:          plan.Amount,
+		Description:     fmt.Sprintf("Installment plan %s: credit of %s", planNumber, t.TransactionID),
+		Reference:       planNumber,
+		Channel:         enrichment.DefaultChannel,
+	}
+	if _, err := ledger.PostExternal(tx, &credit, nil); err != nil {
+		return plan, err
+	}
+	plan.CreditTransactionID = credit.ID
+
+	if plan.Fee > 0 {
+		fee := models.Transaction{
+			AccountID:       account.ID,
+			TransactionType: ledger.TypeFee,
+			Amount:          plan.Fee,
+			Description:     "Installment plan fee " + planNumber,
+			Reference:       planNumber,
+			Channel:         enrichment.DefaultChannel,
+		}
+		if _, err := ledger.PostExternal(tx, &fee, nil); err != nil {
+			return plan, err
+		}
+		plan.FeeTransactionID = &fee.ID
+	}
+
+	next := now.AddDate(0, 1, 0)
+	plan.NextDueDate = &next
+	return plan, tx.Create(&plan).Error
+}
+
+// Collect takes the plan's next installment from its account inside an open transaction
+// The last installment takes whatever remains on the backing loan. The payment records the due date
+// it was scheduled for alongside the date it was taken
+func Collect(tx *gorm.DB, plan *models.InstallmentPlan, featureFlags *flags.Store, now time.Time) (models.LoanPayment, models.Account, error) {
+	var loan models.Loan
+	if err := tx.First(&loan, plan.LoanID).Error; err != nil {
+		return models.LoanPayment{}, models.Account{}, err
+	}
+	amount := math.Min(plan.MonthlyPayment, loan.RemainingBalance)
+	if plan.PaidInstallments+1 >= plan.Months {
+		amount = loan.RemainingBalance
+	}
+	var scheduled time.Time
+	if plan.NextDueDate != nil {
+		scheduled = *plan.NextDueDate
+	}
+	payment, account, err := pay(tx, plan, &loan, amount, statushistory.SystemActor, featureFlags, now)
+	if err != nil || scheduled.IsZero() {
+		return payment, account, err
+	}
+	payment.ScheduledDate = &scheduled
+	return payment, account, tx.Model(&payment).Update("scheduled_date", scheduled).Error
+}
+
+// PayOff settles the plan's remaining balance early, from its account, inside an open transaction
+func PayOff(tx *gorm.DB, plan *models.InstallmentPlan, by string, featureFlags *flags.Store, now time.Time) (models.LoanPayment, models.Account, error) {
+	var loan models.Loan
+	if err := tx.First(&loan, plan.LoanID).Error; err != nil {
+		return models.LoanPayment{}, models.Account{}, err
+	}
+	return pay(tx, plan, &loan, loan.RemainingBalance, by, featureFlags, now)
+}
+
+// pay collects amount through the backing loan and advances the plan's schedule
+func pay(tx *gorm.DB, plan *models.InstallmentPlan, loan *models.Loan, amount float64, by string, featureFlags *flags.Store, now time.Time) (models.LoanPayment, models.Account, error) {
+	if plan.Status != StatusActive {
+		return models.LoanPayment{}, models.Account{}, ErrPlanClosed
+	}
+	payment, account, err := loans.Pay(tx, loan, plan.AccountID, amount, by, featureFlags)
+	if err != nil {
+		return payment, account, err
+	}
+
+	updates := map[string]interface{}{
+		"paid_installments": plan.PaidInstallments + 1,
+		"last_error":        "",
+	}
+	if loan.Status == "paid_off" {
+		updates["status"] = StatusPaidOff
+		updates["next_due_date"] = nil
+		updates["paid_off_at"] = now
+	} else {
+		updates["next_due_date"] = plan.NextDueDate.AddDate(0, 1, 0)
+	}
+	if err := tx.Model(plan).Updates(updates).Error; err != nil {
+		return payment, account, err
+	}
+	return payment, account, tx.First(plan, plan.ID).Error
+}
+
+// lookahead is how far ahead of its due date an installment can be collected under the preceding
+// convention - longer than any run of weekends and holidays
+const lookahead = 7
+
+// CollectDue collects every installment due by now; a failed collection is counted and retried on the next run
+// A due date on a weekend or holiday moves to the next business day, or the previous one under the preceding
+// convention; the schedule itself keeps its dates, so later installments are not pushed along
+func CollectDue(db *gorm.DB, featureFlags *flags.Store, now time.Time, convention string) (collected, missed int, err error) {
+	calendar, err := businessdays.Load(db)
+	if err != nil {
+		return 0, 0, err
+	}
+	var plans []models.InstallmentPlan
+	err = db.Where("status = ? AND next_due_date <= ?", StatusActive, now.AddDate(0, 0, lookahead)).Order("id").Find(&plans).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	for i := range plans {
+		plan := &plans[i]
+		if calendar.Adjust(*plan.NextDueDate, convention).After(now) {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			_, _, err := Collect(tx, plan, featureFlags, now)
+			return err
+		})
+		if err == nil {
+			collected++
+			continue
+		}
+		missed++
+		log.Printf("installments: collection for plan %s failed: %v", plan.PlanNumber, err)
+		db.Model(plan).Updates(map[string]interface{}{
+			"missed_collections": gorm.Expr("missed_collections + 1"),
+			"last_error":         err.Error(),
+		})
+	}
+	return collected, missed, nil
+}
@@ -0,0 +1,254 @@
+package interest
+
+import (
+	"banking-app/businessdays"
+	"banking-app/cache"
+	"banking-app/enrichment"
+	"banking-app/finance"
+	"banking-app/flags"
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/money"
+	"banking-app/statements"
+	"banking-app/tenancy"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Accrue adds each day's interest on the open accounts of every product with a rate, for the days since each
+// account last accrued through yesterday, however many nights were missed. A day earns the rate in force on it, so
+// a change applies from its effective date exactly. Interest is the end-of-day balance by effective date times the
+// rate over 365, rounded to cents per day; the month's accrued interest is posted as an interest credit at the end
+// of its last day. Each day's interest is booked as it accrues, against interest expense and owed on accrued
+// interest, and the month-end credit settles what was owed, so the books carry the liability before it reaches
+// customers. It returns how many accounts accrued; an account that fails is logged and retried next run from the
+// first day it did not save. Month-end postings are written through to the balance cache as each account commits
+func Accrue(db *gorm.DB, featureFlags *flags.Store, balances *cache.Balances, now time.Time) (int, error) {
+	var rates []models.ProductRate
+	if err := db.Order("tenant_id, account_type, effective_date").Find(&rates).Error; err != nil {
+		return 0, err
+	}
+	today := ledger.StartOfDay(now)
+	accrued := 0
+	for start := 0; start < len(rates); {
+		end := start
+		for end < len(rates) && rates[end].TenantID == rates[start].TenantID && rates[end].AccountType == rates[start].AccountType {
+			end++
+		}
+		schedule := rates[start:end]
+		start = end
+
+		scoped := db.WithContext(tenancy.NewContext(db.Statement.Context, schedule[0].TenantID))
+		var accounts []models.Account
+		err := scoped.Where("account_type = ? AND status <> ?", schedule[0].AccountType, "closed").Order("id").Find(&accounts).Error
+		if err != nil {
+			return accrued, err
+		}
+		for _, account := range accounts {
+			ok, err := accrueAccount(scoped, featureFlags, balances, account, schedule, today)
+			if err != nil {
+				log.Printf("interest: accrual for account %s failed: %v", account.AccountNumber, err)
+				continue
+			}
+			if ok {
+				accrued++
+			}
+		}
+	}
+	return accrued, nil
+}
+
+// AccrueAccount brings one account's accrual up to today at its product's rates, as the nightly run would; a
+// product without rates accrues nothing. It is run before an account changes product, so the days before the
+// change earn the old product's rates
+func AccrueAccount(db *gorm.DB, featureFlags *flags.Store, account models.Account, now time.Time) error {
+	var schedule []models.ProductRate
+	if err := db.Where("account_type = ?", account.AccountType).Order("effective_date").Find(&schedule).Error; err != nil {
+		return err
+	}
+	if len(schedule) == 0 {
+		return nil
+	}
+	_, err := accrueAccount(db, featureFlags, nil, account, schedule, ledger.StartOfDay(now))
+	return err
+}
+
+// PostAccrued brings an account's accrual up to today and credits the interest it has accrued this month at once,
+// effective at the end of yesterday, as a closure does before sweeping the balance. It returns the account after
+// any credit
+func PostAccrued(tx *gorm.DB, featureFlags *flags.Store, account models.Account, now time.Time) (models.Account, error) {
+	if err := AccrueAccount(tx, featureFlags, account, now); err != nil {
+		return account, err
+	}
+	if err := tx.First(&account, account.ID).Error; err != nil {
+		return account, err
+	}
+	if account.AccruedInterest <= 0 {
+		return account, nil
+	}
+	after, err := post(tx, featureFlags, account, ledger.StartOfDay(now).AddDate(0, 0, -1), account.AccruedInterest)
+	if err != nil {
+		return after, err
+	}
+	after.AccruedInterest = 0
+	return after, tx.Model(&models.Account{}).Where("id = ?", account.ID).Update("accrued_interest", 0).Error
+}
+
+// catchUpDays is how many days of one account are accrued in a database transaction, so an account catching up
+// after a long outage holds the write queue for a month of days at a time rather than the whole gap
+const catchUpDays = 31
+
+// accrueAccount accrues one account day by day up to today, reporting whether any day was accrued
+// Each run of up to catchUpDays days saves its days, the accrued total and any month-end posting together, so a
+// failure leaves the account as the last saved run left it. balances may be nil when the caller refreshes the
+// cached balance itself
+func accrueAccount(db *gorm.DB, featureFlags *flags.Store, balances *cache.Balances, account models.Account, schedule []models.ProductRate, today time.Time) (bool, error) {
+	day := ledger.StartOfDay(account.CreatedAt)
+	if account.InterestAccruedThrough != nil {
+		day = *account.InterestAccruedThrough
+	}
+	// Days before the product's first rate earn nothing, so an account that has never accrued starts there
+	if first := ledger.StartOfDay(schedule[0].EffectiveDate); account.InterestAccruedThrough == nil && day.Before(first) {
+		day = first
+	}
+	if !day.Before(today) {
+		return false, nil
+	}
+
+	accrued := false
+	for day.Before(today) {
+		through := day.AddDate(0, 0, catchUpDays)
+		if through.After(today) {
+			through = today
+		}
+		var posted *models.Account
+		total := account.AccruedInterest
+		next := day
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for ; next.Before(through); next = ledger.DayAfter(next) {
+				balance, err := statements.BalanceAt(tx, account.ID, next)
+				if err != nil {
+					return err
+				}
+				before := total
+				total = money.Cents(total + finance.DailyInterest(balance.Balance, rateOn(schedule, next)))
+				if err := book(tx, account, next, money.Cents(total-before)); err != nil {
+					return err
+				}
+				if businessdays.In(ledger.DayAfter(next)).Day() == 1 && total > 0 {
+					after, err := post(tx, featureFlags, account, next, total)
+					if err != nil {
+						return err
+					}
+					posted, total = &after, 0
+				}
+			}
+			return tx.Model(&models.Account{}).Where("id = ?", account.ID).
+				Updates(map[string]interface{}{"accrued_interest": total, "interest_accrued_through": next}).Error
+		})
+		if err != nil {
+			return accrued, err
+		}
+		if posted != nil && balances != nil {
+			balances.Set(cache.Entry(*posted))
+		}
+		day, accrued = next, true
+		account.AccruedInterest, account.InterestAccruedThrough = total, &next
+	}
+	return accrued, nil
+}
+
+// rateOn returns the rate of a schedule ordered by effective date in force on a day
+func rateOn(schedule []models.ProductRate, day time.Time) float64 {
+	rate := 0.0
+	for _, r := range schedule {
+		if r.EffectiveDate.After(day) {
+			break
+		}
+		rate = r.Rate
+	}
+	return rate
+}
+
+// book records one day's accrued interest against interest expense, owed on accrued interest until it is credited
+// Both entries name the account as their counterparty, which is what attributes the liability to it
+func book(tx *gorm.DB, account models.Account, day time.Time, amount float64) error {
+	if amount <= 0 {
+		return nil
+	}
+	expense, err := gl.Account(tx, account.TenantID, gl.InterestExpense, account.Currency)
+	if err != nil {
+		return err
+	}
+	accrued, err := gl.Account(tx, account.TenantID, gl.AccruedInterest, account.Currency)
+	if err != nil {
+		return err
+	}
+	entry := func(accountID uint, transactionType string) *models.Transaction {
+		return &models.Transaction{
+			AccountID:             accountID,
+			TransactionType:       transactionType,
+			Amount:                amount,
+			Description:           "Interest accrued on " + account.AccountNumber + " for " + businessdays.Format(day),
+			Reference:             fmt.Sprintf("ACR-%s-%s", account.AccountNumber, businessdays.In(day).Format("20060102")),
+			Channel:               enrichment.DefaultChannel,
+			EffectiveDate:         ledger.DayAfter(day).Add(-time.Second),
+			CounterpartyAccountID: &account.ID,
+		}
+	}
+	_, err = ledger.PostLegs(tx, []ledger.Leg{{Transaction: entry(expense.ID, "withdrawal")}, {Transaction: entry(accrued.ID, "deposit")}}, nil)
+	return err
+}
+
+// Owed returns the interest on the books as accrued to an account and not yet credited: the entries on accrued
+// interest naming it, and the settlements of its interest credits
+func Owed(db *gorm.DB, account models.Account) (float64, error) {
+	var owed float64
+	err := db.Model(&models.Transaction{}).Select("COALESCE(SUM("+ledger.SignedAmountOf("transactions")+"), 0)").
+		Joins("JOIN accounts accrued ON accrued.id = transactions.account_id").
+		Where("accrued.account_number = ?", gl.Number(account.TenantID, gl.AccruedInterest, account.Currency)).
+		Where("(transactions.counterparty_account_id = ? OR transactions.offset_of_id IN (?))",
+			account.ID, db.Model(&models.Transaction{}).Select("id").Where("account_id = ?", account.ID)).
+		Scan(&owed).Error
+	return money.Cents(owed), err
+}
+
+// post credits a month's accrued interest at the end of its last day, returning the account after it
+// The credit settles what the books owe the account on accrued interest; any more, accrued before interest was
+// booked daily, is expensed as it is credited
+func post(tx *gorm.DB, featureFlags *flags.Store, account models.Account, lastDay time.Time, amount float64) (models.Account, error) {
+	posting := models.Transaction{
+		AccountID:       account.ID,
+		TransactionType: ledger.TypeInterest,
+		Amount:          amount,
+		Description:     "Interest for " + businessdays.In(lastDay).Format("January 2006"),
+		Reference:       fmt.Sprintf("INT-%s-%s", account.AccountNumber, businessdays.In(lastDay).Format("200601")),
+		Channel:         enrichment.DefaultChannel,
+		EffectiveDate:   ledger.DayAfter(lastDay).Add(-time.Second),
+	}
+	owed, err := Owed(tx, account)
+	if err != nil {
+		return models.Account{}, err
+	}
+	after, err := ledger.Post(tx, &posting, featureFlags)
+	if err != nil {
+		return after, err
+	}
+	settled := math.Max(0, math.Min(posting.Amount, owed))
+	if settled > 0 {
+		if _, err := ledger.OffsetTo(tx, posting, after, gl.AccruedInterest, settled); err != nil {
+			return after, err
+		}
+	}
+	if rest := money.Cents(posting.Amount - settled); rest > 0 {
+		if _, err := ledger.OffsetTo(tx, posting, after, gl.InterestExpense, rest); err != nil {
+			return after, err
+		}
+	}
+	return after, nil
+}
@@ -0,0 +1,79 @@
+package interest
+
+import (
+	"banking-app/clock"
+	"banking-app/database"
+	"banking-app/ledger"
+	"banking-app/models"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+func TestAccrueCatchesUpEveryMissedDay(t *testing.T) {
+	opened := time.Date(2026, time.January, 10, 9, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(opened)
+	clock.Use(fake)
+	t.Cleanup(func() { clock.Use(clock.System{}) })
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "interest.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	db.Logger = logger.Default.LogMode(logger.Silent)
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	// 3.65% on 10,000 earns a dollar a day, and still does on the 10,022 after January's interest is credited
+	rate := models.ProductRate{AccountType: "savings", Rate: 0.0365, EffectiveDate: time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC), ScheduledBy: "tester"}
+	if err := db.Create(&rate).Error; err != nil {
+		t.Fatal(err)
+	}
+	customer := models.Customer{FirstName: "Idle", LastName: "Saver", Email: "saver@example.test", DateOfBirth: "1980-01-01", Status: "active"}
+	if err := db.Create(&customer).Error; err != nil {
+		t.Fatal(err)
+	}
+	account := models.Account{CustomerID: customer.ID, AccountNumber: "INT-GAP", AccountType: "savings", Currency: "USD", Status: "active"}
+	if err := db.Create(&account).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ledger.Post(db, &models.Transaction{AccountID: account.ID, TransactionType: "deposit", Amount: 10000}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The job goes down the night the account opens and misses 40 nights, past a month end
+	now := opened.AddDate(0, 0, 40)
+	fake.Set(now)
+	for run := 1; run <= 2; run++ {
+		accrued, err := Accrue(db, nil, nil, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[int]int{1: 1, 2: 0}[run]; accrued != want {
+			t.Errorf("run %d accrued %d accounts, want %d", run, accrued, want)
+		}
+	}
+
+	var stored models.Account
+	db.First(&stored, account.ID)
+	if through := stored.InterestAccruedThrough; through == nil || !through.Equal(ledger.StartOfDay(now)) || stored.AccruedInterest != 18 {
+		t.Errorf("accrued %v through %v, want 18 for February 1 to 18 through %v", stored.AccruedInterest, through, ledger.StartOfDay(now))
+	}
+	var credits []models.Transaction
+	db.Where("account_id = ? AND transaction_type = ?", account.ID, ledger.TypeInterest).Find(&credits)
+	if len(credits) != 1 || credits[0].Amount != 22 || credits[0].Reference != "INT-INT-GAP-202601" {
+		t.Errorf("interest credits %+v, want 22 for January 10 to 31", credits)
+	}
+	var days int64
+	db.Model(&models.Transaction{}).Where("counterparty_account_id = ? AND reference LIKE ? AND transaction_type = ?", account.ID, "ACR-INT-GAP-%", "deposit").Count(&days)
+	if days != 40 {
+		t.Errorf("%d days booked to accrued interest, want all 40", days)
+	}
+}
@@ -0,0 +1,154 @@
+package interest
+
+import (
+	"banking-app/businessdays"
+	"banking-app/communications"
+	"banking-app/display"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/notifications"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultDecreaseNoticeDays is how far ahead customers must be told of a rate decrease unless configured
+const DefaultDecreaseNoticeDays = 30
+
+// Rate change errors - handlers map these to client responses
+var (
+	ErrRate          = errors.New("rate must be at least 0 and below 1")
+	ErrEffectiveDate = errors.New("effective_date must be after today")
+	ErrOutOfOrder    = errors.New("a rate change must take effect after every change already scheduled for the product")
+	ErrUnchanged     = errors.New("rate is already the product's rate on that date")
+)
+
+// NoticeError refuses a rate decrease that would give account holders less than the required notice
+type NoticeError struct {
+	NoticeDays int
+	Earliest   time.Time // First effective date the decrease may have
+}
+
+func (e *NoticeError) Error() string {
+	return fmt.Sprintf("a rate decrease needs %d days' notice, so effective_date must be %s or later", e.NoticeDays, businessdays.Format(e.Earliest))
+}
+
+// Config holds rate change settings
+type Config struct {
+	DecreaseNoticeDays int
+}
+
+// ConfigFromEnv reads RATE_DECREASE_NOTICE_DAYS (default 30)
+func ConfigFromEnv() Config {
+	cfg := Config{DecreaseNoticeDays: DefaultDecreaseNoticeDays}
+	if raw := os.Getenv("RATE_DECREASE_NOTICE_DAYS"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+			cfg.DecreaseNoticeDays = n
+		} else {
+			log.Printf("interest: ignoring invalid RATE_DECREASE_NOTICE_DAYS %q", raw)
+		}
+	}
+	return cfg
+}
+
+// On returns the rate an account type earns on a day: the latest change effective on or before it, or 0
+func On(db *gorm.DB, accountType string, day time.Time) (float64, error) {
+	var rate models.ProductRate
+	err := db.Where("account_type = ? AND effective_date <= ?", accountType, ledger.StartOfDay(day)).
+		Order("effective_date DESC").Limit(1).Find(&rate).Error
+	return rate.Rate, err
+}
+
+// Upcoming returns the next change to an account type's rate after a day, or nil when none is scheduled
+func Upcoming(db *gorm.DB, accountType string, day time.Time) (*models.ProductRate, error) {
+	var rate models.ProductRate
+	err := db.Where("account_type = ? AND effective_date > ?", accountType, ledger.StartOfDay(day)).
+		Order("effective_date").Limit(1).Find(&rate).Error
+	if err != nil || rate.ID == 0 {
+		return nil, err
+	}
+	return &rate, nil
+}
+
+// Schedule records a change to a product's rate and tells the holder of every open account of the product
+// The effective date must be after today and after every change already scheduled, and a decrease must be at
+// least the notice period away. Holders without a verified email are logged instead
+func Schedule(db *gorm.DB, cfg Config, product models.Product, change *models.ProductRate, now time.Time) error {
+	if change.Rate < 0 || change.Rate >= 1 {
+		return ErrRate
+	}
+	today := ledger.StartOfDay(now)
+	change.EffectiveDate = ledger.StartOfDay(change.EffectiveDate)
+	if !change.EffectiveDate.After(today) {
+		return ErrEffectiveDate
+	}
+	change.ID, change.ProductID, change.AccountType = 0, product.ID, product.AccountType
+	change.NoticeDays = int(math.Round(change.EffectiveDate.Sub(today).Hours() / 24))
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var latest models.ProductRate
+		if err := tx.Where("account_type = ?", product.AccountType).Order("effective_date DESC").Limit(1).Find(&latest).Error; err != nil {
+			return err
+		}
+		if latest.ID != 0 && !change.EffectiveDate.After(latest.EffectiveDate) {
+			return ErrOutOfOrder
+		}
+		change.PreviousRate = latest.Rate
+		if change.Rate == change.PreviousRate {
+			return ErrUnchanged
+		}
+		if earliest := ledger.StartOfDay(today.AddDate(0, 0, cfg.DecreaseNoticeDays)); change.Rate < change.PreviousRate && change.EffectiveDate.Before(earliest) {
+			return &NoticeError{NoticeDays: cfg.DecreaseNoticeDays, Earliest: earliest}
+		}
+		if err := tx.Create(change).Error; err != nil {
+			return err
+		}
+
+		var accounts []models.Account
+		if err := tx.Preload("Customer").Where("account_type = ? AND status <> ?", product.AccountType, "closed").Order("id").Find(&accounts).Error; err != nil {
+			return err
+		}
+		for _, account := range accounts {
+			if account.Customer.Email == "" || !account.Customer.EmailVerified {
+				log.Printf("interest: customer %d has no verified email for the rate change %d", account.CustomerID, change.ID)
+				continue
+			}
+			err := notifications.Enqueue(tx, &models.Notification{
+				CustomerID:   account.CustomerID,
+				Channel:      "email",
+				ResourceType: communications.ResourceRateChange,
+				ResourceID:   change.ID,
+				Recipient:    account.Customer.Email,
+				Subject:      "The interest rate on your " + product.Name + " account is changing",
+				Body:         notice(product, account, *change),
+			})
+			if err != nil {
+				return err
+			}
+			change.Notified++
+		}
+		return tx.Model(change).Update("notified", change.Notified).Error
+	})
+}
+
+// notice is the message telling an account holder of a rate change
+func notice(product models.Product, account models.Account, change models.ProductRate) string {
+	direction := "increase"
+	if change.Rate < change.PreviousRate {
+		direction = "decrease"
+	}
+	return fmt.Sprintf("The interest rate on your %s account %s will %s from %s to %s a year on %s. "+
+		"Interest up to the day before is earned at the current rate.", product.Name, display.MaskAccountNumber(account.AccountNumber),
+		direction, Percent(change.PreviousRate), Percent(change.Rate), businessdays.Format(change.EffectiveDate))
+}
+
+// Percent formats an annual rate as a percentage, e.g. 0.0425 as 4.25%
+func Percent(rate float64) string {
+	return strconv.FormatFloat(math.Round(rate*1e6)/1e4, 'f', -1, 64) + "%"
+}
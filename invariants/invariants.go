@@ -0,0 +1,126 @@
+package invariants
+
+import (
+	"banking-app/metrics"
+	"banking-app/models"
+	"banking-app/money"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+
+	"gorm.io/gorm"
+)
+
+// exempt lists the account types whose balances may run negative without limit
+// Loan and credit line balances are the amount owed, and internal ledger accounts carry debit balances.
+// The types are spelled out because the packages that own them post through the ledger, which imports this one
+var exempt = map[string]bool{
+	"loan":        true,
+	"credit_line": true,
+	"internal":    true,
+}
+
+// Guard errors
+var (
+	ErrBelowFloor         = errors.New("posting would take the account below its allowed balance")
+	ErrDirectBalanceWrite = errors.New("account balances may only be written by the ledger")
+)
+
+// writerKey marks the context of the one statement allowed to write accounts.balance
+type writerKey struct{}
+
+// Patterns for spotting hand-written SQL that assigns accounts.balance, however it is quoted
+var (
+	accountsUpdate    = regexp.MustCompile("(?is)\\bupdate\\s+[\"`]?accounts[\"`]?\\s+set\\b")
+	whereClause       = regexp.MustCompile(`(?i)\bwhere\b`)
+	balanceAssignment = regexp.MustCompile("(?i)(^|[\\s,.\"`])balance[\"`]?\\s*=")
+)
+
+// Floor is the lowest balance an account may be taken to, and false for types without one
+// The overdraft limit counts whether or not its flag is on: the flag decides what the funds check offers,
+// the floor is the backstop behind it
+func Floor(account models.Account) (float64, bool) {
+	if exempt[account.AccountType] {
+		return 0, false
+	}
+	return 0 - account.OverdraftLimit, true // Not -limit, which prints as -0.00 without an overdraft
+}
+
+// SetBalance writes an account's new balance, the only path by which accounts.balance changes after creation
+// account carries the new balance and before the stored one. A write that takes the account below its floor is
+// refused, unless it raises a balance that was already below it, and logged with what caused it
+func SetBalance(tx *gorm.DB, account models.Account, before float64, cause models.Transaction) error {
+	if floor, ok := Floor(account); ok && money.Cents(account.Balance) < money.Cents(floor) && money.Cents(account.Balance) < money.Cents(before) {
+		log.Printf("invariants: refused balance write tenant=%d account=%d number=%s type=%s before=%.2f after=%.2f floor=%.2f "+
+			"transaction=%q type=%s amount=%.2f reference=%q",
+			account.TenantID, account.ID, account.AccountNumber, account.AccountType, before, account.Balance, floor,
+			cause.TransactionID, cause.TransactionType, cause.Amount, cause.Reference)
+		metrics.NewCounter(`invariant_guard_rejections_total{kind="balance_floor"}`, "Balance writes refused by the invariant guard").Inc()
+		return ErrBelowFloor
+	}
+	ctx := context.WithValue(tx.Statement.Context, writerKey{}, true)
+	return tx.Session(&gorm.Session{NewDB: true, Context: ctx}).
+		Exec("UPDATE accounts SET balance = ? WHERE id = ?", account.Balance, account.ID).Error
+}
+
+// RegisterCallbacks refuses every balance write that does not come through SetBalance
+// Account.Balance is create-only in its gorm tag, so model updates and saves skip it; these callbacks cover the
+// rest: raw SQL, and updates addressed to the accounts table by name rather than through the model
+func RegisterCallbacks(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Raw().Before("gorm:raw").Register("invariants:raw", guardRaw); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").Register("invariants:row", guardRaw); err != nil {
+		return err
+	}
+	if err := callbacks.Query().Before("gorm:query").Register("invariants:query", guardRaw); err != nil {
+		return err
+	}
+	return callbacks.Update().Before("gorm:update").Register("invariants:update", guardUpdate)
+}
+
+// guardRaw refuses hand-written SQL that assigns accounts.balance outside SetBalance
+func guardRaw(tx *gorm.DB) {
+	if tx.Error != nil || tx.Statement.SQL.Len() == 0 || !assignsBalance(tx.Statement.SQL.String()) {
+		return
+	}
+	refuse(tx, tx.Statement.SQL.String())
+}
+
+// assignsBalance reports whether a statement sets accounts.balance; a balance in its WHERE clause does not count
+func assignsBalance(sql string) bool {
+	start := accountsUpdate.FindStringIndex(sql)
+	if start == nil {
+		return false
+	}
+	set := sql[start[1]:]
+	if end := whereClause.FindStringIndex(set); end != nil {
+		set = set[:end[0]]
+	}
+	return balanceAssignment.MatchString(set)
+}
+
+// guardUpdate refuses balance updates made with Table("accounts"), which carry no model and so no field permissions
+func guardUpdate(tx *gorm.DB) {
+	if tx.Error != nil || tx.Statement.Schema != nil || tx.Statement.Table != "accounts" {
+		return
+	}
+	if values, ok := tx.Statement.Dest.(map[string]interface{}); ok {
+		if _, ok := values["balance"]; ok {
+			refuse(tx, fmt.Sprintf("UPDATE accounts %v", values))
+		}
+	}
+}
+
+// refuse fails a statement unless it runs on the context SetBalance marks
+func refuse(tx *gorm.DB, statement string) {
+	if allowed, _ := tx.Statement.Context.Value(writerKey{}).(bool); allowed {
+		return
+	}
+	log.Printf("invariants: refused direct balance write: %s", statement)
+	metrics.NewCounter(`invariant_guard_rejections_total{kind="direct_write"}`, "Balance writes refused by the invariant guard").Inc()
+	tx.AddError(ErrDirectBalanceWrite)
+}
@@ -0,0 +1,259 @@
+package invariants
+
+import (
+	"banking-app/clock"
+	"banking-app/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Violation statuses
+const (
+	StatusOpen     = "open"
+	StatusResolved = "resolved"
+)
+
+// Kinds of violation the scan looks for
+const (
+	KindNegativeBalance     = "negative_balance"     // Balance below the floor of an account type that has one
+	KindBalanceChain        = "balance_chain"        // Posting whose balance_before is not the previous posting's balance_after
+	KindStatementContinuity = "statement_continuity" // Statement whose opening balance is not the previous statement's closing balance
+)
+
+// SystemActor resolves violations the scan no longer finds
+const SystemActor = "system"
+
+// tolerance absorbs rounding in stored decimal amounts
+const tolerance = 0.005
+
+// ErrNotOpen is returned when resolving a violation that has already been resolved
+var ErrNotOpen = errors.New("violation is not open")
+
+// key identifies the same violation across scans
+type key struct {
+	kind          string
+	accountID     uint
+	transactionID uint
+	statementID   uint
+}
+
+// Scan checks every account and posting chain and records what it finds in the violation queue
+// Violations already open are refreshed rather than repeated, and open ones the scan no longer finds are resolved.
+// Returns the number of new violations
+func Scan(db *gorm.DB, now time.Time) (int, error) {
+	found, err := find(db)
+	if err != nil {
+		return 0, err
+	}
+
+	var open []models.InvariantViolation
+	if err := db.Where("status = ?", StatusOpen).Find(&open).Error; err != nil {
+		return 0, err
+	}
+	existing := make(map[key]models.InvariantViolation, len(open))
+	for _, v := range open {
+		existing[keyOf(v)] = v
+	}
+
+	created := 0
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, v := range found {
+			k := keyOf(v)
+			if prior, ok := existing[k]; ok {
+				delete(existing, k)
+				err := tx.Model(&prior).Updates(map[string]interface{}{"expected": v.Expected, "actual": v.Actual, "detail": v.Detail, "last_seen_at": now}).Error
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			v.Status = StatusOpen
+			v.DetectedAt = now
+			v.LastSeenAt = now
+			if err := tx.Create(&v).Error; err != nil {
+				return err
+			}
+			created++
+		}
+		for _, prior := range existing {
+			v := prior
+			if err := Resolve(tx, &v, SystemActor, "No longer found by the invariant scan"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return created, err
+}
+
+// find lists the violations present now, without touching the queue
+func find(db *gorm.DB) ([]models.InvariantViolation, error) {
+	var found []models.InvariantViolation
+
+	var accounts []models.Account
+	err := db.Where("account_type NOT IN ? AND balance < -overdraft_limit - ?", exemptTypes(), tolerance).Order("id").Find(&accounts).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		floor, _ := Floor(account)
+		found = append(found, models.InvariantViolation{
+			TenantID:      account.TenantID,
+			Kind:          KindNegativeBalance,
+			AccountID:     account.ID,
+			AccountNumber: account.AccountNumber,
+			Expected:      floor,
+			Actual:        account.Balance,
+			Detail:        fmt.Sprintf("%s account balance %.2f is below its floor of %.2f", account.AccountType, account.Balance, floor),
+		})
+	}
+
+	// Postings on an account are numbered in the order they were made, so each one starts where the one before ended
+	type link struct {
+		ID            uint
+		TenantID      uint
+		AccountID     uint
+		AccountNumber string
+		BalanceBefore float64
+		Previous      float64
+	}
+	chain := db.Model(&models.Transaction{}).
+		Select("id, tenant_id, account_id, balance_before, LAG(balance_after) OVER (PARTITION BY account_id ORDER BY id) AS previous")
+	var breaks []link
+	err = db.Table("(?) AS chain", chain).
+		Select("chain.*, accounts.account_number").
+		Joins("JOIN accounts ON accounts.id = chain.account_id").
+		Where("chain.previous IS NOT NULL AND ABS(chain.balance_before - chain.previous) > ?", tolerance).
+		Order("chain.id").Scan(&breaks).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range breaks {
+		id := b.ID
+		found = append(found, models.InvariantViolation{
+			TenantID:      b.TenantID,
+			Kind:          KindBalanceChain,
+			AccountID:     b.AccountID,
+			AccountNumber: b.AccountNumber,
+			TransactionID: &id,
+			Expected:      b.Previous,
+			Actual:        b.BalanceBefore,
+			Detail:        fmt.Sprintf("Posting %d starts from %.2f but the posting before it ended at %.2f", b.ID, b.BalanceBefore, b.Previous),
+		})
+	}
+
+	// Each archived statement opens where the one numbered before it closed
+	type statementLink struct {
+		ID            uint
+		TenantID      uint
+		AccountID     uint
+		AccountNumber string
+		Sequence      int
+		Opening       float64
+		Previous      float64
+	}
+	var gaps []statementLink
+	err = db.Table("statements AS s").
+		Select("s.id, s.tenant_id, s.account_id, accounts.account_number, s.sequence, s.opening_balance AS opening, p.closing_balance AS previous").
+		Joins("JOIN statements AS p ON p.account_id = s.account_id AND p.sequence = s.sequence - 1 AND p.deleted_at IS NULL").
+		Joins("JOIN accounts ON accounts.id = s.account_id").
+		Where("s.deleted_at IS NULL AND s.sequence > 1 AND ABS(s.opening_balance - p.closing_balance) > ?", tolerance).
+		Order("s.id").Scan(&gaps).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range gaps {
+		found = append(found, StatementContinuity(g.TenantID, g.AccountID, g.AccountNumber, g.ID, g.Sequence, g.Previous, g.Opening))
+	}
+	return found, nil
+}
+
+// StatementContinuity describes a statement that does not open where the previous statement in its account's
+// sequence closed
+func StatementContinuity(tenantID, accountID uint, accountNumber string, statementID uint, sequence int, previous, opening float64) models.InvariantViolation {
+	return models.InvariantViolation{
+		TenantID:      tenantID,
+		Kind:          KindStatementContinuity,
+		AccountID:     accountID,
+		AccountNumber: accountNumber,
+		StatementID:   &statementID,
+		Expected:      previous,
+		Actual:        opening,
+		Detail: fmt.Sprintf("Statement %d opens at %.2f but statement %d closed at %.2f",
+			sequence, opening, sequence-1, previous),
+	}
+}
+
+// Open adds a violation found outside the scan to the queue, or refreshes the same one if it is already open
+// Reports whether it was new
+func Open(tx *gorm.DB, v models.InvariantViolation, now time.Time) (bool, error) {
+	var open []models.InvariantViolation
+	if err := tx.Where("status = ? AND kind = ? AND account_id = ?", StatusOpen, v.Kind, v.AccountID).Find(&open).Error; err != nil {
+		return false, err
+	}
+	for _, prior := range open {
+		if keyOf(prior) != keyOf(v) {
+			continue
+		}
+		err := tx.Model(&prior).Updates(map[string]interface{}{"expected": v.Expected, "actual": v.Actual, "detail": v.Detail, "last_seen_at": now}).Error
+		return false, err
+	}
+	v.Status = StatusOpen
+	v.DetectedAt = now
+	v.LastSeenAt = now
+	return true, tx.Create(&v).Error
+}
+
+// exemptTypes lists the account types without a floor, for queries
+func exemptTypes() []string {
+	types := make([]string, 0, len(exempt))
+	for t := range exempt {
+		types = append(types, t)
+	}
+	return types
+}
+
+// keyOf is the identity of a violation across scans
+func keyOf(v models.InvariantViolation) key {
+	k := key{kind: v.Kind, accountID: v.AccountID}
+	if v.TransactionID != nil {
+		k.transactionID = *v.TransactionID
+	}
+	if v.StatementID != nil {
+		k.statementID = *v.StatementID
+	}
+	return k
+}
+
+// Resolve takes an open violation out of the queue
+// The update only matches a violation still open, so a concurrent resolution is reported as ErrNotOpen
+func Resolve(tx *gorm.DB, v *models.InvariantViolation, by, note string) error {
+	now := clock.Now()
+	result := tx.Model(&models.InvariantViolation{}).Where("id = ? AND status = ?", v.ID, StatusOpen).Updates(map[string]interface{}{
+		"status":          StatusResolved,
+		"resolved_at":     now,
+		"resolved_by":     by,
+		"resolution_note": note,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotOpen
+	}
+	v.Status = StatusResolved
+	v.ResolvedAt = &now
+	v.ResolvedBy = by
+	v.ResolutionNote = note
+	return nil
+}
+
+// OpenCount is the number of violations awaiting review
+func OpenCount(db *gorm.DB) (int64, error) {
+	var count int64
+	err := db.Model(&models.InvariantViolation{}).Where("status = ?", StatusOpen).Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,36 @@
+package investigations
+
+import (
+	"banking-app/businessdays"
+	"fmt"
+	"strings"
+)
+
+// DOT writes the graph in GraphViz's DOT language, e.g. for `dot -Tsvg`
+// label shows an account number; callers pass a masking function unless numbers may be revealed
+func DOT(g *Graph, label func(string) string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph flow {\n")
+	fmt.Fprintf(&b, "  label=%s;\n", quote(fmt.Sprintf("%s flow from %s, %d hops", g.Direction, g.TransactionID, g.Depth)))
+	b.WriteString("  rankdir=LR;\n  node [shape=box];\n")
+	for _, n := range g.Nodes {
+		attrs := ""
+		if n.AccountID == g.RootAccountID {
+			attrs = ", style=bold"
+		}
+		fmt.Fprintf(&b, "  a%d [label=%s%s];\n", n.AccountID, quote(fmt.Sprintf("%s\n%s, customer %d", label(n.AccountNumber), n.AccountType, n.CustomerID)), attrs)
+	}
+	for _, e := range g.Edges {
+		at := businessdays.In(e.CreatedAt).Format("2006-01-02 15:04:05")
+		fmt.Fprintf(&b, "  a%d -> a%d [label=%s];\n", e.FromAccountID, e.ToAccountID, quote(fmt.Sprintf("%.2f %s\n%s\ntransfer %d", e.Amount, e.Currency, at, e.TransferID)))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// quote writes s as a DOT string; newlines become centered line breaks
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
@@ -0,0 +1,296 @@
+package investigations
+
+import (
+	"banking-app/models"
+	"errors"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Directions a flow is traced in
+const (
+	Forward  = "forward"  // Where the money went next
+	Backward = "backward" // Where the money came from
+)
+
+// Bounds on a trace
+const (
+	DefaultDepth    = 3
+	MaxDepth        = 6
+	DefaultMaxNodes = 100
+	MaxNodes        = 500
+)
+
+// ErrInvalid is returned for a direction, depth or node limit outside the bounds above
+var ErrInvalid = errors.New("direction must be forward or backward, depth 1 to 6 and max_nodes 1 to 500")
+
+// Options bound a trace
+type Options struct {
+	Direction string
+	Depth     int // Hops from the starting account
+	MaxNodes  int // Accounts in the graph, the starting account included
+}
+
+// Validate fills in defaults and checks the bounds
+func (o *Options) Validate() error {
+	if o.Direction == "" {
+		o.Direction = Forward
+	}
+	if o.Depth == 0 {
+		o.Depth = DefaultDepth
+	}
+	if o.MaxNodes == 0 {
+		o.MaxNodes = DefaultMaxNodes
+	}
+	if (o.Direction != Forward && o.Direction != Backward) || o.Depth < 1 || o.Depth > MaxDepth || o.MaxNodes < 1 || o.MaxNodes > MaxNodes {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// Node is an account money passed through
+type Node struct {
+	AccountID     uint      `json:"account_id"`
+	AccountNumber string    `json:"account_number"`
+	CustomerID    uint      `json:"customer_id"`
+	AccountType   string    `json:"account_type"`
+	Currency      string    `json:"currency"`
+	Status        string    `json:"status"`
+	Depth         int       `json:"depth"`   // Hops from the starting account
+	Reached       time.Time `json:"reached"` // Forward: when money first arrived; backward: when it last left
+}
+
+// Edge is a transfer between two accounts in the graph
+type Edge struct {
+	TransferID          uint      `json:"transfer_id"`
+	FromAccountID       uint      `json:"from_account_id"`
+	ToAccountID         uint      `json:"to_account_id"`
+	Amount              float64   `json:"amount"`
+	Currency            string    `json:"currency"` // The debited account's currency
+	DebitTransactionID  uint      `json:"debit_transaction_id"`
+	CreditTransactionID uint      `json:"credit_transaction_id"`
+	CreatedAt           time.Time `json:"created_at"`
+	Depth               int       `json:"depth"` // Hop the transfer was followed on
+}
+
+// Graph is the money flow around a transaction
+type Graph struct {
+	TransactionID string `json:"transaction_id"`  // Starting transaction
+	RootAccountID uint   `json:"root_account_id"` // Account the walk starts from
+	Direction     string `json:"direction"`
+	Depth         int    `json:"depth"`
+	MaxNodes      int    `json:"max_nodes"`
+	Nodes         []Node `json:"nodes"`
+	Edges         []Edge `json:"edges"`
+	HiddenNodes   int    `json:"hidden_nodes"` // Accounts reached but not shown to the caller, and not walked through
+	Truncated     bool   `json:"truncated"`    // A node limit cut the walk short of its depth
+}
+
+// Visible decides whether the caller may see an account
+type Visible func(models.Account) bool
+
+// Trace walks transfers out of (or into) the starting transaction's account, hop by hop
+// A transfer leg starts the walk at its own transfer; any other posting starts at its account and time.
+// Money can only move on after it arrived, so forward hops follow transfers made at or after the
+// account was reached and backward hops those made at or before; each transfer is followed once,
+// which is what ends cycles
+func Trace(db *gorm.DB, start models.Transaction, opts Options, visible Visible) (*Graph, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	forward := opts.Direction == Forward
+	g := &Graph{TransactionID: start.TransactionID, Direction: opts.Direction, Depth: opts.Depth, MaxNodes: opts.MaxNodes, Nodes: []Node{}, Edges: []Edge{}}
+
+	var first models.Transfer
+	err := db.Where("debit_transaction_id = ? OR credit_transaction_id = ?", start.ID, start.ID).First(&first).Error
+	leg := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	g.RootAccountID = start.AccountID
+	reached := start.CreatedAt
+	if leg {
+		g.RootAccountID, reached = first.FromAccountID, first.CreatedAt
+		if !forward {
+			g.RootAccountID = first.ToAccountID
+		}
+	}
+
+	var root models.Account
+	if err := db.First(&root, g.RootAccountID).Error; err != nil {
+		return nil, err
+	}
+	if !visible(root) {
+		g.HiddenNodes = 1
+		return g, nil
+	}
+
+	w := &walk{db: db, g: g, forward: forward, visible: visible, max: opts.MaxNodes,
+		nodes: map[uint]int{}, followed: map[uint]bool{}, hidden: map[uint]bool{}, currency: map[uint]string{}}
+	w.add(root, 0, reached)
+
+	frontier, hop := []uint{root.ID}, 1
+	if leg {
+		if frontier, err = w.follow([]models.Transfer{first}, hop); err != nil {
+			return nil, err
+		}
+		hop++
+	}
+	for ; hop <= opts.Depth && len(frontier) > 0 && !g.Truncated; hop++ {
+		if frontier, err = w.step(frontier, hop); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if !g.Edges[i].CreatedAt.Equal(g.Edges[j].CreatedAt) {
+			return g.Edges[i].CreatedAt.Before(g.Edges[j].CreatedAt)
+		}
+		return g.Edges[i].TransferID < g.Edges[j].TransferID
+	})
+	g.HiddenNodes = len(w.hidden)
+	return g, nil
+}
+
+// walk is the state of one trace
+type walk struct {
+	db       *gorm.DB
+	g        *Graph
+	forward  bool
+	visible  Visible
+	max      int
+	nodes    map[uint]int    // Account ID to its index in g.Nodes
+	followed map[uint]bool   // Transfer IDs already in the graph
+	hidden   map[uint]bool   // Accounts the caller may not see
+	currency map[uint]string // Account currencies, for edges
+}
+
+// add puts an account in the graph
+func (w *walk) add(account models.Account, depth int, reached time.Time) {
+	w.nodes[account.ID] = len(w.g.Nodes)
+	w.currency[account.ID] = account.Currency
+	w.g.Nodes = append(w.g.Nodes, Node{
+		AccountID:     account.ID,
+		AccountNumber: account.AccountNumber,
+		CustomerID:    account.CustomerID,
+		AccountType:   account.AccountType,
+		Currency:      account.Currency,
+		Status:        account.Status,
+		Depth:         depth,
+		Reached:       reached,
+	})
+}
+
+// step follows the transfers leaving (or entering) the frontier accounts since they were reached
+func (w *walk) step(frontier []uint, hop int) ([]uint, error) {
+	var transfers []models.Transfer
+	for _, id := range frontier {
+		node := w.g.Nodes[w.nodes[id]]
+		query := w.db.Order("created_at, id").Limit(w.max + 1) // No account may contribute more edges than the graph has nodes
+		if w.forward {
+			query = query.Where("from_account_id = ? AND created_at >= ?", id, node.Reached)
+		} else {
+			query = query.Where("to_account_id = ? AND created_at <= ?", id, node.Reached)
+		}
+		var found []models.Transfer
+		if err := query.Find(&found).Error; err != nil {
+			return nil, err
+		}
+		if len(found) > w.max {
+			found, w.g.Truncated = found[:w.max], true
+		}
+		transfers = append(transfers, found...)
+	}
+	return w.follow(transfers, hop)
+}
+
+// follow adds transfers and their far accounts to the graph and returns the accounts to walk from next
+// An account reached earlier than before (later, walking backward) is walked again from the new time
+func (w *walk) follow(transfers []models.Transfer, hop int) ([]uint, error) {
+	far := func(t models.Transfer) uint {
+		if w.forward {
+			return t.ToAccountID
+		}
+		return t.FromAccountID
+	}
+
+	var ids []uint
+	for _, t := range transfers {
+		if _, known := w.nodes[far(t)]; !known && !w.hidden[far(t)] {
+			ids = append(ids, far(t))
+		}
+	}
+	accounts := map[uint]models.Account{}
+	if len(ids) > 0 {
+		var list []models.Account
+		if err := w.db.Where("id IN ?", ids).Find(&list).Error; err != nil {
+			return nil, err
+		}
+		for _, a := range list {
+			accounts[a.ID] = a
+		}
+	}
+
+	var next []uint
+	queued := map[uint]bool{}
+	for _, t := range transfers {
+		if w.followed[t.ID] {
+			continue
+		}
+		id := far(t)
+		if _, known := w.nodes[id]; !known {
+			account, ok := accounts[id]
+			// Accounts outside the caller's tenant do not load and are hidden like any other
+			if !ok || !w.visible(account) {
+				w.hidden[id] = true
+				continue
+			}
+			if len(w.g.Nodes) >= w.max {
+				w.g.Truncated = true
+				continue
+			}
+			w.add(account, hop, t.CreatedAt)
+			if !queued[id] {
+				next, queued[id] = append(next, id), true
+			}
+		} else {
+			node := &w.g.Nodes[w.nodes[id]]
+			if (w.forward && t.CreatedAt.Before(node.Reached)) || (!w.forward && t.CreatedAt.After(node.Reached)) {
+				node.Reached = t.CreatedAt
+				if !queued[id] {
+					next, queued[id] = append(next, id), true
+				}
+			}
+		}
+
+		w.followed[t.ID] = true
+		w.g.Edges = append(w.g.Edges, Edge{
+			TransferID:          t.ID,
+			FromAccountID:       t.FromAccountID,
+			ToAccountID:         t.ToAccountID,
+			Amount:              t.Amount,
+			Currency:            w.currency[t.FromAccountID],
+			DebitTransactionID:  t.DebitTransactionID,
+			CreditTransactionID: t.CreditTransactionID,
+			CreatedAt:           t.CreatedAt,
+			Depth:               hop,
+		})
+	}
+	return next, nil
+}
+
+// StaffCustomers returns the customers bank staff sign in as; their accounts are hidden from all but admins
+func StaffCustomers(db *gorm.DB) (map[uint]bool, error) {
+	var ids []uint
+	if err := db.Model(&models.User{}).Where("role IN ? AND customer_id IS NOT NULL", []string{"admin", "teller"}).Pluck("customer_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	staff := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		staff[id] = true
+	}
+	return staff, nil
+}
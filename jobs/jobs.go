@@ -0,0 +1,497 @@
+package jobs
+
+import (
+	"banking-app/models"
+	"banking-app/tracing"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Run statuses
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusSkipped   = "skipped" // A step not run because a step it depends on failed
+)
+
+// Run triggers
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+	TriggerStep     = "step" // Run as a step of another run
+)
+
+// DefaultLeaseTTL is how long a lease lasts without renewal; a crashed holder's lease frees up after it
+const DefaultLeaseTTL = 5 * time.Minute
+
+// Scheduler errors - handlers map these to client responses
+var (
+	ErrUnknownJob = errors.New("no job is registered with that name")
+	ErrRunning    = errors.New("job is already running")
+	ErrPaused     = errors.New("scheduled jobs are paused for maintenance")
+)
+
+// Job is a unit of background work; it returns the number of records it processed
+type Job interface {
+	Name() string
+	Run(ctx context.Context) (int, error)
+}
+
+// funcJob adapts a function to Job
+type funcJob struct {
+	name string
+	fn   func(ctx context.Context) (int, error)
+}
+
+func (j funcJob) Name() string                         { return j.name }
+func (j funcJob) Run(ctx context.Context) (int, error) { return j.fn(ctx) }
+
+// Func returns a Job that calls fn
+func Func(name string, fn func(ctx context.Context) (int, error)) Job {
+	return funcJob{name: name, fn: fn}
+}
+
+// Gate decides whether a job may start and tracks it while it runs; maintenance.Mode is one
+type Gate interface {
+	Run(name string, job func()) bool
+}
+
+// Registered is a job with its schedule, as listed by the admin API
+type Registered struct {
+	Name     string         `json:"name"`
+	Schedule string         `json:"schedule"`           // Cron spec, or "off" for manual runs only
+	NextRun  *time.Time     `json:"next_run,omitempty"` // Next scheduled start on this instance
+	LastRun  *models.JobRun `json:"last_run,omitempty"` // Latest run on any instance
+}
+
+// entry is a registered job and its parsed schedule
+type entry struct {
+	job      Job
+	spec     string
+	schedule Schedule // nil when scheduling is off
+	next     time.Time
+}
+
+// Scheduler runs registered jobs on their schedules, recording each run and holding a database lease
+// while it runs so no two instances sharing the database run a job at once
+type Scheduler struct {
+	DB          *gorm.DB
+	Gate        Gate          // Optional; jobs it refuses are retried after PausedRetry
+	PausedRetry time.Duration // Retry delay for a refused run
+	LeaseTTL    time.Duration // Lease lifetime; renewed while the job runs
+	Instance    string        // Lease holder name, unique per process
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+// NewScheduler returns a scheduler with a unique instance name and the default lease TTL
+func NewScheduler(db *gorm.DB, gate Gate, pausedRetry time.Duration) *Scheduler {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return &Scheduler{
+		DB:          db,
+		Gate:        gate,
+		PausedRetry: pausedRetry,
+		LeaseTTL:    DefaultLeaseTTL,
+		Instance:    fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix)),
+		entries:     make(map[string]*entry),
+	}
+}
+
+// ScheduleEnv is the variable that overrides a job's default schedule, e.g. JOB_SCHEDULE_CREDIT_LINES
+func ScheduleEnv(name string) string {
+	return "JOB_SCHEDULE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Register adds a job with its default schedule, which ScheduleEnv can override
+// A schedule of "off" leaves the job to manual runs
+func (s *Scheduler) Register(job Job, defaultSpec string) error {
+	spec := defaultSpec
+	if override := strings.TrimSpace(os.Getenv(ScheduleEnv(job.Name()))); override != "" {
+		spec = override
+	}
+	e := &entry{job: job, spec: spec}
+	if spec != "off" {
+		schedule, err := Parse(spec)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", job.Name(), err)
+		}
+		e.schedule = schedule
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[job.Name()] = e
+	return nil
+}
+
+// Start runs each scheduled job at its next matching time until stop is closed
+func (s *Scheduler) Start(stop <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if e.schedule != nil {
+			go s.loop(e, stop)
+		}
+	}
+}
+
+// loop waits for each of a job's scheduled times and runs it
+func (s *Scheduler) loop(e *entry, stop <-chan struct{}) {
+	next := s.setNext(e, e.schedule.Next(time.Now()))
+	for !next.IsZero() {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return
+		}
+
+		next = s.setNext(e, e.schedule.Next(time.Now()))
+		if !s.gated(e.job.Name(), func() {
+			if _, err := s.execute(e.job, TriggerSchedule, ""); err != nil && err != ErrRunning {
+				log.Printf("jobs: %s could not start: %v", e.job.Name(), err)
+			}
+		}) && s.PausedRetry > 0 {
+			if retry := time.Now().Add(s.PausedRetry); retry.Before(next) {
+				next = s.setNext(e, retry)
+			}
+		}
+	}
+}
+
+// gated runs fn through the Gate, if any, and reports whether it ran
+func (s *Scheduler) gated(name string, fn func()) bool {
+	if s.Gate == nil {
+		fn()
+		return true
+	}
+	return s.Gate.Run(name, fn)
+}
+
+// setNext records a job's next run for listing
+func (s *Scheduler) setNext(e *entry, next time.Time) time.Time {
+	s.mu.Lock()
+	e.next = next
+	s.mu.Unlock()
+	return next
+}
+
+// Trigger starts a manual run in the background and returns its record once the lease is held
+func (s *Scheduler) Trigger(name, by string) (models.JobRun, error) {
+	return s.trigger(name, models.JobRun{Trigger: TriggerManual, TriggeredBy: by})
+}
+
+// Resume starts a manual run like Trigger that records the failed run it picks up from; the job reads it
+// with CurrentRun
+func (s *Scheduler) Resume(name, by string, from uint) (models.JobRun, error) {
+	return s.trigger(name, models.JobRun{Trigger: TriggerManual, TriggeredBy: by, ResumedFromID: &from})
+}
+
+// trigger starts a run from a template holding its trigger fields
+func (s *Scheduler) trigger(name string, template models.JobRun) (models.JobRun, error) {
+	s.mu.Lock()
+	e, ok := s.entries[name]
+	s.mu.Unlock()
+	if !ok {
+		return models.JobRun{}, ErrUnknownJob
+	}
+
+	type started struct {
+		run models.JobRun
+		err error
+	}
+	result := make(chan started, 1)
+	go func() {
+		ran := s.gated(name, func() {
+			s.executeNotify(e.job, template, func(run models.JobRun, err error) {
+				result <- started{run, err}
+			})
+		})
+		if !ran {
+			result <- started{err: ErrPaused}
+		}
+	}()
+	r := <-result
+	return r.run, r.err
+}
+
+// RunStep runs a registered job to completion as a step of parent, under the job's lease, and returns its run
+// A run of the job already in progress is waited for, up to wait, before giving up with ErrRunning.
+// Steps are not gated: the parent run already passed the Gate
+func (s *Scheduler) RunStep(name string, parent models.JobRun, wait time.Duration) (models.JobRun, error) {
+	s.mu.Lock()
+	e, ok := s.entries[name]
+	s.mu.Unlock()
+	if !ok {
+		return models.JobRun{}, ErrUnknownJob
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		run, err := s.executeNotify(e.job, models.JobRun{Trigger: TriggerStep, TriggeredBy: parent.JobName, ParentRunID: &parent.ID}, nil)
+		if err != ErrRunning || time.Now().After(deadline) {
+			return run, err
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// runKey carries a run's record in its job's context
+type runKey struct{}
+
+// CurrentRun returns the record of the run a job was started for, from the context it was given
+func CurrentRun(ctx context.Context) (models.JobRun, bool) {
+	run, ok := ctx.Value(runKey{}).(models.JobRun)
+	return run, ok
+}
+
+// Due is a scheduled run that falls within a span of time
+type Due struct {
+	Job string    `json:"job"`
+	At  time.Time `json:"at"` // The scheduled time the run stands for
+}
+
+// MaxCatchUp bounds how many scheduled runs RunDue makes in one call
+const MaxCatchUp = 20000
+
+// ErrTooManyRuns is returned by RunDue when a span holds more than MaxCatchUp scheduled runs
+var ErrTooManyRuns = fmt.Errorf("more than %d scheduled runs fall in that span", MaxCatchUp)
+
+// DueBetween lists the scheduled runs after from up to and including to, in time order; jobs due at
+// the same time are ordered by name
+func (s *Scheduler) DueBetween(from, to time.Time) ([]Due, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []Due
+	for name, e := range s.entries {
+		if e.schedule == nil {
+			continue
+		}
+		for at := e.schedule.Next(from); !at.IsZero() && !at.After(to); at = e.schedule.Next(at) {
+			if len(due) == MaxCatchUp {
+				return nil, ErrTooManyRuns
+			}
+			due = append(due, Due{Job: name, At: at})
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].At.Equal(due[j].At) {
+			return due[i].At.Before(due[j].At)
+		}
+		return due[i].Job < due[j].Job
+	})
+	return due, nil
+}
+
+// RunDue runs, one at a time and in order, every scheduled run after from up to and including to.
+// at is called with each run's scheduled time before it starts, so a fake clock can be moved there.
+// It stops at the first run the Gate refuses, returning the runs made so far with ErrPaused
+func (s *Scheduler) RunDue(from, to time.Time, at func(time.Time)) ([]models.JobRun, error) {
+	due, err := s.DueBetween(from, to)
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]models.JobRun, 0, len(due))
+	for _, d := range due {
+		s.mu.Lock()
+		e := s.entries[d.Job]
+		s.mu.Unlock()
+		at(d.At)
+
+		var run models.JobRun
+		var runErr error
+		if !s.gated(d.Job, func() { run, runErr = s.execute(e.job, TriggerSchedule, "") }) {
+			return runs, ErrPaused
+		}
+		if runErr != nil {
+			return runs, fmt.Errorf("job %s: %w", d.Job, runErr)
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// Jobs lists the registered jobs by name with their schedules and latest runs
+func (s *Scheduler) Jobs() ([]Registered, error) {
+	return s.JobsContext(context.Background())
+}
+
+// JobsContext is Jobs with the latest runs looked up under ctx
+func (s *Scheduler) JobsContext(ctx context.Context) ([]Registered, error) {
+	s.mu.Lock()
+	list := make([]Registered, 0, len(s.entries))
+	for name, e := range s.entries {
+		r := Registered{Name: name, Schedule: e.spec}
+		if !e.next.IsZero() {
+			next := e.next
+			r.NextRun = &next
+		}
+		list = append(list, r)
+	}
+	s.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	if len(list) == 0 {
+		return list, nil
+	}
+	// One statement for every job's latest run; each branch is a single seek on the job_name index
+	branches := make([]string, len(list))
+	names := make([]interface{}, len(list))
+	for i := range list {
+		branches[i] = "SELECT MAX(id) FROM job_runs WHERE job_name = ?"
+		names[i] = list[i].Name
+	}
+	var runs []models.JobRun
+	if err := s.DB.WithContext(ctx).Where("id IN ("+strings.Join(branches, " UNION ALL ")+")", names...).Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	latest := make(map[string]*models.JobRun, len(runs))
+	for i := range runs {
+		latest[runs[i].JobName] = &runs[i]
+	}
+	for i := range list {
+		list[i].LastRun = latest[list[i].Name]
+	}
+	return list, nil
+}
+
+// execute runs a job to completion under its lease
+func (s *Scheduler) execute(job Job, trigger, by string) (models.JobRun, error) {
+	return s.executeNotify(job, models.JobRun{Trigger: trigger, TriggeredBy: by}, nil)
+}
+
+// executeNotify takes the job's lease, records the run from template's trigger fields, reports it to started
+// and then runs the job, recovering panics. It returns ErrRunning without running anything when another run
+// holds the lease
+func (s *Scheduler) executeNotify(job Job, template models.JobRun, started func(models.JobRun, error)) (models.JobRun, error) {
+	now := time.Now()
+	run := template
+	run.JobName = job.Name()
+	run.Instance = s.Instance
+	run.Status = StatusRunning
+	run.StartedAt = now
+	err := s.acquire(&run, now)
+	if started != nil {
+		started(run, err)
+	}
+	if err != nil {
+		return run, err
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), runKey{}, run))
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		s.renew(ctx, run)
+	}()
+
+	// Each run is the root of its own trace; jobs that query through ctx get their statements as child spans
+	ctx, span := tracing.Start(ctx, "job "+job.Name(), trace.WithAttributes(
+		attribute.String("job.name", job.Name()),
+		attribute.String("job.trigger", run.Trigger),
+		attribute.Int64("job.run_id", int64(run.ID)),
+	))
+	items, err := safeRun(ctx, job)
+	span.SetAttributes(attribute.Int("job.items", items))
+	tracing.End(span, err)
+	cancel()
+	<-renewed
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.DurationMS = finished.Sub(run.StartedAt).Milliseconds()
+	run.Items = items
+	run.Status = StatusSucceeded
+	if err != nil {
+		run.Status = StatusFailed
+		run.Error = err.Error()
+		log.Printf("jobs: %s failed: %v", job.Name(), err)
+	}
+	if err := s.DB.Save(&run).Error; err != nil {
+		log.Printf("jobs: recording %s run %d failed: %v", job.Name(), run.ID, err)
+	}
+	return run, s.release(run)
+}
+
+// safeRun runs a job, turning a panic into an error so one bad job cannot take down the process
+func safeRun(ctx context.Context, job Job) (items int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("jobs: %s panicked: %v\n%s", job.Name(), r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.Run(ctx)
+}
+
+// acquire takes the job's lease and records the run in one transaction
+// Runs left running by a holder whose lease expired are marked failed, since nothing is finishing them
+func (s *Scheduler) acquire(run *models.JobRun, now time.Time) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.JobLease{Name: run.JobName}).Error; err != nil {
+			return err
+		}
+		expires := now.Add(s.LeaseTTL)
+		taken := tx.Model(&models.JobLease{}).
+			Where("name = ? AND (expires_at IS NULL OR expires_at < ?)", run.JobName, now).
+			Updates(map[string]interface{}{"holder": s.Instance, "expires_at": expires})
+		if taken.Error != nil {
+			return taken.Error
+		}
+		if taken.RowsAffected == 0 {
+			return ErrRunning
+		}
+
+		err := tx.Model(&models.JobRun{}).Where("job_name = ? AND status = ?", run.JobName, StatusRunning).
+			Updates(map[string]interface{}{"status": StatusFailed, "finished_at": now, "error": "abandoned: lease expired before the run finished"}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(run).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.JobLease{}).Where("name = ?", run.JobName).Update("run_id", run.ID).Error
+	})
+}
+
+// renew extends the lease every third of its lifetime until ctx is done
+func (s *Scheduler) renew(ctx context.Context, run models.JobRun) {
+	ticker := time.NewTicker(s.LeaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := s.DB.Model(&models.JobLease{}).Where("name = ? AND holder = ? AND run_id = ?", run.JobName, s.Instance, run.ID).
+				Update("expires_at", time.Now().Add(s.LeaseTTL)).Error
+			if err != nil {
+				log.Printf("jobs: renewing %s lease failed: %v", run.JobName, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// release frees the lease so the next run can start at once
+func (s *Scheduler) release(run models.JobRun) error {
+	return s.DB.Model(&models.JobLease{}).Where("name = ? AND holder = ? AND run_id = ?", run.JobName, s.Instance, run.ID).
+		Update("expires_at", nil).Error
+}
@@ -0,0 +1,120 @@
+package ledger
+
+import (
+	"banking-app/flags"
+	"banking-app/models"
+	"banking-app/money"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// MaxLegs caps how many legs one client batch may post
+const MaxLegs = 20
+
+// Batch errors - handlers map these to client responses
+var (
+	ErrLegCount    = fmt.Errorf("a batch posts between 2 and %d legs", MaxLegs)
+	ErrLegCurrency = errors.New("account currency differs from the batch currency and the leg has no fx rate")
+	ErrFXRate      = errors.New("an fx rate must be positive and is only given for a leg in another currency")
+	ErrUnbalanced  = errors.New("debits and credits valued in the batch currency do not balance")
+)
+
+// Leg is one posting of a set made together, on its transaction's account
+type Leg struct {
+	Transaction  *models.Transaction // Filled in as it posts
+	Offset       string              // General-ledger code balancing the leg on its own; empty when other legs balance it
+	NoFundsCheck bool                // Bank-initiated debits of loan and credit line accounts, whose negative balance is what is owed
+	Rate         float64             // Batch currency per unit of the account's currency; 0 when they are the same
+}
+
+// LegError is why one leg of a batch cannot post
+type LegError struct {
+	Leg int // Index in the batch
+	Err error
+}
+
+func (e LegError) Error() string {
+	return fmt.Sprintf("leg %d: %v", e.Leg, e.Err)
+}
+
+func (e LegError) Unwrap() error {
+	return e.Err
+}
+
+// PostLegs posts legs in order inside an open database transaction, with each leg's own offset, returning each
+// leg's account after it
+// This is where postings made together are sequenced: transfers, fees, loan disbursements, credit line draws and
+// client batches all post through it. It stops at the first leg that fails, and the caller must roll its
+// transaction back; the accounts returned are those of the legs before it, so their count is the failing leg
+func PostLegs(tx *gorm.DB, legs []Leg, featureFlags *flags.Store) ([]models.Account, error) {
+	accounts := make([]models.Account, 0, len(legs))
+	for _, leg := range legs {
+		account, err := post(tx, leg.Transaction, featureFlags, !leg.NoFundsCheck)
+		if err != nil {
+			return accounts, err
+		}
+		if leg.Offset != "" {
+			if _, err := OffsetTo(tx, *leg.Transaction, account, leg.Offset, leg.Transaction.Amount); err != nil {
+				return accounts, err
+			}
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// CheckBatch validates a client batch before any of it posts, returning each leg's account and every leg that
+// cannot post
+// Each leg needs a valid amount and an active account in the batch currency, or a rate into it. Once every leg
+// passes, debits and credits valued in the batch currency must balance to the cent, or ErrUnbalanced is
+// returned. Funds are checked as the legs post, since earlier legs move the balances later ones see
+func CheckBatch(tx *gorm.DB, legs []Leg, currency string) ([]models.Account, []LegError, error) {
+	if len(legs) < 2 || len(legs) > MaxLegs {
+		return nil, nil, ErrLegCount
+	}
+	accounts := make([]models.Account, len(legs))
+	var invalid []LegError
+	var debits, credits float64
+	for i, leg := range legs {
+		t := leg.Transaction
+		if err := ValidateAmount(t.Amount); err != nil {
+			invalid = append(invalid, LegError{Leg: i, Err: err})
+			continue
+		}
+		err := tx.First(&accounts[i], t.AccountID).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			invalid = append(invalid, LegError{Leg: i, Err: gorm.ErrRecordNotFound})
+			continue
+		case err != nil:
+			return accounts, nil, err
+		}
+
+		account := accounts[i]
+		rate := leg.Rate
+		switch {
+		case account.Status != "active":
+			invalid = append(invalid, LegError{Leg: i, Err: ErrAccountInactive})
+			continue
+		case rate < 0, rate > 0 && account.Currency == currency:
+			invalid = append(invalid, LegError{Leg: i, Err: ErrFXRate})
+			continue
+		case rate == 0 && account.Currency != currency:
+			invalid = append(invalid, LegError{Leg: i, Err: ErrLegCurrency})
+			continue
+		case rate == 0:
+			rate = 1
+		}
+		if value := t.Amount * rate; IsCredit(t.TransactionType) {
+			credits += value
+		} else {
+			debits += value
+		}
+	}
+	if len(invalid) == 0 && money.Cents(credits) != money.Cents(debits) {
+		return accounts, nil, ErrUnbalanced
+	}
+	return accounts, invalid, nil
+}
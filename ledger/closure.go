@@ -0,0 +1,170 @@
+package ledger
+
+import (
+	"banking-app/events"
+	"banking-app/flags"
+	"banking-app/models"
+	"banking-app/statushistory"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Closure methods for the remaining balance
+const (
+	CloseToAccount    = "transfer"
+	CloseCashierCheck = "cashier_check"
+	CloseNoBalance    = "none"
+)
+
+// Closure errors - handlers map these to client responses
+var (
+	ErrNegativeBalance     = errors.New("account has a negative balance")
+	ErrSweepDestination    = errors.New("invalid sweep destination")
+	ErrDestinationCurrency = errors.New("destination currency differs")
+	ErrFundsHeld           = errors.New("account has funds on hold")
+)
+
+// CloseRequest says where a closing account's balance goes
+type CloseRequest struct {
+	DestinationAccountID uint   // Receiving account, or 0 with CashierCheck
+	CashierCheck         bool   // Pay the balance out by cashier's check
+	ClosedBy             string // Username recorded on the closure
+	Reason               string // Free-text reason
+}
+
+// ExistingClosure returns the closure record of an account that is already closed, if any
+func ExistingClosure(db *gorm.DB, accountID uint) (models.AccountClosure, bool, error) {
+	var closure models.AccountClosure
+	err := db.Where("account_id = ?", accountID).Limit(1).Find(&closure).Error
+	return closure, closure.ID != 0, err
+}
+
+// Close sweeps an account's balance out and marks it closed inside an open transaction
+// It returns the closure and the accounts whose balances changed, the closed account first.
+// The sweep posts through Post on both legs, so period locks and status checks apply. The unique
+// closure row makes a concurrent second close fail rather than sweep twice. An account with debits
+// held for review or pending pre-authorizations is refused, since sweeping would pay out held funds.
+// Accrued interest is the caller's to credit first, as the interest package posts it.
+func Close(tx *gorm.DB, accountID uint, req CloseRequest, featureFlags *flags.Store) (models.AccountClosure, []models.Account, error) {
+	closure := models.AccountClosure{AccountID: accountID, ClosedBy: req.ClosedBy, Reason: req.Reason}
+	var touched []models.Account
+
+	var account models.Account
+	if err := tx.First(&account, accountID).Error; err != nil {
+		return closure, touched, err
+	}
+	if account.Status != "active" {
+		return closure, touched, ErrAccountInactive
+	}
+	if account.Balance < 0 {
+		return closure, touched, ErrNegativeBalance
+	}
+	if held, err := Held(tx, account.ID); err != nil {
+		return closure, touched, err
+	} else if held > 0 {
+		return closure, touched, ErrFundsHeld
+	}
+
+	closure.SweptAmount = account.Balance
+	switch {
+	case account.Balance == 0:
+		closure.Method = CloseNoBalance
+	case req.CashierCheck:
+		closure.Method = CloseCashierCheck
+		closure.CashierCheckNumber = "CHK" + time.Now().Format("20060102150405") + fmt.Sprintf("%04d", account.ID%10000)
+	case req.DestinationAccountID == account.ID:
+		return closure, touched, ErrSelfTransfer
+	case req.DestinationAccountID != 0:
+		closure.Method = CloseToAccount
+		closure.DestinationAccountID = &req.DestinationAccountID
+	default:
+		return closure, touched, ErrSweepDestination
+	}
+
+	if closure.Method == CloseToAccount {
+		var destination models.Account
+		if err := tx.First(&destination, req.DestinationAccountID).Error; err == gorm.ErrRecordNotFound {
+			return closure, touched, ErrSweepDestination
+		} else if err != nil {
+			return closure, touched, err
+		}
+		if destination.Currency != account.Currency {
+			return closure, touched, ErrDestinationCurrency
+		}
+	}
+
+	if closure.Method != CloseNoBalance {
+		debitType, description := "transfer", "Closing balance transfer to account "+fmt.Sprint(req.DestinationAccountID)
+		if closure.Method == CloseCashierCheck {
+			debitType, description = "withdrawal", "Closing balance paid by cashier's check "+closure.CashierCheckNumber
+		}
+		debit := models.Transaction{
+			AccountID:       account.ID,
+			TransactionType: debitType,
+			Amount:          account.Balance,
+			Description:     description,
+			Reference:       closure.CashierCheckNumber,
+			Channel:         "branch",
+		}
+		if closure.Method == CloseToAccount {
+			debit.CounterpartyAccountID = &req.DestinationAccountID
+		}
+		// A cashier's check pays the money out of the bank; a transfer stays on the books
+		postSweep := Post
+		if closure.Method == CloseCashierCheck {
+			postSweep = PostExternal
+		}
+		swept, err := postSweep(tx, &debit, featureFlags)
+		if err != nil {
+			return closure, touched, err
+		}
+		account = swept
+		closure.SweepTransactionID = &debit.ID
+
+		if closure.Method == CloseToAccount {
+			credit := models.Transaction{
+				AccountID:             req.DestinationAccountID,
+				TransactionType:       "deposit",
+				Amount:                debit.Amount,
+				Description:           "Closing balance transfer from " + account.AccountNumber,
+				Reference:             debit.TransactionID,
+				Channel:               "branch",
+				CounterpartyAccountID: &account.ID,
+			}
+			destination, err := Post(tx, &credit, featureFlags)
+			if err != nil {
+				return closure, touched, err
+			}
+			closure.CreditTransactionID = &credit.ID
+			touched = append(touched, destination)
+		}
+	}
+
+	previous := account.Status
+	account.Status = "closed"
+	account.Version++
+	if err := tx.Model(&account).Updates(map[string]interface{}{"status": account.Status, "version": account.Version}).Error; err != nil {
+		return closure, touched, err
+	}
+	err := statushistory.Record(tx, models.StatusHistory{
+		TenantID:    account.TenantID,
+		SubjectType: statushistory.SubjectAccount,
+		SubjectID:   account.ID,
+		OldStatus:   previous,
+		NewStatus:   account.Status,
+		Reason:      req.Reason,
+		ChangedBy:   req.ClosedBy,
+	})
+	if err != nil {
+		return closure, touched, err
+	}
+	touched = append([]models.Account{account}, touched...)
+
+	if err := tx.Create(&closure).Error; err != nil {
+		return closure, touched, err
+	}
+	return closure, touched, events.Record(tx, events.AggregateAccount, account.ID, events.AccountClosed, closure)
+}
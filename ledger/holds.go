@@ -0,0 +1,54 @@
+package ledger
+
+import (
+	"banking-app/flags"
+	"banking-app/models"
+
+	"gorm.io/gorm"
+)
+
+// ReviewPending is the status of a debit held for review on a new account
+// Its amount stays out of the account's available funds until it posts or is declined
+const ReviewPending = "pending_review"
+
+// AuthorizationPending is the status of a pre-authorization hold not yet captured, released or expired
+// Its amount stays out of the account's available funds meanwhile
+const AuthorizationPending = "pending"
+
+// HeldForReview returns the total of an account's debits waiting for review
+func HeldForReview(db *gorm.DB, accountID uint) (float64, error) {
+	var held float64
+	err := db.Model(&models.TransactionReview{}).Where("account_id = ? AND status = ?", accountID, ReviewPending).
+		Select("COALESCE(SUM(amount), 0)").Scan(&held).Error
+	return held, err
+}
+
+// HeldForAuthorization returns the total of an account's pending pre-authorization holds
+func HeldForAuthorization(db *gorm.DB, accountID uint) (float64, error) {
+	var held float64
+	err := db.Model(&models.Authorization{}).Where("account_id = ? AND status = ?", accountID, AuthorizationPending).
+		Select("COALESCE(SUM(amount), 0)").Scan(&held).Error
+	return held, err
+}
+
+// Held returns everything held out of an account's available funds: debits waiting for review and pending
+// pre-authorizations
+func Held(db *gorm.DB, accountID uint) (float64, error) {
+	reviewed, err := HeldForReview(db, accountID)
+	if err != nil {
+		return 0, err
+	}
+	authorized, err := HeldForAuthorization(db, accountID)
+	return reviewed + authorized, err
+}
+
+// Available returns what a debit from an account may draw on: its balance, plus the overdraft limit while its
+// flag is on, less the amounts held. featureFlags may be nil
+func Available(db *gorm.DB, account models.Account, featureFlags *flags.Store) (float64, error) {
+	available := account.Balance
+	if featureFlags != nil && featureFlags.Enabled(flags.Overdraft, account.CustomerID) {
+		available += account.OverdraftLimit
+	}
+	held, err := Held(db, account.ID)
+	return available - held, err
+}
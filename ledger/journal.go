@@ -0,0 +1,179 @@
+package ledger
+
+import (
+	"banking-app/clock"
+	"banking-app/flags"
+	"banking-app/gl"
+	"banking-app/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// offsetCodes maps customer posting types to the general-ledger account on the other side
+// Money moving in or out of the bank offsets against cash; interest and fees against income and expense
+var offsetCodes = map[string]string{
+	"deposit":    gl.Cash,
+	"withdrawal": gl.Cash,
+	"transfer":   gl.Cash,
+	"payment":    gl.Cash,
+	TypeInterest: gl.InterestExpense,
+	TypeFee:      gl.FeeIncome,
+}
+
+// PostExternal posts a customer transaction together with its balancing general-ledger entry
+// Use it for money entering or leaving the bank; transfers between two accounts on the books
+// are already balanced and use Post for both legs
+func PostExternal(tx *gorm.DB, t *models.Transaction, featureFlags *flags.Store) (models.Account, error) {
+	return postOne(tx, Leg{Transaction: t, Offset: offsetCodes[t.TransactionType]}, featureFlags)
+}
+
+// postOne posts a single leg, returning its account after it
+func postOne(tx *gorm.DB, leg Leg, featureFlags *flags.Store) (models.Account, error) {
+	accounts, err := PostLegs(tx, []Leg{leg}, featureFlags)
+	if err != nil {
+		return models.Account{}, err
+	}
+	return accounts[0], nil
+}
+
+// OffsetTo balances part or all of a posting against an internal account of the posting's tenant and currency
+func OffsetTo(tx *gorm.DB, original models.Transaction, account models.Account, code string, amount float64) (models.Transaction, error) {
+	internal, err := gl.Account(tx, account.TenantID, code, account.Currency)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+	return Offset(tx, original, internal.ID, amount)
+}
+
+// Offset posts amount on the opposite side of original to another account and links it back
+func Offset(tx *gorm.DB, original models.Transaction, accountID uint, amount float64) (models.Transaction, error) {
+	leg := models.Transaction{
+		AccountID:       accountID,
+		TransactionType: "deposit",
+		Amount:          amount,
+		Description:     "Offset of " + original.TransactionID,
+		Reference:       original.TransactionID,
+		Channel:         original.Channel,
+		EffectiveDate:   original.EffectiveDate,
+		ValueDate:       original.ValueDate,
+		OffsetOfID:      &original.ID,
+	}
+	if IsCredit(original.TransactionType) {
+		leg.TransactionType = "withdrawal"
+	}
+	_, err := Post(tx, &leg, nil)
+	return leg, err
+}
+
+// Disburse debits a loan account by the principal and credits it out of cash
+// The loan account's negative balance is the receivable, so the funds check does not apply
+func Disburse(tx *gorm.DB, loanAccount models.Account, amount float64, loanNumber string) (models.Account, error) {
+	t := models.Transaction{
+		AccountID:       loanAccount.ID,
+		TransactionType: "withdrawal",
+		Amount:          amount,
+		Description:     "Disbursement of loan " + loanNumber,
+		Reference:       loanNumber,
+		Channel:         "api",
+	}
+	return postOne(tx, Leg{Transaction: &t, Offset: gl.Cash, NoFundsCheck: true}, nil)
+}
+
+// Draw moves amount from a line of credit account to the account it covers
+// The line account's negative balance is the amount drawn, so the funds check does not apply to it.
+// Both legs are on the books, so no general-ledger offset is needed. They take valueDate, the value date of the
+// posting the draw covers, so the line charges interest from the day the money is used
+func Draw(tx *gorm.DB, lineAccount models.Account, toAccountID uint, amount float64, lineNumber, description string, valueDate *time.Time) (models.Transaction, error) {
+	debit := models.Transaction{
+		AccountID:             lineAccount.ID,
+		TransactionType:       "withdrawal",
+		Amount:                amount,
+		Description:           description,
+		Reference:             lineNumber,
+		Channel:               "api",
+		CounterpartyAccountID: &toAccountID,
+		ValueDate:             valueDate,
+	}
+	credit := models.Transaction{
+		AccountID:             toAccountID,
+		TransactionType:       "deposit",
+		Amount:                amount,
+		Description:           description,
+		Reference:             lineNumber,
+		Channel:               "api",
+		CounterpartyAccountID: &lineAccount.ID,
+		ValueDate:             valueDate,
+	}
+	_, err := PostLegs(tx, []Leg{{Transaction: &debit, NoFundsCheck: true}, {Transaction: &credit}}, nil)
+	return credit, err
+}
+
+// Reverse posts the opposite of a posting effective now - credits reverse as withdrawals and debits as deposits -
+// with its general-ledger offsets, and fails with ErrAlreadyReversed when the posting already has a reversal
+// A reason, when given, is added to the reversal's description
+func Reverse(tx *gorm.DB, original models.Transaction, reason string) (models.Transaction, models.Account, error) {
+	reversalType := "deposit"
+	if IsCredit(original.TransactionType) {
+		reversalType = "withdrawal"
+	}
+	description := "Reversal of " + original.TransactionID
+	if reason != "" {
+		description += ": " + reason
+	}
+	reversal := models.Transaction{
+		AccountID:       original.AccountID,
+		TransactionType: reversalType,
+		Amount:          original.Amount,
+		Description:     description,
+		Reference:       original.TransactionID,
+		Channel:         original.Channel,
+		MerchantName:    original.MerchantName,
+		CategoryCode:    original.CategoryCode,
+		EffectiveDate:   clock.Now(),
+		ReversalOfID:    &original.ID,
+	}
+
+	var existing int64
+	if err := tx.Model(&models.Transaction{}).Where("reversal_of_id = ?", original.ID).Count(&existing).Error; err != nil {
+		return reversal, models.Account{}, err
+	}
+	if existing > 0 {
+		return reversal, models.Account{}, ErrAlreadyReversed
+	}
+	account, err := Post(tx, &reversal, nil)
+	if err != nil {
+		return reversal, account, err
+	}
+	return reversal, account, ReverseOffsets(tx, original, reversal)
+}
+
+// ReverseOffsets reverses the general-ledger entries of a posting alongside its reversal
+// Each offset reversal is linked to the original offset and to the customer reversal it balances
+func ReverseOffsets(tx *gorm.DB, original, reversal models.Transaction) error {
+	var offsets []models.Transaction
+	if err := tx.Where("offset_of_id = ?", original.ID).Order("id").Find(&offsets).Error; err != nil {
+		return err
+	}
+	for _, offset := range offsets {
+		leg := models.Transaction{
+			AccountID:       offset.AccountID,
+			TransactionType: "deposit",
+			Amount:          offset.Amount,
+			Description:     "Reversal of " + offset.TransactionID,
+			Reference:       offset.TransactionID,
+			Channel:         offset.Channel,
+			EffectiveDate:   reversal.EffectiveDate,
+			ValueDate:       reversal.ValueDate,
+			ReversalOfID:    &offset.ID,
+			OffsetOfID:      &reversal.ID,
+		}
+		if IsCredit(offset.TransactionType) {
+			leg.TransactionType = "withdrawal"
+		}
+		if _, err := post(tx, &leg, nil, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package ledger
+
+import (
+	"banking-app/clock"
+	"banking-app/descriptors"
+	"banking-app/events"
+	"banking-app/flags"
+	"banking-app/gl"
+	"banking-app/invariants"
+	"banking-app/models"
+	"banking-app/money"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Posting errors - handlers map these to client responses
+var (
+	ErrAccountInactive   = errors.New("account is not active")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrPeriodLocked      = errors.New("accounting period is locked")
+	ErrFutureDated       = errors.New("effective date is in the future")
+	ErrAlreadyReversed   = errors.New("transaction has already been reversed")
+)
+
+// Bank-originated posting types - interest credited to and fees charged on an account
+const (
+	TypeInterest = "interest"
+	TypeFee      = "fee"
+)
+
+// Transaction types that credit the account; every other type debits it
+var creditTypes = map[string]bool{"deposit": true, TypeInterest: true}
+
+// CreditSQL and SignedAmountSQL are the SQL forms of IsCredit and SignedAmount for aggregate queries
+const (
+	creditTypesSQL  = "('deposit', 'interest')"
+	CreditSQL       = "transaction_type IN " + creditTypesSQL
+	SignedAmountSQL = "CASE WHEN " + CreditSQL + " THEN amount ELSE -amount END"
+)
+
+// SignedAmountOf is SignedAmountSQL qualified with a table alias, for queries joining transactions twice
+func SignedAmountOf(table string) string {
+	return "CASE WHEN " + table + ".transaction_type IN " + creditTypesSQL + " THEN " + table + ".amount ELSE -" + table + ".amount END"
+}
+
+// IsCredit reports whether a transaction type increases the balance
+func IsCredit(transactionType string) bool {
+	return creditTypes[transactionType]
+}
+
+// SignedAmount returns a posting's effect on the balance
+func SignedAmount(t models.Transaction) float64 {
+	if IsCredit(t.TransactionType) {
+		return t.Amount
+	}
+	return -t.Amount
+}
+
+// NewTransactionID generates a system transaction reference
+// Critical for audit trails and transaction tracking. The nanosecond suffix keeps the several postings
+// one request makes, such as a fee and the ledger offsets, from colliding within the same second
+func NewTransactionID() string {
+	now := time.Now()
+	return "TXN" + now.Format("20060102150405") + fmt.Sprintf("%09d", now.Nanosecond())
+}
+
+// Post applies a transaction to its account inside an open database transaction
+// It checks the amount, status, funds and the period lock, updates the balance and version, stores the
+// transaction and records the transaction.posted outbox event. featureFlags may be nil.
+func Post(tx *gorm.DB, t *models.Transaction, featureFlags *flags.Store) (models.Account, error) {
+	return post(tx, t, featureFlags, true)
+}
+
+// post implements Post; checkFunds is false only for bank-initiated debits such as loan disbursements
+func post(tx *gorm.DB, t *models.Transaction, featureFlags *flags.Store, checkFunds bool) (models.Account, error) {
+	var account models.Account
+	if err := ValidateAmount(t.Amount); err != nil {
+		return account, err
+	}
+	if err := tx.First(&account, t.AccountID).Error; err != nil {
+		return account, err
+	}
+	if account.Status != "active" {
+		return account, ErrAccountInactive
+	}
+	// Amounts are counted in the account currency's minor units, so a posting finer than that is rounded to it
+	amount := money.FromFloat(t.Amount, account.Currency)
+	if amount.IsZero() {
+		return account, ErrZeroAmount
+	}
+	t.Amount = amount.Float()
+
+	now := clock.Now()
+	if t.EffectiveDate.IsZero() {
+		t.EffectiveDate = now
+	}
+	if t.EffectiveDate.After(now.Add(time.Minute)) {
+		return account, ErrFutureDated
+	}
+	if locked, err := IsLocked(tx, t.EffectiveDate); err != nil {
+		return account, err
+	} else if locked {
+		return account, ErrPeriodLocked
+	}
+	// Postings whose path assigns no value date are valued on the day they are effective
+	if t.ValueDate == nil {
+		valueDate := StartOfDay(t.EffectiveDate)
+		t.ValueDate = &valueDate
+	}
+
+	// Funds available for debits - the overdraft limit only counts while its flag is on, and debits held for
+	// review and pending pre-authorizations keep their amount. Internal ledger accounts carry debit balances, so only
+	// customer accounts are checked
+	if checkFunds && account.AccountType != gl.AccountType && !IsCredit(t.TransactionType) {
+		available, err := Available(tx, account, featureFlags)
+		if err != nil {
+			return account, err
+		}
+		if short, err := money.FromFloat(available, account.Currency).Less(amount); err != nil {
+			return account, err
+		} else if short {
+			return account, ErrInsufficientFunds
+		}
+	}
+
+	// Background jobs post without a tenant context, so the posting takes its account's tenant
+	t.TenantID = account.TenantID
+	balance := money.FromFloat(account.Balance, account.Currency)
+	if !IsCredit(t.TransactionType) {
+		amount = amount.Neg()
+	}
+	after, err := balance.Add(amount)
+	if err != nil {
+		return account, err
+	}
+	t.BalanceBefore = balance.Float()
+	account.Balance = after.Float()
+	t.BalanceAfter = account.Balance
+	if t.TransactionID == "" {
+		t.TransactionID = NewTransactionID()
+	}
+	account.Version++
+	descriptor, err := descriptors.Describe(tx, *t, account)
+	if err != nil {
+		return account, err
+	}
+	t.Descriptor = descriptor
+
+	// The balance column is written only here, behind the floor guard; Save covers the rest of the row
+	if err := invariants.SetBalance(tx, account, t.BalanceBefore, *t); err != nil {
+		return account, err
+	}
+	if err := tx.Save(&account).Error; err != nil {
+		return account, err
+	}
+	if err := tx.Create(t).Error; err != nil {
+		return account, err
+	}
+	// A credit to a customer account can set off sweeps as it is created, such as toward a garnishment order,
+	// so the caller gets the row as it stands
+	if IsCredit(t.TransactionType) && account.AccountType != gl.AccountType {
+		if err := tx.First(&account, account.ID).Error; err != nil {
+			return account, err
+		}
+	}
+
+	// Outbox event commits with the posting - published by the dispatcher
+	return account, events.Record(tx, events.AggregateAccount, account.ID, events.TransactionPosted, EventPayload(*t))
+}
+
+// EventPayload is the published body of a transaction.posted event
+func EventPayload(t models.Transaction) map[string]interface{} {
+	payload := map[string]interface{}{
+		"transaction_id":   t.TransactionID,
+		"id":               t.ID,
+		"account_id":       t.AccountID,
+		"transaction_type": t.TransactionType,
+		"amount":           t.Amount,
+		"balance_before":   t.BalanceBefore,
+		"balance_after":    t.BalanceAfter,
+		"description":      t.Description,
+		"descriptor":       t.Descriptor,
+		"memo":             t.Memo,
+		"reference":        t.Reference,
+		"created_at":       t.CreatedAt,
+		"effective_date":   t.EffectiveDate,
+		"value_date":       t.ValueDate,
+	}
+	if t.ReversalOfID != nil {
+		payload["reversal_of_id"] = *t.ReversalOfID
+	}
+	return payload
+}
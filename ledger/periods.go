@@ -0,0 +1,54 @@
+package ledger
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CurrentLock returns the period lock in force for the tenant of db, if any
+func CurrentLock(db *gorm.DB) (models.PeriodLock, bool, error) {
+	var lock models.PeriodLock
+	err := db.Order("id DESC").Limit(1).Find(&lock).Error
+	return lock, lock.ID != 0, err
+}
+
+// IsLocked reports whether an effective date falls on or before the closed-through date
+func IsLocked(db *gorm.DB, effective time.Time) (bool, error) {
+	lock, ok, err := CurrentLock(db)
+	if err != nil || !ok {
+		return false, err
+	}
+	return effective.Before(DayAfter(lock.ClosedThrough)), nil
+}
+
+// DayAfter returns bank midnight of the day following a date
+func DayAfter(date time.Time) time.Time {
+	return businessdays.DayAfter(date)
+}
+
+// StartOfDay returns bank midnight of a date
+func StartOfDay(date time.Time) time.Time {
+	return businessdays.StartOfDay(date)
+}
+
+// ValueDate returns the bank day a posting of a type submitted at a time is valued on, under the bank's daily
+// cutoffs by transaction type and its holiday calendar; a zero time is now
+// A type without a cutoff is valued on the day it is submitted
+func ValueDate(db *gorm.DB, transactionType string, at time.Time, cutoffs map[string]string) (time.Time, error) {
+	if at.IsZero() {
+		at = clock.Now()
+	}
+	cutoff, ok := cutoffs[transactionType]
+	if !ok {
+		return StartOfDay(at), nil
+	}
+	calendar, err := businessdays.Load(db)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return calendar.ValueDate(at, cutoff), nil
+}
@@ -0,0 +1,30 @@
+package ledger
+
+import "errors"
+
+// Money movement errors - every entry point checks requests through ValidateAmount or ValidateMovement,
+// and Post checks every posting again, so all of them refuse the same requests
+var (
+	ErrZeroAmount     = errors.New("amount must not be zero")
+	ErrNegativeAmount = errors.New("amount must be positive")
+	ErrSelfTransfer   = errors.New("source and destination are the same account")
+)
+
+// ValidateAmount checks an amount to post or move
+func ValidateAmount(amount float64) error {
+	switch {
+	case amount == 0:
+		return ErrZeroAmount
+	case !(amount > 0):
+		return ErrNegativeAmount
+	}
+	return nil
+}
+
+// ValidateMovement checks moving amount from one account to another
+func ValidateMovement(fromAccountID, toAccountID uint, amount float64) error {
+	if fromAccountID == toAccountID {
+		return ErrSelfTransfer
+	}
+	return ValidateAmount(amount)
+}
@@ -0,0 +1,328 @@
+package liens
+
+import (
+	"banking-app/clock"
+	"banking-app/events"
+	"banking-app/ledger"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"banking-app/money"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Lien statuses
+const (
+	StatusActive    = "active"
+	StatusSatisfied = "satisfied"
+	StatusReleased  = "released"
+)
+
+// Lien errors - handlers map these to client responses
+var (
+	ErrHeld             = errors.New("debit would take the balance below the amount held by liens")
+	ErrInvalid          = errors.New("a lien needs a claimant, a legal reference, a priority of 1 or more and either an amount or all funds")
+	ErrNotActive        = errors.New("lien is no longer active")
+	ErrNoFunds          = errors.New("no funds are left for the lien once more senior liens are covered")
+	ErrDocumentNotFound = errors.New("document not found for the account's customer")
+)
+
+// Hold is what an account's active liens hold against customer debits
+type Hold struct {
+	Count    int     `json:"active_liens"`
+	Amount   float64 `json:"held_amount"` // Sum of the amount liens; meaningless when AllFunds is set
+	AllFunds bool    `json:"all_funds"`   // An all-funds lien holds the whole balance
+}
+
+// Covers reports whether a balance still covers the hold
+// Without active liens nothing is held, so an overdrawn balance is left to the overdraft limit
+func (h Hold) Covers(balance float64) bool {
+	if h.Count == 0 {
+		return true
+	}
+	if h.AllFunds {
+		return false
+	}
+	return balance >= h.Amount-0.005
+}
+
+// Available is the part of a balance not held by liens
+func (h Hold) Available(balance float64) float64 {
+	if h.AllFunds {
+		return 0
+	}
+	return math.Max(0, money.Cents(balance-h.Amount))
+}
+
+// Active returns an account's active liens, most senior first
+func Active(db *gorm.DB, accountID uint) ([]models.Lien, error) {
+	var liens []models.Lien
+	err := db.Where("account_id = ? AND status = ?", accountID, StatusActive).Order("priority, id").Find(&liens).Error
+	return liens, err
+}
+
+// HoldOn totals what an account's active liens hold
+func HoldOn(db *gorm.DB, accountID uint) (Hold, error) {
+	liens, err := Active(db, accountID)
+	if err != nil {
+		return Hold{}, err
+	}
+	return total(liens), nil
+}
+
+// HoldAhead totals what an account's active liens ranked ahead of another claim hold: those of a lower priority
+// number, and those of the same priority placed no later than the claim
+func HoldAhead(db *gorm.DB, accountID uint, priority int, placed time.Time) (Hold, error) {
+	liens, err := Active(db, accountID)
+	if err != nil {
+		return Hold{}, err
+	}
+	var ahead []models.Lien
+	for _, l := range liens {
+		if l.Priority < priority || (l.Priority == priority && !l.CreatedAt.After(placed)) {
+			ahead = append(ahead, l)
+		}
+	}
+	return total(ahead), nil
+}
+
+// total sums the hold of a set of active liens
+func total(liens []models.Lien) Hold {
+	var h Hold
+	for _, l := range liens {
+		h.Count++
+		h.AllFunds = h.AllFunds || l.AllFunds
+		h.Amount = money.Cents(h.Amount + l.Amount)
+	}
+	return h
+}
+
+// Enforce checks, inside the debit's transaction, that an account's balance after a customer debit still covers
+// its active liens. Call it once the debit and any fee have posted, so the whole reduction counts
+func Enforce(tx *gorm.DB, account models.Account) error {
+	hold, err := HoldOn(tx, account.ID)
+	if err != nil {
+		return err
+	}
+	if !hold.Covers(account.Balance) {
+		return ErrHeld
+	}
+	return nil
+}
+
+// Validate checks a lien's fields before it is placed or changed
+func Validate(l models.Lien) error {
+	if strings.TrimSpace(l.Claimant) == "" || strings.TrimSpace(l.LegalReference) == "" || l.Priority < 1 {
+		return ErrInvalid
+	}
+	if l.AllFunds != (l.Amount == 0) || l.Amount < 0 {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// Place records a new active lien with its supporting documents inside an open transaction
+func Place(tx *gorm.DB, l *models.Lien, documentIDs []uint) error {
+	if err := Validate(*l); err != nil {
+		return err
+	}
+	var account models.Account
+	if err := tx.Select("id, customer_id, tenant_id").First(&account, l.AccountID).Error; err != nil {
+		return err
+	}
+	l.ID = 0
+	l.Status = StatusActive
+	l.Amount = money.Cents(l.Amount)
+	l.PaidAmount = 0
+	if err := tx.Create(l).Error; err != nil {
+		return err
+	}
+	if err := Attach(tx, *l, account, documentIDs); err != nil {
+		return err
+	}
+	return events.Record(tx, events.AggregateAccount, l.AccountID, events.AccountLienPlaced, l)
+}
+
+// Change replaces an active lien's claimant, reference, amount and priority and attaches further documents
+// The amount is what is still claimed, so a lien paid in part is changed to its new outstanding amount
+func Change(tx *gorm.DB, l *models.Lien, changes models.Lien, documentIDs []uint) error {
+	if l.Status != StatusActive {
+		return ErrNotActive
+	}
+	if err := Validate(changes); err != nil {
+		return err
+	}
+	var account models.Account
+	if err := tx.Select("id, customer_id, tenant_id").First(&account, l.AccountID).Error; err != nil {
+		return err
+	}
+	previous := *l
+	l.Claimant, l.LegalReference, l.Priority = changes.Claimant, changes.LegalReference, changes.Priority
+	l.AllFunds, l.Amount = changes.AllFunds, money.Cents(changes.Amount)
+	result := tx.Model(&models.Lien{}).Where("id = ? AND status = ?", l.ID, StatusActive).Updates(map[string]interface{}{
+		"claimant":        l.Claimant,
+		"legal_reference": l.LegalReference,
+		"priority":        l.Priority,
+		"all_funds":       l.AllFunds,
+		"amount":          l.Amount,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotActive
+	}
+	if err := Attach(tx, *l, account, documentIDs); err != nil {
+		return err
+	}
+	return events.Record(tx, events.AggregateAccount, l.AccountID, events.AccountLienChanged, map[string]interface{}{
+		"lien":     l,
+		"previous": previous,
+	})
+}
+
+// Attach links documents of the account's customer to a lien; documents already attached are skipped
+func Attach(tx *gorm.DB, l models.Lien, account models.Account, documentIDs []uint) error {
+	for _, id := range documentIDs {
+		var count int64
+		if err := tx.Model(&models.Document{}).Where("id = ? AND customer_id = ?", id, account.CustomerID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrDocumentNotFound
+		}
+		link := models.LienDocument{TenantID: account.TenantID, LienID: l.ID, DocumentID: id}
+		if err := tx.Where(models.LienDocument{LienID: l.ID, DocumentID: id}).FirstOrCreate(&link).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Release lifts an active lien without paying it
+func Release(tx *gorm.DB, l *models.Lien, by, reason string) error {
+	if !lifecycle.Allows(lifecycle.Lien, l.Status, StatusReleased) {
+		return ErrNotActive
+	}
+	now := clock.Now()
+	result := tx.Model(&models.Lien{}).Where("id = ? AND status = ?", l.ID, StatusActive).
+		Updates(map[string]interface{}{"status": StatusReleased, "released_at": now, "released_by": by, "release_reason": reason})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotActive
+	}
+	l.Status, l.ReleasedAt, l.ReleasedBy, l.ReleaseReason = StatusReleased, &now, by, reason
+	return events.Record(tx, events.AggregateAccount, l.AccountID, events.AccountLienReleased, l)
+}
+
+// Satisfy pays an active lien to a destination account inside an open transaction
+// Liens are paid in priority order: the lien gets only what the balance holds beyond the liens senior to it. An
+// amount lien paid in part stays active for the rest; an all-funds lien takes what is available and is satisfied.
+// Account restrictions do not apply, since the payment is ordered by the claimant rather than the customer
+func Satisfy(tx *gorm.DB, l *models.Lien, destinationID uint, by string) (models.LienPayment, error) {
+	var payment models.LienPayment
+	if l.Status != StatusActive {
+		return payment, ErrNotActive
+	}
+	if destinationID == l.AccountID {
+		return payment, ledger.ErrSelfTransfer
+	}
+
+	var account, destination models.Account
+	if err := tx.First(&account, l.AccountID).Error; err != nil {
+		return payment, err
+	}
+	if err := tx.First(&destination, destinationID).Error; err != nil {
+		return payment, err
+	}
+	if destination.Currency != account.Currency {
+		return payment, ledger.ErrDestinationCurrency
+	}
+
+	active, err := Active(tx, l.AccountID)
+	if err != nil {
+		return payment, err
+	}
+	var senior []models.Lien
+	for _, other := range active {
+		if other.ID == l.ID {
+			break
+		}
+		senior = append(senior, other)
+	}
+	amount := total(senior).Available(account.Balance)
+	if !l.AllFunds {
+		amount = math.Min(amount, l.Amount)
+	}
+	if amount <= 0 {
+		return payment, ErrNoFunds
+	}
+
+	debit := models.Transaction{
+		AccountID:             account.ID,
+		TransactionType:       "transfer",
+		Amount:                amount,
+		Description:           "Lien payment to " + l.Claimant + " (" + l.LegalReference + ")",
+		Channel:               "branch",
+		CounterpartyAccountID: &destination.ID,
+	}
+	if _, err := ledger.Post(tx, &debit, nil); err != nil {
+		return payment, err
+	}
+	credit := models.Transaction{
+		AccountID:             destination.ID,
+		TransactionType:       "deposit",
+		Amount:                amount,
+		Description:           "Lien payment from " + account.AccountNumber + " (" + l.LegalReference + ")",
+		Reference:             debit.TransactionID,
+		Channel:               "branch",
+		CounterpartyAccountID: &account.ID,
+	}
+	if _, err := ledger.Post(tx, &credit, nil); err != nil {
+		return payment, err
+	}
+
+	payment = models.LienPayment{
+		TenantID:             account.TenantID,
+		LienID:               l.ID,
+		Amount:               amount,
+		DestinationAccountID: destination.ID,
+		DebitTransactionID:   debit.ID,
+		CreditTransactionID:  credit.ID,
+		PaidBy:               by,
+	}
+	if err := tx.Create(&payment).Error; err != nil {
+		return payment, err
+	}
+
+	updates := map[string]interface{}{"paid_amount": money.Cents(l.PaidAmount + amount)}
+	if !l.AllFunds {
+		updates["amount"] = money.Cents(l.Amount - amount)
+	}
+	if l.AllFunds || updates["amount"].(float64) == 0 {
+		now := clock.Now()
+		updates["status"], updates["satisfied_at"], updates["satisfied_by"] = StatusSatisfied, now, by
+		l.Status, l.SatisfiedAt, l.SatisfiedBy = StatusSatisfied, &now, by
+	}
+	result := tx.Model(&models.Lien{}).Where("id = ? AND status = ?", l.ID, StatusActive).Updates(updates)
+	if result.Error != nil {
+		return payment, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return payment, ErrNotActive
+	}
+	l.PaidAmount = updates["paid_amount"].(float64)
+	if !l.AllFunds {
+		l.Amount = updates["amount"].(float64)
+	}
+	return payment, events.Record(tx, events.AggregateAccount, l.AccountID, events.AccountLienPaid, map[string]interface{}{
+		"lien":    l,
+		"payment": payment,
+	})
+}
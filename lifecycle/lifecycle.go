@@ -0,0 +1,258 @@
+package lifecycle
+
+import (
+	"banking-app/auth"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Transition is one status change a record may make
+type Transition struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Permission string `json:"permission,omitempty"` // Held by a user making the change; jobs are not checked
+	Reason     bool   `json:"reason_required"`      // The change must carry a free-text reason
+}
+
+// Machine declares a record type's statuses, the ones it may be created in, and the changes between them
+type Machine struct {
+	Subject     string       `json:"subject"`
+	Model       interface{}  `json:"-"` // The model whose Status field the machine governs
+	Statuses    []string     `json:"statuses"`
+	Initial     []string     `json:"initial"`
+	Transitions []Transition `json:"transitions"`
+}
+
+// Lifecycle errors - handlers map these to client responses
+var (
+	ErrInvalidTransition = errors.New("invalid status transition")
+	ErrReasonRequired    = errors.New("a reason is required for this status change")
+	ErrForbidden         = errors.New("role may not make this status change")
+	ErrUnknownSubject    = errors.New("no lifecycle is declared for this record type")
+)
+
+// TransitionError is a refused status change, naming the statuses the record could move to instead
+type TransitionError struct {
+	Subject string
+	From    string // Empty for a record being created
+	To      string
+	Allowed []string
+}
+
+func (e *TransitionError) Error() string {
+	allowed := "none"
+	if len(e.Allowed) > 0 {
+		allowed = strings.Join(e.Allowed, ", ")
+	}
+	if e.From == "" {
+		return fmt.Sprintf("%s cannot be created %s; allowed: %s", e.Subject, e.To, allowed)
+	}
+	return fmt.Sprintf("%s cannot move from %s to %s; allowed: %s", e.Subject, e.From, e.To, allowed)
+}
+
+// Is lets errors.Is match a TransitionError against ErrInvalidTransition
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// Lookup returns the machine declared for a subject
+func Lookup(subject string) (Machine, bool) {
+	for _, m := range machines {
+		if m.Subject == subject {
+			return m, true
+		}
+	}
+	return Machine{}, false
+}
+
+// All returns every declared machine in declaration order
+func All() []Machine {
+	return append([]Machine(nil), machines...)
+}
+
+// Allowed lists the statuses a record may move to from a status; from a record being created, the initial ones
+func (m Machine) Allowed(from string) []string {
+	if from == "" {
+		return append([]string{}, m.Initial...)
+	}
+	allowed := []string{}
+	for _, t := range m.Transitions {
+		if t.From == from {
+			allowed = append(allowed, t.To)
+		}
+	}
+	return allowed
+}
+
+// transition finds the declared change from one status to another
+func (m Machine) transition(from, to string) (Transition, bool) {
+	if from == "" {
+		for _, s := range m.Initial {
+			if s == to {
+				return Transition{To: to}, true
+			}
+		}
+		return Transition{}, false
+	}
+	for _, t := range m.Transitions {
+		if t.From == from && t.To == to {
+			return t, true
+		}
+	}
+	return Transition{}, false
+}
+
+// find returns the declared change, or the error refusing it; keeping the same status is always allowed
+func find(subject, from, to string) (Transition, error) {
+	m, ok := Lookup(subject)
+	if !ok {
+		return Transition{}, fmt.Errorf("%w: %s", ErrUnknownSubject, subject)
+	}
+	if from == to {
+		return Transition{From: from, To: to}, nil
+	}
+	t, ok := m.transition(from, to)
+	if !ok {
+		return t, &TransitionError{Subject: subject, From: from, To: to, Allowed: m.Allowed(from)}
+	}
+	return t, nil
+}
+
+// Allows reports whether a declared transition moves a record between two statuses. Unlike Check, staying in the
+// same status is not one, so packages can guard an action that must change the status with it
+func Allows(subject, from, to string) bool {
+	m, ok := Lookup(subject)
+	if !ok || from == to {
+		return false
+	}
+	_, ok = m.transition(from, to)
+	return ok
+}
+
+// Check reports whether a record may move between two statuses; an empty from checks a new record's status
+func Check(subject, from, to string) error {
+	_, err := find(subject, from, to)
+	return err
+}
+
+// CheckReason is Check for a change that carries a reason, which some transitions require
+func CheckReason(subject, from, to, reason string) error {
+	t, err := find(subject, from, to)
+	if err != nil {
+		return err
+	}
+	if t.Reason && strings.TrimSpace(reason) == "" {
+		return ErrReasonRequired
+	}
+	return nil
+}
+
+// Authorize is CheckReason for a change a signed-in user makes, who must also hold the transition's permission
+func Authorize(subject, from, to, role, reason string) error {
+	t, err := find(subject, from, to)
+	if err != nil {
+		return err
+	}
+	if t.Permission != "" && !auth.Can(role, t.Permission) {
+		return ErrForbidden
+	}
+	if t.Reason && strings.TrimSpace(reason) == "" {
+		return ErrReasonRequired
+	}
+	return nil
+}
+
+// Undeclared returns the type names of models with a string Status field but no declared machine
+func Undeclared(models ...interface{}) []string {
+	declared := make(map[reflect.Type]bool, len(machines))
+	for _, m := range machines {
+		declared[indirect(reflect.TypeOf(m.Model))] = true
+	}
+	var missing []string
+	for _, model := range models {
+		t := indirect(reflect.TypeOf(model))
+		if t.Kind() != reflect.Struct {
+			continue
+		}
+		if f, ok := t.FieldByName("Status"); ok && f.Type.Kind() == reflect.String && !declared[t] {
+			missing = append(missing, t.Name())
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// Validate checks the declared machines are well formed: subjects and models unique, every status named in a
+// transition declared, no status changing to itself, and every status reachable from an initial one
+func Validate() error {
+	subjects := map[string]bool{}
+	types := map[reflect.Type]bool{}
+	for _, m := range machines {
+		if subjects[m.Subject] {
+			return fmt.Errorf("lifecycle: %s is declared twice", m.Subject)
+		}
+		subjects[m.Subject] = true
+		t := indirect(reflect.TypeOf(m.Model))
+		if f, ok := t.FieldByName("Status"); !ok || f.Type.Kind() != reflect.String {
+			return fmt.Errorf("lifecycle: %s model %s has no string Status field", m.Subject, t.Name())
+		}
+		if types[t] {
+			return fmt.Errorf("lifecycle: %s model %s is governed twice", m.Subject, t.Name())
+		}
+		types[t] = true
+
+		statuses := map[string]bool{}
+		for _, s := range m.Statuses {
+			statuses[s] = true
+		}
+		if len(m.Initial) == 0 {
+			return fmt.Errorf("lifecycle: %s declares no initial status", m.Subject)
+		}
+		reached := map[string]bool{}
+		for _, s := range m.Initial {
+			if !statuses[s] {
+				return fmt.Errorf("lifecycle: %s initial status %q is not declared", m.Subject, s)
+			}
+			reached[s] = true
+		}
+		seen := map[Transition]bool{}
+		for _, t := range m.Transitions {
+			if !statuses[t.From] || !statuses[t.To] {
+				return fmt.Errorf("lifecycle: %s transition %s -> %s names an undeclared status", m.Subject, t.From, t.To)
+			}
+			if t.From == t.To {
+				return fmt.Errorf("lifecycle: %s transition %s -> %s does not change status", m.Subject, t.From, t.To)
+			}
+			key := Transition{From: t.From, To: t.To}
+			if seen[key] {
+				return fmt.Errorf("lifecycle: %s transition %s -> %s is declared twice", m.Subject, t.From, t.To)
+			}
+			seen[key] = true
+		}
+		for grew := true; grew; {
+			grew = false
+			for _, t := range m.Transitions {
+				if reached[t.From] && !reached[t.To] {
+					reached[t.To], grew = true, true
+				}
+			}
+		}
+		for _, s := range m.Statuses {
+			if !reached[s] {
+				return fmt.Errorf("lifecycle: %s status %q is unreachable", m.Subject, s)
+			}
+		}
+	}
+	return nil
+}
+
+// indirect returns the type a pointer type points to
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
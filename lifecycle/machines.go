@@ -0,0 +1,370 @@
+package lifecycle
+
+import (
+	"banking-app/auth"
+	"banking-app/models"
+)
+
+// Subjects with a declared lifecycle; customer, account, loan and application match the status history's
+const (
+	Customer           = "customer"
+	Account            = "account"
+	Loan               = "loan"
+	Application        = "application"
+	CreditLine         = "credit_line"
+	InstallmentPlan    = "installment_plan"
+	LoanCollection     = "loan_collection"
+	Lien               = "lien"
+	GarnishmentOrder   = "garnishment_order"
+	SARCase            = "sar_case"
+	Escheatment        = "escheatment"
+	ProductChange      = "product_change"
+	ExternalAccount    = "external_account"
+	EmailVerification  = "email_verification"
+	WithdrawalApproval = "withdrawal_approval"
+	TransactionReview  = "transaction_review"
+	Authorization      = "authorization"
+	DuplicatePayment   = "duplicate_payment"
+	Dispute            = "dispute"
+	ExceptionItem      = "exception_item"
+	Invariant          = "invariant_violation"
+	MatchReport        = "match_report"
+	MatchItem          = "match_item"
+	FXRevaluation      = "fx_revaluation"
+	BulkOperation      = "bulk_operation"
+	Campaign           = "campaign"
+	CampaignRecipient  = "campaign_recipient"
+	JobRun             = "job_run"
+	OutboxEvent        = "outbox_event"
+	WebhookDelivery    = "webhook_delivery"
+	Notification       = "notification"
+	Communication      = "communication"
+	ReportSubscription = "report_subscription"
+	ReportRun          = "report_run"
+	StatementRequest   = "statement_request"
+	Tenant             = "tenant"
+	User               = "user"
+)
+
+// machines is every record type's lifecycle. Statuses are spelled out rather than taken from the packages that
+// own them, which import this one
+var machines = []Machine{
+	{
+		Subject: Customer, Model: models.Customer{},
+		Statuses: []string{"active", "inactive"}, Initial: []string{"active"},
+		Transitions: []Transition{
+			{From: "active", To: "inactive", Permission: auth.PermCustomerStatus},
+			{From: "inactive", To: "active", Permission: auth.PermCustomerStatus},
+		},
+	},
+	{
+		Subject: Account, Model: models.Account{},
+		Statuses: []string{"active", "frozen", "closed", "escheated"}, Initial: []string{"active"},
+		Transitions: []Transition{
+			{From: "active", To: "frozen", Permission: auth.PermRestrictions, Reason: true},
+			{From: "frozen", To: "active", Permission: auth.PermRestrictions, Reason: true},
+			{From: "active", To: "closed"},
+			{From: "frozen", To: "closed"}, // A line of credit closing its frozen account
+			{From: "active", To: "escheated", Reason: true},
+			{From: "escheated", To: "active", Reason: true}, // Reclaimed by the owner
+		},
+	},
+	{
+		Subject: Loan, Model: models.Loan{},
+		Statuses: []string{"pending", "declined", "active", "paid_off", "defaulted"}, Initial: []string{"pending"},
+		Transitions: []Transition{
+			{From: "pending", To: "active"},
+			{From: "pending", To: "declined", Permission: auth.PermCreditReview},
+			{From: "active", To: "paid_off"},
+			{From: "active", To: "defaulted", Reason: true},
+			{From: "defaulted", To: "paid_off"}, // Recovered in full
+		},
+	},
+	{
+		Subject: Application, Model: models.AccountApplication{},
+		Statuses: []string{"draft", "submitted", "approved", "rejected", "opened", "expired"}, Initial: []string{"draft"},
+		Transitions: []Transition{
+			{From: "draft", To: "submitted"},
+			{From: "draft", To: "expired"},
+			{From: "submitted", To: "approved", Permission: auth.PermApplications, Reason: true},
+			{From: "submitted", To: "rejected", Permission: auth.PermApplications, Reason: true},
+			{From: "approved", To: "opened"},
+		},
+	},
+	{
+		Subject: CreditLine, Model: models.CreditLine{},
+		Statuses: []string{"pending", "declined", "active", "closed"}, Initial: []string{"pending"},
+		Transitions: []Transition{
+			{From: "pending", To: "active"},
+			{From: "pending", To: "declined"},
+			{From: "active", To: "closed"},
+		},
+	},
+	{
+		Subject: InstallmentPlan, Model: models.InstallmentPlan{},
+		Statuses: []string{"active", "paid_off"}, Initial: []string{"active"},
+		Transitions: []Transition{
+			{From: "active", To: "paid_off"},
+		},
+	},
+	{
+		Subject: LoanCollection, Model: models.LoanCollection{},
+		Statuses: []string{"collected", "short"}, Initial: []string{"collected", "short"},
+		Transitions: []Transition{},
+	},
+	{
+		Subject: Lien, Model: models.Lien{},
+		Statuses: []string{"active", "satisfied", "released"}, Initial: []string{"active"},
+		Transitions: []Transition{
+			{From: "active", To: "satisfied", Permission: auth.PermLiens},
+			{From: "active", To: "released", Permission: auth.PermLiens, Reason: true},
+		},
+	},
+	{
+		Subject: GarnishmentOrder, Model: models.GarnishmentOrder{},
+		Statuses: []string{"active", "suspended", "satisfied"}, Initial: []string{"active"},
+		Transitions: []Transition{
+			{From: "active", To: "suspended", Permission: auth.PermGarnishments, Reason: true},
+			{From: "suspended", To: "active", Permission: auth.PermGarnishments},
+			{From: "active", To: "satisfied", Permission: auth.PermGarnishments},
+			{From: "suspended", To: "satisfied", Permission: auth.PermGarnishments},
+		},
+	},
+	{
+		Subject: SARCase, Model: models.SARCase{},
+		Statuses: []string{"open", "investigating", "sar_filed", "closed_no_action"}, Initial: []string{"open"},
+		Transitions: []Transition{
+			{From: "open", To: "investigating", Permission: auth.PermSARCases},
+			{From: "open", To: "closed_no_action", Permission: auth.PermSARCases, Reason: true},
+			{From: "investigating", To: "sar_filed", Permission: auth.PermSARCases},
+			{From: "investigating", To: "closed_no_action", Permission: auth.PermSARCases, Reason: true},
+		},
+	},
+	{
+		Subject: Escheatment, Model: models.Escheatment{},
+		Statuses: []string{"pending", "cancelled", "escheated", "reclaimed"}, Initial: []string{"pending"},
+		Transitions: []Transition{
+			{From: "pending", To: "cancelled"},
+			{From: "pending", To: "escheated"},
+			{From: "escheated", To: "reclaimed", Reason: true},
+		},
+	},
+	{
+		Subject: ProductChange, Model: models.ProductChange{},
+		Statuses: []string{"scheduled", "applied", "cancelled"}, Initial: []string{"scheduled", "applied"},
+		Transitions: []Transition{
+			{From: "scheduled", To: "applied"},
+			{From: "scheduled", To: "cancelled"},
+		},
+	},
+	{
+		Subject: ExternalAccount, Model: models.ExternalAccount{},
+		Statuses: []string{"pending", "verified", "locked"}, Initial: []string{"pending"},
+		Transitions: []Transition{
+			{From: "pending", To: "verified"},
+			{From: "pending", To: "locked"},
+		},
+	},
+	{
+		Subject: EmailVerification, Model: models.EmailVerification{},
+		Statuses: []string{"pending", "verified", "superseded"}, Initial: []string{"pending"},
+		Transitions: []Transition{
+			{From: "pending", To: "verified"},
+			{From: "pending", To: "superseded"},
+		},
+	},
+	{
+		Subject: WithdrawalApproval, Model: models.WithdrawalApproval{},
+		Statuses: []string{"pending", "approved", "rejected"}, Initial: []string{"pending"},
+		Transitions: []Transition{
+			{From: "pending", To: "approved", Permission: auth.PermApprovals},
+			{From: "pending", To: "rejected", Permission: auth.PermApprovals},
+		},
+	},
+	{
+		Subject: TransactionReview, Model: models.TransactionReview{},
+		Statuses: []string{"pending_review", "approved", "declined"}, Initial: []string{"pending_review"},
+		Transitions: []Transition{
+			{From: "pending_review", To: "approved"},
+			{From: "pending_review", To: "declined"},
+		},
+	},
+	{
+		Subject: Authorization, Model: models.Authorization{},
+		Statuses: []string{"pending", "captured", "released", "expired"}, Initial: []string{"pending"},
+		Transitions: []Transition{
+			{From: "pending", To: "captured", Permission: auth.PermAuthorizations},
+			{From: "pending", To: "released", Permission: auth.PermAuthorizations},
+			{From: "pending", To: "expired"},
+		},
+	},
+	{
+		Subject: DuplicatePayment, Model: models.DuplicatePayment{},
+		Statuses: []string{"flagged", "reversed", "dismissed"}, Initial: []string{"flagged"},
+		Transitions: []Transition{
+			{From: "flagged", To: "reversed"},
+			{From: "flagged", To: "dismissed"},
+		},
+	},
+	{
+		Subject: Dispute, Model: models.Dispute{},
+		Statuses: []string{"open", "resolved"}, Initial: []string{"open"},
+		Transitions: []Transition{
+			{From: "open", To: "resolved", Permission: auth.PermDisputes, Reason: true},
+		},
+	},
+	{
+		Subject: ExceptionItem, Model: models.ExceptionItem{},
+		Statuses: []string{"open", "applied", "returned"}, Initial: []string{"open"},
+		Transitions: []Transition{
+			{From: "open", To: "applied", Permission: auth.PermExceptions},
+			{From: "open", To: "returned", Permission: auth.PermExceptions},
+		},
+	},
+	{
+		Subject: Invariant, Model: models.InvariantViolation{},
+		Statuses: []string{"open", "resolved"}, Initial: []string{"open"},
+		Transitions: []Transition{
+			{From: "open", To: "resolved"},
+		},
+	},
+	{
+		Subject: MatchReport, Model: models.MatchReport{},
+		Statuses: []string{"open", "reconciled"}, Initial: []string{"open", "reconciled"},
+		Transitions: []Transition{
+			{From: "open", To: "reconciled"},
+			{From: "reconciled", To: "open"},
+		},
+	},
+	{
+		Subject: MatchItem, Model: models.MatchItem{},
+		Statuses: []string{"matched", "unmatched_ours", "unmatched_theirs", "adjusted"},
+		Initial:  []string{"matched", "unmatched_ours", "unmatched_theirs"},
+		Transitions: []Transition{
+			{From: "unmatched_theirs", To: "matched"},
+			{From: "unmatched_theirs", To: "adjusted"},
+		},
+	},
+	{
+		Subject: FXRevaluation, Model: models.FXRevaluation{},
+		Statuses: []string{"posted", "failed"}, Initial: []string{"posted", "failed"},
+		Transitions: []Transition{
+			{From: "failed", To: "posted"},
+		},
+	},
+	{
+		Subject: BulkOperation, Model: models.BulkOperation{},
+		Statuses: []string{"queued", "running", "completed"}, Initial: []string{"queued"},
+		Transitions: []Transition{
+			{From: "queued", To: "running"},
+			{From: "running", To: "completed"},
+		},
+	},
+	{
+		Subject: Campaign, Model: models.Campaign{},
+		Statuses: []string{"scheduled", "running", "paused", "completed"}, Initial: []string{"scheduled"},
+		Transitions: []Transition{
+			{From: "scheduled", To: "running"},
+			{From: "scheduled", To: "paused"},
+			{From: "running", To: "paused"},
+			{From: "paused", To: "running"},
+			{From: "paused", To: "scheduled"}, // Paused before it started
+			{From: "running", To: "completed"},
+		},
+	},
+	{
+		Subject: CampaignRecipient, Model: models.CampaignRecipient{},
+		Statuses: []string{"pending", "queued", "sent", "failed", "suppressed", "unreachable"}, Initial: []string{"pending"},
+		Transitions: []Transition{
+			{From: "pending", To: "queued"},
+			{From: "pending", To: "suppressed"},
+			{From: "pending", To: "unreachable"},
+			{From: "pending", To: "failed"}, // The message could not be queued
+			{From: "queued", To: "sent"},
+			{From: "queued", To: "failed"},
+		},
+	},
+	{
+		Subject: JobRun, Model: models.JobRun{},
+		Statuses: []string{"running", "succeeded", "failed", "skipped"}, Initial: []string{"running", "skipped"},
+		Transitions: []Transition{
+			{From: "running", To: "succeeded"},
+			{From: "running", To: "failed"},
+		},
+	},
+	{
+		Subject: OutboxEvent, Model: models.OutboxEvent{},
+		Statuses: []string{"pending", "dispatched", "failed"}, Initial: []string{"pending"},
+		Transitions: []Transition{
+			{From: "pending", To: "dispatched"},
+			{From: "pending", To: "failed"},
+			{From: "failed", To: "pending"}, // Redriven by an administrator
+		},
+	},
+	{
+		Subject: WebhookDelivery, Model: models.WebhookDelivery{},
+		Statuses: []string{"pending", "delivered", "dropped"}, Initial: []string{"pending"},
+		Transitions: []Transition{
+			{From: "pending", To: "delivered"},
+			{From: "pending", To: "dropped"},
+		},
+	},
+	{
+		Subject: Notification, Model: models.Notification{},
+		Statuses: []string{"pending", "sent", "failed"}, Initial: []string{"pending"},
+		Transitions: []Transition{
+			{From: "pending", To: "sent"},
+			{From: "pending", To: "failed"},
+			{From: "failed", To: "pending", Permission: auth.PermCommunications}, // Retried from the communication log
+		},
+	},
+	{
+		// Log entries record an outcome once and never change; a retry logs a new entry
+		Subject: Communication, Model: models.CommunicationLog{},
+		Statuses: []string{"sent", "failed", "generated", "archived"}, Initial: []string{"sent", "failed", "generated", "archived"},
+		Transitions: []Transition{},
+	},
+	{
+		Subject: ReportSubscription, Model: models.ReportSubscription{},
+		Statuses: []string{"active", "paused"}, Initial: []string{"active"},
+		Transitions: []Transition{
+			{From: "active", To: "paused"},
+			{From: "paused", To: "active"},
+		},
+	},
+	{
+		Subject: ReportRun, Model: models.ReportRun{},
+		Statuses: []string{"delivering", "delivered", "failed"}, Initial: []string{"delivering", "failed"},
+		Transitions: []Transition{
+			{From: "delivering", To: "delivered"},
+			{From: "delivering", To: "failed"},
+		},
+	},
+	{
+		Subject: StatementRequest, Model: models.StatementRequest{},
+		Statuses: []string{"queued", "running", "ready", "failed"}, Initial: []string{"queued", "running"},
+		Transitions: []Transition{
+			{From: "queued", To: "running"},
+			{From: "running", To: "ready"},
+			{From: "running", To: "failed"},
+		},
+	},
+	{
+		Subject: Tenant, Model: models.Tenant{},
+		Statuses: []string{"active", "suspended"}, Initial: []string{"active", "suspended"},
+		Transitions: []Transition{
+			{From: "active", To: "suspended"},
+			{From: "suspended", To: "active"},
+		},
+	},
+	{
+		Subject: User, Model: models.User{},
+		Statuses: []string{"active", "locked", "disabled"}, Initial: []string{"active"},
+		Transitions: []Transition{
+			{From: "active", To: "locked"},
+			{From: "locked", To: "active"},
+			{From: "active", To: "disabled"},
+			{From: "locked", To: "disabled"},
+		},
+	},
+}
@@ -0,0 +1,284 @@
+package loadshed
+
+import (
+	"banking-app/apiversion"
+	"banking-app/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Defaults used when the environment does not set a limit
+const (
+	DefaultGlobal     = 256 // Requests in flight across the API
+	DefaultWrites     = 16  // Mutating requests in flight; SQLite serializes writes, so this is far smaller
+	DefaultRetryAfter = 1   // Seconds
+)
+
+// Exempt lists the paths that are never shed, so health checks and scrapes see an overloaded server as it is
+var Exempt = []string{"/health", "/metrics"}
+
+// Limits caps the requests in flight on this instance; zero means unlimited
+type Limits struct {
+	Global            int            `json:"global"`              // Every request
+	Writes            int            `json:"writes"`              // Requests other than GET, HEAD and OPTIONS
+	Routes            map[string]int `json:"routes"`              // Per route, keyed "METHOD /api/v1/path/:param"
+	RetryAfterSeconds int            `json:"retry_after_seconds"` // Retry-After on shed requests
+}
+
+// Validate checks the limits are usable
+func (l Limits) Validate() error {
+	if l.Global < 0 || l.Writes < 0 || l.RetryAfterSeconds < 0 {
+		return errors.New("limits and retry_after_seconds cannot be negative")
+	}
+	for route, n := range l.Routes {
+		if n < 0 {
+			return fmt.Errorf("limit for %q cannot be negative", route)
+		}
+		if fields := strings.Fields(route); len(fields) != 2 || !strings.HasPrefix(fields[1], "/") {
+			return fmt.Errorf("route %q must be \"METHOD /path\"", route)
+		}
+	}
+	return nil
+}
+
+// LimitsFromEnv reads MAX_INFLIGHT, MAX_INFLIGHT_WRITES, SHED_RETRY_AFTER and MAX_INFLIGHT_ROUTES,
+// a comma-separated list such as "POST /api/v1/transfers=4,GET /api/v1/admin/export/transactions=2"
+func LimitsFromEnv() Limits {
+	number := func(key string, fallback int) int {
+		raw := os.Getenv(key)
+		if raw == "" {
+			return fallback
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			log.Printf("loadshed: ignoring invalid %s %q", key, raw)
+			return fallback
+		}
+		return n
+	}
+	limits := Limits{
+		Global:            number("MAX_INFLIGHT", DefaultGlobal),
+		Writes:            number("MAX_INFLIGHT_WRITES", DefaultWrites),
+		RetryAfterSeconds: number("SHED_RETRY_AFTER", DefaultRetryAfter),
+		Routes:            make(map[string]int),
+	}
+	for _, entry := range strings.Split(os.Getenv("MAX_INFLIGHT_ROUTES"), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(entry[i+1:]))
+		if i < 0 || err != nil || n < 0 {
+			log.Printf("loadshed: ignoring invalid MAX_INFLIGHT_ROUTES entry %q", entry)
+			continue
+		}
+		limits.Routes[strings.Join(strings.Fields(entry[:i]), " ")] = n
+	}
+	return limits
+}
+
+// Limiter sheds requests beyond the limits with 503 rather than letting them queue
+// Limits can be changed while the server runs; requests already admitted are unaffected
+type Limiter struct {
+	mu     sync.Mutex
+	limits Limits
+	global int
+	writes int
+	routes map[string]int // In flight per route, so a limit set at runtime sees requests already running
+	shed   map[string]*metrics.Counter
+}
+
+// New returns a limiter and registers its gauges and counters
+func New(limits Limits) *Limiter {
+	if limits.Routes == nil {
+		limits.Routes = make(map[string]int)
+	}
+	l := &Limiter{limits: limits, routes: make(map[string]int), shed: make(map[string]*metrics.Counter)}
+	for _, class := range []string{"global", "writes", "route"} {
+		l.shed[class] = metrics.NewCounter(`http_requests_shed_total{limit="`+class+`"}`, "Requests refused with 503 because a concurrency limit was reached")
+	}
+	metrics.RegisterGauge(`http_requests_in_flight{class="all"}`, "Requests being served", func() float64 {
+		return float64(l.InFlight().Global)
+	})
+	metrics.RegisterGauge(`http_requests_in_flight{class="write"}`, "Requests being served", func() float64 {
+		return float64(l.InFlight().Writes)
+	})
+	return l
+}
+
+// InFlight is a snapshot of the requests being served
+type InFlight struct {
+	Global int            `json:"global"`
+	Writes int            `json:"writes"`
+	Routes map[string]int `json:"routes"` // Limited routes only
+}
+
+// Limits returns the limits in force
+func (l *Limiter) Limits() Limits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return copyLimits(l.limits)
+}
+
+// SetLimits replaces the limits; an empty Routes map removes every route limit
+func (l *Limiter) SetLimits(limits Limits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = copyLimits(limits)
+	return nil
+}
+
+// InFlight returns the current in-flight counts
+func (l *Limiter) InFlight() InFlight {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	routes := make(map[string]int)
+	for route := range l.limits.Routes {
+		routes[route] = l.routes[route]
+	}
+	return InFlight{Global: l.global, Writes: l.writes, Routes: routes}
+}
+
+// copyLimits returns limits with its own Routes map
+func copyLimits(limits Limits) Limits {
+	routes := make(map[string]int, len(limits.Routes))
+	for route, n := range limits.Routes {
+		routes[route] = n
+	}
+	limits.Routes = routes
+	return limits
+}
+
+// ticket is an admitted request; route is empty until the request matches a route
+type ticket struct {
+	write bool
+	route string
+}
+
+// admit counts a request in if every limit that applies has room, returning the limit that was full otherwise
+func (l *Limiter) admit(t *ticket) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case l.limits.Global > 0 && l.global >= l.limits.Global:
+		return "global", false
+	case t.write && l.limits.Writes > 0 && l.writes >= l.limits.Writes:
+		return "writes", false
+	case l.routeFull(t.route):
+		return "route", false
+	}
+	l.global++
+	if t.write {
+		l.writes++
+	}
+	if t.route != "" {
+		l.routes[t.route]++
+	}
+	return "", true
+}
+
+// admitRoute counts an admitted request in on the route it matched after being re-dispatched
+func (l *Limiter) admitRoute(t *ticket, route string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.routeFull(route) {
+		return false
+	}
+	t.route = route
+	l.routes[route]++
+	return true
+}
+
+// routeFull reports whether a route is at its limit; the caller holds mu
+func (l *Limiter) routeFull(route string) bool {
+	limit, limited := l.limits.Routes[route]
+	return route != "" && limited && limit > 0 && l.routes[route] >= limit
+}
+
+// release counts a finished request out
+func (l *Limiter) release(t *ticket) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.global--
+	if t.write {
+		l.writes--
+	}
+	if t.route == "" {
+		return
+	}
+	if l.routes[t.route]--; l.routes[t.route] <= 0 {
+		delete(l.routes, t.route)
+	}
+}
+
+// ticketKey holds a request's ticket in its context, so a v2 request re-dispatched to v1 is not counted twice
+type ticketKey struct{}
+
+// Middleware admits requests while the limits allow and refuses the rest with 503 and Retry-After
+// Install it on the engine after apiversion tracking so v2 callers get their error envelope
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := ""
+		if c.FullPath() != "" {
+			route = c.Request.Method + " " + c.FullPath()
+		}
+		if t, ok := c.Request.Context().Value(ticketKey{}).(*ticket); ok {
+			if t.route == "" && route != "" && !l.admitRoute(t, route) {
+				l.refuse(c, "route")
+				return
+			}
+			c.Next()
+			return
+		}
+		for _, path := range Exempt {
+			if c.Request.URL.Path == path {
+				c.Next()
+				return
+			}
+		}
+
+		method := c.Request.Method
+		t := &ticket{route: route, write: method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions}
+		if limit, ok := l.admit(t); !ok {
+			l.refuse(c, limit)
+			return
+		}
+		defer l.release(t)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), ticketKey{}, t))
+		c.Next()
+	}
+}
+
+// refuse sheds a request with 503 in the caller's API version's error format
+func (l *Limiter) refuse(c *gin.Context, limit string) {
+	l.shed[limit].Inc()
+	retryAfter := l.Limits().RetryAfterSeconds
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	message := "The server is at capacity; retry shortly"
+	if apiversion.Version(c) == apiversion.V2 {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": gin.H{
+			"code":    "OVERLOADED",
+			"message": message,
+			"details": gin.H{"limit": limit, "retry_after_seconds": retryAfter},
+		}})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+		"error":               message,
+		"code":                "OVERLOADED",
+		"limit":               limit,
+		"retry_after_seconds": retryAfter,
+	})
+}
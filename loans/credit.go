@@ -0,0 +1,37 @@
+package loans
+
+import (
+	"banking-app/creditbureau"
+	"banking-app/models"
+	"banking-app/statushistory"
+
+	"gorm.io/gorm"
+)
+
+// SaveCreditDecision stores a loan's credit check and decision inside an open transaction
+// A declined application is declined for good, along with the line of credit it backs, if any
+func SaveCreditDecision(tx *gorm.DB, loan *models.Loan) error {
+	if loan.CreditDecision == creditbureau.DecisionDeclined {
+		previous := loan.Status
+		loan.Status = StatusDeclined
+		if err := tx.Model(&models.CreditLine{}).Where("loan_id = ?", loan.ID).Update("status", StatusDeclined).Error; err != nil {
+			return err
+		}
+		// Automatic decisions have no reviewer and are recorded as the system's
+		err := statushistory.Record(tx, models.StatusHistory{
+			TenantID:    loan.TenantID,
+			SubjectType: statushistory.SubjectLoan,
+			SubjectID:   loan.ID,
+			OldStatus:   previous,
+			NewStatus:   loan.Status,
+			Reason:      loan.CreditDecisionReason,
+			ChangedBy:   loan.CreditReviewedBy,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Model(loan).
+		Select("status", "credit_score", "credit_report_id", "credit_decision", "credit_decision_reason", "credit_checked_at", "credit_reviewed_by").
+		Updates(loan).Error
+}
@@ -0,0 +1,289 @@
+package loans
+
+import (
+	"banking-app/businessdays"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/money"
+	"banking-app/statushistory"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Loan party roles
+const (
+	RoleBorrower   = "borrower"
+	RoleCoBorrower = "co_borrower"
+	RoleGuarantor  = "guarantor"
+)
+
+// Application statuses, before a loan is disbursed and becomes active
+const (
+	StatusPending  = "pending"  // Opened without disbursement; its parties can still change
+	StatusDeclined = "declined" // Refused by the credit review; never disbursed
+)
+
+// ReasonDisbursed is recorded in a loan's status history when it is paid out
+const ReasonDisbursed = "Disbursed"
+
+// DefaultMaxDebtToIncome is the highest share of monthly income loan obligations may take
+const DefaultMaxDebtToIncome = 0.43
+
+// Party errors - handlers map these to client responses
+var (
+	ErrNotPending           = errors.New("loan parties can only change before disbursement")
+	ErrInvalidRole          = errors.New("role must be co_borrower or guarantor")
+	ErrInvalidLiability     = errors.New("liability_percent must be greater than 0 and at most 100")
+	ErrDuplicateParty       = errors.New("customer is already a party to this loan")
+	ErrSoleBorrower         = errors.New("the loan's borrower cannot be removed")
+	ErrNotGuarantor         = errors.New("only guarantors confirm a loan")
+	ErrAlreadyConfirmed     = errors.New("guarantee is already confirmed")
+	ErrUnconfirmedGuarantor = errors.New("every guarantor must confirm before disbursement")
+)
+
+// AffordabilityError reports a party whose loan obligations would exceed the debt-to-income limit
+type AffordabilityError struct {
+	CustomerID uint
+	Role       string
+	Ratio      float64 // Monthly obligations including this loan over monthly income
+	Limit      float64
+}
+
+// Error names the party and its ratio
+func (e *AffordabilityError) Error() string {
+	return fmt.Sprintf("customer %d (%s) would have a debt-to-income ratio of %.2f, above the limit of %.2f",
+		e.CustomerID, e.Role, e.Ratio, e.Limit)
+}
+
+// MaxDebtToIncomeFromEnv reads LOAN_MAX_DTI as a ratio, e.g. 0.43 (the default)
+func MaxDebtToIncomeFromEnv() float64 {
+	limit, err := strconv.ParseFloat(os.Getenv("LOAN_MAX_DTI"), 64)
+	if err != nil || limit <= 0 {
+		return DefaultMaxDebtToIncome
+	}
+	return limit
+}
+
+// AddBorrower records a new loan's customer as its borrower, fully liable
+func AddBorrower(tx *gorm.DB, loan models.Loan, by string) error {
+	return tx.Create(&models.LoanParty{
+		TenantID:         loan.TenantID,
+		LoanID:           loan.ID,
+		CustomerID:       loan.CustomerID,
+		Role:             RoleBorrower,
+		LiabilityPercent: 100,
+		AddedBy:          by,
+	}).Error
+}
+
+// AddParty adds a co-borrower or guarantor to a pending loan
+func AddParty(tx *gorm.DB, loan models.Loan, party *models.LoanParty) error {
+	if loan.Status != StatusPending {
+		return ErrNotPending
+	}
+	if party.Role != RoleCoBorrower && party.Role != RoleGuarantor {
+		return ErrInvalidRole
+	}
+	if party.LiabilityPercent <= 0 || party.LiabilityPercent > 100 {
+		return ErrInvalidLiability
+	}
+	var existing int64
+	if err := tx.Model(&models.LoanParty{}).Where("loan_id = ? AND customer_id = ?", loan.ID, party.CustomerID).Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing > 0 {
+		return ErrDuplicateParty
+	}
+	party.TenantID = loan.TenantID
+	party.LoanID = loan.ID
+	party.ConfirmedAt = nil
+	party.ConfirmedBy = ""
+	return tx.Create(party).Error
+}
+
+// RemoveParty removes a co-borrower or guarantor from a pending loan; the borrower stays
+func RemoveParty(tx *gorm.DB, loan models.Loan, party models.LoanParty) error {
+	if loan.Status != StatusPending {
+		return ErrNotPending
+	}
+	if party.Role == RoleBorrower {
+		return ErrSoleBorrower
+	}
+	return tx.Delete(&party).Error
+}
+
+// ConfirmGuarantee records a guarantor's consent to a pending loan
+func ConfirmGuarantee(tx *gorm.DB, loan models.Loan, party *models.LoanParty, by string, now time.Time) error {
+	if loan.Status != StatusPending {
+		return ErrNotPending
+	}
+	if party.Role != RoleGuarantor {
+		return ErrNotGuarantor
+	}
+	if party.ConfirmedAt != nil {
+		return ErrAlreadyConfirmed
+	}
+	party.ConfirmedAt = &now
+	party.ConfirmedBy = by
+	return tx.Model(party).Updates(map[string]interface{}{"confirmed_at": now, "confirmed_by": by}).Error
+}
+
+// CustomerLoan is a loan as seen by one of its parties
+type CustomerLoan struct {
+	models.Loan
+	Role             string  `json:"role"`
+	LiabilityPercent float64 `json:"liability_percent"`
+	Contingent       bool    `json:"contingent"` // Guarantors owe only if the borrowers default
+}
+
+// CustomerLoans lists every loan a customer is a party to with their role, in loan order
+func CustomerLoans(db *gorm.DB, customerID uint) ([]CustomerLoan, error) {
+	var parties []models.LoanParty
+	if err := db.Where("customer_id = ?", customerID).Find(&parties).Error; err != nil {
+		return nil, err
+	}
+	byLoan := make(map[uint]models.LoanParty, len(parties))
+	ids := make([]uint, 0, len(parties))
+	for _, party := range parties {
+		byLoan[party.LoanID] = party
+		ids = append(ids, party.LoanID)
+	}
+
+	var found []models.Loan
+	if err := db.Where("id IN ? OR customer_id = ?", ids, customerID).Order("id").Find(&found).Error; err != nil {
+		return nil, err
+	}
+	result := make([]CustomerLoan, 0, len(found))
+	for _, loan := range found {
+		party, ok := byLoan[loan.ID]
+		if !ok {
+			party = models.LoanParty{Role: RoleBorrower, LiabilityPercent: 100}
+		}
+		result = append(result, CustomerLoan{
+			Loan:             loan,
+			Role:             party.Role,
+			LiabilityPercent: party.LiabilityPercent,
+			Contingent:       party.Role == RoleGuarantor,
+		})
+	}
+	return result, nil
+}
+
+// IsBorrower reports whether a customer is the loan's borrower or one of its co-borrowers
+// Either may fund payments; guarantors are only liable if the borrowers default
+func IsBorrower(db *gorm.DB, loan models.Loan, customerID uint) (bool, error) {
+	if customerID == loan.CustomerID {
+		return true, nil
+	}
+	var count int64
+	err := db.Model(&models.LoanParty{}).
+		Where("loan_id = ? AND customer_id = ? AND role = ?", loan.ID, customerID, RoleCoBorrower).Count(&count).Error
+	return count > 0, err
+}
+
+// MonthlyObligations is a customer's share of the monthly payments of the active loans they are a party to
+// Each loan counts at the customer's liability percentage, whatever their role; excludeLoanID leaves one loan out
+func MonthlyObligations(db *gorm.DB, customerID, excludeLoanID uint) (float64, error) {
+	var total struct{ Amount float64 }
+	err := db.Model(&models.LoanParty{}).
+		Select("COALESCE(SUM(loans.monthly_payment * loan_parties.liability_percent / 100), 0) AS amount").
+		Joins("JOIN loans ON loans.id = loan_parties.loan_id AND loans.deleted_at IS NULL").
+		Where("loan_parties.customer_id = ? AND loans.status = ? AND loans.id <> ?", customerID, "active", excludeLoanID).
+		Scan(&total).Error
+	return money.Cents(total.Amount), err
+}
+
+// CheckAffordability verifies each party can carry its share of the loan's payment on top of the
+// obligations from every other loan it is a party to. Parties without a recorded income are not checked
+func CheckAffordability(db *gorm.DB, loan models.Loan, parties []models.LoanParty, limit float64) error {
+	for _, party := range parties {
+		var customer models.Customer
+		if err := db.First(&customer, party.CustomerID).Error; err != nil {
+			return err
+		}
+		if customer.MonthlyIncome <= 0 {
+			continue
+		}
+		existing, err := MonthlyObligations(db, party.CustomerID, loan.ID)
+		if err != nil {
+			return err
+		}
+		ratio := (existing + loan.MonthlyPayment*party.LiabilityPercent/100) / customer.MonthlyIncome
+		if ratio > limit {
+			return &AffordabilityError{CustomerID: party.CustomerID, Role: party.Role, Ratio: money.Cents(ratio), Limit: limit}
+		}
+	}
+	return nil
+}
+
+// CheckGuarantors returns ErrUnconfirmedGuarantor while any of the loan's guarantors has not confirmed
+func CheckGuarantors(db *gorm.DB, loanID uint) error {
+	var unconfirmed int64
+	err := db.Model(&models.LoanParty{}).
+		Where("loan_id = ? AND role = ? AND confirmed_at IS NULL", loanID, RoleGuarantor).Count(&unconfirmed).Error
+	if err != nil {
+		return err
+	}
+	if unconfirmed > 0 {
+		return ErrUnconfirmedGuarantor
+	}
+	return nil
+}
+
+// Disburse opens the loan account, pays the principal out and activates the loan inside an open transaction
+// Every guarantor must have confirmed first; by is recorded in both status histories
+func Disburse(tx *gorm.DB, loan *models.Loan, accountNumber, by string, now time.Time) (models.Account, error) {
+	if err := CheckGuarantors(tx, loan.ID); err != nil {
+		return models.Account{}, err
+	}
+
+	account := models.Account{
+		TenantID:      loan.TenantID,
+		CustomerID:    loan.CustomerID,
+		AccountNumber: accountNumber,
+		AccountType:   "loan",
+		Balance:       0, // The disbursement posting takes it negative - negative balance represents debt
+		Currency:      "USD",
+		Status:        "active",
+	}
+	if err := tx.Create(&account).Error; err != nil {
+		return account, err
+	}
+	if err := statushistory.Created(tx, account.TenantID, statushistory.SubjectAccount, account.ID, account.Status, by); err != nil {
+		return account, err
+	}
+
+	previous := loan.Status
+	loan.AccountID = account.ID
+	loan.Status = "active"
+	loan.DisbursementDate = businessdays.Format(now)
+	loan.DueDate = now.AddDate(0, loan.LoanTerm, 0).Format("2006-01-02")
+	err := tx.Model(loan).Updates(map[string]interface{}{
+		"account_id":        loan.AccountID,
+		"status":            loan.Status,
+		"disbursement_date": loan.DisbursementDate,
+		"due_date":          loan.DueDate,
+	}).Error
+	if err != nil {
+		return account, err
+	}
+	err = statushistory.Record(tx, models.StatusHistory{
+		TenantID:    loan.TenantID,
+		SubjectType: statushistory.SubjectLoan,
+		SubjectID:   loan.ID,
+		OldStatus:   previous,
+		NewStatus:   loan.Status,
+		Reason:      ReasonDisbursed,
+		ChangedBy:   by,
+		ChangedAt:   now,
+	})
+	if err != nil {
+		return account, err
+	}
+	return ledger.Disburse(tx, account, loan.PrincipalAmount, loan.LoanNumber)
+}
@@ -0,0 +1,220 @@
+package loans
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/enrichment"
+	"banking-app/finance"
+	"banking-app/flags"
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/liens"
+	"banking-app/models"
+	"banking-app/money"
+	"banking-app/statushistory"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Payment errors - handlers map these to client responses; amounts are checked with ledger.ValidateAmount
+var (
+	ErrLoanClosed    = errors.New("loan is not active")
+	ErrExceedsPayoff = errors.New("payment exceeds the payoff amount")
+	ErrCurrency      = errors.New("funding account is in another currency than the loan")
+)
+
+// AccruedInterest is simple daily interest on the remaining balance from since to asOf
+// Uses an actual/365 day count, rounded to cents
+func AccruedInterest(loan models.Loan, since, asOf time.Time) float64 {
+	return finance.Interest(loan.RemainingBalance, loan.InterestRate, finance.Days(since, asOf))
+}
+
+// Remaining projects an active loan's remaining repayments from now with extra added to each payment. Interest runs
+// from the latest payment as Pay charges it, and installments fall monthly on the disbursement day as in
+// NextInstallment
+func Remaining(tx *gorm.DB, loan models.Loan, extra float64, now time.Time) ([]finance.Installment, error) {
+	if loan.Status != "active" || len(loan.DisbursementDate) < 10 {
+		return nil, ErrLoanClosed
+	}
+	disbursed, err := businessdays.ParseDate(loan.DisbursementDate[:10])
+	if err != nil {
+		return nil, ErrLoanClosed
+	}
+	since, err := interestSince(tx, loan)
+	if err != nil {
+		return nil, err
+	}
+	first := 1
+	for !disbursed.AddDate(0, first, 0).After(now) {
+		first++
+	}
+	return finance.Schedule(loan.RemainingBalance, loan.InterestRate, loan.MonthlyPayment+extra, since, disbursed, first)
+}
+
+// Allocate splits a payment between accrued interest and principal; interest is settled first
+func Allocate(amount, accrued money.Money) (interest, principal money.Money, err error) {
+	if interest, err = amount.Min(accrued); err != nil {
+		return interest, principal, err
+	}
+	principal, err = amount.Sub(interest)
+	return interest, principal, err
+}
+
+// Currency is the currency a loan is repaid in: its loan account's, or for loans opened before the loan account
+// was linked, the funding account's
+func Currency(tx *gorm.DB, loan models.Loan, funding models.Account) (string, error) {
+	if loan.AccountID == 0 {
+		return funding.Currency, nil
+	}
+	var account models.Account
+	err := tx.Select("id, currency").First(&account, loan.AccountID).Error
+	return account.Currency, err
+}
+
+// interestSince is the date interest last settled: the latest payment, else disbursement
+func interestSince(tx *gorm.DB, loan models.Loan) (time.Time, error) {
+	var last models.LoanPayment
+	err := tx.Where("loan_id = ?", loan.ID).Order("paid_at DESC, id DESC").First(&last).Error
+	if err == nil {
+		return last.PaidAt, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return time.Time{}, err
+	}
+	// Date columns read back as either YYYY-MM-DD or a full timestamp depending on the driver
+	if len(loan.DisbursementDate) >= 10 {
+		if disbursed, err := businessdays.ParseDate(loan.DisbursementDate[:10]); err == nil {
+			return disbursed, nil
+		}
+	}
+	return loan.CreatedAt, nil
+}
+
+// Pay debits a funding account and applies the amount to a loan inside an open transaction
+// The allocation record is what interest-paid reporting reads, so it is written with the posting
+// The funding account must be in the loan's currency, and its balance after the debit must still cover its
+// active liens. The payment that pays the loan off is recorded in its status history as by's
+func Pay(tx *gorm.DB, loan *models.Loan, accountID uint, value float64, by string, featureFlags *flags.Store) (models.LoanPayment, models.Account, error) {
+	var payment models.LoanPayment
+	var account models.Account
+
+	if err := ledger.ValidateAmount(value); err != nil {
+		return payment, account, err
+	}
+	if loan.Status != "active" {
+		return payment, account, ErrLoanClosed
+	}
+	if err := tx.Select("id, currency").First(&account, accountID).Error; err != nil {
+		return payment, account, err
+	}
+	currency, err := Currency(tx, *loan, account)
+	if err != nil {
+		return payment, account, err
+	}
+	if money.Same(currency, account.Currency) != nil {
+		return payment, account, ErrCurrency
+	}
+
+	now := clock.Now().UTC()
+	since, err := interestSince(tx, *loan)
+	if err != nil {
+		return payment, account, err
+	}
+	amount := money.FromFloat(value, currency)
+	accrued := money.FromFloat(AccruedInterest(*loan, since, now), currency)
+	remaining := money.FromFloat(loan.RemainingBalance, currency)
+	payoff, err := accrued.Add(remaining)
+	if err != nil {
+		return payment, account, err
+	}
+	if over, err := payoff.Less(amount); err != nil || over {
+		if err == nil {
+			err = ErrExceedsPayoff
+		}
+		return payment, account, err
+	}
+	interest, principal, err := Allocate(amount, accrued)
+	if err != nil {
+		return payment, account, err
+	}
+
+	posting := models.Transaction{
+		TransactionID:   ledger.NewTransactionID(),
+		AccountID:       accountID,
+		TransactionType: "payment",
+		Amount:          amount.Float(),
+		Description:     fmt.Sprintf("Loan payment %s", loan.LoanNumber),
+		Reference:       loan.LoanNumber,
+		Channel:         enrichment.DefaultChannel,
+		EffectiveDate:   now,
+	}
+	account, err = ledger.Post(tx, &posting, featureFlags)
+	if err != nil {
+		return payment, account, err
+	}
+	if err := liens.Enforce(tx, account); err != nil {
+		return payment, account, err
+	}
+
+	// Interest is income; principal pays down the loan account's receivable
+	if interest.IsPositive() {
+		if _, err := ledger.OffsetTo(tx, posting, account, gl.InterestIncome, interest.Float()); err != nil {
+			return payment, account, err
+		}
+	}
+	if principal.IsPositive() {
+		if loan.AccountID != 0 {
+			_, err = ledger.Offset(tx, posting, loan.AccountID, principal.Float())
+		} else {
+			_, err = ledger.OffsetTo(tx, posting, account, gl.Cash, principal.Float())
+		}
+		if err != nil {
+			return payment, account, err
+		}
+	}
+
+	previous := loan.Status
+	if remaining, err = remaining.Sub(principal); err != nil {
+		return payment, account, err
+	}
+	loan.RemainingBalance = remaining.Float()
+	if !remaining.IsPositive() {
+		loan.RemainingBalance = 0
+		loan.Status = "paid_off"
+	}
+	err = tx.Model(loan).Updates(map[string]interface{}{
+		"remaining_balance": loan.RemainingBalance,
+		"status":            loan.Status,
+	}).Error
+	if err != nil {
+		return payment, account, err
+	}
+	err = statushistory.Record(tx, models.StatusHistory{
+		TenantID:    loan.TenantID,
+		SubjectType: statushistory.SubjectLoan,
+		SubjectID:   loan.ID,
+		OldStatus:   previous,
+		NewStatus:   loan.Status,
+		Reason:      statushistory.ReasonPaidOff,
+		ChangedBy:   by,
+	})
+	if err != nil {
+		return payment, account, err
+	}
+
+	payment = models.LoanPayment{
+		LoanID:           loan.ID,
+		AccountID:        accountID,
+		TransactionID:    posting.ID,
+		PaidAt:           now,
+		Amount:           amount.Float(),
+		InterestPortion:  interest.Float(),
+		PrincipalPortion: principal.Float(),
+		BalanceAfter:     loan.RemainingBalance,
+	}
+	err = tx.Create(&payment).Error
+	return payment, account, err
+}
@@ -0,0 +1,394 @@
+package loans
+
+import (
+	"banking-app/businessdays"
+	"banking-app/communications"
+	"banking-app/display"
+	"banking-app/events"
+	"banking-app/flags"
+	"banking-app/models"
+	"banking-app/money"
+	"banking-app/notifications"
+	"banking-app/statushistory"
+	"banking-app/tenancy"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Collection statuses
+const (
+	CollectionCollected = "collected"
+	CollectionShort     = "short"
+)
+
+// Payment split errors - handlers map these to client responses
+var (
+	ErrSplitTotal     = errors.New("share percentages must add up to 100")
+	ErrSplitParty     = errors.New("only the borrower and co-borrowers of the loan fund installments")
+	ErrSplitAccount   = errors.New("funding account must be an active account of the party")
+	ErrSplitFallback  = errors.New("falling back to the borrower needs a funding account for the borrower")
+	ErrSplitDisbursed = errors.New("loan has no disbursement date to schedule installments from")
+	ErrSplitDuplicate = errors.New("a party can only have one share")
+)
+
+// Share is one party's part of a payment split
+type Share struct {
+	PartyID   uint    `json:"party_id"`
+	AccountID uint    `json:"account_id"`
+	Percent   float64 `json:"percent"`
+}
+
+// ConfigureSplit sets how an active loan's installments are funded, replacing any split it had, inside an open
+// transaction. Shares must add up to 100; parties left out fund nothing. The next installment is the first one on
+// the loan's schedule after now
+func ConfigureSplit(tx *gorm.DB, loan models.Loan, shares []Share, fallback bool, by string, now time.Time) (models.LoanSplit, error) {
+	var split models.LoanSplit
+	if loan.Status != "active" {
+		return split, ErrLoanClosed
+	}
+	next, err := NextInstallment(loan, now)
+	if err != nil {
+		return split, err
+	}
+
+	var parties []models.LoanParty
+	if err := tx.Where("loan_id = ?", loan.ID).Find(&parties).Error; err != nil {
+		return split, err
+	}
+	byID := make(map[uint]models.LoanParty, len(parties))
+	for _, p := range parties {
+		byID[p.ID] = p
+	}
+
+	total := 0.0
+	seen := map[uint]bool{}
+	borrowerFunded := false
+	for _, share := range shares {
+		party, ok := byID[share.PartyID]
+		if !ok || (party.Role != RoleBorrower && party.Role != RoleCoBorrower) {
+			return split, ErrSplitParty
+		}
+		if seen[share.PartyID] {
+			return split, ErrSplitDuplicate
+		}
+		seen[share.PartyID] = true
+		if share.Percent < 0 || share.Percent > 100 {
+			return split, ErrSplitTotal
+		}
+		var account models.Account
+		if err := tx.Select("id, customer_id, status, account_type").First(&account, share.AccountID).Error; err != nil ||
+			account.CustomerID != party.CustomerID || account.Status != "active" || account.AccountType == "loan" {
+			return split, ErrSplitAccount
+		}
+		borrowerFunded = borrowerFunded || party.Role == RoleBorrower
+		total += share.Percent
+	}
+	if math.Abs(total-100) > 0.001 {
+		return split, ErrSplitTotal
+	}
+	if fallback && !borrowerFunded {
+		return split, ErrSplitFallback
+	}
+
+	err = tx.Model(&models.LoanParty{}).Where("loan_id = ?", loan.ID).
+		Updates(map[string]interface{}{"share_percent": 0, "funding_account_id": nil}).Error
+	if err != nil {
+		return split, err
+	}
+	for _, share := range shares {
+		err := tx.Model(&models.LoanParty{}).Where("id = ?", share.PartyID).
+			Updates(map[string]interface{}{"share_percent": share.Percent, "funding_account_id": share.AccountID}).Error
+		if err != nil {
+			return split, err
+		}
+	}
+
+	err = tx.Where("loan_id = ?", loan.ID).First(&split).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return split, err
+	}
+	split.TenantID = loan.TenantID
+	split.LoanID = loan.ID
+	split.FallbackToBorrower = fallback
+	split.NextDueDate = next
+	split.ConfiguredBy = by
+	return split, tx.Save(&split).Error
+}
+
+// NextInstallment is the first installment date on a loan's schedule after a time
+// Installments fall monthly on the disbursement day, as in the delinquency report
+func NextInstallment(loan models.Loan, after time.Time) (time.Time, error) {
+	// Date columns read back as either YYYY-MM-DD or a full timestamp depending on the driver
+	if len(loan.DisbursementDate) < 10 {
+		return time.Time{}, ErrSplitDisbursed
+	}
+	disbursed, err := businessdays.ParseDate(loan.DisbursementDate[:10])
+	if err != nil {
+		return time.Time{}, ErrSplitDisbursed
+	}
+	for k := 1; ; k++ {
+		if due := disbursed.AddDate(0, k, 0); due.After(after) {
+			return due, nil
+		}
+	}
+}
+
+// Collect takes the split's next installment inside an open transaction
+// Each funding party's share is attempted on its own, so one party's lack of funds does not stop the others. With
+// fallback on, the borrower's account is then charged for each co-borrower share that failed. No debit is more than
+// the loan's payoff, so a loan the earlier shares paid off leaves the rest owing nothing, and the installment is
+// what the shares came to. Parties whose debit failed are sent a notice; a loan.installment_short event is
+// recorded only when the installment as a whole is still short afterwards
+func Collect(tx *gorm.DB, split *models.LoanSplit, featureFlags *flags.Store, now time.Time) (models.LoanCollection, error) {
+	collection := models.LoanCollection{TenantID: split.TenantID, LoanID: split.LoanID, ScheduledDate: split.NextDueDate}
+
+	var loan models.Loan
+	if err := tx.First(&loan, split.LoanID).Error; err != nil {
+		return collection, err
+	}
+	if loan.Status != "active" {
+		return collection, ErrLoanClosed
+	}
+	payoff, err := PayoffAmount(tx, loan, now)
+	if err != nil {
+		return collection, err
+	}
+	collection.Amount = money.Cents(math.Min(loan.MonthlyPayment, payoff))
+
+	var parties []models.LoanParty
+	if err := tx.Where("loan_id = ? AND share_percent > 0 AND funding_account_id IS NOT NULL", loan.ID).Order("id").Find(&parties).Error; err != nil {
+		return collection, err
+	}
+	var borrower *models.LoanParty
+	for i := range parties {
+		if parties[i].Role == RoleBorrower {
+			borrower = &parties[i]
+		}
+	}
+
+	// The installment is allocated by each party's share in basis points; the cents left over by rounding go to the
+	// parties that lost the most to it, so the shares always add up to the installment
+	weights := make([]int64, len(parties))
+	for i, party := range parties {
+		weights[i] = int64(math.Round(party.SharePercent * 100))
+	}
+	var amounts []money.Money
+	if len(parties) > 0 {
+		currency, err := Currency(tx, loan, models.Account{})
+		if err != nil {
+			return collection, err
+		}
+		if amounts, err = money.FromFloat(collection.Amount, currency).Allocate(weights...); err != nil {
+			return collection, err
+		}
+	}
+	// Each debit is capped at what still settles the loan, so once earlier shares have paid it off the rest are
+	// owed nothing rather than failing against a closed loan
+	owed := func(amount float64) (float64, error) {
+		if loan.Status != "active" {
+			return 0, nil
+		}
+		payoff, err := PayoffAmount(tx, loan, now)
+		if err != nil {
+			return 0, err
+		}
+		return money.Cents(math.Min(amount, payoff)), nil
+	}
+	var failed []models.LoanCollectionShare
+	due := 0.0
+	for i, party := range parties {
+		amount, err := owed(amounts[i].Float())
+		if err != nil {
+			return collection, err
+		}
+		due += amount
+		share := attempt(tx, &loan, party, *party.FundingAccountID, amount, nil, featureFlags)
+		collection.Shares = append(collection.Shares, share)
+		if share.Error != "" {
+			failed = append(failed, share)
+		}
+	}
+	if len(parties) > 0 {
+		collection.Amount = money.Cents(due)
+	}
+
+	for _, share := range failed {
+		if !split.FallbackToBorrower || borrower == nil || share.PartyID == borrower.ID {
+			continue
+		}
+		amount, err := owed(share.Amount)
+		if err != nil {
+			return collection, err
+		}
+		if amount == 0 {
+			continue
+		}
+		covers := share.PartyID
+		cover := attempt(tx, &loan, *borrower, *borrower.FundingAccountID, amount, &covers, featureFlags)
+		collection.Shares = append(collection.Shares, cover)
+	}
+
+	for _, share := range collection.Shares {
+		collection.Collected += share.Collected
+	}
+	collection.Collected = money.Cents(collection.Collected)
+	paidOff := loan.Status != "active"
+	if paidOff {
+		// Nothing more was owed, whichever shares failed
+		collection.Amount = collection.Collected
+	}
+	collection.Shortfall = money.Cents(collection.Amount - collection.Collected)
+	collection.Status = CollectionCollected
+	if collection.Shortfall > 0 {
+		collection.Status = CollectionShort
+	}
+	if err := tx.Create(&collection).Error; err != nil {
+		return collection, err
+	}
+
+	for _, share := range failed {
+		if paidOff {
+			break
+		}
+		covered := false
+		for _, cover := range collection.Shares {
+			covered = covered || (cover.CoversPartyID != nil && *cover.CoversPartyID == share.PartyID && cover.Error == "")
+		}
+		if err := notifyFailedShare(tx, loan, collection, share, covered); err != nil {
+			return collection, err
+		}
+	}
+	for _, cover := range collection.Shares {
+		if cover.CoversPartyID != nil && cover.Error != "" {
+			if err := notifyFailedShare(tx, loan, collection, cover, false); err != nil {
+				return collection, err
+			}
+		}
+	}
+	if collection.Status == CollectionShort {
+		if err := events.Record(tx, events.AggregateLoan, loan.ID, events.LoanInstallmentShort, collection); err != nil {
+			return collection, err
+		}
+	}
+
+	next, err := NextInstallment(loan, split.NextDueDate)
+	if err != nil {
+		return collection, err
+	}
+	split.NextDueDate = next
+	split.LastCollectedAt = &now
+	return collection, tx.Model(split).Updates(map[string]interface{}{"next_due_date": next, "last_collected_at": now}).Error
+}
+
+// attempt debits one share in a savepoint, so a failed debit leaves the rest of the collection standing
+// The loan is only changed by a debit that went through
+func attempt(tx *gorm.DB, loan *models.Loan, party models.LoanParty, accountID uint, amount float64, covers *uint, featureFlags *flags.Store) models.LoanCollectionShare {
+	share := models.LoanCollectionShare{PartyID: party.ID, CustomerID: party.CustomerID, AccountID: accountID, CoversPartyID: covers, Amount: amount}
+	if amount <= 0 {
+		return share
+	}
+	before := *loan
+	err := tx.Transaction(func(stx *gorm.DB) error {
+		payment, _, err := Pay(stx, loan, accountID, amount, statushistory.SystemActor, featureFlags)
+		if err != nil {
+			return err
+		}
+		if err := stx.Model(&payment).Updates(map[string]interface{}{"party_id": party.ID, "covers_party_id": covers}).Error; err != nil {
+			return err
+		}
+		share.PaymentID = &payment.ID
+		return nil
+	})
+	if err != nil {
+		*loan = before
+		share.PaymentID = nil
+		share.Error = err.Error()
+		log.Printf("loans: split share of %.2f for loan %s from account %d failed: %v", amount, loan.LoanNumber, accountID, err)
+		return share
+	}
+	share.Collected = amount
+	return share
+}
+
+// notifyFailedShare tells the party whose debit failed, saying whether the borrower covered it
+func notifyFailedShare(tx *gorm.DB, loan models.Loan, collection models.LoanCollection, share models.LoanCollectionShare, covered bool) error {
+	var customer models.Customer
+	tx.Select("id, email, email_verified").Limit(1).Find(&customer, share.CustomerID)
+	if customer.Email == "" || !customer.EmailVerified {
+		log.Printf("loans: customer %d has no verified email for the failed share of loan %s", share.CustomerID, loan.LoanNumber)
+		return nil
+	}
+	var account models.Account
+	tx.Select("id, account_number").Limit(1).Find(&account, share.AccountID)
+
+	outcome := fmt.Sprintf("The installment is still %.2f short; please make a payment.", collection.Shortfall)
+	if covered {
+		outcome = "The borrower's account was charged for it instead."
+	}
+	return notifications.Enqueue(tx, &models.Notification{
+		CustomerID:   share.CustomerID,
+		Channel:      "email",
+		ResourceType: communications.ResourceLoanCollection,
+		ResourceID:   collection.ID,
+		Recipient:    customer.Email,
+		Subject:      "Your loan payment share could not be collected",
+		Body: fmt.Sprintf("Your share of %.2f of the loan %s installment due %s could not be collected from account %s. %s",
+			share.Amount, loan.LoanNumber, businessdays.Format(collection.ScheduledDate), display.MaskAccountNumber(account.AccountNumber), outcome),
+	})
+}
+
+// PayoffAmount is what settles a loan now: its remaining balance plus interest accrued since it last settled
+func PayoffAmount(tx *gorm.DB, loan models.Loan, now time.Time) (float64, error) {
+	since, err := interestSince(tx, loan)
+	if err != nil {
+		return 0, err
+	}
+	return money.Cents(AccruedInterest(loan, since, now) + loan.RemainingBalance), nil
+}
+
+// splitLookahead is how far ahead of its due date an installment can be collected under the preceding
+// convention - longer than any run of weekends and holidays
+const splitLookahead = 7
+
+// CollectSplits collects every split installment due by now, each loan in its own transaction and
+// in its tenant's scope. A due date on a weekend or holiday moves by the convention, as for installment plans.
+// Returns the installments collected in full and those left short
+func CollectSplits(db *gorm.DB, featureFlags *flags.Store, now time.Time, convention string) (collected, short int, err error) {
+	calendar, err := businessdays.Load(db)
+	if err != nil {
+		return 0, 0, err
+	}
+	var splits []models.LoanSplit
+	err = db.Joins("JOIN loans ON loans.id = loan_splits.loan_id AND loans.status = ?", "active").
+		Where("loan_splits.next_due_date <= ?", now.AddDate(0, 0, splitLookahead)).Order("loan_splits.id").Find(&splits).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	for i := range splits {
+		split := &splits[i]
+		if calendar.Adjust(split.NextDueDate, convention).After(now) {
+			continue
+		}
+		var collection models.LoanCollection
+		scoped := db.WithContext(tenancy.NewContext(db.Statement.Context, split.TenantID))
+		err := scoped.Transaction(func(tx *gorm.DB) error {
+			var err error
+			collection, err = Collect(tx, split, featureFlags, now)
+			return err
+		})
+		switch {
+		case err != nil:
+			log.Printf("loans: split collection for loan %d failed: %v", split.LoanID, err)
+		case collection.Status == CollectionShort:
+			short++
+		default:
+			collected++
+		}
+	}
+	return collected, short, nil
+}
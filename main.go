@@ -1,13 +1,77 @@
 package main
 
 import (
+	"banking-app/alerts"
+	"banking-app/apiversion"
+	"banking-app/applications"
+	"banking-app/archive"
+	"banking-app/auth"
+	"banking-app/authorizations"
+	"banking-app/bulkops"
+	"banking-app/campaigns"
+	"banking-app/businessdays"
+	"banking-app/cache"
+	"banking-app/certificates"
+	"banking-app/clock"
+	"banking-app/compression"
+	"banking-app/conversions"
+	"banking-app/creditbureau"
+	"banking-app/creditlines"
 	"banking-app/database"
+	"banking-app/descriptors"
+	"banking-app/disputes"
+	"banking-app/duplicates"
+	"banking-app/eod"
+	"banking-app/escheat"
+	"banking-app/events"
+	"banking-app/exceptions"
+	"banking-app/externalaccounts"
+	"banking-app/flags"
+	"banking-app/fx"
 	"banking-app/handlers"
+	"banking-app/i18n"
+	"banking-app/installments"
+	"banking-app/interest"
+	"banking-app/invariants"
+	"banking-app/jobs"
+	"banking-app/lifecycle"
+	"banking-app/loadshed"
+	"banking-app/loans"
+	"banking-app/maintenance"
+	"banking-app/metrics"
+	"banking-app/middleware"
+	"banking-app/notifications"
+	"banking-app/oauth"
+	"banking-app/ratelimit"
+	"banking-app/reconcile"
+	"banking-app/relationship"
+	"banking-app/reviews"
+	"banking-app/sandbox"
+	"banking-app/sar"
+	"banking-app/search"
+	"banking-app/siem"
+	"banking-app/slowquery"
+	"banking-app/statements"
+	"banking-app/statushistory"
+	"banking-app/subscriptions"
+	"banking-app/tags"
+	"banking-app/tenancy"
+	"banking-app/tracing"
+	"banking-app/transfers"
+	"banking-app/uploads"
+	"banking-app/usage"
+	"banking-app/verification"
+	"banking-app/writequeue"
+	"context"
 	"log"
+	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // Application configuration - easily configurable via environment variables
@@ -16,9 +80,59 @@ const (
 )
 
 func main() {
+	// Sandbox mode - a fake clock and a database of its own; only available in builds with -tags sandbox
+	sandboxConfig := sandbox.ConfigFromEnv()
+	if sandboxConfig.Enabled && !sandbox.Available {
+		log.Fatal(sandbox.ErrUnavailable)
+	}
+	// Bank time - days, statement months and job schedules begin at midnight in BANK_TIMEZONE
+	location, err := businessdays.LocationFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	businessdays.SetLocation(location)
+	convention, err := businessdays.ConventionFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Daily cutoffs by transaction type - later submissions are valued the next business day; tenants may override
+	if tenancy.Global.Cutoffs, err = businessdays.CutoffsFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	// Soft credit checks in loan review - off unless CREDIT_BUREAU is set
+	creditConfig, err := creditbureau.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	siemConfig, err := siem.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Webhook circuit breaker, backlog cap and replay rate
+	webhookConfig, err := events.WebhookConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	eodConfig, err := eod.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	usageConfig, err := usage.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dbPath := database.PathFromEnv()
+	if sandboxConfig.Enabled {
+		if sandboxConfig.DBPath == dbPath {
+			log.Fatal("SANDBOX_DB_PATH must differ from DB_PATH")
+		}
+		dbPath = sandboxConfig.DBPath
+	}
+
 	// Initialize database connection
 	// Critical first step - application cannot function without database
-	db, err := database.InitDatabase()
+	db, err := database.InitDatabase(dbPath)
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
@@ -31,16 +145,387 @@ func main() {
 		}
 	}()
 
+	// Write queue - with SQLite, write transactions take turns so they never fail on a locked database
+	// Installed before anything derives a session from db, so every handler, job and worker uses it
+	if db.Dialector.Name() == "sqlite" && writequeue.EnabledFromEnv() {
+		if err := database.QueueWrites(db, writequeue.New(database.BusyTimeoutFromEnv())); err != nil {
+			log.Fatal("Failed to queue database writes:", err)
+		}
+	}
+
+	// Status lifecycles - every model with a status field declares its transitions, or the server does not start
+	if err := lifecycle.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if missing := lifecycle.Undeclared(database.Models()...); len(missing) > 0 {
+		log.Fatal("No status lifecycle declared for: ", strings.Join(missing, ", "))
+	}
+
+	// Multi-tenancy - queries made through a tenant-scoped context are filtered automatically
+	if err := tenancy.RegisterCallbacks(db); err != nil {
+		log.Fatal("Failed to register tenant scoping:", err)
+	}
+	if err := tenancy.EnsureDefault(db); err != nil {
+		log.Fatal("Failed to create default tenant:", err)
+	}
+
+	// Tracing - request, query, outbox and job spans exported over OTLP when OTEL_EXPORTER_OTLP_ENDPOINT is set
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.ConfigFromEnv())
+	if err != nil {
+		log.Fatal("Failed to set up tracing:", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("Error flushing traces: %v", err)
+		}
+	}()
+	if err := tracing.RegisterCallbacks(db); err != nil {
+		log.Fatal("Failed to register query tracing:", err)
+	}
+
+	// Slow query log - statements over the threshold are recorded with the route that ran them
+	slowQueries := slowquery.New(slowquery.ConfigFromEnv())
+	if err := slowQueries.Register(db); err != nil {
+		log.Fatal("Failed to register slow query log:", err)
+	}
+
+	// Full-text transaction search - FTS5 on SQLite when available
+	searcher := search.Setup(db)
+
+	// Balance cache for polled balance lookups - postings write the new balance through as they commit
+	balances := cache.NewBalances(cache.BalanceConfigFromEnv())
+
+	// Traced money flows, per query - transfers posted since a flow was traced show up once it expires
+	flows := cache.NewQueries(30*time.Second, 256)
+
+	// Operations dashboard summaries, per tenant - a summary is at most 30 seconds behind
+	summaries := cache.NewQueries(30*time.Second, 64)
+
+	// Feature flags for risky behaviors - defaults are created disabled
+	if err := flags.EnsureDefaults(db); err != nil {
+		log.Fatal("Failed to create default feature flags:", err)
+	}
+	featureFlags := flags.NewStore(db)
+
+	// Customer uploads - validated, malware-scanned, then stored; also holds statements and the communication log
+	documentUploads, err := uploads.NewPipeline()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Background workers - notification delivery and daily alert evaluation
+	// Every scheduled job runs through the maintenance mode, which pauses them while the API is read-only
+	stop := make(chan struct{})
+	defer close(stop)
+	maintenanceMode := maintenance.New(db)
+	maintenanceMode.Start(10*time.Second, stop)
+	maintenanceMode.Every("notifications", 30*time.Second, stop, notifications.NewDispatcher(db, documentUploads.Storage).DispatchPending)
+
+	// Transactional outbox - publishes committed domain events to SSE streams and each webhook subscription's queue
+	broker := events.NewBroker()
+	// Customers' subscriptions on their own accounts only receive events while the account_webhooks flag is on for them
+	webhookPublisher := events.NewWebhookPublisher(db, webhookConfig)
+	webhookPublisher.Launched = func(customerID uint) bool { return featureFlags.Enabled(flags.AccountWebhooks, customerID) }
+	outbox := &events.Dispatcher{
+		DB:         db,
+		Publishers: []events.Publisher{broker, webhookPublisher},
+	}
+	maintenanceMode.Every("outbox", 2*time.Second, stop, outbox.DispatchPending)
+
+	// Webhook delivery - sends each subscription's queue in order, suspending endpoints that keep failing
+	maintenanceMode.Every("webhooks", time.Second, stop, events.NewWebhookDeliverer(db, webhookConfig).DeliverPending)
+
+	// API client usage - counted in memory and added to the daily usage records on every flush
+	usageMeter := usage.NewMeter(db)
+	maintenanceMode.Every("usage", usageConfig.FlushInterval, stop, func() {
+		if err := usageMeter.Flush(); err != nil {
+			log.Printf("usage: flush failed: %v", err)
+		}
+	})
+
+	// SIEM forwarding - ships the audit log to security's SIEM past a persisted high-water mark; off unless SIEM_SINK is set
+	var siemForwarder *siem.Forwarder
+	if siemConfig.Enabled() {
+		siemForwarder = siem.New(db, siemConfig)
+		maintenanceMode.Every("siem", siemConfig.Interval, stop, siemForwarder.Forward)
+	}
+
+	// Flag cache refreshes every 30s and as soon as a change event is published
+	flagChanges, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+	featureFlags.Start(30*time.Second, flagChanges, stop)
+	metrics.RegisterGauge("outbox_lag_seconds", "Age of the oldest undispatched outbox event", func() float64 {
+		return events.Lag(db).Seconds()
+	})
+
+	// Scheduled jobs - cron schedules overridable with JOB_SCHEDULE_<NAME>; each run is recorded and holds
+	// a database lease, so instances sharing the database never run the same job at once
+	// Jobs query through their run's context, so each run is traced with its statements
+	jobScheduler := jobs.NewScheduler(db, maintenanceMode, maintenance.PausedRetry)
+	registerJob := func(job jobs.Job, spec string) {
+		if err := jobScheduler.Register(job, spec); err != nil {
+			log.Fatal("Invalid job schedule:", err)
+		}
+	}
+	registerJob(jobs.Func("alerts", func(ctx context.Context) (int, error) {
+		alerts.EvaluateDueDates(db.WithContext(ctx), clock.Now())
+		return 0, nil
+	}), "0 6 * * *")
+
+	// Escheatment, an end-of-day step - final notices, then turnover of long-dormant balances
+	escheatConfig := escheat.ConfigFromEnv()
+	registerJob(jobs.Func("escheat", func(ctx context.Context) (int, error) {
+		result, err := escheat.Run(db.WithContext(ctx), escheatConfig, clock.Now())
+		if result != (escheat.Result{}) {
+			log.Printf("escheat: %d noticed, %d cancelled, %d escheated", result.Noticed, result.Cancelled, result.Escheated)
+		}
+		return result.Noticed + result.Cancelled + result.Escheated, err
+	}), "off")
+
+	// Daily return of suspense items nobody resolved in time
+	exceptionConfig := exceptions.ConfigFromEnv()
+	metrics.RegisterGauge("exceptions_open", "Incoming credits held in suspense awaiting resolution", func() float64 {
+		count, _ := exceptions.OpenCount(db)
+		return float64(count)
+	})
+	registerJob(jobs.Func("exceptions", func(ctx context.Context) (int, error) {
+		return exceptions.AutoReturn(db.WithContext(ctx), exceptionConfig, clock.Now())
+	}), "30 2 * * *")
+
+	// Nightly scan for balances below their floor and postings that do not chain, reported beside the exception queue
+	metrics.RegisterGauge("invariant_violations_open", "Broken balance invariants awaiting review", func() float64 {
+		count, _ := invariants.OpenCount(db)
+		return float64(count)
+	})
+	registerJob(jobs.Func("invariants", func(ctx context.Context) (int, error) {
+		return invariants.Scan(db.WithContext(ctx), clock.Now())
+	}), "45 2 * * *")
+
+	// Monthly relationship tiers - each customer's tier for the month, which fees and savings rates are priced with
+	registerJob(jobs.Func("relationship-tiers", func(ctx context.Context) (int, error) {
+		return relationship.Recompute(db.WithContext(ctx), 0, clock.Now())
+	}), "0 4 1 * *")
+
+	// Daily purge of external account links whose micro-deposits were not confirmed in time
+	registerJob(jobs.Func("external-accounts", func(ctx context.Context) (int, error) {
+		return externalaccounts.Purge(db.WithContext(ctx), clock.Now())
+	}), "30 4 * * *")
+
+	// Daily expiry of account application drafts not submitted within 30 days
+	registerJob(jobs.Func("application-expiry", func(ctx context.Context) (int, error) {
+		return applications.Expire(db.WithContext(ctx), clock.Now())
+	}), "45 4 * * *")
+
+	// Held first debits from new accounts post on their own once their review time passes undecided
+	reviewConfig := reviews.ConfigFromEnv()
+	transferConfig := transfers.ConfigFromEnv()
+	duplicateConfig := duplicates.ConfigFromEnv()
+	registerJob(jobs.Func("transaction-reviews", func(ctx context.Context) (int, error) {
+		return reviews.AutoApprove(db.WithContext(ctx), transferConfig, featureFlags, balances, clock.Now())
+	}), "*/5 * * * *")
+
+	// Pre-authorization holds not captured in time are released, and their customers told
+	authorizationConfig := authorizations.ConfigFromEnv()
+	registerJob(jobs.Func("authorization-expiry", func(ctx context.Context) (int, error) {
+		return authorizations.Expire(db.WithContext(ctx), clock.Now())
+	}), "*/5 * * * *")
+
+	// Next year's federal holidays, well before the calendar reaches them
+	registerJob(jobs.Func("holiday-seed", func(ctx context.Context) (int, error) {
+		return businessdays.SeedFederal(db.WithContext(ctx), businessdays.In(clock.Now()).Year()+1)
+	}), "0 1 1 12 *")
+
+	// Collection of installment plan payments through their backing loans, and of loan installments split between
+	// their parties, an end-of-day step
+	// Installments due on a weekend or holiday are collected on the business day the convention picks
+	installmentConfig := installments.ConfigFromEnv()
+	registerJob(jobs.Func("installments", func(ctx context.Context) (int, error) {
+		collected, missed, err := installments.CollectDue(db.WithContext(ctx), featureFlags, clock.Now(), convention)
+		if missed > 0 {
+			log.Printf("installments: %d collected, %d missed", collected, missed)
+		}
+		if err != nil {
+			return collected + missed, err
+		}
+		split, short, err := loans.CollectSplits(db.WithContext(ctx), featureFlags, clock.Now(), convention)
+		if short > 0 {
+			log.Printf("installments: %d split loan installments collected, %d short", split, short)
+		}
+		return collected + missed + split + short, err
+	}), "off")
+
+	// Interest accrual on drawn lines of credit, an end-of-day step
+	registerJob(jobs.Func("credit-lines", func(ctx context.Context) (int, error) {
+		return creditlines.Accrue(db.WithContext(ctx), clock.Now())
+	}), "off")
+
+	// Savings interest accrual at each product's rate on the day, posted at month end; an end-of-day step
+	rateConfig := interest.ConfigFromEnv()
+	registerJob(jobs.Func("interest-accrual", func(ctx context.Context) (int, error) {
+		return interest.Accrue(db.WithContext(ctx), featureFlags, balances, clock.Now())
+	}), "off")
+
+	// Product changes scheduled for the next statement cycle, applied once interest has accrued at the old rates,
+	// then last month's maintenance fees, pro-rated by the days on each product; both end-of-day steps
+	registerJob(jobs.Func("product-changes", func(ctx context.Context) (int, error) {
+		return conversions.ApplyDue(db.WithContext(ctx), featureFlags, clock.Now())
+	}), "off")
+	registerJob(jobs.Func("maintenance-fees", func(ctx context.Context) (int, error) {
+		return conversions.ChargeMaintenance(db.WithContext(ctx), featureFlags, balances, clock.Now())
+	}), "off")
+
+	// Nightly descriptors for postings that have none, such as those made before descriptors were generated
+	registerJob(jobs.Func("descriptor-backfill", func(ctx context.Context) (int, error) {
+		return descriptors.Backfill(db.WithContext(ctx), false)
+	}), "15 2 * * *")
+
+	// Queued bulk account operations, resumed after a restart
+	maintenanceMode.Every("bulk-operations", 2*time.Second, stop, func() {
+		bulkops.RunPending(db, balances, featureFlags)
+	})
+
+	// Announcement campaigns - started at their scheduled time and queued in rate-limited batches
+	campaignConfig := campaigns.ConfigFromEnv()
+	maintenanceMode.Every("campaigns", 2*time.Second, stop, func() {
+		campaigns.RunDue(db, campaignConfig, clock.Now())
+	})
+
+	// Nightly tiering - postings older than ARCHIVE_AFTER_MONTHS move to the archive table in reconciled batches;
+	// account history and statements read the archive too when their range reaches back that far
+	archiveConfig := archive.ConfigFromEnv()
+	registerJob(jobs.Func("archive", func(ctx context.Context) (int, error) {
+		result, err := archive.Run(db.WithContext(ctx), archiveConfig, clock.Now())
+		if result.Rows > 0 {
+			log.Printf("archive: %d transactions archived in %d batches", result.Rows, result.Batches)
+		}
+		return result.Rows, err
+	}), "0 3 * * *")
+
+	// SAR case deadlines - the compliance mailbox is reminded of cases due soon, and again of overdue ones
+	sarConfig := sar.ConfigFromEnv()
+	registerJob(jobs.Func("sar-deadlines", func(ctx context.Context) (int, error) {
+		return sar.Remind(db.WithContext(ctx), sarConfig, clock.Now())
+	}), "0 7 * * *")
+
+	// Dispute closure warnings - customers who have not answered a request for information are warned the dispute
+	// may close, copied to the disputes mailbox
+	disputeConfig := disputes.ConfigFromEnv()
+	registerJob(jobs.Func("dispute-warnings", func(ctx context.Context) (int, error) {
+		return disputes.Warn(db.WithContext(ctx), disputeConfig, clock.Now())
+	}), "0 8 * * *")
+
+	// Monthly statements - generated on each account's statement day, archived in document storage
+	// and emailed as an expiring download link
+	statementDelivery := statements.DeliveryConfigFromEnv()
+	registerJob(jobs.Func("statements", func(ctx context.Context) (int, error) {
+		result, err := statements.Dispatch(db.WithContext(ctx), documentUploads.Storage, statementDelivery, clock.Now())
+		if result != (statements.DispatchResult{}) {
+			log.Printf("statements: %d generated, %d emailed, %d archive-only, %d failed", result.Generated, result.Emailed, result.Archived, result.Failed)
+		}
+		return result.Generated, err
+	}), "0 * * * *")
+
+	// Statements for custom date ranges too large to generate while the customer waits, resumed after a restart
+	customStatements := statements.CustomConfigFromEnv()
+	maintenanceMode.Every("custom-statements", 2*time.Second, stop, func() {
+		statements.RunPending(db, documentUploads.Storage)
+	})
+
+	// Report subscriptions - reports rendered on their own cron schedules, archived in document storage
+	// and emailed or posted to a webhook; failed runs are retried and then alert the owner
+	reportSubscriptionConfig := subscriptions.ConfigFromEnv()
+	registerJob(jobs.Func("report-subscriptions", func(ctx context.Context) (int, error) {
+		result, err := subscriptions.Process(db.WithContext(ctx), documentUploads.Storage, reportSubscriptionConfig, clock.Now())
+		if result != (subscriptions.Result{}) {
+			log.Printf("report-subscriptions: %d runs, %d failed, %d delivered, %d paused", result.Runs, result.Failed, result.Delivered, result.Paused)
+		}
+		return result.Runs, err
+	}), "*/5 * * * *")
+
+	// FX revaluation, an end-of-day step - foreign-currency balances snapshotted at each day's closing rate, with
+	// the unrealized gain or loss posted to the general ledger; a missing rate fails that currency's day and the run
+	registerJob(jobs.Func("fx-revaluation", func(ctx context.Context) (int, error) {
+		result, err := fx.Run(db.WithContext(ctx), clock.Now())
+		if result != (fx.Result{}) {
+			log.Printf("fx-revaluation: %d posted, %d failed", result.Posted, result.Failed)
+		}
+		return result.Posted, err
+	}), "off")
+
+	// End of day - postings are paused while the nightly steps run in order, each recorded as a run of its job
+	// A step whose dependencies did not succeed is skipped; once the cause is fixed an admin resumes the run,
+	// which picks up at the failed step. A sandbox is one instance, so its posting window is never refreshed
+	postingWindow := eod.NewWindow(db, eodConfig)
+	if !sandboxConfig.Enabled {
+		postingWindow.Start(time.Second, stop)
+	}
+	registerJob(jobs.Func("posting-cutoff", func(ctx context.Context) (int, error) {
+		return postingWindow.Cutoff(eod.DrainTimeout)
+	}), "off")
+	endOfDay, err := eod.New(db, jobScheduler, postingWindow, []eod.Step{
+		{Job: "posting-cutoff"},
+		{Job: "credit-lines", DependsOn: []string{"posting-cutoff"}},
+		{Job: "interest-accrual", DependsOn: []string{"posting-cutoff"}},
+		{Job: "installments", DependsOn: []string{"posting-cutoff"}},
+		{Job: "product-changes", DependsOn: []string{"interest-accrual"}},
+		{Job: "maintenance-fees", DependsOn: []string{"product-changes"}},
+		{Job: "fx-revaluation", DependsOn: []string{"credit-lines", "interest-accrual", "installments", "maintenance-fees"}},
+		{Job: "escheat", DependsOn: []string{"posting-cutoff"}},
+		{Job: "statements", DependsOn: []string{"credit-lines", "interest-accrual", "installments", "maintenance-fees"}},
+		{Job: "report-subscriptions", DependsOn: []string{"fx-revaluation", "escheat"}},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	registerJob(endOfDay, "30 0 * * *")
+
+	// In sandbox mode scheduled jobs only run when the fake clock is advanced, at their scheduled times
+	var sandboxMode *sandbox.Sandbox
+	if sandboxConfig.Enabled {
+		sandboxMode, err = sandbox.New(sandboxConfig, db, jobScheduler, func(db *gorm.DB) error {
+			if err := database.Migrate(db); err != nil {
+				return err
+			}
+			search.Setup(db)
+			if err := tenancy.EnsureDefault(db); err != nil {
+				return err
+			}
+			if err := flags.EnsureDefaults(db); err != nil {
+				return err
+			}
+			balances.Clear()
+			return featureFlags.Refresh()
+		})
+		if err != nil {
+			log.Fatal("Failed to start sandbox mode:", err)
+		}
+		log.Printf("Sandbox mode: clock stopped at %s, data in %s", sandboxConfig.Start.Format(time.RFC3339), sandboxConfig.DBPath)
+	} else {
+		jobScheduler.Start(stop)
+	}
+
+	// Balance certificates are signed so third parties can verify them
+	certificateSigner := certificates.SignerFromEnv()
+
+	// External account numbers and micro-deposit amounts are stored encrypted
+	externalAccountSealer := externalaccounts.SealerFromEnv()
+
 	// Initialize HTTP router with middleware
 	// Gin provides high-performance routing with minimal overhead
-	router := gin.Default()
+	router := gin.New()
+
+	// Every request gets an id, echoed in X-Request-ID, and a span; the access log carries both ids
+	router.Use(middleware.RequestID(), tracing.Middleware(), gin.LoggerWithFormatter(middleware.LogFormatter), gin.Recovery())
 	
 	// CORS middleware for cross-origin requests
 	// Essential for web application frontends communicating with backend
 	router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, Idempotency-Key, X-Request-ID, traceparent")
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID")
 		
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -50,26 +535,141 @@ func main() {
 		c.Next()
 	})
 
+	// Response compression - gzip for clients that accept it, skipping small bodies and already-compressed types
+	router.Use(compression.New(compression.ConfigFromEnv()).Middleware())
+
+	// Localization - Accept-Language picks the language of error messages and documents; English is the fallback
+	for tag, keys := range i18n.Missing() {
+		log.Printf("i18n: locale %s lacks %d message(s), shown in %s instead: %s", tag, len(keys), i18n.Default, strings.Join(keys, ", "))
+	}
+	router.Use(middleware.Localize())
+
+	// API versions - v2 overrides v1 per endpoint and shares every endpoint it does not override
+	versions := apiversion.New(router, "/api/v1", "/api/v2", apiversion.SunsetFromEnv())
+	router.Use(versions.Track())
+	router.NoRoute(versions.Fallthrough())
+	if sandboxMode != nil {
+		router.Use(sandboxMode.Middleware())                // X-Sandbox and the fake time on every response
+		router.Use(handlers.SandboxSimulation(sandboxMode)) // Forced errors and latency, and the magic transfer accounts
+	}
+
+	// Load shedding - requests beyond the in-flight limits get 503 at once instead of queueing
+	limiter := loadshed.New(loadshed.LimitsFromEnv())
+	router.Use(limiter.Middleware())
+	router.Use(slowquery.Middleware())
+
+	// Maintenance mode - writes are refused with 503 while reads keep working
+	router.Use(maintenanceMode.Middleware())
+
+	// Posting window - during end of day postings queue or are refused with 503
+	router.Use(postingWindow.Middleware())
+
+	apiMiddleware := []gin.HandlerFunc{middleware.OptionalAuthMiddleware(), tenancy.Middleware(db),
+		middleware.CustomerLocale(db), middleware.AuditMiddleware(db), middleware.ImpersonationGuard(db, middleware.ImpersonationMaxAmountFromEnv()),
+		middleware.ConsentGuard(db), usageMeter.Middleware()}
+	oauthConfig := oauth.ConfigFromEnv()
+	tagConfig := tags.ConfigFromEnv()
+	emailVerification := verification.ConfigFromEnv()
+	maxDebtToIncome := loans.MaxDebtToIncomeFromEnv()
+	creditReviewer := creditbureau.NewReviewer(creditConfig, db)
+	recomputeConfig := reconcile.RecomputeConfigFromEnv()
+	recomputeThrottle := ratelimit.New(recomputeConfig.PerMinute)
+	calculatorLimiter := ratelimit.New(ratelimit.PerMinuteFromEnv("CALCULATOR_RATE_PER_MINUTE", 20))
+
 	// Health check endpoint - crucial for monitoring and load balancers
 	// Provides basic application status information
+	// Stays 200 in maintenance so load balancers keep routing reads
 	router.GET("/health", func(c *gin.Context) {
+		status := "healthy"
+		if maintenanceMode.Enabled() {
+			status = "maintenance"
+		}
 		c.JSON(200, gin.H{
-			"status": "healthy",
+			"status": status,
 			"service": "banking-app",
+			"read_only": maintenanceMode.Enabled(),
 		})
 	})
 
+	// Metrics endpoint in Prometheus text format
+	router.GET("/metrics", metrics.Handler())
+
 	// API versioning - important for backward compatibility
-	v1 := router.Group("/api/v1")
+	// Every request is resolved to a tenant from its token or the X-Tenant header
+	// Authenticated requests are audited, and impersonation tokens are limited to reads
+	// Third-party client tokens only reach the reads their customer consented to, and count toward their quotas
+	// v1 endpoints replaced in v2 announce their deprecation in response headers
+	v1 := router.Group("/api/v1", append(apiMiddleware, versions.Deprecation())...)
 	{
+		// Sign-in - issues JWTs for users created with bankctl
+		v1.POST("/auth/login", handlers.Login(db))
+		v1.GET("/auth/me", middleware.AuthMiddleware(), handlers.GetMe(db)) // Caller identity and impersonation banner
+
+		// OAuth2 for third parties - a signed-in customer approves a client's request, which records a consent
+		v1.GET("/oauth/authorize", handlers.GetAuthorization(db))                                   // Describe a request for the approval screen
+		v1.POST("/oauth/authorize", middleware.AuthMiddleware(), handlers.ApproveAuthorization(db)) // Approve or deny it
+		v1.POST("/oauth/token", handlers.IssueToken(db, oauthConfig))                               // client_credentials and authorization_code grants
+		v1.POST("/oauth/introspect", handlers.IntrospectToken(db))
+
 		// Customer management endpoints - core banking functionality
 		customers := v1.Group("/customers")
 		{
 			customers.GET("", handlers.GetCustomers(db))              // List all customers
 			customers.GET(":id", handlers.GetCustomer(db))            // Get customer by ID
-			customers.POST("", handlers.CreateCustomer(db))           // Create new customer
-			customers.PUT(":id", handlers.UpdateCustomer(db))         // Update customer
+			customers.POST("", handlers.CreateCustomer(db, emailVerification))           // Create new customer; emails a verification token
+			customers.PUT(":id", handlers.UpdateCustomer(db, emailVerification))         // Update customer; a new email waits for confirmation
 			customers.DELETE(":id", handlers.DeleteCustomer(db))      // Delete customer
+			customers.GET(":id/statements/:year/:month", handlers.GetCustomerStatement(db)) // Consolidated monthly statement (JSON or PDF)
+
+			// Email verification - the emailed token is the credential and expires
+			customers.POST("verify-email", handlers.VerifyCustomerEmail(db))
+			customers.POST(":id/verify-email/resend", handlers.ResendEmailVerification(db, emailVerification)) // Earlier tokens stop working
+			customers.GET(":id/tax-summary/:year", handlers.GetTaxSummary(db))          // Year-end interest and fee totals (JSON or CSV)
+			customers.GET(":id/eligible-products", handlers.GetEligibleProducts(db))     // Catalog with the customer's eligibility
+			customers.GET(":id/relationship", handlers.GetCustomerRelationship(db))      // Relationship tier and what the next one needs
+			customers.GET(":id/budgets", handlers.GetCustomerBudgets(db))              // Month-to-date spending against each budget
+			customers.POST(":id/budgets", handlers.CreateCustomerBudget(db))            // Monthly budget for a spending category
+			customers.PUT(":id/budgets/:budgetId", handlers.UpdateCustomerBudget(db))
+			customers.DELETE(":id/budgets/:budgetId", handlers.DeleteCustomerBudget(db))
+			customers.GET(":id/documents", handlers.GetDocuments(db))                    // Uploaded documents
+			customers.POST(":id/documents", middleware.AuthMiddleware(), handlers.UploadDocument(db, documentUploads)) // Validated, scanned upload
+			customers.GET(":id/documents/:documentId", handlers.GetDocumentContent(db, documentUploads))            // Download a stored document
+
+			// Staff notes - customers only see customer-visible ones
+			customers.GET(":id/notes", middleware.AuthMiddleware(), handlers.GetNotes(db, "customer"))
+			customers.POST(":id/notes", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermInternalNotes), handlers.CreateNote(db, "customer"))
+			customers.DELETE(":id/notes/:noteId", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermInternalNotes), handlers.DeleteNote(db, "customer"))
+
+			// Consents for third-party clients to read the customer's data
+			customers.GET(":id/consents", middleware.AuthMiddleware(), handlers.GetConsents(db))
+			customers.POST(":id/consents", middleware.AuthMiddleware(), handlers.CreateConsent(db))
+			customers.POST(":id/consents/:consentId/revoke", middleware.AuthMiddleware(), handlers.RevokeConsent(db)) // Cuts access off at once
+
+			// External accounts - linked once the customer confirms two micro-deposits; the amounts are never returned
+			customers.GET(":id/external-accounts", middleware.AuthMiddleware(), handlers.GetExternalAccounts(db))
+			customers.POST(":id/external-accounts", middleware.AuthMiddleware(), handlers.LinkExternalAccount(db, externalAccountSealer))
+			customers.POST(":id/external-accounts/:externalId/verify", middleware.AuthMiddleware(), handlers.VerifyExternalAccount(db, externalAccountSealer)) // Three attempts
+			customers.DELETE(":id/external-accounts/:externalId", middleware.AuthMiddleware(), handlers.UnlinkExternalAccount(db))
+
+			// Optional message categories a customer opts out of; regulatory notices are always sent
+			customers.GET(":id/notification-preferences", middleware.AuthMiddleware(), handlers.GetNotificationPreferences(db))
+			customers.PUT(":id/notification-preferences", middleware.AuthMiddleware(), handlers.UpdateNotificationPreferences(db)) // {"announcements": false}
+
+			// Communication log - every message and document sent or produced for the customer
+			customers.GET(":id/communications", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermCommunications), handlers.GetCommunications(db))
+			customers.GET(":id/communications/:commId/content", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermCommunications), handlers.GetCommunicationContent(db, documentUploads.Storage))
+			customers.POST(":id/communications/:commId/retry", middleware.AuthMiddleware(), middleware.AdminMiddleware(), handlers.RetryCommunication(db)) // Failed sends only
+
+			// Segmentation tags - the tag is in the path so the audit log records which one changed
+			customers.GET(":id/tags", handlers.GetTags(db, tags.SubjectCustomer))
+			customers.PUT(":id/tags/:tag", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermTags), handlers.AddTag(db, tagConfig, tags.SubjectCustomer))
+			customers.DELETE(":id/tags/:tag", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermTags), handlers.RemoveTag(db, tagConfig, tags.SubjectCustomer))
+
+			// Every status the customer has had, with who changed it and why
+			customers.GET(":id/status-history", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermStatusHistory), handlers.GetStatusHistory(db, statushistory.SubjectCustomer))
+
+			// Data access report - who read or changed the customer's records, from the audit log
+			customers.GET(":id/access-report", middleware.AuthMiddleware(), handlers.GetAccessReport(db, "customer"))
 		}
 
 		// Account management endpoints - core banking functionality
@@ -82,15 +682,332 @@ func main() {
 			accounts.DELETE(":id", handlers.DeleteAccount(db))        // Delete account
 			
 			// Account-specific operations
-			accounts.GET(":id/balance", handlers.GetAccountBalance(db)) // Get account balance
-			accounts.GET(":id/transactions", handlers.GetAccountTransactions(db)) // Get transaction history
+			accounts.GET(":id/balance", handlers.GetAccountBalance(db, balances)) // Get account balance
+			accounts.GET(":id/transactions", handlers.GetAccountTransactions(db, searcher)) // Get transaction history and search
+			accounts.GET(":id/activity", handlers.GetAccountActivity(db))          // Unified activity feed
+			accounts.POST(":id/close", middleware.AuthMiddleware(), handlers.CloseAccount(db, balances, featureFlags)) // Sweep the balance out and close
+
+			// Product conversion - at once or from the next statement cycle, with the change history
+			accounts.POST(":id/convert-product", middleware.AuthMiddleware(), handlers.ConvertAccountProduct(db, featureFlags, balances))
+			accounts.GET(":id/product-changes", handlers.GetProductChanges(db))
+			accounts.DELETE(":id/product-changes/:changeId", middleware.AuthMiddleware(), handlers.CancelProductChange(db)) // Scheduled changes only
+
+			// Monthly statement delivery preference and the statement archive
+			accounts.PUT(":id/statement-preference", handlers.UpdateStatementPreference(db))
+			accounts.GET(":id/statements", handlers.GetAccountStatements(db))
+			accounts.GET(":id/statements/:statementId", handlers.GetAccountStatementDocument(db, documentUploads.Storage))
+			accounts.POST(":id/statements/:year/:month", middleware.AuthMiddleware(), middleware.AdminMiddleware(), handlers.GenerateAccountStatement(db, documentUploads.Storage, statementDelivery))
+			accounts.POST(":id/statements/repair", middleware.AuthMiddleware(), middleware.AdminMiddleware(), handlers.RepairAccountStatements(db, documentUploads.Storage, statementDelivery))
+
+			// Statements for a custom date range - small ranges at once, large ones queued; archived as ad hoc
+			accounts.POST(":id/statements/custom", middleware.AuthMiddleware(), handlers.RequestCustomStatement(db, documentUploads.Storage, customStatements))
+			accounts.GET(":id/statements/custom/:requestId", middleware.AuthMiddleware(), handlers.GetCustomStatementRequest(db))
+			accounts.GET(":id/statements/custom/:requestId/download", middleware.AuthMiddleware(), handlers.DownloadCustomStatement(db, documentUploads.Storage))
+
+			// Balance certificates - issuing is audited, so it needs an authenticated user
+			accounts.GET(":id/certificates", handlers.GetBalanceCertificates(db))
+			accounts.POST(":id/certificates", middleware.AuthMiddleware(), handlers.CreateBalanceCertificate(db, certificateSigner, documentUploads.Storage))
+			accounts.GET(":id/certificates/:certId", handlers.GetBalanceCertificate(db))
+
+			// Transaction hash chain integrity
+			accounts.GET(":id/verify-chain", middleware.AuthMiddleware(), middleware.AdminMiddleware(), handlers.VerifyAccountChain(db))
+
+			// Per-account alert rules and their firing history
+			accounts.GET(":id/alerts", handlers.GetAccountAlerts(db))
+			accounts.POST(":id/alerts", handlers.CreateAccountAlert(db))
+			accounts.GET(":id/alerts/:alertId", handlers.GetAccountAlert(db))
+			accounts.PUT(":id/alerts/:alertId", handlers.UpdateAccountAlert(db))
+			accounts.DELETE(":id/alerts/:alertId", handlers.DeleteAccountAlert(db))
+			accounts.GET(":id/alerts/:alertId/firings", handlers.GetAlertFirings(db))
+
+			// Customer webhooks on one account's events, soft launched behind the account_webhooks flag
+			accounts.GET(":id/webhooks", middleware.AuthMiddleware(), handlers.GetAccountWebhooks(db))
+			accounts.POST(":id/webhooks", middleware.AuthMiddleware(), handlers.CreateAccountWebhook(db, featureFlags, webhookConfig))
+			accounts.DELETE(":id/webhooks/:webhookId", middleware.AuthMiddleware(), handlers.DeleteAccountWebhook(db))
+			accounts.GET(":id/webhooks/:webhookId/deliveries", middleware.AuthMiddleware(), handlers.GetAccountWebhookDeliveries(db))
+
+			// Staff notes - customers only see customer-visible ones
+			accounts.GET(":id/notes", middleware.AuthMiddleware(), handlers.GetNotes(db, "account"))
+			accounts.POST(":id/notes", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermInternalNotes), handlers.CreateNote(db, "account"))
+			accounts.DELETE(":id/notes/:noteId", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermInternalNotes), handlers.DeleteNote(db, "account"))
+
+			// Segmentation tags - the tag is in the path so the audit log records which one changed
+			accounts.GET(":id/tags", handlers.GetTags(db, tags.SubjectAccount))
+			accounts.PUT(":id/tags/:tag", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermTags), handlers.AddTag(db, tagConfig, tags.SubjectAccount))
+			accounts.DELETE(":id/tags/:tag", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermTags), handlers.RemoveTag(db, tagConfig, tags.SubjectAccount))
+
+			// Deposit-only, no-outbound-transfer and withdrawal-approval restrictions - products set them at opening
+			accounts.PUT(":id/restrictions", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermRestrictions), handlers.UpdateAccountRestrictions(db))
+
+			// Third-party liens - staff see them in full, customers what they hold; liens are released, never deleted
+			accounts.GET(":id/liens", handlers.GetAccountLiens(db))
+			accounts.POST(":id/liens", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermLiens), handlers.CreateAccountLien(db))
+			accounts.PUT(":id/liens/:lienId", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermLiens), handlers.UpdateAccountLien(db))
+			accounts.POST(":id/liens/:lienId/satisfy", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermLiens), handlers.SatisfyAccountLien(db, balances))
+			accounts.POST(":id/liens/:lienId/release", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermLiens), handlers.ReleaseAccountLien(db))
+
+			// Pre-authorization holds - placed for a card merchant or a transfer, captured later or released on expiry
+			accounts.GET(":id/authorizations", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermAuthorizations), handlers.GetAccountAuthorizations(db))
+			accounts.POST(":id/authorizations", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermAuthorizations), handlers.CreateAuthorization(db, featureFlags, authorizationConfig))
+
+			// Every status the account has had - opened, frozen, closed, escheated - with who changed it and why
+			accounts.GET(":id/status-history", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermStatusHistory), handlers.GetStatusHistory(db, statushistory.SubjectAccount))
+			accounts.GET(":id/access-report", middleware.AuthMiddleware(), handlers.GetAccessReport(db, "account"))
 		}
 
 		// Transaction processing endpoints - core banking functionality
 		transactions := v1.Group("/transactions")
 		{
 			transactions.GET("", handlers.GetTransactions(db))        // List all transactions
-			transactions.POST("", handlers.CreateTransaction(db))     // Process transaction
+			transactions.POST("", handlers.CreateTransaction(db, balances, featureFlags, reviewConfig, duplicateConfig))     // Process transaction
+			transactions.POST("batch-atomic", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermBatchPostings), handlers.PostBatch(db, balances, featureFlags)) // Several legs, all posted or none
+			transactions.POST(":id/reverse", middleware.AuthMiddleware(), handlers.ReverseTransaction(db, balances)) // Post a correcting reversal
+			transactions.GET(":id/receipt", handlers.GetTransactionReceipt(db))  // Hash-chained proof of posting (JSON or PDF)
+			transactions.POST(":id/installment-plan", middleware.AuthMiddleware(), handlers.CreateInstallmentPlan(db, balances, installmentConfig)) // Convert into monthly installments
+			transactions.POST(":id/disputes", middleware.AuthMiddleware(), handlers.OpenDispute(db)) // The holder, or staff on their behalf
+
+			// Staff notes - customers only see customer-visible ones
+			transactions.GET(":id/notes", middleware.AuthMiddleware(), handlers.GetNotes(db, "transaction"))
+			transactions.POST(":id/notes", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermInternalNotes), handlers.CreateNote(db, "transaction"))
+			transactions.DELETE(":id/notes/:noteId", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermInternalNotes), handlers.DeleteNote(db, "transaction"))
+		}
+
+		// Lines of credit - opened through the loan approval steps, drawn and repaid automatically
+		creditLines := v1.Group("/credit-lines", middleware.AuthMiddleware())
+		{
+			creditLines.POST("", handlers.CreateCreditLine(db)) // Apply; parties are managed on the backing loan
+			creditLines.GET(":id", handlers.GetCreditLine(db))   // Utilization and accrued interest
+			creditLines.POST(":id/activate", handlers.ActivateCreditLine(db, maxDebtToIncome, creditReviewer))
+			creditLines.POST(":id/close", handlers.CloseCreditLine(db, balances)) // Only once fully repaid
+		}
+
+		// Business day calendar - whether the bank is open on a date, in bank time
+		v1.GET("/calendar/business-day", handlers.GetBusinessDay(db)) // ?date=YYYY-MM-DD, default today
+
+		// Calculators - public what-if projections with the math real loans and accrual use, limited per client address
+		calculators := v1.Group("/calculators", handlers.CalculatorRateLimit(calculatorLimiter))
+		{
+			calculators.POST("/loan", handlers.CalculateLoan())
+			calculators.POST("/early-payoff", handlers.CalculateEarlyPayoff(db)) // A loan_id needs a signed-in caller
+			calculators.POST("/savings-growth", handlers.CalculateSavingsGrowth())
+		}
+
+		// Installment plans - collected monthly, or paid off early
+		v1.GET("/installment-plans/:id", handlers.GetInstallmentPlan(db, convention))
+		v1.POST("/installment-plans/:id/payoff", middleware.AuthMiddleware(), handlers.PayOffInstallmentPlan(db, balances, featureFlags))
+
+		// Emailed statement download links - the token is the credential and expires
+		v1.GET("/statements/:token", handlers.DownloadStatement(db, documentUploads.Storage))
+
+		// Public verification of balance certificates by their printed code
+		v1.GET("/certificates/verify/:code", handlers.VerifyCertificate(db, certificateSigner))
+
+		// Self-service account opening - drafts filled in step by step, submitted, decided, then opened and funded
+		accountApplications := v1.Group("/account-applications", middleware.AuthMiddleware())
+		{
+			accountApplications.POST("", handlers.CreateAccountApplication(db)) // Customers apply for themselves
+			accountApplications.GET("", handlers.GetAccountApplications(db))    // ?status=submitted for the review queue
+			accountApplications.GET(":id", handlers.GetAccountApplication(db))  // With every transition
+			accountApplications.PATCH(":id", handlers.UpdateAccountApplication(db)) // Fill in the product and funding steps
+			accountApplications.POST(":id/submit", handlers.SubmitAccountApplication(db))
+			accountApplications.POST(":id/decision", middleware.PermissionMiddleware(auth.PermApplications), handlers.DecideAccountApplication(db))
+			accountApplications.POST(":id/open", handlers.OpenAccountApplication(db, balances, featureFlags, transferConfig))
+		}
+
+		// Status lifecycles - each record type's statuses and allowed transitions, with required permissions and reasons
+		v1.GET("/lifecycles", middleware.AuthMiddleware(), handlers.GetLifecycles())
+
+		// An API client's own usage and quotas, with its client token
+		v1.GET("/usage", middleware.AuthMiddleware(), handlers.GetOwnUsage(db, usageMeter))
+
+		// Account-to-account transfers with duplicate-suspect detection
+		v1.POST("/transfers", handlers.CreateTransfer(db, balances, featureFlags, transferConfig, reviewConfig, duplicateConfig))
+
+		// Emailed reversal links for repeated payments - the token is the credential and expires
+		v1.POST("/duplicate-payments/:token/reverse", handlers.ReverseDuplicatePayment(db, balances))
+
+		// Emailed unsubscribe links in campaign announcements - the token is the credential
+		v1.POST("/unsubscribe/:token", handlers.Unsubscribe(db))
+
+		// Administrative endpoints - require an authenticated admin user
+		admin := v1.Group("/admin", middleware.AuthMiddleware(), middleware.AdminMiddleware())
+		{
+			// Today's headline numbers for the operations screen - versioned, cached for 30 seconds per tenant
+			admin.GET("/dashboard", handlers.GetDashboard(db, jobScheduler, summaries))
+
+			// Streaming NDJSON extracts for the data warehouse - scoped to the admin's tenant
+			admin.GET("/export/transactions", handlers.ExportTransactions(db))
+			admin.GET("/export/customers", handlers.ExportCustomers(db))
+			admin.GET("/export/accounts", handlers.ExportAccounts(db))
+
+			// Accounting period lock - per tenant
+			admin.GET("/period-lock", handlers.GetPeriodLock(db))
+			admin.PUT("/period-lock", handlers.SetPeriodLock(db))
+
+			// Year-end tax summary pre-generation - per tenant
+			admin.POST("/tax-summaries/:year/generate", handlers.GenerateTaxSummaries(db))
+
+			// Account product catalog, eligibility rules and the override audit trail
+			admin.GET("/products", handlers.GetProducts(db))
+			admin.POST("/products", handlers.CreateProduct(db))
+			admin.PUT("/products/:id", handlers.UpdateProduct(db))
+			admin.DELETE("/products/:id", handlers.DeleteProduct(db))
+			admin.GET("/products/:id/rates", handlers.GetProductRates(db))
+			admin.POST("/products/:id/rates", handlers.ScheduleProductRate(db, rateConfig))
+			admin.GET("/eligibility-overrides", handlers.GetEligibilityOverrides(db))
+
+			// Transaction fee schedules - fees are posted automatically on qualifying postings
+			admin.GET("/fee-schedules", handlers.GetFeeSchedules(db))
+			admin.POST("/fee-schedules", handlers.CreateFeeSchedule(db))
+			admin.PUT("/fee-schedules/:id", handlers.UpdateFeeSchedule(db))    // Only name and effective_to once it has charged fees
+			admin.DELETE("/fee-schedules/:id", handlers.DeleteFeeSchedule(db)) // 409 once it has charged fees
+
+			// Relationship pricing tiers - recomputed monthly; they waive fees and add a savings rate bonus
+			admin.GET("/relationship-tiers", handlers.GetRelationshipTiers(db))
+			admin.POST("/relationship-tiers", handlers.CreateRelationshipTier(db))
+			admin.PUT("/relationship-tiers/:id", handlers.UpdateRelationshipTier(db))    // Only name and effective_to once customers are in it
+			admin.DELETE("/relationship-tiers/:id", handlers.DeleteRelationshipTier(db)) // 409 once customers are in it
+			admin.POST("/relationship-tiers/recompute", handlers.RecomputeRelationships(db)) // This month's tiers now
+
+			// Statement descriptor templates - the canonical text statements, receipts and the feed show per posting
+			admin.GET("/descriptor-templates", handlers.GetDescriptorTemplates(db)) // With the defaults and each kind's variables
+			admin.POST("/descriptor-templates", handlers.CreateDescriptorTemplate(db))
+			admin.PUT("/descriptor-templates/:id", handlers.UpdateDescriptorTemplate(db))
+			admin.DELETE("/descriptor-templates/:id", handlers.DeleteDescriptorTemplate(db))
+			admin.POST("/descriptor-templates/backfill", handlers.BackfillDescriptors(db)) // ?all=true regenerates every descriptor
+
+			// Closing FX rates the nightly revaluation values foreign-currency balances at
+			admin.GET("/fx-rates", handlers.GetFXRates(db))
+			admin.PUT("/fx-rates", handlers.PutFXRate(db))           // Enter or correct a day's rate; 409 once revalued
+			admin.DELETE("/fx-rates/:id", handlers.DeleteFXRate(db)) // 409 once revalued
+
+			// Tag vocabulary - the only tags staff can apply unless TAGS_FREE_FORM is set
+			admin.GET("/tags", handlers.GetTagVocabulary(db, tagConfig))
+			admin.POST("/tags", handlers.CreateTag(db, tagConfig))
+			admin.DELETE("/tags/:tag", handlers.DeleteTag(db, tagConfig)) // Only once no record carries it
+
+			// Unclaimed property - dormancy notices, escheatment report and reclaims
+			admin.GET("/escheatments", handlers.GetEscheatments(db))
+			admin.POST("/escheatments/run", handlers.RunEscheatment(db, escheatConfig))
+			admin.POST("/escheatments/:id/reclaim", handlers.ReclaimEscheatment(db, balances))
+
+			// Viewing the API as a customer, and the audit log that records it
+			admin.POST("/impersonate/:customerId", handlers.StartImpersonation(db))
+			admin.GET("/impersonations", handlers.GetImpersonations(db))
+			admin.DELETE("/impersonations/:id", handlers.EndImpersonation(db))
+			admin.GET("/audit-log", handlers.GetAuditLog(db))
+
+			// OAuth2 client registration - the secret is only shown in the registration response
+			admin.GET("/oauth/clients", handlers.GetOAuthClients(db))
+			admin.POST("/oauth/clients", handlers.CreateOAuthClient(db))
+			admin.DELETE("/oauth/clients/:clientId", handlers.DeleteOAuthClient(db)) // Its tokens stop working at once
+
+			// API client usage and monthly quotas - :id is the client_id
+			admin.GET("/clients/:id/usage", handlers.GetClientUsage(db, usageMeter)) // ?from=&to=, default the month to today
+			admin.GET("/clients/:id/quotas", handlers.GetClientQuotas(db))
+			admin.PUT("/clients/:id/quotas", handlers.SetClientQuota(db, usageMeter)) // One quota per metric and category
+			admin.DELETE("/clients/:id/quotas/:quotaId", handlers.DeleteClientQuota(db, usageMeter))
+
+			// Bulk account operations - e.g. freezing every account in a fraud case
+			admin.POST("/accounts/bulk-action", handlers.CreateBulkAction(db)) // dry_run previews the affected accounts
+			admin.POST("/accounts/recompute-balances", handlers.RecomputeBalances(db, recomputeConfig, recomputeThrottle)) // Over 1,000 accounts run as a bulk operation
+			admin.GET("/bulk-operations/:id", handlers.GetBulkOperation(db))    // Progress and per-account results
+
+			// Announcement campaigns - dry_run previews the audience and a rendered message
+			admin.POST("/campaigns", handlers.CreateCampaign(db, campaignConfig))
+			admin.GET("/campaigns", handlers.GetCampaigns(db))
+			admin.GET("/campaigns/:id", handlers.GetCampaign(db))              // Progress and per-customer statuses
+			admin.POST("/campaigns/:id/pause", handlers.PauseCampaign(db))     // Stops further batches
+			admin.POST("/campaigns/:id/resume", handlers.ResumeCampaign(db))
+			admin.GET("/campaigns/:id/report", handlers.GetCampaignReport(db)) // Delivery report, final once completed
+
+			// Monthly statement dispatch and statements that could not be emailed
+			admin.POST("/statements/run", handlers.RunStatementDispatch(db, documentUploads.Storage, statementDelivery))
+			admin.GET("/statements/follow-ups", handlers.GetStatementFollowUps(db))
+
+			// Scheduled report subscriptions, their runs and the reports they rendered
+			admin.GET("/report-subscriptions", handlers.GetReportSubscriptions(db))
+			admin.POST("/report-subscriptions", handlers.CreateReportSubscription(db)) // Owned by the caller
+			admin.GET("/report-subscriptions/:id", handlers.GetReportSubscription(db))
+			admin.PUT("/report-subscriptions/:id", handlers.UpdateReportSubscription(db)) // "status" pauses or resumes
+			admin.DELETE("/report-subscriptions/:id", handlers.DeleteReportSubscription(db))
+			admin.POST("/report-subscriptions/:id/run", handlers.RunReportSubscription(db, documentUploads.Storage, reportSubscriptionConfig))
+			admin.GET("/report-subscriptions/:id/runs", handlers.GetReportRuns(db))
+			admin.GET("/report-subscriptions/:id/runs/:runId/artifact", handlers.GetReportArtifact(db, documentUploads.Storage))
+
+			// Reconciliation of external bank statements (camt.053 or CSV) against a ledger account
+			admin.POST("/reconcile/statement", handlers.ImportStatement(db, reconcile.ToleranceDaysFromEnv()))
+			admin.GET("/reconcile/reports", handlers.GetMatchReports(db))
+			admin.GET("/reconcile/reports/:id", handlers.GetMatchReport(db))
+			admin.POST("/reconcile/reports/:id/match", handlers.PairMatchItems(db))                           // Pair a statement line with a posting
+			admin.POST("/reconcile/reports/:id/items/:itemId/adjust", handlers.AdjustMatchItem(db, balances)) // Book an unmatched line
+		}
+
+		// Deployment-wide administration - admins of the default tenant only
+		platform := admin.Group("", tenancy.PlatformOnly())
+		{
+			// Bank brands and their first admin users
+			platform.GET("/tenants", handlers.GetTenants(db))
+			platform.POST("/tenants", handlers.CreateTenant(db))
+			platform.PUT("/tenants/:id", handlers.UpdateTenant(db))
+
+			// Transaction enrichment rules
+			platform.GET("/enrichment-rules", handlers.GetEnrichmentRules(db))
+			platform.POST("/enrichment-rules", handlers.CreateEnrichmentRule(db))
+			platform.PUT("/enrichment-rules/:id", handlers.UpdateEnrichmentRule(db))
+			platform.DELETE("/enrichment-rules/:id", handlers.DeleteEnrichmentRule(db))
+			platform.POST("/enrichment-rules/backfill", handlers.BackfillEnrichment(db))
+
+			// Webhook subscriptions and the event outbox
+			platform.GET("/webhooks", handlers.GetWebhookSubscriptions(db))
+			platform.POST("/webhooks", handlers.CreateWebhookSubscription(db))
+			platform.DELETE("/webhooks/:id", handlers.DeleteWebhookSubscription(db))
+			platform.GET("/webhooks/:id/deliveries", handlers.GetWebhookDeliveries(db))
+			platform.POST("/webhooks/:id/resume", handlers.ResumeWebhookSubscription(db))
+			platform.GET("/outbox", handlers.GetOutboxEvents(db))
+			platform.GET("/outbox/stats", handlers.GetOutboxStats(db))
+			platform.POST("/outbox/:id/redrive", handlers.RedriveOutboxEvent(db))
+			platform.GET("/events/stream", handlers.StreamEvents(broker))
+
+			// Runtime feature flags
+			platform.GET("/flags", handlers.GetFeatureFlags(db, featureFlags))
+			platform.POST("/flags", handlers.CreateFeatureFlag(db, featureFlags))
+			platform.PUT("/flags/:key", handlers.UpdateFeatureFlag(db, featureFlags))
+			platform.DELETE("/flags/:key", handlers.DeleteFeatureFlag(db, featureFlags))
+
+			// Diagnostics
+			platform.GET("/query-plans", handlers.GetQueryPlans(db))
+			platform.GET("/slow-queries", handlers.GetSlowQueries(slowQueries)) // Worst statements over the slow query threshold
+			platform.GET("/siem", handlers.GetSIEMStatus(siemForwarder))         // Audit log forwarding progress and breaker state
+
+			// Read-only maintenance mode for migrations - entering pauses scheduled jobs
+			platform.GET("/maintenance", handlers.GetMaintenance(maintenanceMode))
+			platform.POST("/maintenance", handlers.SetMaintenance(maintenanceMode))
+
+			// In-flight request limits on this instance - changes last until restart
+			platform.GET("/concurrency", handlers.GetConcurrency(limiter))
+			platform.PUT("/concurrency", handlers.UpdateConcurrency(limiter))
+
+			// Bank holidays - with weekends, the days the bank is closed
+			platform.GET("/holidays", handlers.GetHolidays(db)) // ?year=
+			platform.POST("/holidays", handlers.CreateHoliday(db))
+			platform.PUT("/holidays/:id", handlers.UpdateHoliday(db))
+			platform.DELETE("/holidays/:id", handlers.DeleteHoliday(db))
+			platform.POST("/holidays/seed", handlers.SeedHolidays(db)) // Federal holidays for {"year"}
+
+			// Background jobs - schedules, run history across instances and manual runs
+			platform.GET("/jobs", handlers.GetJobs(jobScheduler))
+			platform.GET("/jobs/runs", handlers.GetJobRuns(db))
+			platform.POST("/jobs/:name/run", handlers.TriggerJob(jobScheduler)) // 409 while running on any instance
+
+			// Transaction archive - table sizes and recent batches; unarchiving moves a range back and holds it hot
+			platform.GET("/archive", handlers.GetArchiveStatus(db, archiveConfig))
+			platform.POST("/archive/unarchive", handlers.UnarchiveTransactions(db, archiveConfig)) // {"account_id", "from", "to", "hold_days"}
+
+			// End of day - the posting window, the steps and the latest run; a failed run resumes at its failed step
+			platform.GET("/eod", handlers.GetEOD(db, endOfDay))
+			platform.GET("/eod/runs/:id", handlers.GetEODRun(db))
+			platform.POST("/eod/runs/:id/resume", handlers.ResumeEODRun(endOfDay)) // 409 unless it is the latest run and failed
 		}
 
 		// Loan management endpoints - core banking functionality
@@ -98,10 +1015,157 @@ func main() {
 		{
 			loans.GET("", handlers.GetLoans(db))                     // List all loans
 			loans.GET(":id", handlers.GetLoan(db))                   // Get loan by ID
-			loans.POST("", handlers.CreateLoan(db))                  // Create new loan
+			loans.POST("", handlers.CreateLoan(db, maxDebtToIncome, creditReviewer)) // Create new loan (?disburse=false leaves it pending)
 			loans.PUT(":id", handlers.UpdateLoan(db))                // Update loan
 			loans.DELETE(":id", handlers.DeleteLoan(db))             // Delete loan
+			loans.GET(":id/payments", handlers.GetLoanPayments(db))  // Payment history with interest/principal split
+			loans.POST(":id/payments", handlers.CreateLoanPayment(db, balances, featureFlags)) // Pay from a borrower account
+
+			// Co-borrowers and guarantors - changed only while the loan is pending
+			loans.GET(":id/parties", handlers.GetLoanParties(db))
+			loans.POST(":id/parties", middleware.AuthMiddleware(), handlers.AddLoanParty(db))
+			loans.DELETE(":id/parties/:partyId", middleware.AuthMiddleware(), handlers.RemoveLoanParty(db))
+			loans.POST(":id/parties/:partyId/confirm", middleware.AuthMiddleware(), handlers.ConfirmLoanGuarantee(db)) // Guarantor consent
+
+			// Payment split - each installment collected in shares from the parties' own accounts
+			loans.GET(":id/payment-split", middleware.AuthMiddleware(), handlers.GetLoanSplit(db))
+			loans.PUT(":id/payment-split", middleware.AuthMiddleware(), handlers.SetLoanSplit(db))
+			loans.POST(":id/disburse", middleware.AuthMiddleware(), handlers.DisburseLoan(db, maxDebtToIncome, creditReviewer))
+			loans.POST(":id/credit-review", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermCreditReview), handlers.ReviewLoanCredit(db)) // Decide a referred application
+
+			// Staff notes - customers only see customer-visible ones
+			loans.GET(":id/notes", middleware.AuthMiddleware(), handlers.GetNotes(db, "loan"))
+			loans.POST(":id/notes", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermInternalNotes), handlers.CreateNote(db, "loan"))
+			loans.DELETE(":id/notes/:noteId", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermInternalNotes), handlers.DeleteNote(db, "loan"))
+
+			// Every status the loan has had - pending, declined, active, paid off - with who changed it and why
+			loans.GET(":id/status-history", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermStatusHistory), handlers.GetStatusHistory(db, statushistory.SubjectLoan))
+		}
+
+		// Operations - incoming credits and the suspense exception queue, for staff
+		operations := v1.Group("/operations", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermExceptions))
+		{
+			operations.POST("/incoming-credits", handlers.ReceiveIncomingCredit(db, balances, featureFlags))
+			operations.GET("/exceptions", handlers.GetExceptions(db))
+			operations.GET("/exceptions/:id", handlers.GetException(db))
+			operations.POST("/exceptions/:id/apply", handlers.ApplyException(db, balances))
+			operations.POST("/exceptions/:id/return", handlers.ReturnException(db, balances))
+
+			// Broken balance invariants found by the nightly scan - nothing is held, so they are reviewed, not applied
+			operations.GET("/invariants", handlers.GetInvariantViolations(db))
+			operations.POST("/invariants/:id/resolve", handlers.ResolveInvariantViolation(db))
+		}
+
+		// Withdrawal approvals - debits from restricted accounts wait here for a second staff member
+		approvals := v1.Group("/operations/approvals", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermApprovals))
+		{
+			approvals.GET("", handlers.GetWithdrawalApprovals(db))
+			approvals.GET(":id", handlers.GetWithdrawalApproval(db))
+			approvals.POST(":id/approve", handlers.ApproveWithdrawal(db, balances, featureFlags, transferConfig)) // Post it; the requester cannot approve
+			approvals.POST(":id/reject", handlers.RejectWithdrawal(db))
 		}
+
+		// Transaction reviews - the first large debit from a new account waits here until staff decide or its time passes
+		reviewRoutes := v1.Group("/operations/reviews", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermApprovals))
+		{
+			reviewRoutes.GET("", handlers.GetTransactionReviews(db))
+			reviewRoutes.GET(":id", handlers.GetTransactionReview(db))
+			reviewRoutes.POST(":id/approve", handlers.ApproveTransactionReview(db, balances, featureFlags, transferConfig)) // Post it now
+			reviewRoutes.POST(":id/decline", handlers.DeclineTransactionReview(db))                                       // Release the hold and tell the customer
+		}
+
+		// Pre-authorization holds by id - captured as a debit, or released without posting
+		authorizationRoutes := v1.Group("/authorizations", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermAuthorizations))
+		{
+			authorizationRoutes.GET(":id", handlers.GetAuthorizationHold(db))
+			authorizationRoutes.POST(":id/capture", handlers.CaptureAuthorization(db, balances, featureFlags, transferConfig)) // 409 once expired
+			authorizationRoutes.POST(":id/release", handlers.ReleaseAuthorization(db))
+		}
+
+		// Duplicate payments - payments repeating an earlier one through any channel, flagged after posting
+		duplicateRoutes := v1.Group("/operations/duplicate-payments", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermApprovals))
+		{
+			duplicateRoutes.GET("", handlers.GetDuplicatePayments(db))
+			duplicateRoutes.GET(":id", handlers.GetDuplicatePayment(db))                      // With both payments
+			duplicateRoutes.POST(":id/reverse", handlers.ReverseFlaggedPayment(db, balances)) // Reverse the later payment
+			duplicateRoutes.POST(":id/dismiss", handlers.DismissFlaggedPayment(db))           // It was meant; note required
+		}
+
+		// Disputes - customers' challenges to posted transactions, read by the holder and resolved by staff
+		// Each has a thread between the customer and the staff member assigned it, with evidence attached
+		disputeRoutes := v1.Group("/disputes", middleware.AuthMiddleware())
+		{
+			disputeRoutes.GET(":id", handlers.GetDispute(db))                                                                  // With the disputed transaction, and its thread for those in it
+			disputeRoutes.POST(":id/resolve", middleware.PermissionMiddleware(auth.PermDisputes), handlers.ResolveDispute(db)) // Resolution required
+			disputeRoutes.POST(":id/assign", middleware.PermissionMiddleware(auth.PermDisputes), handlers.AssignDispute(db))   // To staff with the permission
+			disputeRoutes.POST(":id/messages", handlers.PostDisputeMessage(db, disputeConfig))                                 // The customer or the assignee
+			disputeRoutes.POST(":id/read", handlers.MarkDisputeRead(db))                                                       // The other side's posts
+			disputeRoutes.POST(":id/attachments", handlers.UploadDisputeEvidence(db, documentUploads, disputeConfig))          // Validated, scanned evidence
+			disputeRoutes.GET(":id/attachments/:attachmentId", handlers.GetDisputeAttachment(db, documentUploads))             // Download evidence
+		}
+
+		// Investigations - money movement graphs around a transaction, for staff
+		investigationRoutes := v1.Group("/investigations", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermInvestigations))
+		{
+			investigationRoutes.GET("/flow", handlers.GetMoneyFlow(db, flows)) // ?transaction_id=&depth=&direction=&format=dot
+		}
+
+		// Suspicious activity report cases - the compliance role only; linked transactions are locked while a case is open
+		sarRoutes := v1.Group("/compliance/cases", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermSARCases))
+		{
+			sarRoutes.GET("", handlers.GetSARCases(db))              // ?status=&assigned_to=&customer_id=
+			sarRoutes.POST("", handlers.CreateSARCase(db, sarConfig)) // For a customer, or from a transaction review or duplicate payment
+			sarRoutes.GET(":id", handlers.GetSARCase(db))
+			sarRoutes.PUT(":id", handlers.UpdateSARCase(db)) // Narrative, investigator and deadline
+			sarRoutes.POST(":id/transactions", handlers.AttachSARCaseTransactions(db))
+			sarRoutes.POST(":id/documents", handlers.AttachSARCaseDocuments(db))
+			sarRoutes.POST(":id/notes", handlers.CreateSARCaseNote(db))
+			sarRoutes.POST(":id/status", handlers.ChangeSARCaseStatus(db)) // investigating, sar_filed or closed_no_action
+			sarRoutes.GET(":id/export", handlers.ExportSARCase(db))         // Filing bundle: JSON with an attachments manifest
+		}
+
+		// Garnishment orders - court orders swept from deposits above a protected amount; never deleted
+		garnishmentRoutes := v1.Group("/garnishments", middleware.AuthMiddleware(), middleware.PermissionMiddleware(auth.PermGarnishments))
+		{
+			garnishmentRoutes.GET("", handlers.GetGarnishments(db))              // ?customer_id=&account_id=&status=
+			garnishmentRoutes.POST("", handlers.CreateGarnishment(db, balances)) // On an account, or every account of a customer in a currency
+			garnishmentRoutes.GET(":id", handlers.GetGarnishment(db))
+			garnishmentRoutes.POST(":id/suspend", handlers.SuspendGarnishment(db))
+			garnishmentRoutes.POST(":id/resume", handlers.ResumeGarnishment(db, balances))
+			garnishmentRoutes.POST(":id/satisfy", handlers.SatisfyGarnishment(db))
+			garnishmentRoutes.POST(":id/documents", handlers.AttachGarnishmentDocuments(db))
+		}
+
+		// Finance reports - per tenant, admins only
+		reports := v1.Group("/reports", middleware.AuthMiddleware(), middleware.AdminMiddleware())
+		{
+			reports.GET("/trial-balance", handlers.GetTrialBalance(db))
+			reports.GET("/income", handlers.GetIncomeReport(db))
+			reports.GET("/exposure", handlers.GetExposureReport(db))
+			reports.GET("/fx-revaluation", handlers.GetFXRevaluationReport(db))         // Base-currency exposure and revaluation impact
+			reports.GET("/interest-liability", handlers.GetInterestLiabilityReport(db)) // Accrued interest not yet credited, by product
+		}
+
+		// Sandbox - move the fake clock, start over and simulate errors; never registered outside sandbox mode
+		if sandboxMode != nil {
+			sandboxRoutes := v1.Group("/sandbox")
+			{
+				sandboxRoutes.GET("", handlers.GetSandbox(sandboxMode))
+				sandboxRoutes.POST("/advance-time", handlers.AdvanceSandboxTime(sandboxMode)) // Runs the jobs due in between, in order
+				sandboxRoutes.POST("/reset", handlers.ResetSandbox(sandboxMode))              // Deletes all sandbox data
+				sandboxRoutes.GET("/simulate", middleware.AuthMiddleware(), handlers.GetSandboxSimulation(sandboxMode))
+				sandboxRoutes.POST("/simulate", middleware.AuthMiddleware(), handlers.CreateSandboxSimulation(sandboxMode)) // The caller's next requests fail or wait
+				sandboxRoutes.DELETE("/simulate", middleware.AuthMiddleware(), handlers.ClearSandboxSimulation(sandboxMode))
+			}
+		}
+	}
+
+	// API v2 - decimal-string amounts and the {"error": {"code", "message"}} envelope
+	v2 := router.Group("/api/v2", apiMiddleware...)
+	{
+		versions.Override(v2, http.MethodGet, "/transactions", handlers.GetTransactionsV2(db))
+		versions.Override(v2, http.MethodPost, "/transactions", handlers.CreateTransactionV2(db, balances, featureFlags, reviewConfig, duplicateConfig))
+		versions.Override(v2, http.MethodPost, "/transfers", handlers.CreateTransferV2(db, balances, featureFlags, transferConfig, reviewConfig, duplicateConfig))
 	}
 
 	// Get port from environment variable or use default
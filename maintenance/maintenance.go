@@ -0,0 +1,282 @@
+package maintenance
+
+import (
+	"banking-app/apiversion"
+	"banking-app/models"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// stateID is the primary key of the single maintenance state row
+const stateID = 1
+
+// Defaults used when entering maintenance without explicit values
+const (
+	DefaultMessage    = "The service is undergoing scheduled maintenance; changes are temporarily unavailable"
+	DefaultRetryAfter = 300 // Seconds
+)
+
+// DrainTimeout bounds how long entering maintenance waits for running jobs to finish
+const DrainTimeout = 30 * time.Second
+
+// PausedRetry is how soon a job skipped for maintenance is tried again
+const PausedRetry = time.Minute
+
+// Allowlist holds the mutating endpoints that keep working in maintenance, as "METHOD /path" below the
+// API version prefix: signing in, the calculators, which change nothing, and the maintenance endpoints themselves so
+// admins can leave it
+var Allowlist = []string{
+	"POST /auth/login",
+	"POST /calculators/loan",
+	"POST /calculators/early-payoff",
+	"POST /calculators/savings-growth",
+	"POST /admin/maintenance",
+}
+
+// ErrNotEnabled is returned when exiting maintenance that is not in effect
+var ErrNotEnabled = errors.New("maintenance mode is not enabled")
+
+// Mode is the in-process view of the persisted maintenance state
+// Every instance refreshes it from the database, so entering or exiting on one instance reaches all of them
+type Mode struct {
+	db *gorm.DB
+
+	mu    sync.RWMutex
+	state models.MaintenanceState
+
+	jobsMu  sync.Mutex
+	running map[string]int // Job name -> runs in progress on this instance
+}
+
+// New loads the persisted state; MAINTENANCE_MODE=true enters maintenance at startup if it is not already in effect
+func New(db *gorm.DB) *Mode {
+	m := &Mode{db: db, running: make(map[string]int)}
+	if err := m.Refresh(); err != nil {
+		log.Printf("maintenance: initial load failed: %v", err)
+	}
+	if os.Getenv("MAINTENANCE_MODE") == "true" && !m.Enabled() {
+		if _, err := m.Enter("config", "", 0); err != nil {
+			log.Printf("maintenance: failed to enter from config: %v", err)
+		}
+	}
+	return m
+}
+
+// Refresh reloads the state from the database; a missing row means maintenance is off
+func (m *Mode) Refresh() error {
+	var state models.MaintenanceState
+	err := m.db.First(&state, stateID).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+	m.mu.Lock()
+	m.state = state
+	m.mu.Unlock()
+	return nil
+}
+
+// Start refreshes the state every interval until stop is closed
+func (m *Mode) Start(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.Refresh(); err != nil {
+					log.Printf("maintenance: refresh failed: %v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// State returns a snapshot of the current state
+func (m *Mode) State() models.MaintenanceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// Enabled reports whether the API is read-only
+func (m *Mode) Enabled() bool {
+	return m.State().Enabled
+}
+
+// Enter persists maintenance mode; new job runs stop starting immediately
+// Empty message and zero retryAfter use the defaults
+func (m *Mode) Enter(by, message string, retryAfter int) (models.MaintenanceState, error) {
+	if strings.TrimSpace(message) == "" {
+		message = DefaultMessage
+	}
+	if retryAfter <= 0 {
+		retryAfter = DefaultRetryAfter
+	}
+	now := time.Now()
+	state := m.State()
+	state.ID = stateID
+	state.Message = strings.TrimSpace(message)
+	state.RetryAfterSeconds = retryAfter
+	if !state.Enabled {
+		state.Enabled = true
+		state.StartedAt = &now
+		state.StartedBy = by
+	}
+	err := m.save(&state)
+	return state, err
+}
+
+// Exit persists leaving maintenance mode
+func (m *Mode) Exit(by string) (models.MaintenanceState, error) {
+	state := m.State()
+	if !state.Enabled {
+		return state, ErrNotEnabled
+	}
+	now := time.Now()
+	state.Enabled = false
+	state.EndedAt = &now
+	state.EndedBy = by
+	err := m.save(&state)
+	return state, err
+}
+
+// save writes the state row and updates the local copy
+func (m *Mode) save(state *models.MaintenanceState) error {
+	if err := m.db.Save(state).Error; err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.state = *state
+	m.mu.Unlock()
+	return nil
+}
+
+// Run runs one scheduled job unless maintenance is in effect, in which case the run is skipped
+// and false is returned. Runs already in progress when maintenance starts finish normally; Drain waits for them
+func (m *Mode) Run(name string, job func()) bool {
+	// Checked under the job lock so a run either sees maintenance or is visible to Drain
+	m.jobsMu.Lock()
+	if m.Enabled() {
+		m.jobsMu.Unlock()
+		return false
+	}
+	m.running[name]++
+	m.jobsMu.Unlock()
+
+	defer func() {
+		m.jobsMu.Lock()
+		m.running[name]--
+		m.jobsMu.Unlock()
+	}()
+	job()
+	return true
+}
+
+// Every runs job through Run now and then every interval until stop is closed
+// A run skipped for maintenance is retried after PausedRetry, so daily jobs resume soon after maintenance ends
+func (m *Mode) Every(name string, interval time.Duration, stop <-chan struct{}, job func()) {
+	go func() {
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+				next := interval
+				if !m.Run(name, job) && PausedRetry < interval {
+					next = PausedRetry
+				}
+				timer.Reset(next)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Drain waits up to timeout for job runs in progress on this instance to finish
+// It returns the names of jobs still running when the wait gave up
+func (m *Mode) Drain(timeout time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	for {
+		running := m.Running()
+		if len(running) == 0 || time.Now().After(deadline) {
+			return running
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Running lists the jobs with a run in progress on this instance
+func (m *Mode) Running() []string {
+	m.jobsMu.Lock()
+	defer m.jobsMu.Unlock()
+	names := []string{}
+	for name, count := range m.running {
+		if count > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Middleware refuses mutating requests with 503 and Retry-After while maintenance is in effect
+// Reads and the Allowlist are always served; install it on the engine after apiversion tracking
+func (m *Mode) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state := m.State()
+		if !state.Enabled || readOnlyMethod(c.Request.Method) || allowed(c.Request.Method, c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		c.Header("Retry-After", strconv.Itoa(state.RetryAfterSeconds))
+		if apiversion.Version(c) == apiversion.V2 {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": gin.H{
+				"code":    "MAINTENANCE",
+				"message": state.Message,
+				"details": gin.H{"retry_after_seconds": state.RetryAfterSeconds},
+			}})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"error":               state.Message,
+			"code":                "MAINTENANCE",
+			"retry_after_seconds": state.RetryAfterSeconds,
+		})
+	}
+}
+
+// readOnlyMethod reports whether a method cannot change state
+func readOnlyMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// allowed reports whether a request matches the Allowlist under any API version prefix
+func allowed(method, path string) bool {
+	for _, prefix := range []string{"/api/v1", "/api/v2"} {
+		if rest := strings.TrimPrefix(path, prefix); rest != path {
+			path = rest
+			break
+		}
+	}
+	path = strings.TrimSuffix(path, "/")
+	for _, entry := range Allowlist {
+		if entry == method+" "+path {
+			return true
+		}
+	}
+	return false
+}
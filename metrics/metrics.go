@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	value int64
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc() { atomic.AddInt64(&c.value, 1) }
+
+// Add adds n to the counter
+func (c *Counter) Add(n int64) { atomic.AddInt64(&c.value, n) }
+
+// Value returns the current count
+func (c *Counter) Value() int64 { return atomic.LoadInt64(&c.value) }
+
+// registry holds every exported metric
+var registry = struct {
+	sync.RWMutex
+	counters map[string]*Counter
+	gauges   map[string]func() float64
+	help     map[string]string
+}{
+	counters: make(map[string]*Counter),
+	gauges:   make(map[string]func() float64),
+	help:     make(map[string]string),
+}
+
+// NewCounter registers (or returns the existing) counter with the given name
+// Names may carry Prometheus-style labels, e.g. requests_total{route="/x"}
+func NewCounter(name, help string) *Counter {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if c, ok := registry.counters[name]; ok {
+		return c
+	}
+	c := &Counter{}
+	registry.counters[name] = c
+	registry.help[baseName(name)] = help
+	return c
+}
+
+// RegisterGauge exposes a value computed at scrape time
+func RegisterGauge(name, help string, fn func() float64) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.gauges[name] = fn
+	registry.help[baseName(name)] = help
+}
+
+// Handler renders all metrics in the Prometheus text exposition format
+func Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		registry.RLock()
+		defer registry.RUnlock()
+
+		type line struct {
+			name, kind string
+			value      float64
+		}
+		var lines []line
+		for name, counter := range registry.counters {
+			lines = append(lines, line{name, "counter", float64(counter.Value())})
+		}
+		for name, fn := range registry.gauges {
+			lines = append(lines, line{name, "gauge", fn()})
+		}
+		sort.Slice(lines, func(i, j int) bool { return lines[i].name < lines[j].name })
+
+		var b strings.Builder
+		described := make(map[string]bool)
+		for _, l := range lines {
+			base := baseName(l.name)
+			if !described[base] {
+				fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", base, registry.help[base], base, l.kind)
+				described[base] = true
+			}
+			fmt.Fprintf(&b, "%s %g\n", l.name, l.value)
+		}
+
+		c.Data(http.StatusOK, "text/plain; version=0.0.4", []byte(b.String()))
+	}
+}
+
+// baseName strips any label set from a metric name
+func baseName(name string) string {
+	if i := strings.IndexByte(name, '{'); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"banking-app/models"
+	"banking-app/tenancy"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// auditKey marks a read that handlers asked to have recorded
+const auditKey = "audit_request"
+
+// Keys naming the records a request touched when its route does not
+const (
+	auditCustomerKey = "audit_customer_id"
+	auditAccountKey  = "audit_account_id"
+)
+
+// AuditRequest records the current request in the audit log even if it is a read,
+// e.g. one that revealed full account numbers
+func AuditRequest(c *gin.Context) {
+	c.Set(auditKey, true)
+}
+
+// AuditCustomer names the customer a request touched when its route does not, e.g. one it created
+func AuditCustomer(c *gin.Context, customerID uint) {
+	c.Set(auditCustomerKey, customerID)
+}
+
+// AuditAccount names the account a request touched when its route does not, e.g. a posting's account in its body
+func AuditAccount(c *gin.Context, accountID uint) {
+	c.Set(auditAccountKey, accountID)
+}
+
+// AuditMiddleware records requests made by authenticated users in the audit log
+// Mutating requests are always recorded; under impersonation every request is, tagged with the session,
+// and so is every third-party client request, tagged with the consent that allowed it. Reads of a customer's
+// records are recorded too, with the customer, so the customer's access report shows who looked
+func AuditMiddleware(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		username, authenticated := c.Get("username")
+		if !authenticated {
+			return
+		}
+		sessionID, impersonating := c.Get("impersonation_session_id")
+		clientID, isClient := c.Get("client_id")
+		customerID, accountID := auditSubject(c, db)
+		if !impersonating && !isClient && readOnlyMethod(c.Request.Method) && !c.GetBool(auditKey) && customerID == 0 {
+			return
+		}
+
+		role, _ := c.Get("user_role")
+		entry := models.AuditEntry{
+			Username:   username.(string),
+			Method:     c.Request.Method,
+			Path:       c.Request.URL.RequestURI(),
+			Route:      c.FullPath(),
+			Status:     c.Writer.Status(),
+			ClientIP:   c.ClientIP(),
+			DurationMS: time.Since(start).Milliseconds(),
+			RequestID:  c.GetString("request_id"),
+		}
+		entry.Role, _ = role.(string)
+		if customerID != 0 {
+			entry.CustomerID = &customerID
+		}
+		if accountID != 0 {
+			entry.AccountID = &accountID
+		}
+		if tenantID, ok := tenancy.FromContext(c.Request.Context()); ok {
+			entry.TenantID = tenantID
+		}
+		if impersonating {
+			session := sessionID.(uint)
+			customer := c.GetUint("impersonated_customer_id")
+			entry.Impersonation = true
+			entry.ImpersonationSessionID = &session
+			entry.ImpersonatedCustomerID = &customer
+		}
+		if isClient {
+			entry.ClientID = clientID.(string)
+			if consentID, ok := c.Get("consent_id"); ok {
+				id := consentID.(uint)
+				entry.ConsentID = &id
+			}
+		}
+
+		if err := db.Create(&entry).Error; err != nil {
+			log.Printf("audit: failed to record %s %s by %s: %v", entry.Method, entry.Path, entry.Username, err)
+		}
+	}
+}
+
+// auditSubject returns the customer, and the account if any, whose records a request touched
+// Handlers name them for records in a request body; otherwise a customer, account or transaction in the route is
+// resolved to its customer, and failing that the customer an impersonation or client token acts for
+func auditSubject(c *gin.Context, db *gorm.DB) (customerID, accountID uint) {
+	customerID, accountID = c.GetUint(auditCustomerKey), c.GetUint(auditAccountKey)
+	if customerID == 0 && accountID == 0 {
+		id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+		route := strings.TrimPrefix(strings.TrimPrefix(c.FullPath(), "/api/v1"), "/api/v2")
+		switch {
+		case id == 0:
+		case strings.HasPrefix(route, "/customers/:id"):
+			customerID = uint(id)
+		case strings.HasPrefix(route, "/accounts/:id"):
+			accountID = uint(id)
+		case strings.HasPrefix(route, "/transactions/:id"):
+			var transaction models.Transaction
+			if tenancy.DB(c, db).Unscoped().Select("account_id").First(&transaction, id).Error == nil {
+				accountID = transaction.AccountID
+			}
+		}
+	}
+	if customerID == 0 && accountID != 0 {
+		var account models.Account
+		if tenancy.DB(c, db).Unscoped().Select("customer_id").First(&account, accountID).Error == nil {
+			customerID = account.CustomerID
+		}
+	}
+	if customerID == 0 {
+		customerID = c.GetUint("impersonated_customer_id")
+	}
+	if customerID == 0 {
+		customerID = c.GetUint("client_customer_id")
+	}
+	return customerID, accountID
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"banking-app/auth"
 	"net/http"
 	"os"
 	"time"
@@ -19,6 +20,7 @@ type User struct {
 	ID       uint   `json:"id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	TenantID uint   `json:"tenant_id"`
 }
 
 // Claims represents JWT payload structure
@@ -27,6 +29,15 @@ type Claims struct {
 	UserID   uint   `json:"user_id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	TenantID uint   `json:"tenant_id,omitempty"` // Bank brand the token is valid for
+
+	// Impersonation - the token acts as CustomerID on behalf of the admin in UserID/Username
+	CustomerID             uint `json:"customer_id,omitempty"`              // Customer being impersonated
+	ImpersonationSessionID uint `json:"impersonation_session_id,omitempty"` // Session the token belongs to
+
+	// Third-party access - the token lets ClientID read CustomerID's data as far as the customer consented
+	ClientID string `json:"client_id,omitempty"` // Client the token was issued to
+	Scope    string `json:"scope,omitempty"`     // Space-separated scopes the token was granted
 	jwt.RegisteredClaims
 }
 
@@ -37,6 +48,7 @@ func GenerateJWT(user User) (string, error) {
 		UserID:   user.ID,
 		Username: user.Username,
 		Role:     user.Role,
+		TenantID: user.TenantID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // 24 hour expiration
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -48,6 +60,78 @@ func GenerateJWT(user User) (string, error) {
 	return token.SignedString(jwtSecret)
 }
 
+// GenerateImpersonationJWT issues a customer-role token for an admin viewing as a customer
+// The admin's own identity stays in the token so every request is attributed to them
+func GenerateImpersonationJWT(admin User, customerID, sessionID uint, expiresAt time.Time) (string, error) {
+	claims := Claims{
+		UserID:                 admin.ID,
+		Username:               admin.Username,
+		Role:                   "customer",
+		TenantID:               admin.TenantID,
+		CustomerID:             customerID,
+		ImpersonationSessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Subject:   admin.Username,
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret)
+}
+
+// GenerateClientJWT issues an OAuth2 access token to a third-party client
+// customerID is zero for a client-credentials token, which reaches no customer's data
+func GenerateClientJWT(clientID string, tenantID, customerID uint, role, scope string, expiresAt time.Time) (string, error) {
+	claims := Claims{
+		Username:   clientID,
+		Role:       role,
+		TenantID:   tenantID,
+		CustomerID: customerID,
+		ClientID:   clientID,
+		Scope:      scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Subject:   clientID,
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret)
+}
+
+// ParseJWT validates a token and returns its claims
+func ParseJWT(token string) (*Claims, error) {
+	claims := &Claims{}
+	if _, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// setClaims copies validated token claims into the request context
+func setClaims(c *gin.Context, claims *Claims) {
+	c.Set("user_id", claims.UserID)
+	c.Set("username", claims.Username)
+	c.Set("user_role", claims.Role)
+	if claims.TenantID != 0 {
+		c.Set("tenant_id", claims.TenantID)
+	}
+	if claims.ImpersonationSessionID != 0 {
+		c.Set("impersonation_session_id", claims.ImpersonationSessionID)
+		c.Set("impersonated_customer_id", claims.CustomerID)
+	}
+	if claims.ClientID != "" {
+		c.Set("client_id", claims.ClientID)
+		c.Set("client_customer_id", claims.CustomerID)
+		c.Set("client_scope", claims.Scope)
+	}
+}
+
 // AuthMiddleware validates JWT tokens for protected routes
 // Essential for banking security - ensures only authenticated users can access sensitive operations
 func AuthMiddleware() gin.HandlerFunc {
@@ -89,9 +173,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		// Add user information to context for use in handlers
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Set("user_role", claims.Role)
+		setClaims(c, claims)
 
 		c.Next()
 	}
@@ -122,9 +204,7 @@ func OptionalAuthMiddleware() gin.HandlerFunc {
 
 		if err == nil && tokenData.Valid {
 			// Add user information to context
-			c.Set("user_id", claims.UserID)
-			c.Set("username", claims.Username)
-			c.Set("user_role", claims.Role)
+			setClaims(c, claims)
 		}
 		
 		// Continue regardless of token validity for optional auth
@@ -151,4 +231,25 @@ func AdminMiddleware() gin.HandlerFunc {
 
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// PermissionMiddleware allows only roles holding a permission, for staff functions beyond admin
+func PermissionMiddleware(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userRole, exists := c.Get("user_role")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			c.Abort()
+			return
+		}
+
+		role, _ := userRole.(string)
+		if !auth.Can(role, permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
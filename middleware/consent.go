@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"banking-app/clock"
+	"banking-app/consent"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ConsentGuard limits third-party client tokens to reads their customer has consented to
+// A client may only read routes consent maps to a scope its token was granted. For customer data the route
+// must belong to the customer the token names, and an active consent must cover the scope. The client and
+// consent are checked on every request, so deleting the client or revoking the consent takes effect at once,
+// and the audit log records each access with the consent it was allowed under
+func ConsentGuard(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientID, isClient := c.Get("client_id")
+		if !isClient {
+			c.Next()
+			return
+		}
+
+		rule, ok := consent.RouteRule(c.FullPath())
+		if !ok || !readOnlyMethod(c.Request.Method) {
+			denyClient(c, "Third-party clients can only read customer data covered by a consent")
+			return
+		}
+		var count int64
+		tenancy.DB(c, db).Model(&models.OAuthClient{}).Where("client_id = ?", clientID).Count(&count)
+		if count == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Client is no longer registered"})
+			c.Abort()
+			return
+		}
+		if rule.Scope != "" && !consent.Contains(strings.Fields(c.GetString("client_scope")), rule.Scope) {
+			denyClient(c, "The token was not granted "+rule.Scope)
+			return
+		}
+		if rule.Subject == consent.SubjectNone {
+			c.Next()
+			return
+		}
+
+		customerID := c.GetUint("client_customer_id")
+		if owner, err := subjectCustomer(c, db, rule.Subject); err != nil || customerID == 0 || owner != customerID {
+			denyClient(c, "The token does not cover this customer's data")
+			return
+		}
+		granted, err := consent.Active(tenancy.DB(c, db), customerID, clientID.(string), rule.Scope, clock.Now())
+		if err != nil {
+			denyClient(c, "No active consent covers "+rule.Scope)
+			return
+		}
+
+		c.Set("consent_id", granted.ID)
+		c.Next()
+	}
+}
+
+// subjectCustomer returns the customer owning the record a route's :id names
+func subjectCustomer(c *gin.Context, db *gorm.DB, subject string) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if subject == consent.SubjectCustomer {
+		return uint(id), nil
+	}
+	var account models.Account
+	err = tenancy.DB(c, db).Select("customer_id").First(&account, id).Error
+	return account.CustomerID, err
+}
+
+// denyClient refuses a third-party request
+func denyClient(c *gin.Context, message string) {
+	c.JSON(http.StatusForbidden, gin.H{"error": message, "code": "CONSENT_REQUIRED"})
+	c.Abort()
+}
@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"banking-app/clock"
+	"banking-app/models"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ImpersonationTTL caps how long an impersonation session lasts
+const ImpersonationTTL = 30 * time.Minute
+
+// DefaultImpersonationMaxAmount is the largest amount a mutation may carry under impersonation
+const DefaultImpersonationMaxAmount = 1.00
+
+// maxInspectedBody bounds how much of a request body is read to find its amount
+const maxInspectedBody = 1 << 20
+
+// ImpersonationMaxAmountFromEnv reads IMPERSONATION_MAX_AMOUNT, defaulting to DefaultImpersonationMaxAmount
+func ImpersonationMaxAmountFromEnv() float64 {
+	raw := os.Getenv("IMPERSONATION_MAX_AMOUNT")
+	if raw == "" {
+		return DefaultImpersonationMaxAmount
+	}
+	amount, err := strconv.ParseFloat(raw, 64)
+	if err != nil || amount < 0 {
+		log.Printf("impersonation: ignoring invalid IMPERSONATION_MAX_AMOUNT %q", raw)
+		return DefaultImpersonationMaxAmount
+	}
+	return amount
+}
+
+// ImpersonationGuard limits what an impersonation token can do
+// Tokens stop working as soon as their session ends. Reads pass through; a mutation is allowed only
+// when its JSON body carries an amount no larger than maxAmount, so support staff can reproduce a
+// problem with a token-sized payment but cannot otherwise act for the customer
+func ImpersonationGuard(db *gorm.DB, maxAmount float64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionID, impersonating := c.Get("impersonation_session_id")
+		if !impersonating {
+			c.Next()
+			return
+		}
+
+		var session models.ImpersonationSession
+		err := db.First(&session, sessionID).Error
+		if err != nil || session.EndedAt != nil || !clock.Now().Before(session.ExpiresAt) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Impersonation session has ended"})
+			c.Abort()
+			return
+		}
+
+		if readOnlyMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+		if amount, ok := requestAmount(c); !ok || amount > maxAmount {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": fmt.Sprintf("Impersonation is read-only; only changes with an amount up to %.2f are allowed", maxAmount),
+				"code":  "IMPERSONATION_READ_ONLY",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// readOnlyMethod reports whether an HTTP method cannot change state
+func readOnlyMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// requestAmount reads the top-level amount of a JSON body and restores the body for the handler
+func requestAmount(c *gin.Context) (float64, bool) {
+	if c.Request.Body == nil {
+		return 0, false
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxInspectedBody))
+	if err != nil {
+		return 0, false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var payload struct {
+		Amount *float64 `json:"amount"`
+	}
+	if json.Unmarshal(body, &payload) != nil || payload.Amount == nil {
+		return 0, false
+	}
+	return *payload.Amount, true
+}
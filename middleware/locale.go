@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"banking-app/i18n"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// localeKey holds the request's *i18n.Locale in the gin context
+const localeKey = "locale"
+
+// Locale returns the language a request is answered in, the default when none was negotiated
+func Locale(c *gin.Context) *i18n.Locale {
+	if locale, ok := c.Get(localeKey); ok {
+		return locale.(*i18n.Locale)
+	}
+	return i18n.For(i18n.Default)
+}
+
+// setLocale records the request's language and announces it in Content-Language
+func setLocale(c *gin.Context, locale *i18n.Locale) {
+	c.Set(localeKey, locale)
+	c.Header("Content-Language", locale.Tag)
+}
+
+// Localize negotiates the response language from Accept-Language and translates error responses into it
+// A JSON error body carrying a code has its message replaced with the catalog's; codes the catalog lacks
+// keep the handler's English message. Responses in the default language pass through untouched
+func Localize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setLocale(c, i18n.Negotiate(c.GetHeader("Accept-Language")))
+		if !strings.Contains(strings.Join(c.Writer.Header().Values("Vary"), ","), "Accept-Language") {
+			c.Writer.Header().Add("Vary", "Accept-Language") // A v2 request falling through to v1 passes here twice
+		}
+
+		w := &localizingWriter{ResponseWriter: c.Writer, c: c}
+		c.Writer = w
+		defer w.finish()
+		c.Next()
+	}
+}
+
+// CustomerLocale answers customers in their preferred language whatever their client asks for
+// Applies to customer sign-ins, impersonation and third-party tokens acting for a customer; must run after
+// the auth and tenancy middleware
+func CustomerLocale(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var languages []string
+		query := tenancy.DB(c, db).Model(&models.Customer{})
+		switch {
+		case c.GetUint("impersonated_customer_id") != 0:
+			query.Where("id = ?", c.GetUint("impersonated_customer_id")).Pluck("preferred_language", &languages)
+		case c.GetUint("client_customer_id") != 0:
+			query.Where("id = ?", c.GetUint("client_customer_id")).Pluck("preferred_language", &languages)
+		case c.GetString("user_role") == "customer":
+			query.Where("id = (SELECT customer_id FROM users WHERE id = ?)", c.GetUint("user_id")).Pluck("preferred_language", &languages)
+		}
+		if len(languages) > 0 && languages[0] != "" {
+			setLocale(c, i18n.For(languages[0]))
+		}
+		c.Next()
+	}
+}
+
+// localizingWriter holds back error bodies in a language other than the default so they can be translated
+type localizingWriter struct {
+	gin.ResponseWriter
+	c       *gin.Context
+	buf     bytes.Buffer
+	decided bool
+	holding bool
+}
+
+func (w *localizingWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		w.holding = w.Status() >= http.StatusBadRequest && Locale(w.c).Tag != i18n.Default &&
+			strings.HasPrefix(w.Header().Get("Content-Type"), "application/json")
+	}
+	if w.holding {
+		return w.buf.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *localizingWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Written reports a held-back body as written, as it will be
+func (w *localizingWriter) Written() bool {
+	return w.ResponseWriter.Written() || w.buf.Len() > 0
+}
+
+// Flush sends anything held back as it is; a flushing handler is streaming, not failing
+func (w *localizingWriter) Flush() {
+	if w.holding {
+		w.holding = false
+		w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// finish translates and sends a held-back error body
+func (w *localizingWriter) finish() {
+	if !w.holding {
+		return
+	}
+	body := localizeError(w.buf.Bytes(), Locale(w.c))
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.Write(body)
+}
+
+// localizeError replaces the message of a v1 {"error", "code"} body or a v2 {"error": {"code", "message"}}
+// envelope with the locale's message for its code; anything else is returned as it is
+func localizeError(body []byte, locale *i18n.Locale) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber() // Other values are re-encoded exactly as they were
+	var doc map[string]interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return body
+	}
+	switch e := doc["error"].(type) {
+	case string:
+		code, _ := doc["code"].(string)
+		if code == "" {
+			return body
+		}
+		doc["error"] = locale.Error(code, e)
+	case map[string]interface{}:
+		code, _ := e["code"].(string)
+		message, _ := e["message"].(string)
+		if code == "" {
+			return body
+		}
+		e["message"] = locale.Error(code, message)
+	default:
+		return body
+	}
+	localized, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	return localized
+}
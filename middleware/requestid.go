@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the request id in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID keeps ids supplied by callers short and free of characters that could forge log lines
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID gives every request an id, stored as "request_id" and echoed in the X-Request-ID response header
+// A well-formed id sent by the caller is kept so a request can be followed across services
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Set("request_id", id)
+		c.Header(RequestIDHeader, id)
+		c.Request.Header.Set(RequestIDHeader, id) // A v2 request re-dispatched to v1 keeps its id
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// LogFormatter is gin's access log line with the request and trace ids appended, so a log line leads to its trace
+func LogFormatter(param gin.LogFormatterParams) string {
+	line := fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v", param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode, param.Latency, param.ClientIP, param.Method, param.Path)
+	if id, ok := param.Keys["request_id"].(string); ok {
+		line += " request_id=" + id
+	}
+	if id, ok := param.Keys["trace_id"].(string); ok {
+		line += " trace_id=" + id
+	}
+	return line + "\n" + param.ErrorMessage
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// AccountApplication is a customer's request to open an account, filled in over several steps and resumable until
+// it is submitted. Eligibility is evaluated on submission; an approved application is opened into a real account
+type AccountApplication struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique application identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the draft was started
+	UpdatedAt time.Time `json:"updated_at"`                                // Last step or status change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	CustomerID     uint      `json:"customer_id" gorm:"not null;index"`    // Applicant
+	Status         string    `json:"status" gorm:"size:20;not null;index"` // draft, submitted, approved, rejected, opened, expired
+	CompletedSteps string    `json:"completed_steps" gorm:"size:50"`       // Comma-separated steps filled in, e.g. product,funding
+	CreatedBy      string    `json:"created_by" gorm:"size:100;not null"`  // Customer or staff member who started it
+	ExpiresAt      time.Time `json:"expires_at" gorm:"not null;index"`     // A draft not submitted by then expires
+
+	// Product step
+	AccountType string `json:"account_type,omitempty" gorm:"size:20"` // Desired product, by its account type
+	Currency    string `json:"currency,omitempty" gorm:"size:3"`
+
+	// Funding step
+	FundingMethod    string  `json:"funding_method,omitempty" gorm:"size:20"`  // none or transfer
+	FundingAmount    float64 `json:"funding_amount" gorm:"type:decimal(15,2)"` // Initial deposit
+	FundingAccountID *uint   `json:"funding_account_id,omitempty"`             // Applicant's account a transfer comes from
+
+	// Submission and decision
+	SubmittedAt    *time.Time `json:"submitted_at,omitempty"`
+	FailedCriteria string     `json:"-" gorm:"type:text"`                   // JSON-encoded eligibility failures found on submission
+	DecidedBy      string     `json:"decided_by,omitempty" gorm:"size:100"` // Staff member, or "system" when approved on submission
+	DecidedAt      *time.Time `json:"decided_at,omitempty"`
+	DecisionReason string     `json:"decision_reason,omitempty" gorm:"size:500"`
+
+	AccountID *uint      `json:"account_id,omitempty" gorm:"index"` // Account opened from the application
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+}
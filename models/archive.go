@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// TransactionArchive is the running total of one account's postings moved to the transactions_archive table
+// Statements add Net to the hot postings for opening balances instead of reading the archive, and a date range
+// reaches the archive only when it starts on or before Through
+type TransactionArchive struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique summary identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the account's first postings were archived
+	UpdatedAt time.Time `json:"updated_at"`                                // Last archive or unarchive batch
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // The account's tenant
+
+	AccountID uint      `json:"account_id" gorm:"not null;uniqueIndex"` // Account
+	Count     int64     `json:"count"`                                  // Archived postings, not counting soft-deleted ones
+	Net       float64   `json:"net" gorm:"type:decimal(15,2)"`          // Signed sum of those postings: credits positive, debits negative
+	Through   time.Time `json:"through"`                                // Latest effective date or creation time among them
+}
+
+// Archive batch directions
+const (
+	ArchiveDirectionArchive   = "archive"
+	ArchiveDirectionUnarchive = "unarchive"
+)
+
+// ArchiveBatch is one batch of postings moved between the hot and archive tables in a single database transaction
+type ArchiveBatch struct {
+	ID        uint      `json:"id" gorm:"primaryKey"` // Unique batch identifier
+	CreatedAt time.Time `json:"created_at"`           // When the batch was committed
+
+	Direction string     `json:"direction" gorm:"size:20;not null;index"` // archive or unarchive
+	AccountID *uint      `json:"account_id,omitempty"`                    // Account an unarchive was limited to
+	Cutoff    *time.Time `json:"cutoff,omitempty"`                        // Archive: postings effective and created before this moved
+	From      *time.Time `json:"from,omitempty"`                          // Unarchive: start of the effective-date range, inclusive
+	To        *time.Time `json:"to,omitempty"`                            // Unarchive: end of the effective-date range, exclusive
+	Rows      int        `json:"rows"`                                    // Postings moved, reconciled against both tables
+	FirstID   uint       `json:"first_id"`                                // Lowest posting ID moved
+	LastID    uint       `json:"last_id"`                                 // Highest posting ID moved
+	Actor     string     `json:"actor" gorm:"size:100"`                   // Administrator who unarchived, or the job
+	HoldUntil *time.Time `json:"hold_until,omitempty"`                    // Unarchive: the job leaves the range hot until then
+}
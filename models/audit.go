@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// AuditEntry records one API request made by an authenticated user
+// Entries are append-only, so ids rise with time; requests made under impersonation carry the admin's identity
+type AuditEntry struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`                                             // Unique entry identifier
+	CreatedAt  time.Time `json:"created_at" gorm:"index;index:idx_audit_route_created,priority:2"` // Request time
+	TenantID   uint      `json:"tenant_id" gorm:"not null;default:1;index"`                        // Owning bank brand
+	Username   string    `json:"username" gorm:"size:100;index"`                                   // User the token was issued to
+	Role       string    `json:"role" gorm:"size:20"`                                              // Role the request was made with
+	Method     string    `json:"method" gorm:"size:10"`                                            // HTTP method
+	Path       string    `json:"path" gorm:"size:500"`                                             // Request path and query
+	Route      string    `json:"route" gorm:"size:200;index:idx_audit_route_created,priority:1"`   // Matched route pattern
+	Status     int       `json:"status"`                                                           // Response status code
+	ClientIP   string    `json:"client_ip" gorm:"size:64"`                                         // Caller address
+	DurationMS int64     `json:"duration_ms"`                                                      // Time taken to respond
+	RequestID  string    `json:"request_id,omitempty" gorm:"size:64;index"`                        // X-Request-ID, also on the request's trace
+
+	// Impersonation - set when an admin made the request as a customer
+	Impersonation          bool  `json:"impersonation" gorm:"index"`                      // Request used an impersonation token
+	ImpersonationSessionID *uint `json:"impersonation_session_id,omitempty" gorm:"index"` // Session the token belongs to
+	ImpersonatedCustomerID *uint `json:"impersonated_customer_id,omitempty"`              // Customer being viewed
+
+	// Data subject - whose records the request read or changed, for customers' access reports
+	CustomerID *uint `json:"customer_id,omitempty" gorm:"index"` // Customer whose records were touched
+	AccountID  *uint `json:"account_id,omitempty" gorm:"index"`  // Account read or changed
+
+	// Bulk operations - one entry per account changed, recorded by the background run
+	BulkOperationID *uint `json:"bulk_operation_id,omitempty" gorm:"index"` // Operation that made the change
+
+	// Campaigns - one entry per customer a regulatory campaign messaged despite their opt-out
+	CampaignID *uint `json:"campaign_id,omitempty" gorm:"index"` // Campaign that overrode the preference
+
+	// Third-party access - set when a client read a customer's data under a consent
+	ClientID  string `json:"client_id,omitempty" gorm:"size:100;index"` // Client the token was issued to
+	ConsentID *uint  `json:"consent_id,omitempty" gorm:"index"`         // Consent the access was allowed under
+}
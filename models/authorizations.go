@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Authorization is a pre-authorization hold: an amount set aside on an account for a card merchant or a transfer
+// to capture later. pending: held against the account's available funds until expires_at, captured: posted as a
+// debit, released: voided before capture, expired: released because it was not captured in time
+type Authorization struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique authorization identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the hold was placed
+	UpdatedAt time.Time `json:"updated_at"`                                // Last status change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	Status      string    `json:"status" gorm:"size:20;not null;index"`      // pending, captured, released, expired
+	Channel     string    `json:"channel" gorm:"size:20;not null"`           // card or transfer; sets the default expiry
+	AccountID   uint      `json:"account_id" gorm:"not null;index"`          // Account the amount is held on
+	CustomerID  uint      `json:"customer_id" gorm:"not null;index"`         // Account holder, told when the hold expires
+	ToAccountID uint      `json:"to_account_id,omitempty"`                   // Destination a transfer hold is captured to
+	Amount      float64   `json:"amount" gorm:"type:decimal(15,2);not null"` // Amount held; a capture may take less
+	Merchant    string    `json:"merchant,omitempty" gorm:"size:200"`        // Card merchant, or the transfer's description
+	Reference   string    `json:"reference,omitempty" gorm:"size:100;index"` // Merchant or client reference, reused when re-authorizing
+	ExpiresAt   time.Time `json:"expires_at" gorm:"index"`                   // Released by the expiry job once passed uncaptured
+	RequestedBy string    `json:"requested_by" gorm:"size:100;not null"`     // User who placed the hold
+
+	PreviousID *uint `json:"previous_id,omitempty"` // Expired or released authorization with the same reference this one replaces
+
+	CapturedAmount float64    `json:"captured_amount" gorm:"type:decimal(15,2);not null;default:0"` // Amount posted on capture
+	TransactionID  *uint      `json:"transaction_id,omitempty"`                                     // Debit posted on capture
+	TransferID     *uint      `json:"transfer_id,omitempty"`                                        // Transfer posted on capture
+	ClosedAt       *time.Time `json:"closed_at,omitempty"`                                          // When it was captured, released or expired
+	ClosedBy       string     `json:"closed_by,omitempty" gorm:"size:100"`                          // User who captured or released it, or system
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Budget is a customer's monthly spending limit for one transaction category
+// Spending is the month-to-date debits categorized with the budget's category code
+type Budget struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`                      // Unique budget identifier
+	CreatedAt time.Time      `json:"created_at"`                                // When the budget was set
+	UpdatedAt time.Time      `json:"updated_at"`                                // Last change timestamp
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`                            // Soft delete support
+	TenantID  uint           `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	CustomerID    uint    `json:"customer_id" gorm:"not null;index"`                 // Customer whose spending is budgeted
+	Category      string  `json:"category" gorm:"size:4;not null"`                   // Category code of the spending, e.g. 5411
+	MonthlyAmount float64 `json:"monthly_amount" gorm:"type:decimal(15,2);not null"` // Spending allowed per bank month
+
+	// Alert Delivery
+	Channel string `json:"channel" gorm:"size:20;not null;default:'email'"` // email, webhook
+	Target  string `json:"target" gorm:"size:500"`                          // Webhook URL or email override (defaults to the verified customer email)
+}
+
+// BudgetAlert records a budget threshold reached in a month; each is sent at most once per budget and month
+type BudgetAlert struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique alert identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the threshold was reached
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	BudgetID       uint    `json:"budget_id" gorm:"not null;uniqueIndex:idx_budget_alerts_period,priority:1"`     // Budget reached
+	Period         string  `json:"period" gorm:"size:7;not null;uniqueIndex:idx_budget_alerts_period,priority:2"` // Bank month, YYYY-MM
+	Threshold      int     `json:"threshold" gorm:"not null;uniqueIndex:idx_budget_alerts_period,priority:3"`     // Percent of the budget: 80 or 100
+	Spent          float64 `json:"spent" gorm:"type:decimal(15,2)"`                                               // Month-to-date spending when it was reached
+	TransactionID  *uint   `json:"transaction_id,omitempty"`                                                      // Posting that reached it
+	NotificationID uint    `json:"notification_id"`                                                               // Notification queued for it; shared when one posting reaches both
+}
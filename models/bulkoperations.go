@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// BulkFilter selects the accounts a bulk operation applies to; criteria are combined with AND
+type BulkFilter struct {
+	CustomerIDs string     `json:"customer_ids,omitempty" gorm:"type:text"` // Comma-separated customer IDs
+	AccountIDs  string     `json:"account_ids,omitempty" gorm:"type:text"`  // Comma-separated account IDs
+	AccountType string     `json:"account_type,omitempty" gorm:"size:20"`   // Product, by account type
+	CreatedFrom *time.Time `json:"created_from,omitempty"`                  // Accounts opened at or after
+	CreatedTo   *time.Time `json:"created_to,omitempty"`                    // Accounts opened before
+}
+
+// BulkOperation is an administrator action applied to every account matching a filter
+// It runs in the background; each account's outcome is recorded as a BulkOperationItem
+type BulkOperation struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique operation identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the operation was requested
+	UpdatedAt time.Time `json:"updated_at"`                                // Last progress update
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	Action         string     `json:"action" gorm:"size:20;not null"`                      // freeze, unfreeze, set_limit, convert_product, recompute_balances
+	Filter         BulkFilter `json:"filter" gorm:"embedded;embeddedPrefix:filter_"`       // Accounts selected
+	OverdraftLimit *float64   `json:"overdraft_limit,omitempty" gorm:"type:decimal(15,2)"` // New limit for set_limit
+	TargetType     string     `json:"target_type,omitempty" gorm:"size:20"`                // Product convert_product moves accounts to
+	NextCycle      bool       `json:"next_cycle,omitempty"`                                // convert_product from the next statement cycle
+	Reason         string     `json:"reason" gorm:"size:500"`                              // Why, e.g. the fraud case
+	RequestedBy    string     `json:"requested_by" gorm:"size:100;not null"`               // Administrator who submitted it
+	RequestedRole  string     `json:"-" gorm:"size:20"`                                    // Their role, for the audit entries
+
+	// Progress
+	Status      string     `json:"status" gorm:"size:20;default:'queued';index"` // queued, running, completed
+	Total       int        `json:"total"`                                        // Accounts matched when the run started
+	Processed   int        `json:"processed"`                                    // Accounts handled so far
+	Succeeded   int        `json:"succeeded"`                                    // Accounts changed
+	Skipped     int        `json:"skipped"`                                      // Accounts already in the requested state
+	Failed      int        `json:"failed"`                                       // Accounts the action could not apply to
+	StartedAt   *time.Time `json:"started_at,omitempty"`                         // When the run started
+	CompletedAt *time.Time `json:"completed_at,omitempty"`                       // When the last account was handled
+}
+
+// BulkOperationItem is the outcome of a bulk operation for one account
+type BulkOperationItem struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique item identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the account was handled
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	BulkOperationID uint   `json:"bulk_operation_id" gorm:"not null;uniqueIndex:idx_bulk_items_operation_account,priority:1"` // Operation
+	AccountID       uint   `json:"account_id" gorm:"not null;uniqueIndex:idx_bulk_items_operation_account,priority:2"`        // Account handled
+	Result          string `json:"result" gorm:"size:20;not null;index"`                                                      // succeeded, skipped, failed
+	PreviousStatus  string `json:"previous_status" gorm:"size:20"`                                                            // Account status before the action
+	Error           string `json:"error,omitempty" gorm:"size:255"`                                                           // Why the action failed
+
+	Drift *float64 `json:"drift,omitempty" gorm:"type:decimal(15,2)"` // recompute_balances: stored balance less the sum of the postings
+}
@@ -0,0 +1,83 @@
+package models
+
+import "time"
+
+// CampaignAudience selects the customers a campaign goes to; criteria are combined with AND
+type CampaignAudience struct {
+	AccountType    string `json:"account_type,omitempty" gorm:"size:20"`    // Product: customers holding an open account of this type
+	Tag            string `json:"tag,omitempty" gorm:"size:40"`             // Customers carrying this tag
+	CustomerStatus string `json:"customer_status,omitempty" gorm:"size:20"` // Customers in this status, e.g. active
+	TenantIDs      string `json:"tenant_ids" gorm:"type:text"`              // Comma-separated tenants whose customers are included
+}
+
+// Campaign is an announcement emailed to every customer in an audience, such as notice of a fee schedule change
+// The campaigns job sends it in rate-limited batches from its scheduled time; each customer's outcome is a
+// CampaignRecipient
+type Campaign struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique campaign identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the campaign was created
+	UpdatedAt time.Time `json:"updated_at"`                                // Last progress update
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	Name        string           `json:"name" gorm:"size:200;not null"`                     // Label for staff
+	Audience    CampaignAudience `json:"audience" gorm:"embedded;embeddedPrefix:audience_"` // Customers selected
+	Subject     string           `json:"subject" gorm:"size:255;not null"`                  // Email subject template
+	Template    string           `json:"template" gorm:"type:text;not null"`                // Email body template with {variables}
+	Regulatory  bool             `json:"regulatory"`                                        // Mandatory notice, sent whatever the customer's preferences
+	ScheduledAt time.Time        `json:"scheduled_at" gorm:"index"`                         // When sending starts
+	CreatedBy   string           `json:"created_by" gorm:"size:100;not null"`               // Administrator who created it
+	CreatedRole string           `json:"-" gorm:"size:20"`                                  // Their role, for the audit entries
+
+	// Rate limit
+	BatchSize            int `json:"batch_size"`             // Customers queued per batch
+	BatchIntervalSeconds int `json:"batch_interval_seconds"` // Time between batches
+
+	// Progress - counts of recipients by status
+	Status      string     `json:"status" gorm:"size:20;default:'scheduled';index"` // scheduled, running, paused, completed
+	Total       int        `json:"total"`                                           // Customers in the audience when sending started
+	Pending     int        `json:"pending"`                                         // Not yet batched
+	Queued      int        `json:"queued"`                                          // Handed to the notification queue, awaiting delivery
+	Sent        int        `json:"sent"`                                            // Delivered
+	Failed      int        `json:"failed"`                                          // Delivery failed after retries
+	Suppressed  int        `json:"suppressed"`                                      // Skipped: the customer opted out
+	Unreachable int        `json:"unreachable"`                                     // Skipped: no verified email address
+	Overrides   int        `json:"overrides"`                                       // Regulatory sends to customers who opted out
+	StartedAt   *time.Time `json:"started_at,omitempty"`                            // When the audience was taken
+	LastBatchAt *time.Time `json:"last_batch_at,omitempty"`                         // When the latest batch was queued
+	PausedAt    *time.Time `json:"paused_at,omitempty"`                             // When it was last paused
+	PausedBy    string     `json:"paused_by,omitempty" gorm:"size:100"`             // Who paused it
+	CompletedAt *time.Time `json:"completed_at,omitempty"`                          // When the last delivery was settled
+}
+
+// CampaignRecipient is one customer's place in a campaign and the outcome of their message
+type CampaignRecipient struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique recipient identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the audience was taken
+	UpdatedAt time.Time `json:"updated_at"`                                // Last status change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // The campaign's tenant
+
+	CampaignID         uint       `json:"campaign_id" gorm:"not null;uniqueIndex:idx_campaign_recipients_campaign_customer,priority:1"` // Campaign
+	CustomerID         uint       `json:"customer_id" gorm:"not null;uniqueIndex:idx_campaign_recipients_campaign_customer,priority:2"` // Customer addressed
+	Status             string     `json:"status" gorm:"size:20;not null;index"`                                                         // pending, queued, sent, failed, suppressed, unreachable
+	NotificationID     *uint      `json:"notification_id,omitempty" gorm:"index"`                                                       // Message queued for the customer
+	OverrodePreference bool       `json:"overrode_preference"`                                                                          // Sent by a regulatory campaign despite an opt-out
+	Error              string     `json:"error,omitempty" gorm:"size:500"`                                                              // Why delivery failed
+	QueuedAt           *time.Time `json:"queued_at,omitempty"`                                                                          // When the message was queued
+
+	UnsubscribeTokenHash string `json:"-" gorm:"size:64;index"` // SHA-256 of the emailed unsubscribe token
+}
+
+// NotificationPreference is a customer's choice about one category of optional messages
+// Customers without a row receive the category
+type NotificationPreference struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique preference identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the choice was first made
+	UpdatedAt time.Time `json:"updated_at"`                                // When it last changed
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	CustomerID uint   `json:"customer_id" gorm:"not null;uniqueIndex:idx_notification_preferences_customer_category,priority:1"`      // Customer
+	Category   string `json:"category" gorm:"size:30;not null;uniqueIndex:idx_notification_preferences_customer_category,priority:2"` // e.g. announcements
+	OptedOut   bool   `json:"opted_out"`                                                                                              // The customer does not want these messages
+	Source     string `json:"source" gorm:"size:20"`                                                                                  // preferences, unsubscribe
+	CampaignID *uint  `json:"campaign_id,omitempty"`                                                                                  // Campaign whose unsubscribe link was used
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BalanceCertificate is an issued balance confirmation letter
+// The figures are frozen at issue so the document can be re-rendered and verified later
+type BalanceCertificate struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`                      // Unique certificate identifier
+	CreatedAt time.Time      `json:"created_at"`                                // Issue time
+	UpdatedAt time.Time      `json:"updated_at"`                                // Last update timestamp
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`                            // Soft delete support
+	TenantID  uint           `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	AccountID     uint   `json:"account_id" gorm:"not null;index"`  // Account the certificate covers
+	CustomerID    uint   `json:"customer_id" gorm:"not null;index"` // Account holder
+	HolderName    string `json:"holder_name" gorm:"size:200"`       // Holder name as printed
+	AccountNumber string `json:"account_number" gorm:"size:50"`     // Account number as printed (masked if requested)
+	Masked        bool   `json:"masked"`                            // Whether the account number was masked
+	Currency      string `json:"currency" gorm:"size:3"`            // Account currency
+	Purpose       string `json:"purpose,omitempty" gorm:"size:200"` // Stated purpose, e.g. visa application
+
+	AsOf           time.Time  `json:"as_of" gorm:"type:date"`                              // Date the balance is stated at (end of day)
+	Balance        float64    `json:"balance" gorm:"type:decimal(15,2)"`                   // Balance at the end of AsOf
+	AverageFrom    *time.Time `json:"average_from,omitempty" gorm:"type:date"`             // First day of the averaging period
+	AverageTo      *time.Time `json:"average_to,omitempty" gorm:"type:date"`               // Last day of the averaging period
+	AverageBalance *float64   `json:"average_balance,omitempty" gorm:"type:decimal(15,2)"` // Average end-of-day balance over the period
+
+	VerificationCode string `json:"verification_code" gorm:"size:40;uniqueIndex;not null"` // HMAC code printed on the document
+	IssuedBy         string `json:"issued_by" gorm:"size:100"`                             // User who issued it
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// AccountClosure records how an account was closed and where its remaining balance went
+// One row per account; a retried close returns this record instead of closing again
+type AccountClosure struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique closure identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the account was closed
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	AccountID            uint    `json:"account_id" gorm:"not null;uniqueIndex"`          // Closed account
+	Method               string  `json:"method" gorm:"size:20;not null"`                  // transfer, cashier_check, none (zero balance)
+	DestinationAccountID *uint   `json:"destination_account_id,omitempty"`                // Account that received the sweep
+	CashierCheckNumber   string  `json:"cashier_check_number,omitempty" gorm:"size:30"`   // Check issued for the sweep
+	SweptAmount          float64 `json:"swept_amount" gorm:"type:decimal(15,2);not null"` // Balance moved out at closure
+	SweepTransactionID   *uint   `json:"sweep_transaction_id,omitempty"`                  // Debit on the closed account
+	CreditTransactionID  *uint   `json:"credit_transaction_id,omitempty"`                 // Credit on the destination account
+	ClosedBy             string  `json:"closed_by" gorm:"size:100"`                       // Username of the requester
+	Reason               string  `json:"reason" gorm:"size:500"`                          // Free-text closure reason
+}
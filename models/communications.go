@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// CommunicationLog records one message or document sent or produced for a customer
+// Entries are never edited; a retried send adds a new entry when it completes
+type CommunicationLog struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique entry identifier
+	CreatedAt time.Time `json:"created_at" gorm:"index"`                   // When the send completed or the document was produced
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	CustomerID     uint   `json:"customer_id" gorm:"not null;index"`      // Customer the communication was for
+	Channel        string `json:"channel" gorm:"size:20;not null;index"`  // email, webhook, letter, archive
+	Subject        string `json:"subject" gorm:"size:255"`                // Subject line or document title
+	Status         string `json:"status" gorm:"size:20;not null"`         // sent, failed, generated, archived
+	ResourceType   string `json:"resource_type,omitempty" gorm:"size:30"` // Related record, e.g. statement, certificate
+	ResourceID     uint   `json:"resource_id,omitempty"`                  // Identifier of the related record
+	NotificationID *uint  `json:"notification_id,omitempty" gorm:"index"` // Notification behind a sent or failed message
+	Error          string `json:"error,omitempty" gorm:"size:500"`        // Why a send failed
+	ContentKey     string `json:"content_key" gorm:"size:255;not null"`   // Body or document in document storage
+	ContentType    string `json:"content_type" gorm:"size:100"`           // MIME type of the stored content
+}
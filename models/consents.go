@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Consent is a customer's permission for a third-party client to read some of their data
+// Consents are never edited; revoking or expiry ends one, and the customer grants a new one instead
+type Consent struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique consent identifier
+	CreatedAt time.Time `json:"created_at"`                                // Record creation timestamp
+	UpdatedAt time.Time `json:"updated_at"`                                // Last update timestamp
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	CustomerID uint       `json:"customer_id" gorm:"not null;index:idx_consents_customer_client,priority:1"`         // Customer whose data is shared
+	GrantedTo  string     `json:"granted_to" gorm:"size:100;not null;index:idx_consents_customer_client,priority:2"` // Client identifier of the third party
+	Scopes     string     `json:"scopes" gorm:"size:200;not null"`                                                   // Comma-separated scopes, e.g. accounts:read,transactions:read
+	GrantedAt  time.Time  `json:"granted_at"`                                                                        // When the customer granted it
+	GrantedBy  string     `json:"granted_by" gorm:"size:100"`                                                        // User who recorded the grant
+	ExpiresAt  time.Time  `json:"expires_at"`                                                                        // Access ends at this time
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`                                                              // Set when revoked early
+	RevokedBy  string     `json:"revoked_by,omitempty" gorm:"size:100"`                                              // User who revoked it
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CreditLine is a revolving line of credit linked to a checking account
+// Withdrawals the checking balance cannot cover draw the shortfall from the line, and later deposits repay it.
+// The drawn amount is the negative balance of the line's own account; a backing loan carries its parties and approval
+type CreditLine struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`                      // Unique line identifier
+	CreatedAt time.Time      `json:"created_at"`                                // When the line was applied for
+	UpdatedAt time.Time      `json:"updated_at"`                                // Last update timestamp
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`                            // Soft delete support
+	TenantID  uint           `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	LineNumber      string `json:"line_number" gorm:"size:50;uniqueIndex;not null"` // Also the backing loan's number
+	CustomerID      uint   `json:"customer_id" gorm:"not null;index"`               // Borrower
+	LinkedAccountID uint   `json:"linked_account_id" gorm:"not null;index"`         // Checking account the line covers
+	AccountID       uint   `json:"account_id" gorm:"index"`                         // Line account, opened on activation; negative balance is the drawn amount
+	LoanID          uint   `json:"loan_id" gorm:"not null;index"`                   // Backing loan - parties and affordability
+
+	// Terms
+	CreditLimit  float64 `json:"credit_limit" gorm:"type:decimal(15,2);not null"` // Most that can be drawn
+	InterestRate float64 `json:"interest_rate" gorm:"type:decimal(5,4);not null"` // Annual rate on the drawn amount
+
+	// Interest
+	AccruedInterest        float64    `json:"accrued_interest" gorm:"type:decimal(15,2);default:0"` // Interest accrued and not yet repaid
+	InterestAccruedThrough *time.Time `json:"interest_accrued_through,omitempty"`                   // Day interest has been accrued up to
+
+	Status      string     `json:"status" gorm:"size:20;default:'pending';index"` // pending, declined, active, closed
+	ActivatedAt *time.Time `json:"activated_at,omitempty"`                        // When the line was approved and opened
+	ClosedAt    *time.Time `json:"closed_at,omitempty"`                           // When the repaid line was closed
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// CreditReport is a soft credit pull kept so the bureau is not asked again while it is fresh
+type CreditReport struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	CustomerID uint      `json:"customer_id" gorm:"not null;index:idx_credit_reports_customer_pulled,priority:1"` // Customer the report is on
+	ReportID   string    `json:"report_id" gorm:"size:100;not null"`                                             // Bureau's report reference
+	Score      int       `json:"score"`                                                                          // Credit score at the time of the pull
+	Tradelines string    `json:"-" gorm:"type:text"`                                                             // JSON-encoded tradelines
+	PulledAt   time.Time `json:"pulled_at" gorm:"index:idx_credit_reports_customer_pulled,priority:2"`           // When the bureau answered
+}
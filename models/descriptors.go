@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// DescriptorTemplate overrides the built-in template that describes one kind of posting on statements
+// An empty account type applies to every product; a template for the posting's product wins over it
+type DescriptorTemplate struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                                                                            // Unique template identifier
+	CreatedAt time.Time `json:"created_at"`                                                                                      // When the template was created
+	UpdatedAt time.Time `json:"updated_at"`                                                                                      // Last change timestamp
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index;uniqueIndex:idx_descriptor_templates_scope,priority:1"` // Owning bank brand
+
+	AccountType string `json:"account_type" gorm:"size:20;uniqueIndex:idx_descriptor_templates_scope,priority:2"`  // Product described; empty for every product
+	Kind        string `json:"kind" gorm:"size:30;not null;uniqueIndex:idx_descriptor_templates_scope,priority:3"` // deposit, transfer_out, loan_payment, ...
+	Template    string `json:"template" gorm:"size:200;not null"`                                                  // e.g. "TRANSFER TO {counterparty_account} / {counterparty_name}"
+
+	CreatedBy string `json:"created_by" gorm:"size:100"` // Admin who created it
+}
@@ -0,0 +1,64 @@
+package models
+
+import "time"
+
+// Dispute is a customer's challenge to a posted transaction, opened by the customer or by staff on their behalf and
+// resolved by staff. A transaction has at most one open dispute; the postings are left as they are either way
+// open: waiting for staff, resolved: decided, with the outcome in the resolution
+type Dispute struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique dispute identifier
+	CreatedAt time.Time `json:"created_at"`                                // When it was opened
+	UpdatedAt time.Time `json:"updated_at"`                                // Last status change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	Status        string  `json:"status" gorm:"size:20;not null;index"`      // open, resolved
+	TransactionID uint    `json:"transaction_id" gorm:"not null;index"`      // Posting disputed
+	AccountID     uint    `json:"account_id" gorm:"not null;index"`          // Account it was posted to
+	CustomerID    uint    `json:"customer_id" gorm:"not null;index"`         // Account holder
+	Amount        float64 `json:"amount" gorm:"type:decimal(15,2);not null"` // Amount of the posting
+	Reason        string  `json:"reason" gorm:"size:500;not null"`           // Why the customer disputes it
+	OpenedBy      string  `json:"opened_by" gorm:"size:100;not null"`        // Customer's username, or the staff member
+
+	// Thread - the customer and the assigned staff member are the only ones who post or read it
+	AssignedTo             string     `json:"assigned_to,omitempty" gorm:"size:100;index"`     // Staff member working the dispute
+	InformationRequestedAt *time.Time `json:"information_requested_at,omitempty" gorm:"index"` // Staff asked the customer for information; cleared by their reply
+	ClosureWarnedAt        *time.Time `json:"closure_warned_at,omitempty"`                     // Customer warned the unanswered request may close the dispute
+
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`                 // When staff decided it
+	ResolvedBy string     `json:"resolved_by,omitempty" gorm:"size:100"` // Staff member who decided it
+	Resolution string     `json:"resolution,omitempty" gorm:"size:500"`  // Outcome, required to resolve
+}
+
+// DisputeMessage is one post in a dispute's thread, by the customer or the assigned staff member
+// A post stays unread until the other side marks it read
+type DisputeMessage struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique message identifier
+	CreatedAt time.Time `json:"created_at"`                                // When it was posted
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	DisputeID           uint       `json:"dispute_id" gorm:"not null;index"`          // Dispute whose thread it is in
+	AuthorType          string     `json:"author_type" gorm:"size:20;not null"`       // customer or staff
+	Author              string     `json:"author" gorm:"size:100;not null"`           // Username of the poster
+	Body                string     `json:"body" gorm:"size:4000;not null"`            // Message text
+	RequestsInformation bool       `json:"requests_information" gorm:"default:false"` // Staff asked the customer for information
+	ReadAt              *time.Time `json:"read_at,omitempty"`                         // When the other side read it
+	ReadBy              string     `json:"read_by,omitempty" gorm:"size:100"`         // Who on the other side read it
+}
+
+// DisputeAttachment is evidence uploaded to a dispute, validated, scanned and kept in upload storage
+// Each is posted with a message in the thread, so it is notified and read like one
+type DisputeAttachment struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique attachment identifier
+	CreatedAt time.Time `json:"created_at"`                                // Upload time
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	DisputeID    uint   `json:"dispute_id" gorm:"not null;index"`      // Dispute the evidence supports
+	MessageID    uint   `json:"message_id" gorm:"not null;index"`      // Thread post it was uploaded with
+	Filename     string `json:"filename" gorm:"size:255"`              // Name the file was uploaded with
+	ContentType  string `json:"content_type" gorm:"size:100"`          // Type sniffed from the file contents
+	Size         int64  `json:"size"`                                  // Stored size in bytes, after metadata stripping
+	SHA256       string `json:"sha256" gorm:"size:64;index"`           // Hash of the stored bytes
+	StorageKey   string `json:"-" gorm:"size:255;not null"`            // Location in upload storage
+	UploaderType string `json:"uploader_type" gorm:"size:20;not null"` // customer or staff
+	UploadedBy   string `json:"uploaded_by" gorm:"size:100"`           // User who uploaded it
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Document is a customer file that passed validation and the malware scan
+// The bytes live in upload storage under StorageKey; rejected uploads never get a row
+type Document struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`                      // Unique document identifier
+	CreatedAt time.Time      `json:"created_at"`                                // Upload time
+	UpdatedAt time.Time      `json:"updated_at"`                                // Last update timestamp
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`                            // Soft delete support
+	TenantID  uint           `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	CustomerID  uint   `json:"customer_id" gorm:"not null;index"` // Customer the document belongs to
+	AccountID   *uint  `json:"account_id,omitempty" gorm:"index"` // Account it relates to, e.g. for cheque images
+	Kind        string `json:"kind" gorm:"size:30;not null"`      // identity, proof_of_address, cheque_image, legal_order
+	Filename    string `json:"filename" gorm:"size:255"`          // Name the file was uploaded with
+	ContentType string `json:"content_type" gorm:"size:100"`      // Type sniffed from the file contents
+	Size        int64  `json:"size"`                              // Stored size in bytes, after metadata stripping
+	SHA256      string `json:"sha256" gorm:"size:64;index"`       // Hash of the stored bytes
+	StorageKey  string `json:"-" gorm:"size:255;not null"`        // Location in upload storage
+	UploadedBy  string `json:"uploaded_by" gorm:"size:100"`       // User who uploaded it
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// DuplicatePayment is a payment that repeats an earlier one from the same account - the same amount to the same
+// counterparty within the detection window, through any channel - flagged after it posted. It is never blocked
+// flagged: waiting for the customer or staff, reversed: the later payment was reversed, dismissed: it was meant
+type DuplicatePayment struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique flag identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the later payment was flagged
+	UpdatedAt time.Time `json:"updated_at"`                                // Last status change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	Status                string  `json:"status" gorm:"size:20;not null;index"`          // flagged, reversed, dismissed
+	AccountID             uint    `json:"account_id" gorm:"not null;index"`              // Account both payments came from
+	CustomerID            uint    `json:"customer_id" gorm:"not null;index"`             // Account holder, emailed the reversal link
+	TransactionID         uint    `json:"transaction_id" gorm:"not null;uniqueIndex"`    // Later payment, the one flagged
+	OriginalTransactionID uint    `json:"original_transaction_id" gorm:"not null;index"` // Earlier payment it repeats
+	Amount                float64 `json:"amount" gorm:"type:decimal(15,2);not null"`     // Amount of both payments
+	MatchedOn             string  `json:"matched_on" gorm:"size:20;not null"`            // account, payee or reference
+	Counterparty          string  `json:"counterparty" gorm:"size:200;not null"`         // Shared identifier, normalized
+	Channel               string  `json:"channel" gorm:"size:20"`                        // Channel of the later payment
+	OriginalChannel       string  `json:"original_channel" gorm:"size:20"`               // Channel of the earlier payment
+
+	ReversalTokenHash string     `json:"-" gorm:"size:64;index"`        // SHA-256 of the emailed reversal token
+	ReversalExpiresAt *time.Time `json:"reversal_expires_at,omitempty"` // When the emailed link stops working
+
+	ReversalTransactionID *uint      `json:"reversal_transaction_id,omitempty"`       // Reversal of the later payment
+	DecidedAt             *time.Time `json:"decided_at,omitempty"`                    // When it was reversed or dismissed
+	DecidedBy             string     `json:"decided_by,omitempty" gorm:"size:100"`    // Staff member, or customer for the emailed link
+	DecisionNote          string     `json:"decision_note,omitempty" gorm:"size:500"` // Why it was reversed or dismissed
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EnrichmentRule maps raw transaction descriptions to structured merchant data
+// Rules are maintained by admins so parsing improves without code changes
+type EnrichmentRule struct {
+	ID        uint           `json:"id" gorm:"primaryKey"` // Unique rule identifier
+	CreatedAt time.Time      `json:"created_at"`           // Rule creation timestamp
+	UpdatedAt time.Time      `json:"updated_at"`           // Last update timestamp
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`       // Soft delete support
+
+	// Matching
+	MatchType string `json:"match_type" gorm:"size:20;not null"` // contains, prefix, regex
+	Pattern   string `json:"pattern" gorm:"size:200;not null"`   // Case-insensitive pattern applied to the description
+	Priority  int    `json:"priority" gorm:"default:100"`        // Lower values are evaluated first
+
+	// Enrichment Output
+	MerchantName string `json:"merchant_name" gorm:"size:200;not null"` // Merchant name to assign
+	CategoryCode string `json:"category_code" gorm:"size:4"`            // Optional MCC-style category code
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// PostingWindow is the persisted posting pause of the deployment
+// There is a single row; while Paused, as it is during end of day, customer postings queue or are refused
+type PostingWindow struct {
+	ID        uint      `json:"id" gorm:"primaryKey"` // Always 1
+	UpdatedAt time.Time `json:"updated_at"`           // Last change timestamp
+
+	Paused    bool       `json:"paused"`               // Postings are held
+	RunID     uint       `json:"run_id,omitempty"`     // End-of-day run that paused them
+	PausedAt  *time.Time `json:"paused_at,omitempty"`  // When the current pause began
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // The pause lifts on its own after this, should its run never finish
+	ResumedAt *time.Time `json:"resumed_at,omitempty"` // When the last pause ended
+}
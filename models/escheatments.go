@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Escheatment tracks an account through abandoned-property turnover
+// pending: final notice sent, cancelled: activity resumed before the cutoff,
+// escheated: funds moved to the escheatment account, reclaimed: returned to the customer
+type Escheatment struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique escheatment identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the final notice was sent
+	UpdatedAt time.Time `json:"updated_at"`                                // Last status change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	AccountID      uint      `json:"account_id" gorm:"not null;index"`                    // Dormant account
+	CustomerID     uint      `json:"customer_id" gorm:"not null;index"`                   // Owner at the time of notice
+	Status         string    `json:"status" gorm:"size:20;not null;index"`                // pending, cancelled, escheated, reclaimed
+	LastActivityAt time.Time `json:"last_activity_at" gorm:"not null"`                    // Last customer-initiated posting or opening
+	DueAt          time.Time `json:"due_at" gorm:"not null"`                              // Funds are escheated from this time on
+	Amount         float64   `json:"amount" gorm:"type:decimal(15,2);not null;default:0"` // Balance turned over
+
+	EscheatedAt         *time.Time `json:"escheated_at,omitempty"`          // When funds moved out
+	DebitTransactionID  *uint      `json:"debit_transaction_id,omitempty"`  // Debit on the customer account
+	CreditTransactionID *uint      `json:"credit_transaction_id,omitempty"` // Credit on the escheatment account
+
+	ReclaimedAt *time.Time `json:"reclaimed_at,omitempty"`                 // When funds were returned
+	ReclaimedBy string     `json:"reclaimed_by,omitempty" gorm:"size:100"` // Admin who approved the reclaim
+	ReclaimNote string     `json:"reclaim_note,omitempty" gorm:"size:500"` // Evidence or reason recorded
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OutboxEvent is a domain event written in the same database transaction as the change it describes
+// A background dispatcher publishes pending rows, giving at-least-once delivery
+type OutboxEvent struct {
+	ID        uint      `json:"id" gorm:"primaryKey"` // Monotonic event sequence
+	CreatedAt time.Time `json:"created_at"`           // When the business change committed
+	UpdatedAt time.Time `json:"updated_at"`           // Last dispatch attempt timestamp
+
+	// Event Identity
+	AggregateType string `json:"aggregate_type" gorm:"size:30;not null;index:idx_outbox_aggregate,priority:1"` // account, customer, loan
+	AggregateID   uint   `json:"aggregate_id" gorm:"not null;index:idx_outbox_aggregate,priority:2"`           // Entity the event belongs to; ordering is per aggregate
+	EventType     string `json:"event_type" gorm:"size:50;not null"`                                           // e.g. transaction.posted
+	Payload       string `json:"payload" gorm:"type:text"`                                                     // JSON event body
+	TraceParent   string `json:"trace_parent,omitempty" gorm:"size:55"`                                        // W3C traceparent of the request that recorded it
+
+	// Dispatch State
+	Status        string     `json:"status" gorm:"size:20;default:'pending';index"` // pending, dispatched, failed
+	Attempts      int        `json:"attempts" gorm:"default:0"`                     // Publish attempts so far
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index"`                  // Earliest time of the next attempt (backoff)
+	LastError     string     `json:"last_error,omitempty" gorm:"size:500"`          // Most recent publish error
+	DispatchedAt  *time.Time `json:"dispatched_at,omitempty"`                       // When publishing succeeded
+}
+
+// WebhookSubscription registers an external endpoint for domain events
+// Payloads are signed with the subscription secret so receivers can verify origin
+// Admins register bank-wide subscriptions; one with an AccountID belongs to that account's customer and only ever
+// receives the account's own events
+type WebhookSubscription struct {
+	ID        uint           `json:"id" gorm:"primaryKey"` // Unique subscription identifier
+	CreatedAt time.Time      `json:"created_at"`           // Subscription creation timestamp
+	UpdatedAt time.Time      `json:"updated_at"`           // Last update timestamp
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`       // Soft delete support
+
+	URL          string `json:"url" gorm:"size:500;not null"` // Delivery endpoint
+	Secret       string `json:"-" gorm:"size:100"`            // HMAC-SHA256 signing secret (never returned)
+	EventTypes   string `json:"event_types" gorm:"size:500"`  // Comma-separated event types; empty = all
+	Active       bool   `json:"active"`                       // Inactive subscriptions receive nothing
+	CreatedBy    string `json:"created_by" gorm:"size:100"`   // Admin who registered the endpoint
+	AlertEmail   string `json:"alert_email" gorm:"size:255"`  // The endpoint owner's address for suspension alerts
+	BacklogLimit int    `json:"backlog_limit"`                // Most deliveries queued; the oldest are dropped beyond it
+
+	// Account Scope
+	AccountID  *uint `json:"account_id,omitempty" gorm:"index"` // Account whose events the subscription receives; none for bank-wide
+	CustomerID uint  `json:"customer_id,omitempty"`             // Holder of that account
+
+	// Circuit Breaker
+	Suspended           bool       `json:"suspended"`                            // Deliveries queue without being attempted
+	SuspendedAt         *time.Time `json:"suspended_at,omitempty"`               // When the breaker last opened
+	NextProbeAt         *time.Time `json:"next_probe_at,omitempty"`              // When a suspended endpoint is next tried with one delivery
+	ConsecutiveFailures int        `json:"consecutive_failures"`                 // Failed attempts since the last success
+	LastError           string     `json:"last_error,omitempty" gorm:"size:500"` // Most recent delivery error
+	DroppedDeliveries   int        `json:"dropped_deliveries"`                   // Deliveries dropped from a full backlog, ever
+	Backlog             int64      `json:"backlog" gorm:"-"`                     // Deliveries waiting, filled in by listings
+}
+
+// WebhookDelivery is one event queued for one subscription
+// A subscription's deliveries are sent one at a time in id order, so an endpoint sees events in the order they
+// were published even across a suspension. Delivered and dropped rows are kept as the delivery history
+type WebhookDelivery struct {
+	ID        uint      `json:"id" gorm:"primaryKey"` // Delivery sequence; a subscription's deliveries go out in this order
+	CreatedAt time.Time `json:"created_at"`           // When the event was queued for the subscription
+	UpdatedAt time.Time `json:"updated_at"`           // Last attempt or state change
+
+	SubscriptionID uint   `json:"subscription_id" gorm:"not null;uniqueIndex:idx_webhook_delivery_event,priority:1;index:idx_webhook_delivery_queue,priority:1"`
+	EventID        uint   `json:"event_id" gorm:"not null;uniqueIndex:idx_webhook_delivery_event,priority:2"` // Outbox event delivered
+	EventType      string `json:"event_type" gorm:"size:50"`                                                  // e.g. transaction.posted
+	TraceParent    string `json:"-" gorm:"size:55"`                                                           // Publish span the delivery continues
+
+	Status        string     `json:"status" gorm:"size:20;default:'pending';index:idx_webhook_delivery_queue,priority:2;index"` // pending, delivered, dropped
+	Attempts      int        `json:"attempts" gorm:"default:0"`                                                                 // Attempts so far, probes included
+	NextAttemptAt time.Time  `json:"next_attempt_at"`                                                                           // Earliest next attempt (backoff)
+	LastError     string     `json:"last_error,omitempty" gorm:"size:500"`                                                      // Most recent attempt's error
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`                                                                    // When the endpoint accepted it
+	DroppedBefore int        `json:"dropped_before,omitempty"`                                                                  // Deliveries dropped just ahead of this one, sent as X-Webhook-Dropped
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// ExceptionItem is an incoming credit held in suspense because it could not be applied as sent
+// open: funds sit in suspense, applied: moved to a corrected account, returned: sent back to the originator
+type ExceptionItem struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique exception identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the credit was received
+	UpdatedAt time.Time `json:"updated_at"`                                // Last status change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	Status        string  `json:"status" gorm:"size:20;not null;index"`      // open, applied, returned
+	Reason        string  `json:"reason" gorm:"size:50;not null"`            // Why the credit could not be applied
+	Payload       string  `json:"payload" gorm:"type:text"`                  // Credit exactly as received
+	AccountNumber string  `json:"account_number" gorm:"size:50;index"`       // Account number the sender gave
+	Amount        float64 `json:"amount" gorm:"type:decimal(15,2);not null"` // Amount held in suspense
+	Currency      string  `json:"currency" gorm:"size:3;not null"`           // Currency of the credit
+	Reference     string  `json:"reference" gorm:"size:100"`                 // Sender's reference
+	Originator    string  `json:"originator" gorm:"size:200"`                // Sender name or institution
+
+	SuspenseTransactionID   uint  `json:"suspense_transaction_id"`             // Credit on the suspense account
+	ResolutionTransactionID *uint `json:"resolution_transaction_id,omitempty"` // Debit on the suspense account when resolved
+	AppliedAccountID        *uint `json:"applied_account_id,omitempty"`        // Corrected account the funds went to
+
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`                     // When the item left the queue
+	ResolvedBy     string     `json:"resolved_by,omitempty" gorm:"size:100"`     // Staff member, or system for auto-returns
+	ResolutionNote string     `json:"resolution_note,omitempty" gorm:"size:500"` // Why it was applied or returned
+}
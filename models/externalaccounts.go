@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// ExternalAccount is a customer's account at another bank, linked once they confirm two micro-deposits sent to it
+// The routing and account numbers and the deposit amounts are stored encrypted; none of them are ever returned
+type ExternalAccount struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique link identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the link was requested
+	UpdatedAt time.Time `json:"updated_at"`                                // Last status change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	CustomerID  uint   `json:"customer_id" gorm:"not null;index"`             // Customer linking the account
+	Nickname    string `json:"nickname" gorm:"size:100"`                      // Customer's name for the account
+	AccountType string `json:"account_type" gorm:"size:20;not null"`          // checking or savings
+	Mask        string `json:"account_number_masked" gorm:"size:20;not null"` // Last four digits, e.g. ••••4821
+
+	// Encrypted Details
+	RoutingNumber string `json:"-" gorm:"not null"`               // ABA routing number, sealed
+	AccountNumber string `json:"-" gorm:"not null"`               // Account number at the other bank, sealed
+	Fingerprint   string `json:"-" gorm:"size:64;not null;index"` // Keyed hash of both numbers, to spot a second link
+	Deposits      string `json:"-" gorm:"size:200"`               // The two micro-deposit amounts, sealed; cleared once used
+
+	// Verification
+	Status     string     `json:"status" gorm:"size:20;not null;index"` // pending, verified, locked
+	Attempts   int        `json:"attempts" gorm:"not null;default:0"`   // Wrong confirmations so far
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"index"`              // An unverified link is purged after this
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`                // When the deposits were confirmed
+	LockedAt   *time.Time `json:"locked_at,omitempty"`                  // When the last attempt was used up
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// FeeSchedule prices a fee charged on qualifying customer postings in one currency over a date range
+// An empty account type or channel matches any; the most specific schedule in effect on a posting's
+// effective date applies. Fees already charged keep the amount they were posted with
+type FeeSchedule struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique schedule identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the schedule was created
+	UpdatedAt time.Time `json:"updated_at"`                                // Last change timestamp
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	Name            string `json:"name" gorm:"size:100;not null"`            // Shown on the fee posting, e.g. "Online transfer fee"
+	AccountType     string `json:"account_type" gorm:"size:20"`              // Product charged; empty for every product
+	TransactionType string `json:"transaction_type" gorm:"size:20;not null"` // deposit, withdrawal, transfer, payment
+	Channel         string `json:"channel" gorm:"size:20"`                   // branch, atm, online, api, card; empty for every channel
+	Currency        string `json:"currency" gorm:"size:3;not null;index"`    // Currency of the postings charged and of the fee
+
+	// Pricing - flat plus a percentage of the posting amount, then held between the minimum and maximum
+	FlatFee float64 `json:"flat_fee" gorm:"type:decimal(15,2);default:0"` // Fixed part
+	Percent float64 `json:"percent" gorm:"type:decimal(7,4);default:0"`   // Percentage of the amount
+	MinFee  float64 `json:"min_fee" gorm:"type:decimal(15,2);default:0"`  // Lower bound; 0 for none
+	MaxFee  float64 `json:"max_fee" gorm:"type:decimal(15,2);default:0"`  // Upper bound; 0 for none
+
+	// Effective Dating - by posting effective date, UTC
+	EffectiveFrom time.Time  `json:"effective_from" gorm:"not null"` // First day charged
+	EffectiveTo   *time.Time `json:"effective_to,omitempty"`         // Last day charged (inclusive); open-ended when nil
+
+	CreatedBy string `json:"created_by" gorm:"size:100"` // Admin who created it
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// FeatureFlag is a runtime toggle for a risky code path
+// Changes take effect without a redeploy; RolloutPercent enables it for a stable slice of customers
+type FeatureFlag struct {
+	ID        uint      `json:"id" gorm:"primaryKey"` // Unique flag identifier
+	CreatedAt time.Time `json:"created_at"`           // Flag creation timestamp
+	UpdatedAt time.Time `json:"updated_at"`           // Last change timestamp
+
+	Key            string `json:"key" gorm:"size:100;uniqueIndex;not null"` // Stable flag name checked by code
+	Description    string `json:"description" gorm:"size:500"`              // What the flag gates
+	Enabled        bool   `json:"enabled"`                                  // Master switch; false disables for everyone
+	RolloutPercent int    `json:"rollout_percent"`                          // 0-100 share of customers, by customer id hash
+	UpdatedBy      string `json:"updated_by" gorm:"size:100"`               // Username of the last change
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// FXRate is a currency's closing rate for one UTC day, in units of the base currency per unit
+// Revaluation only uses the rate of the day it values; a missing day is never filled from another
+type FXRate struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                                                                         // Unique rate identifier
+	CreatedAt time.Time `json:"created_at"`                                                                                   // When the rate was entered
+	UpdatedAt time.Time `json:"updated_at"`                                                                                   // Last correction
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;uniqueIndex:idx_fx_rates_tenant_currency_date,priority:1"` // Owning bank brand
+
+	Currency  string    `json:"currency" gorm:"size:3;not null;uniqueIndex:idx_fx_rates_tenant_currency_date,priority:2"` // Foreign currency quoted
+	Date      time.Time `json:"date" gorm:"not null;uniqueIndex:idx_fx_rates_tenant_currency_date,priority:3"`            // UTC day the rate closes
+	Rate      float64   `json:"rate" gorm:"type:decimal(18,8);not null"`                                                  // Base currency per unit
+	Source    string    `json:"source" gorm:"size:100"`                                                                   // Where the rate came from, e.g. a rate feed
+	CreatedBy string    `json:"created_by" gorm:"size:100"`                                                               // Admin who entered it
+}
+
+// BalanceSnapshot is an account's end-of-day balance valued in the base currency
+// The fx-revaluation job writes one per foreign-currency customer account and revalued day
+type BalanceSnapshot struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique snapshot identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the snapshot was taken
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	AccountID         uint      `json:"account_id" gorm:"not null;uniqueIndex:idx_balance_snapshots_account_date,priority:1"` // Account valued
+	Date              time.Time `json:"date" gorm:"not null;uniqueIndex:idx_balance_snapshots_account_date,priority:2"`       // UTC day whose closing balance this is
+	Currency          string    `json:"currency" gorm:"size:3;not null;index"`                                                // Account currency
+	Balance           float64   `json:"balance" gorm:"type:decimal(15,2)"`                                                    // Closing balance, by effective date
+	Rate              float64   `json:"rate" gorm:"type:decimal(18,8)"`                                                       // Closing rate applied
+	BaseCurrencyValue float64   `json:"base_currency_value" gorm:"type:decimal(15,2)"`                                        // Balance in the base currency
+}
+
+// FXRevaluation is one currency's revaluation for one day: its customer balances valued at the closing rate and
+// the unrealized gain or loss since the previous revaluation, posted to the general ledger in the base currency
+type FXRevaluation struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                                                                                // Unique revaluation identifier
+	CreatedAt time.Time `json:"created_at"`                                                                                          // First attempt
+	UpdatedAt time.Time `json:"updated_at"`                                                                                          // Last attempt
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;uniqueIndex:idx_fx_revaluations_tenant_currency_date,priority:1"` // Owning bank brand
+
+	Currency string    `json:"currency" gorm:"size:3;not null;uniqueIndex:idx_fx_revaluations_tenant_currency_date,priority:2"` // Foreign currency revalued
+	Date     time.Time `json:"date" gorm:"not null;uniqueIndex:idx_fx_revaluations_tenant_currency_date,priority:3"`            // UTC day revalued
+	Status   string    `json:"status" gorm:"size:20;not null;index"`                                                            // posted, failed
+	Error    string    `json:"error,omitempty" gorm:"size:500"`                                                                 // Why the last attempt failed
+
+	// Valuation - customer balances in the currency at the end of the day
+	Rate              float64 `json:"rate" gorm:"type:decimal(18,8)"`                // Closing rate applied
+	Accounts          int     `json:"accounts"`                                      // Accounts snapshotted
+	Balance           float64 `json:"balance" gorm:"type:decimal(15,2)"`             // Net customer balance in the currency
+	BaseCurrencyValue float64 `json:"base_currency_value" gorm:"type:decimal(15,2)"` // The same in the base currency
+
+	// Impact - positive is a gain to the bank, posted to FX_GAIN_LOSS against FX_REVALUATION
+	Impact        float64 `json:"impact" gorm:"type:decimal(15,2)"` // Unrealized gain or loss in the base currency
+	TransactionID *uint   `json:"transaction_id,omitempty"`         // Gain or loss posting; nil when the impact rounds to zero
+}
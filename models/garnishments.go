@@ -0,0 +1,64 @@
+package models
+
+import "time"
+
+// GarnishmentOrder is a court order to collect a debt from a customer's deposits, leaving them a protected amount
+// It covers one account, or every account of the customer in its currency when AccountID is nil. active: deposits
+// are swept toward it, suspended: collection is paused, satisfied: collected in full or closed by staff
+type GarnishmentOrder struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique order identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the order was registered
+	UpdatedAt time.Time `json:"updated_at"`                                // Last change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	Scope      string `json:"scope" gorm:"size:10;not null"`                                                    // account, customer
+	CustomerID uint   `json:"customer_id" gorm:"not null;index:idx_garnishments_customer_status,priority:1"`    // Debtor
+	AccountID  *uint  `json:"account_id,omitempty" gorm:"index"`                                                // Account garnished; nil for every account of the customer
+	Currency   string `json:"currency" gorm:"size:3;not null"`                                                  // Accounts in other currencies are not swept
+	Status     string `json:"status" gorm:"size:20;not null;index:idx_garnishments_customer_status,priority:2"` // active, suspended, satisfied
+
+	Court           string  `json:"court" gorm:"size:200;not null"`                                // Court that issued the order
+	CaseReference   string  `json:"case_reference" gorm:"size:100;not null"`                       // Court case or order number
+	PayeeName       string  `json:"payee_name" gorm:"size:200;not null"`                           // Creditor or court office the funds are remitted for
+	PayeeReference  string  `json:"payee_reference,omitempty" gorm:"size:100"`                     // Reference quoted on remittances
+	TotalAmount     float64 `json:"total_amount" gorm:"type:decimal(15,2);not null"`               // Amount to collect
+	ProtectedAmount float64 `json:"protected_amount" gorm:"type:decimal(15,2);not null"`           // Balance the debtor always keeps
+	CollectedAmount float64 `json:"collected_amount" gorm:"type:decimal(15,2);not null;default:0"` // Swept so far
+	Priority        int     `json:"priority" gorm:"not null;default:1"`                            // Ranked with liens: 1 is most senior; ties go to the earlier claim
+
+	RemittanceAccountID uint   `json:"remittance_account_id" gorm:"not null"` // Account swept funds are transferred to; the GARNISHMENTS internal account by default
+	NotificationID      *uint  `json:"notification_id,omitempty"`             // Notice queued to the customer on registration; nil without an email on file
+	RegisteredBy        string `json:"registered_by" gorm:"size:100"`         // Staff member who registered it
+
+	SuspendedAt   *time.Time `json:"suspended_at,omitempty"`                   // When collection was last paused
+	SuspendedBy   string     `json:"suspended_by,omitempty" gorm:"size:100"`   // Staff member who paused it
+	SuspendReason string     `json:"suspend_reason,omitempty" gorm:"size:500"` // Why it was paused
+	SatisfiedAt   *time.Time `json:"satisfied_at,omitempty"`                   // When it was collected in full or closed
+	SatisfiedBy   string     `json:"satisfied_by,omitempty" gorm:"size:100"`   // Staff member who closed it, or system when collected in full
+	SatisfyNote   string     `json:"satisfy_note,omitempty" gorm:"size:500"`   // Why staff closed it before it was collected
+}
+
+// GarnishmentDocument attaches an uploaded customer document, such as the court order, to a garnishment order
+type GarnishmentDocument struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique attachment identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the document was attached
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	OrderID    uint `json:"order_id" gorm:"not null;uniqueIndex:idx_garnishment_documents_pair,priority:1"`    // Order the document supports
+	DocumentID uint `json:"document_id" gorm:"not null;uniqueIndex:idx_garnishment_documents_pair,priority:2"` // Attached document
+}
+
+// GarnishmentPayment is one sweep of funds from a garnished account to the order's remittance account
+type GarnishmentPayment struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique payment identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the funds were swept
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	OrderID              uint    `json:"order_id" gorm:"not null;index"`            // Order collected toward
+	AccountID            uint    `json:"account_id" gorm:"not null"`                // Account swept
+	Amount               float64 `json:"amount" gorm:"type:decimal(15,2);not null"` // Amount transferred
+	RemittanceAccountID  uint    `json:"remittance_account_id" gorm:"not null"`     // Account credited
+	DebitTransactionID   uint    `json:"debit_transaction_id" gorm:"not null"`      // Transfer out of the garnished account
+	CreditTransactionID  uint    `json:"credit_transaction_id" gorm:"not null"`     // Credit on the remittance account
+	TriggerTransactionID *uint   `json:"trigger_transaction_id,omitempty"`          // Deposit that was swept; nil for sweeps on registration or resumption
+}
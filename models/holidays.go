@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Holiday is a day the bank is closed, in addition to weekends
+// The calendar is platform-wide: every tenant settles through the same payment systems
+type Holiday struct {
+	ID        uint      `json:"id" gorm:"primaryKey"` // Unique holiday identifier
+	CreatedAt time.Time `json:"created_at"`           // When the holiday was added
+	UpdatedAt time.Time `json:"updated_at"`           // Last change timestamp
+
+	Date      string `json:"date" gorm:"size:10;uniqueIndex;not null"` // YYYY-MM-DD in bank time
+	Name      string `json:"name" gorm:"size:100;not null"`            // e.g. "Independence Day"
+	Source    string `json:"source" gorm:"size:20;default:'admin'"`    // federal (seeded) or admin
+	CreatedBy string `json:"created_by,omitempty" gorm:"size:100"`     // Username of the admin who added it
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ImpersonationSession is an admin viewing the API as a customer
+// Tokens issued for the session stop working once it expires or is ended
+type ImpersonationSession struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`                      // Unique session identifier
+	CreatedAt time.Time      `json:"created_at"`                                // Session start
+	UpdatedAt time.Time      `json:"updated_at"`                                // Last update timestamp
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`                            // Soft delete support
+	TenantID  uint           `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	AdminUserID   uint       `json:"admin_user_id" gorm:"not null;index"`     // Admin doing the impersonation
+	AdminUsername string     `json:"admin_username" gorm:"size:100;not null"` // Admin's login name
+	CustomerID    uint       `json:"customer_id" gorm:"not null;index"`       // Customer being impersonated
+	Reason        string     `json:"reason" gorm:"size:500;not null"`         // Why the admin needs the customer's view
+	ExpiresAt     time.Time  `json:"expires_at"`                              // Hard end of the session
+	EndedAt       *time.Time `json:"ended_at,omitempty"`                      // Set when ended early
+	EndedBy       string     `json:"ended_by,omitempty" gorm:"size:100"`      // User who ended it
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// InstallmentPlan converts one posted payment into monthly installments
+// The amount is credited back to the account and carried by a zero-interest loan, so installments
+// are collected and paid off through the loan payment machinery
+type InstallmentPlan struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`                      // Unique plan identifier
+	CreatedAt time.Time      `json:"created_at"`                                // When the payment was converted
+	UpdatedAt time.Time      `json:"updated_at"`                                // Last update timestamp
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`                            // Soft delete support
+	TenantID  uint           `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	PlanNumber    string `json:"plan_number" gorm:"size:50;uniqueIndex;not null"` // Also the backing loan's number
+	CustomerID    uint   `json:"customer_id" gorm:"not null;index"`               // Account holder
+	AccountID     uint   `json:"account_id" gorm:"not null;index"`                // Account credited and collected from
+	TransactionID uint   `json:"transaction_id" gorm:"not null;uniqueIndex"`      // Converted payment - one plan per transaction
+	LoanID        uint   `json:"loan_id" gorm:"not null;index"`                   // Zero-interest loan carrying the balance
+
+	// Terms
+	Amount         float64 `json:"amount" gorm:"type:decimal(15,2);not null"`          // Converted amount, credited back
+	Fee            float64 `json:"fee" gorm:"type:decimal(15,2);default:0"`            // Plan fee, charged at conversion
+	Months         int     `json:"months" gorm:"not null"`                             // Number of installments
+	MonthlyPayment float64 `json:"monthly_payment" gorm:"type:decimal(15,2);not null"` // Regular installment; the last one takes the remainder
+
+	// Schedule
+	Status            string     `json:"status" gorm:"size:20;default:'active';index"` // active, paid_off
+	PaidInstallments  int        `json:"paid_installments" gorm:"default:0"`           // Installments collected so far
+	NextDueDate       *time.Time `json:"next_due_date,omitempty" gorm:"index"`         // Next collection; unset once paid off
+	MissedCollections int        `json:"missed_collections" gorm:"default:0"`          // Collections that failed, e.g. for lack of funds
+	LastError         string     `json:"last_error,omitempty" gorm:"size:200"`         // Why the last collection failed
+	PaidOffAt         *time.Time `json:"paid_off_at,omitempty"`                        // When the plan was settled
+
+	// Postings
+	CreditTransactionID uint  `json:"credit_transaction_id"`        // Credit of the converted amount back to the account
+	FeeTransactionID    *uint `json:"fee_transaction_id,omitempty"` // Plan fee posting
+}
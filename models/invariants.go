@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// InvariantViolation is a broken ledger invariant found by the invariant scan, listed beside the exception queue
+// Unlike an exception it holds no funds: it points at an account whose books need investigating
+// open: still found by the latest scan, or not yet looked at; resolved: cleared by staff, or by the scan once it stops finding it
+type InvariantViolation struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique violation identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the row was written
+	UpdatedAt time.Time `json:"updated_at"`                                // Last status change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	Status        string  `json:"status" gorm:"size:20;not null;index"` // open, resolved
+	Kind          string  `json:"kind" gorm:"size:30;not null"`         // negative_balance, balance_chain, statement_continuity
+	AccountID     uint    `json:"account_id" gorm:"not null;index"`     // Account the invariant failed on
+	AccountNumber string  `json:"account_number" gorm:"size:50"`        // Its number, for the reviewer
+	TransactionID *uint   `json:"transaction_id,omitempty"`             // Posting whose balance_before breaks the chain
+	StatementID   *uint   `json:"statement_id,omitempty"`               // Statement whose opening balance is not the previous closing balance
+	Expected      float64 `json:"expected" gorm:"type:decimal(15,2)"`   // Floor, or the previous posting's balance_after or statement's closing balance
+	Actual        float64 `json:"actual" gorm:"type:decimal(15,2)"`     // Balance, or the posting's balance_before or statement's opening balance
+	Detail        string  `json:"detail" gorm:"size:500"`               // What was found, in words
+
+	DetectedAt     time.Time  `json:"detected_at"`                               // First scan that found it
+	LastSeenAt     time.Time  `json:"last_seen_at"`                              // Latest scan that found it
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`                     // When it left the queue
+	ResolvedBy     string     `json:"resolved_by,omitempty" gorm:"size:100"`     // Staff member, or system when the scan no longer finds it
+	ResolutionNote string     `json:"resolution_note,omitempty" gorm:"size:500"` // What was done about it
+}
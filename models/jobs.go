@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// JobRun records one execution of a scheduled background job
+// Runs belong to the deployment rather than a tenant; jobs work across every tenant
+type JobRun struct {
+	ID        uint      `json:"id" gorm:"primaryKey"` // Unique run identifier
+	CreatedAt time.Time `json:"created_at"`           // When the run was recorded
+
+	JobName     string     `json:"job_name" gorm:"size:50;not null;index"` // Registered job name
+	Trigger     string     `json:"trigger" gorm:"size:20;not null"`        // schedule, manual, step
+	TriggeredBy string     `json:"triggered_by,omitempty" gorm:"size:100"` // Admin who started a manual run
+	Instance    string     `json:"instance" gorm:"size:100"`               // Process that ran it
+	Status      string     `json:"status" gorm:"size:20;not null;index"`   // running, succeeded, failed, skipped
+	StartedAt   time.Time  `json:"started_at"`                             // When the job started
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`                  // When it returned, failed or was abandoned
+	DurationMS  int64      `json:"duration_ms"`                            // Time taken
+	Items       int        `json:"items"`                                  // Records the job processed
+	Error       string     `json:"error,omitempty" gorm:"type:text"`       // Failure or recovered panic
+
+	ParentRunID   *uint `json:"parent_run_id,omitempty" gorm:"index"` // Run this one is a step of, e.g. end of day
+	ResumedFromID *uint `json:"resumed_from_id,omitempty"`            // Failed run this one picks up from
+}
+
+// JobLease is the database lock that keeps a job from running twice at once, across instances
+// A holder renews it while the job runs; an expired lease is free to take, e.g. after a crash
+type JobLease struct {
+	Name      string     `json:"name" gorm:"primaryKey;size:50"` // Job name
+	Holder    string     `json:"holder" gorm:"size:100"`         // Instance holding it
+	RunID     uint       `json:"run_id"`                         // Run it was taken for
+	ExpiresAt *time.Time `json:"expires_at"`                     // Free once passed
+}
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// Lien is a third-party claim on an account's funds, such as a court order or another bank's pledge
+// active: holds its amount (or every fund) against customer debits, satisfied: paid to the claimant,
+// released: lifted without payment. Lower priority numbers are paid first
+type Lien struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique lien identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the lien was placed
+	UpdatedAt time.Time `json:"updated_at"`                                // Last change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	AccountID      uint    `json:"account_id" gorm:"not null;index"`                    // Account the claim is on
+	Status         string  `json:"status" gorm:"size:20;not null;index"`                // active, satisfied, released
+	Claimant       string  `json:"claimant" gorm:"size:200;not null"`                   // Court, creditor or bank making the claim
+	LegalReference string  `json:"legal_reference" gorm:"size:100;not null"`            // Order, case or pledge reference
+	AllFunds       bool    `json:"all_funds" gorm:"default:false"`                      // Holds the whole balance rather than an amount
+	Amount         float64 `json:"amount" gorm:"type:decimal(15,2);not null;default:0"` // Amount still claimed; 0 with all_funds
+	Priority       int     `json:"priority" gorm:"not null;default:1"`                  // 1 is most senior; ties go to the earlier lien
+	PlacedBy       string  `json:"placed_by" gorm:"size:100"`                           // Staff member who recorded it
+
+	PaidAmount    float64    `json:"paid_amount" gorm:"type:decimal(15,2);not null;default:0"` // Paid to the claimant so far
+	SatisfiedAt   *time.Time `json:"satisfied_at,omitempty"`                                   // When it was paid in full
+	SatisfiedBy   string     `json:"satisfied_by,omitempty" gorm:"size:100"`                   // Staff member who paid it
+	ReleasedAt    *time.Time `json:"released_at,omitempty"`                                    // When it was lifted
+	ReleasedBy    string     `json:"released_by,omitempty" gorm:"size:100"`                    // Staff member who lifted it
+	ReleaseReason string     `json:"release_reason,omitempty" gorm:"size:500"`                 // Why it was lifted
+}
+
+// LienDocument attaches an uploaded customer document, such as the court order, to a lien
+type LienDocument struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique attachment identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the document was attached
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	LienID     uint `json:"lien_id" gorm:"not null;uniqueIndex:idx_lien_documents_pair,priority:1"`     // Lien the document supports
+	DocumentID uint `json:"document_id" gorm:"not null;uniqueIndex:idx_lien_documents_pair,priority:2"` // Attached document
+}
+
+// LienPayment is one transfer of funds from the account to a lien's claimant
+type LienPayment struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique payment identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the payment was made
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	LienID               uint    `json:"lien_id" gorm:"not null;index"`             // Lien paid towards
+	Amount               float64 `json:"amount" gorm:"type:decimal(15,2);not null"` // Amount transferred
+	DestinationAccountID uint    `json:"destination_account_id" gorm:"not null"`    // Account credited for the claimant
+	DebitTransactionID   uint    `json:"debit_transaction_id" gorm:"not null"`      // Debit on the liened account
+	CreditTransactionID  uint    `json:"credit_transaction_id" gorm:"not null"`     // Credit on the destination
+	PaidBy               string  `json:"paid_by" gorm:"size:100"`                   // Staff member who made it
+}
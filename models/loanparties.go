@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// LoanParty is a customer liable for a loan: its borrower, a co-borrower or a guarantor
+// Every loan has exactly one borrower party, its Loan.CustomerID; other parties are added before disbursement
+type LoanParty struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique party identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the party was added
+	UpdatedAt time.Time `json:"updated_at"`                                // Last update timestamp
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	LoanID           uint    `json:"loan_id" gorm:"not null;uniqueIndex:idx_loan_parties_loan_customer,priority:1"`           // Loan the party is liable for
+	CustomerID       uint    `json:"customer_id" gorm:"not null;uniqueIndex:idx_loan_parties_loan_customer,priority:2;index"` // Liable customer
+	Role             string  `json:"role" gorm:"size:20;not null"`                                                            // borrower, co_borrower, guarantor
+	LiabilityPercent float64 `json:"liability_percent" gorm:"type:decimal(5,2);not null"`                                     // Share of the loan the party answers for (0-100]
+	AddedBy          string  `json:"added_by,omitempty" gorm:"size:100"`                                                      // User who added the party
+
+	// Payment split - the share of each installment the party funds, when the loan has one
+	SharePercent     float64 `json:"share_percent" gorm:"type:decimal(5,2);not null;default:0"` // Percent of each installment collected from the party (0-100)
+	FundingAccountID *uint   `json:"funding_account_id,omitempty"`                              // The party's account its share is collected from
+
+	// Guarantor consent - a guarantor is bound only once they confirm
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`                 // When the guarantee was confirmed
+	ConfirmedBy string     `json:"confirmed_by,omitempty" gorm:"size:100"` // User who recorded the confirmation
+}
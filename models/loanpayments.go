@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// LoanPayment is the allocation record of one payment made against a loan
+// The split between interest and principal is fixed when the payment is taken and is the
+// source of truth for interest-paid reporting
+type LoanPayment struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique payment identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the payment was recorded
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	LoanID        uint       `json:"loan_id" gorm:"not null;index:idx_loan_payments_loan_paid,priority:1"` // Loan being repaid
+	AccountID     uint       `json:"account_id" gorm:"not null;index"`                                     // Funding account that was debited
+	TransactionID uint       `json:"transaction_id" gorm:"not null;uniqueIndex"`                           // Debit posting on the funding account
+	PaidAt        time.Time  `json:"paid_at" gorm:"not null;index:idx_loan_payments_loan_paid,priority:2"` // Effective date of the payment
+	ScheduledDate *time.Time `json:"scheduled_date,omitempty"`                                             // Installment due date, when collected on a schedule; PaidAt is when it was actually taken
+	PartyID       *uint      `json:"party_id,omitempty"`                                                   // Loan party who funded it, when known
+	CoversPartyID *uint      `json:"covers_party_id,omitempty"`                                            // Party whose split share the borrower paid instead
+
+	Amount           float64 `json:"amount" gorm:"type:decimal(15,2);not null"`            // Total paid
+	InterestPortion  float64 `json:"interest_portion" gorm:"type:decimal(15,2);not null"`  // Part applied to accrued interest
+	PrincipalPortion float64 `json:"principal_portion" gorm:"type:decimal(15,2);not null"` // Part applied to the remaining balance
+	BalanceAfter     float64 `json:"balance_after" gorm:"type:decimal(15,2)"`              // Loan balance after the payment
+}
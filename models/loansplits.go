@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// LoanSplit is a loan's payment split: each installment is collected on its due date in shares, every funding party's
+// from its own account. The shares and funding accounts are on the loan's parties
+type LoanSplit struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique split identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the split was first configured
+	UpdatedAt time.Time `json:"updated_at"`                                // Last change or collection
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	LoanID             uint       `json:"loan_id" gorm:"not null;uniqueIndex"`    // Loan collected under the split
+	FallbackToBorrower bool       `json:"fallback_to_borrower"`                   // Collect a share another party could not fund from the borrower's account
+	NextDueDate        time.Time  `json:"next_due_date" gorm:"not null;index"`    // Next installment, on the loan's monthly disbursement day
+	ConfiguredBy       string     `json:"configured_by" gorm:"size:100;not null"` // User who last set the split
+	LastCollectedAt    *time.Time `json:"last_collected_at,omitempty"`            // When the job last collected an installment
+}
+
+// LoanCollection is one installment collected under a payment split
+// collected: paid in full, whoever funded it; short: still short after any fallback to the borrower
+type LoanCollection struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique collection identifier
+	CreatedAt time.Time `json:"created_at"`                                // When it was collected
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	LoanID        uint      `json:"loan_id" gorm:"not null;index"`                // Loan the installment belongs to
+	ScheduledDate time.Time `json:"scheduled_date" gorm:"not null"`               // Installment due date; it may be collected on a later business day
+	Amount        float64   `json:"amount" gorm:"type:decimal(15,2);not null"`    // Installment due
+	Collected     float64   `json:"collected" gorm:"type:decimal(15,2);not null"` // Paid by all parties, fallback included
+	Shortfall     float64   `json:"shortfall" gorm:"type:decimal(15,2);not null"` // Left unpaid
+	Status        string    `json:"status" gorm:"size:20;not null;index"`         // collected, short
+
+	Shares []LoanCollectionShare `json:"shares" gorm:"foreignKey:CollectionID"` // Each party's attempt, then the borrower's fallbacks
+}
+
+// LoanCollectionShare is one attempt within a collection: a party's share, or the borrower covering a share another
+// party could not fund
+type LoanCollectionShare struct {
+	ID           uint `json:"id" gorm:"primaryKey"`                // Unique share identifier
+	CollectionID uint `json:"collection_id" gorm:"not null;index"` // Collection the attempt belongs to
+
+	PartyID       uint    `json:"party_id" gorm:"not null"`                     // Party debited
+	CustomerID    uint    `json:"customer_id" gorm:"not null"`                  // That party's customer
+	AccountID     uint    `json:"account_id" gorm:"not null"`                   // Funding account debited
+	CoversPartyID *uint   `json:"covers_party_id,omitempty"`                    // Set on a fallback: the party whose share the borrower covered
+	Amount        float64 `json:"amount" gorm:"type:decimal(15,2);not null"`    // Amount attempted
+	Collected     float64 `json:"collected" gorm:"type:decimal(15,2);not null"` // Amount taken: all of it or nothing
+	PaymentID     *uint   `json:"payment_id,omitempty"`                         // Loan payment recorded when it was taken
+	Error         string  `json:"error,omitempty" gorm:"size:200"`              // Why it could not be taken
+}
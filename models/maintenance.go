@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// MaintenanceState is the persisted maintenance mode of the deployment
+// There is a single row; while Enabled the API is read-only and scheduled jobs are paused
+type MaintenanceState struct {
+	ID        uint      `json:"id" gorm:"primaryKey"` // Always 1
+	UpdatedAt time.Time `json:"updated_at"`           // Last change timestamp
+
+	Enabled           bool       `json:"enabled"`                    // API is read-only
+	Message           string     `json:"message" gorm:"size:500"`    // Shown to clients whose writes are refused
+	RetryAfterSeconds int        `json:"retry_after_seconds"`        // Sent as Retry-After on refused writes
+	StartedAt         *time.Time `json:"started_at,omitempty"`       // When the current window began
+	StartedBy         string     `json:"started_by" gorm:"size:100"` // Admin (or "config") that entered maintenance
+	EndedAt           *time.Time `json:"ended_at,omitempty"`         // When the last window ended
+	EndedBy           string     `json:"ended_by" gorm:"size:100"`   // Admin that exited maintenance
+}
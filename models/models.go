@@ -9,23 +9,30 @@ import (
 // Core banking requires customer identification and contact details
 type Customer struct {
 	ID        uint           `json:"id" gorm:"primaryKey"`                    // Unique customer identifier
-	CreatedAt time.Time      `json:"created_at"`                             // Record creation timestamp
+	CreatedAt time.Time      `json:"created_at" gorm:"index"`                // Record creation timestamp
 	UpdatedAt time.Time      `json:"updated_at"`                             // Last update timestamp
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`                         // Soft delete support
+	TenantID  uint           `json:"tenant_id" gorm:"not null;default:1;index;uniqueIndex:idx_customers_tenant_email,priority:1"` // Owning bank brand
 	
 	// Personal Information - Essential for KYC (Know Your Customer) compliance
 	FirstName  string `json:"first_name" gorm:"size:100;not null"`           // Customer's first name
 	LastName   string `json:"last_name" gorm:"size:100;not null"`            // Customer's last name
-	Email      string `json:"email" gorm:"size:255;uniqueIndex"`             // Unique email for identification
+	Email      string `json:"email" gorm:"size:255;uniqueIndex:idx_customers_tenant_email,priority:2"` // Unique email per tenant for identification
+	EmailVerified   bool       `json:"email_verified" gorm:"default:false"`        // Set once the customer confirms the address; email-dependent features need it
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`                // When the current address was confirmed
+	PendingEmail    string     `json:"pending_email,omitempty" gorm:"size:255"`     // Requested new address awaiting confirmation; Email stays active until then
 	Phone      string `json:"phone" gorm:"size:20"`                          // Contact phone number
 	Address    string `json:"address" gorm:"size:500"`                       // Customer address
 	DateOfBirth string `json:"date_of_birth" gorm:"type:date"`               // DOB for age verification
+	PreferredLanguage string `json:"preferred_language" gorm:"size:10"`    // Language for messages and documents, e.g. es; empty follows the client's Accept-Language
 	
 	// Customer Status - Important for account management
 	Status string `json:"status" gorm:"size:20;default:'active'"`            // Customer status (active/inactive)
+	KYCLevel int `json:"kyc_level" gorm:"default:0"`                          // Identity verification level reached (0 = unverified)
+	MonthlyIncome float64 `json:"monthly_income" gorm:"type:decimal(15,2);default:0"` // Gross monthly income for loan affordability (0 = not recorded)
 	
 	// Relationships - Core banking requires linking customers to accounts and loans
-	Accounts []Account `json:"accounts,omitempty"`                           // Customer's bank accounts
+	Accounts []Account `json:"accounts,omitempty" gorm:"constraint:-"`      // Customer's bank accounts; internal ledger accounts have no customer, so no foreign key
 	Loans    []Loan    `json:"loans,omitempty"`                             // Customer's loans
 }
 
@@ -33,21 +40,37 @@ type Customer struct {
 // Core banking systems must track account balances and types
 type Account struct {
 	ID        uint           `json:"id" gorm:"primaryKey"`                   // Unique account identifier
-	CreatedAt time.Time      `json:"created_at"`                            // Account creation date
+	CreatedAt time.Time      `json:"created_at" gorm:"index"`               // Account creation date
 	UpdatedAt time.Time      `json:"updated_at"`                            // Last update timestamp
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`                        // Soft delete support
 	
+	TenantID  uint           `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+	
 	// Account Identification
 	AccountNumber string `json:"account_number" gorm:"size:50;uniqueIndex;not null"` // Unique account number
-	CustomerID    uint   `json:"customer_id" gorm:"not null;index"`                 // Link to customer
+	CustomerID    uint   `json:"customer_id" gorm:"not null;index;index:idx_accounts_customer_status,priority:1"` // Link to customer
 	
 	// Account Properties
 	AccountType  string  `json:"account_type" gorm:"size:20;not null"`       // checking, savings, loan
-	Balance      float64 `json:"balance" gorm:"type:decimal(15,2);default:0"` // Current balance
+	Balance      float64 `json:"balance" gorm:"<-:create;type:decimal(15,2);default:0"` // Current balance - changed only by the ledger, through invariants.SetBalance
 	Currency     string  `json:"currency" gorm:"size:3;default:'USD'"`       // ISO currency code
+	OverdraftLimit float64 `json:"overdraft_limit" gorm:"type:decimal(15,2);default:0"` // Debit allowed below zero (requires the overdraft flag)
+	
+	// Savings interest - accrued daily at the product's rate and posted at month end
+	AccruedInterest        float64    `json:"accrued_interest" gorm:"type:decimal(15,2);default:0"` // Accrued since the last posting
+	InterestAccruedThrough *time.Time `json:"interest_accrued_through,omitempty"`                   // Days before this have accrued
 	
 	// Account Status - Critical for transaction processing
-	Status string `json:"status" gorm:"size:20;default:'active'"`           // Account status
+	Status string `json:"status" gorm:"size:20;default:'active';index:idx_accounts_customer_status,priority:2"` // Account status
+	
+	// Version is bumped on every posting - used for cache validation (ETags)
+	Version uint64 `json:"version" gorm:"default:0"`
+	
+	// Statement delivery - e-statement by email or none, and the day of the month it is sent
+	StatementPreference StatementPreference `json:"statement_preference" gorm:"embedded;embeddedPrefix:statement_"`
+	
+	// Debit restrictions - deposit-only, no transfers out, or staff approval for withdrawals
+	Restrictions AccountRestrictions `json:"restrictions" gorm:"embedded;embeddedPrefix:restriction_"`
 	
 	// Relationships
 	Customer     Customer     `json:"customer,omitempty"`                    // Account owner
@@ -58,26 +81,50 @@ type Account struct {
 // Core banking requires audit trail of all financial movements
 type Transaction struct {
 	ID        uint           `json:"id" gorm:"primaryKey"`                   // Unique transaction ID
-	CreatedAt time.Time      `json:"created_at"`                            // Transaction timestamp
+	CreatedAt time.Time      `json:"created_at" gorm:"index:idx_transactions_account_created,priority:2,sort:desc;index:idx_transactions_type_created,priority:2;index:idx_transactions_created"` // Transaction timestamp
 	UpdatedAt time.Time      `json:"updated_at"`                            // Last update timestamp
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`                        // Soft delete support
 	
+	TenantID  uint           `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+	
 	// Transaction Identification
 	TransactionID string `json:"transaction_id" gorm:"size:100;uniqueIndex;not null"` // System-generated transaction ID
-	AccountID     uint   `json:"account_id" gorm:"not null;index"`                   // Source account
+	AccountID     uint   `json:"account_id" gorm:"not null;index;index:idx_transactions_account_created,priority:1;index:idx_transactions_account_effective,priority:1"` // Source account
 	
 	// Transaction Details
-	TransactionType string  `json:"transaction_type" gorm:"size:20;not null"` // deposit, withdrawal, transfer, payment
+	TransactionType string  `json:"transaction_type" gorm:"size:20;not null;index:idx_transactions_type_created,priority:1"` // deposit, withdrawal, transfer, payment, interest, fee
 	Amount          float64 `json:"amount" gorm:"type:decimal(15,2);not null"`  // Transaction amount
 	
+	// Effective Dating - when the entry counts for statements and accounting, distinct from when it was recorded
+	EffectiveDate time.Time `json:"effective_date" gorm:"index:idx_transactions_account_effective,priority:2"` // Defaults to the posting time
+	ValueDate     *time.Time `json:"value_date" gorm:"index"`                                              // Bank day the posting counts from for interest; the next business day when submitted after its type's cutoff (nil before value dating)
+	ReversalOfID  *uint     `json:"reversal_of_id,omitempty" gorm:"index"`                                   // Original transaction this entry reverses
+	OffsetOfID    *uint     `json:"offset_of_id,omitempty" gorm:"index"`                                     // Customer posting this general-ledger entry balances
+	FeeOfID       *uint     `json:"fee_of_id,omitempty" gorm:"index"`                                        // Posting this fee was charged on
+	FeeScheduleID *uint     `json:"fee_schedule_id,omitempty"`                                               // Fee schedule that priced this fee
+	
 	// Transaction Context
 	Description string `json:"description" gorm:"size:500"`                   // Transaction description
 	Reference   string `json:"reference" gorm:"size:100"`                     // External reference number
+	Memo        string `json:"memo" gorm:"size:500"`                          // Customer-provided note, kept apart from the descriptor
+
+	// Statement Descriptor - canonical text generated from the tenant's templates; regenerated by the backfill job
+	Descriptor            string `json:"descriptor" gorm:"size:255"`                           // e.g. "TRANSFER TO ••••4821 / JANE DOE"
+	CounterpartyAccountID *uint  `json:"counterparty_account_id,omitempty" gorm:"index"`      // Other account of a transfer
+	
+	// Transaction Enrichment - Structured data for filtering and analytics
+	MerchantName string `json:"merchant_name" gorm:"size:200;index"`          // Normalized merchant/counterparty name
+	Channel      string `json:"channel" gorm:"size:20;index"`                 // branch, atm, online, api, card
+	CategoryCode string `json:"category_code" gorm:"size:4;index"`            // MCC-style category code
+	Location     string `json:"location,omitempty" gorm:"size:200"`           // Optional location (city, country)
 	
 	// Balance Tracking - Critical for audit trails
 	BalanceBefore float64 `json:"balance_before" gorm:"type:decimal(15,2)"`   // Balance before transaction
 	BalanceAfter  float64 `json:"balance_after" gorm:"type:decimal(15,2)"`    // Balance after transaction
 	
+	// Integrity - SHA-256 chained to the previous transaction on the account, set at creation
+	Hash string `json:"hash" gorm:"size:64"`
+	
 	// Relationships
 	Account Account `json:"account,omitempty"`                               // Account that owns this transaction
 }
@@ -90,9 +137,12 @@ type Loan struct {
 	UpdatedAt time.Time      `json:"updated_at"`                            // Last update timestamp
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`                        // Soft delete support
 	
+	TenantID  uint           `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+	
 	// Loan Identification
 	LoanNumber  string `json:"loan_number" gorm:"size:50;uniqueIndex;not null"` // Unique loan number
-	CustomerID  uint   `json:"customer_id" gorm:"not null;index"`                // Link to customer
+	CustomerID  uint   `json:"customer_id" gorm:"not null;index;index:idx_loans_customer_status,priority:1"` // Link to customer
+	AccountID   uint   `json:"account_id" gorm:"index"`                        // Loan account carrying the receivable (0 for loans opened before it was linked)
 	
 	// Loan Terms
 	PrincipalAmount float64 `json:"principal_amount" gorm:"type:decimal(15,2);not null"` // Original loan amount
@@ -100,16 +150,25 @@ type Loan struct {
 	LoanTerm        int     `json:"loan_term" gorm:"not null"`                           // Loan term in months
 	
 	// Loan Status
-	Status string `json:"status" gorm:"size:20;default:'active'"`           // active, paid_off, defaulted
+	Status string `json:"status" gorm:"size:20;default:'active';index:idx_loans_customer_status,priority:2"` // pending (not yet disbursed), declined, active, paid_off, defaulted
 	
 	// Loan Balance Tracking
 	RemainingBalance float64 `json:"remaining_balance" gorm:"type:decimal(15,2)"` // Current outstanding balance
 	MonthlyPayment   float64 `json:"monthly_payment" gorm:"type:decimal(10,2)"`   // Calculated monthly payment
 	
+	// Credit Check - recorded by the review step when a credit bureau is configured
+	CreditScore          *int       `json:"credit_score,omitempty"`                        // Score from the soft pull
+	CreditReportID       string     `json:"credit_report_id,omitempty" gorm:"size:100"`    // Bureau's report reference
+	CreditDecision       string     `json:"credit_decision,omitempty" gorm:"size:20"`      // approved, declined, manual_review
+	CreditDecisionReason string     `json:"credit_decision_reason,omitempty" gorm:"size:255"` // Cutoff applied, bureau outage or the reviewer's reason
+	CreditCheckedAt      *time.Time `json:"credit_checked_at,omitempty"`                   // When the bureau was asked
+	CreditReviewedBy     string     `json:"credit_reviewed_by,omitempty" gorm:"size:100"`  // Staff member who decided a manual review
+	
 	// Dates
 	DisbursementDate string `json:"disbursement_date" gorm:"type:date"`     // When loan was disbursed
 	DueDate          string `json:"due_date" gorm:"type:date"`              // Final payment due date
 	
 	// Relationships
 	Customer Customer `json:"customer,omitempty"`                           // Loan borrower
+	Parties  []LoanParty `json:"parties,omitempty"`                         // Borrower, co-borrowers and guarantors
 }
\ No newline at end of file
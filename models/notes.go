@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Note is a staff annotation on a transaction, account, customer or loan
+// Notes are never edited: a correction is a new note naming the one it corrects. Deleting one is soft,
+// keeping who deleted it
+type Note struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`                      // Unique note identifier
+	CreatedAt time.Time      `json:"created_at"`                                // When the note was written
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`                            // Soft delete support
+	TenantID  uint           `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	SubjectType    string `json:"subject_type" gorm:"size:20;not null;index:idx_notes_subject,priority:1"` // transaction, account, customer, loan
+	SubjectID      uint   `json:"subject_id" gorm:"not null;index:idx_notes_subject,priority:2"`           // Record the note is attached to
+	Author         string `json:"author" gorm:"size:100;not null"`                                         // Staff user who wrote it
+	Visibility     string `json:"visibility" gorm:"size:20;not null;default:'internal'"`                   // internal, customer
+	Body           string `json:"body" gorm:"type:text;not null"`                                          // Note text
+	CorrectsNoteID *uint  `json:"corrects_note_id,omitempty" gorm:"index"`                                 // Earlier note this one corrects
+	DeletedBy      string `json:"-" gorm:"size:100"`                                                       // User who deleted it
+}
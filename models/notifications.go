@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Notification is an outbound message queued for delivery to a customer, or to staff when CustomerID is 0
+// Persisting notifications before sending keeps alerts from being lost on restart
+type Notification struct {
+	ID        uint           `json:"id" gorm:"primaryKey"` // Unique notification identifier
+	CreatedAt time.Time      `json:"created_at"`           // When the notification was queued
+	UpdatedAt time.Time      `json:"updated_at"`           // Last delivery attempt timestamp
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`       // Soft delete support
+
+	// Addressing
+	CustomerID uint   `json:"customer_id" gorm:"index"`           // Customer the message concerns; 0 for staff messages
+	Channel    string `json:"channel" gorm:"size:20;not null"`    // email, webhook
+	Recipient  string `json:"recipient" gorm:"size:500;not null"` // Email address or webhook URL
+
+	// Content
+	Subject string `json:"subject" gorm:"size:255"` // Short summary line
+	Body    string `json:"body" gorm:"type:text"`   // Message body
+
+	// Related record, carried into the communication log
+	ResourceType string `json:"resource_type,omitempty" gorm:"size:30"` // e.g. statement, alert_rule
+	ResourceID   uint   `json:"resource_id,omitempty"`                  // Identifier of the related record
+
+	// Delivery State
+	Status    string     `json:"status" gorm:"size:20;default:'pending';index"` // pending, sent, failed
+	Attempts  int        `json:"attempts" gorm:"default:0"`                     // Delivery attempts so far
+	LastError string     `json:"last_error,omitempty" gorm:"size:500"`          // Most recent delivery error
+	SentAt    *time.Time `json:"sent_at,omitempty"`                             // When delivery succeeded
+}
+
+// AlertRule is a customer-defined condition on an account that triggers a notification
+// Lets customers watch balances and large movements without polling the API
+type AlertRule struct {
+	ID        uint           `json:"id" gorm:"primaryKey"` // Unique rule identifier
+	CreatedAt time.Time      `json:"created_at"`           // Rule creation timestamp
+	UpdatedAt time.Time      `json:"updated_at"`           // Last update timestamp
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`       // Soft delete support
+
+	AccountID uint `json:"account_id" gorm:"not null;index"` // Account the rule watches
+
+	// Rule Definition
+	RuleType   string  `json:"rule_type" gorm:"size:30;not null"`               // balance_below, transaction_over, loan_payment_due
+	Threshold  float64 `json:"threshold" gorm:"type:decimal(15,2)"`             // Amount threshold for balance/transaction rules
+	DaysBefore int     `json:"days_before"`                                     // Lead time in days for loan_payment_due rules
+	Enabled    bool    `json:"enabled"`                                         // Disabled rules are never evaluated
+	DailyCap   int     `json:"daily_cap"`                                       // Maximum firings per calendar day (0 = unlimited)
+	Channel    string  `json:"channel" gorm:"size:20;not null;default:'email'"` // email, webhook
+	Target     string  `json:"target" gorm:"size:500"`                          // Webhook URL or email override (defaults to customer email)
+}
+
+// AlertFiring records each time an alert rule triggered
+// Provides the queryable firing history per rule
+type AlertFiring struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`    // Unique firing identifier
+	CreatedAt time.Time `json:"created_at" gorm:"index"` // When the rule fired
+
+	AlertRuleID    uint   `json:"alert_rule_id" gorm:"not null;index"` // Rule that fired
+	AccountID      uint   `json:"account_id" gorm:"not null;index"`    // Account the rule watches
+	TransactionID  *uint  `json:"transaction_id,omitempty"`            // Triggering transaction, if any
+	NotificationID uint   `json:"notification_id"`                     // Notification queued for this firing
+	Message        string `json:"message" gorm:"size:500"`             // Rendered alert message
+}
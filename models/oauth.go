@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OAuthClient is a third party registered to obtain tokens through the OAuth2 endpoints
+// Only a hash of the secret is stored; the secret itself is shown once, at registration
+type OAuthClient struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`                      // Unique record identifier
+	CreatedAt time.Time      `json:"created_at"`                                // Registration timestamp
+	UpdatedAt time.Time      `json:"updated_at"`                                // Last update timestamp
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`                            // Soft delete; tokens stop working
+	TenantID  uint           `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	ClientID     string `json:"client_id" gorm:"size:64;not null;uniqueIndex"` // Public client identifier
+	SecretHash   string `json:"-" gorm:"size:100;not null"`                    // bcrypt hash of the client secret (never returned)
+	Name         string `json:"name" gorm:"size:200;not null"`                 // Partner name shown on the approval screen
+	RedirectURIs string `json:"redirect_uris" gorm:"size:1000"`                // Comma-separated; authorization codes are only sent to these
+	Scopes       string `json:"scopes" gorm:"size:200;not null"`               // Comma-separated scopes the client may request
+	CreatedBy    string `json:"created_by" gorm:"size:100"`                    // Admin who registered the client
+}
+
+// OAuthCode is a one-time authorization code issued when a customer approves a client
+type OAuthCode struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique record identifier
+	CreatedAt time.Time `json:"created_at"`                                // Issue time
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	CodeHash    string     `json:"-" gorm:"size:64;not null;uniqueIndex"` // SHA-256 of the code (never returned)
+	ClientID    string     `json:"client_id" gorm:"size:64;not null"`     // Client the code was issued to
+	CustomerID  uint       `json:"customer_id" gorm:"not null"`           // Customer who approved
+	ConsentID   uint       `json:"consent_id" gorm:"not null"`            // Consent recorded by the approval
+	RedirectURI string     `json:"redirect_uri" gorm:"size:500"`          // Must be repeated when the code is exchanged
+	Scopes      string     `json:"scopes" gorm:"size:200"`                // Comma-separated approved scopes
+	ExpiresAt   time.Time  `json:"expires_at"`                            // Codes are short-lived
+	UsedAt      *time.Time `json:"used_at,omitempty"`                     // Set when exchanged; a code works once
+}
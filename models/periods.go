@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// PeriodLock records an accounting period close for a tenant
+// The latest row is in force: no entry may be effective on or before ClosedThrough
+type PeriodLock struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique lock record identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the lock was set
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	ClosedThrough time.Time `json:"closed_through" gorm:"not null"` // Books closed through this date (inclusive, UTC)
+	SetBy         string    `json:"set_by" gorm:"size:100"`         // Username of the admin who set the lock
+	Note          string    `json:"note" gorm:"size:500"`           // Reason, e.g. "June close"
+}
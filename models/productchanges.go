@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// ProductChange is an account's move from one product to another, applied at once or from the start of the next
+// statement cycle. The maintenance fee of the month it takes effect in is shared between the two products by days
+type ProductChange struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique change identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the change was requested
+	UpdatedAt time.Time `json:"updated_at"`                                // Last status change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	AccountID     uint       `json:"account_id" gorm:"not null;index"`      // Account converted
+	CustomerID    uint       `json:"customer_id" gorm:"not null;index"`     // Account holder
+	FromType      string     `json:"from_type" gorm:"size:20;not null"`     // Product before, by account type
+	ToType        string     `json:"to_type" gorm:"size:20;not null;index"` // Product after, by account type
+	Status        string     `json:"status" gorm:"size:20;not null;index"`  // scheduled, applied, cancelled
+	EffectiveDate time.Time  `json:"effective_date" gorm:"not null;index"`  // Bank day the new product applies from
+	AppliedAt     *time.Time `json:"applied_at,omitempty"`                  // When the account was changed
+	Reason        string     `json:"reason" gorm:"size:500"`                // Why, e.g. the customer's upgrade request
+	RequestedBy   string     `json:"requested_by" gorm:"size:100;not null"` // User or administrator who asked for it
+	Note          string     `json:"note,omitempty" gorm:"size:255"`        // Why a scheduled change was cancelled
+
+	// Parameters before and after
+	PreviousOverdraftLimit float64 `json:"previous_overdraft_limit" gorm:"type:decimal(15,2)"`
+	OverdraftLimit         float64 `json:"overdraft_limit" gorm:"type:decimal(15,2)"`
+	PreviousMonthlyFee     float64 `json:"previous_monthly_fee" gorm:"type:decimal(15,2)"`
+	MonthlyFee             float64 `json:"monthly_fee" gorm:"type:decimal(15,2)"`
+
+	// The effective month's maintenance fee, by the days on each product
+	PreviousFeeShare float64 `json:"previous_fee_share" gorm:"type:decimal(15,2)"` // Charged at the old product's fee
+	FeeShare         float64 `json:"fee_share" gorm:"type:decimal(15,2)"`          // Charged at the new product's fee
+
+	BulkOperationID *uint `json:"bulk_operation_id,omitempty" gorm:"index"` // Bulk conversion that made it, if any
+}
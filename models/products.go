@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// Product is an account product in a tenant's catalog together with its eligibility rules
+// Accounts link to it by account type; a zero rule value means the criterion does not apply
+type Product struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                                                                      // Unique product identifier
+	CreatedAt time.Time `json:"created_at"`                                                                                // Product creation timestamp
+	UpdatedAt time.Time `json:"updated_at"`                                                                                // Last change timestamp
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index;uniqueIndex:idx_products_tenant_type,priority:1"` // Owning bank brand
+
+	AccountType string `json:"account_type" gorm:"size:20;not null;uniqueIndex:idx_products_tenant_type,priority:2"` // Account type the product opens
+	Name        string `json:"name" gorm:"size:100;not null"`                                                        // Name shown to customers
+	Description string `json:"description" gorm:"size:500"`                                                          // What the product offers
+
+	Category   string `json:"category" gorm:"size:20"`         // Family accounts convert within, e.g. checking; empty for the account type alone
+	Deprecated bool   `json:"deprecated" gorm:"default:false"` // Closed to new accounts and conversions; its accounts are moved off in bulk
+
+	// Parameters accounts take on opening and on conversion to the product
+	MonthlyFee     float64  `json:"monthly_fee" gorm:"type:decimal(15,2);default:0"`     // Maintenance fee, pro-rated by the days an account is on the product
+	OverdraftLimit *float64 `json:"overdraft_limit,omitempty" gorm:"type:decimal(15,2)"` // Limit accounts take; nil leaves the account's own
+
+	// Eligibility Rules
+	MaxPerCustomer   int `json:"max_per_customer"`   // Open accounts of this type a customer may hold
+	MinAgeYears      int `json:"min_age_years"`      // Minimum customer age
+	MinTenureDays    int `json:"min_tenure_days"`    // Minimum days since the customer joined
+	RequiredKYCLevel int `json:"required_kyc_level"` // Minimum customer KYC level
+
+	// Restrictions accounts of this type open with
+	Restrictions AccountRestrictions `json:"restrictions" gorm:"embedded;embeddedPrefix:restriction_"`
+}
+
+// EligibilityOverride records staff waiving one eligibility criterion for one account opening
+type EligibilityOverride struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique override identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the account was opened
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	AccountID    uint   `json:"account_id" gorm:"not null;index"`       // Account opened under the override
+	CustomerID   uint   `json:"customer_id" gorm:"not null;index"`      // Customer who was not eligible
+	AccountType  string `json:"account_type" gorm:"size:20;not null"`   // Product the criterion belongs to
+	Criterion    string `json:"criterion" gorm:"size:30;not null"`      // Criterion waived
+	Detail       string `json:"detail" gorm:"size:255"`                 // Why the customer failed it
+	Reason       string `json:"reason" gorm:"size:500;not null"`        // Staff justification
+	OverriddenBy string `json:"overridden_by" gorm:"size:100;not null"` // Staff member who approved it
+}
+
+// ProductRate is an effective-dated interest rate of a product: the rate applies from its effective date until the
+// next one. Accounts of the product's type accrue at the rate in force each day
+type ProductRate struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                                                                                // Unique rate identifier
+	CreatedAt time.Time `json:"created_at"`                                                                                          // When the change was scheduled
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index;uniqueIndex:idx_product_rates_tenant_type_date,priority:1"` // Owning bank brand
+
+	ProductID     uint      `json:"product_id" gorm:"not null;index"`                                                               // Product the rate belongs to
+	AccountType   string    `json:"account_type" gorm:"size:20;not null;uniqueIndex:idx_product_rates_tenant_type_date,priority:2"` // Product's account type, which accounts link by
+	Rate          float64   `json:"rate" gorm:"type:decimal(7,6);not null"`                                                         // Annual rate, e.g. 0.0425
+	PreviousRate  float64   `json:"previous_rate" gorm:"type:decimal(7,6);not null"`                                                // Rate in force the day before
+	EffectiveDate time.Time `json:"effective_date" gorm:"not null;uniqueIndex:idx_product_rates_tenant_type_date,priority:3"`       // Bank day the rate applies from
+	Reason        string    `json:"reason" gorm:"size:500"`                                                                         // Why the rate changed
+	ScheduledBy   string    `json:"scheduled_by" gorm:"size:100;not null"`                                                          // Admin who scheduled it
+	NoticeDays    int       `json:"notice_days"`                                                                                    // Whole days between scheduling and the effective date
+	Notified      int       `json:"notified"`                                                                                       // Account holders told of the change when it was scheduled
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MatchReport is the result of reconciling an external bank statement against a ledger account
+// Items stay open for review until every line is matched or adjusted
+type MatchReport struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`                      // Unique report identifier
+	CreatedAt time.Time      `json:"created_at"`                                // When the statement was imported
+	UpdatedAt time.Time      `json:"updated_at"`                                // Last review action
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`                            // Soft delete support
+	TenantID  uint           `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	AccountID       uint      `json:"account_id" gorm:"not null;index"` // Ledger account reconciled, normally cash
+	StatementID     string    `json:"statement_id" gorm:"size:100"`     // Identifier from the file
+	ExternalAccount string    `json:"external_account" gorm:"size:50"`  // Account the statement is for, e.g. the nostro IBAN
+	Format          string    `json:"format" gorm:"size:10"`            // camt053, csv
+	Filename        string    `json:"filename" gorm:"size:255"`         // Name the file was uploaded with
+	Currency        string    `json:"currency" gorm:"size:3"`           // Statement currency
+	PeriodFrom      time.Time `json:"period_from"`                      // First day the statement covers
+	PeriodTo        time.Time `json:"period_to"`                        // Last day the statement covers
+	ToleranceDays   int       `json:"tolerance_days"`                   // Date window used for matching
+	Status          string    `json:"status" gorm:"size:20;index"`      // open, reconciled
+	ImportedBy      string    `json:"imported_by" gorm:"size:100"`      // Staff user who uploaded it
+	Matched         int       `json:"matched"`                          // Lines paired with a posting
+	UnmatchedOurs   int       `json:"unmatched_ours"`                   // Postings missing from the statement
+	UnmatchedTheirs int       `json:"unmatched_theirs"`                 // Statement lines missing from the ledger
+	Adjusted        int       `json:"adjusted"`                         // Statement lines booked by an adjustment
+}
+
+// MatchItem is one line of a match report: a statement line, a ledger posting, or both when matched
+type MatchItem struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`                      // Unique item identifier
+	CreatedAt     time.Time `json:"created_at"`                                // When the item was created
+	UpdatedAt     time.Time `json:"updated_at"`                                // Last review action
+	TenantID      uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+	MatchReportID uint      `json:"match_report_id" gorm:"not null;index"`     // Report the item belongs to
+
+	Status string `json:"status" gorm:"size:20;not null;index"` // matched, unmatched_ours, unmatched_theirs, adjusted
+
+	// Statement line - empty for unmatched postings
+	ExternalDate        *time.Time `json:"external_date,omitempty"`                        // Booking date
+	ExternalAmount      float64    `json:"external_amount" gorm:"type:decimal(15,2)"`      // Always positive
+	ExternalDirection   string     `json:"external_direction,omitempty" gorm:"size:4"`     // CRDT (money in), DBIT (money out)
+	ExternalReference   string     `json:"external_reference,omitempty" gorm:"size:140"`   // End-to-end or bank reference
+	ExternalDescription string     `json:"external_description,omitempty" gorm:"size:500"` // Remittance information
+
+	// Ledger posting - empty for unmatched statement lines
+	TransactionID *uint `json:"transaction_id,omitempty" gorm:"index"` // Posting on the reconciled account
+
+	MatchedBy               string     `json:"matched_by,omitempty" gorm:"size:100"` // "auto" or the staff user who paired it
+	MatchedAt               *time.Time `json:"matched_at,omitempty"`                 // When it was paired or adjusted
+	AdjustmentTransactionID *uint      `json:"adjustment_transaction_id,omitempty"`  // Entry booked for an unmatched line
+}
@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// RelationshipTier defines a relationship pricing tier: what a customer must hold to reach it and what it earns
+// Every threshold must be met; the highest-ranked tier a customer meets applies. A code may have several
+// definitions one after another in time, each in effect over its own dates
+type RelationshipTier struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique tier definition identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the definition was created
+	UpdatedAt time.Time `json:"updated_at"`                                // Last change timestamp
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	Code string `json:"code" gorm:"size:30;not null;index"` // Stable tier code, e.g. gold
+	Name string `json:"name" gorm:"size:100;not null"`      // Shown to customers, e.g. "Gold Relationship"
+	Rank int    `json:"rank" gorm:"not null"`               // Higher ranks are better tiers
+
+	// Thresholds - deposits and loan balances in the base currency
+	MinDeposits    float64 `json:"min_deposits" gorm:"type:decimal(15,2);default:0"`     // Total deposit balances
+	MinLoanBalance float64 `json:"min_loan_balance" gorm:"type:decimal(15,2);default:0"` // Total outstanding active loans
+	MinProducts    int     `json:"min_products"`                                         // Distinct products held
+	MinTenureDays  int     `json:"min_tenure_days"`                                      // Days since the customer joined
+
+	// Benefits
+	FeeWaivers       string  `json:"fee_waivers" gorm:"size:200"`                           // Comma-separated account types whose transaction fees are waived, e.g. checking
+	SavingsRateBonus float64 `json:"savings_rate_bonus" gorm:"type:decimal(7,4);default:0"` // Percentage points added to the savings rate, e.g. 0.25
+
+	// Effective Dating - by UTC day
+	EffectiveFrom time.Time  `json:"effective_from" gorm:"not null"` // First day the definition applies
+	EffectiveTo   *time.Time `json:"effective_to,omitempty"`         // Last day (inclusive); open-ended when nil
+
+	CreatedBy string `json:"created_by" gorm:"size:100"` // Admin who created it
+}
+
+// CustomerRelationship is a customer's tier for one month, with the figures it was decided on
+// Recomputed monthly; the latest month is the tier fees and rates are priced with
+type CustomerRelationship struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique record identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the month was first computed
+	UpdatedAt time.Time `json:"updated_at"`                                // Last recomputation
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	CustomerID uint   `json:"customer_id" gorm:"not null;uniqueIndex:idx_customer_relationships_customer_period,priority:1"`   // Customer tiered
+	Period     string `json:"period" gorm:"size:7;not null;uniqueIndex:idx_customer_relationships_customer_period,priority:2"` // Month the tier applies for, YYYY-MM
+
+	TierID   *uint  `json:"tier_id,omitempty" gorm:"index"` // Tier definition reached; nil below every tier
+	TierCode string `json:"tier_code" gorm:"size:30"`       // Its code, empty below every tier
+
+	// Inputs - as they stood when the tier was decided
+	TotalDeposits float64   `json:"total_deposits" gorm:"type:decimal(15,2)"` // Deposit balances in the base currency
+	LoanBalance   float64   `json:"loan_balance" gorm:"type:decimal(15,2)"`   // Outstanding active loans in the base currency
+	ProductCount  int       `json:"product_count"`                            // Distinct products held
+	TenureDays    int       `json:"tenure_days"`                              // Days since the customer joined
+	ComputedAt    time.Time `json:"computed_at"`                              // When the inputs were read
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ReportParams are the stored parameters a subscription renders its report with; each report reads its own
+type ReportParams struct {
+	Currency       string `json:"currency,omitempty" gorm:"size:3"` // transaction_volume: one currency, or all when empty
+	Days           int    `json:"days,omitempty"`                   // transaction_volume: whole days before the run day covered
+	MinDaysPastDue int    `json:"min_days_past_due,omitempty"`      // delinquency: loans at least this far behind
+}
+
+// ReportSubscription delivers a report on a cron schedule by email or webhook
+// Runs are made by the report-subscriptions job; a subscription whose owner is deactivated is paused
+type ReportSubscription struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`                      // Unique subscription identifier
+	CreatedAt time.Time      `json:"created_at"`                                // When the subscription was created
+	UpdatedAt time.Time      `json:"updated_at"`                                // Last update timestamp
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`                            // Soft delete support
+	TenantID  uint           `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	Name       string       `json:"name" gorm:"size:200;not null"`                    // Label shown in deliveries
+	ReportType string       `json:"report_type" gorm:"size:40;not null"`              // transaction_volume, delinquency
+	Params     ReportParams `json:"parameters" gorm:"embedded;embeddedPrefix:param_"` // Report parameters
+	Schedule   string       `json:"schedule" gorm:"size:100;not null"`                // Cron expression, e.g. "0 7 * * *"
+	Format     string       `json:"format" gorm:"size:10;not null"`                   // csv, json
+	Channel    string       `json:"channel" gorm:"size:20;not null"`                  // email, webhook
+	Target     string       `json:"target" gorm:"size:500;not null"`                  // Email address or webhook URL
+	AlertEmail string       `json:"alert_email" gorm:"size:255"`                      // Where failure alerts go; defaults to an email target
+
+	// Ownership
+	OwnerID      uint   `json:"owner_id" gorm:"not null;index"`               // User who created the subscription
+	Owner        string `json:"owner" gorm:"size:100;not null"`               // Their username
+	Status       string `json:"status" gorm:"size:20;default:'active';index"` // active, paused
+	PausedReason string `json:"paused_reason,omitempty" gorm:"size:255"`      // Why it was paused
+
+	// Scheduling
+	NextRunAt     *time.Time `json:"next_run_at,omitempty" gorm:"index"`       // When the next run, or retry, is due
+	DueAt         *time.Time `json:"due_at,omitempty"`                         // Scheduled time of the run being retried
+	Attempts      int        `json:"attempts" gorm:"default:0"`                // Failed attempts at that run
+	LastRunAt     *time.Time `json:"last_run_at,omitempty"`                    // When the last run was made
+	LastRunStatus string     `json:"last_run_status,omitempty" gorm:"size:20"` // Outcome of the last run
+}
+
+// ReportRun is one rendering and delivery of a subscribed report
+type ReportRun struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique run identifier
+	CreatedAt time.Time `json:"created_at" gorm:"index"`                   // When the run was made
+	UpdatedAt time.Time `json:"updated_at"`                                // Last status change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	SubscriptionID uint      `json:"subscription_id" gorm:"not null;index"` // Subscription the run is for
+	ScheduledFor   time.Time `json:"scheduled_for"`                         // Scheduled time the run covers
+	Attempt        int       `json:"attempt"`                               // 1 for the first try, counting retries
+	Trigger        string    `json:"trigger" gorm:"size:20"`                // schedule, manual
+	Status         string    `json:"status" gorm:"size:20;not null;index"`  // delivering, delivered, failed
+	Error          string    `json:"error,omitempty" gorm:"size:500"`       // Why rendering or delivery failed
+
+	// Artifact - the rendered report in document storage
+	ArtifactKey string `json:"artifact_key,omitempty" gorm:"size:255"` // Storage key
+	ContentType string `json:"content_type,omitempty" gorm:"size:100"` // MIME type
+	Size        int64  `json:"size"`                                   // Bytes
+	Rows        int    `json:"rows"`                                   // Report rows rendered
+
+	NotificationID      *uint `json:"notification_id,omitempty" gorm:"index"` // Delivery of the report
+	AlertNotificationID *uint `json:"alert_notification_id,omitempty"`        // Failure alert sent to the owner
+}
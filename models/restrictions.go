@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// AccountRestrictions limit what may be debited from an account, e.g. for escrow and savings-club products
+// It is embedded in Account and Product as restriction_* columns; a product's restrictions are copied onto
+// accounts opened under it
+type AccountRestrictions struct {
+	DepositOnly                 bool `json:"deposit_only" gorm:"default:false"`                   // No customer debits at all
+	NoOutboundTransfers         bool `json:"no_outbound_transfers" gorm:"default:false"`          // No transfers out; withdrawals are unaffected
+	StaffApprovalForWithdrawals bool `json:"staff_approval_for_withdrawals" gorm:"default:false"` // Debits wait in the approval queue for staff
+}
+
+// WithdrawalApproval is a debit from an account whose withdrawals need staff approval, held until staff decide
+// pending: waiting in the queue, approved: posted by the approver, rejected: never posted
+type WithdrawalApproval struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique approval identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the debit was requested
+	UpdatedAt time.Time `json:"updated_at"`                                // Last status change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	Status          string  `json:"status" gorm:"size:20;not null;index"`      // pending, approved, rejected
+	Kind            string  `json:"kind" gorm:"size:20;not null"`              // transaction or transfer - the API the debit came through
+	AccountID       uint    `json:"account_id" gorm:"not null;index"`          // Restricted account debited
+	ToAccountID     uint    `json:"to_account_id,omitempty"`                   // Destination of a transfer
+	TransactionType string  `json:"transaction_type" gorm:"size:20;not null"`  // withdrawal, transfer, payment
+	Amount          float64 `json:"amount" gorm:"type:decimal(15,2);not null"` // Amount to debit
+	Description     string  `json:"description" gorm:"size:500"`               // Description sent with the request
+	Memo            string  `json:"memo,omitempty" gorm:"size:500"`            // Customer note sent with the request
+	Reference       string  `json:"reference" gorm:"size:100"`                 // Reference sent with the request
+	Channel         string  `json:"channel" gorm:"size:20"`                    // Channel the request came through
+	RequestedBy     string  `json:"requested_by" gorm:"size:100;not null"`     // User who asked for the debit (the maker)
+
+	TransactionID *uint `json:"transaction_id,omitempty"` // Debit posted on approval
+	TransferID    *uint `json:"transfer_id,omitempty"`    // Transfer posted on approval
+
+	DecidedAt    *time.Time `json:"decided_at,omitempty"`                    // When staff decided
+	DecidedBy    string     `json:"decided_by,omitempty" gorm:"size:100"`    // Staff member who decided (the checker)
+	DecisionNote string     `json:"decision_note,omitempty" gorm:"size:500"` // Why it was approved or rejected
+}
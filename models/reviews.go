@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// TransactionReview is a large first debit from a new account, held for review instead of posting
+// pending_review: its amount is held on the account, approved: posted by staff or on its own once the review time
+// passed, declined: never posted and the hold released
+type TransactionReview struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique review identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the debit was requested
+	UpdatedAt time.Time `json:"updated_at"`                                // Last status change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	Status          string  `json:"status" gorm:"size:20;not null;index"`      // pending_review, approved, declined
+	Kind            string  `json:"kind" gorm:"size:20;not null"`              // transaction or transfer - the API the debit came through
+	AccountID       uint    `json:"account_id" gorm:"not null;index"`          // New account debited
+	CustomerID      uint    `json:"customer_id" gorm:"not null;index"`         // Account holder, told when the debit is declined
+	ToAccountID     uint    `json:"to_account_id,omitempty"`                   // Destination of a transfer
+	TransactionType string  `json:"transaction_type" gorm:"size:20;not null"`  // withdrawal or transfer
+	Amount          float64 `json:"amount" gorm:"type:decimal(15,2);not null"` // Amount held and, once approved, debited
+	Description     string  `json:"description" gorm:"size:500"`               // Description sent with the request
+	Memo            string  `json:"memo,omitempty" gorm:"size:500"`            // Customer note sent with the request
+	Reference       string  `json:"reference" gorm:"size:100"`                 // Reference sent with the request
+	Channel         string  `json:"channel" gorm:"size:20"`                    // Channel the request came through
+	IdempotencyKey  string  `json:"idempotency_key,omitempty" gorm:"size:100"` // Transfer's Idempotency-Key, so a retry finds the review
+	RequestedBy     string  `json:"requested_by" gorm:"size:100;not null"`     // User who asked for the debit
+
+	ReviewBy time.Time `json:"review_by" gorm:"index"` // Approved automatically at this time unless decided before
+
+	TransactionID *uint `json:"transaction_id,omitempty"` // Debit posted on approval
+	TransferID    *uint `json:"transfer_id,omitempty"`    // Transfer posted on approval
+
+	DecidedAt    *time.Time `json:"decided_at,omitempty"`                    // When it was approved or declined
+	DecidedBy    string     `json:"decided_by,omitempty" gorm:"size:100"`    // Staff member, or system for automatic approvals
+	DecisionNote string     `json:"decision_note,omitempty" gorm:"size:500"` // Why it was approved or declined
+	AutoApproved bool       `json:"auto_approved" gorm:"default:false"`      // Approved because the review time passed
+}
@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// SARCase is a compliance investigation into a customer's activity that may end in a suspicious activity report
+// open: awaiting an investigator, investigating: being worked, sar_filed: reported to the regulator,
+// closed_no_action: closed without a report. Cases are only visible to the compliance role, and the subject is
+// never told of one
+type SARCase struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique case identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the case was opened
+	UpdatedAt time.Time `json:"updated_at"`                                // Last change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	CaseNumber string    `json:"case_number" gorm:"size:30;not null;uniqueIndex"` // Reference quoted in the filing, e.g. SAR20261017091500123
+	CustomerID uint      `json:"customer_id" gorm:"not null;index"`               // Subject of the investigation
+	Status     string    `json:"status" gorm:"size:20;not null;index"`            // open, investigating, sar_filed, closed_no_action
+	Narrative  string    `json:"narrative" gorm:"type:text"`                      // What happened and why it is suspicious
+	AssignedTo string    `json:"assigned_to,omitempty" gorm:"size:100;index"`     // Investigating compliance user
+	OpenedBy   string    `json:"opened_by" gorm:"size:100;not null"`              // Compliance user who opened it
+	DueAt      time.Time `json:"due_at" gorm:"index"`                             // Filing deadline
+
+	SourceType string `json:"source_type,omitempty" gorm:"size:30"` // Alert the case was opened from: transaction_review, duplicate_payment
+	SourceID   uint   `json:"source_id,omitempty"`                  // Identifier of that alert
+
+	RemindedAt        *time.Time `json:"reminded_at,omitempty"`         // When the approaching deadline was notified
+	OverdueNotifiedAt *time.Time `json:"overdue_notified_at,omitempty"` // When the missed deadline was notified
+
+	FilingReference string     `json:"filing_reference,omitempty" gorm:"size:100"` // Regulator's acknowledgement of the filed report
+	ClosedAt        *time.Time `json:"closed_at,omitempty"`                        // When the report was filed or the case closed
+	ClosedBy        string     `json:"closed_by,omitempty" gorm:"size:100"`        // Compliance user who filed or closed it
+	CloseReason     string     `json:"close_reason,omitempty" gorm:"size:500"`     // Why no report was filed
+}
+
+// SARCaseTransaction links a transaction to a case; the transaction cannot be reversed or archived while the
+// case is open or investigating
+type SARCaseTransaction struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique link identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the transaction was attached
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	CaseID        uint   `json:"case_id" gorm:"not null;uniqueIndex:idx_sar_case_transactions_pair,priority:1"`              // Case
+	TransactionID uint   `json:"transaction_id" gorm:"not null;uniqueIndex:idx_sar_case_transactions_pair,priority:2;index"` // Linked transaction
+	AddedBy       string `json:"added_by" gorm:"size:100"`                                                                   // Compliance user who attached it
+}
+
+// SARCaseDocument attaches an uploaded document of the subject customer to a case
+type SARCaseDocument struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique attachment identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the document was attached
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	CaseID     uint   `json:"case_id" gorm:"not null;uniqueIndex:idx_sar_case_documents_pair,priority:1"`     // Case
+	DocumentID uint   `json:"document_id" gorm:"not null;uniqueIndex:idx_sar_case_documents_pair,priority:2"` // Attached document
+	AddedBy    string `json:"added_by" gorm:"size:100"`                                                       // Compliance user who attached it
+}
+
+// SARCaseNote is an investigation note on a case; notes are never edited or deleted
+type SARCaseNote struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique note identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the note was written
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	CaseID uint   `json:"case_id" gorm:"not null;index"`   // Case
+	Author string `json:"author" gorm:"size:100;not null"` // Compliance user who wrote it
+	Body   string `json:"body" gorm:"type:text;not null"`  // Note text
+}
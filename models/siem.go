@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// SIEMCursor is the high-water mark of the audit log forwarder: the last entry its sink acknowledged
+// Entries after it are shipped on the next run, so a restart resumes where delivery stopped
+type SIEMCursor struct {
+	Name        string     `json:"name" gorm:"primaryKey;size:50"` // Forwarder the mark belongs to, e.g. audit
+	LastID      uint       `json:"last_id"`                        // Last audit entry acknowledged by the sink
+	ForwardedAt *time.Time `json:"forwarded_at,omitempty"`         // When that entry was acknowledged
+	UpdatedAt   time.Time  `json:"updated_at"`                     // Last change to the mark
+}
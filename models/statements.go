@@ -0,0 +1,85 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// StatementPreference is how an account's monthly statement reaches the customer
+// It is embedded in Account as the statement_channel and statement_day columns
+type StatementPreference struct {
+	Channel string `json:"channel" gorm:"size:20;default:'email'"` // email, none
+	Day     int    `json:"day" gorm:"default:1"`                   // Day of the month the previous month's statement is sent (1-28)
+}
+
+// Statement is an archived account statement: a monthly one, or an ad hoc one generated on request for a custom
+// date range. One monthly row per account and period; the rendered document lives in document storage under StorageKey
+type Statement struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`                      // Unique statement identifier
+	CreatedAt time.Time      `json:"created_at"`                                // First generation time
+	UpdatedAt time.Time      `json:"updated_at"`                                // Last regeneration time
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`                            // Soft delete support
+	TenantID  uint           `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	AccountID   uint      `json:"account_id" gorm:"not null;uniqueIndex:idx_statements_account_period_request,priority:1"`   // Account the statement covers
+	CustomerID  uint      `json:"customer_id" gorm:"not null;index"`                                                         // Account holder
+	PeriodStart time.Time `json:"period_start" gorm:"not null;uniqueIndex:idx_statements_account_period_request,priority:2"` // First day of the statement month, or of an ad hoc range
+	PeriodEnd   time.Time `json:"period_end" gorm:"not null"`                                                                // First day after the month or range (exclusive)
+	Sequence    int       `json:"sequence" gorm:"not null;default:0"`                                                        // Month number in the account's archive, 1 for its first statement; 0 for ad hoc ones
+
+	// Ad hoc statements are outside the monthly sequence: never numbered, sent or checked for continuity
+	AdHoc     bool   `json:"ad_hoc" gorm:"not null;default:false;index"`                                                                  // Generated on request for a custom date range
+	RequestID uint   `json:"request_id,omitempty" gorm:"not null;default:0;uniqueIndex:idx_statements_account_period_request,priority:3"` // StatementRequest it was generated for; 0 for monthly statements
+	Format    string `json:"format" gorm:"size:4;not null;default:'pdf'"`                                                                 // Stored document: pdf, or json or csv for ad hoc statements
+
+	// Figures as rendered
+	Currency         string  `json:"currency" gorm:"size:3"`
+	OpeningBalance   float64 `json:"opening_balance" gorm:"type:decimal(15,2)"`
+	ClosingBalance   float64 `json:"closing_balance" gorm:"type:decimal(15,2)"`
+	TotalCredits     float64 `json:"total_credits" gorm:"type:decimal(15,2)"`
+	TotalDebits      float64 `json:"total_debits" gorm:"type:decimal(15,2)"`
+	TransactionCount int64   `json:"transaction_count"`
+
+	// Continuity with the statement before it
+	PreviousClosingBalance *float64 `json:"previous_closing_balance,omitempty" gorm:"type:decimal(15,2)"` // Closing balance of sequence-1 when this one was generated
+	Repaired               bool     `json:"repaired"`                                                     // Generated by gap repair to fill a missing month; archived without sending
+
+	// Stored document
+	StorageKey  string `json:"-" gorm:"size:255;not null"`   // Key of the PDF in document storage
+	Size        int64  `json:"size"`                         // PDF size in bytes
+	Checksum    string `json:"checksum" gorm:"size:64"`      // SHA-256 of the PDF
+	Generations int    `json:"generations" gorm:"default:1"` // Times the PDF has been rendered
+
+	// Delivery
+	Channel        string     `json:"channel" gorm:"size:20"`                     // Preference at generation: email, none
+	Delivery       string     `json:"delivery" gorm:"size:20;index"`              // emailed, archived
+	NeedsFollowUp  bool       `json:"needs_follow_up" gorm:"index"`               // Email was chosen but could not be sent - ops should contact the customer
+	FollowUpReason string     `json:"follow_up_reason,omitempty" gorm:"size:200"` // Why delivery fell back to the archive
+	LinkTokenHash  string     `json:"-" gorm:"size:64;index"`                     // SHA-256 of the emailed download token
+	LinkExpiresAt  *time.Time `json:"link_expires_at,omitempty"`                  // When the emailed link stops working
+	NotificationID *uint      `json:"notification_id,omitempty"`                  // Email queued for the statement
+}
+
+// StatementRequest is a request for an account statement over a custom date range, e.g. for a visa application
+// Small ranges are generated while the caller waits; larger ones are queued and generated in the background.
+// Once ready, the document is in the statement archive as an ad hoc statement
+type StatementRequest struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique request identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the statement was requested
+	UpdatedAt time.Time `json:"updated_at"`                                // Last status change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	AccountID   uint      `json:"account_id" gorm:"not null;index"`      // Account the statement covers
+	CustomerID  uint      `json:"customer_id" gorm:"not null;index"`     // Account holder
+	PeriodStart time.Time `json:"period_start" gorm:"not null"`          // Bank midnight of the first day covered
+	PeriodEnd   time.Time `json:"period_end" gorm:"not null"`            // Bank midnight after the last day covered (exclusive)
+	Format      string    `json:"format" gorm:"size:4;not null"`         // pdf, json, csv
+	RequestedBy string    `json:"requested_by" gorm:"size:100;not null"` // Customer or staff member who asked for it
+	Async       bool      `json:"async"`                                 // Too large to generate while the caller waited
+
+	Status      string     `json:"status" gorm:"size:20;not null;default:'queued';index"` // queued, running, ready, failed
+	Error       string     `json:"error,omitempty" gorm:"size:500"`                       // Why generation failed
+	StatementID *uint      `json:"statement_id,omitempty"`                                // Archived ad hoc statement, once ready
+	CompletedAt *time.Time `json:"completed_at,omitempty"`                                // When it became ready or failed
+}
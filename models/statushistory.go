@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// StatusHistory is one change of a customer's, account's or loan's status
+// Rows are only ever added; the record itself keeps just its latest status
+type StatusHistory struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique history entry identifier
+	CreatedAt time.Time `json:"-"`                                         // When the row was written
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	SubjectType string    `json:"subject_type" gorm:"size:20;not null;index:idx_status_histories_subject,priority:1"` // customer, account, loan, application
+	SubjectID   uint      `json:"subject_id" gorm:"not null;index:idx_status_histories_subject,priority:2"`           // Record whose status changed
+	OldStatus   string    `json:"old_status" gorm:"size:20"`                                                          // Empty for the status the record was created with
+	NewStatus   string    `json:"new_status" gorm:"size:20;not null"`                                                 // Status from this change on
+	Reason      string    `json:"reason" gorm:"size:500"`                                                             // Why, e.g. the fraud case or "Paid off"
+	ChangedBy   string    `json:"changed_by" gorm:"size:100;not null"`                                                // User, or "system" for background jobs
+	ChangedAt   time.Time `json:"changed_at" gorm:"not null;index:idx_status_histories_subject,priority:3"`           // When the change took effect
+}
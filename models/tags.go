@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Tag is a segmentation label such as vip or payroll, in the tenant's tag vocabulary
+type Tag struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                                                            // Unique tag identifier
+	CreatedAt time.Time `json:"created_at"`                                                                      // When the tag was added to the vocabulary
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;uniqueIndex:idx_tags_tenant_slug,priority:1"` // Owning bank brand
+
+	Slug        string `json:"slug" gorm:"size:40;not null;uniqueIndex:idx_tags_tenant_slug,priority:2"` // Lowercase name, unique per tenant
+	Description string `json:"description" gorm:"size:255"`                                              // What the segment is for
+	CreatedBy   string `json:"created_by" gorm:"size:100"`                                               // Admin who defined it, or who first used it in free-form mode
+}
+
+// EntityTag attaches a tag to a customer or account
+type EntityTag struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique assignment identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the tag was applied
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	TagID       uint   `json:"tag_id" gorm:"not null;uniqueIndex:idx_entity_tags_subject,priority:3"`               // Tag applied
+	SubjectType string `json:"subject_type" gorm:"size:20;not null;uniqueIndex:idx_entity_tags_subject,priority:1"` // customer, account
+	SubjectID   uint   `json:"subject_id" gorm:"not null;uniqueIndex:idx_entity_tags_subject,priority:2"`           // Record the tag is on
+	AddedBy     string `json:"added_by" gorm:"size:100"`                                                            // Staff user who applied it
+}
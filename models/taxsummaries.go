@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// TaxSummary is a pre-generated year-end interest and fee summary for a customer
+// Data holds the rendered summary as JSON so retrieval needs no aggregation
+type TaxSummary struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique record identifier
+	CreatedAt time.Time `json:"created_at"`                                // First generation
+	UpdatedAt time.Time `json:"updated_at"`                                // Last regeneration
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	CustomerID  uint      `json:"customer_id" gorm:"not null;uniqueIndex:idx_tax_summaries_customer_year,priority:1"` // Customer summarized
+	Year        int       `json:"year" gorm:"not null;uniqueIndex:idx_tax_summaries_customer_year,priority:2"`        // Calendar year
+	Data        string    `json:"-" gorm:"type:text;not null"`                                                        // Summary JSON
+	GeneratedAt time.Time `json:"generated_at"`                                                                       // When Data was computed
+}
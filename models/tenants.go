@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Tenant is a bank brand served by this deployment
+// Customers, accounts, transactions, loans and users all belong to exactly one tenant
+type Tenant struct {
+	ID        uint      `json:"id" gorm:"primaryKey"` // Unique tenant identifier
+	CreatedAt time.Time `json:"created_at"`           // Tenant creation timestamp
+	UpdatedAt time.Time `json:"updated_at"`           // Last update timestamp
+
+	Code     string `json:"code" gorm:"size:50;uniqueIndex;not null"` // Short code sent in the X-Tenant header
+	Name     string `json:"name" gorm:"size:200;not null"`            // Brand name
+	Settings string `json:"settings" gorm:"type:text"`                // JSON overrides of global settings
+	Status   string `json:"status" gorm:"size:20;default:'active'"`   // active, suspended
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Transfer moves money between two accounts on the books as a debit and a credit posted together
+type Transfer struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                                                                       // Unique transfer identifier
+	CreatedAt time.Time `json:"created_at" gorm:"index:idx_transfers_source_created,priority:2"`                            // When the transfer was posted
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index;uniqueIndex:idx_transfers_idempotency,priority:1"` // Owning bank brand
+
+	FromAccountID uint    `json:"from_account_id" gorm:"not null;index:idx_transfers_source_created,priority:1"` // Debited account
+	ToAccountID   uint    `json:"to_account_id" gorm:"not null;index"`                                           // Credited account
+	Amount        float64 `json:"amount" gorm:"type:decimal(15,2);not null"`                                     // Amount moved
+	Description   string  `json:"description" gorm:"size:500"`                                                   // Client-supplied description
+	Reference     string  `json:"reference" gorm:"size:100"`                                                     // Client-supplied reference
+
+	DebitTransactionID  uint  `json:"debit_transaction_id"`         // Posting on the source account
+	CreditTransactionID uint  `json:"credit_transaction_id"`        // Posting on the destination account
+	FeeTransactionID    *uint `json:"fee_transaction_id,omitempty"` // Fee charged on the source account, if any
+
+	ConfirmedDuplicateOfID *uint   `json:"confirmed_duplicate_of_id,omitempty"`                                                        // Earlier transfer the client confirmed this repeats
+	IdempotencyKey         *string `json:"idempotency_key,omitempty" gorm:"size:100;uniqueIndex:idx_transfers_idempotency,priority:2"` // Client's Idempotency-Key, unique per tenant
+	CreatedBy              string  `json:"created_by" gorm:"size:100"`                                                                 // User who requested it
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// UsageRecord is an API client's usage on one bank day in one endpoint category
+// Counters are kept in memory per instance and added to the row on each flush, so the row is the sum over instances
+type UsageRecord struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`                       // Unique record identifier
+	CreatedAt time.Time `json:"-"`                                         // First flush of the day
+	UpdatedAt time.Time `json:"updated_at"`                                // Latest flush
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	ClientID     string `json:"client_id" gorm:"size:64;not null;uniqueIndex:idx_usage_client_day_category,priority:1"` // OAuth client
+	Day          string `json:"day" gorm:"size:10;not null;uniqueIndex:idx_usage_client_day_category,priority:2"`       // YYYY-MM-DD in bank time
+	Category     string `json:"category" gorm:"size:100;not null;uniqueIndex:idx_usage_client_day_category,priority:3"` // Endpoint category, e.g. accounts/balance
+	Requests     int64  `json:"requests" gorm:"not null;default:0"`                                                     // Requests served
+	Transactions int64  `json:"transactions" gorm:"not null;default:0"`                                                 // Postings the client initiated that succeeded
+	RowsExported int64  `json:"rows_exported" gorm:"not null;default:0"`                                                // Records returned by list endpoints
+}
+
+// UsageQuota is a monthly limit on one of an API client's usage metrics
+// What happens past the limit is the quota's mode: warn serves the request with a warning header, throttle
+// serves a few a minute and refuses the rest, and block refuses everything until the month ends
+type UsageQuota struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique quota identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the quota was set
+	UpdatedAt time.Time `json:"updated_at"`                                // Last change timestamp
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	ClientID          string `json:"client_id" gorm:"size:64;not null;uniqueIndex:idx_usage_quota_client_metric,priority:1"`  // OAuth client
+	Metric            string `json:"metric" gorm:"size:20;not null;uniqueIndex:idx_usage_quota_client_metric,priority:2"`     // requests, transactions or rows_exported
+	Category          string `json:"category,omitempty" gorm:"size:100;uniqueIndex:idx_usage_quota_client_metric,priority:3"` // Limits one endpoint category; empty covers all
+	MonthlyLimit      int64  `json:"monthly_limit" gorm:"not null"`                                                           // Usage allowed per bank month
+	Mode              string `json:"mode" gorm:"size:10;not null"`                                                            // warn, throttle or block
+	ThrottlePerMinute int    `json:"throttle_per_minute,omitempty"`                                                           // Requests a minute served past the limit in throttle mode
+	UpdatedBy         string `json:"updated_by" gorm:"size:100"`                                                              // Admin who last set the quota
+}
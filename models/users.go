@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// User is an operator or API user that can sign in and receive a JWT
+// Passwords are stored as bcrypt hashes only
+type User struct {
+	ID        uint           `json:"id" gorm:"primaryKey"` // Unique user identifier
+	CreatedAt time.Time      `json:"created_at"`           // Account creation timestamp
+	UpdatedAt time.Time      `json:"updated_at"`           // Last update timestamp
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`       // Soft delete support
+
+	TenantID     uint   `json:"tenant_id" gorm:"not null;default:1;uniqueIndex:idx_users_tenant_username,priority:1"` // Owning bank brand
+	Username     string `json:"username" gorm:"size:100;not null;uniqueIndex:idx_users_tenant_username,priority:2"`   // Login name, unique per tenant
+	PasswordHash string `json:"-" gorm:"size:100;not null"`                                                           // bcrypt hash (never returned)
+	Role         string `json:"role" gorm:"size:20;not null"`                                                         // admin, teller, compliance, customer
+	CustomerID   *uint  `json:"customer_id,omitempty" gorm:"index"`                                                   // Customer a customer-role user signs in as
+
+	// Sign-in State - repeated failures lock the user until an operator unlocks it
+	Status       string     `json:"status" gorm:"size:20;default:'active'"` // active, locked, disabled
+	FailedLogins int        `json:"failed_logins" gorm:"default:0"`         // Consecutive failed sign-ins
+	LockedAt     *time.Time `json:"locked_at,omitempty"`                    // When the user was locked
+	LastLoginAt  *time.Time `json:"last_login_at,omitempty"`                // Last successful sign-in
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// EmailVerification is a token emailed to confirm a customer's address, on sign-up or when the address changes
+// Only the token's hash is stored; issuing a new token for a customer supersedes their pending ones
+type EmailVerification struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`                      // Unique verification identifier
+	CreatedAt time.Time `json:"created_at"`                                // When the token was issued
+	UpdatedAt time.Time `json:"updated_at"`                                // Last status change
+	TenantID  uint      `json:"tenant_id" gorm:"not null;default:1;index"` // Owning bank brand
+
+	CustomerID uint       `json:"customer_id" gorm:"not null;index"`     // Customer whose address is confirmed
+	Email      string     `json:"email" gorm:"size:255;not null"`        // Address the token was sent to
+	Purpose    string     `json:"purpose" gorm:"size:20;not null"`       // signup or change
+	Status     string     `json:"status" gorm:"size:20;not null;index"`  // pending, verified, superseded
+	TokenHash  string     `json:"-" gorm:"size:64;not null;uniqueIndex"` // SHA-256 of the emailed token
+	ExpiresAt  time.Time  `json:"expires_at"`                            // The token no longer works after this
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`                 // When the token was used
+}
@@ -0,0 +1,364 @@
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount counted in its currency's minor units, e.g. {123450, "USD"} for $1,234.50 and {1235, "JPY"}
+// for ¥1,235. Arithmetic between two amounts fails unless they are in the same currency
+type Money struct {
+	Amount   int64  // Minor units: cents for USD, whole yen for JPY
+	Currency string // ISO 4217 code, upper case
+}
+
+// currency is how one currency's amounts are counted and shown
+type currency struct {
+	exponent int    // Decimal places of the minor unit
+	symbol   string // Display symbol; empty shows the code
+}
+
+// currencies are the ones accounts can be opened in; other codes count cents and show their code
+var currencies = map[string]currency{
+	"AUD": {2, "A$"},
+	"CAD": {2, "CA$"},
+	"EUR": {2, "€"},
+	"GBP": {2, "£"},
+	"INR": {2, "₹"},
+	"JPY": {0, "¥"},
+	"USD": {2, "$"},
+}
+
+// defaultExponent is the minor unit of currencies without an entry, and of amounts without a currency
+const defaultExponent = 2
+
+// Errors - callers test them with errors.Is, since mismatches name both currencies
+var (
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrInvalidAmount    = errors.New("invalid amount")
+	ErrWeights          = errors.New("allocation needs at least one positive weight and none negative")
+)
+
+// New returns minor units of a currency
+func New(minor int64, code string) Money {
+	return Money{Amount: minor, Currency: strings.ToUpper(code)}
+}
+
+// Zero returns nothing in a currency
+func Zero(code string) Money {
+	return New(0, code)
+}
+
+// FromFloat converts a major-unit amount as stored in the database, rounding half away from zero to the minor unit
+func FromFloat(amount float64, code string) Money {
+	return New(int64(math.Round(amount*scale(code))), code)
+}
+
+// Cents rounds a major-unit amount half away from zero to cents, trimming the floating point noise of float64
+// arithmetic on the two-decimal columns
+func Cents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// Parse reads a decimal string such as "-1234.5", refusing more decimal places than the currency's minor unit
+func Parse(s, code string) (Money, error) {
+	exponent := Exponent(code)
+	raw := strings.TrimSpace(s)
+	negative := strings.HasPrefix(raw, "-")
+	raw = strings.TrimPrefix(raw, "-")
+	whole, fraction, _ := strings.Cut(raw, ".")
+	if whole == "" || !digits(whole) || !digits(fraction) || len(fraction) > exponent || strings.HasSuffix(raw, ".") {
+		return Money{}, fmt.Errorf("%w: %q in %s", ErrInvalidAmount, s, strings.ToUpper(code))
+	}
+	fraction += strings.Repeat("0", exponent-len(fraction))
+	minor, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q in %s", ErrInvalidAmount, s, strings.ToUpper(code))
+	}
+	if negative {
+		minor = -minor
+	}
+	return New(minor, code), nil
+}
+
+// Exponent is the number of decimal places in a currency's minor unit, e.g. 2 for USD and 0 for JPY
+func Exponent(code string) int {
+	if c, ok := currencies[strings.ToUpper(code)]; ok {
+		return c.exponent
+	}
+	return defaultExponent
+}
+
+// Symbol returns a currency's display symbol, e.g. "€" for EUR, and whether it has one
+func Symbol(code string) (string, bool) {
+	c, ok := currencies[strings.ToUpper(code)]
+	return c.symbol, ok && c.symbol != ""
+}
+
+// Same fails with ErrCurrencyMismatch unless two currency codes are the same
+func Same(a, b string) error {
+	if !strings.EqualFold(a, b) {
+		return fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, strings.ToUpper(a), strings.ToUpper(b))
+	}
+	return nil
+}
+
+// Float converts to major units for the decimal columns and v1 responses
+func (m Money) Float() float64 {
+	return float64(m.Amount) / scale(m.Currency)
+}
+
+// Decimal renders the exact amount in major units, e.g. "-1234.50", or "1235" for JPY
+func (m Money) Decimal() string {
+	minor := m.Amount
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+	}
+	digits := strconv.FormatUint(abs(minor), 10)
+	exponent := Exponent(m.Currency)
+	if exponent == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
+
+// String formats for display with the currency's symbol and thousands separators, e.g. "-$1,234.50" or "¥1,235"
+// Currencies without a known symbol are shown with their code, e.g. "CHF 1,234.50"
+func (m Money) String() string {
+	sign := ""
+	if m.Amount < 0 {
+		sign = "-"
+	}
+	whole, fraction, _ := strings.Cut(strings.TrimPrefix(m.Decimal(), "-"), ".")
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
+	}
+	number := grouped.String()
+	if fraction != "" {
+		number += "." + fraction
+	}
+	if symbol, ok := Symbol(m.Currency); ok {
+		return sign + symbol + number
+	}
+	if m.Currency == "" {
+		return sign + number
+	}
+	return sign + m.Currency + " " + number
+}
+
+// IsZero, IsPositive and IsNegative test the sign of the amount
+func (m Money) IsZero() bool     { return m.Amount == 0 }
+func (m Money) IsPositive() bool { return m.Amount > 0 }
+func (m Money) IsNegative() bool { return m.Amount < 0 }
+
+// Neg returns the amount with its sign flipped
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// Add returns m + o
+func (m Money) Add(o Money) (Money, error) {
+	if err := Same(m.Currency, o.Currency); err != nil {
+		return m, err
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns m - o
+func (m Money) Sub(o Money) (Money, error) {
+	return m.Add(o.Neg())
+}
+
+// Cmp returns -1, 0 or 1 as m is less than, equal to or greater than o
+func (m Money) Cmp(o Money) (int, error) {
+	if err := Same(m.Currency, o.Currency); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.Amount < o.Amount:
+		return -1, nil
+	case m.Amount > o.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Less reports whether m is less than o
+func (m Money) Less(o Money) (bool, error) {
+	cmp, err := m.Cmp(o)
+	return cmp < 0, err
+}
+
+// Min returns the smaller of m and o
+func (m Money) Min(o Money) (Money, error) {
+	less, err := o.Less(m)
+	if less {
+		return o, err
+	}
+	return m, err
+}
+
+// Mul scales the amount by a factor such as a rate, rounding half away from zero to the minor unit
+func (m Money) Mul(factor float64) Money {
+	return Money{Amount: int64(math.Round(float64(m.Amount) * factor)), Currency: m.Currency}
+}
+
+// Percent returns percent of the amount, rounded to the minor unit
+func (m Money) Percent(percent float64) Money {
+	return m.Mul(percent / 100)
+}
+
+// Allocate divides the amount in proportion to weights, e.g. basis points of each party's share
+// Each part is rounded toward zero, and the minor units left over go one each to the parts that lost the most to
+// rounding, earlier parts first on a tie, so the parts always add up to the amount and the same inputs always give
+// the same parts
+func (m Money) Allocate(weights ...int64) ([]Money, error) {
+	total := new(big.Int)
+	for _, weight := range weights {
+		if weight < 0 {
+			return nil, ErrWeights
+		}
+		total.Add(total, big.NewInt(weight))
+	}
+	if total.Sign() == 0 {
+		return nil, ErrWeights
+	}
+
+	amount := new(big.Int).SetUint64(abs(m.Amount))
+	parts := make([]Money, len(weights))
+	remainders := make([]*big.Int, len(weights))
+	allocated := new(big.Int)
+	for i, weight := range weights {
+		share, remainder := new(big.Int).QuoRem(new(big.Int).Mul(amount, big.NewInt(weight)), total, new(big.Int))
+		parts[i] = Money{Amount: share.Int64(), Currency: m.Currency}
+		remainders[i] = remainder
+		allocated.Add(allocated, share)
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]].Cmp(remainders[order[b]]) > 0 })
+	left := new(big.Int).Sub(amount, allocated).Int64()
+	for i := int64(0); i < left; i++ {
+		parts[order[i]].Amount++
+	}
+	if m.Amount < 0 {
+		for i := range parts {
+			parts[i] = parts[i].Neg()
+		}
+	}
+	return parts, nil
+}
+
+// Split divides the amount into n parts as even as the minor unit allows, the larger parts first
+func (m Money) Split(n int) ([]Money, error) {
+	if n <= 0 {
+		return nil, ErrWeights
+	}
+	weights := make([]int64, n)
+	for i := range weights {
+		weights[i] = 1
+	}
+	return m.Allocate(weights...)
+}
+
+// jsonMoney is the wire form: the amount as an exact decimal string beside its currency
+type jsonMoney struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// MarshalJSON writes {"amount": "1234.50", "currency": "USD"}
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonMoney{Amount: m.Decimal(), Currency: m.Currency})
+}
+
+// UnmarshalJSON reads the amount as a decimal string or a JSON number, refusing more decimal places than the
+// currency's minor unit
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Amount   json.RawMessage `json:"amount"`
+		Currency string          `json:"currency"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	amount := strings.Trim(string(raw.Amount), `"`)
+	parsed, err := Parse(amount, raw.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Value stores the amount in one text column as "USD 1234.50"
+func (m Money) Value() (driver.Value, error) {
+	return m.Currency + " " + m.Decimal(), nil
+}
+
+// Scan reads an amount stored by Value
+func (m *Money) Scan(value interface{}) error {
+	var text string
+	switch v := value.(type) {
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	case nil:
+		*m = Money{}
+		return nil
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalidAmount, value)
+	}
+	code, amount, ok := strings.Cut(text, " ")
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrInvalidAmount, text)
+	}
+	parsed, err := Parse(amount, code)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// scale is the number of minor units in one major unit of a currency
+func scale(code string) float64 {
+	return math.Pow10(Exponent(code))
+}
+
+// abs returns the magnitude of minor units, which for math.MinInt64 does not fit an int64
+func abs(minor int64) uint64 {
+	if minor < 0 {
+		return uint64(-(minor + 1)) + 1
+	}
+	return uint64(minor)
+}
+
+// digits reports whether s is empty or only ASCII digits
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package money
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func amounts(parts []Money) []int64 {
+	out := make([]int64, len(parts))
+	for i, part := range parts {
+		out[i] = part.Amount
+	}
+	return out
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  int64
+		weights []int64
+		want    []int64
+	}{
+		{"even", 300, []int64{1, 1, 1}, []int64{100, 100, 100}},
+		{"remainder to the largest losses", 100, []int64{1, 1, 1}, []int64{34, 33, 33}},
+		{"remainder goes earlier parts first on a tie", 200, []int64{1, 1, 1}, []int64{67, 67, 66}},
+		{"remainder by loss, not by position", 1000, []int64{3333, 3333, 3334}, []int64{333, 333, 334}},
+		{"basis points", 10001, []int64{6000, 4000}, []int64{6001, 4000}},
+		{"zero weight gets nothing", 101, []int64{1, 0, 1}, []int64{51, 0, 50}},
+		{"negative amount mirrors the positive one", -100, []int64{1, 1, 1}, []int64{-34, -33, -33}},
+		{"negative remainder by loss", -10001, []int64{6000, 4000}, []int64{-6001, -4000}},
+		{"zero amount", 0, []int64{5, 3, 2}, []int64{0, 0, 0}},
+		{"one minor unit among many", 1, []int64{1, 1, 1, 1}, []int64{1, 0, 0, 0}},
+		{"single part", -12345, []int64{7}, []int64{-12345}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, err := New(tt.amount, "USD").Allocate(tt.weights...)
+			if err != nil {
+				t.Fatalf("Allocate: %v", err)
+			}
+			if got := amounts(parts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Allocate(%d, %v) = %v, want %v", tt.amount, tt.weights, got, tt.want)
+			}
+			for _, part := range parts {
+				if part.Currency != "USD" {
+					t.Errorf("part in %q, want USD", part.Currency)
+				}
+			}
+		})
+	}
+}
+
+func TestAllocateWeights(t *testing.T) {
+	for _, weights := range [][]int64{nil, {0}, {0, 0}, {1, -1}, {-1}} {
+		if _, err := New(100, "USD").Allocate(weights...); !errors.Is(err, ErrWeights) {
+			t.Errorf("Allocate(%v) error = %v, want ErrWeights", weights, err)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		amount int64
+		n      int
+		want   []int64
+	}{
+		{100, 3, []int64{34, 33, 33}},
+		{-100, 3, []int64{-34, -33, -33}},
+		{0, 2, []int64{0, 0}},
+		{5, 5, []int64{1, 1, 1, 1, 1}},
+		{2, 3, []int64{1, 1, 0}},
+		{7, 1, []int64{7}},
+	}
+	for _, tt := range tests {
+		parts, err := New(tt.amount, "JPY").Split(tt.n)
+		if err != nil {
+			t.Fatalf("Split(%d, %d): %v", tt.amount, tt.n, err)
+		}
+		if got := amounts(parts); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Split(%d, %d) = %v, want %v", tt.amount, tt.n, got, tt.want)
+		}
+	}
+	for _, n := range []int{0, -1} {
+		if _, err := New(100, "USD").Split(n); !errors.Is(err, ErrWeights) {
+			t.Errorf("Split(%d) error = %v, want ErrWeights", n, err)
+		}
+	}
+}
+
+// FuzzAllocate checks that the parts add up to the amount, keep its sign, are each within one minor unit of the
+// exact share, and come out the same every time
+func FuzzAllocate(f *testing.F) {
+	f.Add(int64(100), int64(1), int64(1), int64(1))
+	f.Add(int64(-10001), int64(6000), int64(4000), int64(0))
+	f.Add(int64(0), int64(5), int64(3), int64(2))
+	f.Add(int64(1), int64(1), int64(1), int64(1))
+	f.Add(int64(999999999999), int64(3333), int64(3333), int64(3334))
+	f.Fuzz(func(t *testing.T, amount, a, b, c int64) {
+		weights := []int64{a, b, c}
+		parts, err := New(amount, "USD").Allocate(weights...)
+		if a < 0 || b < 0 || c < 0 || a == 0 && b == 0 && c == 0 {
+			if !errors.Is(err, ErrWeights) {
+				t.Fatalf("Allocate(%d, %v) error = %v, want ErrWeights", amount, weights, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("Allocate(%d, %v): %v", amount, weights, err)
+		}
+
+		total := new(big.Int)
+		for _, weight := range weights {
+			total.Add(total, big.NewInt(weight))
+		}
+		sum := new(big.Int)
+		for i, part := range parts {
+			sum.Add(sum, big.NewInt(part.Amount))
+			if amount > 0 && part.Amount < 0 || amount < 0 && part.Amount > 0 || weights[i] == 0 && part.Amount != 0 {
+				t.Fatalf("Allocate(%d, %v) part %d = %d", amount, weights, i, part.Amount)
+			}
+			// |part × total − amount × weight| < total
+			diff := new(big.Int).Sub(new(big.Int).Mul(big.NewInt(part.Amount), total),
+				new(big.Int).Mul(big.NewInt(amount), big.NewInt(weights[i])))
+			if diff.Abs(diff).Cmp(total) >= 0 {
+				t.Fatalf("Allocate(%d, %v) part %d = %d, more than one minor unit off its share", amount, weights, i, part.Amount)
+			}
+		}
+		if sum.Cmp(big.NewInt(amount)) != 0 {
+			t.Fatalf("Allocate(%d, %v) = %v, adds up to %v", amount, weights, amounts(parts), sum)
+		}
+
+		again, _ := New(amount, "USD").Allocate(weights...)
+		if !reflect.DeepEqual(parts, again) {
+			t.Fatalf("Allocate(%d, %v) = %v, then %v", amount, weights, amounts(parts), amounts(again))
+		}
+	})
+}
+
+func TestCents(t *testing.T) {
+	tests := []struct {
+		amount float64
+		want   float64
+	}{
+		{0.1 + 0.2, 0.3},
+		{19.999, 20},
+		{-2.675, -2.68},
+		{1234.5, 1234.5},
+		{-0.004, 0},
+	}
+	for _, tt := range tests {
+		if got := Cents(tt.amount); got != tt.want {
+			t.Errorf("Cents(%v) = %v, want %v", tt.amount, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package notifications
+
+import (
+	"banking-app/clock"
+	"banking-app/communications"
+	"banking-app/models"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MaxAttempts bounds delivery retries before a notification is marked failed
+const MaxAttempts = 5
+
+// Sender delivers a single notification over one channel
+// Implementations must be safe for concurrent use
+type Sender interface {
+	Send(n models.Notification) error
+}
+
+// EmailSender delivers notifications by SMTP when SMTP_HOST is configured
+// Without SMTP configuration messages are logged, which keeps development setups working
+type EmailSender struct {
+	Host string
+	Port string
+	From string
+}
+
+// Send delivers a notification as a plain-text email
+func (s EmailSender) Send(n models.Notification) error {
+	if s.Host == "" {
+		log.Printf("[email] to=%s subject=%q body=%q", n.Recipient, n.Subject, n.Body)
+		return nil
+	}
+
+	msg := "From: " + s.From + "\r\n" +
+		"To: " + n.Recipient + "\r\n" +
+		"Subject: " + n.Subject + "\r\n\r\n" +
+		n.Body + "\r\n"
+	return smtp.SendMail(s.Host+":"+s.Port, nil, s.From, []string{n.Recipient}, []byte(msg))
+}
+
+// WebhookSender delivers notifications as JSON POST requests
+type WebhookSender struct {
+	Client *http.Client
+}
+
+// Send posts the notification payload to the recipient URL
+func (s WebhookSender) Send(n models.Notification) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":          n.ID,
+		"customer_id": n.CustomerID,
+		"subject":     n.Subject,
+		"body":        n.Body,
+		"created_at":  n.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.Client.Post(n.Recipient, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// DefaultSenders builds the channel → sender map from environment configuration
+func DefaultSenders() map[string]Sender {
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "25"
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "no-reply@banking-app.local"
+	}
+
+	return map[string]Sender{
+		"email":   EmailSender{Host: os.Getenv("SMTP_HOST"), Port: port, From: from},
+		"webhook": WebhookSender{Client: &http.Client{Timeout: 10 * time.Second}},
+	}
+}
+
+// Enqueue stores a notification for asynchronous delivery
+// Accepts a transaction handle so callers can queue inside their own unit of work
+func Enqueue(db *gorm.DB, n *models.Notification) error {
+	n.Status = "pending"
+	n.Attempts = 0
+	return db.Create(n).Error
+}
+
+// Dispatcher delivers pending notifications through the configured senders
+// Each sent or finally failed notification to a customer is added to their communication log
+type Dispatcher struct {
+	DB      *gorm.DB
+	Senders map[string]Sender
+	Storage communications.Storage // Where logged message bodies are kept
+}
+
+// NewDispatcher creates a dispatcher using the environment-configured senders
+func NewDispatcher(db *gorm.DB, storage communications.Storage) *Dispatcher {
+	return &Dispatcher{DB: db, Senders: DefaultSenders(), Storage: storage}
+}
+
+// Start polls for pending notifications at the given interval until stop is closed
+func (d *Dispatcher) Start(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.DispatchPending()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// DispatchPending attempts delivery of every pending notification once
+func (d *Dispatcher) DispatchPending() {
+	var pending []models.Notification
+	if err := d.DB.Where("status = ?", "pending").Order("id").Limit(100).Find(&pending).Error; err != nil {
+		log.Printf("notifications: failed to load pending: %v", err)
+		return
+	}
+
+	for _, n := range pending {
+		d.deliver(n)
+	}
+}
+
+// deliver sends one notification and records the outcome
+func (d *Dispatcher) deliver(n models.Notification) {
+	updates := map[string]interface{}{"attempts": n.Attempts + 1}
+
+	sender, ok := d.Senders[n.Channel]
+	var err error
+	if !ok {
+		err = fmt.Errorf("unsupported channel %q", n.Channel)
+	} else {
+		err = sender.Send(n)
+	}
+
+	if err == nil {
+		now := clock.Now()
+		updates["status"] = "sent"
+		updates["sent_at"] = &now
+		updates["last_error"] = ""
+	} else {
+		updates["last_error"] = err.Error()
+		if !ok || n.Attempts+1 >= MaxAttempts {
+			updates["status"] = "failed"
+		}
+	}
+
+	if err := d.DB.Model(&models.Notification{}).Where("id = ?", n.ID).Updates(updates).Error; err != nil {
+		log.Printf("notifications: failed to record delivery of %d: %v", n.ID, err)
+		return
+	}
+
+	// Staff notifications, such as subscribed reports, concern no customer and stay out of the communication log
+	status, _ := updates["status"].(string)
+	if (status != "sent" && status != "failed") || n.CustomerID == 0 {
+		return
+	}
+	var sendErr string
+	if err != nil {
+		sendErr = err.Error()
+	}
+	if _, err := communications.RecordNotification(d.DB, d.Storage, n, status, sendErr); err != nil {
+		log.Printf("notifications: failed to log delivery of %d: %v", n.ID, err)
+	}
+}
@@ -0,0 +1,261 @@
+package oauth
+
+import (
+	"banking-app/auth"
+	"banking-app/consent"
+	"banking-app/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Grant types the token endpoint accepts
+const (
+	GrantClientCredentials = "client_credentials" // The client acting for itself
+	GrantAuthorizationCode = "authorization_code" // The client acting for a customer who approved it
+)
+
+// Token roles - customer tokens reach one customer's consented data, client tokens only service scopes
+const (
+	RoleCustomer = "customer"
+	RoleClient   = "client"
+)
+
+// CodeTTL is how long an authorization code can be exchanged
+const CodeTTL = 10 * time.Minute
+
+// Error is an OAuth2 error response (RFC 6749 section 5.2)
+type Error struct {
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string { return e.Code + ": " + e.Description }
+
+// Errors returned by the grants; handlers send them in the OAuth2 error format
+var (
+	ErrInvalidClient = &Error{"invalid_client", "client authentication failed"}
+	ErrInvalidGrant  = &Error{"invalid_grant", "the authorization code is invalid, expired, already used or was issued for another redirect_uri"}
+	ErrRedirectURI   = &Error{"invalid_request", "redirect_uri is not registered for this client"}
+	ErrNoCustomer    = &Error{"access_denied", "only a customer user linked to a customer can approve a client"}
+	ErrConsentEnded  = &Error{"invalid_grant", "the customer's consent has been revoked or has expired"}
+)
+
+// Config holds token settings
+type Config struct {
+	TokenTTL time.Duration // Access token lifetime; a customer token never outlives its consent
+}
+
+// ConfigFromEnv reads OAUTH_TOKEN_TTL_MINUTES (default 60)
+func ConfigFromEnv() Config {
+	cfg := Config{TokenTTL: time.Hour}
+	if raw := os.Getenv("OAUTH_TOKEN_TTL_MINUTES"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			cfg.TokenTTL = time.Duration(n) * time.Minute
+		} else {
+			log.Printf("oauth: ignoring invalid OAUTH_TOKEN_TTL_MINUTES %q", raw)
+		}
+	}
+	return cfg
+}
+
+// AllScopes lists every scope a client can be registered for
+func AllScopes() []string {
+	return append(append([]string{}, consent.Scopes...), consent.ServiceScopes...)
+}
+
+// RegisterClient creates a client and returns it with its secret, which is not stored and cannot be shown again
+func RegisterClient(db *gorm.DB, name, redirectURIs, scopes, by string) (models.OAuthClient, string, error) {
+	client := models.OAuthClient{Name: strings.TrimSpace(name), CreatedBy: by}
+	if client.Name == "" {
+		return client, "", errors.New("name is required")
+	}
+	var err error
+	if client.Scopes, err = consent.ParseScopes(scopes, AllScopes()); err != nil {
+		return client, "", err
+	}
+	var uris []string
+	for _, uri := range strings.Split(redirectURIs, ",") {
+		if uri = strings.TrimSpace(uri); uri == "" {
+			continue
+		}
+		if parsed, err := url.Parse(uri); err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+			return client, "", errors.New("redirect URIs must be absolute URLs without a fragment: " + uri)
+		}
+		uris = append(uris, uri)
+	}
+	client.RedirectURIs = strings.Join(uris, ",")
+	if len(uris) == 0 && hasCustomerScope(client.Scopes) {
+		return client, "", errors.New("a redirect URI is required for customer scopes")
+	}
+
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return client, "", err
+	}
+	client.ClientID = "cli_" + hex.EncodeToString(id)
+	secret, err := auth.GenerateSecret()
+	if err != nil {
+		return client, "", err
+	}
+	if client.SecretHash, err = auth.HashPassword(secret); err != nil {
+		return client, "", err
+	}
+	return client, secret, db.Create(&client).Error
+}
+
+// hasCustomerScope reports whether a comma-separated scope list includes a scope needing customer consent
+func hasCustomerScope(scopes string) bool {
+	for _, scope := range strings.Split(scopes, ",") {
+		if consent.Contains(consent.Scopes, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthenticateClient checks a client's credentials
+func AuthenticateClient(db *gorm.DB, clientID, secret string) (models.OAuthClient, error) {
+	var client models.OAuthClient
+	if err := db.Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		return client, ErrInvalidClient
+	}
+	if bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(secret)) != nil {
+		return client, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// FindClient returns a registered client by its identifier
+func FindClient(db *gorm.DB, clientID string) (models.OAuthClient, error) {
+	var client models.OAuthClient
+	err := db.Where("client_id = ?", clientID).First(&client).Error
+	return client, err
+}
+
+// RequestScopes validates the scopes a client asks for against those it is registered for and kind allows
+// (consent.Scopes or consent.ServiceScopes). An empty request means every registered scope of that kind.
+// The result is comma-separated
+func RequestScopes(client models.OAuthClient, requested string, kind []string) (string, error) {
+	var allowed []string
+	for _, scope := range strings.Split(client.Scopes, ",") {
+		if consent.Contains(kind, scope) {
+			allowed = append(allowed, scope)
+		}
+	}
+	if len(allowed) == 0 {
+		return "", &Error{"unauthorized_client", "the client is not registered for this grant's scopes"}
+	}
+	if strings.TrimSpace(requested) == "" {
+		requested = strings.Join(allowed, ",")
+	}
+	scopes, err := consent.ParseScopes(requested, allowed)
+	if err != nil {
+		return "", &Error{"invalid_scope", err.Error()}
+	}
+	return scopes, nil
+}
+
+// RedirectURI returns the redirect URI to use: the requested one if registered, or the only registered one
+func RedirectURI(client models.OAuthClient, requested string) (string, error) {
+	registered := strings.Split(client.RedirectURIs, ",")
+	if requested == "" && len(registered) == 1 && registered[0] != "" {
+		return registered[0], nil
+	}
+	for _, uri := range registered {
+		if uri != "" && uri == requested {
+			return uri, nil
+		}
+	}
+	return "", ErrRedirectURI
+}
+
+// Approve records a customer's consent to a client and issues a one-time code for it
+// Returns the code, which is only stored hashed
+func Approve(db *gorm.DB, client models.OAuthClient, user models.User, redirectURI, scopes string, now time.Time) (string, models.Consent, error) {
+	var granted models.Consent
+	if user.Role != RoleCustomer || user.CustomerID == nil {
+		return "", granted, ErrNoCustomer
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", granted, err
+	}
+	code := base64.RawURLEncoding.EncodeToString(raw)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		granted = models.Consent{
+			CustomerID: *user.CustomerID,
+			GrantedTo:  client.ClientID,
+			Scopes:     scopes,
+			GrantedAt:  now,
+			GrantedBy:  user.Username,
+			ExpiresAt:  now.Add(consent.MaxDuration),
+		}
+		if err := tx.Create(&granted).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.OAuthCode{
+			CodeHash:    hashCode(code),
+			ClientID:    client.ClientID,
+			CustomerID:  granted.CustomerID,
+			ConsentID:   granted.ID,
+			RedirectURI: redirectURI,
+			Scopes:      scopes,
+			ExpiresAt:   now.Add(CodeTTL),
+		}).Error
+	})
+	return code, granted, err
+}
+
+// Exchange redeems an authorization code for the client it was issued to, once
+// It returns the code's record and the consent behind it, which must still be active
+func Exchange(db *gorm.DB, client models.OAuthClient, code, redirectURI string, now time.Time) (models.OAuthCode, models.Consent, error) {
+	var issued models.OAuthCode
+	var granted models.Consent
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("code_hash = ?", hashCode(code)).First(&issued).Error; err != nil {
+			return ErrInvalidGrant
+		}
+		if issued.ClientID != client.ClientID || issued.RedirectURI != redirectURI || !now.Before(issued.ExpiresAt) {
+			return ErrInvalidGrant
+		}
+		used := tx.Model(&issued).Where("used_at IS NULL").Update("used_at", now)
+		if used.Error != nil {
+			return used.Error
+		}
+		if used.RowsAffected == 0 {
+			return ErrInvalidGrant
+		}
+		if err := tx.First(&granted, issued.ConsentID).Error; err != nil {
+			return err
+		}
+		if consent.Status(granted, now) != consent.StatusActive {
+			return ErrConsentEnded
+		}
+		return nil
+	})
+	return issued, granted, err
+}
+
+// hashCode is the stored form of an authorization code
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// TokenScope converts a comma-separated scope list to the space-separated form used in tokens and responses
+func TokenScope(scopes string) string {
+	return strings.ReplaceAll(scopes, ",", " ")
+}
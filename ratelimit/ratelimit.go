@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sweepAt is the number of callers tracked before those idle for a whole window are dropped
+const sweepAt = 10000
+
+// Limiter limits how many requests each caller makes per minute on this instance
+type Limiter struct {
+	mu        sync.Mutex
+	perMinute int
+	recent    map[string][]time.Time // Each caller's requests within the last minute, oldest first
+}
+
+// New returns a limiter allowing perMinute requests per caller in any minute
+func New(perMinute int) *Limiter {
+	return &Limiter{perMinute: perMinute, recent: make(map[string][]time.Time)}
+}
+
+// Allow counts a request by a caller, or refuses it with how long until the caller's oldest request leaves the window
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	window := now.Add(-time.Minute)
+	if len(l.recent) >= sweepAt {
+		for k, recent := range l.recent {
+			if !recent[len(recent)-1].After(window) {
+				delete(l.recent, k)
+			}
+		}
+	}
+	recent := l.recent[key]
+	for len(recent) > 0 && !recent[0].After(window) {
+		recent = recent[1:]
+	}
+	if len(recent) >= l.perMinute {
+		l.recent[key] = recent
+		return false, recent[0].Sub(window)
+	}
+	l.recent[key] = append(recent, now)
+	return true, 0
+}
+
+// PerMinuteFromEnv reads a per-minute limit from the environment variable name, or fallback when it is unset or
+// not a positive number
+func PerMinuteFromEnv(name string, fallback int) int {
+	perMinute, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || perMinute <= 0 {
+		return fallback
+	}
+	return perMinute
+}
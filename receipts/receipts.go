@@ -0,0 +1,370 @@
+package receipts
+
+import (
+	"banking-app/archive"
+	"banking-app/clock"
+	"banking-app/display"
+	"banking-app/documents"
+	"banking-app/gl"
+	"banking-app/i18n"
+	"banking-app/ledger"
+	"banking-app/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Algorithm names the hash that chains each account's transactions
+const Algorithm = "SHA-256"
+
+// Hash chains a transaction to the hash of the previous transaction on its account
+// Only fields fixed at posting are covered; enrichment (merchant, category, channel) is excluded because it is
+// backfilled later, as is the descriptor. The memo is appended only when present, so transactions posted before
+// memos were recorded keep their hashes. The first transaction on an account chains to the empty string
+func Hash(previous string, t models.Transaction) string {
+	optional := func(id *uint) string {
+		if id == nil {
+			return ""
+		}
+		return strconv.FormatUint(uint64(*id), 10)
+	}
+	fields := []string{
+		previous,
+		t.TransactionID,
+		strconv.FormatUint(uint64(t.AccountID), 10),
+		t.TransactionType,
+		strconv.FormatFloat(t.Amount, 'f', 2, 64),
+		strconv.FormatFloat(t.BalanceBefore, 'f', 2, 64),
+		strconv.FormatFloat(t.BalanceAfter, 'f', 2, 64),
+		t.EffectiveDate.UTC().Format(time.RFC3339Nano),
+		t.CreatedAt.UTC().Format(time.RFC3339Nano),
+		t.Description,
+		t.Reference,
+		optional(t.ReversalOfID),
+		optional(t.OffsetOfID),
+	}
+	if t.Memo != "" {
+		fields = append(fields, t.Memo)
+	}
+	payload := strings.Join(fields, "|")
+	sum := sha256.Sum256([]byte(payload))
+	return hex.EncodeToString(sum[:])
+}
+
+// RegisterCallbacks hashes every transaction as it is created, whichever code path posts it
+func RegisterCallbacks(db *gorm.DB) error {
+	return db.Callback().Create().Before("gorm:create").Register("receipts:hash", hashOnCreate)
+}
+
+// hashOnCreate stamps new transaction rows with their chained hash
+// The previous hash is read inside the creating database transaction, where the posting already holds
+// the write lock, so concurrent postings on an account cannot chain to the same predecessor
+func hashOnCreate(tx *gorm.DB) {
+	if tx.Error != nil || tx.Statement.Schema == nil || tx.Statement.Schema.Table != "transactions" {
+		return
+	}
+
+	heads := map[uint]string{} // Accounts chained earlier in the same batch
+	stamp := func(t *models.Transaction) {
+		if t.CreatedAt.IsZero() {
+			t.CreatedAt = clock.Now() // The hash covers the creation time, so fix it before gorm does
+		}
+		previous, ok := heads[t.AccountID]
+		if !ok {
+			var err error
+			if previous, err = head(tx.Session(&gorm.Session{NewDB: true}), t.AccountID); err != nil {
+				tx.AddError(err)
+				return
+			}
+		}
+		t.Hash = Hash(previous, *t)
+		heads[t.AccountID] = t.Hash
+	}
+
+	switch value := tx.Statement.ReflectValue; value.Kind() {
+	case reflect.Struct:
+		if t, ok := value.Addr().Interface().(*models.Transaction); ok {
+			stamp(t)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if t, ok := reflect.Indirect(value.Index(i)).Addr().Interface().(*models.Transaction); ok {
+				stamp(t)
+			}
+		}
+	}
+}
+
+// head returns the hash of the latest transaction on an account, including soft-deleted ones
+func head(db *gorm.DB, accountID uint) (string, error) {
+	var hashes []string
+	err := db.Unscoped().Model(&models.Transaction{}).Where("account_id = ?", accountID).
+		Order("id DESC").Limit(1).Pluck("hash", &hashes).Error
+	if err != nil || len(hashes) == 0 {
+		return "", err
+	}
+	return hashes[0], nil
+}
+
+// Backfill hashes transactions recorded before hashing existed, account by account in posting order
+// Rows that already carry a hash keep it; it returns the number of rows hashed
+func Backfill(db *gorm.DB) (int, error) {
+	var accountIDs []uint
+	err := db.Unscoped().Model(&models.Transaction{}).Where("hash = '' OR hash IS NULL").
+		Distinct().Pluck("account_id", &accountIDs).Error
+	if err != nil {
+		return 0, err
+	}
+
+	hashed := 0
+	for _, accountID := range accountIDs {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			previous := ""
+			var batch []models.Transaction
+			return tx.Unscoped().Where("account_id = ?", accountID).FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+				for _, t := range batch {
+					if t.Hash == "" {
+						t.Hash = Hash(previous, t)
+						if err := tx.Model(&models.Transaction{}).Where("id = ?", t.ID).UpdateColumn("hash", t.Hash).Error; err != nil {
+							return err
+						}
+						hashed++
+					}
+					previous = t.Hash
+				}
+				return nil
+			}).Error
+		})
+		if err != nil {
+			return hashed, err
+		}
+	}
+	return hashed, nil
+}
+
+// Break describes the first transaction whose stored hash does not match its recomputed one
+// A deleted or reordered row shows up as a break at the next row
+type Break struct {
+	ID            uint   `json:"id"`             // Transaction row
+	TransactionID string `json:"transaction_id"` // System transaction identifier
+	Position      int    `json:"position"`       // 1-based place in the account's chain
+	Reason        string `json:"reason"`         // missing_hash or hash_mismatch
+	Expected      string `json:"expected_hash"`  // Recomputed from the row and its predecessor
+	Stored        string `json:"stored_hash"`    // Hash recorded on the row
+}
+
+// ChainReport is the outcome of walking an account's hash chain
+type ChainReport struct {
+	AccountID  uint   `json:"account_id"`
+	Algorithm  string `json:"algorithm"`
+	Checked    int    `json:"checked"`     // Rows walked, up to and including the first break
+	Valid      bool   `json:"valid"`       // No break was found
+	HeadHash   string `json:"head_hash"`   // Hash of the latest transaction when the chain is valid
+	FirstBreak *Break `json:"first_break"` // Nil when the chain is valid
+}
+
+// Verify walks an account's transactions in posting order and reports the first break in the chain
+// Archived postings are walked with the rest, since the chain runs through them
+func Verify(db *gorm.DB, accountID uint) (ChainReport, error) {
+	report := ChainReport{AccountID: accountID, Algorithm: Algorithm, Valid: true}
+	previous := ""
+	var batch []models.Transaction
+	err := archive.Transactions(db).Unscoped().Where("account_id = ?", accountID).FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for _, t := range batch {
+			report.Checked++
+			expected := Hash(previous, t)
+			if t.Hash != expected {
+				reason := "hash_mismatch"
+				if t.Hash == "" {
+					reason = "missing_hash"
+				}
+				report.Valid = false
+				report.FirstBreak = &Break{ID: t.ID, TransactionID: t.TransactionID, Position: report.Checked,
+					Reason: reason, Expected: expected, Stored: t.Hash}
+				return errStop
+			}
+			previous = t.Hash
+		}
+		return nil
+	}).Error
+	if err != nil && err != errStop {
+		return report, err
+	}
+	if report.Valid {
+		report.HeadHash = previous
+	}
+	return report, nil
+}
+
+// errStop ends a chain walk at the first break
+var errStop = errors.New("chain break found")
+
+// Party is one side of a receipted transaction; customer account numbers are always masked
+type Party struct {
+	AccountNumber string `json:"account_number"`
+	AccountType   string `json:"account_type"`
+	Name          string `json:"name,omitempty"` // Holder of the receipt's own account, or the internal account for bank-side entries
+}
+
+// Receipt is the customer-facing proof that a transaction was posted
+type Receipt struct {
+	ReceiptNumber   string    `json:"receipt_number"`
+	ID              uint      `json:"id"`
+	TransactionID   string    `json:"transaction_id"`
+	TransactionType string    `json:"transaction_type"`
+	Direction       string    `json:"direction"` // credit or debit, from the account's point of view
+	Amount          float64   `json:"amount"`
+	Currency        string    `json:"currency"`
+	Description     string    `json:"description"`
+	Descriptor      string    `json:"descriptor"`
+	Memo            string    `json:"memo,omitempty"`
+	Reference       string    `json:"reference"`
+	ReversalOfID    *uint     `json:"reversal_of_id,omitempty"`
+	EffectiveDate   time.Time `json:"effective_date"`
+	PostedAt        time.Time `json:"posted_at"`
+	Account         Party     `json:"account"`
+	Counterparty    *Party    `json:"counterparty"` // Nil when no counter-entry was recorded
+	Hash            string    `json:"hash"`
+	PreviousHash    string    `json:"previous_hash"`
+	HashAlgorithm   string    `json:"hash_algorithm"`
+	GeneratedAt     time.Time `json:"generated_at"`
+}
+
+// Build assembles the receipt of a transaction
+// The counterparty is the other leg of a transfer, or the ledger entry that offsets the posting
+func Build(db *gorm.DB, t models.Transaction) (Receipt, error) {
+	var account models.Account
+	if err := db.Unscoped().Preload("Customer").First(&account, t.AccountID).Error; err != nil {
+		return Receipt{}, err
+	}
+
+	receipt := Receipt{
+		ReceiptNumber:   "RCPT-" + t.TransactionID,
+		ID:              t.ID,
+		TransactionID:   t.TransactionID,
+		TransactionType: t.TransactionType,
+		Direction:       "debit",
+		Amount:          t.Amount,
+		Currency:        account.Currency,
+		Description:     t.Description,
+		Descriptor:      t.Descriptor,
+		Memo:            t.Memo,
+		Reference:       t.Reference,
+		ReversalOfID:    t.ReversalOfID,
+		EffectiveDate:   t.EffectiveDate,
+		PostedAt:        t.CreatedAt,
+		Account:         party(account),
+		Hash:            t.Hash,
+		HashAlgorithm:   Algorithm,
+		GeneratedAt:     clock.Now().UTC(),
+	}
+	if ledger.IsCredit(t.TransactionType) {
+		receipt.Direction = "credit"
+	}
+	if account.AccountType != gl.AccountType {
+		receipt.Account.Name = strings.TrimSpace(account.Customer.FirstName + " " + account.Customer.LastName)
+	}
+
+	var previous []string
+	err := archive.Transactions(db).Unscoped().Where("account_id = ? AND id < ?", t.AccountID, t.ID).
+		Order("id DESC").Limit(1).Pluck("hash", &previous).Error
+	if err != nil {
+		return receipt, err
+	}
+	if len(previous) > 0 {
+		receipt.PreviousHash = previous[0]
+	}
+
+	counterpartyID, err := counterparty(db, t)
+	if err != nil {
+		return receipt, err
+	}
+	if counterpartyID != 0 {
+		var other models.Account
+		if err := db.Unscoped().First(&other, counterpartyID).Error; err == nil {
+			p := party(other)
+			receipt.Counterparty = &p
+		}
+	}
+	return receipt, nil
+}
+
+// counterparty finds the account on the other side of a transaction, or 0 if none was recorded
+func counterparty(db *gorm.DB, t models.Transaction) (uint, error) {
+	var transfer models.Transfer
+	err := db.Where("debit_transaction_id = ? OR credit_transaction_id = ?", t.ID, t.ID).First(&transfer).Error
+	if err == nil {
+		if transfer.DebitTransactionID == t.ID {
+			return transfer.ToAccountID, nil
+		}
+		return transfer.FromAccountID, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return 0, err
+	}
+
+	// A ledger entry's counterparty is the posting it offsets, and vice versa
+	var offset models.Transaction
+	query := db.Unscoped().Select("account_id")
+	if t.OffsetOfID != nil {
+		err = query.First(&offset, *t.OffsetOfID).Error
+	} else {
+		err = query.Where("offset_of_id = ?", t.ID).First(&offset).Error
+	}
+	if err == gorm.ErrRecordNotFound {
+		return 0, nil
+	}
+	return offset.AccountID, err
+}
+
+// party describes an account on a receipt; internal ledger accounts are also named by their code
+func party(account models.Account) Party {
+	p := Party{AccountNumber: display.MaskAccountNumber(account.AccountNumber), AccountType: account.AccountType}
+	if account.AccountType == gl.AccountType {
+		p.Name = gl.Code(account.AccountNumber)
+	}
+	return p
+}
+
+// WritePDF renders a receipt as a one-page document, labelled in the given locale
+func WritePDF(w io.Writer, r Receipt, bank string, locale *i18n.Locale) error {
+	t := locale.T
+	pdf := documents.NewPDF(w)
+	pdf.Heading(bank)
+	pdf.Heading(t("receipt.title"))
+	pdf.Text(t("receipt.number", r.ReceiptNumber))
+	pdf.Text("")
+	pdf.Mono(fmt.Sprintf("%-20s %s", t("receipt.transaction"), r.TransactionID))
+	pdf.Mono(fmt.Sprintf("%-20s %s (%s)", t("receipt.type"), r.TransactionType, r.Direction))
+	pdf.Mono(fmt.Sprintf("%-20s %s", t("receipt.amount"), locale.Amount(r.Amount, r.Currency)))
+	pdf.Mono(fmt.Sprintf("%-20s %s", t("receipt.posted"), locale.Timestamp(r.PostedAt)))
+	pdf.Mono(fmt.Sprintf("%-20s %s", t("receipt.effective"), locale.Date(r.EffectiveDate)))
+	if r.Descriptor != "" {
+		pdf.Mono(fmt.Sprintf("%-20s %s", t("receipt.description"), r.Descriptor))
+	} else if r.Description != "" {
+		pdf.Mono(fmt.Sprintf("%-20s %s", t("receipt.description"), r.Description))
+	}
+	if r.Memo != "" {
+		pdf.Mono(fmt.Sprintf("%-20s %s", t("receipt.memo"), r.Memo))
+	}
+	if r.Reference != "" {
+		pdf.Mono(fmt.Sprintf("%-20s %s", t("receipt.reference"), r.Reference))
+	}
+	pdf.Mono(fmt.Sprintf("%-20s %s %s", t("receipt.account"), r.Account.AccountNumber, r.Account.Name))
+	if r.Counterparty != nil {
+		pdf.Mono(fmt.Sprintf("%-20s %s %s", t("receipt.counterparty"), r.Counterparty.AccountNumber, r.Counterparty.Name))
+	}
+	pdf.Heading(t("receipt.integrity"))
+	pdf.Text(t("receipt.chained", r.HashAlgorithm))
+	pdf.Mono(fmt.Sprintf("%-8s %s", t("receipt.hash"), r.Hash))
+	pdf.Mono(fmt.Sprintf("%-8s %s", t("receipt.previous"), r.PreviousHash))
+	return pdf.Close()
+}
@@ -0,0 +1,370 @@
+package reconcile
+
+import (
+	"banking-app/clock"
+	"banking-app/enrichment"
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"banking-app/money"
+	"errors"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Match item statuses
+const (
+	ItemMatched         = "matched"
+	ItemUnmatchedOurs   = "unmatched_ours"
+	ItemUnmatchedTheirs = "unmatched_theirs"
+	ItemAdjusted        = "adjusted"
+)
+
+// Report statuses
+const (
+	ReportOpen       = "open"
+	ReportReconciled = "reconciled"
+)
+
+// AutoMatcher is MatchedBy for pairs found at import
+const AutoMatcher = "auto"
+
+// ToleranceDaysFromEnv reads RECONCILE_TOLERANCE_DAYS (default 2), the date window for matching
+func ToleranceDaysFromEnv() int {
+	n, err := strconv.Atoi(os.Getenv("RECONCILE_TOLERANCE_DAYS"))
+	if err != nil || n < 0 {
+		n = 2
+	}
+	return n
+}
+
+// Statement reconciliation errors - handlers map these to client responses
+var (
+	ErrNotAssetAccount = errors.New("statements reconcile against an internal asset account such as cash")
+	ErrCurrency        = errors.New("statement currency does not match the account")
+	ErrItemState       = errors.New("item is not in a state that allows this")
+	ErrMismatch        = errors.New("statement line and posting differ in amount or direction")
+	ErrOffsetCode      = errors.New("offset_code must be another internal account code")
+)
+
+// ImportOptions describe how a statement is matched and recorded
+type ImportOptions struct {
+	ToleranceDays int    // A posting matches a line dated at most this many days apart
+	Format        string // Format the statement was read from
+	Filename      string
+	By            string // Staff user importing it
+}
+
+// posting is a ledger entry on the reconciled account with what matching compares
+type posting struct {
+	models.Transaction
+	direction  string
+	references []string // Its own IDs and those of the customer posting it offsets
+}
+
+// Import matches a statement against the postings on an account and stores the report inside an open transaction
+// A line matches an unmatched posting of the same amount and direction dated within the tolerance, preferring
+// one whose reference agrees and then the closest date. Postings already matched by an earlier report are
+// left out, so overlapping statements do not pair a posting twice
+func Import(tx *gorm.DB, account models.Account, stmt Statement, opts ImportOptions) (models.MatchReport, error) {
+	report := models.MatchReport{
+		TenantID:        account.TenantID,
+		AccountID:       account.ID,
+		StatementID:     stmt.ID,
+		ExternalAccount: stmt.Account,
+		Format:          opts.Format,
+		Filename:        opts.Filename,
+		Currency:        account.Currency,
+		PeriodFrom:      stmt.From,
+		PeriodTo:        stmt.To,
+		ToleranceDays:   opts.ToleranceDays,
+		ImportedBy:      opts.By,
+	}
+	if account.AccountType != gl.AccountType || gl.Kinds[gl.Code(account.AccountNumber)] != gl.KindAsset {
+		return report, ErrNotAssetAccount
+	}
+	if stmt.Currency != "" && stmt.Currency != account.Currency {
+		return report, ErrCurrency
+	}
+
+	tolerance := time.Duration(opts.ToleranceDays) * 24 * time.Hour
+	postings, err := candidates(tx, account.ID, stmt.From.Add(-tolerance), stmt.To.Add(tolerance+24*time.Hour))
+	if err != nil {
+		return report, err
+	}
+
+	lines := append([]Line(nil), stmt.Lines...)
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Date.Before(lines[j].Date) })
+	used := make(map[uint]bool)
+	now := clock.Now()
+	var items []models.MatchItem
+	for _, line := range lines {
+		item := lineItem(line)
+		if best := bestMatch(line, postings, used, opts.ToleranceDays); best != nil {
+			used[best.ID] = true
+			item.Status = ItemMatched
+			item.TransactionID = &best.ID
+			item.MatchedBy = AutoMatcher
+			item.MatchedAt = &now
+		}
+		items = append(items, item)
+	}
+	// Postings inside the statement period that no line accounts for; those in the tolerance margin
+	// belong to the neighbouring statements
+	periodEnd := stmt.To.Add(24 * time.Hour)
+	for i := range postings {
+		p := &postings[i]
+		if used[p.ID] || p.EffectiveDate.Before(stmt.From) || !p.EffectiveDate.Before(periodEnd) {
+			continue
+		}
+		items = append(items, models.MatchItem{Status: ItemUnmatchedOurs, TransactionID: &p.ID})
+	}
+
+	if err := tx.Create(&report).Error; err != nil {
+		return report, err
+	}
+	for i := range items {
+		items[i].TenantID = report.TenantID
+		items[i].MatchReportID = report.ID
+	}
+	if len(items) > 0 {
+		if err := tx.Create(&items).Error; err != nil {
+			return report, err
+		}
+	}
+	return report, refresh(tx, &report)
+}
+
+// lineItem is the unmatched item for a statement line
+func lineItem(line Line) models.MatchItem {
+	date := line.Date
+	return models.MatchItem{
+		Status:              ItemUnmatchedTheirs,
+		ExternalDate:        &date,
+		ExternalAmount:      line.Amount,
+		ExternalDirection:   line.Direction,
+		ExternalReference:   line.Reference,
+		ExternalDescription: line.Description,
+	}
+}
+
+// candidates loads the postings on an account effective in [from, to) that no report has matched or booked
+func candidates(tx *gorm.DB, accountID uint, from, to time.Time) ([]posting, error) {
+	var transactions []models.Transaction
+	err := tx.Where("account_id = ? AND effective_date >= ? AND effective_date < ?", accountID, from, to).
+		Where("id NOT IN (?)", tx.Model(&models.MatchItem{}).Select("transaction_id").
+			Where("transaction_id IS NOT NULL AND status IN ?", []string{ItemMatched, ItemAdjusted})).
+		Where("id NOT IN (?)", tx.Model(&models.MatchItem{}).Select("adjustment_transaction_id").
+			Where("adjustment_transaction_id IS NOT NULL")).
+		Order("effective_date, id").Find(&transactions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var originalIDs []uint
+	for _, t := range transactions {
+		if t.OffsetOfID != nil {
+			originalIDs = append(originalIDs, *t.OffsetOfID)
+		}
+	}
+	originals := make(map[uint]models.Transaction)
+	if len(originalIDs) > 0 {
+		var rows []models.Transaction
+		if err := tx.Select("id, transaction_id, reference").Where("id IN ?", originalIDs).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			originals[row.ID] = row
+		}
+	}
+
+	postings := make([]posting, len(transactions))
+	for i, t := range transactions {
+		p := posting{Transaction: t, direction: direction(t), references: []string{t.TransactionID, t.Reference}}
+		if t.OffsetOfID != nil {
+			original := originals[*t.OffsetOfID]
+			p.references = append(p.references, original.TransactionID, original.Reference)
+		}
+		postings[i] = p
+	}
+	return postings, nil
+}
+
+// direction is the statement direction a posting on an asset account shows up as
+// Money arriving debits the asset, which lowers the stored balance in this ledger's sign convention
+func direction(t models.Transaction) string {
+	if ledger.SignedAmount(t) < 0 {
+		return Credit
+	}
+	return Debit
+}
+
+// bestMatch picks the unused posting a line matches, or nil
+func bestMatch(line Line, postings []posting, used map[uint]bool, toleranceDays int) *posting {
+	var best *posting
+	bestRef, bestDays := false, 0
+	for i := range postings {
+		p := &postings[i]
+		if used[p.ID] || p.direction != line.Direction || money.Cents(p.Amount) != line.Amount {
+			continue
+		}
+		days := daysApart(line.Date, p.EffectiveDate)
+		if days > toleranceDays {
+			continue
+		}
+		ref := referenceMatches(line.Reference, p.references)
+		if best == nil || (ref && !bestRef) || (ref == bestRef && days < bestDays) {
+			best, bestRef, bestDays = p, ref, days
+		}
+	}
+	return best
+}
+
+// daysApart counts whole calendar days between two dates
+func daysApart(a, b time.Time) int {
+	day := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	other := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(math.Abs(day.Sub(other).Hours()) / 24)
+}
+
+// referenceMatches reports whether a statement reference names one of a posting's references
+func referenceMatches(reference string, references []string) bool {
+	if reference == "" {
+		return false
+	}
+	for _, r := range references {
+		if r != "" && r == reference {
+			return true
+		}
+	}
+	return false
+}
+
+// Pair manually matches an unmatched statement line with an unmatched posting of the same report inside an
+// open transaction. The posting's item is folded into the line's
+func Pair(tx *gorm.DB, report *models.MatchReport, lineItemID, postingItemID uint, by string) (models.MatchItem, error) {
+	var line, ours models.MatchItem
+	if err := tx.Where("match_report_id = ?", report.ID).First(&line, lineItemID).Error; err != nil {
+		return line, err
+	}
+	if err := tx.Where("match_report_id = ?", report.ID).First(&ours, postingItemID).Error; err != nil {
+		return line, err
+	}
+	if !lifecycle.Allows(lifecycle.MatchItem, line.Status, ItemMatched) || ours.Status != ItemUnmatchedOurs || ours.TransactionID == nil {
+		return line, ErrItemState
+	}
+	var t models.Transaction
+	if err := tx.First(&t, *ours.TransactionID).Error; err != nil {
+		return line, err
+	}
+	if money.Cents(t.Amount) != line.ExternalAmount || direction(t) != line.ExternalDirection {
+		return line, ErrMismatch
+	}
+
+	now := clock.Now()
+	line.Status = ItemMatched
+	line.TransactionID = ours.TransactionID
+	line.MatchedBy = by
+	line.MatchedAt = &now
+	if err := tx.Save(&line).Error; err != nil {
+		return line, err
+	}
+	if err := tx.Delete(&ours).Error; err != nil {
+		return line, err
+	}
+	return line, refresh(tx, report)
+}
+
+// Adjust books an unmatched statement line on the reconciled account inside an open transaction, offset
+// against another internal account (suspense when offsetCode is empty), e.g. for a charge the correspondent
+// bank took. The entry is effective on the line's date
+func Adjust(tx *gorm.DB, report *models.MatchReport, itemID uint, offsetCode, by string) (models.MatchItem, models.Transaction, error) {
+	var item models.MatchItem
+	var entry models.Transaction
+	if err := tx.Where("match_report_id = ?", report.ID).First(&item, itemID).Error; err != nil {
+		return item, entry, err
+	}
+	if !lifecycle.Allows(lifecycle.MatchItem, item.Status, ItemAdjusted) {
+		return item, entry, ErrItemState
+	}
+	var account models.Account
+	if err := tx.First(&account, report.AccountID).Error; err != nil {
+		return item, entry, err
+	}
+	if offsetCode == "" {
+		offsetCode = gl.Suspense
+	}
+	if _, ok := gl.Kinds[offsetCode]; !ok || offsetCode == gl.Code(account.AccountNumber) {
+		return item, entry, ErrOffsetCode
+	}
+
+	// Money in lowers the asset account's stored balance, as for any cash receipt
+	entry = models.Transaction{
+		AccountID:       account.ID,
+		TransactionType: "deposit",
+		Amount:          item.ExternalAmount,
+		Description:     "Statement reconciliation adjustment",
+		Reference:       item.ExternalReference,
+		Channel:         enrichment.DefaultChannel,
+		EffectiveDate:   *item.ExternalDate,
+	}
+	if item.ExternalDirection == Credit {
+		entry.TransactionType = "withdrawal"
+	}
+	if item.ExternalDescription != "" {
+		entry.Description += ": " + item.ExternalDescription
+	}
+	if _, err := ledger.Post(tx, &entry, nil); err != nil {
+		return item, entry, err
+	}
+	if _, err := ledger.OffsetTo(tx, entry, account, offsetCode, entry.Amount); err != nil {
+		return item, entry, err
+	}
+
+	now := clock.Now()
+	item.Status = ItemAdjusted
+	item.AdjustmentTransactionID = &entry.ID
+	item.MatchedBy = by
+	item.MatchedAt = &now
+	if err := tx.Save(&item).Error; err != nil {
+		return item, entry, err
+	}
+	return item, entry, refresh(tx, report)
+}
+
+// refresh recounts a report's items and marks it reconciled once nothing is unmatched
+func refresh(tx *gorm.DB, report *models.MatchReport) error {
+	var rows []struct {
+		Status string
+		Count  int
+	}
+	if err := tx.Model(&models.MatchItem{}).Select("status, COUNT(*) AS count").
+		Where("match_report_id = ?", report.ID).Group("status").Scan(&rows).Error; err != nil {
+		return err
+	}
+	counts := make(map[string]int)
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	report.Matched = counts[ItemMatched]
+	report.UnmatchedOurs = counts[ItemUnmatchedOurs]
+	report.UnmatchedTheirs = counts[ItemUnmatchedTheirs]
+	report.Adjusted = counts[ItemAdjusted]
+	report.Status = ReportOpen
+	if report.UnmatchedOurs == 0 && report.UnmatchedTheirs == 0 {
+		report.Status = ReportReconciled
+	}
+	return tx.Model(report).Updates(map[string]interface{}{
+		"matched":          report.Matched,
+		"unmatched_ours":   report.UnmatchedOurs,
+		"unmatched_theirs": report.UnmatchedTheirs,
+		"adjusted":         report.Adjusted,
+		"status":           report.Status,
+	}).Error
+}
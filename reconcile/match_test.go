@@ -0,0 +1,364 @@
+package reconcile
+
+import (
+	"banking-app/clock"
+	"banking-app/database"
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/models"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB opens a migrated database in a temporary directory on a fake clock stopped after the March statements,
+// returning it with the USD cash account
+func testDB(t *testing.T) (*gorm.DB, models.Account) {
+	t.Helper()
+	clock.Use(clock.NewFake(date(time.April, 20)))
+	t.Cleanup(func() { clock.Use(clock.System{}) })
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "reconcile.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	db.Logger = logger.Default.LogMode(logger.Silent)
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	cash, err := gl.Account(db, 1, gl.Cash, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, cash
+}
+
+// receive books money arriving in cash on a day, as a withdrawal lowers the asset's stored balance
+func receive(t *testing.T, db *gorm.DB, cash models.Account, amount float64, on time.Time) models.Transaction {
+	t.Helper()
+	return book(t, db, models.Transaction{AccountID: cash.ID, TransactionType: "withdrawal", Amount: amount, EffectiveDate: on})
+}
+
+// pay books money leaving cash on a day
+func pay(t *testing.T, db *gorm.DB, cash models.Account, amount float64, on time.Time) models.Transaction {
+	t.Helper()
+	return book(t, db, models.Transaction{AccountID: cash.ID, TransactionType: "deposit", Amount: amount, EffectiveDate: on})
+}
+
+func book(t *testing.T, db *gorm.DB, posting models.Transaction) models.Transaction {
+	t.Helper()
+	posting.Description = "Reconciliation test"
+	if _, err := ledger.Post(db, &posting, nil); err != nil {
+		t.Fatal(err)
+	}
+	return posting
+}
+
+// statement is a March statement with the given lines
+func statement(lines ...Line) Statement {
+	return Statement{ID: "STMT-TEST", Account: "GB33BUKB20201555555555", Currency: "USD",
+		From: date(time.March, 1), To: date(time.March, 31), Lines: lines}
+}
+
+// load imports a statement and returns the stored report with its items, failing the test on an error
+func load(t *testing.T, db *gorm.DB, cash models.Account, stmt Statement, toleranceDays int) (models.MatchReport, []models.MatchItem) {
+	t.Helper()
+	var report models.MatchReport
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		report, err = Import(tx, cash, stmt, ImportOptions{ToleranceDays: toleranceDays, Format: FormatCSV, Filename: "test.csv", By: "admin"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report, items(t, db, report)
+}
+
+// items returns a report's stored items in order
+func items(t *testing.T, db *gorm.DB, report models.MatchReport) []models.MatchItem {
+	t.Helper()
+	var rows []models.MatchItem
+	if err := db.Where("match_report_id = ?", report.ID).Order("id").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		receive   bool // Whether the posting is money in; otherwise money out
+		amount    float64
+		on        int // Day of March the posting is effective
+		line      Line
+		tolerance int
+		matched   bool
+	}{
+		{"money in on the same day", true, 100, 10, Line{Date: date(time.March, 10), Amount: 100, Direction: Credit}, 2, true},
+		{"money out on the same day", false, 100, 10, Line{Date: date(time.March, 10), Amount: 100, Direction: Debit}, 2, true},
+		{"a line at the edge of the window", true, 100, 10, Line{Date: date(time.March, 12), Amount: 100, Direction: Credit}, 2, true},
+		{"a line booked before the posting", true, 100, 10, Line{Date: date(time.March, 8), Amount: 100, Direction: Credit}, 2, true},
+		{"a line a day outside the window", true, 100, 10, Line{Date: date(time.March, 13), Amount: 100, Direction: Credit}, 2, false},
+		{"a next-day line without tolerance", true, 100, 10, Line{Date: date(time.March, 11), Amount: 100, Direction: Credit}, 0, false},
+		{"the opposite direction", false, 100, 10, Line{Date: date(time.March, 10), Amount: 100, Direction: Credit}, 2, false},
+		{"a cent apart", true, 100, 10, Line{Date: date(time.March, 10), Amount: 100.01, Direction: Credit}, 2, false},
+		{"floating point noise in the posting", true, 0.1 + 0.2, 10, Line{Date: date(time.March, 10), Amount: 0.3, Direction: Credit}, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, cash := testDB(t)
+			book := pay
+			if tt.receive {
+				book = receive
+			}
+			posting := book(t, db, cash, tt.amount, date(time.March, tt.on))
+
+			report, rows := load(t, db, cash, statement(tt.line), tt.tolerance)
+			if tt.matched {
+				if len(rows) != 1 || rows[0].Status != ItemMatched || *rows[0].TransactionID != posting.ID || rows[0].MatchedBy != AutoMatcher {
+					t.Fatalf("items %+v, want the line matched to posting %d", rows, posting.ID)
+				}
+				if report.Status != ReportReconciled || report.Matched != 1 {
+					t.Errorf("report %s with %d matched, want reconciled with 1", report.Status, report.Matched)
+				}
+				return
+			}
+			if len(rows) != 2 || rows[0].Status != ItemUnmatchedTheirs || rows[0].TransactionID != nil ||
+				rows[1].Status != ItemUnmatchedOurs || *rows[1].TransactionID != posting.ID {
+				t.Fatalf("items %+v, want the line and the posting each unmatched", rows)
+			}
+			if report.Status != ReportOpen || report.UnmatchedTheirs != 1 || report.UnmatchedOurs != 1 {
+				t.Errorf("report %+v, want open with one unmatched on each side", report)
+			}
+		})
+	}
+}
+
+func TestMatchPrefersReferenceThenDate(t *testing.T) {
+	db, cash := testDB(t)
+	customer := models.Customer{FirstName: "Paying", LastName: "Customer", Email: "paying@example.test", DateOfBirth: "1980-01-01", Status: "active"}
+	if err := db.Create(&customer).Error; err != nil {
+		t.Fatal(err)
+	}
+	account := models.Account{CustomerID: customer.ID, AccountNumber: "RECON-1", AccountType: "checking", Currency: "USD", Status: "active"}
+	if err := db.Create(&account).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	near := receive(t, db, cash, 100, date(time.March, 10))
+	// A customer's deposit two days later, offset to cash; the line names the customer posting
+	deposit := book(t, db, models.Transaction{AccountID: account.ID, TransactionType: "deposit", Amount: 100, EffectiveDate: date(time.March, 12)})
+	offset, err := ledger.OffsetTo(db, deposit, account, gl.Cash, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	early := pay(t, db, cash, 60, date(time.March, 8))
+	late := pay(t, db, cash, 60, date(time.March, 11))
+
+	_, rows := load(t, db, cash, statement(
+		Line{Date: date(time.March, 10), Amount: 100, Direction: Credit, Reference: deposit.TransactionID},
+		Line{Date: date(time.March, 10), Amount: 100, Direction: Credit, Reference: "SOMEONE-ELSE"},
+		Line{Date: date(time.March, 10), Amount: 60, Direction: Debit},
+		Line{Date: date(time.March, 10), Amount: 60, Direction: Debit},
+	), 2)
+
+	want := []uint{offset.ID, near.ID, late.ID, early.ID}
+	if len(rows) != len(want) {
+		t.Fatalf("items %+v, want %d matched", rows, len(want))
+	}
+	for i, row := range rows {
+		if row.Status != ItemMatched || *row.TransactionID != want[i] {
+			t.Errorf("line %d: %s with posting %v, want matched with %d", i+1, row.Status, row.TransactionID, want[i])
+		}
+	}
+}
+
+func TestImportPeriodAndOverlap(t *testing.T) {
+	db, cash := testDB(t)
+	margin := receive(t, db, cash, 5, date(time.February, 28))
+	missing := receive(t, db, cash, 7, date(time.March, 31))
+	lateLine := receive(t, db, cash, 9, date(time.April, 1))
+	shared := receive(t, db, cash, 11, date(time.March, 20))
+
+	stmt := statement(
+		Line{Date: date(time.March, 20), Amount: 11, Direction: Credit},
+		Line{Date: date(time.March, 31), Amount: 9, Direction: Credit},
+	)
+	report, rows := load(t, db, cash, stmt, 2)
+	// The margin posting belongs to February's statement; the one on the last day is missing from this one
+	if report.Matched != 2 || report.UnmatchedOurs != 1 || report.UnmatchedTheirs != 0 {
+		t.Fatalf("report %+v, want 2 matched and 1 of ours unmatched", report)
+	}
+	if rows[0].Status != ItemMatched || *rows[0].TransactionID != shared.ID || *rows[1].TransactionID != lateLine.ID ||
+		rows[2].Status != ItemUnmatchedOurs || *rows[2].TransactionID != missing.ID {
+		t.Errorf("items %+v", rows)
+	}
+	for _, row := range rows {
+		if row.TransactionID != nil && *row.TransactionID == margin.ID {
+			t.Errorf("the February margin posting is on the March report: %+v", row)
+		}
+	}
+
+	// A corrected statement for the second half of March repeats a line; the posting is already matched
+	overlap := Statement{Currency: "USD", From: date(time.March, 16), To: date(time.March, 31), Lines: stmt.Lines[:1]}
+	again, rows := load(t, db, cash, overlap, 2)
+	if again.Matched != 0 || again.UnmatchedTheirs != 1 || again.UnmatchedOurs != 1 || *rows[1].TransactionID != missing.ID {
+		t.Errorf("overlapping report %+v with items %+v, want the repeated line unmatched and only the missing posting of ours", again, rows)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	db, cash := testDB(t)
+	suspense, err := gl.Account(db, 1, gl.Suspense, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	customer := models.Account{AccountNumber: "RECON-CUSTOMER", AccountType: "checking", Currency: "USD", Status: "active"}
+	if err := db.Create(&customer).Error; err != nil {
+		t.Fatal(err)
+	}
+	eur := statement(Line{Date: date(time.March, 2), Amount: 1, Direction: Credit})
+	eur.Currency = "EUR"
+
+	tests := []struct {
+		name    string
+		account models.Account
+		stmt    Statement
+		want    error
+	}{
+		{"a customer account", customer, statement(), ErrNotAssetAccount},
+		{"a liability account", suspense, statement(), ErrNotAssetAccount},
+		{"another currency", cash, eur, ErrCurrency},
+	}
+	for _, tt := range tests {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			_, err := Import(tx, tt.account, tt.stmt, ImportOptions{ToleranceDays: 2})
+			return err
+		})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	var reports int64
+	db.Model(&models.MatchReport{}).Count(&reports)
+	if reports != 0 {
+		t.Errorf("%d reports stored by failed imports", reports)
+	}
+}
+
+func TestReportReview(t *testing.T) {
+	db, cash := testDB(t)
+	receive(t, db, cash, 1500, date(time.March, 2))
+	late := pay(t, db, cash, 250.5, date(time.March, 20))
+	stmt, err := ParseStatement(fixture(t, "camt053.001.02.xml"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, rows := load(t, db, cash, stmt, 2)
+	// The invoice matches; the wire was booked weeks late, and the 40.10 charge was never booked
+	if report.Status != ReportOpen || report.Matched != 1 || report.UnmatchedTheirs != 2 || report.UnmatchedOurs != 1 || report.Adjusted != 0 {
+		t.Fatalf("imported report %+v, want open with 1 matched, 2 lines and 1 posting unmatched", report)
+	}
+	if report.StatementID != "STMT-2026-03" || report.ExternalAccount != "GB33BUKB20201555555555" || report.Currency != "USD" ||
+		!report.PeriodFrom.Equal(date(time.March, 1)) || !report.PeriodTo.Equal(date(time.March, 31)) || report.ToleranceDays != 2 || report.ImportedBy != "admin" {
+		t.Errorf("report header %+v", report)
+	}
+	matched, wire, charge, ours := rows[0], rows[1], rows[2], rows[3]
+	if wire.ExternalReference != "NTRY-2" || charge.ExternalAmount != 40.1 || *ours.TransactionID != late.ID {
+		t.Fatalf("items %+v", rows)
+	}
+
+	review := func(action func(tx *gorm.DB) error) error {
+		return db.Transaction(action)
+	}
+	pair := func(line, posting uint) error {
+		return review(func(tx *gorm.DB) error {
+			_, err := Pair(tx, &report, line, posting, "reviewer")
+			return err
+		})
+	}
+	adjust := func(item uint, code string) (models.Transaction, error) {
+		var entry models.Transaction
+		err := review(func(tx *gorm.DB) error {
+			var err error
+			_, entry, err = Adjust(tx, &report, item, code, "reviewer")
+			return err
+		})
+		return entry, err
+	}
+
+	if err := pair(charge.ID, ours.ID); !errors.Is(err, ErrMismatch) {
+		t.Errorf("pairing the charge with the wire posting: %v, want ErrMismatch", err)
+	}
+	if err := pair(matched.ID, ours.ID); !errors.Is(err, ErrItemState) {
+		t.Errorf("pairing a matched line: %v, want ErrItemState", err)
+	}
+	if err := pair(wire.ID, charge.ID); !errors.Is(err, ErrItemState) {
+		t.Errorf("pairing a line with a line: %v, want ErrItemState", err)
+	}
+	if err := pair(wire.ID, 9999); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("pairing with an item of no report: %v, want ErrRecordNotFound", err)
+	}
+	if err := pair(wire.ID, ours.ID); err != nil {
+		t.Fatal(err)
+	}
+	if report.Matched != 2 || report.UnmatchedTheirs != 1 || report.UnmatchedOurs != 0 || report.Status != ReportOpen {
+		t.Errorf("after pairing the wire: %+v", report)
+	}
+	if rows := items(t, db, report); len(rows) != 3 || rows[1].Status != ItemMatched || *rows[1].TransactionID != late.ID || rows[1].MatchedBy != "reviewer" {
+		t.Errorf("after pairing the posting's item is not folded into the line's: %+v", rows)
+	}
+
+	for _, code := range []string{gl.Cash, "NOPE"} {
+		if _, err := adjust(charge.ID, code); !errors.Is(err, ErrOffsetCode) {
+			t.Errorf("adjusting against %s: %v, want ErrOffsetCode", code, err)
+		}
+	}
+	entry, err := adjust(charge.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The charge is money out, which raises the asset's stored balance, and is held in suspense until cleared
+	if entry.AccountID != cash.ID || entry.TransactionType != "deposit" || entry.Amount != 40.1 || entry.Reference != "BANK-4" ||
+		!entry.EffectiveDate.Equal(date(time.March, 5)) {
+		t.Errorf("adjustment %+v", entry)
+	}
+	suspense, err := gl.Account(db, 1, gl.Suspense, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offset models.Transaction
+	if err := db.Where("offset_of_id = ?", entry.ID).First(&offset).Error; err != nil || offset.AccountID != suspense.ID ||
+		ledger.SignedAmount(offset)+ledger.SignedAmount(entry) != 0 {
+		t.Errorf("adjustment offset %+v (%v), want a balancing posting on suspense", offset, err)
+	}
+	if report.Adjusted != 1 || report.UnmatchedTheirs != 0 || report.Status != ReportReconciled {
+		t.Errorf("after the adjustment: %+v", report)
+	}
+	if _, err := adjust(charge.ID, ""); !errors.Is(err, ErrItemState) {
+		t.Errorf("adjusting twice: %v, want ErrItemState", err)
+	}
+
+	var stored models.MatchReport
+	db.First(&stored, report.ID)
+	if stored.Status != ReportReconciled || stored.Matched != 2 || stored.Adjusted != 1 || stored.UnmatchedOurs != 0 || stored.UnmatchedTheirs != 0 {
+		t.Errorf("stored report %+v does not hold the reviewed counts", stored)
+	}
+
+	// Importing the statement again finds nothing left to match: every posting is matched or booked
+	again, _ := load(t, db, cash, stmt, 2)
+	if again.Matched != 0 || again.UnmatchedTheirs != 3 || again.UnmatchedOurs != 0 {
+		t.Errorf("re-imported report %+v, want every line unmatched", again)
+	}
+}
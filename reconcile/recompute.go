@@ -0,0 +1,148 @@
+package reconcile
+
+import (
+	"banking-app/archive"
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/money"
+	"errors"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MaxRecompute is the most accounts recomputed within one request; larger sets run as a bulk operation
+const MaxRecompute = 1000
+
+// ErrNotFound is the error of an account that does not exist in the caller's tenant, or is an internal ledger account
+var ErrNotFound = errors.New("account not found")
+
+// RecomputeConfig bounds how hard balance recomputation works the database
+type RecomputeConfig struct {
+	Workers   int // Accounts recomputed at once within a request
+	PerMinute int // Recompute requests each administrator may make per minute
+}
+
+// RecomputeConfigFromEnv reads RECOMPUTE_WORKERS (default 8) and RECOMPUTE_RATE_PER_MINUTE (default 6)
+func RecomputeConfigFromEnv() RecomputeConfig {
+	workers, err := strconv.Atoi(os.Getenv("RECOMPUTE_WORKERS"))
+	if err != nil || workers <= 0 {
+		workers = 8
+	}
+	perMinute, err := strconv.Atoi(os.Getenv("RECOMPUTE_RATE_PER_MINUTE"))
+	if err != nil || perMinute <= 0 {
+		perMinute = 6
+	}
+	return RecomputeConfig{Workers: workers, PerMinute: perMinute}
+}
+
+// LastPosting is the latest posting counted in a recomputed balance
+type LastPosting struct {
+	ID            uint      `json:"id"`
+	TransactionID string    `json:"transaction_id"`
+	Type          string    `json:"transaction_type"`
+	Amount        float64   `json:"amount"`
+	BalanceAfter  float64   `json:"balance_after"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Recomputed is one account's stored balance against the balance its postings add up to
+type Recomputed struct {
+	AccountID       uint         `json:"account_id"`
+	AccountNumber   string       `json:"account_number,omitempty"`
+	Balance         float64      `json:"balance"`           // Stored account balance
+	LedgerBalance   float64      `json:"ledger_balance"`    // Sum of the postings, archived ones included
+	Match           bool         `json:"match"`             // The two agree to the cent
+	Drift           float64      `json:"drift"`             // Balance - LedgerBalance
+	Transactions    int64        `json:"transaction_count"` // Postings counted
+	LastTransaction *LastPosting `json:"last_transaction"`  // Nil for an account without postings
+	Error           string       `json:"error,omitempty"`   // Why the account could not be recomputed
+}
+
+// Recompute recomputes accounts' balances from their postings with up to workers at once, returning the results in
+// the order of ids. An account that cannot be recomputed carries its error rather than failing the rest
+func Recompute(db *gorm.DB, ids []uint, workers int) []Recomputed {
+	results := make([]Recomputed, len(ids))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(ids); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				result, err := RecomputeOne(db, ids[i])
+				if err != nil {
+					result = Recomputed{AccountID: ids[i], Error: err.Error()}
+				}
+				results[i] = result
+			}
+		}()
+	}
+	for i := range ids {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// RecomputeOne compares an account's stored balance with one aggregate over its hot postings plus its archive totals
+func RecomputeOne(db *gorm.DB, accountID uint) (Recomputed, error) {
+	result := Recomputed{AccountID: accountID}
+	var accounts []models.Account
+	err := db.Select("id, account_number, account_type, balance").Where("id = ?", accountID).Limit(1).Find(&accounts).Error
+	if err != nil {
+		return result, err
+	}
+	if len(accounts) == 0 || accounts[0].AccountType == gl.AccountType {
+		return result, ErrNotFound
+	}
+	account := accounts[0]
+
+	net, count, lastID, err := ledgerBalance(db, accountID)
+	if err != nil {
+		return result, err
+	}
+	if lastID != 0 {
+		var last models.Transaction
+		if err := db.Select("id, transaction_id, transaction_type, amount, balance_after, created_at").First(&last, lastID).Error; err != nil {
+			return result, err
+		}
+		result.LastTransaction = &LastPosting{ID: last.ID, TransactionID: last.TransactionID, Type: last.TransactionType,
+			Amount: last.Amount, BalanceAfter: last.BalanceAfter, CreatedAt: last.CreatedAt}
+	}
+
+	result.AccountNumber = account.AccountNumber
+	result.Balance = account.Balance
+	result.LedgerBalance = net
+	result.Transactions = count
+	result.Drift = money.Cents(account.Balance - net)
+	result.Match = math.Abs(account.Balance-net) <= tolerance
+	return result, nil
+}
+
+// ledgerBalance sums an account's postings in one aggregate over the transactions table, adding the running total
+// of its archived postings; lastID is its latest hot posting
+func ledgerBalance(db *gorm.DB, accountID uint) (net float64, count int64, lastID uint, err error) {
+	var hot struct {
+		Net   float64
+		Count int64
+		Last  uint
+	}
+	err = db.Model(&models.Transaction{}).
+		Select("COALESCE(SUM("+ledger.SignedAmountSQL+"), 0) AS net, COUNT(*) AS count, COALESCE(MAX(id), 0) AS last").
+		Where("account_id = ?", accountID).Scan(&hot).Error
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	summary, _, err := archive.Summary(db, accountID)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return money.Cents(hot.Net + summary.Net), hot.Count + summary.Count, hot.Last, nil
+}
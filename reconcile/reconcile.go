@@ -0,0 +1,98 @@
+package reconcile
+
+import (
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/money"
+	"math"
+
+	"gorm.io/gorm"
+)
+
+// tolerance absorbs floating point noise in decimal columns
+const tolerance = 0.005
+
+// Break is an account whose stored balance disagrees with its transaction history
+type Break struct {
+	AccountID     uint    `json:"account_id"`
+	AccountNumber string  `json:"account_number"`
+	Balance       float64 `json:"balance"`           // Stored account balance
+	LedgerBalance float64 `json:"ledger_balance"`    // Balance implied by the postings
+	LastBalance   float64 `json:"last_balance"`      // balance_after of the latest posting
+	Difference    float64 `json:"difference"`        // Balance - LedgerBalance
+	Transactions  int64   `json:"transaction_count"` // Postings considered
+}
+
+// Report summarizes a reconciliation run
+type Report struct {
+	AccountsChecked int     `json:"accounts_checked"`
+	Breaks          []Break `json:"breaks"`
+}
+
+// Accounts compares every account balance with the sum of its postings, archived ones included
+// Deposits and interest credit the account; every other posting debits it
+func Accounts(db *gorm.DB) (Report, error) {
+	report := Report{Breaks: []Break{}}
+
+	var accounts []models.Account
+	if err := db.Select("id, account_number, balance").Order("id").Find(&accounts).Error; err != nil {
+		return report, err
+	}
+
+	type ledgerRow struct {
+		AccountID uint
+		Net       float64
+		Count     int64
+	}
+	var rows []ledgerRow
+	err := db.Model(&models.Transaction{}).
+		Select("account_id, SUM(" + ledger.SignedAmountSQL + ") AS net, COUNT(*) AS count").
+		Group("account_id").Scan(&rows).Error
+	if err != nil {
+		return report, err
+	}
+	postings := make(map[uint]ledgerRow, len(rows))
+	for _, row := range rows {
+		postings[row.AccountID] = row
+	}
+
+	// Archived postings count through their accounts' running totals
+	var archived []models.TransactionArchive
+	if err := db.Find(&archived).Error; err != nil {
+		return report, err
+	}
+	for _, summary := range archived {
+		row := postings[summary.AccountID]
+		row.Net += summary.Net
+		row.Count += summary.Count
+		postings[summary.AccountID] = row
+	}
+
+	for _, account := range accounts {
+		report.AccountsChecked++
+		row := postings[account.ID]
+
+		var last models.Transaction
+		lastBalance := 0.0
+		if row.Count > 0 {
+			if err := db.Where("account_id = ?", account.ID).Order("id DESC").First(&last).Error; err != nil {
+				return report, err
+			}
+			lastBalance = last.BalanceAfter
+		}
+
+		diff := account.Balance - row.Net
+		if math.Abs(diff) > tolerance || math.Abs(account.Balance-lastBalance) > tolerance {
+			report.Breaks = append(report.Breaks, Break{
+				AccountID:     account.ID,
+				AccountNumber: account.AccountNumber,
+				Balance:       account.Balance,
+				LedgerBalance: row.Net,
+				LastBalance:   lastBalance,
+				Difference:    money.Cents(diff),
+				Transactions:  row.Count,
+			})
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,302 @@
+package reconcile
+
+import (
+	"banking-app/money"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Statement formats
+const (
+	FormatCAMT053 = "camt053"
+	FormatCSV     = "csv"
+)
+
+// Line directions, from the statement issuer's point of view of our account with them
+const (
+	Credit = "CRDT" // Money in
+	Debit  = "DBIT" // Money out
+)
+
+// ErrEmptyStatement is returned for a statement with no booked lines
+var ErrEmptyStatement = errors.New("statement has no booked entries")
+
+// Statement is an external bank statement reduced to what matching needs
+type Statement struct {
+	ID       string
+	Account  string // IBAN or other identifier of the account it covers
+	Currency string
+	From, To time.Time // Days covered; from the lines when the file does not say
+	Lines    []Line
+}
+
+// Line is one booked statement entry
+type Line struct {
+	Date        time.Time
+	Amount      float64 // Always positive
+	Direction   string  // Credit or Debit
+	Reference   string
+	Description string
+}
+
+// ParseStatement reads a camt.053 or CSV statement; format may be empty to detect it from the content
+func ParseStatement(data []byte, format string) (Statement, error) {
+	if format == "" {
+		format = FormatCSV
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+			format = FormatCAMT053
+		}
+	}
+	var stmt Statement
+	var err error
+	switch format {
+	case FormatCAMT053:
+		stmt, err = ParseCAMT053(data)
+	case FormatCSV:
+		stmt, err = ParseCSV(data)
+	default:
+		return stmt, fmt.Errorf("format must be %s or %s", FormatCAMT053, FormatCSV)
+	}
+	if err != nil {
+		return stmt, err
+	}
+	if len(stmt.Lines) == 0 {
+		return stmt, ErrEmptyStatement
+	}
+	for _, line := range stmt.Lines {
+		if stmt.From.IsZero() || line.Date.Before(stmt.From) {
+			stmt.From = line.Date
+		}
+		if line.Date.After(stmt.To) {
+			stmt.To = line.Date
+		}
+	}
+	return stmt, nil
+}
+
+// camtDocument is the subset of an ISO 20022 camt.053 BankToCustomerStatement that matching reads
+// Elements are matched by local name, so any camt.053 version's namespace is accepted
+type camtDocument struct {
+	Statements []struct {
+		ID      string `xml:"Id"`
+		Account struct {
+			IBAN     string `xml:"Id>IBAN"`
+			Other    string `xml:"Id>Othr>Id"`
+			Currency string `xml:"Ccy"`
+		} `xml:"Acct"`
+		Period struct {
+			From string `xml:"FrDtTm"`
+			To   string `xml:"ToDtTm"`
+		} `xml:"FrToDt"`
+		Entries []camtEntry `xml:"Ntry"`
+	} `xml:"BkToCstmrStmt>Stmt"`
+}
+
+// camtEntry is one Ntry element
+type camtEntry struct {
+	Amount struct {
+		Value    string `xml:",chardata"`
+		Currency string `xml:"Ccy,attr"`
+	} `xml:"Amt"`
+	Direction string `xml:"CdtDbtInd"`
+	Status    struct {
+		Text string `xml:",chardata"` // BOOK, PDNG; a plain code before camt.053.001.08
+		Code string `xml:"Cd"`        // The same code from camt.053.001.08 on
+	} `xml:"Sts"`
+	Booking  camtDate `xml:"BookgDt"`
+	Value    camtDate `xml:"ValDt"`
+	EntryRef string   `xml:"NtryRef"`
+	BankRef  string   `xml:"AcctSvcrRef"`
+	Info     string   `xml:"AddtlNtryInf"`
+	Details  []struct {
+		EndToEndID   string   `xml:"Refs>EndToEndId"`
+		Unstructured []string `xml:"RmtInf>Ustrd"`
+	} `xml:"NtryDtls>TxDtls"`
+}
+
+// camtDate is a date that camt gives as Dt or DtTm
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+// parse returns the date, or the zero time when neither form is present
+func (d camtDate) parse() (time.Time, error) {
+	switch {
+	case d.Date != "":
+		return time.Parse("2006-01-02", strings.TrimSpace(d.Date))
+	case d.DateTime != "":
+		return parseDateTime(d.DateTime)
+	}
+	return time.Time{}, nil
+}
+
+// parseDateTime reads an ISO 8601 date-time with or without a zone, keeping only the day
+func parseDateTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04:05.999999999"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date-time %q", s)
+}
+
+// ParseCAMT053 reads the booked entries of the first statement in a camt.053 file
+// Pending entries are skipped. The reference is the first end-to-end ID, else the entry or bank reference
+func ParseCAMT053(data []byte) (Statement, error) {
+	var stmt Statement
+	var doc camtDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return stmt, fmt.Errorf("invalid camt.053: %w", err)
+	}
+	if len(doc.Statements) == 0 {
+		return stmt, errors.New("invalid camt.053: no Stmt element")
+	}
+	s := doc.Statements[0]
+	stmt.ID = strings.TrimSpace(s.ID)
+	stmt.Account = strings.TrimSpace(s.Account.IBAN)
+	if stmt.Account == "" {
+		stmt.Account = strings.TrimSpace(s.Account.Other)
+	}
+	stmt.Currency = strings.TrimSpace(s.Account.Currency)
+	if s.Period.From != "" && s.Period.To != "" {
+		from, err := parseDateTime(s.Period.From)
+		if err != nil {
+			return stmt, err
+		}
+		to, err := parseDateTime(s.Period.To)
+		if err != nil {
+			return stmt, err
+		}
+		stmt.From, stmt.To = from, to
+	}
+
+	for i, entry := range s.Entries {
+		status := strings.TrimSpace(entry.Status.Code)
+		if status == "" {
+			status = strings.TrimSpace(entry.Status.Text)
+		}
+		if status != "" && status != "BOOK" {
+			continue
+		}
+		line, err := entry.line()
+		if err != nil {
+			return stmt, fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		if stmt.Currency == "" {
+			stmt.Currency = strings.TrimSpace(entry.Amount.Currency)
+		}
+		stmt.Lines = append(stmt.Lines, line)
+	}
+	return stmt, nil
+}
+
+// line converts an entry to a statement line
+func (e camtEntry) line() (Line, error) {
+	var line Line
+	amount, err := strconv.ParseFloat(strings.TrimSpace(e.Amount.Value), 64)
+	if err != nil || amount <= 0 {
+		return line, fmt.Errorf("invalid amount %q", e.Amount.Value)
+	}
+	line.Amount = money.Cents(amount)
+	line.Direction = strings.TrimSpace(e.Direction)
+	if line.Direction != Credit && line.Direction != Debit {
+		return line, fmt.Errorf("invalid CdtDbtInd %q", e.Direction)
+	}
+	if line.Date, err = e.Booking.parse(); err == nil && line.Date.IsZero() {
+		line.Date, err = e.Value.parse()
+	}
+	if err != nil {
+		return line, err
+	}
+	if line.Date.IsZero() {
+		return line, errors.New("entry has no booking or value date")
+	}
+
+	var unstructured []string
+	for _, d := range e.Details {
+		if id := strings.TrimSpace(d.EndToEndID); line.Reference == "" && id != "" && id != "NOTPROVIDED" {
+			line.Reference = id
+		}
+		unstructured = append(unstructured, d.Unstructured...)
+	}
+	for _, ref := range []string{e.EntryRef, e.BankRef} {
+		if line.Reference == "" {
+			line.Reference = strings.TrimSpace(ref)
+		}
+	}
+	line.Description = strings.TrimSpace(strings.Join(unstructured, " "))
+	if line.Description == "" {
+		line.Description = strings.TrimSpace(e.Info)
+	}
+	return line, nil
+}
+
+// ParseCSV reads the CSV fallback format: a header row naming date (YYYY-MM-DD), amount, credit_debit
+// (CRDT or DBIT), reference and description, in any order. Only date and amount are required; without
+// credit_debit a negative amount is a debit
+func ParseCSV(data []byte) (Statement, error) {
+	var stmt Statement
+	r := csv.NewReader(bytes.NewReader(data))
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return stmt, fmt.Errorf("invalid csv: %w", err)
+	}
+	index := make(map[string]int)
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"date", "amount"} {
+		if _, ok := index[required]; !ok {
+			return stmt, fmt.Errorf("invalid csv: missing %s column", required)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := index[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	for row := 2; ; row++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stmt, fmt.Errorf("invalid csv: %w", err)
+		}
+		var line Line
+		if line.Date, err = time.Parse("2006-01-02", field(record, "date")); err != nil {
+			return stmt, fmt.Errorf("row %d: invalid date %q", row, field(record, "date"))
+		}
+		amount, err := strconv.ParseFloat(field(record, "amount"), 64)
+		if err != nil || amount == 0 {
+			return stmt, fmt.Errorf("row %d: invalid amount %q", row, field(record, "amount"))
+		}
+		line.Direction = strings.ToUpper(field(record, "credit_debit"))
+		switch {
+		case line.Direction == "" && amount < 0:
+			line.Direction = Debit
+		case line.Direction == "":
+			line.Direction = Credit
+		case line.Direction != Credit && line.Direction != Debit:
+			return stmt, fmt.Errorf("row %d: credit_debit must be CRDT or DBIT", row)
+		}
+		line.Amount = money.Cents(math.Abs(amount))
+		line.Reference = field(record, "reference")
+		line.Description = field(record, "description")
+		stmt.Lines = append(stmt.Lines, line)
+	}
+	return stmt, nil
+}
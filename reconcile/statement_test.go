@@ -0,0 +1,129 @@
+package reconcile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// date returns midnight UTC on a day of 2026
+func date(month time.Month, day int) time.Time {
+	return time.Date(2026, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// fixture reads a file from testdata
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// march is the statement in testdata/camt053.001.02.xml and testdata/statement.csv, apart from what only one has
+var march = []Line{
+	{Date: date(time.March, 2), Amount: 1500, Direction: Credit, Reference: "E2E-INV-1001", Description: "Invoice 1001 Acme Corp"},
+	{Date: date(time.March, 3), Amount: 250.5, Direction: Debit, Reference: "NTRY-2", Description: "Outgoing wire"},
+	{Date: date(time.March, 5), Amount: 40.1, Direction: Debit, Reference: "BANK-4"},
+}
+
+func TestParseCAMT053(t *testing.T) {
+	tests := []struct {
+		file string
+		want Statement
+	}{
+		{"camt053.001.02.xml", Statement{
+			ID: "STMT-2026-03", Account: "GB33BUKB20201555555555", Currency: "USD",
+			From: date(time.March, 1), To: date(time.March, 31), Lines: march,
+		}},
+		{"camt053.001.08.xml", Statement{
+			ID: "NOSTRO-7-0410", Account: "NOSTRO-7", Currency: "EUR",
+			From: date(time.April, 8), To: date(time.April, 10),
+			Lines: []Line{
+				{Date: date(time.April, 10), Amount: 100, Direction: Credit, Reference: "E2E-EUR-1"},
+				{Date: date(time.April, 8), Amount: 20, Direction: Debit, Reference: "NTRY-EUR-3"},
+			},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			for _, format := range []string{FormatCAMT053, ""} {
+				stmt, err := ParseStatement(fixture(t, tt.file), format)
+				if err != nil {
+					t.Fatalf("format %q: %v", format, err)
+				}
+				if !reflect.DeepEqual(stmt, tt.want) {
+					t.Errorf("format %q:\n got %+v\nwant %+v", format, stmt, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	want := Statement{From: date(time.March, 2), To: date(time.March, 31), Lines: append(append([]Line(nil), march...),
+		Line{Date: date(time.March, 31), Amount: 12.75, Direction: Credit, Description: "Interest"})}
+	// The CSV joins the remittance lines with a comma
+	want.Lines[0].Description = "Invoice 1001, Acme Corp"
+
+	for _, format := range []string{FormatCSV, ""} {
+		stmt, err := ParseStatement(fixture(t, "statement.csv"), format)
+		if err != nil {
+			t.Fatalf("format %q: %v", format, err)
+		}
+		if !reflect.DeepEqual(stmt, want) {
+			t.Errorf("format %q:\n got %+v\nwant %+v", format, stmt, want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	camt := func(entries ...string) string {
+		return `<Document><BkToCstmrStmt><Stmt><Id>S</Id>` + strings.Join(entries, "") + `</Stmt></BkToCstmrStmt></Document>`
+	}
+	ntry := func(amount, direction, dates string) string {
+		return `<Ntry><Amt Ccy="USD">` + amount + `</Amt><CdtDbtInd>` + direction + `</CdtDbtInd><Sts>BOOK</Sts>` + dates + `</Ntry>`
+	}
+	booked := `<BookgDt><Dt>2026-03-02</Dt></BookgDt>`
+
+	tests := []struct {
+		name   string
+		data   string
+		format string
+		want   string // Part of the error message
+	}{
+		{"an unknown format", "date,amount\n2026-03-02,1\n", "mt940", "format must be"},
+		{"malformed XML", "<Document><BkToCstmrStmt>", "", "invalid camt.053"},
+		{"camt without a statement", "<Document><BkToCstmrStmt/></Document>", "", "no Stmt element"},
+		{"camt with only pending entries", camt(`<Ntry><Amt Ccy="USD">5</Amt><CdtDbtInd>CRDT</CdtDbtInd><Sts>PDNG</Sts>` + booked + `</Ntry>`), "", ErrEmptyStatement.Error()},
+		{"a zero camt amount", camt(ntry("0.00", "CRDT", booked)), "", `entry 1: invalid amount "0.00"`},
+		{"a negative camt amount", camt(ntry("10", "CRDT", booked), ntry("-3", "DBIT", booked)), "", `entry 2: invalid amount "-3"`},
+		{"an unknown direction", camt(ntry("10", "RVSL", booked)), "", `invalid CdtDbtInd "RVSL"`},
+		{"an entry without dates", camt(ntry("10", "CRDT", "")), "", "no booking or value date"},
+		{"an unreadable date-time", camt(ntry("10", "CRDT", `<BookgDt><DtTm>yesterday</DtTm></BookgDt>`)), "", `invalid date-time "yesterday"`},
+		{"CSV with only a header", "date,amount\n", "", ErrEmptyStatement.Error()},
+		{"CSV without an amount column", "date,value\n2026-03-02,1\n", "", "missing amount column"},
+		{"CSV without a date column", "amount\n1\n", "csv", "missing date column"},
+		{"a CSV date in another layout", "date,amount\n02/03/2026,1\n", "", `row 2: invalid date "02/03/2026"`},
+		{"a zero CSV amount", "date,amount\n2026-03-02,1\n2026-03-03,0\n", "", `row 3: invalid amount "0"`},
+		{"an unknown CSV direction", "date,amount,credit_debit\n2026-03-02,1,in\n", "", "credit_debit must be CRDT or DBIT"},
+		{"a ragged CSV row", "date,amount\n2026-03-02,1,extra\n", "", "invalid csv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseStatement([]byte(tt.data), tt.format)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseStatement([]byte("date,amount\n"), ""); !errors.Is(err, ErrEmptyStatement) {
+		t.Errorf("an empty statement: %v, want ErrEmptyStatement", err)
+	}
+}
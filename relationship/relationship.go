@@ -0,0 +1,196 @@
+package relationship
+
+import (
+	"banking-app/businessdays"
+	"banking-app/fx"
+	"banking-app/models"
+	"banking-app/money"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PeriodLayout formats the month a tier applies for
+const PeriodLayout = "2006-01"
+
+// nonDeposit are the account types whose balances are not deposits: amounts owed and internal ledger accounts
+var nonDeposit = []string{"loan", "credit_line", "internal"}
+
+// Tier definition errors - handlers map these to client responses
+var (
+	ErrDefinition = errors.New("a tier needs a code, a name and a positive rank; thresholds and the savings rate bonus must not be negative")
+	ErrDates      = errors.New("effective_to must not be before effective_from")
+	ErrOverlap    = errors.New("another definition with the same code or rank is in effect on some of these dates")
+)
+
+// Inputs are the figures a customer's tier is decided on
+type Inputs struct {
+	TotalDeposits float64 `json:"total_deposits"` // Deposit balances in the base currency
+	LoanBalance   float64 `json:"loan_balance"`   // Outstanding active loans in the base currency
+	ProductCount  int     `json:"product_count"`  // Distinct products held
+	TenureDays    int     `json:"tenure_days"`    // Days since the customer joined
+}
+
+// Needs is what a customer lacks for a tier; zero where they already meet the threshold
+type Needs struct {
+	Deposits    float64 `json:"deposits"`
+	LoanBalance float64 `json:"loan_balance"`
+	Products    int     `json:"products"`
+	TenureDays  int     `json:"tenure_days"`
+}
+
+// InEffect reports whether a tier definition applies on date's bank day
+func InEffect(t models.RelationshipTier, date time.Time) bool {
+	day := businessdays.StartOfDay(date)
+	if day.Before(businessdays.StartOfDay(t.EffectiveFrom)) {
+		return false
+	}
+	return t.EffectiveTo == nil || !day.After(businessdays.StartOfDay(*t.EffectiveTo))
+}
+
+// Meets reports whether inputs reach every threshold of a tier
+func Meets(t models.RelationshipTier, in Inputs) bool {
+	return Missing(t, in) == Needs{}
+}
+
+// Missing is what inputs lack for a tier
+func Missing(t models.RelationshipTier, in Inputs) Needs {
+	return Needs{
+		Deposits:    money.Cents(math.Max(0, t.MinDeposits-in.TotalDeposits)),
+		LoanBalance: money.Cents(math.Max(0, t.MinLoanBalance-in.LoanBalance)),
+		Products:    max(0, t.MinProducts-in.ProductCount),
+		TenureDays:  max(0, t.MinTenureDays-in.TenureDays),
+	}
+}
+
+// Select picks the highest-ranked tier in effect on date that inputs meet
+func Select(tiers []models.RelationshipTier, in Inputs, date time.Time) (models.RelationshipTier, bool) {
+	var best models.RelationshipTier
+	found := false
+	for _, t := range tiers {
+		if InEffect(t, date) && Meets(t, in) && (!found || t.Rank > best.Rank) {
+			best, found = t, true
+		}
+	}
+	return best, found
+}
+
+// Next picks the lowest-ranked tier in effect on date above rank, the one a customer works towards
+func Next(tiers []models.RelationshipTier, rank int, date time.Time) (models.RelationshipTier, bool) {
+	var next models.RelationshipTier
+	found := false
+	for _, t := range tiers {
+		if InEffect(t, date) && t.Rank > rank && (!found || t.Rank < next.Rank) {
+			next, found = t, true
+		}
+	}
+	return next, found
+}
+
+// Waives reports whether a tier waives transaction fees on an account type
+func Waives(t models.RelationshipTier, accountType string) bool {
+	for _, waived := range strings.Split(t.FeeWaivers, ",") {
+		if strings.TrimSpace(waived) == accountType {
+			return true
+		}
+	}
+	return false
+}
+
+// Measure reads a customer's inputs as they stand now
+// Balances in other currencies are valued at their latest closing rate; one without a rate yet is left out
+func Measure(db *gorm.DB, customer models.Customer, now time.Time) (Inputs, error) {
+	in := Inputs{TenureDays: max(0, businessdays.Days(customer.CreatedAt, now))}
+
+	type balance struct {
+		Currency string
+		Total    float64
+	}
+	var deposits []balance
+	err := db.Model(&models.Account{}).Select("currency, SUM(balance) AS total").
+		Where("customer_id = ? AND status <> 'closed' AND account_type NOT IN ?", customer.ID, nonDeposit).
+		Group("currency").Scan(&deposits).Error
+	if err != nil {
+		return in, err
+	}
+	for _, d := range deposits {
+		rate, ok, err := baseRate(db, customer.TenantID, d.Currency, now)
+		if err != nil {
+			return in, err
+		}
+		if ok {
+			in.TotalDeposits += d.Total * rate
+		}
+	}
+	in.TotalDeposits = money.Cents(in.TotalDeposits)
+
+	var loans []balance
+	err = db.Model(&models.Loan{}).Select("accounts.currency AS currency, SUM(loans.remaining_balance) AS total").
+		Joins("LEFT JOIN accounts ON accounts.id = loans.account_id").
+		Where("loans.customer_id = ? AND loans.status = 'active'", customer.ID).
+		Group("accounts.currency").Scan(&loans).Error
+	if err != nil {
+		return in, err
+	}
+	for _, l := range loans {
+		rate, ok, err := baseRate(db, customer.TenantID, l.Currency, now)
+		if err != nil {
+			return in, err
+		}
+		if ok {
+			in.LoanBalance += l.Total * rate
+		}
+	}
+	in.LoanBalance = money.Cents(in.LoanBalance)
+
+	var products int64
+	err = db.Model(&models.Account{}).Distinct("account_type").
+		Where("customer_id = ? AND status <> 'closed' AND account_type <> 'internal'", customer.ID).
+		Count(&products).Error
+	in.ProductCount = int(products)
+	return in, err
+}
+
+// baseRate is the base-currency value of one unit of currency on the latest rate up to now
+// Loans without an account carry no currency and are taken to be in the base currency
+func baseRate(db *gorm.DB, tenantID uint, currency string, now time.Time) (float64, bool, error) {
+	if currency == "" || currency == fx.BaseCurrency {
+		return 1, true, nil
+	}
+	var rate models.FXRate
+	err := db.Where("tenant_id = ? AND currency = ? AND date <= ?", tenantID, currency, now).Order("date DESC").Limit(1).Find(&rate).Error
+	return rate.Rate, rate.ID != 0, err
+}
+
+// Current returns a customer's latest recorded tier, with its definition when they reached one
+// found is false before the customer's first computation
+func Current(db *gorm.DB, customerID uint) (record models.CustomerRelationship, tier *models.RelationshipTier, found bool, err error) {
+	err = db.Where("customer_id = ?", customerID).Order("period DESC").Limit(1).Find(&record).Error
+	if err != nil || record.ID == 0 {
+		return record, nil, false, err
+	}
+	if record.TierID == nil {
+		return record, nil, true, nil
+	}
+	var definition models.RelationshipTier
+	if err := db.Find(&definition, *record.TierID).Error; err != nil {
+		return record, nil, true, err
+	}
+	if definition.ID == 0 {
+		return record, nil, true, nil
+	}
+	return record, &definition, true, nil
+}
+
+// WaivesFees reports whether an account holder's recorded tier waives fees on postings to it effective on date
+// A tier definition that has ended no longer waives anything, even before the next monthly recomputation
+func WaivesFees(db *gorm.DB, account models.Account, date time.Time) (bool, error) {
+	_, tier, _, err := Current(db, account.CustomerID)
+	if err != nil || tier == nil {
+		return false, err
+	}
+	return InEffect(*tier, date) && Waives(*tier, account.AccountType), nil
+}
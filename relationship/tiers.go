@@ -0,0 +1,128 @@
+package relationship
+
+import (
+	"banking-app/businessdays"
+	"banking-app/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Validate checks a tier definition's fields and dates, normalizing its code and fee waiver list
+// The fee waiver account types are checked by the caller against the tenant's catalog
+func Validate(t *models.RelationshipTier) error {
+	t.Code = strings.ToLower(strings.TrimSpace(t.Code))
+	var waivers []string
+	for _, accountType := range strings.Split(t.FeeWaivers, ",") {
+		if accountType = strings.TrimSpace(accountType); accountType != "" {
+			waivers = append(waivers, accountType)
+		}
+	}
+	t.FeeWaivers = strings.Join(waivers, ",")
+	switch {
+	case t.Code == "" || strings.TrimSpace(t.Name) == "" || t.Rank <= 0,
+		t.MinDeposits < 0 || t.MinLoanBalance < 0 || t.MinProducts < 0 || t.MinTenureDays < 0 || t.SavingsRateBonus < 0:
+		return ErrDefinition
+	case t.EffectiveTo != nil && businessdays.StartOfDay(*t.EffectiveTo).Before(businessdays.StartOfDay(t.EffectiveFrom)):
+		return ErrDates
+	}
+	return nil
+}
+
+// Overlaps reports whether two definitions share a code or a rank on at least one common day
+// A code names one tier at a time, and a rank orders one tier at a time
+func Overlaps(a, b models.RelationshipTier) bool {
+	if a.Code != b.Code && a.Rank != b.Rank {
+		return false
+	}
+	return (a.EffectiveTo == nil || !businessdays.StartOfDay(b.EffectiveFrom).After(businessdays.StartOfDay(*a.EffectiveTo))) &&
+		(b.EffectiveTo == nil || !businessdays.StartOfDay(a.EffectiveFrom).After(businessdays.StartOfDay(*b.EffectiveTo)))
+}
+
+// Conflict returns a definition other than t that overlaps it, if any
+func Conflict(db *gorm.DB, t models.RelationshipTier) (models.RelationshipTier, bool, error) {
+	var candidates []models.RelationshipTier
+	if err := db.Where("id <> ? AND (code = ? OR rank = ?)", t.ID, t.Code, t.Rank).Find(&candidates).Error; err != nil {
+		return models.RelationshipTier{}, false, err
+	}
+	for _, other := range candidates {
+		if Overlaps(t, other) {
+			return other, true, nil
+		}
+	}
+	return models.RelationshipTier{}, false, nil
+}
+
+// InUse reports whether any customer's recorded tier refers to a definition
+func InUse(db *gorm.DB, tierID uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.CustomerRelationship{}).Where("tier_id = ?", tierID).Count(&count).Error
+	return count > 0, err
+}
+
+// Recompute records the current month's tier for every customer, or for one tenant's when tenantID is set
+// Rerunning within a month replaces that month's record, so the latest inputs always win. Returns the number
+// of customers recorded
+func Recompute(db *gorm.DB, tenantID uint, now time.Time) (int, error) {
+	query := db.Order("id")
+	if tenantID != 0 {
+		query = query.Where("tenant_id = ?", tenantID)
+	}
+	var customers []models.Customer
+	if err := query.Find(&customers).Error; err != nil {
+		return 0, err
+	}
+
+	tiers := map[uint][]models.RelationshipTier{} // Per tenant, loaded on first use
+	period := businessdays.In(now).Format(PeriodLayout)
+	recorded := 0
+	for _, customer := range customers {
+		definitions, ok := tiers[customer.TenantID]
+		if !ok {
+			if err := db.Where("tenant_id = ?", customer.TenantID).Find(&definitions).Error; err != nil {
+				return recorded, err
+			}
+			tiers[customer.TenantID] = definitions
+		}
+		if err := record(db, customer, definitions, period, now); err != nil {
+			return recorded, err
+		}
+		recorded++
+	}
+	return recorded, nil
+}
+
+// record measures one customer and writes their tier for the period
+func record(db *gorm.DB, customer models.Customer, tiers []models.RelationshipTier, period string, now time.Time) error {
+	in, err := Measure(db, customer, now)
+	if err != nil {
+		return err
+	}
+	entry := models.CustomerRelationship{
+		TenantID:      customer.TenantID,
+		CustomerID:    customer.ID,
+		Period:        period,
+		TotalDeposits: in.TotalDeposits,
+		LoanBalance:   in.LoanBalance,
+		ProductCount:  in.ProductCount,
+		TenureDays:    in.TenureDays,
+		ComputedAt:    now,
+	}
+	if tier, ok := Select(tiers, in, now); ok {
+		entry.TierID = &tier.ID
+		entry.TierCode = tier.Code
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var existing models.CustomerRelationship
+		if err := tx.Where("customer_id = ? AND period = ?", customer.ID, period).Find(&existing).Error; err != nil {
+			return err
+		}
+		if existing.ID == 0 {
+			return tx.Create(&entry).Error
+		}
+		return tx.Model(&existing).Select("tier_id", "tier_code", "total_deposits", "loan_balance", "product_count", "tenure_days", "computed_at").
+			Updates(&entry).Error
+	})
+}
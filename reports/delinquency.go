@@ -0,0 +1,89 @@
+package reports
+
+import (
+	"banking-app/businessdays"
+	"banking-app/models"
+	"banking-app/money"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Delinquency is an active loan whose payments are behind its installment schedule
+// Installments fall monthly on the disbursement day; payments made count against the oldest installment first
+type Delinquency struct {
+	LoanID           uint    `json:"loan_id"`
+	LoanNumber       string  `json:"loan_number"`
+	CustomerID       uint    `json:"customer_id"`
+	CustomerName     string  `json:"customer_name"`
+	MonthlyPayment   float64 `json:"monthly_payment"`
+	InstallmentsDue  int     `json:"installments_due"` // Installments fallen due by the report date
+	AmountDue        float64 `json:"amount_due"`       // What those installments add up to
+	AmountPaid       float64 `json:"amount_paid"`
+	AmountPastDue    float64 `json:"amount_past_due"`
+	OldestUnpaidDate string  `json:"oldest_unpaid_date"` // YYYY-MM-DD of the earliest installment not covered
+	DaysPastDue      int     `json:"days_past_due"`
+	RemainingBalance float64 `json:"remaining_balance"`
+}
+
+// Delinquencies lists the active loans at least minDaysPastDue days behind as of asOf, most overdue first
+func Delinquencies(db *gorm.DB, asOf time.Time, minDaysPastDue int) ([]Delinquency, error) {
+	var rows []struct {
+		ID               uint
+		LoanNumber       string
+		CustomerID       uint
+		CustomerName     string
+		LoanTerm         int
+		MonthlyPayment   float64
+		RemainingBalance float64
+		DisbursementDate string
+		Paid             float64
+	}
+	err := db.Model(&models.Loan{}).
+		Select("loans.id, loans.loan_number, loans.customer_id, loans.loan_term, loans.monthly_payment, "+
+			"loans.remaining_balance, loans.disbursement_date, customers.first_name || ' ' || customers.last_name AS customer_name, "+
+			"(SELECT COALESCE(SUM(amount), 0) FROM loan_payments WHERE loan_payments.loan_id = loans.id AND loan_payments.paid_at <= ?) AS paid", asOf).
+		Joins("LEFT JOIN customers ON customers.id = loans.customer_id").
+		Where("loans.status = ? AND loans.monthly_payment > 0", "active").
+		Order("loans.id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	report := []Delinquency{}
+	for _, row := range rows {
+		// Date columns read back as either YYYY-MM-DD or a full timestamp depending on the driver
+		if len(row.DisbursementDate) < 10 {
+			continue
+		}
+		disbursed, err := businessdays.ParseDate(row.DisbursementDate[:10])
+		if err != nil {
+			continue
+		}
+		due := 0
+		for due < row.LoanTerm && !disbursed.AddDate(0, due+1, 0).After(asOf) {
+			due++
+		}
+		owed := money.Cents(float64(due) * row.MonthlyPayment)
+		pastDue := money.Cents(owed - row.Paid)
+		if pastDue <= 0 {
+			continue
+		}
+		covered := int(math.Floor((row.Paid + 0.005) / row.MonthlyPayment))
+		oldest := disbursed.AddDate(0, covered+1, 0)
+		days := businessdays.Days(oldest, asOf)
+		if days < minDaysPastDue {
+			continue
+		}
+		report = append(report, Delinquency{
+			LoanID: row.ID, LoanNumber: row.LoanNumber, CustomerID: row.CustomerID, CustomerName: row.CustomerName,
+			MonthlyPayment: row.MonthlyPayment, InstallmentsDue: due, AmountDue: owed, AmountPaid: money.Cents(row.Paid),
+			AmountPastDue: pastDue, OldestUnpaidDate: businessdays.Format(oldest), DaysPastDue: days,
+			RemainingBalance: row.RemainingBalance,
+		})
+	}
+	sort.SliceStable(report, func(i, j int) bool { return report[i].DaysPastDue > report[j].DaysPastDue })
+	return report, nil
+}
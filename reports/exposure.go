@@ -0,0 +1,48 @@
+package reports
+
+import (
+	"banking-app/models"
+	"banking-app/money"
+	"banking-app/tags"
+
+	"gorm.io/gorm"
+)
+
+// Exposure is what one customer owes on active loans, split by how they are liable
+// Direct exposure comes from loans they borrowed or co-borrowed; contingent exposure from loans they
+// guarantee. Each loan counts at the customer's liability percentage of its remaining balance
+type Exposure struct {
+	CustomerID   uint    `json:"customer_id"`
+	CustomerName string  `json:"customer_name"`
+	Direct       float64 `json:"direct"`
+	Contingent   float64 `json:"contingent"`
+	Total        float64 `json:"total"`
+	Loans        int     `json:"loans"`
+}
+
+// Exposures lists every customer with active loan exposure, largest total first
+// A non-empty customerTag limits the report to customers carrying that tag
+func Exposures(db *gorm.DB, customerTag string) ([]Exposure, error) {
+	var rows []Exposure
+	query := db.Model(&models.LoanParty{})
+	if customerTag != "" {
+		query = query.Where("loan_parties.customer_id IN (?)", tags.Tagged(db, tags.SubjectCustomer, customerTag))
+	}
+	err := query.
+		Select("loan_parties.customer_id, customers.first_name || ' ' || customers.last_name AS customer_name, "+
+			"COALESCE(SUM(CASE WHEN loan_parties.role <> ? THEN loans.remaining_balance * loan_parties.liability_percent / 100 ELSE 0 END), 0) AS direct, "+
+			"COALESCE(SUM(CASE WHEN loan_parties.role = ? THEN loans.remaining_balance * loan_parties.liability_percent / 100 ELSE 0 END), 0) AS contingent, "+
+			"COUNT(*) AS loans", "guarantor", "guarantor").
+		Joins("JOIN loans ON loans.id = loan_parties.loan_id AND loans.deleted_at IS NULL").
+		Joins("JOIN customers ON customers.id = loan_parties.customer_id").
+		Where("loans.status = ?", "active").
+		Group("loan_parties.customer_id, customers.first_name, customers.last_name").
+		Order("direct + contingent DESC, loan_parties.customer_id").
+		Scan(&rows).Error
+	for i := range rows {
+		rows[i].Direct = money.Cents(rows[i].Direct)
+		rows[i].Contingent = money.Cents(rows[i].Contingent)
+		rows[i].Total = money.Cents(rows[i].Direct + rows[i].Contingent)
+	}
+	return rows, err
+}
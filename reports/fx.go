@@ -0,0 +1,72 @@
+package reports
+
+import (
+	"banking-app/businessdays"
+	"banking-app/fx"
+	"banking-app/models"
+	"banking-app/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FXCurrency is one foreign currency's exposure at the end of a period and its revaluation impact over it
+type FXCurrency struct {
+	Currency          string     `json:"currency"`
+	AsOf              *time.Time `json:"as_of"` // Last day revalued in the period; nil when none was
+	Accounts          int        `json:"accounts"`
+	Balance           float64    `json:"balance"` // Net customer balance in the currency on that day
+	Rate              float64    `json:"rate"`
+	BaseCurrencyValue float64    `json:"base_currency_value"`
+	Impact            float64    `json:"impact"` // Unrealized gains less losses posted for days in the period
+	DaysRevalued      int        `json:"days_revalued"`
+	FailedDays        []string   `json:"failed_days"` // Days whose revaluation failed, such as for a missing rate
+}
+
+// FXRevaluationSummary is exposure per foreign currency in the base currency and the period's revaluation impact
+type FXRevaluationSummary struct {
+	BaseCurrency           string       `json:"base_currency"`
+	From                   time.Time    `json:"from"`
+	To                     time.Time    `json:"to"` // Exclusive
+	Currencies             []FXCurrency `json:"currencies"`
+	TotalBaseCurrencyValue float64      `json:"total_base_currency_value"`
+	TotalImpact            float64      `json:"total_impact"`
+	Complete               bool         `json:"complete"` // No revaluation in the period failed
+}
+
+// FXRevaluations summarizes the revaluations of days in [from, to), one line per currency
+func FXRevaluations(db *gorm.DB, from, to time.Time) (FXRevaluationSummary, error) {
+	summary := FXRevaluationSummary{BaseCurrency: fx.BaseCurrency, From: from, To: to, Currencies: []FXCurrency{}, Complete: true}
+	var rows []models.FXRevaluation
+	if err := db.Where("date >= ? AND date < ?", from, to).Order("currency, date").Find(&rows).Error; err != nil {
+		return summary, err
+	}
+
+	byCurrency := map[string]*FXCurrency{}
+	var currencies []string
+	for _, row := range rows {
+		line, ok := byCurrency[row.Currency]
+		if !ok {
+			line = &FXCurrency{Currency: row.Currency, FailedDays: []string{}}
+			byCurrency[row.Currency] = line
+			currencies = append(currencies, row.Currency)
+		}
+		if row.Status != fx.StatusPosted {
+			line.FailedDays = append(line.FailedDays, businessdays.Format(row.Date))
+			summary.Complete = false
+			continue
+		}
+		date := row.Date
+		line.AsOf, line.Accounts, line.Balance, line.Rate, line.BaseCurrencyValue = &date, row.Accounts, row.Balance, row.Rate, row.BaseCurrencyValue
+		line.Impact = money.Cents(line.Impact + row.Impact)
+		line.DaysRevalued++
+	}
+
+	for _, currency := range currencies {
+		line := byCurrency[currency]
+		summary.TotalBaseCurrencyValue = money.Cents(summary.TotalBaseCurrencyValue + line.BaseCurrencyValue)
+		summary.TotalImpact = money.Cents(summary.TotalImpact + line.Impact)
+		summary.Currencies = append(summary.Currencies, *line)
+	}
+	return summary, nil
+}
@@ -0,0 +1,69 @@
+package reports
+
+import (
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// InterestLiabilityLine is the interest accrued on one product's accounts and not yet credited to them
+type InterestLiabilityLine struct {
+	Product  string  `json:"product"`        // Account type
+	Name     string  `json:"name,omitempty"` // Product name, when the account type is set up as a product
+	Accrued  float64 `json:"accrued"`
+	Accounts int     `json:"accounts"` // Accounts owed interest
+}
+
+// InterestLiability is the interest owed to deposit accounts in one currency at the end of a day
+type InterestLiability struct {
+	Currency string                  `json:"currency"`
+	AsOf     time.Time               `json:"as_of"`
+	Lines    []InterestLiabilityLine `json:"lines"`
+	Total    float64                 `json:"total"` // Balance of the accrued interest account at the end of the day
+}
+
+// InterestLiabilities sums the entries on a tenant's accrued interest account effective before the end of asOf
+// by product. Each entry is attributed to the account whose daily accrual names it as counterparty, or whose
+// interest credit it settles; the product is that account's type as it is now
+func InterestLiabilities(db *gorm.DB, tenantID uint, currency string, asOf time.Time) (InterestLiability, error) {
+	report := InterestLiability{Currency: currency, AsOf: ledger.StartOfDay(asOf), Lines: []InterestLiabilityLine{}}
+
+	var rows []struct {
+		AccountID uint
+		Product   string
+		Name      string
+		Net       float64
+	}
+	err := db.Model(&models.Transaction{}).
+		Select("owed.id AS account_id, COALESCE(owed.account_type, '') AS product, COALESCE(products.name, '') AS name, "+
+			"COALESCE(SUM("+ledger.SignedAmountOf("transactions")+"), 0) AS net").
+		Joins("JOIN accounts accrued ON accrued.id = transactions.account_id").
+		Joins("LEFT JOIN transactions original ON original.id = transactions.offset_of_id").
+		Joins("LEFT JOIN accounts owed ON owed.id = COALESCE(original.account_id, transactions.counterparty_account_id)").
+		Joins("LEFT JOIN products ON products.tenant_id = owed.tenant_id AND products.account_type = owed.account_type").
+		Where("accrued.account_number = ?", gl.Number(tenantID, gl.AccruedInterest, currency)).
+		Where("transactions.effective_date < ?", ledger.DayAfter(report.AsOf)).
+		Group("owed.id, owed.account_type, products.name").Order("2, 1").Scan(&rows).Error
+	if err != nil {
+		return report, err
+	}
+
+	for _, row := range rows {
+		net := money.Cents(row.Net)
+		if net == 0 {
+			continue
+		}
+		if n := len(report.Lines); n == 0 || report.Lines[n-1].Product != row.Product {
+			report.Lines = append(report.Lines, InterestLiabilityLine{Product: row.Product, Name: row.Name})
+		}
+		line := &report.Lines[len(report.Lines)-1]
+		line.Accrued = money.Cents(line.Accrued + net)
+		line.Accounts++
+		report.Total = money.Cents(report.Total + net)
+	}
+	return report, nil
+}
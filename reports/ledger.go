@@ -0,0 +1,177 @@
+package reports
+
+import (
+	"banking-app/creditlines"
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/money"
+	"banking-app/tags"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TrialBalanceLine is one row of a trial balance
+// Customer accounts are grouped by product; internal accounts are listed individually
+type TrialBalanceLine struct {
+	Account string  `json:"account"`
+	Code    string  `json:"code,omitempty"`
+	Kind    string  `json:"kind"`
+	Debit   float64 `json:"debit"`
+	Credit  float64 `json:"credit"`
+}
+
+// TrialBalance lists every balance in one currency; debits and credits must agree
+type TrialBalance struct {
+	Currency     string             `json:"currency"`
+	Lines        []TrialBalanceLine `json:"lines"`
+	TotalDebits  float64            `json:"total_debits"`
+	TotalCredits float64            `json:"total_credits"`
+	Balanced     bool               `json:"balanced"`
+}
+
+// TrialBalances builds one trial balance per currency from current account balances
+// Balances are stored credit-positive, so a negative balance is a debit. Deleted accounts are
+// included - their balances are still on the books
+func TrialBalances(db *gorm.DB) ([]TrialBalance, error) {
+	var rows []struct {
+		Currency      string
+		AccountType   string
+		AccountNumber string
+		Balance       float64
+	}
+	err := db.Unscoped().Model(&models.Account{}).
+		Select("currency, account_type, CASE WHEN account_type = ? THEN account_number ELSE '' END AS account_number, "+
+			"COALESCE(SUM(balance), 0) AS balance", gl.AccountType).
+		Group("currency, account_type, 3").Order("currency, account_type, 3").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byCurrency := map[string]*TrialBalance{}
+	var currencies []string
+	for _, row := range rows {
+		tb, ok := byCurrency[row.Currency]
+		if !ok {
+			tb = &TrialBalance{Currency: row.Currency, Lines: []TrialBalanceLine{}}
+			byCurrency[row.Currency] = tb
+			currencies = append(currencies, row.Currency)
+		}
+
+		line := TrialBalanceLine{Account: "Customer " + row.AccountType + " accounts", Kind: gl.KindLiability}
+		if row.AccountType == gl.AccountType {
+			line.Account = row.AccountNumber
+			line.Code = gl.Code(row.AccountNumber)
+			line.Kind = gl.Kinds[line.Code]
+		} else if row.AccountType == "loan" || row.AccountType == creditlines.AccountType {
+			line.Kind = gl.KindAsset
+		}
+
+		balance := money.Cents(row.Balance)
+		if balance < 0 {
+			line.Debit = -balance
+		} else {
+			line.Credit = balance
+		}
+		tb.TotalDebits += line.Debit
+		tb.TotalCredits += line.Credit
+		tb.Lines = append(tb.Lines, line)
+	}
+
+	sort.Strings(currencies)
+	result := make([]TrialBalance, 0, len(currencies))
+	for _, currency := range currencies {
+		tb := byCurrency[currency]
+		tb.TotalDebits = money.Cents(tb.TotalDebits)
+		tb.TotalCredits = money.Cents(tb.TotalCredits)
+		tb.Balanced = tb.TotalDebits == tb.TotalCredits
+		result = append(result, *tb)
+	}
+	return result, nil
+}
+
+// IncomeLine is the income or expense booked to one internal account for one product
+type IncomeLine struct {
+	Code    string  `json:"code"`
+	Kind    string  `json:"kind"`
+	Product string  `json:"product"`
+	Amount  float64 `json:"amount"` // Positive income, or positive expense for expense accounts
+	Entries int64   `json:"entries"`
+}
+
+// Income summarizes income and expense in one currency over a period
+type Income struct {
+	Currency     string       `json:"currency"`
+	From         time.Time    `json:"from"`
+	To           time.Time    `json:"to"` // Exclusive
+	Segment      Segment      `json:"segment"`
+	Lines        []IncomeLine `json:"lines"`
+	TotalIncome  float64      `json:"total_income"`
+	TotalExpense float64      `json:"total_expense"`
+	NetIncome    float64      `json:"net_income"`
+}
+
+// Segment limits the income statement to entries offsetting customer accounts carrying tags
+// Entries that offset no customer account, such as manual adjustments, fall outside every segment
+type Segment struct {
+	AccountTag  string `json:"account_tag,omitempty"`  // Tag on the customer account
+	CustomerTag string `json:"customer_tag,omitempty"` // Tag on the account's owner
+}
+
+// IncomeStatement sums the entries on income and expense accounts of one currency in [from, to) by effective date
+// The product is the type of the customer account the entry offsets, or names as its counterparty as interest
+// accrues, or loan for loan payments
+func IncomeStatement(db *gorm.DB, currency string, from, to time.Time, segment Segment) (Income, error) {
+	report := Income{Currency: currency, From: from, To: to, Segment: segment, Lines: []IncomeLine{}}
+
+	var rows []struct {
+		AccountNumber string
+		Product       string
+		Net           float64
+		Entries       int64
+	}
+	query := db.Model(&models.Transaction{})
+	if segment.AccountTag != "" {
+		query = query.Where("product.id IN (?)", tags.Tagged(db, tags.SubjectAccount, segment.AccountTag))
+	}
+	if segment.CustomerTag != "" {
+		query = query.Where("product.customer_id IN (?)", tags.Tagged(db, tags.SubjectCustomer, segment.CustomerTag))
+	}
+	err := query.
+		Select("internal.account_number, "+
+			"CASE WHEN loan_payments.id IS NOT NULL THEN 'loan' ELSE COALESCE(product.account_type, '') END AS product, "+
+			"COALESCE(SUM("+ledger.SignedAmountOf("transactions")+"), 0) AS net, COUNT(*) AS entries").
+		Joins("JOIN accounts internal ON internal.id = transactions.account_id AND internal.account_type = ?", gl.AccountType).
+		Joins("LEFT JOIN transactions original ON original.id = transactions.offset_of_id").
+		Joins("LEFT JOIN accounts product ON product.id = COALESCE(original.account_id, transactions.counterparty_account_id)").
+		Joins("LEFT JOIN loan_payments ON loan_payments.transaction_id = COALESCE(original.reversal_of_id, original.id)").
+		Where("internal.currency = ?", currency).
+		Where("transactions.effective_date >= ? AND transactions.effective_date < ?", from, to).
+		Group("internal.account_number, 2").Order("internal.account_number, 2").Scan(&rows).Error
+	if err != nil {
+		return report, err
+	}
+
+	for _, row := range rows {
+		code := gl.Code(row.AccountNumber)
+		kind := gl.Kinds[code]
+		line := IncomeLine{Code: code, Kind: kind, Product: row.Product, Entries: row.Entries}
+		switch kind {
+		case gl.KindIncome:
+			line.Amount = money.Cents(row.Net)
+			report.TotalIncome += line.Amount
+		case gl.KindExpense:
+			line.Amount = money.Cents(-row.Net)
+			report.TotalExpense += line.Amount
+		default:
+			continue
+		}
+		report.Lines = append(report.Lines, line)
+	}
+	report.TotalIncome = money.Cents(report.TotalIncome)
+	report.TotalExpense = money.Cents(report.TotalExpense)
+	report.NetIncome = money.Cents(report.TotalIncome - report.TotalExpense)
+	return report, nil
+}
@@ -0,0 +1,39 @@
+package reports
+
+import (
+	"banking-app/gl"
+	"banking-app/models"
+	"banking-app/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Volume is the number and value of one type of customer posting on one day in one currency
+type Volume struct {
+	Date            string  `json:"date"` // YYYY-MM-DD, UTC
+	Currency        string  `json:"currency"`
+	TransactionType string  `json:"transaction_type"`
+	Count           int64   `json:"count"`
+	Amount          float64 `json:"amount"`
+}
+
+// TransactionVolume totals postings on customer accounts with effective dates in [from, to), by day, currency
+// and type. General-ledger entries are left out, so each customer posting counts once
+// An empty currency covers every currency
+func TransactionVolume(db *gorm.DB, currency string, from, to time.Time) ([]Volume, error) {
+	query := db.Model(&models.Transaction{}).
+		Select("date(transactions.effective_date) AS date, accounts.currency, transactions.transaction_type, "+
+			"COUNT(*) AS count, COALESCE(SUM(transactions.amount), 0) AS amount").
+		Joins("JOIN accounts ON accounts.id = transactions.account_id AND accounts.account_type <> ?", gl.AccountType).
+		Where("transactions.effective_date >= ? AND transactions.effective_date < ?", from, to)
+	if currency != "" {
+		query = query.Where("accounts.currency = ?", currency)
+	}
+	rows := []Volume{}
+	err := query.Group("1, 2, 3").Order("1, 2, 3").Scan(&rows).Error
+	for i := range rows {
+		rows[i].Amount = money.Cents(rows[i].Amount)
+	}
+	return rows, err
+}
@@ -0,0 +1,126 @@
+package restrictions
+
+import (
+	"banking-app/clock"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// Approval statuses
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+// Approval kinds - the API a held debit came through, which posts it once approved
+const (
+	KindTransaction = "transaction"
+	KindTransfer    = "transfer"
+)
+
+// Restriction and approval errors - handlers map these to client responses
+var (
+	ErrDepositOnly           = errors.New("account accepts deposits only")
+	ErrOutboundTransfers     = errors.New("account does not allow outbound transfers")
+	ErrApprovalRequired      = errors.New("withdrawals from this account need staff approval")
+	ErrNotPending            = errors.New("approval has already been decided")
+	ErrRequesterCannotDecide = errors.New("the user who requested a withdrawal cannot decide it")
+)
+
+// debitTypes are the customer debits restrictions apply to; fees and interest are bank-originated and always post
+var debitTypes = []string{"withdrawal", "transfer", "payment"}
+
+// Restricts reports whether restrictions apply to a transaction type
+func Restricts(transactionType string) bool {
+	for _, t := range debitTypes {
+		if t == transactionType {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow checks a debit against an account's restrictions
+// approved is set when staff approved the debit from the queue, which lifts only the approval requirement
+func Allow(r models.AccountRestrictions, transactionType string, approved bool) error {
+	if !Restricts(transactionType) {
+		return nil
+	}
+	switch {
+	case r.DepositOnly:
+		return ErrDepositOnly
+	case r.NoOutboundTransfers && transactionType == "transfer":
+		return ErrOutboundTransfers
+	case r.StaffApprovalForWithdrawals && !approved:
+		return ErrApprovalRequired
+	}
+	return nil
+}
+
+// Check loads an account inside an open transaction and checks a debit from it
+func Check(tx *gorm.DB, accountID uint, transactionType string, approved bool) error {
+	if !Restricts(transactionType) {
+		return nil
+	}
+	var account models.Account
+	if err := tx.Select("id, restriction_deposit_only, restriction_no_outbound_transfers, restriction_staff_approval_for_withdrawals").
+		First(&account, accountID).Error; err != nil {
+		return err
+	}
+	return Allow(account.Restrictions, transactionType, approved)
+}
+
+// Hold queues a debit for staff approval
+func Hold(db *gorm.DB, approval *models.WithdrawalApproval) error {
+	approval.ID = 0
+	approval.Status = StatusPending
+	return db.Create(approval).Error
+}
+
+// Claim marks a pending approval approved before its debit is posted, so two approvers cannot both post it
+// The maker cannot be the checker. Release puts the approval back when the posting fails
+func Claim(db *gorm.DB, approval *models.WithdrawalApproval, by, note string) error {
+	return decide(db, approval, StatusApproved, by, note)
+}
+
+// Release returns a claimed approval to the queue after its debit failed to post
+func Release(db *gorm.DB, approval *models.WithdrawalApproval) error {
+	approval.Status, approval.DecidedAt, approval.DecidedBy, approval.DecisionNote = StatusPending, nil, "", ""
+	return db.Model(approval).Select("status", "decided_at", "decided_by", "decision_note").Updates(approval).Error
+}
+
+// Complete links an approved approval to what its debit posted
+func Complete(db *gorm.DB, approval *models.WithdrawalApproval, transactionID, transferID *uint) error {
+	approval.TransactionID, approval.TransferID = transactionID, transferID
+	return db.Model(approval).Select("transaction_id", "transfer_id").Updates(approval).Error
+}
+
+// Reject takes a pending approval out of the queue without posting it
+func Reject(db *gorm.DB, approval *models.WithdrawalApproval, by, note string) error {
+	return decide(db, approval, StatusRejected, by, note)
+}
+
+// decide moves a pending approval to status; the status condition makes concurrent decisions fail with ErrNotPending
+func decide(db *gorm.DB, approval *models.WithdrawalApproval, status, by, note string) error {
+	if !lifecycle.Allows(lifecycle.WithdrawalApproval, approval.Status, status) {
+		return ErrNotPending
+	}
+	if approval.RequestedBy == by {
+		return ErrRequesterCannotDecide
+	}
+	now := clock.Now()
+	result := db.Model(&models.WithdrawalApproval{}).Where("id = ? AND status = ?", approval.ID, StatusPending).
+		Updates(map[string]interface{}{"status": status, "decided_at": now, "decided_by": by, "decision_note": note})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotPending
+	}
+	approval.Status, approval.DecidedAt, approval.DecidedBy, approval.DecisionNote = status, &now, by, note
+	return nil
+}
@@ -0,0 +1,385 @@
+package reviews
+
+import (
+	"banking-app/alerts"
+	"banking-app/cache"
+	"banking-app/clock"
+	"banking-app/communications"
+	"banking-app/creditlines"
+	"banking-app/enrichment"
+	"banking-app/fees"
+	"banking-app/flags"
+	"banking-app/gl"
+	"banking-app/ledger"
+	"banking-app/liens"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"banking-app/notifications"
+	"banking-app/restrictions"
+	"banking-app/tenancy"
+	"banking-app/transfers"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Review statuses
+const (
+	StatusPending  = ledger.ReviewPending
+	StatusApproved = "approved"
+	StatusDeclined = "declined"
+)
+
+// Review kinds - the API a held debit came through
+const (
+	KindTransaction = "transaction"
+	KindTransfer    = "transfer"
+)
+
+// SystemActor decides reviews whose review time passed
+const SystemActor = "system"
+
+// Review errors - handlers map these to client responses
+var (
+	ErrNotPending            = errors.New("review has already been decided")
+	ErrRequesterCannotDecide = errors.New("the user who requested a debit cannot decide its review")
+)
+
+// reviewedTypes are the debits the rule holds; deposits, payments and bank-originated postings always post
+var reviewedTypes = []string{"withdrawal", "transfer"}
+
+// Config holds the rule deciding which debits are held
+type Config struct {
+	NewFor     time.Duration // Accounts opened within this are new
+	Threshold  float64       // Debits above this amount are held
+	ReviewTime time.Duration // Held debits not decided within this are approved automatically
+}
+
+// ConfigFromEnv reads NEW_ACCOUNT_REVIEW_DAYS (default 30), NEW_ACCOUNT_REVIEW_THRESHOLD (default 250) and
+// NEW_ACCOUNT_REVIEW_HOURS (default 24)
+// The rule only applies to customers the new_account_review flag is enabled for
+func ConfigFromEnv() Config {
+	days, err := strconv.Atoi(os.Getenv("NEW_ACCOUNT_REVIEW_DAYS"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+	threshold, err := strconv.ParseFloat(os.Getenv("NEW_ACCOUNT_REVIEW_THRESHOLD"), 64)
+	if err != nil || threshold < 0 {
+		threshold = 250
+	}
+	hours, err := strconv.Atoi(os.Getenv("NEW_ACCOUNT_REVIEW_HOURS"))
+	if err != nil || hours <= 0 {
+		hours = 24
+	}
+	return Config{
+		NewFor:     time.Duration(days) * 24 * time.Hour,
+		Threshold:  threshold,
+		ReviewTime: time.Duration(hours) * time.Hour,
+	}
+}
+
+// Reviewed reports whether the rule applies to a transaction type
+func Reviewed(transactionType string) bool {
+	for _, t := range reviewedTypes {
+		if t == transactionType {
+			return true
+		}
+	}
+	return false
+}
+
+// applies reports whether an account is one the rule holds debits from
+// Restricted accounts are left to their restrictions, which refuse the debit or queue it for staff approval
+func applies(account models.Account, transactionType string, cfg Config, featureFlags *flags.Store, now time.Time) bool {
+	r := account.Restrictions
+	switch {
+	case account.AccountType == gl.AccountType, account.Status != "active":
+		return false
+	case r.DepositOnly, r.StaffApprovalForWithdrawals, r.NoOutboundTransfers && transactionType == "transfer":
+		return false
+	case now.Sub(account.CreatedAt) >= cfg.NewFor:
+		return false
+	}
+	return featureFlags != nil && featureFlags.Enabled(flags.NewAccountReview, account.CustomerID)
+}
+
+// Hold queues a debit for review when the rule applies to it, returning nil when it should post now
+// The rule holds a withdrawal or transfer above the threshold from an account younger than cfg.NewFor until one
+// such debit has posted. The held amount leaves the account's available funds, so the debit must fit in them.
+// A transfer retried with its Idempotency-Key returns the review first held for it
+func Hold(db *gorm.DB, cfg Config, featureFlags *flags.Store, review models.TransactionReview, now time.Time) (*models.TransactionReview, error) {
+	if !Reviewed(review.TransactionType) || review.Amount <= cfg.Threshold {
+		return nil, nil
+	}
+	var held *models.TransactionReview
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var account models.Account
+		if err := tx.First(&account, review.AccountID).Error; err != nil {
+			return err
+		}
+		if !applies(account, review.TransactionType, cfg, featureFlags, now) {
+			return nil
+		}
+		// A large debit already posted, approved here or made before the rule applied, was the first
+		var posted int64
+		if err := tx.Model(&models.Transaction{}).Where("account_id = ? AND transaction_type IN ? AND amount > ?",
+			account.ID, reviewedTypes, cfg.Threshold).Count(&posted).Error; err != nil {
+			return err
+		}
+		if posted > 0 {
+			return nil
+		}
+
+		if review.IdempotencyKey != "" {
+			var existing models.TransactionReview
+			if err := tx.Where("idempotency_key = ? AND account_id = ? AND status = ?", review.IdempotencyKey, account.ID, StatusPending).
+				Limit(1).Find(&existing).Error; err != nil {
+				return err
+			}
+			if existing.ID != 0 {
+				held = &existing
+				return nil
+			}
+			// A key already used for a posted transfer is replayed by the transfer itself
+			var used int64
+			if err := tx.Model(&models.Transfer{}).Where("idempotency_key = ?", review.IdempotencyKey).Count(&used).Error; err != nil {
+				return err
+			}
+			if used > 0 {
+				return nil
+			}
+		}
+		if review.Kind == KindTransfer {
+			if err := checkDestination(tx, account, review.ToAccountID); err != nil {
+				return err
+			}
+		}
+		available, err := ledger.Available(tx, account, featureFlags)
+		if err != nil {
+			return err
+		}
+		if available < review.Amount {
+			return ledger.ErrInsufficientFunds
+		}
+
+		review.ID = 0
+		review.Status = StatusPending
+		review.CustomerID = account.CustomerID
+		review.ReviewBy = now.Add(cfg.ReviewTime)
+		if err := tx.Create(&review).Error; err != nil {
+			return err
+		}
+		held = &review
+		return nil
+	})
+	return held, err
+}
+
+// checkDestination refuses a transfer when it is held rather than when it is approved, if it could never post
+func checkDestination(tx *gorm.DB, from models.Account, toAccountID uint) error {
+	var to models.Account
+	if err := tx.Select("id, status, currency").First(&to, toAccountID).Error; err != nil {
+		return err
+	}
+	if to.Status != "active" {
+		return transfers.ErrDestinationInactive
+	}
+	if to.Currency != from.Currency {
+		return transfers.ErrCurrencyMismatch
+	}
+	return nil
+}
+
+// Posted is what an approved review posted
+type Posted struct {
+	Transaction *models.Transaction // Withdrawal posted; nil for a transfer
+	Transfer    *transfers.Result   // Transfer posted; nil for a withdrawal
+	Fee         *models.Transaction // Fee charged with it, if any
+}
+
+// Approve posts a held debit as it was requested, effective now, and links it to the review
+// A debit that no longer posts, such as one a lien placed since now blocks, fails with the posting's error and
+// stays pending. Balances are refreshed in the cache and alert rules evaluated once it commits
+func Approve(db *gorm.DB, review *models.TransactionReview, by, note string, cfg transfers.Config, featureFlags *flags.Store, balances *cache.Balances) (Posted, error) {
+	var posted Posted
+	auto := by == SystemActor
+	if !lifecycle.Allows(lifecycle.TransactionReview, review.Status, StatusApproved) {
+		return posted, ErrNotPending
+	}
+	if !auto && review.RequestedBy == by {
+		return posted, ErrRequesterCannotDecide
+	}
+	// Jobs approve without a tenant context; the postings belong to the review's tenant
+	db = db.WithContext(tenancy.NewContext(db.Statement.Context, review.TenantID))
+
+	original := *review
+	var accounts []models.Account
+	var drawn *models.CreditLine
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Deciding first takes the review's own amount out of the hold the posting's funds check sees
+		if err := decide(tx, review, StatusApproved, by, note, auto); err != nil {
+			return err
+		}
+		var tenant models.Tenant
+		if err := tx.Limit(1).Find(&tenant, review.TenantID).Error; err != nil {
+			return err
+		}
+		cutoffs := tenancy.SettingsFor(tenant).Cutoffs
+
+		if review.Kind == KindTransfer {
+			result, err := transfers.Post(tx, transfers.Request{
+				FromAccountID:    review.AccountID,
+				ToAccountID:      review.ToAccountID,
+				Amount:           review.Amount,
+				Description:      review.Description,
+				Reference:        review.Reference,
+				Channel:          review.Channel,
+				ConfirmDuplicate: true, // The review itself was the original request
+				IdempotencyKey:   review.IdempotencyKey,
+				CreatedBy:        review.RequestedBy,
+				Cutoffs:          cutoffs,
+			}, cfg, featureFlags)
+			if err != nil {
+				return err
+			}
+			posted.Transfer, posted.Fee = &result, result.Fee
+			accounts = []models.Account{result.From, result.To}
+			review.TransactionID, review.TransferID = &result.Transfer.DebitTransactionID, &result.Transfer.ID
+			return tx.Model(review).Select("transaction_id", "transfer_id").Updates(review).Error
+		}
+
+		transaction := &models.Transaction{
+			AccountID:       review.AccountID,
+			TransactionType: review.TransactionType,
+			Amount:          review.Amount,
+			Description:     review.Description,
+			Memo:            review.Memo,
+			Reference:       review.Reference,
+			Channel:         review.Channel,
+		}
+		if rules, err := enrichment.LoadRules(tx); err == nil {
+			enrichment.Apply(rules, transaction)
+		}
+		if err := restrictions.Check(tx, transaction.AccountID, transaction.TransactionType, false); err != nil {
+			return err
+		}
+		valueDate, err := ledger.ValueDate(tx, transaction.TransactionType, time.Time{}, cutoffs)
+		if err != nil {
+			return err
+		}
+		transaction.ValueDate = &valueDate
+		if drawn, err = creditlines.Cover(tx, transaction); err != nil {
+			return err
+		}
+		account, err := ledger.PostExternal(tx, transaction, featureFlags)
+		if err != nil {
+			return err
+		}
+		if posted.Fee, account, err = fees.Charge(tx, *transaction, account, featureFlags); err != nil {
+			return err
+		}
+		if err := liens.Enforce(tx, account); err != nil {
+			return err
+		}
+		posted.Transaction = transaction
+		accounts = []models.Account{account}
+		review.TransactionID = &transaction.ID
+		return tx.Model(review).Select("transaction_id").Updates(review).Error
+	})
+	if err != nil {
+		*review = original
+		return Posted{}, err
+	}
+
+	for _, account := range accounts {
+		balances.Invalidate(account.ID)
+	}
+	if drawn != nil {
+		balances.Invalidate(drawn.AccountID)
+	}
+	if posted.Transfer != nil {
+		alerts.EvaluateTransaction(db, posted.Transfer.From, posted.Transfer.Debit)
+		alerts.EvaluateTransaction(db, posted.Transfer.To, posted.Transfer.Credit)
+	} else {
+		alerts.EvaluateTransaction(db, accounts[0], *posted.Transaction)
+	}
+	return posted, nil
+}
+
+// Decline takes a held debit out of the queue without posting it, releasing its hold, and tells the customer
+// The notice goes to the customer's email once it is verified
+func Decline(db *gorm.DB, review *models.TransactionReview, by, note string) error {
+	if !lifecycle.Allows(lifecycle.TransactionReview, review.Status, StatusDeclined) {
+		return ErrNotPending
+	}
+	if review.RequestedBy == by {
+		return ErrRequesterCannotDecide
+	}
+	var account models.Account
+	if err := db.Select("id, account_number, currency").First(&account, review.AccountID).Error; err != nil {
+		return err
+	}
+	var customer models.Customer
+	db.Select("id, email, email_verified").Limit(1).Find(&customer, review.CustomerID)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := decide(tx, review, StatusDeclined, by, note, false); err != nil {
+			return err
+		}
+		if customer.Email == "" || !customer.EmailVerified {
+			log.Printf("reviews: customer %d has no verified email for the decline of review %d", review.CustomerID, review.ID)
+			return nil
+		}
+		return notifications.Enqueue(tx, &models.Notification{
+			CustomerID:   review.CustomerID,
+			Channel:      "email",
+			ResourceType: communications.ResourceTransactionReview,
+			ResourceID:   review.ID,
+			Recipient:    customer.Email,
+			Subject:      "Your " + review.TransactionType + " was declined",
+			Body: fmt.Sprintf("Your %s of %.2f %s from account %s was reviewed and declined, so it was not made. "+
+				"The amount held for it is available again.", review.TransactionType, review.Amount, account.Currency, account.AccountNumber),
+		})
+	})
+}
+
+// AutoApprove posts the held debits whose review time has passed without a decision, returning how many posted
+// One that fails to post is logged and stays pending, for staff to decline or for the next run
+func AutoApprove(db *gorm.DB, cfg transfers.Config, featureFlags *flags.Store, balances *cache.Balances, now time.Time) (int, error) {
+	var due []models.TransactionReview
+	if err := db.Where("status = ? AND review_by <= ?", StatusPending, now).Order("review_by, id").Find(&due).Error; err != nil {
+		return 0, err
+	}
+	approved := 0
+	for i := range due {
+		_, err := Approve(db, &due[i], SystemActor, "Approved automatically when the review time passed", cfg, featureFlags, balances)
+		switch {
+		case err == nil:
+			approved++
+		case errors.Is(err, ErrNotPending):
+		default:
+			log.Printf("reviews: held %s %d did not post: %v", due[i].TransactionType, due[i].ID, err)
+		}
+	}
+	return approved, nil
+}
+
+// decide moves a pending review to status; the status condition makes concurrent decisions fail with ErrNotPending
+func decide(tx *gorm.DB, review *models.TransactionReview, status, by, note string, auto bool) error {
+	now := clock.Now()
+	result := tx.Model(&models.TransactionReview{}).Where("id = ? AND status = ?", review.ID, StatusPending).
+		Updates(map[string]interface{}{"status": status, "decided_at": now, "decided_by": by, "decision_note": note, "auto_approved": auto})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotPending
+	}
+	review.Status, review.DecidedAt, review.DecidedBy, review.DecisionNote, review.AutoApproved = status, &now, by, note, auto
+	return nil
+}
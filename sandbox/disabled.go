@@ -0,0 +1,12 @@
+//go:build !sandbox
+
+package sandbox
+
+// Available reports whether this binary was built with sandbox mode; production builds leave it out,
+// so nothing in them can move the clock or wipe a database
+const Available = false
+
+// wipe is never reached, since New refuses to create a sandbox in this build
+func (s *Sandbox) wipe() error {
+	return ErrUnavailable
+}
@@ -0,0 +1,32 @@
+//go:build sandbox
+
+package sandbox
+
+import "gorm.io/gorm"
+
+// Available reports whether this binary was built with sandbox mode
+const Available = true
+
+// wipe drops every table in the sandbox database, including the search index and its triggers
+func (s *Sandbox) wipe() error {
+	return s.db.Connection(func(conn *gorm.DB) error {
+		// Tables go in no particular order, so foreign keys must not hold them back
+		if err := conn.Exec("PRAGMA foreign_keys = OFF").Error; err != nil {
+			return err
+		}
+		defer conn.Exec("PRAGMA foreign_keys = ON")
+		var tables []string
+		err := conn.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY sql LIKE 'CREATE VIRTUAL%' DESC").
+			Scan(&tables).Error
+		if err != nil {
+			return err
+		}
+		// Dropping a virtual table drops its shadow tables too, so those may already be gone
+		for _, table := range tables {
+			if err := conn.Exec(`DROP TABLE IF EXISTS "` + table + `"`).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
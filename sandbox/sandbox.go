@@ -0,0 +1,173 @@
+package sandbox
+
+import (
+	"banking-app/clock"
+	"banking-app/jobs"
+	"banking-app/models"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DefaultDBPath is the database file sandbox data is kept in
+const DefaultDBPath = "sandbox.db"
+
+// MaxAdvance bounds how far one call moves the clock, so a typo cannot queue years of job runs
+const MaxAdvance = 366 * 24 * time.Hour
+
+// Errors returned by the sandbox; handlers map these to client responses
+var (
+	ErrUnavailable = errors.New("sandbox mode is not available in this build; build with -tags sandbox")
+	ErrAdvance     = errors.New("time can only move forward, by at most 366 days at once")
+)
+
+// DefaultTimeoutDelay is how long a transfer to the timeout account is held
+const DefaultTimeoutDelay = 30 * time.Second
+
+// Config holds sandbox mode settings
+type Config struct {
+	Enabled      bool
+	DBPath       string        // Separate from DB_PATH, so sandbox data never mixes with real data
+	Start        time.Time     // Where the fake clock starts, and where a reset puts it back
+	TimeoutDelay time.Duration // How long a transfer to the timeout account is held
+}
+
+// ConfigFromEnv reads SANDBOX_MODE, SANDBOX_DB_PATH (default sandbox.db), SANDBOX_START (YYYY-MM-DD,
+// default today) and SANDBOX_TIMEOUT_SECONDS (default 30). The clock starts at midnight UTC on the start day,
+// so runs are repeatable
+func ConfigFromEnv() Config {
+	cfg := Config{DBPath: DefaultDBPath, TimeoutDelay: DefaultTimeoutDelay}
+	if raw := os.Getenv("SANDBOX_MODE"); raw != "" {
+		if enabled, err := strconv.ParseBool(raw); err == nil {
+			cfg.Enabled = enabled
+		} else {
+			log.Printf("sandbox: ignoring invalid SANDBOX_MODE %q", raw)
+		}
+	}
+	if path := os.Getenv("SANDBOX_DB_PATH"); path != "" {
+		cfg.DBPath = path
+	}
+	cfg.Start = time.Now().UTC().Truncate(24 * time.Hour)
+	if raw := os.Getenv("SANDBOX_START"); raw != "" {
+		if start, err := time.Parse("2006-01-02", raw); err == nil {
+			cfg.Start = start
+		} else {
+			log.Printf("sandbox: ignoring invalid SANDBOX_START %q", raw)
+		}
+	}
+	if raw := os.Getenv("SANDBOX_TIMEOUT_SECONDS"); raw != "" {
+		if seconds, err := strconv.Atoi(raw); err == nil && seconds >= 0 {
+			cfg.TimeoutDelay = time.Duration(seconds) * time.Second
+		} else {
+			log.Printf("sandbox: ignoring invalid SANDBOX_TIMEOUT_SECONDS %q", raw)
+		}
+	}
+	return cfg
+}
+
+// Sandbox owns the fake clock and the sandbox database
+type Sandbox struct {
+	cfg       Config
+	db        *gorm.DB
+	clock     *clock.Fake
+	scheduler *jobs.Scheduler
+	seed      func(db *gorm.DB) error
+
+	mu sync.Mutex // Serializes moving the clock and resetting
+
+	simMu       sync.Mutex            // Guards simulations
+	simulations map[string]Simulation // Forced errors and latency pending per caller
+}
+
+// Status describes the sandbox, as returned by the status endpoint
+type Status struct {
+	Now    time.Time `json:"now"`
+	Start  time.Time `json:"start"`
+	DBPath string    `json:"db_path"`
+}
+
+// Advanced describes one move of the clock and the scheduled runs it caught up on
+type Advanced struct {
+	From   time.Time       `json:"from"`
+	To     time.Time       `json:"to"`
+	Runs   int             `json:"runs"`
+	ByJob  map[string]int  `json:"by_job"` // Runs made per job
+	Failed []models.JobRun `json:"failed"` // Runs that ended in an error, which do not stop the clock
+}
+
+// New installs a fake clock as the application clock and opens the magic accounts. Scheduled jobs then only
+// run when the clock is advanced, so the scheduler must not be started. seed re-creates the startup data after
+// a reset. It fails unless the binary was built with the sandbox tag
+func New(cfg Config, db *gorm.DB, scheduler *jobs.Scheduler, seed func(db *gorm.DB) error) (*Sandbox, error) {
+	if !Available {
+		return nil, ErrUnavailable
+	}
+	fake := clock.NewFake(cfg.Start)
+	clock.Use(fake)
+	s := &Sandbox{cfg: cfg, db: db, clock: fake, scheduler: scheduler, seed: seed, simulations: map[string]Simulation{}}
+	return s, s.seedMagicAccounts()
+}
+
+// Status returns the fake time and where sandbox data is kept
+func (s *Sandbox) Status() Status {
+	return Status{Now: s.clock.Now(), Start: s.cfg.Start, DBPath: s.cfg.DBPath}
+}
+
+// Advance moves the clock forward by d, running each scheduled job due in the skipped period at its
+// scheduled time, in order. The clock is left at the last run made when a run cannot start
+func (s *Sandbox) Advance(d time.Duration) (Advanced, error) {
+	if d <= 0 || d > MaxAdvance {
+		return Advanced{}, ErrAdvance
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from := s.clock.Now()
+	to := from.Add(d)
+	runs, err := s.scheduler.RunDue(from, to, s.clock.Set)
+	if err == nil {
+		s.clock.Set(to)
+	}
+	advanced := Advanced{From: from, To: s.clock.Now(), Runs: len(runs), ByJob: map[string]int{}, Failed: []models.JobRun{}}
+	for _, run := range runs {
+		advanced.ByJob[run.JobName]++
+		if run.Status == jobs.StatusFailed {
+			advanced.Failed = append(advanced.Failed, run)
+		}
+	}
+	return advanced, err
+}
+
+// Reset deletes all sandbox data and pending simulations, seeds the database again and puts the clock back at
+// the start
+func (s *Sandbox) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.wipe(); err != nil {
+		return err
+	}
+	s.clock.Set(s.cfg.Start)
+	s.simMu.Lock()
+	s.simulations = map[string]Simulation{}
+	s.simMu.Unlock()
+	if err := s.seed(s.db); err != nil {
+		return err
+	}
+	return s.seedMagicAccounts()
+}
+
+// Middleware marks every response as coming from the sandbox, with the fake time it was served at
+func (s *Sandbox) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("X-Sandbox", "true")
+		c.Header("X-Sandbox-Time", s.clock.Now().UTC().Format(time.RFC3339))
+		c.Next()
+	}
+}
@@ -0,0 +1,168 @@
+package sandbox
+
+import (
+	"banking-app/models"
+	"banking-app/tenancy"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Magic account numbers: transfers to these sandbox accounts always end the same way, so partners can test
+// conditions that are hard to reproduce against real data
+const (
+	AccountInsufficient = "ACC-SIM-INSUFFICIENT" // Refused with INSUFFICIENT_FUNDS
+	AccountTimeout      = "ACC-SIM-TIMEOUT"      // Answered only after the timeout delay, then posted as usual
+	AccountFrozen       = "ACC-SIM-FROZEN"       // Refused with ACCOUNT_FROZEN
+)
+
+// MagicAccounts lists the magic account numbers in the order they are seeded
+var MagicAccounts = []string{AccountInsufficient, AccountTimeout, AccountFrozen}
+
+// Simulation limits, so a typo cannot leave a caller failing or stalled for long
+const (
+	MaxSimulatedRequests = 100
+	MaxSimulatedLatency  = 60 * time.Second
+)
+
+// SimulatedCodes are the error codes a caller can force, with the status each is returned with by default
+var SimulatedCodes = map[string]int{
+	"INSUFFICIENT_FUNDS":  http.StatusBadRequest,
+	"ACCOUNT_FROZEN":      http.StatusForbidden,
+	"INVALID_REQUEST":     http.StatusBadRequest,
+	"UNAUTHORIZED":        http.StatusUnauthorized,
+	"PERMISSION_DENIED":   http.StatusForbidden,
+	"NOT_FOUND":           http.StatusNotFound,
+	"CONFLICT":            http.StatusConflict,
+	"RATE_LIMITED":        http.StatusTooManyRequests,
+	"INTERNAL_ERROR":      http.StatusInternalServerError,
+	"SERVICE_UNAVAILABLE": http.StatusServiceUnavailable,
+	"GATEWAY_TIMEOUT":     http.StatusGatewayTimeout,
+}
+
+// ErrSimulation is returned for a simulation that forces nothing or goes beyond the limits
+var ErrSimulation = errors.New("a simulation needs a known error_code or a latency of at most 60 seconds, for 1 to 100 requests")
+
+// Simulation is what the next requests of one caller are forced to do
+type Simulation struct {
+	ErrorCode string        `json:"error_code,omitempty"` // Returned instead of handling the request; empty adds latency only
+	Status    int           `json:"status,omitempty"`     // HTTP status of the forced error
+	Latency   time.Duration `json:"-"`                    // Added before the request is answered
+	LatencyMS int64         `json:"latency_ms"`           // Latency, as shown to callers
+	Remaining int           `json:"remaining"`            // Requests still to be affected
+}
+
+// Simulate forces the caller's next sim.Remaining requests to wait sim.Latency and, with an error code, to fail
+// with it. It replaces whatever the caller had pending
+func (s *Sandbox) Simulate(caller string, sim Simulation) (Simulation, error) {
+	sim.ErrorCode = strings.ToUpper(strings.TrimSpace(sim.ErrorCode))
+	if sim.Remaining == 0 {
+		sim.Remaining = 1
+	}
+	if sim.Remaining < 0 || sim.Remaining > MaxSimulatedRequests || sim.Latency < 0 || sim.Latency > MaxSimulatedLatency {
+		return Simulation{}, ErrSimulation
+	}
+	if sim.ErrorCode == "" {
+		if sim.Latency == 0 || sim.Status != 0 {
+			return Simulation{}, ErrSimulation
+		}
+	} else {
+		status, known := SimulatedCodes[sim.ErrorCode]
+		if !known || (sim.Status != 0 && (sim.Status < 400 || sim.Status > 599)) {
+			return Simulation{}, ErrSimulation
+		}
+		if sim.Status == 0 {
+			sim.Status = status
+		}
+	}
+	sim.LatencyMS = sim.Latency.Milliseconds()
+
+	s.simMu.Lock()
+	defer s.simMu.Unlock()
+	s.simulations[caller] = sim
+	return sim, nil
+}
+
+// Simulation returns what the caller has pending, if anything
+func (s *Sandbox) Simulation(caller string) (Simulation, bool) {
+	s.simMu.Lock()
+	defer s.simMu.Unlock()
+	sim, ok := s.simulations[caller]
+	return sim, ok
+}
+
+// ClearSimulation drops what the caller has pending
+func (s *Sandbox) ClearSimulation(caller string) {
+	s.simMu.Lock()
+	defer s.simMu.Unlock()
+	delete(s.simulations, caller)
+}
+
+// TakeSimulation uses up one of the caller's pending requests and returns what it must do
+func (s *Sandbox) TakeSimulation(caller string) (Simulation, bool) {
+	s.simMu.Lock()
+	defer s.simMu.Unlock()
+	sim, ok := s.simulations[caller]
+	if !ok {
+		return sim, false
+	}
+	sim.Remaining--
+	if sim.Remaining <= 0 {
+		delete(s.simulations, caller)
+	} else {
+		s.simulations[caller] = sim
+	}
+	return sim, true
+}
+
+// TimeoutDelay is how long a transfer to the timeout account is held before it is handled
+func (s *Sandbox) TimeoutDelay() time.Duration {
+	return s.cfg.TimeoutDelay
+}
+
+// MagicAccount returns the magic account number of an account, if it is one of the magic accounts
+func (s *Sandbox) MagicAccount(accountID uint) (string, bool) {
+	var numbers []string
+	if err := s.db.Model(&models.Account{}).Where("id = ?", accountID).Pluck("account_number", &numbers).Error; err != nil || len(numbers) == 0 {
+		return "", false
+	}
+	for _, magic := range MagicAccounts {
+		if numbers[0] == magic {
+			return magic, true
+		}
+	}
+	return "", false
+}
+
+// seedMagicAccounts opens the magic accounts in the default tenant, under a customer of their own
+func (s *Sandbox) seedMagicAccounts() error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		customer := models.Customer{
+			TenantID:  tenancy.DefaultTenantID,
+			FirstName: "Sandbox",
+			LastName:  "Simulations",
+			Email:     "simulations@sandbox.invalid",
+			Status:    "active",
+		}
+		if err := tx.Where(models.Customer{TenantID: customer.TenantID, Email: customer.Email}).FirstOrCreate(&customer).Error; err != nil {
+			return err
+		}
+		for _, number := range MagicAccounts {
+			account := models.Account{
+				TenantID:      customer.TenantID,
+				AccountNumber: number,
+				CustomerID:    customer.ID,
+				AccountType:   "checking",
+				Currency:      "USD",
+				Status:        "active",
+			}
+			if err := tx.Where(models.Account{AccountNumber: number}).FirstOrCreate(&account).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,89 @@
+package sar
+
+import (
+	"banking-app/archive"
+	"banking-app/models"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BundleVersion identifies the layout of an export bundle
+const BundleVersion = 1
+
+// Attachment is one document in a bundle's manifest; the filer fetches it from Path and checks it against SHA256
+type Attachment struct {
+	DocumentID  uint      `json:"document_id"`
+	Filename    string    `json:"filename"`
+	Kind        string    `json:"kind"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	Path        string    `json:"path"` // API path serving the stored file
+	AddedBy     string    `json:"added_by"`
+	AddedAt     time.Time `json:"added_at"`
+}
+
+// Bundle is everything a SAR filing draws on: the case, its subject, linked transactions and notes, and a
+// manifest of the attached documents
+type Bundle struct {
+	Version      int                  `json:"version"`
+	GeneratedAt  time.Time            `json:"generated_at"`
+	GeneratedBy  string               `json:"generated_by"`
+	Case         models.SARCase       `json:"case"`
+	Subject      models.Customer      `json:"subject"`
+	Accounts     []models.Account     `json:"accounts"`     // The subject's accounts
+	Transactions []models.Transaction `json:"transactions"` // Linked transactions, archived ones included, oldest first
+	Notes        []models.SARCaseNote `json:"notes"`
+	Manifest     []Attachment         `json:"attachments_manifest"`
+}
+
+// Export assembles a case's bundle
+func Export(db *gorm.DB, c models.SARCase, by string, now time.Time) (Bundle, error) {
+	bundle := Bundle{Version: BundleVersion, GeneratedAt: now, GeneratedBy: by, Case: c,
+		Accounts: []models.Account{}, Transactions: []models.Transaction{}, Notes: []models.SARCaseNote{}, Manifest: []Attachment{}}
+	if err := db.Unscoped().First(&bundle.Subject, c.CustomerID).Error; err != nil {
+		return bundle, err
+	}
+	if err := db.Unscoped().Where("customer_id = ?", c.CustomerID).Order("id").Find(&bundle.Accounts).Error; err != nil {
+		return bundle, err
+	}
+
+	var ids []uint
+	if err := db.Model(&models.SARCaseTransaction{}).Where("case_id = ?", c.ID).Pluck("transaction_id", &ids).Error; err != nil {
+		return bundle, err
+	}
+	if len(ids) > 0 {
+		err := archive.Transactions(db).Unscoped().Where("id IN ?", ids).Order("created_at, id").Find(&bundle.Transactions).Error
+		if err != nil {
+			return bundle, err
+		}
+	}
+	if err := db.Where("case_id = ?", c.ID).Order("id").Find(&bundle.Notes).Error; err != nil {
+		return bundle, err
+	}
+
+	var links []models.SARCaseDocument
+	if err := db.Where("case_id = ?", c.ID).Order("id").Find(&links).Error; err != nil {
+		return bundle, err
+	}
+	for _, link := range links {
+		var d models.Document
+		if err := db.Unscoped().First(&d, link.DocumentID).Error; err != nil {
+			return bundle, err
+		}
+		bundle.Manifest = append(bundle.Manifest, Attachment{
+			DocumentID:  d.ID,
+			Filename:    d.Filename,
+			Kind:        d.Kind,
+			ContentType: d.ContentType,
+			Size:        d.Size,
+			SHA256:      d.SHA256,
+			Path:        fmt.Sprintf("/api/v1/customers/%d/documents/%d", d.CustomerID, d.ID),
+			AddedBy:     link.AddedBy,
+			AddedAt:     link.CreatedAt,
+		})
+	}
+	return bundle, nil
+}
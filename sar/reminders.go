@@ -0,0 +1,63 @@
+package sar
+
+import (
+	"banking-app/businessdays"
+	"banking-app/models"
+	"banking-app/notifications"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Remind notifies the compliance mailbox of active cases whose filing deadline is within cfg.ReminderDays, and
+// again once a deadline has passed. Each case is reminded once per deadline, and returns how many were notified
+// The messages go to staff only and are never added to the subject's communication log
+func Remind(db *gorm.DB, cfg Config, now time.Time) (int, error) {
+	var cases []models.SARCase
+	err := db.Where("status IN ? AND ((reminded_at IS NULL AND due_at <= ?) OR (overdue_notified_at IS NULL AND due_at <= ?))",
+		Active, now.AddDate(0, 0, cfg.ReminderDays), now).Order("due_at, id").Find(&cases).Error
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, c := range cases {
+		overdue := !c.DueAt.After(now)
+		column, subject := "reminded_at", fmt.Sprintf("SAR case %s is due %s", c.CaseNumber, businessdays.Format(c.DueAt))
+		if overdue {
+			column, subject = "overdue_notified_at", fmt.Sprintf("SAR case %s is past its filing deadline of %s", c.CaseNumber, businessdays.Format(c.DueAt))
+		}
+		assigned := c.AssignedTo
+		if assigned == "" {
+			assigned = "nobody yet"
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			updates := map[string]interface{}{column: now}
+			if overdue && c.RemindedAt == nil {
+				updates["reminded_at"] = now // Past the deadline already, so the earlier reminder is moot
+			}
+			if err := tx.Model(&models.SARCase{}).Where("id = ?", c.ID).Updates(updates).Error; err != nil {
+				return err
+			}
+			if cfg.ReminderEmail == "" {
+				log.Printf("sar: %s (assigned to %s); SAR_REMINDER_EMAIL is not set", subject, assigned)
+				return nil
+			}
+			return notifications.Enqueue(tx, &models.Notification{
+				Channel:      "email",
+				Recipient:    cfg.ReminderEmail,
+				ResourceType: ResourceCase,
+				ResourceID:   c.ID,
+				Subject:      subject,
+				Body: fmt.Sprintf("Case %s (status %s, assigned to %s) must be filed or closed by %s.",
+					c.CaseNumber, c.Status, assigned, businessdays.Format(c.DueAt)),
+			})
+		})
+		if err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
@@ -0,0 +1,280 @@
+package sar
+
+import (
+	"banking-app/archive"
+	"banking-app/clock"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Case statuses
+const (
+	StatusOpen           = "open"
+	StatusInvestigating  = "investigating"
+	StatusFiled          = "sar_filed"
+	StatusClosedNoAction = "closed_no_action"
+)
+
+// Active lists the statuses of cases still being worked; their transactions are locked
+var Active = []string{StatusOpen, StatusInvestigating}
+
+// Alerts a case can be opened from
+const (
+	SourceTransactionReview = "transaction_review"
+	SourceDuplicatePayment  = "duplicate_payment"
+)
+
+// ResourceCase marks staff notifications about a case
+const ResourceCase = "sar_case"
+
+// Case errors - handlers map these to client responses
+var (
+	ErrClosed              = errors.New("case has been filed or closed")
+	ErrNarrativeRequired   = errors.New("a narrative is required before a SAR is filed")
+	ErrUnknownSource       = errors.New("source_type must be transaction_review or duplicate_payment")
+	ErrSourceNotFound      = errors.New("alert not found")
+	ErrCustomerNotFound    = errors.New("customer not found")
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrDocumentNotFound    = errors.New("document not found for the case's customer")
+	ErrInvestigator        = errors.New("cases can only be assigned to compliance users")
+	ErrLocked              = errors.New("transaction is linked to an open SAR case")
+)
+
+// Config holds case deadlines and where reminders go
+type Config struct {
+	FilingDays    int    // Days from opening a case to its filing deadline
+	ReminderDays  int    // Days before the deadline the reminder goes out
+	ReminderEmail string // Compliance mailbox reminders are sent to; none only logs them
+}
+
+// ConfigFromEnv reads SAR_FILING_DAYS (default 30), SAR_REMINDER_DAYS (default 5) and SAR_REMINDER_EMAIL
+func ConfigFromEnv() Config {
+	days := func(key string, fallback int) int {
+		n, err := strconv.Atoi(os.Getenv(key))
+		if err != nil || n <= 0 {
+			return fallback
+		}
+		return n
+	}
+	return Config{
+		FilingDays:    days("SAR_FILING_DAYS", 30),
+		ReminderDays:  days("SAR_REMINDER_DAYS", 5),
+		ReminderEmail: strings.TrimSpace(os.Getenv("SAR_REMINDER_EMAIL")),
+	}
+}
+
+// Source is the alert a case is opened from
+type Source struct {
+	Type string
+	ID   uint
+}
+
+// fromSource returns the customer and transactions an alert concerns
+func fromSource(tx *gorm.DB, source Source) (uint, []uint, error) {
+	switch source.Type {
+	case SourceTransactionReview:
+		var review models.TransactionReview
+		if err := tx.First(&review, source.ID).Error; err != nil {
+			return 0, nil, ErrSourceNotFound
+		}
+		var ids []uint
+		if review.TransactionID != nil {
+			ids = append(ids, *review.TransactionID)
+		}
+		return review.CustomerID, ids, nil
+	case SourceDuplicatePayment:
+		var flag models.DuplicatePayment
+		if err := tx.First(&flag, source.ID).Error; err != nil {
+			return 0, nil, ErrSourceNotFound
+		}
+		return flag.CustomerID, []uint{flag.OriginalTransactionID, flag.TransactionID}, nil
+	}
+	return 0, nil, ErrUnknownSource
+}
+
+// Open records a new case inside an open transaction, due cfg.FilingDays from now. A case opened from an alert
+// takes its subject from the alert and links the alert's transactions alongside transactionIDs
+func Open(tx *gorm.DB, cfg Config, c *models.SARCase, source *Source, transactionIDs, documentIDs []uint) error {
+	if source != nil {
+		customerID, linked, err := fromSource(tx, *source)
+		if err != nil {
+			return err
+		}
+		c.CustomerID, c.SourceType, c.SourceID = customerID, source.Type, source.ID
+		transactionIDs = append(linked, transactionIDs...)
+	}
+	var count int64
+	if err := tx.Model(&models.Customer{}).Where("id = ?", c.CustomerID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrCustomerNotFound
+	}
+	if err := checkInvestigator(tx, c.AssignedTo); err != nil {
+		return err
+	}
+	if err := lifecycle.Check(lifecycle.SARCase, "", StatusOpen); err != nil {
+		return err
+	}
+
+	now := clock.Now()
+	c.ID = 0
+	c.Status = StatusOpen
+	c.CaseNumber = "SAR" + now.Format("20060102150405") + strconv.Itoa(int(time.Now().UnixNano()%1000))
+	c.DueAt = now.AddDate(0, 0, cfg.FilingDays)
+	if err := tx.Create(c).Error; err != nil {
+		return err
+	}
+	if err := AttachTransactions(tx, *c, transactionIDs, c.OpenedBy); err != nil {
+		return err
+	}
+	return AttachDocuments(tx, *c, documentIDs, c.OpenedBy)
+}
+
+// checkInvestigator checks a case is assigned to a compliance user of the tenant, or to nobody
+func checkInvestigator(tx *gorm.DB, username string) error {
+	if username == "" {
+		return nil
+	}
+	var count int64
+	if err := tx.Model(&models.User{}).Where("username = ? AND role = ?", username, "compliance").Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrInvestigator
+	}
+	return nil
+}
+
+// Update replaces a case's narrative, investigator and deadline; filed and closed cases are final
+// A new deadline clears the reminders sent for the old one
+func Update(tx *gorm.DB, c *models.SARCase, narrative, assignedTo string, dueAt *time.Time) error {
+	if !isActive(c.Status) {
+		return ErrClosed
+	}
+	if err := checkInvestigator(tx, assignedTo); err != nil {
+		return err
+	}
+	updates := map[string]interface{}{"narrative": narrative, "assigned_to": assignedTo}
+	if dueAt != nil && !dueAt.Equal(c.DueAt) {
+		updates["due_at"], updates["reminded_at"], updates["overdue_notified_at"] = *dueAt, nil, nil
+		c.DueAt, c.RemindedAt, c.OverdueNotifiedAt = *dueAt, nil, nil
+	}
+	result := tx.Model(&models.SARCase{}).Where("id = ? AND status IN ?", c.ID, Active).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrClosed
+	}
+	c.Narrative, c.AssignedTo = narrative, assignedTo
+	return nil
+}
+
+// AttachTransactions links transactions to an active case; ones already linked are skipped
+// Archived transactions can be linked too, and are kept in the archive
+func AttachTransactions(tx *gorm.DB, c models.SARCase, ids []uint, by string) error {
+	if len(ids) > 0 && !isActive(c.Status) {
+		return ErrClosed
+	}
+	for _, id := range ids {
+		var count int64
+		if err := archive.Transactions(tx).Where("id = ?", id).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrTransactionNotFound
+		}
+		link := models.SARCaseTransaction{TenantID: c.TenantID, CaseID: c.ID, TransactionID: id, AddedBy: by}
+		if err := tx.Where(models.SARCaseTransaction{CaseID: c.ID, TransactionID: id}).FirstOrCreate(&link).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AttachDocuments links documents of the case's customer to an active case; ones already attached are skipped
+func AttachDocuments(tx *gorm.DB, c models.SARCase, ids []uint, by string) error {
+	if len(ids) > 0 && !isActive(c.Status) {
+		return ErrClosed
+	}
+	for _, id := range ids {
+		var count int64
+		if err := tx.Model(&models.Document{}).Where("id = ? AND customer_id = ?", id, c.CustomerID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrDocumentNotFound
+		}
+		link := models.SARCaseDocument{TenantID: c.TenantID, CaseID: c.ID, DocumentID: id, AddedBy: by}
+		if err := tx.Where(models.SARCaseDocument{CaseID: c.ID, DocumentID: id}).FirstOrCreate(&link).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddNote records an investigation note; filed and closed cases take no more
+func AddNote(tx *gorm.DB, c models.SARCase, author, body string) (models.SARCaseNote, error) {
+	note := models.SARCaseNote{TenantID: c.TenantID, CaseID: c.ID, Author: author, Body: body}
+	if !isActive(c.Status) {
+		return note, ErrClosed
+	}
+	return note, tx.Create(&note).Error
+}
+
+// Transition moves a case to a new status as a compliance user. Filing needs a narrative and records the
+// regulator's reference; closing without action needs a reason
+func Transition(tx *gorm.DB, c *models.SARCase, to, role, by, reason, filingReference string) error {
+	if err := lifecycle.Authorize(lifecycle.SARCase, c.Status, to, role, reason); err != nil {
+		return err
+	}
+	if to == c.Status {
+		return nil
+	}
+	updates := map[string]interface{}{"status": to}
+	now := clock.Now()
+	switch to {
+	case StatusFiled:
+		if strings.TrimSpace(c.Narrative) == "" {
+			return ErrNarrativeRequired
+		}
+		updates["filing_reference"], updates["closed_at"], updates["closed_by"] = filingReference, now, by
+	case StatusClosedNoAction:
+		updates["close_reason"], updates["closed_at"], updates["closed_by"] = reason, now, by
+	case StatusInvestigating:
+		if c.AssignedTo == "" {
+			updates["assigned_to"] = by
+		}
+	}
+	result := tx.Model(&models.SARCase{}).Where("id = ? AND status = ?", c.ID, c.Status).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrClosed
+	}
+	return tx.First(c, c.ID).Error
+}
+
+// Locked reports whether a transaction is linked to an open or investigating case
+func Locked(db *gorm.DB, transactionID uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.SARCaseTransaction{}).
+		Joins("JOIN sar_cases ON sar_cases.id = sar_case_transactions.case_id").
+		Where("sar_case_transactions.transaction_id = ? AND sar_cases.status IN ?", transactionID, Active).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// isActive reports whether a case is still being worked
+func isActive(status string) bool {
+	return status == StatusOpen || status == StatusInvestigating
+}
@@ -0,0 +1,92 @@
+package search
+
+import (
+	"banking-app/models"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ftsStatements create the FTS5 shadow table and the triggers that keep it in sync
+// The table uses external content so transaction text is not stored twice
+var ftsStatements = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS transactions_fts USING fts5(
+		description, reference, merchant_name,
+		content='transactions', content_rowid='id', tokenize='unicode61')`,
+	`CREATE TRIGGER IF NOT EXISTS transactions_fts_ai AFTER INSERT ON transactions BEGIN
+		INSERT INTO transactions_fts(rowid, description, reference, merchant_name)
+		VALUES (new.id, new.description, new.reference, new.merchant_name);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS transactions_fts_ad AFTER DELETE ON transactions BEGIN
+		INSERT INTO transactions_fts(transactions_fts, rowid, description, reference, merchant_name)
+		VALUES ('delete', old.id, old.description, old.reference, old.merchant_name);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS transactions_fts_au AFTER UPDATE ON transactions BEGIN
+		INSERT INTO transactions_fts(transactions_fts, rowid, description, reference, merchant_name)
+		VALUES ('delete', old.id, old.description, old.reference, old.merchant_name);
+		INSERT INTO transactions_fts(rowid, description, reference, merchant_name)
+		VALUES (new.id, new.description, new.reference, new.merchant_name);
+	END`,
+}
+
+// setupFTS5 creates the shadow table and triggers, rebuilding the index on first creation
+func setupFTS5(db *gorm.DB) error {
+	var existing int64
+	db.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'transactions_fts'").Scan(&existing)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range ftsStatements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		// Index rows that were written before the shadow table existed
+		if existing == 0 {
+			return tx.Exec("INSERT INTO transactions_fts(transactions_fts) VALUES ('rebuild')").Error
+		}
+		return nil
+	})
+}
+
+// FTS5Searcher ranks matches with SQLite's bm25 function
+type FTS5Searcher struct{}
+
+// Search runs a ranked FTS5 query combined with the structured filters
+func (FTS5Searcher) Search(db *gorm.DB, f TransactionFilter) ([]models.Transaction, int64, error) {
+	var transactions []models.Transaction
+	match := FTS5Query(ParseQuery(f.Query))
+	if match == "" {
+		return transactions, 0, nil
+	}
+
+	base := func() *gorm.DB {
+		query := db.Model(&models.Transaction{}).
+			Joins("JOIN transactions_fts ON transactions_fts.rowid = transactions.id").
+			Where("transactions_fts MATCH ?", match)
+		return ApplyFilters(query, f)
+	}
+
+	var total int64
+	if err := base().Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := base().Select("transactions.*").
+		Order("bm25(transactions_fts)").Order("transactions.created_at DESC").
+		Offset(f.Offset).Limit(f.Limit).Find(&transactions).Error
+	return transactions, total, err
+}
+
+// FTS5Query renders parsed terms as an FTS5 MATCH expression
+// Every term is double-quoted so only the phrase and prefix operators are ever interpreted
+func FTS5Query(terms []Term) string {
+	parts := make([]string, 0, len(terms))
+	for _, t := range terms {
+		part := `"` + strings.ReplaceAll(t.Text, `"`, `""`) + `"`
+		if t.Prefix {
+			part += "*"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
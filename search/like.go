@@ -0,0 +1,45 @@
+package search
+
+import (
+	"banking-app/models"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// LikeSearcher matches every term with LIKE across the searchable columns
+// Used when no full-text index is available; results are ordered by recency
+type LikeSearcher struct{}
+
+// Search runs an unranked substring search combined with the structured filters
+func (LikeSearcher) Search(db *gorm.DB, f TransactionFilter) ([]models.Transaction, int64, error) {
+	var transactions []models.Transaction
+	terms := ParseQuery(f.Query)
+	if len(terms) == 0 {
+		return transactions, 0, nil
+	}
+
+	base := func() *gorm.DB {
+		query := db.Model(&models.Transaction{})
+		for _, t := range terms {
+			pattern := "%" + escapeLike(t.Text) + "%"
+			query = query.Where(
+				"(transactions.description LIKE ? ESCAPE '\\' OR transactions.reference LIKE ? ESCAPE '\\' OR transactions.merchant_name LIKE ? ESCAPE '\\')",
+				pattern, pattern, pattern)
+		}
+		return ApplyFilters(query, f)
+	}
+
+	var total int64
+	if err := base().Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := base().Order("transactions.created_at DESC").Offset(f.Offset).Limit(f.Limit).Find(&transactions).Error
+	return transactions, total, err
+}
+
+// escapeLike escapes LIKE wildcards so user input is matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,130 @@
+package search
+
+import (
+	"banking-app/models"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TransactionFilter describes a full-text query plus the structured filters it combines with
+type TransactionFilter struct {
+	AccountID uint       // Restrict results to one account (0 = all accounts)
+	Query     string     // Free-text query over description, reference, and merchant name
+	Type      string     // Transaction type filter
+	From      *time.Time // Inclusive lower bound on created_at
+	To        *time.Time // Exclusive upper bound on created_at
+	MinAmount *float64   // Inclusive minimum amount
+	MaxAmount *float64   // Inclusive maximum amount
+	Offset    int        // Pagination offset
+	Limit     int        // Page size
+}
+
+// TransactionSearcher runs ranked full-text searches over transactions
+// SQLite uses FTS5; a Postgres tsvector implementation can satisfy the same interface
+type TransactionSearcher interface {
+	Search(db *gorm.DB, f TransactionFilter) ([]models.Transaction, int64, error)
+}
+
+// Setup prepares the best available searcher for the connected database
+// Falls back to LIKE matching when SQLite was built without FTS5 (build with -tags sqlite_fts5)
+func Setup(db *gorm.DB) TransactionSearcher {
+	if db.Dialector.Name() == "sqlite" {
+		if err := setupFTS5(db); err != nil {
+			log.Printf("search: FTS5 unavailable, falling back to LIKE search: %v", err)
+			return LikeSearcher{}
+		}
+		return FTS5Searcher{}
+	}
+	return LikeSearcher{}
+}
+
+// ApplyFilters adds the structured (non-text) filters to a transaction query
+func ApplyFilters(query *gorm.DB, f TransactionFilter) *gorm.DB {
+	if f.AccountID != 0 {
+		query = query.Where("transactions.account_id = ?", f.AccountID)
+	}
+	if f.Type != "" {
+		query = query.Where("transactions.transaction_type = ?", f.Type)
+	}
+	if f.From != nil {
+		query = query.Where("transactions.created_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		query = query.Where("transactions.created_at < ?", *f.To)
+	}
+	if f.MinAmount != nil {
+		query = query.Where("transactions.amount >= ?", *f.MinAmount)
+	}
+	if f.MaxAmount != nil {
+		query = query.Where("transactions.amount <= ?", *f.MaxAmount)
+	}
+	return query
+}
+
+// Term is one parsed element of a user query
+type Term struct {
+	Text   string // Term or phrase text with special characters removed
+	Phrase bool   // Quoted multi-word phrase
+	Prefix bool   // Trailing * requests prefix matching
+}
+
+// ParseQuery splits user input into terms, honoring "quoted phrases" and trailing * prefixes
+// Characters with query-syntax meaning are stripped so user input can never break the query
+func ParseQuery(q string) []Term {
+	var terms []Term
+	for len(q) > 0 {
+		q = strings.TrimSpace(q)
+		if q == "" {
+			break
+		}
+
+		if q[0] == '"' {
+			end := strings.IndexByte(q[1:], '"')
+			var phrase string
+			if end < 0 {
+				phrase, q = q[1:], ""
+			} else {
+				phrase, q = q[1:end+1], q[end+2:]
+			}
+			if text := clean(phrase); text != "" {
+				terms = append(terms, Term{Text: text, Phrase: strings.Contains(text, " ")})
+			}
+			continue
+		}
+
+		end := strings.IndexAny(q, " \t\n")
+		var word string
+		if end < 0 {
+			word, q = q, ""
+		} else {
+			word, q = q[:end], q[end:]
+		}
+		prefix := strings.HasSuffix(word, "*")
+		for _, part := range strings.Fields(clean(word)) {
+			terms = append(terms, Term{Text: part})
+		}
+		if prefix && len(terms) > 0 {
+			terms[len(terms)-1].Prefix = true
+		}
+	}
+	return terms
+}
+
+// clean keeps letters, digits, and a few in-word separators, turning everything else into spaces
+func clean(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r > 127:
+			b.WriteRune(r)
+		case r == '-' || r == '_' || r == '.' || r == '/' || r == '&' || r == '\'':
+			b.WriteRune(r)
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
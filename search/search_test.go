@@ -0,0 +1,59 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []Term
+	}{
+		{"electric", []Term{{Text: "electric"}}},
+		{"  electric   bill ", []Term{{Text: "electric"}, {Text: "bill"}}},
+		{`"electric bill"`, []Term{{Text: "electric bill", Phrase: true}}},
+		{`"electric bill" march`, []Term{{Text: "electric bill", Phrase: true}, {Text: "march"}}},
+		{`"electric bill`, []Term{{Text: "electric bill", Phrase: true}}},
+		{`"electric"`, []Term{{Text: "electric"}}},
+		{"electr*", []Term{{Text: "electr", Prefix: true}}},
+		{"pastr* coff*", []Term{{Text: "pastr", Prefix: true}, {Text: "coff", Prefix: true}}},
+		{"O'Brien's", []Term{{Text: "O'Brien's"}}},
+		{"INV/4471", []Term{{Text: "INV/4471"}}},
+		{"100%", []Term{{Text: "100"}}},
+		{"description:electric", []Term{{Text: "description"}, {Text: "electric"}}},
+		{"NEAR(a b)", []Term{{Text: "NEAR"}, {Text: "a"}, {Text: "b"}}},
+		{`( ) ^ : * + "" {}`, nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := ParseQuery(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestFTS5Query(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"electric", `"electric"`},
+		{`"electric bill" march`, `"electric bill" "march"`},
+		{"electr* OR coffee", `"electr"* "OR" "coffee"`},
+		{"NOT heater", `"NOT" "heater"`},
+		{"O'Brien's", `"O'Brien's"`},
+		{"*", ""},
+	}
+	for _, tt := range tests {
+		if got := FTS5Query(ParseQuery(tt.query)); got != tt.want {
+			t.Errorf("FTS5Query(%q) = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got := escapeLike(`100%_off\`); got != `100\%\_off\\` {
+		t.Errorf("escapeLike = %s", got)
+	}
+}
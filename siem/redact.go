@@ -0,0 +1,61 @@
+package siem
+
+import (
+	"banking-app/display"
+	"banking-app/models"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces a value that may identify a customer
+const Redacted = "REDACTED"
+
+// safeQueryKeys are query parameters that never carry personal data; every other value is redacted
+var safeQueryKeys = map[string]bool{
+	"page": true, "limit": true, "status": true, "format": true, "type": true, "sort": true, "order": true,
+	"from": true, "to": true, "refresh": true, "all": true, "dry_run": true,
+}
+
+var (
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	digitsPattern = regexp.MustCompile(`\d{8,}`) // Account, card and phone numbers; record ids are shorter
+)
+
+// Redact removes the personal data an audit entry may still carry before it leaves the bank: query values
+// other than safeQueryKeys, email addresses, and long digit runs such as account numbers, which keep their
+// last four digits. Usernames that are email addresses are redacted too
+func Redact(entry models.AuditEntry) models.AuditEntry {
+	entry.Path = redactPath(entry.Path)
+	entry.Username = emailPattern.ReplaceAllString(entry.Username, Redacted)
+	return entry
+}
+
+func redactPath(raw string) string {
+	path, query, _ := strings.Cut(raw, "?")
+	path = redactText(path)
+	if query == "" {
+		return path
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return path + "?" + Redacted
+	}
+	for key, list := range values {
+		if safeQueryKeys[key] {
+			continue
+		}
+		for i := range list {
+			list[i] = Redacted
+		}
+	}
+	return path + "?" + values.Encode()
+}
+
+func redactText(s string) string {
+	if unescaped, err := url.PathUnescape(s); err == nil {
+		s = unescaped
+	}
+	s = emailPattern.ReplaceAllString(s, Redacted)
+	return digitsPattern.ReplaceAllStringFunc(s, display.MaskAccountNumber)
+}
@@ -0,0 +1,338 @@
+package siem
+
+import (
+	"banking-app/clock"
+	"banking-app/metrics"
+	"banking-app/models"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Sinks the audit log can be forwarded to
+const (
+	SinkOff    = "off"
+	SinkSyslog = "syslog" // RFC 5424 over TCP or UDP
+	SinkHTTPS  = "https"  // Batches POSTed as a JSON array
+)
+
+// EventType is the type every forwarded audit entry carries
+const EventType = "audit.request"
+
+// cursorName names the audit log forwarder's row in siem_cursors
+const cursorName = "audit"
+
+// Config holds SIEM forwarding settings
+type Config struct {
+	Sink            string
+	Endpoint        string // tcp://host:port or udp://host:port for syslog, a URL for https
+	Token           string // Bearer token sent to an https sink
+	BatchSize       int
+	Interval        time.Duration
+	BreakerFailures int           // Consecutive failed deliveries that open the circuit breaker
+	BreakerCooldown time.Duration // How long an open breaker waits before trying the sink again
+}
+
+// Enabled reports whether audit entries are forwarded at all
+func (c Config) Enabled() bool {
+	return c.Sink != SinkOff
+}
+
+// ConfigFromEnv reads SIEM_SINK (off, syslog or https; default off), SIEM_ENDPOINT, SIEM_TOKEN,
+// SIEM_BATCH_SIZE (default 100), SIEM_INTERVAL_SECONDS (default 5), SIEM_BREAKER_FAILURES (default 5) and
+// SIEM_BREAKER_COOLDOWN_SECONDS (default 60)
+// An https sink must use https, except on a loopback address where a local collector may listen on plain http
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Sink:            strings.ToLower(strings.TrimSpace(os.Getenv("SIEM_SINK"))),
+		Endpoint:        strings.TrimSpace(os.Getenv("SIEM_ENDPOINT")),
+		Token:           os.Getenv("SIEM_TOKEN"),
+		BatchSize:       100,
+		BreakerFailures: 5,
+	}
+	if cfg.Sink == "" {
+		cfg.Sink = SinkOff
+	}
+
+	interval, cooldown := 5, 60
+	settings := []struct {
+		name string
+		into *int
+	}{
+		{"SIEM_BATCH_SIZE", &cfg.BatchSize},
+		{"SIEM_INTERVAL_SECONDS", &interval},
+		{"SIEM_BREAKER_FAILURES", &cfg.BreakerFailures},
+		{"SIEM_BREAKER_COOLDOWN_SECONDS", &cooldown},
+	}
+	for _, setting := range settings {
+		raw := strings.TrimSpace(os.Getenv(setting.name))
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return cfg, fmt.Errorf("invalid %s %q: must be a whole number of 1 or more", setting.name, raw)
+		}
+		*setting.into = n
+	}
+	cfg.Interval = time.Duration(interval) * time.Second
+	cfg.BreakerCooldown = time.Duration(cooldown) * time.Second
+
+	switch cfg.Sink {
+	case SinkOff:
+		return cfg, nil
+	case SinkSyslog, SinkHTTPS:
+	default:
+		return cfg, fmt.Errorf("invalid SIEM_SINK %q: must be off, syslog or https", cfg.Sink)
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return cfg, fmt.Errorf("invalid SIEM_ENDPOINT %q: must be a URL with a host", cfg.Endpoint)
+	}
+	if cfg.Sink == SinkSyslog && endpoint.Scheme != "tcp" && endpoint.Scheme != "udp" {
+		return cfg, fmt.Errorf("invalid SIEM_ENDPOINT %q: a syslog sink is tcp://host:port or udp://host:port", cfg.Endpoint)
+	}
+	if cfg.Sink == SinkHTTPS && endpoint.Scheme != "https" && !(endpoint.Scheme == "http" && loopback(endpoint.Hostname())) {
+		return cfg, fmt.Errorf("invalid SIEM_ENDPOINT %q: an https sink must use https", cfg.Endpoint)
+	}
+	return cfg, nil
+}
+
+// loopback reports whether a host names this machine
+func loopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Event is one audit entry as shipped to the SIEM, with personal data already redacted
+// The entry's id is kept, so a sink that receives a batch twice after a restart can drop the repeat
+type Event struct {
+	Type string `json:"event_type"` // Always EventType
+	models.AuditEntry
+}
+
+// Forwarder ships the audit log to a SIEM sink in id order, at least once
+// It records the last acknowledged entry as a high-water mark only after the sink accepted a batch, so a
+// restart resends at most the batch in flight and never skips an entry. SQLite assigns ids in commit order,
+// so no entry ever commits behind the mark
+type Forwarder struct {
+	db      *gorm.DB
+	cfg     Config
+	sink    Sink
+	breaker breaker
+
+	forwarded *metrics.Counter
+	failures  *metrics.Counter
+}
+
+// New creates a forwarder for an enabled configuration and registers its metrics
+func New(db *gorm.DB, cfg Config) *Forwarder {
+	f := &Forwarder{
+		db:        db,
+		cfg:       cfg,
+		sink:      newSink(cfg),
+		breaker:   breaker{threshold: cfg.BreakerFailures, cooldown: cfg.BreakerCooldown},
+		forwarded: metrics.NewCounter("siem_events_forwarded_total", "Audit entries acknowledged by the SIEM sink"),
+		failures:  metrics.NewCounter("siem_delivery_failures_total", "Failed deliveries of audit entry batches to the SIEM sink"),
+	}
+	metrics.RegisterGauge("siem_lag_seconds", "Age of the oldest audit entry not yet forwarded to the SIEM", func() float64 {
+		lag, _ := f.Lag()
+		return lag.Seconds()
+	})
+	metrics.RegisterGauge("siem_breaker_open", "1 while the SIEM circuit breaker holds deliveries back", func() float64 {
+		if f.breaker.state(time.Now()) == BreakerOpen {
+			return 1
+		}
+		return 0
+	})
+	return f
+}
+
+// Forward ships every audit entry after the high-water mark, one batch at a time, until caught up
+// A failed delivery stops the run and is retried on the next one; while the breaker is open nothing is sent
+func (f *Forwarder) Forward() {
+	if !f.breaker.allow(time.Now()) {
+		return
+	}
+	for {
+		cursor, err := f.cursor()
+		if err != nil {
+			log.Printf("siem: failed to load the high-water mark: %v", err)
+			return
+		}
+		var entries []models.AuditEntry
+		if err := f.db.Where("id > ?", cursor.LastID).Order("id").Limit(f.cfg.BatchSize).Find(&entries).Error; err != nil {
+			log.Printf("siem: failed to load audit entries: %v", err)
+			return
+		}
+		if len(entries) == 0 {
+			return
+		}
+
+		batch := make([]Event, len(entries))
+		for i, entry := range entries {
+			batch[i] = Event{Type: EventType, AuditEntry: Redact(entry)}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err = f.sink.Send(ctx, batch)
+		cancel()
+		if err != nil {
+			f.failures.Inc()
+			if f.breaker.failure(time.Now(), err) {
+				log.Printf("siem: circuit breaker open for %s after %d failed deliveries: %v", f.cfg.BreakerCooldown, f.cfg.BreakerFailures, err)
+			} else {
+				log.Printf("siem: delivery of entries %d-%d failed: %v", entries[0].ID, entries[len(entries)-1].ID, err)
+			}
+			return
+		}
+		f.breaker.success()
+
+		// Conditional on the mark read, so two instances sharing the database never move it backwards
+		now := clock.Now()
+		last := entries[len(entries)-1].ID
+		result := f.db.Model(&models.SIEMCursor{}).Where("name = ? AND last_id = ?", cursorName, cursor.LastID).
+			Updates(map[string]interface{}{"last_id": last, "forwarded_at": &now})
+		if result.Error != nil {
+			log.Printf("siem: entries through %d were delivered but the high-water mark was not saved; they will be resent: %v", last, result.Error)
+			return
+		}
+		f.forwarded.Add(int64(len(entries)))
+		if len(entries) < f.cfg.BatchSize {
+			return
+		}
+	}
+}
+
+// cursor loads the high-water mark, starting from the beginning of the audit log on first use
+func (f *Forwarder) cursor() (models.SIEMCursor, error) {
+	cursor := models.SIEMCursor{Name: cursorName}
+	err := f.db.Where(models.SIEMCursor{Name: cursorName}).FirstOrCreate(&cursor).Error
+	return cursor, err
+}
+
+// Lag returns the age of the oldest audit entry not yet acknowledged by the sink, zero when caught up
+func (f *Forwarder) Lag() (time.Duration, error) {
+	cursor, err := f.cursor()
+	if err != nil {
+		return 0, err
+	}
+	var oldest models.AuditEntry
+	err = f.db.Where("id > ?", cursor.LastID).Order("id").Limit(1).Find(&oldest).Error
+	if err != nil || oldest.ID == 0 {
+		return 0, err
+	}
+	return clock.Since(oldest.CreatedAt), nil
+}
+
+// Status is the forwarder's progress and the state of its circuit breaker
+type Status struct {
+	Sink                string     `json:"sink"`
+	Endpoint            string     `json:"endpoint"`
+	HighWaterMark       uint       `json:"high_water_mark"` // Last audit entry acknowledged by the sink
+	ForwardedAt         *time.Time `json:"forwarded_at,omitempty"`
+	Pending             int64      `json:"pending"` // Entries after the mark
+	LagSeconds          float64    `json:"lag_seconds"`
+	Breaker             string     `json:"breaker"` // closed, open or half_open
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"` // When an open breaker lets the next delivery through
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+// Status reports how far forwarding has got
+func (f *Forwarder) Status() (Status, error) {
+	status := Status{Sink: f.cfg.Sink, Endpoint: f.cfg.Endpoint}
+	cursor, err := f.cursor()
+	if err != nil {
+		return status, err
+	}
+	status.HighWaterMark, status.ForwardedAt = cursor.LastID, cursor.ForwardedAt
+	if err := f.db.Model(&models.AuditEntry{}).Where("id > ?", cursor.LastID).Count(&status.Pending).Error; err != nil {
+		return status, err
+	}
+	lag, err := f.Lag()
+	if err != nil {
+		return status, err
+	}
+	status.LagSeconds = lag.Seconds()
+
+	now := time.Now()
+	f.breaker.mu.Lock()
+	defer f.breaker.mu.Unlock()
+	status.Breaker = f.breaker.stateLocked(now)
+	status.ConsecutiveFailures, status.LastError = f.breaker.failures, f.breaker.lastError
+	if status.Breaker == BreakerOpen {
+		retryAt := f.breaker.openUntil
+		status.RetryAt = &retryAt
+	}
+	return status, nil
+}
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open" // Cooldown over; the next delivery decides whether it closes or opens again
+)
+
+// breaker stops deliveries to a sink that keeps failing, trying again once its cooldown has passed
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	lastError string
+}
+
+// allow reports whether a delivery may be attempted now
+func (b *breaker) allow(now time.Time) bool {
+	return b.state(now) != BreakerOpen
+}
+
+// failure records a failed delivery and reports whether it opened the breaker
+func (b *breaker) failure(now time.Time, err error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastError = err.Error()
+	if b.failures >= b.threshold {
+		b.openUntil = now.Add(b.cooldown)
+		return true
+	}
+	return false
+}
+
+// success closes the breaker
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures, b.lastError, b.openUntil = 0, "", time.Time{}
+}
+
+func (b *breaker) state(now time.Time) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stateLocked(now)
+}
+
+func (b *breaker) stateLocked(now time.Time) string {
+	switch {
+	case b.failures < b.threshold:
+		return BreakerClosed
+	case now.Before(b.openUntil):
+		return BreakerOpen
+	}
+	return BreakerHalfOpen
+}
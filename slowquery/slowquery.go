@@ -0,0 +1,277 @@
+package slowquery
+
+import (
+	"banking-app/metrics"
+	"context"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DefaultThreshold is how long a statement runs before it is recorded as slow
+const DefaultThreshold = 200 * time.Millisecond
+
+// Capacity is how many slow statements are kept; older ones drop out of the window first
+const Capacity = 500
+
+// Background is the route recorded for statements run outside a request, such as scheduled jobs
+const Background = "background"
+
+// startKey holds a statement's start time in its gorm instance settings
+const startKey = "slowquery:start"
+
+// Config holds slow query settings
+type Config struct {
+	Threshold time.Duration
+	Explain   bool // Attach SQLite's EXPLAIN QUERY PLAN to each slow statement
+}
+
+// ConfigFromEnv reads SLOW_QUERY_THRESHOLD_MS (default 200) and SLOW_QUERY_EXPLAIN
+// Explaining defaults to on in development, when gin runs in debug mode
+func ConfigFromEnv() Config {
+	cfg := Config{Threshold: DefaultThreshold, Explain: gin.IsDebugging()}
+	if raw := os.Getenv("SLOW_QUERY_THRESHOLD_MS"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			cfg.Threshold = time.Duration(n) * time.Millisecond
+		} else {
+			log.Printf("slowquery: ignoring invalid SLOW_QUERY_THRESHOLD_MS %q", raw)
+		}
+	}
+	if raw := os.Getenv("SLOW_QUERY_EXPLAIN"); raw != "" {
+		if explain, err := strconv.ParseBool(raw); err == nil {
+			cfg.Explain = explain
+		} else {
+			log.Printf("slowquery: ignoring invalid SLOW_QUERY_EXPLAIN %q", raw)
+		}
+	}
+	return cfg
+}
+
+// Query is one slow statement
+type Query struct {
+	At         time.Time `json:"at"`
+	Route      string    `json:"route"` // "METHOD /api/v1/path/:param", or Background
+	SQL        string    `json:"sql"`   // Normalized, with literal values replaced by ?
+	DurationMS float64   `json:"duration_ms"`
+	Rows       int64     `json:"rows"`
+	Plan       []string  `json:"plan,omitempty"`
+}
+
+// Offender groups the slow runs of one statement from one route
+type Offender struct {
+	Route    string    `json:"route"`
+	SQL      string    `json:"sql"`
+	Count    int       `json:"count"`
+	TotalMS  float64   `json:"total_ms"`
+	MaxMS    float64   `json:"max_ms"`
+	AvgMS    float64   `json:"avg_ms"`
+	MaxRows  int64     `json:"max_rows"`
+	LastSeen time.Time `json:"last_seen"`
+	Plan     []string  `json:"plan,omitempty"` // From the slowest run
+}
+
+// Recorder keeps the most recent slow statements
+type Recorder struct {
+	cfg Config
+
+	mu      sync.Mutex
+	queries []Query // Ring buffer of at most Capacity
+	next    int
+}
+
+// New returns a recorder; Register attaches it to a database
+func New(cfg Config) *Recorder {
+	return &Recorder{cfg: cfg}
+}
+
+// Config returns the recorder's settings
+func (r *Recorder) Config() Config {
+	return r.cfg
+}
+
+// Register times every statement run through db
+func (r *Recorder) Register(db *gorm.DB) error {
+	callbacks := db.Callback()
+	for _, err := range []error{
+		callbacks.Create().Before("*").Register("slowquery:start_create", start),
+		callbacks.Create().After("*").Register("slowquery:finish_create", r.finish),
+		callbacks.Query().Before("*").Register("slowquery:start_query", start),
+		callbacks.Query().After("*").Register("slowquery:finish_query", r.finish),
+		callbacks.Update().Before("*").Register("slowquery:start_update", start),
+		callbacks.Update().After("*").Register("slowquery:finish_update", r.finish),
+		callbacks.Delete().Before("*").Register("slowquery:start_delete", start),
+		callbacks.Delete().After("*").Register("slowquery:finish_delete", r.finish),
+		callbacks.Row().Before("*").Register("slowquery:start_row", start),
+		callbacks.Row().After("*").Register("slowquery:finish_row", r.finish),
+		callbacks.Raw().Before("*").Register("slowquery:start_raw", start),
+		callbacks.Raw().After("*").Register("slowquery:finish_raw", r.finish),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// start notes when a statement began
+func start(tx *gorm.DB) {
+	tx.InstanceSet(startKey, time.Now())
+}
+
+// finish counts the statement for Counting and records it if it ran longer than the threshold
+func (r *Recorder) finish(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(startKey)
+	if !ok {
+		return
+	}
+	if n, ok := tx.Statement.Context.Value(counterKey{}).(*int64); ok {
+		atomic.AddInt64(n, 1)
+	}
+	elapsed := time.Since(value.(time.Time))
+	if elapsed < r.cfg.Threshold || tx.Statement.SQL.Len() == 0 {
+		return
+	}
+
+	route, ok := RouteFromContext(tx.Statement.Context)
+	if !ok {
+		route = Background
+	}
+	q := Query{
+		At:         time.Now(),
+		Route:      route,
+		SQL:        Normalize(tx.Statement.SQL.String()),
+		DurationMS: float64(elapsed.Microseconds()) / 1000,
+		Rows:       tx.RowsAffected,
+	}
+	if r.cfg.Explain && tx.Dialector.Name() == "sqlite" {
+		q.Plan = explain(tx)
+	}
+	metrics.NewCounter(`db_slow_queries_total{route="`+route+`"}`, "Statements slower than the slow query threshold, by route").Inc()
+	log.Printf("Slow query: %.1fms on %s (%d rows): %s", q.DurationMS, route, q.Rows, q.SQL)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.queries) < Capacity {
+		r.queries = append(r.queries, q)
+		return
+	}
+	r.queries[r.next] = q
+	r.next = (r.next + 1) % Capacity
+}
+
+// explain runs EXPLAIN QUERY PLAN for a statement on the connection it ran on, bypassing gorm so it is not timed
+func explain(tx *gorm.DB) []string {
+	rows, err := tx.Statement.ConnPool.QueryContext(tx.Statement.Context, "EXPLAIN QUERY PLAN "+tx.Statement.SQL.String(), tx.Statement.Vars...)
+	if err != nil {
+		return []string{"explain failed: " + err.Error()}
+	}
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			return append(plan, "explain failed: "+err.Error())
+		}
+		plan = append(plan, detail)
+	}
+	return plan
+}
+
+// Worst groups the slow statements recorded since a time by route and SQL, most total time first
+func (r *Recorder) Worst(since time.Time, limit int) []Offender {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	groups := make(map[string]*Offender)
+	for _, q := range r.queries {
+		if q.At.Before(since) {
+			continue
+		}
+		key := q.Route + "\x00" + q.SQL
+		o, ok := groups[key]
+		if !ok {
+			o = &Offender{Route: q.Route, SQL: q.SQL}
+			groups[key] = o
+		}
+		o.Count++
+		o.TotalMS += q.DurationMS
+		if q.DurationMS >= o.MaxMS {
+			o.MaxMS, o.Plan = q.DurationMS, q.Plan
+		}
+		if q.Rows > o.MaxRows {
+			o.MaxRows = q.Rows
+		}
+		if q.At.After(o.LastSeen) {
+			o.LastSeen = q.At
+		}
+	}
+
+	offenders := make([]Offender, 0, len(groups))
+	for _, o := range groups {
+		o.AvgMS = o.TotalMS / float64(o.Count)
+		offenders = append(offenders, *o)
+	}
+	sort.Slice(offenders, func(i, j int) bool { return offenders[i].TotalMS > offenders[j].TotalMS })
+	if limit > 0 && len(offenders) > limit {
+		offenders = offenders[:limit]
+	}
+	return offenders
+}
+
+// Literal values are stripped from recorded SQL so no customer data reaches logs or the admin endpoint
+var (
+	stringLiteral = regexp.MustCompile(`'(?:[^']|'')*'`)
+	numberLiteral = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	placeholders  = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)+\s*\)`)
+	whitespace    = regexp.MustCompile(`\s+`)
+)
+
+// Normalize replaces literal values with ?, collapses IN lists to a single ? and tidies whitespace,
+// so runs of the same statement with different values group together
+func Normalize(sql string) string {
+	sql = stringLiteral.ReplaceAllString(sql, "?")
+	sql = numberLiteral.ReplaceAllString(sql, "?")
+	sql = placeholders.ReplaceAllString(sql, "(?)")
+	return strings.TrimSpace(whitespace.ReplaceAllString(sql, " "))
+}
+
+// routeKey carries the matched route to the statements a request runs
+type routeKey struct{}
+
+// RouteFromContext returns the route stored by Middleware
+func RouteFromContext(ctx context.Context) (string, bool) {
+	route, ok := ctx.Value(routeKey{}).(string)
+	return route, ok
+}
+
+// counterKey carries a statement counter to the statements run under a context
+type counterKey struct{}
+
+// Counting returns a context that counts the statements run under it on a registered database, and a function
+// reporting the count so far. Endpoints with a query budget report it so tests can hold them to it
+func Counting(ctx context.Context) (context.Context, func() int) {
+	n := new(int64)
+	return context.WithValue(ctx, counterKey{}, n), func() int { return int(atomic.LoadInt64(n)) }
+}
+
+// Middleware stores the matched route in the request context, where handlers' tenancy.DB sessions carry it
+// to the statements they run. A v2 request re-dispatched to v1 is stored under the v1 route
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.FullPath() != "" {
+			route := c.Request.Method + " " + c.FullPath()
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), routeKey{}, route))
+		}
+		c.Next()
+	}
+}
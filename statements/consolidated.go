@@ -0,0 +1,204 @@
+package statements
+
+import (
+	"banking-app/fx"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/money"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AccountSection is one account's part of a consolidated statement
+type AccountSection struct {
+	AccountID     uint        `json:"account_id"`
+	AccountNumber string      `json:"account_number"`
+	AccountType   string      `json:"account_type"`
+	Currency      string      `json:"currency"`
+	Summary       Summary     `json:"summary"`
+	Converted     *Conversion `json:"converted,omitempty"` // Absent for accounts already in the base currency
+}
+
+// Conversion is an account's figures in the statement's base currency at the rate they were converted with
+type Conversion struct {
+	Currency       string    `json:"currency"`  // Base currency
+	Rate           float64   `json:"rate"`      // Base currency per unit of the account's currency
+	RateDate       time.Time `json:"rate_date"` // Closing rate's day, always the statement's last day
+	ClosingBalance float64   `json:"closing_balance"`
+	NetMovement    float64   `json:"net_movement"`
+}
+
+// MissingRatesError fails a consolidated statement whose accounts cannot all be converted at the close date's rates
+// Pairs are written CUR/BASE, e.g. EUR/USD
+type MissingRatesError struct {
+	Date  time.Time
+	Pairs []string
+}
+
+func (e *MissingRatesError) Error() string {
+	return fmt.Sprintf("no closing rate on %s for %s", e.Date.Format("2006-01-02"), strings.Join(e.Pairs, ", "))
+}
+
+// LoanPosition is an outstanding loan counted as a liability
+type LoanPosition struct {
+	LoanID           uint    `json:"loan_id"`
+	LoanNumber       string  `json:"loan_number"`
+	Status           string  `json:"status"`
+	RemainingBalance float64 `json:"remaining_balance"`
+}
+
+// Consolidated is the summary page of a customer's statement across all accounts
+// Totals are in the base currency; other accounts are converted at the closing rates of the period's last day
+type Consolidated struct {
+	CustomerID       uint             `json:"customer_id"`
+	CustomerName     string           `json:"customer_name"`
+	PeriodStart      time.Time        `json:"period_start"`
+	PeriodEnd        time.Time        `json:"period_end"` // Exclusive
+	BaseCurrency     string           `json:"base_currency"`
+	RatesAsOf        *time.Time       `json:"rates_as_of,omitempty"` // Day of the closing rates used, when any account was converted
+	TotalAssets      float64          `json:"total_assets"`
+	TotalLiabilities float64          `json:"total_liabilities"`
+	NetMovement      float64          `json:"net_movement"`
+	Accounts         []AccountSection `json:"accounts"`
+	Loans            []LoanPosition   `json:"loans"`
+}
+
+// Consolidate summarizes every account a customer held during [start, end), totalled in base
+// Accounts opened after the period, or closed or deleted before it began, are left out.
+// Accounts in other currencies are converted at the closing rates of the period's last day, never a later or
+// earlier rate, so regenerating a statement gives the same figures. Without every rate it returns a *MissingRatesError.
+// Only aggregates are loaded here; postings are streamed per account with Each
+func Consolidate(db *gorm.DB, customer models.Customer, base string, start, end time.Time) (Consolidated, error) {
+	out := Consolidated{
+		CustomerID:   customer.ID,
+		CustomerName: customer.FirstName + " " + customer.LastName,
+		PeriodStart:  start,
+		PeriodEnd:    end,
+		BaseCurrency: base,
+		Accounts:     []AccountSection{},
+		Loans:        []LoanPosition{},
+	}
+
+	// There is no closed-at timestamp, so the last update of a closed account stands in for it
+	var accounts []models.Account
+	err := db.Unscoped().
+		Where("customer_id = ? AND created_at < ?", customer.ID, end).
+		Where("deleted_at IS NULL OR deleted_at >= ?", start).
+		Where("status <> 'closed' OR updated_at >= ?", start).
+		Order("id").Find(&accounts).Error
+	if err != nil {
+		return out, err
+	}
+
+	closeDay := ledger.StartOfDay(end.AddDate(0, 0, -1))
+	rates, err := closingRates(db, accounts, base, closeDay)
+	if err != nil {
+		return out, err
+	}
+	if len(rates) > 0 {
+		out.RatesAsOf = &closeDay
+	}
+
+	for _, account := range accounts {
+		sum, err := Summarize(db, account.ID, start, end)
+		if err != nil {
+			return out, err
+		}
+		section := AccountSection{
+			AccountID:     account.ID,
+			AccountNumber: account.AccountNumber,
+			AccountType:   account.AccountType,
+			Currency:      account.Currency,
+			Summary:       sum,
+		}
+		closing, movement := sum.ClosingBalance, sum.TotalCredits-sum.TotalDebits
+		if rate, ok := rates[account.Currency]; ok {
+			closing, movement = money.Cents(closing*rate), money.Cents(movement*rate)
+			section.Converted = &Conversion{Currency: base, Rate: rate, RateDate: closeDay, ClosingBalance: closing, NetMovement: movement}
+		}
+		out.Accounts = append(out.Accounts, section)
+
+		if closing >= 0 {
+			out.TotalAssets += closing
+		} else {
+			out.TotalLiabilities -= closing // Overdrawn balances are owed to the bank
+		}
+		out.NetMovement += movement
+	}
+
+	// Loans carry no posting history, so liabilities use the current outstanding balance
+	var loans []models.Loan
+	err = db.Where("customer_id = ? AND created_at < ? AND remaining_balance > 0", customer.ID, end).
+		Order("id").Find(&loans).Error
+	if err != nil {
+		return out, err
+	}
+	for _, loan := range loans {
+		out.Loans = append(out.Loans, LoanPosition{
+			LoanID:           loan.ID,
+			LoanNumber:       loan.LoanNumber,
+			Status:           loan.Status,
+			RemainingBalance: loan.RemainingBalance,
+		})
+		out.TotalLiabilities += loan.RemainingBalance
+	}
+
+	out.TotalAssets = money.Cents(out.TotalAssets)
+	out.TotalLiabilities = money.Cents(out.TotalLiabilities)
+	out.NetMovement = money.Cents(out.NetMovement)
+	return out, nil
+}
+
+// closingRates returns base currency per unit of each foreign currency the accounts hold, from the rates closing on day
+// Rates are entered against fx.BaseCurrency, so another base is reached through it. Every missing pair is reported at once
+func closingRates(db *gorm.DB, accounts []models.Account, base string, day time.Time) (map[string]float64, error) {
+	var foreign []string
+	for _, account := range accounts {
+		if account.Currency != base && !contains(foreign, account.Currency) {
+			foreign = append(foreign, account.Currency)
+		}
+	}
+	if len(foreign) == 0 {
+		return nil, nil
+	}
+	sort.Strings(foreign)
+
+	var rows []models.FXRate
+	if err := db.Where("currency IN ? AND date = ?", append([]string{base}, foreign...), day).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	quoted := map[string]float64{fx.BaseCurrency: 1}
+	for _, row := range rows {
+		quoted[row.Currency] = row.Rate
+	}
+
+	rates := map[string]float64{}
+	var missing []string
+	for _, currency := range foreign {
+		from, fromOK := quoted[currency]
+		to, toOK := quoted[base]
+		if !fromOK || !toOK {
+			missing = append(missing, currency+"/"+base)
+			continue
+		}
+		rates[currency] = math.Round(from/to*1e8) / 1e8
+	}
+	if len(missing) > 0 {
+		return nil, &MissingRatesError{Date: day, Pairs: missing}
+	}
+	return rates, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
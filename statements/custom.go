@@ -0,0 +1,354 @@
+package statements
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/communications"
+	"banking-app/i18n"
+	"banking-app/ledger"
+	"banking-app/lifecycle"
+	"banking-app/models"
+	"banking-app/money"
+	"banking-app/tenancy"
+	"banking-app/uploads"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Custom statement request statuses
+const (
+	RequestQueued  = "queued"
+	RequestRunning = "running"
+	RequestReady   = "ready"
+	RequestFailed  = "failed"
+)
+
+// Documents a custom statement can be generated as
+const (
+	FormatPDF  = "pdf"
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// Kinds of pending debit listed on a custom statement
+const (
+	PendingAuthorization = "authorization" // A pre-authorization hold
+	PendingReview        = "review"        // A first debit held for review
+)
+
+// Validation errors - handlers map these to client responses
+var (
+	ErrInvalidFormat = errors.New("format must be pdf, json or csv")
+	ErrInvalidDates  = errors.New("from and to must be YYYY-MM-DD")
+	ErrReversedRange = errors.New("to cannot be before from")
+	ErrFutureRange   = errors.New("to cannot be in the future")
+	ErrRangeTooLong  = errors.New("the range is longer than statements may cover")
+	ErrBeforeOpening = errors.New("from cannot be before the account was opened")
+)
+
+// CustomConfig holds the limits of statements requested for custom date ranges
+type CustomConfig struct {
+	MaxMonths int   // Longest range a statement may cover
+	SyncLimit int64 // Most postings generated while the caller waits; statements with more are queued
+}
+
+// CustomConfigFromEnv reads STATEMENT_CUSTOM_MAX_MONTHS (default 24) and STATEMENT_CUSTOM_SYNC_LIMIT (default 500)
+func CustomConfigFromEnv() CustomConfig {
+	months, err := strconv.Atoi(os.Getenv("STATEMENT_CUSTOM_MAX_MONTHS"))
+	if err != nil || months <= 0 {
+		months = 24
+	}
+	limit, err := strconv.ParseInt(os.Getenv("STATEMENT_CUSTOM_SYNC_LIMIT"), 10, 64)
+	if err != nil || limit < 0 {
+		limit = 500
+	}
+	return CustomConfig{MaxMonths: months, SyncLimit: limit}
+}
+
+// ParseRange turns inclusive from and to dates into the statement period [start, end) in bank time
+// The range may not run past today, start before the account was opened or cover more than cfg.MaxMonths months
+func ParseRange(cfg CustomConfig, account models.Account, from, to string, now time.Time) (time.Time, time.Time, error) {
+	start, fromErr := businessdays.ParseDate(from)
+	last, toErr := businessdays.ParseDate(to)
+	if fromErr != nil || toErr != nil {
+		return start, last, ErrInvalidDates
+	}
+	end := ledger.DayAfter(last)
+	switch {
+	case last.Before(start):
+		return start, end, ErrReversedRange
+	case last.After(ledger.StartOfDay(now)):
+		return start, end, ErrFutureRange
+	case end.After(start.AddDate(0, cfg.MaxMonths, 0)):
+		return start, end, fmt.Errorf("%w: at most %d months", ErrRangeTooLong, cfg.MaxMonths)
+	case start.Before(ledger.StartOfDay(account.CreatedAt)):
+		return start, end, ErrBeforeOpening
+	}
+	return start, end, nil
+}
+
+// ValidFormat reports whether a custom statement can be generated as format
+func ValidFormat(format string) bool {
+	return format == FormatPDF || format == FormatJSON || format == FormatCSV
+}
+
+// Pending is a debit held on the account rather than posted, so it is in neither the lines nor the balances
+type Pending struct {
+	Date        time.Time `json:"date"` // When the hold was placed
+	Kind        string    `json:"kind"` // authorization or review
+	Reference   string    `json:"reference,omitempty"`
+	Description string    `json:"description"`
+	Amount      float64   `json:"amount"` // Signed like lines: a held debit is negative
+	Status      string    `json:"status"` // Its status now; it may have posted or been released since
+}
+
+// Custom is an account's activity over a custom date range, with the debits that were pending during it
+type Custom struct {
+	Statement
+	Pending []Pending `json:"pending"`
+}
+
+// BuildCustom assembles the statement of an account for [start, end)
+// The opening balance is the account's balance at the end of the day before start, found the same way as
+// BalanceAt. Pending lists holds and reviewed debits that were pending at any time in the range, oldest first
+func BuildCustom(db *gorm.DB, account models.Account, start, end time.Time) (Custom, error) {
+	st, err := Build(db, account, start, end)
+	if err != nil {
+		return Custom{Statement: st}, err
+	}
+	pending, err := PendingDuring(db, account.ID, start, end)
+	return Custom{Statement: st, Pending: pending}, err
+}
+
+// PendingDuring lists the authorization holds and reviewed debits on an account that were pending at some time in
+// [start, end): placed before the range ended and still pending, or settled after it began
+func PendingDuring(db *gorm.DB, accountID uint, start, end time.Time) ([]Pending, error) {
+	pending := []Pending{}
+
+	var holds []models.Authorization
+	err := db.Where("account_id = ? AND created_at < ? AND (status = ? OR closed_at >= ?)", accountID, end, ledger.AuthorizationPending, start).
+		Order("created_at, id").Find(&holds).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range holds {
+		pending = append(pending, Pending{
+			Date:        businessdays.In(h.CreatedAt),
+			Kind:        PendingAuthorization,
+			Reference:   h.Reference,
+			Description: h.Merchant,
+			Amount:      -money.Cents(h.Amount),
+			Status:      h.Status,
+		})
+	}
+
+	var reviews []models.TransactionReview
+	err = db.Where("account_id = ? AND created_at < ? AND (status = ? OR decided_at >= ?)", accountID, end, ledger.ReviewPending, start).
+		Order("created_at, id").Find(&reviews).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range reviews {
+		pending = append(pending, Pending{
+			Date:        businessdays.In(r.CreatedAt),
+			Kind:        PendingReview,
+			Reference:   r.Reference,
+			Description: r.Description,
+			Amount:      -money.Cents(r.Amount),
+			Status:      r.Status,
+		})
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].Date.Before(pending[j].Date) })
+	return pending, nil
+}
+
+// WriteCustomCSV renders a custom statement as CSV: the lines as WriteCSV writes them, then one row per pending
+// debit typed pending_authorization or pending_review, with its reference as the transaction ID, its status as the
+// memo and no running balance
+func WriteCustomCSV(w io.Writer, st Custom) error {
+	if err := WriteCSV(w, st.Statement); err != nil {
+		return err
+	}
+	out := csv.NewWriter(w)
+	for _, p := range st.Pending {
+		out.Write([]string{
+			p.Date.Format(time.RFC3339),
+			"",
+			p.Reference,
+			"pending_" + p.Kind,
+			p.Description,
+			p.Status,
+			strconv.FormatFloat(p.Amount, 'f', 2, 64),
+			"",
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// renderCustom writes a custom statement in format, labelled in the given locale
+func renderCustom(w io.Writer, db *gorm.DB, account models.Account, bank string, st Custom, format string, locale *i18n.Locale) error {
+	switch format {
+	case FormatJSON:
+		return json.NewEncoder(w).Encode(st)
+	case FormatCSV:
+		return WriteCustomCSV(w, st)
+	}
+	summary := Summary{
+		OpeningBalance: st.OpeningBalance,
+		ClosingBalance: st.ClosingBalance,
+		TotalCredits:   st.TotalCredits,
+		TotalDebits:    st.TotalDebits,
+		Transactions:   int64(len(st.Lines)),
+	}
+	return writeAccountPDF(w, db, account, bank, st.PeriodStart, st.PeriodEnd, summary, locale, st.Pending)
+}
+
+// contentTypes are the media types of stored statement documents by format
+var contentTypes = map[string]string{
+	FormatPDF:  "application/pdf",
+	FormatJSON: "application/json",
+	FormatCSV:  "text/csv",
+}
+
+// ContentType is the media type of a stored statement document
+func ContentType(format string) string {
+	if t, ok := contentTypes[format]; ok {
+		return t
+	}
+	return contentTypes[FormatPDF]
+}
+
+// GenerateCustom renders a requested statement, stores it and archives it as an ad hoc statement, marking the
+// request ready. Ad hoc statements are logged in the customer's communication log but never emailed, numbered or
+// checked for continuity. The request must be running and the account loaded with its Customer
+func GenerateCustom(db *gorm.DB, storage uploads.Storage, account models.Account, req *models.StatementRequest, now time.Time) (models.Statement, error) {
+	custom, err := BuildCustom(db, account, req.PeriodStart, req.PeriodEnd)
+	if err != nil {
+		return models.Statement{}, err
+	}
+	var tenant models.Tenant
+	db.First(&tenant, account.TenantID)
+
+	locale := i18n.For(account.Customer.PreferredLanguage)
+	var buf bytes.Buffer
+	if err := renderCustom(&buf, db, account, tenant.Name, custom, req.Format, locale); err != nil {
+		return models.Statement{}, err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	key := uploads.Prefix("statements", account.TenantID, account.CustomerID, req.PeriodStart) +
+		fmt.Sprintf("/%d-adhoc-%d.%s", account.ID, req.ID, req.Format)
+	if err := storage.Put(key, buf.Bytes()); err != nil {
+		return models.Statement{}, err
+	}
+
+	st := models.Statement{
+		TenantID:         account.TenantID,
+		AccountID:        account.ID,
+		CustomerID:       account.CustomerID,
+		PeriodStart:      req.PeriodStart,
+		PeriodEnd:        req.PeriodEnd,
+		AdHoc:            true,
+		RequestID:        req.ID,
+		Format:           req.Format,
+		Currency:         account.Currency,
+		OpeningBalance:   custom.OpeningBalance,
+		ClosingBalance:   custom.ClosingBalance,
+		TotalCredits:     custom.TotalCredits,
+		TotalDebits:      custom.TotalDebits,
+		TransactionCount: int64(len(custom.Lines)),
+		StorageKey:       key,
+		Size:             int64(buf.Len()),
+		Checksum:         hex.EncodeToString(sum[:]),
+		Generations:      1,
+		Channel:          ChannelNone,
+		Delivery:         DeliveryArchived,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lifecycle.Check(lifecycle.StatementRequest, req.Status, RequestReady); err != nil {
+			return err
+		}
+		if err := tx.Create(&st).Error; err != nil {
+			return err
+		}
+		req.Status, req.StatementID, req.CompletedAt, req.Error = RequestReady, &st.ID, &now, ""
+		err := tx.Model(req).Updates(map[string]interface{}{"status": req.Status, "statement_id": st.ID, "completed_at": now, "error": ""}).Error
+		if err != nil {
+			return err
+		}
+		last := req.PeriodEnd.AddDate(0, 0, -1)
+		_, err = communications.Record(tx, nil, communications.Entry{
+			CustomerID:   st.CustomerID,
+			Channel:      communications.ChannelArchive,
+			Subject:      locale.T("statement.custom_subject", locale.Date(req.PeriodStart), locale.Date(last)),
+			Status:       communications.StatusArchived,
+			ResourceType: communications.ResourceStatement,
+			ResourceID:   st.ID,
+			ContentKey:   st.StorageKey,
+			ContentType:  ContentType(st.Format),
+		})
+		return err
+	})
+	return st, err
+}
+
+// FailRequest records why a running request could not be generated
+func FailRequest(db *gorm.DB, req *models.StatementRequest, cause error, now time.Time) error {
+	if err := lifecycle.Check(lifecycle.StatementRequest, req.Status, RequestFailed); err != nil {
+		return err
+	}
+	message := cause.Error()
+	if len(message) > 500 {
+		message = message[:500]
+	}
+	req.Status, req.Error, req.CompletedAt = RequestFailed, message, &now
+	return db.Model(req).Updates(map[string]interface{}{"status": req.Status, "error": message, "completed_at": now}).Error
+}
+
+// RunPending generates every queued custom statement, oldest first, each in its own tenant
+// A request left running by a restart is generated again from the start
+func RunPending(db *gorm.DB, storage uploads.Storage) {
+	var queued []models.StatementRequest
+	if err := db.Where("status IN ?", []string{RequestQueued, RequestRunning}).Order("id").Find(&queued).Error; err != nil {
+		log.Printf("statements: loading custom statement requests failed: %v", err)
+		return
+	}
+	for i := range queued {
+		req := &queued[i]
+		scoped := db.WithContext(tenancy.NewContext(context.Background(), req.TenantID))
+		if req.Status == RequestQueued {
+			if err := scoped.Model(req).Update("status", RequestRunning).Error; err != nil {
+				log.Printf("statements: starting custom statement %d failed: %v", req.ID, err)
+				continue
+			}
+			req.Status = RequestRunning
+		}
+
+		var account models.Account
+		err := scoped.Preload("Customer").First(&account, req.AccountID).Error
+		if err == nil {
+			_, err = GenerateCustom(scoped, storage, account, req, clock.Now())
+		}
+		if err != nil {
+			log.Printf("statements: custom statement %d failed: %v", req.ID, err)
+			if err := FailRequest(scoped, req, err, clock.Now()); err != nil {
+				log.Printf("statements: recording custom statement %d failure failed: %v", req.ID, err)
+			}
+		}
+	}
+}
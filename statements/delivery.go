@@ -0,0 +1,394 @@
+package statements
+
+import (
+	"banking-app/businessdays"
+	"banking-app/communications"
+	"banking-app/display"
+	"banking-app/documents"
+	"banking-app/events"
+	"banking-app/i18n"
+	"banking-app/models"
+	"banking-app/notifications"
+	"banking-app/uploads"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Statement delivery channels an account can choose
+const (
+	ChannelEmail = "email"
+	ChannelNone  = "none"
+)
+
+// Delivery outcomes of an archived statement
+const (
+	DeliveryEmailed  = "emailed"
+	DeliveryArchived = "archived"
+)
+
+// MaxDay is the latest statement day; every month has it
+const MaxDay = 28
+
+// ErrLinkExpired is returned for a download token whose link has expired
+var ErrLinkExpired = errors.New("statement link has expired")
+
+// DeliveryConfig holds the settings of emailed statement links
+type DeliveryConfig struct {
+	LinkTTL time.Duration // How long an emailed download link works
+	BaseURL string        // Public URL of the API that links point at
+}
+
+// DeliveryConfigFromEnv reads STATEMENT_LINK_TTL_HOURS (default 168, one week) and
+// PUBLIC_BASE_URL (default "http://localhost:8080")
+func DeliveryConfigFromEnv() DeliveryConfig {
+	hours, err := strconv.Atoi(os.Getenv("STATEMENT_LINK_TTL_HOURS"))
+	if err != nil || hours <= 0 {
+		hours = 168
+	}
+	base := strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	return DeliveryConfig{LinkTTL: time.Duration(hours) * time.Hour, BaseURL: base}
+}
+
+// ValidatePreference checks a channel and statement day
+func ValidatePreference(pref models.StatementPreference) error {
+	if pref.Channel != ChannelEmail && pref.Channel != ChannelNone {
+		return fmt.Errorf("channel must be %s or %s", ChannelEmail, ChannelNone)
+	}
+	if pref.Day < 1 || pref.Day > MaxDay {
+		return fmt.Errorf("day must be between 1 and %d", MaxDay)
+	}
+	return nil
+}
+
+// DuePeriod returns the start of the month whose statement is due at now, and whether one is due
+// The previous month's statement falls due on the preferred day and stays due until it is archived
+func DuePeriod(pref models.StatementPreference, now time.Time) (time.Time, bool) {
+	day := pref.Day
+	if day < 1 || day > MaxDay {
+		day = 1
+	}
+	now = businessdays.In(now)
+	if pref.Channel == ChannelNone || now.Day() < day {
+		return time.Time{}, false
+	}
+	return businessdays.StartOfMonth(now).AddDate(0, -1, 0), true
+}
+
+// DispatchResult counts what one dispatch run did
+type DispatchResult struct {
+	Generated int `json:"generated"`
+	Emailed   int `json:"emailed"`
+	Archived  int `json:"archived"` // Archive-only, flagged for follow-up
+	Failed    int `json:"failed"`
+}
+
+// Dispatch generates every due statement that is not archived yet
+// Safe to run repeatedly: an account's period is only generated and sent once
+func Dispatch(db *gorm.DB, storage uploads.Storage, cfg DeliveryConfig, now time.Time) (DispatchResult, error) {
+	var result DispatchResult
+	thisMonth := businessdays.StartOfMonth(now)
+
+	var accounts []models.Account
+	err := db.Preload("Customer").
+		Where("statement_channel = ? AND status <> ? AND account_type <> ?", ChannelEmail, "closed", "internal").
+		Where("created_at < ?", thisMonth).
+		Find(&accounts).Error
+	if err != nil {
+		return result, err
+	}
+
+	for _, account := range accounts {
+		start, due := DuePeriod(account.StatementPreference, now)
+		if !due {
+			continue
+		}
+		var archived int64
+		if err := db.Model(&models.Statement{}).Where("account_id = ? AND period_start = ? AND ad_hoc = ?", account.ID, start, false).Count(&archived).Error; err != nil {
+			return result, err
+		}
+		if archived > 0 {
+			continue
+		}
+
+		st, _, err := Generate(db, storage, cfg, account, start, now)
+		if err != nil {
+			log.Printf("statements: account %s %s failed: %v", account.AccountNumber, start.Format("2006-01"), err)
+			result.Failed++
+			continue
+		}
+		result.Generated++
+		if st.Delivery == DeliveryEmailed {
+			result.Emailed++
+		} else {
+			result.Archived++
+		}
+	}
+	return result, nil
+}
+
+// Generate renders an account's statement for the month starting at start, stores the PDF and archives it
+// The statement and its email are in the customer's preferred language.
+// The first generation sends the statement; regenerating a period re-renders the same storage key and
+// archive row without sending again. It reports whether the statement was created by this call.
+// Each statement takes its month's sequence number in the account's archive, and its opening balance is checked
+// against the closing balance of the statement numbered before it.
+// The account must be loaded with its Customer
+func Generate(db *gorm.DB, storage uploads.Storage, cfg DeliveryConfig, account models.Account, start, now time.Time) (models.Statement, bool, error) {
+	return generate(db, storage, cfg, account, start, now, false)
+}
+
+// generate is Generate; a repaired statement fills a gap in the archive and is archived without sending
+func generate(db *gorm.DB, storage uploads.Storage, cfg DeliveryConfig, account models.Account, start, now time.Time, repair bool) (models.Statement, bool, error) {
+	start = businessdays.StartOfMonth(start)
+	end := start.AddDate(0, 1, 0)
+
+	sequence, err := sequenceFor(db, account.ID, start)
+	if err != nil {
+		return models.Statement{}, false, err
+	}
+	summary, err := Summarize(db, account.ID, start, end)
+	if err != nil {
+		return models.Statement{}, false, err
+	}
+	var tenant models.Tenant
+	db.First(&tenant, account.TenantID)
+
+	var buf bytes.Buffer
+	if err := writeAccountPDF(&buf, db, account, tenant.Name, start, end, summary, i18n.For(account.Customer.PreferredLanguage), nil); err != nil {
+		return models.Statement{}, false, err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	key := uploads.Prefix("statements", account.TenantID, account.CustomerID, start) + fmt.Sprintf("/%d.pdf", account.ID)
+	if err := storage.Put(key, buf.Bytes()); err != nil {
+		return models.Statement{}, false, err
+	}
+
+	var st models.Statement
+	created := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("account_id = ? AND period_start = ? AND ad_hoc = ?", account.ID, start, false).First(&st).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		created = err == gorm.ErrRecordNotFound
+
+		st.TenantID = account.TenantID
+		st.AccountID = account.ID
+		st.CustomerID = account.CustomerID
+		st.PeriodStart = start
+		st.PeriodEnd = end
+		st.Sequence = sequence
+		st.Currency = account.Currency
+		st.OpeningBalance = summary.OpeningBalance
+		st.ClosingBalance = summary.ClosingBalance
+		st.TotalCredits = summary.TotalCredits
+		st.TotalDebits = summary.TotalDebits
+		st.TransactionCount = summary.Transactions
+		st.StorageKey = key
+		st.Size = int64(buf.Len())
+		st.Checksum = hex.EncodeToString(sum[:])
+		if !created {
+			st.Generations++
+			if err := tx.Save(&st).Error; err != nil {
+				return err
+			}
+			return checkContinuity(tx, account, &st, now)
+		}
+
+		st.Generations = 1
+		st.Channel = account.StatementPreference.Channel
+		st.Repaired = repair
+		if err := tx.Create(&st).Error; err != nil {
+			return err
+		}
+		if err := checkContinuity(tx, account, &st, now); err != nil {
+			return err
+		}
+		if err := deliver(tx, cfg, account, &st, now); err != nil {
+			return err
+		}
+		if repair {
+			return nil
+		}
+		return events.Record(tx, events.AggregateAccount, account.ID, events.StatementReady, map[string]interface{}{
+			"account_id":      account.ID,
+			"statement_id":    st.ID,
+			"sequence":        st.Sequence,
+			"period_start":    st.PeriodStart,
+			"period_end":      st.PeriodEnd,
+			"closing_balance": st.ClosingBalance,
+			"currency":        st.Currency,
+			"delivery":        st.Delivery,
+		})
+	})
+	return st, created, err
+}
+
+// deliver emails a new statement's download link, or leaves it archive-only and flags it for follow-up
+// when the customer has no verified email address. Repaired statements are only archived
+// Archive-only statements are added to the customer's communication log
+func deliver(tx *gorm.DB, cfg DeliveryConfig, account models.Account, st *models.Statement, now time.Time) error {
+	locale := i18n.For(account.Customer.PreferredLanguage)
+	updates := map[string]interface{}{}
+	switch {
+	case st.Channel != ChannelEmail, st.Repaired:
+		updates["delivery"] = DeliveryArchived
+	case strings.TrimSpace(account.Customer.Email) == "":
+		updates["delivery"] = DeliveryArchived
+		updates["needs_follow_up"] = true
+		updates["follow_up_reason"] = "Customer has no email address on file"
+	case !account.Customer.EmailVerified:
+		updates["delivery"] = DeliveryArchived
+		updates["needs_follow_up"] = true
+		updates["follow_up_reason"] = "Customer email address is not verified"
+	default:
+		token, err := newToken()
+		if err != nil {
+			return err
+		}
+		expires := now.Add(cfg.LinkTTL)
+		notification := models.Notification{
+			CustomerID:   account.CustomerID,
+			Channel:      "email",
+			ResourceType: communications.ResourceStatement,
+			ResourceID:   st.ID,
+			Recipient:    account.Customer.Email,
+			Subject:      locale.T("statement.email_subject", locale.Month(st.PeriodStart)),
+			Body: locale.T("statement.email_body", display.MaskAccountNumber(account.AccountNumber), locale.Month(st.PeriodStart),
+				int(cfg.LinkTTL.Hours()/24), cfg.BaseURL+"/api/v1/statements/"+token),
+		}
+		if err := notifications.Enqueue(tx, &notification); err != nil {
+			return err
+		}
+		updates["delivery"] = DeliveryEmailed
+		updates["link_token_hash"] = HashToken(token)
+		updates["link_expires_at"] = &expires
+		updates["notification_id"] = notification.ID
+	}
+	if err := tx.Model(st).Updates(updates).Error; err != nil {
+		return err
+	}
+	if err := tx.First(st, st.ID).Error; err != nil {
+		return err
+	}
+
+	// An emailed statement is logged when its email is sent; an archived one is logged now, pointing at the PDF
+	if st.Delivery != DeliveryArchived {
+		return nil
+	}
+	_, err := communications.Record(tx, nil, communications.Entry{
+		CustomerID:   st.CustomerID,
+		Channel:      communications.ChannelArchive,
+		Subject:      locale.T("statement.archive_subject", locale.Month(st.PeriodStart)),
+		Status:       communications.StatusArchived,
+		ResourceType: communications.ResourceStatement,
+		ResourceID:   st.ID,
+		ContentKey:   st.StorageKey,
+		ContentType:  "application/pdf",
+	})
+	return err
+}
+
+// FindByToken looks up the statement of an emailed download token
+// Unknown tokens return gorm.ErrRecordNotFound and expired ones ErrLinkExpired
+func FindByToken(db *gorm.DB, token string, now time.Time) (models.Statement, error) {
+	var st models.Statement
+	if token == "" {
+		return st, gorm.ErrRecordNotFound
+	}
+	if err := db.Where("link_token_hash = ?", HashToken(token)).First(&st).Error; err != nil {
+		return st, err
+	}
+	if st.LinkExpiresAt == nil || now.After(*st.LinkExpiresAt) {
+		return st, ErrLinkExpired
+	}
+	return st, nil
+}
+
+// HashToken is the stored form of a download token; the token itself is only ever emailed
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken returns a random, URL-safe download token
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// writeAccountPDF renders one account's statement with its postings, labelled in the given locale, followed by
+// a section for pending debits when there are any. Nothing time-dependent is printed, so regenerating an unchanged
+// period produces the same document
+func writeAccountPDF(w io.Writer, db *gorm.DB, account models.Account, bank string, start, end time.Time, summary Summary, locale *i18n.Locale, pending []Pending) error {
+	money, date, t := locale.Number, locale.Date, locale.T
+	last := end.AddDate(0, 0, -1)
+	holder := strings.TrimSpace(account.Customer.FirstName + " " + account.Customer.LastName)
+
+	pdf := documents.NewPDF(w)
+	if bank != "" {
+		pdf.Heading(bank)
+	}
+	pdf.Heading(t("statement.title"))
+	pdf.Text(holder)
+	pdf.Text(t("statement.account", display.MaskAccountNumber(account.AccountNumber), account.AccountType, account.Currency))
+	pdf.Text(t("statement.period", date(start), date(last)))
+
+	pdf.Heading(t("statement.transactions"))
+	pdf.Mono(fmt.Sprintf("%-10s %-20s %-26s %12s %12s", t("statement.column_date"), t("statement.column_reference"),
+		t("statement.column_description"), t("statement.column_amount"), t("statement.column_balance")))
+	pdf.Mono(fmt.Sprintf("%-10s %-20s %-26s %12s %12s", date(start), "", t("statement.opening_balance"), "", money(summary.OpeningBalance)))
+	err := Each(db, account.ID, start, end, summary.OpeningBalance, func(line Line) error {
+		pdf.Mono(fmt.Sprintf("%-10s %-20s %-26s %12s %12s", date(line.Date), clip(line.TransactionID, 20),
+			clip(line.Description, 26), money(line.Amount), money(line.Balance)))
+		if line.Memo != "" && line.Memo != line.Description {
+			pdf.Mono(fmt.Sprintf("%-10s %-20s %-26s", "", "", clip(t("statement.memo", line.Memo), 26)))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	pdf.Mono(fmt.Sprintf("%-10s %-20s %-26s %12s %12s", date(last), "", t("statement.closing_balance"), "", money(summary.ClosingBalance)))
+	pdf.Text(t("statement.totals", money(summary.TotalCredits), money(summary.TotalDebits), summary.Transactions))
+
+	if len(pending) > 0 {
+		pdf.Heading(t("statement.pending"))
+		pdf.Text(t("statement.pending_note"))
+		pdf.Mono(fmt.Sprintf("%-10s %-20s %-26s %12s %12s", t("statement.column_date"), t("statement.column_reference"),
+			t("statement.column_description"), t("statement.column_amount"), t("statement.column_status")))
+		for _, p := range pending {
+			pdf.Mono(fmt.Sprintf("%-10s %-20s %-26s %12s %12s", date(p.Date), clip(p.Reference, 20),
+				clip(p.Description, 26), money(p.Amount), clip(p.Status, 12)))
+		}
+	}
+	return pdf.Close()
+}
+
+// clip shortens s to at most n characters for fixed-width columns
+func clip(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "~"
+}
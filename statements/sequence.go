@@ -0,0 +1,152 @@
+package statements
+
+import (
+	"banking-app/businessdays"
+	"banking-app/invariants"
+	"banking-app/metrics"
+	"banking-app/models"
+	"banking-app/uploads"
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrBeforeSequence is returned when generating a month before an account's first archived statement
+// The first statement is sequence 1, so an earlier month has no number to take
+var ErrBeforeSequence = errors.New("period is before the account's first archived statement")
+
+// Gap is a month missing from an account's statement archive
+type Gap struct {
+	Sequence    int       `json:"sequence"`
+	PeriodStart time.Time `json:"period_start"`
+}
+
+// sequenceFor numbers a period in an account's archive: 1 for the first archived month, counting every month since
+// A skipped month keeps its number, so it shows as a gap and is filled in place when repaired.
+// Soft-deleted statements still anchor the count
+func sequenceFor(db *gorm.DB, accountID uint, start time.Time) (int, error) {
+	var first models.Statement
+	err := db.Unscoped().Where("account_id = ? AND ad_hoc = ?", accountID, false).Order("period_start").First(&first).Error
+	if err == gorm.ErrRecordNotFound {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	months := monthsBetween(first.PeriodStart, start)
+	if months < 0 {
+		return 0, ErrBeforeSequence
+	}
+	return first.Sequence + months, nil
+}
+
+// monthsBetween counts the calendar months from one period start to another, in bank time
+func monthsBetween(from, to time.Time) int {
+	a, b := businessdays.In(from), businessdays.In(to)
+	return (b.Year()-a.Year())*12 + int(b.Month()) - int(a.Month())
+}
+
+// checkContinuity records the closing balance of the statement numbered before st and opens an invariant violation
+// when st does not open at it. Generation goes ahead either way: the statement shows the books as they are now,
+// and the violation points at what changed after the earlier statement was archived
+func checkContinuity(tx *gorm.DB, account models.Account, st *models.Statement, now time.Time) error {
+	var previous models.Statement
+	err := tx.Where("account_id = ? AND sequence = ? AND ad_hoc = ?", st.AccountID, st.Sequence-1, false).First(&previous).Error
+	if err == gorm.ErrRecordNotFound {
+		return tx.Model(st).Update("previous_closing_balance", nil).Error
+	}
+	if err != nil {
+		return err
+	}
+	closing := previous.ClosingBalance
+	st.PreviousClosingBalance = &closing
+	if err := tx.Model(st).Update("previous_closing_balance", closing).Error; err != nil {
+		return err
+	}
+	if math.Abs(st.OpeningBalance-closing) < 0.005 {
+		return nil
+	}
+
+	log.Printf("statements: continuity break tenant=%d account=%s statement=%d sequence=%d period=%s opening=%.2f previous_closing=%.2f",
+		account.TenantID, account.AccountNumber, st.ID, st.Sequence, st.PeriodStart.Format("2006-01"), st.OpeningBalance, closing)
+	metrics.NewCounter("statement_continuity_breaks_total", "Statements generated with an opening balance that is not the previous closing balance").Inc()
+	_, err = invariants.Open(tx, invariants.StatementContinuity(account.TenantID, account.ID, account.AccountNumber,
+		st.ID, st.Sequence, closing, st.OpeningBalance), now)
+	return err
+}
+
+// Gaps lists the months missing between an account's first and latest archived statements, oldest first
+func Gaps(db *gorm.DB, accountID uint) ([]Gap, error) {
+	var archived []models.Statement
+	err := db.Select("sequence", "period_start").Where("account_id = ? AND sequence > 0 AND ad_hoc = ?", accountID, false).Order("sequence").Find(&archived).Error
+	if err != nil || len(archived) == 0 {
+		return []Gap{}, err
+	}
+	anchor := archived[0]
+	gaps := []Gap{}
+	next := 1
+	for _, st := range archived {
+		for ; next < st.Sequence; next++ {
+			gaps = append(gaps, Gap{Sequence: next, PeriodStart: businessdays.StartOfMonth(anchor.PeriodStart).AddDate(0, next-anchor.Sequence, 0)})
+		}
+		next = st.Sequence + 1
+	}
+	return gaps, nil
+}
+
+// Repair generates every missing month of an account's archive from its postings, under the month's own sequence
+// number. Repaired statements are archived without being sent. The account must be loaded with its Customer
+func Repair(db *gorm.DB, storage uploads.Storage, cfg DeliveryConfig, account models.Account, now time.Time) ([]models.Statement, error) {
+	gaps, err := Gaps(db, account.ID)
+	if err != nil {
+		return nil, err
+	}
+	repaired := []models.Statement{}
+	for _, gap := range gaps {
+		st, _, err := generate(db, storage, cfg, account, gap.PeriodStart, now, true)
+		if err != nil {
+			return repaired, err
+		}
+		repaired = append(repaired, st)
+	}
+	return repaired, nil
+}
+
+// Backfill numbers statements archived before sequencing, oldest first per account, and records each one's
+// previous closing balance. Returns how many it numbered
+func Backfill(db *gorm.DB) (int, error) {
+	var accountIDs []uint
+	if err := db.Unscoped().Model(&models.Statement{}).Where("sequence = 0 AND ad_hoc = ?", false).Distinct().Pluck("account_id", &accountIDs).Error; err != nil {
+		return 0, err
+	}
+	numbered := 0
+	for _, accountID := range accountIDs {
+		var archived []models.Statement
+		if err := db.Unscoped().Where("account_id = ? AND ad_hoc = ?", accountID, false).Order("period_start").Find(&archived).Error; err != nil {
+			return numbered, err
+		}
+		closings := map[int]float64{}
+		for _, st := range archived {
+			sequence := st.Sequence
+			if sequence == 0 {
+				sequence = 1 + monthsBetween(archived[0].PeriodStart, st.PeriodStart)
+			}
+			updates := map[string]interface{}{"sequence": sequence}
+			if closing, ok := closings[sequence-1]; ok && st.PreviousClosingBalance == nil {
+				updates["previous_closing_balance"] = closing
+			}
+			closings[sequence] = st.ClosingBalance
+			if st.Sequence != 0 {
+				continue
+			}
+			if err := db.Unscoped().Model(&models.Statement{}).Where("id = ?", st.ID).Updates(updates).Error; err != nil {
+				return numbered, err
+			}
+			numbered++
+		}
+	}
+	return numbered, nil
+}
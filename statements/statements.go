@@ -0,0 +1,296 @@
+package statements
+
+import (
+	"banking-app/archive"
+	"banking-app/businessdays"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/money"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Line is one posting on a statement
+type Line struct {
+	Date          time.Time `json:"date"`       // Effective date
+	ValueDate     time.Time `json:"value_date"` // Bank day the posting counts from; its effective day before value dating
+	TransactionID string    `json:"transaction_id"`
+	Type          string    `json:"type"`
+	Description   string    `json:"description"` // Statement descriptor; the raw description for postings made before descriptors
+	Memo          string    `json:"memo,omitempty"`
+	Amount        float64   `json:"amount"` // Signed: credits positive, debits negative
+	Balance       float64   `json:"balance"`
+}
+
+// Statement is an account's activity over one period
+type Statement struct {
+	AccountID      uint      `json:"account_id"`
+	AccountNumber  string    `json:"account_number"`
+	Currency       string    `json:"currency"`
+	PeriodStart    time.Time `json:"period_start"`
+	PeriodEnd      time.Time `json:"period_end"` // Exclusive
+	OpeningBalance float64   `json:"opening_balance"`
+	ClosingBalance float64   `json:"closing_balance"`
+	TotalCredits   float64   `json:"total_credits"`
+	TotalDebits    float64   `json:"total_debits"`
+	Lines          []Line    `json:"lines"`
+
+	IncludesArchive bool `json:"includes_archive,omitempty"` // Postings were read from the archive table
+}
+
+// ParseMonth parses a YYYY-MM period into its first instant (UTC)
+func ParseMonth(month string) (time.Time, error) {
+	start, err := time.Parse("2006-01", month)
+	if err != nil {
+		return start, fmt.Errorf("invalid month %q, expected YYYY-MM", month)
+	}
+	return start, nil
+}
+
+// Summary is the balance movement of an account over a period
+type Summary struct {
+	OpeningBalance float64 `json:"opening_balance"`
+	ClosingBalance float64 `json:"closing_balance"`
+	TotalCredits   float64 `json:"total_credits"`
+	TotalDebits    float64 `json:"total_debits"`
+	Transactions   int64   `json:"transaction_count"`
+
+	IncludesArchive bool `json:"includes_archive,omitempty"` // The period reaches back into archived postings
+}
+
+// Summarize computes an account's period totals with aggregate queries, without loading postings
+func Summarize(db *gorm.DB, accountID uint, start, end time.Time) (Summary, error) {
+	var sum Summary
+
+	opening, err := openingBalance(db, accountID, start)
+	if err != nil {
+		return sum, err
+	}
+
+	postings, archived, err := source(db, accountID, start)
+	if err != nil {
+		return sum, err
+	}
+	var period struct {
+		Credits float64
+		Debits  float64
+		Count   int64
+	}
+	err = postings.
+		Select("COALESCE(SUM(CASE WHEN "+ledger.CreditSQL+" THEN amount ELSE 0 END), 0) AS credits, "+
+			"COALESCE(SUM(CASE WHEN "+ledger.CreditSQL+" THEN 0 ELSE amount END), 0) AS debits, COUNT(*) AS count").
+		Where("account_id = ? AND effective_date >= ? AND effective_date < ?", accountID, start, end).Scan(&period).Error
+	if err != nil {
+		return sum, err
+	}
+
+	sum.OpeningBalance = opening
+	sum.TotalCredits = money.Cents(period.Credits)
+	sum.TotalDebits = money.Cents(period.Debits)
+	sum.ClosingBalance = money.Cents(sum.OpeningBalance + sum.TotalCredits - sum.TotalDebits)
+	sum.Transactions = period.Count
+	sum.IncludesArchive = archived
+	return sum, nil
+}
+
+// openingBalance sums the postings effective before a time
+// Statement openings and historical balances both come from here, so they cannot disagree. Once every archived
+// posting falls before the time, the archive's running total stands in for them
+func openingBalance(db *gorm.DB, accountID uint, before time.Time) (float64, error) {
+	summary, archived, err := archive.Summary(db, accountID)
+	if err != nil {
+		return 0, err
+	}
+	postings, carried := db.Model(&models.Transaction{}), 0.0
+	if archived && !before.After(summary.Through) {
+		postings = archive.Transactions(db)
+	} else if archived {
+		carried = summary.Net
+	}
+
+	var opening struct{ Net float64 }
+	err = postings.
+		Select("COALESCE(SUM("+ledger.SignedAmountSQL+"), 0) AS net").
+		Where("account_id = ? AND effective_date < ?", accountID, before).Scan(&opening).Error
+	return money.Cents(carried + opening.Net), err
+}
+
+// source is the postings an account's history from a time on is read from: the transactions table, or its union
+// with the archive when the history reaches back that far
+func source(db *gorm.DB, accountID uint, from time.Time) (*gorm.DB, bool, error) {
+	archived, err := archive.Reaches(db, accountID, &from)
+	if err != nil || !archived {
+		return db.Model(&models.Transaction{}), false, err
+	}
+	return archive.Transactions(db), true, nil
+}
+
+// MethodReplay is how a historical balance is found: summed from the postings effective by the end of the day
+// Accounts keep no daily balance snapshots, so it is the only method
+const MethodReplay = "replay"
+
+// Balance is an account's balance at the end of a day
+type Balance struct {
+	AsOf            time.Time `json:"as_of"`
+	Balance         float64   `json:"balance"`
+	Method          string    `json:"method"`
+	LastTransaction *Line     `json:"last_transaction"` // The last posting counted, by effective date; nil if none
+}
+
+// BalanceAt returns an account's balance at the end of a day, by effective date
+// It is the opening balance of a statement starting the next day
+func BalanceAt(db *gorm.DB, accountID uint, day time.Time) (Balance, error) {
+	end := ledger.DayAfter(day)
+	balance := Balance{AsOf: ledger.StartOfDay(day), Method: MethodReplay}
+	var err error
+	if balance.Balance, err = openingBalance(db, accountID, end); err != nil {
+		return balance, err
+	}
+
+	// The latest posting is hot unless none is as late as the archive reaches
+	var last []models.Transaction
+	err = db.Where("account_id = ? AND effective_date < ?", accountID, end).
+		Order("effective_date DESC, id DESC").Limit(1).Find(&last).Error
+	if err != nil {
+		return balance, err
+	}
+	if summary, archived, err := archive.Summary(db, accountID); err != nil {
+		return balance, err
+	} else if archived && (len(last) == 0 || !last[0].EffectiveDate.After(summary.Through)) {
+		err = archive.Transactions(db).Where("account_id = ? AND effective_date < ?", accountID, end).
+			Order("effective_date DESC, id DESC").Limit(1).Find(&last).Error
+		if err != nil {
+			return balance, err
+		}
+	}
+	if len(last) == 0 {
+		return balance, nil
+	}
+	balance.LastTransaction = &Line{
+		Date:          businessdays.In(last[0].EffectiveDate),
+		ValueDate:     valueDate(last[0]),
+		TransactionID: last[0].TransactionID,
+		Type:          last[0].TransactionType,
+		Description:   describe(last[0]),
+		Memo:          last[0].Memo,
+		Amount:        ledger.SignedAmount(last[0]),
+		Balance:       balance.Balance,
+	}
+	return balance, nil
+}
+
+// Each streams an account's postings for [start, end) in effective-date order
+// Rows are read one at a time so large statements never load into memory; opening is the
+// balance the running balance starts from
+func Each(db *gorm.DB, accountID uint, start, end time.Time, opening float64, fn func(Line) error) error {
+	postings, _, err := source(db, accountID, start)
+	if err != nil {
+		return err
+	}
+	rows, err := postings.
+		Where("account_id = ? AND effective_date >= ? AND effective_date < ?", accountID, start, end).
+		Order("effective_date, id").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	balance := opening
+	for rows.Next() {
+		var t models.Transaction
+		if err := db.ScanRows(rows, &t); err != nil {
+			return err
+		}
+		amount := ledger.SignedAmount(t)
+		balance = money.Cents(balance + amount)
+		err := fn(Line{
+			Date:          businessdays.In(t.EffectiveDate),
+			ValueDate:     valueDate(t),
+			TransactionID: t.TransactionID,
+			Type:          t.TransactionType,
+			Description:   describe(t),
+			Memo:          t.Memo,
+			Amount:        amount,
+			Balance:       balance,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Build assembles the statement of an account for [start, end) by effective date
+// Balances are computed from effective-dated postings, so backdated corrections land in the period
+// they belong to rather than the one they were recorded in
+func Build(db *gorm.DB, account models.Account, start, end time.Time) (Statement, error) {
+	st := Statement{
+		AccountID:     account.ID,
+		AccountNumber: account.AccountNumber,
+		Currency:      account.Currency,
+		PeriodStart:   start,
+		PeriodEnd:     end,
+		Lines:         []Line{},
+	}
+
+	sum, err := Summarize(db, account.ID, start, end)
+	if err != nil {
+		return st, err
+	}
+	st.OpeningBalance = sum.OpeningBalance
+	st.ClosingBalance = sum.ClosingBalance
+	st.TotalCredits = sum.TotalCredits
+	st.TotalDebits = sum.TotalDebits
+	st.IncludesArchive = sum.IncludesArchive
+
+	err = Each(db, account.ID, start, end, st.OpeningBalance, func(line Line) error {
+		st.Lines = append(st.Lines, line)
+		return nil
+	})
+	return st, err
+}
+
+// WriteCSV renders a statement as CSV with opening and closing balance rows
+func WriteCSV(w io.Writer, st Statement) error {
+	money := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"date", "value_date", "transaction_id", "type", "description", "memo", "amount", "balance"})
+	out.Write([]string{businessdays.Format(st.PeriodStart), "", "", "opening_balance", "", "", "", money(st.OpeningBalance)})
+	for _, line := range st.Lines {
+		out.Write([]string{
+			line.Date.Format(time.RFC3339),
+			businessdays.Format(line.ValueDate),
+			line.TransactionID,
+			line.Type,
+			line.Description,
+			line.Memo,
+			money(line.Amount),
+			money(line.Balance),
+		})
+	}
+	out.Write([]string{businessdays.Format(st.PeriodEnd.AddDate(0, 0, -1)), "", "", "closing_balance", "", "", "", money(st.ClosingBalance)})
+	out.Flush()
+	return out.Error()
+}
+
+// describe is the text a statement shows for a posting: its descriptor, or its description if it has none yet
+func describe(t models.Transaction) string {
+	if t.Descriptor != "" {
+		return t.Descriptor
+	}
+	return t.Description
+}
+
+// valueDate is the bank day a posting counts from; postings made before value dating count from their effective day
+func valueDate(t models.Transaction) time.Time {
+	if t.ValueDate == nil {
+		return ledger.StartOfDay(t.EffectiveDate)
+	}
+	return businessdays.In(*t.ValueDate)
+}
@@ -0,0 +1,96 @@
+package statushistory
+
+import (
+	"banking-app/clock"
+	"banking-app/lifecycle"
+	"banking-app/models"
+
+	"gorm.io/gorm"
+)
+
+// Record types whose status changes are kept
+const (
+	SubjectCustomer = "customer"
+	SubjectAccount  = "account"
+	SubjectLoan     = "loan"
+
+	SubjectApplication = "application" // Account applications; every transition is kept
+)
+
+// SystemActor is recorded for changes made by background jobs rather than a user
+const SystemActor = "system"
+
+// Reasons recorded for changes that have no free-text reason of their own
+const (
+	ReasonCreated  = "Created"
+	ReasonUpdated  = "Updated"
+	ReasonPaidOff  = "Paid off"
+	ReasonBackfill = "Status before history was recorded"
+)
+
+// Record checks a status change against the subject's lifecycle and writes it using the caller's transaction handle
+// Must be called inside the same db.Transaction as the change itself, so a refused change rolls it back. A change
+// to the status the record already had is not recorded; ChangedAt defaults to now and ChangedBy to SystemActor
+func Record(tx *gorm.DB, change models.StatusHistory) error {
+	if change.OldStatus == change.NewStatus {
+		return nil
+	}
+	if err := lifecycle.CheckReason(change.SubjectType, change.OldStatus, change.NewStatus, change.Reason); err != nil {
+		return err
+	}
+	if change.ChangedAt.IsZero() {
+		change.ChangedAt = clock.Now()
+	}
+	if change.ChangedBy == "" {
+		change.ChangedBy = SystemActor
+	}
+	return tx.Create(&change).Error
+}
+
+// Created records the status a new record starts with
+func Created(tx *gorm.DB, tenantID uint, subjectType string, subjectID uint, status, by string) error {
+	return Record(tx, models.StatusHistory{
+		TenantID:    tenantID,
+		SubjectType: subjectType,
+		SubjectID:   subjectID,
+		NewStatus:   status,
+		Reason:      ReasonCreated,
+		ChangedBy:   by,
+	})
+}
+
+// For lists a record's status changes, oldest first
+func For(db *gorm.DB, subjectType string, subjectID uint) ([]models.StatusHistory, error) {
+	history := []models.StatusHistory{}
+	err := db.Where("subject_type = ? AND subject_id = ?", subjectType, subjectID).Order("changed_at, id").Find(&history).Error
+	return history, err
+}
+
+// Backfill gives every customer, account and loan without a creation row one, dated when the record
+// was created, so no history is empty. Records that have since moved on without a recorded change get
+// a second row for their current status, dated at their last update
+func Backfill(db *gorm.DB) error {
+	for _, subjectType := range []string{SubjectCustomer, SubjectAccount, SubjectLoan} {
+		table := subjectType + "s"
+		// Loans opened without disbursement start out pending; everything else started out active
+		initial := "'active'"
+		if subjectType == SubjectLoan {
+			initial = "CASE WHEN status IN ('pending', 'declined') THEN 'pending' ELSE 'active' END"
+		}
+		err := db.Exec(`INSERT INTO status_histories (created_at, tenant_id, subject_type, subject_id, old_status, new_status, reason, changed_by, changed_at)
+			SELECT created_at, tenant_id, ?, id, '', `+initial+`, ?, ?, created_at FROM `+table+`
+			WHERE NOT EXISTS (SELECT 1 FROM status_histories h WHERE h.subject_type = ? AND h.subject_id = `+table+`.id AND h.old_status = '')`,
+			subjectType, ReasonCreated, SystemActor, subjectType).Error
+		if err != nil {
+			return err
+		}
+		err = db.Exec(`INSERT INTO status_histories (created_at, tenant_id, subject_type, subject_id, old_status, new_status, reason, changed_by, changed_at)
+			SELECT updated_at, tenant_id, ?, id, `+initial+`, status, ?, ?, updated_at FROM `+table+`
+			WHERE status <> `+initial+` AND NOT EXISTS (SELECT 1 FROM status_histories h WHERE h.subject_type = ? AND h.subject_id = `+table+`.id AND h.old_status <> '')`,
+			subjectType, ReasonBackfill, SystemActor, subjectType).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
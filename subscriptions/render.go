@@ -0,0 +1,89 @@
+package subscriptions
+
+import (
+	"banking-app/businessdays"
+	"banking-app/models"
+	"banking-app/reports"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Rendered is a report ready to store and deliver
+type Rendered struct {
+	Data        []byte
+	ContentType string
+	Rows        int
+}
+
+// Render builds a subscription's report as of at, with its stored parameters and in its format
+// Transaction volume covers the whole UTC days before at's day; delinquency is as of at
+func Render(db *gorm.DB, sub models.ReportSubscription, at time.Time) (Rendered, error) {
+	var header []string
+	var records [][]string
+	var rows interface{}
+	meta := map[string]interface{}{
+		"subscription": sub.Name,
+		"report":       sub.ReportType,
+		"generated_at": at.UTC(),
+		"parameters":   sub.Params,
+	}
+
+	switch sub.ReportType {
+	case ReportTransactionVolume:
+		to := businessdays.StartOfDay(at)
+		from := to.AddDate(0, 0, -sub.Params.Days)
+		volumes, err := reports.TransactionVolume(db, sub.Params.Currency, from, to)
+		if err != nil {
+			return Rendered{}, err
+		}
+		meta["from"], meta["to"] = from, to
+		header = []string{"date", "currency", "transaction_type", "count", "amount"}
+		for _, v := range volumes {
+			records = append(records, []string{v.Date, v.Currency, v.TransactionType,
+				strconv.FormatInt(v.Count, 10), money(v.Amount)})
+		}
+		rows = volumes
+	case ReportDelinquency:
+		delinquencies, err := reports.Delinquencies(db, at, sub.Params.MinDaysPastDue)
+		if err != nil {
+			return Rendered{}, err
+		}
+		header = []string{"loan_id", "loan_number", "customer_id", "customer_name", "monthly_payment", "installments_due",
+			"amount_due", "amount_paid", "amount_past_due", "oldest_unpaid_date", "days_past_due", "remaining_balance"}
+		for _, d := range delinquencies {
+			records = append(records, []string{strconv.FormatUint(uint64(d.LoanID), 10), d.LoanNumber,
+				strconv.FormatUint(uint64(d.CustomerID), 10), d.CustomerName, money(d.MonthlyPayment),
+				strconv.Itoa(d.InstallmentsDue), money(d.AmountDue), money(d.AmountPaid), money(d.AmountPastDue),
+				d.OldestUnpaidDate, strconv.Itoa(d.DaysPastDue), money(d.RemainingBalance)})
+		}
+		rows = delinquencies
+	default:
+		return Rendered{}, ErrReportType
+	}
+
+	var buf bytes.Buffer
+	if sub.Format == FormatJSON {
+		meta["rows"] = rows
+		if err := json.NewEncoder(&buf).Encode(meta); err != nil {
+			return Rendered{}, err
+		}
+		return Rendered{Data: buf.Bytes(), ContentType: "application/json", Rows: len(records)}, nil
+	}
+	out := csv.NewWriter(&buf)
+	out.Write(header)
+	out.WriteAll(records)
+	if err := out.Error(); err != nil {
+		return Rendered{}, err
+	}
+	return Rendered{Data: buf.Bytes(), ContentType: "text/csv", Rows: len(records)}, nil
+}
+
+// money formats an amount with two decimals
+func money(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
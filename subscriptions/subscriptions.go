@@ -0,0 +1,380 @@
+package subscriptions
+
+import (
+	"banking-app/clock"
+	"banking-app/jobs"
+	"banking-app/models"
+	"banking-app/notifications"
+	"banking-app/tenancy"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Reports that can be subscribed to
+const (
+	ReportTransactionVolume = "transaction_volume"
+	ReportDelinquency       = "delinquency"
+)
+
+// Delivery formats and channels
+const (
+	FormatCSV      = "csv"
+	FormatJSON     = "json"
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+)
+
+// Subscription statuses
+const (
+	StatusActive = "active"
+	StatusPaused = "paused"
+)
+
+// Run statuses; a delivering run waits on its notification, which retries delivery on its own
+const (
+	RunDelivering = "delivering"
+	RunDelivered  = "delivered"
+	RunFailed     = "failed"
+)
+
+// Run triggers
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// Related record types on queued notifications
+const (
+	ResourceRun          = "report_run"
+	ResourceSubscription = "report_subscription"
+)
+
+// MaxAttempts bounds how often a scheduled run that cannot be rendered or stored is tried before its owner is alerted
+const MaxAttempts = 3
+
+// RetryDelay is how long the first retry waits; each later one waits as many times longer as attempts made
+const RetryDelay = 15 * time.Minute
+
+// Validation errors - handlers map these to 400
+var (
+	ErrReportType = errors.New("report_type must be transaction_volume or delinquency")
+	ErrFormat     = errors.New("format must be csv or json")
+	ErrChannel    = errors.New("channel must be email or webhook")
+	ErrTarget     = errors.New("target must be an email address for email delivery, or an http(s) URL for webhooks")
+	ErrAlertEmail = errors.New("alert_email must be an email address, and is required for webhook delivery")
+	ErrSchedule   = errors.New("schedule must be a cron expression such as \"0 7 * * *\" or a shorthand such as @daily")
+	ErrParams     = errors.New("invalid report parameters: days must be 1-31, currency a supported code, min_days_past_due 0 or more")
+)
+
+// ErrOwnerInactive is returned when resuming a subscription whose owner is deactivated
+var ErrOwnerInactive = errors.New("the subscription's owner is deactivated")
+
+// Config holds report delivery settings
+type Config struct {
+	BaseURL string // Public URL of the API that emailed artifact links point at
+}
+
+// ConfigFromEnv reads PUBLIC_BASE_URL (default "http://localhost:8080"), as statement links do
+func ConfigFromEnv() Config {
+	base := strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	return Config{BaseURL: base}
+}
+
+// Storage is where rendered reports are kept; uploads.Storage satisfies it
+type Storage interface {
+	Put(key string, data []byte) error
+}
+
+// Validate checks a subscription and fills in defaults: one day of volume, one day past due, and failure
+// alerts to an email target
+func Validate(sub *models.ReportSubscription) error {
+	switch sub.ReportType {
+	case ReportTransactionVolume:
+		sub.Params.MinDaysPastDue = 0
+		sub.Params.Currency = strings.ToUpper(strings.TrimSpace(sub.Params.Currency))
+		if sub.Params.Days == 0 {
+			sub.Params.Days = 1
+		}
+		if sub.Params.Days < 1 || sub.Params.Days > 31 || (sub.Params.Currency != "" && !tenancy.SupportedCurrency(sub.Params.Currency)) {
+			return ErrParams
+		}
+	case ReportDelinquency:
+		sub.Params.Currency, sub.Params.Days = "", 0
+		if sub.Params.MinDaysPastDue < 0 {
+			return ErrParams
+		}
+	default:
+		return ErrReportType
+	}
+	if sub.Format != FormatCSV && sub.Format != FormatJSON {
+		return ErrFormat
+	}
+
+	sub.Target = strings.TrimSpace(sub.Target)
+	sub.AlertEmail = strings.TrimSpace(sub.AlertEmail)
+	switch sub.Channel {
+	case ChannelEmail:
+		if !validEmail(sub.Target) {
+			return ErrTarget
+		}
+		if sub.AlertEmail == "" {
+			sub.AlertEmail = sub.Target
+		}
+	case ChannelWebhook:
+		if u, err := url.Parse(sub.Target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrTarget
+		}
+	default:
+		return ErrChannel
+	}
+	if !validEmail(sub.AlertEmail) {
+		return ErrAlertEmail
+	}
+
+	if _, err := Next(*sub, clock.Now()); err != nil {
+		return ErrSchedule
+	}
+	return nil
+}
+
+// validEmail reports whether s is a bare email address
+func validEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
+
+// Next returns the subscription's first scheduled time after t
+func Next(sub models.ReportSubscription, t time.Time) (time.Time, error) {
+	schedule, err := jobs.Parse(sub.Schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := schedule.Next(t)
+	if next.IsZero() {
+		return next, ErrSchedule
+	}
+	return next, nil
+}
+
+// Run renders a subscription's report, stores it and queues its delivery, recording the run
+// The report covers only the subscription's tenant. A run that cannot be rendered or stored is recorded
+// as failed, and no delivery is queued
+func Run(db *gorm.DB, storage Storage, cfg Config, sub models.ReportSubscription, scheduledFor time.Time, trigger string, attempt int) (models.ReportRun, error) {
+	// The job has no tenant context, so the report is scoped to the subscription's tenant here
+	db = db.WithContext(tenancy.NewContext(db.Statement.Context, sub.TenantID))
+	run := models.ReportRun{TenantID: sub.TenantID, SubscriptionID: sub.ID, ScheduledFor: scheduledFor,
+		Attempt: attempt, Trigger: trigger, Status: RunDelivering}
+	if err := db.Create(&run).Error; err != nil {
+		return run, err
+	}
+
+	rendered, err := Render(db, sub, scheduledFor)
+	if err == nil {
+		run.ArtifactKey = fmt.Sprintf("reports/%d/%d/%d.%s", sub.TenantID, sub.ID, run.ID, sub.Format)
+		run.ContentType, run.Size, run.Rows = rendered.ContentType, int64(len(rendered.Data)), rendered.Rows
+		err = storage.Put(run.ArtifactKey, rendered.Data)
+	}
+	if err != nil {
+		run.Status, run.Error, run.ArtifactKey = RunFailed, truncate(err.Error(), 500), ""
+		return run, db.Save(&run).Error
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		notification := models.Notification{
+			Channel:      sub.Channel,
+			Recipient:    sub.Target,
+			ResourceType: ResourceRun,
+			ResourceID:   run.ID,
+			Subject:      fmt.Sprintf("%s: %s report for %s", sub.Name, strings.ReplaceAll(sub.ReportType, "_", " "), scheduledFor.UTC().Format("2006-01-02 15:04")),
+		}
+		if sub.Channel == ChannelWebhook {
+			notification.Body = string(rendered.Data)
+		} else {
+			notification.Body = fmt.Sprintf("The %s report has %d rows. Download it (%s) as an admin at %s",
+				sub.Name, rendered.Rows, sub.Format, ArtifactURL(cfg, run))
+		}
+		if err := notifications.Enqueue(tx, &notification); err != nil {
+			return err
+		}
+		run.NotificationID = &notification.ID
+		return tx.Save(&run).Error
+	})
+	return run, err
+}
+
+// ArtifactURL is where admins download a run's rendered report
+func ArtifactURL(cfg Config, run models.ReportRun) string {
+	return fmt.Sprintf("%s/api/v1/admin/report-subscriptions/%d/runs/%d/artifact", cfg.BaseURL, run.SubscriptionID, run.ID)
+}
+
+// Result counts what one Process pass did
+type Result struct {
+	Runs      int // Scheduled runs made, including retries
+	Failed    int // Runs that failed to render or deliver
+	Delivered int // Deliveries confirmed since the last pass
+	Paused    int // Subscriptions paused because their owner is deactivated
+}
+
+// Process is the report-subscriptions job. It settles runs whose delivery finished, pauses subscriptions
+// of deactivated owners, then makes every scheduled run and retry that is due
+func Process(db *gorm.DB, storage Storage, cfg Config, now time.Time) (Result, error) {
+	var result Result
+	delivered, failed, err := settle(db)
+	if err != nil {
+		return result, err
+	}
+	result.Delivered, result.Failed = delivered, failed
+
+	paused, err := PauseInactiveOwners(db)
+	if err != nil {
+		return result, err
+	}
+	result.Paused = int(paused)
+
+	var due []models.ReportSubscription
+	if err := db.Where("status = ? AND next_run_at <= ?", StatusActive, now).Order("next_run_at, id").Find(&due).Error; err != nil {
+		return result, err
+	}
+	for _, sub := range due {
+		scheduledFor := *sub.NextRunAt
+		if sub.DueAt != nil {
+			scheduledFor = *sub.DueAt
+		}
+		attempt := sub.Attempts + 1
+		run, err := Run(db, storage, cfg, sub, scheduledFor, TriggerSchedule, attempt)
+		if err != nil {
+			log.Printf("report subscriptions: run of %d failed: %v", sub.ID, err)
+			if run.ID == 0 {
+				continue
+			}
+			run.Status, run.Error = RunFailed, truncate(err.Error(), 500)
+			db.Save(&run)
+		}
+		result.Runs++
+
+		updates := map[string]interface{}{"last_run_at": now, "last_run_status": run.Status, "attempts": 0, "due_at": nil}
+		if run.Status == RunFailed {
+			result.Failed++
+			if attempt < MaxAttempts {
+				retryAt := now.Add(RetryDelay * time.Duration(attempt))
+				updates["attempts"], updates["due_at"], updates["next_run_at"] = attempt, scheduledFor, retryAt
+			} else {
+				alert(db, sub, &run, fmt.Sprintf("The report could not be produced after %d attempts: %s", attempt, run.Error))
+			}
+		}
+		if _, retrying := updates["next_run_at"]; !retrying {
+			next, err := Next(sub, now)
+			if err != nil {
+				return result, err
+			}
+			updates["next_run_at"] = next
+		}
+		if err := db.Model(&models.ReportSubscription{}).Where("id = ?", sub.ID).Updates(updates).Error; err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// settle records the outcome of runs whose delivery notification has been sent or has finally failed
+// A failed delivery has already been retried by the notification queue, so its owner is alerted at once
+func settle(db *gorm.DB) (delivered, failed int, err error) {
+	var runs []struct {
+		models.ReportRun
+		NotificationStatus string
+		NotificationError  string
+	}
+	err = db.Model(&models.ReportRun{}).
+		Select("report_runs.*, notifications.status AS notification_status, notifications.last_error AS notification_error").
+		Joins("JOIN notifications ON notifications.id = report_runs.notification_id").
+		Where("report_runs.status = ? AND notifications.status IN ?", RunDelivering, []string{"sent", "failed"}).
+		Scan(&runs).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, r := range runs {
+		run := r.ReportRun
+		if r.NotificationStatus == "sent" {
+			run.Status = RunDelivered
+			delivered++
+		} else {
+			run.Status, run.Error = RunFailed, truncate("delivery failed: "+r.NotificationError, 500)
+			failed++
+			var sub models.ReportSubscription
+			if err := db.First(&sub, run.SubscriptionID).Error; err == nil {
+				alert(db, sub, &run, fmt.Sprintf("The report was produced but could not be delivered to %s after %d attempts: %s",
+					sub.Target, notifications.MaxAttempts, r.NotificationError))
+			}
+		}
+		if err := db.Save(&run).Error; err != nil {
+			return delivered, failed, err
+		}
+		// Only the subscription's latest run sets its last status
+		db.Model(&models.ReportSubscription{}).
+			Where("id = ? AND NOT EXISTS (SELECT 1 FROM report_runs later WHERE later.subscription_id = ? AND later.id > ?)",
+				run.SubscriptionID, run.SubscriptionID, run.ID).
+			Update("last_run_status", run.Status)
+	}
+	return delivered, failed, nil
+}
+
+// alert emails the subscription owner's alert address about a failed run
+func alert(db *gorm.DB, sub models.ReportSubscription, run *models.ReportRun, reason string) {
+	db = db.WithContext(tenancy.NewContext(db.Statement.Context, sub.TenantID))
+	if sub.AlertEmail == "" {
+		log.Printf("report subscriptions: %d failed with no alert address: %s", sub.ID, reason)
+		return
+	}
+	notification := models.Notification{
+		Channel:      ChannelEmail,
+		Recipient:    sub.AlertEmail,
+		ResourceType: ResourceSubscription,
+		ResourceID:   sub.ID,
+		Subject:      fmt.Sprintf("Report subscription %q failed", sub.Name),
+		Body: fmt.Sprintf("Subscription %d (%s, owned by %s) failed its run for %s. %s",
+			sub.ID, sub.ReportType, sub.Owner, run.ScheduledFor.UTC().Format("2006-01-02 15:04"), reason),
+	}
+	if err := notifications.Enqueue(db, &notification); err != nil {
+		log.Printf("report subscriptions: failed to alert owner of %d: %v", sub.ID, err)
+		return
+	}
+	run.AlertNotificationID = &notification.ID
+	if err := db.Model(run).Update("alert_notification_id", notification.ID).Error; err != nil {
+		log.Printf("report subscriptions: failed to record alert for run %d: %v", run.ID, err)
+	}
+}
+
+// PauseInactiveOwners pauses the active subscriptions of users who are disabled or deleted
+func PauseInactiveOwners(db *gorm.DB) (int64, error) {
+	active := db.Model(&models.User{}).Select("id").Where("status <> ?", "disabled")
+	result := db.Model(&models.ReportSubscription{}).
+		Where("status = ? AND owner_id NOT IN (?)", StatusActive, active).
+		Updates(map[string]interface{}{"status": StatusPaused, "paused_reason": "Owner deactivated"})
+	return result.RowsAffected, result.Error
+}
+
+// OwnerActive reports whether a subscription's owner can still receive its reports
+func OwnerActive(db *gorm.DB, sub models.ReportSubscription) (bool, error) {
+	var count int64
+	err := db.Model(&models.User{}).Where("id = ? AND status <> ?", sub.OwnerID, "disabled").Count(&count).Error
+	return count > 0, err
+}
+
+// truncate shortens s to at most n bytes for a bounded column
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
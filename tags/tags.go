@@ -0,0 +1,193 @@
+package tags
+
+import (
+	"banking-app/models"
+	"errors"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// Record types tags attach to
+const (
+	SubjectCustomer = "customer"
+	SubjectAccount  = "account"
+)
+
+// DefaultMaxPerEntity is how many tags one customer or account may carry unless configured otherwise
+const DefaultMaxPerEntity = 10
+
+// MaxSlugLength bounds a tag's length, matching the slug column
+const MaxSlugLength = 40
+
+// slugPattern is lowercase letters and digits, in words joined by single hyphens
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Errors returned when tagging; handlers map these to client responses
+var (
+	ErrInvalidSlug = errors.New("tags must be lowercase letters and digits, optionally joined by single hyphens, at most 40 characters")
+	ErrUnknownTag  = errors.New("tag is not in the vocabulary; an admin must add it first")
+	ErrTooMany     = errors.New("record already has the maximum number of tags")
+	ErrNotTagged   = errors.New("record does not have this tag")
+	ErrTagInUse    = errors.New("tag is still applied to customers or accounts")
+)
+
+// Config holds tagging settings
+type Config struct {
+	MaxPerEntity int
+	FreeForm     bool // Any valid slug may be applied, joining the vocabulary on first use; otherwise admins manage the vocabulary
+}
+
+// ConfigFromEnv reads TAGS_MAX_PER_ENTITY (default 10) and TAGS_FREE_FORM (default false)
+func ConfigFromEnv() Config {
+	cfg := Config{MaxPerEntity: DefaultMaxPerEntity}
+	if raw := os.Getenv("TAGS_MAX_PER_ENTITY"); raw != "" {
+		if max, err := strconv.Atoi(raw); err == nil && max > 0 {
+			cfg.MaxPerEntity = max
+		} else {
+			log.Printf("tags: ignoring invalid TAGS_MAX_PER_ENTITY %q", raw)
+		}
+	}
+	if raw := os.Getenv("TAGS_FREE_FORM"); raw != "" {
+		if freeForm, err := strconv.ParseBool(raw); err == nil {
+			cfg.FreeForm = freeForm
+		} else {
+			log.Printf("tags: ignoring invalid TAGS_FREE_FORM %q", raw)
+		}
+	}
+	return cfg
+}
+
+// ValidSlug reports whether a tag is well formed; tags are never normalized, so VIP is rejected rather than stored as vip
+func ValidSlug(slug string) bool {
+	return len(slug) <= MaxSlugLength && slugPattern.MatchString(slug)
+}
+
+// Add applies a tag to a customer or account. Applying a tag the record already has changes nothing
+// and reports false. In free-form mode an unknown tag is added to the vocabulary first
+func Add(db *gorm.DB, cfg Config, subjectType string, subjectID uint, slug, by string) (bool, error) {
+	if !ValidSlug(slug) {
+		return false, ErrInvalidSlug
+	}
+	added := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var tag models.Tag
+		err := tx.Where("slug = ?", slug).First(&tag).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if !cfg.FreeForm {
+				return ErrUnknownTag
+			}
+			tag = models.Tag{Slug: slug, CreatedBy: by}
+			err = tx.Create(&tag).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		var tagged []uint
+		if err := tx.Model(&models.EntityTag{}).Where("subject_type = ? AND subject_id = ?", subjectType, subjectID).
+			Pluck("tag_id", &tagged).Error; err != nil {
+			return err
+		}
+		for _, id := range tagged {
+			if id == tag.ID {
+				return nil
+			}
+		}
+		if len(tagged) >= cfg.MaxPerEntity {
+			return ErrTooMany
+		}
+		added = true
+		return tx.Create(&models.EntityTag{TagID: tag.ID, SubjectType: subjectType, SubjectID: subjectID, AddedBy: by}).Error
+	})
+	return added, err
+}
+
+// Remove takes a tag off a customer or account
+func Remove(db *gorm.DB, subjectType string, subjectID uint, slug string) error {
+	result := db.Where("subject_type = ? AND subject_id = ? AND tag_id IN (?)", subjectType, subjectID,
+		db.Model(&models.Tag{}).Select("id").Where("slug = ?", slug)).
+		Delete(&models.EntityTag{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotTagged
+	}
+	return nil
+}
+
+// For returns the tags on the given records, sorted, keyed by record ID
+// Used by list and detail responses so a whole page is loaded in one query
+func For(db *gorm.DB, subjectType string, ids []uint) (map[uint][]string, error) {
+	byID := make(map[uint][]string)
+	if len(ids) == 0 {
+		return byID, nil
+	}
+	var rows []struct {
+		SubjectID uint
+		Slug      string
+	}
+	err := db.Model(&models.EntityTag{}).Select("entity_tags.subject_id, tags.slug").
+		Joins("JOIN tags ON tags.id = entity_tags.tag_id").
+		Where("entity_tags.subject_type = ? AND entity_tags.subject_id IN ?", subjectType, ids).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		byID[row.SubjectID] = append(byID[row.SubjectID], row.Slug)
+	}
+	for _, slugs := range byID {
+		sort.Strings(slugs)
+	}
+	return byID, nil
+}
+
+// Tagged is a subquery of the IDs of the records of a type carrying a tag, for use as `id IN (?)`
+func Tagged(db *gorm.DB, subjectType, slug string) *gorm.DB {
+	return db.Model(&models.EntityTag{}).Select("entity_tags.subject_id").
+		Joins("JOIN tags ON tags.id = entity_tags.tag_id").
+		Where("entity_tags.subject_type = ? AND tags.slug = ?", subjectType, slug)
+}
+
+// Usage is a vocabulary entry with how many records carry it
+type Usage struct {
+	models.Tag
+	Customers int64 `json:"customers"`
+	Accounts  int64 `json:"accounts"`
+}
+
+// Vocabulary lists every tag with its usage, alphabetically
+func Vocabulary(db *gorm.DB) ([]Usage, error) {
+	var usage []Usage
+	err := db.Model(&models.Tag{}).
+		Select("tags.*, "+
+			"(SELECT count(*) FROM entity_tags WHERE entity_tags.tag_id = tags.id AND entity_tags.subject_type = ?) AS customers, "+
+			"(SELECT count(*) FROM entity_tags WHERE entity_tags.tag_id = tags.id AND entity_tags.subject_type = ?) AS accounts",
+			SubjectCustomer, SubjectAccount).
+		Order("tags.slug").Scan(&usage).Error
+	return usage, err
+}
+
+// Delete removes a tag from the vocabulary; a tag still applied anywhere must be removed from those records first
+func Delete(db *gorm.DB, slug string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var tag models.Tag
+		if err := tx.Where("slug = ?", slug).First(&tag).Error; err != nil {
+			return err
+		}
+		var used int64
+		if err := tx.Model(&models.EntityTag{}).Where("tag_id = ?", tag.ID).Count(&used).Error; err != nil {
+			return err
+		}
+		if used > 0 {
+			return ErrTagInUse
+		}
+		return tx.Delete(&tag).Error
+	})
+}
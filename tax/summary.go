@@ -0,0 +1,178 @@
+package tax
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/ledger"
+	"banking-app/models"
+	"banking-app/money"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AccountTotals is the interest earned and fees charged on one account
+type AccountTotals struct {
+	AccountID      uint    `json:"account_id"`
+	AccountNumber  string  `json:"account_number"`
+	AccountType    string  `json:"account_type"`
+	Currency       string  `json:"currency"`
+	InterestEarned float64 `json:"interest_earned"`
+	FeesCharged    float64 `json:"fees_charged"`
+}
+
+// LoanTotals is the interest paid on one loan
+type LoanTotals struct {
+	LoanID       uint    `json:"loan_id"`
+	LoanNumber   string  `json:"loan_number"`
+	InterestPaid float64 `json:"interest_paid"`
+}
+
+// Summary is a customer's year-end interest and fee totals
+type Summary struct {
+	CustomerID          uint            `json:"customer_id"`
+	Year                int             `json:"year"`
+	TotalInterestEarned float64         `json:"total_interest_earned"`
+	TotalInterestPaid   float64         `json:"total_interest_paid"`
+	TotalFeesCharged    float64         `json:"total_fees_charged"`
+	Accounts            []AccountTotals `json:"accounts"`
+	Loans               []LoanTotals    `json:"loans"`
+	GeneratedAt         time.Time       `json:"generated_at"`
+}
+
+// notReversedSQL excludes postings that were later reversed - only what was actually charged counts
+const notReversedSQL = "NOT EXISTS (SELECT 1 FROM transactions r WHERE r.reversal_of_id = transactions.id AND r.deleted_at IS NULL)"
+
+// Build aggregates a customer's interest and fee postings and loan interest for a calendar year
+// Postings count by effective date. Loan interest comes from the payment allocation records, so it
+// matches what was charged rather than a recomputation
+func Build(db *gorm.DB, customerID uint, year int) (Summary, error) {
+	start := businessdays.Month(year, time.January)
+	end := start.AddDate(1, 0, 0)
+	sum := Summary{
+		CustomerID:  customerID,
+		Year:        year,
+		Accounts:    []AccountTotals{},
+		Loans:       []LoanTotals{},
+		GeneratedAt: clock.Now().UTC(),
+	}
+
+	// Closed and deleted accounts still carry the year's charges
+	err := db.Model(&models.Transaction{}).
+		Select("accounts.id AS account_id, accounts.account_number, accounts.account_type, accounts.currency, "+
+			"COALESCE(SUM(CASE WHEN transactions.transaction_type = ? THEN transactions.amount ELSE 0 END), 0) AS interest_earned, "+
+			"COALESCE(SUM(CASE WHEN transactions.transaction_type = ? THEN transactions.amount ELSE 0 END), 0) AS fees_charged",
+			ledger.TypeInterest, ledger.TypeFee).
+		Joins("JOIN accounts ON accounts.id = transactions.account_id").
+		Where("accounts.customer_id = ? AND transactions.transaction_type IN ?", customerID, []string{ledger.TypeInterest, ledger.TypeFee}).
+		Where("transactions.effective_date >= ? AND transactions.effective_date < ?", start, end).
+		Where(notReversedSQL).
+		Group("accounts.id, accounts.account_number, accounts.account_type, accounts.currency").
+		Order("accounts.id").Scan(&sum.Accounts).Error
+	if err != nil {
+		return sum, err
+	}
+
+	err = db.Model(&models.LoanPayment{}).
+		Select("loans.id AS loan_id, loans.loan_number, COALESCE(SUM(loan_payments.interest_portion), 0) AS interest_paid").
+		Joins("JOIN loans ON loans.id = loan_payments.loan_id").
+		Where("loans.customer_id = ? AND loan_payments.paid_at >= ? AND loan_payments.paid_at < ?", customerID, start, end).
+		Group("loans.id, loans.loan_number").
+		Order("loans.id").Scan(&sum.Loans).Error
+	if err != nil {
+		return sum, err
+	}
+
+	for i := range sum.Accounts {
+		sum.Accounts[i].InterestEarned = money.Cents(sum.Accounts[i].InterestEarned)
+		sum.Accounts[i].FeesCharged = money.Cents(sum.Accounts[i].FeesCharged)
+		sum.TotalInterestEarned += sum.Accounts[i].InterestEarned
+		sum.TotalFeesCharged += sum.Accounts[i].FeesCharged
+	}
+	for i := range sum.Loans {
+		sum.Loans[i].InterestPaid = money.Cents(sum.Loans[i].InterestPaid)
+		sum.TotalInterestPaid += sum.Loans[i].InterestPaid
+	}
+	sum.TotalInterestEarned = money.Cents(sum.TotalInterestEarned)
+	sum.TotalFeesCharged = money.Cents(sum.TotalFeesCharged)
+	sum.TotalInterestPaid = money.Cents(sum.TotalInterestPaid)
+	return sum, nil
+}
+
+// Load returns the stored summary for a customer and year, if one was generated
+func Load(db *gorm.DB, customerID uint, year int) (Summary, bool, error) {
+	var sum Summary
+	var stored models.TaxSummary
+	err := db.Where("customer_id = ? AND year = ?", customerID, year).First(&stored).Error
+	if err == gorm.ErrRecordNotFound {
+		return sum, false, nil
+	}
+	if err != nil {
+		return sum, false, err
+	}
+	err = json.Unmarshal([]byte(stored.Data), &sum)
+	return sum, err == nil, err
+}
+
+// Store saves a summary, replacing any earlier one for the same customer and year
+func Store(db *gorm.DB, sum Summary) error {
+	data, err := json.Marshal(sum)
+	if err != nil {
+		return err
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "customer_id"}, {Name: "year"}},
+		DoUpdates: clause.AssignmentColumns([]string{"data", "generated_at", "updated_at"}),
+	}).Create(&models.TaxSummary{
+		CustomerID:  sum.CustomerID,
+		Year:        sum.Year,
+		Data:        string(data),
+		GeneratedAt: sum.GeneratedAt,
+	}).Error
+}
+
+// GenerateAll builds and stores the summary of every customer for a year
+// Customers are read in batches; rerunning regenerates every summary
+func GenerateAll(db *gorm.DB, year int) (int, error) {
+	generated := 0
+	var batch []models.Customer
+	result := db.Select("id").FindInBatches(&batch, 200, func(tx *gorm.DB, _ int) error {
+		for _, customer := range batch {
+			sum, err := Build(db, customer.ID, year)
+			if err != nil {
+				return err
+			}
+			if err := Store(db, sum); err != nil {
+				return err
+			}
+			generated++
+		}
+		return nil
+	})
+	return generated, result.Error
+}
+
+// WriteCSV renders a summary with one row per account and loan and a totals row
+func WriteCSV(w io.Writer, sum Summary) error {
+	money := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"year", "source", "id", "number", "interest_earned", "interest_paid", "fees_charged"})
+	for _, a := range sum.Accounts {
+		out.Write([]string{strconv.Itoa(sum.Year), "account", strconv.FormatUint(uint64(a.AccountID), 10), a.AccountNumber,
+			money(a.InterestEarned), "", money(a.FeesCharged)})
+	}
+	for _, l := range sum.Loans {
+		out.Write([]string{strconv.Itoa(sum.Year), "loan", strconv.FormatUint(uint64(l.LoanID), 10), l.LoanNumber,
+			"", money(l.InterestPaid), ""})
+	}
+	out.Write([]string{strconv.Itoa(sum.Year), "total", "", "",
+		money(sum.TotalInterestEarned), money(sum.TotalInterestPaid), money(sum.TotalFeesCharged)})
+	out.Flush()
+	return out.Error()
+}
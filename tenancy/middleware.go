@@ -0,0 +1,95 @@
+package tenancy
+
+import (
+	"banking-app/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Header selects the tenant by code on requests without a tenant-bound token
+const Header = "X-Tenant"
+
+// Middleware resolves the request tenant and scopes the request context to it
+// A token's tenant always wins; only admins of the default tenant may switch tenants with the header.
+// Requests with neither a token tenant nor the header use the default tenant.
+func Middleware(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var tokenTenant uint
+		if v, ok := c.Get("tenant_id"); ok {
+			tokenTenant, _ = v.(uint)
+		}
+		// Tokens issued before multi-tenancy carry no tenant and belong to the default tenant
+		if _, authenticated := c.Get("user_id"); authenticated && tokenTenant == 0 {
+			tokenTenant = DefaultTenantID
+		}
+		role, _ := c.Get("user_role")
+		platformAdmin := tokenTenant == DefaultTenantID && role == "admin"
+
+		var tenant models.Tenant
+		var err error
+		code := c.GetHeader(Header)
+		switch {
+		case code != "":
+			err = db.Where("code = ?", code).First(&tenant).Error
+			if err == nil && tokenTenant != 0 && tenant.ID != tokenTenant && !platformAdmin {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Token is not valid for this tenant"})
+				return
+			}
+		case tokenTenant != 0:
+			err = db.First(&tenant, tokenTenant).Error
+		default:
+			err = db.First(&tenant, DefaultTenantID).Error
+		}
+		if err == gorm.ErrRecordNotFound {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Unknown tenant"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve tenant"})
+			return
+		}
+		if tenant.Status != "active" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Tenant is suspended"})
+			return
+		}
+
+		c.Set("tenant", tenant)
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), tenant.ID))
+		c.Next()
+	}
+}
+
+// DB returns the database handle scoped to the request tenant
+// Handlers must query through it so tenant-owned tables are filtered automatically
+func DB(c *gin.Context, db *gorm.DB) *gorm.DB {
+	return db.WithContext(c.Request.Context())
+}
+
+// Current returns the tenant resolved for the request
+func Current(c *gin.Context) models.Tenant {
+	if v, ok := c.Get("tenant"); ok {
+		if tenant, ok := v.(models.Tenant); ok {
+			return tenant
+		}
+	}
+	return models.Tenant{ID: DefaultTenantID, Code: DefaultCode}
+}
+
+// CurrentSettings returns the effective settings of the request tenant
+func CurrentSettings(c *gin.Context) Settings {
+	return SettingsFor(Current(c))
+}
+
+// PlatformOnly restricts deployment-wide administration to requests in the default tenant
+// Tenant admins manage their own data but not shared configuration such as webhooks or flags
+func PlatformOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if Current(c).ID != DefaultTenantID {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Platform administration is only available to the default tenant"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,157 @@
+package tenancy
+
+import (
+	"banking-app/models"
+	"context"
+	"encoding/json"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultTenantID owns all data created before multi-tenancy and is the platform operator's tenant
+const DefaultTenantID uint = 1
+
+// DefaultCode is the code of the default tenant
+const DefaultCode = "default"
+
+// Settings are per-tenant overrides of global behavior; zero values fall back to Global
+type Settings struct {
+	DefaultCurrency  string             `json:"default_currency,omitempty"`  // Currency of newly opened accounts
+	TransactionLimit float64            `json:"transaction_limit,omitempty"` // Largest single posting; 0 = unlimited
+	FeeSchedule      map[string]float64 `json:"fee_schedule,omitempty"`      // Fee per transaction type (applied once fee charging exists)
+	Cutoffs          map[string]string  `json:"cutoffs,omitempty"`           // Daily HH:MM bank-time cutoff per transaction type; later submissions are valued the next business day
+}
+
+// Global holds the settings used when a tenant does not override them
+var Global = Settings{DefaultCurrency: "USD"}
+
+// SupportedCurrencies are the ISO codes accounts can be opened in
+var SupportedCurrencies = []string{"AUD", "CAD", "EUR", "GBP", "INR", "JPY", "USD"}
+
+// SupportedCurrency reports whether accounts can be opened in a currency code
+func SupportedCurrency(code string) bool {
+	for _, supported := range SupportedCurrencies {
+		if code == supported {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// NewContext returns a context whose database queries are scoped to a tenant
+func NewContext(ctx context.Context, tenantID uint) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenantID)
+}
+
+// FromContext returns the tenant a context is scoped to
+func FromContext(ctx context.Context) (uint, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	id, ok := ctx.Value(contextKey{}).(uint)
+	return id, ok && id != 0
+}
+
+// EnsureDefault creates the default tenant that pre-existing rows belong to
+func EnsureDefault(db *gorm.DB) error {
+	tenant := models.Tenant{ID: DefaultTenantID, Code: DefaultCode, Name: "Default", Status: "active"}
+	return db.Where(models.Tenant{ID: DefaultTenantID}).FirstOrCreate(&tenant).Error
+}
+
+// SettingsFor merges a tenant's overrides over the global settings
+func SettingsFor(tenant models.Tenant) Settings {
+	settings := Global
+	if tenant.Settings == "" {
+		return settings
+	}
+
+	var overrides Settings
+	if err := json.Unmarshal([]byte(tenant.Settings), &overrides); err != nil {
+		return settings
+	}
+	if overrides.DefaultCurrency != "" {
+		settings.DefaultCurrency = overrides.DefaultCurrency
+	}
+	if overrides.TransactionLimit > 0 {
+		settings.TransactionLimit = overrides.TransactionLimit
+	}
+	if len(overrides.FeeSchedule) > 0 {
+		settings.FeeSchedule = overrides.FeeSchedule
+	}
+	if len(overrides.Cutoffs) > 0 {
+		settings.Cutoffs = overrides.Cutoffs
+	}
+	return settings
+}
+
+// RegisterCallbacks scopes every query on a tenant-owned model to the tenant in the statement context
+// Tenant-owned models are those with a TenantID field; statements without a tenant context
+// (background jobs, the CLI) are left unscoped
+func RegisterCallbacks(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Query().Before("gorm:query").Register("tenancy:query", scope); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").Register("tenancy:row", scope); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("tenancy:update", scope); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("gorm:delete").Register("tenancy:delete", scope); err != nil {
+		return err
+	}
+	return callbacks.Create().Before("gorm:create").Register("tenancy:create", assign)
+}
+
+// scope adds tenant_id = ? to statements on tenant-owned tables
+// The statement's own conditions are grouped first: gorm joins an Or condition to the rest without parentheses, so
+// "a OR b AND tenant_id = ?" would let b match rows of every tenant
+func scope(tx *gorm.DB) {
+	tenantID, ok := FromContext(tx.Statement.Context)
+	if !ok || tx.Statement.Schema == nil || tx.Statement.Schema.LookUpField("TenantID") == nil {
+		return
+	}
+	where := clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: tx.Statement.Table, Name: "tenant_id"}, Value: tenantID},
+	}}
+	if c, ok := tx.Statement.Clauses[where.Name()]; ok {
+		if own, ok := c.Expression.(clause.Where); ok && len(own.Exprs) > 0 {
+			where.Exprs = append(where.Exprs, clause.AndConditions{Exprs: own.Exprs})
+			c.Expression = where
+			tx.Statement.Clauses[where.Name()] = c
+			return
+		}
+	}
+	tx.Statement.AddClause(where)
+}
+
+// assign stamps new tenant-owned rows with the context tenant, overriding any client-supplied value
+func assign(tx *gorm.DB) {
+	tenantID, ok := FromContext(tx.Statement.Context)
+	if !ok || tx.Statement.Schema == nil {
+		return
+	}
+	field := tx.Statement.Schema.LookUpField("TenantID")
+	if field == nil {
+		return
+	}
+
+	rv := tx.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if err := field.Set(tx.Statement.Context, reflect.Indirect(rv.Index(i)), tenantID); err != nil {
+				tx.AddError(err)
+			}
+		}
+	case reflect.Struct:
+		if err := field.Set(tx.Statement.Context, rv, tenantID); err != nil {
+			tx.AddError(err)
+		}
+	}
+}
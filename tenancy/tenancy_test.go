@@ -0,0 +1,194 @@
+package tenancy
+
+import (
+	"banking-app/models"
+	"context"
+	"fmt"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB opens an in-memory database with the tenancy callbacks, holding a customer with an account and a
+// transaction in each of tenants 1 and 2
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1) // Every connection to :memory: is a database of its own
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.Customer{}, &models.Account{}, &models.Transaction{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterCallbacks(db); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tenantID := range []uint{1, 2} {
+		tx := db.WithContext(NewContext(context.Background(), tenantID))
+		customer := models.Customer{FirstName: "Tenant", LastName: "Customer", Email: "customer@example.test", DateOfBirth: "1980-01-01"}
+		if err := tx.Create(&customer).Error; err != nil {
+			t.Fatal(err)
+		}
+		account := models.Account{CustomerID: customer.ID, AccountNumber: fmt.Sprintf("ACC-%d", tenantID), AccountType: "checking", Balance: 100, Currency: "USD", Status: "active"}
+		if err := tx.Create(&account).Error; err != nil {
+			t.Fatal(err)
+		}
+		transaction := models.Transaction{TransactionID: fmt.Sprintf("TXN-%d", tenantID), AccountID: account.ID, TransactionType: "deposit", Amount: 100}
+		if err := tx.Create(&transaction).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// scoped returns db in tenant 1's context
+func scoped(db *gorm.DB) *gorm.DB {
+	return db.WithContext(NewContext(context.Background(), 1))
+}
+
+func TestInsertsAreStamped(t *testing.T) {
+	db := testDB(t)
+	customer := models.Customer{TenantID: 2, FirstName: "Spoofed", LastName: "Tenant", Email: "spoof@example.test", DateOfBirth: "1980-01-01"}
+	if err := scoped(db).Create(&customer).Error; err != nil {
+		t.Fatal(err)
+	}
+	var tenantID uint
+	db.Raw("SELECT tenant_id FROM customers WHERE id = ?", customer.ID).Scan(&tenantID)
+	if tenantID != 1 {
+		t.Errorf("customer created in tenant 1 with tenant_id 2 stored tenant_id %d", tenantID)
+	}
+}
+
+func TestQueriesAreScoped(t *testing.T) {
+	db := testDB(t)
+	var other models.Customer
+	if err := db.Where("tenant_id = ?", 2).First(&other).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		want, all int64 // Rows tenant 1 sees, and rows seen without a tenant context
+		query     func(tx *gorm.DB) (int64, error)
+	}{
+		{"Find customers", 1, 2, func(tx *gorm.DB) (int64, error) {
+			var rows []models.Customer
+			err := tx.Find(&rows).Error
+			return int64(len(rows)), err
+		}},
+		{"Find accounts", 1, 2, func(tx *gorm.DB) (int64, error) {
+			var rows []models.Account
+			err := tx.Find(&rows).Error
+			return int64(len(rows)), err
+		}},
+		{"Find transactions", 1, 2, func(tx *gorm.DB) (int64, error) {
+			var rows []models.Transaction
+			err := tx.Find(&rows).Error
+			return int64(len(rows)), err
+		}},
+		{"Find with a condition", 1, 2, func(tx *gorm.DB) (int64, error) {
+			var rows []models.Account
+			err := tx.Where("balance > ?", 0).Or("status = ?", "active").Find(&rows).Error
+			return int64(len(rows)), err
+		}},
+		{"Find by primary key", 0, 1, func(tx *gorm.DB) (int64, error) {
+			var rows []models.Customer
+			err := tx.Find(&rows, other.ID).Error
+			return int64(len(rows)), err
+		}},
+		{"Count", 1, 2, func(tx *gorm.DB) (n int64, err error) {
+			err = tx.Model(&models.Transaction{}).Count(&n).Error
+			return n, err
+		}},
+		{"Pluck", 1, 2, func(tx *gorm.DB) (int64, error) {
+			var ids []uint
+			err := tx.Model(&models.Customer{}).Pluck("id", &ids).Error
+			return int64(len(ids)), err
+		}},
+		{"Scan into a summary", 1, 2, func(tx *gorm.DB) (int64, error) {
+			var rows []struct{ ID uint }
+			err := tx.Model(&models.Account{}).Select("accounts.id").Scan(&rows).Error
+			return int64(len(rows)), err
+		}},
+		{"Joins", 1, 2, func(tx *gorm.DB) (int64, error) {
+			var rows []models.Transaction
+			err := tx.Joins("JOIN accounts ON accounts.id = transactions.account_id").Find(&rows).Error
+			return int64(len(rows)), err
+		}},
+		{"Preload", 1, 2, func(tx *gorm.DB) (int64, error) {
+			var rows []models.Customer
+			err := tx.Preload("Accounts").Find(&rows).Error
+			n := int64(0)
+			for _, row := range rows {
+				n += int64(len(row.Accounts))
+			}
+			return n, err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.query(scoped(db))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("tenant 1 sees %d rows, want %d", got, tt.want)
+			}
+			all, err := tt.query(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if all != tt.all {
+				t.Errorf("without a tenant context %d rows, want %d", all, tt.all)
+			}
+		})
+	}
+
+	if err := scoped(db).First(&models.Customer{}, other.ID).Error; err != gorm.ErrRecordNotFound {
+		t.Errorf("First of another tenant's customer: %v, want record not found", err)
+	}
+}
+
+func TestWritesAreScoped(t *testing.T) {
+	db := testDB(t)
+	var other models.Account
+	if err := db.Where("tenant_id = ?", 2).First(&other).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if n := scoped(db).Model(&models.Account{}).Where("id = ?", other.ID).Update("status", "frozen").RowsAffected; n != 0 {
+		t.Errorf("Update of another tenant's account affected %d rows", n)
+	}
+	if n := scoped(db).Model(&models.Account{}).Where("id = ?", 0).Or("id = ?", other.ID).Update("status", "frozen").RowsAffected; n != 0 {
+		t.Errorf("Update of another tenant's account through an Or condition affected %d rows", n)
+	}
+	if n := scoped(db).Model(&models.Account{}).Where("1 = 1").Updates(map[string]interface{}{"status": "dormant"}).RowsAffected; n != 1 {
+		t.Errorf("Updates of every account affected %d rows, want tenant 1's only", n)
+	}
+	if n := scoped(db).Where("id = ?", 0).Or("tenant_id = ?", 2).Delete(&models.Customer{}).RowsAffected; n != 0 {
+		t.Errorf("Delete of another tenant's customer through an Or condition affected %d rows", n)
+	}
+	if n := scoped(db).Delete(&models.Customer{}, "id > ?", 0).RowsAffected; n != 1 {
+		t.Errorf("Delete of every customer affected %d rows, want tenant 1's only", n)
+	}
+
+	var reloaded models.Account
+	db.First(&reloaded, other.ID)
+	if reloaded.Status != "active" || reloaded.Balance != 100 {
+		t.Errorf("another tenant's account changed: status %q, balance %v", reloaded.Status, reloaded.Balance)
+	}
+	var customers int64
+	db.Model(&models.Customer{}).Where("tenant_id = ?", 2).Count(&customers)
+	if customers != 1 {
+		t.Errorf("another tenant's customer was deleted")
+	}
+}
@@ -0,0 +1,170 @@
+#!/bin/bash
+
+# Data Access Report Tests
+# Has staff, the customer, an admin signed in as the customer and a third-party client read and change a customer's
+# records, and checks that the customer's access report counts each actor's reads and writes by endpoint category
+# with first and last times, shows the impersonating admin by name, leaves out other customers' records, narrows to
+# one account, lists the requests in detail a page at a time and is limited to staff with the permission and the
+# customer. Then seeds 100,000 audit entries and checks the report is still served from indexes in bounded time.
+# Users are created with bankctl and audit entries seeded in the server's database, so DB_PATH must be the database
+# the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-access-report.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl REPORT_SECONDS=2 ./test-access-report.sh
+
+source "$(dirname "$0")/test-lib.sh"
+REPORT_SECONDS="${REPORT_SECONDS:-2}"
+PASSWORD="access-test-$RUN_ID"
+REDIRECT="https://partner.example/callback"
+FORM=1
+CHECK_HELPERS="a = lambda actor, access, category: next((x for x in b['accesses'] if x['actor'] == actor and x['access'] == access and x['category'] == category), None)"
+
+echo " Data Access Report Tests"
+echo "=========================="
+
+# query_param URL NAME - prints one query parameter of a URL
+query_param() {
+    python3 -c "import sys, urllib.parse; print(urllib.parse.parse_qs(urllib.parse.urlparse(sys.argv[1]).query).get(sys.argv[2], [''])[0])" "$1" "$2"
+}
+
+ADMIN_USER="access-admin-$RUN_ID"
+TELLER_USER="access-teller-$RUN_ID"
+CUSTOMER_USER="access-customer-$RUN_ID"
+SEEDED_USER="access-seeded-$RUN_ID"
+TODAY=$(date -u +%Y-%m-%d)
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "$ADMIN_USER")")
+TELLER=(-H "Authorization: Bearer $(staff "$TELLER_USER" teller)")
+
+request POST "$V1/customers" "{\"first_name\": \"Access\", \"last_name\": \"Holder\", \"email\": \"access-holder-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${ADMIN[@]}"
+check "staff create the customer" "s == 201"
+HOLDER=$(field "['customer']['id']")
+request POST "$V1/customers" "{\"first_name\": \"Access\", \"last_name\": \"Other\", \"email\": \"access-other-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${ADMIN[@]}"
+OTHER=$(field "['customer']['id']")
+CUSTOMER=(-H "Authorization: Bearer $(customer_user "$CUSTOMER_USER" "$HOLDER")")
+
+echo
+echo "Accesses"
+request POST "$V1/accounts" "{\"customer_id\": $HOLDER, \"account_type\": \"checking\"}" "${ADMIN[@]}"
+CHECKING=$(field "['account']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $HOLDER, \"account_type\": \"savings\"}" "${ADMIN[@]}"
+SAVINGS=$(field "['account']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $OTHER, \"account_type\": \"checking\"}" "${ADMIN[@]}"
+OTHER_ACCOUNT=$(field "['account']['id']")
+request POST "$V1/transactions" "{\"account_id\": $CHECKING, \"transaction_type\": \"deposit\", \"amount\": 100}" "${ADMIN[@]}"
+check "staff post a deposit named in the body" "s == 201"
+DEPOSIT=$(field "['transaction']['id']")
+
+request GET "$V1/customers/$HOLDER" "" "${TELLER[@]}"
+request GET "$V1/accounts/$CHECKING/balance" "" "${TELLER[@]}"
+request GET "$V1/accounts/$CHECKING/balance" "" "${TELLER[@]}"
+request GET "$V1/transactions/$DEPOSIT/receipt" "" "${TELLER[@]}"
+request GET "$V1/customers/$OTHER" "" "${TELLER[@]}"
+request GET "$V1/accounts/$OTHER_ACCOUNT/balance" "" "${TELLER[@]}"
+request GET "$V1/accounts/$SAVINGS" "" "${CUSTOMER[@]}"
+check "the customer reads their own account" "s == 200"
+
+request POST "$V1/admin/impersonate/$HOLDER" "{\"reason\": \"Support call $RUN_ID\"}" "${ADMIN[@]}"
+check "an admin signs in as the customer" "s == 201"
+IMPERSONATION=(-H "Authorization: Bearer $(field "['token']")")
+request GET "$V1/accounts/$CHECKING" "" "${IMPERSONATION[@]}"
+request GET "$V1/accounts?customer_id=$HOLDER" "" "${IMPERSONATION[@]}"
+check "the admin reads as the customer" "s == 200"
+
+request POST "$V1/admin/oauth/clients" "{\"name\": \"Access Report App\", \"redirect_uris\": \"$REDIRECT\", \"scopes\": \"balances:read\"}" "${ADMIN[@]}"
+CLIENT_ID=$(field "['client']['client_id']")
+CLIENT=(-u "$CLIENT_ID:$(field "['client_secret']")")
+request POST "$V1/oauth/authorize" "{\"client_id\": \"$CLIENT_ID\", \"redirect_uri\": \"$REDIRECT\", \"scope\": \"balances:read\", \"approve\": true}" "${CUSTOMER[@]}"
+CODE=$(query_param "$(field "['redirect_to']")" code)
+request POST "$V1/oauth/token" "grant_type=authorization_code&code=$CODE&redirect_uri=$REDIRECT" "${CLIENT[@]}"
+CLIENT_TOKEN=(-H "Authorization: Bearer $(field "['access_token']")")
+request GET "$V1/accounts/$CHECKING/balance" "" "${CLIENT_TOKEN[@]}"
+check "a third party reads a balance under the customer's consent" "s == 200"
+
+echo
+echo "Summary"
+request GET "$V1/customers/$HOLDER/access-report" "" "${ADMIN[@]}"
+check "the report covers the year to today" "s == 200 and b['customer_id'] == $HOLDER and b['to'] == '$TODAY' and b['from'] < '$TODAY'"
+check "staff writes are counted by category" \
+    "a('$ADMIN_USER', 'write', 'customers')['count'] == 1 and a('$ADMIN_USER', 'write', 'accounts')['count'] == 2 and a('$ADMIN_USER', 'write', 'transactions')['count'] == 1"
+check "staff reads are counted with the role and first and last times" \
+    "a('$TELLER_USER', 'read', 'accounts/balance')['count'] == 2 and a('$TELLER_USER', 'read', 'accounts/balance')['role'] == 'teller' and a('$TELLER_USER', 'read', 'accounts/balance')['first_at'] < a('$TELLER_USER', 'read', 'accounts/balance')['last_at']"
+check "a read of a transaction counts toward its account holder" "a('$TELLER_USER', 'read', 'transactions/receipt')['count'] == 1"
+check "the customer's own reads are theirs" \
+    "a('$CUSTOMER_USER', 'read', 'accounts')['actor_type'] == 'customer' and a('$CUSTOMER_USER', 'read', 'accounts')['count'] == 1"
+check "impersonated reads show the real admin" \
+    "a('$ADMIN_USER', 'read', 'accounts')['impersonation'] == True and a('$ADMIN_USER', 'read', 'accounts')['actor_type'] == 'staff' and a('$ADMIN_USER', 'read', 'accounts')['count'] == 2"
+check "starting the impersonation is on the report" "a('$ADMIN_USER', 'write', 'admin/impersonate')['count'] == 1"
+check "third-party reads show the client" \
+    "a('$CLIENT_ID', 'read', 'accounts/balance')['actor_type'] == 'api_client' and a('$CLIENT_ID', 'read', 'accounts/balance')['count'] == 1"
+check "another customer's records are left out" \
+    "a('$TELLER_USER', 'read', 'customers')['count'] == 1 and b['total'] == sum(x['count'] for x in b['accesses'])"
+
+request GET "$V1/accounts/$CHECKING/access-report" "" "${ADMIN[@]}"
+check "an account's report covers only that account" \
+    "s == 200 and b['account_id'] == $CHECKING and a('$TELLER_USER', 'read', 'accounts/balance')['count'] == 2 and a('$TELLER_USER', 'read', 'customers') is None and a('$CUSTOMER_USER', 'read', 'accounts') is None"
+
+YESTERDAY=$(python3 -c "import datetime; print(datetime.date.fromisoformat('$TODAY') - datetime.timedelta(days=1))")
+request GET "$V1/customers/$HOLDER/access-report?from=$YESTERDAY&to=$YESTERDAY" "" "${ADMIN[@]}"
+check "a period without accesses is empty" "s == 200 and b['total'] == 0 and b['accesses'] == []"
+
+echo
+echo "Detail"
+request GET "$V1/customers/$HOLDER/access-report" "" "${ADMIN[@]}"
+TOTAL=$(field "['total']")
+request GET "$V1/customers/$HOLDER/access-report?detail=true&limit=3" "" "${ADMIN[@]}"
+check "the detail lists the requests newest first, a page at a time" \
+    "s == 200 and b['total'] == $TOTAL + 1 and len(b['events']) == 3 and b['events'][0]['id'] > b['events'][1]['id'] > b['events'][2]['id']"
+check "each request carries its actor type, access and category" \
+    "b['events'][0]['route'] == '/api/v1/customers/:id/access-report' and b['events'][0]['actor_type'] == 'staff' and b['events'][0]['access'] == 'read' and b['events'][0]['category'] == 'customers/access-report'"
+request GET "$V1/customers/$HOLDER/access-report?detail=true&limit=3&page=2" "" "${ADMIN[@]}"
+check "later pages continue" "s == 200 and len(b['events']) == 3"
+
+echo
+echo "Permissions"
+request GET "$V1/customers/$HOLDER/access-report" "" "${TELLER[@]}"
+check "staff without the permission are refused" "s == 403"
+request GET "$V1/customers/$HOLDER/access-report" "" "${CUSTOMER[@]}"
+check "the customer sees their own report" "s == 200 and a('$TELLER_USER', 'read', 'accounts/balance')['count'] == 2"
+request GET "$V1/customers/$OTHER/access-report" "" "${CUSTOMER[@]}"
+check "the customer cannot see another customer's report" "s == 403"
+request GET "$V1/customers/$HOLDER/access-report"
+check "a sign-in is required" "s == 401"
+request GET "$V1/customers/999999/access-report" "" "${ADMIN[@]}"
+check "an unknown customer is not found" "s == 404"
+request GET "$V1/customers/$HOLDER/access-report?from=$TODAY&to=$YESTERDAY" "" "${ADMIN[@]}"
+check "to before from is rejected" "s == 400"
+request GET "$V1/customers/$HOLDER/access-report?from=yesterday" "" "${ADMIN[@]}"
+check "a malformed date is rejected" "s == 400"
+
+echo
+echo "Scale"
+# 100,000 entries across 1,000 customers, 100 of them the holder's, recorded just after the last real entry
+python3 -c "
+import datetime, sqlite3, sys
+db = sqlite3.connect(sys.argv[1], timeout=30)
+last = db.execute('SELECT MAX(created_at) FROM audit_entries').fetchone()[0]
+start = datetime.datetime.fromisoformat(last[:26].replace(' ', 'T')) + datetime.timedelta(milliseconds=1)
+holder, user = int(sys.argv[2]), sys.argv[3]
+rows = []
+for i in range(100000):
+    at = (start + datetime.timedelta(microseconds=i)).strftime('%Y-%m-%d %H:%M:%S.%f') + '+00:00'
+    customer = holder if i % 1000 == 0 else 1000000 + i % 1000
+    rows.append((at, 1, user, 'admin', 'GET', '/api/v1/customers/%d' % customer, '/api/v1/customers/:id', 200, '127.0.0.1', 1, customer))
+db.executemany('INSERT INTO audit_entries (created_at, tenant_id, username, role, method, path, route, status, client_ip, duration_ms, customer_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)', rows)
+db.commit()
+" "$DB_PATH" "$HOLDER" "$SEEDED_USER"
+check "100,000 audit entries are seeded" "int('$(sql "SELECT COUNT(*) FROM audit_entries WHERE username = '$SEEDED_USER'")') == 100000"
+sleep 1
+request GET "$V1/customers/$HOLDER/access-report" "" "${ADMIN[@]}"
+check "the report counts only the holder's seeded entries" "s == 200 and a('$SEEDED_USER', 'read', 'customers')['count'] == 100"
+check "the report is built within ${REPORT_SECONDS}s" "t < $REPORT_SECONDS"
+request GET "$V1/customers/$HOLDER/access-report?detail=true&limit=50&page=2" "" "${ADMIN[@]}"
+check "a detail page is served within ${REPORT_SECONDS}s" "s == 200 and len(b['events']) == 50 and t < $REPORT_SECONDS"
+request GET "$V1/admin/query-plans" "" "${ADMIN[@]}"
+check "the report's queries are served by indexes" \
+    "s == 200 and all(p['uses_index'] and 'audit_entries_' in ' '.join(p['plan']) and 'tenant_id' not in ' '.join(p['plan']) for p in b['plans'] if p['name'] in ('audit_entries_from', 'customer_access_report', 'account_access_report'))"
+sql "DELETE FROM audit_entries WHERE username = '$SEEDED_USER'" > /dev/null
+
+finish "access report"
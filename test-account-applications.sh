@@ -0,0 +1,209 @@
+#!/bin/bash
+
+# Account Application Tests
+# Has a customer start an account application, fill in its product and funding steps with each validated as it is
+# saved, leave and resume it, and submit it: an eligible customer is approved at once and opens the account with its
+# initial transfer in one step. Checks that an applicant failing a criterion or the KYC level is referred to staff,
+# who approve (the criteria are kept as eligibility overrides) or reject with a reason, that a failed funding
+# transfer opens no account, that customers only see and act on their own applications, that drafts expire after
+# 30 days through the application-expiry job, and that every transition is in the status history. Each run creates
+# its own tenant; users are created with bankctl and drafts are aged in the server's database, so DB_PATH must be
+# the database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-account-applications.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-account-applications.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="apply-test-$RUN_ID-Aa1!"
+PLATFORM_USER="apply-platform-$RUN_ID"
+TENANT_CODE="apply$RUN_ID"
+
+echo " Account Application Tests"
+echo "==========================="
+
+# customer NAME KYC_LEVEL - creates a customer and stores their ID in CUSTOMER
+customer() {
+    request POST "$V1/customers" "{\"first_name\": \"$1\", \"last_name\": \"Applicant\", \"email\": \"apply-$1-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\", \"kyc_level\": $2}" "${AUTH[@]}"
+    CUSTOMER=$(field "['customer']['id']")
+}
+
+# account CUSTOMER AMOUNT - opens a checking account with AMOUNT deposited and stores its ID in ACCOUNT
+account() {
+    request POST "$V1/accounts" "{\"customer_id\": $1, \"account_type\": \"checking\"}" "${AUTH[@]}"
+    ACCOUNT=$(field "['account']['id']")
+    request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": $2}" "${AUTH[@]}"
+}
+
+# apply CUSTOMER ACCOUNT_TYPE FUNDING - starts and fills in an application as staff and stores its ID in APP
+apply() {
+    request POST "$V1/account-applications" "{\"customer_id\": $1}" "${AUTH[@]}"
+    APP=$(field "['application']['id']")
+    request PATCH "$V1/account-applications/$APP" "{\"product\": {\"account_type\": \"$2\"}, \"funding\": $3}" "${AUTH[@]}"
+}
+
+echo "Setup"
+tenant "$PLATFORM_USER" "Applications" apply-admin
+
+request POST "$V1/admin/products" "{\"account_type\": \"premium_savings\", \"name\": \"Premium Savings\", \"required_kyc_level\": 2}" "${AUTH[@]}"
+check "a product needing KYC level 2 is created" "s == 201"
+customer Verified 2
+VERIFIED=$CUSTOMER
+account "$VERIFIED" 100
+FUNDING=$ACCOUNT
+customer Unverified 0
+UNVERIFIED=$CUSTOMER
+account "$UNVERIFIED" 100
+OTHER_ACCOUNT=$ACCOUNT
+CUSTOMER_AUTH=(-H "Authorization: Bearer $(customer_user "apply-customer" "$VERIFIED" "$TENANT_CODE")")
+
+echo
+echo "Drafts"
+request POST "$V1/account-applications" "" "${CUSTOMER_AUTH[@]}"
+check "a customer starts a draft for themselves" "s == 201 and b['application']['status'] == 'draft' and b['application']['customer_id'] == $VERIFIED and b['next_step'] == 'product'"
+APP=$(field "['application']['id']")
+check "the draft expires in 30 days" "$(python3 -c "
+import datetime
+print(round((datetime.datetime.fromisoformat('$(field "['application']['expires_at']")'.replace('Z', '+00:00')[:26] + '+00:00') - datetime.datetime.now(datetime.timezone.utc)).total_seconds() / 86400))") == 30"
+request POST "$V1/account-applications" "{\"customer_id\": $UNVERIFIED}" "${CUSTOMER_AUTH[@]}"
+check "customers cannot apply for someone else" "s == 403"
+request POST "$V1/account-applications" "" "${AUTH[@]}"
+check "staff name the customer" "s == 400"
+request POST "$V1/account-applications" "{\"customer_id\": 999999999}" "${AUTH[@]}"
+check "an unknown customer is not found" "s == 404"
+request POST "$V1/account-applications" ""
+check "applying needs a login" "s == 401"
+request POST "$V1/account-applications/$APP/submit" "" "${CUSTOMER_AUTH[@]}"
+check "an empty draft cannot be submitted" "s == 400 and b['code'] == 'APPLICATION_INCOMPLETE' and b['missing_steps'] == ['product', 'funding']"
+
+request PATCH "$V1/account-applications/$APP" "{}" "${CUSTOMER_AUTH[@]}"
+check "a step is required" "s == 400"
+request PATCH "$V1/account-applications/$APP" "{\"product\": {}}" "${CUSTOMER_AUTH[@]}"
+check "the product step needs an account type" "s == 400 and b['code'] == 'INVALID_APPLICATION_STEP' and b['step'] == 'product'"
+request PATCH "$V1/account-applications/$APP" "{\"product\": {\"account_type\": \"loan\"}}" "${CUSTOMER_AUTH[@]}"
+check "loans are not opened this way" "s == 400 and b['code'] == 'RESERVED_ACCOUNT_TYPE'"
+request PATCH "$V1/account-applications/$APP" "{\"product\": {\"account_type\": \"gold\"}}" "${CUSTOMER_AUTH[@]}"
+check "a type outside the catalog is refused" "s == 400 and b['code'] == 'INVALID_ACCOUNT_TYPE'"
+request PATCH "$V1/account-applications/$APP" "{\"product\": {\"account_type\": \"checking\", \"currency\": \"XYZ\"}}" "${CUSTOMER_AUTH[@]}"
+check "an unsupported currency is refused" "s == 400 and b['step'] == 'product'"
+request PATCH "$V1/account-applications/$APP" "{\"product\": {\"account_type\": \"checking\"}}" "${CUSTOMER_AUTH[@]}"
+check "the product step is saved" "s == 200 and b['application']['account_type'] == 'checking' and b['application']['currency'] == 'USD' and b['application']['completed_steps'] == 'product' and b['next_step'] == 'funding'"
+
+request PATCH "$V1/account-applications/$APP" "{\"funding\": {\"method\": \"wire\"}}" "${CUSTOMER_AUTH[@]}"
+check "an unknown funding method is refused" "s == 400 and b['step'] == 'funding'"
+request PATCH "$V1/account-applications/$APP" "{\"funding\": {\"method\": \"transfer\", \"amount\": 40}}" "${CUSTOMER_AUTH[@]}"
+check "a transfer names its account" "s == 400 and 'from_account_id' in b['error']"
+request PATCH "$V1/account-applications/$APP" "{\"funding\": {\"method\": \"transfer\", \"amount\": 40, \"from_account_id\": $OTHER_ACCOUNT}}" "${CUSTOMER_AUTH[@]}"
+check "a transfer comes from the applicant's own account" "s == 400 and 'applicant' in b['error']"
+request PATCH "$V1/account-applications/$APP" "{\"funding\": {\"method\": \"transfer\", \"amount\": -5, \"from_account_id\": $FUNDING}}" "${CUSTOMER_AUTH[@]}"
+check "a transfer amount is positive" "s == 400"
+request PATCH "$V1/account-applications/$APP" "{\"funding\": {\"method\": \"none\", \"amount\": 5}}" "${CUSTOMER_AUTH[@]}"
+check "an unfunded account takes no amount" "s == 400"
+request GET "$V1/account-applications/$APP" "" "${CUSTOMER_AUTH[@]}"
+check "refused steps are not saved" "b['application']['completed_steps'] == 'product' and b['next_step'] == 'funding'"
+request PATCH "$V1/account-applications/$APP" "{\"funding\": {\"method\": \"transfer\", \"amount\": 40, \"from_account_id\": $FUNDING}}" "${CUSTOMER_AUTH[@]}"
+check "the funding step is saved" "s == 200 and b['application']['completed_steps'] == 'product,funding' and b['next_step'] == 'submit'"
+request GET "$V1/account-applications/$APP" "" "${CUSTOMER_AUTH[@]}"
+check "the draft is resumed where it was left" "s == 200 and b['application']['funding_amount'] == 40 and b['application']['funding_account_id'] == $FUNDING and b['next_step'] == 'submit'"
+request GET "$V1/account-applications/$APP" "" "${AUTH[@]}"
+check "staff see it too" "s == 200"
+
+echo
+echo "Approved on submission"
+request POST "$V1/account-applications/$APP/decision" "{\"decision\": \"approve\", \"reason\": \"Early\"}" "${AUTH[@]}"
+check "a draft waits for no decision" "s == 409"
+request POST "$V1/account-applications/$APP/open" "" "${CUSTOMER_AUTH[@]}"
+check "a draft cannot be opened" "s == 409"
+request POST "$V1/account-applications/$APP/submit" "" "${CUSTOMER_AUTH[@]}"
+check "an eligible customer is approved at once" "s == 200 and b['application']['status'] == 'approved' and b['application']['decided_by'] == 'system' and b['failed_criteria'] == []"
+request PATCH "$V1/account-applications/$APP" "{\"funding\": {\"method\": \"none\"}}" "${CUSTOMER_AUTH[@]}"
+check "a submitted application can no longer be changed" "s == 409"
+request POST "$V1/account-applications/$APP/submit" "" "${CUSTOMER_AUTH[@]}"
+check "nor submitted again" "s == 409"
+request POST "$V1/account-applications/$APP/open" "" "${CUSTOMER_AUTH[@]}"
+check "the account is opened with its initial deposit" "s == 201 and b['account']['account_type'] == 'checking' and b['account']['balance'] == 40 and b['application']['status'] == 'opened' and b['application']['account_id'] == b['account']['id']"
+OPENED=$(field "['account']['id']")
+check "the funding is a transfer from the applicant's account" "b['funding_transfer']['from_account_id'] == $FUNDING and b['funding_transfer']['amount'] == 40"
+check "the funding account paid for it" "$(sql "SELECT balance FROM accounts WHERE id = $FUNDING") == 60"
+check "the transfer names the application" "$(sql "SELECT COUNT(*) FROM transactions WHERE account_id = $FUNDING AND reference = 'APP-$APP'") == 1"
+request POST "$V1/account-applications/$APP/open" "" "${CUSTOMER_AUTH[@]}"
+check "an application opens one account" "s == 409"
+request GET "$V1/account-applications/$APP" "" "${CUSTOMER_AUTH[@]}"
+check "every transition is in its history" \
+    "[(h['old_status'], h['new_status'], h['changed_by']) for h in b['status_history']] == [('', 'draft', 'apply-customer'), ('draft', 'submitted', 'apply-customer'), ('submitted', 'approved', 'system'), ('approved', 'opened', 'apply-customer')]"
+check "the opened account is recorded" "b['application']['account_id'] == $OPENED and 'next_step' not in b"
+
+echo
+echo "Referred to staff"
+apply "$UNVERIFIED" premium_savings '{"method": "none"}'
+REFERRED=$APP
+request GET "$V1/account-applications/$REFERRED" "" "${CUSTOMER_AUTH[@]}"
+check "customers do not see others' applications" "s == 404"
+request POST "$V1/account-applications/$REFERRED/submit" "" "${CUSTOMER_AUTH[@]}"
+check "nor submit them" "s == 404"
+request POST "$V1/account-applications/$REFERRED/submit" "" "${AUTH[@]}"
+check "an applicant failing a criterion is referred to staff" "s == 202 and b['application']['status'] == 'submitted' and [f['criterion'] for f in b['failed_criteria']] == ['kyc_level'] and b['failed_criteria'][0]['required'] == 2"
+request GET "$V1/account-applications?status=submitted" "" "${AUTH[@]}"
+check "staff see the review queue" "s == 200 and [a['id'] for a in b['applications']] == [$REFERRED]"
+request GET "$V1/account-applications" "" "${CUSTOMER_AUTH[@]}"
+check "customers list only their own" "s == 200 and [a['id'] for a in b['applications']] == [$(sql "SELECT id FROM account_applications WHERE customer_id = $VERIFIED")]"
+request POST "$V1/account-applications/$REFERRED/open" "" "${AUTH[@]}"
+check "a referred application cannot be opened" "s == 409"
+request POST "$V1/account-applications/$REFERRED/decision" "{\"decision\": \"approve\", \"reason\": \"Mine\"}" "${CUSTOMER_AUTH[@]}"
+check "customers cannot decide" "s == 403"
+request POST "$V1/account-applications/$REFERRED/decision" "{\"decision\": \"approve\"}" "${AUTH[@]}"
+check "a decision needs a reason" "s == 400"
+request POST "$V1/account-applications/$REFERRED/decision" "{\"decision\": \"approve\", \"reason\": \"Passport checked in branch\"}" "${AUTH[@]}"
+check "staff approve it" "s == 200 and b['application']['status'] == 'approved' and b['application']['decided_by'] == 'apply-admin' and b['application']['decision_reason'] == 'Passport checked in branch'"
+request POST "$V1/account-applications/$REFERRED/decision" "{\"decision\": \"reject\", \"reason\": \"Changed my mind\"}" "${AUTH[@]}"
+check "a decision is final" "s == 409"
+request POST "$V1/account-applications/$REFERRED/open" "" "${AUTH[@]}"
+check "the approved application opens the account" "s == 201 and b['account']['account_type'] == 'premium_savings' and b['account']['balance'] == 0 and 'funding_transfer' not in b"
+check "the waived criterion is kept against the account" \
+    "[(o['criterion'], o['reason'], o['overridden_by'], o['account_id']) for o in b['eligibility_overrides']] == [('kyc_level', 'Passport checked in branch', 'apply-admin', b['account']['id'])]"
+
+apply "$UNVERIFIED" checking '{"method": "none"}'
+check "a product without rules still needs a verified identity" "s == 200"
+request POST "$V1/account-applications/$APP/submit" "" "${AUTH[@]}"
+check "so the unverified customer is referred" "s == 202 and b['failed_criteria'][0]['criterion'] == 'kyc_level' and b['failed_criteria'][0]['required'] == 1"
+request POST "$V1/account-applications/$APP/decision" "{\"decision\": \"reject\", \"reason\": \"Identity not confirmed\"}" "${AUTH[@]}"
+check "staff reject it" "s == 200 and b['application']['status'] == 'rejected'"
+request POST "$V1/account-applications/$APP/open" "" "${AUTH[@]}"
+check "a rejected application is never opened" "s == 409"
+request GET "$V1/account-applications/$APP" "" "${AUTH[@]}"
+check "the rejection is in its history with the reason" "b['status_history'][-1]['new_status'] == 'rejected' and b['status_history'][-1]['reason'] == 'Identity not confirmed'"
+
+echo
+echo "Funding"
+ACCOUNTS_BEFORE=$(sql "SELECT COUNT(*) FROM accounts WHERE customer_id = $VERIFIED")
+request POST "$V1/account-applications" "" "${CUSTOMER_AUTH[@]}"
+APP=$(field "['application']['id']")
+request PATCH "$V1/account-applications/$APP" "{\"product\": {\"account_type\": \"savings\"}, \"funding\": {\"method\": \"transfer\", \"amount\": 50, \"from_account_id\": $FUNDING}}" "${CUSTOMER_AUTH[@]}"
+check "both steps are saved at once" "s == 200 and b['next_step'] == 'submit'"
+request POST "$V1/account-applications/$APP/submit" "" "${CUSTOMER_AUTH[@]}"
+request POST "$V1/transactions" "{\"account_id\": $FUNDING, \"transaction_type\": \"withdrawal\", \"amount\": 30}" "${AUTH[@]}"
+request POST "$V1/account-applications/$APP/open" "" "${CUSTOMER_AUTH[@]}"
+check "a funding transfer the account cannot cover is refused" "s == 400 and b['error'].startswith('Insufficient')"
+check "and no account is opened" "$(sql "SELECT COUNT(*) FROM accounts WHERE customer_id = $VERIFIED") == $ACCOUNTS_BEFORE"
+request GET "$V1/account-applications/$APP" "" "${CUSTOMER_AUTH[@]}"
+check "the application stays approved" "b['application']['status'] == 'approved'"
+request POST "$V1/transactions" "{\"account_id\": $FUNDING, \"transaction_type\": \"deposit\", \"amount\": 30}" "${AUTH[@]}"
+request POST "$V1/account-applications/$APP/open" "" "${CUSTOMER_AUTH[@]}"
+check "it opens once the funds are there" "s == 201 and b['account']['balance'] == 50 and b['account']['account_type'] == 'savings'"
+
+echo
+echo "Expiry"
+request POST "$V1/account-applications" "" "${CUSTOMER_AUTH[@]}"
+STALE=$(field "['application']['id']")
+request POST "$V1/account-applications" "" "${CUSTOMER_AUTH[@]}"
+FRESH=$(field "['application']['id']")
+sql "UPDATE account_applications SET expires_at = datetime('now', '-1 day') WHERE id = $STALE" > /dev/null
+run_job application-expiry
+check "the application-expiry job succeeds" "[r for r in b['runs'] if r['job_name'] == 'application-expiry'][0]['status'] == 'succeeded'"
+request GET "$V1/account-applications/$STALE" "" "${CUSTOMER_AUTH[@]}"
+check "a draft left for 30 days expires" "b['application']['status'] == 'expired' and b['status_history'][-1]['changed_by'] == 'system' and 'next_step' not in b"
+request PATCH "$V1/account-applications/$STALE" "{\"product\": {\"account_type\": \"checking\"}}" "${CUSTOMER_AUTH[@]}"
+check "an expired draft cannot be resumed" "s == 409"
+request GET "$V1/account-applications/$FRESH" "" "${CUSTOMER_AUTH[@]}"
+check "newer drafts are kept" "b['application']['status'] == 'draft'"
+
+finish "account application"
@@ -0,0 +1,102 @@
+#!/bin/bash
+
+# Account Closure Tests
+# Checks that closing sweeps the balance to another account or a cashier's check, that an account with a pending
+# pre-authorization or a debit held for review cannot be closed until the hold is gone, and that interest accrued
+# this month is credited before the sweep so it is paid out with the balance. The review hold needs the global
+# new_account_review flag, which the suite turns on and restores on exit. Each run creates its own tenant; the
+# platform admin and the users are created with bankctl, and accrued interest is set in the server's database, so
+# DB_PATH must be the database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-account-closure.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-account-closure.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="closure-test-$RUN_ID-Aa1!"
+TENANT_CODE="close$RUN_ID"
+
+echo " Account Closure Tests"
+echo "======================="
+
+# account TYPE [DEPOSIT] - opens an account of TYPE for HOLDER, funding it with DEPOSIT, and prints its ID
+account() {
+    request POST "$V1/accounts" "{\"customer_id\": $HOLDER, \"account_type\": \"$1\"}" "${AUTH[@]}"
+    local id
+    id=$(field "['account']['id']")
+    if [ -n "$2" ]; then
+        request POST "$V1/transactions" "{\"account_id\": $id, \"transaction_type\": \"deposit\", \"amount\": $2}" "${AUTH[@]}"
+    fi
+    echo "$id"
+}
+
+# flag ENABLED - turns the new_account_review flag on or off
+flag() {
+    request PUT "$V1/admin/flags/new_account_review" "{\"enabled\": $1}" "${PLATFORM[@]}"
+}
+
+echo "Setup"
+tenant "closure-platform-$RUN_ID" "Closures" closure-admin
+CHECKER=("${PLATFORM[@]}" -H "X-Tenant: $TENANT_CODE")
+request POST "$V1/customers" "{\"first_name\": \"Closing\", \"last_name\": \"Holder\", \"email\": \"closure-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+HOLDER=$(field "['customer']['id']")
+DESTINATION=$(account checking)
+check "the holder has a destination account" "'$DESTINATION'.isdigit()"
+
+echo
+echo "Sweeping"
+EMPTY=$(account savings)
+request POST "$V1/accounts/$EMPTY/close" "" "${AUTH[@]}"
+check "an empty account closes without a destination" "s == 200 and b['closure']['method'] == 'none' and b['account']['status'] == 'closed'"
+FUNDED=$(account savings 120)
+request POST "$V1/accounts/$FUNDED/close" '{"reason": "Customer request"}' "${AUTH[@]}"
+check "a funded account needs a destination" "s == 400"
+request POST "$V1/accounts/$FUNDED/close" "{\"destination_account_id\": $DESTINATION, \"reason\": \"Customer request\"}" "${AUTH[@]}"
+check "the balance is swept to the destination" \
+    "s == 200 and b['closure']['method'] == 'transfer' and float(b['closure']['swept_amount']) == 120 and float(b['account']['balance']) == 0"
+request POST "$V1/accounts/$FUNDED/close" "{\"destination_account_id\": $DESTINATION}" "${AUTH[@]}"
+check "closing again returns the original closure" "s == 200 and b['already_closed'] is True"
+CHECKED=$(account savings 40)
+request POST "$V1/accounts/$CHECKED/close" '{"destination": "cashier_check"}' "${AUTH[@]}"
+check "a cashier's check pays the balance out" "s == 200 and b['closure']['method'] == 'cashier_check' and b['closure']['cashier_check_number']"
+
+echo
+echo "Holds"
+AUTHORIZED=$(account checking 300)
+request POST "$V1/accounts/$AUTHORIZED/authorizations" "{\"amount\": 80, \"channel\": \"card\", \"merchant\": \"Hotel $RUN_ID\"}" "${AUTH[@]}"
+HOLD=$(field "['authorization']['id']")
+request POST "$V1/accounts/$AUTHORIZED/close" "{\"destination_account_id\": $DESTINATION}" "${AUTH[@]}"
+check "an account with a pending pre-authorization is not closed" "s == 409"
+request GET "$V1/accounts/$AUTHORIZED/balance" "" "${AUTH[@]}"
+check "nothing was swept" "s == 200 and float(b['balance']) == 300"
+request POST "$V1/authorizations/$HOLD/release" "" "${AUTH[@]}"
+request POST "$V1/accounts/$AUTHORIZED/close" "{\"destination_account_id\": $DESTINATION}" "${AUTH[@]}"
+check "once the hold is released it closes with the whole balance" "s == 200 and float(b['closure']['swept_amount']) == 300"
+
+request GET "$V1/admin/flags" "" "${PLATFORM[@]}"
+WAS_ENABLED=$(python3 -c "import json, sys; print(json.dumps([f['enabled'] for f in json.loads(sys.argv[1])['flags'] if f['key'] == 'new_account_review'][0]))" "$BODY")
+on_exit 'flag "$WAS_ENABLED"'
+flag true
+REVIEWED=$(account checking 600)
+request POST "$V1/transactions" "{\"account_id\": $REVIEWED, \"transaction_type\": \"withdrawal\", \"amount\": 400}" "${AUTH[@]}"
+check "a large first withdrawal is held for review" "s == 202"
+REVIEW=$(field "['review']['id']")
+flag "$WAS_ENABLED"
+request POST "$V1/accounts/$REVIEWED/close" "{\"destination_account_id\": $DESTINATION}" "${AUTH[@]}"
+check "an account with a debit held for review is not closed" "s == 409"
+request POST "$V1/operations/reviews/$REVIEW/decline" "{\"note\": \"Account is being closed\"}" "${CHECKER[@]}"
+check "staff decline it" "s == 200 and b['review']['status'] == 'declined'"
+request POST "$V1/accounts/$REVIEWED/close" "{\"destination_account_id\": $DESTINATION}" "${AUTH[@]}"
+check "once the review is declined it closes with the whole balance" "s == 200 and float(b['closure']['swept_amount']) == 600"
+
+echo
+echo "Accrued interest"
+EARNING=$(account savings 1000)
+sql "UPDATE accounts SET accrued_interest = 3.17 WHERE id = $EARNING" > /dev/null
+request POST "$V1/accounts/$EARNING/close" "{\"destination_account_id\": $DESTINATION}" "${AUTH[@]}"
+check "the accrued interest is swept with the balance" "s == 200 and float(b['closure']['swept_amount']) == 1003.17 and float(b['account']['balance']) == 0"
+request GET "$V1/accounts/$EARNING/transactions" "" "${AUTH[@]}"
+check "it was credited as interest before the sweep" \
+    "s == 200 and [t['transaction_type'] for t in sorted(b['transactions'], key=lambda t: t['id'])][-2:] == ['interest', 'transfer'] and any(t['transaction_type'] == 'interest' and float(t['amount']) == 3.17 for t in b['transactions'])"
+check "nothing is left accrued" "'$(sql "SELECT accrued_interest FROM accounts WHERE id = $EARNING")' in ('0', '0.0')"
+
+finish "account closure"
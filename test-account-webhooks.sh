@@ -0,0 +1,223 @@
+#!/bin/bash
+
+# Account Webhook Tests
+# Runs a local webhook receiver and checks customer subscriptions on their own accounts: the account_webhooks flag
+# gates them, only the holder or staff with accounts:webhooks reach an account's subscriptions, event types are
+# limited to transaction.posted, balance.low and statement.ready, and each account has a capped number of them.
+# Events on two customers' accounts, the bank's cash offsets of their postings and forged outbox rows are then
+# published, and each endpoint must receive exactly its own account's events, signed with its secret, with the
+# delivery logs to match. The users are created with bankctl and forged events are written to the server's database,
+# so DB_PATH must be the database the server uses. The server must run with ACCOUNT_WEBHOOK_ALLOW_HTTP=true, for the
+# local receiver, and the default ACCOUNT_WEBHOOK_LIMIT. The run leaves the account_webhooks flag off. Exits non-zero
+# on failure.
+#
+# Usage: DB_PATH=banking.db ./test-account-webhooks.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl RECEIVER_PORT=18099 ./test-account-webhooks.sh
+
+source "$(dirname "$0")/test-lib.sh"
+RECEIVER_PORT="${RECEIVER_PORT:-18099}"
+RECEIVER="http://127.0.0.1:$RECEIVER_PORT"
+PASSWORD="hooks-test-$RUN_ID-Aa1!"
+SECRET_A="alice-secret-$RUN_ID"
+SECRET_B="bob-secret-$RUN_ID"
+
+echo " Account Webhook Tests"
+echo "======================"
+
+# received PYTHON_EXPRESSION - waits up to 15 seconds for the expression to hold over the deliveries the receiver
+# accepted, as `r`: a list of {path, type, event, account_id, signed}, where signed is true when the signature
+# matches the secret of the path's customer
+received() {
+    for _ in $(seq 1 30); do
+        python3 -c "
+import json, sys
+r = [json.loads(line) for line in open(sys.argv[1])]
+sys.exit(0 if ($1) else 1)
+" "$WORK/received" 2>/dev/null && return 0
+        sleep 0.5
+    done
+    return 1
+}
+
+# flag ENABLED - switches the account_webhooks flag; the server refreshes its cache as it saves the change
+flag() {
+    request PUT "$V1/admin/flags/account_webhooks" "{\"enabled\": $1, \"rollout_percent\": 100}" "${ADMIN[@]}"
+}
+
+# post ACCOUNT TYPE AMOUNT - posts a transaction as the admin
+post() {
+    request POST "$V1/transactions" "{\"account_id\": $1, \"transaction_type\": \"$2\", \"amount\": $3}" "${ADMIN[@]}"
+}
+
+# hook AUTH_VAR ACCOUNT PATH SECRET TYPES - subscribes the receiver's PATH to an account as a caller
+hook() {
+    local -n caller=$1
+    request POST "$V1/accounts/$2/webhooks" "{\"url\": \"$RECEIVER/$3\", \"secret\": \"$4\", \"event_types\": \"$5\"}" "${caller[@]}"
+}
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "hooks-admin-$RUN_ID")")
+for holder in alice bob; do
+    request POST "$V1/customers" "{\"first_name\": \"${holder^}\", \"last_name\": \"Hooks\", \"email\": \"$holder-$RUN_ID@example.com\"}" "${ADMIN[@]}"
+    declare "${holder^^}=$(field "['customer']['id']")"
+done
+request POST "$V1/accounts" "{\"customer_id\": $ALICE, \"account_type\": \"checking\"}" "${ADMIN[@]}"
+ALICE_CHECKING=$(field "['account']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $ALICE, \"account_type\": \"savings\"}" "${ADMIN[@]}"
+ALICE_SAVINGS=$(field "['account']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $BOB, \"account_type\": \"checking\"}" "${ADMIN[@]}"
+BOB_CHECKING=$(field "['account']['id']")
+for holder in alice bob; do
+    id=${holder^^}
+    declare -a "${holder^^}_AUTH=(-H 'Authorization: Bearer $(customer_user "hooks-$holder-$RUN_ID" "${!id}")')"
+done
+
+# Webhook receiver - records each delivery's path, event and account, and whether its signature matches the
+# secret of the customer the path belongs to (a... for Alice, b... for Bob)
+touch "$WORK/received"
+python3 -c "
+import hashlib, hmac, http.server, json, sys
+secrets = {'a': sys.argv[3], 'b': sys.argv[4]}
+class Receiver(http.server.BaseHTTPRequestHandler):
+    def do_POST(self):
+        body = self.rfile.read(int(self.headers.get('Content-Length', 0)))
+        path = self.path.strip('/')
+        expected = 'sha256=' + hmac.new(secrets[path[0]].encode(), body, hashlib.sha256).hexdigest()
+        record = {'path': path, 'type': self.headers.get('X-Event-Type'), 'event': int(self.headers.get('X-Event-ID')),
+                  'account_id': json.loads(body).get('account_id'), 'signed': self.headers.get('X-Webhook-Signature') == expected}
+        with open(sys.argv[2], 'a') as f:
+            f.write(json.dumps(record) + '\n')
+        self.send_response(204)
+        self.end_headers()
+    def log_message(self, *args):
+        pass
+http.server.HTTPServer(('127.0.0.1', int(sys.argv[1])), Receiver).serve_forever()
+" "$RECEIVER_PORT" "$WORK/received" "$SECRET_A" "$SECRET_B" &
+background $!
+
+echo
+echo "Soft launch and access"
+flag false
+hook ALICE_AUTH "$ALICE_CHECKING" a-checking "$SECRET_A" transaction.posted
+check "subscribing needs the account_webhooks flag" "s == 403 and b['code'] == 'FEATURE_NOT_AVAILABLE'"
+flag true
+request GET "$V1/accounts/$ALICE_CHECKING/webhooks"
+check "signing in is required" "s == 401"
+hook BOB_AUTH "$ALICE_CHECKING" b-stolen "$SECRET_B" transaction.posted
+check "another customer cannot subscribe to the account" "s == 404"
+hook ALICE_AUTH "$ALICE_CHECKING" a-checking "$SECRET_A" "transaction.posted,account.frozen"
+check "only account event types are accepted" "s == 400"
+hook ALICE_AUTH "$ALICE_CHECKING" a-checking "$SECRET_A" ""
+check "at least one event type is required" "s == 400"
+hook ALICE_AUTH "$ALICE_CHECKING" a-checking "short" transaction.posted
+check "a short secret is refused" "s == 400"
+request POST "$V1/accounts/$ALICE_CHECKING/webhooks" "{\"url\": \"ftp://127.0.0.1/hook\", \"secret\": \"$SECRET_A\", \"event_types\": \"transaction.posted\"}" "${ALICE_AUTH[@]}"
+check "the URL must be http(s)" "s == 400"
+
+hook ALICE_AUTH "$ALICE_CHECKING" a-checking "$SECRET_A" "statement.ready, transaction.posted,balance.low,transaction.posted"
+check "the holder subscribes to an account" "s == 201 and b['subscription']['account_id'] == $ALICE_CHECKING and b['subscription']['customer_id'] == $ALICE and 'secret' not in b['subscription']"
+check "event types are normalized" "b['subscription']['event_types'] == 'transaction.posted,balance.low,statement.ready'"
+ALICE_HOOK=$(field "['subscription']['id']")
+hook ALICE_AUTH "$ALICE_SAVINGS" a-savings "$SECRET_A" transaction.posted
+ALICE_SAVINGS_HOOK=$(field "['subscription']['id']")
+hook BOB_AUTH "$BOB_CHECKING" b-checking "$SECRET_B" "transaction.posted,balance.low"
+BOB_HOOK=$(field "['subscription']['id']")
+
+hook ALICE_AUTH "$ALICE_CHECKING" a-extra "$SECRET_A" transaction.posted
+EXTRA1=$(field "['subscription']['id']")
+hook ALICE_AUTH "$ALICE_CHECKING" a-extra "$SECRET_A" transaction.posted
+EXTRA2=$(field "['subscription']['id']")
+hook ALICE_AUTH "$ALICE_CHECKING" a-extra "$SECRET_A" transaction.posted
+check "an account has at most three subscriptions" "s == 409 and b['code'] == 'WEBHOOK_LIMIT_REACHED' and b['limit'] == 3"
+request DELETE "$V1/accounts/$ALICE_CHECKING/webhooks/$EXTRA1" "" "${BOB_AUTH[@]}"
+check "another customer cannot delete them" "s == 404"
+request DELETE "$V1/accounts/$BOB_CHECKING/webhooks/$EXTRA1" "" "${BOB_AUTH[@]}"
+check "nor reach them through their own account" "s == 404"
+request DELETE "$V1/accounts/$ALICE_CHECKING/webhooks/$EXTRA1" "" "${ALICE_AUTH[@]}"
+check "the holder deletes a subscription" "s == 200"
+request DELETE "$V1/accounts/$ALICE_CHECKING/webhooks/$EXTRA2" "" "${ADMIN[@]}"
+check "staff with accounts:webhooks can too" "s == 200"
+request GET "$V1/accounts/$ALICE_CHECKING/webhooks" "" "${ALICE_AUTH[@]}"
+check "the account lists its remaining subscription" "s == 200 and [w['id'] for w in b['subscriptions']] == [$ALICE_HOOK]"
+request GET "$V1/accounts/$ALICE_CHECKING/webhooks" "" "${BOB_AUTH[@]}"
+check "another customer cannot list them" "s == 404"
+
+echo
+echo "Account scoping"
+request POST "$V1/accounts/$ALICE_CHECKING/alerts" '{"rule_type": "balance_below", "threshold": 100, "channel": "email", "target": "alerts@example.com"}'
+request POST "$V1/accounts/$BOB_CHECKING/alerts" '{"rule_type": "balance_below", "threshold": 100, "channel": "email", "target": "alerts@example.com"}'
+post "$ALICE_CHECKING" deposit 500
+post "$ALICE_CHECKING" withdrawal 450
+post "$ALICE_SAVINGS" deposit 20
+post "$BOB_CHECKING" deposit 300
+request POST "$V1/transfers" "{\"from_account_id\": $BOB_CHECKING, \"to_account_id\": $ALICE_CHECKING, \"amount\": 250}" "${ADMIN[@]}"
+check "a transfer from Bob to Alice posts" "s in (200, 201)"
+LAST_MONTH=$(python3 -c "
+import datetime
+d = datetime.date.today().replace(day=1) - datetime.timedelta(days=1)
+print('%d/%02d' % (d.year, d.month))")
+request POST "$V1/accounts/$ALICE_CHECKING/statements/$LAST_MONTH" "" "${ADMIN[@]}"
+check "a statement is generated for Alice's checking account" "s in (200, 201)"
+
+# Forged outbox rows: an event on Alice's account whose payload names Bob's, an account event type she cannot
+# subscribe to, and one on Bob's customer aggregate. A deposit to each account after them marks when they are done
+for forged in \
+    "'account', $ALICE_CHECKING, 'transaction.posted', '{\"account_id\": $BOB_CHECKING}'" \
+    "'account', $ALICE_CHECKING, 'account.frozen', '{\"account_id\": $ALICE_CHECKING}'" \
+    "'customer', $ALICE_CHECKING, 'transaction.posted', '{\"account_id\": $ALICE_CHECKING}'" \
+    "'account', $BOB_CHECKING, 'balance.low', '{\"id\": $BOB_CHECKING}'"; do
+    sql "INSERT INTO outbox_events (created_at, updated_at, aggregate_type, aggregate_id, event_type, payload, status, attempts, next_attempt_at)
+         VALUES (datetime('now'), datetime('now'), $forged, 'pending', 0, datetime('now', '-1 minute'))" > /dev/null
+done
+FORGED_FROM=$(sql "SELECT MAX(id) - 3 FROM outbox_events")
+post "$ALICE_CHECKING" deposit 1
+post "$BOB_CHECKING" deposit 1
+MARKERS=$(sql "SELECT GROUP_CONCAT(id) FROM (SELECT MAX(id) AS id FROM outbox_events WHERE aggregate_type = 'account' AND aggregate_id IN ($ALICE_CHECKING, $BOB_CHECKING) GROUP BY aggregate_id)")
+
+ARRIVED=False
+received "{d['event'] for d in r} >= {$MARKERS}" && ARRIVED=True
+check "every delivery arrives" "$ARRIVED"
+check "every delivery is signed with its subscriber's secret" "$(python3 -c "
+import json, sys
+print(all(json.loads(l)['signed'] for l in open(sys.argv[1])))" "$WORK/received")"
+ACCOUNTS="{'a-checking': $ALICE_CHECKING, 'a-savings': $ALICE_SAVINGS, 'b-checking': $BOB_CHECKING}"
+RECEIVED=$(cat "$WORK/received" | python3 -c "import json, sys; print(json.dumps([json.loads(l) for l in sys.stdin]))")
+BODY=$RECEIVED STATUS=200
+check "each endpoint receives only its own account's events" \
+    "all(d['account_id'] == $ACCOUNTS[d['path']] for d in b) and {d['path'] for d in b} == set($ACCOUNTS)"
+check "no forged event is delivered" "not [d for d in b if $FORGED_FROM <= d['event'] < $FORGED_FROM + 4]"
+check "the checking subscription gets postings, the low balance and the statement" \
+    "sorted(d['type'] for d in b if d['path'] == 'a-checking') == ['balance.low'] + ['statement.ready'] + ['transaction.posted'] * 4"
+check "the savings subscription gets only its one deposit" "[d['type'] for d in b if d['path'] == 'a-savings'] == ['transaction.posted']"
+check "Bob's subscription gets his postings and his low balance" \
+    "sorted(d['type'] for d in b if d['path'] == 'b-checking') == ['balance.low', 'transaction.posted', 'transaction.posted', 'transaction.posted']"
+check "the bank's cash offsets are published but never delivered" \
+    "$(sql "SELECT COUNT(*) FROM outbox_events WHERE event_type = 'transaction.posted' AND aggregate_type = 'account' AND id >= $FORGED_FROM AND aggregate_id NOT IN ($ALICE_CHECKING, $BOB_CHECKING)") >= 2"
+
+echo
+echo "Delivery logs"
+request GET "$V1/accounts/$ALICE_CHECKING/webhooks/$ALICE_HOOK/deliveries" "" "${ALICE_AUTH[@]}"
+check "the holder reads the subscription's deliveries" \
+    "s == 200 and b['total'] == 6 and all(d['subscription_id'] == $ALICE_HOOK and d['status'] == 'delivered' for d in b['deliveries'])"
+request GET "$V1/accounts/$ALICE_CHECKING/webhooks/$BOB_HOOK/deliveries" "" "${ALICE_AUTH[@]}"
+check "another account's subscription is not found through hers" "s == 404"
+request GET "$V1/accounts/$BOB_CHECKING/webhooks/$BOB_HOOK/deliveries" "" "${ALICE_AUTH[@]}"
+check "nor through its own account" "s == 404"
+request GET "$V1/accounts/$BOB_CHECKING/webhooks/$BOB_HOOK/deliveries?status=delivered" "" "${BOB_AUTH[@]}"
+check "Bob reads his" "s == 200 and b['total'] == 4"
+
+echo
+echo "Switching off"
+flag false
+post "$ALICE_SAVINGS" deposit 5
+EVENT=$(sql "SELECT MAX(id) FROM outbox_events WHERE aggregate_type = 'account' AND aggregate_id = $ALICE_SAVINGS")
+for _ in $(seq 1 30); do
+    [ "$(sql "SELECT status FROM outbox_events WHERE id = $EVENT")" = "dispatched" ] && break
+    sleep 0.5
+done
+check "with the flag off, events are no longer queued for account subscriptions" \
+    "$(sql "SELECT COUNT(*) FROM webhook_deliveries WHERE event_id = $EVENT") == 0"
+request GET "$V1/accounts/$ALICE_SAVINGS/webhooks" "" "${ALICE_AUTH[@]}"
+check "but the holder still sees the subscriptions" "s == 200 and [w['id'] for w in b['subscriptions']] == [$ALICE_SAVINGS_HOOK]"
+
+finish "account webhook"
@@ -0,0 +1,133 @@
+#!/bin/bash
+
+# Anonymization Tests
+# Seeds a customer with personal data through the API, takes a copy of the server's database and checks that
+# bankctl anonymize refuses the copy while it is listed in PRODUCTION_DB_PATH (directly or through a symlink),
+# then anonymizes it and checks that names, emails, phones, addresses, dates of birth, notes, document names and
+# stored files are replaced, that no original email or phone is left anywhere, that balances and transactions
+# are unchanged and still reconcile, and that the same seed gives the same fakes. The server's own database is
+# only read. The admin user is created with bankctl against the server's database. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-anonymize.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-anonymize.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="anonymize-test-$RUN_ID"
+
+echo " Anonymization Tests"
+echo "===================="
+
+# copy DESTINATION - takes a consistent copy of the server's database, WAL included
+copy() {
+    python3 -c "
+import sqlite3, sys
+src = sqlite3.connect(sys.argv[1], timeout=10)
+dst = sqlite3.connect(sys.argv[2])
+src.backup(dst)
+dst.close()" "$DB_PATH" "$1"
+}
+
+# mentions DATABASE TEXT... - prints how many text values anywhere in a database contain any of the texts
+mentions() {
+    python3 -c "
+import sqlite3, sys
+db = sqlite3.connect(sys.argv[1])
+needles = [n.lower() for n in sys.argv[2:]]
+found = 0
+for (table,) in db.execute(\"SELECT name FROM sqlite_master WHERE type = 'table'\"):
+    for row in db.execute('SELECT * FROM \"%s\"' % table):
+        for value in row:
+            if isinstance(value, str) and any(n in value.lower() for n in needles):
+                found += 1
+print(found)" "$@"
+}
+
+EMAIL="anon-$RUN_ID@example.org"
+NEW_EMAIL="anon-new-$RUN_ID@example.org"
+PHONE="+1 (415) 867-${RUN_ID: -4}"
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "anonymize-admin-$RUN_ID")")
+
+request POST "$V1/customers" "{\"first_name\": \"Rosalind\", \"last_name\": \"Quimby-$RUN_ID\", \"email\": \"$EMAIL\",
+    \"phone\": \"$PHONE\", \"address\": \"12 Real Street, Realtown\", \"date_of_birth\": \"1980-06-15\"}"
+CUSTOMER=$(field "['customer']['id']")
+request PUT "$V1/customers/$CUSTOMER" "{\"email\": \"$NEW_EMAIL\"}"
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}"
+ACCOUNT=$(field "['account']['id']")
+request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 250, \"description\": \"Refund for $EMAIL\"}"
+request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"withdrawal\", \"amount\": 40}"
+request POST "$V1/customers/$CUSTOMER/notes" "{\"body\": \"Called Rosalind on $PHONE\", \"visibility\": \"internal\"}" "${ADMIN[@]}"
+check "a note is written" "s == 201"
+python3 -c "
+import struct, sys, zlib
+def chunk(kind, data):
+    return struct.pack('>I', len(data)) + kind + data + struct.pack('>I', zlib.crc32(kind + data))
+raw = b''.join(b'\x00' + b'\xff\x00\x00' * 8 for _ in range(8))
+sys.stdout.buffer.write(b'\x89PNG\r\n\x1a\n' + chunk(b'IHDR', struct.pack('>IIBBBBB', 8, 8, 8, 2, 0, 0, 0))
+    + chunk(b'IDAT', zlib.compress(raw)) + chunk(b'IEND', b''))" > "$WORK/passport-rosalind.png"
+STATUS=$(curl -s -o /dev/null -w '%{http_code}' -X POST "$V1/customers/$CUSTOMER/documents" "${ADMIN[@]}" \
+    -F kind=identity -F "file=@$WORK/passport-rosalind.png;type=image/png")
+check "a document is uploaded" "s == 201"
+
+copy "$WORK/copy.db"
+copy "$WORK/again.db"
+ln -s "$WORK/copy.db" "$WORK/link.db"
+
+echo
+echo "Production guard"
+PRODUCTION_DB_PATH="$WORK/copy.db" $BANKCTL -db "$WORK/copy.db" anonymize -uploads "$WORK/uploads" > "$WORK/out" 2>&1
+STATUS=$?
+BODY=""
+check "a database listed in PRODUCTION_DB_PATH is refused" "s != 0"
+check "the refusal says why" "'refusing to anonymize a production database' in open('$WORK/out').read()"
+PRODUCTION_DB_PATH="/elsewhere/prod.db,$WORK/copy.db" $BANKCTL -db "$WORK/link.db" anonymize -uploads "$WORK/uploads" > /dev/null 2>&1
+STATUS=$?
+check "a symlink to a listed database is refused" "s != 0"
+check "the refused database was left alone" "$(mentions "$WORK/copy.db" "$EMAIL") > 0"
+
+echo
+echo "Anonymization"
+BODY=$(PRODUCTION_DB_PATH="$DB_PATH" $BANKCTL -json -db "$WORK/copy.db" anonymize -uploads "$WORK/uploads")
+STATUS=$?
+check "the copy is anonymized" "s == 0 and b['customers'] > 0"
+check "the verification pass found nothing left" "b['leaks'] == []"
+check "the transaction chain was rebuilt for the changed description" "b['transactions_rehashed'] >= 2"
+check "stored files were replaced" "b['files'] >= 1"
+
+row() {
+    DB_PATH="$WORK/copy.db" sql "SELECT $1 FROM customers WHERE id = ?" "$CUSTOMER"
+}
+BODY=""
+STATUS=0
+check "names were replaced" "'$(row first_name)' != 'Rosalind' and 'Quimby' not in '$(row last_name)'"
+check "the email is a unique fake" "'$(row email)'.endswith('.$CUSTOMER@example.com')"
+check "the pending email is a fake too" "'$(row pending_email)'.endswith('.$CUSTOMER.new@example.com')"
+check "the phone was replaced" "'$(row phone)'.startswith('+1 555')"
+check "the address was replaced" "'$(row address)' != '12 Real Street, Realtown'"
+check "the date of birth moved by up to a year" \
+    "0 < abs(__import__('datetime').date.fromisoformat('$(row date_of_birth)'[:10]) - __import__('datetime').date(1980, 6, 15)).days <= 365"
+check "no original email or phone is left anywhere" "$(mentions "$WORK/copy.db" "$EMAIL" "$NEW_EMAIL" "$PHONE") == 0"
+check "the note was replaced" "'$(DB_PATH="$WORK/copy.db" sql "SELECT body FROM notes WHERE subject_id = ? AND subject_type = 'customer'" "$CUSTOMER")'.startswith('Anonymized note')"
+DOCUMENT=$(DB_PATH="$WORK/copy.db" sql "SELECT id || ' ' || filename || ' ' || storage_key || ' ' || sha256 FROM documents WHERE customer_id = ?" "$CUSTOMER")
+read -r DOC_ID DOC_NAME DOC_KEY DOC_SUM <<< "$DOCUMENT"
+check "the document name was replaced" "'$DOC_NAME' == 'document-$DOC_ID.png'"
+check "the document file is a placeholder matching its checksum" \
+    "__import__('hashlib').sha256(open('$WORK/uploads/$DOC_KEY', 'rb').read()).hexdigest() == '$DOC_SUM'"
+
+echo
+echo "Data kept"
+check "balances are unchanged" \
+    "'$(sql "SELECT group_concat(id || ':' || balance) FROM accounts")' == '$(DB_PATH="$WORK/copy.db" sql "SELECT group_concat(id || ':' || balance) FROM accounts")'"
+check "every transaction is kept" \
+    "'$(sql "SELECT count(*) || ':' || total(amount) FROM transactions")' == '$(DB_PATH="$WORK/copy.db" sql "SELECT count(*) || ':' || total(amount) FROM transactions")'"
+check "the description names the fake address" \
+    "'$(DB_PATH="$WORK/copy.db" sql "SELECT description FROM transactions WHERE account_id = ? AND transaction_type = 'deposit'" "$ACCOUNT")' == 'Refund for $(row email)'"
+$BANKCTL -db "$WORK/copy.db" reconcile > /dev/null 2>&1
+STATUS=$?
+check "the copy still reconciles" "s == 0"
+$BANKCTL -db "$WORK/again.db" anonymize -uploads "$WORK/uploads-again" > /dev/null 2>&1
+check "the same seed gives the same fakes" \
+    "'$(row email)' == '$(DB_PATH="$WORK/again.db" sql "SELECT email FROM customers WHERE id = ?" "$CUSTOMER")'"
+
+finish "anonymization"
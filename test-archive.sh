@@ -0,0 +1,141 @@
+#!/bin/bash
+
+# Transaction Archive Tests
+# Backdates postings on a run's own accounts past the hot period and runs the archive job, which moves all but
+# each account's latest posting into the archive table and keeps per-account totals. Account history, customer
+# statements, generated statements and historical balances read the archive transparently - flagged with
+# includes_archive only when their range reaches back that far - and come out the same as before archiving, as
+# does the hash chain check. A second run moves nothing more. Unarchiving a range moves it back and holds it hot
+# through the next run, and unarchiving the rest clears the account's archive totals. Postings are backdated in the
+# server's database, so DB_PATH must be the database the server uses; the platform admin is created with bankctl.
+# The job archives every tenant's old postings, not just the run's. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-archive.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-archive.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="archive-test-$RUN_ID-Aa1!"
+PLATFORM_USER="archive-platform-$RUN_ID"
+TENANT_CODE="archive$RUN_ID"
+TODAY=$(date -u +%Y-%m-%d)
+
+echo " Transaction Archive Tests"
+echo "=========================="
+
+# post ACCOUNT TYPE AMOUNT [DAY] - posts a transaction, backdated to noon on DAY if given, and prints its ID
+post() {
+    request POST "$V1/transactions" "{\"account_id\": $1, \"transaction_type\": \"$2\", \"amount\": $3}" "${AUTH[@]}"
+    local id
+    id=$(field "['transaction']['id']")
+    if [ -n "$4" ]; then
+        sql "UPDATE transactions SET effective_date = '$4 12:00:00+00:00', created_at = '$4 12:00:00+00:00', value_date = '$4 00:00:00+00:00' WHERE id = $id" > /dev/null
+    fi
+    echo "$id"
+}
+
+# hot ACCOUNT / archived ACCOUNT - count an account's postings in each table
+hot() {
+    sql "SELECT COUNT(*) FROM transactions WHERE account_id = $1"
+}
+archived() {
+    sql "SELECT COUNT(*) FROM transactions_archive WHERE account_id = $1"
+}
+
+# statement MONTH - reads the customer's statement for YYYY/MM into BODY
+statement() {
+    request GET "$V1/customers/$CUSTOMER/statements/$1" "" "${AUTH[@]}"
+}
+
+# chain - prints the parts of the account's hash chain report that archiving must not change
+chain() {
+    request GET "$V1/accounts/$ACCOUNT/verify-chain" "" "${AUTH[@]}"
+    python3 -c "
+import json, sys
+b = json.loads(sys.argv[1])
+print(b['valid'], b['checked'], (b['first_break'] or {}).get('id'))" "$BODY"
+}
+
+echo "Setup"
+tenant "$PLATFORM_USER" "Archive Bank" archive-admin
+
+request POST "$V1/customers" "{\"first_name\": \"Ada\", \"last_name\": \"Archive\", \"email\": \"ada-$RUN_ID@example.com\"}" "${AUTH[@]}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+ACCOUNT=$(field "['account']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"savings\"}" "${AUTH[@]}"
+SAVINGS=$(field "['account']['id']")
+sql "UPDATE accounts SET created_at = '2022-01-01 12:00:00+00:00' WHERE id IN ($ACCOUNT, $SAVINGS)" > /dev/null
+post "$ACCOUNT" deposit 100 2022-03-10 > /dev/null
+post "$ACCOUNT" deposit 50 2022-04-10 > /dev/null
+post "$ACCOUNT" withdrawal 30 2022-04-20 > /dev/null
+post "$ACCOUNT" deposit 20 > /dev/null
+post "$SAVINGS" deposit 10 2022-03-15 > /dev/null
+check "postings are backdated past the hot period" "'$(hot "$ACCOUNT")' == '4'"
+
+request GET "$V1/accounts/$ACCOUNT/transactions" "" "${AUTH[@]}"
+check "the history is all hot before archiving" "s == 200 and len(b['transactions']) == 4 and 'includes_archive' not in b"
+CHAIN=$(chain)
+
+echo
+echo "Archiving"
+run_job archive
+check "the archive job runs" "[r for r in b['runs'] if r['job_name'] == 'archive'][0]['status'] == 'succeeded'"
+check "old postings move to the archive table" "'$(archived "$ACCOUNT")' == '3' and '$(hot "$ACCOUNT")' == '1'"
+check "an account's latest posting stays hot however old" "'$(archived "$SAVINGS")' == '0' and '$(hot "$SAVINGS")' == '1'"
+check "the account's archive totals are kept" \
+    "'$(sql "SELECT count || ' ' || net FROM transaction_archives WHERE account_id = $ACCOUNT")' == '3 120'"
+request GET "$V1/admin/archive" "" "${PLATFORM[@]}"
+check "the status shows the archive and its batches" \
+    "s == 200 and b['after_months'] == 18 and b['archived_rows'] >= 3 and b['recent_batches'][0]['direction'] == 'archive' and b['recent_batches'][0]['actor'] == 'archive-job'"
+request GET "$V1/admin/archive" "" "${AUTH[@]}"
+check "only platform admins see it" "s == 403"
+
+echo
+echo "Reading through the archive"
+request GET "$V1/accounts/$ACCOUNT/transactions" "" "${AUTH[@]}"
+check "the full history unions the archive and says so" \
+    "s == 200 and b['includes_archive'] and [t['amount'] for t in b['transactions']] == [20, 30, 50, 100]"
+request GET "$V1/accounts/$ACCOUNT/transactions?from=2022-04-01" "" "${AUTH[@]}"
+check "a range reaching back reads the archive" "b['includes_archive'] and len(b['transactions']) == 3"
+request GET "$V1/accounts/$ACCOUNT/transactions?from=$TODAY" "" "${AUTH[@]}"
+check "a recent range reads only the hot table" "len(b['transactions']) == 1 and 'includes_archive' not in b"
+statement 2022/04
+check "an old statement is built from archived postings" \
+    "s == 200 and b['includes_archive'] and b['accounts'][0]['summary']['includes_archive'] and [l['amount'] for l in b['accounts'][0]['lines']] == [50, -30]"
+check "with its opening and closing balances unchanged" \
+    "b['accounts'][0]['summary']['opening_balance'] == 100 and b['accounts'][0]['summary']['closing_balance'] == 120"
+statement "$(date -u +%Y/%m)"
+check "a current statement opens from the archive totals without reading the archive" \
+    "s == 200 and 'includes_archive' not in b and b['accounts'][0]['summary']['opening_balance'] == 120 and b['accounts'][0]['summary']['closing_balance'] == 140"
+request POST "$V1/accounts/$ACCOUNT/statements/2022/04" "" "${AUTH[@]}"
+check "a statement generated for an archived month balances" \
+    "s == 201 and b['statement']['opening_balance'] == 100 and b['statement']['closing_balance'] == 120"
+request GET "$V1/accounts/$ACCOUNT/balance?as_of=2022-04-30" "" "${AUTH[@]}"
+check "historical balances count archived postings" "s == 200 and b['balance'] == 120 and b['last_transaction']['amount'] == -30"
+check "the hash chain check walks the archive" "'$(chain)' == '$CHAIN'"
+run_job archive
+check "a second run moves nothing more" "'$(archived "$ACCOUNT")' == '3' and '$(hot "$ACCOUNT")' == '1'"
+
+echo
+echo "Unarchiving"
+request POST "$V1/admin/archive/unarchive" "{\"account_id\": $ACCOUNT, \"from\": \"2022-04-30\", \"to\": \"2022-04-01\"}" "${PLATFORM[@]}"
+check "the range must run forwards" "s == 400"
+request POST "$V1/admin/archive/unarchive" "{\"account_id\": $ACCOUNT, \"from\": \"2022-04-01\", \"to\": \"2022-04-30\"}" "${PLATFORM[@]}"
+check "a range is unarchived" "s == 200 and b['rows'] == 2 and b['hold_until']"
+check "back into the transactions table" "'$(archived "$ACCOUNT")' == '1' and '$(hot "$ACCOUNT")' == '3'"
+check "and out of the archive totals" \
+    "'$(sql "SELECT count || ' ' || net FROM transaction_archives WHERE account_id = $ACCOUNT")' == '1 100'"
+statement 2022/04
+check "the month no longer reaches the archive" \
+    "'includes_archive' not in b and b['accounts'][0]['summary']['opening_balance'] == 100 and b['accounts'][0]['summary']['closing_balance'] == 120"
+run_job archive
+check "the unarchived range is held hot" "'$(archived "$ACCOUNT")' == '1' and '$(hot "$ACCOUNT")' == '3'"
+request POST "$V1/admin/archive/unarchive" "{\"account_id\": $ACCOUNT, \"from\": \"2000-01-01\", \"to\": \"$TODAY\"}" "${PLATFORM[@]}"
+check "the rest is unarchived" "s == 200 and b['rows'] == 1"
+check "which clears the account's archive totals" \
+    "'$(archived "$ACCOUNT")' == '0' and '$(sql "SELECT COUNT(*) FROM transaction_archives WHERE account_id = $ACCOUNT")' == '0'"
+request GET "$V1/accounts/$ACCOUNT/transactions" "" "${AUTH[@]}"
+check "and the history is hot again" "len(b['transactions']) == 4 and 'includes_archive' not in b"
+check "with the hash chain unchanged" "'$(chain)' == '$CHAIN'"
+
+finish "archive"
@@ -0,0 +1,188 @@
+#!/bin/bash
+
+# Pre-Authorization Tests
+# Places card and transfer holds on an account and checks they leave its available funds and show in the balance's
+# held_amount, with the card default of 7 days and the transfer default of 1 day. Captures in full and in part post
+# the debit and release the hold; released holds and second captures are refused. A hold past its expiry cannot be
+# captured, and the authorization-expiry job marks it expired, drops held_amount, records the account event and
+# emails the customer; authorizing again with its reference places a fresh hold. Finally captures race the expiry
+# job over holds expiring around the same moment, and every hold must end captured or expired, never both, with
+# the balance matching the captures. Each run creates its own tenant; holds are aged in the server's database, so
+# DB_PATH must be the database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-authorizations.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-authorizations.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="auth-test-$RUN_ID-Aa1!"
+PLATFORM_USER="auth-platform-$RUN_ID"
+TENANT_CODE="auth$RUN_ID"
+RACERS=12
+
+echo " Pre-Authorization Tests"
+echo "========================="
+
+# expire_at ID SECONDS - moves a hold's expiry to SECONDS from now, which may be negative
+expire_at() {
+    python3 -c "
+import datetime, sqlite3, sys
+db = sqlite3.connect(sys.argv[1], timeout=10)
+at = datetime.datetime.now(datetime.timezone.utc) + datetime.timedelta(seconds=float(sys.argv[3]))
+db.execute('UPDATE authorizations SET expires_at = ? WHERE id = ?', (at.strftime('%Y-%m-%d %H:%M:%S.%f+00:00'), int(sys.argv[2])))
+db.commit()
+" "$DB_PATH" "$1" "$2"
+}
+
+# hold AMOUNT [EXTRA_JSON] - places a card hold on ACCOUNT and stores its ID in HOLD
+hold() {
+    request POST "$V1/accounts/$ACCOUNT/authorizations" "{\"amount\": $1, \"channel\": \"card\", \"merchant\": \"Hotel $RUN_ID\"${2:+, $2}}" "${AUTH[@]}"
+    HOLD=$(field "['authorization']['id']" 2>/dev/null)
+}
+
+# balance - reads ACCOUNT's balance into BODY
+balance() {
+    request GET "$V1/accounts/$ACCOUNT/balance" "" "${AUTH[@]}"
+}
+
+echo "Setup"
+tenant "$PLATFORM_USER" "Authorizations" auth-admin
+
+EMAIL="holder-$RUN_ID@example.com"
+request POST "$V1/customers" "{\"first_name\": \"Card\", \"last_name\": \"Holder\", \"email\": \"$EMAIL\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+HOLDER=$(field "['customer']['id']")
+sql "UPDATE customers SET email_verified = 1 WHERE id = $HOLDER" > /dev/null
+request POST "$V1/accounts" "{\"customer_id\": $HOLDER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+ACCOUNT=$(field "['account']['id']")
+request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 500}" "${AUTH[@]}"
+request POST "$V1/accounts" "{\"customer_id\": $HOLDER, \"account_type\": \"savings\"}" "${AUTH[@]}"
+SAVINGS=$(field "['account']['id']")
+check "the holder has a funded checking account and a savings account" "s == 201"
+CUSTOMER_AUTH=(-H "Authorization: Bearer $(customer_user "auth-customer" "$HOLDER" "$TENANT_CODE")")
+
+echo
+echo "Holds"
+hold 100 "\"reference\": \"stay-$RUN_ID\""
+CARD=$HOLD
+check "a card hold is placed" "s == 201 and b['authorization']['status'] == 'pending' and b['authorization']['amount'] == 100"
+check "card holds expire in 7 days" "$(python3 -c "
+import datetime
+print(round((datetime.datetime.fromisoformat('$(field "['authorization']['expires_at']")'.replace('Z', '+00:00')[:26] + '+00:00') - datetime.datetime.now(datetime.timezone.utc)).total_seconds() / 3600))") == 168"
+request POST "$V1/accounts/$ACCOUNT/authorizations" "{\"amount\": 50, \"channel\": \"transfer\", \"to_account_id\": $SAVINGS, \"merchant\": \"Rent share\"}" "${AUTH[@]}"
+TRANSFER=$(field "['authorization']['id']")
+check "a transfer hold is placed" "s == 201 and b['authorization']['to_account_id'] == $SAVINGS"
+check "transfer holds expire in 1 day" "$(python3 -c "
+import datetime
+print(round((datetime.datetime.fromisoformat('$(field "['authorization']['expires_at']")'.replace('Z', '+00:00')[:26] + '+00:00') - datetime.datetime.now(datetime.timezone.utc)).total_seconds() / 3600))") == 24"
+balance
+check "the balance shows both holds in held_amount" "b['balance'] == 500 and b['held_amount'] == 150"
+
+hold 100 "\"reference\": \"stay-$RUN_ID\""
+check "repeating a pending hold's reference returns it" "s == 200 and b['authorization']['id'] == $CARD"
+hold 400
+check "a hold beyond the available funds is refused" "s == 400 and b['error'] == 'Insufficient balance or invalid account status'"
+request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"withdrawal\", \"amount\": 400}" "${AUTH[@]}"
+check "a withdrawal into the held amount is refused" "s == 400 and b['error'] == 'Insufficient balance or invalid account status'"
+request POST "$V1/accounts/$ACCOUNT/authorizations" "{\"amount\": 10, \"channel\": \"cheque\"}" "${AUTH[@]}"
+check "an unknown channel is refused" "s == 400 and b['error'] == 'channel must be card or transfer'"
+request POST "$V1/accounts/$ACCOUNT/authorizations" "{\"amount\": 10, \"channel\": \"card\"}" "${CUSTOMER_AUTH[@]}"
+check "customers cannot place holds" "s == 403"
+request POST "$V1/accounts/$ACCOUNT/authorizations" "{\"amount\": 10, \"channel\": \"card\"}"
+check "placing a hold needs authentication" "s == 401"
+
+echo
+echo "Capture"
+request POST "$V1/authorizations/$CARD/capture" '{"amount": 120}' "${AUTH[@]}"
+check "a capture above the hold is refused" "s == 400 and b['code'] == 'CAPTURE_EXCEEDS_AUTHORIZATION'"
+request POST "$V1/authorizations/$CARD/capture" '{"amount": 60}' "${AUTH[@]}"
+check "a partial capture posts a card withdrawal" "s == 200 and b['authorization']['status'] == 'captured' and b['authorization']['captured_amount'] == 60 and b['transaction']['amount'] == 60 and b['transaction']['channel'] == 'card'"
+balance
+check "the rest of the hold is released with it" "b['balance'] == 440 and b['held_amount'] == 50"
+request POST "$V1/authorizations/$CARD/capture" '{}' "${AUTH[@]}"
+check "a captured hold cannot be captured again" "s == 409 and b['code'] == 'AUTHORIZATION_CLOSED'"
+request POST "$V1/authorizations/$TRANSFER/capture" '{}' "${AUTH[@]}"
+check "capturing a transfer hold posts the transfer in full" "s == 200 and b['transfer']['amount'] == 50 and b['authorization']['transfer_id'] == b['transfer']['id']"
+request GET "$V1/accounts/$SAVINGS/balance" "" "${AUTH[@]}"
+check "the destination is credited" "b['balance'] == 50 and b['held_amount'] == 0"
+
+hold 30
+request POST "$V1/authorizations/$HOLD/release" "" "${AUTH[@]}"
+check "a pending hold is released" "s == 200 and b['authorization']['status'] == 'released' and b['authorization']['closed_by'] == 'auth-admin'"
+balance
+check "releasing drops held_amount at once" "b['balance'] == 390 and b['held_amount'] == 0"
+request POST "$V1/authorizations/$HOLD/capture" '{}' "${AUTH[@]}"
+check "a released hold cannot be captured" "s == 409 and b['code'] == 'AUTHORIZATION_CLOSED'"
+request POST "$V1/authorizations/$HOLD/release" "" "${AUTH[@]}"
+check "nor released twice" "s == 409 and b['code'] == 'AUTHORIZATION_CLOSED'"
+
+echo
+echo "Expiry"
+hold 80 "\"reference\": \"car-$RUN_ID\""
+STALE=$HOLD
+expire_at "$STALE" -60
+request POST "$V1/authorizations/$STALE/capture" '{}' "${AUTH[@]}"
+check "a hold past its expiry cannot be captured" "s == 409 and b['code'] == 'AUTHORIZATION_EXPIRED'"
+balance
+check "it stays held until the job releases it" "b['held_amount'] == 80"
+run_job authorization-expiry
+check "the authorization-expiry job succeeds" "[r for r in b['runs'] if r['job_name'] == 'authorization-expiry'][0]['status'] == 'succeeded'"
+request GET "$V1/authorizations/$STALE" "" "${AUTH[@]}"
+check "the hold is marked expired by the system" "b['authorization']['status'] == 'expired' and b['authorization']['closed_by'] == 'system'"
+balance
+check "held_amount drops once it expires" "b['balance'] == 390 and b['held_amount'] == 0"
+check "the expiry is recorded as an account event" "$(sql "SELECT COUNT(*) FROM outbox_events WHERE event_type = 'account.authorization_expired' AND aggregate_id = $ACCOUNT") == 1"
+check "the customer is emailed" "'$(sql "SELECT recipient FROM notifications WHERE resource_type = 'authorization' AND resource_id = $STALE")' == '$EMAIL'"
+request POST "$V1/authorizations/$STALE/capture" '{}' "${AUTH[@]}"
+check "an expired hold still answers AUTHORIZATION_EXPIRED" "s == 409 and b['code'] == 'AUTHORIZATION_EXPIRED'"
+hold 80 "\"reference\": \"car-$RUN_ID\""
+check "authorizing again places a fresh hold" "s == 201 and b['authorization']['id'] != $STALE and b['authorization']['previous_id'] == $STALE and b['authorization']['status'] == 'pending'"
+request POST "$V1/authorizations/$HOLD/capture" '{}' "${AUTH[@]}"
+check "the fresh hold captures" "s == 200 and b['authorization']['status'] == 'captured'"
+request GET "$V1/authorizations/$STALE" "" "${AUTH[@]}"
+check "the old hold stays expired" "'transaction_id' not in b['authorization'] and b['authorization']['status'] == 'expired'"
+
+echo
+echo "Capture racing expiry"
+balance
+BEFORE=$(field "['balance']")
+RACE=()
+for _ in $(seq 1 $RACERS); do
+    hold 10
+    RACE+=("$HOLD")
+done
+# Expiries spread a little either side of the moment the captures and the job start together
+python3 -c "
+import datetime, sqlite3, sys, time
+db = sqlite3.connect(sys.argv[1], timeout=10)
+start = datetime.datetime.now(datetime.timezone.utc) + datetime.timedelta(seconds=2)
+ids = sys.argv[2:]
+for i, id in enumerate(ids):
+    at = start + datetime.timedelta(seconds=(i - len(ids) / 2) * 0.04)
+    db.execute('UPDATE authorizations SET expires_at = ? WHERE id = ?', (at.strftime('%Y-%m-%d %H:%M:%S.%f+00:00'), int(id)))
+db.commit()
+time.sleep(max(0, (start - datetime.datetime.now(datetime.timezone.utc)).total_seconds()))
+" "$DB_PATH" "${RACE[@]}"
+for id in "${RACE[@]}"; do
+    curl -s -o /dev/null -w '%{http_code}' -X POST "$V1/authorizations/$id/capture" -H "Content-Type: application/json" -d '{}' "${AUTH[@]}" > "$WORK/$id" &
+done
+run_job authorization-expiry &
+wait
+run_job authorization-expiry
+CAPTURED=0
+CONSISTENT=1
+for id in "${RACE[@]}"; do
+    status=$(sql "SELECT status FROM authorizations WHERE id = $id")
+    code=$(cat "$WORK/$id")
+    posted=$(sql "SELECT COUNT(*) FROM transactions WHERE id = (SELECT transaction_id FROM authorizations WHERE id = $id)")
+    case "$status:$code:$posted" in
+        captured:200:1) CAPTURED=$((CAPTURED + 1)) ;;
+        expired:409:0) ;;
+        *) CONSISTENT=0; echo "    hold $id ended $status after capture answered $code with $posted postings" ;;
+    esac
+done
+echo "    $CAPTURED of $RACERS captured before expiring"
+check "every racing hold ends captured with its posting or expired without one" "$CONSISTENT == 1"
+balance
+check "the balance matches the captures and nothing stays held" "round(b['balance'], 2) == round($BEFORE - 10 * $CAPTURED, 2) and b['held_amount'] == 0"
+check "each expired hold has one event" "$(sql "SELECT COUNT(*) FROM outbox_events WHERE event_type = 'account.authorization_expired' AND aggregate_id = $ACCOUNT") == 1 + $RACERS - $CAPTURED"
+
+finish "pre-authorization"
@@ -0,0 +1,148 @@
+#!/bin/bash
+
+# Balance Cache Tests
+# Starts its own server and checks the balance cache. Deposits, transfers, reversals and the maintenance-fees job
+# write the new balance through as they commit, so the next read is a cache hit showing it. Parallel deposits and
+# transfers into one account are interleaved with readers polling its balance: no reader ever sees the balance go
+# back, so a read that started before a posting never caches the balance from before it, and the cache ends equal
+# to the database. The server is then restarted checking every hit against the database: a balance changed behind
+# the cache's back is logged as drift, counted and repaired. Hit, miss and drift counts are read from /metrics.
+# The server and database are the script's own, so no other server is needed. Exits non-zero on failure.
+#
+# Usage: ./test-balance-cache.sh                          (builds the server with go build)
+#        SERVER_BIN=./banking-app BANKCTL=./bankctl PORT=18096 READERS=4 WRITERS=60 ./test-balance-cache.sh
+
+source "$(dirname "$0")/test-lib.sh"
+serve 18096
+READERS="${READERS:-4}"
+WRITERS="${WRITERS:-60}"
+PASSWORD="cache-test-$RUN_ID-Aa1!"
+
+echo " Balance Cache Tests"
+echo "===================="
+
+# metric NAME - prints a metric's current value, or 0
+metric() {
+    curl -s "$BASE_URL/metrics" | python3 -c "
+import sys
+print(next((int(float(l.split()[-1])) for l in sys.stdin if l.startswith(sys.argv[1] + ' ')), 0))" "$1"
+}
+
+# balance ACCOUNT - reads an account's balance into BODY and STATUS
+balance() {
+    request GET "$V1/accounts/$1/balance" "" "${ADMIN[@]}"
+}
+
+# deposit ACCOUNT AMOUNT - posts a deposit
+deposit() {
+    request POST "$V1/transactions" "{\"account_id\": $1, \"transaction_type\": \"deposit\", \"amount\": $2}" "${ADMIN[@]}"
+}
+
+build
+
+echo "Setup"
+# The sample rate is the share of cache hits checked against the database
+start_server "$SERVER_BIN" BALANCE_CACHE_SAMPLE_RATE=0
+ADMIN=(-H "Authorization: Bearer $(staff "cache-admin")")
+request PUT "$V1/admin/concurrency" '{"global": 0, "writes": 0, "retry_after_seconds": 1}' "${ADMIN[@]}"
+check "the write cap is lifted so every parallel posting reaches the database" "s == 200"
+request POST "$V1/customers" "{\"first_name\": \"Cache\", \"last_name\": \"Holder\", \"email\": \"cache-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${ADMIN[@]}"
+HOLDER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $HOLDER, \"account_type\": \"checking\"}" "${ADMIN[@]}"
+SHARED=$(field "['account']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $HOLDER, \"account_type\": \"savings\"}" "${ADMIN[@]}"
+SOURCE=$(field "['account']['id']")
+deposit "$SHARED" 100
+deposit "$SOURCE" 1000
+
+echo
+echo "Write-through"
+balance "$SHARED"
+check "a balance is read" "s == 200 and b['balance'] == 100"
+MISSES=$(metric 'balance_cache_requests_total{result="miss"}')
+HITS=$(metric 'balance_cache_requests_total{result="hit"}')
+balance "$SHARED"
+check "and then served from the cache" "b['balance'] == 100 and $(metric 'balance_cache_requests_total{result="hit"}') == $HITS + 1"
+deposit "$SHARED" 25
+TRANSACTION=$(field "['transaction']['id']")
+balance "$SHARED"
+check "a deposit is written through, so the next read is a hit with the new balance" "b['balance'] == 125"
+request POST "$V1/transfers" "{\"from_account_id\": $SOURCE, \"to_account_id\": $SHARED, \"amount\": 50}" "${ADMIN[@]}"
+balance "$SHARED"
+check "so is a transfer, for the account credited" "b['balance'] == 175"
+balance "$SOURCE"
+check "and the account debited" "b['balance'] == 950"
+request POST "$V1/transactions/$TRANSACTION/reverse" "{\"reason\": \"Cache test\"}" "${ADMIN[@]}"
+balance "$SHARED"
+check "and a reversal" "b['balance'] == 150"
+check "none of those reads missed the cache" "$(metric 'balance_cache_requests_total{result="miss"}') == $MISSES"
+
+echo
+echo "Write-through from a job"
+request POST "$V1/admin/products" "{\"account_type\": \"checking\", \"name\": \"Fee Checking\", \"monthly_fee\": 5}" "${ADMIN[@]}"
+check "checking is given a monthly maintenance fee" "s == 201"
+LAST_MONTH=$(python3 -c "import datetime; d = datetime.date.today().replace(day=1) - datetime.timedelta(days=1); print(d.replace(day=1))")
+sql "UPDATE accounts SET created_at = '$LAST_MONTH 09:00:00+00:00' WHERE id = $SHARED" > /dev/null
+balance "$SHARED"
+MISSES=$(metric 'balance_cache_requests_total{result="miss"}')
+request POST "$V1/admin/jobs/maintenance-fees/run" "" "${ADMIN[@]}"
+for _ in $(seq 1 50); do
+    sleep 0.1
+    [ "$(sql "SELECT COUNT(*) FROM transactions WHERE account_id = $SHARED AND transaction_type = 'fee'")" = "1" ] && break
+done
+balance "$SHARED"
+check "last month's maintenance fee shows at once, within the cache lifetime" "b['balance'] == 145"
+check "from the cache" "$(metric 'balance_cache_requests_total{result="miss"}') == $MISSES"
+
+echo
+echo "$WRITERS deposits and $WRITERS transfers into one account with $READERS readers polling it"
+START=$(sql "SELECT balance FROM accounts WHERE id = $SHARED")
+PIDS=()
+for r in $(seq "$READERS"); do
+    (for _ in $(seq 1 $((WRITERS * 2))); do
+        curl -s "${ADMIN[@]}" "$V1/accounts/$SHARED/balance"
+        echo
+    done > "$WORK/reader-$r") &
+    PIDS+=($!)
+done
+seq "$WRITERS" | xargs -P 20 -I{} curl -s -o /dev/null -w "%{http_code}\n" "${ADMIN[@]}" \
+    -X POST "$V1/transactions" -H "Content-Type: application/json" \
+    -d "{\"account_id\": $SHARED, \"transaction_type\": \"deposit\", \"amount\": 1}" > "$WORK/deposits" &
+PIDS+=($!)
+seq "$WRITERS" | xargs -P 20 -I{} curl -s -o /dev/null -w "%{http_code}\n" "${ADMIN[@]}" \
+    -X POST "$V1/transfers" -H "Content-Type: application/json" \
+    -d "{\"from_account_id\": $SOURCE, \"to_account_id\": $SHARED, \"amount\": 1, \"reference\": \"cache-{}\", \"confirm_duplicate\": true}" > "$WORK/transfers" &
+PIDS+=($!)
+wait "${PIDS[@]}"
+STATUS=200 BODY= check "every deposit and transfer posted" \
+    "open('$WORK/deposits').read().split() == ['201'] * $WRITERS and open('$WORK/transfers').read().split() == ['201'] * $WRITERS"
+SEEN=$(python3 -c "
+import json, sys
+runs = []
+for r in range(1, $READERS + 1):
+    runs.append([json.loads(l)['balance'] for l in open('$WORK/reader-%d' % r) if l.strip()])
+print(json.dumps({'reads': sum(len(x) for x in runs), 'backwards': sum(1 for x in runs for a, b in zip(x, x[1:]) if b < a), 'max': max(max(x) for x in runs)}))")
+STATUS=200 BODY="$SEEN" check "every poll was answered" "b['reads'] == $READERS * $WRITERS * 2"
+STATUS=200 BODY="$SEEN" check "no reader ever saw the balance go back" "b['backwards'] == 0"
+balance "$SHARED"
+check "the cached balance ends with every posting" "b['balance'] == $START + 2 * $WRITERS"
+check "equal to the database" "b['balance'] == $(sql "SELECT balance FROM accounts WHERE id = $SHARED")"
+check "hits are counted in the hit ratio" "$(curl -s "$BASE_URL/metrics" | grep '^balance_cache_hit_ratio ' | cut -d' ' -f2) > 0.5"
+
+echo
+echo "Consistency check"
+stop_server
+start_server "$SERVER_BIN" BALANCE_CACHE_SAMPLE_RATE=1
+ADMIN=(-H "Authorization: Bearer $(token "cache-admin")")
+balance "$SHARED"
+CACHED=$(field "['balance']")
+sql "UPDATE accounts SET balance = balance + 1000 WHERE id = $SHARED" > /dev/null
+balance "$SHARED"
+check "with every hit sampled, a balance changed behind the cache is read from the database" "b['balance'] == $CACHED + 1000"
+check "the drift is counted" "$(metric balance_cache_drift_total) == 1"
+check "and logged" "'balance of account $SHARED drifted: cached %.2f' % $CACHED in open('$WORK/server.log').read()"
+balance "$SHARED"
+check "the entry is repaired, so the next hit agrees with the database" "b['balance'] == $CACHED + 1000 and $(metric balance_cache_drift_total) == 1"
+sql "UPDATE accounts SET balance = balance - 1000 WHERE id = $SHARED" > /dev/null
+
+finish "balance cache"
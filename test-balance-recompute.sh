@@ -0,0 +1,106 @@
+#!/bin/bash
+
+# Balance Recompute Tests
+# Recomputes a run's own account balances from their postings: an untouched account matches with its latest posting,
+# an account whose stored balance is corrupted in the database reports the drift, and unknown ids carry an error
+# without failing the rest. More than 1,000 ids queue a recompute_balances bulk operation whose drifted items carry
+# the amount. Non-admins are refused, and an administrator's seventh request within a minute is rate limited, so the
+# server must run with the default RECOMPUTE_RATE_PER_MINUTE. Balances are corrupted in the server's database, so
+# DB_PATH must be the database the server uses; the platform admin is created with bankctl. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-balance-recompute.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-balance-recompute.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="recompute-test-$RUN_ID-Aa1!"
+PLATFORM_USER="recompute-platform-$RUN_ID"
+TENANT_CODE="recompute$RUN_ID"
+
+echo " Balance Recompute Tests"
+echo "========================"
+
+# item ID - prints the result for one account from the last recompute body
+item() {
+    python3 -c "
+import json, sys
+print(json.dumps([a for a in json.loads(sys.argv[1])['accounts'] if a['account_id'] == int(sys.argv[2])][0]))" "$BODY" "$1"
+}
+
+# recompute IDS... - recomputes the listed accounts as the tenant admin
+recompute() {
+    local ids
+    ids=$(IFS=,; echo "$*")
+    request POST "$V1/admin/accounts/recompute-balances" "{\"account_ids\": [$ids]}" "${AUTH[@]}"
+}
+
+echo "Setup"
+tenant "$PLATFORM_USER" "Recompute Bank" recompute-admin
+
+request POST "$V1/customers" "{\"first_name\": \"Rey\", \"last_name\": \"Recompute\", \"email\": \"rey-$RUN_ID@example.com\"}" "${AUTH[@]}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+GOOD=$(field "['account']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"savings\"}" "${AUTH[@]}"
+BAD=$(field "['account']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"savings\"}" "${AUTH[@]}"
+EMPTY=$(field "['account']['id']")
+request POST "$V1/transactions" "{\"account_id\": $GOOD, \"transaction_type\": \"deposit\", \"amount\": 120}" "${AUTH[@]}"
+request POST "$V1/transactions" "{\"account_id\": $GOOD, \"transaction_type\": \"withdrawal\", \"amount\": 20.5}" "${AUTH[@]}"
+LAST=$(field "['transaction']['id']")
+request POST "$V1/transactions" "{\"account_id\": $BAD, \"transaction_type\": \"deposit\", \"amount\": 40}" "${AUTH[@]}"
+sql "UPDATE accounts SET balance = 47.25 WHERE id = $BAD" > /dev/null
+UNKNOWN=$((BAD + 1000000))
+
+echo
+echo "Synchronous recompute"
+recompute "$GOOD" "$BAD" "$EMPTY" "$UNKNOWN" "$GOOD"
+check "a list within the limit is recomputed at once" "s == 200 and b['total'] == 4"
+check "repeated ids are recomputed once" "[a['account_id'] for a in b['accounts']] == [$GOOD, $BAD, $EMPTY, $UNKNOWN]"
+check "the counts add up" "b['matched'] == 2 and b['drifted'] == 1 and b['failed'] == 1"
+BODY=$(item "$GOOD")
+check "an untouched balance matches its postings" "b['match'] and b['drift'] == 0 and b['balance'] == 99.5 and b['ledger_balance'] == 99.5"
+check "the latest posting is reported" "b['transaction_count'] == 2 and b['last_transaction']['id'] == $LAST and b['last_transaction']['balance_after'] == 99.5"
+recompute "$GOOD" "$BAD" "$EMPTY" "$UNKNOWN"
+BODY=$(item "$BAD")
+check "a corrupted balance reports its drift" "not b['match'] and b['drift'] == 7.25 and b['balance'] == 47.25 and b['ledger_balance'] == 40"
+recompute "$GOOD" "$BAD" "$EMPTY" "$UNKNOWN"
+BODY=$(item "$EMPTY")
+check "an account without postings matches with no last transaction" "b['match'] and b['transaction_count'] == 0 and b['last_transaction'] is None"
+recompute "$GOOD" "$BAD" "$EMPTY" "$UNKNOWN"
+BODY=$(item "$UNKNOWN")
+check "an unknown account carries an error" "not b['match'] and b['error'] == 'account not found'"
+request POST "$V1/admin/accounts/recompute-balances" "{\"account_ids\": []}" "${AUTH[@]}"
+check "an empty list is rejected" "s == 400"
+request GET "$V1/accounts/$BAD" "" "${AUTH[@]}"
+check "recomputing changes no balance" "b['balance'] == 47.25"
+
+echo
+echo "Asynchronous recompute"
+IDS=$(python3 -c "print(','.join(str(i) for i in [$GOOD, $BAD] + list(range($UNKNOWN, $UNKNOWN + 1000))))")
+request POST "$V1/admin/accounts/recompute-balances" "{\"account_ids\": [$IDS]}" "${AUTH[@]}"
+check "more than 1,000 accounts queue a bulk operation" "s == 202 and b['async'] and b['operation']['action'] == 'recompute_balances' and b['operation']['status'] == 'queued'"
+OPERATION=$(field "['operation']['id']")
+for _ in $(seq 1 50); do
+    sleep 0.2
+    request GET "$V1/admin/bulk-operations/$OPERATION" "" "${AUTH[@]}"
+    [ "$(field "['operation']['status']")" = completed ] && break
+done
+check "the operation recomputes the accounts that exist" "b['operation']['status'] == 'completed' and b['operation']['total'] == 2"
+request GET "$V1/admin/bulk-operations/$OPERATION?result=failed" "" "${AUTH[@]}"
+check "a drifted account fails with the amount" "b['total'] == 1 and b['items'][0]['account_id'] == $BAD and b['items'][0]['drift'] == 7.25"
+request GET "$V1/admin/bulk-operations/$OPERATION?result=succeeded" "" "${AUTH[@]}"
+check "a matching account succeeds" "b['total'] == 1 and b['items'][0]['account_id'] == $GOOD and 'drift' not in b['items'][0]"
+
+echo
+echo "Access and rate limit"
+TELLER=(-H "Authorization: Bearer $(staff "recompute-teller-$RUN_ID" teller)")
+request POST "$V1/admin/accounts/recompute-balances" "{\"account_ids\": [1]}" "${TELLER[@]}"
+check "non-admins are refused" "s == 403"
+recompute "$GOOD"
+check "the sixth request in a minute is allowed" "s == 200"
+recompute "$GOOD"
+check "the seventh is rate limited" "s == 429 and b['code'] == 'RATE_LIMITED' and 0 < b['retry_after_seconds'] <= 60"
+request POST "$V1/admin/accounts/recompute-balances" "{\"account_ids\": [$GOOD]}" "${PLATFORM[@]}"
+check "the limit is kept per administrator" "s == 200"
+
+finish "balance recompute"
@@ -0,0 +1,138 @@
+#!/bin/bash
+
+# Atomic Batch Tests
+# Checks that only staff with the batch permission post batches, that a batch needs 2 to 20 legs whose debits and
+# credits balance, that every invalid leg is reported with the code a single posting would get, and that a batch
+# posts all its legs with a shared reference or, when one leg cannot post, none of them. Covers general-ledger legs,
+# legs in another currency with and without an FX rate and the international alerts they fire, restricted accounts
+# and liens. An admin and a customer user
+# are created with bankctl against the server's database, so DB_PATH must be the database the server uses. Exits
+# non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-batch.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-batch.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="batch-test-$RUN_ID"
+
+echo " Atomic Batch Tests"
+echo "==================="
+
+# account TYPE [CURRENCY] - opens an account for the run's customer and prints its ID
+account() {
+    request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"$1\", \"currency\": \"${2:-USD}\"}" "${ADMIN[@]}"
+    field "['account']['id']"
+}
+
+# batch LEGS [FIELDS] - posts a batch of legs as the admin
+batch() {
+    request POST "$V1/transactions/batch-atomic" "{\"legs\": [$1]${2:+, $2}}" "${ADMIN[@]}"
+}
+
+# balances - prints the run's account balances as "A B C E"
+balances() {
+    sql "SELECT group_concat(balance, ' ') FROM (SELECT balance FROM accounts WHERE id IN ($A, $B, $C, $E) ORDER BY id)"
+}
+
+# international ACCOUNT THRESHOLD - adds an international alert rule to ACCOUNT and prints its ID
+international() {
+    request POST "$V1/accounts/$1/alerts" "{\"rule_type\": \"international\", \"threshold\": $2, \"channel\": \"email\", \"target\": \"alerts@example.com\"}" "${ADMIN[@]}"
+    field "['alert']['id']"
+}
+
+# firings ACCOUNT RULE - prints the messages of an alert rule's firings, newest first, as a Python list
+firings() {
+    request GET "$V1/accounts/$1/alerts/$2/firings?limit=100" "" "${ADMIN[@]}"
+    python3 -c "import json, sys; print([f['message'] for f in json.loads(sys.argv[1])['firings']])" "$BODY"
+}
+
+# fee_income - prints the default tenant's USD fee income balance
+fee_income() {
+    sql "SELECT COALESCE(MAX(balance), 0) FROM accounts WHERE account_number = 'GL-1-FEE_INCOME-USD'"
+}
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "batch-admin-$RUN_ID")")
+request POST "$V1/customers" "{\"first_name\": \"Bea\", \"last_name\": \"Batch\", \"email\": \"batch-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\", \"monthly_income\": 10000}" "${ADMIN[@]}"
+CUSTOMER=$(field "['customer']['id']")
+CUSTOMER_AUTH=(-H "Authorization: Bearer $(customer_user "batch-customer-$RUN_ID" "$CUSTOMER")")
+A=$(account checking)
+B=$(account checking)
+C=$(account savings)
+E=$(account savings EUR)
+request POST "$V1/transactions" "{\"account_id\": $A, \"transaction_type\": \"deposit\", \"amount\": 1000}" "${ADMIN[@]}"
+check "the paying account is funded" "s == 201"
+
+echo
+echo "Validation"
+request POST "$V1/transactions/batch-atomic" "{\"legs\": [{\"account_id\": $A, \"direction\": \"debit\", \"amount\": 1}, {\"account_id\": $B, \"direction\": \"credit\", \"amount\": 1}]}" "${CUSTOMER_AUTH[@]}"
+check "customers cannot post batches" "s == 403"
+batch "{\"account_id\": $A, \"direction\": \"debit\", \"amount\": 1}"
+check "a batch needs at least two legs" "s == 400 and b['code'] == 'INVALID_BATCH'"
+batch "$(python3 -c "print(', '.join(['{\"account_id\": $B, \"direction\": \"credit\", \"amount\": 1}'] * 21))")"
+check "and at most twenty" "s == 400 and b['code'] == 'INVALID_BATCH'"
+batch "{\"account_id\": $A, \"direction\": \"debit\", \"amount\": 100}, {\"account_id\": $B, \"direction\": \"credit\", \"amount\": 99}"
+check "debits and credits must balance" "s == 400 and b['code'] == 'BATCH_UNBALANCED'"
+batch "{\"account_id\": $A, \"direction\": \"debit\", \"amount\": 10}, {\"account_id\": $B, \"direction\": \"credit\", \"amount\": 5, \"transaction_type\": \"payment\"}, {\"gl_code\": \"NOPE\", \"direction\": \"credit\", \"amount\": 5}, {\"direction\": \"sideways\", \"account_id\": $C, \"amount\": 1}"
+check "every malformed leg is reported" "s == 400 and [(l['leg'], l['code']) for l in b['legs']] == [(1, 'INVALID_TRANSACTION_TYPE'), (2, 'INVALID_LEG'), (3, 'INVALID_TRANSACTION_TYPE')]"
+batch "{\"account_id\": $A, \"direction\": \"debit\", \"amount\": 10}, {\"account_id\": 999999999, \"direction\": \"credit\", \"amount\": 10}, {\"account_id\": $B, \"direction\": \"credit\", \"amount\": 0}"
+check "unknown accounts and zero amounts are reported per leg" "s == 400 and [(l['leg'], l['code']) for l in b['legs']] == [(1, 'ACCOUNT_NOT_FOUND'), (2, 'ZERO_AMOUNT')]"
+check "nothing posted" "'$(balances)' == '1000 0 0 0'"
+
+echo
+echo "Posting"
+FEES_BEFORE=$(fee_income)
+batch "{\"account_id\": $A, \"direction\": \"debit\", \"amount\": 100, \"transaction_type\": \"payment\", \"description\": \"Invoice $RUN_ID\"},
+       {\"account_id\": $B, \"direction\": \"credit\", \"amount\": 90},
+       {\"gl_code\": \"FEE_INCOME\", \"direction\": \"credit\", \"amount\": 3},
+       {\"account_id\": $C, \"direction\": \"credit\", \"amount\": 7, \"description\": \"Tax withheld\"}" "\"reference\": \"INV-$RUN_ID\""
+check "a balanced batch posts" "s == 201 and len(b['transactions']) == 4"
+check "legs post in order with their types" "[t['transaction_type'] for t in b['transactions']] == ['payment', 'deposit', 'deposit', 'deposit']"
+check "every leg shares the reference" "{t['reference'] for t in b['transactions']} == {'INV-$RUN_ID'}"
+check "every balance moved" "'$(balances)' == '900 90 7 0'"
+check "the general-ledger leg posted" "round($(fee_income) - $FEES_BEFORE, 2) == 3"
+batch "{\"account_id\": $A, \"direction\": \"debit\", \"amount\": 1}, {\"account_id\": $B, \"direction\": \"credit\", \"amount\": 1}"
+check "a reference is generated when left out" "s == 201 and b['reference'].startswith('BATCH') and b['transactions'][1]['reference'] == b['reference']"
+
+echo
+echo "All or nothing"
+batch "{\"account_id\": $A, \"direction\": \"debit\", \"amount\": 500}, {\"account_id\": $B, \"direction\": \"credit\", \"amount\": 500},
+       {\"account_id\": $A, \"direction\": \"debit\", \"amount\": 600}, {\"account_id\": $C, \"direction\": \"credit\", \"amount\": 600}" "\"reference\": \"FAIL-$RUN_ID\""
+check "a leg the earlier legs leave unfunded fails the batch" "s == 400 and b['code'] == 'INVALID_BATCH' and b['legs'] == [{'leg': 2, 'code': 'INSUFFICIENT_FUNDS', 'error': b['legs'][0]['error']}]"
+check "the legs before it are rolled back" "'$(balances)' == '899 91 7 0'"
+check "no posting carries its reference" "$(sql "SELECT COUNT(*) FROM transactions WHERE reference = 'FAIL-$RUN_ID'") == 0"
+
+echo
+echo "Currencies"
+DOMESTIC_ALERT=$(international "$A" 0)
+FOREIGN_ALERT=$(international "$E" 50)
+batch "{\"account_id\": $A, \"direction\": \"debit\", \"amount\": 108.25}, {\"account_id\": $E, \"direction\": \"credit\", \"amount\": 100}"
+check "a leg in another currency needs a rate" "s == 400 and b['legs'][0]['leg'] == 1 and b['legs'][0]['code'] == 'CURRENCY_MISMATCH'"
+batch "{\"account_id\": $A, \"direction\": \"debit\", \"amount\": 108.25, \"fx\": {\"rate\": 1}}, {\"account_id\": $E, \"direction\": \"credit\", \"amount\": 100, \"fx\": {\"rate\": 1.0825}}"
+check "a leg in the batch currency takes no rate" "s == 400 and [(l['leg'], l['code']) for l in b['legs']] == [(0, 'INVALID_FX_RATE')]"
+batch "{\"account_id\": $A, \"direction\": \"debit\", \"amount\": 108.25}, {\"account_id\": $E, \"direction\": \"credit\", \"amount\": 100, \"fx\": {\"rate\": 1.0825}}"
+check "with a rate it balances in the batch currency" "s == 201 and b['transactions'][1]['amount'] == 100"
+check "each account moves in its own currency" "'$(balances)' == '790.75 91 7 100'"
+check "the converted leg fires an international alert" "$(firings "$E" "$FOREIGN_ALERT") == ['A deposit of 100.00 EUR was posted to account $(sql "SELECT account_number FROM accounts WHERE id = $E"), converted at an exchange rate of 1.0825']"
+check "the leg in the base currency does not" "$(firings "$A" "$DOMESTIC_ALERT") == []"
+request POST "$V1/transactions" "{\"account_id\": $E, \"transaction_type\": \"deposit\", \"amount\": 20}" "${ADMIN[@]}"
+check "a foreign-currency posting under the threshold does not alert" "s == 201 and len($(firings "$E" "$FOREIGN_ALERT")) == 1"
+request POST "$V1/transactions" "{\"account_id\": $E, \"transaction_type\": \"withdrawal\", \"amount\": 60}" "${ADMIN[@]}"
+check "one at the threshold or over does" "s == 201 and $(firings "$E" "$FOREIGN_ALERT")[0].startswith('A withdrawal of 60.00 EUR in a foreign currency')"
+
+echo
+echo "Restrictions and liens"
+request PUT "$V1/accounts/$C/restrictions" '{"deposit_only": true, "reason": "Batch test"}' "${ADMIN[@]}"
+batch "{\"account_id\": $C, \"direction\": \"debit\", \"amount\": 5}, {\"account_id\": $B, \"direction\": \"credit\", \"amount\": 5}"
+check "a deposit-only account refuses a debit leg" "s == 400 and b['legs'][0]['code'] == 'ACCOUNT_DEPOSIT_ONLY'"
+request POST "$V1/accounts/$A/liens" "{\"claimant\": \"County Court\", \"legal_reference\": \"CC-$RUN_ID\", \"amount\": 700}" "${ADMIN[@]}"
+batch "{\"account_id\": $A, \"direction\": \"debit\", \"amount\": 100}, {\"account_id\": $B, \"direction\": \"credit\", \"amount\": 100}"
+check "a debit into a lien's hold fails the batch" "s == 403 and b['legs'][0]['code'] == 'LIEN_HOLD'"
+check "and is rolled back" "'$(balances)' == '790.75 91 7 60'"
+
+echo
+echo "Shared primitive"
+request POST "$V1/transfers" "{\"from_account_id\": $B, \"to_account_id\": $A, \"amount\": 10, \"confirm_duplicate\": true}" "${ADMIN[@]}"
+check "transfers still post both legs" "s == 201 and '$(balances)' == '800.75 81 7 60'"
+
+finish "atomic batch"
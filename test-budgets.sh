@@ -0,0 +1,149 @@
+#!/bin/bash
+
+# Customer Budget Tests
+# Checks budget validation (category code, amount, channel, one budget per category), that month-to-date spending
+# counts categorized debits from any of the customer's accounts but not credits, reversed postings or transfers to
+# the customer's own accounts, and that the 80% and 100% alerts are each queued once per month: to the verified
+# customer email by default or a webhook target, with one alert for a posting that reaches both. Each run creates
+# its own tenant; the platform admin is created with bankctl, and the customer's email is marked verified and
+# transfers are categorized in the server's database, so DB_PATH must be the database the server uses. Exits
+# non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-budgets.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-budgets.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="budget-test-$RUN_ID-Aa1!"
+PLATFORM_USER="budget-platform-$RUN_ID"
+TENANT_CODE="bud$RUN_ID"
+
+echo " Customer Budget Tests"
+echo "======================"
+
+# customer NAME - creates a customer and stores its ID in CUSTOMER
+customer() {
+    request POST "$V1/customers" "{\"first_name\": \"$1\", \"last_name\": \"Client\", \"email\": \"budget-$1-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+    CUSTOMER=$(field "['customer']['id']")
+}
+
+# account CUSTOMER TYPE DEPOSIT - opens an account with an opening deposit and stores its ID in ACCOUNT
+account() {
+    request POST "$V1/accounts" "{\"customer_id\": $1, \"account_type\": \"$2\"}" "${AUTH[@]}"
+    ACCOUNT=$(field "['account']['id']")
+    request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": $3}" "${AUTH[@]}"
+}
+
+# spend AMOUNT CATEGORY [TYPE] - posts a categorized transaction on the spender's checking account
+spend() {
+    request POST "$V1/transactions" "{\"account_id\": $CHECKING, \"transaction_type\": \"${3:-withdrawal}\", \"amount\": $1, \"category_code\": \"$2\"}" "${AUTH[@]}"
+}
+
+# budgets - fetches the spender's budgets
+budgets() {
+    request GET "$V1/customers/$SPENDER/budgets" "" "${AUTH[@]}"
+}
+
+# budget_status CATEGORY - python expression for a category's status in the last budgets body
+budget_status() {
+    echo "[x for x in b['budgets'] if x['budget']['category'] == '$1'][0]"
+}
+
+# alerts BUDGET - prints the number of notifications queued for a budget
+alerts() {
+    sql "SELECT COUNT(*) FROM notifications WHERE resource_type = 'budget' AND resource_id = $1"
+}
+
+echo "Setup"
+tenant "$PLATFORM_USER" "Budgets" budget-admin
+customer Spender
+SPENDER=$CUSTOMER
+EMAIL="budget-Spender-$RUN_ID@example.com"
+sql "UPDATE customers SET email_verified = 1 WHERE id = $SPENDER" > /dev/null
+account "$SPENDER" checking 2000
+CHECKING=$ACCOUNT
+account "$SPENDER" savings 10
+SAVINGS=$ACCOUNT
+customer Other
+OTHER=$CUSTOMER
+account "$OTHER" checking 10
+OTHER_CHECKING=$ACCOUNT
+
+echo
+echo "Validation"
+request POST "$V1/customers/$SPENDER/budgets" "{\"monthly_amount\": 100}" "${AUTH[@]}"
+check "a budget needs a category" "s == 400 and b['code'] == 'INVALID_BUDGET'"
+request POST "$V1/customers/$SPENDER/budgets" "{\"category\": \"54111\", \"monthly_amount\": 100}" "${AUTH[@]}"
+check "category codes are at most 4 characters" "s == 400 and b['code'] == 'INVALID_BUDGET'"
+request POST "$V1/customers/$SPENDER/budgets" "{\"category\": \"5411\", \"monthly_amount\": 0}" "${AUTH[@]}"
+check "the monthly amount must be positive" "s == 400 and b['code'] == 'INVALID_BUDGET'"
+request POST "$V1/customers/$SPENDER/budgets" "{\"category\": \"5411\", \"monthly_amount\": 100, \"channel\": \"sms\"}" "${AUTH[@]}"
+check "unknown channels are refused" "s == 400 and b['code'] == 'INVALID_BUDGET'"
+request POST "$V1/customers/$SPENDER/budgets" "{\"category\": \"5812\", \"monthly_amount\": 50, \"channel\": \"webhook\"}" "${AUTH[@]}"
+check "webhook alerts need a target" "s == 400 and b['code'] == 'INVALID_BUDGET'"
+request POST "$V1/customers/$SPENDER/budgets" "{\"category\": \" 5411 \", \"monthly_amount\": 100}" "${AUTH[@]}"
+check "a budget is created with the email channel" "s == 201 and b['budget']['category'] == '5411' and b['budget']['channel'] == 'email'"
+GROCERIES=$(field "['budget']['id']")
+request POST "$V1/customers/$SPENDER/budgets" "{\"category\": \"5411\", \"monthly_amount\": 300}" "${AUTH[@]}"
+check "a second budget for the category is refused" "s == 409 and b['code'] == 'BUDGET_EXISTS'"
+request POST "$V1/customers/$SPENDER/budgets" "{\"category\": \"5812\", \"monthly_amount\": 50, \"channel\": \"webhook\", \"target\": \"http://127.0.0.1:9/budget-hook\"}" "${AUTH[@]}"
+check "a webhook budget is created" "s == 201"
+DINING=$(field "['budget']['id']")
+request PUT "$V1/customers/$SPENDER/budgets/$DINING" "{\"category\": \"5411\"}" "${AUTH[@]}"
+check "a budget cannot move onto a category already budgeted" "s == 409 and b['code'] == 'BUDGET_EXISTS'"
+request PUT "$V1/customers/$OTHER/budgets/$DINING" "{\"monthly_amount\": 60}" "${AUTH[@]}"
+check "another customer's budget is not found" "s == 404"
+
+echo
+echo "Spending"
+spend 50 5411
+budgets
+check "a categorized withdrawal is spending" "s == 200 and $(budget_status 5411)['spent'] == 50 and $(budget_status 5411)['percent_used'] == 50 and $(budget_status 5411)['alerts_sent'] == []"
+spend 30 5411 deposit
+budgets
+check "credits are not spending" "$(budget_status 5411)['spent'] == 50"
+request POST "$V1/transfers" "{\"from_account_id\": $CHECKING, \"to_account_id\": $SAVINGS, \"amount\": 20}" "${AUTH[@]}"
+sql "UPDATE transactions SET category_code = '5411' WHERE account_id = $CHECKING AND transaction_type = 'transfer'" > /dev/null
+budgets
+check "a transfer to the customer's own account is not spending" "$(budget_status 5411)['spent'] == 50"
+request POST "$V1/transfers" "{\"from_account_id\": $CHECKING, \"to_account_id\": $OTHER_CHECKING, \"amount\": 20}" "${AUTH[@]}"
+sql "UPDATE transactions SET category_code = '5411' WHERE account_id = $CHECKING AND counterparty_account_id = $OTHER_CHECKING" > /dev/null
+budgets
+check "a transfer to someone else is spending" "$(budget_status 5411)['spent'] == 70 and $(budget_status 5411)['remaining'] == 30"
+
+echo
+echo "Alerts"
+check "no alert is sent below 80%" "'$(alerts "$GROCERIES")' == '0'"
+spend 12 5411
+budgets
+check "reaching 80% records the alert" "$(budget_status 5411)['spent'] == 82 and $(budget_status 5411)['alerts_sent'] == [80]"
+check "the 80% alert is queued to the verified email" "'$(sql "SELECT COUNT(*) FROM notifications WHERE resource_type = 'budget' AND resource_id = $GROCERIES AND recipient = '$EMAIL' AND subject LIKE '%80%'")' == '1'"
+spend 5 5411
+check "spending more below 100% does not alert again" "'$(alerts "$GROCERIES")' == '1'"
+spend 13 5411
+budgets
+check "reaching 100% alerts once more" "'$(alerts "$GROCERIES")' == '2' and $(budget_status 5411)['alerts_sent'] == [80, 100] and $(budget_status 5411)['remaining'] == 0"
+spend 25 5411
+budgets
+check "overspending does not alert again" "'$(alerts "$GROCERIES")' == '2' and $(budget_status 5411)['remaining'] == -25"
+spend 60 5812
+DINNER=$(field "['transaction']['id']")
+budgets
+check "a posting reaching both thresholds sends one alert" "'$(alerts "$DINING")' == '1' and $(budget_status 5812)['alerts_sent'] == [80, 100]"
+check "the alert goes to the webhook target" "'$(sql "SELECT recipient FROM notifications WHERE resource_type = 'budget' AND resource_id = $DINING")' == 'http://127.0.0.1:9/budget-hook'"
+request POST "$V1/transactions/$DINNER/reverse" "{\"reason\": \"Duplicate\"}" "${AUTH[@]}"
+check "the dinner is reversed" "s == 201"
+budgets
+check "a reversed posting is not spending, and its alerts stay sent" "$(budget_status 5812)['spent'] == 0 and $(budget_status 5812)['alerts_sent'] == [80, 100]"
+spend 45 5812
+check "thresholds already reached this month do not alert again" "'$(alerts "$DINING")' == '1'"
+
+echo
+echo "Removal"
+request DELETE "$V1/customers/$SPENDER/budgets/$DINING" "" "${AUTH[@]}"
+check "a budget is deleted" "s == 200"
+budgets
+check "a deleted budget is no longer listed" "[x['budget']['category'] for x in b['budgets']] == ['5411']"
+request POST "$V1/customers/$SPENDER/budgets" "{\"category\": \"5812\", \"monthly_amount\": 500}" "${AUTH[@]}"
+check "the category can be budgeted again" "s == 201"
+
+finish "budget"
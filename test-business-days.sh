@@ -0,0 +1,173 @@
+#!/bin/bash
+
+# Business Day and Bank Time Tests
+# Checks the business day calendar - weekends, seeded federal holidays with Sunday observance and no Saturday
+# observance, next and previous business days - and that only platform admins manage holidays. Days around the
+# daylight saving changes must be 23 and 25 hours long, and postings late on the last evening of March in bank time
+# must land on the March statement and balance whether the client sent a New York or a UTC offset. An installment
+# due on a past weekend is collected on the following business day and records both dates, while one due this
+# coming weekend is left for Monday. The server must run with BANK_TIMEZONE=America/New_York and the default
+# following convention. An admin is created with bankctl against the server's database, so DB_PATH must be the
+# database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-business-days.sh      (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-business-days.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="business-days-test-$RUN_ID"
+
+echo " Business Day and Bank Time Tests"
+echo "================================="
+
+# day DATE - asks the calendar about a date
+day() {
+    request GET "$V1/calendar/business-day?date=$1"
+}
+
+# saturday WEEKS - prints the YYYY-MM-DD of the Saturday WEEKS weeks from this week's in New York; negative is past
+saturday() {
+    python3 -c "
+import datetime, sys, zoneinfo
+today = datetime.datetime.now(zoneinfo.ZoneInfo('America/New_York')).date()
+print((today + datetime.timedelta(days=(5 - today.weekday()) % 7 + 7 * int(sys.argv[1]))).isoformat())" "$1"
+}
+
+# customer NAME - creates a customer and prints its id
+customer() {
+    request POST "$V1/customers" "{\"first_name\": \"$1\", \"last_name\": \"Calendar\", \"email\": \"days-$1-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}"
+    field "['customer']['id']"
+}
+
+# account CUSTOMER - opens a checking account and prints its id
+# Account numbers opened in the same second can collide, so a refused open is retried
+account() {
+    for _ in 1 2 3; do
+        request POST "$V1/accounts" "{\"customer_id\": $1, \"account_type\": \"checking\"}"
+        [ "$STATUS" = 201 ] && break
+        sleep 1
+    done
+    field "['account']['id']"
+}
+
+# post ACCOUNT TYPE AMOUNT [EFFECTIVE_DATE] - posts a transaction as the admin
+post() {
+    request POST "$V1/transactions" "{\"account_id\": $1, \"transaction_type\": \"$2\", \"amount\": $3${4:+, \"effective_date\": \"$4\"}}" "${ADMIN[@]}"
+}
+
+# run_installments - runs the installments job once and waits for it to finish
+run_installments() {
+    request POST "$V1/admin/jobs/installments/run" "" "${ADMIN[@]}"
+    local run
+    run=$(field "['run']['id']" 2>/dev/null)
+    for _ in $(seq 1 50); do
+        sleep 0.1
+        request GET "$V1/admin/jobs/runs?job=installments&limit=5" "" "${ADMIN[@]}"
+        python3 -c "
+import json, sys
+run = [r for r in json.loads(sys.argv[1])['runs'] if r['id'] == $run][0]
+sys.exit(1 if run['status'] == 'running' else 0)" "$BODY" 2>/dev/null && break
+    done
+}
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "days-admin-$RUN_ID")")
+day 2026-03-09
+check "the server runs on New York time" "s == 200 and b['time_zone'] == 'America/New_York'"
+[ "$(field "['time_zone']")" = America/New_York ] || { echo "Start the server with BANK_TIMEZONE=America/New_York"; exit 1; }
+
+echo
+echo "Calendar"
+check "an ordinary Monday is a business day" "b['is_business_day'] and 'holiday' not in b and b['hours'] == 24"
+day 2026-03-07
+check "Saturdays are closed" "not b['is_business_day'] and b['next_business_day'] == '2026-03-09' and b['previous_business_day'] == '2026-03-06'"
+day 2026-11-26
+check "Thanksgiving is a seeded federal holiday" "not b['is_business_day'] and b['holiday'] == 'Thanksgiving Day'"
+check "the days either side skip it" "b['next_business_day'] == '2026-11-27' and b['previous_business_day'] == '2026-11-25'"
+day 2026-07-03
+check "Independence Day on a Saturday is not observed on the Friday" "b['is_business_day']"
+day 2027-07-05
+check "next year is seeded, with Independence Day on a Sunday observed on the Monday" "not b['is_business_day'] and b['holiday'] == 'Independence Day'"
+day 2026-01-19
+check "Martin Luther King day falls on the third Monday" "not b['is_business_day']"
+day 2026-02-30
+check "impossible dates are refused" "s == 400"
+
+echo
+echo "Daylight saving"
+day 2026-03-08
+check "the spring-forward day is 23 hours" "b['hours'] == 23 and b['starts_at'] == '2026-03-08T00:00:00-05:00' and b['ends_at'] == '2026-03-09T00:00:00-04:00'"
+day 2026-11-01
+check "the fall-back day is 25 hours" "b['hours'] == 25 and b['starts_at'] == '2026-11-01T00:00:00-04:00' and b['ends_at'] == '2026-11-02T00:00:00-05:00'"
+
+echo
+echo "Holiday management"
+request POST "$V1/admin/holidays" "{\"date\": \"2026-12-24\", \"name\": \"Christmas Eve\"}"
+check "managing holidays needs a login" "s == 401"
+request POST "$V1/admin/holidays" "{\"date\": \"24/12/2026\", \"name\": \"Christmas Eve\"}" "${ADMIN[@]}"
+check "a malformed date is refused" "s == 400 and b['code'] == 'INVALID_HOLIDAY'"
+request POST "$V1/admin/holidays" "{\"date\": \"2026-12-25\", \"name\": \"Second Christmas\"}" "${ADMIN[@]}"
+check "a date can hold one holiday" "s == 409 and b['code'] == 'HOLIDAY_EXISTS'"
+request POST "$V1/admin/holidays" "{\"date\": \"2026-12-24\", \"name\": \"Christmas Eve\"}" "${ADMIN[@]}"
+check "an admin adds a holiday" "s == 201 and b['holiday']['source'] == 'admin'"
+EVE=$(field "['holiday']['id']")
+day 2026-12-23
+check "the calendar closes on it" "b['next_business_day'] == '2026-12-28'"
+request PUT "$V1/admin/holidays/$EVE" "{\"date\": \"2026-12-31\", \"name\": \"New Year's Eve\"}" "${ADMIN[@]}"
+check "a holiday can be moved" "s == 200 and b['holiday']['date'] == '2026-12-31'"
+day 2026-12-24
+check "its old date reopens" "b['is_business_day']"
+request GET "$V1/admin/holidays?year=2026" "" "${ADMIN[@]}"
+check "the year's holidays are listed in date order" "s == 200 and [h['date'] for h in b['holidays']] == sorted(h['date'] for h in b['holidays']) and '2026-12-31' in [h['date'] for h in b['holidays']] and all(h['date'].startswith('2026') for h in b['holidays'])"
+request DELETE "$V1/admin/holidays/$EVE" "" "${ADMIN[@]}"
+check "a holiday can be deleted" "s == 200"
+day 2026-12-31
+check "its date reopens" "b['is_business_day']"
+YEAR=$(python3 -c "print(2100 + $RUN_ID % 90)")
+sql "DELETE FROM holidays WHERE date LIKE '$YEAR-%'" > /dev/null
+request POST "$V1/admin/holidays/seed" "{\"year\": $YEAR}" "${ADMIN[@]}"
+check "a year's federal holidays are seeded" "s == 200 and 10 <= b['added'] <= 11"
+request POST "$V1/admin/holidays/seed" "{\"year\": $YEAR}" "${ADMIN[@]}"
+check "a year is only seeded once" "s == 200 and b['added'] == 0"
+
+echo
+echo "Month cut-off"
+OWNER=$(customer Marta)
+ACCOUNT=$(account "$OWNER")
+sql "UPDATE accounts SET created_at = '2026-02-01 12:00:00+00:00' WHERE id = ?" "$ACCOUNT" > /dev/null
+post "$ACCOUNT" deposit 100 2026-03-31T23:30:00-04:00
+check "a posting late on 31 March New York time is accepted" "s == 201"
+post "$ACCOUNT" deposit 20 2026-04-01T03:45:00Z
+check "so is one at the same hour sent in UTC" "s == 201"
+post "$ACCOUNT" deposit 7 2026-04-01T00:30:00-04:00
+check "and one just after midnight on 1 April" "s == 201"
+request GET "$V1/accounts/$ACCOUNT/balance?as_of=2026-03-31"
+check "both late postings are in the 31 March balance" "s == 200 and b['balance'] == 120"
+request GET "$V1/customers/$OWNER/statements/2026/3" "" "${ADMIN[@]}"
+check "the March statement holds both and not April's" "s == 200 and [l['amount'] for l in b['accounts'][0]['lines']] == [100, 20]"
+check "their dates are the bank's" "[l['date'][:10] for l in b['accounts'][0]['lines']] == ['2026-03-31'] * 2"
+request GET "$V1/customers/$OWNER/statements/2026/4" "" "${ADMIN[@]}"
+check "the April statement opens at 120" "s == 200 and b['accounts'][0]['summary']['opening_balance'] == 120 and b['accounts'][0]['summary']['transaction_count'] == 1"
+
+echo
+echo "Installment collection"
+post "$ACCOUNT" payment 110
+PAYMENT=$(field "['transaction']['id']")
+request POST "$V1/transactions/$PAYMENT/installment-plan" "{\"months\": 3}" "${ADMIN[@]}"
+check "a payment is converted into a plan" "s == 201"
+PLAN=$(field "['plan']['id']")
+LAST=$(saturday -2)
+sql "UPDATE installment_plans SET next_due_date = ? WHERE id = ?" "$LAST 16:00:00+00:00" "$PLAN" > /dev/null
+request GET "$V1/installment-plans/$PLAN" "" "${ADMIN[@]}"
+check "an installment due on a Saturday is collected on a later day" "s == 200 and b['next_collection_date'][:10] > '$LAST'"
+run_installments
+request GET "$V1/installment-plans/$PLAN" "" "${ADMIN[@]}"
+check "the overdue installment is collected" "s == 200 and b['plan']['paid_installments'] == 1 and len(b['payments']) == 1"
+check "its payment records the scheduled and the actual date" "b['payments'][0]['scheduled_date'][:10] == '$LAST' and b['payments'][0]['paid_at'][:10] > '$LAST'"
+check "the schedule keeps its day of the month" "b['plan']['next_due_date'][:10] > '$LAST' and b['plan']['next_due_date'][8:10] == '$LAST'[8:10]"
+NEXT=$(saturday 0)
+sql "UPDATE installment_plans SET next_due_date = ? WHERE id = ?" "$NEXT 16:00:00+00:00" "$PLAN" > /dev/null
+run_installments
+request GET "$V1/installment-plans/$PLAN" "" "${ADMIN[@]}"
+check "one due this Saturday waits for the following business day" "b['plan']['paid_installments'] == 1 and b['next_collection_date'][:10] > '$NEXT'"
+
+finish "business day"
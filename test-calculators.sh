@@ -0,0 +1,78 @@
+#!/bin/bash
+
+# Calculator Tests
+# Quotes a loan, an early payoff and savings growth without signing in. The loan calculator's payment must match the
+# payment of a loan created by the run with the same terms, its schedule must repay the principal, and extra monthly
+# payments must save months and interest, for hypothetical loans and for the run's loan, which needs a signed-in
+# caller. Savings grow by the contributions and credited interest. Inputs outside the bounds are refused, and the
+# twenty-first request from an address within a minute is rate limited, so the server must run with the default
+# CALCULATOR_RATE_PER_MINUTE. The admin user is created with bankctl. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-calculators.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-calculators.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="calculator-test-$RUN_ID-Aa1!"
+ADMIN_USER="calculator-admin-$RUN_ID"
+
+echo " Calculator Tests"
+echo "================="
+
+echo "Setup"
+AUTH=(-H "Authorization: Bearer $(staff "$ADMIN_USER")")
+request POST "$V1/customers" "{\"first_name\": \"Cal\", \"last_name\": \"Culator\", \"email\": \"cal-$RUN_ID@example.com\"}" "${AUTH[@]}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/loans" "{\"customer_id\": $CUSTOMER, \"principal_amount\": 20000, \"interest_rate\": 0.065, \"loan_term\": 60}" "${AUTH[@]}"
+check "a loan is created for the run" "s == 201 and b['loan']['status'] == 'active'"
+LOAN=$(field "['loan']['id']")
+PAYMENT=$(field "['loan']['monthly_payment']")
+
+echo
+echo "Loan"
+request POST "$V1/calculators/loan" '{"principal": 20000, "rate": 0.065, "term": 60}'
+check "a loan is quoted without signing in" "s == 200"
+check "the payment is the one a loan with the same terms is charged" "b['monthly_payment'] == round($PAYMENT, 2)"
+check "the schedule repays the principal" "abs(sum(i['principal'] for i in b['schedule']) - 20000) < 0.005 and b['schedule'][-1]['balance'] == 0"
+check "the schedule runs for about the term" "59 <= len(b['schedule']) <= 61"
+check "the totals add up" "abs(b['total_paid'] - 20000 - b['total_interest']) < 0.005 and abs(sum(i['interest'] for i in b['schedule']) - b['total_interest']) < 0.005"
+request POST "$V1/calculators/loan" '{"principal": 20000, "rate": 0, "term": 60}'
+check "a zero rate is refused" "s == 400"
+request POST "$V1/calculators/loan" '{"principal": 20000, "rate": 0.065, "term": 481}'
+check "a term over 480 months is refused" "s == 400"
+
+echo
+echo "Early payoff"
+request POST "$V1/calculators/early-payoff" '{"principal": 20000, "rate": 0.065, "term": 60, "extra_monthly": 200}'
+check "extra payments save months and interest" "s == 200 and b['months_saved'] > 0 and b['interest_saved'] > 0"
+check "the savings are the differences" "b['months'] - b['months_with_extra'] == b['months_saved'] and b['payoff_date_with_extra'] < b['payoff_date']"
+request POST "$V1/calculators/early-payoff" '{"principal": 20000, "rate": 0.065, "term": 60, "extra_monthly": 0}'
+check "an extra amount is required" "s == 400"
+request POST "$V1/calculators/early-payoff" "{\"loan_id\": $LOAN, \"extra_monthly\": 200}"
+check "an existing loan needs a signed-in caller" "s == 401"
+request POST "$V1/calculators/early-payoff" "{\"loan_id\": $LOAN, \"extra_monthly\": 200}" "${AUTH[@]}"
+check "an existing loan is projected from its balance and payment" "s == 200 and b['monthly_payment'] == round($PAYMENT, 2) and 59 <= b['months'] <= 61 and b['months_saved'] > 0"
+request POST "$V1/calculators/early-payoff" "{\"loan_id\": $((LOAN + 1000000)), \"extra_monthly\": 200}" "${AUTH[@]}"
+check "an unknown loan is not found" "s == 404"
+
+echo
+echo "Savings growth"
+request POST "$V1/calculators/savings-growth" '{"initial": 1000, "monthly_contribution": 0, "rate": 0, "years": 2}'
+check "nothing is earned at a zero rate" "s == 200 and len(b['series']) == 2 and b['final_balance'] == 1000 and b['total_interest'] == 0"
+request POST "$V1/calculators/savings-growth" '{"initial": 1000, "monthly_contribution": 200, "rate": 0.03, "years": 10}'
+check "a year is projected at a time" "s == 200 and [g['year'] for g in b['series']] == list(range(1, 11))"
+check "contributions are made monthly" "b['total_contributions'] == 25000"
+check "the balance is contributions and credited interest" "b['total_interest'] > 0 and abs(b['final_balance'] - 25000 - b['total_interest']) < 0.005"
+request POST "$V1/calculators/savings-growth" '{"initial": 1000, "monthly_contribution": 0, "rate": 0.03, "years": 51}'
+check "more than 50 years is refused" "s == 400"
+
+echo
+echo "Rate limit"
+# Eleven calculator requests so far; the limit is twenty a minute per address
+for _ in $(seq 1 9); do
+    request POST "$V1/calculators/loan" '{"principal": 1000, "rate": 0.05, "term": 12}'
+done
+check "the twentieth request in a minute is allowed" "s == 200"
+request POST "$V1/calculators/loan" '{"principal": 1000, "rate": 0.05, "term": 12}'
+check "the twenty-first is rate limited" "s == 429 and b['code'] == 'RATE_LIMITED' and 0 < b['retry_after_seconds'] <= 60"
+
+finish "calculator"
@@ -0,0 +1,183 @@
+#!/bin/bash
+
+# Campaign Tests
+# Sends announcement campaigns to a run's own tenant: validation, the tenant scope, a dry run's counts and sample
+# rendering, and customers' notification preferences. A campaign to checking customers is sent to the verified
+# customer, suppressed for the one who opted out and skipped for the unverified one; its delivery report is final
+# once the notification is sent. Batches are rate limited and can be paused and resumed, also before a scheduled
+# campaign starts. The emailed unsubscribe link opts the customer out, and a regulatory campaign is sent to customers
+# who opted out anyway, with an audit entry for each. Each run creates its own tenant, so DB_PATH must be the
+# database the server uses; the platform admin is created with bankctl. Deliveries wait for the notification
+# dispatcher, which runs every 30 seconds. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-campaigns.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-campaigns.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="campaign-test-$RUN_ID-Aa1!"
+PLATFORM_USER="campaign-platform-$RUN_ID"
+TENANT_CODE="campaign$RUN_ID"
+TAG="fee-notice-$RUN_ID"
+
+echo " Campaign Tests"
+echo "==============="
+
+# wait_for CAMPAIGN PYTHON_EXPRESSION [SECONDS] - polls the campaign until the expression holds for its body, then
+# leaves the campaign in BODY
+wait_for() {
+    for _ in $(seq 1 $((${3:-60} * 2))); do
+        request GET "$V1/admin/campaigns/$1" "" "${AUTH[@]}"
+        python3 -c "
+import json, sys
+b = json.loads(sys.argv[1])
+sys.exit(0 if ($2) else 1)" "$BODY" 2>/dev/null && return
+        sleep 0.5
+    done
+}
+
+# recipient CAMPAIGN CUSTOMER - prints a customer's recipient status in a campaign
+recipient() {
+    sql "SELECT status FROM campaign_recipients WHERE campaign_id = $1 AND customer_id = $2"
+}
+
+# customer FIRST EMAIL_VERIFIED ACCOUNT_TYPE - creates a customer with an account and prints its ID
+customer() {
+    request POST "$V1/customers" "{\"first_name\": \"$1\", \"last_name\": \"Campaign\", \"email\": \"$1-$RUN_ID@example.com\"}" "${AUTH[@]}"
+    local id
+    id=$(field "['customer']['id']")
+    sql "UPDATE customers SET email_verified = $2 WHERE id = $id" > /dev/null
+    request POST "$V1/accounts" "{\"customer_id\": $id, \"account_type\": \"$3\"}" "${AUTH[@]}"
+    echo "$id"
+}
+
+echo "Setup"
+tenant "$PLATFORM_USER" "Campaign Bank" campaign-admin
+
+ANN=$(customer Ann 1 checking)
+BEN=$(customer Ben 1 checking)
+CAM=$(customer Cam 0 checking)
+DEE=$(customer Dee 1 savings)
+request POST "$V1/admin/tags" "{\"slug\": \"$TAG\"}" "${AUTH[@]}"
+request PUT "$V1/customers/$DEE/tags/$TAG" "" "${AUTH[@]}"
+check "a customer is tagged" "s in (200, 201)"
+
+MESSAGE="\"subject\": \"A change for {first_name}\", \"template\": \"Hi {first_name}, {bank_name} is changing its fee schedule.\""
+CHECKING="{\"name\": \"Fee schedule\", \"audience\": {\"account_type\": \"checking\"}, $MESSAGE"
+
+echo
+echo "Validation"
+request POST "$V1/admin/campaigns" "{\"name\": \"Bad\", \"subject\": \"Hi\", \"template\": \"Your {balance} is low\"}" "${AUTH[@]}"
+check "templates only use known variables" "s == 400 and 'unknown template variable {balance}' in b['error']"
+request POST "$V1/admin/campaigns" "{\"name\": \"Bad\", \"subject\": \"Hi {first_name\", \"template\": \"Hello\"}" "${AUTH[@]}"
+check "braces must balance" "s == 400 and 'subject' in b['error']"
+request POST "$V1/admin/campaigns" "{\"name\": \"Bad\", \"audience\": {\"tenants\": [\"default\"]}, $MESSAGE}" "${AUTH[@]}"
+check "a tenant cannot address another tenant's customers" "s == 403"
+request POST "$V1/admin/campaigns" "{\"name\": \"Bad\", \"audience\": {\"tenants\": [\"no-such-$RUN_ID\"]}, $MESSAGE}" "${PLATFORM[@]}"
+check "tenants must exist" "s == 400 and 'unknown tenant' in b['error']"
+request POST "$V1/admin/campaigns" "$CHECKING}"
+check "campaigns need an administrator" "s == 401"
+
+echo
+echo "Preferences"
+request GET "$V1/customers/$BEN/notification-preferences" "" "${AUTH[@]}"
+check "customers receive announcements by default" "s == 200 and b['preferences'] == {'announcements': True}"
+request PUT "$V1/customers/$BEN/notification-preferences" "{\"announcements\": false}" "${AUTH[@]}"
+check "a customer opts out" "s == 200 and b['preferences'] == {'announcements': False}"
+
+echo
+echo "Dry run"
+request POST "$V1/admin/campaigns" "$CHECKING, \"dry_run\": true}" "${AUTH[@]}"
+check "the audience is counted with those who would be skipped" \
+    "s == 200 and b['preview']['audience'] == 3 and b['preview']['opted_out'] == 1 and b['preview']['unreachable'] == 1"
+check "and the first customer's message is rendered" \
+    "b['preview']['sample']['customer_id'] == $ANN and b['preview']['sample']['subject'] == 'A change for Ann' and b['preview']['sample']['body'].startswith('Hi Ann, Campaign Bank $RUN_ID is changing') and '/unsubscribe/' in b['preview']['sample']['body']"
+request POST "$V1/admin/campaigns" "{\"name\": \"Tagged\", \"audience\": {\"tag\": \"$TAG\"}, $MESSAGE, \"dry_run\": true}" "${AUTH[@]}"
+check "tags select customers" "b['preview']['audience'] == 1 and b['preview']['sample']['customer_id'] == $DEE"
+request POST "$V1/admin/campaigns" "{\"name\": \"Inactive\", \"audience\": {\"customer_status\": \"inactive\"}, $MESSAGE, \"dry_run\": true}" "${AUTH[@]}"
+check "an empty audience has no sample" "b['preview']['audience'] == 0 and b['preview']['sample'] is None"
+request GET "$V1/admin/campaigns" "" "${AUTH[@]}"
+check "a dry run creates nothing" "b['total'] == 0"
+
+echo
+echo "Rate limit"
+request POST "$V1/admin/campaigns" "$CHECKING, \"batch_size\": 1, \"batch_interval_seconds\": 3600}" "${AUTH[@]}"
+check "a campaign is scheduled for now" "s == 201 and b['campaign']['status'] == 'scheduled' and b['campaign']['audience']['tenant_ids'] != ''"
+SLOW=$(field "['campaign']['id']")
+wait_for "$SLOW" "b['campaign']['status'] == 'running' and b['campaign']['queued'] + b['campaign']['sent'] == 1" 10
+check "one batch of one customer is queued" "b['campaign']['total'] == 3 and b['campaign']['pending'] == 2"
+sleep 3
+request GET "$V1/admin/campaigns/$SLOW" "" "${AUTH[@]}"
+check "and the next waits for the interval" "b['campaign']['pending'] == 2"
+request POST "$V1/admin/campaigns/$SLOW/pause" "" "${AUTH[@]}"
+check "a running campaign is paused" "s == 200 and b['campaign']['status'] == 'paused' and b['campaign']['paused_by'] == 'campaign-admin'"
+request POST "$V1/admin/campaigns/$SLOW/resume" "" "${AUTH[@]}"
+check "and resumed" "s == 200 and b['campaign']['status'] == 'running'"
+request POST "$V1/admin/campaigns/$SLOW/pause" "" "${AUTH[@]}"
+
+echo
+echo "Scheduling"
+request POST "$V1/admin/campaigns" "$CHECKING, \"scheduled_at\": \"$(date -u -d '+1 day' +%Y-%m-%dT%H:%M:%SZ)\"}" "${AUTH[@]}"
+LATER=$(field "['campaign']['id']")
+sleep 3
+request GET "$V1/admin/campaigns/$LATER" "" "${AUTH[@]}"
+check "a campaign waits for its scheduled time" "b['campaign']['status'] == 'scheduled' and b['total'] == 0"
+request POST "$V1/admin/campaigns/$LATER/pause" "" "${AUTH[@]}"
+check "it can be paused before it starts" "s == 200 and b['campaign']['status'] == 'paused'"
+request POST "$V1/admin/campaigns/$LATER/resume" "" "${AUTH[@]}"
+check "and resumes to its schedule" "s == 200 and b['campaign']['status'] == 'scheduled'"
+
+echo
+echo "Delivery"
+request POST "$V1/admin/campaigns" "$CHECKING, \"batch_size\": 10, \"batch_interval_seconds\": 1}" "${AUTH[@]}"
+CAMPAIGN=$(field "['campaign']['id']")
+wait_for "$CAMPAIGN" "b['campaign']['pending'] == 0 and b['campaign']['total'] == 3" 10
+check "the opted-out customer is suppressed" "'$(recipient "$CAMPAIGN" "$BEN")' == 'suppressed'"
+check "the unverified customer is unreachable" "'$(recipient "$CAMPAIGN" "$CAM")' == 'unreachable'"
+check "the verified customer's message is queued" "'$(recipient "$CAMPAIGN" "$ANN")' in ('queued', 'sent')"
+NOTICE="SELECT body FROM notifications WHERE resource_type = 'campaign' AND resource_id = $CAMPAIGN AND customer_id = $ANN"
+check "with the subject and body rendered for them" \
+    "'$(sql "SELECT subject FROM notifications WHERE resource_type = 'campaign' AND resource_id = $CAMPAIGN AND customer_id = $ANN")' == 'A change for Ann'"
+TOKEN=$(sql "$NOTICE" | sed -n 's|.*/unsubscribe/\([A-Za-z0-9_-]*\).*|\1|p')
+check "and an unsubscribe link" "len('$TOKEN') > 20"
+request GET "$V1/admin/campaigns/$CAMPAIGN/report" "" "${AUTH[@]}"
+check "the report is not final while delivery is outstanding" "s == 200 and (not b['final'] or b['by_status']['sent'] == 1)"
+
+echo
+echo "Unsubscribe"
+request POST "$V1/unsubscribe/$TOKEN" ""
+check "the emailed link opts the customer out" "s == 200 and b['preference']['opted_out'] and b['preference']['source'] == 'unsubscribe' and b['preference']['campaign_id'] == $CAMPAIGN"
+request GET "$V1/customers/$ANN/notification-preferences" "" "${AUTH[@]}"
+check "which their preferences show" "b['preferences'] == {'announcements': False}"
+request POST "$V1/unsubscribe/not-a-token" ""
+check "unknown links are refused" "s == 404"
+
+echo
+echo "Regulatory"
+request POST "$V1/admin/campaigns" "{\"name\": \"Terms\", \"audience\": {\"account_type\": \"checking\"}, \"subject\": \"Changes to your terms\", \"template\": \"Dear {full_name}, required notice.\", \"regulatory\": true, \"batch_interval_seconds\": 1}" "${AUTH[@]}"
+REGULATORY=$(field "['campaign']['id']")
+wait_for "$REGULATORY" "b['campaign']['pending'] == 0 and b['campaign']['total'] == 3" 10
+check "customers who opted out are sent a regulatory campaign" \
+    "'$(recipient "$REGULATORY" "$ANN")' in ('queued', 'sent') and '$(recipient "$REGULATORY" "$BEN")' in ('queued', 'sent') and b['campaign']['overrides'] == 2"
+check "without an unsubscribe link" \
+    "'$(sql "SELECT COUNT(*) FROM notifications WHERE resource_type = 'campaign' AND resource_id = $REGULATORY AND body LIKE '%unsubscribe%'")' == '0'"
+check "and each override is in the audit log" \
+    "'$(sql "SELECT COUNT(*) FROM audit_entries WHERE campaign_id = $REGULATORY AND route = 'campaign:regulatory_override' AND customer_id IN ($ANN, $BEN) AND username = 'campaign-admin'")' == '2'"
+
+echo
+echo "Report"
+wait_for "$CAMPAIGN" "b['campaign']['status'] == 'completed'" 45
+check "a campaign completes once its messages are delivered" "b['campaign']['status'] == 'completed' and b['campaign']['completed_at']"
+request GET "$V1/admin/campaigns/$CAMPAIGN/report" "" "${AUTH[@]}"
+check "with a final delivery report" \
+    "b['final'] and b['by_status'] == {'pending': 0, 'queued': 0, 'sent': 1, 'failed': 0, 'suppressed': 1, 'unreachable': 1} and b['failures'] == []"
+request GET "$V1/admin/campaigns/$CAMPAIGN?status=sent" "" "${AUTH[@]}"
+check "recipients can be listed by status" "b['total'] == 1 and b['recipients'][0]['customer_id'] == $ANN"
+check "the message is in the customer's communication log" \
+    "'$(sql "SELECT COUNT(*) FROM communication_logs WHERE customer_id = $ANN AND resource_type = 'campaign' AND resource_id = $CAMPAIGN")' == '1'"
+wait_for "$REGULATORY" "b['campaign']['status'] == 'completed'" 10
+request GET "$V1/admin/campaigns/$REGULATORY/report" "" "${AUTH[@]}"
+check "the regulatory report counts its overrides" "b['final'] and b['overrides'] == 2 and b['by_status']['sent'] == 2"
+request POST "$V1/admin/campaigns/$CAMPAIGN/pause" "" "${AUTH[@]}"
+check "a completed campaign cannot be paused" "s == 409 and b['code'] == 'INVALID_STATUS_TRANSITION'"
+
+finish "campaign"
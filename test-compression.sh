@@ -0,0 +1,131 @@
+#!/bin/bash
+
+# Response Compression Tests
+# Checks Accept-Encoding negotiation (gzip, q=0, *, unsupported codings), that small bodies and PDFs are sent as
+# they are, that exports and v2 fall-through responses are compressed once, and that an account's streamed
+# transaction history stays valid JSON. It then measures a 10,000-row history with and without gzip: transfer
+# size, time to first byte and, when SERVER_PID names the server process, its peak resident memory. The rows are
+# copied in SQL, so DB_PATH must be the database the server uses; they are not hash-chained. The admin user is
+# created with bankctl against the server's database. Run with the default compression settings. Exits non-zero
+# on failure.
+#
+# Usage: DB_PATH=banking.db ./test-compression.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl SERVER_PID=1234 ROWS=10000 ./test-compression.sh
+
+source "$(dirname "$0")/test-lib.sh"
+ROWS="${ROWS:-10000}"
+PASSWORD="compression-test-$RUN_ID"
+CHECK_HELPERS="
+import gzip
+def raw(name):
+    return open('$WORK/' + name + '.body', 'rb').read()
+def encoding(name):
+    for line in open('$WORK/' + name + '.headers'):
+        key, _, value = line.partition(':')
+        if key.strip().lower() == 'content-encoding':
+            return value.strip().lower()
+    return ''
+def body(name):
+    return gzip.decompress(raw(name)) if encoding(name) == 'gzip' else raw(name)
+def doc(name):
+    return json.loads(body(name))
+def ndjson(name):
+    return [json.loads(line) for line in body(name).splitlines() if line.strip()]"
+
+echo " Response Compression Tests"
+echo "==========================="
+
+# fetch NAME URL [CURL_ARGS...] - saves the raw body to $WORK/NAME.body and the headers to $WORK/NAME.headers
+fetch() {
+    local name=$1 url=$2
+    shift 2
+    curl -s -o "$WORK/$name.body" -D "$WORK/$name.headers" "$url" "$@"
+}
+
+# header NAME FIELD - prints a response header of a saved response, lower-cased
+header() {
+    tr -d '\r' < "$WORK/$1.headers" | awk -F': ' -v field="$2" 'tolower($1) == tolower(field) {print tolower($2)}'
+}
+
+# peak_rss - prints the server's peak resident memory in KiB, when SERVER_PID is set
+peak_rss() {
+    [ -n "$SERVER_PID" ] && awk '/^VmHWM/ {print $2}' "/proc/$SERVER_PID/status"
+}
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "compression-admin-$RUN_ID")")
+request POST "$V1/customers" "{\"first_name\": \"Compression\", \"last_name\": \"Test\", \"email\": \"compression-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}"
+ACCOUNT=$(field "['account']['id']")
+request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 1250.75, \"description\": \"Salary <March> & bonus\"}"
+TRANSACTION=$(field "['transaction']['id']")
+request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"withdrawal\", \"amount\": 20}"
+HISTORY="$V1/accounts/$ACCOUNT/transactions"
+
+echo
+echo "Negotiation"
+fetch identity "$HISTORY"
+check "without Accept-Encoding the history is sent as it is" "encoding('identity') == '' and len(doc('identity')['transactions']) == 2"
+check "the streamed history keeps its fields and display amounts" \
+    "doc('identity')['account_id'] == $ACCOUNT and doc('identity')['transactions'][1]['display_amount'] == '\$1,250.75'"
+check "the history is HTML-escaped like every other JSON response" "b'Salary \\\\u003cMarch\\\\u003e \\\\u0026 bonus' in raw('identity')"
+fetch search "$HISTORY?q=Salary"
+check "searching the history still pages" "doc('search')['total'] == 1 and doc('search')['page'] == 1"
+fetch small "$BASE_URL/health" -H "Accept-Encoding: gzip"
+check "a body under the minimum size is not compressed" "encoding('small') == '' and json.loads(raw('small'))"
+
+# Enough rows for the history to pass the minimum size
+for i in 1 2 3 4 5 6; do
+    request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": $i, \"description\": \"Deposit $i\"}"
+done
+fetch plain "$HISTORY"
+fetch gzip "$HISTORY" -H "Accept-Encoding: gzip"
+check "gzip is used when accepted" "encoding('gzip') == 'gzip'"
+check "the compressed body is the same JSON" "body('gzip') == raw('plain')"
+check "caches are told the response varies by Accept-Encoding" "'accept-encoding' in '$(header gzip vary)'"
+check "any Content-Length is the compressed size" "'$(header gzip content-length)' in ('', str(len(raw('gzip'))))"
+fetch refused "$HISTORY" -H "Accept-Encoding: gzip;q=0, identity"
+check "gzip;q=0 refuses gzip" "encoding('refused') == '' and raw('refused') == raw('plain')"
+fetch any "$HISTORY" -H "Accept-Encoding: *"
+check "* accepts gzip" "encoding('any') == 'gzip'"
+fetch other "$HISTORY" -H "Accept-Encoding: br"
+check "an unsupported coding gets the body as it is" "encoding('other') == ''"
+fetch weighted "$HISTORY" -H "Accept-Encoding: br;q=1.0, gzip;q=0.5"
+check "gzip with a lower q value is still used" "encoding('weighted') == 'gzip'"
+
+echo
+echo "Exclusions and single compression"
+fetch pdf "$V1/transactions/$TRANSACTION/receipt?format=pdf" -H "Accept-Encoding: gzip"
+check "PDFs are never compressed" "raw('pdf').startswith(b'%PDF') and encoding('pdf') == ''"
+fetch export "$V1/admin/export/transactions" -H "Accept-Encoding: gzip" "${ADMIN[@]}"
+check "exports are compressed once" "encoding('export') == 'gzip' and ndjson('export')[-1]['_summary']['row_count'] >= 8"
+fetch v2 "$BASE_URL/api/v2/accounts/$ACCOUNT/transactions" -H "Accept-Encoding: gzip"
+check "a v2 request served by v1 is compressed once" "encoding('v2') == 'gzip' and len(doc('v2')['transactions']) == 8"
+
+echo
+echo "$ROWS-row history"
+sql "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < CAST(? AS INTEGER))
+     INSERT INTO transactions (created_at, updated_at, tenant_id, transaction_id, account_id, transaction_type, amount,
+         effective_date, description, reference, merchant_name, channel, category_code, balance_before, balance_after, hash)
+     SELECT datetime(t.created_at, '-' || n.i || ' minutes'), t.updated_at, t.tenant_id, t.transaction_id || '-' || n.i,
+         t.account_id, t.transaction_type, t.amount + n.i, t.effective_date, 'Copied deposit ' || n.i, t.reference,
+         t.merchant_name, t.channel, t.category_code, t.balance_before, t.balance_after, t.hash
+     FROM transactions t, n WHERE t.id = CAST(? AS INTEGER)" "$((ROWS - 8))" "$TRANSACTION" > /dev/null
+RSS_BEFORE=$(peak_rss)
+curl -s -o "$WORK/big-plain.body" -D "$WORK/big-plain.headers" -w '%{size_download} %{time_starttransfer} %{time_total}\n' "$HISTORY" > "$WORK/plain.stats"
+RSS_PLAIN=$(peak_rss)
+curl -s -o "$WORK/big-gzip.body" -D "$WORK/big-gzip.headers" -H "Accept-Encoding: gzip" \
+    -w '%{size_download} %{time_starttransfer} %{time_total}\n' "$HISTORY" > "$WORK/gzip.stats"
+check "every row is streamed as one valid document" "len(doc('big-plain')['transactions']) == $ROWS"
+check "the compressed history decodes to the same rows" "body('big-gzip') == raw('big-plain')"
+check "gzip sends under a fifth of the bytes" "len(raw('big-gzip')) * 5 < len(raw('big-plain'))"
+read -r PLAIN_SIZE PLAIN_FIRST PLAIN_TOTAL < "$WORK/plain.stats"
+read -r GZIP_SIZE GZIP_FIRST GZIP_TOTAL < "$WORK/gzip.stats"
+printf '    identity: %9d bytes, first byte %.3fs, total %.3fs\n' "$PLAIN_SIZE" "$PLAIN_FIRST" "$PLAIN_TOTAL"
+printf '    gzip:     %9d bytes, first byte %.3fs, total %.3fs\n' "$GZIP_SIZE" "$GZIP_FIRST" "$GZIP_TOTAL"
+if [ -n "$SERVER_PID" ]; then
+    echo "    server peak RSS: $RSS_BEFORE KiB before, $RSS_PLAIN KiB after the identity request, $(peak_rss) KiB after gzip"
+fi
+
+finish "compression"
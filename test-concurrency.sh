@@ -0,0 +1,97 @@
+#!/bin/bash
+
+# Concurrent Write Test
+# Fires parallel deposits at one account and at accounts of their own, plus parallel transfers, while reads run,
+# and checks that none fails with a SQLite lock error (500), that every posting landed exactly once and that the
+# postings kept the books in balance. The write cap is lifted through the admin endpoint for the test, so every
+# request reaches the database, and restored afterwards. The admin user is created with bankctl against the
+# server's database. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-concurrency.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl WRITERS=50 ./test-concurrency.sh
+
+source "$(dirname "$0")/test-lib.sh"
+WRITERS="${WRITERS:-50}"
+PASSWORD="concurrency-test-$RUN_ID"
+
+echo " Concurrent Write Test"
+echo "======================"
+
+# account - opens a checking account for the run's customer and prints its id
+# Account numbers opened in the same second can collide, so a refused open is retried
+account() {
+    local id
+    for _ in 1 2 3; do
+        request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER_ID, \"account_type\": \"checking\"}"
+        id=$(field "['account']['id']" 2>/dev/null) && break
+    done
+    echo "$id"
+}
+
+# balance ACCOUNT - prints an account's balance as a whole number
+balance() {
+    request GET "$V1/accounts/$1/balance"
+    field "['balance']" | sed 's/\.0$//'
+}
+
+# imbalance - prints the USD trial balance's debits less credits, which the run's postings must not change
+imbalance() {
+    request GET "$V1/reports/trial-balance" "" "${ADMIN[@]}"
+    python3 -c "
+import json, sys
+tb = [tb for tb in json.loads(sys.argv[1])['trial_balances'] if tb['currency'] == 'USD'][0]
+print(round(tb['total_debits'] - tb['total_credits'], 2))" "$BODY"
+}
+
+# report NAME FILE EXPECTED_STATUS - prints the status counts of a batch and checks none was a 500
+report() {
+    echo "  $1: $(sort "$2" | uniq -c | awk '{printf "%s x%s  ", $2, $1}')"
+    assert "$1 never failed with a server error" "! grep -q '^5' $2"
+    assert "$1 all succeeded" "[ \$(grep -c '^$3\$' $2) -eq $WRITERS ]"
+}
+
+echo "Setup"
+request POST "$V1/customers" "{\"first_name\": \"Concurrent\", \"last_name\": \"Test\", \"email\": \"concurrency-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}"
+CUSTOMER_ID=$(field "['customer']['id']")
+SHARED=$(account)
+for i in $(seq "$WRITERS"); do account; done > "$WORK/accounts"
+SOURCE=$(account)
+request POST "$V1/transactions" "{\"account_id\": $SOURCE, \"transaction_type\": \"deposit\", \"amount\": $WRITERS}"
+
+ADMIN=(-H "Authorization: Bearer $(staff "concurrency-admin-$RUN_ID")")
+request GET "$V1/admin/concurrency" "" "${ADMIN[@]}"
+ORIGINAL_LIMITS=$(python3 -c "import json, sys; print(json.dumps(json.loads(sys.argv[1])['limits']))" "$BODY")
+request PUT "$V1/admin/concurrency" '{"global": 0, "writes": 0, "retry_after_seconds": 1}' "${ADMIN[@]}"
+check "admin lifts the write cap" "s == 200"
+on_exit 'request PUT "$V1/admin/concurrency" "$ORIGINAL_LIMITS" "${ADMIN[@]}"'
+IMBALANCE=$(imbalance)
+
+echo
+echo "$WRITERS deposits to one account, $WRITERS to separate accounts and $WRITERS transfers, with reads alongside"
+seq "$WRITERS" | xargs -P "$WRITERS" -I{} curl -s -o /dev/null -w "%{http_code}\n" \
+    -X POST "$V1/transactions" -H "Content-Type: application/json" \
+    -d "{\"account_id\": $SHARED, \"transaction_type\": \"deposit\", \"amount\": 1}" > "$WORK/shared" &
+xargs -P "$WRITERS" -I{} curl -s -o /dev/null -w "%{http_code}\n" \
+    -X POST "$V1/transactions" -H "Content-Type: application/json" \
+    -d "{\"account_id\": {}, \"transaction_type\": \"deposit\", \"amount\": 1}" < "$WORK/accounts" > "$WORK/separate" &
+xargs -P "$WRITERS" -I{} curl -s -o /dev/null -w "%{http_code}\n" \
+    -X POST "$V1/transfers" -H "Content-Type: application/json" \
+    -d "{\"from_account_id\": $SOURCE, \"to_account_id\": {}, \"amount\": 1, \"reference\": \"concurrency-{}\"}" < "$WORK/accounts" > "$WORK/transfers" &
+seq "$WRITERS" | xargs -P "$WRITERS" -I{} curl -s -o /dev/null -w "%{http_code}\n" \
+    "$V1/accounts/$SHARED/balance" > "$WORK/reads" &
+wait
+
+report "deposits to one account" "$WORK/shared" 201
+report "deposits to separate accounts" "$WORK/separate" 201
+report "transfers" "$WORK/transfers" 201
+assert "every read succeeded" "[ \$(grep -c '^200$' $WORK/reads) -eq $WRITERS ]"
+
+echo
+echo "Afterwards"
+assert "the shared account holds every deposit once" "[ \"$(balance "$SHARED")\" = \"$WRITERS\" ]"
+assert "the transfer source was drained exactly" "[ \"$(balance "$SOURCE")\" = \"0\" ]"
+assert "each separate account holds its deposit and transfer" \
+    "[ \"\$(while read -r a; do balance \"\$a\"; done < $WORK/accounts | sort -u)\" = \"2\" ]"
+assert "the postings left the books as balanced as they were" "[ \"$(imbalance)\" = \"$IMBALANCE\" ]"
+
+finish "concurrent write"
@@ -0,0 +1,133 @@
+#!/bin/bash
+
+# Consolidated Statement Tests
+# Checks GET /customers/:id/statements/:year/:month for a customer with several accounts and a loan: a section per
+# account with its opening and closing balances and postings, running balances that end at the closing balance, and
+# a summary whose assets, liabilities and net movement add up from the sections and the loan. Accounts opened after
+# the month or closed before it are left out, while one closed during the month stays in. Also checks the PDF
+# rendering and the errors for a bad period, format or customer. The accounts and postings are moved into earlier
+# months in the server's database, so DB_PATH must be the database the server uses. The memory budget of a large
+# statement is covered by go test ./handlers. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-consolidated-statements.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... ./test-consolidated-statements.sh
+
+source "$(dirname "$0")/test-lib.sh"
+CHECK_HELPERS="acc = {a['account_id']: a for a in (b or {}).get('accounts') or []} if isinstance(b, dict) else {}"
+
+echo " Consolidated Statement Tests"
+echo "============================="
+
+# month OFFSET [DAY] - prints a day of the month OFFSET months from this one as YYYY-MM-DD, the 1st by default
+month() {
+    python3 -c "
+import datetime, sys
+d = datetime.datetime.now(datetime.timezone.utc).date().replace(day=1)
+m = d.year * 12 + d.month - 1 + int(sys.argv[1])
+print(datetime.date(m // 12, m % 12 + 1, int(sys.argv[2])).isoformat())" "$1" "${2:-1}"
+}
+
+# period OFFSET - prints the statement path of the month OFFSET months from this one, e.g. 2026/3
+period() {
+    python3 -c "import sys; y, m, _ = sys.argv[1].split('-'); print(f'{y}/{int(m)}')" "$(month "$1")"
+}
+
+# account TYPE - opens an account for the customer and prints its id
+account() {
+    request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"$1\"}"
+    field "['account']['id']"
+}
+
+# post ACCOUNT TYPE AMOUNT DATE - posts a transaction and moves it to noon on DATE
+post() {
+    request POST "$V1/transactions" "{\"account_id\": $1, \"transaction_type\": \"$2\", \"amount\": $3}"
+    sql "UPDATE transactions SET effective_date = '$4 12:00:00+00:00', created_at = '$4 12:00:00+00:00' WHERE id = $(field "['transaction']['id']")" > /dev/null
+}
+
+# opened ACCOUNT DATE - moves an account's opening to DATE
+opened() {
+    sql "UPDATE accounts SET created_at = '$2 09:00:00+00:00' WHERE id = $1" > /dev/null
+}
+
+PERIOD=$(month -1)
+MONTH=$(period -1)
+
+echo "Setup"
+request POST "$V1/customers" "{\"first_name\": \"Consolidated\", \"last_name\": \"Holder\", \"email\": \"consolidated-$RUN_ID@example.test\", \"date_of_birth\": \"1980-01-01\"}"
+CUSTOMER=$(field "['customer']['id']")
+CHECKING=$(account checking)
+SAVINGS=$(account savings)
+CLOSED_DURING=$(account checking)
+CLOSED_BEFORE=$(account checking)
+OPENED_AFTER=$(account savings)
+for id in $CHECKING $SAVINGS $CLOSED_DURING $CLOSED_BEFORE; do
+    opened "$id" "$(month -3)"
+done
+post "$CHECKING" deposit 1000 "$(month -2 10)"
+post "$CHECKING" deposit 250 "$(month -1 5)"
+post "$CHECKING" withdrawal 100 "$(month -1 12)"
+post "$CHECKING" withdrawal 40 "$(month -1 20)"
+post "$SAVINGS" deposit 500 "$(month -1 15)"
+post "$CLOSED_DURING" deposit 75 "$(month -2 3)"
+post "$CLOSED_DURING" withdrawal 75 "$(month -1 8)"
+post "$OPENED_AFTER" deposit 60 "$(month 0)"
+sql "UPDATE accounts SET status = 'closed', updated_at = '$(month -1 9) 09:00:00+00:00' WHERE id = $CLOSED_DURING" > /dev/null
+sql "UPDATE accounts SET status = 'closed', updated_at = '$(month -2 1) 09:00:00+00:00' WHERE id = $CLOSED_BEFORE" > /dev/null
+request POST "$V1/loans?disburse=false" "{\"customer_id\": $CUSTOMER, \"principal_amount\": 3000, \"interest_rate\": 0.05, \"loan_term\": 24}"
+LOAN=$(field "['loan']['id']")
+sql "UPDATE loans SET created_at = '$(month -3) 09:00:00+00:00', remaining_balance = 2400 WHERE id = $LOAN" > /dev/null
+check "a customer with accounts, postings and a loan exists" "s == 201 and '$CUSTOMER$CHECKING$SAVINGS$CLOSED_DURING$CLOSED_BEFORE$OPENED_AFTER$LOAN'.isdigit()"
+
+echo
+echo "Sections"
+request GET "$V1/customers/$CUSTOMER/statements/$MONTH"
+check "the statement covers last month" "s == 200 and b['customer_id'] == $CUSTOMER and b['period_start'][:10] == '$PERIOD'"
+check "accounts held during the month each have a section" "sorted(acc) == sorted([$CHECKING, $SAVINGS, $CLOSED_DURING])"
+check "an account opened after the month is left out" "$OPENED_AFTER not in acc"
+check "an account closed before the month is left out" "$CLOSED_BEFORE not in acc"
+check "an account's opening balance carries earlier postings" \
+    "acc[$CHECKING]['summary']['opening_balance'] == 1000 and acc[$CHECKING]['summary']['closing_balance'] == 1110"
+check "sections list the month's postings only" \
+    "[l['amount'] for l in acc[$CHECKING]['lines']] == [250, -100, -40] and len(acc[$SAVINGS]['lines']) == 1"
+check "running balances end at the closing balance" \
+    "all((a['lines'][-1]['balance'] if a['lines'] else a['summary']['opening_balance']) == a['summary']['closing_balance'] for a in acc.values())"
+check "an account closed during the month shows its run-down" \
+    "acc[$CLOSED_DURING]['summary']['opening_balance'] == 75 and acc[$CLOSED_DURING]['summary']['closing_balance'] == 0"
+
+echo
+echo "Summary"
+check "total assets add up the closing balances" "b['total_assets'] == 1610 == sum(a['summary']['closing_balance'] for a in acc.values())"
+check "total liabilities are the outstanding loan balance" \
+    "b['total_liabilities'] == 2400 and [l['loan_id'] for l in b['loans']] == [$LOAN] and b['loans'][0]['remaining_balance'] == 2400"
+check "net movement is the month's credits less debits" \
+    "b['net_movement'] == 535 == sum(a['summary']['total_credits'] - a['summary']['total_debits'] for a in acc.values())"
+check "the statement is in the base currency" "b['base_currency'] == 'USD' and all(a.get('converted') is None for a in acc.values())"
+request GET "$V1/customers/$CUSTOMER/statements/$(period -12)"
+check "a month before any account was opened has no sections or liabilities" \
+    "s == 200 and b['accounts'] == [] and b['loans'] == [] and b['total_assets'] == 0 and b['total_liabilities'] == 0"
+
+echo
+echo "PDF"
+HEADERS=$(mktemp)
+curl -s -D "$HEADERS" -o /tmp/consolidated-$RUN_ID.pdf "$V1/customers/$CUSTOMER/statements/$MONTH?format=pdf"
+BODY=$(python3 -c "
+import json, sys
+pdf = open(sys.argv[1], 'rb').read()
+print(json.dumps({'pdf': pdf[:5].decode('latin-1'), 'eof': pdf.rstrip().endswith(b'%%EOF'), 'size': len(pdf),
+                  'type': 'application/pdf' in open(sys.argv[2]).read()}))" "/tmp/consolidated-$RUN_ID.pdf" "$HEADERS")
+STATUS=200
+rm -f "$HEADERS" "/tmp/consolidated-$RUN_ID.pdf"
+check "?format=pdf returns a complete PDF document" "b['pdf'] == '%PDF-' and b['eof'] and b['type'] and b['size'] > 1000"
+
+echo
+echo "Errors"
+request GET "$V1/customers/$CUSTOMER/statements/2026/13"
+check "a month out of range is rejected" "s == 400"
+request GET "$V1/customers/$CUSTOMER/statements/last/month"
+check "a period that is not a number is rejected" "s == 400"
+request GET "$V1/customers/$CUSTOMER/statements/$MONTH?format=csv"
+check "an unsupported format is rejected" "s == 400"
+request GET "$V1/customers/999999999/statements/$MONTH"
+check "an unknown customer is not found" "s == 404"
+
+finish "consolidated statement"
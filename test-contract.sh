@@ -0,0 +1,98 @@
+#!/bin/bash
+
+# API Contract Tests
+# Exercises the v1 and v2 transaction and transfer endpoints against the same running server and
+# database, checking response shapes, error formats and deprecation headers. Also checks which account
+# types and currencies can be opened. Exits non-zero on failure.
+#
+# Usage: ./test-contract.sh            (server on localhost:8080)
+#        BASE_URL=http://host:port ./test-contract.sh
+
+source "$(dirname "$0")/test-lib.sh"
+V2="$BASE_URL/api/v2"
+
+echo " API Contract Tests (v1 and v2)"
+echo "==============================="
+
+echo "Setup"
+request POST "$V1/customers" "{\"first_name\": \"Contract\", \"last_name\": \"Test\", \"email\": \"contract-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}"
+check "customer created" "s == 201"
+CUSTOMER_ID=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER_ID, \"account_type\": \"checking\"}"
+FROM=$(field "['account']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER_ID, \"account_type\": \"savings\"}"
+TO=$(field "['account']['id']")
+
+echo "v1 transactions"
+request POST "$V1/transactions" "{\"account_id\": $FROM, \"transaction_type\": \"deposit\", \"amount\": 100.25}"
+check "v1 deposit returns 201" "s == 201"
+check "v1 amounts are numbers" "isinstance(b['transaction']['amount'], float) and b['transaction']['balance_after'] == 100.25"
+check "v1 replaced endpoint sends Deprecation" "h.get('deprecation') == 'true'"
+check "v1 replaced endpoint sends Sunset" "h.get('sunset')"
+check "v1 replaced endpoint links its successor" "h.get('link') == '</api/v2/transactions>; rel=\"successor-version\"'"
+request POST "$V1/transactions" "{\"account_id\": $FROM, \"transaction_type\": \"bogus\", \"amount\": 1}"
+check "v1 errors are a message string" "s == 400 and b == {'error': 'Invalid transaction type'}"
+request GET "$V1/accounts/$FROM"
+check "v1 endpoint without a replacement is not deprecated" "'deprecation' not in h"
+
+echo "v2 transactions"
+request POST "$V2/transactions" "{\"account_id\": $FROM, \"transaction_type\": \"deposit\", \"amount\": \"50.10\"}"
+check "v2 deposit returns 201" "s == 201"
+check "v2 amounts are decimal strings" "b['data']['amount'] == '50.10' and b['data']['balance_after'] == '150.35'"
+check "v2 responses are not deprecated" "'deprecation' not in h"
+request POST "$V2/transactions" "{\"account_id\": $FROM, \"transaction_type\": \"deposit\", \"amount\": 50.10}"
+check "v2 rejects numeric amounts" "s == 400 and b['error']['code'] == 'INVALID_REQUEST'"
+request POST "$V2/transactions" "{\"account_id\": $FROM, \"transaction_type\": \"deposit\", \"amount\": \"1.005\"}"
+check "v2 rejects sub-cent amounts" "s == 400 and b['error']['code'] == 'INVALID_AMOUNT'"
+request POST "$V2/transactions" "{\"account_id\": $FROM, \"transaction_type\": \"withdrawal\", \"amount\": \"1000000.00\"}"
+check "v2 errors use the code envelope" "s == 400 and b['error']['code'] in ('INSUFFICIENT_FUNDS', 'AMOUNT_LIMIT_EXCEEDED') and b['error']['message']"
+
+echo "Shared database"
+request GET "$V2/transactions?account_id=$FROM&limit=100"
+check "v2 list sees the v1 posting" "any(t['amount'] == '100.25' for t in b['data']) and b['meta']['total'] == 2"
+request GET "$V1/transactions?account_id=$FROM&limit=100"
+check "v1 list sees the v2 posting" "any(t['amount'] == 50.1 for t in b['transactions']) and b['total'] == 2"
+request GET "$V2/accounts/$FROM"
+check "v2 falls through to v1 for endpoints it does not override" "s == 200 and b['id'] == $FROM and b['balance'] == 150.35"
+
+echo "Transfers"
+request POST "$V2/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $TO, \"amount\": \"20.00\"}"
+check "v2 transfer returns 201 with a string amount" "s == 201 and b['data']['amount'] == '20.00'"
+request POST "$V2/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $TO, \"amount\": \"20.00\"}"
+check "v2 duplicate carries details in the envelope" "s == 409 and b['error']['code'] == 'DUPLICATE_SUSPECTED' and 'original_transfer_id' in b['error']['details']"
+request POST "$V1/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $TO, \"amount\": 20}"
+check "v1 sees the v2 transfer as a duplicate, in the v1 format" "s == 409 and b['code'] == 'DUPLICATE_SUSPECTED' and 'original_transfer_id' in b"
+check "v1 transfers are deprecated" "h.get('link', '').startswith('</api/v2/transfers>')"
+request POST "$V1/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $TO, \"amount\": 5.5}"
+check "v1 transfer returns 201 with a numeric amount" "s == 201 and b['transfer']['amount'] == 5.5"
+
+echo "Account opening"
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER_ID, \"account_type\": \"loan\"}"
+check "loan accounts point to the loan API" "s == 400 and b['code'] == 'RESERVED_ACCOUNT_TYPE' and b['account_type'] == 'loan' and '/api/v1/loans' in b['error']"
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER_ID, \"account_type\": \"credit_line\"}"
+check "credit line accounts are reserved" "s == 400 and b['code'] == 'RESERVED_ACCOUNT_TYPE' and b['account_type'] == 'credit_line'"
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER_ID, \"account_type\": \"internal\"}"
+check "internal ledger accounts are reserved" "s == 400 and b['code'] == 'RESERVED_ACCOUNT_TYPE' and b['account_type'] == 'internal'"
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER_ID, \"account_type\": \"bogus\"}"
+check "unknown types are rejected with the allowed list" "s == 400 and b['code'] == 'INVALID_ACCOUNT_TYPE' and b['account_type'] == 'bogus' and {'checking', 'savings'} <= set(b['allowed'])"
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER_ID}"
+check "a missing type is rejected" "s == 400 and b['code'] == 'INVALID_ACCOUNT_TYPE' and b['account_type'] == ''"
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER_ID, \"account_type\": \"checking\", \"currency\": \"XYZ\"}"
+check "unsupported currencies are rejected with the supported list" "s == 400 and b['code'] == 'UNSUPPORTED_CURRENCY' and b['currency'] == 'XYZ' and 'USD' in b['supported']"
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER_ID, \"account_type\": \"savings\", \"currency\": \"eur\"}"
+check "a supported currency is kept" "s == 201 and b['account']['currency'] == 'EUR'"
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER_ID, \"account_type\": \"savings\"}"
+check "the currency defaults to the tenant's" "s == 201 and b['account']['currency'] == 'USD'"
+
+echo "Metrics"
+METRICS=$(curl -s "$BASE_URL/metrics")
+for version in v1 v2; do
+    if echo "$METRICS" | grep -q "api_requests_total{version=\"$version\"}"; then
+        echo "  ✓ $version requests are counted"
+    else
+        echo "  ✗ $version requests are counted"
+        FAILURES=$((FAILURES + 1))
+    fi
+done
+
+finish "contract"
@@ -0,0 +1,77 @@
+#!/bin/bash
+
+# Credit Bureau Timeout Tests
+# Starts its own server with the HTTP credit bureau pointed at a fake bureau that never answers in time, and checks
+# that a loan application comes back promptly as a clean referral to manual review once the client timeout and its
+# retries run out, instead of hanging or failing with a server error. While that application waits on the bureau,
+# a deposit and an account opening must still complete at once: the bureau call holds no database write turn. The
+# server, bureau and database are the script's own, so no other server is needed. Exits non-zero on failure.
+#
+# Usage: ./test-credit-bureau-timeout.sh                  (builds the server with go build)
+#        SERVER_BIN=./banking-app PORT=18099 BUREAU_PORT=18100 ./test-credit-bureau-timeout.sh
+
+source "$(dirname "$0")/test-lib.sh"
+serve 18099
+BUREAU_PORT="${BUREAU_PORT:-18100}"
+
+echo " Credit Bureau Timeout Tests"
+echo "============================"
+
+# slow_bureau - accepts credit checks and holds each one for a minute without answering, counting the attempts
+slow_bureau() {
+    python3 -c "
+import http.server, sys, time
+class Bureau(http.server.BaseHTTPRequestHandler):
+    def do_POST(self):
+        with open(sys.argv[2] + '/attempts', 'a') as f:
+            f.write('.')
+        time.sleep(60)
+    def log_message(self, *args):
+        pass
+http.server.ThreadingHTTPServer(('127.0.0.1', int(sys.argv[1])), Bureau).serve_forever()
+" "$BUREAU_PORT" "$WORK" &
+    background $!
+}
+
+build
+
+echo "Setup"
+slow_bureau
+# A one second timeout with two retries gives up after about 4.5 seconds: 1 + 0.5 + 1 + 1 + 1
+start_server "$SERVER_BIN" CREDIT_BUREAU=http CREDIT_BUREAU_URL="http://127.0.0.1:$BUREAU_PORT/check" \
+    CREDIT_BUREAU_TIMEOUT_SECONDS=1 CREDIT_BUREAU_RETRIES=2
+request POST "$V1/customers" "{\"first_name\": \"Slow\", \"last_name\": \"Bureau\", \"email\": \"slow-$RUN_ID@example.test\", \"date_of_birth\": \"1980-01-01\"}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}"
+ACCOUNT=$(field "['account']['id']")
+check "a customer and account exist" "s == 201 and '$CUSTOMER$ACCOUNT'.isdigit()"
+
+echo
+echo "A bureau that never answers"
+request POST "$V1/loans" "{\"customer_id\": $CUSTOMER, \"principal_amount\": 1000, \"interest_rate\": 0.05, \"loan_term\": 12}"
+check "the application is answered once the timeout and retries run out" "s == 201 and 4 <= t < 10"
+check "it is referred for manual review as a bureau outage" \
+    "b['loan']['status'] == 'pending' and b['loan']['credit_decision'] == 'manual_review' and b['loan']['credit_decision_reason'] == 'credit bureau unavailable' and 'credit_score' not in b['loan']"
+check "the bureau was tried three times" "len(open('$WORK/attempts').read()) == 3"
+LOAN=$(field "['loan']['id']")
+check "the referral is saved" \
+    "__import__('sqlite3').connect('$DB_PATH', timeout=10).execute('SELECT credit_decision FROM loans WHERE id = ?', ($LOAN,)).fetchone() == ('manual_review',)"
+
+echo
+echo "Writes while the bureau is waited on"
+curl -s -o "$WORK/slow" -w '%{http_code}' -X POST "$V1/loans" -H "Content-Type: application/json" \
+    -d "{\"customer_id\": $CUSTOMER, \"principal_amount\": 500, \"interest_rate\": 0.05, \"loan_term\": 12}" > "$WORK/slow.status" &
+SLOW_PID=$!
+sleep 1
+request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 25}"
+check "a deposit completes at once" "s == 201 and t < 1"
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"savings\"}"
+check "an account opening completes at once" "s == 201 and t < 1"
+kill -0 "$SLOW_PID" 2>/dev/null
+STILL_WAITING=$?
+wait "$SLOW_PID"
+BODY=$(cat "$WORK/slow") STATUS=$(cat "$WORK/slow.status") ELAPSED=0
+check "the application was still waiting on the bureau meanwhile" "$STILL_WAITING == 0"
+check "and was then referred as well" "s == 201 and b['loan']['credit_decision'] == 'manual_review'"
+
+finish "credit bureau timeout"
@@ -0,0 +1,133 @@
+#!/bin/bash
+
+# Soft Credit Check Tests
+# Checks the credit review step of loan and line of credit applications against the stub bureau: high scores are
+# approved and disbursed, low scores declined for good, scores in between referred to staff, and a bureau outage
+# referred rather than blocking. Also checks the manual review endpoint's permissions and that decisions reach the
+# outbox without the score. Run the server with the stub bureau and these scores:
+#
+#   CREDIT_BUREAU=stub CREDIT_BUREAU_STUB_SCORES="credit-high@example.test=780,credit-mid@example.test=650,\
+#   credit-low@example.test=520,credit-down@example.test=unavailable" ./banking-app
+#
+# An admin and a teller are created with bankctl against the server's database, so DB_PATH must be the database
+# the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-credit-checks.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-credit-checks.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="credit-test-$RUN_ID"
+
+echo " Soft Credit Check Tests"
+echo "========================"
+
+# customer KEY - prints the id of the customer with the stub's email for KEY, creating it on the first run
+customer() {
+    local email="credit-$1@example.test" id
+    id=$(sql "SELECT id FROM customers WHERE email = ? AND deleted_at IS NULL" "$email")
+    if [ -z "$id" ]; then
+        request POST "$V1/customers" "{\"first_name\": \"$1\", \"last_name\": \"Applicant\", \"email\": \"$email\", \"date_of_birth\": \"1980-01-01\"}"
+        id=$(field "['customer']['id']")
+    fi
+    echo "$id"
+}
+
+# apply CUSTOMER [QUERY] - applies for a loan, disbursed at once unless QUERY is ?disburse=false
+apply() {
+    request POST "$V1/loans$2" "{\"customer_id\": $1, \"principal_amount\": 1000, \"interest_rate\": 0.05, \"loan_term\": 12}"
+}
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "credit-admin-$RUN_ID" admin)")
+TELLER=(-H "Authorization: Bearer $(staff "credit-teller-$RUN_ID" teller)")
+HIGH=$(customer high)
+MID=$(customer mid)
+LOW=$(customer low)
+DOWN=$(customer down)
+request GET "$V1/customers/$DOWN"
+check "four applicants exist" "s == 200 and '$HIGH$MID$LOW$DOWN'.isdigit()"
+
+echo
+echo "Automatic decisions"
+apply "$HIGH"
+check "a score above the approval cutoff is approved and disbursed" \
+    "s == 201 and b['loan']['status'] == 'active' and b['loan']['credit_decision'] == 'approved' and b['loan']['credit_score'] == 780"
+check "the score and report id are stored on the application" \
+    "b['loan']['credit_report_id'].startswith('STUB-') and b['loan']['credit_checked_at']"
+apply "$LOW"
+check "a score below the decline cutoff is declined" \
+    "s == 201 and b['loan']['status'] == 'declined' and b['loan']['credit_decision'] == 'declined' and b['loan']['account_id'] == 0"
+DECLINED=$(field "['loan']['id']")
+request POST "$V1/loans/$DECLINED/disburse" "" "${ADMIN[@]}"
+check "a declined application cannot be disbursed" "s == 409 and b['code'] == 'CREDIT_DECLINED'"
+apply "$MID"
+check "a score in between is referred for manual review" \
+    "s == 201 and b['loan']['status'] == 'pending' and b['loan']['credit_decision'] == 'manual_review' and b['loan']['credit_score'] == 650"
+REFERRED=$(field "['loan']['id']")
+request POST "$V1/loans/$REFERRED/disburse" "" "${ADMIN[@]}"
+check "a referred application waits for staff" "s == 409 and b['code'] == 'CREDIT_REVIEW_PENDING'"
+
+echo
+echo "Bureau outage"
+apply "$DOWN"
+check "an outage refers the application instead of blocking it" \
+    "s == 201 and b['loan']['status'] == 'pending' and b['loan']['credit_decision'] == 'manual_review' and b['loan']['credit_decision_reason'] == 'credit bureau unavailable' and 'credit_score' not in b['loan']"
+
+echo
+echo "Manual review"
+request POST "$V1/loans/$REFERRED/credit-review" "{\"decision\": \"approve\", \"reason\": \"Stable income\"}"
+check "reviewing needs a login" "s == 401"
+request POST "$V1/loans/$REFERRED/credit-review" "{\"decision\": \"approve\", \"reason\": \"Stable income\"}" "${TELLER[@]}"
+check "tellers cannot decide referrals" "s == 403"
+request POST "$V1/loans/$REFERRED/credit-review" "{\"decision\": \"maybe\", \"reason\": \"Unsure\"}" "${ADMIN[@]}"
+check "the decision is approve or decline" "s == 400"
+request POST "$V1/loans/$REFERRED/credit-review" "{\"decision\": \"approve\"}" "${ADMIN[@]}"
+check "a reason is required" "s == 400"
+request POST "$V1/loans/$REFERRED/credit-review" "{\"decision\": \"approve\", \"reason\": \"Stable income\"}" "${ADMIN[@]}"
+check "an admin approves a referral" \
+    "s == 200 and b['loan']['credit_decision'] == 'approved' and b['loan']['credit_reviewed_by'] == 'credit-admin-$RUN_ID' and b['loan']['credit_decision_reason'] == 'Stable income'"
+request POST "$V1/loans/$REFERRED/credit-review" "{\"decision\": \"decline\", \"reason\": \"Changed mind\"}" "${ADMIN[@]}"
+check "a decided referral cannot be reviewed again" "s == 409 and b['code'] == 'CREDIT_REVIEW_NOT_PENDING'"
+request POST "$V1/loans/$REFERRED/disburse" "" "${ADMIN[@]}"
+check "the approved referral is disbursed without a new pull" \
+    "s == 200 and b['loan']['status'] == 'active' and b['loan']['credit_reviewed_by'] == 'credit-admin-$RUN_ID'"
+request POST "$V1/loans/$DECLINED/credit-review" "{\"decision\": \"approve\", \"reason\": \"Appeal\"}" "${ADMIN[@]}"
+check "a declined application cannot be reviewed" "s == 409"
+
+echo
+echo "Review at disbursement"
+apply "$HIGH" "?disburse=false"
+check "an application left pending is not checked yet" "s == 201 and b['loan']['status'] == 'pending' and 'credit_decision' not in b['loan']"
+LATER=$(field "['loan']['id']")
+request POST "$V1/loans/$LATER/disburse" "" "${ADMIN[@]}"
+check "disbursing it runs the credit check" "s == 200 and b['loan']['status'] == 'active' and b['loan']['credit_decision'] == 'approved'"
+apply "$MID" "?disburse=false"
+PENDING=$(field "['loan']['id']")
+request POST "$V1/loans/$PENDING/disburse" "" "${ADMIN[@]}"
+check "a referral at disbursement is accepted for review" "s == 202 and b['loan']['credit_decision'] == 'manual_review'"
+request POST "$V1/loans/$PENDING/credit-review" "{\"decision\": \"decline\", \"reason\": \"Thin file\"}" "${ADMIN[@]}"
+check "an admin declines a referral" "s == 200 and b['loan']['status'] == 'declined' and b['loan']['credit_decision'] == 'declined'"
+
+echo
+echo "Lines of credit"
+request POST "$V1/accounts" "{\"customer_id\": $LOW, \"account_type\": \"checking\"}"
+CHECKING=$(field "['account']['id']")
+request POST "$V1/credit-lines" "{\"linked_account_id\": $CHECKING, \"credit_limit\": 500, \"interest_rate\": 0.18}" "${ADMIN[@]}"
+LINE=$(field "['credit_line']['id']")
+request POST "$V1/credit-lines/$LINE/activate" "" "${ADMIN[@]}"
+check "activating a line runs the credit check" "s == 422 and b['code'] == 'CREDIT_DECLINED'"
+request GET "$V1/credit-lines/$LINE" "" "${ADMIN[@]}"
+check "the declined line is closed to activation" "s == 200 and b['status'] == 'declined'"
+request POST "$V1/credit-lines/$LINE/activate" "" "${ADMIN[@]}"
+check "it cannot be activated again" "s == 409 and b['code'] == 'CREDIT_DECLINED'"
+request POST "$V1/credit-lines" "{\"linked_account_id\": $CHECKING, \"credit_limit\": 300, \"interest_rate\": 0.18}" "${ADMIN[@]}"
+check "the account can apply for a new line" "s == 201"
+
+echo
+echo "Outbox"
+check "every decision is an event" \
+    "$(sql "SELECT count(*) FROM outbox_events WHERE event_type = 'loan.credit_decided' AND aggregate_id IN (?, ?, ?)" "$DECLINED" "$REFERRED" "$PENDING") == 5"
+check "events carry the decision but not the score" \
+    "all('\"decision\"' in p and 'score' not in p for p in '''$(sql "SELECT payload FROM outbox_events WHERE event_type = 'loan.credit_decided' AND aggregate_id = ?" "$REFERRED")'''.splitlines())"
+
+finish "credit"
@@ -0,0 +1,186 @@
+#!/bin/bash
+
+# Custom Statement Tests
+# Requests statements of an account for custom date ranges: a small range is generated at once as JSON, CSV or PDF,
+# opening with the account's as-of balance for the day before the range and listing the holds pending during it in
+# a separate section; a large range is queued and downloaded once the worker has generated it. Dates after today,
+# before the account opened, reversed or longer than the limit are refused, customers only reach their own accounts,
+# and generated statements are archived as ad hoc without disturbing the monthly numbering. Postings, holds and the
+# account's opening are backdated in the server's database, and the platform admin and customer user are created
+# with bankctl, so DB_PATH must be the database the server uses. The server must run with
+# STATEMENT_CUSTOM_SYNC_LIMIT=2 and the default STATEMENT_CUSTOM_MAX_MONTHS. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-custom-statements.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-custom-statements.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="custom-statement-test-$RUN_ID-Aa1!"
+PLATFORM_USER="custom-statement-platform-$RUN_ID"
+TENANT_CODE="cst$RUN_ID"
+
+echo " Custom Statement Tests"
+echo "======================="
+
+# day N - prints the date N days before today as YYYY-MM-DD; negative N is in the future
+day() {
+    python3 -c "
+import datetime, sys
+print(datetime.date.today() - datetime.timedelta(days=int(sys.argv[1])))" "$1"
+}
+
+# at N - prints noon N days ago as a database timestamp
+at() {
+    echo "$(day "$1") 12:00:00+00:00"
+}
+
+# post TYPE AMOUNT N - posts to ACCOUNT and backdates the posting to N days ago
+post() {
+    request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"$1\", \"amount\": $2}" "${AUTH[@]}"
+    sql "UPDATE transactions SET effective_date = '$(at "$3")', created_at = '$(at "$3")' WHERE id = $(field "['transaction']['id']")" > /dev/null
+}
+
+# hold AMOUNT MERCHANT N - places a card hold on ACCOUNT, backdated to N days ago, and stores its ID in HOLD
+hold() {
+    request POST "$V1/accounts/$ACCOUNT/authorizations" "{\"amount\": $1, \"channel\": \"card\", \"merchant\": \"$2\"}" "${AUTH[@]}"
+    HOLD=$(field "['authorization']['id']")
+    sql "UPDATE authorizations SET created_at = '$(at "$3")' WHERE id = $HOLD" > /dev/null
+}
+
+# custom FROM TO FORMAT [AUTH_ARRAY] - requests a custom statement of ACCOUNT
+custom() {
+    local -n auth=${4:-AUTH}
+    request POST "$V1/accounts/$ACCOUNT/statements/custom" "{\"from\": \"$1\", \"to\": \"$2\", \"format\": \"$3\"}" "${auth[@]}"
+}
+
+# download REQUEST - fetches a request's document into $WORK/document and its headers into $WORK/headers
+download() {
+    STATUS=$(curl -s -o "$WORK/document" -D "$WORK/headers" -w '%{http_code}' "$V1/accounts/$ACCOUNT/statements/custom/$1/download" "${AUTH[@]}")
+}
+
+echo "Setup"
+tenant "$PLATFORM_USER" "Custom Statements" custom-statement-admin
+request POST "$V1/customers" "{\"first_name\": \"Cora\", \"last_name\": \"Range\", \"email\": \"custom-statement-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+ACCOUNT=$(field "['account']['id']")
+request POST "$V1/customers" "{\"first_name\": \"Otto\", \"last_name\": \"Other\", \"email\": \"custom-statement-other-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+request POST "$V1/accounts" "{\"customer_id\": $(field "['customer']['id']"), \"account_type\": \"checking\"}" "${AUTH[@]}"
+OTHER_ACCOUNT=$(field "['account']['id']")
+sql "UPDATE accounts SET created_at = '$(at 40)' WHERE id = $ACCOUNT" > /dev/null
+# Postings 30, 20, 10 and 2 days ago; the range 25 to 5 days ago holds the middle two
+post deposit 1000 30
+post withdrawal 200 20
+post deposit 50 10
+post deposit 5 2
+# Holds: pending since 15 days ago, released 3 days ago after being placed before the range, and one settled
+# before the range began
+hold 75 "Pending Hotel" 15
+hold 20 "Released Cafe" 28
+request POST "$V1/authorizations/$HOLD/release" "" "${AUTH[@]}"
+sql "UPDATE authorizations SET closed_at = '$(at 3)' WHERE id = $HOLD" > /dev/null
+hold 10 "Old Kiosk" 35
+request POST "$V1/authorizations/$HOLD/release" "" "${AUTH[@]}"
+sql "UPDATE authorizations SET closed_at = '$(at 32)' WHERE id = $HOLD" > /dev/null
+CUSTOMER_AUTH=(-H "Authorization: Bearer $(customer_user "custom-statement-customer" "$CUSTOMER" "$TENANT_CODE")")
+FROM=$(day 25)
+TO=$(day 5)
+
+echo
+echo "Generated at once"
+request GET "$V1/accounts/$ACCOUNT/balance?as_of=$(day 26)" "" "${AUTH[@]}"
+AS_OF=$(field "['balance']")
+custom "$FROM" "$TO" json
+check "a small range is generated while the caller waits" \
+    "s == 201 and b['request']['status'] == 'ready' and b['request']['async'] is False and b['download_url'].endswith('/download')"
+check "it is archived as an ad hoc statement" \
+    "b['statement']['ad_hoc'] is True and b['statement']['sequence'] == 0 and b['statement']['format'] == 'json' and b['statement']['delivery'] == 'archived'"
+JSON_REQUEST=$(field "['request']['id']")
+download "$JSON_REQUEST"
+BODY=$(cat "$WORK/document")
+check "the opening balance is the as-of balance of the day before from" "b['opening_balance'] == $AS_OF == 1000"
+check "only postings in the range are listed, with running balances" \
+    "[(l['amount'], l['balance']) for l in b['lines']] == [(-200, 800), (50, 850)] and b['closing_balance'] == 850"
+check "the totals cover the range" "b['total_credits'] == 50 and b['total_debits'] == 200"
+check "holds pending during the range are listed separately with their status now" \
+    "[(p['kind'], p['description'], p['amount'], p['status']) for p in b['pending']] == [('authorization', 'Released Cafe', -20, 'released'), ('authorization', 'Pending Hotel', -75, 'pending')]"
+check "the document is served as JSON" "'application/json' in open('$WORK/headers').read().lower()"
+
+custom "$FROM" "$TO" csv
+CSV_REQUEST=$(field "['request']['id']")
+download "$CSV_REQUEST"
+check "CSV lists the postings then the pending holds" \
+    "s == 200 and [(r[3], r[4], r[5]) for r in __import__('csv').reader(open('$WORK/document'))][-3:] == [('closing_balance', '', ''), ('pending_authorization', 'Released Cafe', 'released'), ('pending_authorization', 'Pending Hotel', 'pending')]"
+check "and is named by its range" "'statement-$ACCOUNT-$FROM-$TO.csv' in open('$WORK/headers').read()"
+
+custom "$FROM" "$TO" pdf
+download "$(field "['request']['id']")"
+check "PDF is the default document" "s == 200 and open('$WORK/document', 'rb').read(4) == b'%PDF'"
+
+echo
+echo "Validation"
+custom "$TO" "$FROM" json
+check "to before from is refused" "s == 400 and 'before' in b['error']"
+custom "$FROM" "$(day -1)" json
+check "a range ending in the future is refused" "s == 400 and 'future' in b['error']"
+custom "$(day 800)" "$TO" json
+check "a range longer than 24 months is refused" "s == 400 and '24 months' in b['error']"
+custom "$(day 45)" "$TO" json
+check "a range starting before the account opened is refused" "s == 400 and 'opened' in b['error']"
+custom "$FROM" "$TO" xml
+check "an unknown format is refused" "s == 400"
+custom "2026-13-01" "$TO" json
+check "malformed dates are refused" "s == 400"
+
+echo
+echo "Queued"
+custom "$(day 35)" "$(day 0)" json
+check "a range over the sync limit is queued" "s == 202 and b['request']['async'] is True and b['request']['status'] == 'queued'"
+QUEUED=$(field "['request']['id']")
+STATUS_URL=$(field "['status_url']")
+for _ in $(seq 1 50); do
+    sleep 0.2
+    request GET "$BASE_URL$STATUS_URL" "" "${AUTH[@]}"
+    [ "$(field "['request']['status']")" = "ready" ] && break
+done
+check "the worker generates it" "s == 200 and b['request']['status'] == 'ready' and b['download_url'].endswith('/download')"
+download "$QUEUED"
+BODY=$(cat "$WORK/document")
+check "its statement covers the whole range" \
+    "s == 200 and b['opening_balance'] == 0 and len(b['lines']) == 4 and b['closing_balance'] == 855 and len(b['pending']) == 3"
+ID=$(sql "INSERT INTO statement_requests (created_at, updated_at, tenant_id, account_id, customer_id, period_start, period_end, format, requested_by, async, status) SELECT created_at, updated_at, tenant_id, account_id, customer_id, period_start, period_end, format, requested_by, 1, 'failed' FROM statement_requests WHERE id = $QUEUED RETURNING id")
+download "$ID"
+check "a statement that is not ready cannot be downloaded" "s == 409"
+
+echo
+echo "Access"
+custom "$FROM" "$TO" json CUSTOMER_AUTH
+check "customers can request statements of their own accounts" "s == 201"
+request POST "$V1/accounts/$OTHER_ACCOUNT/statements/custom" "{\"from\": \"$(day 0)\", \"to\": \"$(day 0)\"}" "${CUSTOMER_AUTH[@]}"
+check "but not of other customers' accounts" "s == 404"
+request GET "$V1/accounts/$ACCOUNT/statements/custom/$JSON_REQUEST" "" "${CUSTOMER_AUTH[@]}"
+check "and can follow their requests" "s == 200 and b['request']['status'] == 'ready'"
+request GET "$V1/accounts/$OTHER_ACCOUNT/statements/custom/$JSON_REQUEST" "" "${AUTH[@]}"
+check "a request is only found under its own account" "s == 404"
+request POST "$V1/accounts/$ACCOUNT/statements/custom" "{\"from\": \"$FROM\", \"to\": \"$TO\"}"
+check "anonymous callers cannot request statements" "s == 401"
+
+echo
+echo "Archive"
+request GET "$V1/accounts/$ACCOUNT/statements?ad_hoc=true" "" "${AUTH[@]}"
+check "the archive lists the ad hoc statements" "s == 200 and b['total'] == 5 and all(st['ad_hoc'] for st in b['statements'])"
+check "and they leave no gaps in the monthly sequence" "b['gaps'] == [] and b['complete'] is True"
+request GET "$V1/accounts/$ACCOUNT/statements?ad_hoc=false" "" "${AUTH[@]}"
+check "no monthly statement is archived yet" "b['total'] == 0"
+request GET "$V1/customers/$CUSTOMER/communications" "" "${AUTH[@]}"
+check "each is logged in the customer's communication log" \
+    "len([m for m in b['communications'] if m['channel'] == 'archive' and m['resource_type'] == 'statement']) == 5"
+LAST_MONTH=$(python3 -c "
+import datetime
+d = datetime.date.today().replace(day=1) - datetime.timedelta(days=1)
+print('%d/%d' % (d.year, d.month))")
+request POST "$V1/accounts/$ACCOUNT/statements/$LAST_MONTH" "" "${AUTH[@]}"
+check "the first monthly statement is still numbered 1" "s == 201 and b['statement']['sequence'] == 1 and b['statement']['ad_hoc'] is False"
+request GET "$V1/accounts/$ACCOUNT/statements?ad_hoc=false" "" "${AUTH[@]}"
+check "and is the only monthly one" "b['total'] == 1 and b['complete'] is True"
+
+finish "custom statement"
@@ -0,0 +1,103 @@
+#!/bin/bash
+
+# Dashboard Tests
+# Reads the operations dashboard in a fresh tenant: today's postings by type and currency, new customers and
+# accounts, rejected postings by route and status, and the review, duplicate and exception queues, in a versioned
+# payload built within the query budget. A summary is cached for 30 seconds, so postings made meanwhile do not show
+# and an unchanged summary revalidates with 304. Job, webhook and connection pool blocks are shown to the platform
+# tenant only, and customers cannot read the dashboard. The platform admin and the tenant's customer user are
+# created with bankctl in the server's database, so DB_PATH must be the database the server uses. Exits non-zero
+# on failure.
+#
+# Usage: DB_PATH=banking.db ./test-dashboard.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-dashboard.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="dashboard-test-$RUN_ID-Aa1!"
+PLATFORM_USER="dashboard-platform-$RUN_ID"
+TENANT_CODE="dash$RUN_ID"
+QUERY_BUDGET=12 # dashboard.QueryBudget
+
+echo " Dashboard Tests"
+echo "================="
+
+# post ACCOUNT TYPE AMOUNT - posts a transaction as the tenant admin
+post() {
+    request POST "$V1/transactions" "{\"account_id\": $1, \"transaction_type\": \"$2\", \"amount\": $3}" "${AUTH[@]}"
+}
+
+# dashboard AUTH_ARRAY [CURL_ARGS...] - reads the dashboard
+dashboard() {
+    local -n auth=$1
+    shift
+    request GET "$V1/admin/dashboard" "" "${auth[@]}" "$@"
+}
+
+# Python expression over a dashboard body: today's postings as {(type, currency): (count, amount)}
+VOLUMES="{(v['transaction_type'], v['currency']): (v['count'], v['amount']) for v in b['transactions']}"
+
+echo "Setup"
+tenant "$PLATFORM_USER" "Dashboard" dashboard-admin
+request POST "$V1/customers" "{\"first_name\": \"Dana\", \"last_name\": \"Board\", \"email\": \"dashboard-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+CHECKING=$(field "['account']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"savings\", \"currency\": \"EUR\"}" "${AUTH[@]}"
+SAVINGS=$(field "['account']['id']")
+post "$CHECKING" deposit 500
+post "$CHECKING" deposit 250.25
+post "$CHECKING" withdrawal 100
+post "$SAVINGS" deposit 40
+post "$CHECKING" withdrawal 1000000
+check "an overdrawing withdrawal is rejected" "s >= 400"
+CUSTOMER_AUTH=(-H "Authorization: Bearer $(customer_user "dashboard-customer" "$CUSTOMER" "$TENANT_CODE")")
+
+echo
+echo "Today's numbers"
+dashboard AUTH
+check "the summary is versioned and computed for this request" "s == 200 and b['version'] == 1 and b['cached'] is False"
+check "it stays within the query budget" "0 < b['query_count'] <= $QUERY_BUDGET"
+check "postings are totalled by type and currency" \
+    "$VOLUMES == {('deposit', 'USD'): (2, 750.25), ('withdrawal', 'USD'): (1, 100), ('deposit', 'EUR'): (1, 40)}"
+check "new customers and accounts are counted" "b['onboarding'] == {'new_customers': 1, 'new_accounts': 2}"
+check "the rejected posting is counted by route and status" \
+    "[(f['route'], f['count']) for f in b['failed_transactions']] == [('/api/v1/transactions', 1)] and b['failed_transactions'][0]['status'] >= 400"
+check "the risk queues are empty" "b['risk'] == {'pending_reviews': 0, 'flagged_duplicates': 0, 'open_exceptions': 0}"
+check "deployment-wide blocks are left out for a tenant" "'jobs' not in b and 'webhooks' not in b and 'database' not in b"
+GENERATED=$(field "['generated_at']")
+
+echo
+echo "Caching"
+post "$CHECKING" deposit 5
+dashboard AUTH -D "$WORK/headers"
+check "a second read within 30 seconds is served from the cache" \
+    "s == 200 and b['cached'] is True and b['generated_at'] == '$GENERATED' and $VOLUMES[('deposit', 'USD')] == (2, 750.25)"
+ETAG=$(grep -i '^etag:' "$WORK/headers" | cut -d' ' -f2- | tr -d '\r')
+check "responses carry a validator" "'$ETAG'.startswith('W/')"
+dashboard AUTH -H "If-None-Match: $ETAG"
+check "an unchanged summary revalidates with 304" "s == 304"
+
+echo
+echo "Platform"
+run_job application-expiry
+dashboard PLATFORM
+if [ "$(field "['cached']")" = "True" ]; then
+    # An earlier run's summary is still cached; wait for it to expire so the job's run shows
+    sleep 31
+    dashboard PLATFORM
+fi
+check "the platform tenant sees its own summary within the budget" "s == 200 and b['tenant_id'] == 1 and b['query_count'] <= $QUERY_BUDGET"
+check "it lists every job with its latest run" \
+    "len(b['jobs']) > 1 and [j['last_run']['id'] for j in b['jobs'] if j['name'] == 'application-expiry'] == [$JOB_RUN]"
+check "it reports the webhook backlog" \
+    "all(b['webhooks'][k] >= 0 for k in ('pending_deliveries', 'suspended_subscriptions', 'pending_events', 'failed_events'))"
+check "it reports the connection pool" "b['database']['open_connections'] >= 1"
+
+echo
+echo "Access"
+dashboard CUSTOMER_AUTH
+check "customers cannot read the dashboard" "s == 403"
+request GET "$V1/admin/dashboard"
+check "anonymous callers cannot read the dashboard" "s == 401"
+
+finish "dashboard"
@@ -0,0 +1,96 @@
+#!/bin/bash
+
+# Customer Deletion Tests
+# Checks that a customer with open accounts, balances or loans cannot be deleted, with a 409 listing every blocking
+# reason, and that deleting a customer whose accounts are all closed and empty soft-deletes those accounts too while
+# keeping their transactions. The admin user is created with bankctl, and one closed account is given a balance
+# directly in the server's database, since the API never leaves one. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-deletion.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-deletion.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="deletion-test-$RUN_ID"
+
+echo " Customer Deletion Tests"
+echo "========================"
+
+# customer NAME - creates a customer and stores its ID in CUSTOMER
+customer() {
+    request POST "$V1/customers" "{\"first_name\": \"$1\", \"last_name\": \"Deletion\", \"email\": \"$1-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\", \"monthly_income\": 10000}"
+    CUSTOMER=$(field "['customer']['id']")
+}
+
+# account CUSTOMER_ID - opens a checking account and stores its ID in ACCOUNT
+account() {
+    request POST "$V1/accounts" "{\"customer_id\": $1, \"account_type\": \"checking\"}"
+    ACCOUNT=$(field "['account']['id']")
+}
+
+# blocking REASON - a check expression for a 409 listing that reason
+blocking() {
+    echo "s == 409 and b['code'] == 'CUSTOMER_HAS_OBLIGATIONS' and '$1' in [r['reason'] for r in b['blocking']]"
+}
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "deletion-admin-$RUN_ID")")
+
+echo "Open accounts"
+customer Open
+account "$CUSTOMER"
+request DELETE "$V1/customers/$CUSTOMER"
+check "an empty active account blocks deletion" "$(blocking open_accounts) and b['blocking'][0]['account_ids'] == [$ACCOUNT]"
+check "an empty account is not reported as holding a balance" "'nonzero_balance' not in [r['reason'] for r in b['blocking']]"
+
+echo "Balances"
+request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 25}"
+request DELETE "$V1/customers/$CUSTOMER"
+check "a funded account reports both reasons" "$(blocking open_accounts) and $(blocking nonzero_balance) and len(b['blocking']) == 2"
+
+customer Closed
+account "$CUSTOMER"
+request POST "$V1/accounts/$ACCOUNT/close" "{}" "${ADMIN[@]}"
+sql "UPDATE accounts SET balance = 5 WHERE id = $ACCOUNT" > /dev/null
+request DELETE "$V1/customers/$CUSTOMER"
+check "a closed account with a balance blocks deletion" "$(blocking nonzero_balance) and len(b['blocking']) == 1"
+
+echo "Loans"
+customer Borrower
+BORROWER=$CUSTOMER
+request POST "$V1/loans?disburse=false" "{\"customer_id\": $BORROWER, \"principal_amount\": 1000, \"interest_rate\": 0.05, \"loan_term\": 12}"
+LOAN=$(field "['loan']['id']")
+request DELETE "$V1/customers/$BORROWER"
+check "a pending loan blocks its borrower's deletion" "$(blocking open_loans) and b['blocking'][0]['loan_ids'] == [$LOAN]"
+
+customer Guarantor
+request POST "$V1/loans/$LOAN/parties" "{\"customer_id\": $CUSTOMER, \"role\": \"guarantor\", \"liability_percent\": 50}" "${ADMIN[@]}"
+request DELETE "$V1/customers/$CUSTOMER"
+check "the loan blocks its guarantor's deletion" "$(blocking open_loans) and b['blocking'][0]['loan_ids'] == [$LOAN]"
+
+sql "UPDATE loans SET status = 'paid_off' WHERE id = $LOAN" > /dev/null
+request DELETE "$V1/customers/$CUSTOMER"
+check "a paid-off loan does not block deletion" "s == 200"
+
+echo "Cascade"
+customer Cascade
+account "$CUSTOMER"
+CLOSED=$ACCOUNT
+request POST "$V1/transactions" "{\"account_id\": $CLOSED, \"transaction_type\": \"deposit\", \"amount\": 40}"
+request POST "$V1/transactions" "{\"account_id\": $CLOSED, \"transaction_type\": \"withdrawal\", \"amount\": 40}"
+request POST "$V1/accounts/$CLOSED/close" "{}" "${ADMIN[@]}"
+check "an emptied account closes" "s == 200"
+account "$CUSTOMER"
+request POST "$V1/accounts/$ACCOUNT/close" "{}" "${ADMIN[@]}"
+request DELETE "$V1/customers/$CUSTOMER"
+check "a customer with only closed, empty accounts is deleted" "s == 200 and b['accounts_deleted'] == 2"
+request GET "$V1/customers/$CUSTOMER"
+check "the customer is gone" "s == 404"
+request GET "$V1/accounts/$CLOSED"
+check "their closed accounts are gone" "s == 404"
+check "the accounts are soft-deleted, not removed" "$(sql "SELECT count(*) FROM accounts WHERE customer_id = $CUSTOMER AND deleted_at IS NOT NULL") == 2"
+check "the accounts' transactions are kept" "$(sql "SELECT count(*) FROM transactions WHERE account_id = $CLOSED AND deleted_at IS NULL") == 2"
+
+request DELETE "$V1/customers/$CUSTOMER"
+check "deleting again is 404" "s == 404"
+
+finish "customer deletion"
@@ -0,0 +1,160 @@
+#!/bin/bash
+
+# Statement Descriptor Tests
+# Checks the descriptors the server generates: deposits, transfers naming the other account and its owner, loan
+# disbursements and payments, and reversals naming what they reverse. The text a client sends is kept as the memo and
+# a descriptor it sends is ignored. Templates are validated against their kind's variables, a tenant's catch-all
+# template replaces the default and a product template wins over both. Receipts, the activity feed and the
+# consolidated statement show descriptors, and the backfill describes postings that have none without breaking the
+# hash chain. An admin user is created with bankctl against the server's database, so DB_PATH must be the database
+# the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-descriptors.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-descriptors.sh
+
+source "$(dirname "$0")/test-lib.sh"
+V2="$BASE_URL/api/v2"
+PASSWORD="descriptors-test-$RUN_ID"
+
+echo " Statement Descriptor Tests"
+echo "==========================="
+
+# customer FIRST LAST - creates a customer and prints its id
+customer() {
+    request POST "$V1/customers" "{\"first_name\": \"$1\", \"last_name\": \"$2\", \"email\": \"descriptors-$1-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}"
+    field "['customer']['id']"
+}
+
+# account CUSTOMER [TYPE] - opens an account and prints its id
+# Account numbers opened in the same second can collide, so a refused open is retried
+account() {
+    for _ in 1 2 3; do
+        request POST "$V1/accounts" "{\"customer_id\": $1, \"account_type\": \"${2:-checking}\"}"
+        [ "$STATUS" = 201 ] && break
+        sleep 1
+    done
+    field "['account']['id']"
+}
+
+# receipt TRANSACTION - fetches a transaction's receipt, leaving the receipt itself in BODY
+receipt() {
+    request GET "$V1/transactions/$1/receipt"
+    BODY=$(python3 -c "import json, sys; print(json.dumps(json.loads(sys.argv[1])['receipt']))" "$BODY")
+}
+
+# template JSON - creates a descriptor template as the admin
+template() {
+    request POST "$V1/admin/descriptor-templates" "$1" "${ADMIN[@]}"
+}
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "descriptors-admin-$RUN_ID")")
+JANE=$(customer Jane Doe)
+SAM=$(customer Sam Smith)
+CHECKING=$(account "$JANE")
+SAVINGS=$(account "$JANE" savings)
+OTHER=$(account "$SAM")
+request GET "$V1/accounts/$OTHER"
+OTHER_LAST4=$(field "['account_number'][-4:]")
+request GET "$V1/accounts/$CHECKING"
+CHECKING_LAST4=$(field "['account_number'][-4:]")
+
+echo
+echo "Defaults"
+request POST "$V1/transactions" "{\"account_id\": $CHECKING, \"transaction_type\": \"deposit\", \"amount\": 500, \"description\": \"Birthday money\", \"descriptor\": \"FORGED\"}"
+check "a deposit is described by the server" "s == 201 and b['transaction']['descriptor'] == 'DEPOSIT'"
+check "the client's description is kept as the memo" "b['transaction']['memo'] == 'Birthday money'"
+DEPOSIT=$(field "['transaction']['id']")
+request POST "$V2/transactions" "{\"account_id\": $SAVINGS, \"transaction_type\": \"deposit\", \"amount\": \"50.00\", \"description\": \"Savings\", \"memo\": \"Rainy day fund\"}"
+check "a separate memo is kept on v2" "s == 201 and b['data']['memo'] == 'Rainy day fund' and b['data']['descriptor'] == 'DEPOSIT'"
+
+request POST "$V1/transfers" "{\"from_account_id\": $CHECKING, \"to_account_id\": $OTHER, \"amount\": 120, \"description\": \"Rent share\"}"
+check "a transfer is made" "s == 201"
+DEBIT=$(field "['transfer']['debit_transaction_id']")
+CREDIT=$(field "['transfer']['credit_transaction_id']")
+receipt "$DEBIT"
+check "the debit names the other account and its owner" "b['descriptor'] == 'TRANSFER TO ••••$OTHER_LAST4 / SAM SMITH' and b['memo'] == 'Rent share'"
+receipt "$CREDIT"
+check "the credit names the sender" "b['descriptor'] == 'TRANSFER FROM ••••$CHECKING_LAST4 / JANE DOE' and b['memo'] == 'Rent share'"
+
+request POST "$V1/transactions" "{\"account_id\": $CHECKING, \"transaction_type\": \"deposit\", \"amount\": 5}"
+request POST "$V1/transactions/$(field "['transaction']['id']")/reverse" "{\"reason\": \"Duplicate\"}" "${ADMIN[@]}"
+check "a reversal names what it reverses" "s == 201 and b['transaction']['descriptor'] == 'REVERSAL DEPOSIT'"
+
+request POST "$V1/loans" "{\"customer_id\": $JANE, \"principal_amount\": 1000, \"interest_rate\": 0.05, \"loan_term\": 12}"
+LOAN=$(field "['loan']['id']")
+LOAN_NUMBER=$(field "['loan']['loan_number']")
+check "the disbursement names the loan" \
+    "'$(sql "SELECT descriptor FROM transactions WHERE reference = ? AND transaction_type = 'withdrawal'" "$LOAN_NUMBER")' == 'LOAN DISBURSEMENT $LOAN_NUMBER'"
+request POST "$V1/loans/$LOAN/payments" "{\"account_id\": $SAVINGS, \"amount\": 20}"
+check "a loan payment names the loan" \
+    "s == 201 and '$(sql "SELECT descriptor FROM transactions WHERE reference = ? AND transaction_type = 'payment'" "$LOAN_NUMBER")' == 'LOAN PAYMENT $LOAN_NUMBER'"
+
+echo
+echo "Templates"
+request GET "$V1/admin/descriptor-templates" "" "${ADMIN[@]}"
+check "the defaults and variables are listed" \
+    "s == 200 and any(k['kind'] == 'transfer_out' and 'counterparty_name' in k['variables'] for k in b['kinds'])"
+template "{\"kind\": \"deposit\", \"template\": \"CASH IN {loan_number}\"}"
+check "a variable the kind has no value for is refused" "s == 400 and b['code'] == 'INVALID_DESCRIPTOR_TEMPLATE' and '{loan_number}' in b['error']"
+template "{\"kind\": \"deposit\", \"template\": \"CASH IN {memo\"}"
+check "unbalanced braces are refused" "s == 400 and b['code'] == 'INVALID_DESCRIPTOR_TEMPLATE'"
+template "{\"kind\": \"cheque\", \"template\": \"CHEQUE\"}"
+check "an unknown kind is refused" "s == 400 and b['code'] == 'INVALID_DESCRIPTOR_TEMPLATE'"
+template "{\"account_type\": \"internal\", \"kind\": \"deposit\", \"template\": \"X\"}"
+check "internal accounts cannot have templates" "s == 400"
+
+template "{\"kind\": \"deposit\", \"template\": \"CASH IN - {memo}\"}"
+check "a catch-all template is created" "s == 201"
+CATCH_ALL=$(field "['template']['id']")
+template "{\"kind\": \"deposit\", \"template\": \"OTHER\"}"
+check "a second template for the same product and kind conflicts" "s == 409 and b['code'] == 'DESCRIPTOR_TEMPLATE_EXISTS'"
+template "{\"account_type\": \"savings\", \"kind\": \"deposit\", \"template\": \"SAVINGS CREDIT {amount} {currency}\"}"
+check "a product template is created" "s == 201"
+PRODUCT=$(field "['template']['id']")
+
+request POST "$V1/transactions" "{\"account_id\": $CHECKING, \"transaction_type\": \"deposit\", \"amount\": 10, \"description\": \"Tips\"}"
+check "the catch-all template replaces the default" "b['transaction']['descriptor'] == 'CASH IN - TIPS'"
+request POST "$V1/transactions" "{\"account_id\": $CHECKING, \"transaction_type\": \"deposit\", \"amount\": 10}"
+check "a separator left by an empty variable is dropped" "b['transaction']['descriptor'] == 'CASH IN'"
+request POST "$V1/transactions" "{\"account_id\": $SAVINGS, \"transaction_type\": \"deposit\", \"amount\": 10}"
+check "the product template wins" "b['transaction']['descriptor'] == 'SAVINGS CREDIT 10.00 USD'"
+
+echo
+echo "Display"
+receipt "$DEBIT"
+check "receipts carry the descriptor" "b['descriptor'].startswith('TRANSFER TO')"
+request GET "$V1/accounts/$CHECKING/activity?types=transaction" "" "${ADMIN[@]}"
+check "the activity feed is titled with descriptors" \
+    "s == 200 and any(i['title'] == 'CASH IN - TIPS' and i['details']['memo'] == 'Tips' for i in b['items'])"
+request GET "$V1/accounts/$CHECKING/transactions" "" "${ADMIN[@]}"
+check "transaction lists carry descriptors and memos" \
+    "s == 200 and any(t['descriptor'].startswith('TRANSFER TO') and t['memo'] == 'Rent share' for t in b['transactions'])"
+MONTH=$(date +%Y/%m)
+request GET "$V1/customers/$JANE/statements/$MONTH" "" "${ADMIN[@]}"
+check "the consolidated statement shows descriptors and memos" \
+    "s == 200 and any(l['description'] == 'TRANSFER TO ••••$OTHER_LAST4 / SAM SMITH' and l['memo'] == 'Rent share' for a in b['accounts'] for l in a['lines'])"
+
+echo
+echo "Backfill"
+sql "UPDATE transactions SET descriptor = '' WHERE id IN (?, ?)" "$DEBIT" "$DEPOSIT" > /dev/null
+request POST "$V1/admin/descriptor-templates/backfill" "" "${ADMIN[@]}"
+check "postings without a descriptor are described" "s == 200 and b['updated'] >= 2"
+receipt "$DEBIT"
+check "the transfer is described again" "b['descriptor'] == 'TRANSFER TO ••••$OTHER_LAST4 / SAM SMITH'"
+receipt "$DEPOSIT"
+check "an old deposit takes the current template" "b['descriptor'] == 'CASH IN - BIRTHDAY MONEY'"
+
+request DELETE "$V1/admin/descriptor-templates/$CATCH_ALL" "" "${ADMIN[@]}"
+request DELETE "$V1/admin/descriptor-templates/$PRODUCT" "" "${ADMIN[@]}"
+check "templates are deleted" "s == 200"
+request POST "$V1/admin/descriptor-templates/backfill?all=true" "" "${ADMIN[@]}"
+check "all=true regenerates every descriptor" "s == 200 and b['updated'] >= 3"
+receipt "$DEPOSIT"
+check "deleted templates fall back to the default" "b['descriptor'] == 'DEPOSIT'"
+for ACCOUNT in "$CHECKING" "$SAVINGS" "$OTHER"; do
+    request GET "$V1/accounts/$ACCOUNT/verify-chain" "" "${ADMIN[@]}"
+    check "account $ACCOUNT's hash chain still verifies" "s == 200 and b['valid']"
+done
+
+finish "descriptor"
@@ -0,0 +1,185 @@
+#!/bin/bash
+
+# Dispute Tests
+# Checks that the holder can dispute a posting on their own account and staff with transactions:disputes on the
+# holder's behalf, that a reason is required and a posting has one open dispute at a time, that other customers and
+# staff without the permission cannot reach a dispute, and that only staff resolve one, with a resolution, once.
+# Checks too that a dispute's thread, read markers and evidence belong to the holder and the staff member assigned it,
+# that staff replies reach the holder's verified email, and that an unanswered request for information gets one
+# closure warning from the dispute-warnings job.
+# Each run creates its own tenant; the platform admin and the users are created with bankctl, so DB_PATH must be the
+# database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-disputes.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-disputes.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="dispute-test-$RUN_ID-Aa1!"
+TENANT_CODE="disp$RUN_ID"
+
+echo " Dispute Tests"
+echo "==============="
+
+# customer NAME - creates a customer with a funded checking account and a payment from it, storing the IDs in
+# CUSTOMER and PAYMENT
+customer() {
+    request POST "$V1/customers" "{\"first_name\": \"$1\", \"last_name\": \"Disputer\", \"email\": \"dispute-$1-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+    CUSTOMER=$(field "['customer']['id']")
+    request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+    local account
+    account=$(field "['account']['id']")
+    request POST "$V1/transactions" "{\"account_id\": $account, \"transaction_type\": \"deposit\", \"amount\": 500}" "${AUTH[@]}"
+    request POST "$V1/transactions" "{\"account_id\": $account, \"transaction_type\": \"payment\", \"amount\": 79.99, \"merchant_name\": \"Gadget Store\"}" "${AUTH[@]}"
+    PAYMENT=$(field "['transaction']['id']")
+}
+
+# tenant_staff USERNAME ROLE - creates a staff member with ROLE in the run's tenant and prints their token
+tenant_staff() {
+    staff "$1" "$2" > /dev/null
+    sql "UPDATE users SET tenant_id = ? WHERE username = ?" "$TENANT" "$1" > /dev/null
+    token "$1" -H "X-Tenant: $TENANT_CODE"
+}
+
+# attach FILE TYPE [CURL_ARGS...] - uploads a file from WORK to the dispute as evidence declaring TYPE
+attach() {
+    local file=$1 type=$2 out
+    shift 2
+    out=$(mktemp)
+    STATUS=$(curl -s -o "$out" -w '%{http_code}' -X POST "$V1/disputes/$DISPUTE/attachments" -F "file=@$WORK/$file;type=$type" "$@")
+    BODY=$(cat "$out")
+    rm -f "$out"
+}
+
+# notified SUBJECT - prints how many notifications about the dispute carry SUBJECT
+notified() {
+    sql "SELECT COUNT(*) FROM notifications WHERE resource_type = 'dispute' AND resource_id = ? AND subject = ?" "$DISPUTE" "$1"
+}
+
+echo "Setup"
+tenant "dispute-platform-$RUN_ID" "Disputes" dispute-admin
+customer Holder
+HOLDER_PAYMENT=$PAYMENT
+HOLDER=(-H "Authorization: Bearer $(customer_user "dispute-holder-$RUN_ID" "$CUSTOMER" "$TENANT_CODE")" -H "X-Tenant: $TENANT_CODE")
+sql "UPDATE customers SET email_verified = 1 WHERE id = ?" "$CUSTOMER" > /dev/null
+customer Other
+OTHER_PAYMENT=$PAYMENT
+OTHER=(-H "Authorization: Bearer $(customer_user "dispute-other-$RUN_ID" "$CUSTOMER" "$TENANT_CODE")" -H "X-Tenant: $TENANT_CODE")
+COMPLIANCE=(-H "Authorization: Bearer $(staff "dispute-compliance-$RUN_ID" compliance)")
+ASSIGNEE=(-H "Authorization: Bearer $(tenant_staff "dispute-assignee-$RUN_ID" teller)" -H "X-Tenant: $TENANT_CODE")
+BYSTANDER=(-H "Authorization: Bearer $(tenant_staff "dispute-bystander-$RUN_ID" teller)" -H "X-Tenant: $TENANT_CODE")
+printf '%%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj\n%%%%EOF\n' > "$WORK/receipt.pdf"
+printf '<html><script>alert(1)</script></html>' > "$WORK/receipt.html"
+check "each customer has a payment to dispute" "'$HOLDER_PAYMENT$OTHER_PAYMENT'.isdigit()"
+
+echo
+echo "Opening"
+request POST "$V1/transactions/$HOLDER_PAYMENT/disputes" '{"reason": " "}' "${HOLDER[@]}"
+check "a reason is required" "s == 400"
+request POST "$V1/transactions/$OTHER_PAYMENT/disputes" '{"reason": "Not mine"}' "${HOLDER[@]}"
+check "another customer's posting is not found" "s == 404"
+request POST "$V1/transactions/$HOLDER_PAYMENT/disputes" '{"reason": "The goods never arrived"}' "${HOLDER[@]}"
+check "the holder disputes their payment" \
+    "s == 201 and b['dispute']['status'] == 'open' and b['dispute']['opened_by'] == 'dispute-holder-$RUN_ID' and float(b['dispute']['amount']) == 79.99 and b['dispute']['reason'] == 'The goods never arrived'"
+DISPUTE=$(field "['dispute']['id']")
+request POST "$V1/transactions/$HOLDER_PAYMENT/disputes" '{"reason": "Still waiting"}' "${HOLDER[@]}"
+check "a posting has one open dispute" "s == 409 and b['code'] == 'DISPUTE_OPEN'"
+request POST "$V1/transactions/$OTHER_PAYMENT/disputes" '{"reason": "Customer called about a double charge"}' "${COMPLIANCE[@]}"
+check "staff without the permission cannot open one" "s == 403"
+request POST "$V1/transactions/$OTHER_PAYMENT/disputes" '{"reason": "Customer called about a double charge"}' "${AUTH[@]}"
+check "staff open one on the holder's behalf" "s == 201 and b['dispute']['opened_by'] == 'dispute-admin' and b['dispute']['customer_id'] == $CUSTOMER"
+
+echo
+echo "Reading"
+request GET "$V1/disputes/$DISPUTE" "" "${HOLDER[@]}"
+check "the holder reads their dispute with the posting" "s == 200 and b['dispute']['id'] == $DISPUTE and b['transaction']['id'] == $HOLDER_PAYMENT"
+request GET "$V1/disputes/$DISPUTE" "" "${OTHER[@]}"
+check "another customer cannot" "s == 404"
+request GET "$V1/disputes/$DISPUTE" "" "${COMPLIANCE[@]}"
+check "nor can staff without the permission" "s == 403"
+
+echo
+echo "Thread"
+request GET "$V1/disputes/$DISPUTE" "" "${AUTH[@]}"
+check "staff not assigned the dispute read it without its thread" "s == 200 and 'thread' not in b and 'attachments' not in b"
+request POST "$V1/disputes/$DISPUTE/messages" '{"body": "The courier says it was delivered, but it was not"}' "${HOLDER[@]}"
+check "the holder posts before anyone is assigned" "s == 201 and b['dispute_message']['author_type'] == 'customer'"
+request POST "$V1/disputes/$DISPUTE/assign" "{\"assigned_to\": \"dispute-assignee-$RUN_ID\"}" "${HOLDER[@]}"
+check "the holder cannot assign it" "s == 403"
+request POST "$V1/disputes/$DISPUTE/assign" "{\"assigned_to\": \"dispute-compliance-$RUN_ID\"}" "${AUTH[@]}"
+check "it is only assigned to staff of the tenant with the permission" "s == 400 and b['code'] == 'INVALID_ASSIGNEE'"
+request POST "$V1/disputes/$DISPUTE/assign" "{\"assigned_to\": \"dispute-assignee-$RUN_ID\"}" "${AUTH[@]}"
+check "staff assign it" "s == 200 and b['dispute']['assigned_to'] == 'dispute-assignee-$RUN_ID'"
+request POST "$V1/disputes/$DISPUTE/messages" '{"body": "Looking into it"}' "${BYSTANDER[@]}"
+check "other staff cannot post" "s == 403 and b['code'] == 'DISPUTE_NOT_ASSIGNED'"
+request POST "$V1/disputes/$DISPUTE/messages" '{"body": "Me too"}' "${OTHER[@]}"
+check "nor can another customer, who does not find it" "s == 404"
+request POST "$V1/disputes/$DISPUTE/messages" '{"body": "Please send the order confirmation", "request_information": true}' "${HOLDER[@]}"
+check "only staff ask for information" "s == 400 and b['code'] == 'INVALID_MESSAGE'"
+request POST "$V1/disputes/$DISPUTE/messages" '{"body": "Please send the order confirmation", "request_information": true}' "${ASSIGNEE[@]}"
+check "the assignee asks the holder for information" "s == 201 and b['dispute_message']['requests_information']"
+check "the holder is emailed about it" "$(notified 'We need more information about your dispute') == 1"
+request GET "$V1/disputes/$DISPUTE" "" "${HOLDER[@]}"
+check "the holder reads the thread with the reply unread" \
+    "s == 200 and b['thread']['total'] == 2 and b['thread']['unread'] == 1 and b['thread']['messages'][1]['body'] == 'Please send the order confirmation' and b['dispute']['information_requested_at']"
+request POST "$V1/disputes/$DISPUTE/read" "" "${HOLDER[@]}"
+check "the holder marks it read" "s == 200 and b['marked'] == 1 and b['unread'] == 0"
+request GET "$V1/disputes/$DISPUTE" "" "${ASSIGNEE[@]}"
+check "the read marker shows to the assignee, whose own unread post is the holder's" \
+    "s == 200 and b['thread']['messages'][1]['read_by'] == 'dispute-holder-$RUN_ID' and b['thread']['unread'] == 1"
+
+echo
+echo "Evidence"
+attach receipt.html application/pdf "${HOLDER[@]}"
+check "evidence that is not what it claims is refused" "s == 415"
+attach receipt.pdf application/pdf "${HOLDER[@]}" -F "message=The order confirmation"
+check "the holder uploads evidence with a post" \
+    "s == 201 and b['attachment']['content_type'] == 'application/pdf' and b['dispute_message']['body'] == 'The order confirmation' and 'storage_key' not in b['attachment']"
+ATTACHMENT=$(field "['attachment']['id']")
+request GET "$V1/disputes/$DISPUTE/attachments/$ATTACHMENT" "" "${OTHER[@]}"
+check "another customer cannot download it" "s == 404"
+request GET "$V1/disputes/$DISPUTE/attachments/$ATTACHMENT" "" "${BYSTANDER[@]}"
+check "nor can other staff" "s == 403 and b['code'] == 'DISPUTE_NOT_ASSIGNED'"
+curl -s -o "$WORK/download" "$V1/disputes/$DISPUTE/attachments/$ATTACHMENT" "${ASSIGNEE[@]}"
+check "the assignee downloads the evidence" "$(cmp -s "$WORK/download" "$WORK/receipt.pdf" && echo True || echo False)"
+request GET "$V1/disputes/$DISPUTE?page=3&limit=1" "" "${ASSIGNEE[@]}"
+check "the thread pages with the evidence's metadata" \
+    "s == 200 and b['thread']['total'] == 3 and b['thread']['page'] == 3 and [m['body'] for m in b['thread']['messages']] == ['The order confirmation'] and [a['filename'] for a in b['attachments']] == ['receipt.pdf']"
+request GET "$V1/disputes/$DISPUTE" "" "${HOLDER[@]}"
+check "the holder's upload answered the request for information" "s == 200 and not b['dispute'].get('information_requested_at')"
+
+echo
+echo "Closure warnings"
+request POST "$V1/disputes/$DISPUTE/messages" '{"body": "Please also send the courier receipt", "request_information": true}' "${ASSIGNEE[@]}"
+check "the assignee asks again" "s == 201"
+run_job dispute-warnings
+check "a fresh request is not warned about" "'$JOB_ERROR' == '' and $(notified 'Your dispute may be closed') == 0"
+sql "UPDATE disputes SET information_requested_at = ? WHERE id = ?" "$(date -u -d '11 days ago' '+%Y-%m-%d %H:%M:%S+00:00')" "$DISPUTE" > /dev/null
+run_job dispute-warnings
+check "a request unanswered for ten days warns the holder" "'$JOB_ERROR' == '' and $(notified 'Your dispute may be closed') == 1"
+run_job dispute-warnings
+check "once" "'$JOB_ERROR' == '' and $(notified 'Your dispute may be closed') == 1"
+request GET "$V1/disputes/$DISPUTE" "" "${ASSIGNEE[@]}"
+check "the dispute shows the warning and stays open" "s == 200 and b['dispute']['closure_warned_at'] and b['dispute']['status'] == 'open'"
+request POST "$V1/disputes/$DISPUTE/messages" '{"body": "Attached to my next message"}' "${HOLDER[@]}"
+check "the holder's reply clears the warning" \
+    "s == 201 and '$(sql "SELECT COALESCE(closure_warned_at, '') || COALESCE(information_requested_at, '') FROM disputes WHERE id = ?" "$DISPUTE")' == ''"
+
+echo
+echo "Resolving"
+request POST "$V1/disputes/$DISPUTE/resolve" '{"resolution": "Refunded"}' "${HOLDER[@]}"
+check "the holder cannot resolve it" "s == 403"
+request POST "$V1/disputes/$DISPUTE/resolve" '{"resolution": ""}' "${AUTH[@]}"
+check "a resolution is required" "s == 400 and b['code'] == 'REASON_REQUIRED'"
+request POST "$V1/disputes/$DISPUTE/resolve" '{"resolution": "Merchant refunded the charge"}' "${AUTH[@]}"
+check "staff resolve it" \
+    "s == 200 and b['dispute']['status'] == 'resolved' and b['dispute']['resolved_by'] == 'dispute-admin' and b['dispute']['resolution'] == 'Merchant refunded the charge' and b['dispute']['resolved_at']"
+request POST "$V1/disputes/$DISPUTE/resolve" '{"resolution": "Again"}' "${AUTH[@]}"
+check "a resolved dispute is not resolved again" "s == 409 and b['code'] == 'DISPUTE_RESOLVED'"
+request POST "$V1/disputes/$DISPUTE/messages" '{"body": "Thank you"}' "${HOLDER[@]}"
+check "its thread is closed" "s == 409 and b['code'] == 'DISPUTE_RESOLVED'"
+request GET "$V1/disputes/$DISPUTE" "" "${HOLDER[@]}"
+check "the holder sees the outcome" "s == 200 and b['dispute']['status'] == 'resolved'"
+request POST "$V1/transactions/$HOLDER_PAYMENT/disputes" '{"reason": "The refund never arrived"}' "${HOLDER[@]}"
+check "the posting can be disputed afresh" "s == 201 and b['dispute']['id'] != $DISPUTE"
+
+finish "dispute"
@@ -0,0 +1,147 @@
+#!/bin/bash
+
+# Duplicate Payment Tests
+# Checks that a payment repeated through another channel within the window is posted but flagged, matching on the
+# beneficiary account, the payee or the reference with case and spacing ignored, that other amounts, payees and
+# reversed payments are not compared, that the holder is emailed a reversal link that works once and expires, that
+# staff can list, inspect, reverse and dismiss flags, and that a repeated transfer is reversed on both accounts.
+# Each run creates its own tenant; the platform admin is created with bankctl, and the links are read and expired in
+# the server's database, so DB_PATH must be the database the server uses. The server must run with the default
+# window (DUPLICATE_PAYMENT_WINDOW_MINUTES unset). Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-duplicate-payments.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-duplicate-payments.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="dup-test-$RUN_ID-Aa1!"
+PLATFORM_USER="dup-platform-$RUN_ID"
+TENANT_CODE="dup$RUN_ID"
+
+echo " Duplicate Payment Tests"
+echo "========================="
+
+# customer NAME - creates a customer with a funded checking account and stores their IDs in CUSTOMER and ACCOUNT
+customer() {
+    request POST "$V1/customers" "{\"first_name\": \"$1\", \"last_name\": \"Payer\", \"email\": \"dup-$1-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+    CUSTOMER=$(field "['customer']['id']")
+    request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+    ACCOUNT=$(field "['account']['id']")
+    request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 5000}" "${AUTH[@]}"
+}
+
+# pay ACCOUNT AMOUNT CHANNEL FIELDS - posts a payment, FIELDS being extra JSON members such as "reference": "..."
+pay() {
+    request POST "$V1/transactions" "{\"account_id\": $1, \"transaction_type\": \"payment\", \"amount\": $2, \"channel\": \"$3\"${4:+, $4}}" "${AUTH[@]}"
+    PAYMENT=$(field "['transaction']['id']" 2>/dev/null)
+}
+
+# transfer FROM TO AMOUNT FIELDS - transfers between accounts, FIELDS being extra JSON members
+transfer() {
+    request POST "$V1/transfers" "{\"from_account_id\": $1, \"to_account_id\": $2, \"amount\": $3${4:+, $4}}" "${AUTH[@]}"
+}
+
+# flag_of TRANSACTION - prints the ID of the flag raised on a transaction, or nothing
+flag_of() {
+    sql "SELECT id FROM duplicate_payments WHERE transaction_id = $1"
+}
+
+# token_of FLAG - prints the reversal token emailed for a flag
+token_of() {
+    sql "SELECT substr(body, instr(body, '/duplicate-payments/') + 20, 43) FROM notifications WHERE resource_type = 'duplicate_payment' AND resource_id = $1"
+}
+
+echo "Setup"
+tenant "$PLATFORM_USER" "Duplicates" dup-admin
+customer Holder
+HOLDER=$CUSTOMER
+CHECKING=$ACCOUNT
+check "the holder's account is funded" "s == 201"
+sql "UPDATE customers SET email_verified = 1 WHERE id = $HOLDER" > /dev/null
+customer Landlord
+LANDLORD=$ACCOUNT
+customer Unverified
+UNVERIFIED=$ACCOUNT
+
+echo
+echo "Matching"
+pay "$CHECKING" 120 online "\"reference\": \"INV 1001\""
+check "a payment posts" "s == 201"
+BILL=$PAYMENT
+transfer "$CHECKING" "$LANDLORD" 120 "\"reference\": \"inv1001\""
+check "the same bill paid again by transfer still posts" "s == 201"
+REPEAT=$(field "['transfer']['debit_transaction_id']")
+FLAG=$(flag_of "$REPEAT")
+check "the transfer is flagged as repeating the payment" "'$FLAG' != ''"
+check "the flag matched the reference, case and spacing ignored" "'$(sql "SELECT matched_on || ':' || counterparty || ':' || original_transaction_id FROM duplicate_payments WHERE id = 0$FLAG")' == 'reference:inv1001:$BILL'"
+check "the flag records both channels" "'$(sql "SELECT original_channel || ':' || channel FROM duplicate_payments WHERE id = 0$FLAG")' == 'online:api'"
+pay "$CHECKING" 120.01 card "\"reference\": \"INV 1001\""
+check "another amount is not flagged" "s == 201 and '$(flag_of "$PAYMENT")' == ''"
+pay "$CHECKING" 120 card "\"reference\": \"INV 1002\""
+check "another reference is not flagged" "s == 201 and '$(flag_of "$PAYMENT")' == ''"
+pay "$CHECKING" 45.5 card "\"merchant_name\": \"City Water\""
+pay "$CHECKING" 45.5 online "\"merchant_name\": \"city  water\""
+WATER=$(flag_of "$PAYMENT")
+check "the same payee is flagged" "'$WATER' != '' and '$(sql "SELECT matched_on FROM duplicate_payments WHERE id = 0$WATER")' == 'payee'"
+transfer "$CHECKING" "$LANDLORD" 300
+transfer "$CHECKING" "$LANDLORD" 300 "\"confirm_duplicate\": true"
+RENT=$(flag_of "$(field "['transfer']['debit_transaction_id']")")
+check "a transfer confirmed as intended is still flagged on the beneficiary account" "s == 201 and '$(sql "SELECT matched_on || ':' || counterparty FROM duplicate_payments WHERE id = 0$RENT")' == 'account:$LANDLORD'"
+pay "$CHECKING" 80 online "\"reference\": \"OLD-1\""
+sql "UPDATE transactions SET created_at = datetime('now', '-31 minutes') WHERE id = $PAYMENT" > /dev/null
+pay "$CHECKING" 80 online "\"reference\": \"OLD-1\""
+check "a payment outside the window is not flagged" "s == 201 and '$(flag_of "$PAYMENT")' == ''"
+pay "$UNVERIFIED" 60 online "\"reference\": \"GYM\""
+pay "$UNVERIFIED" 60 atm "\"reference\": \"GYM\""
+UNNOTIFIED=$(flag_of "$PAYMENT")
+check "a holder without a verified email is flagged without a link" "'$UNNOTIFIED' != '' and '$(sql "SELECT COUNT(*) FROM notifications WHERE resource_type = 'duplicate_payment' AND resource_id = 0$UNNOTIFIED")' == '0'"
+
+echo
+echo "Staff"
+request GET "$V1/operations/duplicate-payments" "" "${AUTH[@]}"
+check "the queue lists flags newest first" "s == 200 and [d['id'] for d in b['duplicate_payments']][-1] == $FLAG and b['total'] >= 3"
+check "the queue does not expose the token" "all('reversal_token_hash' not in d for d in b['duplicate_payments'])"
+request GET "$V1/operations/duplicate-payments?account_id=$UNVERIFIED" "" "${AUTH[@]}"
+check "the queue filters by account" "[d['id'] for d in b['duplicate_payments']] == [$UNNOTIFIED]"
+request GET "$V1/operations/duplicate-payments/$FLAG" "" "${AUTH[@]}"
+check "a flag shows both payments" "s == 200 and [p['id'] for p in b['payments']] == [$BILL, $REPEAT]"
+request POST "$V1/operations/duplicate-payments/$WATER/dismiss" "{}" "${AUTH[@]}"
+check "dismissing needs a note" "s == 400"
+request POST "$V1/operations/duplicate-payments/$WATER/dismiss" "{\"note\": \"Two meters\"}" "${AUTH[@]}"
+check "a flag is dismissed" "s == 200 and b['duplicate_payment']['status'] == 'dismissed' and b['duplicate_payment']['decided_by'] == 'dup-admin'"
+request POST "$V1/operations/duplicate-payments/$WATER/reverse" "{}" "${AUTH[@]}"
+check "a dismissed flag cannot be reversed" "s == 409 and b['code'] == 'DUPLICATE_DECIDED'"
+request POST "$V1/operations/duplicate-payments/$UNNOTIFIED/reverse" "{\"note\": \"Customer called\"}" "${AUTH[@]}"
+check "staff reverse a flag" "s == 200 and b['duplicate_payment']['status'] == 'reversed' and b['duplicate_payment']['decision_note'] == 'Customer called'"
+check "the payment is refunded" "$(sql "SELECT balance FROM accounts WHERE id = $UNVERIFIED") == 4940"
+request GET "$V1/operations/duplicate-payments"
+check "the queue needs staff" "s == 401"
+
+echo
+echo "Emailed link"
+BODY_TEXT=$(sql "SELECT body FROM notifications WHERE resource_type = 'duplicate_payment' AND resource_id = $FLAG")
+check "the holder is emailed a reversal link" "'/api/v1/duplicate-payments/' in '''$BODY_TEXT''' and 'reference' in '''$BODY_TEXT'''"
+TOKEN=$(token_of "$FLAG")
+LANDLORD_BEFORE=$(sql "SELECT balance FROM accounts WHERE id = $LANDLORD")
+request GET "$V1/duplicate-payments/$TOKEN/reverse"
+BODY="" # Gin's plain-text 404
+check "following the link with GET reverses nothing" "s == 404 and '$(sql "SELECT status FROM duplicate_payments WHERE id = $FLAG")' == 'flagged'"
+request POST "$V1/duplicate-payments/not-a-token/reverse"
+check "an unknown token is not found" "s == 404"
+request POST "$V1/duplicate-payments/$TOKEN/reverse"
+check "the link reverses the repeated transfer" "s == 200 and b['duplicate_payment']['status'] == 'reversed' and b['duplicate_payment']['decided_by'] == 'customer'"
+check "both legs of the transfer are reversed" "$(sql "SELECT COUNT(*) FROM transactions WHERE reversal_of_id IS NOT NULL AND account_id IN ($CHECKING, $LANDLORD) AND amount = 120") == 2"
+check "the beneficiary gives the amount back" "abs($(sql "SELECT balance FROM accounts WHERE id = $LANDLORD") - ($LANDLORD_BEFORE - 120)) < 0.001"
+request POST "$V1/duplicate-payments/$TOKEN/reverse"
+check "the link works once" "s == 409 and b['code'] == 'DUPLICATE_DECIDED'"
+pay "$CHECKING" 120 card "\"reference\": \"INV 1001\""
+check "a reversed payment is skipped for an earlier one" "'$(sql "SELECT original_transaction_id FROM duplicate_payments WHERE transaction_id = $PAYMENT")' == '$BILL'"
+
+pay "$CHECKING" 15 online "\"reference\": \"LATE\""
+pay "$CHECKING" 15 online "\"reference\": \"LATE\""
+LATE=$(flag_of "$PAYMENT")
+sql "UPDATE duplicate_payments SET reversal_expires_at = datetime('now', '-1 minute') WHERE id = 0$LATE" > /dev/null
+request POST "$V1/duplicate-payments/$(token_of "0$LATE")/reverse"
+check "an expired link is refused" "s == 410 and b['code'] == 'REVERSAL_LINK_EXPIRED'"
+check "the expired flag waits for staff" "'$(sql "SELECT status FROM duplicate_payments WHERE id = 0$LATE")' == 'flagged'"
+
+finish "duplicate payment"
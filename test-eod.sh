@@ -0,0 +1,182 @@
+#!/bin/bash
+
+# End-of-Day Tests
+# Starts its own server and checks the end-of-day job: it pauses postings, runs its steps in order and records
+# each as a run of its job with the end-of-day run as parent. While it runs a deposit queues and goes through once
+# postings resume, and other writes and reads are unaffected. A EUR account with no closing rate fails the FX
+# revaluation step, so the step after it that depends on it is skipped while independent steps still run. Once
+# the rate is entered, resuming the failed run runs only the failed and skipped steps. The server is then
+# restarted with postings refused instead of queued, and a posting during end of day gets 503 with Retry-After on
+# v1 and v2. A step is held in progress by taking its job's lease in the database, which keeps the run paused
+# for as long as the checks need. The server and database are the script's own, so no other server is needed.
+# Exits non-zero on failure.
+#
+# Usage: ./test-eod.sh                                   (builds the server with go build)
+#        SERVER_BIN=./banking-app BANKCTL=./bankctl PORT=18096 ./test-eod.sh
+
+source "$(dirname "$0")/test-lib.sh"
+serve 18096
+V2="$BASE_URL/api/v2"
+PASSWORD="eod-test-$RUN_ID-Aa1!"
+CHECK_HELPERS="step = lambda name: next((r for r in b['steps'] if r['job_name'] == name), None)"
+
+echo " End-of-Day Tests"
+echo "================="
+
+# hold JOB - takes a job's lease as another instance would, so an end-of-day step running it waits
+hold() {
+    sql "INSERT OR REPLACE INTO job_leases (name, holder, run_id, expires_at) VALUES ('$1', 'test-eod', 0, datetime('now', '+10 minutes'))" > /dev/null
+}
+
+# release JOB - frees a job's lease taken with hold
+release() {
+    sql "UPDATE job_leases SET expires_at = NULL WHERE name = '$1' AND holder = 'test-eod'" > /dev/null
+}
+
+# start_eod - starts an end-of-day run and waits until postings are paused, storing the run's id in RUN
+start_eod() {
+    request POST "$V1/admin/jobs/eod/run" "" "${ADMIN[@]}"
+    RUN=$(field "['run']['id']" 2>/dev/null)
+    for _ in $(seq 1 50); do
+        request GET "$V1/admin/eod" "" "${ADMIN[@]}"
+        [ "$(field "['paused']" 2>/dev/null)" = "True" ] && return
+        sleep 0.1
+    done
+}
+
+# finished RUN - waits for an end-of-day run to finish, leaving it and its steps in BODY
+finished() {
+    for _ in $(seq 1 150); do
+        request GET "$V1/admin/eod/runs/$1" "" "${ADMIN[@]}"
+        [ "$(field "['run']['status']" 2>/dev/null)" != "running" ] && return
+        sleep 0.2
+    done
+}
+
+# deposit ACCOUNT [VERSION] - posts a deposit of 10
+deposit() {
+    if [ "$2" = "v2" ]; then
+        request POST "$V2/transactions" "{\"account_id\": $1, \"transaction_type\": \"deposit\", \"amount\": \"10.00\"}" "${ADMIN[@]}"
+    else
+        request POST "$V1/transactions" "{\"account_id\": $1, \"transaction_type\": \"deposit\", \"amount\": 10}" "${ADMIN[@]}"
+    fi
+}
+
+build
+YESTERDAY=$(python3 -c "import datetime; print(datetime.datetime.now(datetime.timezone.utc).date() - datetime.timedelta(days=1))")
+
+echo "Setup"
+start_server "$SERVER_BIN" EOD_QUEUE_SECONDS=20
+ADMIN=(-H "Authorization: Bearer $(staff eod-admin)")
+request POST "$V1/customers" "{\"first_name\": \"Day\", \"last_name\": \"Closer\", \"email\": \"eod-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${ADMIN[@]}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${ADMIN[@]}"
+CHECKING=$(field "['account']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"savings\", \"currency\": \"EUR\"}" "${ADMIN[@]}"
+check "a EUR account is opened, with no closing rate entered" "s == 201"
+deposit "$CHECKING"
+check "postings go through outside end of day" "s == 201"
+
+request GET "$V1/admin/eod" "" "${ADMIN[@]}"
+check "the steps are listed in order with their dependencies" \
+    "s == 200 and [x['job'] for x in b['steps']] == ['posting-cutoff', 'credit-lines', 'interest-accrual', 'installments', 'product-changes', 'maintenance-fees', 'fx-revaluation', 'escheat', 'statements', 'report-subscriptions'] and b['steps'][6]['depends_on'] == ['credit-lines', 'interest-accrual', 'installments', 'maintenance-fees']"
+check "postings are open" "b['paused'] == False and 'last_run' not in b"
+request GET "$V1/admin/jobs" "" "${ADMIN[@]}"
+check "end of day is scheduled and its nightly steps are not scheduled on their own" \
+    "[j['schedule'] for j in b['jobs'] if j['name'] == 'eod'] == ['30 0 * * *'] and all(j['schedule'] == 'off' for j in b['jobs'] if j['name'] in ('posting-cutoff', 'credit-lines', 'interest-accrual', 'installments', 'product-changes', 'maintenance-fees', 'fx-revaluation', 'escheat'))"
+request POST "$V1/admin/jobs/posting-cutoff/run" "" "${ADMIN[@]}"
+sleep 0.5
+request GET "$V1/admin/jobs/runs?job=posting-cutoff" "" "${ADMIN[@]}"
+check "the cutoff does nothing outside end of day" "b['runs'][0]['status'] == 'failed' and 'not paused' in b['runs'][0]['error']"
+
+echo
+echo "Posting window"
+hold escheat
+start_eod
+FIRST=$RUN
+check "end of day pauses postings" "s == 200 and b['paused'] and b['posting_window']['run_id'] == $FIRST"
+curl -s -o "$WORK/queued.json" -w '%{http_code}' -X POST "$V1/transactions" -H "Content-Type: application/json" \
+    -d "{\"account_id\": $CHECKING, \"transaction_type\": \"deposit\", \"amount\": 25}" "${ADMIN[@]}" > "$WORK/queued.status" &
+QUEUED_PID=$!
+sleep 1.5
+STATUS=0 BODY=
+check "a deposit queues while postings are paused" "$(kill -0 $QUEUED_PID 2>/dev/null && echo True || echo False)"
+request GET "$V1/accounts/$CHECKING/balance" "" "${ADMIN[@]}"
+check "reads are served" "s == 200 and b['balance'] == 10"
+request PUT "$V1/customers/$CUSTOMER" "{\"phone\": \"555-0100\"}" "${ADMIN[@]}"
+check "writes other than postings are served" "s == 200"
+request GET "$V1/admin/eod/runs/$FIRST" "" "${ADMIN[@]}"
+check "steps run in order until the held one" \
+    "b['run']['status'] == 'running' and [r['job_name'] for r in b['steps']] == ['posting-cutoff', 'credit-lines', 'interest-accrual', 'installments', 'product-changes', 'maintenance-fees', 'fx-revaluation']"
+check "each step is a run of its job with the end-of-day run as parent" \
+    "all(r['trigger'] == 'step' and r['parent_run_id'] == $FIRST and r['triggered_by'] == 'eod' for r in b['steps'])"
+
+release escheat
+finished "$FIRST"
+check "a failed step fails the run" "b['run']['status'] == 'failed' and b['run']['error'] == 'failed: fx-revaluation; skipped: report-subscriptions' and b['run']['items'] == 8"
+check "the failing step records why" "step('fx-revaluation')['status'] == 'failed' and 'no closing rate' in step('fx-revaluation')['error']"
+check "steps depending on it are skipped" \
+    "step('report-subscriptions')['status'] == 'skipped' and step('report-subscriptions')['error'] == 'depends on fx-revaluation, which did not succeed'"
+check "independent steps still run" "step('escheat')['status'] == 'succeeded' and step('statements')['status'] == 'succeeded'"
+check "each step records its duration" "all(r['finished_at'] for r in b['steps']) and step('escheat')['duration_ms'] < 60000"
+wait $QUEUED_PID
+check "the queued deposit posts once postings resume" "$(cat "$WORK/queued.status") == 201"
+request GET "$V1/admin/eod" "" "${ADMIN[@]}"
+check "postings resume however the run ends" "b['paused'] == False and b['last_run']['id'] == $FIRST and len(b['last_run_steps']) == 10"
+request GET "$V1/accounts/$CHECKING/balance" "" "${ADMIN[@]}"
+check "the queued deposit is on the balance" "b['balance'] == 35"
+
+echo
+echo "Resume"
+request POST "$V1/admin/eod/runs/$(sql "SELECT id FROM job_runs WHERE parent_run_id = $FIRST AND job_name = 'statements'")/resume" "" "${ADMIN[@]}"
+check "only end-of-day runs resume" "s == 404"
+request POST "$V1/admin/eod/runs/999999/resume" "" "${ADMIN[@]}"
+check "an unknown run is not found" "s == 404"
+request PUT "$V1/admin/fx-rates" "{\"currency\": \"EUR\", \"date\": \"$YESTERDAY\", \"rate\": 1.1, \"source\": \"test\"}" "${ADMIN[@]}"
+check "the missing rate is entered" "s == 201"
+request POST "$V1/admin/eod/runs/$FIRST/resume" "" "${ADMIN[@]}"
+check "the failed run is resumed" "s == 202 and b['run']['job_name'] == 'eod' and b['run']['resumed_from_id'] == $FIRST and b['run']['triggered_by'] == 'eod-admin'"
+SECOND=$(field "['run']['id']")
+finished "$SECOND"
+check "the resumed run succeeds" "b['run']['status'] == 'succeeded' and b['run']['items'] == 2"
+check "only the failed and skipped steps run again" \
+    "[(r['job_name'], r['status']) for r in b['steps']] == [('fx-revaluation', 'succeeded'), ('report-subscriptions', 'succeeded')]"
+request GET "$V1/admin/jobs/runs?job=fx-revaluation" "" "${ADMIN[@]}"
+check "the job run history holds both attempts" "[r['parent_run_id'] for r in b['runs']] == [$SECOND, $FIRST]"
+request POST "$V1/admin/eod/runs/$FIRST/resume" "" "${ADMIN[@]}"
+check "a run resumed since cannot be resumed again" "s == 409 and b['code'] == 'EOD_SUPERSEDED'"
+request POST "$V1/admin/eod/runs/$SECOND/resume" "" "${ADMIN[@]}"
+check "a run that succeeded cannot be resumed" "s == 409 and b['code'] == 'EOD_NOT_FAILED'"
+
+echo
+echo "Refusing postings"
+stop_server
+start_server "$SERVER_BIN" EOD_QUEUE_SECONDS=20 EOD_POSTING_MODE=reject EOD_RETRY_AFTER_SECONDS=7
+ADMIN=(-H "Authorization: Bearer $(token eod-admin)")
+hold escheat
+start_eod
+deposit "$CHECKING"
+check "a posting is refused with 503" "s == 503 and b['code'] == 'POSTING_PAUSED' and b['retry_after_seconds'] == 7"
+check "the refusal says when to retry" "h.get('retry-after') == '7'"
+deposit "$CHECKING" v2
+check "v2 postings are refused in its envelope" "s == 503 and b['error']['code'] == 'POSTING_PAUSED' and b['error']['details']['retry_after_seconds'] == 7"
+request POST "$V1/transfers" "{\"from_account_id\": $CHECKING, \"to_account_id\": $CHECKING, \"amount\": 1}" "${ADMIN[@]}" -H "Accept-Language: es"
+check "transfers are refused too, in the caller's language" "s == 503 and b['error'].startswith('Los movimientos')"
+release escheat
+finished "$RUN"
+check "the run succeeds with nothing left to revalue" "b['run']['status'] == 'succeeded' and b['run']['items'] == 10"
+deposit "$CHECKING"
+check "postings are accepted again" "s == 201"
+
+echo
+echo "Other instances"
+sql "UPDATE posting_windows SET paused = 1, expires_at = datetime('now', '+1 minute')" > /dev/null
+sleep 1.5
+deposit "$CHECKING"
+check "a pause taken by another instance applies within a second" "s == 503"
+sql "UPDATE posting_windows SET expires_at = datetime('now', '-1 second')" > /dev/null
+sleep 1.5
+deposit "$CHECKING"
+check "a pause whose run never finished lifts once it expires" "s == 201"
+
+finish "end-of-day"
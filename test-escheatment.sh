@@ -0,0 +1,138 @@
+#!/bin/bash
+
+# Escheatment Tests
+# Checks the dormancy thresholds and ledger balancing of escheatment against a server on the default settings
+# (ESCHEAT_AFTER_DAYS=1095, ESCHEAT_NOTICE_DAYS=30). Accounts are aged in the server's database: one just short of
+# the notice window is left alone, dormant ones get a final notice due no sooner than the notice period, interest
+# does not keep an account alive while a deposit does, and activity after a notice cancels it. A notice moved past
+# its due date is escheated with a balanced pair of postings into the tenant's escheatment account, shows on the
+# filing report, and is reclaimed with both postings reversed. Each run creates its own tenant; the platform admin
+# is created with bankctl, so DB_PATH must be the database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-escheatment.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-escheatment.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="escheat-test-$RUN_ID-Aa1!"
+TENANT_CODE="esc$RUN_ID"
+
+echo " Escheatment Tests"
+echo "=================="
+
+# ago DAYS - prints the time DAYS days ago as stored in the database
+ago() {
+    python3 -c "
+import datetime, sys
+print((datetime.datetime.now(datetime.timezone.utc) - datetime.timedelta(days=float(sys.argv[1]))).strftime('%Y-%m-%d %H:%M:%S+00:00'))" "$1"
+}
+
+# account IDLE_DAYS [AMOUNT] - opens a savings account with a deposit, both aged IDLE_DAYS, and prints its id
+account() {
+    request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"savings\"}" "${AUTH[@]}"
+    local id
+    id=$(field "['account']['id']")
+    post "$id" deposit "${2:-100}" "$1"
+    sql "UPDATE accounts SET created_at = '$(ago "$1")' WHERE id = $id" > /dev/null
+    echo "$id"
+}
+
+# post ACCOUNT TYPE AMOUNT DAYS_AGO - posts a transaction and ages it
+post() {
+    request POST "$V1/transactions" "{\"account_id\": $1, \"transaction_type\": \"$2\", \"amount\": $3}" "${AUTH[@]}"
+    sql "UPDATE transactions SET created_at = '$(ago "$4")', effective_date = '$(ago "$4")' WHERE id = $(field "['transaction']['id']")" > /dev/null
+}
+
+# run - runs the escheatment job for the tenant
+run() {
+    request POST "$V1/admin/escheatments/run" "" "${AUTH[@]}"
+}
+
+# escheatment ACCOUNT - prints the id of the account's latest escheatment
+escheatment() {
+    sql "SELECT id FROM escheatments WHERE account_id = $1 ORDER BY id DESC LIMIT 1"
+}
+
+# balance ACCOUNT - prints an account's stored balance and the sum of its postings as a Python list, e.g. [250, 250.0]
+balance() {
+    echo "[$(sql "SELECT balance FROM accounts WHERE id = $1"), $(sql "SELECT COALESCE(SUM(CASE WHEN transaction_type IN ('deposit', 'interest') THEN amount ELSE -amount END), 0) FROM transactions WHERE account_id = $1")]"
+}
+
+echo "Setup"
+tenant "escheat-platform-$RUN_ID" "Escheatment" escheat-admin
+request POST "$V1/customers" "{\"first_name\": \"Dormant\", \"last_name\": \"Owner\", \"email\": \"escheat-$RUN_ID@example.test\", \"date_of_birth\": \"1950-01-01\"}" "${AUTH[@]}"
+CUSTOMER=$(field "['customer']['id']")
+SHORT=$(account 1064)
+DORMANT=$(account 1100 250)
+INTEREST=$(account 1100)
+post "$INTEREST" interest 1.5 100
+DEPOSITED=$(account 1100)
+post "$DEPOSITED" deposit 10 100
+REVIVED=$(account 1100)
+EMPTY=$(account 1100)
+post "$EMPTY" withdrawal 100 1100
+check "accounts are aged in the database" "'$SHORT$DORMANT$INTEREST$DEPOSITED$REVIVED$EMPTY'.isdigit()"
+
+echo
+echo "Thresholds"
+run
+check "the job notices the dormant accounts only" "s == 200 and b['result'] == {'noticed': 3, 'cancelled': 0, 'escheated': 0}"
+check "an account a day short of the notice window is left alone" "'$(escheatment "$SHORT")' == ''"
+check "a dormant account gets a final notice" "'$(sql "SELECT status FROM escheatments WHERE account_id = $DORMANT")' == 'pending'"
+check "interest does not keep an account alive" "'$(sql "SELECT status FROM escheatments WHERE account_id = $INTEREST")' == 'pending'"
+check "a customer deposit does" "'$(escheatment "$DEPOSITED")' == ''"
+check "an account with no balance has nothing to turn over" "'$(escheatment "$EMPTY")' == ''"
+check "the notice is due no sooner than the notice period" \
+    "'$(sql "SELECT due_at FROM escheatments WHERE account_id = $DORMANT")'[:19] >= '$(ago -29.99)'[:19]"
+check "the final notice is emailed" \
+    "$(sql "SELECT COUNT(*) FROM notifications WHERE resource_type = 'escheatment' AND resource_id = $(escheatment "$DORMANT")") == 1"
+run
+check "a second run sends no more notices" "s == 200 and b['result']['noticed'] == 0"
+post "$REVIVED" deposit 5 0
+run
+check "activity after the notice cancels it" \
+    "b['result']['cancelled'] == 1 and '$(sql "SELECT status FROM escheatments WHERE account_id = $REVIVED")' == 'cancelled'"
+
+echo
+echo "Turnover"
+sql "UPDATE escheatments SET due_at = '$(ago 0.01)' WHERE account_id IN ($DORMANT, $INTEREST)" > /dev/null
+run
+check "notices past their due date are escheated" "s == 200 and b['result']['escheated'] == 2"
+ROW=$(escheatment "$DORMANT")
+HOLDING=$(sql "SELECT id FROM accounts WHERE account_number = 'GL-$TENANT-ESCHEATMENT-USD'")
+check "the account is escheated and emptied" \
+    "'$(sql "SELECT status FROM accounts WHERE id = $DORMANT")' == 'escheated' and $(balance "$DORMANT") == [0.0, 0.0]"
+check "the escheatment account holds both balances" "$(balance "$HOLDING") == [351.5, 351.5]"
+check "the debit and credit balance each other" \
+    "$(sql "SELECT SUM(CASE WHEN t.transaction_type = 'deposit' THEN t.amount ELSE -t.amount END) FROM transactions t JOIN escheatments e ON t.id IN (e.debit_transaction_id, e.credit_transaction_id) WHERE e.id = $ROW") == 0"
+request POST "$V1/transactions" "{\"account_id\": $DORMANT, \"transaction_type\": \"deposit\", \"amount\": 1}" "${AUTH[@]}"
+check "an escheated account takes no postings" "s >= 400"
+request GET "$V1/admin/escheatments?status=escheated" "" "${AUTH[@]}"
+check "the filing report lists the escheated accounts with their owner" \
+    "s == 200 and b['total'] == 2 and all(r['customer_name'] == 'Dormant Owner' for r in b['escheatments']) and sorted(r['amount'] for r in b['escheatments']) == [101.5, 250]"
+request GET "$V1/admin/escheatments?format=csv&status=escheated" "" "${AUTH[@]}"
+STATUS_CSV=$STATUS
+BODY=$(python3 -c "import csv, io, json, sys; print(json.dumps(list(csv.DictReader(io.StringIO(sys.argv[1])))))" "$BODY")
+STATUS=$STATUS_CSV
+check "the report is available as CSV" "s == 200 and len(b) == 2 and all(r['customer_name'] == 'Dormant Owner' and r['escheated'] for r in b)"
+
+echo
+echo "Reclaim"
+request POST "$V1/admin/escheatments/$ROW/reclaim" "{}" "${AUTH[@]}"
+check "a reclaim needs a note" "s == 400"
+request POST "$V1/admin/escheatments/$(escheatment "$REVIVED")/reclaim" "{\"note\": \"Not escheated\"}" "${AUTH[@]}"
+check "a cancelled notice cannot be reclaimed" "s == 409"
+request POST "$V1/admin/escheatments/$ROW/reclaim" "{\"note\": \"Owner came forward with identification\"}" "${AUTH[@]}"
+check "escheated funds are reclaimed" "s == 200 and b['escheatment']['status'] == 'reclaimed' and b['escheatment']['reclaimed_by']"
+check "the account is reactivated with its balance" \
+    "'$(sql "SELECT status FROM accounts WHERE id = $DORMANT")' == 'active' and $(balance "$DORMANT") == [250.0, 250.0]"
+check "the escheatment account gives the amount back" "$(balance "$HOLDING") == [101.5, 101.5]"
+check "both postings are reversed" \
+    "$(sql "SELECT COUNT(*) FROM transactions t JOIN escheatments e ON t.reversal_of_id IN (e.debit_transaction_id, e.credit_transaction_id) WHERE e.id = $ROW") == 2"
+check "the reclaim is in the account's status history with its note" \
+    "$(sql "SELECT COUNT(*) FROM status_histories WHERE subject_type = 'account' AND subject_id = $DORMANT AND new_status = 'active' AND reason = 'Owner came forward with identification'") == 1"
+request POST "$V1/admin/escheatments/$ROW/reclaim" "{\"note\": \"Again\"}" "${AUTH[@]}"
+check "funds cannot be reclaimed twice" "s == 409"
+request POST "$V1/admin/escheatments/999999999/reclaim" "{\"note\": \"Unknown\"}" "${AUTH[@]}"
+check "an unknown escheatment is not found" "s == 404"
+
+finish "escheatment"
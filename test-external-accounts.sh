@@ -0,0 +1,114 @@
+#!/bin/bash
+
+# External Account Tests
+# Checks link validation (ABA check digit, account number length, account type, one link per account), that the
+# numbers and micro-deposit amounts are stored encrypted and never returned, that the amounts verify a link in
+# either order, that three wrong pairs lock a link which then cannot be verified or unlinked, that an expired link
+# is refused, and that the external-accounts job purges expired unverified links but keeps verified ones. Each run
+# creates its own tenant; the platform admin is created with bankctl, the amounts are read with bankctl
+# micro-deposits and expiry dates are moved back in the server's database, so DB_PATH must be the database the
+# server uses and bankctl must run with the server's EXTERNAL_ACCOUNT_KEY. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-external-accounts.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-external-accounts.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="external-test-$RUN_ID-Aa1!"
+PLATFORM_USER="external-platform-$RUN_ID"
+TENANT_CODE="ext$RUN_ID"
+ACCOUNT_NUMBER="$(printf '%012d' "$((RUN_ID % 1000000000000))")"
+
+echo " External Account Tests"
+echo "======================="
+
+# link ROUTING ACCOUNT [TYPE] - asks to link an external account for the customer and stores its ID in LINK
+link() {
+    request POST "$V1/customers/$CUSTOMER/external-accounts" "{\"routing_number\": \"$1\", \"account_number\": \"$2\", \"account_type\": \"${3:-checking}\", \"nickname\": \"Credit union\"}" "${AUTH[@]}"
+    LINK=$(field "['external_account']['id']" 2>/dev/null)
+}
+
+# amounts LINK - prints a link's micro-deposits as a JSON array, read the way operators read them
+amounts() {
+    $BANKCTL -db "$DB_PATH" -json micro-deposits 2>/dev/null | python3 -c "
+import json, sys
+print(json.dumps([o['amounts'] for o in json.load(sys.stdin) if o['external_account_id'] == int(sys.argv[1])][0]))" "$1"
+}
+
+# verify LINK AMOUNTS - confirms a link with a JSON array of amounts
+verify() {
+    request POST "$V1/customers/$CUSTOMER/external-accounts/$1/verify" "{\"amounts\": $2}" "${AUTH[@]}"
+}
+
+echo "Setup"
+tenant "$PLATFORM_USER" "External" external-admin
+request POST "$V1/customers" "{\"first_name\": \"Linking\", \"last_name\": \"Client\", \"email\": \"external-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+CUSTOMER=$(field "['customer']['id']")
+
+echo
+echo "Validation"
+request POST "$V1/customers/$CUSTOMER/external-accounts" "{\"routing_number\": \"011000015\", \"account_number\": \"$ACCOUNT_NUMBER\"}"
+check "linking needs a signed-in user" "s == 401"
+link 011000016 "$ACCOUNT_NUMBER"
+check "a routing number failing the check digit is refused" "s == 400 and b['code'] == 'INVALID_EXTERNAL_ACCOUNT'"
+link 011000015 123
+check "account numbers have at least 4 digits" "s == 400 and b['code'] == 'INVALID_EXTERNAL_ACCOUNT'"
+link 011000015 12345678901234567890
+check "account numbers have at most 17 digits" "s == 400 and b['code'] == 'INVALID_EXTERNAL_ACCOUNT'"
+link 011000015 "$ACCOUNT_NUMBER" brokerage
+check "unknown account types are refused" "s == 400 and b['code'] == 'INVALID_EXTERNAL_ACCOUNT'"
+link 011000015 "$ACCOUNT_NUMBER"
+check "a link starts pending with the account number masked" "s == 201 and b['external_account']['status'] == 'pending' and b['external_account']['account_number_masked'] == '••••${ACCOUNT_NUMBER: -4}'"
+check "the response carries no numbers or amounts" "'$ACCOUNT_NUMBER' not in json.dumps(b) and '011000015' not in json.dumps(b) and not {'routing_number', 'account_number', 'deposits', 'amounts'} & set(b['external_account'])"
+FIRST=$LINK
+check "the numbers are stored encrypted" "'$(sql "SELECT COUNT(*) FROM external_accounts WHERE id = $FIRST AND (account_number LIKE '%$ACCOUNT_NUMBER%' OR routing_number = '011000015' OR deposits LIKE '%,%')")' == '0'"
+link 011000015 "$ACCOUNT_NUMBER"
+check "the same account cannot be linked twice" "s == 409 and b['code'] == 'EXTERNAL_ACCOUNT_EXISTS'"
+
+echo
+echo "Verification"
+FIRST_AMOUNTS=$(amounts "$FIRST")
+check "operators can read the two amounts" "len($FIRST_AMOUNTS) == 2 and all(0.01 <= a <= 0.99 for a in $FIRST_AMOUNTS) and $FIRST_AMOUNTS[0] != $FIRST_AMOUNTS[1]"
+verify "$FIRST" "[1.5]"
+check "both amounts are needed" "s == 400"
+verify "$FIRST" "$(python3 -c "a = $FIRST_AMOUNTS; print([a[0], round(1 - a[0], 2) if round(1 - a[0], 2) != a[1] else 0.5])")"
+check "a wrong pair uses an attempt" "s == 422 and b['code'] == 'MICRO_DEPOSIT_MISMATCH' and b['attempts_remaining'] == 2"
+verify "$FIRST" "$(python3 -c "print(list(reversed($FIRST_AMOUNTS)))")"
+check "the amounts verify the link in either order" "s == 200 and b['external_account']['status'] == 'verified' and b['external_account']['verified_at']"
+verify "$FIRST" "$FIRST_AMOUNTS"
+check "a verified link is not verified again" "s == 409 and b['code'] == 'EXTERNAL_ACCOUNT_NOT_PENDING'"
+check "the amounts are cleared once used" "'$(sql "SELECT deposits FROM external_accounts WHERE id = $FIRST")' == ''"
+link 011000015 "$ACCOUNT_NUMBER"
+check "a verified account cannot be linked again" "s == 409 and b['code'] == 'EXTERNAL_ACCOUNT_EXISTS'"
+
+echo
+echo "Lockout"
+link 021000021 "$ACCOUNT_NUMBER" savings
+LOCKING=$LINK
+LOCKING_AMOUNTS=$(amounts "$LOCKING")
+for attempt in 1 2 3; do
+    verify "$LOCKING" "[0.0$attempt, 0.00]"
+done
+check "the third wrong pair locks the link" "s == 422 and b['attempts_remaining'] == 0 and b['external_account']['status'] == 'locked'"
+verify "$LOCKING" "$LOCKING_AMOUNTS"
+check "a locked link refuses even the right amounts" "s == 423 and b['code'] == 'EXTERNAL_ACCOUNT_LOCKED'"
+request DELETE "$V1/customers/$CUSTOMER/external-accounts/$LOCKING" "" "${AUTH[@]}"
+check "a locked link cannot be unlinked" "s == 423"
+link 021000021 "$ACCOUNT_NUMBER" savings
+check "a locked account cannot be linked again before it expires" "s == 409"
+
+echo
+echo "Expiry"
+link 011000015 "9$ACCOUNT_NUMBER"
+EXPIRING=$LINK
+sql "UPDATE external_accounts SET expires_at = '2000-01-01 00:00:00+00:00' WHERE id IN ($EXPIRING, $LOCKING)" > /dev/null
+verify "$EXPIRING" "$(amounts "$EXPIRING" 2>/dev/null || echo '[0.01, 0.02]')"
+check "an expired link is refused" "s == 410 and b['code'] == 'EXTERNAL_ACCOUNT_EXPIRED'"
+run_job external-accounts
+request GET "$V1/customers/$CUSTOMER/external-accounts" "" "${AUTH[@]}"
+check "the job purges expired pending and locked links and keeps verified ones" "[(x['id'], x['status']) for x in b['external_accounts']] == [($FIRST, 'verified')]"
+link 021000021 "$ACCOUNT_NUMBER" savings
+check "a purged account can be linked again" "s == 201"
+request DELETE "$V1/customers/$CUSTOMER/external-accounts/$FIRST" "" "${AUTH[@]}"
+check "a verified link can be unlinked" "s == 200"
+
+finish "external account"
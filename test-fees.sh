@@ -0,0 +1,159 @@
+#!/bin/bash
+
+# Transaction Fee Schedule Tests
+# Checks fee schedule validation and overlaps, the fee each pricing rule charges (flat, percentage, minimum and
+# maximum), that the most specific schedule wins, that fees post as linked transactions itemized in v1 and v2
+# responses and on statements, that a posting the balance cannot cover with its fee is refused as a whole, and
+# that effective-dated changes leave fees already charged alone. Schedules are made for a product created by the
+# run, so other postings are never charged. The admin user is created with bankctl. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-fees.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-fees.sh
+
+source "$(dirname "$0")/test-lib.sh"
+V2="$BASE_URL/api/v2"
+PASSWORD="fees-test-$RUN_ID"
+ADMIN_USER="fees-admin-$RUN_ID"
+PRODUCT="fee$(( $(date +%s) % 1000000 ))$(( $$ % 1000 ))"
+
+echo " Transaction Fee Schedule Tests"
+echo "==============================="
+
+# day OFFSET - prints today's UTC date moved by OFFSET days, as YYYY-MM-DD
+day() {
+    python3 -c "import datetime, sys; print((datetime.datetime.now(datetime.timezone.utc).date() + datetime.timedelta(days=int(sys.argv[1]))).isoformat())" "$1"
+}
+
+# schedule FIELDS - creates a fee schedule for the run's product in USD and stores its ID in SCHEDULE
+schedule() {
+    request POST "$V1/admin/fee-schedules" "{\"account_type\": \"$PRODUCT\", \"currency\": \"USD\", $1}" "${ADMIN[@]}"
+    SCHEDULE=$(field "['fee_schedule']['id']" 2>/dev/null)
+}
+
+# post TYPE AMOUNT [FIELDS] - posts a transaction on the run's account
+post() {
+    request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"$1\", \"amount\": $2${3:+, $3}}" "${ADMIN[@]}"
+}
+
+# transfer AMOUNT CHANNEL - transfers from the run's account to the other account
+transfer() {
+    request POST "$V1/transfers" "{\"from_account_id\": $ACCOUNT, \"to_account_id\": $OTHER, \"amount\": $1, \"channel\": \"$2\", \"confirm_duplicate\": true}" "${ADMIN[@]}"
+}
+
+# balance - prints the run's account balance
+balance() {
+    curl -s "${ADMIN[@]}" "$V1/accounts/$ACCOUNT" | python3 -c "import json, sys; print(json.load(sys.stdin)['balance'])"
+}
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "$ADMIN_USER")")
+request POST "$V1/admin/products" "{\"account_type\": \"$PRODUCT\", \"name\": \"Fee test account\"}" "${ADMIN[@]}"
+request POST "$V1/customers" "{\"first_name\": \"Fee\", \"last_name\": \"Payer\", \"email\": \"fees-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\", \"monthly_income\": 10000}" "${ADMIN[@]}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"$PRODUCT\", \"currency\": \"USD\"}" "${ADMIN[@]}"
+ACCOUNT=$(field "['account']['id']")
+ACCOUNT_NUMBER=$(field "['account']['account_number']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\", \"currency\": \"USD\"}" "${ADMIN[@]}"
+OTHER=$(field "['account']['id']")
+check "accounts are opened on the run's product" "s == 201"
+
+echo
+echo "Validation"
+schedule '"name": "Bad", "transaction_type": "interest", "flat_fee": 1, "effective_from": "2025-01-01"'
+check "only customer posting types can be charged" "s == 400 and b['code'] == 'INVALID_FEE_SCHEDULE'"
+schedule '"name": "Bad", "transaction_type": "withdrawal", "channel": "carrier-pigeon", "flat_fee": 1, "effective_from": "2025-01-01"'
+check "an unknown channel is rejected" "s == 400"
+request POST "$V1/admin/fee-schedules" '{"name": "Bad", "transaction_type": "withdrawal", "currency": "XYZ", "flat_fee": 1, "effective_from": "2025-01-01"}' "${ADMIN[@]}"
+check "an unsupported currency is rejected" "s == 400"
+schedule '"name": "Bad", "transaction_type": "withdrawal", "effective_from": "2025-01-01"'
+check "a schedule needs a flat fee or a percentage" "s == 400"
+schedule '"name": "Bad", "transaction_type": "withdrawal", "percent": 1, "min_fee": 5, "max_fee": 2, "effective_from": "2025-01-01"'
+check "the maximum cannot be below the minimum" "s == 400"
+schedule '"name": "Bad", "transaction_type": "withdrawal", "flat_fee": 1, "effective_from": "2025-02-01", "effective_to": "2025-01-31"'
+check "the range cannot end before it starts" "s == 400"
+schedule '"name": "Bad", "transaction_type": "withdrawal", "flat_fee": 1, "effective_from": "01/02/2025"'
+check "dates must be YYYY-MM-DD" "s == 400"
+request POST "$V1/admin/fee-schedules" '{"name": "Bad", "account_type": "loan", "transaction_type": "payment", "currency": "USD", "flat_fee": 1, "effective_from": "2025-01-01"}' "${ADMIN[@]}"
+check "products are checked against the catalog" "s == 400 and b['code'] == 'RESERVED_ACCOUNT_TYPE'"
+
+echo
+echo "Schedules"
+schedule "\"name\": \"Old withdrawal fee\", \"transaction_type\": \"withdrawal\", \"flat_fee\": 1.5, \"effective_from\": \"$(day -30)\", \"effective_to\": \"$(day -6)\""
+check "a schedule is created" "s == 201 and b['fee_schedule']['created_by'] == '$ADMIN_USER'"
+OLD_WITHDRAWAL=$SCHEDULE
+schedule "\"name\": \"Withdrawal fee\", \"transaction_type\": \"withdrawal\", \"flat_fee\": 2, \"effective_from\": \"$(day -5)\""
+WITHDRAWAL=$SCHEDULE
+check "the next price starts where the old one ends" "s == 201"
+schedule "\"name\": \"Clash\", \"transaction_type\": \"withdrawal\", \"flat_fee\": 9, \"effective_from\": \"$(day -10)\", \"effective_to\": \"$(day -5)\""
+check "overlapping schedules are refused" "s == 409 and b['code'] == 'FEE_SCHEDULE_OVERLAP' and b['conflicting_id'] in ($OLD_WITHDRAWAL, $WITHDRAWAL)"
+schedule "\"name\": \"ATM withdrawal fee\", \"transaction_type\": \"withdrawal\", \"channel\": \"atm\", \"flat_fee\": 3, \"effective_from\": \"$(day -5)\""
+check "a channel-specific schedule can sit alongside" "s == 201"
+schedule "\"name\": \"Online transfer fee\", \"transaction_type\": \"transfer\", \"channel\": \"online\", \"percent\": 0.5, \"min_fee\": 2, \"max_fee\": 25, \"effective_from\": \"$(day -5)\""
+schedule "\"name\": \"Bill payment fee\", \"transaction_type\": \"payment\", \"flat_fee\": 1, \"percent\": 1, \"effective_from\": \"$(day -5)\""
+request GET "$V1/admin/fee-schedules?account_type=$PRODUCT&active_on=$(day 0)" "" "${ADMIN[@]}"
+check "schedules in effect today are listed" "len(b['fee_schedules']) == 4 and $OLD_WITHDRAWAL not in [x['id'] for x in b['fee_schedules']]"
+
+echo
+echo "Charging"
+post deposit 20000
+check "a posting no schedule covers has no fee" "s == 201 and 'fee' not in b"
+post withdrawal 100
+check "a flat fee is charged" "s == 201 and b['fee']['amount'] == 2 and b['fee']['transaction_type'] == 'fee'"
+check "the fee links the posting and its schedule" "b['fee']['fee_of_id'] == b['transaction']['id'] and b['fee']['fee_schedule_id'] == $WITHDRAWAL"
+check "the fee names its schedule" "b['fee']['description'].startswith('Withdrawal fee on ')"
+check "the balance reflects both" "b['fee']['balance_after'] == 20000 - 100 - 2"
+FIRST_FEE=$(field "['fee']['id']")
+post withdrawal 100 '"channel": "atm"'
+check "the most specific schedule wins" "b['fee']['amount'] == 3"
+post payment 50
+check "flat and percentage add up" "b['fee']['amount'] == 1.5"
+post payment 33.33
+check "fees round to cents" "b['fee']['amount'] == 1.33"
+transfer 100 online
+check "a percentage is raised to the minimum" "s == 201 and b['fee']['amount'] == 2"
+check "the transfer links its fee" "b['transfer']['fee_transaction_id'] == b['fee']['id']"
+transfer 1000 online
+check "a percentage between the bounds" "b['fee']['amount'] == 5"
+transfer 10000 online
+check "a percentage is capped at the maximum" "b['fee']['amount'] == 25"
+transfer 100 branch
+check "other channels are not charged" "s == 201 and 'fee' not in b"
+request POST "$V2/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"withdrawal\", \"amount\": \"10.00\"}" "${ADMIN[@]}"
+check "v2 itemizes the fee" "s == 201 and b['data']['fee']['amount'] == '2.00' and b['data']['fee']['fee_of_id'] == b['data']['id']"
+request POST "$V2/transfers" "{\"from_account_id\": $ACCOUNT, \"to_account_id\": $OTHER, \"amount\": \"400.00\", \"channel\": \"online\"}" "${ADMIN[@]}"
+check "v2 transfers itemize the fee" "s == 201 and b['data']['fee']['amount'] == '2.00'"
+
+BEFORE=$(balance)
+post withdrawal "$BEFORE"
+check "a posting its fee would overdraw is refused" "s == 400 and 'Insufficient' in b['error']"
+BODY="{\"balance\": $(balance)}" STATUS=200
+check "nothing is posted" "b['balance'] == $BEFORE"
+
+echo
+echo "Effective dating"
+post withdrawal 10 "\"effective_date\": \"$(day -10)T12:00:00Z\""
+check "a backdated posting is charged the price then in effect" "s == 201 and b['fee']['amount'] == 1.5 and b['fee']['fee_schedule_id'] == $OLD_WITHDRAWAL"
+check "the fee takes the posting's effective date" "b['fee']['effective_date'][:10] == '$(day -10)'"
+request PUT "$V1/admin/fee-schedules/$WITHDRAWAL" "{\"name\": \"Withdrawal fee\", \"account_type\": \"$PRODUCT\", \"transaction_type\": \"withdrawal\", \"currency\": \"USD\", \"flat_fee\": 4, \"effective_from\": \"$(day -5)\"}" "${ADMIN[@]}"
+check "a schedule that charged fees cannot be repriced" "s == 409 and b['code'] == 'FEE_SCHEDULE_IN_USE'"
+request PUT "$V1/admin/fee-schedules/$WITHDRAWAL" "{\"name\": \"Withdrawal fee\", \"account_type\": \"$PRODUCT\", \"transaction_type\": \"withdrawal\", \"currency\": \"USD\", \"flat_fee\": 2, \"effective_from\": \"$(day -5)\", \"effective_to\": \"$(day -1)\"}" "${ADMIN[@]}"
+check "nor end before its last fee" "s == 409 and b['last_charged_on'] == '$(day 0)'"
+request PUT "$V1/admin/fee-schedules/$WITHDRAWAL" "{\"name\": \"Withdrawal fee\", \"account_type\": \"$PRODUCT\", \"transaction_type\": \"withdrawal\", \"currency\": \"USD\", \"flat_fee\": 2, \"effective_from\": \"$(day -5)\", \"effective_to\": \"$(day 0)\"}" "${ADMIN[@]}"
+check "it can be ended" "s == 200 and b['fee_schedule']['effective_to'][:10] == '$(day 0)'"
+schedule "\"name\": \"Withdrawal fee\", \"transaction_type\": \"withdrawal\", \"flat_fee\": 4, \"effective_from\": \"$(day 1)\""
+check "and a new price scheduled from tomorrow" "s == 201"
+request DELETE "$V1/admin/fee-schedules/$WITHDRAWAL" "" "${ADMIN[@]}"
+check "a schedule that charged fees cannot be deleted" "s == 409"
+request DELETE "$V1/admin/fee-schedules/$SCHEDULE" "" "${ADMIN[@]}"
+check "an unused schedule can be deleted" "s == 200"
+request GET "$V1/transactions?account_id=$ACCOUNT&type=fee&limit=100" "" "${ADMIN[@]}"
+check "fees already charged keep their amounts" "[t['amount'] for t in b['transactions'] if t['id'] == $FIRST_FEE] == [2]"
+
+echo
+echo "Statements"
+STATEMENT=$($BANKCTL -db "$DB_PATH" statement -account "$ACCOUNT_NUMBER" -month "$(day 0 | cut -c1-7)" -format csv -out /dev/stdout)
+BODY='{}' STATUS=200
+check "fees are statement lines of their own" "sum(1 for l in '''$STATEMENT'''.splitlines() if ',fee,' in l) >= 8"
+check "each names the posting it was charged on" "all(' ON TXN' in l for l in '''$STATEMENT'''.splitlines() if ',fee,' in l)"
+
+finish "fee"
@@ -0,0 +1,110 @@
+#!/bin/bash
+
+# FX Revaluation Tests
+# Checks closing rate entry and validation, the fx-revaluation job valuing a EUR account day by day at each
+# day's closing rate, the unrealized gain or loss it posts to the general ledger, that a missing rate fails the
+# day (and every later one) loudly instead of reusing an older rate, catching up once the rate is entered, and
+# the revaluation report. Each run creates its own tenant so earlier runs never overlap it; the platform admin is
+# created with bankctl, and the run's revaluation history is seeded in the server's database, so DB_PATH must be
+# the database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-fx.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-fx.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="fx-test-$RUN_ID-Aa1!"
+PLATFORM_USER="fx-platform-$RUN_ID"
+TENANT_CODE="fx$RUN_ID"
+
+echo " FX Revaluation Tests"
+echo "====================="
+
+# day OFFSET - prints today's UTC date moved by OFFSET days, as YYYY-MM-DD
+day() {
+    python3 -c "import datetime, sys; print((datetime.datetime.now(datetime.timezone.utc).date() + datetime.timedelta(days=int(sys.argv[1]))).isoformat())" "$1"
+}
+
+# rate CURRENCY OFFSET RATE - enters a closing rate for a day relative to today
+rate() {
+    request PUT "$V1/admin/fx-rates" "{\"currency\": \"$1\", \"date\": \"$(day "$2")\", \"rate\": $3, \"source\": \"test\"}" "${AUTH[@]}"
+}
+
+# revaluation OFFSET COLUMN - prints a column of the run's EUR revaluation of a day relative to today
+revaluation() {
+    sql "SELECT $2 FROM fx_revaluations WHERE tenant_id = $TENANT AND currency = 'EUR' AND date = '$(day "$1") 00:00:00+00:00'"
+}
+
+# gl CODE - prints the balance of one of the run's USD general-ledger accounts
+gl() {
+    sql "SELECT balance FROM accounts WHERE account_number = 'GL-$TENANT-$1-USD'"
+}
+
+echo "Setup"
+tenant "$PLATFORM_USER" "FX" fx-admin
+request POST "$V1/customers" "{\"first_name\": \"Euro\", \"last_name\": \"Saver\", \"email\": \"fx-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\", \"monthly_income\": 10000}" "${AUTH[@]}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"savings\", \"currency\": \"EUR\"}" "${AUTH[@]}"
+EUR_ACCOUNT=$(field "['account']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+USD_ACCOUNT=$(field "['account']['id']")
+request POST "$V1/transactions" "{\"account_id\": $EUR_ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 1000}" "${AUTH[@]}"
+request POST "$V1/transactions" "{\"account_id\": $USD_ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 250}" "${AUTH[@]}"
+# The deposits are moved a week back, and EUR was last revalued four days ago at 1.10
+sql "UPDATE transactions SET effective_date = datetime('now', '-7 days') WHERE tenant_id = $TENANT" > /dev/null
+sql "INSERT INTO fx_revaluations (tenant_id, currency, date, status, rate, accounts, balance, base_currency_value, impact, created_at, updated_at)
+     VALUES ($TENANT, 'EUR', '$(day -4) 00:00:00+00:00', 'posted', 1.10, 1, 1000, 1100, 0, datetime('now'), datetime('now'))" > /dev/null
+sql "INSERT INTO balance_snapshots (tenant_id, account_id, date, currency, balance, rate, base_currency_value, created_at)
+     VALUES ($TENANT, $EUR_ACCOUNT, '$(day -4) 00:00:00+00:00', 'EUR', 1000, 1.10, 1100, datetime('now'))" > /dev/null
+
+echo
+echo "Rates"
+rate USD -3 1
+check "the base currency has no rate" "s == 400 and b['code'] == 'INVALID_FX_RATE'"
+rate EUR -3 0
+check "a rate must be positive" "s == 400 and b['code'] == 'INVALID_FX_RATE'"
+rate EUR 1 1.2
+check "a future day cannot have a rate" "s == 400"
+rate eur -3 1.2
+check "a closing rate is entered" "s == 201 and b['rate']['currency'] == 'EUR' and b['rate']['date'].startswith('$(day -3)')"
+rate EUR -3 1.12
+check "it can be corrected before it is used" "s == 200 and b['rate']['rate'] == 1.12"
+rate EUR -1 1.08
+request GET "$V1/admin/fx-rates?currency=EUR&from=$(day -3)" "" "${AUTH[@]}"
+check "rates are listed latest first" "b['total'] == 2 and b['rates'][0]['date'].startswith('$(day -1)')"
+
+echo
+echo "Revaluation"
+run_job fx-revaluation
+check "a missing rate fails the run loudly" "'tenant $TENANT EUR on $(day -2)' in '''$JOB_ERROR''' and 'no closing rate' in '''$JOB_ERROR'''"
+check "the day before the gap is revalued" "'$(revaluation -3 status)' == 'posted' and $(revaluation -3 base_currency_value) == 1120"
+check "EUR strengthening against deposits is a loss" "$(revaluation -3 impact) == -20"
+check "the account is snapshotted in the base currency" "$(sql "SELECT base_currency_value FROM balance_snapshots WHERE account_id = $EUR_ACCOUNT AND date = '$(day -3) 00:00:00+00:00'") == 1120"
+check "the gap day is recorded as failed" "'$(revaluation -2 status)' == 'failed' and 'no closing rate' in '$(revaluation -2 error)'"
+check "no later day is revalued past the gap" "'$(revaluation -1 status)' == ''"
+check "no stale rate was used" "$(sql "SELECT count(*) FROM balance_snapshots WHERE account_id = $EUR_ACCOUNT") == 2"
+check "the loss is posted against the revaluation account" "$(gl FX_GAIN_LOSS) == -20 and $(gl FX_REVALUATION) == 20"
+check "USD accounts are not revalued" "$(sql "SELECT count(*) FROM balance_snapshots WHERE account_id = $USD_ACCOUNT") == 0"
+rate EUR -3 1.5
+check "a rate a revaluation used is fixed" "s == 409 and b['code'] == 'FX_RATE_IN_USE'"
+
+request GET "$V1/reports/fx-revaluation?from=$(day -5)&to=$(day 0)" "" "${AUTH[@]}"
+check "the report flags the failed day" "not b['complete'] and b['currencies'][0]['failed_days'] == ['$(day -2)']"
+
+rate EUR -2 1.15
+run_job fx-revaluation
+check "the run catches up once the rate is entered" "'tenant $TENANT ' not in '''$JOB_ERROR'''"
+check "each day is valued at its own rate" "$(revaluation -2 impact) == -30 and $(revaluation -1 impact) == 70"
+check "the gain and losses net out on the ledger" "$(gl FX_GAIN_LOSS) == 20 and $(gl FX_REVALUATION) == -20"
+run_job fx-revaluation
+check "a posted day is never revalued twice" "$(sql "SELECT count(*) FROM balance_snapshots WHERE account_id = $EUR_ACCOUNT") == 4"
+
+echo
+echo "Report"
+request GET "$V1/reports/fx-revaluation?from=$(day -5)&to=$(day 0)" "" "${AUTH[@]}"
+check "the report is complete" "s == 200 and b['complete'] and b['base_currency'] == 'USD'"
+check "exposure is valued at the latest rate" "b['currencies'][0]['balance'] == 1000 and b['currencies'][0]['base_currency_value'] == 1080 and b['currencies'][0]['as_of'].startswith('$(day -1)')"
+check "the period's impact sums every day" "b['currencies'][0]['impact'] == 20 and b['total_impact'] == 20 and b['currencies'][0]['days_revalued'] == 4"
+request GET "$V1/reports/trial-balance" "" "${AUTH[@]}"
+check "the books still balance" "all(tb['balanced'] for tb in b['trial_balances'])"
+
+finish "FX revaluation"
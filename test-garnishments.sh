@@ -0,0 +1,242 @@
+#!/bin/bash
+
+# Garnishment Order Tests
+# Checks that only admins register, suspend, resume and satisfy garnishment orders, that an order needs a court, a
+# case reference, a payee and a total, and that its documents must be the debtor's. Registering an order queues the
+# customer's notice and sweeps the balance above the protected amount to the remittance account; later deposits are
+# swept the same way until the order is collected in full. Spending below the protected amount, overdrafts and liens
+# ranked ahead of the order are left alone, while junior liens are not. Customer-wide orders protect the amount
+# across all the customer's accounts. An admin and a teller are created with bankctl against the server's database,
+# so DB_PATH must be the database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-garnishments.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-garnishments.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="garnishments-test-$RUN_ID"
+
+echo " Garnishment Order Tests"
+echo "========================"
+
+# customer NAME - creates a customer and prints its id
+customer() {
+    request POST "$V1/customers" "{\"first_name\": \"$1\", \"last_name\": \"Debtor\", \"email\": \"garnishments-$1-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}"
+    field "['customer']['id']"
+}
+
+# account CUSTOMER - opens a checking account and prints its id
+# Account numbers opened in the same second can collide, so a refused open is retried
+account() {
+    for _ in 1 2 3; do
+        request POST "$V1/accounts" "{\"customer_id\": $1, \"account_type\": \"checking\"}"
+        [ "$STATUS" = 201 ] && break
+        sleep 1
+    done
+    field "['account']['id']"
+}
+
+# upload CUSTOMER - uploads a one-page PDF court order for a customer and prints the document id
+upload() {
+    printf '%%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj\ntrailer << /Root 1 0 R >>\n%%%%EOF\n' > "$WORK/order.pdf"
+    curl -s -X POST "$V1/customers/$1/documents" "${ADMIN[@]}" -F kind=legal_order -F "file=@$WORK/order.pdf;type=application/pdf" |
+        python3 -c "import json, sys; print(json.load(sys.stdin)['document']['id'])"
+}
+
+# post ACCOUNT TYPE AMOUNT [CURL_ARGS...] - posts a transaction on v1
+post() {
+    local account=$1 type=$2 amount=$3
+    shift 3
+    request POST "$V1/transactions" "{\"account_id\": $account, \"transaction_type\": \"$type\", \"amount\": $amount}" "$@"
+}
+
+# balance ACCOUNT - prints an account's balance from the database, where the cache cannot lag
+balance() {
+    sql "SELECT balance FROM accounts WHERE id = ?" "$1" | sed 's/\.0$//'
+}
+
+# collected ORDER - prints what an order has collected as a whole number
+collected() {
+    sql "SELECT collected_amount FROM garnishment_orders WHERE id = ?" "$1" | sed 's/\.0$//'
+}
+
+# overdraft ENABLED - turns the overdraft flag on or off
+overdraft() {
+    request PUT "$V1/admin/flags/overdraft" "{\"enabled\": $1, \"rollout_percent\": 100}" "${ADMIN[@]}"
+}
+
+# garnish JSON - registers an order as the admin
+garnish() {
+    request POST "$V1/garnishments" "$1" "${ADMIN[@]}"
+}
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "garnishments-admin-$RUN_ID" admin)")
+TELLER=(-H "Authorization: Bearer $(staff "garnishments-teller-$RUN_ID" teller)")
+request GET "$V1/admin/flags" "" "${ADMIN[@]}"
+WAS_ENABLED=$(python3 -c "import json, sys; print(json.dumps([f['enabled'] for f in json.loads(sys.argv[1])['flags'] if f['key'] == 'overdraft'][0]))" "$BODY")
+on_exit 'overdraft "$WAS_ENABLED"'
+DEBTOR=$(customer Gina)
+ACCOUNT=$(account "$DEBTOR")
+SPARE=$(account "$DEBTOR")
+OTHER=$(customer Other)
+COURT=$(account "$OTHER")
+post "$ACCOUNT" deposit 1000
+ORDER=$(upload "$DEBTOR")
+AMENDED=$(upload "$DEBTOR")
+FOREIGN_ORDER=$(upload "$OTHER")
+check "court orders are uploaded as legal_order documents" "'$ORDER'.isdigit() and '$FOREIGN_ORDER'.isdigit()"
+ORDER_JSON="\"court\": \"County Court\", \"case_reference\": \"GO-1\", \"payee_name\": \"Acme Collections\", \"total_amount\": 1200, \"protected_amount\": 300"
+
+echo
+echo "Registering orders"
+request POST "$V1/garnishments" "{\"account_id\": $ACCOUNT, $ORDER_JSON}"
+check "registering an order needs a login" "s == 401"
+request POST "$V1/garnishments" "{\"account_id\": $ACCOUNT, $ORDER_JSON}" "${TELLER[@]}"
+check "tellers cannot register orders" "s == 403"
+garnish "{$ORDER_JSON}"
+check "an order names an account or a customer" "s == 400"
+garnish "{\"account_id\": $ACCOUNT, \"customer_id\": $DEBTOR, $ORDER_JSON}"
+check "but not both" "s == 400"
+garnish "{\"account_id\": $ACCOUNT, \"court\": \" \", \"case_reference\": \"GO-1\", \"payee_name\": \"Acme Collections\", \"total_amount\": 1200}"
+check "an order needs a court" "s == 400"
+garnish "{\"account_id\": $ACCOUNT, \"court\": \"County Court\", \"case_reference\": \"GO-1\", \"payee_name\": \"Acme Collections\", \"total_amount\": 1200, \"protected_amount\": -1}"
+check "the protected amount cannot be negative" "s == 400"
+garnish "{\"account_id\": $ACCOUNT, $ORDER_JSON, \"document_ids\": [$FOREIGN_ORDER]}"
+check "documents must be the debtor's" "s == 400"
+garnish "{\"account_id\": $ACCOUNT, $ORDER_JSON, \"remittance_account_id\": $SPARE}"
+check "funds cannot be remitted to the debtor's own account" "s == 400"
+check "refused orders leave nothing behind" \
+    "$(sql "SELECT count(*) FROM garnishment_orders WHERE customer_id = ?" "$DEBTOR") == 0 and $(balance "$ACCOUNT") == 1000"
+garnish "{\"account_id\": $ACCOUNT, $ORDER_JSON, \"payee_reference\": \"ACME-77\", \"document_ids\": [$ORDER]}"
+check "an order is registered with its court order" \
+    "s == 201 and b['garnishment']['status'] == 'active' and b['garnishment']['scope'] == 'account' and b['garnishment']['priority'] == 1 and [d['id'] for d in b['garnishment']['documents']] == [$ORDER]"
+check "it sweeps the balance above the protected amount right away" \
+    "[(p['account_id'], p['amount']) for p in b['swept']] == [($ACCOUNT, 700)] and b['garnishment']['collected_amount'] == 700"
+GARNISHMENT=$(field "['garnishment']['id']")
+REMIT=$(field "['garnishment']['remittance_account_id']")
+NOTICE=$(field "['garnishment']['notification_id']")
+check "the debtor keeps the protected amount" "$(balance "$ACCOUNT") == 300"
+check "the funds go to the GARNISHMENTS internal account" \
+    "'$(sql "SELECT account_type FROM accounts WHERE id = ?" "$REMIT")' == 'internal' and $(balance "$REMIT") == 700"
+check "the sweep is a ledger transfer quoting the payee reference" \
+    "'$(sql "SELECT reference FROM transactions WHERE account_id = ? AND description = 'Garnishment to Acme Collections (GO-1)'" "$ACCOUNT")' == 'ACME-77'"
+check "the customer's notice is queued to their email" \
+    "'$(sql "SELECT recipient FROM notifications WHERE id = ? AND resource_type = 'garnishment' AND resource_id = ?" "$NOTICE" "$GARNISHMENT")' == 'garnishments-Gina-$RUN_ID@example.com'"
+check "the notice states the protected amount" \
+    "'300.00 USD' in '''$(sql "SELECT body FROM notifications WHERE id = ?" "$NOTICE")'''"
+
+echo
+echo "Deposits, spending and overdrafts"
+post "$ACCOUNT" deposit 100
+check "a deposit posts and is swept above the protected amount" "s == 201 and $(balance "$ACCOUNT") == 300 and $(collected "$GARNISHMENT") == 800"
+post "$ACCOUNT" withdrawal 250
+check "the debtor may spend the protected amount" "s == 201 and $(balance "$ACCOUNT") == 50"
+post "$ACCOUNT" deposit 200
+check "a deposit that leaves the balance below the protected amount is not swept" \
+    "s == 201 and $(balance "$ACCOUNT") == 250 and $(collected "$GARNISHMENT") == 800"
+python3 -c "
+import sqlite3, sys
+db = sqlite3.connect(sys.argv[1], timeout=10)
+db.execute('UPDATE accounts SET overdraft_limit = 500 WHERE id = ?', (sys.argv[2],))
+db.commit()" "$DB_PATH" "$ACCOUNT"
+overdraft true
+check "the overdraft flag is turned on" "s == 200 and b['flag']['enabled']"
+post "$ACCOUNT" withdrawal 400
+check "the debtor may draw on the overdraft" "s == 201 and $(balance "$ACCOUNT") == -150"
+post "$ACCOUNT" deposit 400
+check "a deposit into an overdrawn account is not swept below the protected amount" \
+    "s == 201 and $(balance "$ACCOUNT") == 250 and $(collected "$GARNISHMENT") == 800"
+post "$ACCOUNT" deposit 100
+check "only the part above the protected amount is swept, never the overdraft" \
+    "s == 201 and $(balance "$ACCOUNT") == 300 and $(collected "$GARNISHMENT") == 850"
+post "$ACCOUNT" deposit 1000
+check "the last sweep takes only what is still owed" "s == 201 and $(balance "$ACCOUNT") == 950 and $(collected "$GARNISHMENT") == 1200"
+request GET "$V1/garnishments/$GARNISHMENT" "" "${ADMIN[@]}"
+check "an order collected in full is satisfied by the system" \
+    "s == 200 and b['garnishment']['status'] == 'satisfied' and b['garnishment']['satisfied_by'] == 'system' and len(b['garnishment']['payments']) == 4"
+check "each sweep names the deposit that set it off" "[p.get('trigger_transaction_id') is None for p in b['garnishment']['payments']] == [True, False, False, False]"
+post "$ACCOUNT" deposit 100
+check "a satisfied order sweeps nothing more" "s == 201 and $(balance "$ACCOUNT") == 1050"
+check "the remittance account holds every sweep" "$(balance "$REMIT") == 1200"
+
+echo
+echo "Liens"
+SENIOR_ACCOUNT=$(account "$DEBTOR")
+request POST "$V1/accounts/$SENIOR_ACCOUNT/liens" "{\"claimant\": \"First Bank\", \"legal_reference\": \"PLEDGE-1\", \"amount\": 400}" "${ADMIN[@]}"
+check "a senior lien is placed first" "s == 201"
+garnish "{\"account_id\": $SENIOR_ACCOUNT, \"court\": \"County Court\", \"case_reference\": \"GO-2\", \"payee_name\": \"Acme Collections\", \"total_amount\": 2000, \"protected_amount\": 100, \"priority\": 2, \"remittance_account_id\": $COURT}"
+check "an order ranked below it is registered with a remittance account" "s == 201 and b['swept'] == [] and b['garnishment']['remittance_account_id'] == $COURT"
+SENIOR_GARNISHMENT=$(field "['garnishment']['id']")
+post "$SENIOR_ACCOUNT" deposit 1000
+check "the senior lien's amount is left alone" \
+    "s == 201 and $(balance "$SENIOR_ACCOUNT") == 500 and $(collected "$SENIOR_GARNISHMENT") == 500 and $(balance "$COURT") == 500"
+post "$SENIOR_ACCOUNT" withdrawal 101
+check "the lien still holds its amount" "s == 403 and b['code'] == 'LIEN_HOLD'"
+JUNIOR_ACCOUNT=$(account "$DEBTOR")
+garnish "{\"account_id\": $JUNIOR_ACCOUNT, \"court\": \"County Court\", \"case_reference\": \"GO-3\", \"payee_name\": \"Acme Collections\", \"total_amount\": 2000, \"protected_amount\": 100}"
+JUNIOR_GARNISHMENT=$(field "['garnishment']['id']")
+request POST "$V1/accounts/$JUNIOR_ACCOUNT/liens" "{\"claimant\": \"Second Bank\", \"legal_reference\": \"PLEDGE-2\", \"amount\": 400, \"priority\": 1}" "${ADMIN[@]}"
+check "a lien of the same priority placed later ranks behind the order" "s == 201"
+post "$JUNIOR_ACCOUNT" deposit 1000
+check "the junior lien does not shield funds from the order" \
+    "s == 201 and $(balance "$JUNIOR_ACCOUNT") == 100 and $(collected "$JUNIOR_GARNISHMENT") == 900"
+
+echo
+echo "Customer-wide orders"
+WIDE=$(customer Walt)
+FIRST=$(account "$WIDE")
+SECOND=$(account "$WIDE")
+post "$FIRST" deposit 300
+post "$SECOND" deposit 300
+request POST "$V1/garnishments" "{\"customer_id\": $WIDE, \"court\": \"State Court\", \"case_reference\": \"GO-4\", \"payee_name\": \"Tax Office\", \"total_amount\": 1000, \"protected_amount\": 400}" "${ADMIN[@]}"
+check "a customer-wide order covers the customer's accounts in its currency" \
+    "s == 201 and b['garnishment']['scope'] == 'customer' and b['garnishment']['currency'] == 'USD' and 'account_id' not in b['garnishment']"
+check "the protected amount is left across all of them" \
+    "[(p['account_id'], p['amount']) for p in b['swept']] == [($FIRST, 200)] and $(balance "$FIRST") == 100 and $(balance "$SECOND") == 300"
+WIDE_GARNISHMENT=$(field "['garnishment']['id']")
+post "$SECOND" deposit 100
+check "a deposit to any covered account is swept" "s == 201 and $(balance "$SECOND") == 300 and $(collected "$WIDE_GARNISHMENT") == 300"
+request GET "$V1/garnishments?account_id=$SECOND" "" "${TELLER[@]}"
+check "tellers cannot list orders" "s == 403"
+request GET "$V1/garnishments?account_id=$SECOND" "" "${ADMIN[@]}"
+check "orders are listed by the accounts they cover" "s == 200 and [o['id'] for o in b['garnishments']] == [$WIDE_GARNISHMENT]"
+request POST "$V1/accounts/$FIRST/close" "{\"destination\": \"cashier_check\"}" "${ADMIN[@]}"
+check "an account covered by an open order cannot be closed" "s == 409"
+
+echo
+echo "Suspension and satisfaction"
+request POST "$V1/garnishments/$WIDE_GARNISHMENT/suspend" "{}" "${ADMIN[@]}"
+check "a suspension needs a reason" "s == 400"
+request POST "$V1/garnishments/$WIDE_GARNISHMENT/suspend" "{\"reason\": \"Exemption hearing\"}" "${TELLER[@]}"
+check "tellers cannot suspend orders" "s == 403"
+request POST "$V1/garnishments/$WIDE_GARNISHMENT/suspend" "{\"reason\": \"Exemption hearing\"}" "${ADMIN[@]}"
+check "an admin suspends an order" "s == 200 and b['garnishment']['status'] == 'suspended' and b['garnishment']['suspend_reason'] == 'Exemption hearing'"
+request POST "$V1/garnishments/$WIDE_GARNISHMENT/suspend" "{\"reason\": \"Again\"}" "${ADMIN[@]}"
+check "a suspended order cannot be suspended again" "s == 409"
+post "$SECOND" deposit 100
+check "a suspended order sweeps nothing" "s == 201 and $(balance "$SECOND") == 400 and $(collected "$WIDE_GARNISHMENT") == 300"
+request POST "$V1/garnishments/$WIDE_GARNISHMENT/resume" "" "${ADMIN[@]}"
+check "resuming sweeps the balance above the protected amount" \
+    "s == 200 and b['garnishment']['status'] == 'active' and [(p['account_id'], p['amount']) for p in b['swept']] == [($FIRST, 100)]"
+check "it takes from the covered accounts in order" "$(balance "$FIRST") == 0 and $(balance "$SECOND") == 400"
+request POST "$V1/garnishments/$WIDE_GARNISHMENT/resume" "" "${ADMIN[@]}"
+check "an active order cannot be resumed" "s == 409"
+request POST "$V1/garnishments/$WIDE_GARNISHMENT/documents" "{\"document_ids\": [$ORDER]}" "${ADMIN[@]}"
+check "documents must be the order's customer's" "s == 400"
+request POST "$V1/garnishments/$SENIOR_GARNISHMENT/documents" "{\"document_ids\": [$ORDER, $AMENDED]}" "${ADMIN[@]}"
+check "documents are attached after registration" "s == 200 and [d['id'] for d in b['garnishment']['documents']] == [$ORDER, $AMENDED]"
+request POST "$V1/garnishments/$WIDE_GARNISHMENT/satisfy" "{\"note\": \"Paid to the court directly\"}" "${ADMIN[@]}"
+check "an admin satisfies an order early" \
+    "s == 200 and b['garnishment']['status'] == 'satisfied' and b['garnishment']['satisfied_by'] == 'garnishments-admin-$RUN_ID' and b['garnishment']['satisfy_note'] == 'Paid to the court directly'"
+request POST "$V1/garnishments/$WIDE_GARNISHMENT/satisfy" "" "${ADMIN[@]}"
+check "a satisfied order cannot be satisfied again" "s == 409"
+post "$SECOND" deposit 100
+check "deposits post in full once it is satisfied" "s == 201 and $(balance "$SECOND") == 500"
+request GET "$V1/garnishments?customer_id=$WIDE&status=satisfied" "" "${ADMIN[@]}"
+check "satisfied orders are kept" "s == 200 and b['total'] == 1"
+request GET "$V1/garnishments/999999999" "" "${ADMIN[@]}"
+check "an unknown order is not found" "s == 404"
+check "every change is on the outbox" \
+    "'$(sql "SELECT group_concat(event_type) FROM (SELECT event_type FROM outbox_events WHERE aggregate_type = 'customer' AND aggregate_id = ? AND event_type LIKE 'account.garnishment_%' ORDER BY id)" "$WIDE")'.split(',') == ['account.garnishment_registered', 'account.garnishment_suspended', 'account.garnishment_resumed', 'account.garnishment_satisfied']"
+
+finish "garnishment"
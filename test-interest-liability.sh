@@ -0,0 +1,149 @@
+#!/bin/bash
+
+# Interest Liability Tests
+# Runs a sandbox server (built with -tags sandbox) from 1 January 2026 and advances its clock, so the end-of-day run
+# accrues savings and checking interest day by day through a full month and into the next. Checks that each day's
+# interest is booked against interest expense and owed on the accrued interest account, that the interest liability
+# report shows what is owed by product as of any day and ties to the interest the accounts carry, that the month-end
+# credits draw the liability down by exactly what they post, to the cent, so expense is never counted twice, and
+# that interest accrued before daily booking is expensed as it is credited. The server and its database are the
+# script's own, so no other server is needed. Exits non-zero on failure.
+#
+# Usage: ./test-interest-liability.sh                    (builds the sandbox server with go build -tags sandbox)
+#        SANDBOX_BIN=./banking-sandbox BANKCTL=./bankctl PORT=18098 ./test-interest-liability.sh
+
+source "$(dirname "$0")/test-lib.sh"
+serve 18098
+PASSWORD="liability-test-$RUN_ID-Aa1!"
+
+echo " Interest Liability Tests"
+echo "========================="
+
+# advance DAYS - moves the sandbox clock on by DAYS days, running every end of day in between
+advance() {
+    request POST "$V1/sandbox/advance-time" "{\"days\": $1}"
+    check "the clock moves on $1 days and every run succeeds" "s == 200 and b['failed'] == []"
+}
+
+# liability [QUERY] - fetches the interest liability report
+liability() {
+    request GET "$V1/reports/interest-liability$1" "" "${AUTH[@]}"
+}
+
+# customer NAME TYPE AMOUNT - opens an account of a type for a new customer, funds it and prints its id
+customer() {
+    request POST "$V1/customers" "{\"first_name\": \"$1\", \"last_name\": \"Saver\", \"email\": \"liability-$1-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+    request POST "$V1/accounts" "{\"customer_id\": $(field "['customer']['id']"), \"account_type\": \"$2\"}" "${AUTH[@]}"
+    local id
+    id=$(field "['account']['id']")
+    if [ "$3" != 0 ]; then
+        request POST "$V1/transactions" "{\"account_id\": $id, \"transaction_type\": \"deposit\", \"amount\": $3}" "${AUTH[@]}"
+    fi
+    echo "$id"
+}
+
+# owed ACCOUNT - prints the net of the accrued interest entries attributed to an account
+owed() {
+    sql "SELECT ROUND(COALESCE(SUM(CASE WHEN t.transaction_type = 'deposit' THEN t.amount ELSE -t.amount END), 0), 2)
+         FROM transactions t JOIN accounts a ON a.id = t.account_id AND a.account_number LIKE 'GL-%-ACCRUED_INTEREST-USD'
+         LEFT JOIN transactions o ON o.id = t.offset_of_id WHERE COALESCE(o.account_id, t.counterparty_account_id) = $1"
+}
+
+build sandbox
+# The sandbox keeps its data in DB_PATH, the sandbox database
+start_server "$SANDBOX_BIN" DB_PATH="$WORK/unused.db" SANDBOX_MODE=true SANDBOX_DB_PATH="$DB_PATH" SANDBOX_START=2026-01-01
+
+echo "Setup"
+AUTH=(-H "Authorization: Bearer $(staff "liability-admin")")
+for product in "savings|Easy Saver|0.041" "checking|Everyday|0.01"; do
+    IFS='|' read -r TYPE NAME RATE <<< "$product"
+    request POST "$V1/admin/products" "{\"account_type\": \"$TYPE\", \"name\": \"$NAME\"}" "${AUTH[@]}"
+    request POST "$V1/admin/products/$(field "['product']['id']")/rates" "{\"rate\": $RATE, \"effective_date\": \"2026-01-02\"}" "${AUTH[@]}"
+    check "$TYPE earns $RATE from 2 January" "s == 201"
+done
+# 12,345.67 at 4.1% earns 1.39 a day, and 1.50 once 1,000 more is paid in on 16 January; 2,500 at 1% earns 0.07
+SAVINGS=$(customer Ada savings 12345.67)
+CHECKING=$(customer Grace checking 2500)
+EMPTY=$(customer Alan savings 0)
+# Interest this account accrued before it was booked daily, which no entry on the books owes
+LEGACY=$(customer Edsger savings 0)
+sql "UPDATE accounts SET accrued_interest = 5 WHERE id = $LEGACY" > /dev/null
+
+echo
+echo "Accrual"
+liability
+check "nothing is owed before any interest accrues" "s == 200 and b['lines'] == [] and b['total'] == 0 and b['currency'] == 'USD'"
+request POST "$V1/sandbox/advance-time" "{\"days\": 15, \"duration\": \"1h\"}"
+check "the clock moves on to 16 January and every run succeeds" "s == 200 and b['failed'] == []"
+check "each day's interest is booked on accrued interest" \
+    "$(sql "SELECT COUNT(*) FROM transactions WHERE counterparty_account_id = $SAVINGS AND reference LIKE 'ACR-%' AND transaction_type = 'deposit'") == 14"
+check "against interest expense, in the same amount" \
+    "$(sql "SELECT COUNT(*) FROM transactions WHERE counterparty_account_id = $SAVINGS AND reference LIKE 'ACR-%' AND transaction_type = 'withdrawal' AND amount = 1.39") == 14"
+check "an account earning nothing books nothing" "$(sql "SELECT COUNT(*) FROM transactions WHERE counterparty_account_id = $EMPTY") == 0"
+liability
+check "the report owes each product what it accrued through yesterday" \
+    "s == 200 and [(l['product'], l['name'], l['accrued'], l['accounts']) for l in b['lines']] == [('checking', 'Everyday', 0.98, 1), ('savings', 'Easy Saver', 19.46, 1)] and b['total'] == 20.44"
+check "and ties to the interest the accounts carry" \
+    "b['total'] == round($(sql "SELECT SUM(accrued_interest) FROM accounts WHERE id IN ($SAVINGS, $CHECKING)"), 2)"
+check "an account is owed what it carries" "$(owed "$SAVINGS") == $(sql "SELECT accrued_interest FROM accounts WHERE id = $SAVINGS")"
+liability "?as_of=2026-01-05"
+check "earlier days show what was owed then" "b['total'] == 5.84 and b['as_of'][:10] == '2026-01-05'"
+request GET "$V1/reports/income?from=2026-01-01&to=2026-01-15" "" "${AUTH[@]}"
+check "the expense is recognized as it accrues, by product" \
+    "sorted((l['product'], l['amount']) for l in b['lines'] if l['code'] == 'INTEREST_EXPENSE') == [('checking', 0.98), ('savings', 19.46)]"
+request GET "$V1/reports/trial-balance" "" "${AUTH[@]}"
+check "the books balance, with the liability on accrued interest" \
+    "all(t['balanced'] for t in b['trial_balances']) and [l['credit'] for t in b['trial_balances'] for l in t['lines'] if l.get('code') == 'ACCRUED_INTEREST'] == [20.44]"
+request POST "$V1/transactions" "{\"account_id\": $SAVINGS, \"transaction_type\": \"deposit\", \"amount\": 1000}" "${AUTH[@]}"
+check "more is paid into the savings account" "s == 201"
+
+echo
+echo "Month end"
+advance 16
+POSTED=$(sql "SELECT amount FROM transactions WHERE account_id = $SAVINGS AND transaction_type = 'interest'")
+check "January's savings interest is credited" "$POSTED == 43.46"
+check "January's checking interest is credited" "$(sql "SELECT amount FROM transactions WHERE account_id = $CHECKING AND transaction_type = 'interest'") == 2.10"
+check "the liability drawn down equals the interest accrued in January, to the cent" \
+    "round($(sql "SELECT SUM(amount) FROM transactions WHERE counterparty_account_id = $SAVINGS AND reference LIKE 'ACR-%-202601%' AND transaction_type = 'deposit'"), 2) == $POSTED"
+check "the credit settles exactly what it posts" \
+    "$(sql "SELECT SUM(o.amount) FROM transactions o JOIN transactions i ON i.id = o.offset_of_id JOIN accounts a ON a.id = o.account_id WHERE i.account_id = $SAVINGS AND i.transaction_type = 'interest' AND a.account_number LIKE '%-ACCRUED_INTEREST-%'") == $POSTED"
+check "and nothing of it is expensed again" \
+    "$(sql "SELECT COUNT(*) FROM transactions o JOIN transactions i ON i.id = o.offset_of_id JOIN accounts a ON a.id = o.account_id WHERE i.account_id = $SAVINGS AND a.account_number LIKE '%-INTEREST_EXPENSE-%'") == 0"
+check "nothing is owed to the accounts after the credit" "$(owed "$SAVINGS") == 0 and $(owed "$CHECKING") == 0"
+check "interest accrued before daily booking is credited" "$(sql "SELECT amount FROM transactions WHERE account_id = $LEGACY AND transaction_type = 'interest'") == 5"
+check "and expensed as it is, leaving the liability alone" \
+    "$(sql "SELECT COUNT(*) FROM transactions o JOIN transactions i ON i.id = o.offset_of_id JOIN accounts a ON a.id = o.account_id WHERE i.account_id = $LEGACY AND a.account_number LIKE '%-INTEREST_EXPENSE-%' AND o.amount = 5") == 1 and $(owed "$LEGACY") == 0"
+liability "?as_of=2026-01-31"
+check "nothing is owed at the end of January" "s == 200 and b['lines'] == [] and b['total'] == 0"
+liability "?as_of=2026-01-30"
+check "the day before, the month's interest was owed but for its last day" \
+    "[(l['product'], l['accrued']) for l in b['lines']] == [('checking', 2.03), ('savings', 41.96)]"
+liability "?as_of=2026-01-15"
+check "past days are reported as they stood" "b['total'] == 20.44"
+request GET "$V1/reports/income?from=2026-01-01&to=2026-01-31" "" "${AUTH[@]}"
+check "January's interest expense is what was credited" \
+    "sorted((l['product'], l['amount']) for l in b['lines'] if l['code'] == 'INTEREST_EXPENSE') == [('checking', 2.10), ('savings', 48.46)]"
+request GET "$V1/reports/trial-balance" "" "${AUTH[@]}"
+check "the books still balance" "all(t['balanced'] for t in b['trial_balances'])"
+
+echo
+echo "Next month"
+advance 9
+liability
+check "February accrues on the balance with January's interest" \
+    "[(l['product'], l['accrued'], l['accounts']) for l in b['lines']] == [('checking', 0.63, 1), ('savings', 13.50, 1)] and b['total'] == 14.13"
+check "and ties to the interest the accounts carry" \
+    "b['total'] == round($(sql "SELECT SUM(accrued_interest) FROM accounts WHERE id IN ($SAVINGS, $CHECKING, $EMPTY, $LEGACY)"), 2)"
+
+echo
+echo "Validation"
+liability "?as_of=31-01-2026"
+check "an invalid date is refused" "s == 400"
+liability "?currency=EUR"
+check "a currency without accrued interest owes nothing" "s == 200 and b['currency'] == 'EUR' and b['total'] == 0"
+request GET "$V1/reports/interest-liability"
+check "the report needs a login" "s == 401"
+
+stop_server
+
+finish "interest liability"
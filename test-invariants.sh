@@ -0,0 +1,95 @@
+#!/bin/bash
+
+# Balance Invariant Tests
+# Checks that postings still move balances once the balance column is written only by the ledger's guarded write,
+# that a credit may raise a balance already below its floor, and that the invariants job finds balances below
+# their floor and postings whose balance_before is not the previous balance_after, lists them beside the exception
+# queue without repeating them, lets staff resolve them, and resolves them itself once they are gone. The broken
+# books are made in the server's database, so DB_PATH must be the database the server uses; the platform admin is
+# created with bankctl. Each run creates its own tenant so other runs' violations never show. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-invariants.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-invariants.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="invariant-test-$RUN_ID-Aa1!"
+PLATFORM_USER="invariant-platform-$RUN_ID"
+TENANT_CODE="inv$RUN_ID"
+
+echo " Balance Invariant Tests"
+echo "========================"
+
+echo "Setup"
+tenant "$PLATFORM_USER" "Invariants" invariant-admin
+request POST "$V1/customers" "{\"first_name\": \"Ivy\", \"last_name\": \"Invariant\", \"email\": \"invariant-$RUN_ID@example.com\"}" "${AUTH[@]}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+CHECKING=$(field "['account']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"savings\"}" "${AUTH[@]}"
+SAVINGS=$(field "['account']['id']")
+
+echo
+echo "Postings"
+request POST "$V1/transactions" "{\"account_id\": $CHECKING, \"transaction_type\": \"deposit\", \"amount\": 100}" "${AUTH[@]}"
+check "a deposit posts" "s == 201 and b['transaction']['balance_after'] == 100"
+request POST "$V1/transactions" "{\"account_id\": $CHECKING, \"transaction_type\": \"withdrawal\", \"amount\": 30}" "${AUTH[@]}"
+check "a withdrawal posts from where the deposit left off" "s == 201 and b['transaction']['balance_before'] == 100 and b['transaction']['balance_after'] == 70"
+request POST "$V1/transfers" "{\"from_account_id\": $CHECKING, \"to_account_id\": $SAVINGS, \"amount\": 20}" "${AUTH[@]}"
+check "a transfer posts" "s == 201"
+request GET "$V1/accounts/$CHECKING" "" "${AUTH[@]}"
+check "the stored balance follows the postings" "s == 200 and b['balance'] == 50"
+request POST "$V1/transactions" "{\"account_id\": $CHECKING, \"transaction_type\": \"withdrawal\", \"amount\": 80}" "${AUTH[@]}"
+check "a debit past the balance is still refused by the funds check" "s == 400 and b['error'] == 'Insufficient balance or invalid account status'"
+
+echo
+echo "Scan"
+# The savings account is pushed below zero behind the ledger's back, and a checking posting is made to start
+# from the wrong balance
+sql "UPDATE accounts SET balance = -40 WHERE id = $SAVINGS" > /dev/null
+BROKEN=$(sql "SELECT id FROM transactions WHERE account_id = $CHECKING ORDER BY id LIMIT 1 OFFSET 1")
+sql "UPDATE transactions SET balance_before = 95 WHERE id = $BROKEN" > /dev/null
+run_job invariants
+[ -z "$JOB_ERROR" ] && echo "  ✓ the invariants job runs" || { echo "  ✗ the invariants job failed: $JOB_ERROR"; FAILURES=$((FAILURES + 1)); }
+request GET "$V1/operations/invariants" "" "${AUTH[@]}"
+check "both violations are listed" "s == 200 and b['open'] == 2 and b['total'] == 2"
+check "the negative balance names its account and floor" \
+    "any(v['kind'] == 'negative_balance' and v['account_id'] == $SAVINGS and v['expected'] == 0 and v['actual'] == -40 for v in b['violations'])"
+check "the broken chain names its posting and the balance it should start from" \
+    "any(v['kind'] == 'balance_chain' and v['transaction_id'] == $BROKEN and v['expected'] == 100 and v['actual'] == 95 for v in b['violations'])"
+request GET "$V1/operations/exceptions" "" "${AUTH[@]}"
+check "nothing is put in suspense" "s == 200 and b['total'] == 0"
+run_job invariants
+request GET "$V1/operations/invariants" "" "${AUTH[@]}"
+check "a second scan does not repeat them" "b['total'] == 2"
+request GET "$V1/operations/invariants?kind=balance_chain" "" "${AUTH[@]}"
+check "violations can be listed by kind" "b['total'] == 1 and b['violations'][0]['kind'] == 'balance_chain'"
+CHAIN=$(field "['violations'][0]['id']")
+
+echo
+echo "Guard"
+request POST "$V1/transactions" "{\"account_id\": $SAVINGS, \"transaction_type\": \"deposit\", \"amount\": 15}" "${AUTH[@]}"
+check "a credit may raise a balance below its floor" "s == 201 and b['transaction']['balance_after'] == -25"
+request POST "$V1/transactions" "{\"account_id\": $SAVINGS, \"transaction_type\": \"withdrawal\", \"amount\": 5}" "${AUTH[@]}"
+check "but nothing may lower it" "s == 400 and b['error'] == 'Insufficient balance or invalid account status'"
+
+echo
+echo "Resolution"
+request POST "$V1/operations/invariants/$CHAIN/resolve" "{}" "${AUTH[@]}"
+check "a resolution needs a note" "s == 400"
+request POST "$V1/operations/invariants/$CHAIN/resolve" "{\"note\": \"Posting corrected by hand during migration\"}" "${AUTH[@]}"
+check "staff resolve a violation" "s == 200 and b['violation']['status'] == 'resolved' and b['violation']['resolved_by'] == 'invariant-admin'"
+request POST "$V1/operations/invariants/$CHAIN/resolve" "{\"note\": \"again\"}" "${AUTH[@]}"
+check "a resolved violation cannot be resolved again" "s == 409"
+sql "UPDATE accounts SET balance = 0 WHERE id = $SAVINGS" > /dev/null
+run_job invariants
+request GET "$V1/operations/invariants?status=all" "" "${AUTH[@]}"
+check "the scan resolves a violation it no longer finds" \
+    "any(v['kind'] == 'negative_balance' and v['status'] == 'resolved' and v['resolved_by'] == 'system' for v in b['violations'])"
+check "and reopens one staff cleared that is still there" \
+    "len([v for v in b['violations'] if v.get('transaction_id') == $BROKEN and v['status'] == 'open']) == 1"
+check "the credit after the tampered balance is reported too, as it starts where the ledger did not leave off" \
+    "any(v['kind'] == 'balance_chain' and v['account_id'] == $SAVINGS and v['expected'] == 20 and v['actual'] == -40 for v in b['violations'])"
+request GET "$V1/operations/invariants" ""
+check "the queue needs staff credentials" "s == 401"
+
+finish "invariant"
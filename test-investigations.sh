@@ -0,0 +1,157 @@
+#!/bin/bash
+
+# Money Flow Investigation Tests
+# Seeds a chain of transfers across five accounts, A -> B -> C -> D -> E and back to B, plus an earlier B -> E that
+# the chain's money cannot have taken. Checks that the flow endpoint follows the chain hop by hop up to the requested
+# depth, stops at the cycle, only follows transfers made after the money arrived, walks backward to the source, caps
+# the graph at max_nodes and hides - without walking through - accounts held by bank staff from tellers. Also checks
+# the DOT export, ETag revalidation and that only staff may trace. An admin and a teller are created with bankctl
+# against the server's database, so DB_PATH must be the database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-investigations.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-investigations.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="flow-test-$RUN_ID"
+
+echo " Money Flow Investigation Tests"
+echo "==============================="
+
+# customer NAME - creates a customer and prints its id
+customer() {
+    request POST "$V1/customers" "{\"first_name\": \"$1\", \"last_name\": \"Flow\", \"email\": \"flow-$1-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}"
+    field "['customer']['id']"
+}
+
+# account CUSTOMER - opens a checking account with 1000 in it and prints its id
+# Account numbers opened in the same second can collide, so a refused open is retried
+account() {
+    for _ in 1 2 3; do
+        request POST "$V1/accounts" "{\"customer_id\": $1, \"account_type\": \"checking\"}"
+        [ "$STATUS" = 201 ] && break
+        sleep 1
+    done
+    local id
+    id=$(field "['account']['id']")
+    request POST "$V1/transactions" "{\"account_id\": $id, \"transaction_type\": \"deposit\", \"amount\": 1000}"
+    echo "$id"
+}
+
+# transfer FROM TO AMOUNT - moves money and prints the transfer's debit transaction id
+transfer() {
+    request POST "$V1/transfers" "{\"from_account_id\": $1, \"to_account_id\": $2, \"amount\": $3}"
+    [ "$STATUS" = 201 ] || { echo "transfer $1 -> $2 failed: $BODY" >&2; exit 1; }
+    field "['transfer']['debit_transaction_id']"
+}
+
+# flow AUTH_ARRAY_NAME QUERY [CURL_ARGS...] - traces a flow as a caller
+flow() {
+    local -n auth=$1
+    local query=$2
+    shift 2
+    request GET "$V1/investigations/flow?$query" "" "${auth[@]}" "$@"
+}
+
+# Python expressions over a flow body: the account and transfer sets it holds
+NODES="sorted(n['account_id'] for n in b['flow']['nodes'])"
+EDGES="[(e['from_account_id'], e['to_account_id']) for e in b['flow']['edges']]"
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "flow-admin-$RUN_ID" admin)")
+TELLER=(-H "Authorization: Bearer $(staff "flow-teller-$RUN_ID" teller)")
+NOBODY=()
+for name in A B C D E; do
+    declare "$name=$(account "$(customer "$name")")"
+done
+EARLY=$(transfer "$B" "$E" 5)
+sleep 1
+START=$(transfer "$A" "$B" 100)
+sleep 1
+transfer "$B" "$C" 90 > /dev/null
+sleep 1
+transfer "$C" "$D" 80 > /dev/null
+sleep 1
+LATE=$(transfer "$D" "$E" 70)
+sleep 1
+LOOP=$(transfer "$E" "$B" 60)
+check "five accounts and six transfers are seeded" "'$A$B$C$D$E$START$LOOP'.isdigit()"
+
+echo
+echo "Access and validation"
+flow NOBODY "transaction_id=$START"
+check "tracing needs a login" "s == 401"
+CUSTOMER_USER="flow-customer-$RUN_ID"
+staff "$CUSTOMER_USER" customer > "$WORK/customer.token"
+CUSTOMER=(-H "Authorization: Bearer $(cat "$WORK/customer.token")")
+flow CUSTOMER "transaction_id=$START"
+check "customers cannot trace" "s == 403"
+flow ADMIN ""
+check "transaction_id is required" "s == 400 and b['code'] == 'INVALID_FLOW_QUERY'"
+flow ADMIN "transaction_id=$START&depth=7"
+check "depth is capped" "s == 400 and b['code'] == 'INVALID_FLOW_QUERY'"
+flow ADMIN "transaction_id=$START&direction=sideways"
+check "direction is forward or backward" "s == 400"
+flow ADMIN "transaction_id=$START&format=png"
+check "format is json or dot" "s == 400"
+flow ADMIN "transaction_id=999999999"
+check "an unknown transaction is not found" "s == 404"
+
+echo
+echo "Forward"
+flow ADMIN "transaction_id=$START"
+check "depth defaults to three hops from the starting transfer" \
+    "s == 200 and b['flow']['depth'] == 3 and b['flow']['root_account_id'] == $A and $EDGES == [($A, $B), ($B, $C), ($C, $D)]"
+check "nodes carry their hop and masked account numbers" \
+    "{n['account_id']: n['depth'] for n in b['flow']['nodes']} == {$A: 0, $B: 1, $C: 2, $D: 3} and all(n['account_number'].startswith('••••') for n in b['flow']['nodes'])"
+check "edges carry amounts, timestamps and both legs" \
+    "[e['amount'] for e in b['flow']['edges']] == [100, 90, 80] and all(e['created_at'] and e['debit_transaction_id'] and e['credit_transaction_id'] for e in b['flow']['edges'])"
+check "the earlier B -> E transfer is not followed" "($B, $E) not in $EDGES"
+flow ADMIN "transaction_id=$START&depth=6"
+check "the cycle back to B ends the walk" \
+    "s == 200 and $EDGES == [($A, $B), ($B, $C), ($C, $D), ($D, $E), ($E, $B)] and $NODES == sorted([$A, $B, $C, $D, $E]) and not b['flow']['truncated']"
+flow ADMIN "transaction_id=$(sql "SELECT transaction_id FROM transactions WHERE id = ?" "$START")&depth=1"
+check "the transaction can be named by its reference" "s == 200 and $EDGES == [($A, $B)]"
+flow ADMIN "transaction_id=$START&depth=6&max_nodes=3"
+check "max_nodes bounds the graph" "s == 200 and len(b['flow']['nodes']) == 3 and b['flow']['truncated']"
+
+echo
+echo "Backward"
+flow ADMIN "transaction_id=$LATE&direction=backward&depth=2"
+check "walking back from D -> E follows what reached D, not E's other credits" \
+    "s == 200 and b['flow']['root_account_id'] == $E and $EDGES == [($C, $D), ($D, $E)]"
+flow ADMIN "transaction_id=$LATE&direction=backward&depth=6"
+check "it ends at the source" "s == 200 and $EDGES == [($A, $B), ($B, $C), ($C, $D), ($D, $E)]"
+flow ADMIN "transaction_id=$EARLY&direction=backward"
+check "nothing reached B before the early transfer" "s == 200 and $EDGES == [($B, $E)]"
+
+echo
+echo "Visibility"
+STAFF_CUSTOMER=$(sql "SELECT customer_id FROM accounts WHERE id = ?" "$C")
+sql "UPDATE users SET customer_id = ? WHERE username = ?" "$STAFF_CUSTOMER" "flow-teller-$RUN_ID"
+flow TELLER "transaction_id=$START&depth=6"
+check "a staff member's account is hidden from tellers and not walked through" \
+    "s == 200 and $NODES == sorted([$A, $B]) and $EDGES == [($A, $B)] and b['flow']['hidden_nodes'] == 1"
+flow ADMIN "transaction_id=$START&depth=6&max_nodes=99"
+check "admins see staff accounts" "s == 200 and $C in $NODES and b['flow']['hidden_nodes'] == 0"
+
+echo
+echo "Caching"
+flow ADMIN "transaction_id=$START" -D "$WORK/headers"
+ETAG=$(grep -i '^etag:' "$WORK/headers" | cut -d' ' -f2- | tr -d '\r')
+check "responses carry a validator" "'$ETAG'.startswith('W/')"
+flow ADMIN "transaction_id=$START" -H "If-None-Match: $ETAG"
+check "an unchanged flow revalidates with 304" "s == 304"
+flow TELLER "transaction_id=$START" -H "If-None-Match: $ETAG"
+check "callers who see other accounts do not share it" "s == 200"
+
+echo
+echo "DOT export"
+flow ADMIN "transaction_id=$START&depth=6&format=dot" -D "$WORK/headers"
+BODY=$(python3 -c "import json, sys; print(json.dumps(sys.stdin.read()))" <<< "$BODY")
+check "the graph is served as GraphViz" "s == 200 and b.startswith('digraph flow {') and b.rstrip().endswith('}')"
+check "it has a node per account and an edge per transfer" \
+    "all('a%d [label=' % n in b for n in [$A, $B, $C, $D, $E]) and b.count(' -> ') == 5 and 'a$E -> a$B' in b"
+check "labels show masked numbers and amounts" "'••••' in b and '100.00 USD' in b"
+check "the content type is text/vnd.graphviz" "$(grep -ci '^content-type: text/vnd.graphviz' "$WORK/headers") == 1"
+
+finish "money flow"
@@ -0,0 +1,224 @@
+#!/bin/bash
+
+# Shared helpers for the test-*.sh suites, which source it before their checks:
+#
+#   source "$(dirname "$0")/test-lib.sh"
+#
+# Suites run against the server at BASE_URL using the database at DB_PATH, creating their users with BANKCTL, unless
+# they start their own with serve. RUN_ID keeps the names a run creates unique, FAILURES counts the failed checks and
+# WORK is a scratch directory removed on exit. Helpers that log in use the suite's PASSWORD.
+
+BASE_URL="${BASE_URL:-http://localhost:8080}"
+V1="$BASE_URL/api/v1"
+BANKCTL="${BANKCTL:-go run ./cmd/bankctl}"
+DB_PATH="${DB_PATH:-banking.db}"
+RUN_ID="$(date +%s)$$"
+FAILURES=0
+WORK=$(mktemp -d)
+SERVING=
+BACKGROUND=()
+AT_EXIT=()
+
+cleanup() {
+    local command
+    for command in "${AT_EXIT[@]}"; do
+        eval "$command"
+    done
+    [ -n "$SERVING" ] && [ -n "$SERVER_PID" ] && kill "$SERVER_PID" 2>/dev/null
+    [ ${#BACKGROUND[@]} -gt 0 ] && kill "${BACKGROUND[@]}" 2>/dev/null
+    rm -rf "$WORK"
+}
+trap cleanup EXIT
+
+# on_exit COMMAND - runs a command when the suite exits, e.g. to restore a setting it changed
+on_exit() {
+    AT_EXIT+=("$1")
+}
+
+# background PID - stops a process the suite started, such as a webhook receiver, when it exits
+background() {
+    BACKGROUND+=("$1")
+}
+
+# request METHOD URL [BODY] [CURL_ARGS...] - stores the status in STATUS, the body in BODY, the response headers in
+# HEADERS and the seconds taken in ELAPSED. The body is sent as JSON, or as a form when FORM is set and it is not an
+# object
+request() {
+    local method=$1 url=$2 body=$3
+    shift 3 2>/dev/null || shift $#
+    local type=application/json
+    if [ -n "$FORM" ] && [ "${body:0:1}" != "{" ]; then
+        type=application/x-www-form-urlencoded
+    fi
+    local out
+    out=$(mktemp)
+    read -r STATUS ELAPSED < <(curl -s -o "$out" -D "$out.headers" -w '%{http_code} %{time_total}' -X "$method" "$url" \
+        -H "Content-Type: $type" ${body:+-d "$body"} "$@")
+    BODY=$(cat "$out")
+    HEADERS=$(tr -d '\r' < "$out.headers" 2>/dev/null)
+    rm -f "$out" "$out.headers"
+}
+
+# check NAME PYTHON_EXPRESSION - evaluates the expression with the parsed body as `b` (None when it is not JSON), its
+# lines parsed as `rows`, the status as `s`, the response headers as `h`, keyed in lower case, and the seconds taken as
+# `t`. Python in CHECK_HELPERS runs first, defining names a suite's checks share
+check() {
+    if python3 -c "
+import json, os, sys
+try:
+    b = json.loads(sys.argv[1]) if sys.argv[1] else None
+except ValueError:
+    b = None
+rows = []
+for line in sys.argv[1].splitlines():
+    try:
+        rows.append(json.loads(line))
+    except ValueError:
+        pass
+s = int(sys.argv[2])
+t = float(sys.argv[3] or 0)
+h = {k.strip().lower(): v.strip() for k, _, v in (line.partition(':') for line in sys.argv[4].splitlines()) if v}
+$CHECK_HELPERS
+sys.exit(0 if ($2) else 1)
+" "$BODY" "${STATUS:-0}" "$ELAPSED" "$HEADERS" 2>/dev/null; then
+        echo "  ✓ $1"
+    else
+        echo "  ✗ $1 (status $STATUS): ${BODY:0:300}"
+        FAILURES=$((FAILURES + 1))
+    fi
+}
+
+# assert NAME SHELL_CONDITION - checks a shell condition, for suites that measure rather than read responses
+assert() {
+    if eval "$2"; then
+        echo "  ✓ $1"
+    else
+        echo "  ✗ $1"
+        FAILURES=$((FAILURES + 1))
+    fi
+}
+
+# field PYTHON_PATH - prints a value from the last body, e.g. field "['account']['id']"
+field() {
+    python3 -c "import json, sys; print(json.loads(sys.argv[1])$1)" "$BODY"
+}
+
+# sql QUERY [PARAMS...] - runs a statement against the database at DB_PATH and prints the first column of each row
+sql() {
+    python3 -c "
+import sqlite3, sys
+db = sqlite3.connect(sys.argv[1], timeout=10)
+rows = db.execute(sys.argv[2], sys.argv[3:]).fetchall()
+db.commit()
+for row in rows:
+    print(row[0])
+" "$DB_PATH" "$@"
+}
+
+# token USERNAME [CURL_ARGS...] - logs in with PASSWORD and prints the token
+token() {
+    request POST "$V1/auth/login" "{\"username\": \"$1\", \"password\": \"$PASSWORD\"}" "${@:2}"
+    field "['token']"
+}
+
+# staff USERNAME [ROLE] - creates an admin with bankctl, gives them ROLE instead when it is set, and prints their token
+staff() {
+    BANKCTL_PASSWORD="$PASSWORD" $BANKCTL -db "$DB_PATH" create-admin -username "$1" > /dev/null || exit 1
+    if [ -n "$2" ] && [ "$2" != admin ]; then
+        sql "UPDATE users SET role = ? WHERE username = ?" "$2" "$1" > /dev/null
+    fi
+    token "$1"
+}
+
+# customer_user USERNAME CUSTOMER_ID [TENANT_CODE] - creates a customer's login with bankctl, in a tenant when one is
+# given, and prints its token
+customer_user() {
+    BANKCTL_PASSWORD="$PASSWORD" $BANKCTL -db "$DB_PATH" create-customer-user ${3:+-tenant "$3"} -username "$1" -customer-id "$2" > /dev/null || exit 1
+    token "$1" ${3:+-H "X-Tenant: $3"}
+}
+
+# tenant PLATFORM_USER NAME ADMIN_USER - creates a platform admin and a tenant coded TENANT_CODE with its own admin,
+# storing the tenant's id in TENANT and the platform and tenant admins' headers in PLATFORM and AUTH
+tenant() {
+    PLATFORM=(-H "Authorization: Bearer $(staff "$1")")
+    request POST "$V1/admin/tenants" "{\"code\": \"$TENANT_CODE\", \"name\": \"$2 $RUN_ID\", \"admin\": {\"username\": \"$3\", \"password\": \"$PASSWORD\"}}" "${PLATFORM[@]}"
+    check "a tenant is created for the run" "s == 201"
+    TENANT=$(field "['tenant']['id']")
+    AUTH=(-H "Authorization: Bearer $(token "$3" -H "X-Tenant: $TENANT_CODE")")
+}
+
+# run_job NAME [CURL_ARGS...] - runs a job once as PLATFORM, or with the given headers, and waits for it to finish,
+# storing the run's id in JOB_RUN and its error, empty when it succeeded, in JOB_ERROR. The run is polled through the
+# API rather than the database, so the poll never holds a lock the job needs
+run_job() {
+    local job=$1
+    shift
+    [ $# -gt 0 ] || set -- "${PLATFORM[@]}"
+    request POST "$V1/admin/jobs/$job/run" "" "$@"
+    JOB_RUN=$(field "['run']['id']" 2>/dev/null)
+    for _ in $(seq 1 50); do
+        sleep 0.1
+        request GET "$V1/admin/jobs/runs?job=$job&limit=5" "" "$@"
+        JOB_ERROR=$(python3 -c "
+import json, sys
+runs = [r for r in json.loads(sys.argv[1])['runs'] if str(r['id']) == sys.argv[2]] or [{'status': 'running'}]
+print('running' if runs[0]['status'] == 'running' else runs[0].get('error') or '')" "$BODY" "$JOB_RUN" 2>/dev/null || echo running)
+        [ "$JOB_ERROR" != running ] && break
+    done
+}
+
+# serve PORT - points the suite at its own server on PORT, or the PORT setting, using a database in WORK; the server
+# is stopped on exit
+serve() {
+    SERVING=1
+    SERVER_PID=
+    PORT="${PORT:-$1}"
+    BASE_URL="http://localhost:$PORT"
+    V1="$BASE_URL/api/v1"
+    DB_PATH="$WORK/banking.db"
+}
+
+# build [sandbox] - builds the server, or the sandbox server, into WORK as SERVER_BIN or SANDBOX_BIN unless that is
+# already set
+build() {
+    if [ "$1" = sandbox ]; then
+        [ -n "$SANDBOX_BIN" ] && return
+        SANDBOX_BIN="$WORK/banking-sandbox"
+        go build -tags sandbox -o "$SANDBOX_BIN" . || exit 1
+        return
+    fi
+    [ -n "$SERVER_BIN" ] && return
+    SERVER_BIN="$WORK/banking-app"
+    go build -o "$SERVER_BIN" . || exit 1
+}
+
+# start_server BINARY [ENV...] - starts a server on PORT using DB_PATH, with the given settings, and waits until it
+# answers
+start_server() {
+    local binary=$1
+    shift
+    env DB_PATH="$DB_PATH" PORT="$PORT" "$@" "$binary" >> "$WORK/server.log" 2>&1 &
+    SERVER_PID=$!
+    for _ in $(seq 1 50); do
+        curl -s -o /dev/null "$BASE_URL/health" && return
+        sleep 0.2
+    done
+    echo "server did not start:"; cat "$WORK/server.log"; exit 1
+}
+
+# stop_server - stops the server and waits for it to exit
+stop_server() {
+    kill "$SERVER_PID" 2>/dev/null
+    wait "$SERVER_PID" 2>/dev/null
+    SERVER_PID=
+}
+
+# finish SUITE - reports the failed checks and exits non-zero if there were any
+finish() {
+    echo
+    if [ "$FAILURES" -gt 0 ]; then
+        echo "❌ $FAILURES $1 check(s) failed"
+        exit 1
+    fi
+    echo "✅ All $1 checks passed"
+}
@@ -0,0 +1,191 @@
+#!/bin/bash
+
+# Account Lien Tests
+# Checks that only admins place, change, satisfy and release liens, that a lien needs a claimant, a legal reference
+# and either an amount or all funds, and that its documents must be the account owner's. Withdrawals, transfers and
+# v2 postings that would take the balance below the amount held are refused with LIEN_HOLD, while deposits and bank
+# charges still post. Satisfying liens pays them in priority order, in part when a more senior lien leaves too
+# little, and an all-funds lien blocks every debit and the account's closure until released. Staff see liens in
+# full and customers only a summary. An admin and a teller are created with bankctl against the server's database,
+# so DB_PATH must be the database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-liens.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-liens.sh
+
+source "$(dirname "$0")/test-lib.sh"
+V2="$BASE_URL/api/v2"
+PASSWORD="liens-test-$RUN_ID"
+
+echo " Account Lien Tests"
+echo "==================="
+
+# customer NAME - creates a customer and prints its id
+customer() {
+    request POST "$V1/customers" "{\"first_name\": \"$1\", \"last_name\": \"Debtor\", \"email\": \"liens-$1-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}"
+    field "['customer']['id']"
+}
+
+# account CUSTOMER - opens a checking account and prints its id
+# Account numbers opened in the same second can collide, so a refused open is retried
+account() {
+    for _ in 1 2 3; do
+        request POST "$V1/accounts" "{\"customer_id\": $1, \"account_type\": \"checking\"}"
+        [ "$STATUS" = 201 ] && break
+        sleep 1
+    done
+    field "['account']['id']"
+}
+
+# upload CUSTOMER - uploads a one-page PDF court order for a customer and prints the document id
+upload() {
+    printf '%%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj\ntrailer << /Root 1 0 R >>\n%%%%EOF\n' > "$WORK/order.pdf"
+    curl -s -X POST "$V1/customers/$1/documents" "${ADMIN[@]}" -F kind=legal_order -F "file=@$WORK/order.pdf;type=application/pdf" |
+        python3 -c "import json, sys; print(json.load(sys.stdin)['document']['id'])"
+}
+
+# post ACCOUNT TYPE AMOUNT [CURL_ARGS...] - posts a transaction on v1
+post() {
+    local account=$1 type=$2 amount=$3
+    shift 3
+    request POST "$V1/transactions" "{\"account_id\": $account, \"transaction_type\": \"$type\", \"amount\": $amount}" "$@"
+}
+
+# balance ACCOUNT - prints an account's balance as a whole number
+balance() {
+    request GET "$V1/accounts/$1/balance"
+    field "['balance']" | sed 's/\.0$//'
+}
+
+# lien ACCOUNT JSON - places a lien as the admin
+lien() {
+    request POST "$V1/accounts/$1/liens" "$2" "${ADMIN[@]}"
+}
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "liens-admin-$RUN_ID" admin)")
+TELLER=(-H "Authorization: Bearer $(staff "liens-teller-$RUN_ID" teller)")
+DEBTOR=$(customer Owen)
+ACCOUNT=$(account "$DEBTOR")
+OTHER=$(customer Other)
+COURT=$(account "$OTHER")
+CREDITOR=$(account "$OTHER")
+post "$ACCOUNT" deposit 1000
+ORDER=$(upload "$DEBTOR")
+FOREIGN_ORDER=$(upload "$OTHER")
+check "a court order is uploaded as a legal_order document" "'$ORDER'.isdigit()"
+
+echo
+echo "Placing liens"
+request POST "$V1/accounts/$ACCOUNT/liens" "{\"claimant\": \"County Court\", \"legal_reference\": \"CC-1\", \"amount\": 300}"
+check "placing a lien needs a login" "s == 401"
+request POST "$V1/accounts/$ACCOUNT/liens" "{\"claimant\": \"County Court\", \"legal_reference\": \"CC-1\", \"amount\": 300}" "${TELLER[@]}"
+check "tellers cannot place liens" "s == 403"
+lien "$ACCOUNT" "{\"claimant\": \"County Court\", \"legal_reference\": \"CC-1\", \"amount\": 300, \"all_funds\": true}"
+check "a lien is either an amount or all funds" "s == 400"
+lien "$ACCOUNT" "{\"claimant\": \" \", \"legal_reference\": \"CC-1\", \"amount\": 300}"
+check "a lien needs a claimant" "s == 400"
+lien "$ACCOUNT" "{\"claimant\": \"County Court\", \"legal_reference\": \"CC-1\", \"amount\": 300, \"document_ids\": [$FOREIGN_ORDER]}"
+check "documents must be the account owner's" "s == 400"
+check "a refused lien leaves nothing behind" "$(sql "SELECT count(*) FROM liens WHERE account_id = ?" "$ACCOUNT") == 0"
+lien "$ACCOUNT" "{\"claimant\": \"County Court\", \"legal_reference\": \"CC-1\", \"amount\": 300, \"priority\": 2, \"document_ids\": [$ORDER]}"
+check "an amount lien is placed with its court order" \
+    "s == 201 and b['lien']['status'] == 'active' and b['lien']['amount'] == 300 and [d['id'] for d in b['lien']['documents']] == [$ORDER]"
+JUNIOR=$(field "['lien']['id']")
+lien "$ACCOUNT" "{\"claimant\": \"First Bank\", \"legal_reference\": \"PLEDGE-7\", \"amount\": 400}"
+check "priority defaults to the most senior" "s == 201 and b['lien']['priority'] == 1 and b['lien']['placed_by'] == 'liens-admin-$RUN_ID'"
+SENIOR=$(field "['lien']['id']")
+
+echo
+echo "Withdrawal enforcement"
+post "$ACCOUNT" withdrawal 301
+check "a withdrawal into the held amount is refused" "s == 403 and b['code'] == 'LIEN_HOLD'"
+check "nothing was debited" "$(balance "$ACCOUNT") == 1000"
+post "$ACCOUNT" withdrawal 300
+check "a withdrawal down to the held amount posts" "s == 201"
+request POST "$V1/transfers" "{\"from_account_id\": $ACCOUNT, \"to_account_id\": $COURT, \"amount\": 1}"
+check "transfers out are held too" "s == 403 and b['code'] == 'LIEN_HOLD'"
+request POST "$V2/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"payment\", \"amount\": \"0.01\"}"
+check "v2 refuses in its error envelope" "s == 403 and b['error']['code'] == 'LIEN_HOLD'"
+post "$ACCOUNT" deposit 100
+check "deposits still post" "s == 201 and $(balance "$ACCOUNT") == 800"
+
+echo
+echo "Views"
+request GET "$V1/accounts/$ACCOUNT/liens"
+check "customers see only what is held" \
+    "s == 200 and 'liens' not in b and b['lien_summary'] == {'active_liens': 2, 'held_amount': 700, 'all_funds': False, 'available_balance': 100}"
+request GET "$V1/accounts/$ACCOUNT"
+check "the account shows customers the summary" "s == 200 and b['lien_summary']['held_amount'] == 700 and 'liens' not in b"
+request GET "$V1/accounts/$ACCOUNT/liens" "" "${TELLER[@]}"
+check "staff see every lien, most senior first" "s == 200 and [l['id'] for l in b['liens']] == [$SENIOR, $JUNIOR]"
+check "staff see the documents" "b['liens'][1]['documents'][0]['kind'] == 'legal_order' and b['liens'][0]['documents'] == []"
+request GET "$V1/accounts/$ACCOUNT" "" "${TELLER[@]}"
+check "the account shows staff the active liens" "s == 200 and len(b['liens']) == 2 and 'lien_summary' not in b"
+
+echo
+echo "Changing a lien"
+request PUT "$V1/accounts/$ACCOUNT/liens/$JUNIOR" "{\"claimant\": \"County Court\", \"legal_reference\": \"CC-1A\", \"amount\": 350, \"priority\": 2}" "${TELLER[@]}"
+check "tellers cannot change liens" "s == 403"
+request PUT "$V1/accounts/$ACCOUNT/liens/$JUNIOR" "{\"claimant\": \"County Court\", \"legal_reference\": \"CC-1A\", \"amount\": 350, \"priority\": 2}" "${ADMIN[@]}"
+check "an admin changes the amount and reference" "s == 200 and b['lien']['amount'] == 350 and b['lien']['legal_reference'] == 'CC-1A'"
+check "its documents stay attached" "len(b['lien']['documents']) == 1"
+request PUT "$V1/accounts/$COURT/liens/$JUNIOR" "{\"claimant\": \"County Court\", \"legal_reference\": \"CC-1A\", \"amount\": 350}" "${ADMIN[@]}"
+check "a lien is only found under its own account" "s == 404"
+post "$ACCOUNT" withdrawal 51
+check "the new amount is enforced" "s == 403 and b['code'] == 'LIEN_HOLD'"
+
+echo
+echo "Satisfaction in priority order"
+post "$ACCOUNT" fee 200 "${ADMIN[@]}"
+check "bank charges may take the balance below the held amount" "s == 201 and $(balance "$ACCOUNT") == 600"
+request POST "$V1/accounts/$ACCOUNT/liens/$JUNIOR/satisfy" "{\"destination_account_id\": $COURT}" "${TELLER[@]}"
+check "tellers cannot satisfy liens" "s == 403"
+request POST "$V1/accounts/$ACCOUNT/liens/$JUNIOR/satisfy" "{\"destination_account_id\": $COURT}" "${ADMIN[@]}"
+check "a junior lien gets only what the senior one leaves" \
+    "s == 200 and b['payment']['amount'] == 200 and b['lien']['status'] == 'active' and b['lien']['amount'] == 150 and b['lien']['paid_amount'] == 200"
+request POST "$V1/accounts/$ACCOUNT/liens/$JUNIOR/satisfy" "{\"destination_account_id\": $COURT}" "${ADMIN[@]}"
+check "nothing is left for it while the senior lien is unpaid" "s == 409"
+request POST "$V1/accounts/$ACCOUNT/liens/$SENIOR/satisfy" "{\"destination_account_id\": $ACCOUNT}" "${ADMIN[@]}"
+check "a lien cannot be paid into its own account" "s == 400 and b['code'] == 'SELF_TRANSFER'"
+request POST "$V1/accounts/$ACCOUNT/liens/$SENIOR/satisfy" "{\"destination_account_id\": 999999999}" "${ADMIN[@]}"
+check "the destination must exist" "s == 404"
+request POST "$V1/accounts/$ACCOUNT/liens/$SENIOR/satisfy" "{\"destination_account_id\": $CREDITOR}" "${ADMIN[@]}"
+check "the senior lien is paid in full and satisfied" \
+    "s == 200 and b['payment']['amount'] == 400 and b['lien']['status'] == 'satisfied' and b['lien']['satisfied_by'] == 'liens-admin-$RUN_ID'"
+request POST "$V1/accounts/$ACCOUNT/liens/$SENIOR/satisfy" "{\"destination_account_id\": $CREDITOR}" "${ADMIN[@]}"
+check "a satisfied lien is not paid again" "s == 409"
+post "$ACCOUNT" deposit 500
+request POST "$V1/accounts/$ACCOUNT/liens/$JUNIOR/satisfy" "{\"destination_account_id\": $COURT}" "${ADMIN[@]}"
+check "the junior lien is paid its rest once funds arrive" \
+    "s == 200 and b['payment']['amount'] == 150 and b['lien']['status'] == 'satisfied' and b['lien']['paid_amount'] == 350 and len(b['lien']['payments']) == 2"
+check "the account paid both claimants" "$(balance "$ACCOUNT") == 350 and $(balance "$COURT") == 350 and $(balance "$CREDITOR") == 400"
+check "each payment is a ledger transfer with a credit to the claimant" \
+    "$(sql "SELECT count(*) FROM transactions WHERE account_id = ? AND description LIKE 'Lien payment to %'" "$ACCOUNT") == 3"
+post "$ACCOUNT" withdrawal 1
+check "satisfied liens hold nothing" "s == 201"
+
+echo
+echo "All-funds liens and release"
+lien "$ACCOUNT" "{\"claimant\": \"Tax Authority\", \"legal_reference\": \"LEVY-42\", \"all_funds\": true}"
+check "an all-funds lien is placed" "s == 201 and b['lien']['all_funds'] and b['lien']['amount'] == 0"
+LEVY=$(field "['lien']['id']")
+post "$ACCOUNT" withdrawal 0.01
+check "it blocks every withdrawal" "s == 403 and b['code'] == 'LIEN_HOLD'"
+request GET "$V1/accounts/$ACCOUNT/liens"
+check "customers see all funds held" "b['lien_summary']['all_funds'] and b['lien_summary']['available_balance'] == 0"
+request POST "$V1/accounts/$ACCOUNT/close" "{\"destination\": \"cashier_check\"}" "${ADMIN[@]}"
+check "an account with active liens cannot be closed" "s == 409"
+request POST "$V1/accounts/$ACCOUNT/liens/$LEVY/release" "{}" "${ADMIN[@]}"
+check "a release needs a reason" "s == 400"
+request POST "$V1/accounts/$ACCOUNT/liens/$LEVY/release" "{\"reason\": \"Levy withdrawn\"}" "${ADMIN[@]}"
+check "the lien is released" "s == 200 and b['lien']['status'] == 'released' and b['lien']['release_reason'] == 'Levy withdrawn'"
+request PUT "$V1/accounts/$ACCOUNT/liens/$LEVY" "{\"claimant\": \"Tax Authority\", \"legal_reference\": \"LEVY-42\", \"all_funds\": true}" "${ADMIN[@]}"
+check "a released lien cannot be changed" "s == 409"
+post "$ACCOUNT" withdrawal 1
+check "withdrawals post again" "s == 201"
+request GET "$V1/accounts/$ACCOUNT/liens?status=released" "" "${ADMIN[@]}"
+check "released liens are kept" "s == 200 and [l['id'] for l in b['liens']] == [$LEVY]"
+check "every change is on the outbox" \
+    "'$(sql "SELECT group_concat(event_type) FROM (SELECT event_type FROM outbox_events WHERE aggregate_id = ? AND event_type LIKE 'account.lien_%' ORDER BY id)" "$ACCOUNT")'.split(',') == ['account.lien_placed'] * 2 + ['account.lien_changed'] + ['account.lien_paid'] * 3 + ['account.lien_placed', 'account.lien_released']"
+
+finish "lien"
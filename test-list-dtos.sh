@@ -0,0 +1,80 @@
+#!/bin/bash
+
+# List Summary Tests
+# Checks the customer and transaction list endpoints return summary rows: customers carry account and loan counts
+# instead of their collections, and transactions carry the account number, currency and owner name instead of a
+# nested account. ?include= embeds the nested objects on request, for every row on the page, and the summary page
+# is much smaller than the included one. Query counts per page are held to a budget by go test ./handlers. The
+# customer, accounts and loans are created by the run. Exits non-zero on failure.
+#
+# Usage: ./test-list-dtos.sh            (server on localhost:8080)
+#        BASE_URL=http://host:port ./test-list-dtos.sh
+
+source "$(dirname "$0")/test-lib.sh"
+
+echo " List Summary Tests"
+echo "==================="
+
+# customer_row [PARAMS] - pages through the customer list until the run's customer turns up, leaving the page's
+# size in PAGE_SIZE and the customer's row in BODY
+customer_row() {
+    local page row
+    for page in $(seq 1 1000); do
+        request GET "$V1/customers?limit=100&page=$page$1"
+        PAGE_SIZE=${#BODY}
+        row=$(python3 -c "
+import json, sys
+b = json.loads(sys.argv[1])
+rows = [r for r in b['customers'] if r['id'] == int(sys.argv[2])]
+print(json.dumps(rows[0]) if rows else ('' if b['customers'] else '{}'))" "$BODY" "$CUSTOMER")
+        [ -n "$row" ] && break
+    done
+    BODY=$row
+}
+
+echo "Setup"
+request POST "$V1/customers" "{\"first_name\": \"Summary\", \"last_name\": \"Lister\", \"email\": \"lists-$RUN_ID@example.test\", \"date_of_birth\": \"1980-01-01\"}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}"
+CHECKING=$(field "['account']['id']")
+CHECKING_NUMBER=$(field "['account']['account_number']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"savings\"}"
+SAVINGS=$(field "['account']['id']")
+request POST "$V1/loans?disburse=false" "{\"customer_id\": $CUSTOMER, \"principal_amount\": 1000, \"interest_rate\": 0.05, \"loan_term\": 12}"
+for amount in 10 20 30; do
+    request POST "$V1/transactions" "{\"account_id\": $CHECKING, \"transaction_type\": \"deposit\", \"amount\": $amount}"
+done
+check "a customer with two accounts, a loan and deposits exists" "s == 201 and '$CUSTOMER$CHECKING$SAVINGS'.isdigit()"
+
+echo
+echo "Customers"
+customer_row
+SUMMARY_SIZE=$PAGE_SIZE
+check "rows carry account and loan counts" "b['account_count'] == 2 and b['loan_count'] == 1"
+check "rows carry no collections" "'accounts' not in b and 'loans' not in b"
+check "rows carry the summary fields" "all(k in b for k in ('id', 'first_name', 'last_name', 'email', 'status', 'created_at'))"
+customer_row "&include=accounts,loans"
+INCLUDED_SIZE=$PAGE_SIZE
+check "include=accounts,loans embeds both collections" \
+    "sorted(a['id'] for a in b['accounts']) == sorted([$CHECKING, $SAVINGS]) and len(b['loans']) == 1"
+customer_row "&include=accounts"
+check "include=accounts embeds only the accounts" "len(b['accounts']) == 2 and 'loans' not in b"
+check "the summary page is much smaller than the included one" "$SUMMARY_SIZE * 2 < $INCLUDED_SIZE"
+
+echo
+echo "Transactions"
+request GET "$V1/transactions?account_id=$CHECKING&limit=100"
+check "the account's transactions are listed" "s == 200 and b['total'] == 3 and len(b['transactions']) == 3"
+check "rows carry the account number, currency and owner" \
+    "all(t['account_number'][-4:] == '${CHECKING_NUMBER: -4}' and t['currency'] == 'USD' and t['customer_id'] == $CUSTOMER and t['customer_name'] == 'Summary Lister' for t in b['transactions'])"
+check "rows carry no nested account" "all('account' not in t for t in b['transactions'])"
+request GET "$V1/transactions?account_id=$CHECKING&limit=100&include=account"
+check "include=account nests the account on every row" \
+    "all(t['account']['id'] == $CHECKING for t in b['transactions'])"
+request GET "$V1/transactions?account_id=$CHECKING&limit=100&include=customer"
+check "include=customer nests the account and its owner" \
+    "all(t['account']['customer']['id'] == $CUSTOMER for t in b['transactions'])"
+request GET "$V1/transactions?account_id=$CHECKING&limit=100&include=nonsense"
+check "an unknown include is ignored" "s == 200 and all('account' not in t for t in b['transactions'])"
+
+finish "list summary"
@@ -0,0 +1,78 @@
+#!/bin/bash
+
+# List Total Tests
+# Posts deposits and withdrawals to two fresh accounts and pages through GET /transactions with the account_id and
+# type filters combined, checking on every page that total is exactly the number of rows the filters match, that
+# the pages together hold each of those rows once, and that ?include= joins do not change the count. Also checks a
+# page past the end is empty with the same total. Exits non-zero on failure.
+#
+# Usage: ./test-list-totals.sh            (server on localhost:8080)
+#        BASE_URL=http://host:port ./test-list-totals.sh
+
+source "$(dirname "$0")/test-lib.sh"
+
+echo " List Total Tests"
+echo "================="
+
+# pages FILTER LIMIT - walks every page of the filtered list, leaving in BODY the totals each page reported, the
+# ids across all pages and the rows' account ids and types
+pages() {
+    local page all="[]"
+    for page in $(seq 1 100); do
+        request GET "$V1/transactions?$1&limit=$2&page=$page"
+        all=$(python3 -c "
+import json, sys
+all, b = json.loads(sys.argv[1]), json.loads(sys.argv[2])
+all.append({'total': b['total'], 'rows': [[t['id'], t['account_id'], t['transaction_type']] for t in b['transactions'] or []]})
+print(json.dumps(all))" "$all" "$BODY")
+        [ "$(python3 -c "import json, sys; print(len(json.loads(sys.argv[1])['transactions'] or []))" "$BODY")" -lt "$2" ] && break
+    done
+    BODY="{\"pages\": $all}"
+    STATUS=200
+}
+
+# totals NAME FILTER EXPECTED CONDITION - checks every page size of the filtered list against the expected total,
+# with the condition each row must meet written over `a` (account id) and `t` (type)
+totals() {
+    local limit
+    for limit in 2 3 100; do
+        pages "$2" "$limit"
+        check "$1, $limit per page" "
+all(p['total'] == $3 for p in b['pages']) and
+sorted(r[0] for p in b['pages'] for r in p['rows']) == sorted(set(r[0] for p in b['pages'] for r in p['rows'])) and
+sum(len(p['rows']) for p in b['pages']) == $3 and
+all((lambda a, t: $4)(r[1], r[2]) for p in b['pages'] for r in p['rows'])"
+    done
+}
+
+echo "Setup"
+request POST "$V1/customers" "{\"first_name\": \"Total\", \"last_name\": \"Counter\", \"email\": \"totals-$RUN_ID@example.test\", \"date_of_birth\": \"1980-01-01\"}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}"
+FIRST=$(field "['account']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}"
+SECOND=$(field "['account']['id']")
+for amount in 100 200 300 400 500; do
+    request POST "$V1/transactions" "{\"account_id\": $FIRST, \"transaction_type\": \"deposit\", \"amount\": $amount}"
+    request POST "$V1/transactions" "{\"account_id\": $SECOND, \"transaction_type\": \"deposit\", \"amount\": $amount}"
+done
+for amount in 10 20 30; do
+    request POST "$V1/transactions" "{\"account_id\": $FIRST, \"transaction_type\": \"withdrawal\", \"amount\": $amount}"
+done
+check "two accounts with deposits and withdrawals exist" "s == 201 and '$FIRST$SECOND'.isdigit()"
+
+echo
+echo "Totals"
+totals "one account" "account_id=$FIRST" 8 "a == $FIRST"
+totals "one account's deposits" "account_id=$FIRST&type=deposit" 5 "a == $FIRST and t == 'deposit'"
+totals "one account's withdrawals" "account_id=$FIRST&type=withdrawal" 3 "a == $FIRST and t == 'withdrawal'"
+totals "the other account's withdrawals" "account_id=$SECOND&type=withdrawal" 0 "False"
+totals "deposits with their accounts and owners included" "account_id=$FIRST&type=deposit&include=customer" 5 \
+    "a == $FIRST and t == 'deposit'"
+
+echo
+echo "Past the end"
+request GET "$V1/transactions?account_id=$FIRST&type=deposit&limit=2&page=9"
+check "a page past the end is empty with the same total" "s == 200 and not b['transactions'] and b['total'] == 5 and b['page'] == 9"
+
+finish "list total"
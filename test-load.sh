@@ -0,0 +1,60 @@
+#!/bin/bash
+
+# Load Shedding Test
+# Saturates the write limit with parallel deposits while reading, and checks that writes beyond the limit are
+# shed with 503 and Retry-After while every read succeeds. The limits are lowered through the admin endpoint for
+# the test and restored afterwards. The admin user is created with bankctl against the server's database.
+# Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-load.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl WRITERS=80 READERS=40 ./test-load.sh
+
+source "$(dirname "$0")/test-lib.sh"
+WRITERS="${WRITERS:-80}"
+READERS="${READERS:-40}"
+PASSWORD="load-test-$RUN_ID"
+
+echo " Load Shedding Test"
+echo "==================="
+
+echo "Setup"
+request POST "$V1/customers" "{\"first_name\": \"Load\", \"last_name\": \"Test\", \"email\": \"load-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}"
+CUSTOMER_ID=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER_ID, \"account_type\": \"checking\"}"
+ACCOUNT=$(field "['account']['id']")
+
+ADMIN=(-H "Authorization: Bearer $(staff "load-admin-$RUN_ID")")
+request GET "$V1/admin/concurrency" "" "${ADMIN[@]}"
+ORIGINAL_LIMITS=$(python3 -c "import json, sys; print(json.dumps(json.loads(sys.argv[1])['limits']))" "$BODY")
+request PUT "$V1/admin/concurrency" '{"global": 0, "writes": 1, "retry_after_seconds": 3}' "${ADMIN[@]}"
+check "admin lowers the write limit to 1" "s == 200"
+on_exit 'request PUT "$V1/admin/concurrency" "$ORIGINAL_LIMITS" "${ADMIN[@]}"'
+
+echo "Saturating writes with $WRITERS deposits while $READERS reads run"
+seq "$WRITERS" | xargs -P "$WRITERS" -I{} curl -s -o /dev/null -D "$WORK/write-{}.headers" -w "%{http_code}\n" \
+    -X POST "$V1/transactions" -H "Content-Type: application/json" \
+    -d "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 1}" > "$WORK/writes" &
+seq "$READERS" | xargs -P "$READERS" -I{} curl -s -o /dev/null -w '%{http_code} %{time_total}\n' \
+    "$V1/accounts/$ACCOUNT/balance" > "$WORK/reads" &
+wait
+
+ACCEPTED=$(grep -c '^201$' "$WORK/writes")
+SHED=$(grep -c '^503$' "$WORK/writes")
+OTHER=$((WRITERS - ACCEPTED - SHED))
+READ_OK=$(grep -c '^200 ' "$WORK/reads")
+SLOWEST=$(sort -k2 -n "$WORK/reads" | tail -1 | cut -d' ' -f2)
+# Writes neither accepted nor shed are reported here rather than failing the test
+echo "  writes: $ACCEPTED accepted, $SHED shed, $OTHER failed otherwise; reads: $READ_OK/$READERS ok, slowest ${SLOWEST}s"
+assert "writes were accepted up to the limit" "[ $ACCEPTED -gt 0 ]"
+assert "writes beyond the limit were shed" "[ $SHED -gt 0 ]"
+assert "shed writes carry Retry-After" "[ \$(grep -lis '^retry-after: 3' $WORK/write-*.headers | wc -l) -eq $SHED ]"
+assert "every read succeeded while writes were saturated" "[ $READ_OK -eq $READERS ]"
+
+echo "Afterwards"
+request GET "$V1/accounts/$ACCOUNT/balance"
+check "the balance reflects exactly the accepted writes" "b['balance'] == $ACCEPTED"
+METRICS=$(curl -s "$BASE_URL/metrics")
+assert "shed writes are counted in metrics" "echo \"\$METRICS\" | grep -q '^http_requests_shed_total{limit=\"writes\"} [1-9]'"
+assert "in-flight writes are back to zero" "echo \"\$METRICS\" | grep -q '^http_requests_in_flight{class=\"write\"} 0'"
+
+finish "load shedding"
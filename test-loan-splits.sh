@@ -0,0 +1,157 @@
+#!/bin/bash
+
+# Loan Payment Split Tests
+# Sets a 60/40 payment split on a co-borrowed loan and checks the configuration rules, then runs the installments job
+# over installments made due in the server's database: a full collection takes each party's share from its own
+# account and the payment history names the party behind each payment; when the co-borrower lacks funds the
+# borrower covers the share, the co-borrower alone is notified and no installment_short event is recorded; when both
+# lack funds the installment is left short, both are notified and the event is recorded; with fallback off a
+# failed share is left short without charging the borrower; a final installment paying the loan off is collected
+# without a shortfall or notices. Each run creates its own tenant, so DB_PATH must be the database the server uses;
+# the platform admin is created with bankctl. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-loan-splits.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-loan-splits.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="split-test-$RUN_ID-Aa1!"
+PLATFORM_USER="split-platform-$RUN_ID"
+TENANT_CODE="split$RUN_ID"
+
+echo " Loan Payment Split Tests"
+echo "========================="
+
+# collect - makes the loan's next installment due yesterday, runs the installments job, waits for it and leaves
+# the payment split with its latest collection in BODY
+collect() {
+    sql "UPDATE loan_splits SET next_due_date = datetime('now', '-1 day') WHERE loan_id = $LOAN" > /dev/null
+    request POST "$V1/admin/jobs/installments/run" "" "${PLATFORM[@]}"
+    local run
+    run=$(field "['run']['id']" 2>/dev/null)
+    for _ in $(seq 1 50); do
+        sleep 0.1
+        request GET "$V1/admin/jobs/runs?job=installments&limit=5" "" "${PLATFORM[@]}"
+        python3 -c "
+import json, sys
+run = [r for r in json.loads(sys.argv[1])['runs'] if r['id'] == $run][0]
+sys.exit(1 if run['status'] == 'running' else 0)" "$BODY" 2>/dev/null && break
+    done
+    request GET "$V1/loans/$LOAN/payment-split" "" "${AUTH[@]}"
+}
+
+# drain ACCOUNT - withdraws all but 10.00 from an account
+drain() {
+    request GET "$V1/accounts/$1" "" "${AUTH[@]}"
+    local amount
+    amount=$(python3 -c "import json, sys; print(round(json.loads(sys.argv[1])['balance'] - 10, 2))" "$BODY")
+    request POST "$V1/transactions" "{\"account_id\": $1, \"transaction_type\": \"withdrawal\", \"amount\": $amount}" "${AUTH[@]}"
+}
+
+# notices CUSTOMER - prints how many loan collection notices a customer has been sent
+notices() {
+    sql "SELECT COUNT(*) FROM notifications WHERE resource_type = 'loan_collection' AND customer_id = $1"
+}
+
+echo "Setup"
+tenant "$PLATFORM_USER" "Splits" split-admin
+
+request POST "$V1/customers" "{\"first_name\": \"Bea\", \"last_name\": \"Borrower\", \"email\": \"borrower-$RUN_ID@example.com\"}" "${AUTH[@]}"
+BORROWER=$(field "['customer']['id']")
+request POST "$V1/customers" "{\"first_name\": \"Cole\", \"last_name\": \"Coborrower\", \"email\": \"coborrower-$RUN_ID@example.com\"}" "${AUTH[@]}"
+COBORROWER=$(field "['customer']['id']")
+sql "UPDATE customers SET email_verified = 1 WHERE id IN ($BORROWER, $COBORROWER)" > /dev/null
+request POST "$V1/accounts" "{\"customer_id\": $BORROWER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+BORROWER_ACCOUNT=$(field "['account']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $COBORROWER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+COBORROWER_ACCOUNT=$(field "['account']['id']")
+request POST "$V1/transactions" "{\"account_id\": $BORROWER_ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 1000}" "${AUTH[@]}"
+request POST "$V1/transactions" "{\"account_id\": $COBORROWER_ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 1000}" "${AUTH[@]}"
+
+request POST "$V1/loans?disburse=false" "{\"customer_id\": $BORROWER, \"principal_amount\": 1200, \"interest_rate\": 0.06, \"loan_term\": 12}" "${AUTH[@]}"
+LOAN=$(field "['loan']['id']")
+INSTALLMENT=$(python3 -c "import json, sys; print(round(json.loads(sys.argv[1])['loan']['monthly_payment'], 2))" "$BODY")
+request POST "$V1/loans/$LOAN/parties" "{\"customer_id\": $COBORROWER, \"role\": \"co_borrower\", \"liability_percent\": 50}" "${AUTH[@]}"
+COBORROWER_PARTY=$(field "['party']['id']")
+request GET "$V1/loans/$LOAN/parties" "" "${AUTH[@]}"
+BORROWER_PARTY=$(python3 -c "import json, sys; print([p['id'] for p in json.loads(sys.argv[1])['parties'] if p['role'] == 'borrower'][0])" "$BODY")
+
+echo
+echo "Configuration"
+SPLIT="{\"fallback_to_borrower\": true, \"shares\": [{\"party_id\": $BORROWER_PARTY, \"account_id\": $BORROWER_ACCOUNT, \"percent\": 60}, {\"party_id\": $COBORROWER_PARTY, \"account_id\": $COBORROWER_ACCOUNT, \"percent\": 40}]}"
+request PUT "$V1/loans/$LOAN/payment-split" "$SPLIT" "${AUTH[@]}"
+check "a pending loan cannot be split" "s == 409"
+request POST "$V1/loans/$LOAN/disburse" "" "${AUTH[@]}"
+check "the loan is disbursed" "s == 200"
+request GET "$V1/loans/$LOAN/payment-split" "" "${AUTH[@]}"
+check "a loan without a split has none to show" "s == 404"
+request PUT "$V1/loans/$LOAN/payment-split" "{\"shares\": [{\"party_id\": $BORROWER_PARTY, \"account_id\": $BORROWER_ACCOUNT, \"percent\": 60}, {\"party_id\": $COBORROWER_PARTY, \"account_id\": $COBORROWER_ACCOUNT, \"percent\": 30}]}" "${AUTH[@]}"
+check "shares must add up to 100" "s == 400 and 'add up to 100' in b['error']"
+request PUT "$V1/loans/$LOAN/payment-split" "{\"shares\": [{\"party_id\": $BORROWER_PARTY, \"account_id\": $BORROWER_ACCOUNT, \"percent\": 60}, {\"party_id\": $COBORROWER_PARTY, \"account_id\": $BORROWER_ACCOUNT, \"percent\": 40}]}" "${AUTH[@]}"
+check "a party funds its share from its own account" "s == 400 and 'account of the party' in b['error']"
+request PUT "$V1/loans/$LOAN/payment-split" "{\"shares\": [{\"party_id\": 999999, \"account_id\": $BORROWER_ACCOUNT, \"percent\": 100}]}" "${AUTH[@]}"
+check "shares are for the loan's borrower and co-borrowers" "s == 400"
+request PUT "$V1/loans/$LOAN/payment-split" "{\"fallback_to_borrower\": true, \"shares\": [{\"party_id\": $COBORROWER_PARTY, \"account_id\": $COBORROWER_ACCOUNT, \"percent\": 100}]}" "${AUTH[@]}"
+check "falling back needs the borrower's funding account" "s == 400 and 'borrower' in b['error']"
+request PUT "$V1/loans/$LOAN/payment-split" "$SPLIT" "${AUTH[@]}"
+check "a 60/40 split is set" "s == 200 and b['split']['fallback_to_borrower'] and sorted(p['share_percent'] for p in b['parties']) == [40, 60]"
+check "with the first installment a month after disbursement" \
+    "'$(python3 -c "import datetime; print(datetime.date.today() + datetime.timedelta(days=27))")' <= b['split']['next_due_date'][:10] <= '$(python3 -c "import datetime; print(datetime.date.today() + datetime.timedelta(days=31))")'"
+
+echo
+echo "Collection"
+collect
+check "the installment is collected in full" "s == 200 and b['collections'][0]['status'] == 'collected' and b['collections'][0]['amount'] == $INSTALLMENT and b['collections'][0]['shortfall'] == 0"
+check "in two shares from the parties' own accounts" \
+    "[(x['party_id'], x['account_id'], x['amount']) for x in b['collections'][0]['shares']] == [($BORROWER_PARTY, $BORROWER_ACCOUNT, round($INSTALLMENT * 0.6, 2)), ($COBORROWER_PARTY, $COBORROWER_ACCOUNT, round($INSTALLMENT - round($INSTALLMENT * 0.6, 2), 2))]"
+check "and the next installment moves a month on" "b['split']['next_due_date'][:10] > '$(date -u +%Y-%m-%d)'"
+request GET "$V1/loans/$LOAN/payments" "" "${AUTH[@]}"
+check "the payment history names the party behind each payment" \
+    "sorted((p['party_id'], p['account_id']) for p in b['payments']) == sorted([($BORROWER_PARTY, $BORROWER_ACCOUNT), ($COBORROWER_PARTY, $COBORROWER_ACCOUNT)]) and all('covers_party_id' not in p for p in b['payments'])"
+
+echo
+echo "Fallback"
+drain "$COBORROWER_ACCOUNT"
+collect
+check "a co-borrower without funds is covered by the borrower" "b['collections'][0]['status'] == 'collected' and b['collections'][0]['collected'] == $INSTALLMENT"
+check "the failed share and the borrower's cover are both recorded" \
+    "[(x['party_id'], x['collected'] > 0, x.get('covers_party_id')) for x in b['collections'][0]['shares']] == [($BORROWER_PARTY, True, None), ($COBORROWER_PARTY, False, None), ($BORROWER_PARTY, True, $COBORROWER_PARTY)] and b['collections'][0]['shares'][1]['error']"
+request GET "$V1/loans/$LOAN/payments?limit=2" "" "${AUTH[@]}"
+check "the history shows the borrower paying the co-borrower's share" \
+    "any(p['party_id'] == $BORROWER_PARTY and p.get('covers_party_id') == $COBORROWER_PARTY and p['account_id'] == $BORROWER_ACCOUNT for p in b['payments'])"
+check "the co-borrower is notified" "'$(notices "$COBORROWER")' == '1'"
+check "the borrower is not" "'$(notices "$BORROWER")' == '0'"
+check "and the installment is not short" "'$(sql "SELECT COUNT(*) FROM outbox_events WHERE aggregate_type = 'loan' AND aggregate_id = $LOAN AND event_type = 'loan.installment_short'")' == '0'"
+
+echo
+echo "Shortfall"
+drain "$BORROWER_ACCOUNT"
+collect
+check "an installment neither party can fund is left short" \
+    "b['collections'][0]['status'] == 'short' and b['collections'][0]['collected'] == 0 and b['collections'][0]['shortfall'] == $INSTALLMENT"
+check "after trying the borrower's cover" "len(b['collections'][0]['shares']) == 3 and all(x['error'] for x in b['collections'][0]['shares'])"
+check "both parties are notified" "'$(notices "$COBORROWER")' == '2' and int('$(notices "$BORROWER")') >= 1"
+check "and the short installment is recorded as an event" "'$(sql "SELECT COUNT(*) FROM outbox_events WHERE aggregate_type = 'loan' AND aggregate_id = $LOAN AND event_type = 'loan.installment_short'")' == '1'"
+
+echo
+echo "Without fallback"
+request POST "$V1/transactions" "{\"account_id\": $BORROWER_ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 1000}" "${AUTH[@]}"
+request PUT "$V1/loans/$LOAN/payment-split" "$(echo "$SPLIT" | sed 's/"fallback_to_borrower": true/"fallback_to_borrower": false/')" "${AUTH[@]}"
+check "fallback is turned off" "s == 200 and not b['split']['fallback_to_borrower']"
+collect
+check "the co-borrower's share is left short without charging the borrower" \
+    "b['collections'][0]['status'] == 'short' and len(b['collections'][0]['shares']) == 2 and b['collections'][0]['shortfall'] == b['collections'][0]['shares'][1]['amount']"
+
+echo
+echo "Payoff"
+request POST "$V1/transactions" "{\"account_id\": $COBORROWER_ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 100}" "${AUTH[@]}"
+sql "UPDATE loans SET remaining_balance = 25 WHERE id = $LOAN" > /dev/null
+NOTICES="$(notices "$BORROWER") $(notices "$COBORROWER")"
+collect
+check "a final installment smaller than the payment is collected in full" \
+    "b['collections'][0]['status'] == 'collected' and b['collections'][0]['shortfall'] == 0 and 25 <= b['collections'][0]['amount'] < $INSTALLMENT"
+check "and pays the loan off" "'$(sql "SELECT status FROM loans WHERE id = $LOAN")' == 'paid_off'"
+check "without a shortfall notice to either party" "'$(notices "$BORROWER") $(notices "$COBORROWER")' == '$NOTICES'"
+request GET "$V1/loans/$LOAN/payment-split" ""
+check "the split needs staff credentials" "s == 401"
+
+finish "loan split"
@@ -0,0 +1,127 @@
+#!/bin/bash
+
+# Localization Tests
+# Checks that every error code the API can return has a message in every shipped locale, that Accept-Language
+# picks the language of error messages and documents with English as the fallback, and that a customer's
+# preferred_language overrides what their client asks for.
+#
+# The catalog checks read the source tree, so run this from the repository root. A customer user is created with
+# bankctl against the server's database, so DB_PATH must be the database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-localization.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-localization.sh
+
+source "$(dirname "$0")/test-lib.sh"
+V2="$BASE_URL/api/v2"
+PASSWORD="locale-test-$RUN_ID"
+
+echo " Localization Tests"
+echo "==================="
+
+# document NAME URL PATTERN [CURL_ARGS...] - fetches a PDF and passes when its text matches the pattern
+document() {
+    local name=$1 url=$2 pattern=$3
+    shift 3
+    local out
+    out=$(mktemp)
+    curl -s -o "$out" "$url" "$@"
+    if grep -aqE "$pattern" "$out"; then
+        echo "  ✓ $name"
+    else
+        echo "  ✗ $name: no match for $pattern"
+        FAILURES=$((FAILURES + 1))
+    fi
+    rm -f "$out"
+}
+
+echo "Catalogs"
+# Every error code used in a JSON error body, as an apiError Code or an inline "code" key
+if python3 - <<'EOF'
+import glob, json, os, re, sys
+codes = set()
+for path in glob.glob("**/*.go", recursive=True):
+    with open(path) as f:
+        codes.update(re.findall(r'(?:Code:\s*|"code":\s*)"([A-Z][A-Z_]+)"', f.read()))
+catalogs = {os.path.basename(p)[:-5]: json.load(open(p)) for p in glob.glob("i18n/locales/*.json")}
+failed = False
+if not codes or "en" not in catalogs or len(catalogs) < 2:
+    print("    found %d codes and locales %s" % (len(codes), sorted(catalogs)))
+    failed = True
+for tag, messages in sorted(catalogs.items()):
+    missing = sorted(code for code in codes if not messages.get("error." + code))
+    if missing:
+        print("    %s has no message for %s" % (tag, ", ".join(missing)))
+        failed = True
+    lacking = sorted(set(catalogs["en"]) - set(messages))
+    if lacking:
+        print("    %s lacks %s" % (tag, ", ".join(lacking)))
+        failed = True
+sys.exit(1 if failed else 0)
+EOF
+then
+    echo "  ✓ every error code has a message in every locale, and every locale has every key"
+else
+    echo "  ✗ catalogs are incomplete"
+    FAILURES=$((FAILURES + 1))
+fi
+
+echo
+echo "Setup"
+request POST "$V1/customers" "{\"first_name\": \"Lucia\", \"last_name\": \"Locale\", \"email\": \"locale-$RUN_ID@example.com\"}"
+check "customer created" "s == 201 and b['customer']['preferred_language'] == ''"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}"
+ACCOUNT=$(field "['account']['id']")
+request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 1234.5}"
+check "account opened and funded" "s == 201"
+TRANSACTION=$(field "['transaction']['id']")
+MONTH=$(date -u +%Y/%m)
+
+echo
+echo "Error messages"
+SELF="{\"from_account_id\": $ACCOUNT, \"to_account_id\": $ACCOUNT, \"amount\": 10}"
+request POST "$V1/transfers" "$SELF"
+check "English is the default" "s == 400 and b['code'] == 'SELF_TRANSFER' and b['error'] == 'Source and destination must be different accounts'"
+check "the language is announced" "h.get('content-language') == 'en'"
+request POST "$V1/transfers" "$SELF" -H "Accept-Language: es"
+check "Accept-Language picks the message language" "s == 400 and b['code'] == 'SELF_TRANSFER' and b['error'] == 'Las cuentas de origen y destino deben ser distintas'"
+check "and is announced" "h.get('content-language') == 'es'"
+request POST "$V1/transfers" "$SELF" -H "Accept-Language: es-MX,en;q=0.5"
+check "a regional variant gets its language" "b['error'] == 'Las cuentas de origen y destino deben ser distintas'"
+request POST "$V1/transfers" "$SELF" -H "Accept-Language: fr-FR, de;q=0.9"
+check "unshipped languages fall back to English" "b['error'] == 'Source and destination must be different accounts'"
+request POST "$V1/transfers" "$SELF" -H "Accept-Language: en;q=0.2, es;q=0.8"
+check "quality values are honoured" "b['error'] == 'Las cuentas de origen y destino deben ser distintas'"
+request POST "$V2/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": \"12.345\"}" -H "Accept-Language: es"
+check "v2 error envelopes are translated" "s == 400 and b['error']['code'] == 'INVALID_AMOUNT' and b['error']['message'] == 'Importe no válido'"
+request GET "$V1/accounts/999999999" "" -H "Accept-Language: es"
+check "errors without a code are left as they are" "s == 404 and b['error'] == 'Account not found'"
+
+echo
+echo "Preferred language"
+request PUT "$V1/customers/$CUSTOMER" "{\"preferred_language\": \"fr\"}"
+check "an unshipped language is refused" "s == 400 and b['code'] == 'UNSUPPORTED_LANGUAGE'"
+request PUT "$V1/customers/$CUSTOMER" "{\"preferred_language\": \"es-MX\"}"
+check "a regional variant is stored as its language" "s == 200 and b['customer']['preferred_language'] == 'es'"
+CUSTOMER_AUTH=(-H "Authorization: Bearer $(customer_user "locale-customer-$RUN_ID" "$CUSTOMER")")
+request POST "$V1/transfers" "$SELF" -H "Accept-Language: en" "${CUSTOMER_AUTH[@]}"
+check "the customer's preference overrides Accept-Language" "b['error'] == 'Las cuentas de origen y destino deben ser distintas'"
+check "and is announced" "h.get('content-language') == 'es'"
+
+echo
+echo "Documents"
+document "statements are labelled in English by default" "$V1/customers/$CUSTOMER/statements/$MONTH?format=pdf" \
+    "\(Consolidated Statement\)"
+document "and in the requested language" "$V1/customers/$CUSTOMER/statements/$MONTH?format=pdf" \
+    "\(Extracto consolidado\)" -H "Accept-Language: es"
+document "with the language's date order" "$V1/customers/$CUSTOMER/statements/$MONTH?format=pdf" \
+    "\(Periodo: del 01/[0-9]{2}/[0-9]{4} al [0-9]{2}/[0-9]{2}/[0-9]{4}\)" -H "Accept-Language: es"
+document "and number format" "$V1/customers/$CUSTOMER/statements/$MONTH?format=pdf" \
+    " 1\.234,50\)" -H "Accept-Language: es"
+document "English numbers are grouped too" "$V1/customers/$CUSTOMER/statements/$MONTH?format=pdf" " 1,234\.50\)"
+document "receipts are labelled in the requested language" "$V1/transactions/$TRANSACTION/receipt?format=pdf" \
+    "\(Importe +1\.234,50 USD\)" -H "Accept-Language: es"
+document "and in the customer's preferred language when they ask" "$V1/transactions/$TRANSACTION/receipt?format=pdf" \
+    "\(Comprobante de operaci" "${CUSTOMER_AUTH[@]}"
+
+finish "localization"
@@ -0,0 +1,95 @@
+#!/bin/bash
+
+# Money Tests
+# Checks that amounts are counted in each currency's minor units: postings finer than the minor unit are rounded to
+# it, or refused when that leaves nothing, balances add up exactly over many postings, and display amounts show the
+# currency's decimals. Percentage fees are rounded half away from zero over random amounts. Transfers and loan
+# payments between accounts in different currencies are refused. Schedules are made for a product created by the
+# run, so other postings are never charged. The admin user is created with bankctl. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-money.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-money.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="money-test-$RUN_ID"
+ADMIN_USER="money-admin-$RUN_ID"
+PRODUCT="money$(( $(date +%s) % 1000000 ))$(( $$ % 1000 ))"
+
+echo " Money Tests"
+echo "============"
+
+# post ACCOUNT TYPE AMOUNT - posts a transaction
+post() {
+    request POST "$V1/transactions" "{\"account_id\": $1, \"transaction_type\": \"$2\", \"amount\": $3}" "${ADMIN[@]}"
+}
+
+# account CURRENCY [TYPE] - opens an account for the run's customer and prints its ID
+account() {
+    request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"${2:-checking}\", \"currency\": \"$1\"}" "${ADMIN[@]}"
+    field "['account']['id']"
+}
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "$ADMIN_USER")")
+request POST "$V1/admin/products" "{\"account_type\": \"$PRODUCT\", \"name\": \"Money test account\"}" "${ADMIN[@]}"
+request POST "$V1/customers" "{\"first_name\": \"Minor\", \"last_name\": \"Units\", \"email\": \"money-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\", \"monthly_income\": 10000}" "${ADMIN[@]}"
+CUSTOMER=$(field "['customer']['id']")
+USD=$(account USD)
+EUR=$(account EUR)
+YEN=$(account JPY)
+FEES=$(account USD "$PRODUCT")
+check "accounts are opened in three currencies" "s == 201"
+
+echo
+echo "Minor units"
+post "$YEN" deposit 1000.4
+check "a yen posting is rounded to whole yen" "s == 201 and b['transaction']['amount'] == 1000 and b['transaction']['balance_after'] == 1000"
+request GET "$V1/accounts/$YEN" "" "${ADMIN[@]}"
+check "and displayed without decimals" "b['display_balance'] == '¥1,000'"
+post "$YEN" deposit 0.4
+check "a posting that rounds to nothing is refused" "s == 400 and b['code'] == 'ZERO_AMOUNT'"
+post "$USD" deposit 10.005
+check "a dollar posting is rounded half away from zero to cents" "s == 201 and b['transaction']['amount'] == 10.01"
+post "$USD" deposit 0.004
+check "and refused when no cents are left" "s == 400"
+
+AMOUNTS=$(python3 -c "import random; print(' '.join('%d.%02d' % (random.randint(0, 500), random.randint(0, 99)) for _ in range(40)))")
+for amount in $AMOUNTS; do
+    post "$EUR" deposit "$amount"
+done
+EXPECTED=$(python3 -c "import sys; from decimal import Decimal; print(sum(Decimal(a) for a in sys.argv[1:]))" $AMOUNTS)
+request GET "$V1/accounts/$EUR" "" "${ADMIN[@]}"
+check "a balance is the exact sum of its postings" "'%.2f' % b['balance'] == '$EXPECTED' and b['display_balance'].startswith('€')"
+
+echo
+echo "Percentage fees"
+request POST "$V1/admin/fee-schedules" "{\"name\": \"Percent fee\", \"account_type\": \"$PRODUCT\", \"currency\": \"USD\", \"transaction_type\": \"withdrawal\", \"percent\": 1.75, \"effective_from\": \"2020-01-01\"}" "${ADMIN[@]}"
+check "a percentage schedule is created" "s == 201"
+post "$FEES" deposit 100000
+MISMATCHES=0
+for amount in $(python3 -c "import random; print(' '.join('%d.%02d' % (random.randint(1, 999), random.randint(0, 99)) for _ in range(20)))"); do
+    post "$FEES" withdrawal "$amount"
+    python3 -c "
+import json, sys
+from decimal import Decimal, ROUND_HALF_UP
+b = json.loads(sys.argv[1])
+expected = (Decimal(sys.argv[2]) * Decimal('1.75') / 100).quantize(Decimal('0.01'), rounding=ROUND_HALF_UP)
+sys.exit(0 if Decimal(str(b['fee']['amount'])) == expected else 1)" "$BODY" "$amount" || MISMATCHES=$((MISMATCHES + 1))
+done
+check "each fee is the percentage rounded half away from zero to cents" "$MISMATCHES == 0"
+
+echo
+echo "Currency guards"
+post "$USD" deposit 500
+request POST "$V1/transfers" "{\"from_account_id\": $USD, \"to_account_id\": $EUR, \"amount\": 10}" "${ADMIN[@]}"
+check "a transfer between currencies is refused" "s == 400 and 'different currencies' in b['error']"
+request POST "$V1/loans?disburse=false" "{\"customer_id\": $CUSTOMER, \"principal_amount\": 1000, \"interest_rate\": 0.05, \"loan_term\": 12}" "${ADMIN[@]}"
+LOAN=$(field "['loan']['id']")
+request POST "$V1/loans/$LOAN/disburse" "" "${ADMIN[@]}"
+request POST "$V1/loans/$LOAN/payments" "{\"account_id\": $EUR, \"amount\": 20}" "${ADMIN[@]}"
+check "a loan cannot be paid from an account in another currency" "s == 400 and b['code'] == 'LOAN_CURRENCY_MISMATCH'"
+request POST "$V1/loans/$LOAN/payments" "{\"account_id\": $USD, \"amount\": 20.004}" "${ADMIN[@]}"
+check "a payment in the loan's currency is applied in cents" \
+    "s == 201 and b['payment']['amount'] == 20 and round(b['payment']['interest_portion'] + b['payment']['principal_portion'], 2) == 20"
+
+finish "money"
@@ -0,0 +1,142 @@
+#!/bin/bash
+
+# New Account Review Tests
+# Checks that with the new_account_review flag on, the first withdrawal or transfer above the threshold from a new
+# account is answered with 202 pending_review and an estimated review time on v1 and v2 instead of posting, that
+# deposits, small debits and debits from older accounts post at once, that the held amount leaves the available
+# funds, that a retried transfer finds its review, that staff approval posts it once and not by its requester, that
+# declining releases the hold and emails the customer, that only the first large debit is held, that the
+# transaction-reviews job approves debits whose review time passed, and that nothing is held with the flag off.
+# Each run creates its own tenant; the platform admin is created with bankctl, account ages and review times are
+# moved back in the server's database, so DB_PATH must be the database the server uses. The flag is global, so it
+# is put back as it was on exit. The server must run with the default rule (NEW_ACCOUNT_REVIEW_* unset). Exits
+# non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-new-account-reviews.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-new-account-reviews.sh
+
+source "$(dirname "$0")/test-lib.sh"
+V2="$BASE_URL/api/v2"
+PASSWORD="review-test-$RUN_ID-Aa1!"
+PLATFORM_USER="review-platform-$RUN_ID"
+TENANT_CODE="rev$RUN_ID"
+
+echo " New Account Review Tests"
+echo "========================="
+
+# account DEPOSIT - opens a checking account for the run's customer with an opening deposit and stores its ID in ACCOUNT
+account() {
+    request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+    ACCOUNT=$(field "['account']['id']")
+    request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": $1}" "${AUTH[@]}"
+}
+
+# debit ACCOUNT AMOUNT - posts a withdrawal as the tenant admin
+debit() {
+    request POST "$V1/transactions" "{\"account_id\": $1, \"transaction_type\": \"withdrawal\", \"amount\": $2}" "${AUTH[@]}"
+}
+
+# balance ACCOUNT - prints an account's balance as a whole number
+balance() {
+    request GET "$V1/accounts/$1/balance" "" "${AUTH[@]}"
+    field "['balance']" | sed 's/\.0$//'
+}
+
+# flag ENABLED - turns the new_account_review flag on or off
+flag() {
+    request PUT "$V1/admin/flags/new_account_review" "{\"enabled\": $1}" "${PLATFORM[@]}"
+}
+
+echo "Setup"
+PLATFORM=(-H "Authorization: Bearer $(staff "$PLATFORM_USER")")
+CHECKER=("${PLATFORM[@]}" -H "X-Tenant: $TENANT_CODE")
+request GET "$V1/admin/flags" "" "${PLATFORM[@]}"
+WAS_ENABLED=$(python3 -c "import json, sys; print(json.dumps([f['enabled'] for f in json.loads(sys.argv[1])['flags'] if f['key'] == 'new_account_review'][0]))" "$BODY")
+on_exit 'flag "$WAS_ENABLED"'
+flag true
+check "the flag turns the rule on" "s == 200 and b['flag']['enabled']"
+request POST "$V1/admin/tenants" "{\"code\": \"$TENANT_CODE\", \"name\": \"Reviews $RUN_ID\", \"admin\": {\"username\": \"review-admin\", \"password\": \"$PASSWORD\"}}" "${PLATFORM[@]}"
+check "a tenant is created for the run" "s == 201"
+AUTH=(-H "Authorization: Bearer $(token "review-admin" -H "X-Tenant: $TENANT_CODE")")
+request POST "$V1/customers" "{\"first_name\": \"New\", \"last_name\": \"Client\", \"email\": \"review-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+CUSTOMER=$(field "['customer']['id']")
+sql "UPDATE customers SET email_verified = 1 WHERE id = $CUSTOMER" > /dev/null
+account 1000
+check "deposits are never held" "s == 201"
+NEW=$ACCOUNT
+account 10
+DESTINATION=$ACCOUNT
+account 1000
+OLD=$ACCOUNT
+sql "UPDATE accounts SET created_at = datetime('now', '-60 days') WHERE id = $OLD" > /dev/null
+
+echo
+echo "Holding"
+debit "$NEW" 100
+check "a debit under the threshold posts" "s == 201"
+debit "$OLD" 400
+check "an account older than the review period is not held" "s == 201"
+debit "$NEW" 400
+check "the first large withdrawal is pending review" "s == 202 and b['status'] == 'pending_review' and b['review']['status'] == 'pending_review' and b['review']['amount'] == 400"
+check "the response gives an estimated review time" "b['estimated_review_by'] == b['review']['review_by'] and 'review' in b['message']"
+WITHDRAWAL=$(field "['review']['id']")
+check "nothing is debited while it waits" "$(balance "$NEW") == 900"
+request POST "$V2/transfers" "{\"from_account_id\": $NEW, \"to_account_id\": $DESTINATION, \"amount\": \"300.00\"}" "${AUTH[@]}" -H "Idempotency-Key: review-$RUN_ID"
+check "v2 holds a large transfer with a decimal amount" "s == 202 and b['data']['status'] == 'pending_review' and b['data']['kind'] == 'transfer' and b['data']['amount'] == '300.00' and b['data']['estimated_review_by']"
+TRANSFER=$(field "['data']['id']")
+request POST "$V2/transfers" "{\"from_account_id\": $NEW, \"to_account_id\": $DESTINATION, \"amount\": \"300.00\"}" "${AUTH[@]}" -H "Idempotency-Key: review-$RUN_ID"
+check "a retried transfer finds its review" "s == 202 and b['data']['id'] == $TRANSFER"
+debit "$NEW" 240
+check "held amounts are not available to other debits" "s == 400 and 'Insufficient' in b['error']"
+debit "$NEW" 260
+check "a large debit is only held when the funds cover it" "s == 400 and 'Insufficient' in b['error']"
+request POST "$V1/transfers" "{\"from_account_id\": $NEW, \"to_account_id\": 999999999, \"amount\": 260}" "${AUTH[@]}"
+check "a transfer that could never post is refused, not held" "s == 404"
+
+echo
+echo "Review queue"
+request GET "$V1/operations/reviews?account_id=$NEW"
+check "the queue needs staff" "s == 401"
+request GET "$V1/operations/reviews?account_id=$NEW" "" "${AUTH[@]}"
+check "staff see the held debits posting soonest first" "s == 200 and b['total'] == 2 and [r['id'] for r in b['reviews']] == [$WITHDRAWAL, $TRANSFER]"
+request POST "$V1/operations/reviews/$WITHDRAWAL/approve" "{}" "${AUTH[@]}"
+check "the requester cannot approve their own debit" "s == 403 and b['code'] == 'SAME_USER_DECISION'"
+request POST "$V1/operations/reviews/$WITHDRAWAL/approve" "{\"note\": \"Called the customer\"}" "${CHECKER[@]}"
+check "approval posts the debit" "s == 200 and b['review']['status'] == 'approved' and b['transaction']['amount'] == 400 and b['review']['transaction_id'] == b['transaction']['id']"
+check "the approval is not automatic" "not b['review']['auto_approved'] and b['review']['decided_by'] == '$PLATFORM_USER'"
+check "the account was debited once" "$(balance "$NEW") == 500"
+request POST "$V1/operations/reviews/$WITHDRAWAL/approve" "{}" "${CHECKER[@]}"
+check "a decided review cannot be approved again" "s == 409 and b['code'] == 'REVIEW_DECIDED'"
+request POST "$V1/operations/reviews/$TRANSFER/decline" "{}" "${CHECKER[@]}"
+check "declining needs a note" "s == 400"
+request POST "$V1/operations/reviews/$TRANSFER/decline" "{\"note\": \"Destination unverified\"}" "${CHECKER[@]}"
+check "a declined transfer is not posted" "s == 200 and b['review']['status'] == 'declined' and 'transfer_id' not in b['review']"
+check "the customer is emailed about the decline" "'$(sql "SELECT COUNT(*) FROM notifications WHERE resource_type = 'transaction_review' AND resource_id = $TRANSFER AND recipient = 'review-$RUN_ID@example.com'")' == '1'"
+check "the destination was not credited" "$(balance "$DESTINATION") == 10"
+debit "$NEW" 450
+check "the declined hold is released and later large debits post" "s == 201 and $(balance "$NEW") == 50"
+
+echo
+echo "Automatic approval"
+account 1000
+LATER=$ACCOUNT
+request POST "$V1/transfers" "{\"from_account_id\": $LATER, \"to_account_id\": $DESTINATION, \"amount\": 500}" "${AUTH[@]}"
+check "v1 holds a large transfer" "s == 202 and b['status'] == 'pending_review' and b['review']['kind'] == 'transfer'"
+AUTO=$(field "['review']['id']")
+run_job transaction-reviews
+request GET "$V1/operations/reviews/$AUTO" "" "${AUTH[@]}"
+check "the job leaves debits inside their review time" "s == 200 and b['review']['status'] == 'pending_review'"
+sql "UPDATE transaction_reviews SET review_by = '2000-01-01 00:00:00+00:00' WHERE id = $AUTO" > /dev/null
+run_job transaction-reviews
+request GET "$V1/operations/reviews/$AUTO" "" "${AUTH[@]}"
+check "the job approves debits past their review time" "s == 200 and b['review']['status'] == 'approved' and b['review']['auto_approved'] and b['review']['decided_by'] == 'system' and b['review']['transfer_id']"
+check "the transfer posted to both accounts" "$(balance "$LATER") == 500 and $(balance "$DESTINATION") == 510"
+
+echo
+echo "Flag off"
+flag false
+account 1000
+debit "$ACCOUNT" 400
+check "nothing is held with the flag off" "s == 201"
+
+finish "new account review"
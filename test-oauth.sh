@@ -0,0 +1,114 @@
+#!/bin/bash
+
+# OAuth2 Integration Tests
+# Walks the authorization-code flow end to end against a running server: client registration, a customer
+# approving the client, code exchange, consent-limited reads, introspection and revocation. Also checks the
+# client-credentials grant. Users are created with bankctl against the server's database. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-oauth.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-oauth.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="oauth-test-$RUN_ID"
+REDIRECT="https://partner.example/callback"
+FORM=1
+
+echo " OAuth2 Integration Tests"
+echo "========================="
+
+# query_param URL NAME - prints one query parameter of a URL
+query_param() {
+    python3 -c "import sys, urllib.parse; print(urllib.parse.parse_qs(urllib.parse.urlparse(sys.argv[1]).query).get(sys.argv[2], [''])[0])" "$1" "$2"
+}
+
+echo "Setup"
+request POST "$V1/customers" "{\"first_name\": \"OAuth\", \"last_name\": \"Test\", \"email\": \"oauth-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}"
+check "customer created" "s == 201"
+CUSTOMER_ID=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER_ID, \"account_type\": \"checking\"}"
+ACCOUNT=$(field "['account']['id']")
+request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 250}"
+request POST "$V1/customers" "{\"first_name\": \"Other\", \"last_name\": \"Customer\", \"email\": \"oauth-other-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}"
+OTHER_ID=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $OTHER_ID, \"account_type\": \"checking\"}"
+OTHER_ACCOUNT=$(field "['account']['id']")
+
+ADMIN=(-H "Authorization: Bearer $(staff "oauth-admin-$RUN_ID")")
+CUSTOMER=(-H "Authorization: Bearer $(customer_user "oauth-customer-$RUN_ID" "$CUSTOMER_ID")")
+
+echo "Client registration"
+request POST "$V1/admin/oauth/clients" "{\"name\": \"Budget App\", \"redirect_uris\": \"$REDIRECT\", \"scopes\": \"accounts:read,balances:read,certificates:verify\"}" "${ADMIN[@]}"
+check "admin registers a client and sees its secret once" "s == 201 and b['client_secret'] and 'secret_hash' not in b['client']"
+CLIENT_ID=$(field "['client']['client_id']")
+CLIENT_SECRET=$(field "['client_secret']")
+CLIENT=(-u "$CLIENT_ID:$CLIENT_SECRET")
+request POST "$V1/admin/oauth/clients" "{\"name\": \"Bad\", \"scopes\": \"payments:write\"}" "${ADMIN[@]}"
+check "unknown scopes are refused" "s == 400"
+request POST "$V1/admin/oauth/clients" "{\"name\": \"Sneaky\", \"scopes\": \"accounts:read\"}" "${CUSTOMER[@]}"
+check "customers cannot register clients" "s == 403"
+
+echo "Authorization code flow"
+request GET "$V1/oauth/authorize?response_type=code&client_id=$CLIENT_ID&redirect_uri=$REDIRECT&scope=balances:read&state=xyz"
+check "authorization request is described for the approval screen" "s == 200 and b['client']['name'] == 'Budget App' and b['scopes'] == ['balances:read']"
+request GET "$V1/oauth/authorize?response_type=code&client_id=$CLIENT_ID&redirect_uri=https://evil.example/&scope=balances:read"
+check "unregistered redirect URIs are refused" "s == 400 and b['error'] == 'invalid_request'"
+request POST "$V1/oauth/authorize" "{\"client_id\": \"$CLIENT_ID\", \"scope\": \"balances:read\", \"state\": \"xyz\", \"approve\": false}" "${CUSTOMER[@]}"
+check "denying redirects with access_denied" "s == 200 and 'error=access_denied' in b['redirect_to'] and 'state=xyz' in b['redirect_to']"
+request POST "$V1/oauth/authorize" "{\"client_id\": \"$CLIENT_ID\", \"scope\": \"balances:read\", \"approve\": true}" "${ADMIN[@]}"
+check "only customer users can approve" "s == 403 and b['error'] == 'access_denied'"
+request POST "$V1/oauth/authorize" "{\"client_id\": \"$CLIENT_ID\", \"redirect_uri\": \"$REDIRECT\", \"scope\": \"balances:read\", \"state\": \"xyz\", \"approve\": true}" "${CUSTOMER[@]}"
+check "approving records a consent and redirects with a code" "s == 200 and b['consent']['customer_id'] == $CUSTOMER_ID and 'code=' in b['redirect_to'] and 'state=xyz' in b['redirect_to']"
+CODE=$(query_param "$(field "['redirect_to']")" code)
+CONSENT_ID=$(field "['consent']['id']")
+
+request POST "$V1/oauth/token" "grant_type=authorization_code&code=$CODE&redirect_uri=$REDIRECT" -u "$CLIENT_ID:wrong"
+check "a wrong client secret is refused" "s == 401 and b['error'] == 'invalid_client'"
+request POST "$V1/oauth/token" "grant_type=authorization_code&code=$CODE&redirect_uri=https://partner.example/other" "${CLIENT[@]}"
+check "the code is bound to its redirect URI" "s == 400 and b['error'] == 'invalid_grant'"
+request POST "$V1/oauth/token" "grant_type=authorization_code&code=$CODE&redirect_uri=$REDIRECT" "${CLIENT[@]}"
+check "the code is exchanged for a customer token" "s == 200 and b['token_type'] == 'Bearer' and b['scope'] == 'balances:read' and b['expires_in'] > 0"
+ACCESS_TOKEN=$(field "['access_token']")
+TOKEN=(-H "Authorization: Bearer $ACCESS_TOKEN")
+request POST "$V1/oauth/token" "grant_type=authorization_code&code=$CODE&redirect_uri=$REDIRECT" "${CLIENT[@]}"
+check "a code works only once" "s == 400 and b['error'] == 'invalid_grant'"
+
+echo "Consent-limited access"
+request GET "$V1/auth/me" "" "${TOKEN[@]}"
+check "routes outside the consent scopes are closed to the client" "s == 403 and b['code'] == 'CONSENT_REQUIRED'"
+request GET "$V1/accounts/$ACCOUNT/balance" "" "${TOKEN[@]}"
+check "the client reads the consented balance" "s == 200 and b['balance'] == 250"
+request GET "$V1/accounts/$ACCOUNT" "" "${TOKEN[@]}"
+check "scopes the customer did not approve are refused" "s == 403 and b['code'] == 'CONSENT_REQUIRED'"
+request GET "$V1/accounts/$OTHER_ACCOUNT/balance" "" "${TOKEN[@]}"
+check "other customers' data is refused" "s == 403 and b['code'] == 'CONSENT_REQUIRED'"
+request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"withdrawal\", \"amount\": 10}" "${TOKEN[@]}"
+check "client tokens cannot make changes" "s == 403"
+request POST "$V1/oauth/introspect" "token=$ACCESS_TOKEN" "${CLIENT[@]}"
+check "introspection reports the token active for the customer" "s == 200 and b['active'] and b['customer_id'] == $CUSTOMER_ID and b['consent_id'] == $CONSENT_ID"
+request GET "$V1/admin/audit-log?consent_id=$CONSENT_ID" "" "${ADMIN[@]}"
+check "reads are audited under the consent" "s == 200 and any(e['route'] == '/api/v1/accounts/:id/balance' for e in b['entries'])"
+
+echo "Client credentials"
+request POST "$V1/oauth/token" "grant_type=client_credentials&client_id=$CLIENT_ID&client_secret=$CLIENT_SECRET"
+check "the client gets a service token" "s == 200 and b['scope'] == 'certificates:verify'"
+SERVICE=(-H "Authorization: Bearer $(field "['access_token']")")
+request POST "$V1/oauth/token" "grant_type=client_credentials&scope=balances:read" "${CLIENT[@]}"
+check "customer scopes need the code flow" "s == 400 and b['error'] == 'invalid_scope'"
+request GET "$V1/certificates/verify/NOSUCHCODE" "" "${SERVICE[@]}"
+check "the service token reaches its scope's route" "s != 401 and s != 403"
+request GET "$V1/accounts/$ACCOUNT/balance" "" "${SERVICE[@]}"
+check "the service token reaches no customer data" "s == 403"
+
+echo "Revocation"
+request POST "$V1/customers/$CUSTOMER_ID/consents/$CONSENT_ID/revoke" "" "${CUSTOMER[@]}"
+check "the customer revokes the consent" "s == 200 and b['consent']['status'] == 'revoked'"
+request GET "$V1/accounts/$ACCOUNT/balance" "" "${TOKEN[@]}"
+check "revoking cuts access off at once" "s == 403"
+request POST "$V1/oauth/introspect" "token=$ACCESS_TOKEN" "${CLIENT[@]}"
+check "introspection reports the token inactive" "s == 200 and b == {'active': False}"
+request DELETE "$V1/admin/oauth/clients/$CLIENT_ID" "" "${ADMIN[@]}"
+check "admin deletes the client" "s == 200"
+request GET "$V1/certificates/verify/NOSUCHCODE" "" "${SERVICE[@]}"
+check "a deleted client's tokens stop working" "s == 401"
+
+finish "OAuth"
@@ -0,0 +1,246 @@
+#!/bin/bash
+
+# Product Conversion Tests
+# Checks that catalog products validate their category and fee, that an account converts only to an open product
+# of its category and never to or from a reserved type, that the customer must be eligible for the target unless
+# staff waive the criterion, that an immediate conversion applies the product's limit and restrictions, records the
+# change with the month's fee shares, emails the holder and shows on the activity feed, that a change scheduled for
+# the next statement cycle can be cancelled and is applied, or cancelled once the account is closed, by the
+# product-changes job, that the maintenance-fees job charges last month's fee pro-rated by days on each product once,
+# and that a deprecated product opens no accounts while a bulk operation converts its accounts with per-account
+# results. Each run creates its own tenant; the platform admin is created with bankctl, and accounts and changes are
+# moved back in the server's database, so DB_PATH must be the database the server uses. The server must run with
+# BANK_TIMEZONE unset. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-product-conversion.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-product-conversion.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="conv-test-$RUN_ID-Aa1!"
+PLATFORM_USER="conv-platform-$RUN_ID"
+TENANT_CODE="conv$RUN_ID"
+
+echo " Product Conversion Tests"
+echo "========================="
+
+# dates - prints today, the first day of this month and of last month, and the days in last month (UTC)
+read -r TODAY THIS_MONTH LAST_MONTH LAST_MONTH_DAYS NEXT_MONTH < <(python3 -c "
+import datetime
+today = datetime.datetime.now(datetime.timezone.utc).date()
+this = today.replace(day=1)
+last = (this - datetime.timedelta(days=1)).replace(day=1)
+nxt = (this + datetime.timedelta(days=32)).replace(day=1)
+print(today, this, last, (this - last).days, nxt)")
+
+# account CUSTOMER TYPE - opens an account of TYPE with 100 deposited and stores its ID in ACCOUNT
+account() {
+    request POST "$V1/accounts" "{\"customer_id\": $1, \"account_type\": \"$2\"}" "${AUTH[@]}"
+    ACCOUNT=$(field "['account']['id']")
+    request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 100}" "${AUTH[@]}"
+}
+
+# customer NAME - creates a customer with a verified email and stores their ID in CUSTOMER
+customer() {
+    request POST "$V1/customers" "{\"first_name\": \"$1\", \"last_name\": \"Switcher\", \"email\": \"conv-$1-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+    CUSTOMER=$(field "['customer']['id']")
+    sql "UPDATE customers SET email_verified = 1 WHERE id = $CUSTOMER" > /dev/null
+}
+
+# convert ACCOUNT BODY [CURL_ARGS...] - asks for a product conversion
+convert() {
+    local id=$1 body=$2
+    shift 2
+    request POST "$V1/accounts/$id/convert-product" "$body" "$@"
+}
+
+echo "Setup"
+tenant "$PLATFORM_USER" "Conversions" conv-admin
+
+request POST "$V1/admin/products" "{\"account_type\": \"checking\", \"name\": \"Everyday Checking\", \"category\": \"savings\"}" "${AUTH[@]}"
+check "a standard type is its own category" "s == 400 and 'must be checking' in b['error']"
+request POST "$V1/admin/products" "{\"account_type\": \"premium_checking\", \"name\": \"Premium\", \"monthly_fee\": -1}" "${AUTH[@]}"
+check "a negative monthly fee is refused" "s == 400"
+request POST "$V1/admin/products" "{\"account_type\": \"checking\", \"name\": \"Everyday Checking\", \"monthly_fee\": 5}" "${AUTH[@]}"
+check "checking is given a monthly fee" "s == 201 and b['product']['monthly_fee'] == 5"
+request POST "$V1/admin/products" "{\"account_type\": \"premium_checking\", \"name\": \"Premium Checking\", \"category\": \"Checking\", \"monthly_fee\": 15, \"overdraft_limit\": 500, \"max_per_customer\": 1, \"restrictions\": {\"no_outbound_transfers\": true}}" "${AUTH[@]}"
+check "a premium checking product is created in the checking category" "s == 201 and b['product']['category'] == 'checking' and b['product']['overdraft_limit'] == 500"
+PREMIUM_PRODUCT=$(field "['product']['id']")
+request POST "$V1/admin/products" "{\"account_type\": \"premium_savings\", \"name\": \"Premium Savings\", \"category\": \"savings\"}" "${AUTH[@]}"
+check "a premium savings product is created" "s == 201"
+request POST "$V1/admin/products" "{\"account_type\": \"basic_checking\", \"name\": \"Basic Checking\", \"category\": \"checking\", \"monthly_fee\": 2}" "${AUTH[@]}"
+check "a basic checking product is created" "s == 201"
+BASIC_PRODUCT=$(field "['product']['id']")
+
+customer Upgrader
+UPGRADER=$CUSTOMER
+account "$UPGRADER" checking
+CHECKING=$ACCOUNT
+account "$UPGRADER" checking
+SECOND=$ACCOUNT
+
+echo
+echo "Validation"
+convert "$CHECKING" "{}" "${AUTH[@]}"
+check "the target product is required" "s == 400"
+convert "$CHECKING" "{\"account_type\": \"premium_checking\"}"
+check "converting needs a login" "s == 401"
+convert 999999999 "{\"account_type\": \"premium_checking\"}" "${AUTH[@]}"
+check "an unknown account is not found" "s == 404"
+convert "$CHECKING" "{\"account_type\": \"loan\"}" "${AUTH[@]}"
+check "nothing converts to a loan" "s == 400 and b['code'] == 'RESERVED_ACCOUNT_TYPE'"
+convert "$CHECKING" "{\"account_type\": \"gold\"}" "${AUTH[@]}"
+check "a type outside the catalog is refused" "s == 400 and 'not in the catalog' in b['error']"
+convert "$CHECKING" "{\"account_type\": \"checking\"}" "${AUTH[@]}"
+check "the account's own product is refused" "s == 400"
+convert "$CHECKING" "{\"account_type\": \"savings\"}" "${AUTH[@]}"
+check "checking does not become savings" "s == 422 and b['code'] == 'INCOMPATIBLE_PRODUCT' and b['from_category'] == 'checking' and b['to_category'] == 'savings'"
+convert "$CHECKING" "{\"account_type\": \"premium_savings\"}" "${AUTH[@]}"
+check "nor a savings category product" "s == 422 and b['code'] == 'INCOMPATIBLE_PRODUCT'"
+
+echo
+echo "Immediate conversion"
+convert "$CHECKING" "{\"account_type\": \"premium_checking\", \"reason\": \"Upgrade $RUN_ID\"}" "${AUTH[@]}"
+check "the account is converted" "s == 200 and b['product_change']['status'] == 'applied' and b['product_change']['from_type'] == 'checking' and b['product_change']['to_type'] == 'premium_checking'"
+check "the change records the parameters before and after" \
+    "b['product_change']['previous_overdraft_limit'] == 0 and b['product_change']['overdraft_limit'] == 500 and b['product_change']['previous_monthly_fee'] == 5 and b['product_change']['monthly_fee'] == 15"
+SHARES=$(python3 -c "
+import datetime
+today, days = datetime.date.fromisoformat('$TODAY'), ($(python3 -c "import datetime; t = datetime.date.fromisoformat('$THIS_MONTH'); print((datetime.date.fromisoformat('$NEXT_MONTH') - t).days)"))
+print([0, round(15 * (days - today.day + 1) / days, 2)])")
+check "an account opened today pays only the new product's share of the month" "[b['product_change']['previous_fee_share'], b['product_change']['fee_share']] == $SHARES"
+check "the change records who asked and why" "b['product_change']['requested_by'] == 'conv-admin' and b['product_change']['reason'] == 'Upgrade $RUN_ID' and b['product_change']['effective_date'][:10] == '$TODAY'"
+CHANGE=$(field "['product_change']['id']")
+request GET "$V1/accounts/$CHECKING" "" "${AUTH[@]}"
+check "the account takes the product's limit and restrictions" \
+    "s == 200 and b['account_type'] == 'premium_checking' and b['overdraft_limit'] == 500 and b['restrictions']['no_outbound_transfers']"
+check "the days before are left to the old product's rates" "(b['interest_accrued_through'] or '')[:10] == '$TODAY'"
+check "the holder is emailed" "$(sql "SELECT COUNT(*) FROM notifications WHERE resource_type = 'product_change' AND resource_id = $CHANGE AND customer_id = $UPGRADER") == 1"
+BODY_TEXT=$(sql "SELECT body FROM notifications WHERE resource_type = 'product_change' AND resource_id = $CHANGE")
+check "the email names both products and the new fee" "'Everyday Checking' in '''$BODY_TEXT''' and 'Premium Checking' in '''$BODY_TEXT''' and '\$15.00' in '''$BODY_TEXT'''"
+request GET "$V1/accounts/$CHECKING/activity?types=status" "" "${AUTH[@]}"
+check "the change is on the activity feed" "len([i for i in b['items'] if i['title'] == 'Account product changed']) == 1"
+request GET "$V1/accounts/$CHECKING/product-changes" "" "${AUTH[@]}"
+check "the history lists the change" "s == 200 and [c['id'] for c in b['product_changes']] == [$CHANGE] and b['account_type'] == 'premium_checking'"
+
+echo
+echo "Eligibility"
+convert "$SECOND" "{\"account_type\": \"premium_checking\"}" "${AUTH[@]}"
+check "a customer holding the most premium accounts allowed is refused" \
+    "s == 422 and [f['criterion'] for f in b['failed_criteria']] == ['max_per_customer']"
+convert "$SECOND" "{\"account_type\": \"premium_checking\", \"overrides\": [{\"criterion\": \"max_per_customer\"}]}" "${AUTH[@]}"
+check "a waiver needs a reason" "s == 400"
+convert "$SECOND" "{\"account_type\": \"premium_checking\", \"overrides\": [{\"criterion\": \"max_per_customer\", \"reason\": \"Joint household\"}]}" "${AUTH[@]}"
+check "staff can waive the criterion" "s == 200 and b['eligibility_overrides'][0]['account_id'] == $SECOND and b['eligibility_overrides'][0]['account_type'] == 'premium_checking'"
+convert "$SECOND" "{\"account_type\": \"checking\"}" "${AUTH[@]}"
+check "an account converts back within its category" "s == 200 and b['product_change']['overdraft_limit'] == 500 and b['product_change']['previous_overdraft_limit'] == 500"
+
+echo
+echo "Next statement cycle"
+customer Planner
+PLANNER=$CUSTOMER
+account "$PLANNER" checking
+PLANNED=$ACCOUNT
+account "$PLANNER" checking
+CLOSING=$ACCOUNT
+convert "$PLANNED" "{\"account_type\": \"premium_checking\", \"next_cycle\": true}" "${AUTH[@]}"
+check "a change for the next cycle is scheduled" "s == 202 and b['product_change']['status'] == 'scheduled' and b['product_change']['effective_date'][:10] == '$NEXT_MONTH'"
+check "the new product has the whole next month" "b['product_change']['previous_fee_share'] == 0 and b['product_change']['fee_share'] == 15"
+SCHEDULED=$(field "['product_change']['id']")
+check "the holder is told ahead" "'$NEXT_MONTH' in '''$(sql "SELECT body FROM notifications WHERE resource_type = 'product_change' AND resource_id = $SCHEDULED")'''"
+request GET "$V1/accounts/$PLANNED" "" "${AUTH[@]}"
+check "the account keeps its product until then" "b['account_type'] == 'checking'"
+convert "$PLANNED" "{\"account_type\": \"premium_checking\"}" "${AUTH[@]}"
+check "an account has one scheduled change at a time" "s == 409"
+request DELETE "$V1/accounts/$PLANNED/product-changes/$SCHEDULED" "" "${AUTH[@]}"
+check "the scheduled change is cancelled" "s == 200 and b['product_change']['status'] == 'cancelled' and b['product_change']['note'] == 'Cancelled by conv-admin'"
+request DELETE "$V1/accounts/$PLANNED/product-changes/$SCHEDULED" "" "${AUTH[@]}"
+check "a cancelled change cannot be cancelled again" "s == 409"
+request DELETE "$V1/accounts/$CHECKING/product-changes/$CHANGE" "" "${AUTH[@]}"
+check "an applied change cannot be cancelled" "s == 409"
+convert "$PLANNED" "{\"account_type\": \"premium_checking\", \"next_cycle\": true}" "${AUTH[@]}"
+SCHEDULED=$(field "['product_change']['id']")
+convert "$CLOSING" "{\"account_type\": \"basic_checking\", \"next_cycle\": true}" "${AUTH[@]}"
+check "another account is scheduled" "s == 202"
+DOOMED=$(field "['product_change']['id']")
+request POST "$V1/transactions" "{\"account_id\": $CLOSING, \"transaction_type\": \"withdrawal\", \"amount\": 100}" "${AUTH[@]}"
+request POST "$V1/accounts/$CLOSING/close" "" "${AUTH[@]}"
+check "that account is closed" "s == 200"
+sql "UPDATE product_changes SET effective_date = '$TODAY 00:00:00+00:00' WHERE id IN ($SCHEDULED, $DOOMED)" > /dev/null
+run_job product-changes
+check "the product-changes job succeeds" "[r for r in b['runs'] if r['job_name'] == 'product-changes'][0]['status'] == 'succeeded'"
+request GET "$V1/accounts/$PLANNED/product-changes" "" "${AUTH[@]}"
+check "the due change is applied" "b['product_changes'][0]['id'] == $SCHEDULED and b['product_changes'][0]['status'] == 'applied' and b['account_type'] == 'premium_checking'"
+request GET "$V1/accounts/$CLOSING/product-changes" "" "${AUTH[@]}"
+check "the closed account's change is cancelled with why" "b['product_changes'][0]['status'] == 'cancelled' and 'closed' in b['product_changes'][0]['note'] and b['account_type'] == 'checking'"
+
+echo
+echo "Maintenance fees"
+customer Payer
+PAYER=$CUSTOMER
+account "$PAYER" checking
+SWITCHED=$ACCOUNT
+account "$PAYER" premium_checking
+LATE=$ACCOUNT
+account "$PAYER" savings
+FREE=$ACCOUNT
+convert "$SWITCHED" "{\"account_type\": \"premium_checking\", \"overrides\": [{\"criterion\": \"max_per_customer\", \"reason\": \"Test\"}]}" "${AUTH[@]}"
+SWITCH=$(field "['product_change']['id']")
+# SWITCHED opened on the 1st of last month and converted on the 11th; LATE opened on the 21st
+DAY11=$(python3 -c "import datetime; print(datetime.date.fromisoformat('$LAST_MONTH') + datetime.timedelta(days=10))")
+DAY21=$(python3 -c "import datetime; print(datetime.date.fromisoformat('$LAST_MONTH') + datetime.timedelta(days=20))")
+sql "UPDATE accounts SET created_at = '$LAST_MONTH 09:00:00+00:00' WHERE id IN ($SWITCHED, $FREE)" > /dev/null
+sql "UPDATE accounts SET created_at = '$DAY21 09:00:00+00:00' WHERE id = $LATE" > /dev/null
+sql "UPDATE product_changes SET effective_date = '$DAY11 00:00:00+00:00' WHERE id = $SWITCH" > /dev/null
+EXPECTED=$(python3 -c "
+days = $LAST_MONTH_DAYS
+print({'switched': round(round(5 * 10 / days, 2) + round(15 * (days - 10) / days, 2), 2), 'late': round(15 * (days - 20) / days, 2)})")
+run_job maintenance-fees
+check "the maintenance-fees job succeeds" "[r for r in b['runs'] if r['job_name'] == 'maintenance-fees'][0]['status'] == 'succeeded'"
+MONTH=$(echo "$LAST_MONTH" | tr -d '-' | cut -c1-6)
+FEES=$(sql "SELECT json_group_object(account_id, json_array(amount, reference, substr(effective_date, 1, 10), description)) FROM transactions WHERE transaction_type = 'fee' AND account_id IN ($SWITCHED, $LATE, $FREE, $CHECKING)")
+check "a converted account pays each product's fee for its days" "abs($FEES['$SWITCHED'][0] - $EXPECTED['switched']) < 0.001"
+check "the fee names both products" "'Everyday Checking 10 days' in $FEES['$SWITCHED'][3] and 'Premium Checking $((LAST_MONTH_DAYS - 10)) days' in $FEES['$SWITCHED'][3]"
+check "the fee is effective on the month's last day with a monthly reference" \
+    "$FEES['$SWITCHED'][1].startswith('MAINT-') and $FEES['$SWITCHED'][1].endswith('-$MONTH') and $FEES['$SWITCHED'][2] == '$(python3 -c "import datetime; print(datetime.date.fromisoformat('$THIS_MONTH') - datetime.timedelta(days=1))")'"
+check "an account opened mid-month pays from its opening" "abs($FEES['$LATE'][0] - $EXPECTED['late']) < 0.001"
+check "a product without a fee and an account opened this month are not charged" "'$FREE' not in $FEES and '$CHECKING' not in $FEES"
+run_job maintenance-fees
+check "rerunning charges nothing more" "$(sql "SELECT COUNT(*) FROM transactions WHERE transaction_type = 'fee' AND account_id IN ($SWITCHED, $LATE)") == 2"
+
+echo
+echo "Retiring a product"
+customer Basic
+BASIC=$CUSTOMER
+account "$BASIC" basic_checking
+BASIC_ONE=$ACCOUNT
+account "$BASIC" basic_checking
+BASIC_TWO=$ACCOUNT
+account "$BASIC" basic_checking
+BASIC_CLOSED=$ACCOUNT
+request POST "$V1/transactions" "{\"account_id\": $BASIC_CLOSED, \"transaction_type\": \"withdrawal\", \"amount\": 100}" "${AUTH[@]}"
+request POST "$V1/accounts/$BASIC_CLOSED/close" "" "${AUTH[@]}"
+request PUT "$V1/admin/products/$BASIC_PRODUCT" "{\"name\": \"Basic Checking\", \"category\": \"checking\", \"monthly_fee\": 2, \"deprecated\": true}" "${AUTH[@]}"
+check "the basic product is deprecated" "s == 200 and b['product']['deprecated']"
+request POST "$V1/accounts" "{\"customer_id\": $BASIC, \"account_type\": \"basic_checking\"}" "${AUTH[@]}"
+check "a deprecated product opens no accounts" "s == 400 and b['code'] == 'INVALID_ACCOUNT_TYPE' and 'basic_checking' not in b['allowed']"
+convert "$PLANNED" "{\"account_type\": \"basic_checking\"}" "${AUTH[@]}"
+check "nor takes conversions" "s == 400 and 'deprecated' in b['error']"
+request POST "$V1/admin/accounts/bulk-action" "{\"action\": \"convert_product\", \"filter\": {\"account_type\": \"basic_checking\"}}" "${AUTH[@]}"
+check "a bulk conversion needs a target" "s == 400"
+request POST "$V1/admin/accounts/bulk-action" "{\"action\": \"convert_product\", \"filter\": {\"account_type\": \"basic_checking\"}, \"target_type\": \"checking\", \"reason\": \"Basic retired\", \"dry_run\": true}" "${AUTH[@]}"
+check "a dry run counts the product's accounts" "s == 200 and b['affected'] == 3"
+request POST "$V1/admin/accounts/bulk-action" "{\"action\": \"convert_product\", \"filter\": {\"account_type\": \"basic_checking\"}, \"target_type\": \"checking\", \"reason\": \"Basic retired\"}" "${AUTH[@]}"
+check "the bulk conversion is queued" "s == 202 and b['operation']['target_type'] == 'checking'"
+OPERATION=$(field "['operation']['id']")
+for _ in $(seq 1 50); do
+    sleep 0.2
+    request GET "$V1/admin/bulk-operations/$OPERATION" "" "${AUTH[@]}"
+    [ "$(field "['operation']['status']" 2>/dev/null)" = "completed" ] && break
+done
+check "every account has a result" "b['operation']['status'] == 'completed' and b['operation']['succeeded'] == 2 and b['operation']['failed'] == 1"
+check "the closed account failed with why" "[(i['account_id'], i['result']) for i in b['items'] if 'closed' in i.get('error', '')] == [($BASIC_CLOSED, 'failed')]"
+request GET "$V1/accounts/$BASIC_ONE/product-changes" "" "${AUTH[@]}"
+check "the converted accounts record the operation" "b['account_type'] == 'checking' and b['product_changes'][0]['bulk_operation_id'] == $OPERATION and b['product_changes'][0]['reason'] == 'Basic retired'"
+check "both open accounts moved" "$(sql "SELECT COUNT(*) FROM accounts WHERE id IN ($BASIC_ONE, $BASIC_TWO) AND account_type = 'checking'") == 2"
+
+finish "product conversion"
@@ -0,0 +1,138 @@
+#!/bin/bash
+
+# Product Rate Change Tests
+# Checks that product rate changes are validated, that a decrease without the notice period is refused with the
+# earliest date allowed while an increase can take effect tomorrow, that changes must follow those already
+# scheduled, that scheduling emails holders with a verified email, that the rate history and the account detail
+# show the current and upcoming rates, and that the interest-accrual job accrues each day at the rate in force on
+# it, switching on the effective date exactly, posts the month's interest at month end and does nothing when rerun.
+# Each run creates its own tenant; the platform admin is created with bankctl, and the rates and the account are
+# moved back in the server's database, so DB_PATH must be the database the server uses. The server must run with
+# the default notice period (RATE_DECREASE_NOTICE_DAYS unset) and BANK_TIMEZONE unset. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-rate-changes.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-rate-changes.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="rate-test-$RUN_ID-Aa1!"
+PLATFORM_USER="rate-platform-$RUN_ID"
+TENANT_CODE="rate$RUN_ID"
+
+echo " Product Rate Change Tests"
+echo "=========================="
+
+# day OFFSET - prints today (UTC) moved by OFFSET days, as YYYY-MM-DD
+day() {
+    python3 -c "
+import datetime, sys
+print((datetime.datetime.now(datetime.timezone.utc).date() + datetime.timedelta(days=int(sys.argv[1]))).isoformat())" "$1"
+}
+
+# schedule RATE EFFECTIVE_DATE - schedules a change to the run's product rate
+schedule() {
+    request POST "$V1/admin/products/$PRODUCT/rates" "{\"rate\": $1, \"effective_date\": \"$2\", \"reason\": \"Test $RUN_ID\"}" "${AUTH[@]}"
+}
+
+# customer NAME - creates a customer with a savings account and stores their IDs in CUSTOMER and ACCOUNT
+customer() {
+    request POST "$V1/customers" "{\"first_name\": \"$1\", \"last_name\": \"Saver\", \"email\": \"rate-$1-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+    CUSTOMER=$(field "['customer']['id']")
+    request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"savings\"}" "${AUTH[@]}"
+    ACCOUNT=$(field "['account']['id']")
+}
+
+echo "Setup"
+tenant "$PLATFORM_USER" "Rates" rate-admin
+request POST "$V1/admin/products" "{\"account_type\": \"savings\", \"name\": \"Easy Saver\"}" "${AUTH[@]}"
+check "a savings product is created" "s == 201"
+PRODUCT=$(field "['product']['id']")
+customer Holder
+HOLDER=$CUSTOMER
+SAVINGS=$ACCOUNT
+sql "UPDATE customers SET email_verified = 1 WHERE id = $HOLDER" > /dev/null
+request POST "$V1/transactions" "{\"account_id\": $SAVINGS, \"transaction_type\": \"deposit\", \"amount\": 10000}" "${AUTH[@]}"
+check "the holder's savings are funded" "s == 201"
+customer Unverified
+UNVERIFIED=$CUSTOMER
+EMPTY=$ACCOUNT
+
+echo
+echo "Validation"
+schedule 1.5 "$(day 1)"
+check "a rate of 100% or more is refused" "s == 400 and b['code'] == 'INVALID_RATE_CHANGE'"
+schedule 0.0365 "$(day 0)"
+check "a change cannot take effect today" "s == 400 and b['code'] == 'INVALID_RATE_CHANGE'"
+schedule 0.0365 "tomorrow"
+check "an unparseable date is refused" "s == 400"
+request POST "$V1/admin/products/$PRODUCT/rates" "{\"effective_date\": \"$(day 1)\"}" "${AUTH[@]}"
+check "the rate is required" "s == 400"
+request POST "$V1/admin/products/999999999/rates" "{\"rate\": 0.01, \"effective_date\": \"$(day 1)\"}" "${AUTH[@]}"
+check "an unknown product is not found" "s == 404"
+request POST "$V1/admin/products/$PRODUCT/rates" "{\"rate\": 0.01, \"effective_date\": \"$(day 1)\"}"
+check "scheduling needs an admin" "s == 401"
+
+echo
+echo "Scheduling"
+schedule 0.0365 "$(day 1)"
+check "an increase can take effect tomorrow" "s == 201 and b['rate']['rate'] == 0.0365 and b['rate']['previous_rate'] == 0 and b['rate']['notice_days'] == 1"
+check "the change records who scheduled it and why" "b['rate']['scheduled_by'] == 'rate-admin' and b['rate']['reason'] == 'Test $RUN_ID'"
+check "only the holder with a verified email is notified" "b['rate']['notified'] == 1"
+INCREASE=$(field "['rate']['id']")
+schedule 0.0365 "$(day 5)"
+check "a change to the same rate is refused" "s == 400 and b['code'] == 'INVALID_RATE_CHANGE'"
+schedule 0.01825 "$(day 10)"
+check "a decrease without 30 days' notice is refused" "s == 422 and b['code'] == 'RATE_NOTICE_REQUIRED' and b['notice_days'] == 30"
+check "the refusal gives the earliest date allowed" "b['earliest_effective_date'] == '$(day 30)'"
+schedule 0.01825 "$(day 30)"
+check "a decrease with the full notice is scheduled" "s == 201 and b['rate']['previous_rate'] == 0.0365 and b['rate']['notice_days'] == 30 and b['rate']['notified'] == 1"
+DECREASE=$(field "['rate']['id']")
+schedule 0.05 "$(day 20)"
+check "a change before one already scheduled conflicts" "s == 409 and b['code'] == 'RATE_CHANGE_OUT_OF_ORDER'"
+check "the holder got one email per change" "$(sql "SELECT COUNT(*) FROM notifications WHERE resource_type = 'rate_change' AND customer_id = $HOLDER AND resource_id IN ($INCREASE, $DECREASE)") == 2"
+check "the holder without a verified email got none" "$(sql "SELECT COUNT(*) FROM notifications WHERE resource_type = 'rate_change' AND customer_id = $UNVERIFIED") == 0"
+BODY_TEXT=$(sql "SELECT body FROM notifications WHERE resource_type = 'rate_change' AND resource_id = $DECREASE")
+check "the notice gives both rates and the date" "'decrease from 3.65% to 1.825%' in '''$BODY_TEXT''' and '$(day 30)' in '''$BODY_TEXT''' and 'Easy Saver' in '''$BODY_TEXT'''"
+
+echo
+echo "History and account detail"
+request GET "$V1/admin/products/$PRODUCT/rates" "" "${AUTH[@]}"
+check "the history lists changes latest first" "s == 200 and [r['id'] for r in b['rates']] == [$DECREASE, $INCREASE]"
+check "no rate is in force before the first change" "b['current_rate'] == 0"
+request GET "$V1/accounts/$SAVINGS" "" "${AUTH[@]}"
+check "the account shows its upcoming rate" "s == 200 and 'interest_rate' not in b and b['upcoming_rate']['id'] == $INCREASE"
+
+echo
+echo "Accrual"
+# The account opens 20 days ago at 3.65% (1.00 a day on 10,000) and the decrease to 1.825% (0.50 a day) took effect 10 days ago
+sql "UPDATE product_rates SET effective_date = '$(day -20) 00:00:00+00:00' WHERE id = $INCREASE" > /dev/null
+sql "UPDATE product_rates SET effective_date = '$(day -10) 00:00:00+00:00' WHERE id = $DECREASE" > /dev/null
+sql "UPDATE accounts SET created_at = '$(day -20) 09:00:00+00:00' WHERE tenant_id = $TENANT" > /dev/null
+sql "UPDATE transactions SET effective_date = '$(day -20) 12:00:00+00:00', created_at = '$(day -20) 12:00:00+00:00' WHERE tenant_id = $TENANT" > /dev/null
+request GET "$V1/accounts/$SAVINGS" "" "${AUTH[@]}"
+check "the account shows the rate in force today" "b['interest_rate'] == 0.01825 and 'upcoming_rate' not in b"
+EXPECTED=$(python3 -c "
+import datetime, json
+today = datetime.datetime.now(datetime.timezone.utc).date()
+total, posted = 0, {}
+for offset in range(-20, 0):
+    day = today + datetime.timedelta(days=offset)
+    total = round(total + (1.00 if offset < -10 else 0.50), 2)
+    if (day + datetime.timedelta(days=1)).day == 1:
+        posted[day.strftime('%Y%m')] = [total, day.isoformat()]
+        total = 0
+print(json.dumps({'accrued': total, 'posted': posted}))")
+run_job interest-accrual
+check "the job run succeeds" "[r for r in b['runs'] if r['job_name'] == 'interest-accrual'][0]['status'] == 'succeeded'"
+INTEREST=$(sql "SELECT json_group_array(json_array(reference, amount, substr(effective_date, 1, 10))) FROM transactions WHERE account_id = $SAVINGS AND transaction_type = 'interest'")
+request GET "$V1/accounts/$SAVINGS" "" "${AUTH[@]}"
+check "each day accrued at the rate in force on it" "abs(b['accrued_interest'] + sum(r[1] for r in $INTEREST) - 15) < 0.001"
+check "the unposted interest is carried on the account" "abs(b['accrued_interest'] - $EXPECTED['accrued']) < 0.001"
+check "interest is posted at month end, effective on the month's last day" "sorted([r[0][-6:], r[1], r[2]] for r in $INTEREST) == sorted([k] + v for k, v in $EXPECTED['posted'].items())"
+check "the account has accrued through yesterday" "(b['interest_accrued_through'] or '')[:10] == '$(day 0)'"
+check "the posted interest is in the balance" "abs(b['balance'] - 10000 - sum(r[1] for r in $INTEREST)) < 0.001"
+check "an empty account accrues nothing" "$(sql "SELECT accrued_interest FROM accounts WHERE id = $EMPTY") == 0"
+run_job interest-accrual
+request GET "$V1/accounts/$SAVINGS" "" "${AUTH[@]}"
+check "rerunning the same day accrues nothing more" "abs(b['accrued_interest'] - $EXPECTED['accrued']) < 0.001 and $(sql "SELECT COUNT(*) FROM transactions WHERE account_id = $SAVINGS AND transaction_type = 'interest'") == len($INTEREST)"
+
+finish "rate change"
@@ -0,0 +1,153 @@
+#!/bin/bash
+
+# Relationship Pricing Tests
+# Checks tier definition validation and overlaps, the relationship endpoint before and after a recomputation
+# (recorded tier, live inputs, the tier they qualify for and what the next tier needs), checking fees waived
+# for a customer holding a loan and savings above the threshold while another customer is still charged, that
+# a tier customers are in only changes its name and end date, and that the monthly job moves a customer up once
+# their tenure and deposits reach the top tier. Each run creates its own tenant so its tiers never price other
+# runs' customers; the platform admin is created with bankctl, and a customer's join date is moved back in the
+# server's database, so DB_PATH must be the database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-relationship-pricing.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-relationship-pricing.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="relationship-test-$RUN_ID-Aa1!"
+PLATFORM_USER="relationship-platform-$RUN_ID"
+TENANT_CODE="rel$RUN_ID"
+
+echo " Relationship Pricing Tests"
+echo "==========================="
+
+# day OFFSET - prints today's UTC date moved by OFFSET days, as YYYY-MM-DD
+day() {
+    python3 -c "import datetime, sys; print((datetime.datetime.now(datetime.timezone.utc).date() + datetime.timedelta(days=int(sys.argv[1]))).isoformat())" "$1"
+}
+
+# tier FIELDS - creates a tier definition effective from a week ago and stores its ID in TIER
+tier() {
+    request POST "$V1/admin/relationship-tiers" "{$1, \"effective_from\": \"$(day -7)\"}" "${AUTH[@]}"
+    TIER=$(field "['relationship_tier']['id']" 2>/dev/null)
+}
+
+# customer NAME - creates a customer and stores its ID in CUSTOMER
+customer() {
+    request POST "$V1/customers" "{\"first_name\": \"$1\", \"last_name\": \"Client\", \"email\": \"relationship-$1-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\", \"monthly_income\": 10000}" "${AUTH[@]}"
+    CUSTOMER=$(field "['customer']['id']")
+}
+
+# account CUSTOMER TYPE DEPOSIT - opens an account with an opening deposit and stores its ID in ACCOUNT
+account() {
+    request POST "$V1/accounts" "{\"customer_id\": $1, \"account_type\": \"$2\"}" "${AUTH[@]}"
+    ACCOUNT=$(field "['account']['id']")
+    request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": $3}" "${AUTH[@]}"
+}
+
+# withdraw ACCOUNT - withdraws 10 from an account
+withdraw() {
+    request POST "$V1/transactions" "{\"account_id\": $1, \"transaction_type\": \"withdrawal\", \"amount\": 10}" "${AUTH[@]}"
+}
+
+# relationship CUSTOMER - fetches a customer's relationship
+relationship() {
+    request GET "$V1/customers/$1/relationship" "" "${AUTH[@]}"
+}
+
+echo "Setup"
+tenant "$PLATFORM_USER" "Relationship" relationship-admin
+customer Loyal
+LOYAL=$CUSTOMER
+account "$LOYAL" savings 20000
+account "$LOYAL" checking 1000
+LOYAL_CHECKING=$ACCOUNT
+request POST "$V1/loans" "{\"customer_id\": $LOYAL, \"principal_amount\": 5000, \"interest_rate\": 0.05, \"loan_term\": 12}" "${AUTH[@]}"
+check "the loyal customer holds savings, checking and a loan" "s == 201"
+customer Casual
+CASUAL=$CUSTOMER
+account "$CASUAL" checking 1000
+CASUAL_CHECKING=$ACCOUNT
+request POST "$V1/admin/fee-schedules" "{\"name\": \"Checking withdrawal fee\", \"account_type\": \"checking\", \"currency\": \"USD\", \"transaction_type\": \"withdrawal\", \"flat_fee\": 2, \"effective_from\": \"$(day -7)\"}" "${AUTH[@]}"
+check "checking withdrawals carry a fee" "s == 201"
+
+echo
+echo "Validation"
+tier '"code": "bad", "name": "Bad", "rank": 0'
+check "a tier needs a positive rank" "s == 400 and b['code'] == 'INVALID_RELATIONSHIP_TIER'"
+tier '"code": "bad", "name": "Bad", "rank": 9, "min_deposits": -1'
+check "thresholds cannot be negative" "s == 400 and b['code'] == 'INVALID_RELATIONSHIP_TIER'"
+tier '"code": "bad", "name": "Bad", "rank": 9, "fee_waivers": "loan"'
+check "fee waivers are checked against the catalog" "s == 400 and b['code'] == 'RESERVED_ACCOUNT_TYPE'"
+request POST "$V1/admin/relationship-tiers" '{"code": "bad", "name": "Bad", "rank": 9, "effective_from": "2025-02-01", "effective_to": "2025-01-31"}' "${AUTH[@]}"
+check "the range cannot end before it starts" "s == 400"
+
+echo
+echo "Tiers"
+tier '"code": "Silver", "name": "Silver", "rank": 1, "min_deposits": 5000, "min_products": 2, "savings_rate_bonus": 0.1'
+check "a tier is created with its code normalized" "s == 201 and b['relationship_tier']['code'] == 'silver' and b['relationship_tier']['created_by'] == 'relationship-admin'"
+tier '"code": "gold", "name": "Gold", "rank": 2, "min_deposits": 10000, "min_loan_balance": 1, "fee_waivers": "checking", "savings_rate_bonus": 0.25'
+GOLD=$TIER
+check "a tier can waive checking fees" "s == 201 and b['relationship_tier']['fee_waivers'] == 'checking'"
+tier '"code": "platinum", "name": "Platinum", "rank": 3, "min_deposits": 50000, "min_tenure_days": 365, "savings_rate_bonus": 0.5'
+PLATINUM=$TIER
+tier '"code": "gold-plus", "name": "Gold Plus", "rank": 2'
+check "two tiers cannot share a rank" "s == 409 and b['code'] == 'RELATIONSHIP_TIER_OVERLAP' and b['conflicting_id'] == $GOLD"
+tier '"code": "gold", "name": "Gold again", "rank": 7'
+check "two definitions of a code cannot overlap" "s == 409 and b['conflicting_id'] == $GOLD"
+request GET "$V1/admin/relationship-tiers?active_on=$(day 0)" "" "${AUTH[@]}"
+check "tiers in effect are listed best first" "[t['code'] for t in b['relationship_tiers']] == ['platinum', 'gold', 'silver']"
+
+echo
+echo "Before the first computation"
+relationship "$LOYAL"
+check "nothing is recorded yet" "s == 200 and b['recorded'] is None and b['tier'] is None"
+check "live inputs are measured" "b['inputs']['total_deposits'] == 21000 and b['inputs']['loan_balance'] == 5000 and b['inputs']['product_count'] == 3"
+check "the tier they qualify for is shown" "b['qualifies_for'] == 'gold'"
+check "the next tier shows what is missing" "b['next_tier']['tier']['code'] == 'platinum' and b['next_tier']['needs'] == {'deposits': 29000, 'loan_balance': 0, 'products': 0, 'tenure_days': 365}"
+withdraw "$LOYAL_CHECKING"
+check "fees are charged until a tier is recorded" "s == 201 and b['fee']['amount'] == 2"
+
+echo
+echo "Recomputation"
+request POST "$V1/admin/relationship-tiers/recompute" "" "${AUTH[@]}"
+check "the tenant's customers are tiered" "s == 200 and b['customers'] == 2"
+relationship "$LOYAL"
+check "the recorded tier is gold" "b['tier']['code'] == 'gold' and b['recorded']['tier_code'] == 'gold' and b['recorded']['period'] == '$(date -u +%Y-%m)'"
+check "the inputs are stored with it" "b['recorded']['total_deposits'] == 20988 and b['recorded']['loan_balance'] == 5000 and b['recorded']['product_count'] == 3"
+check "the savings rate bonus is shown" "b['tier']['savings_rate_bonus'] == 0.25"
+relationship "$CASUAL"
+check "a customer below every tier has none" "b['tier'] is None and b['recorded']['tier_code'] == '' and b['next_tier']['tier']['code'] == 'silver'"
+check "what the first tier needs is shown" "b['next_tier']['needs'] == {'deposits': 4000, 'loan_balance': 0, 'products': 1, 'tenure_days': 0}"
+withdraw "$LOYAL_CHECKING"
+check "gold waives checking fees" "s == 201 and 'fee' not in b"
+withdraw "$CASUAL_CHECKING"
+check "other customers are still charged" "s == 201 and b['fee']['amount'] == 2"
+request POST "$V1/admin/relationship-tiers/recompute" "" "${AUTH[@]}"
+check "recomputing within a month replaces its record" "[$(sql "SELECT COUNT(*) FROM customer_relationships WHERE customer_id = $LOYAL")] == [1]"
+
+echo
+echo "Tiers in use"
+request PUT "$V1/admin/relationship-tiers/$GOLD" "{\"code\": \"gold\", \"name\": \"Gold\", \"rank\": 2, \"min_deposits\": 15000, \"min_loan_balance\": 1, \"fee_waivers\": \"checking\", \"savings_rate_bonus\": 0.25, \"effective_from\": \"$(day -7)\"}" "${AUTH[@]}"
+check "thresholds of a tier in use cannot change" "s == 409 and b['code'] == 'RELATIONSHIP_TIER_IN_USE'"
+request PUT "$V1/admin/relationship-tiers/$GOLD" "{\"code\": \"gold\", \"name\": \"Gold Relationship\", \"rank\": 2, \"min_deposits\": 10000, \"min_loan_balance\": 1, \"fee_waivers\": \"checking\", \"savings_rate_bonus\": 0.25, \"effective_from\": \"$(day -7)\"}" "${AUTH[@]}"
+check "its name can" "s == 200 and b['relationship_tier']['name'] == 'Gold Relationship'"
+request DELETE "$V1/admin/relationship-tiers/$GOLD" "" "${AUTH[@]}"
+check "a tier in use cannot be deleted" "s == 409 and b['code'] == 'RELATIONSHIP_TIER_IN_USE'"
+request DELETE "$V1/admin/relationship-tiers/$PLATINUM" "" "${AUTH[@]}"
+check "a tier nobody is in can" "s == 200"
+tier '"code": "platinum", "name": "Platinum", "rank": 3, "min_deposits": 50000, "min_tenure_days": 365, "savings_rate_bonus": 0.5'
+check "and be defined again" "s == 201"
+
+echo
+echo "Monthly job"
+sql "UPDATE customers SET created_at = datetime('now', '-400 days') WHERE id = $LOYAL" > /dev/null
+account "$LOYAL" savings 40000
+relationship "$LOYAL"
+check "reaching a tier shows before the job records it" "b['tier']['code'] == 'gold' and b['qualifies_for'] == 'platinum' and b['next_tier'] is None"
+run_job relationship-tiers
+relationship "$LOYAL"
+check "the job moves the customer up" "b['tier']['code'] == 'platinum' and b['recorded']['tenure_days'] >= 400"
+withdraw "$LOYAL_CHECKING"
+check "platinum does not waive checking fees" "s == 201 and b['fee']['amount'] == 2"
+
+finish "relationship pricing"
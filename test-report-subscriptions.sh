@@ -0,0 +1,161 @@
+#!/bin/bash
+
+# Report Subscription Tests
+# Checks creating, validating, pausing and deleting report subscriptions, manual runs and downloading the report
+# they archive, and the report-subscriptions job: scheduled runs, retries of a failing run ending in an alert,
+# a delivery that finally failed, and pausing subscriptions whose owner bankctl disables. The admin users are
+# created with bankctl, and due times and delivery outcomes are set in the server's database, so DB_PATH must
+# be the database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-report-subscriptions.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-report-subscriptions.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="reports-test-$RUN_ID"
+ADMIN_USER="reports-admin-$RUN_ID"
+OWNER_USER="reports-owner-$RUN_ID"
+
+echo " Report Subscription Tests"
+echo "=========================="
+
+# subscribe BODY - creates a subscription as the owner and stores its ID in SUB
+subscribe() {
+    request POST "$V1/admin/report-subscriptions" "$1" "${OWNER[@]}"
+    SUB=$(field "['subscription']['id']" 2>/dev/null)
+}
+
+# due SUB - makes a subscription's next run, or retry, due now
+due() {
+    sql "UPDATE report_subscriptions SET next_run_at = datetime('now', '-1 minute') WHERE id = $1" > /dev/null
+}
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "$ADMIN_USER")")
+OWNER=(-H "Authorization: Bearer $(staff "$OWNER_USER")")
+request POST "$V1/customers" "{\"first_name\": \"Volume\", \"last_name\": \"Reports\", \"email\": \"volume-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\", \"monthly_income\": 10000}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}"
+ACCOUNT=$(field "['account']['id']")
+request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 125.50}"
+# Volume reports cover whole days before the run, so the deposit is moved to yesterday
+sql "UPDATE transactions SET effective_date = datetime('now', '-1 day') WHERE account_id = $ACCOUNT" > /dev/null
+
+echo
+echo "Validation"
+request POST "$V1/admin/report-subscriptions" '{"report_type": "transaction_volume"}' "${OWNER[@]}"
+check "a name is required" "s == 400"
+VALID='"name": "Volume", "report_type": "transaction_volume", "schedule": "0 7 * * *", "format": "csv", "channel": "email", "target": "ops@example.com"'
+request POST "$V1/admin/report-subscriptions" "{${VALID/transaction_volume/balances}}" "${OWNER[@]}"
+check "an unknown report type is rejected" "s == 400"
+request POST "$V1/admin/report-subscriptions" "{${VALID/0 7 \* \* \*/every day}}" "${OWNER[@]}"
+check "an invalid schedule is rejected" "s == 400"
+request POST "$V1/admin/report-subscriptions" "{${VALID/\"csv\"/\"pdf\"}}" "${OWNER[@]}"
+check "an unknown format is rejected" "s == 400"
+request POST "$V1/admin/report-subscriptions" "{${VALID/ops@example.com/not-an-address}}" "${OWNER[@]}"
+check "an email channel needs an email target" "s == 400"
+request POST "$V1/admin/report-subscriptions" '{"name": "Hook", "report_type": "delinquency", "schedule": "0 7 * * *", "format": "json", "channel": "webhook", "target": "http://127.0.0.1:9/reports"}' "${OWNER[@]}"
+check "a webhook needs an alert email" "s == 400"
+
+echo
+echo "Subscriptions"
+subscribe "{$VALID, \"parameters\": {\"days\": 2}}"
+check "a subscription is created" "s == 201 and b['subscription']['status'] == 'active'"
+check "it is owned by the caller" "b['subscription']['owner'] == '$OWNER_USER'"
+check "failure alerts default to the email target" "b['subscription']['alert_email'] == 'ops@example.com'"
+check "its next run is scheduled" "b['subscription']['next_run_at'].find('T07:00:00') > 0"
+VOLUME=$SUB
+request GET "$V1/admin/report-subscriptions?report_type=transaction_volume&status=active&limit=500" "" "${ADMIN[@]}"
+check "it is listed with the report type filter" "any(x['id'] == $VOLUME for x in b['subscriptions'])"
+request GET "$V1/admin/report-subscriptions/999999" "" "${ADMIN[@]}"
+check "an unknown subscription is 404" "s == 404"
+
+echo
+echo "Manual runs"
+request POST "$V1/admin/report-subscriptions/$VOLUME/run" "" "${ADMIN[@]}"
+check "a manual run renders and queues the report" "s == 201 and b['run']['status'] == 'delivering' and b['run']['trigger'] == 'manual'"
+check "the run links its notification" "b['run']['notification_id'] > 0"
+RUN=$(field "['run']['id']")
+check "the notification links the report" "'/report-subscriptions/$VOLUME/runs/$RUN/artifact' in '''$(sql "SELECT body FROM notifications WHERE resource_type = 'report_run' AND resource_id = $RUN")'''"
+ARTIFACT=$(curl -s -D /tmp/report-headers-$RUN_ID "${ADMIN[@]}" "$V1/admin/report-subscriptions/$VOLUME/runs/$RUN/artifact")
+BODY='{}' STATUS=200
+check "the report is a CSV with a header" "'''$ARTIFACT'''.splitlines()[0] == 'date,currency,transaction_type,count,amount'"
+check "it includes yesterday's deposit" "any(l.split(',')[2] == 'deposit' for l in '''$ARTIFACT'''.splitlines()[1:])"
+check "it downloads as a named file" "'report-$VOLUME-$RUN.csv' in '''$(cat /tmp/report-headers-$RUN_ID)'''"
+rm -f /tmp/report-headers-$RUN_ID
+request GET "$V1/admin/report-subscriptions/$VOLUME/runs" "" "${ADMIN[@]}"
+check "the run is listed" "b['total'] == 1 and b['runs'][0]['id'] == $RUN"
+
+subscribe '{"name": "Arrears", "report_type": "delinquency", "parameters": {"min_days_past_due": 30}, "schedule": "0 7 * * 1", "format": "json", "channel": "email", "target": "collections@example.com"}'
+DELINQUENCY=$SUB
+request POST "$V1/admin/report-subscriptions/$DELINQUENCY/run" "" "${ADMIN[@]}"
+RUN=$(field "['run']['id']")
+request GET "$V1/admin/report-subscriptions/$DELINQUENCY/runs/$RUN/artifact" "" "${ADMIN[@]}"
+check "a JSON report carries its parameters" "s == 200 and b['parameters']['min_days_past_due'] == 30 and isinstance(b['rows'], list)"
+
+echo
+echo "Scheduled runs"
+due "$VOLUME"
+run_job report-subscriptions "${ADMIN[@]}"
+request GET "$V1/admin/report-subscriptions/$VOLUME/runs" "" "${ADMIN[@]}"
+check "the job makes a due run" "b['total'] == 2 and b['runs'][0]['trigger'] == 'schedule'"
+request GET "$V1/admin/report-subscriptions/$VOLUME" "" "${ADMIN[@]}"
+check "the next run moves on" "b['subscription']['next_run_at'].find('T07:00:00') > 0 and b['subscription']['attempts'] == 0"
+
+# A delivery the notification queue gave up on fails the run and alerts the owner
+RUN=$(sql "SELECT max(id) FROM report_runs WHERE subscription_id = $VOLUME")
+sql "UPDATE notifications SET status = 'failed', last_error = 'mailbox unavailable' WHERE id = (SELECT notification_id FROM report_runs WHERE id = $RUN)" > /dev/null
+run_job report-subscriptions "${ADMIN[@]}"
+request GET "$V1/admin/report-subscriptions/$VOLUME/runs?status=failed" "" "${ADMIN[@]}"
+check "a failed delivery fails the run" "b['total'] == 1 and 'mailbox unavailable' in b['runs'][0]['error']"
+check "the owner is alerted" "b['runs'][0]['alert_notification_id'] > 0"
+check "the alert goes to the alert address" "'$(sql "SELECT recipient FROM notifications WHERE resource_type = 'report_subscription' AND resource_id = $VOLUME")' == 'ops@example.com'"
+request GET "$V1/admin/report-subscriptions/$VOLUME" "" "${ADMIN[@]}"
+check "the subscription shows the failure" "b['subscription']['last_run_status'] == 'failed'"
+
+# A report that cannot be rendered is retried, then the owner is alerted
+subscribe '{"name": "Broken", "report_type": "delinquency", "schedule": "0 7 * * *", "format": "csv", "channel": "webhook", "target": "http://127.0.0.1:9/reports", "alert_email": "owner@example.com"}'
+BROKEN=$SUB
+sql "UPDATE report_subscriptions SET report_type = 'retired' WHERE id = $BROKEN" > /dev/null
+due "$BROKEN"
+run_job report-subscriptions "${ADMIN[@]}"
+request GET "$V1/admin/report-subscriptions/$BROKEN" "" "${ADMIN[@]}"
+check "a failed run is retried later" "b['subscription']['attempts'] == 1 and b['subscription']['due_at'] is not None"
+for _ in 2 3; do
+    due "$BROKEN"
+    run_job report-subscriptions "${ADMIN[@]}"
+done
+request GET "$V1/admin/report-subscriptions/$BROKEN/runs" "" "${ADMIN[@]}"
+check "each attempt is recorded" "b['total'] == 3 and [r['attempt'] for r in b['runs']] == [3, 2, 1]"
+check "only the last attempt alerts" "'alert_notification_id' in b['runs'][0] and 'alert_notification_id' not in b['runs'][1]"
+check "retries keep the scheduled time" "len(set(r['scheduled_for'] for r in b['runs'])) == 1"
+request GET "$V1/admin/report-subscriptions/$BROKEN" "" "${ADMIN[@]}"
+check "the schedule resumes after the last attempt" "b['subscription']['attempts'] == 0 and b['subscription'].get('due_at') is None"
+
+echo
+echo "Pausing"
+request PUT "$V1/admin/report-subscriptions/$DELINQUENCY" '{"name": "Arrears", "report_type": "delinquency", "schedule": "0 7 * * 1", "format": "json", "channel": "email", "target": "collections@example.com", "status": "paused"}' "${ADMIN[@]}"
+check "a subscription can be paused" "s == 200 and b['subscription']['status'] == 'paused'"
+due "$DELINQUENCY"
+run_job report-subscriptions "${ADMIN[@]}"
+request GET "$V1/admin/report-subscriptions/$DELINQUENCY/runs" "" "${ADMIN[@]}"
+check "a paused subscription does not run" "b['total'] == 1"
+
+OUTPUT=$($BANKCTL -db "$DB_PATH" disable-user -username "$OWNER_USER")
+BODY='{}' STATUS=200
+check "bankctl disables the owner" "'paused' in '''$OUTPUT'''"
+request GET "$V1/admin/report-subscriptions/$VOLUME" "" "${ADMIN[@]}"
+check "the owner's subscriptions are paused" "b['subscription']['status'] == 'paused' and b['subscription']['paused_reason'] == 'Owner deactivated'"
+request PUT "$V1/admin/report-subscriptions/$DELINQUENCY" '{"name": "Arrears", "report_type": "delinquency", "schedule": "0 7 * * 1", "format": "json", "channel": "email", "target": "collections@example.com", "status": "active"}' "${ADMIN[@]}"
+check "they cannot be resumed while the owner is deactivated" "s == 409 and b['code'] == 'OWNER_INACTIVE'"
+request POST "$V1/auth/login" "{\"username\": \"$OWNER_USER\", \"password\": \"$PASSWORD\"}"
+check "the owner can no longer sign in" "s == 403"
+
+echo
+echo "Deletion"
+request DELETE "$V1/admin/report-subscriptions/$BROKEN" "" "${ADMIN[@]}"
+check "a subscription can be deleted" "s == 200"
+request GET "$V1/admin/report-subscriptions/$BROKEN" "" "${ADMIN[@]}"
+check "it is gone" "s == 404"
+check "its runs are kept" "$(sql "SELECT count(*) FROM report_runs WHERE subscription_id = $BROKEN") == 3"
+
+finish "report subscription"
@@ -0,0 +1,143 @@
+#!/bin/bash
+
+# Account Restriction Tests
+# Checks that a catalog product's restrictions are copied onto accounts opened under it, that deposit-only and
+# no-outbound-transfer accounts refuse debits with their own codes on v1 and v2, that debits from accounts whose
+# withdrawals need staff approval are held in the approval queue instead, that a held debit is posted only when
+# someone other than its requester approves it (and never twice), that rejection posts nothing, and that restriction
+# changes are permissioned and appear on the activity feed. Two admins and a teller are created with bankctl against
+# the server's database, so DB_PATH must be the database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-restrictions.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-restrictions.sh
+
+source "$(dirname "$0")/test-lib.sh"
+V2="$BASE_URL/api/v2"
+PASSWORD="restrictions-test-$RUN_ID"
+PRODUCT="escrow${RUN_ID: -8}"
+
+echo " Account Restriction Tests"
+echo "=========================="
+
+# account TYPE - opens an account of a type for the run's customer and prints its id
+# Account numbers opened in the same second can collide, so a refused open is retried
+account() {
+    for _ in 1 2 3; do
+        request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"$1\"}"
+        [ "$STATUS" = 201 ] && break
+        sleep 1
+    done
+    field "['account']['id']"
+}
+
+# balance ACCOUNT - prints an account's balance as a whole number
+balance() {
+    request GET "$V1/accounts/$1/balance"
+    field "['balance']" | sed 's/\.0$//'
+}
+
+# restrict ACCOUNT JSON - replaces an account's restrictions as the first admin
+restrict() {
+    request PUT "$V1/accounts/$1/restrictions" "$2" "${MAKER[@]}"
+}
+
+echo "Setup"
+MAKER=(-H "Authorization: Bearer $(staff "restrictions-maker-$RUN_ID" admin)")
+CHECKER=(-H "Authorization: Bearer $(staff "restrictions-checker-$RUN_ID" admin)")
+TELLER=(-H "Authorization: Bearer $(staff "restrictions-teller-$RUN_ID" teller)")
+request POST "$V1/customers" "{\"first_name\": \"Escrow\", \"last_name\": \"Holder\", \"email\": \"restrictions-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}"
+CUSTOMER=$(field "['customer']['id']")
+
+echo
+echo "Product catalog"
+request POST "$V1/admin/products" "{\"account_type\": \"$PRODUCT\", \"name\": \"Escrow\", \"restrictions\": {\"no_outbound_transfers\": true, \"staff_approval_for_withdrawals\": true}}" "${MAKER[@]}"
+check "a product carries restrictions" "s == 201 and b['product']['restrictions'] == {'deposit_only': False, 'no_outbound_transfers': True, 'staff_approval_for_withdrawals': True}"
+ESCROW=$(account "$PRODUCT")
+request GET "$V1/accounts/$ESCROW"
+check "accounts open with their product's restrictions" "s == 200 and b['restrictions']['staff_approval_for_withdrawals'] and b['restrictions']['no_outbound_transfers']"
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\", \"restrictions\": {\"deposit_only\": true}}"
+check "restrictions cannot be asked for when opening" "s == 201 and not b['account']['restrictions']['deposit_only']"
+CHECKING=$(field "['account']['id']")
+request POST "$V1/transactions" "{\"account_id\": $ESCROW, \"transaction_type\": \"deposit\", \"amount\": 500}"
+check "deposits post normally" "s == 201"
+
+echo
+echo "Restricted debits"
+request POST "$V1/transfers" "{\"from_account_id\": $ESCROW, \"to_account_id\": $CHECKING, \"amount\": 10}"
+check "transfers out are refused" "s == 403 and b['code'] == 'OUTBOUND_TRANSFERS_RESTRICTED'"
+request POST "$V1/transactions" "{\"account_id\": $ESCROW, \"transaction_type\": \"transfer\", \"amount\": 10}"
+check "transfer postings are refused too" "s == 403 and b['code'] == 'OUTBOUND_TRANSFERS_RESTRICTED'"
+request POST "$V1/transactions" "{\"account_id\": $ESCROW, \"transaction_type\": \"withdrawal\", \"amount\": 40, \"description\": \"Contractor payout\"}"
+check "a withdrawal is held for approval" "s == 202 and b['approval']['status'] == 'pending' and b['approval']['amount'] == 40"
+ANONYMOUS=$(field "['approval']['id']")
+request POST "$V2/transactions" "{\"account_id\": $ESCROW, \"transaction_type\": \"withdrawal\", \"amount\": \"25.50\"}"
+check "v2 holds it with a decimal amount" "s == 202 and b['data']['status'] == 'pending' and b['data']['amount'] == '25.50'"
+TO_REJECT=$(field "['data']['id']")
+request POST "$V1/transactions" "{\"account_id\": $ESCROW, \"transaction_type\": \"withdrawal\", \"amount\": 100}" "${MAKER[@]}"
+OWN=$(field "['approval']['id']")
+check "nothing was debited while held" "$(balance "$ESCROW") == 500"
+request POST "$V1/transactions" "{\"account_id\": $ESCROW, \"transaction_type\": \"fee\", \"amount\": 1}" "${MAKER[@]}"
+check "bank charges are not restricted" "s == 201"
+
+echo
+echo "Approval queue"
+request GET "$V1/operations/approvals?account_id=$ESCROW"
+check "the queue needs staff" "s == 401"
+request GET "$V1/operations/approvals?account_id=$ESCROW" "" "${TELLER[@]}"
+check "tellers see the held debits oldest first" "s == 200 and b['total'] == 3 and [a['id'] for a in b['approvals']] == [$ANONYMOUS, $TO_REJECT, $OWN]"
+request POST "$V1/operations/approvals/$OWN/approve" "{}" "${MAKER[@]}"
+check "the requester cannot approve their own withdrawal" "s == 403 and b['code'] == 'SAME_USER_DECISION'"
+request POST "$V1/operations/approvals/$OWN/approve" "{\"note\": \"Invoice checked\"}" "${CHECKER[@]}"
+check "another admin's approval posts it" "s == 200 and b['approval']['status'] == 'approved' and b['approval']['decided_by'] == 'restrictions-checker-$RUN_ID' and b['transaction']['amount'] == 100"
+check "the approval links the posting" "b['approval']['transaction_id'] == b['transaction']['id']"
+check "the account was debited once" "$(balance "$ESCROW") == 399"
+request POST "$V1/operations/approvals/$OWN/approve" "{}" "${TELLER[@]}"
+check "a decided approval cannot be approved again" "s == 409 and b['code'] == 'APPROVAL_DECIDED'"
+request POST "$V1/operations/approvals/$TO_REJECT/reject" "{}" "${TELLER[@]}"
+check "a rejection needs a note" "s == 400"
+request POST "$V1/operations/approvals/$TO_REJECT/reject" "{\"note\": \"Not authorised by the escrow agent\"}" "${TELLER[@]}"
+check "a rejected withdrawal is not posted" "s == 200 and b['approval']['status'] == 'rejected' and 'transaction_id' not in b['approval']"
+check "the balance is unchanged by the rejection" "$(balance "$ESCROW") == 399"
+
+echo
+echo "Changing restrictions"
+restrict "$ESCROW" '{"deposit_only": true, "no_outbound_transfers": true, "staff_approval_for_withdrawals": true, "reason": "Dispute"}'
+check "an admin sets restrictions" "s == 200 and b['restrictions']['deposit_only']"
+request PUT "$V1/accounts/$ESCROW/restrictions" '{"deposit_only": false, "reason": "Dispute"}' "${TELLER[@]}"
+check "tellers cannot change them" "s == 403"
+restrict "$ESCROW" '{"deposit_only": false}'
+check "a change needs a reason" "s == 400"
+request POST "$V1/transactions" "{\"account_id\": $ESCROW, \"transaction_type\": \"withdrawal\", \"amount\": 5}"
+check "deposit-only accounts refuse withdrawals outright" "s == 403 and b['code'] == 'ACCOUNT_DEPOSIT_ONLY'"
+request POST "$V2/transactions" "{\"account_id\": $ESCROW, \"transaction_type\": \"payment\", \"amount\": \"5\"}"
+check "v2 refuses them in its error envelope" "s == 403 and b['error']['code'] == 'ACCOUNT_DEPOSIT_ONLY'"
+request POST "$V1/transactions" "{\"account_id\": $ESCROW, \"transaction_type\": \"deposit\", \"amount\": 1}"
+check "deposit-only accounts still take deposits" "s == 201"
+request POST "$V1/operations/approvals/$ANONYMOUS/approve" "{}" "${CHECKER[@]}"
+check "a held withdrawal cannot be approved past deposit-only" "s == 403 and b['code'] == 'ACCOUNT_DEPOSIT_ONLY'"
+request GET "$V1/operations/approvals/$ANONYMOUS" "" "${CHECKER[@]}"
+check "it stays in the queue" "s == 200 and b['approval']['status'] == 'pending' and 'decided_by' not in b['approval']"
+
+restrict "$ESCROW" '{"staff_approval_for_withdrawals": true, "reason": "Dispute resolved"}'
+request POST "$V1/transfers" "{\"from_account_id\": $ESCROW, \"to_account_id\": $CHECKING, \"amount\": 50, \"reference\": \"release-$RUN_ID\"}"
+check "a transfer is held once transfers are allowed" "s == 202 and b['approval']['kind'] == 'transfer' and b['approval']['to_account_id'] == $CHECKING"
+TRANSFER=$(field "['approval']['id']")
+request POST "$V1/operations/approvals/$TRANSFER/approve" "{}" "${CHECKER[@]}"
+check "approving posts both legs" "s == 200 and b['transfer']['amount'] == 50 and b['approval']['transfer_id'] == b['transfer']['id']"
+check "the destination was credited" "$(balance "$CHECKING") == 50"
+request POST "$V1/operations/approvals/$ANONYMOUS/approve" "{}" "${CHECKER[@]}"
+check "the earlier withdrawal posts once allowed" "s == 200 and b['approval']['status'] == 'approved'"
+check "the account reflects every approved debit" "$(balance "$ESCROW") == 310"
+restrict "$ESCROW" '{"reason": "Escrow released"}'
+request POST "$V1/transactions" "{\"account_id\": $ESCROW, \"transaction_type\": \"withdrawal\", \"amount\": 10}"
+check "unrestricted accounts debit straight away" "s == 201"
+
+echo
+echo "Account view and activity"
+request GET "$V1/accounts/$ESCROW"
+check "the account shows its current restrictions" "b['restrictions'] == {'deposit_only': False, 'no_outbound_transfers': False, 'staff_approval_for_withdrawals': False}"
+request GET "$V1/accounts/$ESCROW/activity?types=status"
+check "each change is on the activity feed" "len([i for i in b['items'] if i['title'] == 'Account restrictions changed']) == 3"
+check "the feed records who changed what and why" "[i for i in b['items'] if i['title'] == 'Account restrictions changed'][0]['details']['reason'] == 'Escrow released' and [i for i in b['items'] if i['title'] == 'Account restrictions changed'][0]['details']['changed_by'] == 'restrictions-maker-$RUN_ID'"
+
+finish "account restriction"
@@ -0,0 +1,144 @@
+#!/bin/bash
+
+# Sandbox Error Simulation Tests
+# Builds the server twice, without and with -tags sandbox, and starts each on its own database. The production
+# build must not have the sandbox routes, must not treat the magic account numbers specially even when an account
+# carries one, and must refuse to start with SANDBOX_MODE=true. The sandbox build opens the magic accounts:
+# transfers to ACC-SIM-INSUFFICIENT fail with INSUFFICIENT_FUNDS, to ACC-SIM-FROZEN with ACCOUNT_FROZEN, and to
+# ACC-SIM-TIMEOUT only after the timeout delay (shortened with SANDBOX_TIMEOUT_SECONDS). A caller can force its
+# own next requests to fail with a chosen code or to wait, on v1 and v2, without affecting other callers. The
+# servers and databases are the script's own, so no other server is needed. Exits non-zero on failure.
+#
+# Usage: ./test-sandbox-simulation.sh                    (builds both servers with go build)
+#        SERVER_BIN=./banking-app SANDBOX_BIN=./banking-sandbox BANKCTL=./bankctl PORT=18097 ./test-sandbox-simulation.sh
+
+source "$(dirname "$0")/test-lib.sh"
+serve 18097
+V2="$BASE_URL/api/v2"
+PASSWORD="simulation-test-$RUN_ID-Aa1!"
+
+echo " Sandbox Error Simulation Tests"
+echo "==============================="
+
+# account - opens a checking account with 100 deposited for a new customer and prints its id
+account() {
+    request POST "$V1/customers" "{\"first_name\": \"Sim\", \"last_name\": \"Partner\", \"email\": \"simulation-$RUN_ID-$RANDOM@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${ADMIN[@]}"
+    request POST "$V1/accounts" "{\"customer_id\": $(field "['customer']['id']"), \"account_type\": \"checking\"}" "${ADMIN[@]}"
+    local id
+    id=$(field "['account']['id']")
+    request POST "$V1/transactions" "{\"account_id\": $id, \"transaction_type\": \"deposit\", \"amount\": 100}" "${ADMIN[@]}"
+    echo "$id"
+}
+
+# transfer FROM TO [VERSION] - transfers 10 as the admin
+transfer() {
+    if [ "$3" = "v2" ]; then
+        request POST "$V2/transfers" "{\"from_account_id\": $1, \"to_account_id\": $2, \"amount\": \"10.00\"}" "${ADMIN[@]}"
+    else
+        request POST "$V1/transfers" "{\"from_account_id\": $1, \"to_account_id\": $2, \"amount\": 10}" "${ADMIN[@]}"
+    fi
+}
+
+build
+build sandbox
+
+echo "Production build"
+DB_PATH="$WORK/prod.db"
+start_server "$SERVER_BIN"
+ADMIN=(-H "Authorization: Bearer $(staff "simulation-admin")")
+request GET "$V1/sandbox"
+check "the sandbox routes are absent" "s == 404"
+request POST "$V1/sandbox/simulate" "{\"error_code\": \"INTERNAL_ERROR\"}" "${ADMIN[@]}"
+check "the simulate endpoint is absent" "s == 404"
+request GET "$V1/sandbox/simulate" "" "${ADMIN[@]}"
+check "and cannot be read either" "s == 404"
+SOURCE=$(account)
+TARGET=$(account)
+sql "UPDATE accounts SET account_number = 'ACC-SIM-FROZEN' WHERE id = $TARGET" > /dev/null
+transfer "$SOURCE" "$TARGET"
+check "a transfer to an account numbered ACC-SIM-FROZEN posts as usual" "s == 201 and 'x-sandbox-simulated' not in h"
+request GET "$V1/accounts/$SOURCE" "" "${ADMIN[@]}"
+check "no simulated error is ever returned" "s == 200 and 'x-sandbox' not in h"
+check "no magic accounts are opened" "'$(sql "SELECT count(*) FROM accounts WHERE account_number LIKE 'ACC-SIM-%'")' == '1'"
+stop_server
+env PORT="$PORT" DB_PATH="$DB_PATH" SANDBOX_MODE=true SANDBOX_DB_PATH="$WORK/refused.db" timeout 20 "$SERVER_BIN" > "$WORK/refused.log" 2>&1
+STATUS=$?
+BODY=
+check "SANDBOX_MODE=true stops a production build at startup" "s != 0 and s != 124"
+
+echo
+echo "Magic accounts"
+# The sandbox keeps its data in DB_PATH, the sandbox database
+DB_PATH="$WORK/sandbox.db"
+start_server "$SANDBOX_BIN" DB_PATH="$WORK/unused.db" SANDBOX_MODE=true SANDBOX_DB_PATH="$DB_PATH" SANDBOX_TIMEOUT_SECONDS=2
+ADMIN=(-H "Authorization: Bearer $(staff "simulation-admin")")
+TELLER=(-H "Authorization: Bearer $(staff "simulation-teller" teller)")
+INSUFFICIENT=$(sql "SELECT id FROM accounts WHERE account_number = 'ACC-SIM-INSUFFICIENT'")
+TIMEOUT=$(sql "SELECT id FROM accounts WHERE account_number = 'ACC-SIM-TIMEOUT'")
+FROZEN=$(sql "SELECT id FROM accounts WHERE account_number = 'ACC-SIM-FROZEN'")
+check "the sandbox opens the three magic accounts" "'$INSUFFICIENT'.isdigit() and '$TIMEOUT'.isdigit() and '$FROZEN'.isdigit()"
+SOURCE=$(account)
+transfer "$SOURCE" "$INSUFFICIENT"
+check "a transfer to ACC-SIM-INSUFFICIENT fails with INSUFFICIENT_FUNDS" "s == 400 and b['code'] == 'INSUFFICIENT_FUNDS'"
+transfer "$SOURCE" "$INSUFFICIENT" v2
+check "on v2 too, in its error envelope" "s == 400 and b['error']['code'] == 'INSUFFICIENT_FUNDS'"
+transfer "$SOURCE" "$FROZEN"
+check "a transfer to ACC-SIM-FROZEN fails with ACCOUNT_FROZEN" "s == 403 and b['code'] == 'ACCOUNT_FROZEN'"
+transfer "$SOURCE" "$FROZEN" v2
+check "on v2 too" "s == 403 and b['error']['code'] == 'ACCOUNT_FROZEN'"
+check "nothing was debited" "'$(sql "SELECT balance FROM accounts WHERE id = $SOURCE")' == '100'"
+transfer "$SOURCE" "$TIMEOUT"
+check "a transfer to ACC-SIM-TIMEOUT is answered only after the delay" "s == 201 and t >= 2 and h.get('x-sandbox-simulated') == 'true'"
+transfer "$SOURCE" "$INSUFFICIENT"
+check "the magic accounts fail every time" "s == 400 and b['code'] == 'INSUFFICIENT_FUNDS'"
+
+echo
+echo "Forced errors and latency"
+request POST "$V1/sandbox/simulate" "{\"error_code\": \"INTERNAL_ERROR\"}"
+check "simulating needs a login" "s == 401"
+request POST "$V1/sandbox/simulate" "{\"error_code\": \"NO_SUCH_CODE\"}" "${ADMIN[@]}"
+check "the error code must be a known one" "s == 400 and 'RATE_LIMITED' in b['error_codes']"
+request POST "$V1/sandbox/simulate" "{\"error_code\": \"INTERNAL_ERROR\", \"count\": 101}" "${ADMIN[@]}"
+check "at most 100 requests are affected" "s == 400"
+request POST "$V1/sandbox/simulate" "{}" "${ADMIN[@]}"
+check "a simulation must force something" "s == 400"
+request POST "$V1/sandbox/simulate" "{\"error_code\": \"service_unavailable\", \"count\": 2}" "${ADMIN[@]}"
+check "the caller forces its next two requests to fail" \
+    "s == 201 and b['simulation']['error_code'] == 'SERVICE_UNAVAILABLE' and b['simulation']['status'] == 503 and b['simulation']['remaining'] == 2"
+request GET "$V1/sandbox/simulate" "" "${ADMIN[@]}"
+check "sandbox routes show what is pending without using it up" "s == 200 and b['simulation']['remaining'] == 2"
+request GET "$V1/accounts/$SOURCE" "" "${TELLER[@]}"
+check "other callers are not affected" "s == 200"
+request GET "$V1/accounts/$SOURCE" "" "${ADMIN[@]}"
+check "the first request fails with the chosen code" "s == 503 and b['code'] == 'SERVICE_UNAVAILABLE' and h.get('x-sandbox-simulated') == 'true'"
+request GET "$V2/transactions?account_id=$SOURCE" "" "${ADMIN[@]}"
+check "v2 requests count too and get the v2 envelope" "s == 503 and b['error']['code'] == 'SERVICE_UNAVAILABLE'"
+request GET "$V1/accounts/$SOURCE" "" "${ADMIN[@]}"
+check "the caller's requests are handled again after the count" "s == 200"
+request GET "$V1/sandbox/simulate" "" "${ADMIN[@]}"
+check "nothing is pending then" "s == 200 and b['simulation'] is None"
+request POST "$V1/sandbox/simulate" "{\"error_code\": \"INTERNAL_ERROR\", \"status\": 502}" "${ADMIN[@]}"
+request GET "$V1/accounts/$SOURCE" "" "${ADMIN[@]}"
+check "the status can be chosen" "s == 502 and b['code'] == 'INTERNAL_ERROR'"
+request POST "$V1/sandbox/simulate" "{\"latency_ms\": 1500}" "${ADMIN[@]}"
+check "latency can be forced alone" "s == 201 and b['simulation']['latency_ms'] == 1500"
+request GET "$V1/accounts/$SOURCE" "" "${ADMIN[@]}"
+check "the request waits and then is handled" "s == 200 and t >= 1.5"
+request POST "$V1/sandbox/simulate" "{\"error_code\": \"RATE_LIMITED\", \"latency_ms\": 1000}" "${ADMIN[@]}"
+transfer "$SOURCE" "$TIMEOUT"
+check "latency and an error combine, and the error comes first on a transfer" "s == 429 and b['code'] == 'RATE_LIMITED' and 1 <= t < 2"
+request POST "$V1/sandbox/simulate" "{\"error_code\": \"CONFLICT\", \"count\": 5}" "${ADMIN[@]}"
+request DELETE "$V1/sandbox/simulate" "" "${ADMIN[@]}"
+check "a simulation can be cleared" "s == 200"
+request GET "$V1/accounts/$SOURCE" "" "${ADMIN[@]}"
+check "cleared simulations no longer apply" "s == 200"
+
+echo
+echo "Reset"
+request POST "$V1/sandbox/reset"
+check "the sandbox is reset" "s == 200"
+check "the magic accounts are opened again" \
+    "'$(sql "SELECT count(*) FROM accounts WHERE account_number LIKE 'ACC-SIM-%'")' == '3'"
+stop_server
+
+finish "sandbox simulation"
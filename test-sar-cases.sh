@@ -0,0 +1,128 @@
+#!/bin/bash
+
+# SAR Case Tests
+# Works suspicious activity report cases as a compliance user: a case opened for a customer links transactions,
+# which can then be neither reversed nor archived until it is filed, while unlinked ones still can. Notes and
+# narrative changes are taken while the case is active, statuses follow open -> investigating -> sar_filed or
+# closed_no_action, filing needs a narrative and closing a reason, and the export bundle carries the linked
+# transactions and notes. An overdue case is picked up by the sar-deadlines job. Admins cannot see cases. Users
+# are created with bankctl and given the compliance role, and postings are backdated, in the server's database, so
+# DB_PATH must be the database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-sar-cases.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-sar-cases.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="sar-test-$RUN_ID-Aa1!"
+ADMIN_USER="sar-admin-$RUN_ID"
+ANALYST="sar-analyst-$RUN_ID"
+INVESTIGATOR="sar-investigator-$RUN_ID"
+
+echo " SAR Case Tests"
+echo "==============="
+
+# post TYPE AMOUNT [DAY] - posts a transaction on the run's account, backdated to noon on DAY if given, and prints its ID
+post() {
+    request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"$1\", \"amount\": $2}" "${AUTH[@]}"
+    local id
+    id=$(field "['transaction']['id']")
+    if [ -n "$3" ]; then
+        sql "UPDATE transactions SET effective_date = '$3 12:00:00+00:00', created_at = '$3 12:00:00+00:00', value_date = '$3 00:00:00+00:00' WHERE id = $id" > /dev/null
+    fi
+    echo "$id"
+}
+
+# status CASE STATUS [EXTRA_JSON] - moves a case as the analyst
+status() {
+    request POST "$V1/compliance/cases/$1/status" "{\"status\": \"$2\"${3:+, $3}}" "${ANALYST_AUTH[@]}"
+}
+
+echo "Setup"
+AUTH=(-H "Authorization: Bearer $(staff "$ADMIN_USER")")
+ANALYST_AUTH=(-H "Authorization: Bearer $(staff "$ANALYST" compliance)")
+staff "$INVESTIGATOR" compliance > /dev/null
+request POST "$V1/customers" "{\"first_name\": \"Sam\", \"last_name\": \"Subject\", \"email\": \"sam-$RUN_ID@example.com\"}" "${AUTH[@]}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+ACCOUNT=$(field "['account']['id']")
+LINKED_DEPOSIT=$(post deposit 5000 2020-01-15)
+LINKED_WITHDRAWAL=$(post withdrawal 4900 2020-01-16)
+UNLINKED=$(post deposit 10 2020-01-17)
+LATEST=$(post deposit 50)
+
+echo
+echo "Opening a case"
+request GET "$V1/compliance/cases" "" "${AUTH[@]}"
+check "admins cannot see cases" "s == 403"
+request POST "$V1/compliance/cases" "{\"customer_id\": $CUSTOMER, \"transaction_ids\": [$LINKED_DEPOSIT, $LINKED_WITHDRAWAL, $LINKED_DEPOSIT]}" "${ANALYST_AUTH[@]}"
+check "a case is opened for a customer" "s == 201 and b['case']['status'] == 'open' and b['case']['case_number'].startswith('SAR') and b['case']['opened_by'] == '$ANALYST'"
+check "its transactions are linked once each" "sorted(t['transaction_id'] for t in b['case']['transactions']) == sorted([$LINKED_DEPOSIT, $LINKED_WITHDRAWAL])"
+check "it is due in 30 days" "b['case']['due_at'] > b['case']['created_at']"
+CASE=$(field "['case']['id']")
+request POST "$V1/compliance/cases" "{\"customer_id\": $CUSTOMER, \"assigned_to\": \"$ADMIN_USER\"}" "${ANALYST_AUTH[@]}"
+check "cases are assigned only to compliance users" "s == 400"
+request POST "$V1/compliance/cases" "{\"customer_id\": $((CUSTOMER + 1000000))}" "${ANALYST_AUTH[@]}"
+check "an unknown customer is not found" "s == 404"
+request POST "$V1/compliance/cases" '{"source_type": "fraud_score", "source_id": 1}' "${ANALYST_AUTH[@]}"
+check "only known alert types open cases" "s == 400"
+request POST "$V1/compliance/cases" '{"source_type": "duplicate_payment", "source_id": 999999999}' "${ANALYST_AUTH[@]}"
+check "an unknown alert is not found" "s == 404"
+request POST "$V1/compliance/cases" "{\"customer_id\": $CUSTOMER, \"transaction_ids\": [999999999]}" "${ANALYST_AUTH[@]}"
+check "an unknown transaction is refused" "s == 400"
+
+echo
+echo "Locked transactions"
+request POST "$V1/transactions/$LINKED_DEPOSIT/reverse" '{"reason": "test"}' "${AUTH[@]}"
+check "a linked transaction cannot be reversed" "s == 409 and b['code'] == 'TRANSACTION_LOCKED'"
+request POST "$V1/transactions/$LATEST/reverse" '{"reason": "test"}' "${AUTH[@]}"
+check "an unlinked one still can" "s in (200, 201)"
+run_job archive "${AUTH[@]}"
+check "linked transactions are not archived" "$(sql "SELECT COUNT(*) FROM transactions WHERE id IN ($LINKED_DEPOSIT, $LINKED_WITHDRAWAL)") == 2"
+check "unlinked ones are" "$(sql "SELECT COUNT(*) FROM transactions_archive WHERE id = $UNLINKED") == 1"
+
+echo
+echo "Investigation"
+request POST "$V1/compliance/cases/$CASE/notes" '{"body": "Structured deposits just under the reporting threshold"}' "${ANALYST_AUTH[@]}"
+check "a note is recorded" "s == 201 and b['note']['author'] == '$ANALYST'"
+request POST "$V1/compliance/cases/$CASE/notes" '{"body": " "}' "${ANALYST_AUTH[@]}"
+check "an empty note is refused" "s == 400"
+status "$CASE" sar_filed
+check "an open case cannot be filed" "s == 409 and b['code'] == 'INVALID_STATUS_TRANSITION' and b['allowed'] == ['investigating', 'closed_no_action']"
+status "$CASE" investigating
+check "starting the investigation assigns the case to the caller" "s == 200 and b['case']['status'] == 'investigating' and b['case']['assigned_to'] == '$ANALYST'"
+status "$CASE" sar_filed
+check "a SAR needs a narrative" "s == 400"
+YESTERDAY=$(python3 -c "import datetime; print(datetime.date.today() - datetime.timedelta(days=2))")
+request PUT "$V1/compliance/cases/$CASE" "{\"narrative\": \"Funds moved in and out within a day\", \"assigned_to\": \"$INVESTIGATOR\", \"due_at\": \"$YESTERDAY\"}" "${ANALYST_AUTH[@]}"
+check "the narrative, investigator and deadline change" "s == 200 and b['case']['assigned_to'] == '$INVESTIGATOR' and b['case']['narrative'].startswith('Funds') and b['case']['due_at'].startswith('$YESTERDAY')"
+run_job sar-deadlines "${AUTH[@]}"
+request GET "$V1/compliance/cases/$CASE" "" "${ANALYST_AUTH[@]}"
+check "an overdue case is notified" "b['case'].get('overdue_notified_at') is not None"
+check "the case shows its links and notes" "len(b['case']['transactions']) == 2 and len(b['case']['notes']) == 1"
+
+echo
+echo "Export and filing"
+request GET "$V1/compliance/cases/$CASE/export" "" "${ANALYST_AUTH[@]}"
+check "the bundle carries the case, subject and linked transactions" "s == 200 and b['case']['id'] == $CASE and b['subject']['id'] == $CUSTOMER and sorted(t['id'] for t in b['transactions']) == sorted([$LINKED_DEPOSIT, $LINKED_WITHDRAWAL])"
+check "the bundle carries the notes and a manifest" "len(b['notes']) == 1 and b['attachments_manifest'] == [] and b['generated_by'] == '$ANALYST'"
+status "$CASE" sar_filed '"filing_reference": "BSA-'"$RUN_ID"'"'
+check "the SAR is filed" "s == 200 and b['case']['status'] == 'sar_filed' and b['case']['filing_reference'] == 'BSA-$RUN_ID' and b['case']['closed_by'] == '$ANALYST'"
+request POST "$V1/compliance/cases/$CASE/notes" '{"body": "Late note"}' "${ANALYST_AUTH[@]}"
+check "a filed case takes no more notes" "s == 409"
+request POST "$V1/transactions/$LINKED_WITHDRAWAL/reverse" '{"reason": "test"}' "${AUTH[@]}"
+check "its transactions are unlocked" "s in (200, 201)"
+
+echo
+echo "Closing without action"
+request POST "$V1/compliance/cases" "{\"customer_id\": $CUSTOMER}" "${ANALYST_AUTH[@]}"
+OTHER=$(field "['case']['id']")
+status "$OTHER" closed_no_action
+check "closing needs a reason" "s == 400 and b['code'] == 'REASON_REQUIRED'"
+status "$OTHER" closed_no_action '"reason": "Payroll timing explained by employer letter"'
+check "a case closes without a report" "s == 200 and b['case']['status'] == 'closed_no_action' and b['case']['close_reason'].startswith('Payroll')"
+request GET "$V1/compliance/cases?customer_id=$CUSTOMER" "" "${ANALYST_AUTH[@]}"
+check "closed and filed cases leave the default list" "s == 200 and b['total'] == 0"
+request GET "$V1/compliance/cases?customer_id=$CUSTOMER&status=all" "" "${ANALYST_AUTH[@]}"
+check "status=all lists them" "b['total'] == 2"
+
+finish "SAR case"
@@ -0,0 +1,142 @@
+#!/bin/bash
+
+# Transaction Search Tests
+# Posts transactions with known descriptions, references and merchant names to a fresh account, and checks
+# GET /accounts/:id/transactions?q= against them: single words across all three columns, quoted phrases, prefix*
+# terms, and queries full of characters with meaning to FTS5 or LIKE, which must neither fail nor match more than
+# their words. Also checks that search combines with the type and amount filters, paginates with a stable total and
+# stays within the account. With the FTS5 index in place (server built with -tags sqlite_fts5) it checks ranking and
+# that a bare word does not match longer words; otherwise the server's substring fallback is tested. DB_PATH must
+# be the database the server uses, to tell which searcher is active. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-search.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... ./test-search.sh
+
+source "$(dirname "$0")/test-lib.sh"
+CHECK_HELPERS="ids = set(t['id'] for t in (b or {}).get('transactions') or [])"
+
+echo " Transaction Search Tests"
+echo "========================="
+
+# post ACCOUNT TYPE AMOUNT DESCRIPTION [REFERENCE] [MERCHANT] - posts a transaction and prints its id
+post() {
+    local body
+    body=$(python3 -c "import json, sys; print(json.dumps({'account_id': int(sys.argv[1]), 'transaction_type': sys.argv[2],
+    'amount': float(sys.argv[3]), 'description': sys.argv[4], 'reference': sys.argv[5], 'merchant_name': sys.argv[6]}))" \
+        "$1" "$2" "$3" "$4" "${5:-}" "${6:-}")
+    request POST "$V1/transactions" "$body"
+    field "['transaction']['id']"
+}
+
+# search ACCOUNT QUERY [PARAMS] - searches an account's history, URL-encoding the query
+search() {
+    request GET "$V1/accounts/$1/transactions?q=$(python3 -c "import sys, urllib.parse; print(urllib.parse.quote(sys.argv[1]))" "$2")$3"
+}
+
+echo "Setup"
+request POST "$V1/customers" "{\"first_name\": \"Search\", \"last_name\": \"Tester\", \"email\": \"search-$RUN_ID@example.test\", \"date_of_birth\": \"1980-01-01\"}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}"
+ACCOUNT=$(field "['account']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"savings\"}"
+OTHER=$(field "['account']['id']")
+
+PAYROLL=$(post "$ACCOUNT" deposit 1000 "Payroll ACME Corp" "PAY-2026-01")
+ELECTRIC=$(post "$ACCOUNT" withdrawal 82.15 "Electric bill March" "INV/4471" "City Electric & Gas")
+CAFE=$(post "$ACCOUNT" withdrawal 40 "Coffee and pastries" "" "Electrolux Cafe")
+HEATER=$(post "$ACCOUNT" withdrawal 60 "Bill for electric heater")
+ORGANIC=$(post "$ACCOUNT" withdrawal 15 "O'Brien's 100% organic" "" "O'Brien's")
+post "$OTHER" deposit 30 "Electric bill April" > /dev/null
+check "transactions are posted" "'$PAYROLL$ELECTRIC$CAFE$HEATER$ORGANIC'.isdigit()"
+FTS5=$(python3 -c "
+import sqlite3, sys
+print(sqlite3.connect(sys.argv[1], timeout=10).execute(\"SELECT count(*) FROM sqlite_master WHERE name = 'transactions_fts'\").fetchone()[0])" "$DB_PATH")
+if [ "$FTS5" = 1 ]; then
+    echo "  (server searches with FTS5)"
+else
+    echo "  (server searches with the substring fallback)"
+fi
+
+echo
+echo "Words"
+search "$ACCOUNT" "electric"
+check "a word matches descriptions and merchant names" "s == 200 and ids == {$ELECTRIC, $HEATER} and b['total'] == 2 and b['query'] == 'electric'"
+search "$ACCOUNT" "ELECTRIC"
+check "matching ignores case" "s == 200 and ids == {$ELECTRIC, $HEATER}"
+search "$ACCOUNT" "PAY-2026-01"
+check "a reference matches" "s == 200 and ids == {$PAYROLL}"
+search "$ACCOUNT" "acme payroll"
+check "every word must match, in any order" "s == 200 and ids == {$PAYROLL}"
+search "$ACCOUNT" "acme electric"
+check "words matching different transactions match neither" "s == 200 and ids == set() and b['total'] == 0"
+search "$OTHER" "electric"
+check "another account's history is searched separately" "s == 200 and len(ids) == 1 and not ids & {$ELECTRIC, $HEATER}"
+
+echo
+echo "Phrases"
+search "$ACCOUNT" '"electric bill"'
+check "a quoted phrase matches only the words together and in order" "s == 200 and ids == {$ELECTRIC}"
+search "$ACCOUNT" '"bill electric"'
+check "the phrase reversed matches nothing" "s == 200 and ids == set()"
+search "$ACCOUNT" '"electric bill" march'
+check "a phrase combines with a word" "s == 200 and ids == {$ELECTRIC}"
+search "$ACCOUNT" '"electric bill'
+check "an unterminated quote is read as a phrase to the end" "s == 200 and ids == {$ELECTRIC}"
+
+echo
+echo "Prefixes"
+search "$ACCOUNT" "electr*"
+check "a prefix matches every word that starts with it" "s == 200 and ids == {$ELECTRIC, $CAFE, $HEATER}"
+search "$ACCOUNT" "pastr* coff*"
+check "several prefixes must all match" "s == 200 and ids == {$CAFE}"
+if [ "$FTS5" = 1 ]; then
+    search "$ACCOUNT" "electr"
+    check "without the star a word matches only itself" "s == 200 and ids == set()"
+fi
+
+echo
+echo "Special characters"
+search "$ACCOUNT" "O'Brien's"
+check "an apostrophe is matched, not treated as a quote" "s == 200 and ids == {$ORGANIC}"
+search "$ACCOUNT" "100%"
+check "a percent sign is not a wildcard" "s == 200 and ids == {$ORGANIC}"
+search "$ACCOUNT" "INV/4471"
+check "a slash within a reference matches" "s == 200 and ids == {$ELECTRIC}"
+search "$ACCOUNT" "City Electric & Gas"
+check "an ampersand matches" "s == 200 and ids == {$ELECTRIC}"
+search "$ACCOUNT" "electric OR coffee"
+check "OR is a word to match, not an operator" "s == 200 and ids == set()"
+search "$ACCOUNT" "electric NOT heater"
+check "NOT is a word to match, not an operator" "s == 200 and ids == set()"
+search "$ACCOUNT" 'description:electric'
+check "a column filter is not honoured" "s == 200 and ids == set()"
+search "$ACCOUNT" '( ) ^ : * + - " {} NEAR(a b)'
+check "a query of syntax characters does not fail" "s == 200"
+search "$ACCOUNT" '%'
+check "a lone wildcard matches nothing" "s == 200 and ids == set() and b['total'] == 0"
+search "$ACCOUNT" '_'
+check "a lone underscore matches nothing" "s == 200 and ids == set()"
+
+echo
+echo "Filters and pages"
+search "$ACCOUNT" "electr*" "&type=withdrawal&min_amount=50"
+check "search combines with the type and amount filters" "s == 200 and ids == {$ELECTRIC, $HEATER} and b['total'] == 2"
+search "$ACCOUNT" "electr*" "&max_amount=50"
+check "a maximum amount narrows it further" "s == 200 and ids == {$CAFE}"
+search "$ACCOUNT" "electr*" "&type=deposit"
+check "a type with no matches returns none" "s == 200 and ids == set() and b['total'] == 0"
+search "$ACCOUNT" "electr*" "&limit=2&page=1"
+FIRST=$BODY
+check "the first page holds the limit and the total counts every match" "s == 200 and len(ids) == 2 and b['total'] == 3 and b['page'] == 1"
+search "$ACCOUNT" "electr*" "&limit=2&page=2"
+check "the second page holds the rest, with nothing repeated" \
+    "s == 200 and b['total'] == 3 and len(ids) == 1 and not ids & set(t['id'] for t in json.loads('''$FIRST''')['transactions'])"
+
+if [ "$FTS5" = 1 ]; then
+    echo
+    echo "Ranking"
+    search "$ACCOUNT" "electric"
+    check "the transaction matching in more columns ranks first" "s == 200 and b['transactions'][0]['id'] == $ELECTRIC"
+fi
+
+finish "search"
@@ -0,0 +1,152 @@
+#!/bin/bash
+
+# SIEM Forwarding Tests
+# Starts its own server with the audit log forwarder pointed at a fake sink, and checks that audited requests
+# reach the sink in id order with the bearer token, personal data in paths redacted, and no entry missing. The
+# sink is then taken down: deliveries fail, the circuit breaker opens and lag builds up. The server is restarted
+# while the sink is still down; once it recovers, forwarding resumes from the persisted high-water mark, so every
+# entry arrives exactly in order and nothing acknowledged before the restart is sent again. Finally the server is
+# restarted with a syslog sink and a message is checked for RFC 5424 framing. The server, sink and database are
+# the script's own, so no other server is needed. Exits non-zero on failure.
+#
+# Usage: ./test-siem.sh                                   (builds the server with go build)
+#        SERVER_BIN=./banking-app BANKCTL=./bankctl PORT=18097 SINK_PORT=18098 ./test-siem.sh
+
+source "$(dirname "$0")/test-lib.sh"
+serve 18097
+SINK_PORT="${SINK_PORT:-18098}"
+PASSWORD="siem-test-$RUN_ID-Aa1!"
+# Checks see the events the sink received as `events` and the audit entry ids as `ids`
+CHECK_HELPERS="
+events = [json.loads(line) for line in open('$WORK/received')]
+ids = [r[0] for r in __import__('sqlite3').connect('$DB_PATH', timeout=10).execute('SELECT id FROM audit_entries ORDER BY id')]"
+
+echo " SIEM Forwarding Tests"
+echo "======================"
+
+# siem [ENV...] - starts the script's server with the forwarder's settings and the given sink
+siem() {
+    start_server "$SERVER_BIN" SIEM_INTERVAL_SECONDS=1 SIEM_BATCH_SIZE=3 SIEM_BREAKER_FAILURES=2 SIEM_BREAKER_COOLDOWN_SECONDS=2 "$@"
+}
+
+# https_sink - records each POSTed event as a line, answering 503 while $WORK/down exists
+https_sink() {
+    python3 -c "
+import http.server, json, os, sys
+class Sink(http.server.BaseHTTPRequestHandler):
+    def do_POST(self):
+        body = self.rfile.read(int(self.headers.get('Content-Length', 0)))
+        if os.path.exists(sys.argv[2] + '/down') or self.headers.get('Authorization') != 'Bearer sink-token':
+            self.send_response(503)
+            self.end_headers()
+            return
+        with open(sys.argv[2] + '/received', 'a') as f:
+            for event in json.loads(body):
+                f.write(json.dumps(event) + '\n')
+        self.send_response(204)
+        self.end_headers()
+    def log_message(self, *args):
+        pass
+http.server.HTTPServer(('127.0.0.1', int(sys.argv[1])), Sink).serve_forever()
+" "$SINK_PORT" "$WORK" &
+    SINK_PID=$!
+    background $SINK_PID
+}
+
+# caught_up - waits for the forwarder to acknowledge every audit entry, leaving its status in BODY
+caught_up() {
+    for _ in $(seq 1 50); do
+        request GET "$V1/admin/siem" "" "${ADMIN[@]}"
+        [ "$(field "['status']['pending']" 2>/dev/null)" = "0" ] && return
+        sleep 0.2
+    done
+}
+
+# audited N - makes N audited requests: updates of a customer that does not exist, with personal data in the path
+audited() {
+    for i in $(seq 1 "$1"); do
+        request PUT "$V1/customers/4111111111111111?email=jane.doe@example.com&page=$i" "{}" "${ADMIN[@]}"
+    done
+}
+
+build
+touch "$WORK/received"
+
+echo "Setup"
+https_sink
+siem SIEM_SINK=https SIEM_ENDPOINT="http://127.0.0.1:$SINK_PORT/ingest" SIEM_TOKEN=sink-token
+ADMIN=(-H "Authorization: Bearer $(staff "siem-admin")")
+request GET "$V1/admin/siem" "" "${ADMIN[@]}"
+check "forwarding is enabled with the https sink" "s == 200 and b['enabled'] and b['status']['sink'] == 'https' and b['status']['breaker'] == 'closed'"
+
+echo
+echo "Delivery"
+audited 7
+caught_up
+check "every audit entry is acknowledged" "b['status']['high_water_mark'] == ids[-1] and b['status']['lag_seconds'] == 0"
+check "entries arrive in id order with none missing" "[e['id'] for e in events] == ids and len(ids) >= 7"
+check "each is an audit event" "all(e['event_type'] == 'audit.request' and e['username'] == 'siem-admin' and e['route'] for e in events)"
+check "emails and other query values are redacted" "all('jane.doe' not in e['path'] and 'email=REDACTED' in e['path'] for e in events[-7:])"
+check "account numbers keep only their last four digits" "all('4111111111111111' not in e['path'] and e['path'].endswith('&page=' + str(i + 1)) and '1111?' in e['path'] for i, e in enumerate(events[-7:]))"
+curl -s "$BASE_URL/metrics" > "$WORK/metrics"
+BODY='{}' STATUS=200
+check "forwarded entries are counted" "int(float([l.split()[1] for l in open('$WORK/metrics') if l.startswith('siem_events_forwarded_total ')][0])) == len(ids)"
+
+echo
+echo "Sink outage"
+touch "$WORK/down"
+audited 4
+sleep 3
+request GET "$V1/admin/siem" "" "${ADMIN[@]}"
+check "the breaker opens after repeated failures" "b['status']['breaker'] in ('open', 'half_open') and b['status']['consecutive_failures'] >= 2 and '503' in b['status']['last_error']"
+check "undelivered entries are pending and lagging" "b['status']['pending'] >= 4 and b['status']['lag_seconds'] > 0"
+curl -s "$BASE_URL/metrics" > "$WORK/metrics"
+BODY='{}' STATUS=200
+check "failed deliveries and lag are exported" "any(l.startswith('siem_delivery_failures_total ') for l in open('$WORK/metrics')) and any(l.startswith('siem_lag_seconds ') and float(l.split()[1]) > 0 for l in open('$WORK/metrics'))"
+DELIVERED=$(wc -l < "$WORK/received")
+
+echo
+echo "Restart"
+stop_server
+siem SIEM_SINK=https SIEM_ENDPOINT="http://127.0.0.1:$SINK_PORT/ingest" SIEM_TOKEN=sink-token
+audited 2
+sleep 1.5
+check "nothing is delivered while the sink is still down" "len(events) == $DELIVERED"
+rm -f "$WORK/down"
+sleep 1
+caught_up
+check "forwarding resumes from the high-water mark once the sink recovers" "b['status']['pending'] == 0 and b['status']['breaker'] == 'closed'"
+check "every entry arrived exactly once, in order" "[e['id'] for e in events] == ids"
+
+echo
+echo "Syslog"
+stop_server
+python3 -c "
+import socket, sys
+server = socket.socket()
+server.setsockopt(socket.SOL_SOCKET, socket.SO_REUSEADDR, 1)
+server.bind(('127.0.0.1', int(sys.argv[1])))
+server.listen(1)
+conn, _ = server.accept()
+with open(sys.argv[2], 'wb') as f:
+    while True:
+        data = conn.recv(65536)
+        if not data:
+            break
+        f.write(data)
+" "$SINK_PORT" "$WORK/syslog" &
+LISTENER=$!
+background $LISTENER
+kill "$SINK_PID" 2>/dev/null; wait "$SINK_PID" 2>/dev/null
+sleep 0.3
+siem SIEM_SINK=syslog SIEM_ENDPOINT="tcp://127.0.0.1:$SINK_PORT"
+audited 1
+caught_up
+wait "$LISTENER" 2>/dev/null
+BODY='{}' STATUS=200
+check "a syslog message is octet-counted RFC 5424 at facility log audit" "
+    (lambda raw: raw.split(' ', 1)[1].startswith('<110>1 ') and int(raw.split(' ', 1)[0]) == len(raw.split(' ', 1)[1].encode())
+     and ' banking-app - audit - ' in raw and json.loads(raw.split(' - audit - ', 1)[1])['id'] == ids[-1]
+    )(open('$WORK/syslog').read())"
+
+finish "SIEM forwarding"
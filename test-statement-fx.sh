@@ -0,0 +1,118 @@
+#!/bin/bash
+
+# Consolidated Statement FX Tests
+# Checks that a consolidated statement totals accounts in several currencies in the base currency at the closing
+# rates of the statement's last day, annotating each converted account with its rate, that generation fails
+# naming every missing currency pair rather than reaching for another day's rate, that regenerating an old
+# statement after current rates change gives identical figures, and conversion through USD for a tenant whose
+# base currency is not USD. Each run creates its own tenant; the platform admin is created with bankctl, and the
+# accounts are moved into last month in the server's database, so DB_PATH must be the database the server uses.
+# Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-statement-fx.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-statement-fx.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="stmt-fx-test-$RUN_ID-Aa1!"
+PLATFORM_USER="stmt-fx-platform-$RUN_ID"
+TENANT_CODE="sfx$RUN_ID"
+
+echo " Consolidated Statement FX Tests"
+echo "================================"
+
+# day OFFSET - prints the last day of last month moved by OFFSET days, as YYYY-MM-DD
+day() {
+    python3 -c "
+import datetime, sys
+close = datetime.datetime.now(datetime.timezone.utc).date().replace(day=1) - datetime.timedelta(days=1)
+print((close + datetime.timedelta(days=int(sys.argv[1]))).isoformat())" "$1"
+}
+
+# rate CURRENCY DATE RATE - enters a closing rate for a day
+rate() {
+    request PUT "$V1/admin/fx-rates" "{\"currency\": \"$1\", \"date\": \"$2\", \"rate\": $3, \"source\": \"test\"}" "${AUTH[@]}"
+}
+
+# account CURRENCY AMOUNT - opens an account for the customer and deposits into it, printing its id
+account() {
+    request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"savings\", \"currency\": \"$1\"}" "${AUTH[@]}"
+    local id
+    id=$(field "['account']['id']")
+    request POST "$V1/transactions" "{\"account_id\": $id, \"transaction_type\": \"deposit\", \"amount\": $2}" "${AUTH[@]}"
+    echo "$id"
+}
+
+# section CURRENCY - the expression for the statement's account section in a currency
+section() {
+    echo "[a for a in b['accounts'] if a['currency'] == '$1'][0]"
+}
+
+CLOSE=$(day 0)
+MONTH=$(python3 -c "import sys; print(sys.argv[1][:7].replace('-', '/'))" "$CLOSE")
+TODAY=$(date -u +%Y-%m-%d)
+
+echo "Setup"
+tenant "$PLATFORM_USER" "Statement FX" stmt-fx-admin
+request POST "$V1/customers" "{\"first_name\": \"Multi\", \"last_name\": \"Currency\", \"email\": \"stmt-fx-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\", \"monthly_income\": 10000}" "${AUTH[@]}"
+CUSTOMER=$(field "['customer']['id']")
+account USD 250 > /dev/null
+account EUR 1000 > /dev/null
+account GBP 200 > /dev/null
+# Everything is moved into last month, whose statement is the one under test
+sql "UPDATE accounts SET created_at = '$(day -20) 09:00:00+00:00' WHERE tenant_id = $TENANT AND customer_id = $CUSTOMER" > /dev/null
+sql "UPDATE transactions SET effective_date = '$(day -10) 12:00:00+00:00', created_at = '$(day -10) 12:00:00+00:00' WHERE tenant_id = $TENANT" > /dev/null
+
+echo
+echo "Missing rates"
+request GET "$V1/customers/$CUSTOMER/statements/$MONTH" "" "${AUTH[@]}"
+check "generation fails naming every missing pair" \
+    "s == 422 and b['code'] == 'MISSING_FX_RATES' and b['missing_pairs'] == ['EUR/USD', 'GBP/USD'] and b['rate_date'] == '$CLOSE'"
+rate EUR "$CLOSE" 1.1
+rate GBP "$(day -1)" 1.3
+rate GBP "$TODAY" 1.3
+request GET "$V1/customers/$CUSTOMER/statements/$MONTH" "" "${AUTH[@]}"
+check "another day's rate is never used instead" "s == 422 and b['missing_pairs'] == ['GBP/USD'] and '$CLOSE' in b['error']"
+
+echo
+echo "Conversion"
+rate GBP "$CLOSE" 1.27
+request GET "$V1/customers/$CUSTOMER/statements/$MONTH" "" "${AUTH[@]}"
+FIRST="$BODY"
+check "the statement is generated once every rate is in" "s == 200 and b['base_currency'] == 'USD' and b['rates_as_of'].startswith('$CLOSE')"
+check "totals are in the base currency" "b['total_assets'] == 1604 and b['net_movement'] == 1604"
+check "each converted figure carries its rate and day" \
+    "all(c['currency'] == 'USD' and c['rate_date'].startswith('$CLOSE') for c in [$(section EUR)['converted'], $(section GBP)['converted']])
+     and $(section EUR)['converted']['rate'] == 1.1 and $(section EUR)['converted']['closing_balance'] == 1100
+     and $(section GBP)['converted']['rate'] == 1.27 and $(section GBP)['converted']['net_movement'] == 254"
+check "accounts keep their own currency's figures" "$(section EUR)['summary']['closing_balance'] == 1000 and $(section GBP)['summary']['closing_balance'] == 200"
+check "base currency accounts are not converted" "'converted' not in $(section USD)"
+request GET "$V1/customers/$CUSTOMER/statements/$(date -u +%Y/%m)" "" "${AUTH[@]}"
+check "this month's statement waits for its last day's rates" \
+    "s == 422 and b['rate_date'] >= '$TODAY' and 'EUR/USD' in b['missing_pairs']"
+
+echo
+echo "Regeneration"
+rate EUR "$TODAY" 1.5
+rate GBP "$TODAY" 1.9
+rate EUR "$(day -1)" 0.5
+request GET "$V1/customers/$CUSTOMER/statements/$MONTH" "" "${AUTH[@]}"
+check "regenerating after rates change gives the same statement" "s == 200 and b == json.loads('''$FIRST''')"
+check "the figures are unchanged" "b['total_assets'] == 1604 and $(section EUR)['converted']['rate'] == 1.1"
+OUT=$(mktemp)
+curl -s -o "$OUT" "$V1/customers/$CUSTOMER/statements/$MONTH?format=pdf" "${AUTH[@]}"
+BODY='{}' STATUS=200
+check "the PDF names the base currency" "$(grep -ac 'Totals in USD' "$OUT") == 1"
+check "and the rate each account was converted at" "$(grep -ac "at 1.1 USD per EUR" "$OUT") == 1"
+rm -f "$OUT"
+
+echo
+echo "Other base currencies"
+request PUT "$V1/admin/tenants/$TENANT" "{\"settings\": {\"default_currency\": \"EUR\"}}" "${PLATFORM[@]}"
+check "the tenant's base currency is changed" "s == 200"
+request GET "$V1/customers/$CUSTOMER/statements/$MONTH" "" "${AUTH[@]}"
+check "totals are in the tenant's base currency" "s == 200 and b['base_currency'] == 'EUR'"
+check "other currencies are converted through USD" \
+    "$(section USD)['converted']['rate'] == 0.90909091 and $(section USD)['converted']['closing_balance'] == 227.27 and $(section GBP)['converted']['closing_balance'] == 230.91"
+check "and the base currency's account is not converted" "'converted' not in $(section EUR) and b['total_assets'] == 1458.18"
+
+finish "consolidated statement FX"
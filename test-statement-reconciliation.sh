@@ -0,0 +1,129 @@
+#!/bin/bash
+
+# Statement Reconciliation Tests
+# Uploads the fixture statements in reconcile/testdata against a tenant's cash account. Customer postings are
+# backdated in the server's database to the fixture's March dates: the invoice matches, a wire booked weeks late and
+# a bank charge are left for review. Checks the report counts, pairing the wire by hand with a mismatch refused,
+# adjusting the charge to suspense, the reconciled status, a re-upload finding nothing left to match, and upload
+# errors. Parsing, matching and the report model are covered in detail by go test ./reconcile. Each run creates
+# its own tenant; the platform admin is created with bankctl, so DB_PATH must be the database the server uses.
+# Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-statement-reconciliation.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-statement-reconciliation.sh
+
+source "$(dirname "$0")/test-lib.sh"
+FIXTURES="$(cd "$(dirname "$0")" && pwd)/reconcile/testdata"
+PASSWORD="reconcile-test-$RUN_ID-Aa1!"
+TENANT_CODE="rec$RUN_ID"
+
+echo " Statement Reconciliation Tests"
+echo "==============================="
+
+# post TYPE AMOUNT DATE - posts a customer transaction and moves it and its cash offset to DATE
+post() {
+    request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"$1\", \"amount\": $2}" "${AUTH[@]}"
+    local id
+    id=$(field "['transaction']['id']")
+    sql "UPDATE transactions SET effective_date = '$3 12:00:00+00:00', value_date = '$3 00:00:00+00:00' WHERE id = $id OR offset_of_id = $id" > /dev/null
+}
+
+# upload FILE [CURL_ARGS...] - uploads a fixture statement
+upload() {
+    local file=$1
+    shift
+    local out
+    out=$(mktemp)
+    STATUS=$(curl -s -o "$out" -w '%{http_code}' -X POST "$V1/admin/reconcile/statement" "${AUTH[@]}" -F "file=@$FIXTURES/$file" "$@")
+    BODY=$(cat "$out")
+    rm -f "$out"
+}
+
+# item STATUS AMOUNT - prints the id of the report's item with the status and statement or posting amount
+item() {
+    request GET "$V1/admin/reconcile/reports/$REPORT?status=$1" "" "${AUTH[@]}"
+    python3 -c "
+import json, sys
+for i in json.loads(sys.argv[1])['items']:
+    if float(i['external_amount'] or i.get('posting', {}).get('amount', 0)) == float(sys.argv[2]):
+        print(i['id'])
+        break" "$BODY" "$2"
+}
+
+echo "Setup"
+tenant "reconcile-platform-$RUN_ID" "Reconciliation" reconcile-admin
+request POST "$V1/customers" "{\"first_name\": \"Statement\", \"last_name\": \"Payer\", \"email\": \"reconcile-$RUN_ID@example.test\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\", \"currency\": \"USD\"}" "${AUTH[@]}"
+ACCOUNT=$(field "['account']['id']")
+post deposit 1500 2026-03-02
+post withdrawal 250.50 2026-03-20
+check "an invoice and a late wire are booked in March" "'$CUSTOMER$ACCOUNT'.isdigit()"
+
+echo
+echo "Import"
+upload camt053.001.02.xml
+REPORT=$(field "['report']['id']")
+check "the camt.053 statement is reconciled against cash" "s == 201 and b['report']['format'] == 'camt053' and b['report']['filename'] == 'camt053.001.02.xml'"
+check "the statement header is recorded" \
+    "b['report']['statement_id'] == 'STMT-2026-03' and b['report']['external_account'] == 'GB33BUKB20201555555555' and b['report']['currency'] == 'USD' and b['report']['tolerance_days'] == 2"
+check "the invoice matches and the wire and charge are left for review" \
+    "b['report']['status'] == 'open' and (b['report']['matched'], b['report']['unmatched_theirs'], b['report']['unmatched_ours'], b['report']['adjusted']) == (1, 2, 1, 0)"
+request GET "$V1/admin/reconcile/reports/$REPORT" "" "${AUTH[@]}"
+check "the pending entry is skipped" "len(b['items']) == 4 and all(float(i['external_amount']) != 99 for i in b['items'])"
+check "the matched line carries its posting" \
+    "[(float(i['external_amount']), i['matched_by'], float(i['posting']['amount'])) for i in b['items'] if i['status'] == 'matched'] == [(1500, 'auto', 1500)]"
+request GET "$V1/admin/reconcile/reports?status=open" "" "${AUTH[@]}"
+check "the report is listed as open" "s == 200 and [r['id'] for r in b['reports']] == [$REPORT]"
+
+echo
+echo "Review"
+WIRE=$(item unmatched_theirs 250.5)
+CHARGE=$(item unmatched_theirs 40.1)
+OURS=$(item unmatched_ours 250.5)
+request POST "$V1/admin/reconcile/reports/$REPORT/match" "{\"statement_item_id\": $CHARGE, \"ledger_item_id\": $OURS}" "${AUTH[@]}"
+check "pairing the charge with the wire posting is refused" "s == 409"
+request POST "$V1/admin/reconcile/reports/$REPORT/match" "{\"statement_item_id\": $WIRE}" "${AUTH[@]}"
+check "pairing needs both items" "s == 400"
+request POST "$V1/admin/reconcile/reports/$REPORT/match" "{\"statement_item_id\": $WIRE, \"ledger_item_id\": $OURS}" "${AUTH[@]}"
+check "the wire is paired with its late posting" \
+    "s == 200 and b['item']['status'] == 'matched' and b['item']['matched_by'] == 'reconcile-admin' and (b['report']['matched'], b['report']['unmatched_ours']) == (2, 0)"
+request POST "$V1/admin/reconcile/reports/$REPORT/match" "{\"statement_item_id\": $WIRE, \"ledger_item_id\": $OURS}" "${AUTH[@]}"
+check "the posting's item is gone once paired" "s == 404"
+request POST "$V1/admin/reconcile/reports/$REPORT/items/$CHARGE/adjust" '{"offset_code": "CASH"}' "${AUTH[@]}"
+check "the charge cannot be offset to the account itself" "s == 400"
+request POST "$V1/admin/reconcile/reports/$REPORT/items/$CHARGE/adjust" '{}' "${AUTH[@]}"
+check "the charge is adjusted on its statement date" \
+    "s == 200 and b['item']['status'] == 'adjusted' and b['transaction']['transaction_type'] == 'deposit' and b['transaction']['effective_date'][:10] == '2026-03-05'"
+ADJUSTMENT=$(field "['transaction']['id']")
+check "the report is reconciled" "b['report']['status'] == 'reconciled' and (b['report']['matched'], b['report']['adjusted']) == (2, 1)"
+check "the adjustment is offset to suspense" \
+    "'$(sql "SELECT a.account_number FROM transactions t JOIN accounts a ON a.id = t.account_id WHERE t.offset_of_id = $ADJUSTMENT")'.split('-')[2] == 'SUSPENSE'"
+request POST "$V1/admin/reconcile/reports/$REPORT/items/$CHARGE/adjust" '{}' "${AUTH[@]}"
+check "an adjusted line is not adjusted twice" "s == 409"
+request GET "$V1/admin/reconcile/reports?status=open" "" "${AUTH[@]}"
+check "the report leaves the open list" "s == 200 and b['total'] == 0"
+
+echo
+echo "Re-import"
+upload statement.csv -F format=csv
+check "the CSV copy finds every posting already matched or booked" \
+    "s == 201 and b['report']['format'] == 'csv' and (b['report']['matched'], b['report']['unmatched_theirs'], b['report']['unmatched_ours']) == (0, 4, 0)"
+
+echo
+echo "Errors"
+upload statement.csv -F tolerance_days=-1
+check "a negative tolerance is rejected" "s == 400"
+upload statement.csv -F format=mt940
+check "an unknown format is rejected" "s == 400"
+upload camt053.001.02.xml -F format=csv
+check "a camt.053 file read as CSV is rejected" "s == 400"
+upload statement.csv -F account_id=$ACCOUNT
+check "a customer account cannot be reconciled" "s == 400"
+CASH=$(sql "SELECT id FROM accounts WHERE account_number LIKE 'GL-%-CASH-USD' AND tenant_id = (SELECT id FROM tenants WHERE code = '$TENANT_CODE')")
+upload camt053.001.08.xml -F account_id=$CASH
+check "a EUR statement cannot be reconciled against USD cash" "s == 400"
+request POST "$V1/admin/reconcile/statement" "" "${AUTH[@]}"
+check "a file is required" "s == 400"
+
+finish "statement reconciliation"
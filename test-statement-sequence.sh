@@ -0,0 +1,116 @@
+#!/bin/bash
+
+# Statement Sequence Tests
+# Checks that archived statements are numbered by month from each account's first one, that the archive listing
+# flags a skipped month as a gap, that repair fills it under its own number without sending it, and that a statement
+# whose opening balance is not the previous statement's closing balance is still archived but opens a
+# statement_continuity violation, which the invariants job keeps until the earlier statements are regenerated.
+# Postings are backdated into past months in the server's database, so DB_PATH must be the database the server
+# uses; the platform admin is created with bankctl. Each run creates its own tenant. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-statement-sequence.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-statement-sequence.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="sequence-test-$RUN_ID-Aa1!"
+PLATFORM_USER="sequence-platform-$RUN_ID"
+TENANT_CODE="seq$RUN_ID"
+
+echo " Statement Sequence Tests"
+echo "========================="
+
+# month N - prints the year/month N months before this one, e.g. month 1 for last month
+month() {
+    python3 -c "
+import datetime, sys
+d = datetime.datetime.now(datetime.timezone.utc).date().replace(day=1)
+n = d.year * 12 + d.month - 1 - int(sys.argv[1])
+print('%d/%d' % (n // 12, n % 12 + 1))" "$1"
+}
+
+# post TYPE AMOUNT N - posts to the account and backdates the posting to the middle of the month N months ago
+post() {
+    request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"$1\", \"amount\": $2}" "${AUTH[@]}"
+    local id
+    id=$(field "['transaction']['id']")
+    sql "UPDATE transactions SET effective_date = '$(month "$3" | awk -F/ '{printf "%04d-%02d-15 12:00:00+00:00", $1, $2}')' WHERE id = $id" > /dev/null
+}
+
+echo "Setup"
+tenant "$PLATFORM_USER" "Sequence" sequence-admin
+request POST "$V1/customers" "{\"first_name\": \"Sam\", \"last_name\": \"Sequence\", \"email\": \"sequence-$RUN_ID@example.com\"}" "${AUTH[@]}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+ACCOUNT=$(field "['account']['id']")
+# Four months of history: 100 in, 30 out, nothing, 50 in
+post deposit 100 4
+post withdrawal 30 3
+post deposit 50 1
+
+echo
+echo "Numbering"
+request POST "$V1/accounts/$ACCOUNT/statements/$(month 4)" "" "${AUTH[@]}"
+check "the first statement is sequence 1" "s == 201 and b['statement']['sequence'] == 1 and b['statement']['closing_balance'] == 100"
+check "and has no previous closing balance" "'previous_closing_balance' not in b['statement']"
+request POST "$V1/accounts/$ACCOUNT/statements/$(month 3)" "" "${AUTH[@]}"
+check "the next month is sequence 2" "s == 201 and b['statement']['sequence'] == 2"
+check "and records the closing balance it continues from" "b['statement']['previous_closing_balance'] == 100 and b['statement']['opening_balance'] == 100"
+SECOND=$(field "['statement']['id']")
+request POST "$V1/accounts/$ACCOUNT/statements/$(month 1)" "" "${AUTH[@]}"
+check "a month after a skipped one keeps its own number" "s == 201 and b['statement']['sequence'] == 4"
+check "and has no previous statement to continue from" "'previous_closing_balance' not in b['statement']"
+LATEST=$(field "['statement']['id']")
+request POST "$V1/accounts/$ACCOUNT/statements/$(month 5)" "" "${AUTH[@]}"
+check "a month before the first statement is refused" "s == 409 and b['code'] == 'BEFORE_FIRST_STATEMENT'"
+request POST "$V1/accounts/$ACCOUNT/statements/$(month 3)" "" "${AUTH[@]}"
+check "regenerating a month keeps its number" "s == 200 and b['statement']['sequence'] == 2 and b['statement']['generations'] == 2"
+
+echo
+echo "Gaps"
+SKIPPED=$(month 2 | awk -F/ '{printf "%04d-%02d", $1, $2}')
+request GET "$V1/accounts/$ACCOUNT/statements" "" "${AUTH[@]}"
+check "the archive lists three statements" "s == 200 and b['total'] == 3 and [st['sequence'] for st in b['statements']] == [4, 2, 1]"
+check "the skipped month is flagged as a gap" "not b['complete'] and len(b['gaps']) == 1 and b['gaps'][0]['sequence'] == 3 and b['gaps'][0]['period_start'][:7] == '$SKIPPED'"
+request GET "$V1/accounts/$ACCOUNT/statements?limit=1" "" "${AUTH[@]}"
+check "gaps are flagged whichever page is shown" "len(b['statements']) == 1 and len(b['gaps']) == 1"
+request POST "$V1/accounts/$ACCOUNT/statements/repair" "" "${AUTH[@]}"
+check "repair generates the missing month" "s == 200 and b['count'] == 1 and b['repaired'][0]['sequence'] == 3 and b['repaired'][0]['period_start'][:7] == '$SKIPPED'"
+check "from the account's postings" "b['repaired'][0]['opening_balance'] == 70 and b['repaired'][0]['closing_balance'] == 70 and b['repaired'][0]['previous_closing_balance'] == 70"
+check "and archives it without sending" "b['repaired'][0]['repaired'] and b['repaired'][0]['delivery'] == 'archived' and 'notification_id' not in b['repaired'][0]"
+REPAIRED=$(field "['repaired'][0]['id']")
+check "no email is queued for it" "'$(sql "SELECT COUNT(*) FROM notifications WHERE resource_type = 'statement' AND resource_id = $REPAIRED")' == '0'"
+request GET "$V1/accounts/$ACCOUNT/statements" "" "${AUTH[@]}"
+check "the archive is complete" "b['complete'] and b['gaps'] == [] and [st['sequence'] for st in b['statements']] == [4, 3, 2, 1]"
+request POST "$V1/accounts/$ACCOUNT/statements/repair" "" "${AUTH[@]}"
+check "repairing a complete archive does nothing" "s == 200 and b['count'] == 0"
+request POST "$V1/accounts/$ACCOUNT/statements/repair" "" -H "Authorization: Bearer not-a-token"
+check "repair needs an admin" "s == 401"
+
+echo
+echo "Continuity"
+# A posting lands in month 3 after its statement was archived, so the later statements no longer open where the
+# archived ones closed
+post deposit 25 3
+request POST "$V1/accounts/$ACCOUNT/statements/$(month 1)" "" "${AUTH[@]}"
+check "a statement that does not continue from the previous one is still archived" "s == 200 and b['statement']['opening_balance'] == 95"
+check "with the closing balance it should have continued from" "b['statement']['previous_closing_balance'] == 70"
+request GET "$V1/operations/invariants?kind=statement_continuity" "" "${AUTH[@]}"
+check "the break is put in the violation queue" "s == 200 and b['total'] == 1 and b['violations'][0]['statement_id'] == $LATEST and b['violations'][0]['account_id'] == $ACCOUNT"
+check "with the balances that disagree" "b['violations'][0]['expected'] == 70 and b['violations'][0]['actual'] == 95"
+request POST "$V1/accounts/$ACCOUNT/statements/$(month 1)" "" "${AUTH[@]}"
+request GET "$V1/operations/invariants?kind=statement_continuity" "" "${AUTH[@]}"
+check "regenerating it again does not repeat the violation" "b['total'] == 1"
+run_job invariants
+[ -z "$JOB_ERROR" ] && echo "  ✓ the invariants job runs" || { echo "  ✗ the invariants job failed: $JOB_ERROR"; FAILURES=$((FAILURES + 1)); }
+request GET "$V1/operations/invariants?kind=statement_continuity" "" "${AUTH[@]}"
+check "the scan keeps it open while the archive still disagrees" "b['total'] == 1 and b['violations'][0]['status'] == 'open'"
+request POST "$V1/accounts/$ACCOUNT/statements/$(month 3)" "" "${AUTH[@]}"
+check "the month the posting landed in is regenerated" "s == 200 and b['statement']['id'] == $SECOND and b['statement']['closing_balance'] == 95"
+request POST "$V1/accounts/$ACCOUNT/statements/$(month 2)" "" "${AUTH[@]}"
+check "and the repaired month after it" "s == 200 and b['statement']['opening_balance'] == 95 and b['statement']['previous_closing_balance'] == 95"
+run_job invariants
+[ -z "$JOB_ERROR" ] && echo "  ✓ the invariants job runs" || { echo "  ✗ the invariants job failed: $JOB_ERROR"; FAILURES=$((FAILURES + 1)); }
+request GET "$V1/operations/invariants?kind=statement_continuity&status=all" "" "${AUTH[@]}"
+check "the scan resolves the break once the archive agrees" "b['total'] == 1 and b['violations'][0]['status'] == 'resolved' and b['violations'][0]['resolved_by'] == 'system'"
+
+finish "statement sequence"
@@ -0,0 +1,111 @@
+#!/bin/bash
+
+# Status History Tests
+# Checks that customer, account and loan status changes are kept with who made them and why: creation, a customer
+# status update, freezing with bankctl and unfreezing in bulk, closing an account, and a loan's disbursement and
+# payoff. Also checks who may read the histories.
+#
+# An admin, a teller and a customer user are created with bankctl against the server's database, so DB_PATH must
+# be the database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-status-history.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-status-history.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="history-test-$RUN_ID"
+CHECK_HELPERS="h = [(e['old_status'], e['new_status']) for e in b.get('status_history', [])] if isinstance(b, dict) else []"
+
+echo " Status History Tests"
+echo "====================="
+
+# history TYPE ID [CURL_ARGS...] - fetches a record's status history, as the admin unless told otherwise
+history() {
+    local type=$1 id=$2
+    shift 2
+    [ $# -eq 0 ] && set -- "${ADMIN[@]}"
+    request GET "$V1/$type/$id/status-history" "" "$@"
+}
+
+echo "Setup"
+ADMIN_NAME="history-admin-$RUN_ID"
+ADMIN=(-H "Authorization: Bearer $(staff "$ADMIN_NAME" admin)")
+TELLER=(-H "Authorization: Bearer $(staff "history-teller-$RUN_ID" teller)")
+request POST "$V1/customers" "{\"first_name\": \"Hattie\", \"last_name\": \"History\", \"email\": \"history-$RUN_ID@example.com\", \"monthly_income\": 10000}" "${ADMIN[@]}"
+check "customer created" "s == 201"
+CUSTOMER=$(field "['customer']['id']")
+CUSTOMER_AUTH=(-H "Authorization: Bearer $(customer_user "history-customer-$RUN_ID" "$CUSTOMER")")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${ADMIN[@]}"
+ACCOUNT=$(field "['account']['id']")
+NUMBER=$(field "['account']['account_number']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"savings\"}" "${ADMIN[@]}"
+SAVINGS=$(field "['account']['id']")
+request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 5000}"
+check "accounts opened and funded" "s == 201"
+
+echo
+echo "Access"
+history accounts "$ACCOUNT" -H "X-No-Auth: 1"
+check "reading a history needs a login" "s == 401"
+history accounts "$ACCOUNT" "${CUSTOMER_AUTH[@]}"
+check "customers cannot read histories" "s == 403"
+history accounts "$ACCOUNT" "${TELLER[@]}"
+check "tellers can" "s == 200"
+history accounts 999999999
+check "an unknown record is not found" "s == 404"
+
+echo
+echo "Customers"
+history customers "$CUSTOMER"
+check "a new customer's history starts with its creation" \
+    "s == 200 and b['subject_type'] == 'customer' and h == [('', 'active')] and b['status_history'][0]['changed_by'] == '$ADMIN_NAME'"
+request PUT "$V1/customers/$CUSTOMER" "{\"status\": \"inactive\"}" "${ADMIN[@]}"
+request PUT "$V1/customers/$CUSTOMER" "{\"phone\": \"555-0100\"}" "${ADMIN[@]}"
+request PUT "$V1/customers/$CUSTOMER" "{\"status\": \"active\"}" "${ADMIN[@]}"
+history customers "$CUSTOMER"
+check "status updates are recorded and other updates are not" \
+    "h == [('', 'active'), ('active', 'inactive'), ('inactive', 'active')] and b['total'] == 3"
+
+echo
+echo "Accounts"
+history accounts "$ACCOUNT"
+check "an opened account starts active" "h == [('', 'active')]"
+BANKCTL_PASSWORD="$PASSWORD" $BANKCTL -db "$DB_PATH" freeze-account -number "$NUMBER" -reason "Fraud case $RUN_ID" > /dev/null || exit 1
+history accounts "$ACCOUNT"
+check "a bankctl freeze is recorded with its reason" \
+    "h[-1] == ('active', 'frozen') and b['status_history'][-1]['reason'] == 'Fraud case $RUN_ID' and b['status_history'][-1]['changed_by'] == 'bankctl'"
+request POST "$V1/admin/accounts/bulk-action" "{\"action\": \"unfreeze\", \"filter\": {\"account_ids\": [$ACCOUNT]}, \"reason\": \"Case $RUN_ID cleared\"}" "${ADMIN[@]}"
+OPERATION=$(field "['operation']['id']")
+for _ in $(seq 1 20); do
+    request GET "$V1/admin/bulk-operations/$OPERATION" "" "${ADMIN[@]}"
+    [ "$(field "['operation']['status']" 2>/dev/null)" = completed ] && break
+    sleep 0.5
+done
+history accounts "$ACCOUNT"
+check "a bulk unfreeze is recorded as the requesting admin's" \
+    "h[-1] == ('frozen', 'active') and b['status_history'][-1]['reason'] == 'Case $RUN_ID cleared' and b['status_history'][-1]['changed_by'] == '$ADMIN_NAME'"
+request POST "$V1/accounts/$SAVINGS/close" "{\"reason\": \"Customer request\"}" "${ADMIN[@]}"
+history accounts "$SAVINGS"
+check "closing is recorded" \
+    "h == [('', 'active'), ('active', 'closed')] and b['status_history'][-1]['reason'] == 'Customer request'"
+check "entries are in order" \
+    "[e['changed_at'] for e in b['status_history']] == sorted(e['changed_at'] for e in b['status_history'])"
+
+echo
+echo "Loans"
+request POST "$V1/loans?disburse=false" "{\"customer_id\": $CUSTOMER, \"principal_amount\": 1000, \"interest_rate\": 0.05, \"loan_term\": 12}" "${ADMIN[@]}"
+LOAN=$(field "['loan']['id']")
+history loans "$LOAN"
+check "an application starts pending" "h == [('', 'pending')]"
+request POST "$V1/loans/$LOAN/disburse" "" "${ADMIN[@]}"
+check "loan disbursed" "s == 200"
+LOAN_ACCOUNT=$(field "['loan']['account_id']")
+history loans "$LOAN"
+check "disbursement is recorded" "h == [('', 'pending'), ('pending', 'active')] and b['status_history'][-1]['reason'] == 'Disbursed'"
+history accounts "$LOAN_ACCOUNT"
+check "the loan account has its own history" "h == [('', 'active')]"
+request POST "$V1/loans/$LOAN/payments" "{\"account_id\": $ACCOUNT, \"amount\": 1000}" "${ADMIN[@]}"
+check "loan paid off" "s == 201 and b['loan']['status'] == 'paid_off'"
+history loans "$LOAN"
+check "the payoff is recorded" "h[-1] == ('active', 'paid_off') and b['status_history'][-1]['changed_by'] == '$ADMIN_NAME'"
+
+finish "status history"
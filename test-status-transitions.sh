@@ -0,0 +1,234 @@
+#!/bin/bash
+
+# Status Transition Tests
+# Starts its own server and checks status changes against the declared lifecycles. The lifecycles published at
+# /lifecycles must match the table below exactly, so changing one means changing this test. Customers, tenants and
+# report subscriptions are put through every pair of their statuses, plus one that does not exist: a declared change
+# succeeds and any other is refused with 409 INVALID_STATUS_TRANSITION naming the allowed targets. Accounts are
+# frozen and unfrozen in bulk from every status, and with bankctl. Changes that need a reason or a permission are
+# refused without one. Finally bankctl's guard passes for the tree and fails for a build with a model that has a
+# status field but no lifecycle. Exits non-zero on failure.
+#
+# Usage: ./test-status-transitions.sh                     (builds the server with go build)
+#        SERVER_BIN=./banking-app BANKCTL=./bankctl PORT=18097 ./test-status-transitions.sh
+
+source "$(dirname "$0")/test-lib.sh"
+serve 18097
+PASSWORD="transition-test-$RUN_ID-Aa1!"
+
+# The lifecycles the server must publish: subject -> statuses, initial statuses, and transitions as
+# (from, to, permission, reason required). Checks see it as `e`
+export EXPECTED='{
+  "customer": [["active", "inactive"], ["active"], [
+    ["active", "inactive", "customers:status", false], ["inactive", "active", "customers:status", false]]],
+  "account": [["active", "frozen", "closed", "escheated"], ["active"], [
+    ["active", "frozen", "accounts:restrictions", true], ["frozen", "active", "accounts:restrictions", true],
+    ["active", "closed", "", false], ["frozen", "closed", "", false],
+    ["active", "escheated", "", true], ["escheated", "active", "", true]]],
+  "loan": [["pending", "declined", "active", "paid_off", "defaulted"], ["pending"], [
+    ["pending", "active", "", false], ["pending", "declined", "loans:credit_review", false],
+    ["active", "paid_off", "", false], ["active", "defaulted", "", true], ["defaulted", "paid_off", "", false]]],
+  "application": [["draft", "submitted", "approved", "rejected", "opened", "expired"], ["draft"], [
+    ["draft", "submitted", "", false], ["draft", "expired", "", false],
+    ["submitted", "approved", "accounts:applications", true], ["submitted", "rejected", "accounts:applications", true],
+    ["approved", "opened", "", false]]],
+  "credit_line": [["pending", "declined", "active", "closed"], ["pending"], [
+    ["pending", "active", "", false], ["pending", "declined", "", false], ["active", "closed", "", false]]],
+  "installment_plan": [["active", "paid_off"], ["active"], [["active", "paid_off", "", false]]],
+  "loan_collection": [["collected", "short"], ["collected", "short"], []],
+  "lien": [["active", "satisfied", "released"], ["active"], [
+    ["active", "satisfied", "accounts:liens", false], ["active", "released", "accounts:liens", true]]],
+  "garnishment_order": [["active", "suspended", "satisfied"], ["active"], [
+    ["active", "suspended", "accounts:garnishments", true], ["suspended", "active", "accounts:garnishments", false],
+    ["active", "satisfied", "accounts:garnishments", false], ["suspended", "satisfied", "accounts:garnishments", false]]],
+  "sar_case": [["open", "investigating", "sar_filed", "closed_no_action"], ["open"], [
+    ["open", "investigating", "compliance:sar_cases", false], ["open", "closed_no_action", "compliance:sar_cases", true],
+    ["investigating", "sar_filed", "compliance:sar_cases", false], ["investigating", "closed_no_action", "compliance:sar_cases", true]]],
+  "escheatment": [["pending", "cancelled", "escheated", "reclaimed"], ["pending"], [
+    ["pending", "cancelled", "", false], ["pending", "escheated", "", false], ["escheated", "reclaimed", "", true]]],
+  "product_change": [["scheduled", "applied", "cancelled"], ["scheduled", "applied"], [
+    ["scheduled", "applied", "", false], ["scheduled", "cancelled", "", false]]],
+  "external_account": [["pending", "verified", "locked"], ["pending"], [
+    ["pending", "verified", "", false], ["pending", "locked", "", false]]],
+  "email_verification": [["pending", "verified", "superseded"], ["pending"], [
+    ["pending", "verified", "", false], ["pending", "superseded", "", false]]],
+  "withdrawal_approval": [["pending", "approved", "rejected"], ["pending"], [
+    ["pending", "approved", "operations:approvals", false], ["pending", "rejected", "operations:approvals", false]]],
+  "transaction_review": [["pending_review", "approved", "declined"], ["pending_review"], [
+    ["pending_review", "approved", "", false], ["pending_review", "declined", "", false]]],
+  "authorization": [["pending", "captured", "released", "expired"], ["pending"], [
+    ["pending", "captured", "transactions:authorize", false], ["pending", "released", "transactions:authorize", false],
+    ["pending", "expired", "", false]]],
+  "duplicate_payment": [["flagged", "reversed", "dismissed"], ["flagged"], [
+    ["flagged", "reversed", "", false], ["flagged", "dismissed", "", false]]],
+  "dispute": [["open", "resolved"], ["open"], [["open", "resolved", "transactions:disputes", true]]],
+  "exception_item": [["open", "applied", "returned"], ["open"], [
+    ["open", "applied", "operations:exceptions", false], ["open", "returned", "operations:exceptions", false]]],
+  "invariant_violation": [["open", "resolved"], ["open"], [["open", "resolved", "", false]]],
+  "match_report": [["open", "reconciled"], ["open", "reconciled"], [
+    ["open", "reconciled", "", false], ["reconciled", "open", "", false]]],
+  "match_item": [["matched", "unmatched_ours", "unmatched_theirs", "adjusted"], ["matched", "unmatched_ours", "unmatched_theirs"], [
+    ["unmatched_theirs", "matched", "", false], ["unmatched_theirs", "adjusted", "", false]]],
+  "fx_revaluation": [["posted", "failed"], ["posted", "failed"], [["failed", "posted", "", false]]],
+  "bulk_operation": [["queued", "running", "completed"], ["queued"], [
+    ["queued", "running", "", false], ["running", "completed", "", false]]],
+  "campaign": [["scheduled", "running", "paused", "completed"], ["scheduled"], [
+    ["scheduled", "running", "", false], ["scheduled", "paused", "", false], ["running", "paused", "", false],
+    ["paused", "running", "", false], ["paused", "scheduled", "", false], ["running", "completed", "", false]]],
+  "campaign_recipient": [["pending", "queued", "sent", "failed", "suppressed", "unreachable"], ["pending"], [
+    ["pending", "queued", "", false], ["pending", "suppressed", "", false], ["pending", "unreachable", "", false],
+    ["pending", "failed", "", false], ["queued", "sent", "", false], ["queued", "failed", "", false]]],
+  "job_run": [["running", "succeeded", "failed", "skipped"], ["running", "skipped"], [
+    ["running", "succeeded", "", false], ["running", "failed", "", false]]],
+  "outbox_event": [["pending", "dispatched", "failed"], ["pending"], [
+    ["pending", "dispatched", "", false], ["pending", "failed", "", false], ["failed", "pending", "", false]]],
+  "webhook_delivery": [["pending", "delivered", "dropped"], ["pending"], [
+    ["pending", "delivered", "", false], ["pending", "dropped", "", false]]],
+  "notification": [["pending", "sent", "failed"], ["pending"], [
+    ["pending", "sent", "", false], ["pending", "failed", "", false], ["failed", "pending", "customers:communications", false]]],
+  "communication": [["sent", "failed", "generated", "archived"], ["sent", "failed", "generated", "archived"], []],
+  "report_subscription": [["active", "paused"], ["active"], [["active", "paused", "", false], ["paused", "active", "", false]]],
+  "report_run": [["delivering", "delivered", "failed"], ["delivering", "failed"], [
+    ["delivering", "delivered", "", false], ["delivering", "failed", "", false]]],
+  "statement_request": [["queued", "running", "ready", "failed"], ["queued", "running"], [
+    ["queued", "running", "", false], ["running", "ready", "", false], ["running", "failed", "", false]]],
+  "tenant": [["active", "suspended"], ["active", "suspended"], [["active", "suspended", "", false], ["suspended", "active", "", false]]],
+  "user": [["active", "locked", "disabled"], ["active"], [
+    ["active", "locked", "", false], ["locked", "active", "", false], ["active", "disabled", "", false], ["locked", "disabled", "", false]]]
+}'
+CHECK_HELPERS="e = json.loads(os.environ['EXPECTED'])"
+
+echo " Status Transition Tests"
+echo "========================"
+
+# expect SUBJECT FROM TO - prints the python expression a response to changing FROM to TO must satisfy
+expect() {
+    echo "(s == 200) if ('$2' == '$3' or ['$2', '$3'] in [t[:2] for t in e['$1'][2]]) else \
+(s == 409 and b['code'] == 'INVALID_STATUS_TRANSITION' and b['allowed'] == [t[1] for t in e['$1'][2] if t[0] == '$2'])"
+}
+
+# matrix SUBJECT TABLE ID URL BODY_PREFIX AUTH... - sets the record to every status with SQL and asks for every
+# status, plus one not declared, through PUT, checking each response against the expected lifecycle
+matrix() {
+    local subject=$1 table=$2 id=$3 url=$4 prefix=$5
+    shift 5
+    local statuses
+    statuses=$(python3 -c "import json, sys; print(' '.join(json.loads(sys.argv[1])[sys.argv[2]][0]))" "$EXPECTED" "$subject")
+    for from in $statuses; do
+        for to in $statuses retired; do
+            sql "UPDATE $table SET status = '$from' WHERE id = $id" > /dev/null
+            request PUT "$url" "{$prefix\"status\": \"$to\"}" "$@"
+            check "$subject $from -> $to" "$(expect "$subject" "$from" "$to")"
+            [ "$STATUS" = 200 ] || check "  and is left $from" "'$(sql "SELECT status FROM $table WHERE id = $id")' == '$from'"
+        done
+    done
+}
+
+# bulk ACTION ACCOUNT [REASON] - runs a bulk freeze or unfreeze of one account and stores its item in BODY
+bulk() {
+    request POST "$V1/admin/accounts/bulk-action" "{\"action\": \"$1\", \"filter\": {\"account_ids\": [$2]}, \"reason\": \"$3\"}" "${ADMIN[@]}"
+    [ "$STATUS" = 202 ] || [ "$STATUS" = 201 ] || return
+    local operation
+    operation=$(field "['operation']['id']")
+    for _ in $(seq 1 40); do
+        request GET "$V1/admin/bulk-operations/$operation" "" "${ADMIN[@]}"
+        [ "$(field "['operation']['status']" 2>/dev/null)" = completed ] && break
+        sleep 0.25
+    done
+}
+
+build
+
+echo "Setup"
+start_server "$SERVER_BIN"
+ADMIN=(-H "Authorization: Bearer $(staff "transition-admin")")
+request POST "$V1/customers" "{\"first_name\": \"Status\", \"last_name\": \"Holder\", \"email\": \"status-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${ADMIN[@]}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${ADMIN[@]}"
+ACCOUNT=$(field "['account']['id']")
+NUMBER=$(field "['account']['account_number']")
+check "a customer and account are opened" "s == 201"
+
+echo
+echo "Published lifecycles"
+request GET "$V1/lifecycles"
+check "signing in is required" "s == 401"
+request GET "$V1/lifecycles" "" "${ADMIN[@]}"
+check "every record type with a status has a lifecycle" "s == 200 and sorted(m['subject'] for m in b['lifecycles']) == sorted(e)"
+for subject in $(python3 -c "import json, sys; print(' '.join(json.loads(sys.argv[1])))" "$EXPECTED"); do
+    check "$subject matches the declared table" "[[m['statuses'], m['initial'], [[t['from'], t['to'], t.get('permission', ''), t['reason_required']] for t in m['transitions']]] for m in b['lifecycles'] if m['subject'] == '$subject'] == [e['$subject']]"
+done
+
+echo
+echo "Customers"
+matrix customer customers "$CUSTOMER" "$V1/customers/$CUSTOMER" "" "${ADMIN[@]}"
+sql "UPDATE customers SET status = 'active' WHERE id = $CUSTOMER" > /dev/null
+request PUT "$V1/customers/$CUSTOMER" "{\"status\": \"inactive\", \"status_reason\": \"Moved abroad\"}" "${ADMIN[@]}"
+request GET "$V1/customers/$CUSTOMER/status-history" "" "${ADMIN[@]}"
+check "a status_reason is recorded in the history" "b['status_history'][-1]['new_status'] == 'inactive' and b['status_history'][-1]['reason'] == 'Moved abroad'"
+request PUT "$V1/customers/$CUSTOMER" "{\"status\": \"active\"}"
+check "changing a status needs the customer status permission" "s == 403 and b['error'] == 'Insufficient permissions'"
+request PUT "$V1/customers/$CUSTOMER" "{\"phone\": \"555-0199\"}"
+check "other fields are still updated without it" "s == 200 and b['customer']['status'] == 'inactive'"
+
+echo
+echo "Accounts"
+for from in active frozen closed escheated; do
+    for action in freeze unfreeze; do
+        to=$([ "$action" = freeze ] && echo frozen || echo active)
+        sql "UPDATE accounts SET status = '$from' WHERE id = $ACCOUNT" > /dev/null
+        bulk "$action" "$ACCOUNT" "Case $RUN_ID"
+        if [ "$from" = "$to" ]; then
+            check "a bulk $action of a $from account skips it" "b['items'][0]['result'] == 'skipped'"
+        elif [ "$action" = unfreeze ] && [ "$from" != frozen ]; then
+            check "a bulk unfreeze of a $from account fails; only frozen accounts are unfrozen" \
+                "b['items'][0]['result'] == 'failed' and 'only frozen accounts can be unfrozen' in b['items'][0]['error'] and '$(sql "SELECT status FROM accounts WHERE id = $ACCOUNT")' == '$from'"
+        elif python3 -c "import json, sys; sys.exit(0 if ['$from', '$to'] in [t[:2] for t in json.loads(sys.argv[1])['account'][2]] else 1)" "$EXPECTED"; then
+            check "a bulk $action of a $from account succeeds" "b['items'][0]['result'] == 'succeeded' and '$(sql "SELECT status FROM accounts WHERE id = $ACCOUNT")' == '$to'"
+        else
+            check "a bulk $action of a $from account fails, naming the allowed targets" \
+                "b['items'][0]['result'] == 'failed' and 'cannot move from $from to $to; allowed:' in b['items'][0]['error'] and '$(sql "SELECT status FROM accounts WHERE id = $ACCOUNT")' == '$from'"
+        fi
+    done
+done
+sql "UPDATE accounts SET status = 'active' WHERE id = $ACCOUNT" > /dev/null
+bulk freeze "$ACCOUNT" ""
+check "a bulk freeze needs a reason" "s == 400 and b['code'] == 'REASON_REQUIRED'"
+OUTPUT=$($BANKCTL -db "$DB_PATH" freeze-account -number "$NUMBER" 2>&1)
+STATUS=200 BODY= check "so does a bankctl freeze" "'-reason are required' in '''$OUTPUT'''"
+sql "UPDATE accounts SET status = 'closed' WHERE id = $ACCOUNT" > /dev/null
+OUTPUT=$($BANKCTL -db "$DB_PATH" freeze-account -number "$NUMBER" -reason "Case $RUN_ID" 2>&1)
+STATUS=200 BODY= check "a closed account cannot be frozen with bankctl" \
+    "'account cannot move from closed to frozen; allowed: none' in '''$OUTPUT''' and '$(sql "SELECT status FROM accounts WHERE id = $ACCOUNT")' == 'closed'"
+
+echo
+echo "Tenants"
+request POST "$V1/admin/tenants" "{\"code\": \"st$RUN_ID\", \"name\": \"Transitions $RUN_ID\", \"admin\": {\"username\": \"st-admin\", \"password\": \"$PASSWORD\"}}" "${ADMIN[@]}"
+TENANT=$(field "['tenant']['id']")
+matrix tenant tenants "$TENANT" "$V1/admin/tenants/$TENANT" "" "${ADMIN[@]}"
+
+echo
+echo "Report subscriptions"
+VALID='"name": "Volume", "report_type": "transaction_volume", "schedule": "0 7 * * *", "format": "csv", "channel": "email", "target": "ops@example.com"'
+request POST "$V1/admin/report-subscriptions" "{$VALID}" "${ADMIN[@]}"
+SUB=$(field "['subscription']['id']")
+matrix report_subscription report_subscriptions "$SUB" "$V1/admin/report-subscriptions/$SUB" "$VALID, " "${ADMIN[@]}"
+
+echo
+echo "Guard"
+OUTPUT=$($BANKCTL -db "$DB_PATH" lifecycles -check 2>&1)
+STATUS=200 BODY= check "every model with a status field has a well-formed lifecycle" "'every status field has one' in '''$OUTPUT'''"
+OUTPUT=$($BANKCTL -db "$DB_PATH" lifecycles)
+STATUS=200 BODY= check "the tables print as markdown" "'#### account' in '''$OUTPUT''' and '| active | frozen | accounts:restrictions | required |' in '''$OUTPUT'''"
+if command -v go > /dev/null; then
+    # A model gaining a status field without a lifecycle, added through a build overlay
+    printf 'package models\n\n// GuardProbe has a status but no lifecycle\ntype GuardProbe struct {\n\tID     uint\n\tStatus string\n}\n' > "$WORK/probe.go"
+    sed 's|&models.StatusHistory{},|\&models.StatusHistory{}, \&models.GuardProbe{},|' database/database.go > "$WORK/database.go"
+    printf '{"Replace": {"%s": "%s", "%s": "%s"}}' "$PWD/models/zz_guard_probe.go" "$WORK/probe.go" "$PWD/database/database.go" "$WORK/database.go" > "$WORK/overlay.json"
+    OUTPUT=$(go run -overlay "$WORK/overlay.json" ./cmd/bankctl lifecycles -check 2>&1)
+    STATUS=200 BODY= check "a model with a status field and no lifecycle fails the guard" "'no lifecycle is declared for the status of GuardProbe' in '''$OUTPUT'''"
+else
+    echo "  - go is not installed; the guard's failure case is not checked"
+fi
+
+finish "status transition"
@@ -0,0 +1,107 @@
+#!/bin/bash
+
+# Document Storage Tests
+# Checks the storage backend round trip with `bankctl storage-check` (write, read back with the SHA-256 verified,
+# pre-sign and delete), that documents and statements are kept under kind/tenant/customer/yyyy/mm keys, and that
+# the emailed statement link redirects to a pre-signed S3 URL when the server stores in S3, or serves the PDF itself
+# from disk. With S3_ENDPOINT set (a MinIO endpoint, with S3_BUCKET and AWS credentials) the storage check runs
+# against that bucket and the server must run with STORAGE_BACKEND=s3 and the same settings; otherwise both use
+# the disk store. Each run creates its own tenant; the platform admin is created with bankctl and the storage keys
+# are read from the server's database, so DB_PATH must be the database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-storage.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-storage.sh
+#        S3_ENDPOINT=http://localhost:9000 S3_BUCKET=bank-docs S3_SSE=off AWS_ACCESS_KEY_ID=... \
+#            AWS_SECRET_ACCESS_KEY=... DB_PATH=... ./test-storage.sh   (server storing in the same MinIO bucket)
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="storage-test-$RUN_ID-Aa1!"
+PLATFORM_USER="storage-platform-$RUN_ID"
+TENANT_CODE="store$RUN_ID"
+
+echo " Document Storage Tests"
+echo "========================"
+
+# download URL [CURL_ARGS...] - fetches a file without following redirects into $WORK/download, storing the status
+# in STATUS, the redirect target in LOCATION and whether the file is a PDF in BODY
+download() {
+    local url=$1
+    shift
+    rm -f "$WORK/download"
+    read -r STATUS LOCATION < <(curl -s -o "$WORK/download" -w '%{http_code} %{redirect_url}\n' "$url" "$@")
+    BODY=$(head -c 5 "$WORK/download" 2>/dev/null | grep -q '%PDF-' && echo true || echo false)
+}
+
+if [ -n "$S3_ENDPOINT" ]; then
+    BACKEND=s3
+else
+    BACKEND=disk
+fi
+
+echo "Storage round trip ($BACKEND)"
+BODY=$(STORAGE_BACKEND=$BACKEND UPLOAD_DIR="$WORK/uploads" $BANKCTL -json storage-check 2>&1)
+STATUS=$?
+check "a file is written, read back, pre-signed when supported and deleted" "s == 0 and b['backend'] == '$BACKEND' and b['presigned'] == ('$BACKEND' == 's3')"
+check "the test file is not left behind" "'$BACKEND' == 's3' or not __import__('os').listdir('$WORK/uploads/checks')"
+BODY=$(STORAGE_BACKEND=floppy $BANKCTL storage-check 2>&1)
+STATUS=$?
+BODY=$(python3 -c "import json, sys; print(json.dumps(sys.argv[1]))" "$BODY")
+check "an unknown backend is refused" "s != 0 and 'STORAGE_BACKEND' in b"
+BODY=$(STORAGE_BACKEND=s3 S3_BUCKET= $BANKCTL storage-check 2>&1)
+STATUS=$?
+BODY=$(python3 -c "import json, sys; print(json.dumps(sys.argv[1]))" "$BODY")
+check "S3 needs a bucket" "s != 0 and 'S3_BUCKET' in b"
+
+echo
+echo "Setup"
+tenant "$PLATFORM_USER" "Storage" storage-admin
+request POST "$V1/customers" "{\"first_name\": \"Store\", \"last_name\": \"Holder\", \"email\": \"storage-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+CUSTOMER=$(field "['customer']['id']")
+sql "UPDATE customers SET email_verified = 1 WHERE id = $CUSTOMER" > /dev/null
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+ACCOUNT=$(field "['account']['id']")
+request PUT "$V1/accounts/$ACCOUNT/statement-preference" '{"channel": "email"}' "${AUTH[@]}"
+check "the account takes e-statements" "s == 200"
+THIS_MONTH=$(date -u +%Y/%m)
+read -r YEAR MONTH LAST_MONTH < <(python3 -c "
+import datetime
+d = datetime.datetime.now(datetime.timezone.utc).date().replace(day=1) - datetime.timedelta(days=1)
+print(d.year, d.month, d.strftime('%Y/%m'))")
+
+echo
+echo "Documents"
+printf '%%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj\ntrailer << /Root 1 0 R >>\n%%%%EOF\n' > "$WORK/proof.pdf"
+BODY=$(curl -s -X POST "$V1/customers/$CUSTOMER/documents" "${AUTH[@]}" -F kind=proof_of_address -F "file=@$WORK/proof.pdf;type=application/pdf")
+STATUS=201
+DOCUMENT=$(field "['document']['id']" 2>/dev/null)
+check "a document is uploaded" "'$DOCUMENT'.isdigit()"
+check "it is kept under documents/tenant/customer/yyyy/mm" "'$(sql "SELECT storage_key FROM documents WHERE id = 0$DOCUMENT")' == 'documents/$TENANT/$CUSTOMER/$THIS_MONTH/' + __import__('hashlib').sha256(open('$WORK/proof.pdf', 'rb').read()).hexdigest()"
+download "$V1/customers/$CUSTOMER/documents/$DOCUMENT" "${AUTH[@]}"
+check "the document downloads intact" "s == 200 and open('$WORK/download', 'rb').read() == open('$WORK/proof.pdf', 'rb').read()"
+
+echo
+echo "Statements"
+request POST "$V1/accounts/$ACCOUNT/statements/$YEAR/$MONTH" "" "${AUTH[@]}"
+check "last month's statement is generated and emailed" "s == 201 and b['statement']['delivery'] == 'emailed'"
+STATEMENT=$(field "['statement']['id']")
+check "it is kept under statements/tenant/customer/yyyy/mm" "'$(sql "SELECT storage_key FROM statements WHERE id = $STATEMENT")' == 'statements/$TENANT/$CUSTOMER/$LAST_MONTH/$ACCOUNT.pdf'"
+TOKEN=$(sql "SELECT substr(body, instr(body, '/api/v1/statements/') + 19, 43) FROM notifications WHERE resource_type = 'statement' AND resource_id = $STATEMENT")
+download "$V1/statements/$TOKEN"
+if [ "$BACKEND" = s3 ]; then
+    check "the emailed link redirects to a pre-signed URL on the bucket" "s == 302 and '$LOCATION'.startswith('${S3_ENDPOINT%/}/') and 'X-Amz-Signature=' in '$LOCATION'"
+    PRESIGNED=$LOCATION
+    download "$PRESIGNED"
+    check "the pre-signed URL serves the PDF" "s == 200 and b is True"
+    download "${PRESIGNED/X-Amz-Signature=/X-Amz-Signature=0}"
+    check "a tampered URL is refused" "s == 403"
+else
+    check "the emailed link serves the PDF from disk" "s == 200 and b is True and '$LOCATION' == ''"
+fi
+download "$V1/statements/not-a-token"
+check "an unknown link is not found" "s == 404"
+download "$V1/accounts/$ACCOUNT/statements/$STATEMENT" "${AUTH[@]}"
+check "staff get the PDF through the API" "s == 200 and b is True"
+request POST "$V1/accounts/$ACCOUNT/statements/$YEAR/$MONTH" "" "${AUTH[@]}"
+check "regenerating replaces the PDF at the same key" "s == 200 and '$(sql "SELECT storage_key FROM statements WHERE id = $STATEMENT")' == 'statements/$TENANT/$CUSTOMER/$LAST_MONTH/$ACCOUNT.pdf'"
+
+finish "storage"
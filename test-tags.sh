@@ -0,0 +1,138 @@
+#!/bin/bash
+
+# Customer and Account Tag Tests
+# Checks the tag vocabulary, applying and removing tags, the slug and per-record limits, listing customers and
+# accounts by tag, tag filters on the finance reports, and that tag changes reach the audit log. The admin user is
+# created with bankctl. Unknown tags are checked against the server's TAGS_FREE_FORM setting, which the vocabulary
+# endpoint reports. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-tags.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-tags.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="tags-test-$RUN_ID"
+ADMIN_USER="tags-admin-$RUN_ID"
+
+# Tags are unique per run, since the vocabulary outlives it
+VIP="vip-$RUN_ID"
+PAYROLL="payroll-$RUN_ID"
+
+echo " Customer and Account Tag Tests"
+echo "==============================="
+
+# customer NAME - creates a customer and stores its ID in CUSTOMER
+customer() {
+    request POST "$V1/customers" "{\"first_name\": \"$1\", \"last_name\": \"Tags\", \"email\": \"$1-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\", \"monthly_income\": 10000}"
+    CUSTOMER=$(field "['customer']['id']")
+}
+
+# account CUSTOMER_ID - opens a checking account and stores its ID in ACCOUNT
+account() {
+    request POST "$V1/accounts" "{\"customer_id\": $1, \"account_type\": \"checking\"}"
+    ACCOUNT=$(field "['account']['id']")
+}
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "$ADMIN_USER")")
+customer Tagged
+TAGGED=$CUSTOMER
+account "$TAGGED"
+customer Untagged
+UNTAGGED=$CUSTOMER
+
+echo "Vocabulary"
+request POST "$V1/admin/tags" "{\"slug\": \"$VIP\", \"description\": \"High-value customers\"}"
+check "defining a tag needs an admin" "s == 401"
+request POST "$V1/admin/tags" "{\"slug\": \"$VIP\", \"description\": \"High-value customers\"}" "${ADMIN[@]}"
+check "an admin defines a tag" "s == 201 and b['tag']['slug'] == '$VIP'"
+request POST "$V1/admin/tags" "{\"slug\": \"$VIP\"}" "${ADMIN[@]}"
+check "a tag is defined once" "s == 409 and b['code'] == 'TAG_EXISTS'"
+for slug in VIP Student_Staff -leading trailing- double--hyphen; do
+    request POST "$V1/admin/tags" "{\"slug\": \"$slug\"}" "${ADMIN[@]}"
+    check "'$slug' is not a valid tag" "s == 400 and b['code'] == 'INVALID_TAG'"
+done
+request POST "$V1/admin/tags" "{\"slug\": \"$PAYROLL\"}" "${ADMIN[@]}"
+request GET "$V1/admin/tags" "" "${ADMIN[@]}"
+check "the vocabulary lists both tags unused" "[(t['customers'], t['accounts']) for t in b['tags'] if t['slug'] in ('$VIP', '$PAYROLL')] == [(0, 0), (0, 0)]"
+FREE_FORM=$(field "['free_form']")
+MAX=$(field "['max_per_entity']")
+
+echo "Applying"
+request PUT "$V1/customers/$TAGGED/tags/$VIP"
+check "tagging needs an authenticated user" "s == 401"
+request PUT "$V1/customers/$TAGGED/tags/$VIP" "" "${ADMIN[@]}"
+check "a customer is tagged" "s == 201 and b['tags'] == ['$VIP']"
+request PUT "$V1/customers/$TAGGED/tags/$VIP" "" "${ADMIN[@]}"
+check "tagging again changes nothing" "s == 200 and b['tags'] == ['$VIP']"
+request PUT "$V1/accounts/$ACCOUNT/tags/$PAYROLL" "" "${ADMIN[@]}"
+check "an account is tagged" "s == 201 and b['tags'] == ['$PAYROLL']"
+request PUT "$V1/customers/$TAGGED/tags/Not_A_Slug" "" "${ADMIN[@]}"
+check "a malformed tag is refused" "s == 400 and b['code'] == 'INVALID_TAG'"
+request PUT "$V1/customers/999999999/tags/$VIP" "" "${ADMIN[@]}"
+check "tagging a missing customer is 404" "s == 404"
+
+request PUT "$V1/customers/$UNTAGGED/tags/unlisted-$RUN_ID" "" "${ADMIN[@]}"
+if [ "$FREE_FORM" = "True" ]; then
+    check "free-form mode adds an unknown tag to the vocabulary" "s == 201 and b['tags'] == ['unlisted-$RUN_ID']"
+    request DELETE "$V1/customers/$UNTAGGED/tags/unlisted-$RUN_ID" "" "${ADMIN[@]}"
+else
+    check "a tag outside the vocabulary is refused" "s == 422 and b['code'] == 'UNKNOWN_TAG'"
+fi
+
+echo "Limits"
+for i in $(seq 1 "$MAX"); do
+    request POST "$V1/admin/tags" "{\"slug\": \"limit-$RUN_ID-$i\"}" "${ADMIN[@]}"
+done
+for i in $(seq 2 "$MAX"); do
+    request PUT "$V1/customers/$TAGGED/tags/limit-$RUN_ID-$i" "" "${ADMIN[@]}"
+done
+check "a customer takes up to $MAX tags" "s == 201 and len(b['tags']) == $MAX"
+request PUT "$V1/customers/$TAGGED/tags/limit-$RUN_ID-1" "" "${ADMIN[@]}"
+check "one more is refused" "s == 409 and b['code'] == 'TOO_MANY_TAGS' and b['max'] == $MAX"
+for i in $(seq 2 "$MAX"); do
+    request DELETE "$V1/customers/$TAGGED/tags/limit-$RUN_ID-$i" "" "${ADMIN[@]}"
+done
+check "removing tags frees the slots" "s == 200 and b['tags'] == ['$VIP']"
+
+echo "Details and lists"
+request GET "$V1/customers/$TAGGED"
+check "customer details carry their tags" "s == 200 and b['tags'] == ['$VIP']"
+request GET "$V1/customers/$UNTAGGED"
+check "an untagged customer has an empty list" "s == 200 and b['tags'] == []"
+request GET "$V1/accounts/$ACCOUNT"
+check "account details carry their tags" "s == 200 and b['tags'] == ['$PAYROLL']"
+request GET "$V1/customers?tag=$VIP"
+check "customers are listed by tag" "s == 200 and b['total'] == 1 and [c['id'] for c in b['customers']] == [$TAGGED]"
+request GET "$V1/accounts?tag=$PAYROLL"
+check "accounts are listed by tag" "s == 200 and b['total'] == 1 and [a['id'] for a in b['accounts']] == [$ACCOUNT]"
+request GET "$V1/accounts?tag=$VIP"
+check "customer tags do not match accounts" "s == 200 and b['total'] == 0"
+request GET "$V1/customers?tag=VIP"
+check "listing by a malformed tag is refused" "s == 400 and b['code'] == 'INVALID_TAG'"
+
+echo "Reports"
+request POST "$V1/loans" "{\"customer_id\": $TAGGED, \"principal_amount\": 1000, \"interest_rate\": 0.05, \"loan_term\": 12}"
+request POST "$V1/loans" "{\"customer_id\": $UNTAGGED, \"principal_amount\": 1000, \"interest_rate\": 0.05, \"loan_term\": 12}"
+request GET "$V1/reports/exposure?tag=$VIP" "" "${ADMIN[@]}"
+check "the exposure report is limited to tagged customers" "s == 200 and [e['customer_id'] for e in b['exposures']] == [$TAGGED]"
+request GET "$V1/reports/income?account_tag=$PAYROLL&customer_tag=$VIP" "" "${ADMIN[@]}"
+check "the income report echoes its segment" "s == 200 and b['segment'] == {'account_tag': '$PAYROLL', 'customer_tag': '$VIP'}"
+request GET "$V1/reports/income?customer_tag=Bad_Tag" "" "${ADMIN[@]}"
+check "a malformed report tag is refused" "s == 400 and b['code'] == 'INVALID_TAG'"
+
+echo "Removing"
+request DELETE "$V1/admin/tags/$VIP" "" "${ADMIN[@]}"
+check "a tag in use stays in the vocabulary" "s == 409 and b['code'] == 'TAG_IN_USE'"
+request DELETE "$V1/customers/$TAGGED/tags/$VIP" "" "${ADMIN[@]}"
+check "a tag is removed" "s == 200 and b['tags'] == []"
+request DELETE "$V1/customers/$TAGGED/tags/$VIP" "" "${ADMIN[@]}"
+check "removing it again is 404" "s == 404 and b['code'] == 'TAG_NOT_APPLIED'"
+request DELETE "$V1/admin/tags/$VIP" "" "${ADMIN[@]}"
+check "an unused tag leaves the vocabulary" "s == 200"
+
+echo "Audit"
+request GET "$V1/admin/audit-log?username=$ADMIN_USER&limit=100" "" "${ADMIN[@]}"
+check "applying a tag is audited with the tag" "any(e['method'] == 'PUT' and e['path'] == '/api/v1/customers/$TAGGED/tags/$VIP' and e['status'] == 201 for e in b['entries'])"
+check "removing a tag is audited with the tag" "any(e['method'] == 'DELETE' and e['path'] == '/api/v1/customers/$TAGGED/tags/$VIP' and e['status'] == 200 for e in b['entries'])"
+
+finish "tag"
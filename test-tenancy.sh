@@ -0,0 +1,114 @@
+#!/bin/bash
+
+# Tenant Isolation Tests
+# Creates two tenants, each with its own admin, and gives the second a customer, an account and a deposit. The first
+# tenant's admin then tries to list, read, update, post to, reverse, delete and export them: every list and export
+# must leave them out and every direct request must answer 404 or come back empty, as if they did not exist. The
+# second tenant's token must still find them. Also checks that a token cannot switch tenants with the X-Tenant header, that
+# inserts are stamped with the caller's tenant whatever the body says, and that a default-tenant admin may act in
+# another tenant through the header. The platform admin is created with bankctl against the server's database,
+# so DB_PATH must be the database the server uses. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-tenancy.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-tenancy.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="tenancy-test-$RUN_ID"
+
+echo " Tenant Isolation Tests"
+echo "======================="
+
+# tenant_admin CODE - creates a tenant with its first admin as the platform admin and prints the admin's token
+tenant_admin() {
+    request POST "$V1/admin/tenants" "{\"code\": \"$1\", \"name\": \"Tenant $1\", \"admin\": {\"username\": \"$1-admin\", \"password\": \"$PASSWORD\"}}" "${PLATFORM[@]}"
+    [ "$STATUS" = 201 ] || { echo "could not create tenant $1: $BODY" >&2; exit 1; }
+    token "$1-admin" -H "X-Tenant: $1"
+}
+
+echo "Setup"
+PLATFORM=(-H "Authorization: Bearer $(staff "tenancy-platform-$RUN_ID")")
+ALPHA_CODE="alpha-$RUN_ID"
+BETA_CODE="beta-$RUN_ID"
+ALPHA=(-H "Authorization: Bearer $(tenant_admin "$ALPHA_CODE")")
+BETA=(-H "Authorization: Bearer $(tenant_admin "$BETA_CODE")")
+ALPHA_ID=$(sql "SELECT id FROM tenants WHERE code = ?" "$ALPHA_CODE")
+BETA_ID=$(sql "SELECT id FROM tenants WHERE code = ?" "$BETA_CODE")
+
+request POST "$V1/customers" "{\"first_name\": \"Beta\", \"last_name\": \"Customer\", \"email\": \"beta-$RUN_ID@example.test\", \"date_of_birth\": \"1980-01-01\"}" "${BETA[@]}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${BETA[@]}"
+ACCOUNT=$(field "['account']['id']")
+request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 75}" "${BETA[@]}"
+TRANSACTION=$(field "['transaction']['id']")
+check "the second tenant has a customer, account and deposit" "s == 201 and '$CUSTOMER$ACCOUNT$TRANSACTION'.isdigit()"
+request POST "$V1/customers" "{\"first_name\": \"Alpha\", \"last_name\": \"Customer\", \"email\": \"alpha-$RUN_ID@example.test\", \"date_of_birth\": \"1980-01-01\", \"tenant_id\": $BETA_ID}" "${ALPHA[@]}"
+ALPHA_CUSTOMER=$(field "['customer']['id']")
+check "a tenant_id in the body is ignored on insert" \
+    "s == 201 and '$(sql "SELECT tenant_id FROM customers WHERE id = ?" "$ALPHA_CUSTOMER")' == '$ALPHA_ID'"
+
+echo
+echo "Lists"
+request GET "$V1/customers?limit=100" "" "${ALPHA[@]}"
+check "the other tenant's customer is not listed" "s == 200 and [r['id'] for r in b['customers']] == [$ALPHA_CUSTOMER] and b['total'] == 1"
+request GET "$V1/accounts?limit=100" "" "${ALPHA[@]}"
+check "its account is not listed" "s == 200 and $ACCOUNT not in [r['id'] for r in b['accounts']]"
+request GET "$V1/transactions?limit=100" "" "${ALPHA[@]}"
+check "its transaction is not listed" "s == 200 and not b['transactions'] and b['total'] == 0"
+
+echo
+echo "Reads"
+request GET "$V1/customers/$CUSTOMER" "" "${ALPHA[@]}"
+check "the customer cannot be read" "s == 404"
+request GET "$V1/accounts/$ACCOUNT" "" "${ALPHA[@]}"
+check "the account cannot be read" "s == 404"
+request GET "$V1/accounts/$ACCOUNT/balance" "" "${ALPHA[@]}"
+check "nor its balance" "s == 404"
+request GET "$V1/accounts/$ACCOUNT/transactions" "" "${ALPHA[@]}"
+check "its transaction history is empty, as for an account that does not exist" "s == 200 and b['transactions'] == []"
+request GET "$V1/transactions/$TRANSACTION/receipt" "" "${ALPHA[@]}"
+check "the transaction's receipt cannot be read" "s == 404"
+
+echo
+echo "Writes"
+request PUT "$V1/customers/$CUSTOMER" "{\"first_name\": \"Hijacked\"}" "${ALPHA[@]}"
+check "the customer cannot be updated" "s == 404"
+request PUT "$V1/accounts/$ACCOUNT" "{\"status\": \"frozen\"}" "${ALPHA[@]}"
+request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"withdrawal\", \"amount\": 10}" "${ALPHA[@]}"
+check "the account cannot be posted to" "s == 404"
+request POST "$V1/transactions/$TRANSACTION/reverse" "{\"reason\": \"Cross-tenant\"}" "${ALPHA[@]}"
+check "the transaction cannot be reversed" "s == 404"
+request DELETE "$V1/customers/$CUSTOMER" "" "${ALPHA[@]}"
+check "the customer cannot be deleted" "s == 404"
+check "the customer and account are unchanged" \
+    "'$(sql "SELECT first_name || ' ' || (deleted_at IS NULL) FROM customers WHERE id = ?" "$CUSTOMER")' == 'Beta 1' and '$(sql "SELECT status FROM accounts WHERE id = ?" "$ACCOUNT")' == 'active' and $(sql "SELECT balance FROM accounts WHERE id = ?" "$ACCOUNT") == 75"
+
+echo
+echo "Exports"
+request GET "$V1/admin/export/customers" "" "${ALPHA[@]}"
+check "the customer export holds only the tenant's own customers" \
+    "s == 200 and [r['id'] for r in rows if 'id' in r] == [$ALPHA_CUSTOMER]"
+request GET "$V1/admin/export/accounts" "" "${ALPHA[@]}"
+check "the account export leaves the other tenant's account out" \
+    "s == 200 and all(r.get('id') != $ACCOUNT and r.get('customer_id') != $CUSTOMER for r in rows)"
+request GET "$V1/admin/export/transactions" "" "${ALPHA[@]}"
+check "the transaction export leaves its transaction out" \
+    "s == 200 and all(r.get('id') != $TRANSACTION and r.get('account_id') != $ACCOUNT for r in rows)"
+request GET "$V1/admin/export/customers" "" "${BETA[@]}"
+check "the other tenant's export has its customer" "s == 200 and $CUSTOMER in [r.get('id') for r in rows]"
+
+echo
+echo "Switching tenants"
+request GET "$V1/customers/$CUSTOMER" "" "${ALPHA[@]}" -H "X-Tenant: $BETA_CODE"
+check "a tenant token with another tenant's header is refused" "s == 403"
+request GET "$V1/admin/export/customers" "" "${ALPHA[@]}" -H "X-Tenant: $BETA_CODE"
+check "so is an export" "s == 403"
+request GET "$V1/customers/$CUSTOMER" "" "${BETA[@]}"
+check "the owning tenant reads its customer" "s == 200 and b['email'] == 'beta-$RUN_ID@example.test'"
+request GET "$V1/accounts/$ACCOUNT/balance" "" "${BETA[@]}"
+check "and its account's balance" "s == 200 and b['balance'] == 75"
+request GET "$V1/customers/$CUSTOMER" "" "${PLATFORM[@]}"
+check "a platform admin does not see it from the default tenant" "s == 404"
+request GET "$V1/customers/$CUSTOMER" "" "${PLATFORM[@]}" -H "X-Tenant: $BETA_CODE"
+check "but may act in the tenant through the header" "s == 200"
+
+finish "tenant isolation"
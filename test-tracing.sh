@@ -0,0 +1,147 @@
+#!/bin/bash
+
+# Tracing Tests
+# Posts a transaction under a caller-supplied traceparent and checks the spans the server exported: the request span
+# continues the caller's trace and carries the request id, the statements it ran are its children, publishing the
+# transaction.posted event from the outbox joins the same trace, and the webhook delivery is a child of the publish
+# span whose context reaches the receiver in a traceparent header. A manually triggered job run is checked for a
+# root span with its statements under it, and the audit log for the request id. The server must export spans to the
+# console with its stdout in SERVER_LOG; an admin user is created with bankctl against the server's database, so
+# DB_PATH must be the database the server uses. Exits non-zero on failure.
+#
+# Usage: OTEL_TRACES_EXPORTER=console DB_PATH=banking.db go run . > server.log &
+#        SERVER_LOG=server.log DB_PATH=banking.db ./test-tracing.sh
+#        BASE_URL=http://host:port SERVER_LOG=... DB_PATH=... BANKCTL=./bankctl RECEIVER_PORT=18099 ./test-tracing.sh
+
+source "$(dirname "$0")/test-lib.sh"
+SERVER_LOG="${SERVER_LOG:-server.log}"
+RECEIVER_PORT="${RECEIVER_PORT:-18099}"
+PASSWORD="tracing-test-$RUN_ID"
+
+echo " Tracing Tests"
+echo "=============="
+
+if [ ! -f "$SERVER_LOG" ]; then
+    echo "SERVER_LOG $SERVER_LOG not found; start the server with OTEL_TRACES_EXPORTER=console and its stdout there"
+    exit 1
+fi
+
+# check_spans NAME PYTHON_EXPRESSION - evaluates the expression over the exported spans
+# `spans` holds every span as {name, trace, id, parent, kind, attrs}; `trace(id)` the spans of one trace,
+# `children(span)` a span's direct children and `named(spans, prefix)` the spans whose name starts with prefix
+check_spans() {
+    if python3 -c "
+import json, sys
+spans = []
+for line in open(sys.argv[1], errors='replace'):
+    line = line.strip()
+    if not line.startswith('{\"Name\"'):
+        continue
+    s = json.loads(line)
+    spans.append({'name': s['Name'], 'trace': s['SpanContext']['TraceID'], 'id': s['SpanContext']['SpanID'],
+                  'parent': s['Parent']['SpanID'], 'kind': s['SpanKind'],
+                  'attrs': {a['Key']: a['Value']['Value'] for a in s.get('Attributes') or []}})
+trace = lambda t: [s for s in spans if s['trace'] == t]
+children = lambda p: [s for s in spans if s['trace'] == p['trace'] and s['parent'] == p['id']]
+named = lambda ss, prefix: [s for s in ss if s['name'].startswith(prefix)]
+sys.exit(0 if ($2) else 1)
+" "$SERVER_LOG" 2>/dev/null; then
+        echo "  ✓ $1"
+    else
+        echo "  ✗ $1"
+        FAILURES=$((FAILURES + 1))
+    fi
+}
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "tracing-admin-$RUN_ID")")
+
+# Webhook receiver - records the traceparent of every delivery
+python3 -c "
+import http.server, sys
+class Receiver(http.server.BaseHTTPRequestHandler):
+    def do_POST(self):
+        self.rfile.read(int(self.headers.get('Content-Length', 0)))
+        with open(sys.argv[2], 'a') as f:
+            f.write((self.headers.get('traceparent') or '-') + '\n')
+        self.send_response(204)
+        self.end_headers()
+    def log_message(self, *args):
+        pass
+http.server.HTTPServer(('127.0.0.1', int(sys.argv[1])), Receiver).serve_forever()
+" "$RECEIVER_PORT" "$WORK/deliveries" &
+background $!
+request POST "$V1/admin/webhooks" "{\"url\": \"http://127.0.0.1:$RECEIVER_PORT/hook\", \"event_types\": \"transaction.posted\"}" "${ADMIN[@]}"
+check "a webhook subscription is registered" "s == 201"
+SUBSCRIPTION=$(field "['subscription']['id']")
+
+request POST "$V1/customers" "{\"first_name\": \"Trace\", \"last_name\": \"Customer\", \"email\": \"tracing-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}"
+CUSTOMER=$(field "['customer']['id']")
+for _ in 1 2 3; do
+    request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}"
+    [ "$STATUS" = 201 ] && break
+    sleep 1
+done
+ACCOUNT=$(field "['account']['id']")
+
+echo
+echo "Request ids"
+request GET "$BASE_URL/health"
+check "every response carries a request id" "h.get('x-request-id')"
+request GET "$BASE_URL/health" "" -H "X-Request-ID: caller-$RUN_ID"
+check "a well-formed caller id is kept" "h.get('x-request-id') == 'caller-$RUN_ID'"
+request GET "$BASE_URL/health" "" -H "X-Request-ID: bad id\"quote"
+check "a malformed caller id is replaced" "h.get('x-request-id', '').isalnum()"
+
+echo
+echo "CreateTransaction"
+TRACE=$(python3 -c "import secrets; print(secrets.token_hex(16))")
+CALLER_SPAN=$(python3 -c "import secrets; print(secrets.token_hex(8))")
+request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": 25}" \
+    -H "traceparent: 00-$TRACE-$CALLER_SPAN-01" -H "X-Request-ID: deposit-$RUN_ID" "${ADMIN[@]}"
+check "the deposit posts" "s == 201"
+
+# The outbox is polled every 2 seconds
+for _ in $(seq 1 20); do
+    grep -q "^00-$TRACE-" "$WORK/deliveries" 2>/dev/null && break
+    sleep 0.5
+done
+sleep 0.5
+
+SERVER="[s for s in named(trace('$TRACE'), 'POST /api/v1/transactions') if s['kind'] == 2][0]"
+PUBLISH="named(children($SERVER), 'outbox.publish transaction.posted')[0]"
+DELIVER="named(children($PUBLISH), 'webhook.deliver')[0]"
+check_spans "the request span continues the caller's trace" "$SERVER['parent'] == '$CALLER_SPAN'"
+check_spans "the request span carries the route, status and request id" \
+    "$SERVER['attrs']['http.route'] == '/api/v1/transactions' and $SERVER['attrs']['http.response.status_code'] == 201 and $SERVER['attrs']['request.id'] == 'deposit-$RUN_ID'"
+check_spans "the posting's statements are children of the request span" \
+    "any('INSERT INTO \`transactions\`' in s['attrs'].get('db.statement', '') for s in named(children($SERVER), 'db.create')) and any('INSERT INTO \`outbox_events\`' in s['attrs'].get('db.statement', '') for s in named(children($SERVER), 'db.create'))"
+check_spans "statements are recorded without their values" \
+    "all('deposit-$RUN_ID' not in s['attrs'].get('db.statement', '') and '25' not in s['attrs'].get('db.statement', '') for s in named(children($SERVER), 'db.'))"
+check_spans "publishing the event joins the request's trace" "$PUBLISH['attrs']['event.type'] == 'transaction.posted'"
+check_spans "the subscription lookup is a child of the publish span" "len(named(children($PUBLISH), 'db.query')) >= 1"
+check_spans "the webhook delivery is a child of the publish span" \
+    "$DELIVER['attrs']['webhook.subscription_id'] == $SUBSCRIPTION and $DELIVER['attrs']['http.response.status_code'] == 204"
+check_spans "the receiver got the delivery span's traceparent" \
+    "'00-$TRACE-' + $DELIVER['id'] + '-01' in open('$WORK/deliveries').read().split()"
+check "the outbox event kept the request's trace" \
+    "'$(python3 -c "
+import sqlite3, sys
+db = sqlite3.connect(sys.argv[1], timeout=10)
+print(db.execute(\"SELECT trace_parent FROM outbox_events WHERE event_type = 'transaction.posted' AND aggregate_id = ? ORDER BY id DESC LIMIT 1\", (sys.argv[2],)).fetchone()[0])" "$DB_PATH" "$ACCOUNT")'.startswith('00-$TRACE-')"
+request GET "$V1/admin/audit-log?request_id=deposit-$RUN_ID" "" "${ADMIN[@]}"
+check "the audit log finds the request by its id" "s == 200 and len(b['entries']) == 1 and b['entries'][0]['route'] == '/api/v1/transactions'"
+
+echo
+echo "Scheduled jobs"
+request POST "$V1/admin/jobs/alerts/run" "{}" "${ADMIN[@]}"
+check "the alerts job is triggered" "s == 202"
+RUN=$(field "['run']['id']")
+sleep 2
+JOB="[s for s in named(spans, 'job alerts') if s['attrs'].get('job.run_id') == $RUN][0]"
+check_spans "the job run is the root of its own trace" "$JOB['parent'] == '0000000000000000' and $JOB['attrs']['job.trigger'] == 'manual'"
+check_spans "the job's statements are children of its span" "len(named(children($JOB), 'db.')) >= 1"
+
+request DELETE "$V1/admin/webhooks/$SUBSCRIPTION" "" "${ADMIN[@]}"
+
+finish "tracing"
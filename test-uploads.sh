@@ -0,0 +1,181 @@
+#!/bin/bash
+
+# Document Upload Tests
+# Starts its own server with uploads scanned by a fake clamd that flags a known byte pattern, and checks every stage
+# of POST /customers/:id/documents: declared types that disagree with the magic bytes and types a kind does not
+# accept are refused with 415, oversized files with 413, flagged files with 422 and uploads while the scanner is
+# unreachable with 503. Nothing refused reaches the upload directory, and each refusal is audited as a
+# document.rejected event. Accepted images are stored without their EXIF data under the customer's prefix, keyed by
+# their hash, and download as stored. The server, scanner, database and upload directory are the script's own, so
+# no other server is needed. Exits non-zero on failure.
+#
+# Usage: ./test-uploads.sh                  (builds the server with go build)
+#        SERVER_BIN=./banking-app BANKCTL=./bankctl PORT=18101 CLAMD_PORT=18102 ./test-uploads.sh
+
+source "$(dirname "$0")/test-lib.sh"
+serve 18101
+CLAMD_PORT="${CLAMD_PORT:-18102}"
+PASSWORD="uploads-test-$RUN_ID-Aa1!"
+UPLOAD_DIR="$WORK/uploads"
+FILES="$WORK/files"
+
+echo " Document Upload Tests"
+echo "======================"
+
+# upload KIND FILE TYPE [CURL_ARGS...] - uploads a file for the customer declaring TYPE
+upload() {
+    local kind=$1 file=$2 type=$3 out
+    shift 3
+    out=$(mktemp)
+    STATUS=$(curl -s -o "$out" -w '%{http_code}' -X POST "$V1/customers/$CUSTOMER/documents" "${AUTH[@]}" \
+        -F "kind=$kind" -F "file=@$FILES/$file;type=$type" "$@")
+    BODY=$(cat "$out")
+    rm -f "$out"
+}
+
+# stored - prints the number of files in the upload directory
+stored() {
+    find "$UPLOAD_DIR" -type f 2>/dev/null | wc -l | tr -d ' '
+}
+
+# rejected REASON_FRAGMENT - prints the number of document.rejected events whose payload mentions the fragment
+rejected() {
+    sql "SELECT COUNT(*) FROM outbox_events WHERE event_type = 'document.rejected' AND aggregate_id = $CUSTOMER AND payload LIKE '%$1%'"
+}
+
+# fake_clamd - answers clamd INSTREAM scans, flagging any file containing FAKE-MALWARE-SIGNATURE
+fake_clamd() {
+    python3 -c "
+import socketserver, struct, sys
+class Clamd(socketserver.StreamRequestHandler):
+    def handle(self):
+        if self.rfile.read(10) != b'zINSTREAM\x00':
+            return
+        data = b''
+        while True:
+            size = struct.unpack('>I', self.rfile.read(4))[0]
+            if size == 0:
+                break
+            data += self.rfile.read(size)
+        found = b'FAKE-MALWARE-SIGNATURE' in data
+        self.wfile.write(b'stream: Test.Fake-Malware FOUND\x00' if found else b'stream: OK\x00')
+socketserver.ThreadingTCPServer.allow_reuse_address = True
+socketserver.ThreadingTCPServer(('127.0.0.1', int(sys.argv[1])), Clamd).serve_forever()
+" "$CLAMD_PORT" &
+    CLAMD_PID=$!
+    background $CLAMD_PID
+}
+
+# make_files - writes the test files: images with EXIF, a PDF, an infected image, text and an oversized image
+make_files() {
+    mkdir -p "$FILES"
+    python3 -c "
+import struct, sys, zlib
+d = sys.argv[1]
+def segment(marker, payload):
+    return b'\xff' + bytes([marker]) + struct.pack('>H', len(payload) + 2) + payload
+def jpeg(body):
+    return (b'\xff\xd8' + segment(0xE0, b'JFIF\x00\x01\x01') + segment(0xE1, b'Exif\x00\x00GPS 51.5N 0.12W')
+            + segment(0xDA, b'\x00\x00') + body + b'\xff\xd9')
+def chunk(kind, payload):
+    return struct.pack('>I', len(payload)) + kind + payload + struct.pack('>I', zlib.crc32(kind + payload))
+png = (b'\x89PNG\r\n\x1a\n' + chunk(b'IHDR', bytes([0, 0, 0, 1, 0, 0, 0, 1, 8, 2, 0, 0, 0]))
+       + chunk(b'eXIf', b'MM\x00*GPS 51.5N 0.12W') + chunk(b'IDAT', b'pixels') + chunk(b'IEND', b''))
+files = {
+    'cheque.jpg': jpeg(b'cheque front'),
+    'identity.png': png,
+    'address.pdf': b'%PDF-1.4\nutility bill\n%%EOF\n',
+    'infected.jpg': jpeg(b'cheque FAKE-MALWARE-SIGNATURE'),
+    'notes.txt': b'plain text notes',
+    'huge.jpg': jpeg(b'\x00' * (10 << 20)),
+    'program.exe': b'MZ\x90\x00\x03 FAKE-MALWARE-SIGNATURE',
+}
+for name, data in files.items():
+    open(d + '/' + name, 'wb').write(data)
+" "$FILES"
+}
+
+build
+
+echo "Setup"
+make_files
+fake_clamd
+start_server "$SERVER_BIN" CLAMAV_ADDR="127.0.0.1:$CLAMD_PORT" UPLOAD_DIR="$UPLOAD_DIR"
+AUTH=(-H "Authorization: Bearer $(staff "uploads-admin")")
+request POST "$V1/customers" "{\"first_name\": \"Upload\", \"last_name\": \"Tester\", \"email\": \"uploads-$RUN_ID@example.test\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+ACCOUNT=$(field "['account']['id']")
+request POST "$V1/customers" "{\"first_name\": \"Other\", \"last_name\": \"Owner\", \"email\": \"uploads-other-$RUN_ID@example.test\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+OTHER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $OTHER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+OTHER_ACCOUNT=$(field "['account']['id']")
+check "a customer with an account exists" "s == 201 and '$CUSTOMER$ACCOUNT$OTHER_ACCOUNT'.isdigit()"
+
+echo
+echo "Accepted"
+upload cheque_image cheque.jpg image/jpeg -F "account_id=$ACCOUNT"
+check "a JPEG cheque image is accepted" "s == 201 and b['document']['content_type'] == 'image/jpeg' and b['document']['account_id'] == $ACCOUNT"
+CHEQUE=$(field "['document']['id']")
+KEY=$(sql "SELECT storage_key FROM documents WHERE id = $CHEQUE")
+SHA=$(field "['document']['sha256']")
+check "it is stored under the customer's prefix, keyed by its hash" \
+    "'$KEY' == 'documents/1/$CUSTOMER/$(date -u +%Y/%m)/$SHA' and '$(stored)' == '1'"
+BODY=$(python3 -c "
+import hashlib, json, sys
+data = open(sys.argv[1], 'rb').read()
+print(json.dumps({'gps': b'GPS' in data, 'jfif': b'JFIF' in data, 'sha': hashlib.sha256(data).hexdigest(), 'size': len(data)}))" "$UPLOAD_DIR/$KEY")
+check "the stored image has no EXIF, keeps its JFIF header and matches its hash" "not b['gps'] and b['jfif'] and b['sha'] == '$SHA'"
+curl -s -o "$WORK/download" "$V1/customers/$CUSTOMER/documents/$CHEQUE" "${AUTH[@]}"
+check "the download is the stored file" "$(cmp -s "$WORK/download" "$UPLOAD_DIR/$KEY" && echo True || echo False)"
+upload identity identity.png image/png
+check "a PNG identity document is accepted" "s == 201 and b['document']['content_type'] == 'image/png'"
+check "its eXIf chunk is stripped" "b'GPS' not in open('$UPLOAD_DIR/$(sql "SELECT storage_key FROM documents WHERE id = $(field "['document']['id']")")', 'rb').read()"
+upload proof_of_address address.pdf application/pdf
+check "a PDF proof of address is accepted" "s == 201 and b['document']['content_type'] == 'application/pdf'"
+upload identity identity.png application/octet-stream
+check "a generic declared type is accepted by its contents" "s == 201 and b['document']['content_type'] == 'image/png'"
+ACCEPTED=$(stored)
+
+echo
+echo "Refused"
+upload cheque_image identity.png image/jpeg
+check "a PNG declared as JPEG is refused" "s == 415"
+upload identity program.exe application/pdf
+check "an executable declared as a PDF is refused before the scan" "s == 415"
+upload cheque_image address.pdf application/pdf
+check "a PDF is not a cheque image" "s == 415"
+upload identity notes.txt text/plain
+check "plain text is not a document" "s == 415"
+upload cheque_image huge.jpg image/jpeg
+check "an image over 10 MB is refused" "s == 413"
+upload cheque_image infected.jpg image/jpeg
+check "a flagged file is refused" "s == 422"
+upload cheque_image cheque.jpg image/jpeg -F "account_id=$OTHER_ACCOUNT"
+check "another customer's account is refused" "s == 400"
+upload selfie cheque.jpg image/jpeg
+check "an unknown kind is refused" "s == 400"
+STATUS=$(curl -s -o /dev/null -w '%{http_code}' -X POST "$V1/customers/$CUSTOMER/documents" -F kind=identity -F "file=@$FILES/cheque.jpg;type=image/jpeg")
+BODY=
+check "uploads need a token" "s == 401"
+check "nothing refused was stored" "'$(stored)' == '$ACCEPTED'"
+
+echo
+echo "Scanner down"
+kill "$CLAMD_PID" 2>/dev/null
+wait "$CLAMD_PID" 2>/dev/null
+upload identity address.pdf application/pdf
+check "uploads are refused while the scanner is unreachable" "s == 503"
+check "and nothing is stored unscanned" "'$(stored)' == '$ACCEPTED'"
+
+echo
+echo "Audit"
+check "a flagged file is audited with its signature" "$(rejected 'Test.Fake-Malware') == 1"
+check "every refusal by the pipeline is audited" \
+    "$(sql "SELECT COUNT(*) FROM outbox_events WHERE event_type = 'document.rejected' AND aggregate_id = $CUSTOMER") == 7"
+check "every accepted upload is recorded" \
+    "$(sql "SELECT COUNT(*) FROM outbox_events WHERE event_type = 'document.uploaded' AND aggregate_id = $CUSTOMER") == 4"
+request GET "$V1/customers/$CUSTOMER/documents" "" "${AUTH[@]}"
+check "only accepted documents are listed" "s == 200 and len(b['documents']) == 4"
+
+finish "upload"
@@ -0,0 +1,172 @@
+#!/bin/bash
+
+# API Usage Tests
+# Starts its own server, flushing usage every second, and checks API client usage counting and quotas. A client's
+# balance reads are fired 200 at a time in parallel and counted exactly, both before and after the counters are
+# written to the daily usage records; rows returned by list endpoints are counted, and refused writes are not. The
+# client sees its own usage and staff see any client's. A block quota lets exactly its limit of parallel requests
+# through and refuses the rest with 429 QUOTA_EXCEEDED and Retry-After, on v1 and v2, while other categories are
+# still served; a warn quota serves with a warning header and a throttle quota serves a few a minute. The server
+# and database are the script's own, so no other server is needed. Exits non-zero on failure.
+#
+# Usage: ./test-usage.sh                                 (builds the server with go build)
+#        SERVER_BIN=./banking-app BANKCTL=./bankctl PORT=18095 ./test-usage.sh
+
+source "$(dirname "$0")/test-lib.sh"
+serve 18095
+V2="$BASE_URL/api/v2"
+PASSWORD="usage-test-$RUN_ID-Aa1!"
+REDIRECT="https://partner.example/callback"
+FORM=1
+CHECK_HELPERS="cat = lambda name: next((x for x in b['categories'] if x['category'] == name), None)"
+
+echo " API Usage Tests"
+echo "================"
+
+# query_param URL NAME - prints one query parameter of a URL
+query_param() {
+    python3 -c "import sys, urllib.parse; print(urllib.parse.parse_qs(urllib.parse.urlparse(sys.argv[1]).query).get(sys.argv[2], [''])[0])" "$1" "$2"
+}
+
+# register NAME SCOPE - registers a client the customer approves, storing its id in CLIENT_ID and token in TOKEN
+register() {
+    request POST "$V1/admin/oauth/clients" "{\"name\": \"$1\", \"redirect_uris\": \"$REDIRECT\", \"scopes\": \"$2\"}" "${ADMIN[@]}"
+    CLIENT_ID=$(field "['client']['client_id']")
+    local secret
+    secret=$(field "['client_secret']")
+    request POST "$V1/oauth/authorize" "{\"client_id\": \"$CLIENT_ID\", \"redirect_uri\": \"$REDIRECT\", \"scope\": \"$2\", \"approve\": true}" "${CUSTOMER[@]}"
+    local code
+    code=$(query_param "$(field "['redirect_to']")" code)
+    request POST "$V1/oauth/token" "grant_type=authorization_code&code=$code&redirect_uri=$REDIRECT" -u "$CLIENT_ID:$secret"
+    TOKEN=$(field "['access_token']")
+}
+
+# parallel N TOKEN URL - fires N requests 20 at a time and prints how many got each status, e.g. "200:150 429:50"
+parallel() {
+    seq 1 "$1" | xargs -P 20 -I{} curl -s -o /dev/null -w '%{http_code}\n' -H "Authorization: Bearer $2" "$3" |
+        sort | uniq -c | awk '{printf "%s%s:%s", sep, $2, $1; sep=" "}'
+}
+
+# flushed CLIENT CATEGORY - prints the requests written to the usage records for a client's category
+flushed() {
+    sql "SELECT COALESCE(SUM(requests), 0) FROM usage_records WHERE client_id = '$1' AND category = '$2'"
+}
+
+build
+
+echo "Setup"
+start_server "$SERVER_BIN" USAGE_FLUSH_SECONDS=1
+ADMIN=(-H "Authorization: Bearer $(staff "usage-admin")")
+request POST "$V1/customers" "{\"first_name\": \"Usage\", \"last_name\": \"Holder\", \"email\": \"usage-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${ADMIN[@]}"
+HOLDER=$(field "['customer']['id']")
+CUSTOMER=(-H "Authorization: Bearer $(customer_user "usage-customer" "$HOLDER")")
+request POST "$V1/accounts" "{\"customer_id\": $HOLDER, \"account_type\": \"checking\"}" "${ADMIN[@]}"
+CHECKING=$(field "['account']['id']")
+for amount in 10 20 30; do
+    request POST "$V1/transactions" "{\"account_id\": $CHECKING, \"transaction_type\": \"deposit\", \"amount\": $amount}" "${ADMIN[@]}"
+done
+check "the account has three deposits" "s == 201"
+register "Usage App" "balances:read,transactions:read"
+APP_ID=$CLIENT_ID
+APP_TOKEN=$TOKEN
+register "Capped App" "balances:read"
+CAPPED_ID=$CLIENT_ID
+CAPPED_TOKEN=$TOKEN
+APP=(-H "Authorization: Bearer $APP_TOKEN")
+CAPPED=(-H "Authorization: Bearer $CAPPED_TOKEN")
+check "both clients hold tokens" "'$APP_TOKEN' != '' and '$CAPPED_TOKEN' != ''"
+
+echo
+echo "Counting"
+RESULT=$(parallel 200 "$APP_TOKEN" "$V1/accounts/$CHECKING/balance")
+STATUS=200 BODY= check "200 parallel balance reads are all served ($RESULT)" "'$RESULT' == '200:200'"
+request GET "$V1/usage" "" "${APP[@]}"
+check "the client sees each of them counted before any flush" "s == 200 and b['client_id'] == '$APP_ID' and cat('accounts/balance')['requests'] == 200"
+sleep 2.5
+check "the flush writes exactly that count to the usage records" "$(flushed "$APP_ID" accounts/balance) == 200"
+RESULT=$(parallel 100 "$APP_TOKEN" "$V1/accounts/$CHECKING/balance")
+request GET "$V1/usage" "" "${APP[@]}"
+check "counts after a flush add to the records without counting them twice" \
+    "'$RESULT' == '200:100' and cat('accounts/balance')['requests'] == 300"
+sleep 2.5
+check "and the next flush adds them to the same record" \
+    "$(flushed "$APP_ID" accounts/balance) == 300 and $(sql "SELECT COUNT(*) FROM usage_records WHERE client_id = '$APP_ID' AND category = 'accounts/balance'") == 1"
+
+request GET "$V1/accounts/$CHECKING/transactions" "" "${APP[@]}"
+ROWS=$(field "['transactions'].__len__()")
+request GET "$V1/accounts/$CHECKING/activity" "" "${APP[@]}"
+ITEMS=$(field "['items'].__len__()")
+request GET "$V1/accounts/$CHECKING/transactions" "" "${APP[@]}"
+request POST "$V1/transactions" "{\"account_id\": $CHECKING, \"transaction_type\": \"deposit\", \"amount\": 5}" "${APP[@]}"
+check "a client's write is refused" "s == 403"
+request GET "$V1/usage" "" "${APP[@]}"
+check "rows returned by list endpoints are counted" \
+    "$ROWS >= 3 and cat('accounts/transactions')['rows_exported'] == 2 * $ROWS and cat('accounts/activity')['rows_exported'] == $ITEMS"
+check "the refused write initiates no transaction" "b['totals']['transactions'] == 0 and cat('transactions') is None"
+check "usage is reported by day" "len(b['days']) == 1 and b['days'][0]['requests'] == b['totals']['requests']"
+
+request GET "$V1/admin/clients/$APP_ID/usage" "" "${ADMIN[@]}"
+check "staff see a client's usage" "s == 200 and cat('accounts/balance')['requests'] == 300 and b['totals']['rows_exported'] == 2 * $ROWS + $ITEMS"
+request GET "$V1/admin/clients/$APP_ID/usage?from=2001-01-01&to=2001-01-31" "" "${ADMIN[@]}"
+check "a period without usage is empty" "s == 200 and b['totals']['requests'] == 0 and b['categories'] == []"
+request GET "$V1/admin/clients/$APP_ID/usage?from=2001-02-01&to=2001-01-31" "" "${ADMIN[@]}"
+check "a period ending before it starts is refused" "s == 400"
+request GET "$V1/admin/clients/cli_unknown/usage" "" "${ADMIN[@]}"
+check "an unknown client is not found" "s == 404"
+request GET "$V1/usage" "" "${ADMIN[@]}"
+check "staff have no usage of their own" "s == 403"
+request GET "$V1/admin/clients/$APP_ID/usage" "" "${CUSTOMER[@]}"
+check "customers cannot see a client's usage" "s == 403"
+
+echo
+echo "Block quota"
+request PUT "$V1/admin/clients/$CAPPED_ID/quotas" "{\"metric\": \"calls\", \"monthly_limit\": 50, \"mode\": \"block\"}" "${ADMIN[@]}"
+check "an unknown metric is refused" "s == 400"
+request PUT "$V1/admin/clients/$CAPPED_ID/quotas" "{\"metric\": \"requests\", \"monthly_limit\": 50, \"mode\": \"stop\"}" "${ADMIN[@]}"
+check "an unknown mode is refused" "s == 400"
+request PUT "$V1/admin/clients/$CAPPED_ID/quotas" "{\"metric\": \"requests\", \"category\": \"accounts/balance\", \"monthly_limit\": 50, \"mode\": \"block\"}" "${ADMIN[@]}"
+check "a block quota is set on one category" "s == 200 and b['quota']['monthly_limit'] == 50 and b['quota']['updated_by'] == 'usage-admin'"
+RESULT=$(parallel 100 "$CAPPED_TOKEN" "$V1/accounts/$CHECKING/balance")
+STATUS=200 BODY= check "of 100 parallel reads exactly 50 are served ($RESULT)" "'$RESULT' == '200:50 429:50'"
+request GET "$V1/accounts/$CHECKING/balance" "" "${CAPPED[@]}"
+check "further reads are refused with QUOTA_EXCEEDED" \
+    "s == 429 and b['code'] == 'QUOTA_EXCEEDED' and b['quota']['used'] == 50 and b['quota']['mode'] == 'block'"
+check "with Retry-After until the month ends and no quota left" \
+    "'retry-after' in h and h.get('x-quota-remaining') == '0' and b['quota']['retry_after_seconds'] > 0"
+request GET "$V2/accounts/$CHECKING/balance" "" "${CAPPED[@]}"
+check "v2 refuses in its envelope" "s == 429 and b['error']['code'] == 'QUOTA_EXCEEDED' and b['error']['details']['monthly_limit'] == 50"
+request GET "$V1/accounts/$CHECKING/balance" "" "${CAPPED[@]}" -H "Accept-Language: es"
+check "in the caller's language" "s == 429 and b['error'].startswith('Se ha superado')"
+request GET "$V1/usage" "" "${CAPPED[@]}"
+check "other categories are still served" "s == 200 and cat('accounts/balance')['requests'] == 50"
+check "the quota shows as used up" \
+    "b['quotas'][0]['used'] == 50 and b['quotas'][0]['remaining'] == 0 and b['quotas'][0]['exceeded'] is True"
+request PUT "$V1/admin/clients/$CAPPED_ID/quotas" "{\"metric\": \"requests\", \"category\": \"accounts/balance\", \"monthly_limit\": 60, \"mode\": \"block\"}" "${ADMIN[@]}"
+QUOTA=$(field "['quota']['id']")
+request GET "$V1/accounts/$CHECKING/balance" "" "${CAPPED[@]}"
+check "raising the limit serves reads again at once" "s == 200 and h.get('x-quota-remaining') == '9'"
+request GET "$V1/admin/clients/$CAPPED_ID/quotas" "" "${ADMIN[@]}"
+check "setting the same metric and category replaces the quota" "s == 200 and len(b['quotas']) == 1 and b['quotas'][0]['id'] == $QUOTA"
+
+echo
+echo "Warn and throttle quotas"
+request PUT "$V1/admin/clients/$APP_ID/quotas" "{\"metric\": \"requests\", \"monthly_limit\": 100, \"mode\": \"warn\"}" "${ADMIN[@]}"
+request GET "$V1/accounts/$CHECKING/balance" "" "${APP[@]}"
+check "past a warn quota requests are served with a warning" "s == 200 and h.get('x-quota-warning') == 'Monthly requests quota of 100 exceeded'"
+request PUT "$V1/admin/clients/$APP_ID/quotas" "{\"metric\": \"requests\", \"monthly_limit\": 100, \"mode\": \"throttle\", \"throttle_per_minute\": 5}" "${ADMIN[@]}"
+check "a throttle quota replaces it" "s == 200 and b['quota']['mode'] == 'throttle' and b['quota']['throttle_per_minute'] == 5"
+THROTTLE=$(field "['quota']['id']")
+# Kept inside one clock minute
+[ "$(date +%S | sed 's/^0//')" -gt 50 ] && sleep 12
+RESULT=$(parallel 20 "$APP_TOKEN" "$V1/accounts/$CHECKING/balance")
+STATUS=200 BODY= check "past a throttle quota 5 of 20 parallel reads are served in the minute ($RESULT)" "'$RESULT' == '200:5 429:15'"
+request GET "$V1/accounts/$CHECKING/balance" "" "${APP[@]}"
+check "the rest are refused until the next minute" \
+    "s == 429 and b['quota']['mode'] == 'throttle' and 0 < int(h['retry-after']) <= 60"
+request PUT "$V1/admin/clients/$APP_ID/quotas" "{\"metric\": \"rows_exported\", \"monthly_limit\": 1000, \"mode\": \"block\"}" "${ADMIN[@]}"
+request DELETE "$V1/admin/clients/$APP_ID/quotas/$THROTTLE" "" "${ADMIN[@]}"
+check "a quota is removed" "s == 200"
+request GET "$V1/accounts/$CHECKING/balance" "" "${APP[@]}"
+check "and requests are served again, against the quota left" "s == 200 and h.get('x-quota-limit') == '1000'"
+
+finish "usage"
@@ -0,0 +1,104 @@
+#!/bin/bash
+
+# Money Movement Validation Tests
+# Checks that every entry point that moves money refuses the same requests with the same codes: zero amounts
+# (ZERO_AMOUNT) and negative ones (INVALID_AMOUNT) on v1 and v2 transactions and transfers, loan payments and
+# incoming credits; transfers and closure sweeps to the same account (SELF_TRANSFER); and Idempotency-Key
+# retries of a transfer, which return the original when the request matches and IDEMPOTENCY_CONFLICT when the
+# amount or accounts differ. The admin user is created with bankctl. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-validation.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-validation.sh
+
+source "$(dirname "$0")/test-lib.sh"
+V2="$BASE_URL/api/v2"
+PASSWORD="validation-test-$RUN_ID"
+ADMIN_USER="validation-admin-$RUN_ID"
+
+echo " Money Movement Validation Tests"
+echo "================================"
+
+# account CUSTOMER - opens a checking account and stores its ID in ACCOUNT
+account() {
+    request POST "$V1/accounts" "{\"customer_id\": $1, \"account_type\": \"checking\"}"
+    ACCOUNT=$(field "['account']['id']")
+}
+
+# balance ID - prints an account's balance
+balance() {
+    request GET "$V1/accounts/$1"
+    field "['balance']"
+}
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "$ADMIN_USER")")
+request POST "$V1/customers" "{\"first_name\": \"Negative\", \"last_name\": \"Paths\", \"email\": \"validation-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\", \"monthly_income\": 10000}"
+CUSTOMER=$(field "['customer']['id']")
+account "$CUSTOMER"
+FROM=$ACCOUNT
+account "$CUSTOMER"
+TO=$ACCOUNT
+request POST "$V1/transactions" "{\"account_id\": $FROM, \"transaction_type\": \"deposit\", \"amount\": 500}"
+check "the source account is funded" "s == 201"
+
+echo
+echo "Transactions"
+request POST "$V1/transactions" "{\"account_id\": $FROM, \"transaction_type\": \"deposit\", \"amount\": 0}"
+check "v1 refuses a zero amount" "s == 400 and b['code'] == 'ZERO_AMOUNT'"
+request POST "$V1/transactions" "{\"account_id\": $FROM, \"transaction_type\": \"withdrawal\", \"amount\": -5}"
+check "v1 refuses a negative amount" "s == 400 and 'positive' in b['error']"
+request POST "$V2/transactions" "{\"account_id\": $FROM, \"transaction_type\": \"deposit\", \"amount\": \"0.00\"}"
+check "v2 refuses a zero amount" "s == 400 and b['error']['code'] == 'ZERO_AMOUNT'"
+
+echo
+echo "Transfers"
+request POST "$V1/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $FROM, \"amount\": 10}"
+check "v1 refuses a self-transfer" "s == 400 and b['code'] == 'SELF_TRANSFER'"
+request POST "$V2/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $FROM, \"amount\": \"10.00\"}"
+check "v2 refuses a self-transfer" "s == 400 and b['error']['code'] == 'SELF_TRANSFER'"
+request POST "$V1/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $TO, \"amount\": 0}"
+check "v1 refuses a zero transfer" "s == 400 and b['code'] == 'ZERO_AMOUNT'"
+request POST "$V1/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $TO}"
+check "a transfer without an amount is a zero transfer" "s == 400 and b['code'] == 'ZERO_AMOUNT'"
+request POST "$V2/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $TO, \"amount\": \"0\"}"
+check "v2 refuses a zero transfer" "s == 400 and b['error']['code'] == 'ZERO_AMOUNT'"
+request POST "$V1/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $TO, \"amount\": -10}"
+check "v1 refuses a negative transfer" "s == 400 and 'positive' in b['error']"
+check "nothing moved" "$(balance "$FROM") == 500 and $(balance "$TO") == 0"
+
+echo
+echo "Idempotency keys"
+KEY=(-H "Idempotency-Key: validation-$RUN_ID")
+request POST "$V1/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $TO, \"amount\": 40}" "${KEY[@]}"
+check "a keyed transfer posts" "s == 201 and b['transfer']['idempotency_key'] == 'validation-$RUN_ID'"
+ORIGINAL=$(field "['transfer']['id']")
+request POST "$V1/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $TO, \"amount\": 40, \"description\": \"retry\"}" "${KEY[@]}"
+check "a retry with the key returns the original" "s == 200 and b['idempotent_replay'] and b['transfer']['id'] == $ORIGINAL"
+request POST "$V2/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $TO, \"amount\": \"40.00\"}" "${KEY[@]}"
+check "v2 retries return the original too" "s == 200 and b['data']['id'] == $ORIGINAL"
+check "the transfer posted once" "$(balance "$FROM") == 460 and $(balance "$TO") == 40"
+request POST "$V1/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $TO, \"amount\": 45}" "${KEY[@]}"
+check "the key with another amount conflicts" "s == 409 and b['code'] == 'IDEMPOTENCY_CONFLICT' and b['original_transfer_id'] == $ORIGINAL"
+request POST "$V2/transfers" "{\"from_account_id\": $TO, \"to_account_id\": $FROM, \"amount\": \"40.00\"}" "${KEY[@]}"
+check "the key with other accounts conflicts" "s == 409 and b['error']['code'] == 'IDEMPOTENCY_CONFLICT'"
+check "nothing more moved" "$(balance "$FROM") == 460 and $(balance "$TO") == 40"
+request POST "$V1/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $TO, \"amount\": 1}" -H "Idempotency-Key: $(printf 'k%.0s' $(seq 1 101))"
+check "an overlong key is refused" "s == 400"
+
+echo
+echo "Other entry points"
+request POST "$V1/loans" "{\"customer_id\": $CUSTOMER, \"principal_amount\": 1000, \"interest_rate\": 0.05, \"loan_term\": 12}"
+LOAN=$(field "['loan']['id']")
+request POST "$V1/loans/$LOAN/payments" "{\"account_id\": $FROM, \"amount\": 0}"
+check "a zero loan payment is refused" "s == 400 and b['code'] == 'ZERO_AMOUNT'"
+request POST "$V1/loans/$LOAN/payments" "{\"account_id\": $FROM, \"amount\": -20}"
+check "a negative loan payment is refused" "s == 400 and 'positive' in b['error']"
+request GET "$V1/accounts/$FROM"
+request POST "$V1/operations/incoming-credits" "{\"account_number\": \"$(field "['account_number']")\", \"amount\": 0}" "${ADMIN[@]}"
+check "a zero incoming credit is refused" "s == 400 and b['code'] == 'ZERO_AMOUNT'"
+request POST "$V1/accounts/$FROM/close" "{\"destination_account_id\": $FROM}" "${ADMIN[@]}"
+check "a closure cannot sweep into the closing account" "s == 400 and b['code'] == 'SELF_TRANSFER'"
+request GET "$V1/accounts/$FROM"
+check "the account stays open" "b['status'] == 'active'"
+
+finish "validation"
@@ -0,0 +1,162 @@
+#!/bin/bash
+
+# Value Dating Tests
+# Checks that tenant cutoffs are validated, that a posting type without a cutoff is valued the day it is
+# effective, and that transfers, bill payments (payment postings) and atomic batches submitted just before their
+# cutoff are valued today's business day while those submitted just after it post at once but are valued the
+# next business day - both transfer legs, and every batch leg, on the same day. It then closes every weekday from
+# tomorrow through next Monday as holidays and checks a late transfer is valued after the holiday weekend, and
+# that the customer statement shows both the effective and the value date. Each run creates its own tenant so its
+# cutoffs never value other runs' postings; the platform admin is created with bankctl and value dates are read
+# from the server's database, so DB_PATH must be the database the server uses. The holidays added are removed
+# at the end. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-value-dating.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl ./test-value-dating.sh
+
+source "$(dirname "$0")/test-lib.sh"
+PASSWORD="value-dating-test-$RUN_ID-Aa1!"
+PLATFORM_USER="value-dating-platform-$RUN_ID"
+TENANT_CODE="vd$RUN_ID"
+HOLIDAYS=()
+
+echo " Value Dating Tests"
+echo "==================="
+
+# valued TRANSACTION_IDS - prints the distinct bank dates the postings with these database IDs are valued on
+valued() {
+    sql "SELECT DISTINCT substr(value_date, 1, 10) FROM transactions WHERE id IN ($1)"
+}
+
+# clock OFFSET_MINUTES - prints the bank's wall-clock time moved by OFFSET_MINUTES, as HH:MM
+clock() {
+    python3 -c "
+import datetime, sys, zoneinfo
+now = datetime.datetime.now(zoneinfo.ZoneInfo(sys.argv[1])) + datetime.timedelta(minutes=int(sys.argv[2]))
+print(now.strftime('%H:%M'))" "$ZONE" "$1"
+}
+
+# cutoffs JSON - replaces the run tenant's cutoffs
+cutoffs() {
+    request PUT "$V1/admin/tenants/$TENANT" "{\"settings\": {\"cutoffs\": $1}}" "${PLATFORM[@]}"
+}
+
+# calendar - reads today's business day facts into TODAY, OPEN and NEXT
+calendar() {
+    request GET "$V1/calendar/business-day"
+    TODAY=$(field "['date']")
+    OPEN=$(field "['is_business_day']")
+    NEXT=$(field "['next_business_day']")
+}
+
+echo "Setup"
+tenant "$PLATFORM_USER" "Value dating" value-dating-admin
+request POST "$V1/customers" "{\"first_name\": \"Valerie\", \"last_name\": \"Date\", \"email\": \"value-dating-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}" "${AUTH[@]}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}" "${AUTH[@]}"
+FROM=$(field "['account']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"savings\"}" "${AUTH[@]}"
+TO=$(field "['account']['id']")
+request GET "$V1/calendar/business-day"
+ZONE=$(field "['time_zone']")
+# Cutoffs a few minutes ahead must not wrap past midnight
+while [[ "$(clock 0)" > "23:54" ]]; do
+    sleep 30
+done
+calendar
+if [ "$OPEN" = "True" ]; then
+    SAME_DAY=$TODAY
+else
+    SAME_DAY=$NEXT
+fi
+echo "  bank time $(clock 0) in $ZONE; today $TODAY, open $OPEN, next business day $NEXT"
+
+echo ""
+echo "Cutoff settings"
+cutoffs '{"transfer": "25:00"}'
+check "a cutoff that is not a time is refused" "s == 400"
+cutoffs '{"interest": "17:00"}'
+check "cutoffs are only set for types clients submit" "s == 400"
+cutoffs '{"transfer": "9:30", "payment": "16:00"}'
+check "cutoffs are accepted per transaction type" "s == 200"
+request GET "$V1/admin/tenants" "" "${PLATFORM[@]}"
+check "cutoffs are normalized to HH:MM" "[t for t in b['tenants'] if t['tenant']['id'] == $TENANT][0]['effective_settings']['cutoffs'] == {'transfer': '09:30', 'payment': '16:00'}"
+
+echo ""
+echo "Types without a cutoff"
+request POST "$V1/transactions" "{\"account_id\": $FROM, \"transaction_type\": \"deposit\", \"amount\": 1000}" "${AUTH[@]}"
+check "a deposit is valued on the day it is effective" "s == 201 and b['transaction']['value_date'][:10] == '$TODAY'"
+
+echo ""
+echo "Just before the cutoff"
+cutoffs "{\"transfer\": \"$(clock 3)\", \"payment\": \"$(clock 3)\", \"withdrawal\": \"$(clock 3)\"}"
+check "cutoffs are set a few minutes ahead" "s == 200"
+request POST "$V1/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $TO, \"amount\": 10, \"description\": \"Before cutoff\"}" "${AUTH[@]}"
+check "a transfer before the cutoff posts" "s == 201"
+LEGS="$(field "['transfer']['debit_transaction_id']"),$(field "['transfer']['credit_transaction_id']")"
+BODY="\"$(valued "$LEGS")\""
+check "both legs are valued on today's business day" "b == '$SAME_DAY'"
+request POST "$V1/transactions" "{\"account_id\": $FROM, \"transaction_type\": \"payment\", \"amount\": 20, \"description\": \"Electric bill before cutoff\"}" "${AUTH[@]}"
+check "a bill payment before the cutoff is valued on today's business day" "s == 201 and b['transaction']['value_date'][:10] == '$SAME_DAY'"
+request POST "$V1/transactions/batch-atomic" "{\"currency\": \"USD\", \"legs\": [{\"account_id\": $FROM, \"direction\": \"debit\", \"amount\": 5}, {\"account_id\": $TO, \"direction\": \"credit\", \"amount\": 5}]}" "${AUTH[@]}"
+check "a batch before the cutoff values every leg on today's business day" "s == 201 and {t['value_date'][:10] for t in b['transactions']} == {'$SAME_DAY'}"
+
+echo ""
+echo "Just after the cutoff"
+cutoffs "{\"transfer\": \"$(clock 0)\", \"payment\": \"$(clock 0)\", \"withdrawal\": \"$(clock 0)\"}"
+check "cutoffs are set to the current minute" "s == 200"
+request POST "$V1/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $TO, \"amount\": 11, \"description\": \"After cutoff\"}" "${AUTH[@]}"
+check "a transfer after the cutoff still posts at once" "s == 201"
+LEGS="$(field "['transfer']['debit_transaction_id']"),$(field "['transfer']['credit_transaction_id']")"
+BODY="\"$(valued "$LEGS")\""
+check "both legs are valued on the next business day" "b == '$NEXT'"
+request GET "$V1/accounts/$FROM/balance" "" "${AUTH[@]}"
+check "the balance moves when the transfer posts" "s == 200 and abs(float(b['balance']) - 954) < 0.001"
+request POST "$V1/transactions" "{\"account_id\": $FROM, \"transaction_type\": \"payment\", \"amount\": 21, \"description\": \"Electric bill after cutoff\"}" "${AUTH[@]}"
+check "a bill payment after the cutoff is valued on the next business day" "s == 201 and b['transaction']['value_date'][:10] == '$NEXT'"
+request POST "$V1/transactions" "{\"account_id\": $FROM, \"transaction_type\": \"deposit\", \"amount\": 1}" "${AUTH[@]}"
+check "a deposit, which has no cutoff, is still valued today" "s == 201 and b['transaction']['value_date'][:10] == '$TODAY'"
+request POST "$V1/transactions/batch-atomic" "{\"currency\": \"USD\", \"legs\": [{\"account_id\": $FROM, \"direction\": \"debit\", \"amount\": 6}, {\"account_id\": $TO, \"direction\": \"credit\", \"amount\": 6}]}" "${AUTH[@]}"
+check "a batch after its debit leg's cutoff values every leg on the next business day" "s == 201 and {t['value_date'][:10] for t in b['transactions']} == {'$NEXT'}"
+
+echo ""
+echo "Across a holiday weekend"
+# Every weekday from tomorrow through next Monday becomes a holiday, so the weekend runs into a closed Monday
+for d in $(python3 -c "
+import datetime, sys
+today = datetime.date.fromisoformat(sys.argv[1])
+monday = today + datetime.timedelta(days=(7 - today.weekday()) % 7 or 7)
+d = today + datetime.timedelta(days=1)
+while d <= monday:
+    if d.weekday() < 5:
+        print(d.isoformat())
+    d += datetime.timedelta(days=1)" "$TODAY"); do
+    request POST "$V1/admin/holidays" "{\"date\": \"$d\", \"name\": \"Value dating $RUN_ID\"}" "${PLATFORM[@]}"
+    if [ "$STATUS" = "201" ]; then
+        HOLIDAYS+=("$(field "['holiday']['id']")")
+    fi
+    MONDAY=$d
+done
+calendar
+echo "  next business day after the holidays: $NEXT"
+BODY="\"$NEXT\""
+check "the next business day is after next Monday's holiday" "b > '$MONDAY'"
+request POST "$V1/transfers" "{\"from_account_id\": $FROM, \"to_account_id\": $TO, \"amount\": 12, \"description\": \"Before the long weekend\"}" "${AUTH[@]}"
+check "a late transfer before the holiday weekend posts at once" "s == 201"
+LEGS="$(field "['transfer']['debit_transaction_id']"),$(field "['transfer']['credit_transaction_id']")"
+BODY="\"$(valued "$LEGS")\""
+check "it is valued on the first business day after the holiday weekend" "b == '$NEXT'"
+for id in "${HOLIDAYS[@]}"; do
+    request DELETE "$V1/admin/holidays/$id" "" "${PLATFORM[@]}"
+done
+
+echo ""
+echo "Statements"
+MONTH=$(echo "$TODAY" | tr '-' '/' | cut -c1-7)
+request GET "$V1/customers/$CUSTOMER/statements/$MONTH" "" "${AUTH[@]}"
+check "statement lines carry both the effective and the value date" "s == 200 and all('date' in l and 'value_date' in l for a in b['accounts'] for l in a['lines'])"
+check "a late posting shows its later value date beside the effective date it was submitted on" "any(l['value_date'][:10] == '$NEXT' and l['date'][:10] == '$TODAY' for a in b['accounts'] for l in a['lines'])"
+request GET "$V1/customers/$CUSTOMER/statements/$MONTH?format=pdf" "" "${AUTH[@]}"
+check "the PDF statement renders with the value date column" "s == 200"
+
+finish "value dating"
@@ -0,0 +1,131 @@
+#!/bin/bash
+
+# Email Verification Tests
+# Checks that a new customer's email starts unverified and is sent a token, that e-statements and email alerts
+# are refused until it is verified, that a token verifies once and can neither be reused, guessed nor used after
+# expiry, that resends are spaced and supersede earlier tokens, and that an email change keeps the old address in
+# use until the new one is confirmed, refuses an address another customer has, and tells both addresses. Tokens
+# are read from the queued notifications in the server's database, so DB_PATH must be the database the server uses.
+# Resends wait out the resend interval; running the server with EMAIL_VERIFICATION_RESEND_SECONDS=2 keeps it short.
+# Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-verification.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... ./test-verification.sh
+
+source "$(dirname "$0")/test-lib.sh"
+
+echo " Email Verification Tests"
+echo "========================="
+
+# emailed_token EMAIL - prints the token of the latest verification email sent to an address
+emailed_token() {
+    sql "SELECT body FROM notifications WHERE recipient = ? AND subject = 'Confirm your email address' ORDER BY id DESC LIMIT 1" "$1" |
+        sed 's/.*: //'
+}
+
+# emails EMAIL SUBJECT - prints how many emails with a subject were queued for an address
+emails() {
+    sql "SELECT count(*) FROM notifications WHERE recipient = ? AND subject = ?" "$1" "$2"
+}
+
+# verify TOKEN - submits a verification token
+verify() {
+    request POST "$V1/customers/verify-email" "{\"token\": \"$1\"}"
+}
+
+# wait_resend - sleeps out the resend interval the last 429 asked for
+wait_resend() {
+    sleep "$(awk -F': ' 'tolower($1) == "retry-after" {print $2}' <<< "$HEADERS")"
+}
+
+# customer EMAIL - creates a customer and prints its id
+customer() {
+    request POST "$V1/customers" "{\"first_name\": \"Verify\", \"last_name\": \"Test\", \"email\": \"$1\", \"date_of_birth\": \"1980-01-01\"}"
+    field "['customer']['id']"
+}
+
+EMAIL="verify-$RUN_ID@example.com"
+NEW_EMAIL="verify-new-$RUN_ID@example.com"
+OTHER_EMAIL="verify-other-$RUN_ID@example.com"
+
+echo "Sign-up"
+request POST "$V1/customers" "{\"first_name\": \"Verify\", \"last_name\": \"Test\", \"email\": \"$EMAIL\", \"date_of_birth\": \"1980-01-01\", \"email_verified\": true}"
+check "a new customer is created" "s == 201"
+check "its email starts unverified even when the request says otherwise" "b['customer']['email_verified'] is False"
+CUSTOMER=$(field "['customer']['id']")
+SIGNUP_TOKEN=$(emailed_token "$EMAIL")
+check "a verification token was emailed to the address" "len('$SIGNUP_TOKEN') > 20"
+request GET "$V1/customers?limit=1"
+request GET "$V1/customers?limit=1&page=$(field "['total']")"
+check "the customer list shows the email unverified" "[c['email_verified'] for c in b['customers'] if c['id'] == $CUSTOMER] == [False]"
+
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}"
+ACCOUNT=$(field "['account']['id']")
+
+echo
+echo "Unverified email"
+request PUT "$V1/accounts/$ACCOUNT/statement-preference" '{"channel": "email"}'
+check "e-statements are refused" "s == 409 and b['code'] == 'EMAIL_NOT_VERIFIED'"
+request PUT "$V1/accounts/$ACCOUNT/statement-preference" '{"channel": "none"}'
+check "statements can still be archive-only" "s == 200"
+request POST "$V1/accounts/$ACCOUNT/alerts" '{"rule_type": "transaction_over", "threshold": 100}'
+check "email alerts to the customer's address are refused" "s == 409 and b['code'] == 'EMAIL_NOT_VERIFIED'"
+request POST "$V1/customers/$CUSTOMER/verify-email/resend"
+check "a resend straight after sign-up must wait" "s == 429 and b['code'] == 'RESEND_TOO_SOON'"
+check "the wait is given in Retry-After" "'retry-after' in h"
+
+echo
+echo "Verification"
+verify "not-a-real-token-$RUN_ID"
+check "an unknown token is refused" "s == 404 and b['code'] == 'VERIFICATION_TOKEN_INVALID'"
+verify "$SIGNUP_TOKEN"
+check "the emailed token verifies the address" "s == 200 and b['email_verified'] is True and b['customer_id'] == $CUSTOMER"
+verify "$SIGNUP_TOKEN"
+check "the token cannot be used twice" "s == 409 and b['code'] == 'VERIFICATION_TOKEN_USED'"
+request GET "$V1/customers/$CUSTOMER"
+check "the customer is verified" "b['email_verified'] is True and b.get('email_verified_at')"
+request PUT "$V1/accounts/$ACCOUNT/statement-preference" '{"channel": "email"}'
+check "e-statements can now be chosen" "s == 200"
+request POST "$V1/customers/$CUSTOMER/verify-email/resend"
+check "there is nothing left to resend" "s == 409 and b['code'] == 'NOTHING_TO_VERIFY'"
+
+echo
+echo "Email change"
+OTHER=$(customer "$OTHER_EMAIL")
+request PUT "$V1/customers/$CUSTOMER" "{\"email\": \"$OTHER_EMAIL\"}"
+check "another customer's address is refused" "s == 409 and b['code'] == 'EMAIL_TAKEN'"
+request PUT "$V1/customers/$CUSTOMER" "{\"email\": \"$NEW_EMAIL\", \"phone\": \"555-0100\"}"
+check "a change is accepted" "s == 200 and b['customer']['phone'] == '555-0100'"
+check "the old address stays in use" "b['customer']['email'] == '$EMAIL' and b['customer']['email_verified'] is True"
+check "the new address waits for confirmation" "b['customer']['pending_email'] == '$NEW_EMAIL'"
+FIRST_CHANGE_TOKEN=$(emailed_token "$NEW_EMAIL")
+check "the new address was sent a token" "len('$FIRST_CHANGE_TOKEN') > 20"
+check "the old address was told about the request" "$(emails "$EMAIL" "Email address change requested") == 1"
+
+request POST "$V1/customers/$CUSTOMER/verify-email/resend"
+check "a resend must wait out the interval" "s == 429"
+wait_resend
+request POST "$V1/customers/$CUSTOMER/verify-email/resend"
+check "the resend goes to the new address" "s == 200 and b['email'] == '$NEW_EMAIL' and b['purpose'] == 'change'"
+CHANGE_TOKEN=$(emailed_token "$NEW_EMAIL")
+verify "$FIRST_CHANGE_TOKEN"
+check "the earlier token was superseded" "s == 409 and b['code'] == 'VERIFICATION_TOKEN_SUPERSEDED'"
+verify "$CHANGE_TOKEN"
+check "the resent token confirms the change" "s == 200 and b['email'] == '$NEW_EMAIL'"
+request GET "$V1/customers/$CUSTOMER"
+check "the customer now uses the new address" "b['email'] == '$NEW_EMAIL' and not b.get('pending_email') and b['email_verified'] is True"
+check "the old address was told about the change" "$(emails "$EMAIL" "Your email address was changed") == 1"
+check "the new address was told about the change" "$(emails "$NEW_EMAIL" "Your email address was changed") == 1"
+
+echo
+echo "Expiry"
+EXPIRING_EMAIL="verify-expiring-$RUN_ID@example.com"
+EXPIRING=$(customer "$EXPIRING_EMAIL")
+EXPIRING_TOKEN=$(emailed_token "$EXPIRING_EMAIL")
+sql "UPDATE email_verifications SET expires_at = '2000-01-01 00:00:00+00:00' WHERE customer_id = ?" "$EXPIRING" > /dev/null
+verify "$EXPIRING_TOKEN"
+check "an expired token is refused" "s == 410 and b['code'] == 'VERIFICATION_TOKEN_EXPIRED'"
+request GET "$V1/customers/$EXPIRING"
+check "the customer stays unverified" "b['email_verified'] is False"
+
+finish "email verification"
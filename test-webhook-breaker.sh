@@ -0,0 +1,167 @@
+#!/bin/bash
+
+# Webhook Circuit Breaker Tests
+# Runs a local webhook receiver that can be switched between accepting and failing, and checks that a subscription
+# is suspended once its deliveries keep failing, that its owner is alerted, that events queue while it is suspended
+# with the oldest dropped beyond its backlog limit and the gap flagged on the next delivery, that resuming it replays
+# the backlog in order, and that a successful probe closes the breaker on its own. The admin user is created with
+# bankctl, and failure counts and probe times are set in the server's database, so DB_PATH must be the database the
+# server uses. The server must run with the default WEBHOOK_BREAKER_FAILURES. Exits non-zero on failure.
+#
+# Usage: DB_PATH=banking.db ./test-webhook-breaker.sh            (server on localhost:8080 using that database)
+#        BASE_URL=http://host:port DB_PATH=... BANKCTL=./bankctl RECEIVER_PORT=18098 ./test-webhook-breaker.sh
+
+source "$(dirname "$0")/test-lib.sh"
+RECEIVER_PORT="${RECEIVER_PORT:-18098}"
+PASSWORD="breaker-test-$RUN_ID"
+
+echo " Webhook Circuit Breaker Tests"
+echo "==============================="
+
+# subscription PYTHON_EXPRESSION - waits up to 10 seconds for the expression to hold over the subscription as `w`,
+# leaving the subscription listing in BODY
+subscription() {
+    for _ in $(seq 1 20); do
+        request GET "$V1/admin/webhooks" "" "${ADMIN[@]}"
+        python3 -c "
+import json, sys
+w = [w for w in json.loads(sys.argv[1])['subscriptions'] if w['id'] == $SUBSCRIPTION][0]
+sys.exit(0 if ($1) else 1)
+" "$BODY" 2>/dev/null && return
+        sleep 0.5
+    done
+}
+
+# received COUNT - waits up to 10 seconds for the receiver to have accepted COUNT deliveries
+received() {
+    for _ in $(seq 1 20); do
+        [ "$(wc -l < "$WORK/received")" -ge "$1" ] && return
+        sleep 0.5
+    done
+}
+
+# deposit AMOUNT - posts a deposit, queueing two transaction.posted events for the subscription: the deposit and
+# its offset on the bank's cash account
+deposit() {
+    request POST "$V1/transactions" "{\"account_id\": $ACCOUNT, \"transaction_type\": \"deposit\", \"amount\": $1}" "${ADMIN[@]}"
+}
+
+echo "Setup"
+ADMIN=(-H "Authorization: Bearer $(staff "breaker-admin-$RUN_ID")")
+
+# Webhook receiver - fails with 503 while the mode file says fail, otherwise records the event id and dropped count
+echo ok > "$WORK/mode"
+touch "$WORK/received"
+python3 -c "
+import http.server, sys
+class Receiver(http.server.BaseHTTPRequestHandler):
+    def do_POST(self):
+        self.rfile.read(int(self.headers.get('Content-Length', 0)))
+        if open(sys.argv[2]).read().strip() == 'fail':
+            self.send_response(503)
+        else:
+            with open(sys.argv[3], 'a') as f:
+                f.write('%s %s\n' % (self.headers.get('X-Event-ID'), self.headers.get('X-Webhook-Dropped') or '0'))
+            self.send_response(204)
+        self.end_headers()
+    def log_message(self, *args):
+        pass
+http.server.HTTPServer(('127.0.0.1', int(sys.argv[1])), Receiver).serve_forever()
+" "$RECEIVER_PORT" "$WORK/mode" "$WORK/received" &
+background $!
+
+request POST "$V1/admin/webhooks" "{\"url\": \"http://127.0.0.1:$RECEIVER_PORT/hook\", \"event_types\": \"transaction.posted\", \"alert_email\": \"hooks-$RUN_ID@example.com\", \"backlog_limit\": -1}" "${ADMIN[@]}"
+check "a negative backlog limit is rejected" "s == 400"
+request POST "$V1/admin/webhooks" "{\"url\": \"http://127.0.0.1:$RECEIVER_PORT/hook\", \"event_types\": \"transaction.posted\", \"alert_email\": \"hooks-$RUN_ID@example.com\", \"backlog_limit\": 3}" "${ADMIN[@]}"
+check "a subscription is registered with an alert address and backlog limit" \
+    "s == 201 and b['subscription']['alert_email'] == 'hooks-$RUN_ID@example.com' and b['subscription']['backlog_limit'] == 3 and b['subscription']['created_by'] == 'breaker-admin-$RUN_ID'"
+SUBSCRIPTION=$(field "['subscription']['id']")
+
+request POST "$V1/customers" "{\"first_name\": \"Breaker\", \"last_name\": \"Customer\", \"email\": \"breaker-$RUN_ID@example.com\", \"date_of_birth\": \"1980-01-01\"}"
+CUSTOMER=$(field "['customer']['id']")
+request POST "$V1/accounts" "{\"customer_id\": $CUSTOMER, \"account_type\": \"checking\"}"
+ACCOUNT=$(field "['account']['id']")
+
+echo
+echo "Delivery"
+deposit 10
+received 2
+request GET "$V1/admin/webhooks/$SUBSCRIPTION/deliveries" "" "${ADMIN[@]}"
+check "a healthy endpoint gets the events" \
+    "s == 200 and b['total'] == 2 and all(d['status'] == 'delivered' and d['attempts'] == 1 for d in b['deliveries'])"
+request GET "$V1/admin/webhooks/999999/deliveries" "" "${ADMIN[@]}"
+check "deliveries of an unknown subscription are not found" "s == 404"
+
+echo
+echo "Suspension"
+echo fail > "$WORK/mode"
+# One failure short of the default breaker threshold of 5
+sql "UPDATE webhook_subscriptions SET consecutive_failures = 4 WHERE id = $SUBSCRIPTION" > /dev/null
+deposit 11
+subscription "w['suspended']"
+check "the subscription is suspended after consecutive failures" \
+    "[w for w in b['subscriptions'] if w['id'] == $SUBSCRIPTION][0]['suspended'] == True"
+check "the suspension records the failure and schedules a probe" \
+    "[w for w in b['subscriptions'] if w['id'] == $SUBSCRIPTION][0]['last_error'] == 'endpoint returned status 503' and [w for w in b['subscriptions'] if w['id'] == $SUBSCRIPTION][0]['next_probe_at']"
+BODY=""
+STATUS=200
+check "the owner is emailed about the suspension" \
+    "'$(sql "SELECT COUNT(*) FROM notifications WHERE recipient = 'hooks-$RUN_ID@example.com' AND resource_type = 'webhook_subscription' AND resource_id = $SUBSCRIPTION")' == '1'"
+FAILED_EVENT=$(sql "SELECT event_id FROM webhook_deliveries WHERE subscription_id = $SUBSCRIPTION AND status = 'pending' ORDER BY id LIMIT 1")
+
+echo
+echo "Backlog"
+# Six queued against a limit of three
+deposit 12
+deposit 13
+subscription "w['dropped_deliveries'] == 3"
+check "a full backlog keeps the newest deliveries" \
+    "[w for w in b['subscriptions'] if w['id'] == $SUBSCRIPTION][0]['backlog'] == 3 and [w for w in b['subscriptions'] if w['id'] == $SUBSCRIPTION][0]['dropped_deliveries'] == 3"
+request GET "$V1/admin/webhooks/$SUBSCRIPTION/deliveries?status=dropped" "" "${ADMIN[@]}"
+check "the oldest deliveries are dropped, the failed one first" \
+    "s == 200 and b['total'] == 3 and b['deliveries'][-1]['event_id'] == $FAILED_EVENT"
+request GET "$V1/admin/webhooks/$SUBSCRIPTION/deliveries?status=pending" "" "${ADMIN[@]}"
+check "the oldest delivery left carries the gap" \
+    "s == 200 and b['total'] == 3 and b['deliveries'][-1]['dropped_before'] == 3 and b['deliveries'][0].get('dropped_before', 0) == 0"
+sleep 2
+check "nothing reaches the endpoint while suspended" "$(wc -l < "$WORK/received") == 2"
+
+echo
+echo "Resume"
+echo ok > "$WORK/mode"
+request POST "$V1/admin/webhooks/$SUBSCRIPTION/resume" "" "${ADMIN[@]}"
+check "resuming reports the backlog to replay" "s == 200 and b['backlog'] == 3"
+received 5
+check "the backlog replays in order with the gap flagged on its first delivery" "$(python3 -c "
+import sys
+rows = [l.split() for l in open(sys.argv[1]).read().split('\n')[2:] if l]
+ids = [int(r[0]) for r in rows]
+print(len(rows) == 3 and ids == sorted(ids) and [r[1] for r in rows] == ['3', '0', '0'])
+" "$WORK/received")"
+request POST "$V1/admin/webhooks/$SUBSCRIPTION/resume" "" "${ADMIN[@]}"
+check "resuming an active subscription is rejected" "s == 409 and b['code'] == 'WEBHOOK_NOT_SUSPENDED'"
+request POST "$V1/admin/webhooks/999999/resume" "" "${ADMIN[@]}"
+check "resuming an unknown subscription is not found" "s == 404"
+subscription "w['consecutive_failures'] == 0"
+check "the replay clears the failure count" \
+    "[w for w in b['subscriptions'] if w['id'] == $SUBSCRIPTION][0]['consecutive_failures'] == 0 and [w for w in b['subscriptions'] if w['id'] == $SUBSCRIPTION][0]['backlog'] == 0"
+
+echo
+echo "Probe"
+echo fail > "$WORK/mode"
+sql "UPDATE webhook_subscriptions SET consecutive_failures = 4 WHERE id = $SUBSCRIPTION" > /dev/null
+deposit 16
+subscription "w['suspended']"
+echo ok > "$WORK/mode"
+sql "UPDATE webhook_subscriptions SET next_probe_at = '2000-01-01 00:00:00' WHERE id = $SUBSCRIPTION" > /dev/null
+received 7
+subscription "not w['suspended'] and w['backlog'] == 0"
+check "a successful probe closes the breaker" \
+    "[w for w in b['subscriptions'] if w['id'] == $SUBSCRIPTION][0]['suspended'] == False and [w for w in b['subscriptions'] if w['id'] == $SUBSCRIPTION][0]['backlog'] == 0"
+request GET "$V1/admin/webhooks/$SUBSCRIPTION/deliveries?status=delivered" "" "${ADMIN[@]}"
+check "the probed delivery is delivered after its failed attempt" \
+    "s == 200 and b['total'] == 7 and b['deliveries'][1]['attempts'] == 2 and b['deliveries'][0]['attempts'] == 1"
+
+request DELETE "$V1/admin/webhooks/$SUBSCRIPTION" "" "${ADMIN[@]}"
+
+finish "webhook breaker"
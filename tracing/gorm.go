@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"banking-app/slowquery"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// spanKey holds a statement's span in its gorm instance settings
+const spanKey = "tracing:span"
+
+// RegisterCallbacks gives every statement run through db a child span of the span in its context
+// Statements run outside a traced request or job, such as the outbox poll, are not traced
+func RegisterCallbacks(db *gorm.DB) error {
+	callbacks := db.Callback()
+	for _, err := range []error{
+		callbacks.Create().Before("*").Register("tracing:start_create", startSpan("db.create")),
+		callbacks.Create().After("*").Register("tracing:end_create", endSpan),
+		callbacks.Query().Before("*").Register("tracing:start_query", startSpan("db.query")),
+		callbacks.Query().After("*").Register("tracing:end_query", endSpan),
+		callbacks.Update().Before("*").Register("tracing:start_update", startSpan("db.update")),
+		callbacks.Update().After("*").Register("tracing:end_update", endSpan),
+		callbacks.Delete().Before("*").Register("tracing:start_delete", startSpan("db.delete")),
+		callbacks.Delete().After("*").Register("tracing:end_delete", endSpan),
+		callbacks.Row().Before("*").Register("tracing:start_row", startSpan("db.row")),
+		callbacks.Row().After("*").Register("tracing:end_row", endSpan),
+		callbacks.Raw().Before("*").Register("tracing:start_raw", startSpan("db.raw")),
+		callbacks.Raw().After("*").Register("tracing:end_raw", endSpan),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startSpan opens a client span for a statement when its context is being traced
+func startSpan(name string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		ctx := tx.Statement.Context
+		if ctx == nil || !trace.SpanFromContext(ctx).IsRecording() {
+			return
+		}
+		_, span := Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attribute.String("db.system", tx.Dialector.Name())))
+		tx.InstanceSet(spanKey, span)
+	}
+}
+
+// endSpan records the statement, with literal values stripped as in the slow query log, and ends its span
+func endSpan(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	if table := tx.Statement.Table; table != "" {
+		span.SetAttributes(attribute.String("db.sql.table", table))
+	}
+	if tx.Statement.SQL.Len() > 0 {
+		span.SetAttributes(attribute.String("db.statement", slowquery.Normalize(tx.Statement.SQL.String())))
+	}
+	span.SetAttributes(attribute.Int64("db.rows_affected", tx.RowsAffected))
+	err := tx.Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil // A lookup that found nothing is an answer, not a failure
+	}
+	End(span, err)
+}
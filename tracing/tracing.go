@@ -0,0 +1,134 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name is the instrumentation scope of every span the application creates
+const Name = "banking-app"
+
+// Exporters selected with OTEL_TRACES_EXPORTER
+const (
+	ExporterNone    = "none"
+	ExporterOTLP    = "otlp"    // OTLP over HTTP, configured with the standard OTEL_EXPORTER_OTLP_* variables
+	ExporterConsole = "console" // One JSON span per line on stdout, for development and test-tracing.sh
+)
+
+// Config holds tracing settings
+type Config struct {
+	Exporter    string
+	ServiceName string
+}
+
+// ConfigFromEnv reads OTEL_TRACES_EXPORTER and OTEL_SERVICE_NAME (default banking-app)
+// Without an exporter, OTLP is used when OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set,
+// and tracing is otherwise off
+func ConfigFromEnv() Config {
+	cfg := Config{Exporter: ExporterNone, ServiceName: Name}
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		cfg.Exporter = ExporterOTLP
+	}
+	if raw := strings.ToLower(strings.TrimSpace(os.Getenv("OTEL_TRACES_EXPORTER"))); raw != "" {
+		switch raw {
+		case ExporterNone, ExporterOTLP, ExporterConsole:
+			cfg.Exporter = raw
+		default:
+			log.Printf("tracing: ignoring unsupported OTEL_TRACES_EXPORTER %q", raw)
+		}
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		cfg.ServiceName = name
+	}
+	return cfg
+}
+
+// Setup installs the global tracer provider and the W3C trace context propagator
+// With no exporter the global no-op provider stays in place, so spans cost next to nothing.
+// The returned function flushes spans still buffered and must be called on shutdown
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var processor sdktrace.SpanProcessor
+	switch cfg.Exporter {
+	case ExporterOTLP:
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+		}
+		processor = sdktrace.NewBatchSpanProcessor(exporter)
+	case ExporterConsole:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, fmt.Errorf("creating console exporter: %w", err)
+		}
+		processor = sdktrace.NewSimpleSpanProcessor(exporter) // Written as each span ends
+	default:
+		return func(context.Context) error { return nil }, nil
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the application tracer from the current global provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(Name)
+}
+
+// Start begins a span as a child of any span in ctx
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, opts...)
+}
+
+// End records err on the span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject writes the trace context of ctx into outgoing request headers as traceparent
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// TraceParent returns the W3C traceparent of the span in ctx, or "" when it has none
+// It is stored with work that continues after the request, such as outbox events
+func TraceParent(ctx context.Context) string {
+	if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+		return ""
+	}
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// FromTraceParent returns a context whose remote parent is the stored traceparent
+// An empty or malformed value gives ctx unchanged, so spans started from it begin a new trace
+func FromTraceParent(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": traceParent})
+}
@@ -0,0 +1,309 @@
+package transfers
+
+import (
+	"banking-app/clock"
+	"banking-app/fees"
+	"banking-app/flags"
+	"banking-app/ledger"
+	"banking-app/liens"
+	"banking-app/models"
+	"banking-app/money"
+	"banking-app/restrictions"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Fields a duplicate-suspect check can compare
+const (
+	FieldSource      = "source"
+	FieldDestination = "destination"
+	FieldAmount      = "amount"
+	FieldDescription = "description"
+	FieldReference   = "reference"
+)
+
+// fieldColumns maps each matching field to its transfers column
+var fieldColumns = map[string]string{
+	FieldSource:      "from_account_id",
+	FieldDestination: "to_account_id",
+	FieldAmount:      "amount",
+	FieldDescription: "description",
+	FieldReference:   "reference",
+}
+
+// Transfer errors - handlers map these to client responses
+// Self-transfers and zero amounts are refused with the ledger's shared validation errors
+var (
+	ErrCurrencyMismatch    = errors.New("accounts have different currencies")
+	ErrDestinationInactive = errors.New("destination account is not active")
+)
+
+// DuplicateError is returned when a transfer matches a recent one and was not confirmed
+type DuplicateError struct {
+	Original models.Transfer
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("transfer matches transfer %d", e.Original.ID)
+}
+
+// IdempotencyError is returned when an idempotency key was already used for a different transfer
+type IdempotencyError struct {
+	Original models.Transfer
+}
+
+func (e *IdempotencyError) Error() string {
+	return fmt.Sprintf("idempotency key was used for transfer %d", e.Original.ID)
+}
+
+// Config controls duplicate-suspect detection
+type Config struct {
+	Window time.Duration // How far back to look; 0 disables the check
+	Fields []string      // Fields that must all match
+}
+
+// ConfigFromEnv reads TRANSFER_DUPLICATE_WINDOW_SECONDS (default 60, 0 disables) and
+// TRANSFER_DUPLICATE_FIELDS (default "source,destination,amount")
+// The source always matches: the concurrency guarantee comes from serializing per source account
+func ConfigFromEnv() Config {
+	cfg := Config{Window: 60 * time.Second, Fields: []string{FieldSource, FieldDestination, FieldAmount}}
+	if raw := os.Getenv("TRANSFER_DUPLICATE_WINDOW_SECONDS"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+			cfg.Window = time.Duration(n) * time.Second
+		}
+	}
+	if raw := os.Getenv("TRANSFER_DUPLICATE_FIELDS"); raw != "" {
+		fields := []string{FieldSource}
+		for _, f := range strings.Split(raw, ",") {
+			f = strings.TrimSpace(f)
+			if _, ok := fieldColumns[f]; ok && f != FieldSource {
+				fields = append(fields, f)
+			}
+		}
+		cfg.Fields = fields
+	}
+	return cfg
+}
+
+// Request is a transfer to post
+type Request struct {
+	FromAccountID    uint
+	ToAccountID      uint
+	Amount           float64
+	Description      string
+	Reference        string
+	Channel          string
+	ConfirmDuplicate bool              // The client knows it repeats a recent transfer
+	IdempotencyKey   string            // Client's key for the transfer; empty when not sent
+	CreatedBy        string            // Username recorded on the transfer
+	Approved         bool              // Staff approved the transfer from the approval queue
+	Cutoffs          map[string]string // The bank's daily cutoffs by transaction type; the transfer cutoff values both legs
+}
+
+// Result is a posted transfer with both legs, any fee and the accounts after posting
+type Result struct {
+	Transfer models.Transfer
+	Debit    models.Transaction
+	Credit   models.Transaction
+	Fee      *models.Transaction // Charged on the source account; nil when no fee schedule applies
+	From     models.Account
+	To       models.Account
+	Replayed bool // The idempotency key matched an earlier transfer, returned without posting; only Transfer and Fee are set
+}
+
+// sourceLocks serializes transfers from the same account within this process, so two concurrent
+// identical requests cannot both pass the duplicate check before either commits
+var sourceLocks sync.Map
+
+// lockSource holds the source account's lock until the returned function is called
+func lockSource(accountID uint) func() {
+	value, _ := sourceLocks.LoadOrStore(accountID, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// Post checks for a suspected duplicate and posts both legs of a transfer together, then any fee on the source
+// account, in one database transaction; all of them are valued on the day the transfer cutoff gives
+// A source account's restrictions are checked first; one needing staff approval fails with
+// restrictions.ErrApprovalRequired unless req.Approved is set
+// The source's balance after the debit and any fee must still cover its active liens, or it fails with liens.ErrHeld
+// A request repeating an idempotency key returns the transfer posted under it when the source, destination
+// and amount match, and fails with an IdempotencyError when they differ
+func Post(db *gorm.DB, req Request, cfg Config, featureFlags *flags.Store) (Result, error) {
+	var result Result
+	transfer := &result.Transfer
+	*transfer = models.Transfer{
+		FromAccountID: req.FromAccountID,
+		ToAccountID:   req.ToAccountID,
+		Amount:        req.Amount,
+		Description:   req.Description,
+		Reference:     req.Reference,
+		CreatedBy:     req.CreatedBy,
+	}
+	if err := ledger.ValidateMovement(req.FromAccountID, req.ToAccountID, req.Amount); err != nil {
+		return result, err
+	}
+	if req.IdempotencyKey != "" {
+		transfer.IdempotencyKey = &req.IdempotencyKey
+	}
+
+	unlock := lockSource(req.FromAccountID)
+	defer unlock()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if req.IdempotencyKey != "" {
+			original, found, err := byIdempotencyKey(tx, req.IdempotencyKey)
+			if err != nil {
+				return err
+			}
+			if found {
+				return replay(tx, &result, original, req)
+			}
+		}
+
+		var from, to models.Account
+		if err := tx.First(&from, req.FromAccountID).Error; err != nil {
+			return err
+		}
+		if err := restrictions.Allow(from.Restrictions, "transfer", req.Approved); err != nil {
+			return err
+		}
+		if err := tx.First(&to, req.ToAccountID).Error; err != nil {
+			return err
+		}
+		if to.Status != "active" {
+			return ErrDestinationInactive
+		}
+		// Both legs move the same amount, counted in the source currency's minor units
+		if money.Same(from.Currency, to.Currency) != nil {
+			return ErrCurrencyMismatch
+		}
+		amount := money.FromFloat(req.Amount, from.Currency)
+		transfer.Amount = amount.Float()
+
+		original, found, err := recentMatch(tx, *transfer, cfg)
+		if err != nil {
+			return err
+		}
+		if found {
+			if !req.ConfirmDuplicate {
+				return &DuplicateError{Original: original}
+			}
+			transfer.ConfirmedDuplicateOfID = &original.ID
+		}
+
+		description := req.Description
+		if description == "" {
+			description = "Transfer to " + to.AccountNumber
+		}
+		debit := &result.Debit
+		*debit = models.Transaction{
+			TransactionID:         ledger.NewTransactionID(),
+			AccountID:             from.ID,
+			TransactionType:       "transfer",
+			Amount:                amount.Float(),
+			Description:           description,
+			Memo:                  req.Description,
+			Reference:             req.Reference,
+			Channel:               req.Channel,
+			CounterpartyAccountID: &to.ID,
+		}
+		credit := &result.Credit
+		*credit = models.Transaction{
+			AccountID:             to.ID,
+			TransactionType:       "deposit",
+			Amount:                amount.Float(),
+			Description:           "Transfer from " + from.AccountNumber,
+			Memo:                  req.Description,
+			Reference:             debit.TransactionID,
+			Channel:               req.Channel,
+			CounterpartyAccountID: &from.ID,
+		}
+		if req.Description != "" {
+			credit.Description += ": " + req.Description
+		}
+		// Both legs take the transfer's value date: the next business day when it is submitted after the cutoff
+		valueDate, err := ledger.ValueDate(tx, "transfer", clock.Now(), req.Cutoffs)
+		if err != nil {
+			return err
+		}
+		debit.ValueDate, credit.ValueDate = &valueDate, &valueDate
+		accounts, err := ledger.PostLegs(tx, []ledger.Leg{{Transaction: debit}, {Transaction: credit}}, featureFlags)
+		if err != nil {
+			return err
+		}
+		result.From, result.To = accounts[0], accounts[1]
+
+		if result.Fee, result.From, err = fees.Charge(tx, *debit, result.From, featureFlags); err != nil {
+			return err
+		}
+		if err := liens.Enforce(tx, result.From); err != nil {
+			return err
+		}
+		if result.Fee != nil {
+			transfer.FeeTransactionID = &result.Fee.ID
+		}
+
+		transfer.DebitTransactionID = debit.ID
+		transfer.CreditTransactionID = credit.ID
+		return tx.Create(transfer).Error
+	})
+	// The unique key makes a concurrent request with the same key, from another source account or
+	// another instance, fail here; it is answered like a retry of the one that committed
+	if err != nil && req.IdempotencyKey != "" {
+		if original, found, lookupErr := byIdempotencyKey(db, req.IdempotencyKey); lookupErr == nil && found {
+			result = Result{}
+			err = replay(db, &result, original, req)
+		}
+	}
+	return result, err
+}
+
+// byIdempotencyKey finds the transfer posted under a client's idempotency key
+func byIdempotencyKey(db *gorm.DB, key string) (models.Transfer, bool, error) {
+	var original models.Transfer
+	err := db.Where("idempotency_key = ?", key).Limit(1).Find(&original).Error
+	return original, original.ID != 0, err
+}
+
+// replay fills result with the transfer a repeated idempotency key posted, or fails when the request differs
+func replay(db *gorm.DB, result *Result, original models.Transfer, req Request) error {
+	if original.FromAccountID != req.FromAccountID || original.ToAccountID != req.ToAccountID || original.Amount != req.Amount {
+		return &IdempotencyError{Original: original}
+	}
+	result.Transfer, result.Replayed = original, true
+	if original.FeeTransactionID != nil {
+		result.Fee = &models.Transaction{}
+		return db.First(result.Fee, *original.FeeTransactionID).Error
+	}
+	return nil
+}
+
+// recentMatch finds the latest transfer within the window matching on every configured field
+func recentMatch(tx *gorm.DB, transfer models.Transfer, cfg Config) (models.Transfer, bool, error) {
+	var original models.Transfer
+	if cfg.Window <= 0 {
+		return original, false, nil
+	}
+	values := map[string]interface{}{
+		FieldSource:      transfer.FromAccountID,
+		FieldDestination: transfer.ToAccountID,
+		FieldAmount:      transfer.Amount,
+		FieldDescription: transfer.Description,
+		FieldReference:   transfer.Reference,
+	}
+	query := tx.Where("created_at >= ?", clock.Now().Add(-cfg.Window))
+	for _, field := range cfg.Fields {
+		query = query.Where(fieldColumns[field]+" = ?", values[field])
+	}
+	err := query.Order("id DESC").Limit(1).Find(&original).Error
+	return original, original.ID != 0, err
+}
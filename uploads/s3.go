@@ -0,0 +1,222 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Server-side encryption modes for S3_SSE
+const (
+	SSEOff    = "off"
+	SSEAES256 = "AES256"  // Keys managed by S3
+	SSEKMS    = "aws:kms" // Keys managed by KMS, S3_SSE_KMS_KEY_ID or the account's default key
+)
+
+// checksumMetadata is the object metadata holding the hex SHA-256 of the stored bytes
+const checksumMetadata = "sha256"
+
+// S3Config holds the settings of an S3-compatible store
+type S3Config struct {
+	Bucket     string
+	Region     string
+	Endpoint   string // Custom endpoint such as MinIO; empty for AWS
+	SSE        string
+	KMSKeyID   string
+	PresignTTL time.Duration // How long a pre-signed download URL works
+}
+
+// S3ConfigFromEnv reads S3_BUCKET (required), S3_REGION (default the SDK's region, else us-east-1),
+// S3_ENDPOINT, S3_SSE (AES256, aws:kms or off; default AES256), S3_SSE_KMS_KEY_ID and
+// S3_PRESIGN_SECONDS (default 300)
+// Credentials come from the SDK's default chain, such as AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+func S3ConfigFromEnv() (S3Config, error) {
+	cfg := S3Config{
+		Bucket:     strings.TrimSpace(os.Getenv("S3_BUCKET")),
+		Region:     strings.TrimSpace(os.Getenv("S3_REGION")),
+		Endpoint:   strings.TrimSpace(os.Getenv("S3_ENDPOINT")),
+		SSE:        strings.TrimSpace(os.Getenv("S3_SSE")),
+		KMSKeyID:   strings.TrimSpace(os.Getenv("S3_SSE_KMS_KEY_ID")),
+		PresignTTL: 5 * time.Minute,
+	}
+	if cfg.Bucket == "" {
+		return cfg, errors.New("S3_BUCKET is required when STORAGE_BACKEND is s3")
+	}
+	if cfg.Endpoint != "" {
+		endpoint, err := url.Parse(cfg.Endpoint)
+		if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+			return cfg, fmt.Errorf("invalid S3_ENDPOINT %q: must be an http or https URL", cfg.Endpoint)
+		}
+	}
+	switch strings.ToLower(cfg.SSE) {
+	case "":
+		cfg.SSE = SSEAES256
+	case strings.ToLower(SSEAES256):
+		cfg.SSE = SSEAES256
+	case SSEKMS, SSEOff:
+		cfg.SSE = strings.ToLower(cfg.SSE)
+	default:
+		return cfg, fmt.Errorf("invalid S3_SSE %q: must be AES256, aws:kms or off", cfg.SSE)
+	}
+	if cfg.KMSKeyID != "" && cfg.SSE != SSEKMS {
+		return cfg, errors.New("S3_SSE_KMS_KEY_ID needs S3_SSE=aws:kms")
+	}
+	if raw := strings.TrimSpace(os.Getenv("S3_PRESIGN_SECONDS")); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		// Pre-signed URLs cannot outlive seven days
+		if err != nil || seconds < 1 || seconds > 7*24*60*60 {
+			return cfg, fmt.Errorf("invalid S3_PRESIGN_SECONDS %q: must be from 1 to 604800", raw)
+		}
+		cfg.PresignTTL = time.Duration(seconds) * time.Second
+	}
+	return cfg, nil
+}
+
+// S3Storage keeps files in an S3 bucket, or a bucket of an S3-compatible service such as MinIO
+// Each object carries the SHA-256 of its bytes, which S3 checks on upload and Get checks again as it is read
+type S3Storage struct {
+	client  *s3.Client
+	presign *s3.PresignClient
+	cfg     S3Config
+}
+
+// NewS3Storage creates the store, loading credentials from the SDK's default chain
+// A custom endpoint is addressed path-style, as MinIO expects
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	var options []func(*awsconfig.LoadOptions) error
+	if cfg.Region != "" {
+		options = append(options, awsconfig.WithRegion(cfg.Region))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("s3 storage: %w", err)
+	}
+	if awsCfg.Region == "" {
+		awsCfg.Region = "us-east-1"
+	}
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	presign := s3.NewPresignClient(client, func(o *s3.PresignOptions) {
+		o.Expires = cfg.PresignTTL
+	})
+	return &S3Storage{client: client, presign: presign, cfg: cfg}, nil
+}
+
+// Put uploads the file with its SHA-256, encrypted at rest as configured
+func (s *S3Storage) Put(key string, data []byte) error {
+	sum := sha256.Sum256(data)
+	input := &s3.PutObjectInput{
+		Bucket:         aws.String(s.cfg.Bucket),
+		Key:            aws.String(key),
+		Body:           bytes.NewReader(data),
+		ContentLength:  aws.Int64(int64(len(data))),
+		ChecksumSHA256: aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+		Metadata:       map[string]string{checksumMetadata: hex.EncodeToString(sum[:])},
+	}
+	switch s.cfg.SSE {
+	case SSEAES256:
+		input.ServerSideEncryption = types.ServerSideEncryptionAes256
+	case SSEKMS:
+		input.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		if s.cfg.KMSKeyID != "" {
+			input.SSEKMSKeyId = aws.String(s.cfg.KMSKeyID)
+		}
+	}
+	if _, err := s.client.PutObject(context.Background(), input); err != nil {
+		return fmt.Errorf("s3 storage: put %s: %w", key, err)
+	}
+	return nil
+}
+
+// Get streams a stored file; the last read fails with ErrChecksumMismatch when the bytes do not match their
+// stored SHA-256. A missing file is an fs.ErrNotExist, as it is on disk
+func (s *S3Storage) Get(key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var missing *types.NoSuchKey
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("s3 storage: get %s: %w", key, fs.ErrNotExist)
+		}
+		return nil, fmt.Errorf("s3 storage: get %s: %w", key, err)
+	}
+	want := out.Metadata[checksumMetadata]
+	if want == "" {
+		// Written by something other than Put, so there is nothing to check against
+		return out.Body, nil
+	}
+	return &verifyingReader{body: out.Body, hash: sha256.New(), want: want, key: key}, nil
+}
+
+// Delete removes a stored file; a missing file is not an error
+func (s *S3Storage) Delete(key string) error {
+	_, err := s.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("s3 storage: delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// PresignGet returns a URL that downloads the file directly from the bucket for PresignTTL, served inline
+// with the given name and content type and never cached
+func (s *S3Storage) PresignGet(key, filename, contentType string) (string, error) {
+	req, err := s.presign.PresignGetObject(context.Background(), &s3.GetObjectInput{
+		Bucket:                     aws.String(s.cfg.Bucket),
+		Key:                        aws.String(key),
+		ResponseContentDisposition: aws.String(mime.FormatMediaType("inline", map[string]string{"filename": filename})),
+		ResponseContentType:        aws.String(contentType),
+		ResponseCacheControl:       aws.String("no-store"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("s3 storage: presign %s: %w", key, err)
+	}
+	return req.URL, nil
+}
+
+// verifyingReader hashes a file as it is read and fails the read that reaches its end on a mismatch
+type verifyingReader struct {
+	body io.ReadCloser
+	hash hash.Hash
+	want string
+	key  string
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(r.hash.Sum(nil)) != r.want {
+		return n, fmt.Errorf("%s: %w", r.key, ErrChecksumMismatch)
+	}
+	return n, err
+}
+
+func (r *verifyingReader) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,117 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Verdict is the outcome of scanning one file
+type Verdict struct {
+	Clean     bool   // No threat found
+	Signature string // Name of the threat when not clean
+}
+
+// Scanner inspects uploaded bytes before they are stored
+// Implementations return an error only when the scan itself could not be completed
+type Scanner interface {
+	Scan(ctx context.Context, data []byte) (Verdict, error)
+}
+
+// NoopScanner passes every file - the default when no scanner is configured
+type NoopScanner struct{}
+
+// Scan reports every file clean
+func (NoopScanner) Scan(ctx context.Context, data []byte) (Verdict, error) {
+	return Verdict{Clean: true}, nil
+}
+
+// clamChunkSize is the largest INSTREAM chunk sent to clamd
+const clamChunkSize = 64 * 1024
+
+// ClamAV scans files with a clamd daemon over TCP using the INSTREAM command
+type ClamAV struct {
+	Addr    string        // host:port of clamd
+	Timeout time.Duration // Limit for one scan including the connection
+}
+
+// Scan streams data to clamd and parses its reply
+func (s ClamAV) Scan(ctx context.Context, data []byte) (Verdict, error) {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Verdict{}, fmt.Errorf("clamd: %w", err)
+	}
+	size := make([]byte, 4)
+	for start := 0; start < len(data); start += clamChunkSize {
+		end := start + clamChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		binary.BigEndian.PutUint32(size, uint32(end-start))
+		if _, err := conn.Write(size); err != nil {
+			return Verdict{}, fmt.Errorf("clamd: %w", err)
+		}
+		if _, err := conn.Write(data[start:end]); err != nil {
+			return Verdict{}, fmt.Errorf("clamd: %w", err)
+		}
+	}
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return Verdict{}, fmt.Errorf("clamd: %w", err)
+	}
+
+	var reply bytes.Buffer
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		reply.Write(buf[:n])
+		if err != nil || bytes.IndexByte(buf[:n], 0) >= 0 {
+			break
+		}
+	}
+	return parseClamReply(strings.TrimRight(reply.String(), "\x00\n"))
+}
+
+// parseClamReply turns "stream: OK" or "stream: <signature> FOUND" into a verdict
+func parseClamReply(reply string) (Verdict, error) {
+	result := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case result == "OK":
+		return Verdict{Clean: true}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return Verdict{Signature: strings.TrimSuffix(result, " FOUND")}, nil
+	case result == "":
+		return Verdict{}, errors.New("clamd: empty reply")
+	default:
+		return Verdict{}, fmt.Errorf("clamd: %s", result)
+	}
+}
+
+// ScannerFromEnv returns a ClamAV scanner when CLAMAV_ADDR is set, otherwise the no-op scanner
+func ScannerFromEnv() Scanner {
+	addr := os.Getenv("CLAMAV_ADDR")
+	if addr == "" {
+		return NoopScanner{}
+	}
+	return ClamAV{Addr: addr, Timeout: 30 * time.Second}
+}
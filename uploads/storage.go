@@ -0,0 +1,89 @@
+package uploads
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Storage backends STORAGE_BACKEND selects between
+const (
+	BackendDisk = "disk"
+	BackendS3   = "s3"
+)
+
+// ErrChecksumMismatch is returned while reading a file whose bytes no longer hash to the SHA-256 stored with it
+var ErrChecksumMismatch = errors.New("stored file failed its integrity check")
+
+// Storage persists uploaded files by key
+// Files reach storage only after validation and scanning have passed
+type Storage interface {
+	Put(key string, data []byte) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// Presigner is implemented by storage that can hand out a short-lived URL to download a file directly,
+// so the bytes need not pass through the API
+type Presigner interface {
+	PresignGet(key, filename, contentType string) (string, error)
+}
+
+// Prefix is where a customer's files of one kind and month are kept: kind/tenant/customer/yyyy/mm
+// Lifecycle rules can then expire or archive a tenant's, a customer's or a month's files by prefix
+func Prefix(kind string, tenantID, customerID uint, month time.Time) string {
+	return fmt.Sprintf("%s/%d/%d/%s", kind, tenantID, customerID, month.Format("2006/01"))
+}
+
+// LocalStorage keeps files in a directory on local disk
+type LocalStorage struct {
+	Dir string
+}
+
+// Put writes the file, creating parent directories as needed
+func (s LocalStorage) Put(key string, data []byte) error {
+	path := filepath.Join(s.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o640)
+}
+
+// Get opens a stored file
+func (s LocalStorage) Get(key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.Dir, filepath.FromSlash(key)))
+}
+
+// Delete removes a stored file; a missing file is not an error
+func (s LocalStorage) Delete(key string) error {
+	err := os.Remove(filepath.Join(s.Dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// StorageFromEnv selects the backend with STORAGE_BACKEND (disk or s3; default disk)
+// Disk stores files under UPLOAD_DIR (default "data/uploads"); S3 is configured by S3ConfigFromEnv
+func StorageFromEnv() (Storage, error) {
+	switch backend := strings.ToLower(strings.TrimSpace(os.Getenv("STORAGE_BACKEND"))); backend {
+	case "", BackendDisk:
+		dir := os.Getenv("UPLOAD_DIR")
+		if dir == "" {
+			dir = "data/uploads"
+		}
+		return LocalStorage{Dir: dir}, nil
+	case BackendS3:
+		cfg, err := S3ConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return NewS3Storage(cfg)
+	default:
+		return nil, fmt.Errorf("invalid STORAGE_BACKEND %q: must be disk or s3", backend)
+	}
+}
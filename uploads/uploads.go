@@ -0,0 +1,68 @@
+package uploads
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// InfectedError is returned when the scanner flags a file
+type InfectedError struct {
+	Signature string
+}
+
+func (e *InfectedError) Error() string {
+	return "file failed the malware scan: " + e.Signature
+}
+
+// File describes an upload that passed every check and was stored
+type File struct {
+	Key         string
+	ContentType string
+	Size        int64
+	SHA256      string
+}
+
+// Pipeline validates, scans and stores uploads in that order
+type Pipeline struct {
+	Scanner Scanner
+	Storage Storage
+}
+
+// NewPipeline builds the pipeline from the environment (CLAMAV_ADDR and the storage settings of StorageFromEnv)
+func NewPipeline() (*Pipeline, error) {
+	storage, err := StorageFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return &Pipeline{Scanner: ScannerFromEnv(), Storage: storage}, nil
+}
+
+// Process checks a file and stores it under prefix, keyed by the hash of the stored bytes
+// The scan sees the file exactly as uploaded; metadata is stripped afterwards, so what is stored
+// is never a variant the scanner did not approve of
+func (p *Pipeline) Process(ctx context.Context, prefix, kind, declared string, data []byte) (File, error) {
+	contentType, err := Validate(kind, declared, data)
+	if err != nil {
+		return File{ContentType: contentType}, err
+	}
+
+	verdict, err := p.Scanner.Scan(ctx, data)
+	if err != nil {
+		return File{ContentType: contentType}, fmt.Errorf("scan failed: %w", err)
+	}
+	if !verdict.Clean {
+		return File{ContentType: contentType}, &InfectedError{Signature: verdict.Signature}
+	}
+
+	data = StripMetadata(contentType, data)
+	sum := sha256.Sum256(data)
+	file := File{
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		SHA256:      hex.EncodeToString(sum[:]),
+	}
+	file.Key = prefix + "/" + file.SHA256
+	return file, p.Storage.Put(file.Key, data)
+}
@@ -0,0 +1,301 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// infectedPattern is the byte pattern fakeScanner flags
+var infectedPattern = []byte("FAKE-MALWARE-SIGNATURE")
+
+// fakeScanner flags files containing infectedPattern and records what it was shown
+type fakeScanner struct {
+	scanned [][]byte
+	err     error
+}
+
+func (s *fakeScanner) Scan(ctx context.Context, data []byte) (Verdict, error) {
+	s.scanned = append(s.scanned, append([]byte(nil), data...))
+	if s.err != nil {
+		return Verdict{}, s.err
+	}
+	if bytes.Contains(data, infectedPattern) {
+		return Verdict{Signature: "Test.Fake-Malware"}, nil
+	}
+	return Verdict{Clean: true}, nil
+}
+
+// memStorage keeps stored files in memory
+type memStorage map[string][]byte
+
+func (s memStorage) Put(key string, data []byte) error { s[key] = data; return nil }
+func (s memStorage) Delete(key string) error           { delete(s, key); return nil }
+func (s memStorage) Get(key string) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(s[key])), nil
+}
+
+// jpeg builds a JPEG header with a JFIF APP0 segment, an EXIF APP1 segment, then a start of scan and body
+func jpeg(body []byte) []byte {
+	segment := func(marker byte, payload string) []byte {
+		out := []byte{0xFF, marker, 0, 0}
+		binary.BigEndian.PutUint16(out[2:], uint16(len(payload)+2))
+		return append(out, payload...)
+	}
+	out := []byte{0xFF, 0xD8}
+	out = append(out, segment(0xE0, "JFIF\x00\x01\x01")...)
+	out = append(out, segment(0xE1, "Exif\x00\x00GPS 51.5N 0.12W")...)
+	out = append(out, segment(0xDA, "\x00\x00")...)
+	out = append(out, body...)
+	return append(out, 0xFF, 0xD9)
+}
+
+// png builds a PNG with an IHDR, an eXIf chunk and an IDAT chunk holding body
+func png(body []byte) []byte {
+	chunk := func(kind string, payload []byte) []byte {
+		out := make([]byte, 4, 12+len(payload))
+		binary.BigEndian.PutUint32(out, uint32(len(payload)))
+		out = append(out, kind...)
+		out = append(out, payload...)
+		return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(append([]byte(kind), payload...)))
+	}
+	out := append([]byte(nil), pngSignature...)
+	out = append(out, chunk("IHDR", []byte{0, 0, 0, 1, 0, 0, 0, 1, 8, 2, 0, 0, 0})...)
+	out = append(out, chunk("eXIf", []byte("MM\x00*GPS 51.5N 0.12W"))...)
+	out = append(out, chunk("IDAT", body)...)
+	return append(out, chunk("IEND", nil)...)
+}
+
+// pdf builds a minimal PDF holding body
+func pdf(body []byte) []byte {
+	return append(append([]byte("%PDF-1.4\n"), body...), "\n%%EOF\n"...)
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		declared string
+		data     []byte
+		want     string
+		err      error
+	}{
+		{"a JPEG cheque image", "cheque_image", "image/jpeg", jpeg(nil), "image/jpeg", nil},
+		{"a PNG identity document", "identity", "image/png", png(nil), "image/png", nil},
+		{"a PDF proof of address", "proof_of_address", "application/pdf", pdf(nil), "application/pdf", nil},
+		{"parameters on the declared type", "identity", "application/pdf; charset=binary", pdf(nil), "application/pdf", nil},
+		{"an undeclared type", "identity", "", pdf(nil), "application/pdf", nil},
+		{"a generic declared type", "identity", "application/octet-stream", png(nil), "image/png", nil},
+		{"a PNG declared as JPEG", "cheque_image", "image/jpeg", png(nil), "image/png", ErrTypeMismatch},
+		{"an executable declared as PDF", "identity", "application/pdf", []byte("MZ\x90\x00\x03 program"), "application/octet-stream", ErrTypeMismatch},
+		{"HTML declared as PNG", "identity", "image/png", []byte("<html><script>alert(1)</script></html>"), "text/html", ErrTypeMismatch},
+		{"a PDF cheque image", "cheque_image", "application/pdf", pdf(nil), "application/pdf", ErrUnsupportedType},
+		{"plain text", "identity", "text/plain", []byte("just some text"), "text/plain", ErrUnsupportedType},
+		{"an unknown kind", "selfie", "image/png", png(nil), "image/png", ErrUnsupportedType},
+		{"a JPEG at its limit", "cheque_image", "image/jpeg", jpeg(make([]byte, 10<<20-len(jpeg(nil)))), "image/jpeg", nil},
+		{"a JPEG over its limit", "cheque_image", "image/jpeg", jpeg(make([]byte, 10<<20)), "image/jpeg", ErrTooLarge},
+		{"a PDF over the image limit", "identity", "application/pdf", pdf(make([]byte, 15<<20)), "application/pdf", nil},
+		{"a PDF over its limit", "identity", "application/pdf", pdf(make([]byte, 20<<20)), "application/pdf", ErrTooLarge},
+	}
+	for _, tt := range tests {
+		got, err := Validate(tt.kind, tt.declared, tt.data)
+		if got != tt.want || err != tt.err {
+			t.Errorf("%s: Validate = %q, %v; want %q, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+	if MaxSize() != 20<<20 {
+		t.Errorf("MaxSize = %d, want the PDF limit", MaxSize())
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+	}{
+		{"JPEG", "image/jpeg", jpeg([]byte("scan data"))},
+		{"PNG", "image/png", png([]byte("image data"))},
+	}
+	for _, tt := range tests {
+		stripped := StripMetadata(tt.contentType, tt.data)
+		if bytes.Contains(stripped, []byte("GPS")) {
+			t.Errorf("%s: EXIF survived stripping", tt.name)
+		}
+		if len(tt.data)-len(stripped) > 40 {
+			t.Errorf("%s: stripping removed %d bytes, more than the EXIF block", tt.name, len(tt.data)-len(stripped))
+		}
+		if sniffed, err := Validate("identity", tt.contentType, stripped); err != nil || sniffed != tt.contentType {
+			t.Errorf("%s: the stripped image no longer validates: %q, %v", tt.name, sniffed, err)
+		}
+	}
+	if !bytes.Contains(StripMetadata("image/jpeg", jpeg([]byte("scan data"))), []byte("JFIF\x00\x01\x01")) {
+		t.Errorf("JPEG: the JFIF header was stripped along with EXIF")
+	}
+
+	document := pdf([]byte("Exif GPS"))
+	if !bytes.Equal(StripMetadata("application/pdf", document), document) {
+		t.Errorf("PDF: changed by stripping")
+	}
+	truncated := jpeg(nil)[:12]
+	if !bytes.Equal(StripMetadata("image/jpeg", truncated), truncated) {
+		t.Errorf("truncated JPEG: changed by stripping")
+	}
+}
+
+func TestProcess(t *testing.T) {
+	clean := jpeg([]byte("cheque front"))
+	infected := jpeg(append([]byte("cheque front "), infectedPattern...))
+	tests := []struct {
+		name     string
+		kind     string
+		declared string
+		data     []byte
+		scanErr  error
+		scanned  bool // The scanner saw the file
+		stored   bool
+		wantErr  func(error) bool
+	}{
+		{"a clean file", "cheque_image", "image/jpeg", clean, nil, true, true, func(err error) bool { return err == nil }},
+		{"an infected file", "cheque_image", "image/jpeg", infected, nil, true, false, func(err error) bool {
+			var infected *InfectedError
+			return errors.As(err, &infected) && infected.Signature == "Test.Fake-Malware"
+		}},
+		{"a scanner failure", "cheque_image", "image/jpeg", clean, errors.New("clamd: connection refused"), true, false, func(err error) bool {
+			return err != nil && strings.Contains(err.Error(), "scan failed")
+		}},
+		{"a mismatched type", "cheque_image", "image/png", infected, nil, false, false, func(err error) bool { return err == ErrTypeMismatch }},
+		{"an oversized file", "cheque_image", "image/jpeg", jpeg(make([]byte, 10<<20)), nil, false, false, func(err error) bool { return err == ErrTooLarge }},
+	}
+	for _, tt := range tests {
+		scanner, storage := &fakeScanner{err: tt.scanErr}, memStorage{}
+		pipeline := &Pipeline{Scanner: scanner, Storage: storage}
+		file, err := pipeline.Process(context.Background(), "documents/1/2/2026/03", tt.kind, tt.declared, tt.data)
+		if !tt.wantErr(err) {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if (len(scanner.scanned) == 1) != tt.scanned {
+			t.Errorf("%s: scanned %d times", tt.name, len(scanner.scanned))
+		}
+		if (len(storage) == 1) != tt.stored {
+			t.Errorf("%s: %d files stored", tt.name, len(storage))
+		}
+		if !tt.stored {
+			continue
+		}
+		if !bytes.Equal(scanner.scanned[0], tt.data) {
+			t.Errorf("%s: the scanner was not shown the file as uploaded", tt.name)
+		}
+		stored := storage[file.Key]
+		if !strings.HasPrefix(file.Key, "documents/1/2/2026/03/") || file.Size != int64(len(stored)) || bytes.Contains(stored, []byte("GPS")) {
+			t.Errorf("%s: stored %q of %d bytes (file says %d) with EXIF %v", tt.name, file.Key, len(stored), file.Size, bytes.Contains(stored, []byte("GPS")))
+		}
+	}
+}
+
+func TestParseClamReply(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  Verdict
+		err   bool
+	}{
+		{"stream: OK", Verdict{Clean: true}, false},
+		{"stream: Eicar-Test-Signature FOUND", Verdict{Signature: "Eicar-Test-Signature"}, false},
+		{"stream: Win.Trojan.Agent-1 FOUND", Verdict{Signature: "Win.Trojan.Agent-1"}, false},
+		{"INSTREAM size limit exceeded. ERROR", Verdict{}, true},
+		{"", Verdict{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseClamReply(tt.reply)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("parseClamReply(%q) = %+v, %v", tt.reply, got, err)
+		}
+	}
+}
+
+// fakeClamd accepts one INSTREAM connection at a time, reassembling the chunks and replying like clamd
+func fakeClamd(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			command := make([]byte, len("zINSTREAM\x00"))
+			if _, err := io.ReadFull(conn, command); err != nil || string(command) != "zINSTREAM\x00" {
+				conn.Write([]byte("UNKNOWN COMMAND\x00"))
+				conn.Close()
+				continue
+			}
+			var data []byte
+			size := make([]byte, 4)
+			for {
+				if _, err := io.ReadFull(conn, size); err != nil {
+					break
+				}
+				n := binary.BigEndian.Uint32(size)
+				if n == 0 {
+					break
+				}
+				if n > clamChunkSize {
+					conn.Write([]byte("INSTREAM chunk too large. ERROR\x00"))
+					break
+				}
+				chunk := make([]byte, n)
+				io.ReadFull(conn, chunk)
+				data = append(data, chunk...)
+			}
+			if bytes.Contains(data, infectedPattern) {
+				conn.Write([]byte("stream: Test.Fake-Malware FOUND\x00"))
+			} else {
+				conn.Write([]byte("stream: OK\x00"))
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestClamAV(t *testing.T) {
+	scanner := ClamAV{Addr: fakeClamd(t)}
+	large := make([]byte, 3*clamChunkSize+17) // Several chunks, with the pattern split across a chunk boundary
+	copy(large[clamChunkSize-5:], infectedPattern)
+	tests := []struct {
+		name string
+		data []byte
+		want Verdict
+	}{
+		{"a clean file", jpeg([]byte("cheque")), Verdict{Clean: true}},
+		{"an infected file", jpeg(infectedPattern), Verdict{Signature: "Test.Fake-Malware"}},
+		{"a pattern across chunks", large, Verdict{Signature: "Test.Fake-Malware"}},
+		{"an empty file", nil, Verdict{Clean: true}},
+	}
+	for _, tt := range tests {
+		got, err := scanner.Scan(context.Background(), tt.data)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: Scan = %+v, %v; want %+v", tt.name, got, err, tt.want)
+		}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := listener.Addr().String()
+	listener.Close()
+	if _, err := (ClamAV{Addr: closed}).Scan(context.Background(), []byte("x")); err == nil {
+		t.Errorf("Scan with clamd unreachable returned no error")
+	}
+}
@@ -0,0 +1,137 @@
+package uploads
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"mime"
+	"net/http"
+)
+
+// Validation errors
+var (
+	ErrUnsupportedType = errors.New("file type is not accepted for this document kind")
+	ErrTypeMismatch    = errors.New("declared content type does not match the file contents")
+	ErrTooLarge        = errors.New("file exceeds the size limit for its type")
+)
+
+// KindDisputeEvidence is evidence uploaded to a dispute's thread rather than filed as a customer document
+const KindDisputeEvidence = "dispute_evidence"
+
+// Document kinds and the content types each accepts
+var Kinds = map[string][]string{
+	"identity":          {"image/jpeg", "image/png", "application/pdf"},
+	"proof_of_address":  {"image/jpeg", "image/png", "application/pdf"},
+	"cheque_image":      {"image/jpeg", "image/png"},
+	"legal_order":       {"image/jpeg", "image/png", "application/pdf"},
+	KindDisputeEvidence: {"image/jpeg", "image/png", "application/pdf"},
+}
+
+// SizeLimits caps each content type in bytes
+var SizeLimits = map[string]int64{
+	"image/jpeg":      10 << 20,
+	"image/png":       10 << 20,
+	"application/pdf": 20 << 20,
+}
+
+// MaxSize is the largest file any type accepts; handlers read at most this much plus one byte
+func MaxSize() int64 {
+	var max int64
+	for _, limit := range SizeLimits {
+		if limit > max {
+			max = limit
+		}
+	}
+	return max
+}
+
+// Validate checks a file against its kind and returns the content type sniffed from its magic bytes
+// The declared type must agree with the sniffed one; parameters such as charset are ignored
+func Validate(kind, declared string, data []byte) (string, error) {
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if declared != "" {
+		declared, _, _ = mime.ParseMediaType(declared)
+		if declared != sniffed && declared != "application/octet-stream" {
+			return sniffed, ErrTypeMismatch
+		}
+	}
+
+	accepted := false
+	for _, t := range Kinds[kind] {
+		if t == sniffed {
+			accepted = true
+		}
+	}
+	if !accepted {
+		return sniffed, ErrUnsupportedType
+	}
+	if int64(len(data)) > SizeLimits[sniffed] {
+		return sniffed, ErrTooLarge
+	}
+	return sniffed, nil
+}
+
+// StripMetadata removes EXIF data from JPEG and PNG images; other types are returned unchanged
+// Images are otherwise untouched - no re-encoding, so cheque images keep their full quality
+func StripMetadata(contentType string, data []byte) []byte {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	}
+	return data
+}
+
+// stripJPEG drops APP1 segments (EXIF and XMP) from the header, up to the start of scan
+func stripJPEG(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		if marker == 0xDA { // Start of scan - entropy-coded data follows
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return data
+		}
+		if marker != 0xE1 {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	out.Write(data[i:])
+	return out.Bytes()
+}
+
+// pngSignature starts every PNG file
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// stripPNG drops eXIf chunks; chunks are self-contained, so the others keep valid CRCs
+func stripPNG(data []byte) []byte {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return data
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+	i := len(pngSignature)
+	for i+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return data
+		}
+		if string(data[i+4:i+8]) != "eXIf" {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	out.Write(data[i:])
+	return out.Bytes()
+}
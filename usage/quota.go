@@ -0,0 +1,231 @@
+package usage
+
+import (
+	"banking-app/accessreport"
+	"banking-app/apiversion"
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/eod"
+	"banking-app/models"
+	"banking-app/tenancy"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Quota modes, from least to most strict
+const (
+	ModeWarn     = "warn"     // Served, with an X-Quota-Warning header
+	ModeThrottle = "throttle" // ThrottlePerMinute served a minute; the rest are refused
+	ModeBlock    = "block"    // Refused until the month ends
+)
+
+// Modes lists every quota mode
+var Modes = []string{ModeWarn, ModeThrottle, ModeBlock}
+
+// strictness orders the modes, so the strictest exceeded quota decides a request
+var strictness = map[string]int{ModeWarn: 1, ModeThrottle: 2, ModeBlock: 3}
+
+// DefaultThrottlePerMinute is the rate served past a throttle quota that does not set one
+const DefaultThrottlePerMinute = 60
+
+// ExceededMessage is shown to clients refused for their quota
+const ExceededMessage = "The client's monthly usage quota is exceeded"
+
+// rowsKey holds the records a handler returned to the client
+const rowsKey = "usage_rows"
+
+// Rows counts records a list endpoint returns toward the client's rows_exported; handlers call it with what
+// they send, and the count is only read for client requests
+func Rows(c *gin.Context, n int) {
+	c.Set(rowsKey, c.GetInt64(rowsKey)+int64(n))
+}
+
+// Validate checks a quota's metric, category, limit and mode, defaulting the throttle rate
+func Validate(q *models.UsageQuota) error {
+	switch q.Metric {
+	case MetricRequests, MetricTransactions, MetricRows:
+	default:
+		return fmt.Errorf("metric must be one of %s", strings.Join(Metrics, ", "))
+	}
+	if strictness[q.Mode] == 0 {
+		return fmt.Errorf("mode must be one of %s", strings.Join(Modes, ", "))
+	}
+	if q.MonthlyLimit < 0 {
+		return errors.New("monthly_limit must be zero or more")
+	}
+	if q.ThrottlePerMinute < 0 {
+		return errors.New("throttle_per_minute must be zero or more")
+	}
+	q.Category = strings.Trim(strings.TrimSpace(q.Category), "/")
+	switch {
+	case q.Mode != ModeThrottle:
+		q.ThrottlePerMinute = 0
+	case q.ThrottlePerMinute == 0:
+		q.ThrottlePerMinute = DefaultThrottlePerMinute
+	}
+	return nil
+}
+
+// Status is a quota with the month's usage against it
+type Status struct {
+	models.UsageQuota
+	Used      int64 `json:"used"`
+	Remaining int64 `json:"remaining"`
+	Exceeded  bool  `json:"exceeded"`
+}
+
+// status measures a quota against a month's usage by category
+func status(q models.UsageQuota, byCategory map[string]Counts) Status {
+	s := Status{UsageQuota: q}
+	for category, counts := range byCategory {
+		if q.Category == "" || q.Category == category {
+			s.Used += counts.Get(q.Metric)
+		}
+	}
+	s.Remaining = q.MonthlyLimit - s.Used
+	if s.Remaining <= 0 {
+		s.Remaining, s.Exceeded = 0, true
+	}
+	return s
+}
+
+// applies reports whether a quota limits a request in category; transaction quotas only limit postings
+func applies(q models.UsageQuota, category string, posting bool) bool {
+	if q.Category != "" && q.Category != category {
+		return false
+	}
+	return q.Metric != MetricTransactions || posting
+}
+
+// decision is what the quotas make of a request
+type decision struct {
+	tightest   *Status // The applicable quota with the least remaining
+	exceeded   *Status // The strictest applicable quota that is used up
+	refused    bool
+	retryAfter int // Seconds
+}
+
+// admit checks a request against the client's quotas and, unless it is refused, counts it
+// Serving past a throttle quota is counted per clock minute; admitMu guards the counts
+func (m *Meter) admit(k key, posting bool, now time.Time) decision {
+	m.admitMu.Lock()
+	defer m.admitMu.Unlock()
+	m.mu.RLock()
+	quotas := m.quotas[k.clientID]
+	m.mu.RUnlock()
+
+	var d decision
+	if len(quotas) > 0 {
+		byCategory := m.used(k.clientID, k.month())
+		for _, q := range quotas {
+			if !applies(q, k.category, posting) {
+				continue
+			}
+			s := status(q, byCategory)
+			if d.tightest == nil || s.Remaining < d.tightest.Remaining {
+				d.tightest = &s
+			}
+			if s.Exceeded && (d.exceeded == nil || strictness[s.Mode] > strictness[d.exceeded.Mode] ||
+				(s.Mode == ModeThrottle && d.exceeded.Mode == ModeThrottle && s.ThrottlePerMinute < d.exceeded.ThrottlePerMinute)) {
+				d.exceeded = &s
+			}
+		}
+	}
+	if d.exceeded != nil {
+		switch d.exceeded.Mode {
+		case ModeBlock:
+			next := businessdays.StartOfMonth(now).AddDate(0, 1, 0)
+			d.refused, d.retryAfter = true, int(math.Ceil(next.Sub(now).Seconds()))
+		case ModeThrottle:
+			start := now.Truncate(time.Minute)
+			window := m.throttled[k.clientID]
+			if window == nil || !window.start.Equal(start) {
+				window = &minute{start: start}
+				m.throttled[k.clientID] = window
+			}
+			if window.served >= d.exceeded.ThrottlePerMinute {
+				d.refused, d.retryAfter = true, int(math.Ceil(start.Add(time.Minute).Sub(now).Seconds()))
+			} else {
+				window.served++
+			}
+		}
+	}
+	if !d.refused {
+		m.add(k, Counts{Requests: 1})
+	}
+	return d
+}
+
+// Middleware counts each API client request toward its client's usage and enforces the client's quotas
+// Requests are counted as they are admitted, so parallel requests cannot overshoot a limit; postings that
+// succeed and the records list endpoints return are counted once the handler finishes. Install it on the API
+// groups after authentication and the consent guard, so only requests a client may make are counted
+func (m *Meter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientID := c.GetString("client_id")
+		if clientID == "" {
+			c.Next()
+			return
+		}
+		now := clock.Now()
+		k := key{tenantID: tenancy.Current(c).ID, clientID: clientID, day: businessdays.Format(now), category: accessreport.Category(c.FullPath())}
+		if err := m.load(clientID, k.month()); err != nil {
+			// Served against whatever is cached rather than failing the request
+			log.Printf("usage: loading usage of %s failed: %v", clientID, err)
+		}
+		posting := eod.Posting(c.Request.Method, c.Request.URL.Path)
+		d := m.admit(k, posting, now)
+		if d.tightest != nil {
+			remaining := d.tightest.Remaining
+			if d.tightest.Metric == MetricRequests && !d.refused && remaining > 0 {
+				remaining-- // This request
+			}
+			c.Header("X-Quota-Limit", strconv.FormatInt(d.tightest.MonthlyLimit, 10))
+			c.Header("X-Quota-Remaining", strconv.FormatInt(remaining, 10))
+		}
+		if d.refused {
+			refuse(c, *d.exceeded, d.retryAfter)
+			return
+		}
+		if d.exceeded != nil {
+			c.Header("X-Quota-Warning", fmt.Sprintf("Monthly %s quota of %d exceeded", d.exceeded.Metric, d.exceeded.MonthlyLimit))
+		}
+
+		c.Next()
+		counts := Counts{RowsExported: c.GetInt64(rowsKey)}
+		if posting && c.Writer.Status() < http.StatusMultipleChoices {
+			counts.Transactions = 1
+		}
+		m.add(k, counts)
+	}
+}
+
+// refuse answers a request past a throttle or block quota with 429 and Retry-After
+func refuse(c *gin.Context, quota Status, retryAfter int) {
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	details := gin.H{
+		"metric":              quota.Metric,
+		"category":            quota.Category,
+		"monthly_limit":       quota.MonthlyLimit,
+		"used":                quota.Used,
+		"mode":                quota.Mode,
+		"retry_after_seconds": retryAfter,
+	}
+	if apiversion.Version(c) == apiversion.V2 {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": gin.H{
+			"code":    "QUOTA_EXCEEDED",
+			"message": ExceededMessage,
+			"details": details,
+		}})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": ExceededMessage, "code": "QUOTA_EXCEEDED", "quota": details})
+}
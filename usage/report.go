@@ -0,0 +1,108 @@
+package usage
+
+import (
+	"banking-app/businessdays"
+	"banking-app/clock"
+	"banking-app/models"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// CategoryUsage is a client's usage in one endpoint category
+type CategoryUsage struct {
+	Category string `json:"category"`
+	Counts
+}
+
+// DayUsage is a client's usage on one bank day
+type DayUsage struct {
+	Day string `json:"day"`
+	Counts
+}
+
+// Report is a client's usage in a period, and its quotas against this month's usage
+type Report struct {
+	ClientID   string          `json:"client_id"`
+	From       string          `json:"from"` // Inclusive bank dates
+	To         string          `json:"to"`
+	Totals     Counts          `json:"totals"`
+	Categories []CategoryUsage `json:"categories"` // Most requests first
+	Days       []DayUsage      `json:"days"`       // Days with usage, oldest first
+	Month      string          `json:"month"`      // YYYY-MM the quotas are measured in
+	Quotas     []Status        `json:"quotas"`
+}
+
+// Report sums a client's usage between two YYYY-MM-DD bank dates, counting what this instance has not yet written
+// db is scoped to the caller's tenant
+func (m *Meter) Report(db *gorm.DB, clientID, from, to string) (Report, error) {
+	// Held so no flush is between writing a batch and forgetting it, which would count the batch twice
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	month := businessdays.Format(clock.Now())[:7]
+	start, end := from, to
+	if month+"-01" < start {
+		start = month + "-01"
+	}
+	if month+"-31" > end {
+		end = month + "-31"
+	}
+	var records []models.UsageRecord
+	if err := db.Where("client_id = ? AND day BETWEEN ? AND ?", clientID, start, end).Find(&records).Error; err != nil {
+		return Report{}, err
+	}
+	m.mu.RLock()
+	for k, c := range m.pending {
+		if k.clientID == clientID && k.day >= start && k.day <= end {
+			counts := c.counts()
+			records = append(records, models.UsageRecord{Day: k.day, Category: k.category,
+				Requests: counts.Requests, Transactions: counts.Transactions, RowsExported: counts.RowsExported})
+		}
+	}
+	m.mu.RUnlock()
+
+	report := Report{ClientID: clientID, From: from, To: to, Month: month, Categories: []CategoryUsage{}, Days: []DayUsage{}, Quotas: []Status{}}
+	byCategory := make(map[string]Counts)
+	byDay := make(map[string]Counts)
+	monthUsage := make(map[string]Counts)
+	for _, r := range records {
+		counts := Counts{Requests: r.Requests, Transactions: r.Transactions, RowsExported: r.RowsExported}
+		if r.Day[:7] == month {
+			total := monthUsage[r.Category]
+			total.add(counts)
+			monthUsage[r.Category] = total
+		}
+		if r.Day < from || r.Day > to {
+			continue
+		}
+		report.Totals.add(counts)
+		category, day := byCategory[r.Category], byDay[r.Day]
+		category.add(counts)
+		day.add(counts)
+		byCategory[r.Category], byDay[r.Day] = category, day
+	}
+	for category, counts := range byCategory {
+		report.Categories = append(report.Categories, CategoryUsage{Category: category, Counts: counts})
+	}
+	sort.Slice(report.Categories, func(i, j int) bool {
+		a, b := report.Categories[i], report.Categories[j]
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.Category < b.Category
+	})
+	for day, counts := range byDay {
+		report.Days = append(report.Days, DayUsage{Day: day, Counts: counts})
+	}
+	sort.Slice(report.Days, func(i, j int) bool { return report.Days[i].Day < report.Days[j].Day })
+
+	var quotas []models.UsageQuota
+	if err := db.Where("client_id = ?", clientID).Order("id").Find(&quotas).Error; err != nil {
+		return Report{}, err
+	}
+	for _, q := range quotas {
+		report.Quotas = append(report.Quotas, status(q, monthUsage))
+	}
+	return report, nil
+}
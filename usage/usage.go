@@ -0,0 +1,347 @@
+package usage
+
+import (
+	"banking-app/models"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Metrics an API client's usage is counted in
+const (
+	MetricRequests     = "requests"      // Requests served
+	MetricTransactions = "transactions"  // Postings initiated that succeeded
+	MetricRows         = "rows_exported" // Records returned by list endpoints
+)
+
+// Metrics lists every metric a quota can limit
+var Metrics = []string{MetricRequests, MetricTransactions, MetricRows}
+
+// Config holds how often counters are written to the database
+type Config struct {
+	FlushInterval time.Duration
+}
+
+// ConfigFromEnv reads USAGE_FLUSH_SECONDS (default 10)
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{FlushInterval: 10 * time.Second}
+	if raw := strings.TrimSpace(os.Getenv("USAGE_FLUSH_SECONDS")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return cfg, fmt.Errorf("invalid USAGE_FLUSH_SECONDS %q: must be a whole number of 1 or more", raw)
+		}
+		cfg.FlushInterval = time.Duration(n) * time.Second
+	}
+	return cfg, nil
+}
+
+// Counts is usage in each metric
+type Counts struct {
+	Requests     int64 `json:"requests"`
+	Transactions int64 `json:"transactions"`
+	RowsExported int64 `json:"rows_exported"`
+}
+
+// Get returns the count of a metric
+func (c Counts) Get(metric string) int64 {
+	switch metric {
+	case MetricTransactions:
+		return c.Transactions
+	case MetricRows:
+		return c.RowsExported
+	}
+	return c.Requests
+}
+
+// add adds other to c
+func (c *Counts) add(other Counts) {
+	c.Requests += other.Requests
+	c.Transactions += other.Transactions
+	c.RowsExported += other.RowsExported
+}
+
+// key identifies the usage record a request counts toward; client ids are unique across tenants
+type key struct {
+	tenantID uint
+	clientID string
+	day      string // YYYY-MM-DD in bank time
+	category string
+}
+
+// month returns the YYYY-MM month of the key's day
+func (k key) month() string { return k.day[:7] }
+
+// counter is the usage of one key not yet written; it is updated atomically
+type counter struct {
+	requests, transactions, rows atomic.Int64
+}
+
+// increment adds counts to the counter
+func (c *counter) increment(counts Counts) {
+	c.requests.Add(counts.Requests)
+	c.transactions.Add(counts.Transactions)
+	c.rows.Add(counts.RowsExported)
+}
+
+// counts reads the counter
+func (c *counter) counts() Counts {
+	return Counts{Requests: c.requests.Load(), Transactions: c.transactions.Load(), RowsExported: c.rows.Load()}
+}
+
+// Meter counts API client usage in memory and adds it to the daily usage records on each flush, so requests
+// never wait on a write. Quotas are enforced against the month's usage: the totals in the database at the last
+// flush, which include other instances' flushed usage, plus what this instance has not yet written
+type Meter struct {
+	db *gorm.DB
+
+	// mu guards the maps. Counters are incremented under the read lock, and Flush takes the write lock to swap
+	// pending out, so no increment lands in a batch after it has been read
+	mu        sync.RWMutex
+	pending   map[key]*counter
+	flushing  map[key]Counts                 // Being written by Flush; still counted until the totals are reloaded
+	stored    map[string]map[string]Counts   // Month-to-date database totals by client, then category
+	months    map[string]string              // Month each client's stored totals are for
+	quotas    map[string][]models.UsageQuota // Quotas by client, reloaded after each flush
+	throttled map[string]*minute             // Requests served past a throttle quota, by client
+
+	flushMu sync.Mutex // One flush at a time
+	admitMu sync.Mutex // Checking quotas and counting a request happen together, so parallel requests cannot overshoot a limit
+}
+
+// minute counts requests in one clock minute
+type minute struct {
+	start  time.Time
+	served int
+}
+
+// NewMeter returns a meter writing to db
+func NewMeter(db *gorm.DB) *Meter {
+	return &Meter{
+		db:        db,
+		pending:   make(map[key]*counter),
+		stored:    make(map[string]map[string]Counts),
+		months:    make(map[string]string),
+		quotas:    make(map[string][]models.UsageQuota),
+		throttled: make(map[string]*minute),
+	}
+}
+
+// add counts usage toward k, creating its counter on the first use since the last flush
+func (m *Meter) add(k key, counts Counts) {
+	if counts == (Counts{}) {
+		return
+	}
+	m.mu.RLock()
+	if c := m.pending[k]; c != nil {
+		c.increment(counts)
+		m.mu.RUnlock()
+		return
+	}
+	m.mu.RUnlock()
+	m.mu.Lock()
+	c := m.pending[k]
+	if c == nil {
+		c = &counter{}
+		m.pending[k] = c
+	}
+	c.increment(counts)
+	m.mu.Unlock()
+}
+
+// used returns a client's usage in a month by category: the stored totals plus everything not yet written
+func (m *Meter) used(clientID, month string) map[string]Counts {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	byCategory := make(map[string]Counts)
+	if m.months[clientID] == month {
+		for category, counts := range m.stored[clientID] {
+			byCategory[category] = counts
+		}
+	}
+	for k, counts := range m.flushing {
+		if k.clientID == clientID && k.month() == month {
+			total := byCategory[k.category]
+			total.add(counts)
+			byCategory[k.category] = total
+		}
+	}
+	for k, c := range m.pending {
+		if k.clientID == clientID && k.month() == month {
+			total := byCategory[k.category]
+			total.add(c.counts())
+			byCategory[k.category] = total
+		}
+	}
+	return byCategory
+}
+
+// load reads a client's month-to-date totals and quotas if they are not cached
+func (m *Meter) load(clientID, month string) error {
+	m.mu.RLock()
+	loaded := m.months[clientID] == month
+	_, haveQuotas := m.quotas[clientID]
+	m.mu.RUnlock()
+
+	if !loaded {
+		totals, err := monthTotals(m.db, []string{clientID}, month)
+		if err != nil {
+			return err
+		}
+		m.mu.Lock()
+		// A flush may have loaded newer totals meanwhile
+		if m.months[clientID] != month {
+			m.stored[clientID], m.months[clientID] = totals[clientID], month
+		}
+		m.mu.Unlock()
+	}
+	if !haveQuotas {
+		quotas := []models.UsageQuota{}
+		if err := m.db.Where("client_id = ?", clientID).Order("id").Find(&quotas).Error; err != nil {
+			return err
+		}
+		m.mu.Lock()
+		if _, ok := m.quotas[clientID]; !ok {
+			m.quotas[clientID] = quotas
+		}
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// Forget drops a client's cached quotas, so a change takes effect on this instance at once; other instances
+// reload them after their next flush
+func (m *Meter) Forget(clientID string) {
+	m.mu.Lock()
+	delete(m.quotas, clientID)
+	m.mu.Unlock()
+}
+
+// Flush adds the pending counters to the daily usage records and reloads the month's totals and the quotas
+// Counters that fail to write are kept for the next flush
+func (m *Meter) Flush() error {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.mu.Lock()
+	batch := make(map[key]Counts, len(m.pending))
+	for k, c := range m.pending {
+		if counts := c.counts(); counts != (Counts{}) {
+			batch[k] = counts
+		}
+	}
+	m.pending = make(map[key]*counter)
+	m.flushing = batch
+	m.mu.Unlock()
+
+	var firstErr error
+	failed := make(map[key]Counts)
+	for k, counts := range batch {
+		if err := m.write(k, counts); err != nil {
+			failed[k] = counts
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	// Reload the totals of every client seen this month; the month is taken from the clock each client last used
+	m.mu.RLock()
+	byMonth := make(map[string][]string)
+	for clientID, month := range m.months {
+		byMonth[month] = append(byMonth[month], clientID)
+	}
+	for k := range batch {
+		if m.months[k.clientID] == "" {
+			byMonth[k.month()] = append(byMonth[k.month()], k.clientID)
+		}
+	}
+	m.mu.RUnlock()
+	stored := make(map[string]map[string]Counts)
+	months := make(map[string]string)
+	for month, clients := range byMonth {
+		totals, err := monthTotals(m.db, clients, month)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			// Left without totals, so the clients' next requests load them
+			for _, clientID := range clients {
+				months[clientID] = ""
+			}
+			continue
+		}
+		for _, clientID := range clients {
+			stored[clientID], months[clientID] = totals[clientID], month
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for clientID, month := range months {
+		m.stored[clientID], m.months[clientID] = stored[clientID], month
+	}
+	for k, counts := range failed {
+		c := m.pending[k]
+		if c == nil {
+			c = &counter{}
+			m.pending[k] = c
+		}
+		c.increment(counts)
+	}
+	m.flushing = nil
+	m.quotas = make(map[string][]models.UsageQuota)
+	return firstErr
+}
+
+// write adds counts to the record of k, creating it on the first flush of the day
+// The tenant is set explicitly: flushes run outside any request, so nothing stamps it
+func (m *Meter) write(k key, counts Counts) error {
+	return m.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "client_id"}, {Name: "day"}, {Name: "category"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"requests":      gorm.Expr("usage_records.requests + excluded.requests"),
+			"transactions":  gorm.Expr("usage_records.transactions + excluded.transactions"),
+			"rows_exported": gorm.Expr("usage_records.rows_exported + excluded.rows_exported"),
+			"updated_at":    gorm.Expr("excluded.updated_at"),
+		}),
+	}).Create(&models.UsageRecord{
+		TenantID:     k.tenantID,
+		ClientID:     k.clientID,
+		Day:          k.day,
+		Category:     k.category,
+		Requests:     counts.Requests,
+		Transactions: counts.Transactions,
+		RowsExported: counts.RowsExported,
+	}).Error
+}
+
+// monthTotals sums clients' usage records in a YYYY-MM month by client and category
+func monthTotals(db *gorm.DB, clientIDs []string, month string) (map[string]map[string]Counts, error) {
+	var rows []struct {
+		ClientID string
+		Category string
+		Counts
+	}
+	err := db.Model(&models.UsageRecord{}).
+		Select("client_id, category, SUM(requests) AS requests, SUM(transactions) AS transactions, SUM(rows_exported) AS rows_exported").
+		Where("client_id IN ? AND day BETWEEN ? AND ?", clientIDs, month+"-01", month+"-31").
+		Group("client_id, category").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	totals := make(map[string]map[string]Counts, len(clientIDs))
+	for _, clientID := range clientIDs {
+		totals[clientID] = make(map[string]Counts)
+	}
+	for _, row := range rows {
+		totals[row.ClientID][row.Category] = row.Counts
+	}
+	return totals, nil
+}
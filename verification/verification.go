@@ -0,0 +1,286 @@
+package verification
+
+import (
+	"banking-app/communications"
+	"banking-app/events"
+	"banking-app/models"
+	"banking-app/notifications"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Token purposes
+const (
+	PurposeSignup = "signup" // Confirms the address given when the customer was created
+	PurposeChange = "change" // Confirms a new address before it replaces the current one
+)
+
+// Token statuses
+const (
+	StatusPending    = "pending"
+	StatusVerified   = "verified"
+	StatusSuperseded = "superseded" // A newer token was sent to the customer
+)
+
+// Verification errors - handlers map these to client responses
+var (
+	ErrInvalidToken    = errors.New("verification token is not valid")
+	ErrTokenExpired    = errors.New("verification token has expired")
+	ErrTokenUsed       = errors.New("verification token has already been used")
+	ErrTokenSuperseded = errors.New("a newer verification email has been sent")
+	ErrEmailTaken      = errors.New("email address belongs to another customer")
+	ErrNothingToVerify = errors.New("customer has no unconfirmed email address")
+	ErrResendTooSoon   = errors.New("a verification email was sent moments ago")
+)
+
+// Config holds the settings of emailed verification tokens
+type Config struct {
+	TokenTTL       time.Duration // How long an emailed token works
+	ResendInterval time.Duration // Minimum wait between resends to one customer
+	BaseURL        string        // Public URL of the API the email points at
+}
+
+// ConfigFromEnv reads EMAIL_VERIFICATION_TTL_HOURS (default 48), EMAIL_VERIFICATION_RESEND_SECONDS
+// (default 60) and PUBLIC_BASE_URL (default "http://localhost:8080")
+func ConfigFromEnv() Config {
+	hours, err := strconv.Atoi(os.Getenv("EMAIL_VERIFICATION_TTL_HOURS"))
+	if err != nil || hours <= 0 {
+		hours = 48
+	}
+	seconds, err := strconv.Atoi(os.Getenv("EMAIL_VERIFICATION_RESEND_SECONDS"))
+	if err != nil || seconds < 0 {
+		seconds = 60
+	}
+	base := strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	return Config{TokenTTL: time.Duration(hours) * time.Hour, ResendInterval: time.Duration(seconds) * time.Second, BaseURL: base}
+}
+
+// Issue emails a token confirming email for a customer, superseding any token still pending for them
+// Call it inside the transaction that creates the customer or records the requested address
+func Issue(tx *gorm.DB, cfg Config, customer models.Customer, email, purpose string, now time.Time) (models.EmailVerification, error) {
+	err := tx.Model(&models.EmailVerification{}).Where("customer_id = ? AND status = ?", customer.ID, StatusPending).
+		Update("status", StatusSuperseded).Error
+	if err != nil {
+		return models.EmailVerification{}, err
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return models.EmailVerification{}, err
+	}
+	v := models.EmailVerification{
+		CreatedAt:  now, // The resend interval is measured on the same clock as now
+		TenantID:   customer.TenantID,
+		CustomerID: customer.ID,
+		Email:      email,
+		Purpose:    purpose,
+		Status:     StatusPending,
+		TokenHash:  hashToken(token),
+		ExpiresAt:  now.Add(cfg.TokenTTL),
+	}
+	if err := tx.Create(&v).Error; err != nil {
+		return v, err
+	}
+
+	body := fmt.Sprintf("Confirm your email address within %d hours by sending this token to %s/api/v1/customers/verify-email: %s",
+		int(cfg.TokenTTL.Hours()), cfg.BaseURL, token)
+	if purpose == PurposeChange {
+		body = fmt.Sprintf("You asked to use %s for your account. Your current address stays active until you confirm. ", email) + body
+	}
+	return v, notify(tx, customer.ID, v.ID, email, "Confirm your email address", body)
+}
+
+// Resend issues a fresh token for whatever the customer has left to confirm: a requested new address,
+// or an address that was never verified. Earlier tokens stop working
+// Within the resend interval of the last token it returns that token's record with ErrResendTooSoon
+func Resend(tx *gorm.DB, cfg Config, customer models.Customer, now time.Time) (models.EmailVerification, error) {
+	email, purpose := customer.PendingEmail, PurposeChange
+	if email == "" {
+		if customer.EmailVerified || customer.Email == "" {
+			return models.EmailVerification{}, ErrNothingToVerify
+		}
+		email, purpose = customer.Email, PurposeSignup
+	}
+
+	var last models.EmailVerification
+	err := tx.Where("customer_id = ?", customer.ID).Order("created_at DESC, id DESC").Limit(1).Find(&last).Error
+	if err != nil {
+		return last, err
+	}
+	if last.ID != 0 && now.Before(last.CreatedAt.Add(cfg.ResendInterval)) {
+		return last, ErrResendTooSoon
+	}
+	return Issue(tx, cfg, customer, email, purpose, now)
+}
+
+// RequestChange records email as the customer's pending address and sends it a token; the current address
+// stays in use until the token is used and is told about the request. Asking for the current address again
+// withdraws a pending change
+func RequestChange(tx *gorm.DB, cfg Config, customer *models.Customer, email string, now time.Time) error {
+	if strings.EqualFold(email, customer.Email) {
+		if customer.PendingEmail == "" {
+			return nil
+		}
+		customer.PendingEmail = ""
+		if err := tx.Model(customer).Update("pending_email", "").Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.EmailVerification{}).Where("customer_id = ? AND status = ?", customer.ID, StatusPending).
+			Update("status", StatusSuperseded).Error
+	}
+	if err := checkAvailable(tx, customer, email); err != nil {
+		return err
+	}
+
+	customer.PendingEmail = email
+	if err := tx.Model(customer).Update("pending_email", email).Error; err != nil {
+		return err
+	}
+	if _, err := Issue(tx, cfg, *customer, email, PurposeChange, now); err != nil {
+		return err
+	}
+	if customer.Email == "" {
+		return nil
+	}
+	return notify(tx, customer.ID, 0, customer.Email, "Email address change requested",
+		fmt.Sprintf("A change of your email address to %s was requested. This address stays active until the new one is confirmed. "+
+			"If you did not ask for this, contact us straight away.", email))
+}
+
+// Verify uses a token: it marks a signup address verified, or replaces the customer's address with the
+// confirmed new one and tells both addresses about the change
+func Verify(db *gorm.DB, token string, now time.Time) (models.Customer, error) {
+	var customer models.Customer
+	if token == "" {
+		return customer, ErrInvalidToken
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var v models.EmailVerification
+		if err := tx.Where("token_hash = ?", hashToken(token)).First(&v).Error; err == gorm.ErrRecordNotFound {
+			return ErrInvalidToken
+		} else if err != nil {
+			return err
+		}
+		switch {
+		case v.Status == StatusVerified:
+			return ErrTokenUsed
+		case v.Status == StatusSuperseded:
+			return ErrTokenSuperseded
+		case now.After(v.ExpiresAt):
+			return ErrTokenExpired
+		}
+		if err := tx.First(&customer, v.CustomerID).Error; err == gorm.ErrRecordNotFound {
+			return ErrInvalidToken
+		} else if err != nil {
+			return err
+		}
+
+		// The status condition stops two requests with the same token from both using it
+		result := tx.Model(&models.EmailVerification{}).Where("id = ? AND status = ?", v.ID, StatusPending).
+			Updates(map[string]interface{}{"status": StatusVerified, "verified_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrTokenUsed
+		}
+
+		if v.Purpose != PurposeChange {
+			if !strings.EqualFold(v.Email, customer.Email) {
+				return ErrTokenSuperseded
+			}
+			customer.EmailVerified, customer.EmailVerifiedAt = true, &now
+			return tx.Model(&customer).Updates(map[string]interface{}{"email_verified": true, "email_verified_at": now}).Error
+		}
+
+		if !strings.EqualFold(v.Email, customer.PendingEmail) {
+			return ErrTokenSuperseded
+		}
+		if err := checkAvailable(tx, &customer, v.Email); err != nil {
+			return err
+		}
+		previous := customer.Email
+		customer.Email, customer.PendingEmail, customer.EmailVerified, customer.EmailVerifiedAt = v.Email, "", true, &now
+		err := tx.Model(&customer).Updates(map[string]interface{}{
+			"email": v.Email, "pending_email": "", "email_verified": true, "email_verified_at": now,
+		}).Error
+		if err != nil {
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				return ErrEmailTaken
+			}
+			return err
+		}
+		if err := events.Record(tx, events.AggregateCustomer, customer.ID, events.CustomerEmailChanged, map[string]interface{}{
+			"customer_id":    customer.ID,
+			"email":          customer.Email,
+			"previous_email": previous,
+		}); err != nil {
+			return err
+		}
+
+		body := fmt.Sprintf("The email address on your account was changed from %s to %s. If you did not make this change, contact us straight away.",
+			previous, customer.Email)
+		if previous != "" {
+			if err := notify(tx, customer.ID, v.ID, previous, "Your email address was changed", body); err != nil {
+				return err
+			}
+		}
+		return notify(tx, customer.ID, v.ID, customer.Email, "Your email address was changed", body)
+	})
+	return customer, err
+}
+
+// checkAvailable refuses an address another customer of the same bank already uses
+func checkAvailable(tx *gorm.DB, customer *models.Customer, email string) error {
+	var taken int64
+	err := tx.Model(&models.Customer{}).Where("tenant_id = ? AND email = ? AND id <> ?", customer.TenantID, email, customer.ID).
+		Count(&taken).Error
+	if err != nil {
+		return err
+	}
+	if taken > 0 {
+		return ErrEmailTaken
+	}
+	return nil
+}
+
+// notify queues an email about a customer's address
+func notify(tx *gorm.DB, customerID, verificationID uint, recipient, subject, body string) error {
+	return notifications.Enqueue(tx, &models.Notification{
+		CustomerID:   customerID,
+		Channel:      "email",
+		ResourceType: communications.ResourceEmailVerification,
+		ResourceID:   verificationID,
+		Recipient:    recipient,
+		Subject:      subject,
+		Body:         body,
+	})
+}
+
+// hashToken is the stored form of a token; the token itself is only ever emailed
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken returns a random, URL-safe verification token
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,78 @@
+package writequeue
+
+import (
+	"banking-app/metrics"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTimeout is returned when a write waited longer than the queue's timeout for its turn
+var ErrTimeout = errors.New("timed out waiting for the database write queue")
+
+// EnabledFromEnv reads DB_WRITE_QUEUE; the queue is on unless it is set false
+func EnabledFromEnv() bool {
+	raw := os.Getenv("DB_WRITE_QUEUE")
+	if raw == "" {
+		return true
+	}
+	enabled, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("writequeue: ignoring invalid DB_WRITE_QUEUE %q", raw)
+		return true
+	}
+	return enabled
+}
+
+// Queue lets one database write transaction run at a time
+// SQLite allows one writer at a time; taking turns here means this instance's writes never contend for the lock
+// among themselves. Only the transaction holds a turn - the request's reads, request body and any calls to other
+// services happen outside it, so a slow credit bureau or malware scan never holds up other writes
+type Queue struct {
+	slot    chan struct{}
+	waiting atomic.Int64
+	timeout time.Duration
+}
+
+// New builds a queue whose writes give up after waiting timeout for their turn, and registers its depth gauge
+// The timeout should match the database busy timeout: a write that would have waited that long on the lock
+// fails the same way, and a write made outside a transaction its own goroutine holds cannot wait forever
+func New(timeout time.Duration) *Queue {
+	q := &Queue{slot: make(chan struct{}, 1), timeout: timeout}
+	metrics.RegisterGauge("db_write_queue_waiting", "Database writes waiting for the write queue", func() float64 {
+		return float64(q.waiting.Load())
+	})
+	return q
+}
+
+// Acquire waits for the write turn. It gives up with ctx's error if ctx ends first, or ErrTimeout after the
+// queue's timeout. Every successful Acquire must be matched by one Release
+func (q *Queue) Acquire(ctx context.Context) error {
+	select {
+	case q.slot <- struct{}{}:
+		return nil
+	default:
+	}
+
+	q.waiting.Add(1)
+	defer q.waiting.Add(-1)
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+	select {
+	case q.slot <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return ErrTimeout
+	}
+}
+
+// Release hands the write turn to the next waiting write
+func (q *Queue) Release() {
+	<-q.slot
+}